@@ -26,6 +26,16 @@ for f in examples/*.rs; do
 done
 echo "  Removed $count generated .rs files from examples/"
 
+# Remove source maps written alongside them
+count=0
+for f in examples/*.go.map examples/*.rs.map; do
+    if [ -f "$f" ]; then
+        rm "$f"
+        count=$((count + 1))
+    fi
+done
+echo "  Removed $count source maps from examples/"
+
 # Remove compiled binaries in examples (files matching *.ual basename)
 count=0
 for f in examples/*.ual; do
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ha1tch/ual/pkg/check"
+)
+
+// ============================================================================
+// Checking without running
+//
+//   iual --check file.ual     print problems as file:line:col: message
+//   iual --serve-check        answer JSON check requests, one per line:
+//
+//     -> {"id": 1, "file": "main.ual", "source": "..."}
+//     <- {"id": 1, "diagnostics": [{"file": ..., "line": 3, "message": ...}]}
+//
+// "source" is optional; without it the file is read from disk. The checks
+// themselves are in pkg/check.
+// ============================================================================
+
+// runCheck checks the file at path and prints its problems, exiting with
+// status 1 if there are any
+func runCheck(path string) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	diags := check.Source(path, string(source))
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	if len(diags) > 0 {
+		os.Exit(1)
+	}
+	if verbosity >= verbVerbose {
+		fmt.Fprintf(os.Stderr, "%s: ok\n", path)
+	}
+}
+
+type checkRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	File   string          `json:"file"`
+	Source *string         `json:"source,omitempty"`
+}
+
+type checkResponse struct {
+	ID          json.RawMessage    `json:"id,omitempty"`
+	Diagnostics []check.Diagnostic `json:"diagnostics"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// serveCheck answers check requests from r on w until r ends
+func serveCheck(r io.Reader, w io.Writer) error {
+	in := bufio.NewScanner(r)
+	in.Buffer(make([]byte, 64*1024), 64<<20)
+	enc := json.NewEncoder(w)
+	for in.Scan() {
+		if len(in.Bytes()) == 0 {
+			continue
+		}
+		var req checkRequest
+		resp := checkResponse{Diagnostics: []check.Diagnostic{}}
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			resp.Error = "bad request: " + err.Error()
+		} else {
+			resp.ID = req.ID
+			source, err := requestSource(req)
+			if err != nil {
+				resp.Error = err.Error()
+			} else if diags := check.Source(req.File, source); diags != nil {
+				resp.Diagnostics = diags
+			}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return in.Err()
+}
+
+func requestSource(req checkRequest) (string, error) {
+	if req.Source != nil {
+		return *req.Source, nil
+	}
+	if req.File == "" {
+		return "", fmt.Errorf("request needs a file or source")
+	}
+	data, err := os.ReadFile(req.File)
+	return string(data), err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestServeCheck(t *testing.T) {
+	in := strings.NewReader(`{"id": 1, "file": "a.ual", "source": "@dstack push:1\n"}
+{"id": "two", "file": "b.ual", "source": "@q push:1\n"}
+not json
+`)
+	var out bytes.Buffer
+	if err := serveCheck(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d responses, want 3:\n%s", len(lines), out.String())
+	}
+	var resp []checkResponse
+	for _, l := range lines {
+		var r checkResponse
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatal(err)
+		}
+		resp = append(resp, r)
+	}
+	if string(resp[0].ID) != "1" || len(resp[0].Diagnostics) != 0 {
+		t.Errorf("response 1 = %+v", resp[0])
+	}
+	if string(resp[1].ID) != `"two"` || len(resp[1].Diagnostics) != 1 || resp[1].Diagnostics[0].Message != "undefined stack: @q" {
+		t.Errorf("response 2 = %+v", resp[1])
+	}
+	if resp[2].Error == "" {
+		t.Errorf("response 3 should report a bad request: %+v", resp[2])
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/ha1tch/ual/pkg/interp"
+)
+
+// errInterrupt is returned by readLine when Ctrl-C abandons the line
+var errInterrupt = interp.ErrInterrupt
+
+// lineEditor reads lines from a terminal in raw mode, with Emacs-style
+// editing keys and a history:
+//
+//	Left, Right, Ctrl-B, Ctrl-F   move a character
+//	Home, End, Ctrl-A, Ctrl-E     move to the start or end
+//	Up, Down, Ctrl-P, Ctrl-N      step through the history
+//	Backspace, Delete, Ctrl-D     delete before or under the cursor
+//	Ctrl-K, Ctrl-U, Ctrl-W        delete to the end, to the start, a word back
+//	Ctrl-L                        clear the screen
+//	Ctrl-C                        abandon the line
+//	Ctrl-D on an empty line       end of input
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+}
+
+func newLineEditor(in io.Reader, out io.Writer) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out}
+}
+
+// readLine shows prompt and returns the line typed after it, adding it to
+// the history if it is not blank
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	hist := len(e.history) // the entry shown; len(history) is the new line
+	pending := ""          // the new line, while the history is shown
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	recall := func(to int) {
+		if to < 0 || to > len(e.history) || to == hist {
+			return
+		}
+		if hist == len(e.history) {
+			pending = string(buf)
+		}
+		hist = to
+		if to == len(e.history) {
+			buf = []rune(pending)
+		} else {
+			buf = []rune(e.history[to])
+		}
+		pos = len(buf)
+	}
+	redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(buf) > 0 {
+				break
+			}
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(buf)
+			if strings.TrimSpace(line) != "" {
+				e.history = append(e.history, line)
+			}
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && unicode.IsSpace(buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(buf[start-1]) {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			recall(hist - 1)
+		case 14: // Ctrl-N
+			recall(hist + 1)
+		case 27: // Escape sequence
+			switch e.escape() {
+			case "[A", "OA":
+				recall(hist - 1)
+			case "[B", "OB":
+				recall(hist + 1)
+			case "[C", "OC":
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~", "[7~":
+				pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				pos = len(buf)
+			case "[3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if !unicode.IsPrint(r) && r != '\t' {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+	return string(buf), nil
+}
+
+// escape reads the rest of an escape sequence after ESC: "[" or "O",
+// then any digits and semicolons, then the final character
+func (e *lineEditor) escape() string {
+	var seq []byte
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return ""
+	}
+	seq = append(seq, b)
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, b)
+		if (b < '0' || b > '9') && b != ';' {
+			return string(seq)
+		}
+	}
+}
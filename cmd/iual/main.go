@@ -3,10 +3,15 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/interp"
 	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/module"
 	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/runtime"
 	"github.com/ha1tch/ual/pkg/version"
 )
 
@@ -20,6 +25,11 @@ const (
 
 var verbosity = verbNormal
 var traceExec = false
+var spawnWorkers = 0 // 0: runtime.DefaultSpawnWorkers
+var checkOnly = false // --check: report problems without running
+var profileExec = false // --profile: report time per source line at exit
+var debugMode = false // iual debug: pause at breakpoints and steps
+var walkOnly = false // --walk: walk the tree instead of running bytecode
 
 func main() {
 	args := parseFlags(os.Args[1:])
@@ -30,6 +40,14 @@ func main() {
 	}
 
 	cmd := args[0]
+	if checkOnly {
+		path := cmd
+		if (cmd == "run" || cmd == "r") && len(args) > 1 {
+			path = args[1]
+		}
+		runCheck(path)
+		return
+	}
 
 	switch cmd {
 	case "run", "r":
@@ -37,7 +55,18 @@ func main() {
 			fmt.Fprintln(os.Stderr, "error: no input file specified")
 			os.Exit(1)
 		}
-		runFile(args[1])
+		runFile(args[1], args[2:])
+
+	case "repl":
+		runREPL()
+
+	case "debug":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "error: no input file specified")
+			os.Exit(1)
+		}
+		debugMode = true
+		runFile(args[1], args[2:])
 
 	case "version", "v":
 		fmt.Println("iual", version.Version)
@@ -48,7 +77,7 @@ func main() {
 	default:
 		// Assume it's a filename
 		if strings.HasSuffix(cmd, ".ual") {
-			runFile(cmd)
+			runFile(cmd, args[1:])
 		} else {
 			fmt.Fprintf(os.Stderr, "unknown command: %s\n", cmd)
 			printUsage()
@@ -85,7 +114,40 @@ func parseFlags(args []string) []string {
 			verbosity = verbDebug
 			traceExec = true
 
+		case "--profile":
+			profileExec = true
+
+		case "--walk":
+			walkOnly = true
+
+		case "--check":
+			checkOnly = true
+
+		case "--serve-check":
+			if err := serveCheck(os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		case "--workers":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "error: --workers requires an argument")
+				os.Exit(1)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "error: --workers must be a positive number, got '%s'\n", args[i])
+				os.Exit(1)
+			}
+			spawnWorkers = n
+
 		default:
+			// Everything after the source file belongs to the program
+			if strings.HasSuffix(arg, ".ual") {
+				return append(append(result, arg), args[i+1:]...)
+			}
 			if strings.HasPrefix(arg, "-") {
 				fmt.Fprintf(os.Stderr, "unknown flag: %s\n", arg)
 				os.Exit(1)
@@ -101,11 +163,15 @@ func printUsage() {
 	fmt.Println(`iual - ual interpreter v` + version.Version + `
 
 USAGE:
-    iual [OPTIONS] <file.ual>
-    iual [OPTIONS] run <file.ual>
+    iual [OPTIONS] <file.ual> [ARGS...]
+    iual [OPTIONS] run <file.ual> [ARGS...]
+    iual [OPTIONS] repl
+    iual [OPTIONS] debug <file.ual> [ARGS...]
 
 COMMANDS:
     run, r       Run a ual source file
+    repl         Read and run input interactively (:help for commands)
+    debug        Run a file under the debugger (help for commands)
     version, v   Print version information
     help, h      Print this help message
 
@@ -116,18 +182,45 @@ OPTIONS:
     -q, --quiet      Suppress non-essential output
     --verbose        Verbose output
     --debug          Debug mode (implies --trace)
+    --workers <n>    Max concurrent @spawn tasks (default 64)
+    --profile        Report the time spent on each source line at exit
+    --check          Check the program for errors without running it
+    --walk           Walk the syntax tree instead of compiling to bytecode
+    --serve-check    Answer JSON check requests on stdin, one per line
 
 EXAMPLES:
     iual program.ual
     iual run program.ual
     iual --trace program.ual
+    iual --check program.ual
+    iual --profile program.ual
+    iual repl
 
 NOTE:
-    iual is a tree-walking interpreter, approximately 10-50x slower
-    than compiled ual. Use 'ual build' for production performance.`)
+    iual compiles functions and top-level loops to bytecode and walks
+    the rest of the tree. It is several times slower than compiled ual;
+    use 'ual build' for production performance.`)
+}
+
+// expectUse reports whether prog calls expect_stack or expect_output, and
+// whether it calls expect_output, which needs stdout captured
+func expectUse(prog *ast.Program) (uses, output bool) {
+	ast.Inspect(prog, func(n any) bool {
+		if f, ok := n.(*ast.FuncCall); ok {
+			switch f.Name {
+			case "expect_output":
+				output = true
+				fallthrough
+			case "expect_stack":
+				uses = true
+			}
+		}
+		return true
+	}, nil)
+	return uses, output
 }
 
-func runFile(path string) {
+func runFile(path string, progArgs []string) {
 	// Read source file
 	source, err := os.ReadFile(path)
 	if err != nil {
@@ -156,7 +249,13 @@ func runFile(path string) {
 	p := parser.NewParser(tokens)
 	prog, err := p.Parse()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: parse error: %v\n", path, err)
+		for _, d := range parser.Diagnostics(path, err) {
+			fmt.Fprintln(os.Stderr, d)
+		}
+		os.Exit(1)
+	}
+	if err := module.Load(prog, path); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
 		os.Exit(1)
 	}
 
@@ -165,11 +264,30 @@ func runFile(path string) {
 	}
 
 	// Run interpreter
-	interp := NewInterpreter()
-	interp.SetFilename(path)
-	interp.SetTrace(traceExec)
+	i := interp.NewInterpreter()
+	i.SetFilename(path)
+	i.SetTrace(traceExec)
+	i.SetBytecode(!walkOnly)
+	i.SetArgs(progArgs)
+	i.SetWorkers(spawnWorkers)
+	if uses, output := expectUse(prog); uses {
+		// Its exit hook must run after every other, so enable it first
+		runtime.EnableExpect(output)
+	}
+	if profileExec {
+		prof := interp.NewProfile(prog, path)
+		i.SetProfile(prof)
+		// Registered first, so it runs after the program's own exit hooks
+		runtime.AtExit(func() { prof.Report(os.Stderr) })
+	}
+
+	if debugMode {
+		read, _ := stdinReader()
+		fmt.Println("iual debugger: paused before the first statement; help lists the commands")
+		i.SetDebugger(interp.NewDebugger(path, read, os.Stdout))
+	}
 
-	if err := interp.Run(prog); err != nil {
+	if err := i.Run(prog); err != nil {
 		fmt.Fprintf(os.Stderr, "%s: runtime error: %v\n", path, err)
 		os.Exit(1)
 	}
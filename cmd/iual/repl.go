@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/interp"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/module"
+	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/version"
+)
+
+// ============================================================================
+// Interactive mode
+//
+//   iual repl
+//
+// Each input runs in the same interpreter, so stacks, variables and
+// functions stay from one to the next. An input with an unclosed {, ( or
+// [ continues on the next line. Lines starting with a colon are commands
+// (see replHelp). On a terminal, lines are edited as lineEditor describes;
+// otherwise they are read as they come, without prompts.
+// ============================================================================
+
+// replFile names REPL input in error messages
+const replFile = "<repl>"
+
+const replHelp = `:show             show every stack
+:show @name       show one stack, bottom to top
+:tokens <code>    show the lexer tokens of code
+:ast <code>       show the parse tree of code
+:help             show this help
+:quit             leave (or Ctrl-D)
+`
+
+// session is the state of a REPL: the interpreter inputs run in, and the
+// input read so far of a statement that continues
+type session struct {
+	interp  *interp.Interpreter
+	out     io.Writer // command output and errors
+	pending string
+}
+
+func newSession(out io.Writer) *session {
+	i := interp.NewInterpreter()
+	i.SetFilename(replFile)
+	i.SetTrace(traceExec)
+	i.SetBytecode(!walkOnly)
+	i.SetWorkers(spawnWorkers)
+	return &session{interp: i, out: out}
+}
+
+// runREPL reads and runs inputs from stdin until it ends or :quit
+func runREPL() {
+	s := newSession(os.Stdout)
+	defer s.interp.Close()
+
+	read, terminal := stdinReader()
+	if terminal {
+		fmt.Printf("iual %s - :help for commands, Ctrl-D to leave\n", version.Version)
+	}
+
+	for {
+		prompt := "ual> "
+		if s.pending != "" {
+			prompt = "...> "
+		}
+		line, err := read(prompt)
+		if err == errInterrupt {
+			s.pending = ""
+			continue
+		}
+		if err != nil {
+			if s.pending != "" {
+				s.run(s.pending) // reports what is unclosed
+			}
+			return
+		}
+		if !s.input(line) {
+			return
+		}
+	}
+}
+
+// stdinReader returns a function reading lines from stdin: on a terminal
+// through a lineEditor, showing the prompt, and otherwise as they come.
+// terminal reports which.
+func stdinReader() (read func(prompt string) (string, error), terminal bool) {
+	if restore, err := rawMode(int(os.Stdin.Fd())); err == nil {
+		restore()
+		ed := newLineEditor(os.Stdin, os.Stdout)
+		return func(prompt string) (string, error) {
+			// Raw only while reading, so programs read stdin as usual
+			restore, err := rawMode(int(os.Stdin.Fd()))
+			if err != nil {
+				return "", err
+			}
+			defer restore()
+			return ed.readLine(prompt)
+		}, true
+	}
+	in := bufio.NewReader(os.Stdin)
+	return func(string) (string, error) {
+		line, err := in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}, false
+}
+
+// input handles a line of input, returning false after :quit
+func (s *session) input(line string) bool {
+	if s.pending == "" && strings.HasPrefix(strings.TrimSpace(line), ":") {
+		return s.command(strings.TrimSpace(line))
+	}
+	s.pending += line + "\n"
+	if continues(s.pending) {
+		return true
+	}
+	src := s.pending
+	s.pending = ""
+	s.run(src)
+	return true
+}
+
+// continues reports whether src has a {, ( or [ it has not closed yet
+func continues(src string) bool {
+	depth := 0
+	for _, tok := range lexer.NewLexer(src).Tokenize() {
+		switch tok.Type {
+		case lexer.TokLBrace, lexer.TokLParen, lexer.TokLBracket:
+			depth++
+		case lexer.TokRBrace, lexer.TokRParen, lexer.TokRBracket:
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// parse lexes and parses src, reporting any errors
+func (s *session) parse(src string) (*ast.Program, bool) {
+	tokens := lexer.NewLexer(src).Tokenize()
+	for _, tok := range tokens {
+		if tok.Type == lexer.TokError {
+			fmt.Fprintf(s.out, "%s:%d:%d: lexer error: %s\n", replFile, tok.Line, tok.Column, tok.Value)
+			return nil, false
+		}
+	}
+	prog, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		for _, d := range parser.Diagnostics(replFile, err) {
+			fmt.Fprintln(s.out, d)
+		}
+		return nil, false
+	}
+	return prog, true
+}
+
+// run runs src in the session, then shows the variables it assigned
+func (s *session) run(src string) {
+	prog, ok := s.parse(src)
+	if !ok {
+		return
+	}
+	if err := module.Load(prog, replFile); err != nil {
+		fmt.Fprintf(s.out, "%s: %v\n", replFile, err)
+		return
+	}
+	if err := s.interp.Eval(prog); err != nil {
+		fmt.Fprintf(s.out, "%s: runtime error: %v\n", replFile, err)
+		return
+	}
+	var names []string
+	for _, stmt := range prog.Stmts {
+		switch st := stmt.(type) {
+		case *ast.Assignment:
+			names = append(names, st.Name)
+		case *ast.AssignStmt:
+			names = append(names, st.Name)
+		case *ast.LetAssign:
+			names = append(names, st.Name)
+		case *ast.VarDecl:
+			names = append(names, st.Names...)
+		}
+	}
+	s.interp.PrintVars(names)
+}
+
+// command runs a REPL command, returning false for :quit
+func (s *session) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":quit", ":q", ":exit":
+		return false
+	case ":help", ":h":
+		fmt.Fprint(s.out, replHelp)
+	case ":show", ":s":
+		s.interp.WriteStacks(s.out, arg)
+	case ":tokens":
+		for _, tok := range lexer.NewLexer(arg).Tokenize() {
+			fmt.Fprintf(s.out, "%3d:%-3d  %s\n", tok.Line, tok.Column, tok)
+		}
+	case ":ast":
+		if prog, ok := s.parse(arg); ok {
+			ast.Fprint(s.out, prog)
+		}
+	default:
+		fmt.Fprintf(s.out, "unknown command %s (:help lists them)\n", name)
+	}
+	return true
+}
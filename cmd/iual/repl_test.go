@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestREPLSession(t *testing.T) {
+	var out bytes.Buffer
+	s := newSession(&out)
+	for _, line := range []string{
+		"@nums = stack.new(i64, FIFO)",
+		"func sq(n i64) i64 {",
+		"  return n * n",
+		"}",
+		"@nums push(sq(3))",
+		"@nums push(sq(4))",
+		":show @nums",
+		":show @nope",
+	} {
+		if !s.input(line) {
+			t.Fatalf("%q ended the session", line)
+		}
+	}
+	want := "@nums (FIFO i64): [9 16]\nno stack @nope\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	s.input("if (1 > 0) {")
+	if s.pending == "" {
+		t.Error("an unclosed { should continue on the next line")
+	}
+	s.input("  @nums push:1")
+	s.input("}")
+	s.input(":show nums")
+	if want := "@nums (FIFO i64): [9 16 1]\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	s.input("@nums x = 1")
+	if !strings.Contains(out.String(), "<repl>:") {
+		t.Errorf("a bad input should be reported, got %q", out.String())
+	}
+	if s.input(":quit") {
+		t.Error(":quit should end the session")
+	}
+}
+
+func TestContinues(t *testing.T) {
+	for src, want := range map[string]bool{
+		"push:1\n":                false,
+		"if (x > 1) {\n":          true,
+		"if (x > 1) {\n}\n":       false,
+		"@s push([1, 2,\n":        true,
+		"print(\"{ not a brace\")": false,
+	} {
+		if got := continues(src); got != want {
+			t.Errorf("continues(%q) = %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	keys := strings.Join([]string{
+		"push:2\r",          // a line
+		"pusx\x7fh:1\r",     // backspace
+		"ab\x1b[Dc\x01X\r",  // left, then Ctrl-A
+		"one two\x17\r",     // Ctrl-W
+		"\x1b[A\x1b[A\r",    // up twice: the line before last
+		"gone\x03",          // Ctrl-C
+		"\x10\x10\x0e\r",    // Ctrl-P twice, Ctrl-N
+		"abc\x02\x02\x0b\r", // Ctrl-B twice, Ctrl-K
+		"\x04",              // Ctrl-D on an empty line
+	}, "")
+	ed := newLineEditor(strings.NewReader(keys), io.Discard)
+	var got []string
+	for {
+		line, err := ed.readLine("> ")
+		if err == errInterrupt {
+			got = append(got, "^C")
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		got = append(got, line)
+	}
+	want := []string{"push:2", "push:1", "Xacb", "one ", "Xacb", "^C", "Xacb", "a"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
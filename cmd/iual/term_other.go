@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// rawMode is unsupported here; the REPL reads whole lines without editing.
+func rawMode(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported on this platform")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+// document is an open file
+type document struct {
+	uri   string
+	path  string
+	text  string
+	lines []string
+	toks  []lexer.Token
+	index *index // of the last text that parsed, nil if none has
+}
+
+func newDocument(uri, path, text string) *document {
+	d := &document{uri: uri, path: path}
+	d.update(text)
+	return d
+}
+
+// update replaces the text of d, keeping the old index if the new text
+// does not parse
+func (d *document) update(text string) {
+	d.text = text
+	d.lines = strings.Split(text, "\n")
+	d.toks = lexer.NewLexer(text).Tokenize()
+	prog, err := parser.NewParser(d.toks).Parse()
+	if err == nil {
+		d.index = buildIndex(prog, d.toks)
+	}
+}
+
+// tokenAt returns the token covering line and col, counted from 1 in
+// bytes as the lexer counts them
+func (d *document) tokenAt(line, col int) (lexer.Token, bool) {
+	for _, t := range d.toks {
+		if t.Line != line {
+			continue
+		}
+		if n := tokenLen(t); n > 0 && col >= t.Column && col <= t.Column+n {
+			return t, true
+		}
+	}
+	return lexer.Token{}, false
+}
+
+// tokenLen is the length of t in the source, or 0 if it cannot be known
+// from its value
+func tokenLen(t lexer.Token) int {
+	switch t.Type {
+	case lexer.TokStackRef:
+		return len(t.Value) + 1
+	case lexer.TokIdent:
+		return len(t.Value)
+	}
+	if _, ok := lexer.Keywords[t.Value]; ok {
+		return len(t.Value)
+	}
+	return 0
+}
+
+// symbol is a declared function, stack or variable
+type symbol struct {
+	kind    string // "func", "stack", "var", "param" or "view"
+	name    string
+	pos     ast.Pos // the name in the declaration
+	fn      string  // enclosing function, "" at the top level
+	detail  string  // the declaration, as ual
+	summary string  // what it is in words, for stacks
+}
+
+// index holds the declarations of a document
+type index struct {
+	symbols []symbol
+	funcs   []funcSpan // in source order
+}
+
+// funcSpan is the lines a function declaration covers
+type funcSpan struct {
+	name       string
+	start, end int // end is 0 for the last statement of the file
+}
+
+func buildIndex(prog *ast.Program, toks []lexer.Token) *index {
+	ix := &index{}
+	for i, stmt := range prog.Stmts {
+		pos := prog.Pos[stmt]
+		if pos.File != "" {
+			continue // from a library
+		}
+		f, ok := stmt.(*ast.FuncDecl)
+		if !ok {
+			ix.walk(stmt, prog.Pos, "", toks)
+			continue
+		}
+		span := funcSpan{name: f.Name, start: pos.Line}
+		if i+1 < len(prog.Stmts) {
+			span.end = prog.Pos[prog.Stmts[i+1]].Line - 1
+		}
+		ix.funcs = append(ix.funcs, span)
+		ix.add(symbol{kind: "func", name: f.Name, detail: funcSignature(f)}, pos, toks)
+		for _, p := range f.Params {
+			if p.Stack {
+				ix.add(symbol{kind: "stack", name: p.Name, fn: f.Name, detail: fmt.Sprintf("@%s stack(%s)", p.Name, p.Type),
+					summary: fmt.Sprintf("stack parameter of %s, elements of type %s", f.Name, p.Type)}, pos, toks)
+			} else {
+				ix.add(symbol{kind: "param", name: p.Name, fn: f.Name, detail: fmt.Sprintf("%s %s", p.Name, p.Type)}, pos, toks)
+			}
+		}
+		for _, s := range f.Body {
+			ix.walk(s, prog.Pos, f.Name, toks)
+		}
+	}
+	return ix
+}
+
+// walk declares the names declared in stmt and the statements under it,
+// which belong to fn
+func (ix *index) walk(stmt ast.Stmt, positions map[ast.Stmt]ast.Pos, fn string, toks []lexer.Token) {
+	ast.Inspect(stmt, func(n any) bool {
+		if s, ok := n.(ast.Stmt); ok {
+			if pos, ok := positions[s]; ok {
+				ix.declare(s, pos, fn, toks)
+			}
+		}
+		return true
+	}, nil)
+}
+
+// declare adds the names stmt declares, if any
+func (ix *index) declare(stmt ast.Stmt, pos ast.Pos, fn string, toks []lexer.Token) {
+	switch s := stmt.(type) {
+	case *ast.StackDecl:
+		ix.add(symbol{kind: "stack", name: s.Name, fn: fn, detail: stackDetail(s), summary: stackSummary(s)}, pos, toks)
+	case *ast.ViewDecl:
+		ix.add(symbol{kind: "view", name: s.Name, fn: fn, detail: fmt.Sprintf("%s = view.new(%s)", s.Name, s.Perspective)}, pos, toks)
+	case *ast.VarDecl:
+		for i, name := range s.Names {
+			typ := s.Type
+			if typ == "" && i < len(s.Values) {
+				typ = literalType(s.Values[i])
+			}
+			ix.add(symbol{kind: "var", name: name, fn: fn, detail: strings.TrimSpace("var " + name + " " + typ)}, pos, toks)
+		}
+	case *ast.RangeStmt:
+		ix.add(symbol{kind: "var", name: s.Var, fn: fn, detail: "var " + s.Var + " i64"}, pos, toks)
+	case *ast.ForStmt:
+		for _, p := range s.Params {
+			ix.add(symbol{kind: "var", name: p, fn: fn, detail: "var " + p}, pos, toks)
+		}
+	}
+}
+
+// add records sym, declared by the statement at pos, at the first token
+// naming it there
+func (ix *index) add(sym symbol, pos ast.Pos, toks []lexer.Token) {
+	sym.pos = pos
+	for _, t := range toks {
+		if t.Line < pos.Line || t.Line == pos.Line && t.Column < pos.Col {
+			continue
+		}
+		if t.Line > pos.Line+1 {
+			break
+		}
+		if t.Value == sym.name && (t.Type == lexer.TokStackRef) == (sym.kind == "stack") {
+			sym.pos = ast.Pos{Line: t.Line, Col: t.Column}
+			break
+		}
+	}
+	ix.symbols = append(ix.symbols, sym)
+}
+
+// funcAt returns the function whose declaration covers line, or ""
+func (ix *index) funcAt(line int) string {
+	for _, f := range ix.funcs {
+		if line >= f.start && (f.end == 0 || line <= f.end) {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// lookup finds the declaration a name at line and col refers to: the
+// latest one in the enclosing function before it, or else the first at
+// the top level. Stacks are looked up apart from other names.
+func (ix *index) lookup(name string, stack bool, line, col int) (symbol, bool) {
+	fn := ix.funcAt(line)
+	var found *symbol
+	for i := range ix.symbols {
+		s := &ix.symbols[i]
+		if s.name != name || (s.kind == "stack") != stack {
+			continue
+		}
+		if fn != "" && s.fn == fn && (s.pos.Line < line || s.pos.Line == line && s.pos.Col <= col) {
+			found = s // the latest wins
+		}
+	}
+	if found != nil {
+		return *found, true
+	}
+	for _, s := range ix.symbols {
+		if s.name == name && (s.kind == "stack") == stack && (s.fn == "" || s.kind == "func") {
+			return s, true
+		}
+	}
+	return symbol{}, false
+}
+
+// visible returns the symbols that can be named at line: those of the
+// enclosing function and of the top level
+func (ix *index) visible(line int) []symbol {
+	fn := ix.funcAt(line)
+	var out []symbol
+	seen := map[string]bool{}
+	for _, s := range ix.symbols {
+		key := s.kind + " " + s.name
+		if (s.fn == "" || s.fn == fn) && !seen[key] {
+			seen[key] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func funcSignature(f *ast.FuncDecl) string {
+	var params []string
+	for _, p := range f.Params {
+		if p.Stack {
+			params = append(params, fmt.Sprintf("@%s stack(%s)", p.Name, p.Type))
+		} else {
+			params = append(params, p.Name+" "+p.Type)
+		}
+	}
+	sig := fmt.Sprintf("func %s(%s)", f.Name, strings.Join(params, ", "))
+	if f.ReturnType != "" {
+		sig += " " + f.ReturnType
+	}
+	if f.CanFail {
+		sig = "@error < " + sig
+	}
+	return sig
+}
+
+func stackDetail(s *ast.StackDecl) string {
+	if s.Embed != nil {
+		detail := fmt.Sprintf("embed %q as @%s", s.Embed.Path, s.Name)
+		if s.Embed.Lines {
+			return detail + " lines"
+		}
+		return detail + " " + s.ElementType
+	}
+	args := []string{s.ElementType}
+	if s.Perspective != "" {
+		args = append(args, s.Perspective)
+	}
+	if s.Capacity > 0 {
+		args = append(args, fmt.Sprintf("cap: %d", s.Capacity))
+	}
+	return fmt.Sprintf("@%s = stack.new(%s)", s.Name, strings.Join(args, ", "))
+}
+
+// stackSummary describes a stack declared as s in words
+func stackSummary(s *ast.StackDecl) string {
+	perspective := s.Perspective
+	if perspective == "" {
+		perspective = "LIFO"
+	}
+	text := fmt.Sprintf("stack of %s, %s", s.ElementType, perspective)
+	if s.Capacity > 0 {
+		text += fmt.Sprintf(", capacity %d", s.Capacity)
+	}
+	return text
+}
+
+// literalType is the type of a variable initialised to e, if e says
+func literalType(e ast.Expr) string {
+	switch e.(type) {
+	case *ast.IntLit:
+		return "i64"
+	case *ast.FloatLit:
+		return "f64"
+	case *ast.StringLit, *ast.InterpString:
+		return "string"
+	case *ast.BoolLit:
+		return "bool"
+	}
+	return ""
+}
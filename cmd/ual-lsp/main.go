@@ -0,0 +1,45 @@
+// Command ual-lsp is a Language Server Protocol server for ual.
+//
+// Editors start it and talk to it over stdin and stdout. It offers
+//
+//   - diagnostics when a file is opened or saved: lexer and parse errors,
+//     and the undeclared stacks and functions and wrong argument counts
+//     pkg/check finds
+//   - go to definition for functions, stacks and variables
+//   - hover with the type and perspective of a stack, the signature of a
+//     function, the type of a variable and the effect of a stack operation
+//   - completion of stack operations, functions and variables, and of
+//     stack names after @
+//
+// For VS Code, any generic LSP client extension can run it for .ual files.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ha1tch/ual/pkg/version"
+)
+
+func main() {
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--version", "-version":
+			fmt.Println("ual-lsp", version.Version)
+			return
+		case "--stdio":
+			// The only transport, named by some clients
+		default:
+			fmt.Fprintln(os.Stderr, "usage: ual-lsp [--stdio]")
+			fmt.Fprintln(os.Stderr, "Serves the Language Server Protocol for ual on stdin and stdout.")
+			os.Exit(2)
+		}
+	}
+	if err := serve(os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, errNoShutdown) {
+			fmt.Fprintf(os.Stderr, "ual-lsp: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
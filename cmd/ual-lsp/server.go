@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/ha1tch/ual/pkg/check"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/version"
+)
+
+// errNoShutdown is returned by serve when the client sends exit without
+// shutdown first, which the protocol says should end the server with
+// status 1
+var errNoShutdown = errors.New("exit without shutdown")
+
+type server struct {
+	out      io.Writer
+	docs     map[string]*document // open documents, by URI
+	shutdown bool
+}
+
+// serve answers the LSP messages read from r on w until the client exits
+// or r ends
+func serve(r io.Reader, w io.Writer) error {
+	s := &server{out: w, docs: map[string]*document{}}
+	in := bufio.NewReader(r)
+	for {
+		body, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.reply(nil, nil, &rpcError{codeParseError, err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errNoShutdown
+			}
+			return nil
+		}
+		result, rerr := s.handle(req.Method, req.Params)
+		if req.ID == nil {
+			continue // a notification: no reply, even to an error
+		}
+		if err := s.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) reply(id json.RawMessage, result any, rerr *rpcError) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if rerr != nil {
+		return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *server) notify(method string, params any) {
+	writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Protocol types, as far as ual-lsp uses them
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string    `json:"uri"`
+	Range textRange `json:"range"`
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type docParams struct {
+	TextDocument   textDocumentItem `json:"textDocument"`
+	Text           *string          `json:"text"` // didSave, with includeText
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type positionParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+	Position     position         `json:"position"`
+}
+
+type lspDiagnostic struct {
+	Range    textRange `json:"range"`
+	Severity int       `json:"severity"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *textRange    `json:"range,omitempty"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type completionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// Completion item kinds
+const (
+	kindMethod   = 2
+	kindFunction = 3
+	kindVariable = 6
+	kindKeyword  = 14
+)
+
+// handle runs one request or notification and returns its result
+func (s *server) handle(method string, raw json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    1, // the whole text on every change
+					"save":      map[string]any{"includeText": false},
+				},
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]any{"triggerCharacters": []string{"@"}},
+			},
+			"serverInfo": map[string]any{"name": "ual-lsp", "version": version.Version},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave", "textDocument/didClose":
+		var p docParams
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, &rpcError{codeInvalidParams, err.Error()}
+		}
+		s.sync(method, p)
+		return nil, nil
+	case "textDocument/definition", "textDocument/hover", "textDocument/completion":
+		var p positionParams
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, &rpcError{codeInvalidParams, err.Error()}
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return nil, &rpcError{codeInvalidRequest, "document not open: " + p.TextDocument.URI}
+		}
+		line, col := d.fromPosition(p.Position)
+		switch method {
+		case "textDocument/definition":
+			return s.definition(d, line, col), nil
+		case "textDocument/hover":
+			return s.hover(d, line, col), nil
+		}
+		return s.completion(d, line, col), nil
+	}
+	return nil, &rpcError{codeMethodNotFound, "method not supported: " + method}
+}
+
+// sync follows the open documents, publishing diagnostics when one is
+// opened or saved
+func (s *server) sync(method string, p docParams) {
+	uri := p.TextDocument.URI
+	switch method {
+	case "textDocument/didOpen":
+		s.docs[uri] = newDocument(uri, uriPath(uri), p.TextDocument.Text)
+		s.publish(s.docs[uri])
+	case "textDocument/didChange":
+		if d := s.docs[uri]; d != nil && len(p.ContentChanges) > 0 {
+			d.update(p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+	case "textDocument/didSave":
+		if d := s.docs[uri]; d != nil {
+			if p.Text != nil {
+				d.update(*p.Text)
+			}
+			s.publish(d)
+		}
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": []lspDiagnostic{}})
+	}
+}
+
+// publish sends the problems pkg/check finds in d. Those in imported
+// files are shown at the start of d.
+func (s *server) publish(d *document) {
+	diags := []lspDiagnostic{}
+	for _, c := range check.Source(d.path, d.text) {
+		msg := c.Message
+		r := textRange{}
+		if c.File == d.path && c.Line > 0 {
+			r = d.tokenRange(c.Line, c.Column)
+		} else if c.File != d.path {
+			msg = c.String()
+		}
+		diags = append(diags, lspDiagnostic{Range: r, Severity: 1, Source: "ual", Message: msg})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": d.uri, "diagnostics": diags})
+}
+
+// definition returns where the name at line and col is declared
+func (s *server) definition(d *document, line, col int) any {
+	sym, ok := d.symbolAt(line, col)
+	if !ok {
+		return nil
+	}
+	n := len(sym.name)
+	if sym.kind == "stack" {
+		n++
+	}
+	return location{URI: d.uri, Range: textRange{d.toPosition(sym.pos.Line, sym.pos.Col), d.toPosition(sym.pos.Line, sym.pos.Col+n)}}
+}
+
+// hover describes the name or stack operation at line and col
+func (s *server) hover(d *document, line, col int) any {
+	tok, ok := d.tokenAt(line, col)
+	if !ok {
+		return nil
+	}
+	var text string
+	if sym, ok := d.symbolAt(line, col); ok {
+		text = "```ual\n" + sym.detail + "\n```"
+		if sym.summary != "" {
+			text += "\n\n" + sym.summary
+		}
+	} else if tok.Type == lexer.TokStackRef && builtinStacks[tok.Value] != "" {
+		text = fmt.Sprintf("```ual\n@%s\n```\n\n%s", tok.Value, builtinStacks[tok.Value])
+	} else if op, ok := stackOps[tok.Value]; ok {
+		text = fmt.Sprintf("```ual\n%s %s\n```\n\n%s", tok.Value, op.effect, op.doc)
+	} else {
+		return nil
+	}
+	r := textRange{d.toPosition(tok.Line, tok.Column), d.toPosition(tok.Line, tok.Column+tokenLen(tok))}
+	return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// completion offers the stacks after @, and otherwise stack operations
+// and the functions and variables in scope
+func (s *server) completion(d *document, line, col int) any {
+	items := []completionItem{}
+	text := ""
+	if line-1 < len(d.lines) {
+		text = d.lines[line-1]
+	}
+	start := min(col-1, len(text))
+	for start > 0 && isWordByte(text[start-1]) {
+		start--
+	}
+	var visible []symbol
+	if d.index != nil {
+		visible = d.index.visible(line)
+	}
+	if start > 0 && text[start-1] == '@' {
+		seen := map[string]bool{}
+		for _, sym := range visible {
+			if sym.kind == "stack" && !seen[sym.name] {
+				seen[sym.name] = true
+				items = append(items, completionItem{Label: sym.name, Kind: kindVariable, Detail: sym.detail})
+			}
+		}
+		for _, name := range sortedKeys(builtinStacks) {
+			if !seen[name] {
+				items = append(items, completionItem{Label: name, Kind: kindVariable, Detail: builtinStacks[name]})
+			}
+		}
+		return items
+	}
+	for _, name := range sortedKeys(stackOps) {
+		op := stackOps[name]
+		items = append(items, completionItem{Label: name, Kind: kindMethod, Detail: op.effect, Documentation: op.doc})
+	}
+	for _, sym := range visible {
+		switch sym.kind {
+		case "func":
+			items = append(items, completionItem{Label: sym.name, Kind: kindFunction, Detail: sym.detail})
+		case "var", "param", "view":
+			items = append(items, completionItem{Label: sym.name, Kind: kindVariable, Detail: sym.detail})
+		}
+	}
+	for _, name := range sortedKeys(check.BuiltinFuncs) {
+		items = append(items, completionItem{Label: name, Kind: kindFunction, Detail: "builtin"})
+	}
+	for _, kw := range []string{"var", "func", "if", "elseif", "else", "while", "for", "return", "break", "continue"} {
+		items = append(items, completionItem{Label: kw, Kind: kindKeyword})
+	}
+	return items
+}
+
+// symbolAt returns the declaration of the name at line and col
+func (d *document) symbolAt(line, col int) (symbol, bool) {
+	tok, ok := d.tokenAt(line, col)
+	if !ok || d.index == nil || (tok.Type != lexer.TokIdent && tok.Type != lexer.TokStackRef) {
+		return symbol{}, false
+	}
+	return d.index.lookup(tok.Value, tok.Type == lexer.TokStackRef, line, col)
+}
+
+// tokenRange is the range of the token starting at line and col, or of
+// the rest of the line if there is none
+func (d *document) tokenRange(line, col int) textRange {
+	if col < 1 {
+		col = 1
+	}
+	end := col + 1
+	if tok, ok := d.tokenAt(line, col); ok && tok.Column == col {
+		end = col + tokenLen(tok)
+	} else if line-1 < len(d.lines) && len(d.lines[line-1]) >= col {
+		end = len(d.lines[line-1]) + 1
+	}
+	return textRange{d.toPosition(line, col), d.toPosition(line, end)}
+}
+
+// toPosition converts a line and byte column, counted from 1, to an LSP
+// position, counted from 0 in UTF-16 units
+func (d *document) toPosition(line, col int) position {
+	if line < 1 || line > len(d.lines) {
+		return position{Line: max(line-1, 0)}
+	}
+	text := d.lines[line-1]
+	n := min(max(col-1, 0), len(text))
+	return position{Line: line - 1, Character: utf16Len(text[:n])}
+}
+
+// fromPosition is toPosition reversed
+func (d *document) fromPosition(p position) (line, col int) {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return p.Line + 1, p.Character + 1
+	}
+	text := d.lines[p.Line]
+	units, i := 0, 0
+	for i < len(text) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		units += len(utf16.Encode([]rune{r}))
+		i += size
+	}
+	return p.Line + 1, i + 1
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// uriPath returns the file path of a file: URI, or the URI itself
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// builtinStacks describes the stacks every program has
+var builtinStacks = map[string]string{
+	"dstack": "The data stack: i64, LIFO. Operations without a stack use it.",
+	"rstack": "The return stack: i64, LIFO. tor and fromr move values to and from it.",
+	"bool":   "Comparison results: bool, LIFO. if and while read it.",
+	"error":  "Errors raised by functions that can fail, read by consider.",
+	"spawn":  "Tasks waiting to run, added by @spawn < { ... }.",
+	"defer":  "Blocks run when the enclosing function returns.",
+}
+
+type stackOp struct {
+	effect string // stack effect, Forth style
+	doc    string
+}
+
+// stackOps are the operations offered for completion and described on
+// hover
+var stackOps = map[string]stackOp{
+	"push":    {"( -- a )", "Push its arguments, in order."},
+	"pop":     {"( a -- )", "Remove the top element; pop:x stores it in x, plain pop moves it to @dstack."},
+	"peek":    {"( a -- a )", "Read the top element without removing it."},
+	"take":    {"( a -- )", "Pop, waiting until an element arrives; take:x stores it in x."},
+	"let":     {"( a -- )", "Pop into a variable: let:x."},
+	"dup":     {"( a -- a a )", "Copy the top element."},
+	"drop":    {"( a -- )", "Discard the top element."},
+	"swap":    {"( a b -- b a )", "Exchange the top two elements."},
+	"over":    {"( a b -- a b a )", "Copy the second element to the top."},
+	"rot":     {"( a b c -- b c a )", "Rotate the third element to the top."},
+	"add":     {"( a b -- a+b )", "Add the top two elements."},
+	"sub":     {"( a b -- a-b )", "Subtract the top element from the second."},
+	"mul":     {"( a b -- a*b )", "Multiply the top two elements."},
+	"div":     {"( a b -- a/b )", "Divide the second element by the top."},
+	"mod":     {"( a b -- a%b )", "Remainder of the second element divided by the top."},
+	"neg":     {"( a -- -a )", "Negate the top element."},
+	"abs":     {"( a -- |a| )", "Absolute value of the top element."},
+	"inc":     {"( a -- a+1 )", "Add one to the top element."},
+	"dec":     {"( a -- a-1 )", "Subtract one from the top element."},
+	"min":     {"( a b -- min )", "Keep the smaller of the top two elements."},
+	"max":     {"( a b -- max )", "Keep the larger of the top two elements."},
+	"band":    {"( a b -- a&b )", "Bitwise and."},
+	"bor":     {"( a b -- a|b )", "Bitwise or."},
+	"bxor":    {"( a b -- a^b )", "Bitwise exclusive or."},
+	"bnot":    {"( a -- ^a )", "Bitwise complement."},
+	"shl":     {"( a n -- a<<n )", "Shift left by the top element."},
+	"shr":     {"( a n -- a>>n )", "Shift right by the top element."},
+	"eq":      {"( a b -- ) ( -- a==b )", "Compare the top two elements, pushing the result to @bool."},
+	"ne":      {"( a b -- ) ( -- a!=b )", "Compare the top two elements, pushing the result to @bool."},
+	"lt":      {"( a b -- ) ( -- a<b )", "Compare the top two elements, pushing the result to @bool."},
+	"gt":      {"( a b -- ) ( -- a>b )", "Compare the top two elements, pushing the result to @bool."},
+	"le":      {"( a b -- ) ( -- a<=b )", "Compare the top two elements, pushing the result to @bool."},
+	"ge":      {"( a b -- ) ( -- a>=b )", "Compare the top two elements, pushing the result to @bool."},
+	"print":   {"( a -- )", "Pop and print the top element."},
+	"println": {"( a -- )", "Pop and print the top element and a newline."},
+	"dot":     {"( a -- )", "Pop and print the top element and a newline, as Forth's ."},
+	"emit":    {"( c -- )", "Pop and print the top element as a character."},
+	"tor":     {"( a -- ) ( R: -- a )", "Move the top element to @rstack."},
+	"fromr":   {"( -- a ) ( R: a -- )", "Move the top of @rstack here."},
+	"clear":   {"( ... -- )", "Remove every element."},
+	"len":     {"( -- n )", "Push the number of elements to @dstack."},
+	"set":     {"( -- )", "Store a value under a key: set(key, value), for Hash stacks."},
+	"get":     {"( -- v )", "Read the value under a key: get(key), for Hash stacks."},
+	"has":     {"( -- ) ( -- b )", "Push to @bool whether a key is present: has(key)."},
+	"del":     {"( -- )", "Remove a key: del(key)."},
+	"bring":   {"( -- a )", "Pop from another stack and push here, converting the type."},
+	"freeze":  {"( -- )", "Make the stack read-only."},
+}
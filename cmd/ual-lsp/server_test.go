@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///work/prog.ual"
+
+const testSource = `@q = stack.new(i64, FIFO, cap: 8)
+var total i64 = 0
+
+func bump(n i64) {
+    var total i64 = n
+    @q push:total
+}
+
+bump(1)
+push:total dup drop
+@q pop
+`
+
+// session runs serve on the messages and returns what it wrote, decoded
+func session(t *testing.T, msgs ...string) []map[string]any {
+	t.Helper()
+	var in bytes.Buffer
+	for _, m := range msgs {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	var out bytes.Buffer
+	if err := serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var v map[string]any
+		if err := json.Unmarshal(body, &v); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	return got
+}
+
+func openMsg(text string) string {
+	data, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0", "method": "textDocument/didOpen",
+		"params": map[string]any{"textDocument": map[string]any{"uri": testURI, "text": text}},
+	})
+	return string(data)
+}
+
+func positionMsg(id int, method string, line, char int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"textDocument/%s","params":{"textDocument":{"uri":%q},"position":{"line":%d,"character":%d}}}`,
+		id, method, testURI, line, char)
+}
+
+// result returns the result of the response to request id
+func result(t *testing.T, msgs []map[string]any, id int) any {
+	t.Helper()
+	for _, m := range msgs {
+		if n, ok := m["id"].(float64); ok && int(n) == id {
+			if m["error"] != nil {
+				t.Fatalf("request %d failed: %v", id, m["error"])
+			}
+			return m["result"]
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+	return nil
+}
+
+func TestLifecycle(t *testing.T) {
+	msgs := session(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"workspace/symbol","params":{}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3: %v", len(msgs), msgs)
+	}
+	caps := result(t, msgs, 1).(map[string]any)["capabilities"].(map[string]any)
+	for _, c := range []string{"definitionProvider", "hoverProvider", "completionProvider", "textDocumentSync"} {
+		if caps[c] == nil {
+			t.Errorf("initialize does not announce %s", c)
+		}
+	}
+	if e, _ := msgs[1]["error"].(map[string]any); e == nil || e["code"].(float64) != codeMethodNotFound {
+		t.Errorf("unknown method: got %v", msgs[1])
+	}
+
+	var out bytes.Buffer
+	in := strings.NewReader("Content-Length: 33\r\n\r\n{\"jsonrpc\":\"2.0\",\"method\":\"exit\"}")
+	if err := serve(in, &out); err != errNoShutdown {
+		t.Errorf("exit without shutdown: got %v, want errNoShutdown", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	msgs := session(t, openMsg("@q = stack.new(i64)\n@q push:1\n  @nope push:2\nmissing(1)\n"))
+	if len(msgs) != 1 || msgs[0]["method"] != "textDocument/publishDiagnostics" {
+		t.Fatalf("got %v", msgs)
+	}
+	diags := msgs[0]["params"].(map[string]any)["diagnostics"].([]any)
+	if len(diags) != 2 {
+		t.Fatalf("got %d diagnostics, want 2: %v", len(diags), diags)
+	}
+	d := diags[0].(map[string]any)
+	if d["message"] != "undefined stack: @nope" {
+		t.Errorf("message = %q", d["message"])
+	}
+	r := d["range"].(map[string]any)
+	start, end := r["start"].(map[string]any), r["end"].(map[string]any)
+	if start["line"] != 2.0 || start["character"] != 2.0 || end["character"] != 7.0 {
+		t.Errorf("range = %v, want line 2 characters 2 to 7", r)
+	}
+
+	msgs = session(t, openMsg("@q push:(\n"))
+	diags = msgs[0]["params"].(map[string]any)["diagnostics"].([]any)
+	if len(diags) != 1 {
+		t.Errorf("parse error: got %v", diags)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	msgs := session(t, openMsg(testSource),
+		positionMsg(1, "definition", 10, 1), // @q
+		positionMsg(2, "definition", 8, 1),  // bump
+		positionMsg(3, "definition", 5, 13), // total in bump
+		positionMsg(4, "definition", 9, 7),  // total at the top level
+		positionMsg(5, "definition", 9, 12), // dup
+	)
+	for _, tt := range []struct {
+		id, line, char int
+	}{
+		{1, 0, 0},
+		{2, 3, 5},
+		{3, 4, 8},
+		{4, 1, 4},
+	} {
+		loc, ok := result(t, msgs, tt.id).(map[string]any)
+		if !ok {
+			t.Errorf("request %d: no location", tt.id)
+			continue
+		}
+		start := loc["range"].(map[string]any)["start"].(map[string]any)
+		if loc["uri"] != testURI || start["line"] != float64(tt.line) || start["character"] != float64(tt.char) {
+			t.Errorf("request %d: got %v, want %d:%d", tt.id, loc, tt.line, tt.char)
+		}
+	}
+	if loc := result(t, msgs, 5); loc != nil {
+		t.Errorf("definition of an operation: got %v", loc)
+	}
+}
+
+func TestHover(t *testing.T) {
+	msgs := session(t, openMsg(testSource),
+		positionMsg(1, "hover", 5, 5),  // @q in bump
+		positionMsg(2, "hover", 8, 2),  // bump
+		positionMsg(3, "hover", 9, 16), // drop
+		positionMsg(4, "hover", 1, 6),  // total
+	)
+	for id, want := range map[int][]string{
+		1: {"@q = stack.new(i64, FIFO, cap: 8)", "stack of i64, FIFO, capacity 8"},
+		2: {"func bump(n i64)"},
+		3: {"( a -- )"},
+		4: {"var total i64"},
+	} {
+		h, ok := result(t, msgs, id).(map[string]any)
+		if !ok {
+			t.Errorf("request %d: no hover", id)
+			continue
+		}
+		text := h["contents"].(map[string]any)["value"].(string)
+		for _, w := range want {
+			if !strings.Contains(text, w) {
+				t.Errorf("request %d: hover %q does not contain %q", id, text, w)
+			}
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	// Completion comes while typing: the text does not parse, so the
+	// names are those of the last text that did
+	change, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0", "method": "textDocument/didChange",
+		"params": map[string]any{
+			"textDocument":   map[string]any{"uri": testURI},
+			"contentChanges": []any{map[string]any{"text": testSource + "@\nbu\n"}},
+		},
+	})
+	msgs := session(t, openMsg(testSource), string(change),
+		positionMsg(1, "completion", 11, 1),
+		positionMsg(2, "completion", 12, 2),
+	)
+	labels := func(id int) map[string]bool {
+		out := map[string]bool{}
+		for _, it := range result(t, msgs, id).([]any) {
+			out[it.(map[string]any)["label"].(string)] = true
+		}
+		return out
+	}
+	stacks := labels(1)
+	for _, w := range []string{"q", "dstack", "bool"} {
+		if !stacks[w] {
+			t.Errorf("completion after @ lacks %s: %v", w, stacks)
+		}
+	}
+	if stacks["push"] {
+		t.Errorf("completion after @ offers operations")
+	}
+	names := labels(2)
+	for _, w := range []string{"push", "swap", "bump", "total"} {
+		if !names[w] {
+			t.Errorf("completion lacks %s", w)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	d := newDocument(testURI, "/work/prog.ual", "var s = \"é😀\" x\n")
+	// x is at byte 18 but UTF-16 unit 14
+	if p := d.toPosition(1, 18); p.Character != 14 {
+		t.Errorf("toPosition = %+v, want character 14", p)
+	}
+	if line, col := d.fromPosition(position{Line: 0, Character: 14}); line != 1 || col != 18 {
+		t.Errorf("fromPosition = %d:%d, want 1:18", line, col)
+	}
+	if p := uriPath("file:///work/my%20prog.ual"); p != "/work/my prog.ual" {
+		t.Errorf("uriPath = %q", p)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/backend"
+)
+
+// The backends ual has built in. Others register themselves with
+// pkg/backend from packages linked into cmd/ual.
+func init() {
+	backend.Register(goBackend{})
+	backend.Register(rustBackend{})
+}
+
+// autoTargets are the backends tried in order when --target is not given
+var autoTargets = []string{"go", "rust"}
+
+// backendOptions returns the options the flags give for the program at path
+func backendOptions(path string) *backend.Options {
+	return &backend.Options{
+		Path:       path,
+		NoForth:    noForth,
+		Optimize:   optimize,
+		Workers:    spawnWorkers,
+		CrashDump:  crashDumpDir,
+		Checked:    checked,
+		Profile:    buildProfile,
+		Strip:      stripBinary,
+		Static:     staticBinary,
+		KeepSource: keepSourceDir,
+		Library:    library,
+		Verbosity:  verbosity,
+		Bench:      benchMode,
+	}
+}
+
+// commandOutput is where a backend sends the output of the tools it runs:
+// nowhere, unless debugging
+func commandOutput(opts *backend.Options) io.Writer {
+	if opts.Verbosity >= verbDebug {
+		return os.Stderr
+	}
+	return io.Discard
+}
+
+// runOutput returns where a program run with opts, and its build, write
+// their output
+func runOutput(opts *backend.Options) io.Writer {
+	if opts.Output != nil {
+		return opts.Output
+	}
+	return os.Stderr
+}
+
+// connectProgram connects cmd, a program run with opts, to the standard
+// streams with stderr going through trace, or to trace alone when
+// opts.Output is set
+func connectProgram(cmd *exec.Cmd, opts *backend.Options, trace *traceWriter) {
+	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Stderr = trace
+	if opts.Output != nil {
+		cmd.Stdout = trace
+		return
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+}
+
+// exitStatus returns the status a program run by cmd exited with, or the
+// error if it could not be run
+func exitStatus(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// goBackend generates Go and builds it with the go command
+type goBackend struct{}
+
+func (goBackend) Name() string { return "go" }
+func (goBackend) Ext() string  { return ".go" }
+
+func (goBackend) Available() error {
+	if !checkGoVersion() {
+		return errors.New("Go >= 1.22 is not available\nhint: install Go from https://go.dev/dl/")
+	}
+	return nil
+}
+
+func (goBackend) Generate(prog *ast.Program, opts *backend.Options) (*backend.Output, error) {
+	code, srcMap, err := generateGoProgram(prog, opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &backend.Output{Code: code, Map: srcMap}, nil
+}
+
+func (goBackend) Build(out *backend.Output, opts *backend.Options, binary string) error {
+	var ldflags string
+	if opts.Profile == "small" || opts.Profile == "release" && opts.Strip {
+		ldflags = "-s -w"
+	}
+	if opts.KeepSource != "" {
+		if err := buildGoProject(out.Code, ldflags, binary, opts); err != nil {
+			return err
+		}
+	} else if err := buildGoBinary(out.Code, ldflags, binary, opts); err != nil {
+		return err
+	}
+	if opts.Static {
+		return checkStatic(binary)
+	}
+	return nil
+}
+
+// buildGoBinary builds code, or finds it in the build cache, and copies
+// the binary to binary
+func buildGoBinary(code, ldflags, binary string, opts *backend.Options) error {
+	b, err := buildGoCached(code, ldflags, opts.Static, commandOutput(opts))
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	// Copy the binary out, as the cache keeps its own
+	data, err := os.ReadFile(b.binary)
+	if err == nil {
+		os.Remove(binary) // a running program's binary cannot be rewritten
+		err = os.WriteFile(binary, data, 0755)
+	}
+	if err != nil {
+		return fmt.Errorf("writing output binary: %v", err)
+	}
+	return nil
+}
+
+// buildGoProject writes code to opts.KeepSource as a Go project of its own
+// and builds it there with go build, as its users will
+func buildGoProject(code, ldflags, binary string, opts *backend.Options) error {
+	if err := os.MkdirAll(opts.KeepSource, 0755); err != nil {
+		return err
+	}
+	if err := writeGoProject(opts.KeepSource, goModuleName(opts.Path), code); err != nil {
+		return fmt.Errorf("writing Go project: %v", err)
+	}
+	// go build uses vendor/ by itself, unless GOFLAGS says otherwise
+	args := []string{"build", "-mod=vendor"}
+	if ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	buildCmd := exec.Command("go", append(args, "-o", binary, ".")...)
+	buildCmd.Dir = opts.KeepSource
+	buildCmd.Env = goBuildEnv(opts.Static)
+	buildCmd.Stdout = commandOutput(opts)
+	buildCmd.Stderr = buildCmd.Stdout
+	if err := buildCmd.Run(); err != nil {
+		return fmt.Errorf("go build failed: %v", err)
+	}
+	return nil
+}
+
+func (goBackend) Run(out *backend.Output, opts *backend.Options, args []string) (int, error) {
+	b, err := buildGoCached(out.Code, "", false, runOutput(opts))
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	if opts.Verbosity >= verbVerbose {
+		fmt.Fprintf(os.Stderr, "running %s...\n", opts.Path)
+	}
+
+	// Panic traces name main.go lines; point them at the .ual source
+	trace := newTraceWriter(runOutput(opts), b.goFile, out.Map)
+	cmd := exec.Command(b.binary, args...)
+	connectProgram(cmd, opts, trace)
+	err = cmd.Run()
+	trace.Flush()
+	if err != nil {
+		return exitStatus(fmt.Errorf("running %s failed: %w", opts.Path, err))
+	}
+	return 0, nil
+}
+
+// rustBackend generates Rust and builds it with cargo against rual
+type rustBackend struct{}
+
+func (rustBackend) Name() string { return "rust" }
+func (rustBackend) Ext() string  { return ".rs" }
+
+func (rustBackend) Available() error {
+	if !checkRustVersion() {
+		return errors.New("Rust >= 1.75 is not available\nhint: install Rust from https://rustup.rs/")
+	}
+	return nil
+}
+
+func (rustBackend) Generate(prog *ast.Program, opts *backend.Options) (*backend.Output, error) {
+	code, srcMap, err := generateRustProgram(prog, opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &backend.Output{Code: code, Map: srcMap}, nil
+}
+
+// rualRuntime returns the rual runtime directory, or an error saying
+// where it should be
+func rualRuntime() (string, error) {
+	rualDir := findRualRuntime()
+	if rualDir == "" {
+		return "", errors.New("cannot find rual runtime library\nhint: make sure the 'rual' directory exists alongside the ual compiler")
+	}
+	return rualDir, nil
+}
+
+func (rustBackend) Build(out *backend.Output, opts *backend.Options, binary string) error {
+	if opts.KeepSource != "" {
+		return errors.New("--keep-source is not supported by the Rust backend")
+	}
+	rualDir, err := rualRuntime()
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ual-build-rust")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if opts.Verbosity >= verbDebug {
+		fmt.Fprintf(os.Stderr, "temp dir: %s\n", tmpDir)
+		fmt.Fprintf(os.Stderr, "rual dir: %s\n", rualDir)
+	}
+
+	srcDir := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		return fmt.Errorf("creating src dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "main.rs"), []byte(out.Code), 0644); err != nil {
+		return fmt.Errorf("writing temp file: %v", err)
+	}
+	// Cargo.toml with the profile's settings
+	cargoToml := generateCargoToml(rualDir, opts)
+	if err := os.WriteFile(filepath.Join(tmpDir, "Cargo.toml"), []byte(cargoToml), 0644); err != nil {
+		return fmt.Errorf("writing Cargo.toml: %v", err)
+	}
+
+	target := "release"
+	args := []string{"build", "--release"}
+	if opts.Profile == "debug" {
+		target = "debug"
+		args = []string{"build"}
+	}
+	targetDir := filepath.Join(tmpDir, "target")
+	var musl string
+	if opts.Static {
+		if musl, err = muslTarget(); err != nil {
+			return err
+		}
+		args = append(args, "--target", musl)
+		targetDir = filepath.Join(targetDir, musl)
+	}
+	cmd := exec.Command("cargo", args...)
+	cmd.Dir = tmpDir
+	cmd.Stdout = commandOutput(opts)
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		if musl != "" {
+			return fmt.Errorf("cargo build failed: %v\nhint: a static build needs the musl target: rustup target add %s", err, musl)
+		}
+		return fmt.Errorf("cargo build failed: %v", err)
+	}
+
+	// Read and write the binary, to copy across filesystems
+	data, err := os.ReadFile(filepath.Join(targetDir, target, "ual_program"))
+	if err != nil {
+		return fmt.Errorf("reading built binary: %v", err)
+	}
+	if err := os.WriteFile(binary, data, 0755); err != nil {
+		return fmt.Errorf("writing output binary: %v", err)
+	}
+
+	// Strip if requested and the Cargo profile has not
+	if opts.Strip && opts.Profile != "small" {
+		exec.Command("strip", binary).Run() // strip might not be available
+	}
+	if opts.Static {
+		return checkStatic(binary)
+	}
+	return nil
+}
+
+// generateCargoToml returns the Cargo.toml of a program built against the
+// rual runtime at rualDir with the profile of opts
+func generateCargoToml(rualDir string, opts *backend.Options) string {
+	var profile string
+	switch opts.Profile {
+	case "debug":
+		profile = `[profile.dev]
+opt-level = 0
+debug = true`
+	case "release":
+		if opts.Strip {
+			profile = `[profile.release]
+opt-level = 3
+strip = true`
+		} else {
+			profile = `[profile.release]
+opt-level = 3`
+		}
+	case "small":
+		profile = `[profile.release]
+opt-level = "z"
+lto = true
+codegen-units = 1
+panic = "abort"
+strip = true`
+	}
+
+	return fmt.Sprintf(`[package]
+name = "ual_program"
+version = "0.1.0"
+edition = "2021"
+
+[dependencies]
+rual = { path = "%s" }
+lazy_static = "1.4"
+
+%s
+`, rualDir, profile)
+}
+
+func (rustBackend) Run(out *backend.Output, opts *backend.Options, args []string) (int, error) {
+	rualDir, err := rualRuntime()
+	if err != nil {
+		return 0, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ual-run-rust")
+	if err != nil {
+		return 0, fmt.Errorf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if opts.Verbosity >= verbDebug {
+		fmt.Fprintf(os.Stderr, "temp dir: %s\n", tmpDir)
+		fmt.Fprintf(os.Stderr, "rual dir: %s\n", rualDir)
+	}
+	var features []string
+	if opts.Bench {
+		features = append(features, "bench")
+	}
+	if err := writeRustProject(tmpDir, out.Code, rualDir, features...); err != nil {
+		return 0, err
+	}
+	// Build first, so the status returned is the program's and not cargo's
+	build := exec.Command("cargo", "build", "--release", "-q")
+	build.Dir = tmpDir
+	build.Stdout = runOutput(opts)
+	build.Stderr = build.Stdout
+	if err := build.Run(); err != nil {
+		return 0, fmt.Errorf("cargo build failed: %v", err)
+	}
+
+	if opts.Verbosity >= verbVerbose {
+		fmt.Fprintf(os.Stderr, "running %s...\n", opts.Path)
+	}
+
+	trace := newTraceWriter(runOutput(opts), "src/main.rs", out.Map)
+	cmd := exec.Command(filepath.Join(tmpDir, "target", "release", "ual_program"), args...)
+	connectProgram(cmd, opts, trace)
+	err = cmd.Run()
+	trace.Flush()
+	if err != nil {
+		return exitStatus(fmt.Errorf("running %s failed: %w", opts.Path, err))
+	}
+	return 0, nil
+}
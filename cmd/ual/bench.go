@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/runtime/bench"
+)
+
+// ual bench builds each _test.ual file under its paths as ual test does,
+// with its bench blocks in instead of its tests, and runs it. Every
+// bench block times its body through bench.Run, or rual::run_bench on
+// the Rust target, and reports to the file the runner names in
+// $UAL_BENCH_REPORT.
+
+// benchFile is the outcome of the bench blocks of one _test.ual file
+type benchFile struct {
+	path    string
+	benches []bench.Result // in source order
+	output  string         // what the program printed
+	err     error          // it did not compile, build or exit cleanly
+}
+
+func (f *benchFile) failed() int {
+	n := 0
+	for _, b := range f.benches {
+		if b.Message != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func benchCommand(args []string) {
+	format := "text"
+	benchTime := ""
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--format", "--benchtime":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: %s requires an argument\n", arg)
+				os.Exit(1)
+			}
+			i++
+			if arg == "--format" {
+				format = args[i]
+			} else {
+				benchTime = args[i]
+			}
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "error: --format must be 'text' or 'json', got '%s'\n", format)
+		os.Exit(1)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "error: no _test.ual files in %s\n", strings.Join(paths, " "))
+		os.Exit(1)
+	}
+
+	targetLang = resolveTarget()
+	benchMode = true
+	var results []benchFile
+	for _, path := range files {
+		results = append(results, runBenchFile(path, benchTime))
+	}
+	if format == "json" {
+		writeBenchJSON(os.Stdout, results)
+	} else {
+		writeBenchText(os.Stdout, results, verbosity >= verbVerbose)
+	}
+	for _, f := range results {
+		if f.err != nil || f.failed() > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// runBenchFile compiles, builds and runs the bench blocks of path, for
+// benchTime when it is set
+func runBenchFile(path, benchTime string) benchFile {
+	f := benchFile{path: path}
+	prog, err := loadProgram(path)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	var benches []bench.Result
+	for _, s := range prog.Stmts {
+		if b, ok := s.(*ast.BenchBlock); ok && prog.Pos[s].File == "" {
+			benches = append(benches, bench.Result{Name: b.Name, File: path, Line: prog.Pos[s].Line})
+		}
+	}
+	if len(benches) == 0 {
+		return f // nothing to build
+	}
+	var env []string
+	if benchTime != "" {
+		env = append(env, bench.TimeEnv+"="+benchTime)
+	}
+	run, err := runHarness(prog, path, bench.ReportEnv, env...)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	f.output = run.output
+	ran, err := readBenchReport(run.report)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	exited := "program exited"
+	if run.status != 0 {
+		exited = fmt.Sprintf("program exited with status %d", run.status)
+	}
+	f.benches = benches
+	missing := false
+	for i, b := range f.benches {
+		if r, ok := ran[b.Line]; ok {
+			r.File = path
+			f.benches[i] = r
+			continue
+		}
+		f.benches[i].Message = exited + " before the benchmark ended"
+		missing = true
+	}
+	if run.status != 0 && !missing {
+		f.err = errors.New(exited)
+	}
+	return f
+}
+
+// readBenchReport reads the results a bench program wrote, by line
+func readBenchReport(data []byte) (map[int]bench.Result, error) {
+	ran := make(map[int]bench.Result)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var r bench.Result
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("bench report: %v", err)
+		}
+		ran[r.Line] = r
+	}
+	return ran, sc.Err()
+}
+
+// writeBenchText writes the results in the columns of go test -bench:
+// name, iterations, ns/op, B/op and allocs/op, with the reason of each
+// failure and the program's output when a file failed or verbose is set
+func writeBenchText(w io.Writer, files []benchFile, verbose bool) {
+	total, failed, errs := 0, 0, 0
+	for _, f := range files {
+		for _, b := range f.benches {
+			if b.Message != "" {
+				fmt.Fprintf(w, "FAIL %s:%d %s\n     %s\n", f.path, b.Line, b.Name, b.Message)
+				continue
+			}
+			fmt.Fprintf(w, "%-32s %10d %12.2f ns/op %8d B/op %8d allocs/op\n",
+				fmt.Sprintf("%s:%d %s", f.path, b.Line, b.Name), b.N, b.NsPerOp, b.BytesPerOp, b.AllocsPerOp)
+		}
+		if f.err != nil {
+			errs++
+			fmt.Fprintf(w, "FAIL %s\n", f.path)
+			for _, line := range strings.Split(f.err.Error(), "\n") {
+				fmt.Fprintf(w, "     %s\n", line)
+			}
+		}
+		total += len(f.benches)
+		failed += f.failed()
+		if f.output != "" && (verbose || f.err != nil || f.failed() > 0) {
+			fmt.Fprintf(w, "--- output of %s\n%s", f.path, f.output)
+			if !strings.HasSuffix(f.output, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+	switch {
+	case errs > 0:
+		fmt.Fprintf(w, "FAIL: %d of %d benchmarks failed, %d of %d files did not run cleanly\n", failed, total, errs, len(files))
+	case failed > 0:
+		fmt.Fprintf(w, "FAIL: %d of %d benchmarks failed\n", failed, total)
+	default:
+		fmt.Fprintf(w, "PASS: %d benchmarks\n", total)
+	}
+}
+
+// benchReport is the document ual bench --format json writes
+type benchReport struct {
+	Passed     bool            `json:"passed"`
+	Total      int             `json:"total"`
+	Failed     int             `json:"failed"`
+	Benchmarks []bench.Result  `json:"benchmarks"`
+	Errors     []testFileError `json:"errors,omitempty"`
+}
+
+func writeBenchJSON(w io.Writer, files []benchFile) {
+	r := benchReport{Benchmarks: []bench.Result{}}
+	for _, f := range files {
+		r.Benchmarks = append(r.Benchmarks, f.benches...)
+		r.Total += len(f.benches)
+		r.Failed += f.failed()
+		if f.err != nil {
+			r.Errors = append(r.Errors, testFileError{File: f.path, Message: f.err.Error(), Output: f.output})
+		}
+	}
+	r.Passed = r.Failed == 0 && len(r.Errors) == 0
+	data, _ := json.MarshalIndent(r, "", "  ")
+	fmt.Fprintf(w, "%s\n", data)
+}
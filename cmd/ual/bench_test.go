@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/runtime/bench"
+)
+
+// benchResults are a finished benchmark, a failing one and a file that
+// did not build
+var benchResults = []benchFile{
+	{
+		path: "a_test.ual",
+		benches: []bench.Result{
+			{Name: "push-heavy", File: "a_test.ual", Line: 1, N: 1000, NsPerOp: 12.5, BytesPerOp: 16, AllocsPerOp: 2},
+			{Name: "boom", File: "a_test.ual", Line: 6, Message: "boom"},
+		},
+		output: "hello\n",
+	},
+	{path: "b_test.ual", err: errors.New("go build failed")},
+}
+
+func TestBenchText(t *testing.T) {
+	var b bytes.Buffer
+	writeBenchText(&b, benchResults, false)
+	want := `a_test.ual:1 push-heavy                1000        12.50 ns/op       16 B/op        2 allocs/op
+FAIL a_test.ual:6 boom
+     boom
+--- output of a_test.ual
+hello
+FAIL b_test.ual
+     go build failed
+FAIL: 1 of 2 benchmarks failed, 1 of 2 files did not run cleanly
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestBenchJSON(t *testing.T) {
+	var b bytes.Buffer
+	writeBenchJSON(&b, benchResults)
+	var r benchReport
+	if err := json.Unmarshal(b.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Passed || r.Total != 2 || r.Failed != 1 || len(r.Benchmarks) != 2 || len(r.Errors) != 1 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if r.Benchmarks[0].NsPerOp != 12.5 || r.Benchmarks[1].Message != "boom" {
+		t.Errorf("unexpected benchmarks %+v", r.Benchmarks)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/version"
+)
+
+// ============================================================================
+// Build cache
+//
+// ual build, run, test and bench build the generated Go as a module of its
+// own. Writing that module and running go mod tidy for every build took
+// seconds even for hello world, so the results are kept:
+//
+//	mod/<key>  go.mod and go.sum as tidied, by the untidied go.mod and the
+//	           packages the program imports
+//	go/<key>   main.go, its module and the binary, by the compiler version,
+//	           the generated code, the module, the ual runtime when it is a
+//	           local copy, the Go toolchain, the linker flags and --static
+//
+// under $UAL_BUILD_CACHE, or ual/build in the user's cache directory. A
+// program already built is not built again; a new one reuses the tidied
+// module of any earlier program with the same imports. UAL_BUILD_CACHE=off
+// builds in a temporary directory as before, and ual cache clean empties
+// the cache.
+// ============================================================================
+
+// buildCacheDir returns the build cache directory, or "" if the cache is off
+func buildCacheDir() string {
+	dir := os.Getenv("UAL_BUILD_CACHE")
+	if dir == "off" {
+		return ""
+	}
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(userCache, "ual", "build")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ""
+	}
+	return dir
+}
+
+// goBuild is a built Go program
+type goBuild struct {
+	dir    string // holding main.go, go.mod and the binary
+	temp   bool   // dir is removed by Close
+	goFile string
+	binary string
+}
+
+// Close removes the build's directory, unless the cache keeps it
+func (b *goBuild) Close() {
+	if b.temp {
+		os.RemoveAll(b.dir)
+	}
+}
+
+// buildGoCached builds goCode with ldflags, and without cgo if static, or
+// finds it built in the cache. The compiler's output goes to out.
+func buildGoCached(goCode, ldflags string, static bool, out io.Writer) (*goBuild, error) {
+	ualDir := findUalRuntime()
+	goMod := goModFile(ualDir, goImports(goCode))
+	cache := buildCacheDir()
+
+	// A program is built where the cache keeps it, as panic traces name
+	// the path of main.go. If another ual is building it there, or the
+	// cache is off, it is built in a temporary directory instead.
+	var b *goBuild
+	if cache != "" {
+		parts := []string{version.Version, goCode, goMod, runtimeStamp(ualDir), goToolchain(), ldflags}
+		if static {
+			parts = append(parts, "static")
+		}
+		key := cacheKey(parts...)
+		dir := filepath.Join(cache, "go", key)
+		b = &goBuild{dir: dir, goFile: filepath.Join(dir, "main.go"), binary: filepath.Join(dir, "ual_program")}
+		if _, err := os.Stat(b.binary); err == nil {
+			if verbosity >= verbDebug {
+				fmt.Fprintf(os.Stderr, "cached build: %s\n", dir)
+			}
+			return b, nil
+		}
+		if !claimDir(dir) {
+			b = nil
+		}
+	}
+	if b == nil {
+		tmpDir, err := os.MkdirTemp("", "ual-build")
+		if err != nil {
+			return nil, fmt.Errorf("creating temp dir: %v", err)
+		}
+		b = &goBuild{dir: tmpDir, temp: true, goFile: filepath.Join(tmpDir, "main.go"), binary: filepath.Join(tmpDir, "ual_program")}
+	}
+	if verbosity >= verbDebug {
+		fmt.Fprintf(os.Stderr, "build dir: %s\n", b.dir)
+		if ualDir != "" {
+			fmt.Fprintf(os.Stderr, "using local runtime: %s\n", ualDir)
+		}
+	}
+
+	err := b.build(goCode, goMod, ualDir, ldflags, static, cache, out)
+	if err != nil {
+		os.RemoveAll(b.dir) // a failed build is not kept
+	}
+	return b, err
+}
+
+// build writes goCode and its module to b's directory and builds them
+func (b *goBuild) build(goCode, goMod, ualDir, ldflags string, static bool, cache string, out io.Writer) error {
+	if err := os.WriteFile(b.goFile, []byte(goCode), 0644); err != nil {
+		return fmt.Errorf("writing temp file: %v", err)
+	}
+	if err := writeGoModule(b.dir, goMod, goCode, ualDir, cache); err != nil {
+		return err
+	}
+
+	// Build, then run the binary directly: go run reports every non-zero
+	// status as 1, which would hide the program's exit(code)
+	args := []string{"build"}
+	if ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	// The binary is renamed into place, so that no ual runs half of it
+	buildCmd := exec.Command("go", append(args, "-o", b.binary+".tmp", ".")...)
+	buildCmd.Dir = b.dir
+	buildCmd.Env = goBuildEnv(static)
+	buildCmd.Stdout = out
+	buildCmd.Stderr = out
+	if err := buildCmd.Run(); err != nil {
+		return fmt.Errorf("go build failed: %v", err)
+	}
+	return os.Rename(b.binary+".tmp", b.binary)
+}
+
+// goBuildEnv is the environment go build runs in: ual's own, with cgo off
+// for a static binary
+func goBuildEnv(static bool) []string {
+	if !static {
+		return nil
+	}
+	return append(os.Environ(), "CGO_ENABLED=0")
+}
+
+// claimDir creates dir for a build, reporting false if another ual has.
+// A directory left without a binary for ten minutes is from a build that
+// died, and is taken over.
+func claimDir(dir string) bool {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return false
+	}
+	if os.Mkdir(dir, 0755) == nil {
+		return true
+	}
+	info, err := os.Stat(dir)
+	if err != nil || time.Since(info.ModTime()) < 10*time.Minute {
+		return false
+	}
+	return os.RemoveAll(dir) == nil && os.Mkdir(dir, 0755) == nil
+}
+
+// goModFile is the go.mod of a generated program that imports imports.
+// With a local copy of the ual runtime at ualDir it builds against that
+// copy. The sqlite package is a module of its own, required only by the
+// programs that import it.
+func goModFile(ualDir string, imports []string) string {
+	mods := []string{ualModule}
+	for _, imp := range imports {
+		if imp == sqliteModule {
+			mods = append(mods, sqliteModule)
+		}
+	}
+	var b strings.Builder
+	b.WriteString("module ual_program\n\ngo 1.22\n\n")
+	for _, mod := range mods {
+		fmt.Fprintf(&b, "require %s v%s\n", mod, version.Version)
+	}
+	if ualDir != "" {
+		b.WriteString("\n")
+		for _, mod := range mods {
+			fmt.Fprintf(&b, "replace %s => %s\n", mod, filepath.Join(ualDir, strings.TrimPrefix(mod, ualModule)))
+		}
+	}
+	return b.String()
+}
+
+// writeGoModule writes goMod to dir and tidies it, or copies the go.mod
+// and go.sum tidied for an earlier program with the same imports and
+// runtime from the cache
+func writeGoModule(dir, goMod, goCode, ualDir, cache string) error {
+	var saved string
+	if cache != "" {
+		// A local runtime's own requirements are part of the tidied module
+		var runtimeMod []byte
+		if ualDir != "" {
+			runtimeMod, _ = os.ReadFile(filepath.Join(ualDir, "go.mod"))
+			sqliteMod, _ := os.ReadFile(filepath.Join(ualDir, "pkg", "sqlite", "go.mod"))
+			runtimeMod = append(runtimeMod, sqliteMod...)
+		}
+		saved = filepath.Join(cache, "mod", cacheKey(goMod, strings.Join(goImports(goCode), "\n"), string(runtimeMod)))
+		if copyFiles(saved, dir, "go.mod", "go.sum") == nil {
+			return nil
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+		return fmt.Errorf("writing go.mod: %v", err)
+	}
+	tidyCmd := exec.Command("go", "mod", "tidy")
+	tidyCmd.Dir = dir
+	if verbosity >= verbDebug {
+		tidyCmd.Stdout = os.Stdout
+		tidyCmd.Stderr = os.Stderr
+	}
+	if tidyCmd.Run() != nil || saved == "" {
+		return nil // the build reports what went wrong
+	}
+	// A module with no requirements to check has no go.sum; an empty one
+	// is saved so that the cached module is complete
+	sum := filepath.Join(dir, "go.sum")
+	if _, err := os.Stat(sum); errors.Is(err, fs.ErrNotExist) {
+		os.WriteFile(sum, nil, 0644)
+	}
+
+	// Saved in a directory renamed into place, so that no ual finds a
+	// go.mod without its go.sum
+	if os.MkdirAll(filepath.Dir(saved), 0755) != nil {
+		return nil
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(saved), "tidy")
+	if err != nil {
+		return nil
+	}
+	if copyFiles(dir, tmp, "go.mod", "go.sum") != nil || os.Rename(tmp, saved) != nil {
+		os.RemoveAll(tmp)
+	}
+	return nil
+}
+
+// copyFiles copies the named files from the directory src to dst
+func copyFiles(src, dst string, names ...string) error {
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goImports returns the sorted import paths of goCode
+func goImports(goCode string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "main.go", goCode, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// runtimeStamp identifies the state of a local copy of the ual runtime at
+// ualDir, by the name, size and modification time of its files, so that a
+// program built against it is rebuilt when it changes. A released runtime
+// never changes, and its stamp is "".
+func runtimeStamp(ualDir string) string {
+	if ualDir == "" {
+		return ""
+	}
+	h := sha256.New()
+	for _, name := range []string{"go.mod", "go.sum", "pkg/sqlite/go.mod", "pkg/sqlite/go.sum"} {
+		if info, err := os.Stat(filepath.Join(ualDir, name)); err == nil {
+			fmt.Fprintf(h, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	filepath.WalkDir(filepath.Join(ualDir, "pkg"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// goToolchain identifies the Go toolchain and the platform it builds for
+func goToolchain() string {
+	out, err := exec.Command("go", "env", "GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS").Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// cacheKey hashes parts into the name of a cache entry
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// cacheCommand runs ual cache: dir prints the build cache directory and
+// clean empties it
+func cacheCommand(args []string) {
+	if len(args) != 1 || (args[0] != "dir" && args[0] != "clean") {
+		fmt.Fprintln(os.Stderr, "usage: ual cache dir|clean")
+		os.Exit(1)
+	}
+	dir := buildCacheDir()
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "error: the build cache is off")
+		os.Exit(1)
+	}
+	if args[0] == "dir" {
+		fmt.Println(dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if verbosity >= verbNormal {
+		fmt.Fprintf(os.Stderr, "removed %s\n", dir)
+	}
+}
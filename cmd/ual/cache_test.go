@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoImports(t *testing.T) {
+	got := goImports(`package main
+
+import (
+	"os"
+	rt "github.com/ha1tch/ual/pkg/runtime"
+	"fmt"
+)
+
+func main() {}
+`)
+	want := []string{"fmt", "github.com/ha1tch/ual/pkg/runtime", "os"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imports %v, want %v", got, want)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	if cacheKey("a", "bc") == cacheKey("ab", "c") {
+		t.Error("keys of different parts collide")
+	}
+	if cacheKey("a", "b") != cacheKey("a", "b") {
+		t.Error("key is not stable")
+	}
+}
+
+func TestBuildCacheDir(t *testing.T) {
+	t.Setenv("UAL_BUILD_CACHE", "off")
+	if dir := buildCacheDir(); dir != "" {
+		t.Errorf("cache off, got %q", dir)
+	}
+	want := filepath.Join(t.TempDir(), "cache")
+	t.Setenv("UAL_BUILD_CACHE", want)
+	if dir := buildCacheDir(); dir != want {
+		t.Errorf("cache dir %q, want %q", dir, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Error("cache dir not created")
+	}
+}
+
+func TestClaimDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "go", "key")
+	if !claimDir(dir) {
+		t.Fatal("first claim failed")
+	}
+	if claimDir(dir) {
+		t.Error("a dir being built was claimed twice")
+	}
+}
+
+func TestGoModFile(t *testing.T) {
+	plain := goModFile("/src/ual", []string{"fmt", ualModule + "/pkg/runtime"})
+	if strings.Contains(plain, sqliteModule) || !strings.Contains(plain, "replace "+ualModule+" => /src/ual\n") {
+		t.Errorf("go.mod without sqlite:\n%s", plain)
+	}
+	withSQL := goModFile("/src/ual", []string{ualModule + "/pkg/runtime", sqliteModule})
+	if !strings.Contains(withSQL, "require "+sqliteModule+" v") || !strings.Contains(withSQL, "replace "+sqliteModule+" => /src/ual/pkg/sqlite\n") {
+		t.Errorf("go.mod with sqlite:\n%s", withSQL)
+	}
+	if released := goModFile("", []string{sqliteModule}); strings.Contains(released, "replace") {
+		t.Errorf("go.mod for a released runtime:\n%s", released)
+	}
+}
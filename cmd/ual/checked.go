@@ -0,0 +1,28 @@
+package main
+
+import "regexp"
+
+// Checked builds (--checked).
+//
+// Generated code mostly ignores the error of a pop or peek, as in
+//
+//	{ v, _ := stack_s.Pop(); ... }
+//
+// so popping an empty stack carries on with a zero. In a checked build
+// emit routes every such call through ual.Checked,
+//
+//	{ v, _ := ual.Checked(stack_s.Pop()); ... }
+//
+// which panics on an empty stack. Every statement is preceded by a //line
+// directive, as for crash reports, so the panic names the .ual file and
+// line the pop came from. Calls whose error the generated code tests, such
+// as view pops, are left alone. With -O, the native dstack helpers check
+// the depth themselves.
+
+// uncheckedPop matches a pop or peek whose error is assigned to _
+var uncheckedPop = regexp.MustCompile(`\b(\w+, _ :?= )([\w.]+)\.(Pop|Peek)\(\)`)
+
+// checkPops rewrites the unchecked pops and peeks in line s
+func checkPops(s string) string {
+	return uncheckedPop.ReplaceAllString(s, "${1}ual.Checked(${2}.${3}())")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Codeblock values capture the variables they use by value: each closure
+// starts from a copy of every outside variable its body names, taken when
+// the codeblock is created. Go backend variables live in shared slots of
+// the type stacks, so without the copy two closures made by one function
+// would see whichever call ran last; the Rust backend moves the copies
+// into its closures.
+
+// codeblockCaptures returns the names f's body uses that it does not
+// declare itself, in order of first use. The caller keeps those that are
+// variables in scope.
+func codeblockCaptures(f *ast.FnLit) []string {
+	c := &captureAnalysis{declared: map[string]bool{}, seen: map[string]bool{}}
+	for _, p := range f.Params {
+		c.declared[p] = true
+	}
+	c.walk(reflect.ValueOf(f.Body))
+	var names []string
+	for _, name := range c.names {
+		if !c.declared[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type captureAnalysis struct {
+	declared map[string]bool // parameters and variables declared in the body
+	seen     map[string]bool
+	names    []string // names used, in order
+}
+
+// walk visits every node under v
+func (c *captureAnalysis) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			c.walk(v.Elem())
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		c.visit(v.Interface())
+		c.walk(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			c.walk(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			c.walk(v.Index(i))
+		}
+	}
+}
+
+// visit records the names node declares or uses
+func (c *captureAnalysis) visit(node interface{}) {
+	switch n := node.(type) {
+	case *ast.VarDecl:
+		for _, name := range n.Names {
+			c.declared[name] = true
+		}
+	case *ast.Assignment:
+		c.declared[n.Name] = true
+	case *ast.ForStmt:
+		for _, name := range n.Params {
+			c.declared[name] = true
+		}
+	case *ast.RangeStmt:
+		c.declared[n.Var] = true
+	case *ast.FnLit:
+		for _, name := range n.Params {
+			c.declared[name] = true
+		}
+	case *ast.Ident:
+		c.use(n.Name)
+	case *ast.IndexExpr:
+		c.use(n.Target)
+	case *ast.StackOp:
+		if n.Target != "" {
+			c.use(n.Target)
+		}
+	case *ast.LetAssign:
+		c.use(n.Name)
+	case *ast.AssignStmt:
+		c.use(n.Name)
+	}
+}
+
+func (c *captureAnalysis) use(name string) {
+	if !c.seen[name] {
+		c.seen[name] = true
+		c.names = append(c.names, name)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestCodeblockCaptures(t *testing.T) {
+	src := `var f fn = {|x|
+    var y i64 = x + base
+    @s push:(y * scale)
+    @s pop:out
+    g = {|z| z + x + inner}
+    return limit
+}
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := prog.Stmts[0].(*ast.VarDecl).Values[0].(*ast.FnLit)
+	want := "base scale out inner limit"
+	if got := strings.Join(codeblockCaptures(fn), " "); got != want {
+		t.Errorf("captures %q, want %q", got, want)
+	}
+}
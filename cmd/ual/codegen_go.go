@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/ir"
+	"github.com/ha1tch/ual/pkg/runtime"
 )
 
 type CodeGen struct {
@@ -16,14 +22,48 @@ type CodeGen struct {
 	vars             map[string]bool   // declared variables
 	varOrder         []string          // order of variable declarations for auto-print
 	symbols          *SymbolTable      // variable symbol table
-	fnCounter        int
+	library          string            // ual compile --lib: the package to generate, "" for a program
+	ids              nameCounter       // numbers of made-up names, by kind
 	noForth          bool              // --no-forth flag
 	optimize         bool              // --optimize flag: use native Go variables
+	workers          int               // --workers flag: @spawn pool size (0 = default)
 	inSpawnBlock     bool              // true when generating code inside spawn closure
+	spawnResultType  string            // result stack type inside "@spawn < { } into @s", else ""
+	inCodeblock      bool              // generating the body of a codeblock value
+	argsDeclared     bool              // an args block has been generated
 	spawnNatives     []string          // native variable names declared in current spawn block
 	spawnLocalStacks map[string]string // local stack names in current spawn block -> element type
 	considerStack    []string          // stack of status variable names for nested consider blocks
 	considerBindings map[string]bool   // variables bound in consider cases (have _str versions)
+	selectSources    []string          // "<select>_<case>" suffixes of timer/signal select sources
+	crashDump        string            // --crash-dump dir: write crash reports there
+	checked          bool              // --checked: pops and peeks panic on an empty stack (see checked.go)
+	tests            bool              // ual test: test blocks run, reporting to the runner (see test.go)
+	bench            bool              // ual bench: bench blocks run, reporting to the runner (see bench.go)
+	profile          bool              // ual profile: main profiles the run (see profile.go)
+	srcFile          string            // path of the program, for source maps
+	pos              map[ast.Stmt]ast.Pos
+	srcPos           ast.Pos           // .ual position of the lines being written
+	boolFuncs        map[string]bool   // functions returning bool, which are conditions as they are
+	funcDecls        map[string]*ast.FuncDecl // the program's functions, by name
+	tailFunc         *ast.FuncDecl     // the function being generated, if its tail calls loop (see tailcall.go)
+	tailCalls        map[ast.Stmt]bool // tailFunc's calls to itself in tail position
+	stmtPos          ast.Pos           // position of the statement being generated, for errors
+	marked           ast.Pos           // position of the last source map marker (see sourcemap.go)
+	sourceMap        *sourceMap        // lines of the generated code -> .ual positions
+	crashOps         []string          // traced operations, by CrashTrace id
+	crashOpIDs       map[ast.Pos]int
+	srcLines         map[string][]string // source files read for crashOps
+	structs          map[string][]ast.StructField // struct stacks -> fields, emitted as _struct_<name>
+	expectAt         int               // offset in out where main enables expectations
+	usesExpect       bool              // expect_stack or expect_output is called
+	usesExpectOutput bool              // expect_output is called (stdout is captured)
+	unsafeStacks     map[string]bool   // stacks generated as ual.UnsafeStack (see escape.go)
+	frameEscapes     map[string]bool   // in a function or codeblock, its variables kept on its frame (see escape.go)
+	inserts          []outputInsert    // frames and other text to splice into out once it is complete
+	globalVars       map[string]bool   // -O: globals functions use, declared at package level
+	tos              []string          // -O: dstack values not pushed yet, bottom first (see peephole.go)
+	registers        int               // -O: registers allocated for tos
 	errors           []string          // compilation errors
 }
 
@@ -35,6 +75,7 @@ func NewCodeGen() *CodeGen {
 		vars:             make(map[string]bool),
 		symbols:          NewSymbolTable(),
 		considerBindings: make(map[string]bool),
+		boolFuncs:        make(map[string]bool),
 		noForth:          false,
 		optimize:         false,
 		errors:           make([]string, 0),
@@ -49,6 +90,7 @@ func NewCodeGenWithOptions(noForth bool) *CodeGen {
 		vars:             make(map[string]bool),
 		symbols:          NewSymbolTable(),
 		considerBindings: make(map[string]bool),
+		boolFuncs:        make(map[string]bool),
 		noForth:          noForth,
 		optimize:         false,
 		errors:           make([]string, 0),
@@ -63,16 +105,32 @@ func NewCodeGenOptimized(noForth, optimize bool) *CodeGen {
 		vars:             make(map[string]bool),
 		symbols:          NewSymbolTable(),
 		considerBindings: make(map[string]bool),
+		boolFuncs:        make(map[string]bool),
 		noForth:          noForth,
 		optimize:         optimize,
 		errors:           make([]string, 0),
 	}
 }
 
+// addError records msg, prefixed with the file:line:col of the statement
+// being generated when it is known
 func (g *CodeGen) addError(msg string) {
+	if g.stmtPos.Line > 0 {
+		msg = g.sourcePos(g.stmtPos).String() + ": " + msg
+	}
 	g.errors = append(g.errors, msg)
 }
 
+// at makes stmt the statement errors are reported against, and returns a
+// func restoring the previous one
+func (g *CodeGen) at(stmt ast.Stmt) func() {
+	saved := g.stmtPos
+	if pos, ok := g.pos[stmt]; ok {
+		g.stmtPos = pos
+	}
+	return func() { g.stmtPos = saved }
+}
+
 func (g *CodeGen) hasErrors() bool {
 	return len(g.errors) > 0
 }
@@ -86,28 +144,88 @@ func (g *CodeGen) write(s string) {
 }
 
 func (g *CodeGen) writeln(s string) {
+	g.flushTOS()
+	g.emit(s)
+}
+
+// emit writes a line without flushing the cached dstack values
+func (g *CodeGen) emit(s string) {
+	pos := ast.Pos{}
+	if g.stmtPos.Line > 0 {
+		pos = g.sourcePos(g.stmtPos)
+	}
+	g.marked = markSource(&g.out, g.marked, pos)
+	if g.srcPos.Line > 0 {
+		// Map every line to its statement (a //line directive only
+		// covers the line after it)
+		fmt.Fprintf(&g.out, "//line %s:%d\n", g.srcPos.File, g.srcPos.Line)
+	}
+	if g.checked {
+		s = checkPops(s)
+	}
 	g.out.WriteString(strings.Repeat("\t", g.indent))
 	g.out.WriteString(s)
 	g.out.WriteString("\n")
 }
 
+// usesSQLite reports whether prog calls query or exec, and so imports the
+// sqlite package
+func usesSQLite(prog *ast.Program) bool {
+	found := false
+	ast.Inspect(prog, func(n any) bool {
+		if f, ok := n.(*ast.FuncCall); ok && (f.Name == "query" || f.Name == "exec") {
+			found = true
+		}
+		return !found
+	}, nil)
+	return found
+}
+
 func (g *CodeGen) Generate(prog *ast.Program) string {
 	// Separate function declarations and stack declarations from other statements
 	var funcs []*ast.FuncDecl
 	var stackDecls []*ast.StackDecl
 	var otherStmts []ast.Stmt
+	hasArgs := false
+	g.pos = prog.Pos
+	if g.crashDump == "" && g.library == "" {
+		// Crash reports watch every stack as a *ual.Stack, and a
+		// library's users may use its stacks from any goroutine
+		g.unsafeStacks = singleThreadedStacks(prog)
+	}
+	g.funcDecls = map[string]*ast.FuncDecl{}
 	for _, stmt := range prog.Stmts {
 		if f, ok := stmt.(*ast.FuncDecl); ok {
 			funcs = append(funcs, f)
+			g.funcDecls[f.Name] = f
+			if f.ReturnType == "bool" && !f.CanFail {
+				g.boolFuncs[f.Name] = true
+			}
 		} else if s, ok := stmt.(*ast.StackDecl); ok {
 			stackDecls = append(stackDecls, s)
 		} else {
+			if _, ok := stmt.(*ast.ArgsDecl); ok {
+				hasArgs = true
+			}
 			otherStmts = append(otherStmts, stmt)
 		}
 	}
+	declared := stackDecls // the program's own, for a library to export
+	if hasArgs && g.library != "" {
+		g.addError("args blocks are for programs: a library has no command line")
+	}
+	if hasArgs {
+		// args blocks fill @args (a clashing user declaration wins and is
+		// reported by generateArgsDecl)
+		stackDecls = append(stackDecls, &ast.StackDecl{Name: "args", ElementType: "string", Perspective: "Hash"})
+	}
 	
 	// Header
-	g.writeln("package main")
+	if g.library != "" {
+		g.writeln("package " + g.library)
+	} else {
+		g.writeln("package main")
+	}
 	g.writeln("")
 	g.writeln("import (")
 	g.indent++
@@ -115,13 +233,23 @@ func (g *CodeGen) Generate(prog *ast.Program) string {
 	g.writeln(`"encoding/binary"`)
 	g.writeln(`"fmt"`)
 	g.writeln(`"math"`)
+	if hasArgs {
+		g.writeln(`"os"`)
+	}
 	g.writeln(`"sync"`)
 	g.writeln(`"time"`)
-	if !g.optimize {
-		g.writeln(`"unsafe"`)
-	}
+	g.writeln(`"unsafe"`)
 	g.writeln("")
 	g.writeln(`ual "github.com/ha1tch/ual/pkg/runtime"`)
+	if g.bench {
+		g.writeln(`ualbench "github.com/ha1tch/ual/pkg/runtime/bench"`)
+	}
+	if g.profile {
+		g.writeln(`ualprofile "github.com/ha1tch/ual/pkg/runtime/profile"`)
+	}
+	if usesSQLite(prog) {
+		g.writeln(`ualsql "github.com/ha1tch/ual/pkg/sqlite"`)
+	}
 	g.indent--
 	g.writeln(")")
 	g.writeln("")
@@ -135,42 +263,43 @@ func (g *CodeGen) Generate(prog *ast.Program) string {
 		if g.optimize {
 			// Use native int64 slice as data stack
 			g.writeln("var _dstack = make([]int64, 0, 1024)")
-			g.writeln("var stack_rstack = ual.NewStack(ual.LIFO, ual.TypeInt64)")
-			g.writeln("var stack_bool = ual.NewStack(ual.LIFO, ual.TypeBool)")
-			g.writeln("var stack_error = ual.NewStack(ual.LIFO, ual.TypeBytes)")
 		} else {
 			g.writeln("var stack_dstack = ual.NewStack(ual.LIFO, ual.TypeInt64)")
-			g.writeln("var stack_rstack = ual.NewStack(ual.LIFO, ual.TypeInt64)")
-			g.writeln("var stack_bool = ual.NewStack(ual.LIFO, ual.TypeBool)")
-			g.writeln("var stack_error = ual.NewStack(ual.LIFO, ual.TypeBytes)")
-			g.writeln("")
-			g.writeln("// Spawn task queue")
-			g.writeln("var spawn_tasks []func()")
-			g.writeln("var spawn_mu sync.Mutex")
-			g.writeln("")
-			g.writeln("// Global status for consider blocks")
-			g.writeln("var _consider_status = \"ok\"")
-			g.writeln("var _consider_value interface{}")
-			g.writeln("")
-			g.writeln("// Type stacks for variables")
-			g.writeln("var stack_i64 = ual.NewStack(ual.Hash, ual.TypeInt64)")
-			g.writeln("var stack_u64 = ual.NewStack(ual.Hash, ual.TypeUint64)")
-			g.writeln("var stack_f64 = ual.NewStack(ual.Hash, ual.TypeFloat64)")
-			g.writeln("var stack_string = ual.NewStack(ual.Hash, ual.TypeString)")
-			g.writeln("var stack_bytes = ual.NewStack(ual.Hash, ual.TypeBytes)")
 		}
+		g.writeln("var stack_rstack = ual.NewStack(ual.LIFO, ual.TypeInt64)")
+		g.writeln("var stack_bool = ual.NewStack(ual.LIFO, ual.TypeBool)")
+		g.writeln("var stack_error = ual.NewStack(ual.LIFO, ual.TypeBytes)")
+		g.writeln("")
+		g.writeln("// Spawn task queue, played on a bounded work-stealing pool")
+		g.writeln("var spawn_tasks []func()")
+		g.writeln("var spawn_mu sync.Mutex")
+		if g.workers > 0 {
+			g.writeln(fmt.Sprintf("var spawn_sched = ual.NewScheduler(%d)", g.workers))
+		} else {
+			g.writeln("var spawn_sched = ual.NewScheduler(ual.DefaultSpawnWorkers)")
+		}
+		g.writeln("var spawn_group ual.SpawnGroup // played tasks, for @spawn wait")
+		g.writeln("")
+		g.writeln("// Global status for consider blocks")
+		g.writeln("var _consider_status = \"ok\"")
+		g.writeln("var _consider_value interface{}")
+		g.writeln("")
+		g.writeln("// Type stacks for variables")
+		g.writeln("var stack_i64 = ual.NewStack(ual.Hash, ual.TypeInt64)")
+		g.writeln("var stack_u64 = ual.NewStack(ual.Hash, ual.TypeUint64)")
+		g.writeln("var stack_f64 = ual.NewStack(ual.Hash, ual.TypeFloat64)")
+		g.writeln("var stack_string = ual.NewStack(ual.Hash, ual.TypeString)")
+		g.writeln("var stack_bytes = ual.NewStack(ual.Hash, ual.TypeBytes)")
 		g.writeln("")
 		g.stacks["dstack"] = "i64"
 		g.stacks["rstack"] = "i64"
 		g.stacks["bool"] = "bool"
 		g.stacks["error"] = "bytes"
-		if !g.optimize {
-			g.stacks["i64"] = "i64"
-			g.stacks["u64"] = "u64"
-			g.stacks["f64"] = "f64"
-			g.stacks["string"] = "string"
-			g.stacks["bytes"] = "bytes"
-		}
+		g.stacks["i64"] = "i64"
+		g.stacks["u64"] = "u64"
+		g.stacks["f64"] = "f64"
+		g.stacks["string"] = "string"
+		g.stacks["bytes"] = "bytes"
 	}
 	
 	// Generate user-declared stacks at file level (so functions can access them)
@@ -182,90 +311,178 @@ func (g *CodeGen) Generate(prog *ast.Program) string {
 		g.writeln("")
 	}
 	
+	// Globals are known to the functions, which come first
+	g.declareGlobals(otherStmts, globalUses(prog))
+	
 	// Generate functions at file level
 	for _, f := range funcs {
 		g.generateFuncDecl(f)
 	}
 	
-	// Main function
-	g.writeln("func main() {")
-	g.indent++
+	// Main function, or a library's init
+	if g.library != "" {
+		g.writeln("func init() {")
+		g.indent++
+	} else {
+		g.writeln("func main() {")
+		g.indent++
+		g.writeln("defer ual.RunAtExit() // after main's @defer blocks")
+		g.writeln("ual.HandleInterrupts()")
+	}
+	g.expectAt = g.out.Len()
+	if g.crashDump != "" {
+		g.generateCrashDumpSetup()
+	}
+	if g.profile && g.library == "" {
+		// After EnableExpect, so the profiles are written before it exits
+		g.writeln("ualprofile.Start()")
+	}
 	
 	for _, stmt := range otherStmts {
 		g.generateStmt(stmt)
 	}
 	
 	// Print declared variables (in order of declaration)
-	if len(g.varOrder) > 0 && !g.optimize {
+	if len(g.varOrder) > 0 && !g.tests && !g.bench && g.library == "" {
 		g.writeln("")
 		g.writeln("// Results")
 		for _, name := range g.varOrder {
-			g.writeln(fmt.Sprintf(`fmt.Printf("%s = %%v\n", %s)`, name, name))
+			value := name
+			if g.symbols.Lookup(name) != nil {
+				value = g.generatePrintArg(&ast.Ident{Name: name})
+			}
+			g.writeln(fmt.Sprintf(`fmt.Printf("%s = %%v\n", %s)`, name, value))
 		}
 	}
 	
 	// Suppress unused import warning
 	g.writeln("")
 	g.writeln("_ = ual.LIFO")
-	if !g.optimize {
-		g.writeln("var _ = unsafe.Pointer(nil)")
-	}
+	g.writeln("var _ = unsafe.Pointer(nil)")
 	if !g.noForth {
 		if g.optimize {
 			g.writeln("_ = _dstack")
-			g.writeln("_ = stack_rstack")
-			g.writeln("_ = stack_bool")
-			g.writeln("_ = stack_error")
 		} else {
 			g.writeln("_ = stack_dstack")
-			g.writeln("_ = stack_rstack")
-			g.writeln("_ = stack_bool")
-			g.writeln("_ = stack_error")
-			g.writeln("_ = stack_i64")
-			g.writeln("_ = stack_u64")
-			g.writeln("_ = stack_f64")
-			g.writeln("_ = stack_string")
-			g.writeln("_ = stack_bytes")
 		}
+		g.writeln("_ = stack_rstack")
+		g.writeln("_ = stack_bool")
+		g.writeln("_ = stack_error")
+		g.writeln("_ = stack_i64")
+		g.writeln("_ = stack_u64")
+		g.writeln("_ = stack_f64")
+		g.writeln("_ = stack_string")
+		g.writeln("_ = stack_bytes")
 	}
 	
 	g.indent--
 	g.writeln("}")
+	if g.library != "" {
+		g.writeln("")
+		g.generateExports(funcs, declared)
+	}
+	
+	// Timer and signal sources of select cases, created on first use
+	for _, id := range g.selectSources {
+		g.writeln("")
+		g.writeln(fmt.Sprintf("var _selectSrc%s *ual.Stack", id))
+		g.writeln(fmt.Sprintf("var _selectOnce%s sync.Once", id))
+	}
 	
-	return g.out.String()
+	// Operations named in crash reports, by CrashTrace id
+	if g.crashDump != "" {
+		g.writeln("")
+		g.writeln("var _crashOps = []string{")
+		g.indent++
+		for _, op := range g.crashOps {
+			g.writeln(fmt.Sprintf("%q,", op))
+		}
+		g.indent--
+		g.writeln("}")
+	}
+	
+	g.generateStructTypes()
+	
+	if g.usesExpect {
+		// The first AtExit hook, so the failure count is reported last
+		g.inserts = append(g.inserts, outputInsert{g.expectAt, fmt.Sprintf("\tual.EnableExpect(%v)\n", g.usesExpectOutput)})
+	}
+	out := g.spliceInserts()
+	if g.crashDump == "" && !g.checked {
+		// Not with //line directives, which number the lines after them:
+		// formatting splits some lines in two, and pruning takes some out
+		out = formatGo(pruneGo(out, g.library != ""))
+	}
+	out, g.sourceMap = extractSourceMap(out)
+	return out
+}
+
+// generateCrashDumpSetup turns on crash reports at the start of main and
+// registers the global stacks whose depths they list
+func (g *CodeGen) generateCrashDumpSetup() {
+	g.writeln("defer ual.CrashGuard()")
+	g.writeln(fmt.Sprintf("ual.EnableCrashDump(%q, _crashOps)", g.crashDump))
+	var names []string
+	for name := range g.stacks {
+		if name == "dstack" && g.optimize {
+			continue // a native slice
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g.writeln(fmt.Sprintf("ual.WatchStack(%q, %s)", name, g.stackVarName(name)))
+	}
+}
+
+// sourcePos returns pos with File filled in for the program's own file
+func (g *CodeGen) sourcePos(pos ast.Pos) ast.Pos {
+	if pos.File == "" {
+		pos.File = g.srcFile
+	}
+	return pos
+}
+
+// crashOp returns the CrashTrace id of the statement at pos, described in
+// the report by its position and source line
+func (g *CodeGen) crashOp(pos ast.Pos) int {
+	if id, ok := g.crashOpIDs[pos]; ok {
+		return id
+	}
+	if g.crashOpIDs == nil {
+		g.crashOpIDs = make(map[ast.Pos]int)
+		g.srcLines = make(map[string][]string)
+	}
+	lines, ok := g.srcLines[pos.File]
+	if !ok {
+		data, _ := os.ReadFile(pos.File)
+		lines = strings.Split(string(data), "\n")
+		g.srcLines[pos.File] = lines
+	}
+	op := fmt.Sprintf("%s:%d", filepath.Base(pos.File), pos.Line)
+	if pos.Line <= len(lines) {
+		op += "  " + strings.TrimSpace(lines[pos.Line-1])
+	}
+	id := len(g.crashOps)
+	g.crashOps = append(g.crashOps, op)
+	g.crashOpIDs[pos] = id
+	return id
 }
 
 func (g *CodeGen) generateHelpers() {
 	if g.optimize {
-		// Minimal helpers for optimized mode
 		g.writeln("// Native data stack operations")
 		g.writeln("func _push(v int64) { _dstack = append(_dstack, v) }")
-		g.writeln("func _pop() int64 { n := len(_dstack) - 1; v := _dstack[n]; _dstack = _dstack[:n]; return v }")
-		g.writeln("func _peek() int64 { return _dstack[len(_dstack)-1] }")
-		g.writeln("func _peekN(n int) int64 { return _dstack[len(_dstack)-1-n] }")
-		g.writeln("")
-		g.writeln("func absInt(n int64) int64 { if n < 0 { return -n }; return n }")
-		g.writeln("func minInt(a, b int64) int64 { if a < b { return a }; return b }")
-		g.writeln("func maxInt(a, b int64) int64 { if a > b { return a }; return b }")
-		g.writeln("")
-		// Still need byte conversion for user stacks
-		g.writeln("func intToBytes(n int64) []byte {")
-		g.indent++
-		g.writeln("b := make([]byte, 8)")
-		g.writeln("for i := 7; i >= 0; i-- { b[i] = byte(n & 0xff); n >>= 8 }")
-		g.writeln("return b")
-		g.indent--
-		g.writeln("}")
-		g.writeln("")
-		g.writeln("func bytesToInt(b []byte) int64 {")
-		g.indent++
-		g.writeln("var n int64")
-		g.writeln("for _, v := range b { n = (n << 8) | int64(v) }")
-		g.writeln("return n")
-		g.indent--
-		g.writeln("}")
+		if g.checked {
+			g.writeln("func _pop() int64 { n := len(_dstack) - 1; if n < 0 { ual.Underflow(1) }; v := _dstack[n]; _dstack = _dstack[:n]; return v }")
+			g.writeln("func _peek() int64 { if len(_dstack) == 0 { ual.Underflow(1) }; return _dstack[len(_dstack)-1] }")
+			g.writeln("func _peekN(n int) int64 { if len(_dstack) <= n { ual.Underflow(1) }; return _dstack[len(_dstack)-1-n] }")
+		} else {
+			g.writeln("func _pop() int64 { n := len(_dstack) - 1; v := _dstack[n]; _dstack = _dstack[:n]; return v }")
+			g.writeln("func _peek() int64 { return _dstack[len(_dstack)-1] }")
+			g.writeln("func _peekN(n int) int64 { return _dstack[len(_dstack)-1-n] }")
+		}
 		g.writeln("")
-		return
 	}
 	
 	g.writeln("// Helper functions")
@@ -310,6 +527,38 @@ func (g *CodeGen) generateHelpers() {
 	g.writeln("}")
 	g.writeln("")
 	
+	// Native unsigned variables convert through uintOf, so a negative or
+	// u64-range constant wraps instead of failing to compile
+	g.writeln("func uintOf(n int64) uint64 { return uint64(n) }")
+	g.writeln("")
+	
+	// Unsigned stacks hold the low bits of each value
+	g.writeln("func wrapUint(n int64, bits uint) []byte {")
+	g.indent++
+	g.writeln("u := uint64(n)")
+	g.writeln("if bits < 64 {")
+	g.indent++
+	g.writeln("u &= 1<<bits - 1")
+	g.indent--
+	g.writeln("}")
+	g.writeln("return uintToBytes(u)")
+	g.indent--
+	g.writeln("}")
+	g.writeln("")
+	
+	g.writeln("func bytesToUint(b []byte, bits uint) uint64 {")
+	g.indent++
+	g.writeln("u := uint64(bytesToInt(b))")
+	g.writeln("if bits < 64 {")
+	g.indent++
+	g.writeln("u &= 1<<bits - 1")
+	g.indent--
+	g.writeln("}")
+	g.writeln("return u")
+	g.indent--
+	g.writeln("}")
+	g.writeln("")
+	
 	g.writeln("func floatToBytes(f float64) []byte {")
 	g.indent++
 	g.writeln("bits := *(*uint64)(unsafe.Pointer(&f))")
@@ -326,6 +575,35 @@ func (g *CodeGen) generateHelpers() {
 	g.writeln("}")
 	g.writeln("")
 	
+	// Raw elements of i32, u32 and f32 stacks, as compute blocks see them.
+	// bytesToFloat32 also reads the 8-byte encoding Pop returns.
+	g.writeln("func uint32ToBytes(n uint32) []byte {")
+	g.indent++
+	g.writeln("return []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}")
+	g.indent--
+	g.writeln("}")
+	g.writeln("")
+	
+	g.writeln("func float32ToBytes(f float32) []byte {")
+	g.indent++
+	g.writeln("return uint32ToBytes(*(*uint32)(unsafe.Pointer(&f)))")
+	g.indent--
+	g.writeln("}")
+	g.writeln("")
+	
+	g.writeln("func bytesToFloat32(b []byte) float32 {")
+	g.indent++
+	g.writeln("if len(b) == 8 {")
+	g.indent++
+	g.writeln("return float32(bytesToFloat(b))")
+	g.indent--
+	g.writeln("}")
+	g.writeln("bits := uint32(bytesToInt(b))")
+	g.writeln("return *(*float32)(unsafe.Pointer(&bits))")
+	g.indent--
+	g.writeln("}")
+	g.writeln("")
+	
 	g.writeln("func boolToBytes(v bool) []byte {")
 	g.indent++
 	g.writeln("if v { return []byte{1} }")
@@ -366,10 +644,20 @@ func (g *CodeGen) generateHelpers() {
 	g.writeln("")
 	
 	// Select helper
-	g.writeln("// Select helper: creates cancellable context")
+	g.writeln("// Select helper: creates cancellable context, cancelled on shutdown too")
 	g.writeln("func _selectContext() (context.Context, context.CancelFunc) {")
 	g.indent++
-	g.writeln("return context.WithCancel(context.Background())")
+	g.writeln("return context.WithCancel(ual.Context())")
+	g.indent--
+	g.writeln("}")
+	g.writeln("")
+	
+	// Blocking take that gives up when the program shuts down
+	g.writeln("func _take(s *ual.Stack, timeout int64) []byte {")
+	g.indent++
+	g.writeln("v, err := s.TakeWithContext(ual.Context(), timeout)")
+	g.writeln("ual.StopOnShutdown(err)")
+	g.writeln("return v")
 	g.indent--
 	g.writeln("}")
 	g.writeln("")
@@ -378,10 +666,22 @@ func (g *CodeGen) generateHelpers() {
 	g.writeln("var _ = time.Second // suppress unused import")
 	g.writeln("var _ = math.Pi // suppress unused import")
 	g.writeln("var _ = binary.LittleEndian // suppress unused import")
+	g.writeln("var _ = fmt.Sprint // suppress unused import")
 	g.writeln("")
 }
 
 func (g *CodeGen) generateStmt(stmt ast.Stmt) {
+	defer g.at(stmt)()
+	if g.crashDump != "" || g.checked {
+		if pos, ok := g.pos[stmt]; ok {
+			saved := g.srcPos
+			g.srcPos = g.sourcePos(pos)
+			defer func() { g.srcPos = saved }()
+			if g.crashDump != "" {
+				g.writeln(fmt.Sprintf("ual.CrashTrace(%d)", g.crashOp(g.srcPos)))
+			}
+		}
+	}
 	switch s := stmt.(type) {
 	case *ast.StackDecl:
 		g.generateStackDecl(s)
@@ -397,6 +697,11 @@ func (g *CodeGen) generateStmt(stmt ast.Stmt) {
 		g.generateViewOp(s)
 	case *ast.VarDecl:
 		g.generateVarDecl(s)
+	case *ast.ArgsDecl:
+		g.generateArgsDecl(s)
+	case *ast.ImportStmt:
+		// Top-level imports are resolved before code generation
+		g.addError(fmt.Sprintf("import %q must be at the top level", s.Path))
 	case *ast.LetAssign:
 		g.generateLetAssign(s)
 	case *ast.IfStmt:
@@ -409,6 +714,8 @@ func (g *CodeGen) generateStmt(stmt ast.Stmt) {
 		g.writeln("continue")
 	case *ast.ForStmt:
 		g.generateForStmt(s)
+	case *ast.RangeStmt:
+		g.generateRangeStmt(s)
 	case *ast.FuncDecl:
 		g.generateFuncDecl(s)
 	case *ast.FuncCall:
@@ -417,6 +724,8 @@ func (g *CodeGen) generateStmt(stmt ast.Stmt) {
 		g.generateReturnStmt(s)
 	case *ast.DeferStmt:
 		g.generateDeferStmt(s)
+	case *ast.AtExitStmt:
+		g.generateAtExitStmt(s)
 	case *ast.PanicStmt:
 		g.generatePanicStmt(s)
 	case *ast.TryStmt:
@@ -435,6 +744,14 @@ func (g *CodeGen) generateStmt(stmt ast.Stmt) {
 		g.generateComputeStmt(s)
 	case *ast.StatusStmt:
 		g.generateStatusStmt(s)
+	case *ast.TestBlock:
+		if g.tests {
+			g.generateHarnessBlock("ual.RunTest", s.Name, s, s.Body)
+		}
+	case *ast.BenchBlock:
+		if g.bench {
+			g.generateHarnessBlock("ualbench.Run", s.Name, s, s.Body)
+		}
 	case *ast.Block:
 		for _, stmt := range s.Stmts {
 			g.generateStmt(stmt)
@@ -442,9 +759,17 @@ func (g *CodeGen) generateStmt(stmt ast.Stmt) {
 	case *ast.ExprStmt:
 		// Expression statement - evaluate and discard (or used as implicit return)
 		g.writeln(fmt.Sprintf("_ = %s", g.generateExpr(s.Expr)))
+	default:
+		g.addError(fmt.Sprintf("%s is not supported by the Go backend", stmtName(stmt)))
 	}
 }
 
+// stmtName names the kind of a statement in errors: "*ast.ArrayDecl" is
+// "ArrayDecl"
+func stmtName(stmt ast.Stmt) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*ast.")
+}
+
 func (g *CodeGen) generateStackBlock(sb *ast.StackBlock) {
 	for _, op := range sb.Ops {
 		g.generateStmt(op)
@@ -452,6 +777,7 @@ func (g *CodeGen) generateStackBlock(sb *ast.StackBlock) {
 }
 
 func (g *CodeGen) generateStackDecl(s *ast.StackDecl) {
+	g.declareStruct(s)
 	elemType := g.mapElementType(s.ElementType)
 	persp := g.mapPerspective(s.Perspective)
 	
@@ -462,7 +788,7 @@ func (g *CodeGen) generateStackDecl(s *ast.StackDecl) {
 			g.spawnLocalStacks = make(map[string]string)
 		}
 		// Track local stack for reference resolution
-		g.spawnLocalStacks[s.Name] = s.ElementType
+		g.spawnLocalStacks[s.Name] = valueType(s.ElementType)
 		
 		// Generate local variable declaration in spawn closure
 		if s.Capacity > 0 {
@@ -481,38 +807,155 @@ func (g *CodeGen) generateStackDecl(s *ast.StackDecl) {
 	if g.stacks[s.Name] != "" {
 		op = "="
 	}
-	g.stacks[s.Name] = s.ElementType
+	g.stacks[s.Name] = valueType(s.ElementType)
 	g.perspectives[s.Name] = s.Perspective // Track perspective for compute validation
 	
 	if s.Capacity > 0 {
-		g.writeln(fmt.Sprintf("stack_%s %s ual.NewCappedStack(%s, %s, %d)", 
-			s.Name, op, persp, elemType, s.Capacity))
+		g.writeln(fmt.Sprintf("stack_%s %s ual.NewCapped%s(%s, %s, %d)", 
+			s.Name, op, g.stackType(s.Name), persp, elemType, s.Capacity))
 	} else {
-		g.writeln(fmt.Sprintf("stack_%s %s ual.NewStack(%s, %s)", 
-			s.Name, op, persp, elemType))
+		g.writeln(fmt.Sprintf("stack_%s %s ual.New%s(%s, %s)", 
+			s.Name, op, g.stackType(s.Name), persp, elemType))
 	}
 }
 
 // generateGlobalStackDecl emits a stack declaration at file level using var syntax
 func (g *CodeGen) generateGlobalStackDecl(s *ast.StackDecl) {
+	defer g.at(s)()
 	// Skip if already declared (handles redeclaration in source)
 	if g.stacks[s.Name] != "" {
 		return
 	}
 	
+	g.declareStruct(s)
 	elemType := g.mapElementType(s.ElementType)
 	persp := g.mapPerspective(s.Perspective)
 	
-	g.stacks[s.Name] = s.ElementType
+	g.stacks[s.Name] = valueType(s.ElementType)
 	g.perspectives[s.Name] = s.Perspective
 	
+	if s.Embed != nil {
+		// Filled from the file read at compile time, before main runs
+		g.writeln(fmt.Sprintf("var stack_%s = func() *ual.Stack {", s.Name))
+		g.indent++
+		g.writeln(fmt.Sprintf("s := ual.NewStack(%s, %s)", persp, elemType))
+		for _, e := range s.Embed.Elements() {
+			g.writeln(fmt.Sprintf("s.Push([]byte(%s))", strconv.Quote(e)))
+		}
+		g.writeln("s.Freeze()")
+		g.writeln("return s")
+		g.indent--
+		g.writeln("}()")
+		return
+	}
 	if s.Capacity > 0 {
-		g.writeln(fmt.Sprintf("var stack_%s = ual.NewCappedStack(%s, %s, %d)", 
-			s.Name, persp, elemType, s.Capacity))
+		g.writeln(fmt.Sprintf("var stack_%s = ual.NewCapped%s(%s, %s, %d)", 
+			s.Name, g.stackType(s.Name), persp, elemType, s.Capacity))
 	} else {
-		g.writeln(fmt.Sprintf("var stack_%s = ual.NewStack(%s, %s)", 
-			s.Name, persp, elemType))
+		g.writeln(fmt.Sprintf("var stack_%s = ual.New%s(%s, %s)", 
+			s.Name, g.stackType(s.Name), persp, elemType))
+	}
+}
+
+// stackType returns the runtime type of the user stack name: UnsafeStack
+// if escape analysis found it single-threaded, else Stack
+func (g *CodeGen) stackType(name string) string {
+	if g.unsafeStacks[name] {
+		return "UnsafeStack"
+	}
+	return "Stack"
+}
+
+// declareStruct records the layout of a struct stack. Layouts are emitted
+// as package-level _struct_<name> variables at the end of the program.
+func (g *CodeGen) declareStruct(s *ast.StackDecl) {
+	if s.ElementType != "struct" {
+		return
+	}
+	if g.structs == nil {
+		g.structs = make(map[string][]ast.StructField)
+	}
+	if prev, ok := g.structs[s.Name]; ok && !sameFields(prev, s.Fields) {
+		g.addError(fmt.Sprintf("@%s is declared twice with different struct fields", s.Name))
+		return
+	}
+	g.structs[s.Name] = s.Fields
+}
+
+// generateStructTypes emits the layout of each struct stack
+func (g *CodeGen) generateStructTypes() {
+	names := make([]string, 0, len(g.structs))
+	for name := range g.structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var fields []string
+		for _, f := range g.structs[name] {
+			typ := g.mapElementType(f.Type)
+			if f.Type == "u64" {
+				typ = "ual.TypeUint64"
+			}
+			fields = append(fields, fmt.Sprintf("{Name: %q, Type: %s}", f.Name, typ))
+		}
+		g.writeln("")
+		g.writeln(fmt.Sprintf("var _struct_%s = ual.NewStructType([]ual.StructField{%s}...)", name, strings.Join(fields, ", ")))
+	}
+}
+
+// packStruct generates the packed bytes of a record literal pushed to the
+// struct stack name. Fields left out are zero.
+func (g *CodeGen) packStruct(name string, e ast.Expr) (string, bool) {
+	rec, ok := e.(*ast.RecordLit)
+	if !ok {
+		g.addError(fmt.Sprintf("@%s holds structs; push a record such as {x: 1.0, y: 2.0}", name))
+		return "", false
+	}
+	fields := g.structs[name]
+	vals := make([]string, len(fields))
+	for i, f := range fields {
+		vals[i] = g.wrapValue(structZero(f.Type), f.Type)
+	}
+	for i, fieldName := range rec.Names {
+		idx := structFieldIndex(fields, fieldName)
+		if idx < 0 {
+			g.addError(fmt.Sprintf("@%s has no field %s", name, fieldName))
+			return "", false
+		}
+		vals[idx] = g.wrapValue(g.generateExprValue(rec.Values[i]), fields[idx].Type)
+	}
+	return fmt.Sprintf("_struct_%s.Pack(%s)", name, strings.Join(vals, ", ")), true
+}
+
+func structZero(typ string) string {
+	switch typ {
+	case "f64":
+		return "0.0"
+	case "bool":
+		return "false"
+	}
+	return "0"
+}
+
+func sameFields(a, b []ast.StructField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func structFieldIndex(fields []ast.StructField, name string) int {
+	for i, f := range fields {
+		if f.Name == name {
+			return i
+		}
 	}
+	return -1
 }
 
 func (g *CodeGen) generateViewDecl(v *ast.ViewDecl) {
@@ -527,71 +970,93 @@ func (g *CodeGen) generateViewDecl(v *ast.ViewDecl) {
 	g.writeln(fmt.Sprintf("view_%s %s ual.NewView(%s)", v.Name, op, persp))
 }
 
+// generateAssignment assigns to a declared variable where it lives, a
+// global or a local of an enclosing scope, or else makes name a Go
+// variable of its own. Top-level ones are printed at the end.
 func (g *CodeGen) generateAssignment(a *ast.Assignment) {
-	// Track order for auto-print (only if new)
-	if !g.vars[a.Name] {
+	exprCode := g.generateExpr(a.Expr)
+	topLevel := len(g.symbols.bases) == 0
+	if sym := g.symbols.Lookup(a.Name); sym != nil {
+		if sym.Native {
+			g.writeln(fmt.Sprintf("var_%s = %s", a.Name, g.nativeValue(exprCode, sym.Type)))
+		} else {
+			wrapped := g.wrapValueForType(exprCode, sym.Type)
+			if bits := uintBits(sym.Type); bits > 0 {
+				wrapped = fmt.Sprintf("wrapUint(int64(%s), %d)", exprCode, bits)
+			}
+			g.writeln(fmt.Sprintf("%s.PushAt(%d, %s) // %s = ...", sym.Slot(), sym.Index, wrapped, a.Name))
+		}
+		if topLevel && sym.Scope == 0 && !g.vars[a.Name] {
+			g.varOrder = append(g.varOrder, a.Name)
+			g.vars[a.Name] = true
+		}
+		return
+	}
+	if g.symbols.Assign(a.Name) {
+		g.writeln(fmt.Sprintf("%s = %s", a.Name, exprCode))
+		return
+	}
+	// Track order for auto-print
+	if topLevel && g.symbols.depth == 0 && !g.vars[a.Name] {
 		g.varOrder = append(g.varOrder, a.Name)
+		g.vars[a.Name] = true
 	}
-	g.vars[a.Name] = true
-	exprCode := g.generateExpr(a.Expr)
 	g.writeln(fmt.Sprintf("%s := %s", a.Name, exprCode))
+	g.writeln(fmt.Sprintf("_ = %s", a.Name))
 }
 
-func (g *CodeGen) generateVarDecl(v *ast.VarDecl) {
-	// Infer type if not specified
-	typ := v.Type
+// declareGlobals declares the variables of the top-level var statements
+// among stmts before any function is generated, so that functions can use
+// them (see scope.go). With -O, the ones functions use, as named in uses,
+// become package-level Go variables.
+func (g *CodeGen) declareGlobals(stmts []ast.Stmt, uses map[string][]string) {
+	used := map[string]bool{}
+	for _, names := range uses {
+		for _, name := range names {
+			used[name] = true
+		}
+	}
+	g.symbols.predeclaring = true
+	defer func() { g.symbols.predeclaring = false }()
+	for _, stmt := range stmts {
+		v, ok := stmt.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		typ := g.varDeclType(v)
+		for _, name := range v.Names {
+			g.symbols.DeclareGlobal(name, typ, g.optimize)
+			if g.optimize && used[name] && !g.globalVars[name] {
+				if g.globalVars == nil {
+					g.globalVars = map[string]bool{}
+					g.writeln("// Globals used by functions")
+				}
+				g.globalVars[name] = true
+				g.writeln(fmt.Sprintf("var var_%s %s", name, g.goType(typ)))
+			}
+		}
+	}
+	if g.globalVars != nil {
+		g.writeln("")
+	}
+}
+
+// varDeclType returns the type of the variables a var statement declares:
+// the one given, or the one of its first value
+func (g *CodeGen) varDeclType(v *ast.VarDecl) string {
+	typ := valueType(v.Type)
 	if typ == "" && len(v.Values) > 0 {
 		typ = g.inferType(v.Values[0])
 	}
 	if typ == "" {
 		typ = "i64" // default
 	}
-	
-	// Use native Go variables when:
-	// - optimize flag is set, OR
-	// - inside spawn block (to avoid race conditions with shared stack slots)
-	if g.optimize || g.inSpawnBlock {
-		// Use native Go variables
-		for i, name := range v.Names {
-			// Register in symbol table as native
-			_, err := g.symbols.DeclareNative(name, typ)
-			if err != nil {
-				g.writeln(fmt.Sprintf("// Error: %s", err))
-				continue
-			}
-			
-			// Generate native Go variable
-			var valueCode string
-			if i < len(v.Values) {
-				valueCode = g.generateExpr(v.Values[i])
-			} else {
-				valueCode = g.zeroValue(typ)
-			}
-			
-			goType := g.goType(typ)
-			g.writeln(fmt.Sprintf("var_%s := %s(%s)", name, goType, valueCode))
-			
-			// In spawn blocks, suppress unused variable warning immediately
-			// (variables may be used only for synchronization, not read)
-			if g.inSpawnBlock {
-				g.writeln(fmt.Sprintf("_ = var_%s", name))
-			}
-		}
-		return
-	}
-	
-	// Legacy: use type stack
-	typeStack := TypeStack(typ)
-	
+	return typ
+}
+
+func (g *CodeGen) generateVarDecl(v *ast.VarDecl) {
+	typ := g.varDeclType(v)
 	for i, name := range v.Names {
-		// Register in symbol table
-		idx, err := g.symbols.Declare(name, typ)
-		if err != nil {
-			g.writeln(fmt.Sprintf("// Error: %s", err))
-			continue
-		}
-		
-		// Generate code to store in type stack
 		var valueCode string
 		if i < len(v.Values) {
 			valueCode = g.generateExpr(v.Values[i])
@@ -599,46 +1064,167 @@ func (g *CodeGen) generateVarDecl(v *ast.VarDecl) {
 			// Zero value
 			valueCode = g.zeroValue(typ)
 		}
-		
-		// Store as indexed slot on type stack
-		wrapped := g.wrapValueForType(valueCode, typ)
-		g.writeln(fmt.Sprintf("stack_%s.PushAt(%d, %s) // var %s", typeStack, idx, wrapped, name))
+		g.declareVar(name, typ, valueCode)
 	}
 }
 
-func (g *CodeGen) generateLetAssign(l *ast.LetAssign) {
-	if g.optimize {
-		// Use native variables and native dstack
-		sym := g.symbols.Lookup(l.Name)
-		if sym == nil {
-			// Implicit declaration with type inference
-			_, _ = g.symbols.DeclareNative(l.Name, "i64")
-			g.writeln(fmt.Sprintf("var_%s := _pop()", l.Name))
-		} else if sym.Native {
-			g.writeln(fmt.Sprintf("var_%s = _pop()", l.Name))
-		} else {
-			// Fallback for non-native symbols
-			typeStack := TypeStack(sym.Type)
-			g.writeln(fmt.Sprintf("{ v := _pop(); stack_%s.PushAt(%d, intToBytes(v)) } // %s = ...", 
-				typeStack, sym.Index, l.Name))
+// declareVar declares a variable holding valueCode. Native Go variables are
+// used when the optimize flag is set, inside a spawn block (to avoid race
+// conditions with shared stack slots) and for the locals of functions and
+// codeblocks that do not escape; otherwise the value lives in a slot of the
+// type stack.
+func (g *CodeGen) declareVar(name, typ, valueCode string) {
+	if g.optimize || g.inSpawnBlock || g.nativeLocal(name, typ) {
+		// Register in symbol table as native
+		_, err := g.symbols.DeclareNative(name, typ)
+		if err != nil {
+			g.writeln(fmt.Sprintf("// Error: %s", err))
+			return
+		}
+		
+		op := ":="
+		if g.globalVars[name] && g.symbols.Lookup(name).Global {
+			op = "="
+		}
+		g.writeln(fmt.Sprintf("var_%s %s %s", name, op, g.nativeValue(valueCode, typ)))
+		
+		// Suppress the unused variable warning: ual variables may be
+		// only assigned, or used only for synchronization in spawn blocks
+		if op == ":=" {
+			g.writeln(fmt.Sprintf("_ = var_%s", name))
 		}
 		return
 	}
 	
-	// Legacy: let:name assigns from stack top to named variable
-	sym := g.symbols.Lookup(l.Name)
-	if sym == nil {
-		// Implicit declaration with type inference from stack
-		typ := "i64"
-		typeStack := TypeStack(typ)
-		idx, _ := g.symbols.Declare(l.Name, typ)
-		g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); stack_%s.PushAt(%d, v) } // let %s", 
-			l.Stack, typeStack, idx, l.Name))
-	} else {
-		// Update existing variable
-		typeStack := TypeStack(sym.Type)
-		g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); stack_%s.PushAt(%d, v) } // %s = ...", 
-			l.Stack, typeStack, sym.Index, l.Name))
+	// Legacy: use type stack
+	idx, err := g.symbols.Declare(name, typ)
+	if err != nil {
+		g.writeln(fmt.Sprintf("// Error: %s", err))
+		return
+	}
+	
+	// Store as indexed slot on type stack
+	wrapped := g.wrapValueForType(valueCode, typ)
+	g.writeln(fmt.Sprintf("%s.PushAt(%d, %s) // var %s", g.symbols.Lookup(name).Slot(), idx, wrapped, name))
+}
+
+// nativeLocal reports whether name, a variable of typ declared in the
+// function or codeblock being generated, can be a native Go variable: one
+// that concurrent code in the body does not use. Expressions compute in
+// int64 and float64, so narrower numbers stay on the frame too.
+func (g *CodeGen) nativeLocal(name, typ string) bool {
+	if g.frameEscapes == nil || g.frameEscapes[name] {
+		return false
+	}
+	switch typ {
+	case "i64", "f64", "string", "bool":
+		return true
+	}
+	return false
+}
+
+// generateArgsDecl parses os.Args against an args block, stores every value
+// in @args and declares one variable per entry.
+func (g *CodeGen) generateArgsDecl(a *ast.ArgsDecl) {
+	if g.argsDeclared {
+		g.addError("only one args block is allowed")
+		return
+	}
+	if g.stacks["args"] != "string" || g.perspectives["args"] != "Hash" {
+		g.addError("args block must be at top level and @args must not be redeclared")
+		return
+	}
+	g.argsDeclared = true
+	
+	g.writeln(fmt.Sprintf("_args := ual.ParseArgsOrExit(%q, []ual.ArgSpec{", a.Program))
+	g.indent++
+	for _, sp := range a.Specs {
+		def, hasDef := `""`, false
+		if sp.Default != nil {
+			def, hasDef = g.argDefault(sp.Default), true
+		}
+		g.writeln(fmt.Sprintf("{Kind: %q, Name: %q, Short: %q, Type: %q, Default: %s, HasDefault: %v, Help: %q},",
+			sp.Kind, sp.Name, sp.Short, sp.Type, def, hasDef, sp.Help))
+	}
+	g.indent--
+	g.writeln("}, os.Args[1:])")
+	g.writeln("_args.Fill(stack_args)")
+	
+	for _, sp := range a.Specs {
+		getter := map[string]string{"bool": "Bool", "i64": "Int", "f64": "Float"}[sp.Type]
+		if getter == "" {
+			getter = "String"
+		}
+		name := argVarName(sp.Name)
+		g.declareVar(name, sp.Type, fmt.Sprintf("_args.%s(%q)", getter, sp.Name))
+	}
+}
+
+// argDefault returns Go code for the string form of an args default.
+// Literals are formatted at compile time so usage shows them as written.
+func (g *CodeGen) argDefault(e ast.Expr) string {
+	if lit, ok := argDefaultLiteral(e); ok {
+		return fmt.Sprintf("%q", lit)
+	}
+	return fmt.Sprintf("fmt.Sprint(%s)", g.generateExprValue(e))
+}
+
+// argDefaultLiteral formats a literal args default (optionally negated).
+func argDefaultLiteral(e ast.Expr) (string, bool) {
+	switch v := e.(type) {
+	case *ast.StringLit:
+		return v.Value, true
+	case *ast.IntLit:
+		return strconv.FormatInt(v.Value, 10), true
+	case *ast.FloatLit:
+		return strconv.FormatFloat(v.Value, 'g', -1, 64), true
+	case *ast.BoolLit:
+		return strconv.FormatBool(v.Value), true
+	case *ast.UnaryExpr:
+		if lit, ok := argDefaultLiteral(v.Operand); ok && v.Op == "-" {
+			return "-" + lit, true
+		}
+	}
+	return "", false
+}
+
+// argVarName is the variable an args entry is bound to
+func argVarName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+func (g *CodeGen) generateLetAssign(l *ast.LetAssign) {
+	if g.optimize {
+		// Use native variables and native dstack
+		sym := g.symbols.Lookup(l.Name)
+		if sym == nil {
+			// Implicit declaration with type inference
+			_, _ = g.symbols.DeclareNative(l.Name, "i64")
+			g.writeln(fmt.Sprintf("var_%s := _pop()", l.Name))
+		} else if sym.Native {
+			g.writeln(fmt.Sprintf("var_%s = %s", l.Name, g.nativeValue("_pop()", sym.Type)))
+		} else {
+			// Fallback for non-native symbols
+			g.writeln(fmt.Sprintf("{ v := _pop(); %s.PushAt(%d, %s) } // %s = ...", 
+				sym.Slot(), sym.Index, g.wrapValueForType("v", sym.Type), l.Name))
+		}
+		return
+	}
+	
+	// Legacy: let:name assigns from stack top to named variable
+	sym := g.symbols.Lookup(l.Name)
+	if sym == nil {
+		// Implicit declaration with type inference from stack
+		typ := "i64"
+		idx, _ := g.symbols.Declare(l.Name, typ)
+		g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); %s.PushAt(%d, v) } // let %s", 
+			l.Stack, g.symbols.Slot(typ), idx, l.Name))
+	} else if sym.Native {
+		g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); var_%s = %s }", l.Stack, l.Name, g.nativeVarFromBytes("v", sym.Type)))
+	} else {
+		// Update existing variable
+		g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); %s.PushAt(%d, v) } // %s = ...", 
+			l.Stack, sym.Slot(), sym.Index, l.Name))
 	}
 }
 
@@ -699,9 +1285,40 @@ func (g *CodeGen) generateWhileStmt(s *ast.WhileStmt) {
 	g.writeln("}")
 }
 
+// generateRangeStmt counts the loop variable from Start up to End, both
+// evaluated once. The count is kept apart from the variable, so the body
+// assigning to it does not change the iterations.
+func (g *CodeGen) generateRangeStmt(s *ast.RangeStmt) {
+	g.writeln(fmt.Sprintf("{ // for %s in", s.Var))
+	g.indent++
+	g.writeln(fmt.Sprintf("_rangeEnd := int64(%s)", g.generateExpr(s.End)))
+	g.writeln(fmt.Sprintf("for _rangeI := int64(%s); _rangeI < _rangeEnd; _rangeI++ {", g.generateExpr(s.Start)))
+	g.indent++
+	
+	g.symbols.Enter()
+	g.declareVar(s.Var, "i64", "_rangeI")
+	if sym := g.symbols.Lookup(s.Var); sym != nil && sym.Native && !g.inSpawnBlock {
+		g.writeln(fmt.Sprintf("_ = var_%s", s.Var))
+	}
+	for _, stmt := range s.Body {
+		g.generateStmt(stmt)
+	}
+	g.symbols.Exit()
+	
+	g.indent--
+	g.writeln("}")
+	g.indent--
+	g.writeln("}")
+}
+
 func (g *CodeGen) generateForStmt(s *ast.ForStmt) {
 	stackName := s.Stack
 	
+	if s.Perspective == "hash" || (s.Perspective == "" && g.perspectives[stackName] == "Hash") {
+		g.generateHashForStmt(s)
+		return
+	}
+	
 	// Generate snapshot of stack (copy elements at iteration start)
 	g.writeln(fmt.Sprintf("{ // for @%s", stackName))
 	g.indent++
@@ -714,6 +1331,10 @@ func (g *CodeGen) generateForStmt(s *ast.ForStmt) {
 	if s.Perspective == "fifo" || s.Perspective == "indexed" {
 		ascending = true
 	}
+	if s.Perspective == "" {
+		// The stack's own perspective, as in iual
+		ascending = g.perspectives[stackName] == "FIFO" || g.perspectives[stackName] == "Indexed"
+	}
 	
 	// Generate loop
 	if ascending {
@@ -728,24 +1349,30 @@ func (g *CodeGen) generateForStmt(s *ast.ForStmt) {
 	
 	g.symbols.Enter()
 	
+	// Values of f32, f64 and string stacks keep their type; others read as i64
+	valType := "i64"
+	if t := g.stacks[stackName]; TypeStack(t) == "f64" || t == "string" {
+		valType = t
+	}
+	
 	// Handle params
 	switch len(s.Params) {
 	case 0:
 		// No params: push value to @dstack
-		g.writeln("stack_dstack.Push(_forVal)")
+		g.writeln(g.dstackPush("_forVal"))
 	case 1:
 		// |v|: declare variable with value
 		varName := s.Params[0]
-		idx, _ := g.symbols.Declare(varName, "i64")
-		g.writeln(fmt.Sprintf("stack_i64.PushAt(%d, _forVal) // %s", idx, varName))
+		idx, _ := g.symbols.Declare(varName, valType)
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, _forVal) // %s", g.symbols.Slot(valType), idx, varName))
 	case 2:
 		// |i,v| or |k,v|: declare both
 		idxName := s.Params[0]
 		valName := s.Params[1]
 		idxIdx, _ := g.symbols.Declare(idxName, "i64")
-		valIdx, _ := g.symbols.Declare(valName, "i64")
-		g.writeln(fmt.Sprintf("stack_i64.PushAt(%d, intToBytes(int64(_forIdx))) // %s", idxIdx, idxName))
-		g.writeln(fmt.Sprintf("stack_i64.PushAt(%d, _forVal) // %s", valIdx, valName))
+		valIdx, _ := g.symbols.Declare(valName, valType)
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, intToBytes(int64(_forIdx))) // %s", g.symbols.Slot("i64"), idxIdx, idxName))
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, _forVal) // %s", g.symbols.Slot(valType), valIdx, valName))
 	}
 	
 	// Generate body
@@ -761,25 +1388,56 @@ func (g *CodeGen) generateForStmt(s *ast.ForStmt) {
 	g.writeln("}")
 }
 
-func (g *CodeGen) generateFuncDecl(f *ast.FuncDecl) {
-	// Build parameter list
-	var params []string
-	for _, p := range f.Params {
-		goType := g.goTypeFor(p.Type)
-		params = append(params, fmt.Sprintf("%s %s", p.Name, goType))
+// generateHashForStmt iterates a Hash stack in key insertion order. With
+// |k,v| the key is bound as a string; a key popped by the body before its
+// turn is skipped.
+func (g *CodeGen) generateHashForStmt(s *ast.ForStmt) {
+	stackVar := g.stackVarName(s.Stack)
+	elemType := g.stacks[s.Stack]
+	if elemType == "" {
+		elemType = "i64"
 	}
 	
-	// Build return type
-	var returnSig string
-	if f.CanFail && f.ReturnType != "" {
-		returnSig = fmt.Sprintf("(%s, error)", g.goTypeFor(f.ReturnType))
-	} else if f.CanFail {
-		returnSig = "error"
-	} else if f.ReturnType != "" {
-		returnSig = g.goTypeFor(f.ReturnType)
+	g.writeln(fmt.Sprintf("for _, _forKey := range %s.Keys() { // for @%s", stackVar, s.Stack))
+	g.indent++
+	g.writeln(fmt.Sprintf("_forVal, _forErr := %s.Peek([]byte(_forKey))", stackVar))
+	g.writeln("if _forErr != nil {")
+	g.writeln("\tcontinue")
+	g.writeln("}")
+	
+	g.symbols.Enter()
+	
+	switch len(s.Params) {
+	case 0:
+		g.writeln(g.dstackPush("_forVal"))
+	case 1:
+		idx, _ := g.symbols.Declare(s.Params[0], elemType)
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, _forVal) // %s", g.symbols.Slot(elemType), idx, s.Params[0]))
+	case 2:
+		keyIdx, _ := g.symbols.Declare(s.Params[0], "string")
+		valIdx, _ := g.symbols.Declare(s.Params[1], elemType)
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, []byte(_forKey)) // %s", g.symbols.Slot("string"), keyIdx, s.Params[0]))
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, _forVal) // %s", g.symbols.Slot(elemType), valIdx, s.Params[1]))
+	}
+	
+	for _, stmt := range s.Body {
+		g.generateStmt(stmt)
+	}
+	
+	g.symbols.Exit()
+	
+	g.indent--
+	g.writeln("}")
+}
+
+func (g *CodeGen) generateFuncDecl(f *ast.FuncDecl) {
+	defer g.at(f)()
+	if pos, ok := g.pos[f]; ok && (g.crashDump != "" || g.checked) {
+		g.srcPos = g.sourcePos(pos)
+		defer func() { g.srcPos = ast.Pos{} }()
 	}
 	
-	// Write function signature
+	params, returnSig := g.funcSignature(f)
 	if returnSig != "" {
 		g.writeln(fmt.Sprintf("func %s(%s) %s {", f.Name, strings.Join(params, ", "), returnSig))
 	} else {
@@ -787,35 +1445,164 @@ func (g *CodeGen) generateFuncDecl(f *ast.FuncDecl) {
 	}
 	g.indent++
 	
-	// Enter new scope
-	g.symbols.Enter()
+	// A function whose tail calls loop runs its body in a loop, each
+	// time round with a new frame
+	savedTailFunc, savedTailCalls := g.tailFunc, g.tailCalls
+	defer func() { g.tailFunc, g.tailCalls = savedTailFunc, savedTailCalls }()
+	g.tailFunc, g.tailCalls = f, tailCalls(f)
+	if g.tailCalls != nil {
+		g.writeln(tailLabel + ":")
+		g.writeln("for {")
+		g.indent++
+	}
+	
+	// Enter new scope, with its own variables for each call
+	g.symbols.EnterFrame()
+	frameAt := g.out.Len()
+	savedEscapes := g.frameEscapes
+	g.frameEscapes = frameEscapes(f.Body)
+	defer func() { g.frameEscapes = savedEscapes }()
+	
+	// Stack parameters and stacks declared in the body are only known
+	// to the body
+	savedStacks, savedPerspectives := copyStrings(g.stacks), copyStrings(g.perspectives)
+	defer func() { g.stacks, g.perspectives = savedStacks, savedPerspectives }()
 	
 	// Declare parameters as variables
 	for _, p := range f.Params {
-		idx, _ := g.symbols.Declare(p.Name, p.Type)
-		typeStack := TypeStack(p.Type)
-		g.writeln(fmt.Sprintf("stack_%s.PushAt(%d, %s) // param %s", 
-			typeStack, idx, g.wrapValueForType(p.Name, p.Type), p.Name))
+		if p.Stack {
+			g.stacks[p.Name] = valueType(p.Type)
+			delete(g.perspectives, p.Name)
+			continue
+		}
+		typ := valueType(p.Type)
+		if g.nativeLocal(p.Name, typ) {
+			g.declareVar(p.Name, typ, p.Name)
+			continue
+		}
+		idx, _ := g.symbols.Declare(p.Name, typ)
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, %s) // param %s", 
+			g.symbols.Slot(typ), idx, g.wrapValueForType(p.Name, typ), p.Name))
 	}
 	
 	// Generate body
 	for _, stmt := range f.Body {
 		g.generateStmt(stmt)
 	}
+	if g.tailCalls != nil && !terminates(f.Body, g.tailCalls) {
+		g.writeln("return") // not round again
+	}
 	
-	g.symbols.Exit()
+	g.insertFrame(frameAt, g.symbols.ExitFrame())
 	
+	if g.tailCalls != nil {
+		g.indent--
+		g.writeln("}")
+	}
 	g.indent--
 	g.writeln("}")
 	g.writeln("")
 }
 
+// generateTailCall generates call, a call of the function being generated
+// to itself in tail position, as the start of its next time round
+func (g *CodeGen) generateTailCall(call *ast.FuncCall) {
+	names, args := tailArgs(g.tailFunc, call)
+	if len(names) > 0 {
+		var values []string
+		for _, arg := range args {
+			values = append(values, g.generateExprValue(arg))
+		}
+		g.writeln(fmt.Sprintf("%s = %s // tail call", strings.Join(names, ", "), strings.Join(values, ", ")))
+	}
+	g.writeln("continue " + tailLabel)
+}
+
+// funcSignature returns the Go parameters of f and its result types, ""
+// for none
+func (g *CodeGen) funcSignature(f *ast.FuncDecl) ([]string, string) {
+	// Build parameter list. A stack parameter is the stack itself, named
+	// so that @name in the body refers to it.
+	var params []string
+	for _, p := range f.Params {
+		if p.Stack {
+			params = append(params, fmt.Sprintf("stack_%s *ual.Stack", p.Name))
+			continue
+		}
+		goType := g.goTypeFor(valueType(p.Type))
+		params = append(params, fmt.Sprintf("%s %s", p.Name, goType))
+	}
+	
+	// Build return type
+	var returnSig string
+	returnType := valueType(f.ReturnType)
+	if f.CanFail && returnType != "" {
+		returnSig = fmt.Sprintf("(%s, error)", g.goTypeFor(returnType))
+	} else if f.CanFail {
+		returnSig = "error"
+	} else if returnType != "" {
+		returnSig = g.goTypeFor(returnType)
+	}
+	return params, returnSig
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// insertFrame declares, at offset at of the output, the type stacks that
+// hold the variables of one call of a function or codeblock, so each call,
+// recursive or concurrent, has its own variables. Globals stay on the
+// global type stacks, and locals that do not escape are native variables,
+// so a function may need no frame at all.
+func (g *CodeGen) insertFrame(at int, stacks []string) {
+	if len(stacks) == 0 {
+		return
+	}
+	var frame strings.Builder
+	for _, ts := range stacks {
+		frame.WriteString(strings.Repeat("\t", g.indent))
+		frame.WriteString(fmt.Sprintf("frame_%s := ual.NewStack(ual.Hash, %s) // frame\n", ts, g.mapElementType(ts)))
+	}
+	g.inserts = append(g.inserts, outputInsert{at, frame.String()})
+}
+
+// outputInsert is text to go at offset at of the output, which is only
+// known once the code after it has been generated
+type outputInsert struct {
+	at   int
+	text string
+}
+
+// spliceInserts returns the output with the inserts in it, in one pass
+func (g *CodeGen) spliceInserts() string {
+	out := g.out.String()
+	sort.SliceStable(g.inserts, func(i, j int) bool { return g.inserts[i].at < g.inserts[j].at })
+	var b strings.Builder
+	last := 0
+	for _, ins := range g.inserts {
+		b.WriteString(out[last:ins.at])
+		b.WriteString(ins.text)
+		last = ins.at
+	}
+	b.WriteString(out[last:])
+	return b.String()
+}
+
 func (g *CodeGen) generateFuncCall(f *ast.FuncCall) {
+	if g.tailCalls[f] {
+		g.generateTailCall(f)
+		return
+	}
 	// Handle built-in functions
 	if f.Name == "print" {
 		var args []string
 		for _, arg := range f.Args {
-			args = append(args, g.generateExprValue(arg))
+			args = append(args, g.generatePrintArg(arg))
 		}
 		g.writeln(fmt.Sprintf("fmt.Println(%s)", strings.Join(args, ", ")))
 		return
@@ -823,7 +1610,7 @@ func (g *CodeGen) generateFuncCall(f *ast.FuncCall) {
 	if f.Name == "println" {
 		var args []string
 		for _, arg := range f.Args {
-			args = append(args, g.generateExprValue(arg))
+			args = append(args, g.generatePrintArg(arg))
 		}
 		g.writeln(fmt.Sprintf("fmt.Println(%s)", strings.Join(args, ", ")))
 		return
@@ -834,19 +1621,632 @@ func (g *CodeGen) generateFuncCall(f *ast.FuncCall) {
 		}
 		return
 	}
+	if f.Name == "exit" {
+		// exit / exit(code) - runs @atexit hooks, skips pending @defer blocks
+		switch len(f.Args) {
+		case 0:
+			g.writeln("ual.Exit(0)")
+		case 1:
+			g.writeln(fmt.Sprintf("ual.Exit(int(%s))", g.generateExprValue(f.Args[0])))
+		default:
+			g.addError("exit() takes at most one argument")
+		}
+		return
+	}
+	if f.Name == "expect_stack" || f.Name == "expect_output" {
+		g.generateExpect(f)
+		return
+	}
+	if f.Name == "assert" {
+		// assert(cond, msg) - panics with the message and the call's position
+		msg := `""`
+		if len(f.Args) > 1 {
+			msg = g.generateExprValue(f.Args[1])
+		}
+		pos := g.sourcePos(g.stmtPos)
+		g.writeln(fmt.Sprintf("ual.Assert(%s, %q, %d, %s)", g.generateCondition(f.Args[0]), pos.File, pos.Line, msg))
+		return
+	}
+	if f.Name == "call" || f.Name == "apply" || f.Name == "writefile" || f.Name == "appendfile" || f.Name == "args" || f.Name == "readline" || f.Name == "setenv" || f.Name == "read_csv" || f.Name == "write_csv" || f.Name == "listen" || f.Name == "query" || f.Name == "exec" {
+		code, _ := g.generateBuiltinExpr(f)
+		g.writeln("_ = " + code)
+		return
+	}
+	if f.Name == "clear_line" {
+		g.writeln("ual.ClearLine()")
+		return
+	}
+	if f.Name == "seed" {
+		// seed(x) - restarts the random sequence from x
+		if len(f.Args) != 1 {
+			g.addError("seed() requires a seed argument")
+			return
+		}
+		g.writeln(fmt.Sprintf("ual.Seed(int64(%s))", g.generateExprValue(f.Args[0])))
+		return
+	}
+	if f.Name == "printf" {
+		// printf(fmt, args...) - like print, no newline added
+		if format, args, ok := g.printfArgs(f); ok {
+			g.writeln(fmt.Sprintf("fmt.Printf(%s)", strings.Join(append([]string{format}, args...), ", ")))
+		}
+		return
+	}
+	switch f.Name {
+	case "freeze_time":
+		g.writeln("ual.FreezeTime()")
+		return
+	case "advance_time", "wait_timers":
+		if len(f.Args) != 1 {
+			g.addError(fmt.Sprintf("%s() requires one argument", f.Name))
+			return
+		}
+		if f.Name == "advance_time" {
+			g.writeln(fmt.Sprintf("ual.AdvanceTime(int64(%s))", g.generateExprValue(f.Args[0])))
+		} else {
+			g.writeln(fmt.Sprintf("ual.WaitTimers(int(%s))", g.generateExprValue(f.Args[0])))
+		}
+		return
+	case "mock":
+		g.generateMock(f)
+		return
+	}
+	if f.Name == "runtime_stats" {
+		g.generateRuntimeStats(f)
+		return
+	}
+	if f.Name == "progress" {
+		// progress(n, total) - redraws a progress bar, no-op on non-TTY
+		if len(f.Args) != 2 {
+			g.addError("progress() requires (n, total) arguments")
+			return
+		}
+		g.writeln(fmt.Sprintf("ual.Progress(int64(%s), int64(%s))",
+			g.generateExprValue(f.Args[0]), g.generateExprValue(f.Args[1])))
+		return
+	}
 	
+	args := g.callArgs(f.Name, f.Args, g.generateExprValue)
+	g.writeln(fmt.Sprintf("%s(%s)", f.Name, strings.Join(args, ", ")))
+}
+
+// callArgs generates the arguments of a call to the function name, each by
+// gen. Numeric values convert to the parameter's type, which expressions
+// computing in int64 or float64 need for a u8 or f32 parameter.
+func (g *CodeGen) callArgs(name string, args []ast.Expr, gen func(ast.Expr) string) []string {
+	f := g.funcDecls[name]
+	var code []string
+	for i, arg := range args {
+		c := gen(arg)
+		if f != nil && i < len(f.Params) && !f.Params[i].Stack {
+			if t := valueType(f.Params[i].Type); isNumericType(t) && t != "i64" && t != "f64" {
+				c = g.nativeValue(c, t)
+			}
+		}
+		code = append(code, c)
+	}
+	return code
+}
+
+// printfArgs checks the arguments of printf or format against the verbs
+// of their format string, which must be a literal, and returns the Go
+// format and arguments, each converted to the type its verb formats
+func (g *CodeGen) printfArgs(f *ast.FuncCall) (string, []string, bool) {
+	if len(f.Args) == 0 {
+		g.addError(fmt.Sprintf("%s() requires a format string", f.Name))
+		return "", nil, false
+	}
+	lit, ok := f.Args[0].(*ast.StringLit)
+	if !ok {
+		g.addError(fmt.Sprintf("%s(): the format must be a string literal", f.Name))
+		return "", nil, false
+	}
+	text, verbs, err := runtime.ParseFormat(lit.Value)
+	if err != nil {
+		g.addError(fmt.Sprintf("%s(): %v", f.Name, err))
+		return "", nil, false
+	}
+	if len(verbs) != len(f.Args)-1 {
+		g.addError(fmt.Sprintf("%s(): format has %d verbs but %d arguments", f.Name, len(verbs), len(f.Args)-1))
+		return "", nil, false
+	}
+	var format strings.Builder
 	var args []string
-	for _, arg := range f.Args {
-		args = append(args, g.generateExprValue(arg))
+	for i, v := range verbs {
+		format.WriteString(strings.ReplaceAll(text[i], "%", "%%"))
+		format.WriteString(v.String())
+		arg := g.generateExprValue(f.Args[i+1])
+		switch {
+		case v.Unsigned():
+			// A function rather than a conversion, so a negative constant builds
+			arg = fmt.Sprintf("func(v int64) uint64 { return uint64(v) }(int64(%s))", arg)
+		case v.IsInt():
+			arg = fmt.Sprintf("int64(%s)", arg)
+		case v.IsFloat():
+			arg = fmt.Sprintf("float64(%s)", arg)
+		}
+		args = append(args, arg)
 	}
-	g.writeln(fmt.Sprintf("%s(%s)", f.Name, strings.Join(args, ", ")))
+	format.WriteString(strings.ReplaceAll(text[len(verbs)], "%", "%%"))
+	return fmt.Sprintf("%q", format.String()), args, true
+}
+
+// generateExpect generates expect_stack(@s, [...]) and expect_output("..."),
+// which report a failure with the line of the call and carry on
+func (g *CodeGen) generateExpect(f *ast.FuncCall) {
+	g.usesExpect = true
+	line := g.pos[f].Line
+	if f.Name == "expect_output" {
+		if len(f.Args) != 1 {
+			g.addError("expect_output() requires one string argument")
+			return
+		}
+		g.usesExpectOutput = true
+		g.writeln(fmt.Sprintf("ual.ExpectOutput(%d, %s)", line, g.generateExprValue(f.Args[0])))
+		return
+	}
+
+	var ref *ast.StackRef
+	var list *ast.ListLit
+	if len(f.Args) == 2 {
+		ref, _ = f.Args[0].(*ast.StackRef)
+		list, _ = f.Args[1].(*ast.ListLit)
+	}
+	if ref == nil || list == nil {
+		g.addError("expect_stack() requires a stack and a list, e.g. expect_stack(@s, [1, 2])")
+		return
+	}
+	elemType, ok := g.stacks[ref.Name]
+	if !ok {
+		g.addError(fmt.Sprintf("undefined stack: @%s", ref.Name))
+		return
+	}
+	if ref.Name == "dstack" && g.optimize {
+		g.addError("expect_stack(@dstack) is not supported with --optimize")
+		return
+	}
+	var want []string
+	for _, e := range list.Elems {
+		want = append(want, g.wrapValue(g.generateExprValue(e), elemType))
+	}
+	g.writeln(fmt.Sprintf("ual.ExpectStack(%d, %q, %s, [][]byte{%s})",
+		line, ref.Name, g.stackVarName(ref.Name), strings.Join(want, ", ")))
+}
+
+// generateHarnessBlock runs the body of a test or bench block through
+// run, ual.RunTest or ualbench.Run, from empty default stacks. Only ual
+// test and ual bench run them, and only those of the file under test;
+// other builds, and the libraries it loads, leave them out.
+func (g *CodeGen) generateHarnessBlock(run, name string, s ast.Stmt, body []ast.Stmt) {
+	if g.pos[s].File != "" {
+		return
+	}
+	pos := g.sourcePos(g.pos[s])
+	g.writeln(fmt.Sprintf("%s(%q, %q, %d, func() {", run, name, pos.File, pos.Line))
+	g.indent++
+	if !g.noForth {
+		if g.optimize {
+			g.writeln("_dstack = _dstack[:0]")
+		} else {
+			g.writeln("stack_dstack.Clear()")
+		}
+		g.writeln("stack_rstack.Clear()")
+		g.writeln("stack_bool.Clear()")
+		g.writeln("stack_error.Clear()")
+	}
+	g.symbols.Enter()
+	for _, stmt := range body {
+		g.generateStmt(stmt)
+	}
+	g.symbols.Exit()
+	g.indent--
+	g.writeln("})")
+}
+
+// generateMock generates mock(@s, [...]) and mock(@s, [...], @sent), which
+// make @s a test double whose reads come from the list and whose pushes
+// go to @sent
+func (g *CodeGen) generateMock(f *ast.FuncCall) {
+	var ref, sentRef *ast.StackRef
+	var list *ast.ListLit
+	if len(f.Args) == 2 || len(f.Args) == 3 {
+		ref, _ = f.Args[0].(*ast.StackRef)
+		list, _ = f.Args[1].(*ast.ListLit)
+	}
+	if len(f.Args) == 3 {
+		if sentRef, _ = f.Args[2].(*ast.StackRef); sentRef == nil {
+			ref = nil
+		}
+	}
+	if ref == nil || list == nil {
+		g.addError("mock() requires a stack and a list, e.g. mock(@net, [\"ok\"], @sent)")
+		return
+	}
+	elemType, ok := g.stacks[ref.Name]
+	if !ok {
+		g.addError(fmt.Sprintf("undefined stack: @%s", ref.Name))
+		return
+	}
+	if ref.Name == "dstack" && g.optimize {
+		g.addError("mock(@dstack) is not supported with --optimize")
+		return
+	}
+	if p := g.perspectives[ref.Name]; p == "Hash" || p == "Broadcast" {
+		g.addError(fmt.Sprintf("mock() requires a LIFO, FIFO or Indexed stack, @%s is %s", ref.Name, p))
+		return
+	}
+	sent := "nil"
+	if sentRef != nil {
+		sentType, ok := g.stacks[sentRef.Name]
+		if !ok {
+			g.addError(fmt.Sprintf("undefined stack: @%s", sentRef.Name))
+			return
+		}
+		if sentRef.Name == ref.Name || sentType != elemType {
+			g.addError(fmt.Sprintf("mock() records pushes on another stack of the same type as @%s", ref.Name))
+			return
+		}
+		if p := g.perspectives[sentRef.Name]; p == "Hash" || p == "Broadcast" {
+			g.addError(fmt.Sprintf("mock() records pushes on a LIFO, FIFO or Indexed stack, @%s is %s", sentRef.Name, p))
+			return
+		}
+		sent = g.stackVarName(sentRef.Name)
+	}
+	var script []string
+	for _, e := range list.Elems {
+		script = append(script, g.wrapValue(g.generateExprValue(e), elemType))
+	}
+	g.writeln(fmt.Sprintf("%s.Mock(%s, %s)", g.stackVarName(ref.Name), sent, strings.Join(script, ", ")))
+}
+
+// generateRuntimeStats generates runtime_stats(@health), which replaces the
+// contents of a Hash i64 stack with the process's goroutine, heap and GC
+// figures and the depth of every global stack
+func (g *CodeGen) generateRuntimeStats(f *ast.FuncCall) {
+	var ref *ast.StackRef
+	if len(f.Args) == 1 {
+		ref, _ = f.Args[0].(*ast.StackRef)
+	}
+	if ref == nil {
+		g.addError("runtime_stats() requires a stack argument")
+		return
+	}
+	if g.perspectives[ref.Name] != "Hash" || g.stacks[ref.Name] != "i64" {
+		g.addError(fmt.Sprintf("runtime_stats() requires a Hash stack of i64, @%s is not one", ref.Name))
+		return
+	}
+	var names []string
+	for name := range g.stacks {
+		if name == "dstack" && g.optimize {
+			continue // a native slice
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var entries []string
+	for _, name := range names {
+		entries = append(entries, fmt.Sprintf("%q: %s", name, g.stackVarName(name)))
+	}
+	g.writeln(fmt.Sprintf("ual.FillRuntimeStats(%s, map[string]*ual.Stack{%s})",
+		g.stackVarName(ref.Name), strings.Join(entries, ", ")))
+}
+
+// generateBuiltinExpr generates value-returning builtins used in expressions.
+// Returns false if the call is not a builtin.
+func (g *CodeGen) generateBuiltinExpr(f *ast.FuncCall) (string, bool) {
+	switch f.Name {
+	case "call":
+		// call(f, args...) - calls the codeblock value f
+		if len(f.Args) == 0 {
+			g.addError("call() requires a codeblock argument")
+			return "0", true
+		}
+		args := []string{fmt.Sprintf("int64(%s)", g.generateExprValue(f.Args[0]))}
+		for _, arg := range f.Args[1:] {
+			args = append(args, fmt.Sprintf("int64(%s)", g.generateExprValue(arg)))
+		}
+		return fmt.Sprintf("ual.CallFn(%s)", strings.Join(args, ", ")), true
+	case "apply":
+		// apply(f, @s) - calls f with its arguments popped from @s
+		if len(f.Args) != 2 {
+			g.addError("apply() requires (codeblock, @stack) arguments")
+			return "0", true
+		}
+		ref, ok := f.Args[1].(*ast.StackRef)
+		if !ok {
+			g.addError("apply() second argument must be a stack reference")
+			return "0", true
+		}
+		if t := g.getStackElementType(ref.Name); TypeStack(t) != "i64" {
+			g.addError(fmt.Sprintf("apply() takes arguments from an i64 stack, @%s is %s", ref.Name, t))
+			return "0", true
+		}
+		return fmt.Sprintf("ual.ApplyFn(int64(%s), %s.Pop)", g.generateExprValue(f.Args[0]), g.stackVarName(ref.Name)), true
+	case "render":
+		// render(template, @vars) - template string expanded from a Hash stack
+		if len(f.Args) != 2 {
+			g.addError("render() requires (template, @stack) arguments")
+			return `""`, true
+		}
+		ref, ok := f.Args[1].(*ast.StackRef)
+		if !ok {
+			g.addError("render() second argument must be a stack reference")
+			return `""`, true
+		}
+		if p := g.perspectives[ref.Name]; p != "" && p != "Hash" {
+			g.addError(fmt.Sprintf("render() requires a Hash stack, @%s is %s", ref.Name, p))
+			return `""`, true
+		}
+		tmpl := g.generateExprValue(f.Args[0])
+		return fmt.Sprintf("func() string { s, err := ual.Render(%s, %s); if err != nil { panic(err) }; return s }()",
+			tmpl, g.stackVarName(ref.Name)), true
+	case "is_tty":
+		return "ual.IsTTY()", true
+	case "color":
+		// color(name, s) - ANSI-coloured string, unchanged on non-TTY
+		if len(f.Args) != 2 {
+			g.addError("color() requires (name, string) arguments")
+			return `""`, true
+		}
+		return fmt.Sprintf("ual.Color(%s, %s)", g.generateExprValue(f.Args[0]), g.generateExprValue(f.Args[1])), true
+	case "prompt", "confirm", "password":
+		// prompt(msg) / password(msg) read a line, confirm(msg) asks y/N
+		if len(f.Args) != 1 {
+			g.addError(fmt.Sprintf("%s() requires a message argument", f.Name))
+			return `""`, true
+		}
+		fn := map[string]string{"prompt": "Prompt", "confirm": "Confirm", "password": "Password"}[f.Name]
+		return fmt.Sprintf("ual.%s(%s)", fn, g.generateExprValue(f.Args[0])), true
+	case "format_int":
+		// format_int(n, width, pad) - decimal, padded to width
+		if len(f.Args) != 3 {
+			g.addError("format_int() requires (n, width, pad) arguments")
+			return `""`, true
+		}
+		return fmt.Sprintf("ual.FormatInt(int64(%s), int64(%s), %s)", g.generateExprValue(f.Args[0]),
+			g.generateExprValue(f.Args[1]), g.generateExprValue(f.Args[2])), true
+	case "format_float":
+		// format_float(x, prec, width) - fixed point, right-aligned
+		if len(f.Args) != 3 {
+			g.addError("format_float() requires (x, prec, width) arguments")
+			return `""`, true
+		}
+		return fmt.Sprintf("ual.FormatFloat(float64(%s), int64(%s), int64(%s))", g.generateExprValue(f.Args[0]),
+			g.generateExprValue(f.Args[1]), g.generateExprValue(f.Args[2])), true
+	case "format":
+		// format(fmt, args...) - printf-style formatted string
+		if format, args, ok := g.printfArgs(f); ok {
+			return fmt.Sprintf("fmt.Sprintf(%s)", strings.Join(append([]string{format}, args...), ", ")), true
+		}
+		return `""`, true
+	case "uuid4":
+		return "ual.UUID4()", true
+	case "ulid":
+		return "ual.ULID()", true
+	case "seq":
+		// seq(name) - next value of a per-program counter, from 1
+		if len(f.Args) != 1 {
+			g.addError("seq() requires a counter name argument")
+			return "int64(0)", true
+		}
+		return fmt.Sprintf("ual.Seq(%s)", g.generateExprValue(f.Args[0])), true
+	case "rand":
+		// rand() - a float from 0 up to but not including 1
+		if len(f.Args) != 0 {
+			g.addError("rand() takes no arguments")
+		}
+		return "ual.Rand()", true
+	case "rand_int":
+		// rand_int(n) - an integer from 0 up to but not including n
+		if len(f.Args) != 1 {
+			g.addError("rand_int() requires a bound argument")
+			return "int64(0)", true
+		}
+		return fmt.Sprintf("ual.RandInt(int64(%s))", g.generateExprValue(f.Args[0])), true
+	case "readfile":
+		// readfile(path) - the file's contents, "" if it cannot be read
+		if len(f.Args) != 1 {
+			g.addError("readfile() requires a path argument")
+			return `""`, true
+		}
+		return fmt.Sprintf("func() string { s, err := ual.ReadFile(%s); if %s; return s }()",
+			g.generateExprValue(f.Args[0]), g.fileStatus()), true
+	case "writefile", "appendfile":
+		// writefile(path, @s) / appendfile(path, @s) - true if written
+		if len(f.Args) != 2 {
+			g.addError(fmt.Sprintf("%s() requires (path, @stack) arguments", f.Name))
+			return "false", true
+		}
+		ref, ok := f.Args[1].(*ast.StackRef)
+		if !ok {
+			g.addError(fmt.Sprintf("%s() second argument must be a stack reference", f.Name))
+			return "false", true
+		}
+		if g.perspectives[ref.Name] == "Hash" {
+			g.addError(fmt.Sprintf("%s() cannot write the Hash stack @%s", f.Name, ref.Name))
+			return "false", true
+		}
+		fn := map[string]string{"writefile": "WriteFile", "appendfile": "AppendFile"}[f.Name]
+		return fmt.Sprintf("func() bool { err := ual.%s(%s, %s); if %s; return err == nil }()",
+			fn, g.generateExprValue(f.Args[0]), g.stackVarName(ref.Name), g.fileStatus()), true
+	case "read_csv", "write_csv":
+		// read_csv(path, @a, @b, ...) - rows read; write_csv(...) - true if written
+		fail := map[string]string{"read_csv": "int64(0)", "write_csv": "false"}[f.Name]
+		if len(f.Args) < 2 {
+			g.addError(fmt.Sprintf("%s() requires (path, @stack, ...) arguments", f.Name))
+			return fail, true
+		}
+		var header, cols []string
+		for _, arg := range f.Args[1:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				g.addError(fmt.Sprintf("%s() columns must be stack references", f.Name))
+				return fail, true
+			}
+			if g.perspectives[ref.Name] == "Hash" {
+				g.addError(fmt.Sprintf("%s() cannot take the Hash stack @%s as a column", f.Name, ref.Name))
+				return fail, true
+			}
+			header = append(header, strconv.Quote(ref.Name))
+			cols = append(cols, g.stackVarName(ref.Name))
+		}
+		path := g.generateExprValue(f.Args[0])
+		if f.Name == "read_csv" {
+			return fmt.Sprintf("func() int64 { n, err := ual.ReadCSV(%s, %s); if %s; return n }()",
+				path, strings.Join(cols, ", "), g.fileStatus()), true
+		}
+		return fmt.Sprintf("func() bool { err := ual.WriteCSV(%s, []string{%s}, %s); if %s; return err == nil }()",
+			path, strings.Join(header, ", "), strings.Join(cols, ", "), g.fileStatus()), true
+	case "listen":
+		// listen(addr, @reqs, @resps) - serves HTTP in the background, true if listening
+		if len(f.Args) != 3 {
+			g.addError("listen() requires (addr, @requests, @responses) arguments")
+			return "false", true
+		}
+		var stacks []string
+		for _, arg := range f.Args[1:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				g.addError("listen() requests and responses must be stack references")
+				return "false", true
+			}
+			if t := g.getStackElementType(ref.Name); t != "string" || g.perspectives[ref.Name] == "Hash" {
+				g.addError(fmt.Sprintf("listen() requires string stacks that are not Hash stacks, @%s is not one", ref.Name))
+				return "false", true
+			}
+			stacks = append(stacks, g.stackVarName(ref.Name))
+		}
+		return fmt.Sprintf("func() bool { _, err := ual.ServeHTTP(%s, %s, %s); if %s; return err == nil }()",
+			g.generateExprValue(f.Args[0]), stacks[0], stacks[1], g.fileStatus()), true
+	case "query", "exec":
+		// query(db, sql, params..., @a, @b, ...) - rows pushed, a column to each stack
+		// exec(db, sql, params...) - rows changed
+		if len(f.Args) < 2 {
+			g.addError(fmt.Sprintf("%s() requires (database, sql, ...) arguments", f.Name))
+			return "int64(0)", true
+		}
+		var params, cols []string
+		for _, arg := range f.Args[2:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				params = append(params, g.generateExprValue(arg))
+				continue
+			}
+			if f.Name == "exec" || g.perspectives[ref.Name] == "Hash" {
+				g.addError(fmt.Sprintf("%s() cannot take the stack @%s", f.Name, ref.Name))
+				return "int64(0)", true
+			}
+			cols = append(cols, g.stackVarName(ref.Name))
+		}
+		db, sql := g.generateExprValue(f.Args[0]), g.generateExprValue(f.Args[1])
+		if f.Name == "exec" {
+			return fmt.Sprintf("func() int64 { n, err := ualsql.Exec(%s); if %s; return n }()",
+				strings.Join(append([]string{db, sql}, params...), ", "), g.fileStatus()), true
+		}
+		if len(cols) == 0 {
+			g.addError("query() requires a stack for each column of the result")
+			return "int64(0)", true
+		}
+		return fmt.Sprintf("func() int64 { n, err := ualsql.Query(%s, %s, []any{%s}, %s); if %s; return n }()",
+			db, sql, strings.Join(params, ", "), strings.Join(cols, ", "), g.fileStatus()), true
+	case "exists":
+		if len(f.Args) != 1 {
+			g.addError("exists() requires a path argument")
+			return "false", true
+		}
+		return fmt.Sprintf("ual.FileExists(%s)", g.generateExprValue(f.Args[0])), true
+	case "env":
+		// env(name) - the variable's value, "" with the not_found status if unset
+		if len(f.Args) != 1 {
+			g.addError("env() requires a variable name argument")
+			return `""`, true
+		}
+		if g.noForth {
+			return fmt.Sprintf("func() string { s, _ := ual.Env(%s); return s }()", g.generateExprValue(f.Args[0])), true
+		}
+		return fmt.Sprintf("func(name string) string { s, ok := ual.Env(name); if !ok { _consider_status = \"not_found\"; _consider_value = name }; return s }(%s)",
+			g.generateExprValue(f.Args[0])), true
+	case "setenv":
+		// setenv(name, value) - true if set
+		if len(f.Args) != 2 {
+			g.addError("setenv() requires (name, value) arguments")
+			return "false", true
+		}
+		return fmt.Sprintf("func() bool { err := ual.SetEnv(%s, %s); if %s; return err == nil }()",
+			g.generateExprValue(f.Args[0]), g.generateExprValue(f.Args[1]), g.fileStatus()), true
+	case "readline":
+		// readline() - the next line of stdin, "" with the eof status at its end
+		if len(f.Args) != 0 {
+			g.addError("readline() takes no arguments")
+			return `""`, true
+		}
+		return fmt.Sprintf("func() string { s, err := ual.ReadLine(); if %s; return s }()", g.fileStatus()), true
+	case "args":
+		// args(@s) - pushes the program's arguments, returns how many
+		if len(f.Args) != 1 {
+			g.addError("args() requires a @stack argument")
+			return "int64(0)", true
+		}
+		ref, ok := f.Args[0].(*ast.StackRef)
+		if !ok {
+			g.addError("args() argument must be a stack reference")
+			return "int64(0)", true
+		}
+		if t := g.getStackElementType(ref.Name); t != "string" {
+			g.addError(fmt.Sprintf("args() requires a string stack, @%s is %s", ref.Name, t))
+			return "int64(0)", true
+		}
+		if g.perspectives[ref.Name] == "Hash" {
+			g.addError(fmt.Sprintf("args() cannot push to the Hash stack @%s", ref.Name))
+			return "int64(0)", true
+		}
+		return fmt.Sprintf("ual.PushArgs(%s)", g.stackVarName(ref.Name)), true
+	}
+	return "", false
+}
+
+// fileStatus returns the tail of an `if ...; err check {}` statement that
+// sets the consider status of a failed file builtin, with the error message
+// as its value.
+func (g *CodeGen) fileStatus() string {
+	if g.noForth {
+		// No consider status globals without the Forth stacks
+		return "err != nil {}"
+	}
+	return "err != nil { _consider_status = ual.FileStatus(err); _consider_value = err.Error() }"
 }
 
 func (g *CodeGen) generateReturnStmt(r *ast.ReturnStmt) {
+	if g.tailCalls[r] {
+		g.generateTailCall(r.Value.(*ast.FuncCall))
+		return
+	}
+	if g.inCodeblock {
+		if r.Value == nil {
+			g.writeln("return 0")
+			return
+		}
+		g.writeln(fmt.Sprintf("return int64(%s)", g.generateExprValue(r.Value)))
+		return
+	}
+	if g.spawnResultType != "" {
+		// Inside "@spawn < { } into @results": hand the value to RunForResult
+		if r.Value == nil {
+			g.writeln("return nil, false")
+			return
+		}
+		g.writeln(fmt.Sprintf("return %s, true", g.wrapValueForType(g.generateExprValue(r.Value), g.spawnResultType)))
+		return
+	}
 	if r.Value == nil {
 		g.writeln("return")
 	} else {
 		val := g.generateExprValue(r.Value)
+		if t := g.tailFunc; t != nil && !t.CanFail && isNumericType(t.ReturnType) && t.ReturnType != "i64" && t.ReturnType != "f64" {
+			// Expressions compute in int64 or float64; the result has
+			// the declared type, as T in an instance of a generic function
+			val = g.nativeValue(val, t.ReturnType)
+		}
 		g.writeln(fmt.Sprintf("return %s", val))
 	}
 }
@@ -863,6 +2263,18 @@ func (g *CodeGen) generateDeferStmt(d *ast.DeferStmt) {
 	g.writeln("}()")
 }
 
+func (g *CodeGen) generateAtExitStmt(a *ast.AtExitStmt) {
+	g.writeln("ual.AtExit(func() {")
+	g.indent++
+	
+	for _, stmt := range a.Body {
+		g.generateStmt(stmt)
+	}
+	
+	g.indent--
+	g.writeln("})")
+}
+
 func (g *CodeGen) generatePanicStmt(p *ast.PanicStmt) {
 	if p.Value == nil {
 		// Bare panic - re-panic with recovered value
@@ -951,7 +2363,7 @@ func (g *CodeGen) generateTryStmt(t *ast.TryStmt) {
 	g.writeln("}()")
 }
 
-func (g *CodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
+func (g *CodeGen) generateConsiderStmt(cs *ast.ConsiderStmt) {
 	// Generate consider block with status matching
 	//
 	// @stack { ops }.consider(
@@ -988,7 +2400,7 @@ func (g *CodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	//         e := _consider_value
 	//         handler2(e)
 	//     default:
-	//         panic("unhandled status")
+	//         defaultHandler()
 	//     }
 	//     
 	//     // Restore saved status
@@ -996,9 +2408,14 @@ func (g *CodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	//     _consider_value = _saved_value
 	// }()
 	
-	g.fnCounter++
-	savedStatusVar := fmt.Sprintf("_saved_status_%d", g.fnCounter)
-	savedValueVar := fmt.Sprintf("_saved_value_%d", g.fnCounter)
+	c, err := ir.LowerConsider(cs)
+	if err != nil {
+		g.addError(err.Error())
+		return
+	}
+	n := g.ids.next("consider")
+	savedStatusVar := fmt.Sprintf("_saved_status_%d", n)
+	savedValueVar := fmt.Sprintf("_saved_value_%d", n)
 	
 	g.writeln("func() {")
 	g.indent++
@@ -1011,8 +2428,8 @@ func (g *CodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	g.writeln("")
 	
 	// Execute the block
-	if c.Block != nil {
-		g.generateStackBlock(c.Block)
+	if c.Body != nil {
+		g.generateStackBlock(c.Body)
 	}
 	
 	g.writeln("")
@@ -1027,93 +2444,16 @@ func (g *CodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	g.writeln("}")
 	g.writeln("")
 	
-	// Check if we have a default case
-	hasDefault := false
-	for _, cas := range c.Cases {
-		if cas.Label == "_" {
-			hasDefault = true
-			break
-		}
-	}
-	
-	// Generate switch statement
+	// Match status
 	g.writeln("switch _consider_status {")
-	
 	for _, cas := range c.Cases {
-		if cas.Label == "_" {
-			// Default case
-			g.writeln("default:")
-		} else {
-			g.writeln(fmt.Sprintf("case \"%s\":", cas.Label))
-		}
-		g.indent++
-		
-		// Bind value to variables if requested
-		if len(cas.Bindings) > 0 {
-			// For single binding, bind the value
-			if len(cas.Bindings) == 1 {
-				bindName := cas.Bindings[0]
-				// Try to extract as int64, fall back to 0
-				g.writeln(fmt.Sprintf("var %s int64", bindName))
-				g.writeln("switch _v := _consider_value.(type) {")
-				g.writeln("case int64:")
-				g.indent++
-				g.writeln(fmt.Sprintf("%s = _v", bindName))
-				g.indent--
-				g.writeln("case int:")
-				g.indent++
-				g.writeln(fmt.Sprintf("%s = int64(_v)", bindName))
-				g.indent--
-				g.writeln("case string:")
-				g.indent++
-				g.writeln(fmt.Sprintf("fmt.Sscanf(_v, \"%%d\", &%s)", bindName))
-				g.indent--
-				g.writeln("}")
-				// Also create string version for string operations
-				g.writeln(fmt.Sprintf("%s_str := fmt.Sprint(_consider_value)", bindName))
-				g.writeln(fmt.Sprintf("_ = %s // suppress unused", bindName))
-				g.writeln(fmt.Sprintf("_ = %s_str // suppress unused", bindName))
-				// Track this as a consider binding so print() uses _str version
-				g.considerBindings[bindName] = true
-			} else {
-				// For multiple bindings, we'd need tuple unpacking
-				// For now, just bind first to value
-				g.writeln(fmt.Sprintf("var %s int64", cas.Bindings[0]))
-				g.writeln("switch _v := _consider_value.(type) {")
-				g.writeln("case int64:")
-				g.indent++
-				g.writeln(fmt.Sprintf("%s = _v", cas.Bindings[0]))
-				g.indent--
-				g.writeln("case int:")
-				g.indent++
-				g.writeln(fmt.Sprintf("%s = int64(_v)", cas.Bindings[0]))
-				g.indent--
-				g.writeln("}")
-				g.writeln(fmt.Sprintf("%s_str := fmt.Sprint(_consider_value)", cas.Bindings[0]))
-				// Track this as a consider binding so print() uses _str version
-				g.considerBindings[cas.Bindings[0]] = true
-				for i := 1; i < len(cas.Bindings); i++ {
-					g.writeln(fmt.Sprintf("var %s int64 // additional binding", cas.Bindings[i]))
-				}
-			}
-		}
-		
-		// Generate handler statements
-		for _, stmt := range cas.Handler {
-			g.generateStmt(stmt)
-		}
-		
-		g.indent--
+		g.writeln(fmt.Sprintf("case \"%s\":", cas.Label))
+		g.generateConsiderCase(cas)
 	}
-	
-	// If no default case, add a panic for unhandled status
-	if !hasDefault {
+	if c.Default != nil {
 		g.writeln("default:")
-		g.indent++
-		g.writeln("panic(\"unhandled status in consider: \" + _consider_status)")
-		g.indent--
+		g.generateConsiderCase(*c.Default)
 	}
-	
 	g.writeln("}")
 	
 	// Restore saved status
@@ -1125,6 +2465,40 @@ func (g *CodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	g.writeln("}()")
 }
 
+// generateConsiderCase binds the status value, if the case asks for it, as
+// an int64 and as a string, and generates the case's handler
+func (g *CodeGen) generateConsiderCase(cas ir.ConsiderCase) {
+	g.indent++
+	if cas.Bind != "" {
+		// Try to extract as int64, fall back to 0
+		g.writeln(fmt.Sprintf("var %s int64", cas.Bind))
+		g.writeln("switch _v := _consider_value.(type) {")
+		g.writeln("case int64:")
+		g.indent++
+		g.writeln(fmt.Sprintf("%s = _v", cas.Bind))
+		g.indent--
+		g.writeln("case int:")
+		g.indent++
+		g.writeln(fmt.Sprintf("%s = int64(_v)", cas.Bind))
+		g.indent--
+		g.writeln("case string:")
+		g.indent++
+		g.writeln(fmt.Sprintf("fmt.Sscanf(_v, \"%%d\", &%s)", cas.Bind))
+		g.indent--
+		g.writeln("}")
+		// Also create string version for string operations
+		g.writeln(fmt.Sprintf("%s_str := fmt.Sprint(_consider_value)", cas.Bind))
+		g.writeln(fmt.Sprintf("_ = %s // suppress unused", cas.Bind))
+		g.writeln(fmt.Sprintf("_ = %s_str // suppress unused", cas.Bind))
+		// Track this as a consider binding so print() uses _str version
+		g.considerBindings[cas.Bind] = true
+	}
+	for _, stmt := range cas.Body {
+		g.generateStmt(stmt)
+	}
+	g.indent--
+}
+
 func (g *CodeGen) generateStatusStmt(s *ast.StatusStmt) {
 	// status:label or status:label(value)
 	// Sets the global status variable
@@ -1137,7 +2511,7 @@ func (g *CodeGen) generateStatusStmt(s *ast.StatusStmt) {
 	}
 }
 
-func (g *CodeGen) generateSelectStmt(s *ast.SelectStmt) {
+func (g *CodeGen) generateSelectStmt(ss *ast.SelectStmt) {
 	// Generate select block with concurrent waits on multiple stacks
 	//
 	// @inbox {
@@ -1150,86 +2524,52 @@ func (g *CodeGen) generateSelectStmt(s *ast.SelectStmt) {
 	//
 	// Becomes a racing goroutines pattern with context cancellation
 	
-	g.fnCounter++
-	selectID := g.fnCounter
-	
-	// Check if we have a default case (makes it non-blocking)
-	hasDefault := false
-	for _, cas := range s.Cases {
-		if cas.Stack == "_" {
-			hasDefault = true
-			break
-		}
+	s, err := ir.LowerSelect(ss)
+	if err != nil {
+		g.addError(err.Error())
+		return
 	}
+	selectID := g.ids.next("select")
 	
 	g.writeln("// select block")
 	g.writeln("func() {")
 	g.indent++
 	
 	// Execute setup block first
-	if s.Block != nil {
+	if s.Setup != nil {
 		g.writeln("// setup")
-		g.generateStackBlock(s.Block)
+		g.generateStackBlock(s.Setup)
 		g.writeln("")
 	}
 	
-	// For non-blocking select (has default), use simple sequential checks
+	// Stack each case waits on
+	sources := make([]string, len(s.Cases))
+	for i, cas := range s.Cases {
+		sources[i] = g.selectSource(cas, selectID, i)
+	}
+	
+	// For non-blocking select (has default), take from a ready stack if any
 	// For blocking select, use goroutines with channels
-	if hasDefault {
-		g.writeln("// Non-blocking select: check stacks in order")
+	if !s.Waits {
+		g.writeln("// Non-blocking select: take from a ready stack, if any")
+		g.writeln(fmt.Sprintf("switch _i, _v := ual.SelectPop(%s); _i {", selectPopArgs(s, sources)))
 		
-		caseID := 0
-		firstCase := true
-		for _, cas := range s.Cases {
-			if cas.Stack == "_" {
-				continue
-			}
-			
-			stackVar := g.stackVarName(cas.Stack)
-			
-			if firstCase {
-				g.writeln(fmt.Sprintf("if %s.Len() > 0 {", stackVar))
-				firstCase = false
-			} else {
-				g.writeln(fmt.Sprintf("} else if %s.Len() > 0 {", stackVar))
-			}
+		for i, cas := range s.Cases {
+			g.writeln(fmt.Sprintf("case %d: // %s", i, selectCaseLabel(cas)))
 			g.indent++
-			
-			g.writeln(fmt.Sprintf("_v, _ := %s.Pop()", stackVar))
-			
-			// Bind value to variable if requested
-			if len(cas.Bindings) > 0 {
-				bindName := cas.Bindings[0]
-				g.writeln(fmt.Sprintf("%s := bytesToInt(_v)", bindName))
-				g.writeln(fmt.Sprintf("_ = %s // suppress unused warning", bindName))
-			}
-			
-			// Generate handler statements
-			for _, stmt := range cas.Handler {
-				g.generateStmt(stmt)
-			}
-			
+			g.generateSelectCaseBody(cas, "_v")
 			g.indent--
-			caseID++
 		}
 		
 		// Default case
-		if !firstCase {
-			g.writeln("} else {")
-			g.indent++
-		}
-		for _, cas := range s.Cases {
-			if cas.Stack == "_" {
-				for _, stmt := range cas.Handler {
-					g.generateStmt(stmt)
-				}
-				break
-			}
-		}
-		if !firstCase {
-			g.indent--
-			g.writeln("}")
+		g.writeln("default:")
+		g.indent++
+		g.writeln("_ = _v")
+		for _, stmt := range s.Default {
+			g.generateStmt(stmt)
 		}
+		g.indent--
+		g.writeln("}")
 		
 	} else {
 		// Blocking select: use goroutines
@@ -1250,50 +2590,55 @@ func (g *CodeGen) generateSelectStmt(s *ast.SelectStmt) {
 		g.writeln(fmt.Sprintf("_resultCh%d := make(chan _selectResult, 1)", selectID))
 		g.writeln("")
 		
+		// A stack that is already ready wins without starting the race
+		g.writeln(fmt.Sprintf("if _i, _v := ual.SelectPop(%s); _i >= 0 {", selectPopArgs(s, sources)))
+		g.indent++
+		g.writeln(fmt.Sprintf("_resultCh%d <- _selectResult{_i, _v}", selectID))
+		g.indent--
+		g.writeln("} else {")
+		g.indent++
+		g.writeln("")
+		
 		// Generate a goroutine for each case
-		caseID := 0
 		for i, cas := range s.Cases {
-			stackVar := g.stackVarName(cas.Stack)
+			stackVar := sources[i]
 			
-			// Check if we need a retry label for this case
-			needsRetryLabel := false
-			if cas.TimeoutFn != nil {
-				hasRetry, _ := g.checkSelectControlFlow(cas.TimeoutFn.Body)
-				needsRetryLabel = hasRetry
-			}
-			
-			g.writeln(fmt.Sprintf("// Case %d: @%s", caseID, cas.Stack))
+			g.writeln(fmt.Sprintf("// Case %d: %s", i, selectCaseLabel(cas)))
 			g.writeln("go func() {")
 			g.indent++
-			
-			// Label for retry (only if needed)
-			if needsRetryLabel {
-				g.writeln(fmt.Sprintf("_retry%d_%d:", selectID, i))
+			if g.crashDump != "" {
+				g.writeln("defer ual.CrashGuard()")
 			}
 			
-			if cas.TimeoutMs != nil {
+			if t := cas.Timeout; t != nil {
+				// Label for retry (only if needed)
+				if t.Retry {
+					g.writeln(fmt.Sprintf("_retry%d_%d:", selectID, i))
+				}
+				
 				// Take with timeout
-				timeoutExpr := g.generateExpr(cas.TimeoutMs)
+				timeoutExpr := g.generateExpr(t.Ms)
 				g.writeln(fmt.Sprintf("_v, _err := %s.TakeWithContext(_ctx%d, int64(%s))", stackVar, selectID, timeoutExpr))
 				g.writeln("if _err != nil {")
 				g.indent++
 				g.writeln("// Check if it was a timeout (not a cancel)")
 				g.writeln(fmt.Sprintf("if _err.Error() == \"timeout\" {"))
 				g.indent++
-				
-				// Execute timeout handler if provided
-				if cas.TimeoutFn != nil {
-					// Check for retry() or restart() in the handler
-					hasRetry, hasRestart := g.checkSelectControlFlow(cas.TimeoutFn.Body)
-					
-					// Generate timeout handler body
-					for _, stmt := range cas.TimeoutFn.Body {
-						// Replace retry() and restart() calls with gotos/returns
-						g.generateSelectHandlerStmt(stmt, selectID, i, hasRetry, hasRestart)
-					}
+				for _, stmt := range t.Body {
+					g.generateStmt(stmt)
+				}
+				if t.Retry {
+					g.writeln(fmt.Sprintf("goto _retry%d_%d", selectID, i))
+				} else {
+					// The handler ends the select, as no case won
+					g.writeln("select {")
+					g.writeln(fmt.Sprintf("case _resultCh%d <- _selectResult{-1, nil}:", selectID))
+					g.indent++
+					g.writeln(fmt.Sprintf("_cancel%d()", selectID))
+					g.indent--
+					g.writeln("default:")
+					g.writeln("}")
 				}
-				
-				g.writeln("return // timeout, select completes via handler or default")
 				g.indent--
 				g.writeln("}")
 				g.writeln("return // cancelled")
@@ -1311,7 +2656,7 @@ func (g *CodeGen) generateSelectStmt(s *ast.SelectStmt) {
 			
 			// Successfully got a value, try to send it
 			g.writeln("select {")
-			g.writeln(fmt.Sprintf("case _resultCh%d <- _selectResult{%d, _v}:", selectID, caseID))
+			g.writeln(fmt.Sprintf("case _resultCh%d <- _selectResult{%d, _v}:", selectID, i))
 			g.indent++
 			g.writeln(fmt.Sprintf("_cancel%d() // won the race", selectID))
 			g.indent--
@@ -1321,81 +2666,98 @@ func (g *CodeGen) generateSelectStmt(s *ast.SelectStmt) {
 			g.indent--
 			g.writeln("}()")
 			g.writeln("")
-			
-			caseID++
 		}
+		g.indent--
+		g.writeln("}")
+		g.writeln("")
 		
 		// Wait for result
 		g.writeln("// Blocking: wait for a result")
 		g.writeln(fmt.Sprintf("_result := <-_resultCh%d", selectID))
-		g.generateSelectSwitch(s, selectID)
+		g.generateSelectSwitch(s)
 	}
 	
 	g.indent--
 	g.writeln("}()")
 }
 
-// generateSelectSwitch generates the switch statement for handling select results
-func (g *CodeGen) generateSelectSwitch(s *ast.SelectStmt, selectID int) {
-	g.writeln("switch _result.caseID {")
-	
-	caseID := 0
-	for _, cas := range s.Cases {
-		if cas.Stack == "_" {
-			continue // default handled separately
-		}
-		
-		g.writeln(fmt.Sprintf("case %d: // @%s", caseID, cas.Stack))
+// generateSelectSwitch generates the switch statement for handling select
+// results. A timeout that ended the select sends case -1, which runs none.
+func (g *CodeGen) generateSelectSwitch(s *ir.Select) {
+	g.writeln("switch _result.caseID {")
+	for i, cas := range s.Cases {
+		g.writeln(fmt.Sprintf("case %d: // %s", i, selectCaseLabel(cas)))
+		g.indent++
+		g.generateSelectCaseBody(cas, "_result.value")
+		g.indent--
+	}
+	g.writeln("}")
+}
+
+// generateSelectCaseBody binds the value v received by a select case, if
+// the case asks for it, and generates the case's handler
+func (g *CodeGen) generateSelectCaseBody(cas ir.SelectCase, v string) {
+	if cas.Bind != "" {
+		g.writeln(fmt.Sprintf("%s := %s", cas.Bind, selectBinding(cas, v)))
+		g.writeln(fmt.Sprintf("_ = %s // suppress unused warning", cas.Bind))
+	}
+	for _, stmt := range cas.Body {
+		g.generateStmt(stmt)
+	}
+}
+
+// selectPopArgs is the argument list of ual.SelectPop for a select: the
+// fairness flag and the stacks of every case but the default
+func selectPopArgs(s *ir.Select, sources []string) string {
+	args := append([]string{strconv.FormatBool(s.Fair)}, sources...)
+	return strings.Join(args, ", ")
+}
+
+// selectSource returns the stack a select case waits on. Timer and signal
+// sources are created the first time their select runs and then kept, so a
+// select inside a loop keeps its ticker and its signal registration.
+func (g *CodeGen) selectSource(cas ir.SelectCase, selectID, caseIdx int) string {
+	id := fmt.Sprintf("%d_%d", selectID, caseIdx)
+	switch cas.Kind {
+	case ast.SelectTimer:
+		g.selectSources = append(g.selectSources, id)
+		g.writeln(fmt.Sprintf("_selectOnce%s.Do(func() { _selectSrc%s = ual.Every(int64(%s)) })", id, id, g.generateExpr(cas.Every)))
+		return "_selectSrc" + id
+	case ast.SelectSignal:
+		g.selectSources = append(g.selectSources, id)
+		g.writeln(fmt.Sprintf("_selectOnce%s.Do(func() {", id))
 		g.indent++
-		
-		// Bind value to variables if requested
-		if len(cas.Bindings) > 0 {
-			bindName := cas.Bindings[0]
-			g.writeln(fmt.Sprintf("%s := bytesToInt(_result.value)", bindName))
-			g.writeln(fmt.Sprintf("_ = %s // suppress unused warning", bindName))
-		}
-		
-		// Generate handler statements
-		for _, stmt := range cas.Handler {
-			g.generateStmt(stmt)
-		}
-		
+		g.writeln("var _err error")
+		g.writeln(fmt.Sprintf("if _selectSrc%s, _err = ual.Signals(%q); _err != nil {", id, cas.Signal))
+		g.indent++
+		g.writeln("panic(_err)")
+		g.indent--
+		g.writeln("}")
 		g.indent--
-		caseID++
+		g.writeln("})")
+		return "_selectSrc" + id
 	}
-	
-	g.writeln("}")
+	return g.stackVarName(cas.Stack)
 }
 
-// checkSelectControlFlow checks if handler contains retry() or restart()
-func (g *CodeGen) checkSelectControlFlow(stmts []ast.Stmt) (hasRetry, hasRestart bool) {
-	for _, stmt := range stmts {
-		if fc, ok := stmt.(*ast.FuncCall); ok {
-			if fc.Name == "retry" {
-				hasRetry = true
-			} else if fc.Name == "restart" {
-				hasRestart = true
-			}
-		}
+// selectCaseLabel describes a select case in generated comments
+func selectCaseLabel(cas ir.SelectCase) string {
+	switch cas.Kind {
+	case ast.SelectTimer:
+		return "every"
+	case ast.SelectSignal:
+		return "@signal " + cas.Signal
 	}
-	return
+	return "@" + cas.Stack
 }
 
-// generateSelectHandlerStmt generates a statement inside a select timeout handler
-// Replaces retry() with goto and restart() with return to outer loop
-func (g *CodeGen) generateSelectHandlerStmt(stmt ast.Stmt, selectID, caseIdx int, hasRetry, hasRestart bool) {
-	if fc, ok := stmt.(*ast.FuncCall); ok {
-		if fc.Name == "retry" {
-			g.writeln(fmt.Sprintf("goto _retry%d_%d", selectID, caseIdx))
-			return
-		} else if fc.Name == "restart" {
-			// For restart, we need to signal outer select to restart
-			// This is complex - for now, just retry the whole select
-			g.writeln(fmt.Sprintf("goto _retry%d_%d // restart (same as retry for now)", selectID, caseIdx))
-			return
-		}
+// selectBinding converts a received select value to its bound variable:
+// signal names are strings, stack elements and timer ticks are integers
+func selectBinding(cas ir.SelectCase, v string) string {
+	if cas.Kind == ast.SelectSignal {
+		return fmt.Sprintf("string(%s)", v)
 	}
-	g.generateStmt(stmt)
+	return fmt.Sprintf("bytesToInt(%s)", v)
 }
 
 // generateComputeStmt: generates the optimized compute block
@@ -1406,18 +2768,26 @@ func (g *CodeGen) generateSelectHandlerStmt(stmt ast.Stmt, selectID, caseIdx int
 //   4. Execute compute body with infix math
 //   5. Push results back
 //   6. Unlock
-func (g *CodeGen) generateComputeStmt(c *ast.ComputeStmt) {
-	stackVar := g.stackVarName(c.StackName)
+func (g *CodeGen) generateComputeStmt(cs *ast.ComputeStmt) {
+	c := ir.LowerCompute(cs)
+	stackVar := g.stackVarName(c.Stack)
 
 	// Get the stack's element type and perspective
-	elemType := g.getStackElementType(c.StackName)
+	elemType := g.getStackElementType(c.Stack)
 	goType := g.computeGoType(elemType)
-	perspective := g.perspectives[c.StackName]
+	perspective := g.perspectives[c.Stack]
 	isHash := perspective == "Hash"
+	if elemType == "struct" {
+		goType = g.structComputeType(c.Stack)
+		if len(c.Pops) > 0 {
+			g.addError(fmt.Sprintf("compute on struct stack @%s cannot use bindings; read fields with self[i].x", c.Stack))
+			return
+		}
+	}
 
 	// For Hash stacks: bindings are not allowed (no anonymous pop)
-	if isHash && len(c.Params) > 0 {
-		g.writeln(fmt.Sprintf("// ERROR: Hash perspective stack '%s' cannot use bindings in compute block", c.StackName))
+	if isHash && len(c.Pops) > 0 {
+		g.writeln(fmt.Sprintf("// ERROR: Hash perspective stack '%s' cannot use bindings in compute block", c.Stack))
 		g.writeln("// Use self.property to access named values instead")
 		g.writeln("panic(\"Hash stacks cannot use pop bindings in compute blocks\")")
 		return
@@ -1425,9 +2795,17 @@ func (g *CodeGen) generateComputeStmt(c *ast.ComputeStmt) {
 
 	// 1. Execute setup block (logistics phase)
 	if c.Setup != nil {
-		for _, stmt := range c.Setup.Ops {
-			g.generateStmt(stmt)
-		}
+		g.generateStackBlock(c.Setup)
+	}
+
+	// With -O, @dstack is a native slice: the block works on a copy of it
+	// as a stack, which replaces it afterwards
+	boxDstack := g.optimize && c.Stack == "dstack"
+	if boxDstack {
+		g.writeln("{")
+		g.indent++
+		g.writeln("stack_dstack := ual.NewStack(ual.LIFO, ual.TypeInt64)")
+		g.writeln("for _, v := range _dstack { stack_dstack.Push(intToBytes(v)) }")
 	}
 
 	// 2. Open compute closure and lock
@@ -1438,7 +2816,7 @@ func (g *CodeGen) generateComputeStmt(c *ast.ComputeStmt) {
 
 	// 3. Pop arguments into native variables (LIFO order: first binding = top of stack)
 	// Only for non-Hash stacks
-	for _, param := range c.Params {
+	for _, param := range c.Pops {
 		g.writeln(fmt.Sprintf("_bytes_%s, _err_%s := %s.PopRaw()", param, param, stackVar))
 		g.writeln(fmt.Sprintf("if _err_%s != nil { panic(_err_%s) }", param, param))
 		g.writeln(fmt.Sprintf("var %s %s = %s", param, goType, g.bytesToNative(fmt.Sprintf("_bytes_%s", param), elemType)))
@@ -1446,28 +2824,42 @@ func (g *CodeGen) generateComputeStmt(c *ast.ComputeStmt) {
 
 	// 3.5. Analyze body for self.prop[i] usages and generate views
 	memberViews := g.collectMemberIndexExprs(c.Body)
+	members := make([]string, 0, len(memberViews))
 	for member := range memberViews {
+		members = append(members, member)
+	}
+	sort.Strings(members) // in the same order every time
+	for _, member := range members {
 		// Generate unsafe.Slice view for this property
 		g.writeln(fmt.Sprintf("_raw_%s, _ok_%s := %s.GetRaw(%q)", member, member, stackVar, member))
 		g.writeln(fmt.Sprintf("if !_ok_%s { panic(\"compute: property '%s' missing\") }", member, member))
 		
 		// Map bytes to typed slice (zero-copy)
-		if goType == "float64" {
-			g.writeln(fmt.Sprintf("_ptr_%s := (*float64)(unsafe.Pointer(&_raw_%s[0]))", member, member))
-		} else {
-			g.writeln(fmt.Sprintf("_ptr_%s := (*int64)(unsafe.Pointer(&_raw_%s[0]))", member, member))
+		viewType, width := "int64", 8
+		switch goType {
+		case "float64", "uint64":
+			viewType = goType
+		case "float32", "int32", "uint32":
+			viewType, width = goType, 4
 		}
-		g.writeln(fmt.Sprintf("_view_%s := unsafe.Slice(_ptr_%s, len(_raw_%s)/8)", member, member, member))
+		g.writeln(fmt.Sprintf("_ptr_%s := (*%s)(unsafe.Pointer(&_raw_%s[0]))", member, viewType, member))
+		g.writeln(fmt.Sprintf("_view_%s := unsafe.Slice(_ptr_%s, len(_raw_%s)/%d)", member, member, member, width))
 	}
 
 	// 4. Generate compute body statements
 	// Pass perspective info for return handling
 	for _, stmt := range c.Body {
-		g.generateComputeBodyStmtWithPerspective(stmt, c.StackName, elemType, goType, isHash)
+		g.generateComputeBodyStmtWithPerspective(stmt, c.Stack, elemType, goType, isHash)
 	}
 
 	g.indent--
 	g.writeln("}()")
+	if boxDstack {
+		g.writeln("_dstack = _dstack[:0]")
+		g.writeln("for i := 0; i < stack_dstack.Len(); i++ { v, _ := stack_dstack.PeekAt(i); _push(bytesToInt(v)) }")
+		g.indent--
+		g.writeln("}")
+	}
 }
 
 // collectMemberIndexExprs analyzes the AST and returns unique property names accessed via self.prop[i]
@@ -1510,6 +2902,12 @@ func (g *CodeGen) collectMemberIndexExprsStmt(stmt ast.Stmt, result map[string]b
 		for _, bodyStmt := range s.Body {
 			g.collectMemberIndexExprsStmt(bodyStmt, result)
 		}
+	case *ast.RangeStmt:
+		g.collectMemberIndexExprsExpr(s.Start, result)
+		g.collectMemberIndexExprsExpr(s.End, result)
+		for _, bodyStmt := range s.Body {
+			g.collectMemberIndexExprsStmt(bodyStmt, result)
+		}
 	case *ast.ExprStmt:
 		g.collectMemberIndexExprsExpr(s.Expr, result)
 	}
@@ -1574,6 +2972,19 @@ func (g *CodeGen) generateComputeBodyStmtWithPerspective(stmt ast.Stmt, stackNam
 		// return a, b  ->  push each value
 		// For Hash stacks: use SetRaw with "__result_N__" keys
 		for i, val := range s.Values {
+			if elemType == "struct" {
+				// return {x: a, y: b}  ->  push a packed struct
+				packed, ok := g.packComputeStruct(val, stackName, goType)
+				if !ok {
+					return
+				}
+				if isHash {
+					g.writeln(fmt.Sprintf("%s.SetRaw(%q, %s) // compute result", stackVar, fmt.Sprintf("__result_%d__", i), packed))
+				} else {
+					g.writeln(fmt.Sprintf("%s.PushRaw(%s)", stackVar, packed))
+				}
+				continue
+			}
 			exprStr := g.generateComputeExpr(val, stackName, elemType, goType)
 			if isHash {
 				key := fmt.Sprintf("__result_%d__", i)
@@ -1612,6 +3023,20 @@ func (g *CodeGen) generateComputeBodyStmtWithPerspective(stmt ast.Stmt, stackNam
 		g.indent--
 		g.writeln("}")
 
+	case *ast.RangeStmt:
+		// for i in a..b  ->  count in int64, i holds the count as goType
+		startStr := g.generateComputeExpr(s.Start, stackName, elemType, goType)
+		endStr := g.generateComputeExpr(s.End, stackName, elemType, goType)
+		g.writeln(fmt.Sprintf("for _rangeI, _rangeEnd := int64(%s), int64(%s); _rangeI < _rangeEnd; _rangeI++ {", startStr, endStr))
+		g.indent++
+		g.writeln(fmt.Sprintf("%s := %s(_rangeI)", s.Var, goType))
+		g.writeln(fmt.Sprintf("_ = %s", s.Var))
+		for _, bodyStmt := range s.Body {
+			g.generateComputeBodyStmtWithPerspective(bodyStmt, stackName, elemType, goType, isHash)
+		}
+		g.indent--
+		g.writeln("}")
+
 	case *ast.ExprStmt:
 		g.writeln(fmt.Sprintf("_ = %s", g.generateComputeExpr(s.Expr, stackName, elemType, goType)))
 
@@ -1631,12 +3056,7 @@ func (g *CodeGen) generateComputeExpr(expr ast.Expr, stackName, elemType, goType
 		return fmt.Sprintf("%d", e.Value)
 
 	case *ast.FloatLit:
-		// Always format with decimal to ensure Go sees it as float64
-		s := fmt.Sprintf("%v", e.Value)
-		if !strings.Contains(s, ".") && !strings.Contains(s, "e") {
-			s = s + ".0"
-		}
-		return s
+		return goFloat(e.Value)
 
 	case *ast.StringLit:
 		return fmt.Sprintf("%q", e.Value)
@@ -1663,6 +3083,9 @@ func (g *CodeGen) generateComputeExpr(expr ast.Expr, stackName, elemType, goType
 
 	case *ast.IndexExpr:
 		indexCode := g.generateComputeExpr(e.Index, stackName, elemType, goType)
+		if e.Target == "self" && (e.Field != "" || elemType == "struct") {
+			return g.generateStructFieldRead(e, stackName, elemType, goType, indexCode)
+		}
 		if e.Target == "self" {
 			// self[i] -> lookup from stack's indexed storage
 			stackVar := g.stackVarName(stackName)
@@ -1672,6 +3095,10 @@ func (g *CodeGen) generateComputeExpr(expr ast.Expr, stackName, elemType, goType
 		// buf[i] -> direct local array access
 		return fmt.Sprintf("%s[int(%s)]", e.Target, indexCode)
 
+	case *ast.RecordLit:
+		g.addError("a record can only be returned from a compute block on a struct stack")
+		return "0"
+
 	case *ast.BinaryExpr:
 		left := g.generateComputeExpr(e.Left, stackName, elemType, goType)
 		right := g.generateComputeExpr(e.Right, stackName, elemType, goType)
@@ -1715,6 +3142,13 @@ func (g *CodeGen) generateComputeExpr(expr ast.Expr, stackName, elemType, goType
 		for i, arg := range e.Args {
 			args[i] = g.generateComputeExpr(arg, stackName, elemType, goType)
 		}
+		if goType == "float32" && strings.HasPrefix(fn, "math.") {
+			// The math package works in float64
+			for i := range args {
+				args[i] = "float64(" + args[i] + ")"
+			}
+			return fmt.Sprintf("float32(%s(%s))", fn, strings.Join(args, ", "))
+		}
 		return fmt.Sprintf("%s(%s)", fn, strings.Join(args, ", "))
 
 	default:
@@ -1723,6 +3157,101 @@ func (g *CodeGen) generateComputeExpr(expr ast.Expr, stackName, elemType, goType
 	}
 }
 
+// structComputeType is the type a compute block on a struct stack works
+// in: float64 if any field is f64, else int64
+func (g *CodeGen) structComputeType(name string) string {
+	for _, f := range g.structs[name] {
+		if f.Type == "f64" {
+			return "float64"
+		}
+	}
+	return "int64"
+}
+
+// generateStructFieldRead generates self[i].x, decoding the field straight
+// from the packed element
+func (g *CodeGen) generateStructFieldRead(e *ast.IndexExpr, stackName, elemType, goType, indexCode string) string {
+	if elemType != "struct" {
+		g.addError(fmt.Sprintf("self[i].%s: @%s does not hold structs", e.Field, stackName))
+		return "0"
+	}
+	fields := g.structs[stackName]
+	if e.Field == "" {
+		g.addError(fmt.Sprintf("@%s holds structs; read a field, as in self[i].%s", stackName, fields[0].Name))
+		return "0"
+	}
+	idx := structFieldIndex(fields, e.Field)
+	if idx < 0 {
+		g.addError(fmt.Sprintf("@%s has no field %s", stackName, e.Field))
+		return "0"
+	}
+	off := 0
+	for _, f := range fields[:idx] {
+		off += structFieldWidth(f.Type)
+	}
+	var read string
+	switch fields[idx].Type {
+	case "f64":
+		read = fmt.Sprintf("bytesToFloat(_b[%d:%d])", off, off+8)
+	case "bool":
+		read = fmt.Sprintf("int64(_b[%d])", off)
+	default:
+		read = fmt.Sprintf("bytesToInt(_b[%d:%d])", off, off+8)
+	}
+	return fmt.Sprintf("func() %s { _b, _ok := %s.GetAtRaw(int(%s)); if !_ok { panic(\"compute: index out of bounds\") }; return %s(%s) }()",
+		goType, g.stackVarName(stackName), indexCode, goType, read)
+}
+
+func structFieldWidth(typ string) int {
+	if typ == "bool" {
+		return 1
+	}
+	return 8
+}
+
+// packComputeStruct generates the packed bytes of a record returned by a
+// compute block on a struct stack
+func (g *CodeGen) packComputeStruct(val ast.Expr, stackName, goType string) (string, bool) {
+	rec, ok := val.(*ast.RecordLit)
+	if !ok {
+		g.addError(fmt.Sprintf("compute on struct stack @%s must return a record such as {x: a, y: b}", stackName))
+		return "", false
+	}
+	fields := g.structs[stackName]
+	vals := make([]string, len(fields))
+	for i, f := range fields {
+		vals[i] = g.wrapValue(structZero(f.Type), f.Type)
+	}
+	for i, name := range rec.Names {
+		idx := structFieldIndex(fields, name)
+		if idx < 0 {
+			g.addError(fmt.Sprintf("@%s has no field %s", stackName, name))
+			return "", false
+		}
+		expr := g.generateComputeExpr(rec.Values[i], stackName, "struct", goType)
+		switch fields[idx].Type {
+		case "f64":
+			vals[idx] = fmt.Sprintf("floatToBytes(float64(%s))", expr)
+		case "bool":
+			if b, ok := rec.Values[i].(*ast.BinaryExpr); !ok || !isBoolOp(b.Op) {
+				expr = fmt.Sprintf("(%s) != 0", expr)
+			}
+			vals[idx] = g.wrapValue(expr, "bool")
+		default:
+			vals[idx] = fmt.Sprintf("intToBytes(int64(%s))", expr)
+		}
+	}
+	return fmt.Sprintf("_struct_%s.Pack(%s)", stackName, strings.Join(vals, ", ")), true
+}
+
+func isBoolOp(op string) bool {
+	switch op {
+	case "==", "!=", "<", ">", "<=", ">=", "&&", "||":
+		return true
+	}
+	return false
+}
+
 // computeGoType: returns the Go type for compute block variables
 func (g *CodeGen) computeGoType(elemType string) string {
 	switch elemType {
@@ -1732,6 +3261,10 @@ func (g *CodeGen) computeGoType(elemType string) string {
 		return "int64"
 	case "i32", "int32":
 		return "int32"
+	case "u32", "uint32":
+		return "uint32"
+	case "f32", "float32":
+		return "float32"
 	case "u64", "uint64":
 		return "uint64"
 	default:
@@ -1739,6 +3272,20 @@ func (g *CodeGen) computeGoType(elemType string) string {
 	}
 }
 
+// readVarSlot reads a stack-backed variable from its type stack slot.
+// Integer and bool variables read as int64; strings and floats decode to
+// their own Go type.
+func (g *CodeGen) readVarSlot(sym *Symbol) string {
+	switch TypeStack(sym.Type) {
+	case "string":
+		return fmt.Sprintf("func() string { v, _ := %s.PeekAt(%d); return string(v) }()", sym.Slot(), sym.Index)
+	case "f64":
+		return fmt.Sprintf("func() float64 { v, _ := %s.PeekAt(%d); return bytesToFloat(v) }()", sym.Slot(), sym.Index)
+	}
+	return fmt.Sprintf("func() int64 { v, _ := %s.PeekAt(%d); return bytesToInt(v) }()",
+		sym.Slot(), sym.Index)
+}
+
 // bytesToNative: generates conversion from []byte to native type
 func (g *CodeGen) bytesToNative(bytesVar, elemType string) string {
 	switch elemType {
@@ -1748,6 +3295,10 @@ func (g *CodeGen) bytesToNative(bytesVar, elemType string) string {
 		return fmt.Sprintf("bytesToInt(%s)", bytesVar)
 	case "i32", "int32":
 		return fmt.Sprintf("int32(bytesToInt(%s))", bytesVar)
+	case "u32", "uint32":
+		return fmt.Sprintf("uint32(bytesToInt(%s))", bytesVar)
+	case "f32", "float32":
+		return fmt.Sprintf("bytesToFloat32(%s)", bytesVar)
 	case "u64", "uint64":
 		return fmt.Sprintf("uint64(bytesToInt(%s))", bytesVar)
 	default:
@@ -1755,6 +3306,30 @@ func (g *CodeGen) bytesToNative(bytesVar, elemType string) string {
 	}
 }
 
+// nativeVarFromBytes returns the conversion of bytesVar, an element popped
+// from a stack, to the Go type of a native variable of type typ
+func (g *CodeGen) nativeVarFromBytes(bytesVar, typ string) string {
+	switch typ {
+	case "i64":
+		return fmt.Sprintf("bytesToInt(%s)", bytesVar)
+	case "u64":
+		return fmt.Sprintf("bytesToUint(%s, 64)", bytesVar)
+	case "u8", "u16", "u32":
+		return fmt.Sprintf("%s(bytesToUint(%s, %d))", g.goType(typ), bytesVar, uintBits(typ))
+	case "f64":
+		return fmt.Sprintf("bytesToFloat(%s)", bytesVar)
+	case "f32":
+		return fmt.Sprintf("bytesToFloat32(%s)", bytesVar)
+	case "string":
+		return fmt.Sprintf("string(%s)", bytesVar)
+	case "bool":
+		return fmt.Sprintf("bytesToBool(%s)", bytesVar)
+	case "bytes":
+		return bytesVar
+	}
+	return fmt.Sprintf("%s(bytesToInt(%s))", g.goType(typ), bytesVar)
+}
+
 // nativeToBytes: generates conversion from native type to []byte
 func (g *CodeGen) nativeToBytes(varName, elemType string) string {
 	switch elemType {
@@ -1762,8 +3337,12 @@ func (g *CodeGen) nativeToBytes(varName, elemType string) string {
 		return fmt.Sprintf("floatToBytes(%s)", varName)
 	case "i64", "int64":
 		return fmt.Sprintf("intToBytes(%s)", varName)
-	case "i32", "int32":
-		return fmt.Sprintf("intToBytes(int64(%s))", varName)
+	case "i32", "int32": // compact stacks hold 4 bytes
+		return fmt.Sprintf("uint32ToBytes(uint32(%s))", varName)
+	case "u32", "uint32":
+		return fmt.Sprintf("uint32ToBytes(%s)", varName)
+	case "f32", "float32":
+		return fmt.Sprintf("float32ToBytes(%s)", varName)
 	case "u64", "uint64":
 		return fmt.Sprintf("uintToBytes(%s)", varName)
 	default:
@@ -1787,6 +3366,15 @@ func (g *CodeGen) getStackElementType(stackName string) string {
 	return "f64"
 }
 
+// dstackPush returns the statement pushing v, an encoded integer, onto
+// @dstack, which is a native slice with -O
+func (g *CodeGen) dstackPush(v string) string {
+	if g.optimize {
+		return fmt.Sprintf("_push(bytesToInt(%s))", v)
+	}
+	return fmt.Sprintf("stack_dstack.Push(%s)", v)
+}
+
 func (g *CodeGen) generateErrorPush(e *ast.ErrorPush) {
 	// Push error message to @error stack
 	msg := g.generateExprValue(e.Message)
@@ -1799,6 +3387,23 @@ func (g *CodeGen) generateSpawnPush(s *ast.SpawnPush) {
 	g.writeln("spawn_mu.Lock()")
 	g.writeln("spawn_tasks = append(spawn_tasks, func() {")
 	g.indent++
+	if g.crashDump != "" {
+		g.writeln("defer ual.CrashGuard()")
+	}
+	
+	// into @results: the body becomes a function returning the pushed value
+	var resultsVar string
+	savedResultType := g.spawnResultType
+	g.spawnResultType = ""
+	if s.Into != "" {
+		if _, ok := g.stacks[s.Into]; !ok {
+			g.addError(fmt.Sprintf("@spawn into: undefined stack @%s", s.Into))
+		}
+		resultsVar = g.stackVarName(s.Into)
+		g.spawnResultType = g.getStackElementType(s.Into)
+		g.writeln("ual.RunForResult(func() ([]byte, bool) {")
+		g.indent++
+	}
 	
 	// Create local operational stacks for this goroutine (shadows global ones)
 	// This prevents race conditions when multiple goroutines use dstack/rstack
@@ -1807,11 +3412,12 @@ func (g *CodeGen) generateSpawnPush(s *ast.SpawnPush) {
 	g.writeln("_ = stack_dstack")
 	g.writeln("_ = stack_rstack")
 	
-	// Enter new scope for spawn-local variables
+	// Enter new scope for spawn-local variables. With -O the body still
+	// uses the local @dstack above, not the shared native one.
 	g.symbols.Enter()
-	savedInSpawn := g.inSpawnBlock
+	savedInSpawn, savedOptimize := g.inSpawnBlock, g.optimize
 	savedLocalStacks := g.spawnLocalStacks
-	g.inSpawnBlock = true
+	g.inSpawnBlock, g.optimize = true, false
 	g.spawnLocalStacks = make(map[string]string) // Fresh map for this spawn block
 	
 	// Generate body statements
@@ -1821,14 +3427,32 @@ func (g *CodeGen) generateSpawnPush(s *ast.SpawnPush) {
 	
 	// Exit spawn scope
 	g.spawnLocalStacks = savedLocalStacks
-	g.inSpawnBlock = savedInSpawn
+	g.inSpawnBlock, g.optimize = savedInSpawn, savedOptimize
 	g.symbols.Exit()
 	
+	if s.Into != "" {
+		if n := len(s.Body); n == 0 || !isReturnStmt(s.Body[n-1]) {
+			g.writeln("return nil, false")
+		}
+		g.indent--
+		errStack := "stack_error"
+		if g.noForth {
+			errStack = "nil"
+		}
+		g.writeln(fmt.Sprintf("}, %s, %s)", resultsVar, errStack))
+	}
+	g.spawnResultType = savedResultType
+	
 	g.indent--
 	g.writeln("})")
 	g.writeln("spawn_mu.Unlock()")
 }
 
+func isReturnStmt(s ast.Stmt) bool {
+	_, ok := s.(*ast.ReturnStmt)
+	return ok
+}
+
 func (g *CodeGen) generateSpawnOp(s *ast.SpawnOp) {
 	switch s.Op {
 	case "peek":
@@ -1839,7 +3463,8 @@ func (g *CodeGen) generateSpawnOp(s *ast.SpawnOp) {
 			g.indent++
 			g.writeln("_task := spawn_tasks[len(spawn_tasks)-1]")
 			g.writeln("spawn_mu.Unlock()")
-			g.writeln("go _task()")
+			g.writeln("spawn_group.Add(1)")
+			g.writeln("spawn_sched.Submit(func() { defer spawn_group.Done(); _task() })")
 			g.indent--
 			g.writeln("} else {")
 			g.indent++
@@ -1860,7 +3485,8 @@ func (g *CodeGen) generateSpawnOp(s *ast.SpawnOp) {
 			g.writeln("_task := spawn_tasks[len(spawn_tasks)-1]")
 			g.writeln("spawn_tasks = spawn_tasks[:len(spawn_tasks)-1]")
 			g.writeln("spawn_mu.Unlock()")
-			g.writeln("go _task()")
+			g.writeln("spawn_group.Add(1)")
+			g.writeln("spawn_sched.Submit(func() { defer spawn_group.Done(); _task() })")
 			g.indent--
 			g.writeln("} else {")
 			g.indent++
@@ -1881,7 +3507,7 @@ func (g *CodeGen) generateSpawnOp(s *ast.SpawnOp) {
 	case "len":
 		// @spawn len — push length to dstack
 		g.writeln("spawn_mu.Lock()")
-		g.writeln("stack_dstack.Push(intToBytes(int64(len(spawn_tasks))))")
+		g.writeln(g.dstackPush("intToBytes(int64(len(spawn_tasks)))"))
 		g.writeln("spawn_mu.Unlock()")
 		
 	case "clear":
@@ -1889,6 +3515,20 @@ func (g *CodeGen) generateSpawnOp(s *ast.SpawnOp) {
 		g.writeln("spawn_mu.Lock()")
 		g.writeln("spawn_tasks = spawn_tasks[:0]")
 		g.writeln("spawn_mu.Unlock()")
+		
+	case "wait":
+		// @spawn wait — block until played tasks finish; wait(ms) gives up
+		// after a timeout with the "timeout" status and the tasks left
+		if len(s.Args) == 0 {
+			g.writeln("spawn_group.Wait()")
+			break
+		}
+		g.writeln(fmt.Sprintf("if err := spawn_group.Wait(int64(%s)); err != nil {", g.generateExprValue(s.Args[0])))
+		g.indent++
+		g.writeln("_consider_status = \"timeout\"")
+		g.writeln("_consider_value = int64(spawn_group.Pending())")
+		g.indent--
+		g.writeln("}")
 	}
 }
 
@@ -1930,65 +3570,148 @@ func (g *CodeGen) generateExprValue(expr ast.Expr) string {
 	case *ast.IntLit:
 		return fmt.Sprintf("%d", e.Value)
 	case *ast.FloatLit:
-		return fmt.Sprintf("%f", e.Value)
+		return goFloat(e.Value)
 	case *ast.StringLit:
 		return fmt.Sprintf("%q", e.Value)
+	case *ast.BoolLit:
+		return strconv.FormatBool(e.Value)
 	case *ast.Ident:
 		// Check if it's a variable
 		if sym := g.symbols.Lookup(e.Name); sym != nil {
 			if sym.Native {
-				return fmt.Sprintf("var_%s", e.Name)
+				return g.readNative(sym)
 			}
-			typeStack := TypeStack(sym.Type)
-			return fmt.Sprintf("func() int64 { v, _ := stack_%s.PeekAt(%d); return bytesToInt(v) }()", 
-				typeStack, sym.Index)
+			return g.readVarSlot(sym)
 		}
 		return e.Name
+	case *ast.InterpString:
+		return g.generateSprintf(e.Parts, g.generateExprValue)
 	case *ast.BinaryOp:
+		// "total: " + n + " items" formats like "total: ${n} items"
+		if parts, ok := concatParts(e); ok {
+			return g.generateSprintf(parts, g.generateExprValue)
+		}
 		left := g.generateExprValue(e.Left)
 		right := g.generateExprValue(e.Right)
-		// Handle string concatenation: "str" + var -> "str" + fmt.Sprint(var)
-		if e.Op == "+" {
-			_, leftIsStr := e.Left.(*ast.StringLit)
-			_, rightIsStr := e.Right.(*ast.StringLit)
-			if leftIsStr && !rightIsStr {
-				return fmt.Sprintf("(%s + fmt.Sprint(%s))", left, right)
-			}
-			if rightIsStr && !leftIsStr {
-				return fmt.Sprintf("(fmt.Sprint(%s) + %s)", left, right)
-			}
-		}
 		return fmt.Sprintf("(%s %s %s)", left, e.Op, right)
 	case *ast.UnaryExpr:
 		operand := g.generateExprValue(e.Operand)
 		return fmt.Sprintf("(%s%s)", e.Op, operand)
 	case *ast.FuncCall:
-		var args []string
-		for _, arg := range e.Args {
-			args = append(args, g.generateExprValue(arg))
+		if code, ok := g.generateBuiltinExpr(e); ok {
+			return code
 		}
+		args := g.callArgs(e.Name, e.Args, g.generateExprValue)
 		return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ", "))
+	case *ast.StackExpr:
+		return g.generateStackExpr(e)
+	case *ast.ViewExpr:
+		return g.generateViewExpr(e)
+	case *ast.FnLit:
+		return g.generateFnValue(e)
+	case *ast.StackRef:
+		return g.stackVarName(e.Name)
 	default:
 		return "0"
 	}
 }
 
+// generateSprintf returns a fmt.Sprintf call that formats parts, string
+// literals as written and other expressions with %v, each generated by gen
+func (g *CodeGen) generateSprintf(parts []ast.Expr, gen func(ast.Expr) string) string {
+	var format, text strings.Builder
+	var args []string
+	for _, part := range parts {
+		if lit, ok := part.(*ast.StringLit); ok {
+			format.WriteString(strings.ReplaceAll(lit.Value, "%", "%%"))
+			text.WriteString(lit.Value)
+			continue
+		}
+		format.WriteString("%v")
+		args = append(args, gen(part))
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("%q", text.String())
+	}
+	return fmt.Sprintf("fmt.Sprintf(%q, %s)", format.String(), strings.Join(args, ", "))
+}
+
+// concatParts flattens a chain of + that joins strings, as in
+// "total: " + n + " items", into the parts of an interpolated string. The
+// operands before the first string are added as numbers, so 1 + 2 + "x"
+// is "3x". It reports false if no operand is a string literal.
+func concatParts(e ast.Expr) ([]ast.Expr, bool) {
+	b, ok := e.(*ast.BinaryOp)
+	if !ok || b.Op != "+" {
+		if isStringExpr(e) {
+			return stringParts(e), true
+		}
+		return nil, false
+	}
+	if left, ok := concatParts(b.Left); ok {
+		return append(left, stringParts(b.Right)...), true
+	}
+	if isStringExpr(b.Right) {
+		return append([]ast.Expr{b.Left}, stringParts(b.Right)...), true
+	}
+	return nil, false
+}
+
+// isStringExpr reports whether e is a string literal, plain or interpolated
+func isStringExpr(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.StringLit, *ast.InterpString:
+		return true
+	}
+	return false
+}
+
+// stringParts returns the parts of an interpolated string, or e alone
+func stringParts(e ast.Expr) []ast.Expr {
+	if s, ok := e.(*ast.InterpString); ok {
+		return s.Parts
+	}
+	return []ast.Expr{e}
+}
+
 func (g *CodeGen) generateCondition(cond ast.Expr) string {
 	switch c := cond.(type) {
 	case *ast.BinaryExpr:
+		if c.Op == "&&" || c.Op == "||" {
+			return fmt.Sprintf("(%s) %s (%s)", g.generateCondition(c.Left), c.Op, g.generateCondition(c.Right))
+		}
 		left := g.generateCondExpr(c.Left)
 		right := g.generateCondExpr(c.Right)
 		return fmt.Sprintf("%s %s %s", left, c.Op, right)
 	case *ast.Ident:
 		// Truthy check - look up variable
 		if sym := g.symbols.Lookup(c.Name); sym != nil {
-			typeStack := TypeStack(sym.Type)
-			return fmt.Sprintf("func() bool { v, _ := stack_%s.PeekAt(%d); return bytesToInt(v) != 0 }()", 
-				typeStack, sym.Index)
+			if sym.Native && sym.Type == "bool" {
+				return fmt.Sprintf("var_%s", c.Name)
+			} else if sym.Native {
+				return fmt.Sprintf("var_%s != 0", c.Name)
+			}
+			return fmt.Sprintf("func() bool { v, _ := %s.PeekAt(%d); return bytesToInt(v) != 0 }()", 
+				sym.Slot(), sym.Index)
 		}
 		return "false"
+	case *ast.UnaryExpr:
+		if c.Op == "!" {
+			return fmt.Sprintf("!(%s)", g.generateCondition(c.Operand))
+		}
+		return fmt.Sprintf("%s != 0", g.generateExprValue(c))
 	case *ast.IntLit:
 		return fmt.Sprintf("%d != 0", c.Value)
+	case *ast.FuncCall:
+		if c.Name == "is_tty" || c.Name == "confirm" || c.Name == "writefile" || c.Name == "appendfile" || c.Name == "exists" || c.Name == "setenv" || c.Name == "write_csv" || c.Name == "listen" || g.boolFuncs[c.Name] {
+			return g.generateExprValue(c)
+		}
+		return fmt.Sprintf("%s != 0", g.generateExprValue(c))
+	case *ast.StackExpr:
+		if c.Op == "has" {
+			return g.generateStackExpr(c)
+		}
+		return fmt.Sprintf("%s != 0", g.generateStackExpr(c))
 	default:
 		return "true"
 	}
@@ -1999,7 +3722,7 @@ func (g *CodeGen) generateCondExpr(expr ast.Expr) string {
 	case *ast.IntLit:
 		return fmt.Sprintf("int64(%d)", e.Value)
 	case *ast.FloatLit:
-		return fmt.Sprintf("%f", e.Value)
+		return goFloat(e.Value)
 	case *ast.StringLit:
 		return fmt.Sprintf("%q", e.Value)
 	case *ast.UnaryExpr:
@@ -2007,6 +3730,9 @@ func (g *CodeGen) generateCondExpr(expr ast.Expr) string {
 		operand := g.generateCondExpr(e.Operand)
 		return fmt.Sprintf("(%s%s)", e.Op, operand)
 	case *ast.BinaryOp:
+		if parts, ok := concatParts(e); ok {
+			return g.generateSprintf(parts, g.generateExprValue)
+		}
 		// Handle arithmetic expressions like scrH - 3
 		left := g.generateCondExpr(e.Left)
 		right := g.generateCondExpr(e.Right)
@@ -2014,15 +3740,15 @@ func (g *CodeGen) generateCondExpr(expr ast.Expr) string {
 	case *ast.Ident:
 		if sym := g.symbols.Lookup(e.Name); sym != nil {
 			if sym.Native {
-				return fmt.Sprintf("var_%s", e.Name)
+				return g.readNative(sym)
 			}
-			typeStack := TypeStack(sym.Type)
-			return fmt.Sprintf("func() int64 { v, _ := stack_%s.PeekAt(%d); return bytesToInt(v) }()", 
-				typeStack, sym.Index)
+			return g.readVarSlot(sym)
 		}
 		return "0"
+	case *ast.StackExpr:
+		return g.generateStackExpr(e)
 	default:
-		return "0"
+		return g.generateExprValue(e)
 	}
 }
 
@@ -2032,10 +3758,39 @@ func (g *CodeGen) inferType(expr ast.Expr) string {
 		return "i64"
 	case *ast.FloatLit:
 		return "f64"
-	case *ast.StringLit:
+	case *ast.StringLit, *ast.InterpString:
 		return "string"
 	case *ast.BoolLit:
 		return "bool"
+	case *ast.BinaryOp:
+		if _, ok := concatParts(e); ok {
+			return "string"
+		}
+		return "i64"
+	case *ast.FuncCall:
+		switch e.Name {
+		case "render", "color", "prompt", "password", "uuid4", "ulid", "format_int", "format_float", "format", "readfile", "readline", "env":
+			return "string"
+		case "is_tty", "confirm", "writefile", "appendfile", "exists", "setenv", "write_csv", "listen":
+			return "bool"
+		case "rand":
+			return "f64"
+		}
+		return "i64"
+	case *ast.StackExpr:
+		if e.Op == "to_json" {
+			return "string"
+		}
+		if e.Op == "get" {
+			// The element's type, as the value a variable can hold
+			switch g.getStackElementType(e.Stack) {
+			case "string", "bytes":
+				return "string"
+			case "f64", "f32":
+				return "f64"
+			}
+		}
+		return "i64"
 	case *ast.UnaryExpr:
 		// For unary minus, the type is the operand's type
 		return g.inferType(e.Operand)
@@ -2050,6 +3805,20 @@ func (g *CodeGen) inferType(expr ast.Expr) string {
 	}
 }
 
+// freezeModeConst returns the runtime constant for a freeze("...") mode
+func freezeModeConst(arg ast.Expr) (string, bool) {
+	lit, ok := arg.(*ast.StringLit)
+	if !ok {
+		return "", false
+	}
+	mode, ok := map[string]string{
+		"all":         "FreezeAll",
+		"structure":   "FreezeStructure",
+		"append-only": "AppendOnly",
+	}[lit.Value]
+	return mode, ok
+}
+
 // isFloatType returns true for float types
 func isFloatType(t string) bool {
 	return t == "f64" || t == "f32"
@@ -2061,6 +3830,55 @@ func isIntType(t string) bool {
 		t == "u64" || t == "u32" || t == "u16" || t == "u8"
 }
 
+// generatePrintArg returns arg as print and println print it: values
+// popped or peeked from unsigned stacks, and unsigned variables, print
+// without a sign
+func (g *CodeGen) generatePrintArg(arg ast.Expr) string {
+	switch e := arg.(type) {
+	case *ast.StackExpr:
+		if bits := uintBits(g.getStackElementType(e.Stack)); bits > 0 && (e.Op == "pop" || e.Op == "peek") {
+			op := "Pop"
+			if e.Op == "peek" {
+				op = "Peek"
+			}
+			return fmt.Sprintf("func() uint64 { v, _ := %s.%s(); return bytesToUint(v, %d) }()", g.stackVarName(e.Stack), op, bits)
+		}
+		// Floats print as floats, f32 ones with the digits an f32 needs
+		if t := g.getStackElementType(e.Stack); (t == "f64" || t == "f32") && (e.Op == "pop" || e.Op == "peek") {
+			op := "Pop"
+			if e.Op == "peek" {
+				op = "Peek"
+			}
+			if t == "f32" {
+				return fmt.Sprintf("func() float32 { v, _ := %s.%s(); return bytesToFloat32(v) }()", g.stackVarName(e.Stack), op)
+			}
+			return fmt.Sprintf("func() float64 { v, _ := %s.%s(); return bytesToFloat(v) }()", g.stackVarName(e.Stack), op)
+		}
+	case *ast.Ident:
+		if sym := g.symbols.Lookup(e.Name); sym != nil && uintBits(sym.Type) > 0 {
+			return fmt.Sprintf("uint64(%s)", g.generateExprValue(e))
+		} else if sym != nil && sym.Type == "f32" {
+			return fmt.Sprintf("float32(%s)", g.generateExprValue(e))
+		}
+	}
+	return g.generateExprValue(arg)
+}
+
+// uintBits returns the width of an unsigned type, or 0 for any other type
+func uintBits(t string) uint {
+	switch t {
+	case "u8":
+		return 8
+	case "u16":
+		return 16
+	case "u32":
+		return 32
+	case "u64":
+		return 64
+	}
+	return 0
+}
+
 // isNumericType returns true for numeric types
 func isNumericType(t string) bool {
 	return isIntType(t) || isFloatType(t)
@@ -2167,14 +3985,38 @@ func (g *CodeGen) goType(typ string) string {
 	}
 }
 
+// readNative reads a native variable in an expression. Integers of other
+// widths read as int64 and f32 as float64, as the type stacks hold them,
+// so arithmetic on them does not wrap or round until the result is stored.
+func (g *CodeGen) readNative(sym *Symbol) string {
+	if isIntType(sym.Type) && sym.Type != "i64" {
+		return fmt.Sprintf("int64(var_%s)", sym.Name)
+	}
+	if sym.Type == "f32" {
+		return fmt.Sprintf("float64(var_%s)", sym.Name)
+	}
+	return "var_" + sym.Name
+}
+
+// nativeValue converts value to the Go type of a native variable of type
+// typ. Unsigned conversions wrap at run time, as -1 in a u8 gives 255.
+func (g *CodeGen) nativeValue(value, typ string) string {
+	if uintBits(typ) > 0 {
+		return fmt.Sprintf("%s(uintOf(int64(%s)))", g.goType(typ), value)
+	}
+	return fmt.Sprintf("%s(%s)", g.goType(typ), value)
+}
+
 func (g *CodeGen) wrapValueForType(value string, typ string) string {
 	switch typ {
 	case "i64", "i32", "i16", "i8":
 		return fmt.Sprintf("intToBytes(int64(%s))", value)
 	case "u64", "u32", "u16", "u8":
-		return fmt.Sprintf("uintToBytes(uint64(%s))", value)
-	case "f64", "f32":
-		return fmt.Sprintf("floatToBytes(%s)", value)
+		return fmt.Sprintf("wrapUint(int64(%s), %d)", value, uintBits(typ))
+	case "f64":
+		return fmt.Sprintf("floatToBytes(float64(%s))", value)
+	case "f32":
+		return fmt.Sprintf("floatToBytes(float64(float32(%s)))", value)
 	case "string":
 		return fmt.Sprintf("[]byte(%s)", value)
 	case "bool":
@@ -2192,6 +4034,22 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 	
 	// Check if we're using native dstack in optimized mode
 	nativeDstack := g.optimize && s.Stack == "dstack"
+	if nativeDstack && g.generateTOSOp(s) {
+		return
+	}
+	
+	// Unsigned stacks wrap arithmetic to their width
+	if bits := uintBits(g.stacks[s.Stack]); bits > 0 && !nativeDstack {
+		if g.generateUnsignedOp(s, stackVar, bits) {
+			return
+		}
+	}
+	// Float stacks do arithmetic on the values their bytes encode
+	if t := g.stacks[s.Stack]; (t == "f64" || t == "f32") && !nativeDstack {
+		if g.generateFloatOp(s, stackVar, t) {
+			return
+		}
+	}
 	
 	switch s.Op {
 	case "push":
@@ -2199,6 +4057,19 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			// Get target stack element type
 			elemType := g.stacks[s.Stack]
 			
+			if elemType == "struct" {
+				for _, arg := range s.Args {
+					if packed, ok := g.packStruct(s.Stack, arg); ok {
+						g.writeln(fmt.Sprintf("%s.Push(%s)", stackVar, packed))
+					}
+				}
+				return
+			}
+			if _, ok := s.Args[0].(*ast.RecordLit); ok {
+				g.addError(fmt.Sprintf("cannot push a record to @%s (%s stack)", s.Stack, elemType))
+				return
+			}
+			
 			// Check if pushing a variable
 			if ident, ok := s.Args[0].(*ast.Ident); ok {
 				if sym := g.symbols.Lookup(ident.Name); sym != nil {
@@ -2211,34 +4082,42 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 					}
 					
 					if sym.Native && nativeDstack {
-						// Native var to native dstack
-						g.writeln(fmt.Sprintf("_push(var_%s)", ident.Name))
+						// Native var to native dstack, which holds int64
+						if sym.Type == "i64" {
+							g.writeln(fmt.Sprintf("_push(var_%s)", ident.Name))
+						} else {
+							g.writeln(fmt.Sprintf("_push(int64(var_%s))", ident.Name))
+						}
 						return
 					} else if sym.Native {
 						// Native var to user stack - generate appropriate conversion
-						if isFloatType(elemType) && isIntType(sym.Type) {
+						if bits := uintBits(elemType); bits > 0 {
+							g.writeln(fmt.Sprintf("%s.Push(wrapUint(int64(var_%s), %d))", stackVar, ident.Name, bits))
+						} else if isFloatType(elemType) {
 							g.writeln(fmt.Sprintf("%s.Push(floatToBytes(float64(var_%s)))", stackVar, ident.Name))
-						} else if isIntType(elemType) {
-							g.writeln(fmt.Sprintf("%s.Push(intToBytes(var_%s))", stackVar, ident.Name))
 						} else {
-							g.writeln(fmt.Sprintf("%s.Push(intToBytes(var_%s))", stackVar, ident.Name))
+							g.writeln(fmt.Sprintf("%s.Push(%s)", stackVar, g.wrapValueForType("var_"+ident.Name, elemType)))
 						}
 						return
 					}
 					// Legacy: Push from variable (borrow from type stack)
-					typeStack := TypeStack(sym.Type)
+					typeStack := sym.Slot()
 					if nativeDstack {
-						g.writeln(fmt.Sprintf("{ v, _ := stack_%s.PeekAt(%d); _push(bytesToInt(v)) } // push %s",
+						g.writeln(fmt.Sprintf("{ v, _ := %s.PeekAt(%d); _push(bytesToInt(v)) } // push %s",
 							typeStack, sym.Index, ident.Name))
 					} else {
 						// Check if type conversion is needed
 						if isIntType(sym.Type) && isFloatType(elemType) {
 							// i64 → f64: convert int bytes to float bytes
-							g.writeln(fmt.Sprintf("{ v, _ := stack_%s.PeekAt(%d); %s.Push(floatToBytes(float64(bytesToInt(v)))) } // push %s (i64→f64)",
+							g.writeln(fmt.Sprintf("{ v, _ := %s.PeekAt(%d); %s.Push(floatToBytes(float64(bytesToInt(v)))) } // push %s (i64→f64)",
 								typeStack, sym.Index, stackVar, ident.Name))
+						} else if bits := uintBits(elemType); bits > 0 && sym.Type != elemType {
+							// Any integer to a narrower or unsigned stack: keep the low bits
+							g.writeln(fmt.Sprintf("{ v, _ := %s.PeekAt(%d); %s.Push(wrapUint(bytesToInt(v), %d)) } // push %s (%s→%s)",
+								typeStack, sym.Index, stackVar, bits, ident.Name, sym.Type, elemType))
 						} else {
 							// Same type or compatible - direct copy
-							g.writeln(fmt.Sprintf("{ v, _ := stack_%s.PeekAt(%d); %s.Push(v) } // push %s",
+							g.writeln(fmt.Sprintf("{ v, _ := %s.PeekAt(%d); %s.Push(v) } // push %s",
 								typeStack, sym.Index, stackVar, ident.Name))
 						}
 					}
@@ -2286,8 +4165,16 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			
 			// Generate value
 			elemType := g.stacks[s.Stack]
-			valCode := g.generateExpr(valExpr)
-			wrapped := g.wrapValue(valCode, elemType)
+			var wrapped string
+			if elemType == "struct" {
+				packed, ok := g.packStruct(s.Stack, valExpr)
+				if !ok {
+					return
+				}
+				wrapped = packed
+			} else {
+				wrapped = g.wrapValue(g.generateExpr(valExpr), elemType)
+			}
 			
 			// Use Push with key parameter for Hash perspective
 			g.writeln(fmt.Sprintf("%s.Push(%s, []byte(%q)) // set %q", stackVar, wrapped, keyStr, keyStr))
@@ -2311,7 +4198,7 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			}
 			
 			// Get value by key and push to dstack
-			g.writeln(fmt.Sprintf("{ v, err := %s.Peek([]byte(%q)); if err != nil { panic(err) }; stack_dstack.Push(v) } // get %q", stackVar, keyStr, keyStr))
+			g.writeln(fmt.Sprintf("{ v, err := %s.Peek([]byte(%q)); if err != nil { panic(err) }; %s } // get %q", stackVar, keyStr, g.dstackPush("v"), keyStr))
 		} else {
 			g.writeln("// Error: get requires (key) argument")
 		}
@@ -2336,10 +4223,12 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 				return
 			}
 			
-			if sym.Native {
-				g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); var_%s = bytesToInt(v) }", stackVar, s.Target))
+			if sym.Native && nativeDstack {
+				g.writeln(fmt.Sprintf("var_%s = _pop()", s.Target))
+			} else if sym.Native {
+				g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); var_%s = %s }", stackVar, s.Target, g.nativeVarFromBytes("v", sym.Type)))
 			} else {
-				g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); stack_i64.PushAt(%d, v) } // %s = pop", stackVar, sym.Index, s.Target))
+				g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); %s.PushAt(%d, v) } // %s = pop", stackVar, sym.Slot(), sym.Index, s.Target))
 			}
 		} else if nativeDstack {
 			g.writeln("_ = _pop()")
@@ -2351,7 +4240,7 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 				g.addError(fmt.Sprintf("cannot pop from @%s (%s) to @dstack without target variable; use '@%s pop:varname' or '@%s dot'",
 					s.Stack, elemType, stackVar, stackVar))
 			}
-			g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); stack_dstack.Push(v) }", stackVar))
+			g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); %s }", stackVar, g.dstackPush("v")))
 		} else {
 			// Pop from dstack and discard
 			g.writeln(fmt.Sprintf("_, _ = %s.Pop()", stackVar))
@@ -2365,26 +4254,26 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			if len(s.Args) >= 1 {
 				timeout := g.generateExpr(s.Args[0])
 				if sym != nil && sym.Native {
-					g.writeln(fmt.Sprintf("{ v, _ := %s.Take(int64(%s)); var_%s = bytesToInt(v) }", stackVar, timeout, s.Target))
+					g.writeln(fmt.Sprintf("{ v := _take(%s, int64(%s)); var_%s = %s }", stackVar, timeout, s.Target, g.nativeVarFromBytes("v", sym.Type)))
 				} else if sym != nil {
-					g.writeln(fmt.Sprintf("{ v, _ := %s.Take(int64(%s)); stack_i64.PushAt(%d, v) } // %s = take", stackVar, timeout, sym.Index, s.Target))
+					g.writeln(fmt.Sprintf("{ v := _take(%s, int64(%s)); %s.PushAt(%d, v) } // %s = take", stackVar, timeout, sym.Slot(), sym.Index, s.Target))
 				} else {
-					g.writeln(fmt.Sprintf("{ v, _ := %s.Take(int64(%s)); stack_dstack.Push(v) }", stackVar, timeout))
+					g.writeln(fmt.Sprintf("{ v := _take(%s, int64(%s)); %s }", stackVar, timeout, g.dstackPush("v")))
 				}
 			} else {
 				if sym != nil && sym.Native {
-					g.writeln(fmt.Sprintf("{ v, _ := %s.Take(); var_%s = bytesToInt(v) }", stackVar, s.Target))
+					g.writeln(fmt.Sprintf("{ v := _take(%s, 0); var_%s = %s }", stackVar, s.Target, g.nativeVarFromBytes("v", sym.Type)))
 				} else if sym != nil {
-					g.writeln(fmt.Sprintf("{ v, _ := %s.Take(); stack_i64.PushAt(%d, v) } // %s = take", stackVar, sym.Index, s.Target))
+					g.writeln(fmt.Sprintf("{ v := _take(%s, 0); %s.PushAt(%d, v) } // %s = take", stackVar, sym.Slot(), sym.Index, s.Target))
 				} else {
-					g.writeln(fmt.Sprintf("{ v, _ := %s.Take(); stack_dstack.Push(v) }", stackVar))
+					g.writeln(fmt.Sprintf("{ v := _take(%s, 0); %s }", stackVar, g.dstackPush("v")))
 				}
 			}
 		} else if len(s.Args) >= 1 {
 			timeout := g.generateExpr(s.Args[0])
-			g.writeln(fmt.Sprintf("{ v, _ := %s.Take(int64(%s)); stack_dstack.Push(v) }", stackVar, timeout))
+			g.writeln(fmt.Sprintf("{ v := _take(%s, int64(%s)); %s }", stackVar, timeout, g.dstackPush("v")))
 		} else {
-			g.writeln(fmt.Sprintf("{ v, _ := %s.Take(); stack_dstack.Push(v) }", stackVar))
+			g.writeln(fmt.Sprintf("{ v := _take(%s, 0); %s }", stackVar, g.dstackPush("v")))
 		}
 		
 	case "peek":
@@ -2395,7 +4284,14 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 		}
 		
 	case "bring":
-		if len(s.Args) >= 1 {
+		withFn := false
+		if len(s.Args) >= 2 {
+			_, withFn = s.Args[1].(*ast.FnLit)
+		}
+		if withFn {
+			// @dst bring(@src, {|x| ...}, {|x| pred})
+			g.generateBringWith(s, stackVar)
+		} else if len(s.Args) >= 1 {
 			src := g.generateExpr(s.Args[0])
 			if len(s.Args) >= 2 {
 				param := g.generateExpr(s.Args[1])
@@ -2411,32 +4307,67 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			g.writeln(fmt.Sprintf("%s.SetPerspective(%s)", stackVar, persp))
 		}
 		
-	case "freeze":
-		g.writeln(fmt.Sprintf("%s.Freeze()", stackVar))
-		
-	// TODO: walk and filter operations are incomplete - results are created in
-	// temp stacks but never returned or used. Need to design proper semantics:
-	// Option 1: Replace source stack contents with results
-	// Option 2: Return results to a specified destination stack
-	// Option 3: Push results to dstack
-	// For now, use reduce() or explicit for loops instead.
-	/*
-	case "walk":
-		if len(s.Args) >= 1 {
-			fn := g.generateExpr(s.Args[0])
-			// Create a temporary destination stack
-			g.writeln(fmt.Sprintf("walkDest_%s := ual.NewStack(ual.LIFO, ual.TypeBytes)", stackVar))
-			g.writeln(fmt.Sprintf("walkDest_%s.Walk(%s, %s, nil)", stackVar, s.Stack, fn))
+	case "freeze":
+		// freeze, or freeze("structure") / freeze("append-only")
+		if len(s.Args) == 0 {
+			g.writeln(fmt.Sprintf("%s.Freeze()", stackVar))
+			return
+		}
+		mode, ok := freezeModeConst(s.Args[0])
+		if !ok {
+			g.addError(fmt.Sprintf("@%s freeze: mode must be \"all\", \"structure\" or \"append-only\"", s.Stack))
+			return
+		}
+		g.writeln(fmt.Sprintf("%s.FreezeWith(ual.%s)", stackVar, mode))
+		
+	// @dst walk(@src, fn), @dst filter(@src, pred), @dst map(@src, fn)
+	case "walk", "filter", "map":
+		g.generateWalkOp(s, stackVar)
+		
+	// @s pmap(fn): in-place map across cores
+	case "pmap":
+		g.generatePmapOp(s, stackVar)
+		
+	// @text b64encode(@blob), @blob b64decode(@text), hexencode, hexdecode
+	case "b64encode", "b64decode", "hexencode", "hexdecode":
+		g.generateCodecOp(s, stackVar)
+		
+	// @cfg from_json(text): a JSON object into a Hash stack, an array into any other
+	case "from_json":
+		if len(s.Args) != 1 {
+			g.addError(fmt.Sprintf("@%s from_json requires a text argument", s.Stack))
+			return
 		}
+		errStack := "stack_error"
+		if g.noForth {
+			errStack = "nil"
+		}
+		g.writeln(fmt.Sprintf("%s.FromJSON(%s, %s)", stackVar, g.generateExprValue(s.Args[0]), errStack))
 		
-	case "filter":
-		if len(s.Args) >= 1 {
-			fn := g.generateExpr(s.Args[0])
-			g.writeln(fmt.Sprintf("filterDest_%s := ual.NewStack(ual.LIFO, ual.TypeBytes)", stackVar))
-			// Filter needs a predicate, walk needs a transform
-			g.writeln(fmt.Sprintf("filterDest_%s.Filter(%s, %s, nil)", stackVar, s.Stack, fn))
+	// @blob pack(@s), @s unpack(@blob): a whole stack as one ualpack element
+	case "pack", "unpack":
+		g.generatePackOp(s, stackVar)
+			// @telemetry bridge("mqtt://broker:1883/topic"): pushes also go to the
+	// topic, and its messages are pushed here
+	case "bridge":
+		if len(s.Args) != 1 {
+			g.addError(fmt.Sprintf("@%s bridge requires a URL argument", s.Stack))
+			return
+		}
+		if p := g.perspectives[s.Stack]; p == "Hash" || p == "Broadcast" {
+			g.addError(fmt.Sprintf("@%s bridge: only LIFO, FIFO and Indexed stacks can be bridged", s.Stack))
+			return
+		}
+		errStack := "stack_error"
+		if g.noForth {
+			errStack = "nil"
 		}
-	*/
+		g.writeln(fmt.Sprintf("%s.BridgeTo(%s, %s)", stackVar, g.generateExprValue(s.Args[0]), errStack))
+		
+
+	// @s concat, split(","), strlen, substr(1, 3), contains("x"), upper, lower
+	case "concat", "split", "strlen", "substr", "contains", "upper", "lower":
+		g.generateStringOp(s, stackVar)
 		
 	// Forth-like stack operations
 	case "add":
@@ -2480,7 +4411,7 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 		if nativeDstack {
 			g.writeln("_ = _pop()")
 		} else {
-			g.writeln(fmt.Sprintf("%s.Pop()", stackVar))
+			g.writeln(fmt.Sprintf("_, _ = %s.Pop()", stackVar))
 		}
 	case "swap":
 		if nativeDstack {
@@ -2514,7 +4445,7 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 				if ident, ok := arg.(*ast.Ident); ok && g.considerBindings[ident.Name] {
 					args = append(args, ident.Name+"_str")
 				} else {
-					args = append(args, g.generateExprValue(arg))
+					args = append(args, g.generatePrintArg(arg))
 				}
 			}
 			g.writeln(fmt.Sprintf("fmt.Print(%s)", strings.Join(args, ", ")))
@@ -2525,7 +4456,9 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			// Use correct conversion based on stack element type
 			elemType := g.stacks[s.Stack]
 			converter := "bytesToInt"
-			if elemType == "f64" || elemType == "float64" {
+			if elemType == "f32" {
+				converter = "bytesToFloat32"
+			} else if elemType == "f64" || elemType == "float64" {
 				converter = "bytesToFloat"
 			} else if elemType == "string" {
 				converter = "string"
@@ -2542,7 +4475,7 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 				if ident, ok := arg.(*ast.Ident); ok && g.considerBindings[ident.Name] {
 					args = append(args, ident.Name+"_str")
 				} else {
-					args = append(args, g.generateExprValue(arg))
+					args = append(args, g.generatePrintArg(arg))
 				}
 			}
 			g.writeln(fmt.Sprintf("fmt.Println(%s)", strings.Join(args, ", ")))
@@ -2552,12 +4485,16 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 		} else {
 			elemType := g.stacks[s.Stack]
 			converter := "bytesToInt"
-			if elemType == "f64" || elemType == "float64" {
+			if elemType == "f32" {
+				converter = "bytesToFloat32"
+			} else if elemType == "f64" || elemType == "float64" {
 				converter = "bytesToFloat"
 			} else if elemType == "string" {
 				converter = "string"
 			} else if elemType == "bool" {
 				converter = "bytesToBool"
+			} else if elemType == "struct" {
+				converter = fmt.Sprintf("_struct_%s.Format", s.Stack)
 			}
 			g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); fmt.Println(%s(v)) }", stackVar, converter))
 		}
@@ -2578,12 +4515,16 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 			// Use correct conversion based on stack element type
 			elemType := g.stacks[s.Stack]
 			converter := "bytesToInt"
-			if elemType == "f64" || elemType == "float64" {
+			if elemType == "f32" {
+				converter = "bytesToFloat32"
+			} else if elemType == "f64" || elemType == "float64" {
 				converter = "bytesToFloat"
 			} else if elemType == "string" {
 				converter = "string"
 			} else if elemType == "bool" {
 				converter = "bytesToBool"
+			} else if elemType == "struct" {
+				converter = fmt.Sprintf("_struct_%s.Format", s.Stack)
 			}
 			g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); fmt.Println(%s(v)) }", stackVar, converter))
 		}
@@ -2685,24 +4626,14 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 		}
 	
 	// Comparison operations (push to @bool)
-	case "eq":
-		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) == bytesToInt(b))) }",
-			stackVar, stackVar))
-	case "ne":
-		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) != bytesToInt(b))) }",
-			stackVar, stackVar))
-	case "lt":
-		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) < bytesToInt(b))) }",
-			stackVar, stackVar))
-	case "gt":
-		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) > bytesToInt(b))) }",
-			stackVar, stackVar))
-	case "le":
-		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) <= bytesToInt(b))) }",
-			stackVar, stackVar))
-	case "ge":
-		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) >= bytesToInt(b))) }",
-			stackVar, stackVar))
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		cmp := map[string]string{"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">="}[s.Op]
+		if nativeDstack {
+			g.writeln(fmt.Sprintf("{ b := _pop(); a := _pop(); stack_bool.Push(boolToBytes(a %s b)) }", cmp))
+		} else {
+			g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); stack_bool.Push(boolToBytes(bytesToInt(a) %s bytesToInt(b))) }",
+				stackVar, stackVar, cmp))
+		}
 	
 	case "let":
 		// let:name - assign from stack top to variable
@@ -2734,18 +4665,16 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 					if g.optimize && nativeDstack {
 						g.writeln(fmt.Sprintf("var_%s = _pop()", name))
 					} else {
-						g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); var_%s = bytesToInt(v) }", stackVar, name))
+						g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); var_%s = %s }", stackVar, name, g.nativeVarFromBytes("v", sym.Type)))
 					}
 				} else if g.optimize && nativeDstack {
 					// Non-native symbol with native dstack
-					typeStack := TypeStack(sym.Type)
-					g.writeln(fmt.Sprintf("{ v := _pop(); stack_%s.PushAt(%d, intToBytes(v)) } // %s = ...", 
-						typeStack, sym.Index, name))
+					g.writeln(fmt.Sprintf("{ v := _pop(); %s.PushAt(%d, intToBytes(v)) } // %s = ...", 
+						sym.Slot(), sym.Index, name))
 				} else {
 					// Update existing stack-based variable
-					typeStack := TypeStack(sym.Type)
-					g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); stack_%s.PushAt(%d, v) } // %s = ...",
-						s.Stack, typeStack, sym.Index, name))
+					g.writeln(fmt.Sprintf("{ v, _ := stack_%s.Pop(); %s.PushAt(%d, v) } // %s = ...",
+						s.Stack, sym.Slot(), sym.Index, name))
 				}
 			}
 		}
@@ -2755,6 +4684,17 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 		// @error.has pushes true to @bool if errors exist
 		if s.Stack == "error" {
 			g.writeln("stack_bool.Push(boolToBytes(stack_error.Len() > 0))")
+		} else if len(s.Args) == 1 {
+			// @stack has("key") pushes whether a Hash stack holds the key
+			if key, ok := g.hashKeyArg(s); ok {
+				g.writeln(fmt.Sprintf("stack_bool.Push(boolToBytes(%s.Has(%q))) // has %q", stackVar, key, key))
+			}
+		}
+	
+	case "del":
+		// @stack del("key") removes a key from a Hash stack
+		if key, ok := g.hashKeyArg(s); ok {
+			g.writeln(fmt.Sprintf("if _, err := %s.Delete(%q); err != nil { stack_error.Push([]byte(err.Error())) } // del %q", stackVar, key, key))
 		}
 	
 	case "clear":
@@ -2770,9 +4710,107 @@ func (g *CodeGen) generateStackOp(s *ast.StackOp) {
 	case "msg":
 		// @error.msg gets top error message as string, pushes to @dstack (as bytes)
 		if s.Stack == "error" {
-			g.writeln("{ v, _ := stack_error.Peek(); stack_dstack.Push(v) }")
+			g.writeln(fmt.Sprintf("{ v, _ := stack_error.Peek(); %s }", g.dstackPush("v")))
+		}
+	}
+}
+
+// generateUnsignedOp generates the ops whose result depends on the stack
+// holding unsigned values of the given width: arithmetic wraps modulo
+// 2^bits, division, shifts and comparisons are unsigned, and values print
+// without a sign. It returns false for any other op.
+func (g *CodeGen) generateUnsignedOp(s *ast.StackOp, stackVar string, bits uint) bool {
+	pop2 := fmt.Sprintf("b, _ := %s.Pop(); a, _ := %s.Pop(); x, y := bytesToUint(a, %d), bytesToUint(b, %d)",
+		stackVar, stackVar, bits, bits)
+	pop1 := fmt.Sprintf("v, _ := %s.Pop(); x := bytesToUint(v, %d)", stackVar, bits)
+	
+	binary := map[string]string{
+		"add": "+", "sub": "-", "mul": "*", "div": "/", "mod": "%",
+		"band": "&", "bor": "|", "bxor": "^", "shl": "<<", "shr": ">>",
+	}
+	unary := map[string]string{
+		"neg": "-int64(x)", "bnot": "^int64(x)", "inc": "int64(x + 1)", "dec": "int64(x - 1)",
+	}
+	compare := map[string]string{
+		"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">=",
+	}
+	
+	if op, ok := binary[s.Op]; ok {
+		g.writeln(fmt.Sprintf("{ %s; %s.Push(wrapUint(int64(x %s y), %d)) }", pop2, stackVar, op, bits))
+		return true
+	}
+	if expr, ok := unary[s.Op]; ok {
+		g.writeln(fmt.Sprintf("{ %s; %s.Push(wrapUint(%s, %d)) }", pop1, stackVar, expr, bits))
+		return true
+	}
+	if op, ok := compare[s.Op]; ok {
+		g.writeln(fmt.Sprintf("{ %s; stack_bool.Push(boolToBytes(x %s y)) }", pop2, op))
+		return true
+	}
+	
+	switch s.Op {
+	case "abs":
+		// Unsigned values are their own absolute value
+		return true
+	case "min":
+		g.writeln(fmt.Sprintf("{ %s; if y < x { x = y }; %s.Push(uintToBytes(x)) }", pop2, stackVar))
+		return true
+	case "max":
+		g.writeln(fmt.Sprintf("{ %s; if y > x { x = y }; %s.Push(uintToBytes(x)) }", pop2, stackVar))
+		return true
+	case "print", "println", "dot":
+		if len(s.Args) > 0 {
+			return false
+		}
+		printer := "Println"
+		if s.Op == "print" {
+			printer = "Print"
 		}
+		g.writeln(fmt.Sprintf("{ %s; fmt.%s(x) }", pop1, printer))
+		return true
+	}
+	return false
+}
+
+// generateFloatOp generates the arithmetic, comparisons, min and max of a
+// f64 or f32 stack, on the floats its elements encode. Results on an f32
+// stack are rounded to f32, as iual does. It returns false for any other
+// op.
+func (g *CodeGen) generateFloatOp(s *ast.StackOp, stackVar, typ string) bool {
+	decode := "bytesToFloat(%s)"
+	encode := "floatToBytes(%s)"
+	if typ == "f32" {
+		decode = "float64(bytesToFloat32(%s))"
+		encode = "floatToBytes(float64(float32(%s)))"
+	}
+	pop2 := fmt.Sprintf("b, _ := %s.Pop(); a, _ := %s.Pop(); x, y := %s, %s",
+		stackVar, stackVar, fmt.Sprintf(decode, "a"), fmt.Sprintf(decode, "b"))
+	pop1 := fmt.Sprintf("v, _ := %s.Pop(); x := %s", stackVar, fmt.Sprintf(decode, "v"))
+	
+	binary := map[string]string{
+		"add": "x + y", "sub": "x - y", "mul": "x * y", "div": "x / y", "mod": "math.Mod(x, y)",
+		"min": "math.Min(x, y)", "max": "math.Max(x, y)",
+	}
+	unary := map[string]string{
+		"neg": "-x", "abs": "math.Abs(x)", "inc": "x + 1", "dec": "x - 1",
+	}
+	compare := map[string]string{
+		"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">=",
+	}
+	
+	if expr, ok := binary[s.Op]; ok {
+		g.writeln(fmt.Sprintf("{ %s; %s.Push(%s) }", pop2, stackVar, fmt.Sprintf(encode, expr)))
+		return true
+	}
+	if expr, ok := unary[s.Op]; ok {
+		g.writeln(fmt.Sprintf("{ %s; %s.Push(%s) }", pop1, stackVar, fmt.Sprintf(encode, expr)))
+		return true
 	}
+	if op, ok := compare[s.Op]; ok {
+		g.writeln(fmt.Sprintf("{ %s; stack_bool.Push(boolToBytes(x %s y)) }", pop2, op))
+		return true
+	}
+	return false
 }
 
 func (g *CodeGen) generateBinaryStackOp(stackName string, op string) {
@@ -2785,7 +4823,11 @@ func (g *CodeGen) generateViewOp(v *ast.ViewOp) {
 	case "attach":
 		if len(v.Args) >= 1 {
 			if ref, ok := v.Args[0].(*ast.StackRef); ok {
-				g.writeln(fmt.Sprintf("view_%s.Attach(stack_%s)", v.View, ref.Name))
+				if p := g.perspectives[ref.Name]; p != "" && (p == "Broadcast") != (g.views[v.View] == "Broadcast") {
+					g.addError(fmt.Sprintf("view %s: Broadcast views attach only to Broadcast stacks, and only Broadcast views attach to them (@%s is %s)", v.View, ref.Name, p))
+					return
+				}
+				g.writeln(fmt.Sprintf("if err := view_%s.Attach(stack_%s); err != nil { panic(err) }", v.View, ref.Name))
 			}
 		}
 		
@@ -2793,14 +4835,68 @@ func (g *CodeGen) generateViewOp(v *ast.ViewOp) {
 		g.writeln(fmt.Sprintf("view_%s.Detach()", v.View))
 		
 	case "pop":
-		g.writeln(fmt.Sprintf("_, _ = view_%s.Pop()", v.View))
+		g.writeln(fmt.Sprintf("if _, err := view_%s.Pop(); %s", v.View, g.staleStatus(v.View)))
 		
 	case "peek":
-		g.writeln(fmt.Sprintf("_, _ = view_%s.Peek()", v.View))
+		g.writeln(fmt.Sprintf("if _, err := view_%s.Peek(); %s", v.View, g.staleStatus(v.View)))
+		
+	case "resync":
+		g.writeln(fmt.Sprintf("view_%s.Resync()", v.View))
 		
 	case "advance":
 		g.writeln(fmt.Sprintf("view_%s.Advance()", v.View))
+		
+	// Windows: narrow the view to a range of the stack without copying
+	case "slice":
+		if len(v.Args) >= 2 {
+			start := g.generateExpr(v.Args[0])
+			end := g.generateExpr(v.Args[1])
+			g.writeln(fmt.Sprintf("if err := view_%s.Slice(int(%s), int(%s)); err != nil { panic(err) }", v.View, start, end))
+		} else {
+			g.addError(fmt.Sprintf("view %s: slice requires (start, end) arguments", v.View))
+		}
+		
+	case "skip":
+		if len(v.Args) >= 1 {
+			g.writeln(fmt.Sprintf("if err := view_%s.Skip(int(%s)); err != nil { panic(err) }", v.View, g.generateExpr(v.Args[0])))
+		} else {
+			g.addError(fmt.Sprintf("view %s: skip requires a count", v.View))
+		}
+		
+	case "take":
+		if len(v.Args) >= 1 {
+			g.writeln(fmt.Sprintf("if err := view_%s.TakeN(int(%s)); err != nil { panic(err) }", v.View, g.generateExpr(v.Args[0])))
+		} else {
+			g.addError(fmt.Sprintf("view %s: take requires a count", v.View))
+		}
+		
+	case "unslice":
+		g.writeln(fmt.Sprintf("view_%s.Unslice()", v.View))
+		
+	case "reset":
+		g.writeln(fmt.Sprintf("view_%s.Reset()", v.View))
+	}
+}
+
+// staleStatus returns the tail of an `if ...; err check {}` statement that
+// sets the "stale" consider status, with the view name as its value, when a
+// view operation fails with ual.ErrStale. Other view errors are ignored.
+func (g *CodeGen) staleStatus(view string) string {
+	if g.noForth {
+		// No consider status globals without the Forth stacks
+		return "err != nil {}"
+	}
+	return fmt.Sprintf("err == ual.ErrStale { _consider_status = \"stale\"; _consider_value = %q }", view)
+}
+
+// goFloat returns v as a Go float64 literal, exactly and always with a
+// decimal point or exponent, so Go does not take it for an int
+func goFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
 	}
+	return s
 }
 
 func (g *CodeGen) generateExpr(expr ast.Expr) string {
@@ -2809,11 +4905,14 @@ func (g *CodeGen) generateExpr(expr ast.Expr) string {
 		return fmt.Sprintf("%d", e.Value)
 		
 	case *ast.FloatLit:
-		return fmt.Sprintf("%f", e.Value)
+		return goFloat(e.Value)
 		
 	case *ast.StringLit:
 		return fmt.Sprintf("%q", e.Value)
 		
+	case *ast.BoolLit:
+		return strconv.FormatBool(e.Value)
+		
 	case *ast.StackRef:
 		return g.stackVarName(e.Name)
 		
@@ -2824,11 +4923,9 @@ func (g *CodeGen) generateExpr(expr ast.Expr) string {
 		// Check if it's a variable in the symbol table
 		if sym := g.symbols.Lookup(e.Name); sym != nil {
 			if sym.Native {
-				return fmt.Sprintf("var_%s", e.Name)
+				return g.readNative(sym)
 			}
-			typeStack := TypeStack(sym.Type)
-			return fmt.Sprintf("func() int64 { v, _ := stack_%s.PeekAt(%d); return bytesToInt(v) }()", 
-				typeStack, sym.Index)
+			return g.readVarSlot(sym)
 		}
 		return e.Name
 		
@@ -2838,7 +4935,13 @@ func (g *CodeGen) generateExpr(expr ast.Expr) string {
 	case *ast.TypeLit:
 		return g.mapElementType(e.Value)
 		
+	case *ast.InterpString:
+		return g.generateSprintf(e.Parts, g.generateExpr)
+		
 	case *ast.BinaryOp:
+		if parts, ok := concatParts(e); ok {
+			return g.generateSprintf(parts, g.generateExpr)
+		}
 		left := g.generateExpr(e.Left)
 		right := g.generateExpr(e.Right)
 		return fmt.Sprintf("(%s %s %s)", left, e.Op, right)
@@ -2854,13 +4957,13 @@ func (g *CodeGen) generateExpr(expr ast.Expr) string {
 		return g.generateViewExpr(e)
 		
 	case *ast.FnLit:
-		return g.generateFnLit(e)
+		return g.generateFnValue(e)
 		
 	case *ast.FuncCall:
-		var args []string
-		for _, arg := range e.Args {
-			args = append(args, g.generateExpr(arg))
+		if code, ok := g.generateBuiltinExpr(e); ok {
+			return code
 		}
+		args := g.callArgs(e.Name, e.Args, g.generateExpr)
 		return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ", "))
 		
 	default:
@@ -2868,6 +4971,25 @@ func (g *CodeGen) generateExpr(expr ast.Expr) string {
 	}
 }
 
+// hashKeyArg returns the key of a del or has op, which must be a string
+// literal on a Hash stack
+func (g *CodeGen) hashKeyArg(s *ast.StackOp) (string, bool) {
+	if p, ok := g.perspectives[s.Stack]; ok && p != "Hash" {
+		g.addError(fmt.Sprintf("@%s %s(): requires a Hash stack", s.Stack, s.Op))
+		return "", false
+	}
+	if len(s.Args) != 1 {
+		g.addError(fmt.Sprintf("@%s %s() takes one key", s.Stack, s.Op))
+		return "", false
+	}
+	lit, ok := s.Args[0].(*ast.StringLit)
+	if !ok {
+		g.addError(fmt.Sprintf("@%s %s(): key must be a string literal", s.Stack, s.Op))
+		return "", false
+	}
+	return lit.Value, true
+}
+
 func (g *CodeGen) generateStackExpr(e *ast.StackExpr) string {
 	elemType := g.stacks[e.Stack]
 	
@@ -2880,9 +5002,9 @@ func (g *CodeGen) generateStackExpr(e *ast.StackExpr) string {
 		// Blocking pop - returns unwrapped value
 		if len(e.Args) >= 1 {
 			timeout := g.generateExpr(e.Args[0])
-			return fmt.Sprintf("func() int64 { v, _ := stack_%s.Take(int64(%s)); return bytesToInt(v) }()", e.Stack, timeout)
+			return fmt.Sprintf("func() int64 { v := _take(stack_%s, int64(%s)); return bytesToInt(v) }()", e.Stack, timeout)
 		}
-		return fmt.Sprintf("func() int64 { v, _ := stack_%s.Take(); return bytesToInt(v) }()", e.Stack)
+		return fmt.Sprintf("func() int64 { v := _take(stack_%s, 0); return bytesToInt(v) }()", e.Stack)
 		
 	case "peek":
 		return fmt.Sprintf("func() int64 { v, _ := stack_%s.Peek(); return bytesToInt(v) }()", e.Stack)
@@ -2890,13 +5012,39 @@ func (g *CodeGen) generateStackExpr(e *ast.StackExpr) string {
 	case "reduce":
 		if len(e.Args) >= 2 {
 			initial := g.generateExpr(e.Args[0])
-			fn := g.generateExpr(e.Args[1])
+			fn := g.generateFoldFn(e.Args[1])
+			wrapped := g.wrapValue(initial, elemType)
+			return fmt.Sprintf("func() int64 { r, _ := ual.Reduce(stack_%s, %s, %s); return bytesToInt(r) }()", e.Stack, wrapped, fn)
+		}
+		
+	case "preduce":
+		// Like reduce, but chunks are folded in parallel: fn must be
+		// associative and init its identity
+		if len(e.Args) >= 2 {
+			initial := g.generateExpr(e.Args[0])
+			fn := g.generateFoldFn(e.Args[1])
 			wrapped := g.wrapValue(initial, elemType)
-			return fmt.Sprintf("bytesToInt(ual.Reduce(stack_%s, %s, %s))", e.Stack, wrapped, fn)
+			return fmt.Sprintf("func() int64 { r, _ := ual.ParallelReduce(stack_%s, %s, %s); return bytesToInt(r) }()", e.Stack, wrapped, fn)
 		}
 		
 	case "len":
 		return fmt.Sprintf("int64(stack_%s.Len())", e.Stack)
+		
+	case "has":
+		if len(e.Args) == 1 {
+			return fmt.Sprintf("stack_%s.Has(%s)", e.Stack, g.generateExpr(e.Args[0]))
+		}
+		
+	case "to_json":
+		return fmt.Sprintf("%s.ToJSON()", g.stackVarName(e.Stack))
+		
+	case "get":
+		// @h: get("key") - the value at key of a Hash stack, the zero value if unset
+		if len(e.Args) == 1 {
+			t := g.inferType(e)
+			return fmt.Sprintf("func() %s { v, _ := %s.Peek([]byte(%s)); return %s }()",
+				g.goType(t), g.stackVarName(e.Stack), g.generateExprValue(e.Args[0]), g.unwrapValueForType("v", t))
+		}
 	}
 	
 	return "nil"
@@ -2905,21 +5053,104 @@ func (g *CodeGen) generateStackExpr(e *ast.StackExpr) string {
 func (g *CodeGen) generateViewExpr(e *ast.ViewExpr) string {
 	switch e.Op {
 	case "pop":
-		return fmt.Sprintf("func() int64 { v, _ := view_%s.Pop(); return bytesToInt(v) }()", e.View)
+		return fmt.Sprintf("func() int64 { v, err := view_%s.Pop(); if %s; return bytesToInt(v) }()", e.View, g.staleStatus(e.View))
 		
 	case "peek":
-		return fmt.Sprintf("func() int64 { v, _ := view_%s.Peek(); return bytesToInt(v) }()", e.View)
+		return fmt.Sprintf("func() int64 { v, err := view_%s.Peek(); if %s; return bytesToInt(v) }()", e.View, g.staleStatus(e.View))
 		
 	case "remaining":
-		return fmt.Sprintf("view_%s.Remaining()", e.View)
+		return fmt.Sprintf("int64(view_%s.Remaining())", e.View)
+		
+	case "reduce":
+		// v: reduce(initial, {|acc, x| ...}) - folds over the view's window
+		if len(e.Args) >= 2 {
+			initial := g.generateExpr(e.Args[0])
+			fn := g.generateFoldFn(e.Args[1])
+			return fmt.Sprintf("func() int64 { r, err := ual.Reduce(view_%s, intToBytes(%s), %s); if %s; return bytesToInt(r) }()",
+				e.View, initial, fn, g.staleStatus(e.View))
+		}
 	}
 	
 	return "nil"
 }
 
-func (g *CodeGen) generateFnLit(f *ast.FnLit) string {
-	g.fnCounter++
+// generateFoldFn generates the fold function of reduce and preduce from
+// their codeblock argument
+func (g *CodeGen) generateFoldFn(e ast.Expr) string {
+	if f, ok := e.(*ast.FnLit); ok {
+		return g.generateFnLit(f)
+	}
+	return g.generateExpr(e)
+}
+
+// generateFnValue generates a codeblock used as a value, {|x| ...} stored,
+// passed or pushed. The closure is registered with ual.NewFn just before
+// the statement that uses it, and the value is its handle. It starts from
+// copies of the variables it captures (see closure.go); its result is its
+// single expression, or what it returns, or 0.
+func (g *CodeGen) generateFnValue(f *ast.FnLit) string {
+	name := fmt.Sprintf("_fn%d", g.ids.next("fn"))
+	
+	type capture struct {
+		sym  *Symbol
+		copy string
+	}
+	var captures []capture
+	for _, v := range codeblockCaptures(f) {
+		sym := g.symbols.Lookup(v)
+		if sym == nil {
+			continue
+		}
+		c := capture{sym, fmt.Sprintf("%s_%s", name, v)}
+		if sym.Native {
+			g.writeln(fmt.Sprintf("%s := var_%s", c.copy, v))
+		} else {
+			g.writeln(fmt.Sprintf("%s, _ := %s.PeekAt(%d)", c.copy, sym.Slot(), sym.Index))
+		}
+		captures = append(captures, c)
+	}
 	
+	g.writeln(fmt.Sprintf("%s := ual.NewFn(%d, func(_args []int64) int64 {", name, len(f.Params)))
+	g.indent++
+	g.symbols.EnterFrame()
+	frameAt := g.out.Len()
+	savedResult, savedIn, savedEscapes := g.spawnResultType, g.inCodeblock, g.frameEscapes
+	g.spawnResultType, g.inCodeblock, g.frameEscapes = "", true, frameEscapes(f.Body)
+	
+	for _, c := range captures {
+		if c.sym.Native {
+			g.symbols.DeclareNative(c.sym.Name, c.sym.Type)
+			g.writeln(fmt.Sprintf("var_%s := %s", c.sym.Name, c.copy))
+			g.writeln(fmt.Sprintf("_ = var_%s", c.sym.Name))
+			continue
+		}
+		idx, _ := g.symbols.Declare(c.sym.Name, c.sym.Type)
+		g.writeln(fmt.Sprintf("%s.PushAt(%d, %s) // captured %s", g.symbols.Slot(c.sym.Type), idx, c.copy, c.sym.Name))
+	}
+	for i, p := range f.Params {
+		g.declareVar(p, "i64", fmt.Sprintf("_args[%d]", i))
+		if sym := g.symbols.Lookup(p); sym != nil && sym.Native && !g.inSpawnBlock {
+			g.writeln(fmt.Sprintf("_ = var_%s", p))
+		}
+	}
+	
+	if expr := walkBodyExpr(f); expr != nil {
+		g.writeln(fmt.Sprintf("return int64(%s)", g.generateExprValue(expr)))
+	} else {
+		for _, stmt := range f.Body {
+			g.generateStmt(stmt)
+		}
+		g.writeln("return 0")
+	}
+	
+	g.spawnResultType, g.inCodeblock, g.frameEscapes = savedResult, savedIn, savedEscapes
+	g.insertFrame(frameAt, g.symbols.ExitFrame())
+	g.indent--
+	g.writeln("})")
+	return name
+}
+
+func (g *CodeGen) generateFnLit(f *ast.FnLit) string {
 	// Check if this is a simple expression (ExprStmt wrapping an expression)
 	// Used for map/filter/reduce
 	if len(f.Body) == 1 {
@@ -2942,13 +5173,13 @@ func (g *CodeGen) generateExprFnLit(params []string, expr ast.Expr) string {
 	if len(params) == 1 {
 		param := params[0]
 		body := g.generateExprWithParams(expr, params)
-		return fmt.Sprintf("func(b []byte) ([]byte, error) { %s := bytesToInt(b); return intToBytes(%s), nil }", param, body)
+		return fmt.Sprintf("func(_b []byte) ([]byte, error) { %s := bytesToInt(_b); return intToBytes(%s), nil }", param, body)
 	}
 	
 	if len(params) == 2 {
 		p1, p2 := params[0], params[1]
 		body := g.generateExprWithParams(expr, params)
-		return fmt.Sprintf("func(acc, elem []byte) []byte { %s := bytesToInt(acc); %s := bytesToInt(elem); return intToBytes(%s) }", p1, p2, body)
+		return fmt.Sprintf("func(_acc, _elem []byte) []byte { %s := bytesToInt(_acc); %s := bytesToInt(_elem); return intToBytes(%s) }", p1, p2, body)
 	}
 	
 	return "nil"
@@ -2970,19 +5201,364 @@ func (g *CodeGen) generateExprWithParams(expr ast.Expr, params []string) string
 	}
 }
 
+// generateWalkOp generates walk, filter and map with a destination stack:
+//
+//   @dst walk(@src, {|x| x * 2})      -- append fn(x) for each x
+//   @dst filter(@src, {|x| x > 0})    -- append x where pred(x)
+//   @dst map(@src, {|x| x * 2})       -- replace @dst with fn(x) for each x
+//
+// fn is called in @src's perspective order and @dst yields the results in
+// that same order. @src may also be a view, which walks just its window.
+// Failures (stale view, frozen or full @dst) are pushed to @error.
+func (g *CodeGen) generateWalkOp(s *ast.StackOp, stackVar string) {
+	if len(s.Args) != 2 {
+		g.addError(fmt.Sprintf("@%s %s requires (@source, {|x| ...}) arguments", s.Stack, s.Op))
+		return
+	}
+	
+	dstType := g.getStackElementType(s.Stack)
+	var src, srcType string
+	switch a := s.Args[0].(type) {
+	case *ast.StackRef:
+		src = g.stackVarName(a.Name)
+		srcType = g.getStackElementType(a.Name)
+	case *ast.Ident:
+		if _, isView := g.views[a.Name]; !isView {
+			g.addError(fmt.Sprintf("@%s %s: %s is not a stack or view", s.Stack, s.Op, a.Name))
+			return
+		}
+		// Views don't carry an element type; assume the destination's
+		src = fmt.Sprintf("view_%s", a.Name)
+		srcType = dstType
+	default:
+		g.addError(fmt.Sprintf("@%s %s: first argument must be a stack or view", s.Stack, s.Op))
+		return
+	}
+	
+	fn, ok := s.Args[1].(*ast.FnLit)
+	if !ok || len(fn.Params) != 1 {
+		g.addError(fmt.Sprintf("@%s %s: second argument must be a codeblock {|x| ...}", s.Stack, s.Op))
+		return
+	}
+	body := walkBodyExpr(fn)
+	if body == nil {
+		g.addError(fmt.Sprintf("@%s %s: codeblock must be a single expression", s.Stack, s.Op))
+		return
+	}
+	
+	param := fn.Params[0]
+	value := g.unwrapValueForType("_b", srcType)
+	if s.Op != "filter" && srcType != dstType && isNumericType(srcType) && isNumericType(dstType) {
+		// Numeric conversion: the codeblock computes in the destination type
+		value = fmt.Sprintf("%s(%s)", g.goTypeFor(dstType), value)
+	}
+	decode := fmt.Sprintf("%s := %s; _ = %s", param, value, param)
+	expr := g.generateExprWithParams(body, fn.Params)
+	
+	errStack := "stack_error"
+	if g.noForth {
+		errStack = "nil"
+	}
+	
+	if s.Op == "filter" {
+		if srcType != dstType {
+			g.addError(fmt.Sprintf("@%s filter: source is %s but destination is %s (use walk to convert)", s.Stack, srcType, dstType))
+			return
+		}
+		if !isComparison(body) {
+			switch srcType {
+			case "bool":
+			case "string":
+				expr = fmt.Sprintf("%s != \"\"", expr)
+			default:
+				expr = fmt.Sprintf("%s != 0", expr)
+			}
+		}
+		g.writeln(fmt.Sprintf("%s.Filter(%s, func(_b []byte) bool { %s; return %s }, %s)",
+			stackVar, src, decode, expr, errStack))
+		return
+	}
+	
+	if s.Op == "map" {
+		g.writeln(fmt.Sprintf("%s.Clear()", stackVar))
+	}
+	g.writeln(fmt.Sprintf("%s.Walk(%s, func(_b []byte) ([]byte, error) { %s; return %s, nil }, %s)",
+		stackVar, src, decode, g.wrapValueForType(expr, dstType), errStack))
+}
+
+// generateBringWith generates @dst bring(@src, {|x| ...}) and
+// @dst bring(@src, {|x| ...}, {|x| pred}). The transform computes in the
+// destination type, as in walk, and the predicate sees the source element;
+// an element it rejects is taken from @src and dropped.
+func (g *CodeGen) generateBringWith(s *ast.StackOp, stackVar string) {
+	ref, ok := s.Args[0].(*ast.StackRef)
+	if !ok || len(s.Args) > 3 {
+		g.addError(fmt.Sprintf("@%s bring requires (@source, {|x| ...}) or (@source, {|x| ...}, {|x| pred}) arguments", s.Stack))
+		return
+	}
+	srcType := g.getStackElementType(ref.Name)
+	dstType := g.getStackElementType(s.Stack)
+	
+	fn, body := g.bringCodeblock(s, s.Args[1], "transform")
+	if body == nil {
+		return
+	}
+	param := fn.Params[0]
+	value := g.unwrapValueForType("_b", srcType)
+	if srcType != dstType && isNumericType(srcType) && isNumericType(dstType) {
+		value = fmt.Sprintf("%s(%s)", g.goTypeFor(dstType), value)
+	}
+	transform := fmt.Sprintf("func(_b []byte) ([]byte, error) { %s := %s; _ = %s; return %s, nil }",
+		param, value, param, g.wrapValueForType(g.generateExprWithParams(body, fn.Params), dstType))
+	
+	pred := "nil"
+	if len(s.Args) == 3 {
+		fn, body := g.bringCodeblock(s, s.Args[2], "predicate")
+		if body == nil {
+			return
+		}
+		param := fn.Params[0]
+		expr := g.generateExprWithParams(body, fn.Params)
+		if !isComparison(body) {
+			switch srcType {
+			case "bool":
+			case "string":
+				expr = fmt.Sprintf("%s != \"\"", expr)
+			default:
+				expr = fmt.Sprintf("%s != 0", expr)
+			}
+		}
+		pred = fmt.Sprintf("func(_b []byte) bool { %s := %s; _ = %s; return %s }",
+			param, g.unwrapValueForType("_b", srcType), param, expr)
+	}
+	
+	g.writeln(fmt.Sprintf("%s.BringWith(%s, %s, %s)", stackVar, g.stackVarName(ref.Name), transform, pred))
+}
+
+// bringCodeblock checks that a bring argument is a one-parameter,
+// single-expression codeblock and returns it with its expression
+func (g *CodeGen) bringCodeblock(s *ast.StackOp, arg ast.Expr, what string) (*ast.FnLit, ast.Expr) {
+	fn, ok := arg.(*ast.FnLit)
+	if !ok || len(fn.Params) != 1 {
+		g.addError(fmt.Sprintf("@%s bring: %s must be a codeblock {|x| ...}", s.Stack, what))
+		return nil, nil
+	}
+	body := walkBodyExpr(fn)
+	if body == nil {
+		g.addError(fmt.Sprintf("@%s bring: %s codeblock must be a single expression", s.Stack, what))
+	}
+	return fn, body
+}
+
+// generateCodecOp generates @dst b64encode(@src) and friends, which walk
+// @src like @dst walk does, appending the base64 or hex encoding (or
+// decoding) of each element. Both stacks must hold strings or bytes.
+// Elements that fail to decode are skipped and reported on @error.
+func (g *CodeGen) generateCodecOp(s *ast.StackOp, stackVar string) {
+	if len(s.Args) != 1 {
+		g.addError(fmt.Sprintf("@%s %s requires a source stack argument", s.Stack, s.Op))
+		return
+	}
+	
+	dstType := g.getStackElementType(s.Stack)
+	var src, srcType string
+	switch a := s.Args[0].(type) {
+	case *ast.StackRef:
+		src = g.stackVarName(a.Name)
+		srcType = g.getStackElementType(a.Name)
+	case *ast.Ident:
+		if _, isView := g.views[a.Name]; !isView {
+			g.addError(fmt.Sprintf("@%s %s: %s is not a stack or view", s.Stack, s.Op, a.Name))
+			return
+		}
+		src = fmt.Sprintf("view_%s", a.Name)
+		srcType = "bytes"
+	default:
+		g.addError(fmt.Sprintf("@%s %s: argument must be a stack or view", s.Stack, s.Op))
+		return
+	}
+	for _, t := range []string{srcType, dstType} {
+		if t != "string" && t != "bytes" {
+			g.addError(fmt.Sprintf("@%s %s: requires string or bytes stacks, not %s", s.Stack, s.Op, t))
+			return
+		}
+	}
+	
+	codec := "ual.Base64"
+	if strings.HasPrefix(s.Op, "hex") {
+		codec = "ual.Hex"
+	}
+	method := "Encode"
+	if strings.HasSuffix(s.Op, "decode") {
+		method = "Decode"
+	}
+	errStack := "stack_error"
+	if g.noForth {
+		errStack = "nil"
+	}
+	g.writeln(fmt.Sprintf("%s.Walk(%s, %s.%s, %s)", stackVar, src, codec, method, errStack))
+}
+
+// generatePackOp generates @blob pack(@s), which pushes the ualpack
+// encoding of @s to the bytes stack @blob, and @s unpack(@blob), which pops
+// one and pushes its elements to @s. Encodings that do not fit @s are
+// reported on @error.
+func (g *CodeGen) generatePackOp(s *ast.StackOp, stackVar string) {
+	var ref *ast.StackRef
+	if len(s.Args) == 1 {
+		ref, _ = s.Args[0].(*ast.StackRef)
+	}
+	if ref == nil {
+		g.addError(fmt.Sprintf("@%s %s requires a stack argument", s.Stack, s.Op))
+		return
+	}
+	blob := s.Stack
+	method := "PackFrom"
+	if s.Op == "unpack" {
+		blob = ref.Name
+		method = "UnpackFrom"
+	}
+	if t := g.getStackElementType(blob); t != "bytes" {
+		g.addError(fmt.Sprintf("@%s %s: @%s must be a bytes stack, not %s", s.Stack, s.Op, blob, t))
+		return
+	}
+	errStack := "stack_error"
+	if g.noForth {
+		errStack = "nil"
+	}
+	g.writeln(fmt.Sprintf("%s.%s(%s, %s)", stackVar, method, g.stackVarName(ref.Name), errStack))
+}
+
+// generateStringOp generates the text operations on a string stack. Each
+// pops its operands from the stack and pushes the result back, except
+// strlen, which pushes the length to @dstack, and contains, which pushes
+// to @bool. Positions and lengths count characters.
+func (g *CodeGen) generateStringOp(s *ast.StackOp, stackVar string) {
+	if t := g.getStackElementType(s.Stack); t != "string" {
+		g.addError(fmt.Sprintf("@%s %s requires a string stack, not %s", s.Stack, s.Op, t))
+		return
+	}
+	want := map[string]int{"split": 1, "substr": 2, "contains": 1}[s.Op]
+	if len(s.Args) != want {
+		g.addError(fmt.Sprintf("@%s %s takes %d argument(s), got %d", s.Stack, s.Op, want, len(s.Args)))
+		return
+	}
+	
+	switch s.Op {
+	case "concat":
+		g.writeln(fmt.Sprintf("{ b, _ := %s.Pop(); a, _ := %s.Pop(); %s.Push(ual.StrConcat(a, b)) }",
+			stackVar, stackVar, stackVar))
+	case "split":
+		g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); for _, p := range ual.StrSplit(v, %s) { %s.Push(p) } }",
+			stackVar, g.generateExprValue(s.Args[0]), stackVar))
+	case "strlen":
+		if g.optimize {
+			g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); _push(ual.StrLen(v)) }", stackVar))
+		} else {
+			g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); stack_dstack.Push(intToBytes(ual.StrLen(v))) }", stackVar))
+		}
+	case "substr":
+		g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); %s.Push(ual.Substr(v, int64(%s), int64(%s))) }",
+			stackVar, stackVar, g.generateExprValue(s.Args[0]), g.generateExprValue(s.Args[1])))
+	case "contains":
+		g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); stack_bool.Push(boolToBytes(ual.StrContains(v, %s))) }",
+			stackVar, g.generateExprValue(s.Args[0])))
+	case "upper":
+		g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); %s.Push(ual.StrUpper(v)) }", stackVar, stackVar))
+	case "lower":
+		g.writeln(fmt.Sprintf("{ v, _ := %s.Pop(); %s.Push(ual.StrLower(v)) }", stackVar, stackVar))
+	}
+}
+
+// generatePmapOp generates @s pmap({|x| x * 2}), which replaces every
+// element of @s with fn(x) using ual.ParallelMap. Large stacks are split
+// across cores, so the codeblock must be a single expression with no side
+// effects. A stack restructured while the map runs is reported on @error.
+func (g *CodeGen) generatePmapOp(s *ast.StackOp, stackVar string) {
+	fn, ok := (*ast.FnLit)(nil), len(s.Args) == 1
+	if ok {
+		fn, ok = s.Args[0].(*ast.FnLit)
+	}
+	if !ok || len(fn.Params) != 1 {
+		g.addError(fmt.Sprintf("@%s pmap requires a codeblock argument {|x| ...}", s.Stack))
+		return
+	}
+	body := walkBodyExpr(fn)
+	if body == nil {
+		g.addError(fmt.Sprintf("@%s pmap: codeblock must be a single expression", s.Stack))
+		return
+	}
+	
+	typ := g.getStackElementType(s.Stack)
+	param := fn.Params[0]
+	decode := fmt.Sprintf("%s := %s; _ = %s", param, g.unwrapValueForType("_b", typ), param)
+	expr := g.generateExprWithParams(body, fn.Params)
+	fnCode := fmt.Sprintf("func(_b []byte) ([]byte, error) { %s; return %s, nil }", decode, g.wrapValueForType(expr, typ))
+	if g.noForth {
+		g.writeln(fmt.Sprintf("ual.ParallelMap(%s, %s, nil)", stackVar, fnCode))
+		return
+	}
+	g.writeln(fmt.Sprintf("if err := ual.ParallelMap(%s, %s, stack_error); err != nil { stack_error.Push([]byte(err.Error())) }",
+		stackVar, fnCode))
+}
+
+// walkBodyExpr returns the expression of a single-expression codeblock,
+// accepting both {|x| x * 2} and {|x| return x * 2 }
+func walkBodyExpr(fn *ast.FnLit) ast.Expr {
+	if len(fn.Body) != 1 {
+		return nil
+	}
+	switch st := fn.Body[0].(type) {
+	case *ast.ExprStmt:
+		return st.Expr
+	case *ast.ReturnStmt:
+		return st.Value
+	}
+	return nil
+}
+
+// isComparison reports whether expr already yields a Go bool
+func isComparison(expr ast.Expr) bool {
+	if op, ok := expr.(*ast.BinaryOp); ok {
+		switch op.Op {
+		case "==", "!=", "<", ">", "<=", ">=":
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapValueForType decodes raw element bytes into a native Go value
+func (g *CodeGen) unwrapValueForType(value string, typ string) string {
+	switch typ {
+	case "u64", "u32", "u16", "u8":
+		return fmt.Sprintf("uint64(bytesToInt(%s))", value)
+	case "f64", "f32":
+		return fmt.Sprintf("bytesToFloat(%s)", value)
+	case "string":
+		return fmt.Sprintf("string(%s)", value)
+	case "bool":
+		return fmt.Sprintf("(len(%s) > 0 && %s[0] != 0)", value, value)
+	case "bytes":
+		return value
+	default:
+		return fmt.Sprintf("bytesToInt(%s)", value)
+	}
+}
+
 // generateSimpleFnLit handles simple codeblocks like {|x| x * 2}
 func (g *CodeGen) generateSimpleFnLit(params []string, op *ast.StackOp) string {
 	if len(params) == 1 {
 		param := params[0]
 		// Build expression from stack operation
 		body := g.generateStackOpExpr(op, param)
-		return fmt.Sprintf("func(b []byte) ([]byte, error) { %s := bytesToInt(b); return intToBytes(%s), nil }", param, body)
+		return fmt.Sprintf("func(_b []byte) ([]byte, error) { %s := bytesToInt(_b); return intToBytes(%s), nil }", param, body)
 	}
 	
 	if len(params) == 2 {
 		p1, p2 := params[0], params[1]
 		body := g.generateStackOpExpr(op, p1, p2)
-		return fmt.Sprintf("func(acc, elem []byte) []byte { %s := bytesToInt(acc); %s := bytesToInt(elem); return intToBytes(%s) }", p1, p2, body)
+		return fmt.Sprintf("func(_acc, _elem []byte) []byte { %s := bytesToInt(_acc); %s := bytesToInt(_elem); return intToBytes(%s) }", p1, p2, body)
 	}
 	
 	return "nil"
@@ -3056,12 +5632,18 @@ func (g *CodeGen) stackVarName(name string) string {
 
 func (g *CodeGen) mapElementType(t string) string {
 	switch t {
-	case "i8", "i16", "i32", "i64":
+	case "i8", "i16", "i64", "fn":
 		return "ual.TypeInt64"
-	case "u8", "u16", "u32", "u64":
-		return "ual.TypeInt64" // TODO: unsigned types
-	case "f32", "f64":
+	case "u8", "u16", "u64":
+		return "ual.TypeUint64"
+	case "f64":
 		return "ual.TypeFloat64"
+	case "i32": // 4 bytes per element
+		return "ual.TypeInt32"
+	case "u32":
+		return "ual.TypeUint32"
+	case "f32":
+		return "ual.TypeFloat32"
 	case "bool":
 		return "ual.TypeBool"
 	case "string":
@@ -3083,6 +5665,8 @@ func (g *CodeGen) mapPerspective(p string) string {
 		return "ual.Indexed"
 	case "Hash":
 		return "ual.Hash"
+	case "Broadcast":
+		return "ual.Broadcast"
 	default:
 		return "ual.LIFO"
 	}
@@ -3090,8 +5674,10 @@ func (g *CodeGen) mapPerspective(p string) string {
 
 func (g *CodeGen) wrapValue(val string, elemType string) string {
 	switch elemType {
-	case "i64", "i32", "i16", "i8", "u64", "u32", "u16", "u8":
+	case "i64", "i32", "i16", "i8":
 		return fmt.Sprintf("intToBytes(%s)", val)
+	case "u64", "u32", "u16", "u8":
+		return fmt.Sprintf("wrapUint(int64(%s), %d)", val, uintBits(elemType))
 	case "f64", "f32":
 		return fmt.Sprintf("floatToBytes(%s)", val)
 	case "string":
@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/ir"
+	"github.com/ha1tch/ual/pkg/runtime"
 )
 
 // RustCodeGen generates Rust code from ual AST
@@ -18,6 +21,10 @@ type RustCodeGen struct {
 	vars             map[string]bool   // declared variables
 	varTypes         map[string]string // variable name -> Rust type
 	varOrder         []string          // order of variable declarations for auto-print
+	globalUses       map[string][]string // globals each function uses (see scope.go)
+	globals          map[string]string // globals functions use -> Rust type, held in rual::Global statics
+	globalDecls      map[*ast.VarDecl]bool // top-level var statements, which set the globals
+	depth            int               // blocks enclosing the statement being generated (see scope)
 	defers           []*ast.DeferStmt  // defer blocks to execute at end of main
 	funcDefers       []*ast.DeferStmt  // defer blocks for current function scope
 	considerDepth    int               // nesting depth for consider blocks
@@ -26,8 +33,22 @@ type RustCodeGen struct {
 	errors           []string
 	inFunction       bool
 	inSpawnBlock     bool              // true when generating code inside spawn closure
+	spawnResultType  string            // result stack type inside "@spawn < { } into @s", else ""
+	inCodeblock      bool              // generating the body of a codeblock value
 	spawnLocalStacks map[string]string // local stack names in current spawn block -> element type
-	fnCounter        int
+	stackParams      map[string]bool   // stack parameters of the function being generated
+	tailFunc         *ast.FuncDecl     // the function being generated, if its tail calls loop (see tailcall.go)
+	funcDecls        map[string]*ast.FuncDecl // the program's functions, by name
+	tailCalls        map[ast.Stmt]bool // tailFunc's calls to itself in tail position
+	ids              nameCounter       // numbers of made-up names, by kind
+	argsDeclared     bool // an args block has been generated
+	bench            bool // ual bench: bench blocks run through rual::run_bench (see bench.go)
+	srcFile          string                // path of the program, for errors
+	library          bool                  // ual compile --lib: generate a library crate (see library.go)
+	pos              map[ast.Stmt]ast.Pos  // statement positions
+	stmtPos          ast.Pos               // position of the statement being generated
+	marked           ast.Pos               // position of the last source map marker (see sourcemap.go)
+	sourceMap        *sourceMap            // lines of the generated code -> .ual positions
 }
 
 // NewRustCodeGen creates a new Rust code generator
@@ -45,10 +66,29 @@ func NewRustCodeGen() *RustCodeGen {
 	}
 }
 
+// addError records msg, prefixed with the file:line:col of the statement
+// being generated when it is known
 func (g *RustCodeGen) addError(msg string) {
+	if g.stmtPos.Line > 0 {
+		pos := g.stmtPos
+		if pos.File == "" {
+			pos.File = g.srcFile
+		}
+		msg = pos.String() + ": " + msg
+	}
 	g.errors = append(g.errors, msg)
 }
 
+// at makes stmt the statement errors are reported against, and returns a
+// func restoring the previous one
+func (g *RustCodeGen) at(stmt ast.Stmt) func() {
+	saved := g.stmtPos
+	if pos, ok := g.pos[stmt]; ok {
+		g.stmtPos = pos
+	}
+	return func() { g.stmtPos = saved }
+}
+
 func (g *RustCodeGen) hasErrors() bool {
 	return len(g.errors) > 0
 }
@@ -65,6 +105,9 @@ func (g *RustCodeGen) stackVarName(name string) string {
 			return "local_" + name
 		}
 	}
+	if g.stackParams[name] {
+		return "stack_" + name
+	}
 	return "STACK_" + strings.ToUpper(name)
 }
 
@@ -89,15 +132,30 @@ func (g *RustCodeGen) write(s string) {
 }
 
 func (g *RustCodeGen) writeln(s string) {
+	g.markSource()
 	g.out.WriteString(strings.Repeat("    ", g.indent))
 	g.out.WriteString(s)
 	g.out.WriteString("\n")
 }
 
 func (g *RustCodeGen) writeIndent() {
+	g.markSource()
 	g.out.WriteString(strings.Repeat("    ", g.indent))
 }
 
+// markSource notes the position of the statement being generated for the
+// source map
+func (g *RustCodeGen) markSource() {
+	pos := ast.Pos{}
+	if g.stmtPos.Line > 0 {
+		pos = g.stmtPos
+		if pos.File == "" {
+			pos.File = g.srcFile
+		}
+	}
+	g.marked = markSource(&g.out, g.marked, pos)
+}
+
 // rustKeywords contains Rust reserved keywords that need escaping
 var rustKeywords = map[string]bool{
 	"as": true, "break": true, "const": true, "continue": true, "crate": true,
@@ -141,26 +199,51 @@ func (g *RustCodeGen) sVar(name string) string {
 			return "local_" + name
 		}
 	}
+	if g.stackParams[name] {
+		return "stack_" + name
+	}
 	return "STACK_" + strings.ToUpper(name)
 }
 
 // Generate produces Rust code from a ual program
 func (g *RustCodeGen) Generate(prog *ast.Program) string {
+	g.pos = prog.Pos
 	// Separate function declarations from other statements
 	var funcs []*ast.FuncDecl
 	var stackDecls []*ast.StackDecl
 	var otherStmts []ast.Stmt
+	hasArgs := false
 	
+	g.funcDecls = map[string]*ast.FuncDecl{}
 	for _, stmt := range prog.Stmts {
 		switch s := stmt.(type) {
 		case *ast.FuncDecl:
 			funcs = append(funcs, s)
+			g.funcDecls[s.Name] = s
 		case *ast.StackDecl:
 			stackDecls = append(stackDecls, s)
+		case *ast.ArgsDecl:
+			hasArgs = true
+			otherStmts = append(otherStmts, stmt)
 		default:
 			otherStmts = append(otherStmts, stmt)
 		}
 	}
+	if hasArgs && g.library {
+		g.addError("args blocks are for programs: a library has no command line")
+	}
+	if hasArgs {
+		// args blocks fill @args (a clashing user declaration wins and is
+		// reported by generateArgsDecl)
+		stackDecls = append(stackDecls, &ast.StackDecl{Name: "args", ElementType: "string", Perspective: "Hash"})
+	}
+	if g.library {
+		for _, fn := range funcs {
+			if fn.Name == "init" {
+				g.addError("a library cannot declare a function named init: its init runs the statements outside functions")
+			}
+		}
+	}
 
 	// Write header
 	g.writeln("// Generated by ual compiler (Rust backend)")
@@ -191,6 +274,10 @@ func (g *RustCodeGen) Generate(prog *ast.Program) string {
 	for _, sd := range stackDecls {
 		g.generateStaticStackDecl(sd)
 	}
+	
+	// Globals functions use are statics; the others are locals of main
+	g.globalUses = globalUses(prog)
+	g.declareGlobals(otherStmts)
 	g.indent--
 	g.writeln("}")
 	g.writeln("")
@@ -208,6 +295,7 @@ func (g *RustCodeGen) Generate(prog *ast.Program) string {
 	g.writeln("lazy_static! {")
 	g.indent++
 	g.writeln("static ref SPAWN_TASKS: std::sync::Mutex<Vec<Box<dyn FnOnce() + Send + 'static>>> = std::sync::Mutex::new(Vec::new());")
+	g.writeln("static ref SPAWN_GROUP: rual::SpawnGroup = rual::SpawnGroup::new();")
 	g.indent--
 	g.writeln("}")
 	g.writeln("")
@@ -218,14 +306,22 @@ func (g *RustCodeGen) Generate(prog *ast.Program) string {
 		g.writeln("")
 	}
 
-	// Generate main function
-	g.writeln("fn main() {")
-	g.indent++
-	
-	// Set silent panic hook so catch_unwind doesn't print panic messages
-	// (matches Go's recover() behavior which is silent)
-	g.writeln("std::panic::set_hook(Box::new(|_| {}));")
-	g.writeln("")
+	// Generate main function, or the init a library's users call first
+	if g.library {
+		g.writeln("pub fn init() {")
+		g.indent++
+	} else {
+		g.writeln("fn main() {")
+		g.indent++
+
+		// Set silent panic hook so catch_unwind doesn't print panic messages
+		// (matches Go's recover() behavior which is silent)
+		g.writeln("std::panic::set_hook(Box::new(|_| {}));")
+		g.writeln("")
+		// Dropped last, after the deferred blocks (and on panic)
+		g.writeln("let _at_exit = rual::AtExitGuard;")
+		g.writeln("")
+	}
 
 	// Generate other statements
 	for _, stmt := range otherStmts {
@@ -233,11 +329,11 @@ func (g *RustCodeGen) Generate(prog *ast.Program) string {
 	}
 
 	// Print declared variables (in order of declaration)
-	if len(g.varOrder) > 0 {
+	if len(g.varOrder) > 0 && !g.bench && !g.library {
 		g.writeln("")
 		g.writeln("// Results")
 		for _, name := range g.varOrder {
-			g.writeln(fmt.Sprintf(`println!("%s = {}", %s);`, name, escapeIdent(name)))
+			g.writeln(fmt.Sprintf(`println!("%s = {}", %s);`, name, g.readVar(name)))
 		}
 	}
 
@@ -256,17 +352,108 @@ func (g *RustCodeGen) Generate(prog *ast.Program) string {
 	g.indent--
 	g.writeln("}")
 
-	return g.out.String()
+	out, m := extractSourceMap(formatRust(g.out.String()))
+	g.sourceMap = m
+	return out
+}
+
+// declareGlobals declares a static for each global variable a function
+// uses, in a lazy_static block. Its var statement sets it when main (or a
+// library's init) reaches it; until then it holds its type's zero value.
+func (g *RustCodeGen) declareGlobals(stmts []ast.Stmt) {
+	used := map[string]bool{}
+	for _, names := range g.globalUses {
+		for _, name := range names {
+			used[name] = true
+		}
+	}
+	g.globals = map[string]string{}
+	g.globalDecls = map[*ast.VarDecl]bool{}
+	for _, stmt := range stmts {
+		vd, ok := stmt.(*ast.VarDecl)
+		if !ok {
+			continue
+		}
+		g.globalDecls[vd] = true
+		for i, name := range vd.Names {
+			if !used[name] || g.globals[name] != "" {
+				continue
+			}
+			rustType := g.varDeclType(vd, i)
+			g.globals[name] = rustType
+			g.varTypes[name] = rustType
+			g.writeln(fmt.Sprintf("static ref %s: rual::Global<%s> = rual::Global::new(%s);",
+				globalStatic(name), rustType, g.defaultValue(rustType)))
+		}
+	}
+}
+
+// globalStatic is the static holding the global variable name
+func globalStatic(name string) string {
+	return "GLOBAL_" + strings.ToUpper(name)
+}
+
+// globalVar returns the static holding the global variable name refers
+// to, or "" when it refers to a local, or to no variable
+func (g *RustCodeGen) globalVar(name string) string {
+	if g.globals[name] == "" || g.vars[name] {
+		return ""
+	}
+	return globalStatic(name)
+}
+
+// isVar reports whether name refers to a declared variable, local or global
+func (g *RustCodeGen) isVar(name string) bool {
+	return g.vars[name] || g.globalVar(name) != ""
+}
+
+// readVar generates the value of the variable name refers to
+func (g *RustCodeGen) readVar(name string) string {
+	if s := g.globalVar(name); s != "" {
+		return s + ".get()"
+	}
+	return escapeIdent(name)
+}
+
+// setVar generates a statement storing val in the declared variable name
+// refers to
+func (g *RustCodeGen) setVar(name, val string) {
+	if s := g.globalVar(name); s != "" {
+		g.writeln(fmt.Sprintf("%s.set(%s);", s, val))
+		return
+	}
+	g.writeln(fmt.Sprintf("%s = %s;", escapeIdent(name), val))
+}
+
+// scope starts a block. The variables declared in it, until the returned
+// func is called, end with it.
+func (g *RustCodeGen) scope() func() {
+	saved := make(map[string]bool, len(g.vars))
+	for name, ok := range g.vars {
+		saved[name] = ok
+	}
+	g.depth++
+	return func() { g.vars, g.depth = saved, g.depth-1 }
+}
+
+// pub returns "pub " in a library, where the functions and stacks a
+// program declares are for the crate's users
+func (g *RustCodeGen) pub() string {
+	if g.library {
+		return "pub "
+	}
+	return ""
 }
 
 // generateStaticStackDecl generates a static stack declaration
 func (g *RustCodeGen) generateStaticStackDecl(sd *ast.StackDecl) {
+	defer g.at(sd)()
 	// Check for duplicate declarations
 	if _, exists := g.stacks[sd.Name]; exists {
 		return // Skip duplicate declaration
 	}
 	
-	elemType := sd.ElementType
+	elemType := valueType(sd.ElementType)
 	if elemType == "" {
 		elemType = "i64"
 	}
@@ -276,59 +463,186 @@ func (g *RustCodeGen) generateStaticStackDecl(sd *ast.StackDecl) {
 	if sd.Perspective != "" {
 		perspective = sd.Perspective
 	}
+	if perspective == "Broadcast" {
+		// Views are virtual in this backend, so there are no subscribers
+		g.addError(fmt.Sprintf("@%s: Broadcast stacks are not supported by the Rust backend yet", sd.Name))
+		perspective = "FIFO"
+	}
+	if sd.ElementType == "struct" {
+		g.addError(fmt.Sprintf("@%s: struct element types are not supported by the Rust backend yet", sd.Name))
+	}
 	
 	g.stacks[sd.Name] = elemType
 	g.perspectives[sd.Name] = perspective
 	
 	// Use uppercase for static name - inside lazy_static! block
 	staticName := "STACK_" + strings.ToUpper(sd.Name)
-	g.writeln(fmt.Sprintf("static ref %s: Stack<%s> = Stack::new(Perspective::%s);", 
-		staticName, rustType, perspective))
+	if sd.Embed != nil {
+		// Filled from the file read at compile time, on first use
+		g.writeln(fmt.Sprintf("%sstatic ref %s: Stack<%s> = {", g.pub(), staticName, rustType))
+		g.indent++
+		g.writeln(fmt.Sprintf("let s = Stack::new(Perspective::%s);", perspective))
+		for _, e := range sd.Embed.Elements() {
+			if elemType == "bytes" {
+				g.writeln(fmt.Sprintf("let _ = s.push(%s.to_vec());", rustLiteral(e, true)))
+			} else {
+				g.writeln(fmt.Sprintf("let _ = s.push(%s.to_string());", rustLiteral(e, false)))
+			}
+		}
+		g.writeln("s.freeze();")
+		g.writeln("s")
+		g.indent--
+		g.writeln("};")
+		return
+	}
+	g.writeln(fmt.Sprintf("%sstatic ref %s: Stack<%s> = Stack::new(Perspective::%s);", 
+		g.pub(), staticName, rustType, perspective))
+}
+
+// rustFileStatus sets the consider status of a file builtin, or
+// readline, that failed with the rual::FileError e, with its message as the value
+const rustFileStatus = `CONSIDER_STATUS.with(|s| *s.borrow_mut() = e.status().to_string()); ` +
+	`CONSIDER_VALUE.with(|v| *v.borrow_mut() = e.to_string());`
+
+// rustLiteral returns s as a Rust string literal, or a byte string
+// literal if bytes is set, escaping what is not printable ASCII
+func rustLiteral(s string, bytes bool) string {
+	var b strings.Builder
+	escape := func(r rune) bool {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r >= ' ' && r < 0x7f:
+			b.WriteRune(r)
+		default:
+			return false
+		}
+		return true
+	}
+	if bytes {
+		b.WriteString(`b"`)
+		for i := 0; i < len(s); i++ {
+			if !escape(rune(s[i])) {
+				fmt.Fprintf(&b, `\x%02x`, s[i])
+			}
+		}
+	} else {
+		b.WriteByte('"')
+		for _, r := range s {
+			if !escape(r) {
+				fmt.Fprintf(&b, `\u{%x}`, r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
 }
 
 // generateFuncDecl generates a Rust function
 func (g *RustCodeGen) generateFuncDecl(fn *ast.FuncDecl) {
+	defer g.at(fn)()
 	g.inFunction = true
 	// Save and reset vars for function scope
 	savedVars := g.vars
 	savedFuncDefers := g.funcDefers
+	savedStacks, savedPerspectives := copyStrings(g.stacks), copyStrings(g.perspectives)
 	g.vars = make(map[string]bool)
 	g.funcDefers = nil
+	g.stackParams = make(map[string]bool)
 	defer func() { 
 		g.inFunction = false 
 		g.vars = savedVars
 		g.funcDefers = savedFuncDefers
+		g.stacks, g.perspectives = savedStacks, savedPerspectives
+		g.stackParams = nil
 	}()
 
-	// Build parameter list - mark params as declared
+	// Build parameter list - mark params as declared. A stack parameter
+	// is a reference to the stack, which @name in the body refers to.
 	var params []string
 	for _, p := range fn.Params {
-		rustType := g.ualTypeToRust(p.Type)
+		if p.Stack {
+			elemType := valueType(p.Type)
+			g.stacks[p.Name] = elemType
+			delete(g.perspectives, p.Name)
+			g.stackParams[p.Name] = true
+			params = append(params, fmt.Sprintf("stack_%s: &Stack<%s>", p.Name, g.ualTypeToRust(elemType)))
+			continue
+		}
+		rustType := g.ualTypeToRust(valueType(p.Type))
 		params = append(params, fmt.Sprintf("%s: %s", p.Name, rustType))
 		g.vars[p.Name] = true // Parameters are in scope
+		g.varTypes[p.Name] = rustType
 	}
 
 	// Build return type
 	returnType := ""
 	if fn.ReturnType != "" {
-		returnType = " -> " + g.ualTypeToRust(fn.ReturnType)
+		returnType = " -> " + g.ualTypeToRust(valueType(fn.ReturnType))
 	}
 
-	g.writeln(fmt.Sprintf("fn %s(%s)%s {", fn.Name, strings.Join(params, ", "), returnType))
+	g.writeln(fmt.Sprintf("%sfn %s(%s)%s {", g.pub(), fn.Name, strings.Join(params, ", "), returnType))
 	g.indent++
 
+	// A function whose tail calls loop runs its body in a loop, with its
+	// parameters mutable
+	savedTailFunc, savedTailCalls := g.tailFunc, g.tailCalls
+	defer func() { g.tailFunc, g.tailCalls = savedTailFunc, savedTailCalls }()
+	g.tailFunc, g.tailCalls = fn, tailCalls(fn)
+	if g.tailCalls != nil {
+		for _, p := range fn.Params {
+			if !p.Stack {
+				g.writeln(fmt.Sprintf("let mut %s = %s;", p.Name, p.Name))
+			}
+		}
+		g.writeln(fmt.Sprintf("'%s: loop {", tailLabel))
+		g.indent++
+	}
+
 	// Generate body
 	for _, stmt := range fn.Body {
 		g.generateStmt(stmt)
 	}
 
+	if g.tailCalls != nil {
+		if !terminates(fn.Body, g.tailCalls) {
+			g.writeln("return; // not round again")
+		}
+		g.indent--
+		g.writeln("}")
+	}
 	g.indent--
 	g.writeln("}")
 }
 
+// generateTailCall generates call, a call of the function being generated
+// to itself in tail position, as the start of its next time round
+func (g *RustCodeGen) generateTailCall(call *ast.FuncCall) {
+	names, args := tailArgs(g.tailFunc, call)
+	var values []string
+	for _, arg := range args {
+		values = append(values, g.generateExpr(arg))
+	}
+	switch len(names) {
+	case 0:
+	case 1:
+		g.writeln(fmt.Sprintf("%s = %s; // tail call", names[0], values[0]))
+	default:
+		g.writeln(fmt.Sprintf("(%s) = (%s); // tail call", strings.Join(names, ", "), strings.Join(values, ", ")))
+	}
+	g.writeln(fmt.Sprintf("continue '%s;", tailLabel))
+}
+
 // generateStackDecl generates a local stack declaration (for future use)
 func (g *RustCodeGen) generateStackDecl(sd *ast.StackDecl) {
-	elemType := sd.ElementType
+	elemType := valueType(sd.ElementType)
 	if elemType == "" {
 		elemType = "i64"
 	}
@@ -338,6 +652,14 @@ func (g *RustCodeGen) generateStackDecl(sd *ast.StackDecl) {
 	if sd.Perspective != "" {
 		perspective = sd.Perspective
 	}
+	if perspective == "Broadcast" {
+		// Views are virtual in this backend, so there are no subscribers
+		g.addError(fmt.Sprintf("@%s: Broadcast stacks are not supported by the Rust backend yet", sd.Name))
+		perspective = "FIFO"
+	}
+	if sd.ElementType == "struct" {
+		g.addError(fmt.Sprintf("@%s: struct element types are not supported by the Rust backend yet", sd.Name))
+	}
 	
 	// Handle local stacks in spawn blocks
 	if sd.Local && g.inSpawnBlock {
@@ -365,9 +687,15 @@ func (g *RustCodeGen) generateStackDecl(sd *ast.StackDecl) {
 
 // generateStmt generates a statement
 func (g *RustCodeGen) generateStmt(stmt ast.Stmt) {
+	defer g.at(stmt)()
 	switch s := stmt.(type) {
 	case *ast.VarDecl:
 		g.generateVarDecl(s)
+	case *ast.ArgsDecl:
+		g.generateArgsDecl(s)
+	case *ast.ImportStmt:
+		// Top-level imports are resolved before code generation
+		g.addError(fmt.Sprintf("import %q must be at the top level", s.Path))
 	case *ast.AssignStmt:
 		g.generateAssignStmt(s)
 	case *ast.Assignment:
@@ -378,6 +706,8 @@ func (g *RustCodeGen) generateStmt(stmt ast.Stmt) {
 		g.generateWhileStmt(s)
 	case *ast.ForStmt:
 		g.generateForStmt(s)
+	case *ast.RangeStmt:
+		g.generateRangeStmt(s)
 	case *ast.ReturnStmt:
 		g.generateReturnStmt(s)
 	case *ast.StackOp:
@@ -389,7 +719,21 @@ func (g *RustCodeGen) generateStmt(stmt ast.Stmt) {
 	case *ast.StackDecl:
 		g.generateStackDecl(s)
 	case *ast.FuncCall:
+		if g.tailCalls[s] {
+			g.generateTailCall(s)
+			break
+		}
+		if s.Name == "assert" {
+			g.generateAssert(s)
+			break
+		}
 		g.writeln(fmt.Sprintf("%s;", g.generateFuncCallExpr(s)))
+	case *ast.TestBlock:
+		// Test blocks run under ual test, which the Rust backend does not support yet
+	case *ast.BenchBlock:
+		if g.bench {
+			g.generateBenchBlock(s)
+		}
 	case *ast.ExprStmt:
 		g.writeln(fmt.Sprintf("%s;", g.generateExpr(s.Expr)))
 	case *ast.BreakStmt:
@@ -409,6 +753,8 @@ func (g *RustCodeGen) generateStmt(stmt ast.Stmt) {
 		} else {
 			g.defers = append(g.defers, s)
 		}
+	case *ast.AtExitStmt:
+		g.generateAtExitStmt(s)
 	case *ast.ConsiderStmt:
 		g.generateConsiderStmt(s)
 	case *ast.StatusStmt:
@@ -446,7 +792,7 @@ func (g *RustCodeGen) generateStmt(stmt ast.Stmt) {
 	case *ast.SelectStmt:
 		g.generateSelectStmt(s)
 	default:
-		g.writeln(fmt.Sprintf("// TODO: unhandled statement type: %T", stmt))
+		g.addError(fmt.Sprintf("%s is not supported by the Rust backend", stmtName(stmt)))
 	}
 }
 
@@ -479,8 +825,8 @@ func (g *RustCodeGen) generateLetAssign(la *ast.LetAssign) {
 	sVar := g.sVar(stackName)
 	escapedName := escapeIdent(la.Name)
 	
-	if g.vars[la.Name] {
-		g.writeln(fmt.Sprintf("%s = %s.pop().unwrap_or_default();", escapedName, sVar))
+	if g.isVar(la.Name) {
+		g.setVar(la.Name, sVar+".pop().unwrap_or_default()")
 	} else {
 		g.vars[la.Name] = true
 		g.writeln(fmt.Sprintf("let mut %s = %s.pop().unwrap_or_default();", escapedName, sVar))
@@ -493,6 +839,9 @@ func (g *RustCodeGen) generateViewDecl(vd *ast.ViewDecl) {
 	if perspective == "" {
 		perspective = "LIFO"
 	}
+	if perspective == "Broadcast" {
+		g.addError(fmt.Sprintf("view %s: Broadcast views are not supported by the Rust backend yet", vd.Name))
+	}
 	g.views[vd.Name] = perspective
 	// Track view but don't generate code - views are virtual in our implementation
 	g.writeln(fmt.Sprintf("// View %s created with perspective %s", vd.Name, perspective))
@@ -539,6 +888,12 @@ func (g *RustCodeGen) generateViewOp(vo *ast.ViewOp) {
 			sVar := g.sVar(stackName)
 			g.writeln(fmt.Sprintf("// peek on %s (view %s with %s perspective)", sVar, viewName, perspective))
 		}
+	case "slice", "skip", "take", "unslice":
+		// Views are virtual in this backend, so there is no cursor to window
+		g.addError(fmt.Sprintf("view %s: %s is not supported by the Rust backend yet", viewName, vo.Op))
+	case "resync":
+		// Virtual views hold no cursor or index, so they never go stale
+		g.writeln(fmt.Sprintf("// resync on view %s (no-op)", viewName))
 	default:
 		g.writeln(fmt.Sprintf("// TODO: view op '%s' not implemented", vo.Op))
 	}
@@ -558,6 +913,24 @@ func (g *RustCodeGen) generateSpawnPush(s *ast.SpawnPush) {
 	g.writeln("tasks.push(Box::new(move || {")
 	g.indent++
 	
+	// into @results: the body becomes a closure returning Option<T>
+	var resultsVar string
+	savedResultType := g.spawnResultType
+	savedFuncDefers := g.funcDefers
+	g.spawnResultType = ""
+	if s.Into != "" {
+		elemType, ok := g.stacks[s.Into]
+		if !ok {
+			g.addError(fmt.Sprintf("@spawn into: undefined stack @%s", s.Into))
+			elemType = "i64"
+		}
+		resultsVar = g.sVar(s.Into)
+		g.spawnResultType = elemType
+		g.funcDefers = nil
+		g.writeln(fmt.Sprintf("rual::run_for_result(|| -> Option<%s> {", elemType))
+		g.indent++
+	}
+	
 	// Create thread-local operational stacks with different names
 	// This prevents race conditions when multiple threads use dstack/rstack
 	g.writeln("let _dstack: Stack<i64> = Stack::new(Perspective::LIFO);")
@@ -578,6 +951,16 @@ func (g *RustCodeGen) generateSpawnPush(s *ast.SpawnPush) {
 	g.spawnLocalStacks = savedLocalStacks
 	g.inSpawnBlock = savedInSpawn
 	
+	if s.Into != "" {
+		if n := len(s.Body); n == 0 || !isReturnStmt(s.Body[n-1]) {
+			g.writeln("None")
+		}
+		g.indent--
+		g.writeln(fmt.Sprintf("}, &%s, &%s);", resultsVar, g.sVar("error")))
+	}
+	g.spawnResultType = savedResultType
+	g.funcDefers = savedFuncDefers
+	
 	g.indent--
 	g.writeln("}));")
 	g.indent--
@@ -587,6 +970,25 @@ func (g *RustCodeGen) generateSpawnPush(s *ast.SpawnPush) {
 	g.vars = savedVars
 }
 
+// generateAtExitStmt registers an exit hook. Like a spawn block the body
+// is a move closure, so it sees variables as they were when registered.
+func (g *RustCodeGen) generateAtExitStmt(a *ast.AtExitStmt) {
+	savedVars := make(map[string]bool)
+	for k, v := range g.vars {
+		savedVars[k] = v
+	}
+	
+	g.writeln("rual::at_exit(move || {")
+	g.indent++
+	for _, stmt := range a.Body {
+		g.generateStmt(stmt)
+	}
+	g.indent--
+	g.writeln("});")
+	
+	g.vars = savedVars
+}
+
 // generateSpawnOp generates spawn operations (pop, play, len, clear)
 func (g *RustCodeGen) generateSpawnOp(s *ast.SpawnOp) {
 	switch s.Op {
@@ -623,7 +1025,8 @@ func (g *RustCodeGen) generateSpawnOp(s *ast.SpawnOp) {
 			g.writeln("};")
 			g.writeln("if let Some(task) = task_opt {")
 			g.indent++
-			g.writeln("std::thread::spawn(move || { task(); });")
+			g.writeln("let guard = SPAWN_GROUP.start();")
+			g.writeln("std::thread::spawn(move || { let _guard = guard; task(); });")
 			g.indent--
 			g.writeln("}")
 			g.indent--
@@ -656,20 +1059,31 @@ func (g *RustCodeGen) generateSpawnOp(s *ast.SpawnOp) {
 		g.indent--
 		g.writeln("}")
 		
+	case "wait":
+		// @spawn wait - block until played tasks finish; wait(ms) gives up
+		// after a timeout with the "timeout" status and the tasks left
+		if len(s.Args) == 0 {
+			g.writeln("SPAWN_GROUP.wait(None);")
+			break
+		}
+		g.writeln(fmt.Sprintf("if !SPAWN_GROUP.wait(Some((%s) as u64)) {", g.generateExpr(s.Args[0])))
+		g.indent++
+		g.writeln("CONSIDER_STATUS.with(|s| *s.borrow_mut() = String::from(\"timeout\"));")
+		g.writeln("CONSIDER_VALUE.with(|v| *v.borrow_mut() = SPAWN_GROUP.pending().to_string());")
+		g.indent--
+		g.writeln("}")
+		
 	default:
 		g.writeln(fmt.Sprintf("// TODO: spawn op '%s' not implemented", s.Op))
 	}
 }
 
 // generateSelectStmt generates a select statement (concurrent wait on multiple stacks)
-func (g *RustCodeGen) generateSelectStmt(s *ast.SelectStmt) {
-	// Check if we have a default case (makes it non-blocking)
-	hasDefault := false
-	for _, cas := range s.Cases {
-		if cas.Stack == "_" {
-			hasDefault = true
-			break
-		}
+func (g *RustCodeGen) generateSelectStmt(ss *ast.SelectStmt) {
+	s, err := ir.LowerSelect(ss)
+	if err != nil {
+		g.addError(err.Error())
+		return
 	}
 	
 	g.writeln("// select block")
@@ -677,93 +1091,71 @@ func (g *RustCodeGen) generateSelectStmt(s *ast.SelectStmt) {
 	g.indent++
 	
 	// Execute setup block first
-	if s.Block != nil {
+	if s.Setup != nil {
 		g.writeln("// setup")
-		g.generateStackBlock(s.Block)
+		g.generateStackBlock(s.Setup)
 		g.writeln("")
 	}
 	
-	// For non-blocking select (has default), use simple sequential checks
-	if hasDefault {
-		g.writeln("// Non-blocking select: check stacks in order")
-		
-		firstCase := true
-		for _, cas := range s.Cases {
-			if cas.Stack == "_" {
-				continue
-			}
-			
-			sVar := g.sVar(cas.Stack)
-			
-			if firstCase {
-				g.writeln(fmt.Sprintf("if !%s.is_empty() {", sVar))
-				firstCase = false
-			} else {
-				g.indent--
-				g.writeln(fmt.Sprintf("} else if !%s.is_empty() {", sVar))
-			}
-			g.indent++
-			
-			g.writeln(fmt.Sprintf("let _v = %s.pop().unwrap_or_default();", sVar))
-			
-			// Bind value to variable if requested
-			if len(cas.Bindings) > 0 {
-				bindName := cas.Bindings[0]
-				g.writeln(fmt.Sprintf("let %s = _v;", escapeIdent(bindName)))
-				g.vars[bindName] = true
-			}
-			
-			// Generate handler
-			for _, stmt := range cas.Handler {
-				g.generateStmt(stmt)
-			}
-		}
-		
-		// Generate default case
-		for _, cas := range s.Cases {
-			if cas.Stack == "_" {
-				g.indent--
-				g.writeln("} else {")
-				g.indent++
-				for _, stmt := range cas.Handler {
-					g.generateStmt(stmt)
-				}
-				g.indent--
-			}
+	// Stack each case waits on, and which are ready for rual::select_pick
+	sources := make([]string, len(s.Cases))
+	ready := make([]string, len(s.Cases))
+	for i, cas := range s.Cases {
+		sources[i] = g.selectSource(cas)
+		ready[i] = fmt.Sprintf("!%s.is_empty()", sources[i])
+	}
+	pick := fmt.Sprintf("rual::select_pick(&[%s], %t)", strings.Join(ready, ", "), s.Fair)
+	
+	// For non-blocking select (has default), run a ready case or the default
+	if !s.Waits {
+		g.writeln("// Non-blocking select: run a ready case, if any")
+		g.writeln(fmt.Sprintf("match %s {", pick))
+		g.indent++
+		g.generateSelectArms(s, sources, false)
+		g.writeln("_ => {")
+		g.indent++
+		for _, stmt := range s.Default {
+			g.generateStmt(stmt)
 		}
+		g.indent--
+		g.writeln("}")
+		g.indent--
 		g.writeln("}")
 	} else {
-		// Blocking select - poll until one has data
+		// Blocking select - poll until one has data or a timeout expires
+		start := fmt.Sprintf("_select_start_%d", g.ids.next("select"))
+		hasTimeout := false
+		for _, cas := range s.Cases {
+			hasTimeout = hasTimeout || cas.Timeout != nil
+		}
+		if hasTimeout {
+			g.writeln(fmt.Sprintf("let mut %s = std::time::Instant::now();", start))
+		}
 		g.writeln("// Blocking select: poll stacks until one has data")
 		g.writeln("loop {")
 		g.indent++
-		
+		g.writeln(fmt.Sprintf("match %s {", pick))
+		g.indent++
+		g.generateSelectArms(s, sources, true)
+		g.writeln("_ => {}")
+		g.indent--
+		g.writeln("}")
 		for _, cas := range s.Cases {
-			if cas.Stack == "_" {
-				continue
-			}
-			
-			sVar := g.sVar(cas.Stack)
-			g.writeln(fmt.Sprintf("if !%s.is_empty() {", sVar))
-			g.indent++
-			
-			g.writeln(fmt.Sprintf("let _v = %s.pop().unwrap_or_default();", sVar))
-			
-			// Bind value to variable if requested
-			if len(cas.Bindings) > 0 {
-				bindName := cas.Bindings[0]
-				g.writeln(fmt.Sprintf("let %s = _v;", escapeIdent(bindName)))
-				g.vars[bindName] = true
-			}
-			
-			// Generate handler
-			for _, stmt := range cas.Handler {
-				g.generateStmt(stmt)
+			if t := cas.Timeout; t != nil {
+				g.writeln(fmt.Sprintf("if %s.elapsed().as_millis() as i64 >= (%s) as i64 {", start, g.generateExpr(t.Ms)))
+				g.indent++
+				for _, stmt := range t.Body {
+					g.generateStmt(stmt)
+				}
+				if t.Retry {
+					g.writeln(fmt.Sprintf("%s = std::time::Instant::now();", start))
+					g.writeln("continue;")
+				} else {
+					g.writeln("break;")
+				}
+				g.indent--
+				g.writeln("}")
 			}
-			
-			g.writeln("break;")
-			g.indent--
-			g.writeln("}")
 		}
 		
 		// Small sleep to prevent busy-wait
@@ -777,22 +1169,85 @@ func (g *RustCodeGen) generateSelectStmt(s *ast.SelectStmt) {
 	g.writeln("}")
 }
 
+// generateSelectArms generates one match arm per non-default select case,
+// numbered as rual::select_pick numbers them. Arms of a blocking select end
+// its polling loop.
+func (g *RustCodeGen) generateSelectArms(s *ir.Select, sources []string, blocking bool) {
+	for i, cas := range s.Cases {
+		g.writeln(fmt.Sprintf("Some(%d) => {", i))
+		g.indent++
+		g.writeln(fmt.Sprintf("let _v = %s.pop().unwrap_or_default();", sources[i]))
+		
+		// Bind value to variable if requested
+		if cas.Bind != "" {
+			g.writeln(fmt.Sprintf("let %s = _v;", escapeIdent(cas.Bind)))
+			g.vars[cas.Bind] = true
+		}
+		
+		// Generate handler
+		for _, stmt := range cas.Body {
+			g.generateStmt(stmt)
+		}
+		if blocking {
+			g.writeln("break;")
+		}
+		g.indent--
+		g.writeln("}")
+	}
+}
+
+// selectSource returns the stack a select case waits on. Timer and signal
+// sources live in a static created the first time the select runs, so a
+// select inside a loop keeps its ticker and its signal registration.
+func (g *RustCodeGen) selectSource(cas ir.SelectCase) string {
+	switch cas.Kind {
+	case ast.SelectTimer:
+		n := g.ids.next("source")
+		src := fmt.Sprintf("SELECT_SRC_%d", n)
+		g.writeln(fmt.Sprintf("static %s: std::sync::OnceLock<std::sync::Arc<Stack<i64>>> = std::sync::OnceLock::new();", src))
+		g.writeln(fmt.Sprintf("let _src_%d = %s.get_or_init(|| rual::every((%s) as i64));", n, src, g.generateExpr(cas.Every)))
+		return fmt.Sprintf("_src_%d", n)
+	case ast.SelectSignal:
+		n := g.ids.next("source")
+		src := fmt.Sprintf("SELECT_SRC_%d", n)
+		g.writeln(fmt.Sprintf("static %s: std::sync::OnceLock<std::sync::Arc<Stack<String>>> = std::sync::OnceLock::new();", src))
+		g.writeln(fmt.Sprintf("let _src_%d = %s.get_or_init(|| rual::signals(&[%q]).expect(\"@signal\"));", n, src, cas.Signal))
+		return fmt.Sprintf("_src_%d", n)
+	}
+	return g.sVar(cas.Stack)
+}
+
+// varDeclType returns the Rust type of the i'th variable vd declares:
+// the one given, or the one of its value
+func (g *RustCodeGen) varDeclType(vd *ast.VarDecl, i int) string {
+	if vd.Type != "" {
+		return g.ualTypeToRust(valueType(vd.Type))
+	}
+	if i < len(vd.Values) && vd.Values[i] != nil {
+		// Infer type from initializer expression
+		return g.inferTypeFromExpr(vd.Values[i])
+	}
+	return "i64" // default
+}
+
 // generateVarDecl generates a variable declaration
 func (g *RustCodeGen) generateVarDecl(vd *ast.VarDecl) {
 	for i, name := range vd.Names {
-		// Determine the type - either explicit or inferred from value
-		var rustType string
-		if vd.Type != "" {
-			rustType = g.ualTypeToRust(vd.Type)
-		} else if i < len(vd.Values) && vd.Values[i] != nil {
-			// Infer type from initializer expression
-			rustType = g.inferTypeFromExpr(vd.Values[i])
-		} else {
-			rustType = "i64" // default
-		}
-		
+		rustType := g.varDeclType(vd, i)
 		escapedName := escapeIdent(name)
 		
+		// A global's var statement sets its static
+		if g.globalDecls[vd] && g.globals[name] != "" {
+			rustType = g.globals[name]
+			g.varTypes[name] = rustType
+			val := g.defaultValue(rustType)
+			if i < len(vd.Values) && vd.Values[i] != nil {
+				val = g.generateExprForType(vd.Values[i], rustType)
+			}
+			g.writeln(fmt.Sprintf("%s.set(%s);", globalStatic(name), val))
+			continue
+		}
+		
 		// Check if variable was already declared (e.g., by let:name)
 		if g.vars[name] {
 			// Variable exists - comment out the re-declaration like Go does
@@ -809,7 +1264,7 @@ func (g *RustCodeGen) generateVarDecl(vd *ast.VarDecl) {
 		g.varTypes[name] = rustType
 		
 		if i < len(vd.Values) && vd.Values[i] != nil {
-			val := g.generateExpr(vd.Values[i])
+			val := g.generateExprForType(vd.Values[i], rustType)
 			g.writeln(fmt.Sprintf("let mut %s: %s = %s;", escapedName, rustType, val))
 		} else {
 			defaultVal := g.defaultValue(rustType)
@@ -818,6 +1273,51 @@ func (g *RustCodeGen) generateVarDecl(vd *ast.VarDecl) {
 	}
 }
 
+// generateArgsDecl parses the process arguments against an args block,
+// stores every value in @args and declares one variable per entry.
+func (g *RustCodeGen) generateArgsDecl(a *ast.ArgsDecl) {
+	if g.argsDeclared {
+		g.addError("only one args block is allowed")
+		return
+	}
+	if g.stacks["args"] != "string" || g.perspectives["args"] != "Hash" || g.inFunction {
+		g.addError("args block must be at top level and @args must not be redeclared")
+		return
+	}
+	g.argsDeclared = true
+	
+	g.writeln(fmt.Sprintf("let _args = rual::parse_args_or_exit(%q, &[", a.Program))
+	g.indent++
+	for _, sp := range a.Specs {
+		def := "None"
+		if sp.Default != nil {
+			if lit, ok := argDefaultLiteral(sp.Default); ok {
+				def = fmt.Sprintf("Some(%q.to_string())", lit)
+			} else {
+				def = fmt.Sprintf("Some(format!(\"{}\", %s))", g.generateExpr(sp.Default))
+			}
+		}
+		g.writeln(fmt.Sprintf("rual::ArgSpec { kind: %q, name: %q, short: %q, ty: %q, default: %s, help: %q },",
+			sp.Kind, sp.Name, sp.Short, sp.Type, def, sp.Help))
+	}
+	g.indent--
+	g.writeln("]);")
+	g.writeln(fmt.Sprintf("_args.fill(&%s).ok();", g.sVar("args")))
+	
+	for _, sp := range a.Specs {
+		name := argVarName(sp.Name)
+		rustType := g.ualTypeToRust(sp.Type)
+		getter := map[string]string{"bool": "get_bool", "i64": "get_i64", "f64": "get_f64"}[sp.Type]
+		if getter == "" {
+			getter = "get_string"
+		}
+		g.vars[name] = true
+		g.varTypes[name] = rustType
+		g.writeln(fmt.Sprintf("let mut %s: %s = _args.%s(%q);",
+			escapeIdent(name), rustType, getter, sp.Name))
+	}
+}
+
 // inferTypeFromExpr infers the Rust type from an expression
 func (g *RustCodeGen) inferTypeFromExpr(expr ast.Expr) string {
 	switch e := expr.(type) {
@@ -825,10 +1325,15 @@ func (g *RustCodeGen) inferTypeFromExpr(expr ast.Expr) string {
 		return "i64"
 	case *ast.FloatLit:
 		return "f64"
-	case *ast.StringLit:
+	case *ast.StringLit, *ast.InterpString:
 		return "String"
 	case *ast.BoolLit:
 		return "bool"
+	case *ast.BinaryOp:
+		if _, ok := concatParts(e); ok {
+			return "String"
+		}
+		return "i64"
 	case *ast.UnaryExpr:
 		// Handle negation - type depends on operand
 		return g.inferTypeFromExpr(e.Operand)
@@ -847,6 +1352,24 @@ func (g *RustCodeGen) inferTypeFromExpr(expr ast.Expr) string {
 			return "f64"
 		}
 		return leftType
+	case *ast.FuncCall:
+		switch e.Name {
+		case "render", "color", "prompt", "password", "uuid4", "ulid", "format_int", "format_float", "format", "readfile", "readline", "env":
+			return "String"
+		case "is_tty", "confirm", "writefile", "appendfile", "exists", "setenv", "write_csv", "listen":
+			return "bool"
+		case "rand":
+			return "f64"
+		}
+		return "i64"
+	case *ast.StackExpr:
+		if e.Op == "to_json" {
+			return "String"
+		}
+		if e.Op == "get" {
+			return g.ualTypeToRust(g.getStackElementType(e.Stack))
+		}
+		return "i64"
 	default:
 		return "i64"
 	}
@@ -854,17 +1377,29 @@ func (g *RustCodeGen) inferTypeFromExpr(expr ast.Expr) string {
 
 // generateAssignStmt generates an assignment (reassignment)
 func (g *RustCodeGen) generateAssignStmt(as *ast.AssignStmt) {
-	val := g.generateExpr(as.Value)
-	g.writeln(fmt.Sprintf("%s = %s;", escapeIdent(as.Name), val))
+	g.setVar(as.Name, g.generateAssignedValue(as.Name, as.Value))
+}
+
+// generateAssignedValue generates expr, assigned to the variable name, as
+// a value of the variable's type
+func (g *RustCodeGen) generateAssignedValue(name string, expr ast.Expr) string {
+	if t := g.varTypes[name]; t != "" {
+		return g.generateExprForType(expr, t)
+	}
+	return g.generateExpr(expr)
 }
 
 // generateAssignment generates an assignment (initial)
 func (g *RustCodeGen) generateAssignment(a *ast.Assignment) {
-	val := g.generateExpr(a.Expr)
 	escapedName := escapeIdent(a.Name)
-	if g.vars[a.Name] {
-		g.writeln(fmt.Sprintf("%s = %s;", escapedName, val))
+	if g.isVar(a.Name) {
+		g.setVar(a.Name, g.generateAssignedValue(a.Name, a.Expr))
+		// Top-level ones are printed at the end, as in the Go backend
+		if !g.inFunction && !g.inCodeblock && g.depth == 0 && !contains(g.varOrder, a.Name) {
+			g.varOrder = append(g.varOrder, a.Name)
+		}
 	} else {
+		val := g.generateExpr(a.Expr)
 		// Track order for auto-print
 		g.varOrder = append(g.varOrder, a.Name)
 		g.vars[a.Name] = true
@@ -872,48 +1407,89 @@ func (g *RustCodeGen) generateAssignment(a *ast.Assignment) {
 	}
 }
 
+// generateBlock generates the statements of a block, whose variables end
+// with it
+func (g *RustCodeGen) generateBlock(stmts []ast.Stmt) {
+	defer g.scope()()
+	for _, stmt := range stmts {
+		g.generateStmt(stmt)
+	}
+}
+
 // generateIfStmt generates an if statement
 func (g *RustCodeGen) generateIfStmt(is *ast.IfStmt) {
-	cond := g.generateExpr(is.Condition)
+	cond := g.generateCondition(is.Condition)
 	g.writeln(fmt.Sprintf("if %s {", cond))
 	g.indent++
-	
-	for _, stmt := range is.Body {
-		g.generateStmt(stmt)
-	}
-	
+	g.generateBlock(is.Body)
 	g.indent--
 	
 	// Handle elseif branches
 	for _, elseif := range is.ElseIfs {
-		cond := g.generateExpr(elseif.Condition)
+		cond := g.generateCondition(elseif.Condition)
 		g.writeln("} else if " + cond + " {")
 		g.indent++
-		for _, stmt := range elseif.Body {
-			g.generateStmt(stmt)
-		}
+		g.generateBlock(elseif.Body)
 		g.indent--
 	}
 	
 	if len(is.Else) > 0 {
 		g.writeln("} else {")
 		g.indent++
-		for _, stmt := range is.Else {
-			g.generateStmt(stmt)
-		}
+		g.generateBlock(is.Else)
 		g.indent--
 	}
 	
 	g.writeln("}")
 }
 
+// generateCondition generates a bool for an if or while condition. A
+// value that is not a comparison is true when it is not zero.
+func (g *RustCodeGen) generateCondition(cond ast.Expr) string {
+	switch c := cond.(type) {
+	case *ast.BinaryExpr:
+		switch c.Op {
+		case "&&", "||":
+			return fmt.Sprintf("(%s %s %s)", g.generateCondition(c.Left), c.Op, g.generateCondition(c.Right))
+		case "==", "!=", "<", ">", "<=", ">=":
+			return g.generateExpr(c)
+		}
+	case *ast.UnaryExpr:
+		if c.Op == "!" {
+			return fmt.Sprintf("!%s", g.generateCondition(c.Operand))
+		}
+	case *ast.BoolLit:
+		return g.generateExpr(c)
+	}
+	switch g.inferTypeFromExpr(cond) {
+	case "bool":
+		return g.generateExpr(cond)
+	case "f64":
+		return fmt.Sprintf("(%s != 0.0)", g.generateExpr(cond))
+	}
+	return fmt.Sprintf("(%s != 0)", g.generateExpr(cond))
+}
+
 // generateWhileStmt generates a while loop
 func (g *RustCodeGen) generateWhileStmt(ws *ast.WhileStmt) {
-	cond := g.generateExpr(ws.Condition)
+	cond := g.generateCondition(ws.Condition)
 	g.writeln(fmt.Sprintf("while %s {", cond))
 	g.indent++
+	g.generateBlock(ws.Body)
 	
-	for _, stmt := range ws.Body {
+	g.indent--
+	g.writeln("}")
+}
+
+// generateRangeStmt generates a for loop over start..end
+func (g *RustCodeGen) generateRangeStmt(rs *ast.RangeStmt) {
+	start := g.generateExprForType(rs.Start, "i64")
+	end := g.generateExprForType(rs.End, "i64")
+	g.writeln(fmt.Sprintf("for %s in (%s)..(%s) {", rs.Var, start, end))
+	g.indent++
+	defer g.scope()()
+	g.vars[rs.Var] = true
+	for _, stmt := range rs.Body {
 		g.generateStmt(stmt)
 	}
 	
@@ -925,11 +1501,20 @@ func (g *RustCodeGen) generateWhileStmt(ws *ast.WhileStmt) {
 func (g *RustCodeGen) generateForStmt(fs *ast.ForStmt) {
 	sVar := g.sVar(fs.Stack)
 	
+	if fs.Perspective == "hash" || (fs.Perspective == "" && g.perspectives[fs.Stack] == "Hash") {
+		g.generateHashForStmt(fs)
+		return
+	}
+	
 	// Determine iteration direction based on perspective
 	ascending := false
 	if fs.Perspective == "fifo" || fs.Perspective == "indexed" {
 		ascending = true
 	}
+	if fs.Perspective == "" {
+		// The stack's own perspective, as in iual
+		ascending = g.perspectives[fs.Stack] == "FIFO" || g.perspectives[fs.Stack] == "Indexed"
+	}
 	
 	g.writeln("{")
 	g.indent++
@@ -947,78 +1532,527 @@ func (g *RustCodeGen) generateForStmt(fs *ast.ForStmt) {
 	
 	// Bind iteration variables if provided
 	// Params[0] = index, Params[1] = value (same as Go's |i,v| syntax)
+	defer g.scope()()
+	for _, p := range fs.Params {
+		g.vars[p] = true
+	}
 	if len(fs.Params) == 0 {
 		// No params: push value to DSTACK
 		g.writeln(fmt.Sprintf("{ let _v = _for_guard.get_at_raw(_for_idx).cloned().unwrap_or_default(); %s.push(_v).ok(); }", g.sVar("dstack")))
 	} else if len(fs.Params) == 1 {
 		// Single param is the value - use raw index access
 		g.writeln(fmt.Sprintf("let %s = _for_guard.get_at_raw(_for_idx).cloned().unwrap_or_default();", fs.Params[0]))
+		g.varTypes[fs.Params[0]] = g.ualTypeToRust(g.stacks[fs.Stack])
 	} else if len(fs.Params) >= 2 {
 		// Two params: first is index, second is value
 		g.writeln(fmt.Sprintf("let %s = _for_idx as i64;", fs.Params[0]))
 		g.writeln(fmt.Sprintf("let %s = _for_guard.get_at_raw(_for_idx).cloned().unwrap_or_default();", fs.Params[1]))
+		g.varTypes[fs.Params[0]] = "i64"
+		g.varTypes[fs.Params[1]] = g.ualTypeToRust(g.stacks[fs.Stack])
+	}
+	
+	for _, stmt := range fs.Body {
+		g.generateStmt(stmt)
+	}
+	
+	g.indent--
+	g.writeln("}")
+	g.indent--
+	g.writeln("}")
+}
+
+// hashKeyArg returns the key of a del or has op, which must be a string
+// literal on a Hash stack
+func (g *RustCodeGen) hashKeyArg(op *ast.StackOp) (string, bool) {
+	if p, ok := g.perspectives[op.Stack]; ok && p != "Hash" {
+		g.addError(fmt.Sprintf("@%s %s(): requires a Hash stack", op.Stack, op.Op))
+		return "", false
+	}
+	if len(op.Args) != 1 {
+		g.addError(fmt.Sprintf("@%s %s() takes one key", op.Stack, op.Op))
+		return "", false
+	}
+	lit, ok := op.Args[0].(*ast.StringLit)
+	if !ok {
+		g.addError(fmt.Sprintf("@%s %s(): key must be a string literal", op.Stack, op.Op))
+		return "", false
+	}
+	return lit.Value, true
+}
+
+// generateHashForStmt iterates a Hash stack in key insertion order. The
+// keys are snapshotted first, so the body may use the stack; keys it pops
+// before their turn are skipped.
+func (g *RustCodeGen) generateHashForStmt(fs *ast.ForStmt) {
+	sVar := g.sVar(fs.Stack)
+	valType := g.ualTypeToRust(g.stacks[fs.Stack])
+	
+	g.writeln(fmt.Sprintf("for _for_key in %s.keys() {", sVar))
+	g.indent++
+	g.writeln(fmt.Sprintf("let _for_val = match %s.peek_key(&_for_key) { Ok(v) => v, Err(_) => continue };", sVar))
+	defer g.scope()()
+	for _, p := range fs.Params {
+		g.vars[p] = true
+	}
+	switch len(fs.Params) {
+	case 0:
+		g.writeln(fmt.Sprintf("%s.push(_for_val).ok();", g.sVar("dstack")))
+	case 1:
+		g.writeln(fmt.Sprintf("let %s = _for_val;", fs.Params[0]))
+		g.varTypes[fs.Params[0]] = valType
+	default:
+		g.writeln(fmt.Sprintf("let %s = _for_key.clone();", fs.Params[0]))
+		g.writeln(fmt.Sprintf("let %s = _for_val;", fs.Params[1]))
+		g.varTypes[fs.Params[0]] = "String"
+		g.varTypes[fs.Params[1]] = valType
+	}
+	
+	for _, stmt := range fs.Body {
+		g.generateStmt(stmt)
+	}
+	
+	g.indent--
+	g.writeln("}")
+}
+
+// generateReturnStmt generates a return statement
+func (g *RustCodeGen) generateReturnStmt(rs *ast.ReturnStmt) {
+	if g.tailCalls[rs] {
+		g.generateTailCall(rs.Value.(*ast.FuncCall))
+		return
+	}
+	if g.inCodeblock {
+		if rs.Value == nil {
+			g.writeln("return 0;")
+			return
+		}
+		g.writeln(fmt.Sprintf("return (%s) as i64;", g.generateExpr(rs.Value)))
+		return
+	}
+	if g.spawnResultType != "" {
+		// Inside "@spawn < { } into @results": hand the value to run_for_result
+		if rs.Value == nil {
+			g.writeln("return None;")
+			return
+		}
+		g.writeln(fmt.Sprintf("return Some(%s);", g.generateExprForType(rs.Value, g.spawnResultType)))
+		return
+	}
+	// Execute function-level defers before return (LIFO order)
+	if len(g.funcDefers) > 0 {
+		// If there's a return value, store it first
+		if rs.Value != nil || len(rs.Values) > 0 {
+			var retExpr string
+			if rs.Value != nil {
+				retExpr = g.generateReturnValue(rs.Value)
+			} else if len(rs.Values) == 1 {
+				retExpr = g.generateExpr(rs.Values[0])
+			} else {
+				var vals []string
+				for _, v := range rs.Values {
+					vals = append(vals, g.generateExpr(v))
+				}
+				retExpr = fmt.Sprintf("(%s)", strings.Join(vals, ", "))
+			}
+			g.writeln(fmt.Sprintf("let _ret_val = %s;", retExpr))
+		}
+		
+		// Execute defers in LIFO order
+		for i := len(g.funcDefers) - 1; i >= 0; i-- {
+			d := g.funcDefers[i]
+			for _, stmt := range d.Body {
+				g.generateStmt(stmt)
+			}
+		}
+		
+		// Return the stored value or void
+		if rs.Value != nil || len(rs.Values) > 0 {
+			g.writeln("return _ret_val;")
+		} else {
+			g.writeln("return;")
+		}
+	} else {
+		// No defers, emit simple return
+		if rs.Value != nil {
+			g.writeln(fmt.Sprintf("return %s;", g.generateReturnValue(rs.Value)))
+		} else if len(rs.Values) == 0 {
+			g.writeln("return;")
+		} else if len(rs.Values) == 1 {
+			g.writeln(fmt.Sprintf("return %s;", g.generateExpr(rs.Values[0])))
+		} else {
+			var vals []string
+			for _, v := range rs.Values {
+				vals = append(vals, g.generateExpr(v))
+			}
+			g.writeln(fmt.Sprintf("return (%s);", strings.Join(vals, ", ")))
+		}
+	}
+}
+
+// codeblockExpr generates a codeblock's body expression with every
+// parameter bound to typ, so string concatenation and float literals are
+// generated for the right type.
+func (g *RustCodeGen) codeblockExpr(body ast.Expr, params []string, typ string) string {
+	saved := make(map[string]string)
+	for _, p := range params {
+		if t, ok := g.varTypes[p]; ok {
+			saved[p] = t
+		}
+		g.varTypes[p] = typ
+	}
+	var expr string
+	if isNumericType(typ) {
+		expr = g.generateComputeExpr(body, typ)
+	} else {
+		expr = g.generateExpr(body)
+	}
+	for _, p := range params {
+		if t, ok := saved[p]; ok {
+			g.varTypes[p] = t
+		} else {
+			delete(g.varTypes, p)
+		}
+	}
+	return expr
+}
+
+// generateFnValue generates a codeblock used as a value, {|x| ...} stored,
+// passed or pushed. The closure is registered with rual::new_fn just
+// before the statement that uses it, and the value is its handle. It moves
+// in copies of the variables it captures (see closure.go); its result is
+// its single expression, or what it returns, or 0.
+func (g *RustCodeGen) generateFnValue(f *ast.FnLit) string {
+	name := fmt.Sprintf("_fn%d", g.ids.next("fn"))
+	
+	var captures []string
+	for _, v := range codeblockCaptures(f) {
+		if g.vars[v] {
+			captures = append(captures, v)
+			g.writeln(fmt.Sprintf("let %s_%s = %s.clone();", name, v, escapeIdent(v)))
+		}
+	}
+	
+	g.writeln(fmt.Sprintf("let %s = rual::new_fn(%d, move |_args: &[i64]| -> i64 {", name, len(f.Params)))
+	g.indent++
+	savedVars, savedDefers := g.vars, g.funcDefers
+	savedResult, savedIn := g.spawnResultType, g.inCodeblock
+	g.vars, g.funcDefers = make(map[string]bool), nil
+	g.spawnResultType, g.inCodeblock = "", true
+	
+	for _, v := range captures {
+		g.vars[v] = true
+		g.writeln(fmt.Sprintf("let mut %s = %s_%s.clone();", escapeIdent(v), name, v))
+	}
+	for i, p := range f.Params {
+		g.vars[p] = true
+		g.writeln(fmt.Sprintf("let mut %s: i64 = _args[%d];", escapeIdent(p), i))
+	}
+	
+	if expr := walkBodyExpr(f); expr != nil {
+		g.writeln(fmt.Sprintf("(%s) as i64", g.generateExpr(expr)))
+	} else {
+		for _, stmt := range f.Body {
+			g.generateStmt(stmt)
+		}
+		g.writeln("0")
+	}
+	
+	g.vars, g.funcDefers = savedVars, savedDefers
+	g.spawnResultType, g.inCodeblock = savedResult, savedIn
+	g.indent--
+	g.writeln("});")
+	return name
+}
+
+// generatePmapOp generates @s pmap({|x| ...}): every element is replaced
+// in place by Stack::par_map, which splits large stacks across threads.
+func (g *RustCodeGen) generatePmapOp(op *ast.StackOp, sVar string) {
+	fn, ok := (*ast.FnLit)(nil), len(op.Args) == 1
+	if ok {
+		fn, ok = op.Args[0].(*ast.FnLit)
+	}
+	if !ok || len(fn.Params) != 1 {
+		g.addError(fmt.Sprintf("@%s pmap requires a codeblock argument {|x| ...}", op.Stack))
+		return
+	}
+	body := walkBodyExpr(fn)
+	if body == nil {
+		g.addError(fmt.Sprintf("@%s pmap: codeblock must be a single expression", op.Stack))
+		return
+	}
+	
+	typ := g.ualTypeToRust(g.getStackElementType(op.Stack))
+	param := escapeIdent(fn.Params[0])
+	expr := g.codeblockExpr(body, fn.Params, typ)
+	g.writeln(fmt.Sprintf("if let Err(e) = %s.par_map(|%s: &%s| -> %s { let %s = %s.clone(); %s }) { %s.push(e.to_string()).ok(); }",
+		sVar, param, typ, typ, param, param, expr, g.sVar("error")))
+}
+
+// generateWalkOp generates @dst walk/filter/map(@src, {|x| ...}). The
+// semantics match the Go backend: @dst yields the results in @src's
+// perspective order, map clears @dst first, and failures go to @error.
+func (g *RustCodeGen) generateWalkOp(op *ast.StackOp, sVar string) {
+	if len(op.Args) != 2 {
+		g.addError(fmt.Sprintf("@%s %s requires (@source, {|x| ...}) arguments", op.Stack, op.Op))
+		return
+	}
+	ref, ok := op.Args[0].(*ast.StackRef)
+	if !ok {
+		if ident, isIdent := op.Args[0].(*ast.Ident); isIdent {
+			if _, isView := g.views[ident.Name]; isView {
+				g.addError(fmt.Sprintf("view %s: %s is not supported by the Rust backend yet", ident.Name, op.Op))
+				return
+			}
+		}
+		g.addError(fmt.Sprintf("@%s %s: first argument must be a stack", op.Stack, op.Op))
+		return
+	}
+	fn, ok := op.Args[1].(*ast.FnLit)
+	if !ok || len(fn.Params) != 1 {
+		g.addError(fmt.Sprintf("@%s %s: second argument must be a codeblock {|x| ...}", op.Stack, op.Op))
+		return
+	}
+	body := walkBodyExpr(fn)
+	if body == nil {
+		g.addError(fmt.Sprintf("@%s %s: codeblock must be a single expression", op.Stack, op.Op))
+		return
+	}
+	
+	srcVar := g.sVar(ref.Name)
+	srcType := g.ualTypeToRust(g.getStackElementType(ref.Name))
+	dstType := g.ualTypeToRust(g.getStackElementType(op.Stack))
+	param := escapeIdent(fn.Params[0])
+	
+	// The codeblock computes in the destination type, like the Go backend
+	decode := fmt.Sprintf("let %s = %s.clone();", param, param)
+	if srcType != dstType && isNumericType(srcType) && isNumericType(dstType) && op.Op != "filter" {
+		decode = fmt.Sprintf("let %s = *%s as %s;", param, param, dstType)
+	}
+	exprType := dstType
+	if op.Op == "filter" {
+		exprType = srcType
+	}
+	
+	expr := g.codeblockExpr(body, fn.Params, exprType)
+	
+	onErr := fmt.Sprintf("if let Err(e) = %%s { %s.push(e.to_string()).ok(); }", g.sVar("error"))
+	
+	if op.Op == "filter" {
+		if srcType != dstType {
+			g.addError(fmt.Sprintf("@%s filter: source is %s but destination is %s (use walk to convert)", op.Stack, srcType, dstType))
+			return
+		}
+		if !isComparison(body) {
+			switch exprType {
+			case "bool":
+			case "f64":
+				expr = fmt.Sprintf("%s != 0.0", expr)
+			case "String":
+				expr = fmt.Sprintf("!%s.is_empty()", expr)
+			default:
+				expr = fmt.Sprintf("%s != 0", expr)
+			}
+		}
+		call := fmt.Sprintf("%s.filter(&%s, |%s: &%s| { %s %s })", sVar, srcVar, param, srcType, decode, expr)
+		g.writeln(fmt.Sprintf(onErr, call))
+		return
+	}
+	
+	if op.Op == "map" {
+		g.writeln(fmt.Sprintf("%s.clear();", sVar))
+	}
+	call := fmt.Sprintf("%s.walk(&%s, |%s: &%s| -> %s { %s %s })", sVar, srcVar, param, srcType, dstType, decode, expr)
+	g.writeln(fmt.Sprintf(onErr, call))
+}
+
+// generateBringWith generates @dst bring(@src, {|x| ...}, {|x| pred}) via
+// Stack::bring_with. As in the Go backend, the transform computes in the
+// destination type and the predicate sees the source element.
+func (g *RustCodeGen) generateBringWith(op *ast.StackOp, sVar string) {
+	ref, ok := op.Args[0].(*ast.StackRef)
+	if !ok || len(op.Args) > 3 {
+		g.addError(fmt.Sprintf("@%s bring requires (@source, {|x| ...}) or (@source, {|x| ...}, {|x| pred}) arguments", op.Stack))
+		return
+	}
+	srcVar := g.sVar(ref.Name)
+	srcType := g.ualTypeToRust(g.getStackElementType(ref.Name))
+	dstType := g.ualTypeToRust(g.getStackElementType(op.Stack))
+	
+	fn, body := g.bringCodeblock(op, op.Args[1], "transform")
+	if body == nil {
+		return
+	}
+	param := escapeIdent(fn.Params[0])
+	decode := fmt.Sprintf("let %s = %s.clone();", param, param)
+	if srcType != dstType && isNumericType(srcType) && isNumericType(dstType) {
+		decode = fmt.Sprintf("let %s = *%s as %s;", param, param, dstType)
+	}
+	transform := fmt.Sprintf("|%s: &%s| -> %s { %s %s }", param, srcType, dstType, decode, g.codeblockExpr(body, fn.Params, dstType))
+	
+	pred := "|_| true"
+	if len(op.Args) == 3 {
+		fn, body := g.bringCodeblock(op, op.Args[2], "predicate")
+		if body == nil {
+			return
+		}
+		param := escapeIdent(fn.Params[0])
+		expr := g.codeblockExpr(body, fn.Params, srcType)
+		if !isComparison(body) {
+			switch srcType {
+			case "bool":
+			case "f64":
+				expr = fmt.Sprintf("%s != 0.0", expr)
+			case "String":
+				expr = fmt.Sprintf("!%s.is_empty()", expr)
+			default:
+				expr = fmt.Sprintf("%s != 0", expr)
+			}
+		}
+		pred = fmt.Sprintf("|%s: &%s| { let %s = %s.clone(); %s }", param, srcType, param, param, expr)
+	}
+	
+	g.writeln(fmt.Sprintf("if let Err(e) = %s.bring_with(&%s, %s, %s) { %s.push(e.to_string()).ok(); }",
+		sVar, srcVar, transform, pred, g.sVar("error")))
+}
+
+// bringCodeblock checks that a bring argument is a one-parameter,
+// single-expression codeblock and returns it with its expression
+func (g *RustCodeGen) bringCodeblock(op *ast.StackOp, arg ast.Expr, what string) (*ast.FnLit, ast.Expr) {
+	fn, ok := arg.(*ast.FnLit)
+	if !ok || len(fn.Params) != 1 {
+		g.addError(fmt.Sprintf("@%s bring: %s must be a codeblock {|x| ...}", op.Stack, what))
+		return nil, nil
+	}
+	body := walkBodyExpr(fn)
+	if body == nil {
+		g.addError(fmt.Sprintf("@%s bring: %s codeblock must be a single expression", op.Stack, what))
+	}
+	return fn, body
+}
+
+// generateCodecOp generates @dst b64encode(@src) and friends via
+// Stack::try_walk, converting each element with rual::Codec. Both stacks
+// must hold strings or bytes; decode failures are pushed to @error.
+func (g *RustCodeGen) generateCodecOp(op *ast.StackOp, sVar string) {
+	if len(op.Args) != 1 {
+		g.addError(fmt.Sprintf("@%s %s requires a source stack argument", op.Stack, op.Op))
+		return
+	}
+	ref, ok := op.Args[0].(*ast.StackRef)
+	if !ok {
+		g.addError(fmt.Sprintf("@%s %s: argument must be a stack", op.Stack, op.Op))
+		return
+	}
+	srcType := g.ualTypeToRust(g.getStackElementType(ref.Name))
+	dstType := g.ualTypeToRust(g.getStackElementType(op.Stack))
+	for _, t := range []string{srcType, dstType} {
+		if t != "String" && t != "Vec<u8>" {
+			g.addError(fmt.Sprintf("@%s %s: requires string or bytes stacks, not %s", op.Stack, op.Op, t))
+			return
+		}
+	}
+	
+	codec := "rual::Codec::Base64"
+	if strings.HasPrefix(op.Op, "hex") {
+		codec = "rual::Codec::Hex"
+	}
+	input := "x.as_slice()"
+	if srcType == "String" {
+		input = "x.as_bytes()"
+	}
+	
+	var conv string
+	if strings.HasSuffix(op.Op, "decode") {
+		conv = fmt.Sprintf("%s.decode(%s)", codec, input)
+		if dstType == "String" {
+			conv += ".map(|v| String::from_utf8_lossy(&v).into_owned())"
+		}
+	} else {
+		conv = fmt.Sprintf("%s.encode(%s)", codec, input)
+		if dstType == "Vec<u8>" {
+			conv += ".into_bytes()"
+		}
+		conv = fmt.Sprintf("Ok::<_, rual::CodecError>(%s)", conv)
+	}
+	
+	errVar := g.sVar("error")
+	g.writeln(fmt.Sprintf("if let Err(e) = %s.try_walk(&%s, |x: &%s| %s, |e| { %s.push(e.to_string()).ok(); }) { %s.push(e.to_string()).ok(); }",
+		sVar, g.sVar(ref.Name), srcType, conv, errVar, errVar))
+}
+
+// generatePackOp generates @blob pack(@s) and @s unpack(@blob) with
+// rual::encode_stack and rual::unpack_into. Failures go to @error.
+func (g *RustCodeGen) generatePackOp(op *ast.StackOp, sVar string) {
+	var ref *ast.StackRef
+	if len(op.Args) == 1 {
+		ref, _ = op.Args[0].(*ast.StackRef)
+	}
+	if ref == nil {
+		g.addError(fmt.Sprintf("@%s %s requires a stack argument", op.Stack, op.Op))
+		return
 	}
-	
-	for _, stmt := range fs.Body {
-		g.generateStmt(stmt)
+	blob := op.Stack
+	if op.Op == "unpack" {
+		blob = ref.Name
+	}
+	if t := g.getStackElementType(blob); t != "bytes" {
+		g.addError(fmt.Sprintf("@%s %s: @%s must be a bytes stack, not %s", op.Stack, op.Op, blob, t))
+		return
 	}
 	
-	g.indent--
-	g.writeln("}")
-	g.indent--
+	errVar := g.sVar("error")
+	if op.Op == "pack" {
+		g.writeln(fmt.Sprintf("if let Err(e) = %s.push(rual::encode_stack(&%s)) { %s.push(e.to_string()).ok(); }",
+			sVar, g.sVar(ref.Name), errVar))
+		return
+	}
+	g.writeln(fmt.Sprintf("match %s.pop() {", g.sVar(ref.Name)))
+	g.writeln(fmt.Sprintf("    Ok(b) => if let Err(e) = rual::unpack_into(&%s, &b) { %s.push(e.to_string()).ok(); },", sVar, errVar))
+	g.writeln(fmt.Sprintf("    Err(e) => { %s.push(format!(\"unpack: {}\", e)).ok(); }", errVar))
 	g.writeln("}")
 }
 
-// generateReturnStmt generates a return statement
-func (g *RustCodeGen) generateReturnStmt(rs *ast.ReturnStmt) {
-	// Execute function-level defers before return (LIFO order)
-	if len(g.funcDefers) > 0 {
-		// If there's a return value, store it first
-		if rs.Value != nil || len(rs.Values) > 0 {
-			var retExpr string
-			if rs.Value != nil {
-				retExpr = g.generateExpr(rs.Value)
-			} else if len(rs.Values) == 1 {
-				retExpr = g.generateExpr(rs.Values[0])
-			} else {
-				var vals []string
-				for _, v := range rs.Values {
-					vals = append(vals, g.generateExpr(v))
-				}
-				retExpr = fmt.Sprintf("(%s)", strings.Join(vals, ", "))
-			}
-			g.writeln(fmt.Sprintf("let _ret_val = %s;", retExpr))
-		}
-		
-		// Execute defers in LIFO order
-		for i := len(g.funcDefers) - 1; i >= 0; i-- {
-			d := g.funcDefers[i]
-			for _, stmt := range d.Body {
-				g.generateStmt(stmt)
-			}
-		}
-		
-		// Return the stored value or void
-		if rs.Value != nil || len(rs.Values) > 0 {
-			g.writeln("return _ret_val;")
-		} else {
-			g.writeln("return;")
-		}
-	} else {
-		// No defers, emit simple return
-		if rs.Value != nil {
-			g.writeln(fmt.Sprintf("return %s;", g.generateExpr(rs.Value)))
-		} else if len(rs.Values) == 0 {
-			g.writeln("return;")
-		} else if len(rs.Values) == 1 {
-			g.writeln(fmt.Sprintf("return %s;", g.generateExpr(rs.Values[0])))
-		} else {
-			var vals []string
-			for _, v := range rs.Values {
-				vals = append(vals, g.generateExpr(v))
-			}
-			g.writeln(fmt.Sprintf("return (%s);", strings.Join(vals, ", ")))
-		}
+// generateStringOp generates the text operations on a string stack.
+// strlen pushes the length to @dstack and contains pushes 1 or 0 there,
+// as has does. Positions and lengths count chars, as in the Go backend.
+func (g *RustCodeGen) generateStringOp(op *ast.StackOp, sVar string) {
+	if t := g.getStackElementType(op.Stack); t != "string" {
+		g.addError(fmt.Sprintf("@%s %s requires a string stack, not %s", op.Stack, op.Op, t))
+		return
+	}
+	want := map[string]int{"split": 1, "substr": 2, "contains": 1}[op.Op]
+	if len(op.Args) != want {
+		g.addError(fmt.Sprintf("@%s %s takes %d argument(s), got %d", op.Stack, op.Op, want, len(op.Args)))
+		return
+	}
+	
+	pop := fmt.Sprintf("let a: String = %s.pop().unwrap_or_default();", sVar)
+	switch op.Op {
+	case "concat":
+		g.writeln(fmt.Sprintf("{ let b: String = %s.pop().unwrap_or_default(); %s %s.push(a + &b).ok(); }", sVar, pop, sVar))
+	case "split":
+		g.writeln(fmt.Sprintf("{ %s let sep: String = %s;", pop, g.generateExpr(op.Args[0])))
+		g.indent++
+		g.writeln("let parts: Vec<String> = if sep.is_empty() { a.chars().map(String::from).collect() } else { a.split(sep.as_str()).map(String::from).collect() };")
+		g.writeln(fmt.Sprintf("for p in parts { %s.push(p).ok(); }", sVar))
+		g.indent--
+		g.writeln("}")
+	case "strlen":
+		g.writeln(fmt.Sprintf("{ %s %s.push(a.chars().count() as i64).ok(); }", pop, g.sVar("dstack")))
+	case "substr":
+		g.writeln(fmt.Sprintf("{ %s let (start, n): (i64, i64) = (%s, %s);", pop, g.generateExpr(op.Args[0]), g.generateExpr(op.Args[1])))
+		g.indent++
+		g.writeln("let (start, n) = if start < 0 { (0, n + start) } else { (start, n) };")
+		g.writeln(fmt.Sprintf("%s.push(a.chars().skip(start as usize).take(n.max(0) as usize).collect::<String>()).ok();", sVar))
+		g.indent--
+		g.writeln("}")
+	case "contains":
+		g.writeln(fmt.Sprintf("{ %s let sub: String = %s; %s.push(if a.contains(sub.as_str()) { 1 } else { 0 }).ok(); }",
+			pop, g.generateExpr(op.Args[0]), g.sVar("dstack")))
+	case "upper":
+		g.writeln(fmt.Sprintf("{ %s %s.push(a.to_uppercase()).ok(); }", pop, sVar))
+	case "lower":
+		g.writeln(fmt.Sprintf("{ %s %s.push(a.to_lowercase()).ok(); }", pop, sVar))
 	}
 }
 
@@ -1027,6 +2061,15 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 	sVar := g.sVar(op.Stack)
 	elemType := g.stacks[op.Stack]
 	
+	// Unsigned stacks wrap arithmetic to their width
+	if uintBits(elemType) > 0 && g.generateUnsignedOp(op, sVar, elemType) {
+		return
+	}
+	// Float stacks divide by zero as floats do
+	if (elemType == "f64" || elemType == "f32") && g.generateFloatOp(op, sVar) {
+		return
+	}
+	
 	switch op.Op {
 	case "push":
 		if len(op.Args) >= 1 {
@@ -1047,7 +2090,7 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 		if op.Target != "" {
 			// pop:var — direct assignment to variable
 			// Variable must be explicitly declared
-			if !g.vars[op.Target] {
+			if !g.isVar(op.Target) {
 				g.addError(fmt.Sprintf("cannot pop to undeclared variable '%s'; use 'var %s type = value' first", op.Target, op.Target))
 				return
 			}
@@ -1065,7 +2108,7 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 				return
 			}
 			
-			g.writeln(fmt.Sprintf("%s = %s.pop().unwrap_or_default();", escapeIdent(op.Target), sVar))
+			g.setVar(op.Target, sVar+".pop().unwrap_or_default()")
 		} else if op.Stack == "dstack" {
 			// Pop from dstack and discard
 			g.writeln(fmt.Sprintf("%s.pop();", sVar))
@@ -1110,32 +2153,45 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 		}
 		
 	case "freeze":
-		g.writeln(fmt.Sprintf("%s.freeze();", sVar))
+		if len(op.Args) == 0 {
+			g.writeln(fmt.Sprintf("%s.freeze();", sVar))
+			break
+		}
+		mode, ok := freezeModeConst(op.Args[0])
+		if !ok {
+			g.addError(fmt.Sprintf("@%s freeze: mode must be \"all\", \"structure\" or \"append-only\"", op.Stack))
+			break
+		}
+		g.writeln(fmt.Sprintf("%s.freeze_with(rual::FreezeMode::%s);", sVar, map[string]string{
+			"FreezeAll": "ALL", "FreezeStructure": "STRUCTURE", "AppendOnly": "APPEND_ONLY",
+		}[mode]))
 		
 	case "take":
 		// Blocking pop - wait for data
 		if op.Target != "" {
 			// take:var — assign to variable
 			escName := escapeIdent(op.Target)
-			varExists := g.vars[op.Target]
+			varExists := g.isVar(op.Target)
 			
 			if len(op.Args) >= 1 {
 				// take:var(timeout) - with timeout
 				timeout := g.generateExpr(op.Args[0])
 				if varExists {
-					g.writeln(fmt.Sprintf("%s = %s.take_timeout(%s as u64).unwrap_or_default();", escName, sVar, timeout))
+					g.setVar(op.Target, fmt.Sprintf("%s.take_timeout(%s as u64).unwrap_or_default()", sVar, timeout))
 				} else {
 					g.writeln(fmt.Sprintf("let %s = %s.take_timeout(%s as u64).unwrap_or_default();", escName, sVar, timeout))
 				}
 			} else {
 				// take:var - no timeout
 				if varExists {
-					g.writeln(fmt.Sprintf("%s = %s.take().unwrap_or_default();", escName, sVar))
+					g.setVar(op.Target, sVar+".take().unwrap_or_default()")
 				} else {
 					g.writeln(fmt.Sprintf("let %s = %s.take().unwrap_or_default();", escName, sVar))
 				}
 			}
-			g.vars[op.Target] = true
+			if !varExists {
+				g.vars[op.Target] = true
+			}
 		} else {
 			// @stack take - push to dstack
 			if len(op.Args) >= 1 {
@@ -1148,13 +2204,56 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 		
 	case "bring":
 		// @dest bring(@source) - atomic transfer from source to dest
-		if len(op.Args) >= 1 {
+		withFn := false
+		if len(op.Args) >= 2 {
+			_, withFn = op.Args[1].(*ast.FnLit)
+		}
+		if withFn {
+			g.generateBringWith(op, sVar)
+		} else if len(op.Args) >= 1 {
 			if stackRef, ok := op.Args[0].(*ast.StackRef); ok {
 				srcVar := g.sVar(stackRef.Name)
 				g.writeln(fmt.Sprintf("{ let _v = %s.pop().unwrap_or_default(); %s.push(_v).ok(); }", srcVar, sVar))
 			}
 		}
 		
+	case "walk", "filter", "map":
+		g.generateWalkOp(op, sVar)
+		
+	case "pmap":
+		g.generatePmapOp(op, sVar)
+		
+	case "b64encode", "b64decode", "hexencode", "hexdecode":
+		g.generateCodecOp(op, sVar)
+		
+	case "from_json":
+		// @cfg from_json(text) - errors go to @error
+		if len(op.Args) != 1 {
+			g.addError(fmt.Sprintf("@%s from_json requires a text argument", op.Stack))
+			return
+		}
+		g.writeln(fmt.Sprintf("if let Err(e) = rual::from_json(&%s, &%s) { %s.push(e.to_string()).ok(); }",
+			sVar, g.generateExpr(op.Args[0]), g.sVar("error")))
+		
+	case "pack", "unpack":
+		g.generatePackOp(op, sVar)
+			case "bridge":
+		// @telemetry bridge("mqtt://broker:1883/topic") - errors go to @error
+		if len(op.Args) != 1 {
+			g.addError(fmt.Sprintf("@%s bridge requires a URL argument", op.Stack))
+			return
+		}
+		if p := g.perspectives[op.Stack]; p == "Hash" || p == "Broadcast" {
+			g.addError(fmt.Sprintf("@%s bridge: only LIFO, FIFO and Indexed stacks can be bridged", op.Stack))
+			return
+		}
+		g.writeln(fmt.Sprintf("if let Err(e) = rual::bridge(&*%s, &%s) { %s.push(e).ok(); }",
+			sVar, g.generateExpr(op.Args[0]), g.sVar("error")))
+		
+
+	case "concat", "split", "strlen", "substr", "contains", "upper", "lower":
+		g.generateStringOp(op, sVar)
+		
 	case "perspective":
 		// @stack perspective(FIFO) - set stack perspective
 		if len(op.Args) >= 1 {
@@ -1166,6 +2265,13 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 		}
 		
 	case "has":
+		if len(op.Args) == 1 {
+			// @stack has("key"): whether a Hash stack holds the key
+			if key, ok := g.hashKeyArg(op); ok {
+				g.writeln(fmt.Sprintf("%s.push(if %s.has(%q) { 1 } else { 0 }).ok();", g.sVar("dstack"), sVar, key))
+			}
+			return
+		}
 		// @error.has pushes true/false indicating if stack has elements
 		// Push result to bool stack or dstack
 		if op.Target != "" {
@@ -1249,6 +2355,12 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 		// Move from data stack to return stack (>R in Forth)
 		g.writeln(fmt.Sprintf("{ let v = %s.pop().unwrap_or_default(); RSTACK.push(v).ok(); }", sVar))
 		
+	case "del":
+		// @stack del("key") removes a key from a Hash stack
+		if key, ok := g.hashKeyArg(op); ok {
+			g.writeln(fmt.Sprintf("if let Err(e) = %s.delete(%q) { %s.push(e.to_string()).ok(); }", sVar, key, g.sVar("error")))
+		}
+		
 	case "fromr":
 		// Move from return stack to data stack (R> in Forth)
 		g.writeln(fmt.Sprintf("{ let v = RSTACK.pop().unwrap_or_default(); %s.push(v).ok(); }", sVar))
@@ -1323,10 +2435,9 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 		if len(op.Args) >= 1 {
 			if ident, ok := op.Args[0].(*ast.Ident); ok {
 				name := ident.Name
-				escapedName := escapeIdent(name)
 				
 				// Variable must be explicitly declared
-				if !g.vars[name] {
+				if !g.isVar(name) {
 					g.addError(fmt.Sprintf("cannot let to undeclared variable '%s'; use 'var %s type = value' first", name, name))
 					return
 				}
@@ -1344,7 +2455,7 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 					return
 				}
 				
-				g.writeln(fmt.Sprintf("%s = %s.pop().unwrap_or_default();", escapedName, sVar))
+				g.setVar(name, sVar+".pop().unwrap_or_default()")
 			}
 		}
 		
@@ -1354,11 +2465,17 @@ func (g *RustCodeGen) generateStackOp(op *ast.StackOp) {
 }
 
 // generateComputeStmt generates a compute block
-func (g *RustCodeGen) generateComputeStmt(cs *ast.ComputeStmt) {
-	sVar := g.sVar(cs.StackName)
-	elemType := g.stacks[cs.StackName]
+func (g *RustCodeGen) generateComputeStmt(c *ast.ComputeStmt) {
+	cs := ir.LowerCompute(c)
+	sVar := g.sVar(cs.Stack)
+	elemType := g.stacks[cs.Stack]
 	rustType := g.ualTypeToRust(elemType)
-	perspective := g.perspectives[cs.StackName]
+	perspective := g.perspectives[cs.Stack]
+	
+	// The setup block runs before the kernel takes the lock
+	if cs.Setup != nil {
+		g.generateStackBlock(cs.Setup)
+	}
 	
 	g.writeln("{")
 	g.indent++
@@ -1367,7 +2484,7 @@ func (g *RustCodeGen) generateComputeStmt(cs *ast.ComputeStmt) {
 	g.writeln(fmt.Sprintf("let mut guard = %s.lock();", sVar))
 	
 	// Pop parameters into local variables
-	for _, param := range cs.Params {
+	for _, param := range cs.Pops {
 		g.writeln(fmt.Sprintf("let %s: %s = guard.pop_raw().unwrap_or_default();", escapeIdent(param), rustType))
 	}
 	
@@ -1443,6 +2560,18 @@ func (g *RustCodeGen) generateComputeBodyStmt(stmt ast.Stmt, elemType string, pe
 		g.indent--
 		g.writeln("}")
 		
+	case *ast.RangeStmt:
+		start := g.generateComputeExpr(s.Start, elemType)
+		end := g.generateComputeExpr(s.End, elemType)
+		g.writeln(fmt.Sprintf("for _range_i in ((%s) as i64)..((%s) as i64) {", start, end))
+		g.indent++
+		g.writeln(fmt.Sprintf("let mut %s: %s = _range_i as %s;", escapeIdent(s.Var), elemType, elemType))
+		for _, bodyStmt := range s.Body {
+			g.generateComputeBodyStmt(bodyStmt, elemType, perspective)
+		}
+		g.indent--
+		g.writeln("}")
+		
 	case *ast.BreakStmt:
 		g.writeln("break;")
 		
@@ -1471,8 +2600,11 @@ func (g *RustCodeGen) generateComputeBodyStmt(stmt ast.Stmt, elemType string, pe
 			g.writeln(fmt.Sprintf("%s[%s as usize] = %s;", escapeIdent(s.Target), idx, val))
 		}
 		
+	case *ast.ExprStmt:
+		g.writeln(fmt.Sprintf("let _ = %s;", g.generateComputeExpr(s.Expr, elemType)))
+		
 	default:
-		g.writeln(fmt.Sprintf("// TODO: compute stmt type: %T", stmt))
+		g.addError(fmt.Sprintf("%s is not supported in Rust compute kernels", stmtName(stmt)))
 	}
 }
 
@@ -1630,6 +2762,11 @@ func (g *RustCodeGen) generateExprForType(expr ast.Expr, targetType string) stri
 		}
 	}
 	
+	// Integer variables of other widths push onto i64 stacks as i64
+	if ident, ok := expr.(*ast.Ident); ok && targetType == "i64" && isIntType(g.varTypes[ident.Name]) {
+		return g.generateOperand(expr)
+	}
+	
 	// Check if we're trying to push a float to an integer stack (error)
 	if targetType == "i64" || targetType == "i32" {
 		if _, ok := expr.(*ast.FloatLit); ok {
@@ -1646,9 +2783,140 @@ func (g *RustCodeGen) generateExprForType(expr ast.Expr, targetType string) stri
 		}
 	}
 	
+	// Other widths convert from the i64 or f64 the expression computes in
+	switch targetType {
+	case "f32":
+		if intLit, ok := expr.(*ast.IntLit); ok {
+			return fmt.Sprintf("%d.0", intLit.Value)
+		}
+		return fmt.Sprintf("(%s) as f32", val)
+	case "i8", "i16", "i32":
+		if _, ok := expr.(*ast.IntLit); ok {
+			return val
+		}
+		return fmt.Sprintf("(%s) as i64 as %s", val, targetType)
+	}
+	
+	// Integers to an unsigned stack keep their low bits, as in the Go backend
+	if uintBits(targetType) > 0 {
+		if _, ok := expr.(*ast.FloatLit); ok {
+			g.addError(fmt.Sprintf("cannot push float literal to %s stack", targetType))
+			return val
+		}
+		return fmt.Sprintf("(%s) as i64 as %s", val, targetType)
+	}
+	
 	return val
 }
 
+// generateReturnValue generates the value of a return statement, converted
+// to a numeric return type, as T in an instance of a generic function
+func (g *RustCodeGen) generateReturnValue(expr ast.Expr) string {
+	if f := g.tailFunc; f != nil && !f.CanFail && isNumericType(valueType(f.ReturnType)) {
+		return g.generateExprForType(expr, valueType(f.ReturnType))
+	}
+	return g.generateExpr(expr)
+}
+
+// generateOperand generates an operand of an arithmetic or comparison
+// operator. Integer variables of other widths compute as i64, as in the
+// Go backend and iual, and wrap only when the result is stored.
+func (g *RustCodeGen) generateOperand(expr ast.Expr) string {
+	if ident, ok := expr.(*ast.Ident); ok {
+		switch t := g.varTypes[ident.Name]; {
+		case isIntType(t) && t != "i64":
+			return fmt.Sprintf("(%s as i64)", g.readVar(ident.Name))
+		case t == "f32":
+			return fmt.Sprintf("(%s as f64)", g.readVar(ident.Name))
+		}
+	}
+	return g.generateExpr(expr)
+}
+
+// generateOperandBeside generates expr, an operand whose other operand is
+// other. An integer literal beside a float is written as a float, as
+// Rust does not mix them.
+func (g *RustCodeGen) generateOperandBeside(expr, other ast.Expr) string {
+	if lit, ok := expr.(*ast.IntLit); ok && isFloatType(g.inferTypeFromExpr(other)) {
+		return fmt.Sprintf("%d.0", lit.Value)
+	}
+	return g.generateOperand(expr)
+}
+
+// generateUnsignedOp generates the ops of an unsigned stack that differ
+// from the signed ones: arithmetic wraps instead of panicking on overflow,
+// a shift by the width or more gives 0 as in Go, and abs does nothing. It
+// returns false for any other op.
+func (g *RustCodeGen) generateUnsignedOp(op *ast.StackOp, sVar, elemType string) bool {
+	pop2 := fmt.Sprintf("let b = %s.pop().unwrap_or_default(); let a = %s.pop().unwrap_or_default();", sVar, sVar)
+	pop1 := fmt.Sprintf("let a = %s.pop().unwrap_or_default();", sVar)
+	
+	var result string
+	switch op.Op {
+	case "add", "sub", "mul":
+		g.writeln(fmt.Sprintf("{ %s %s.push(a.wrapping_%s(b)).ok(); }", pop2, sVar, op.Op))
+		return true
+	case "shl", "shr":
+		shift := map[string]string{"shl": "<<", "shr": ">>"}[op.Op]
+		g.writeln(fmt.Sprintf("{ %s %s.push(if b as u64 >= %d { 0 } else { a %s b }).ok(); }", pop2, sVar, uintBits(elemType), shift))
+		return true
+	case "inc":
+		result = "a.wrapping_add(1)"
+	case "dec":
+		result = "a.wrapping_sub(1)"
+	case "neg":
+		result = "a.wrapping_neg()"
+	case "abs":
+		// Unsigned values are their own absolute value
+		return true
+	default:
+		return false
+	}
+	g.writeln(fmt.Sprintf("{ %s %s.push(%s).ok(); }", pop1, sVar, result))
+	return true
+}
+
+// generateFloatOp generates the ops of a float stack that differ from the
+// integer ones: division and remainder by zero give infinity or NaN, as
+// in Go, and inc and dec add a float. It returns false for any other op.
+func (g *RustCodeGen) generateFloatOp(op *ast.StackOp, sVar string) bool {
+	pop2 := fmt.Sprintf("let b = %s.pop().unwrap_or_default(); let a = %s.pop().unwrap_or_default();", sVar, sVar)
+	pop1 := fmt.Sprintf("let a = %s.pop().unwrap_or_default();", sVar)
+	
+	switch op.Op {
+	case "div", "mod":
+		sym := map[string]string{"div": "/", "mod": "%"}[op.Op]
+		g.writeln(fmt.Sprintf("{ %s %s.push(a %s b).ok(); }", pop2, sVar, sym))
+	case "inc":
+		g.writeln(fmt.Sprintf("{ %s %s.push(a + 1.0).ok(); }", pop1, sVar))
+	case "dec":
+		g.writeln(fmt.Sprintf("{ %s %s.push(a - 1.0).ok(); }", pop1, sVar))
+	default:
+		return false
+	}
+	return true
+}
+
+// generateFormat returns a format!() call that formats parts, string
+// literals as written and other expressions with {}
+func (g *RustCodeGen) generateFormat(parts []ast.Expr) string {
+	var format strings.Builder
+	var args []string
+	for _, part := range parts {
+		if lit, ok := part.(*ast.StringLit); ok {
+			text := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "\r", "\\r", "{", "{{", "}", "}}").Replace(lit.Value)
+			format.WriteString(text)
+			continue
+		}
+		format.WriteString("{}")
+		args = append(args, g.generateExpr(part))
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("format!(\"%s\")", format.String())
+	}
+	return fmt.Sprintf("format!(\"%s\", %s)", format.String(), strings.Join(args, ", "))
+}
+
 // generateExpr generates a general expression
 func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 	switch e := expr.(type) {
@@ -1664,7 +2932,7 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 		return s
 		
 	case *ast.StringLit:
-		return fmt.Sprintf("\"%s\".to_string()", e.Value)
+		return rustLiteral(e.Value, false) + ".to_string()"
 		
 	case *ast.BoolLit:
 		if e.Value {
@@ -1673,11 +2941,11 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 		return "false"
 		
 	case *ast.Ident:
-		return escapeIdent(e.Name)
+		return g.readVar(e.Name)
 		
 	case *ast.BinaryExpr:
-		left := g.generateExpr(e.Left)
-		right := g.generateExpr(e.Right)
+		left := g.generateOperandBeside(e.Left, e.Right)
+		right := g.generateOperandBeside(e.Right, e.Left)
 		op := g.translateOp(e.Op)
 		// Handle string concatenation - use format!() instead of +
 		if e.Op == "+" {
@@ -1689,18 +2957,17 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 		}
 		return fmt.Sprintf("(%s %s %s)", left, op, right)
 		
+	case *ast.InterpString:
+		return g.generateFormat(e.Parts)
+		
 	case *ast.BinaryOp:
-		left := g.generateExpr(e.Left)
-		right := g.generateExpr(e.Right)
-		op := g.translateOp(e.Op)
-		// Handle string concatenation - use format!() instead of +
-		if e.Op == "+" {
-			// Check if either side is a string literal or .to_string()
-			if strings.Contains(left, ".to_string()") || strings.Contains(right, ".to_string()") ||
-			   strings.HasPrefix(left, "\"") || strings.HasPrefix(right, "\"") {
-				return fmt.Sprintf("format!(\"{}{}\", %s, %s)", left, right)
-			}
+		// String concatenation formats like an interpolated string
+		if parts, ok := concatParts(e); ok {
+			return g.generateFormat(parts)
 		}
+		left := g.generateOperandBeside(e.Left, e.Right)
+		right := g.generateOperandBeside(e.Right, e.Left)
+		op := g.translateOp(e.Op)
 		return fmt.Sprintf("(%s %s %s)", left, op, right)
 		
 	case *ast.UnaryExpr:
@@ -1732,6 +2999,21 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 			return fmt.Sprintf("%s.take().unwrap_or_default()", sVar)
 		case "is_empty":
 			return fmt.Sprintf("%s.is_empty()", sVar)
+		case "to_json":
+			return fmt.Sprintf("rual::to_json(&%s)", sVar)
+		case "get":
+			// @h: get("key") - the value at key of a Hash stack, the default if unset
+			if len(e.Args) == 1 {
+				return fmt.Sprintf("%s.peek_key(&%s).unwrap_or_default()", sVar, g.generateExprForType(e.Args[0], "string"))
+			}
+			g.addError(fmt.Sprintf("@%s: get() takes one key", e.Stack))
+			return "Default::default()"
+		case "has":
+			if len(e.Args) == 1 {
+				return fmt.Sprintf("%s.has(&%s)", sVar, g.generateExprForType(e.Args[0], "string"))
+			}
+			g.addError(fmt.Sprintf("@%s: has() takes one key", e.Stack))
+			return "Default::default()"
 		case "reduce":
 			// @stack: reduce(initial, {|a, b| expr})
 			if len(e.Args) >= 2 {
@@ -1757,6 +3039,28 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 				}
 			}
 			return fmt.Sprintf("/* TODO: stack expr op %s */", e.Op)
+		case "preduce":
+			// @stack: preduce(identity, {|a, b| expr}) - chunks folded in parallel
+			fnLit, ok := (*ast.FnLit)(nil), len(e.Args) == 2
+			if ok {
+				fnLit, ok = e.Args[1].(*ast.FnLit)
+			}
+			var body ast.Expr
+			if ok && len(fnLit.Params) == 2 {
+				body = walkBodyExpr(fnLit)
+			}
+			if body == nil {
+				g.addError(fmt.Sprintf("@%s: preduce requires (init, {|acc, x| expr}) arguments", e.Stack))
+				return "Default::default()"
+			}
+			typ := g.ualTypeToRust(g.getStackElementType(e.Stack))
+			initial := g.generateExpr(e.Args[0])
+			if isNumericType(typ) {
+				initial = g.generateComputeExpr(e.Args[0], typ)
+			}
+			expr := g.codeblockExpr(body, fnLit.Params, typ)
+			return fmt.Sprintf("%s.par_reduce(%s, |%s: %s, %s: %s| -> %s { %s })", sVar, initial,
+				escapeIdent(fnLit.Params[0]), typ, escapeIdent(fnLit.Params[1]), typ, typ, expr)
 		default:
 			return fmt.Sprintf("/* TODO: stack expr op %s */", e.Op)
 		}
@@ -1773,6 +3077,9 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 		// Stack reference @name - return the stack variable
 		return g.sVar(e.Name)
 		
+	case *ast.FnLit:
+		return g.generateFnValue(e)
+		
 	case *ast.ViewExpr:
 		// View expression like view: pop() or view: peek()
 		viewName := e.View
@@ -1807,10 +3114,353 @@ func (g *RustCodeGen) generateExpr(expr ast.Expr) string {
 	}
 }
 
+// printfArgs checks the arguments of printf or format against the verbs
+// of their format string, which must be a literal, and returns the Rust
+// format and arguments, each cast to the type its verb formats
+func (g *RustCodeGen) printfArgs(fc *ast.FuncCall) (string, []string, bool) {
+	if len(fc.Args) == 0 {
+		g.addError(fmt.Sprintf("%s() requires a format string", fc.Name))
+		return "", nil, false
+	}
+	lit, ok := fc.Args[0].(*ast.StringLit)
+	if !ok {
+		g.addError(fmt.Sprintf("%s(): the format must be a string literal", fc.Name))
+		return "", nil, false
+	}
+	text, verbs, err := runtime.ParseFormat(lit.Value)
+	if err != nil {
+		g.addError(fmt.Sprintf("%s(): %v", fc.Name, err))
+		return "", nil, false
+	}
+	if len(verbs) != len(fc.Args)-1 {
+		g.addError(fmt.Sprintf("%s(): format has %d verbs but %d arguments", fc.Name, len(verbs), len(fc.Args)-1))
+		return "", nil, false
+	}
+	escape := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "\r", "\\r", "{", "{{", "}", "}}")
+	var format strings.Builder
+	var args []string
+	for i, v := range verbs {
+		format.WriteString(escape.Replace(text[i]))
+		format.WriteString(rustFormatSpec(v))
+		arg := g.generateExpr(fc.Args[i+1])
+		switch {
+		case v.IsInt():
+			arg = fmt.Sprintf("(%s) as i64", arg)
+		case v.IsFloat():
+			arg = fmt.Sprintf("(%s) as f64", arg)
+		}
+		args = append(args, arg)
+	}
+	format.WriteString(escape.Replace(text[len(verbs)]))
+	return "\"" + format.String() + "\"", args, true
+}
+
+// rustFormatSpec returns the format!() placeholder for a printf verb.
+// printf pads strings on the left too, so a width always comes with an
+// alignment, and %f has a default precision of 6.
+func rustFormatSpec(v runtime.FormatVerb) string {
+	spec := ""
+	switch {
+	case v.Width < 0:
+	case v.HasFlag('-'):
+		spec += "<"
+	case !v.HasFlag('0'):
+		spec += ">"
+	}
+	if v.HasFlag('+') {
+		spec += "+"
+	}
+	if v.HasFlag('0') {
+		spec += "0"
+	}
+	if v.Width >= 0 {
+		spec += strconv.Itoa(v.Width)
+	}
+	if v.IsFloat() {
+		prec := v.Prec
+		if prec < 0 {
+			prec = 6
+		}
+		spec += "." + strconv.Itoa(prec)
+	}
+	if v.Unsigned() {
+		spec += string(v.Verb)
+	}
+	if spec == "" {
+		return "{}"
+	}
+	return "{:" + spec + "}"
+}
+
 // generateFuncCallExpr generates a function call expression
 func (g *RustCodeGen) generateFuncCallExpr(fc *ast.FuncCall) string {
+	// render(template, @vars) - template string expanded from a Hash stack
+	if fc.Name == "render" {
+		if len(fc.Args) != 2 {
+			g.addError("render() requires (template, @stack) arguments")
+			return "String::new()"
+		}
+		ref, ok := fc.Args[1].(*ast.StackRef)
+		if !ok {
+			g.addError("render() second argument must be a stack reference")
+			return "String::new()"
+		}
+		tmpl := g.generateExpr(fc.Args[0])
+		return fmt.Sprintf("rual::render(&%s, &%s).unwrap_or_else(|e| panic!(\"{}\", e))", tmpl, g.sVar(ref.Name))
+	}
+	
+	// Terminal helpers - no-ops (or identity for color) on non-TTY
+	switch fc.Name {
+	case "is_tty":
+		return "rual::is_tty()"
+	case "clear_line":
+		return "rual::clear_line()"
+	case "runtime_stats":
+		// Goroutine and GC figures have no counterpart in rual
+		g.addError("runtime_stats() is not supported by the Rust backend yet")
+		return "()"
+	case "expect_stack", "expect_output", "freeze_time", "advance_time", "wait_timers", "mock":
+		g.addError(fmt.Sprintf("%s() is not supported by the Rust backend yet", fc.Name))
+		return "()"
+	case "query", "exec":
+		// rual has no SQLite driver
+		g.addError(fmt.Sprintf("%s() is not supported by the Rust backend yet", fc.Name))
+		return "0i64"
+	case "color":
+		if len(fc.Args) != 2 {
+			g.addError("color() requires (name, string) arguments")
+			return "String::new()"
+		}
+		return fmt.Sprintf("rual::color(&%s, &%s)", g.generateExpr(fc.Args[0]), g.generateExpr(fc.Args[1]))
+	case "progress":
+		if len(fc.Args) != 2 {
+			g.addError("progress() requires (n, total) arguments")
+			return "()"
+		}
+		return fmt.Sprintf("rual::progress((%s) as i64, (%s) as i64)", g.generateExpr(fc.Args[0]), g.generateExpr(fc.Args[1]))
+	case "prompt", "confirm", "password":
+		if len(fc.Args) != 1 {
+			g.addError(fmt.Sprintf("%s() requires a message argument", fc.Name))
+			return "String::new()"
+		}
+		return fmt.Sprintf("rual::%s(&%s)", fc.Name, g.generateExpr(fc.Args[0]))
+	case "format_int":
+		if len(fc.Args) != 3 {
+			g.addError("format_int() requires (n, width, pad) arguments")
+			return "String::new()"
+		}
+		return fmt.Sprintf("rual::format_int((%s) as i64, (%s) as i64, &%s)", g.generateExpr(fc.Args[0]),
+			g.generateExpr(fc.Args[1]), g.generateExpr(fc.Args[2]))
+	case "format_float":
+		if len(fc.Args) != 3 {
+			g.addError("format_float() requires (x, prec, width) arguments")
+			return "String::new()"
+		}
+		return fmt.Sprintf("rual::format_float((%s) as f64, (%s) as i64, (%s) as i64)", g.generateExpr(fc.Args[0]),
+			g.generateExpr(fc.Args[1]), g.generateExpr(fc.Args[2]))
+	case "format", "printf":
+		// format(fmt, args...) / printf(fmt, args...) - printf-style
+		macro := map[string]string{"format": "format!", "printf": "print!"}[fc.Name]
+		if format, args, ok := g.printfArgs(fc); ok {
+			return fmt.Sprintf("%s(%s)", macro, strings.Join(append([]string{format}, args...), ", "))
+		}
+		if fc.Name == "printf" {
+			return "()"
+		}
+		return "String::new()"
+	case "uuid4", "ulid":
+		return fmt.Sprintf("rual::%s()", fc.Name)
+	case "seq":
+		if len(fc.Args) != 1 {
+			g.addError("seq() requires a counter name argument")
+			return "0i64"
+		}
+		return fmt.Sprintf("rual::seq(&%s)", g.generateExpr(fc.Args[0]))
+	case "rand":
+		if len(fc.Args) != 0 {
+			g.addError("rand() takes no arguments")
+		}
+		return "rual::rand()"
+	case "rand_int":
+		if len(fc.Args) != 1 {
+			g.addError("rand_int() requires a bound argument")
+			return "0i64"
+		}
+		return fmt.Sprintf("rual::rand_int((%s) as i64)", g.generateExpr(fc.Args[0]))
+	case "seed":
+		if len(fc.Args) != 1 {
+			g.addError("seed() requires a seed argument")
+			return "()"
+		}
+		return fmt.Sprintf("rual::seed((%s) as i64)", g.generateExpr(fc.Args[0]))
+	case "readfile":
+		if len(fc.Args) != 1 {
+			g.addError("readfile() requires a path argument")
+			return "String::new()"
+		}
+		return fmt.Sprintf("rual::read_file(&%s).unwrap_or_else(|e| { %s String::new() })",
+			g.generateExpr(fc.Args[0]), rustFileStatus)
+	case "writefile", "appendfile":
+		if len(fc.Args) != 2 {
+			g.addError(fmt.Sprintf("%s() requires (path, @stack) arguments", fc.Name))
+			return "false"
+		}
+		ref, ok := fc.Args[1].(*ast.StackRef)
+		if !ok {
+			g.addError(fmt.Sprintf("%s() second argument must be a stack reference", fc.Name))
+			return "false"
+		}
+		if g.perspectives[ref.Name] == "Hash" {
+			g.addError(fmt.Sprintf("%s() cannot write the Hash stack @%s", fc.Name, ref.Name))
+			return "false"
+		}
+		return fmt.Sprintf("match rual::write_file(&%s, &%s, %t) { Ok(()) => true, Err(e) => { %s false } }",
+			g.generateExpr(fc.Args[0]), g.sVar(ref.Name), fc.Name == "appendfile", rustFileStatus)
+	case "read_csv", "write_csv":
+		fail := map[string]string{"read_csv": "0i64", "write_csv": "false"}[fc.Name]
+		if len(fc.Args) < 2 {
+			g.addError(fmt.Sprintf("%s() requires (path, @stack, ...) arguments", fc.Name))
+			return fail
+		}
+		var header, cols []string
+		for _, arg := range fc.Args[1:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				g.addError(fmt.Sprintf("%s() columns must be stack references", fc.Name))
+				return fail
+			}
+			if g.perspectives[ref.Name] == "Hash" {
+				g.addError(fmt.Sprintf("%s() cannot take the Hash stack @%s as a column", fc.Name, ref.Name))
+				return fail
+			}
+			header = append(header, rustLiteral(ref.Name, false))
+			cols = append(cols, "&*"+g.sVar(ref.Name))
+		}
+		path := g.generateExpr(fc.Args[0])
+		if fc.Name == "read_csv" {
+			return fmt.Sprintf("{ let (n, r) = rual::read_csv(&%s, &[%s]); if let Err(e) = r { %s } n }",
+				path, strings.Join(cols, ", "), rustFileStatus)
+		}
+		return fmt.Sprintf("match rual::write_csv(&%s, &[%s], &[%s]) { Ok(()) => true, Err(e) => { %s false } }",
+			path, strings.Join(header, ", "), strings.Join(cols, ", "), rustFileStatus)
+	case "listen":
+		if len(fc.Args) != 3 {
+			g.addError("listen() requires (addr, @requests, @responses) arguments")
+			return "false"
+		}
+		var stacks []string
+		for _, arg := range fc.Args[1:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				g.addError("listen() requests and responses must be stack references")
+				return "false"
+			}
+			if t := g.getStackElementType(ref.Name); t != "string" || g.perspectives[ref.Name] == "Hash" {
+				g.addError(fmt.Sprintf("listen() requires string stacks that are not Hash stacks, @%s is not one", ref.Name))
+				return "false"
+			}
+			stacks = append(stacks, "&*"+g.sVar(ref.Name))
+		}
+		return fmt.Sprintf("match rual::listen(&%s, %s, %s) { Ok(()) => true, Err(e) => { %s false } }",
+			g.generateExpr(fc.Args[0]), stacks[0], stacks[1], rustFileStatus)
+	case "exists":
+		if len(fc.Args) != 1 {
+			g.addError("exists() requires a path argument")
+			return "false"
+		}
+		return fmt.Sprintf("rual::file_exists(&%s)", g.generateExpr(fc.Args[0]))
+	case "env":
+		if len(fc.Args) != 1 {
+			g.addError("env() requires a variable name argument")
+			return "String::new()"
+		}
+		return fmt.Sprintf("{ let name: String = %s; rual::env(&name).unwrap_or_else(|| { "+
+			"CONSIDER_STATUS.with(|s| *s.borrow_mut() = String::from(\"not_found\")); "+
+			"CONSIDER_VALUE.with(|v| *v.borrow_mut() = name.clone()); String::new() }) }", g.generateExpr(fc.Args[0]))
+	case "setenv":
+		if len(fc.Args) != 2 {
+			g.addError("setenv() requires (name, value) arguments")
+			return "false"
+		}
+		return fmt.Sprintf("match rual::setenv(&%s, &%s) { Ok(()) => true, Err(e) => { %s false } }",
+			g.generateExpr(fc.Args[0]), g.generateExpr(fc.Args[1]), rustFileStatus)
+	case "readline":
+		if len(fc.Args) != 0 {
+			g.addError("readline() takes no arguments")
+			return "String::new()"
+		}
+		return fmt.Sprintf("rual::readline().unwrap_or_else(|e| { %s String::new() })", rustFileStatus)
+	case "args":
+		if len(fc.Args) != 1 {
+			g.addError("args() requires a @stack argument")
+			return "0i64"
+		}
+		ref, ok := fc.Args[0].(*ast.StackRef)
+		if !ok {
+			g.addError("args() argument must be a stack reference")
+			return "0i64"
+		}
+		if t := g.stacks[ref.Name]; t != "string" {
+			g.addError(fmt.Sprintf("args() requires a string stack, @%s is %s", ref.Name, t))
+			return "0i64"
+		}
+		if g.perspectives[ref.Name] == "Hash" {
+			g.addError(fmt.Sprintf("args() cannot push to the Hash stack @%s", ref.Name))
+			return "0i64"
+		}
+		return fmt.Sprintf("rual::push_args(&%s)", g.sVar(ref.Name))
+	case "call":
+		// call(f, args...) - calls the codeblock value f
+		if len(fc.Args) == 0 {
+			g.addError("call() requires a codeblock argument")
+			return "0i64"
+		}
+		var args []string
+		for _, arg := range fc.Args[1:] {
+			args = append(args, fmt.Sprintf("(%s) as i64", g.generateExpr(arg)))
+		}
+		return fmt.Sprintf("rual::call_fn((%s) as i64, &[%s])", g.generateExpr(fc.Args[0]), strings.Join(args, ", "))
+	case "apply":
+		// apply(f, @s) - calls f with its arguments popped from @s
+		if len(fc.Args) != 2 {
+			g.addError("apply() requires (codeblock, @stack) arguments")
+			return "0i64"
+		}
+		ref, ok := fc.Args[1].(*ast.StackRef)
+		if !ok {
+			g.addError("apply() second argument must be a stack reference")
+			return "0i64"
+		}
+		if t := g.ualTypeToRust(g.getStackElementType(ref.Name)); t != "i64" {
+			g.addError(fmt.Sprintf("apply() takes arguments from an i64 stack, @%s is %s", ref.Name, g.getStackElementType(ref.Name)))
+			return "0i64"
+		}
+		return fmt.Sprintf("rual::apply_fn((%s) as i64, || %s.pop().ok())", g.generateExpr(fc.Args[0]), g.sVar(ref.Name))
+	case "exit":
+		// exit / exit(code) - runs @atexit hooks, skips pending @defer blocks
+		switch len(fc.Args) {
+		case 0:
+			return "rual::exit(0)"
+		case 1:
+			return fmt.Sprintf("rual::exit((%s) as i32)", g.generateExpr(fc.Args[0]))
+		}
+		g.addError("exit() takes at most one argument")
+		return "()"
+	}
+	
 	var args []string
-	for _, arg := range fc.Args {
+	f := g.funcDecls[fc.Name]
+	for idx, arg := range fc.Args {
+		if ref, ok := arg.(*ast.StackRef); ok {
+			// Stack arguments are passed by reference
+			args = append(args, "&"+g.sVar(ref.Name))
+			continue
+		}
+		// Numeric values convert to the parameter's type
+		if f != nil && idx < len(f.Params) && isNumericType(valueType(f.Params[idx].Type)) {
+			args = append(args, g.generateExprForType(arg, valueType(f.Params[idx].Type)))
+			continue
+		}
 		args = append(args, g.generateExpr(arg))
 	}
 	
@@ -1903,10 +3553,15 @@ func (g *RustCodeGen) defaultValue(t string) string {
 }
 
 // generateConsiderStmt generates a consider block using Rust's match
-func (g *RustCodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
-	g.fnCounter++
-	savedStatusVar := fmt.Sprintf("_saved_status_%d", g.fnCounter)
-	savedValueVar := fmt.Sprintf("_saved_value_%d", g.fnCounter)
+func (g *RustCodeGen) generateConsiderStmt(cs *ast.ConsiderStmt) {
+	c, err := ir.LowerConsider(cs)
+	if err != nil {
+		g.addError(err.Error())
+		return
+	}
+	n := g.ids.next("consider")
+	savedStatusVar := fmt.Sprintf("_saved_status_%d", n)
+	savedValueVar := fmt.Sprintf("_saved_value_%d", n)
 	
 	g.writeln("{")
 	g.indent++
@@ -1924,10 +3579,8 @@ func (g *RustCodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	g.considerDepth++
 	
 	// Execute the block
-	if c.Block != nil {
-		for _, op := range c.Block.Ops {
-			g.generateStmt(op)
-		}
+	if c.Body != nil {
+		g.generateStackBlock(c.Body)
 	}
 	
 	g.considerDepth--
@@ -1939,6 +3592,7 @@ func (g *RustCodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	g.writeln("if _status_is_ok && !STACK_ERROR.is_empty() {")
 	g.indent++
 	g.writeln("CONSIDER_STATUS.with(|s| *s.borrow_mut() = String::from(\"error\"));")
+	g.writeln("CONSIDER_VALUE.with(|v| *v.borrow_mut() = STACK_ERROR.peek().unwrap_or_default());")
 	g.indent--
 	g.writeln("}")
 	g.writeln("")
@@ -1951,54 +3605,19 @@ func (g *RustCodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	// Generate match statement
 	g.writeln("match _consider_status.as_str() {")
 	g.indent++
-	
-	hasDefault := false
-	for _, cas := range c.Cases {
-		if cas.Label == "_" {
-			hasDefault = true
-		}
-	}
-	
 	for _, cas := range c.Cases {
-		if cas.Label == "_" {
-			g.writeln("_ => {")
-		} else {
-			g.writeln(fmt.Sprintf("\"%s\" => {", cas.Label))
-		}
-		g.indent++
-		
-		// Bind value if requested
-		if len(cas.Bindings) > 0 {
-			bindName := cas.Bindings[0]
-			if cas.Label == "error" {
-				// For error, prefer CONSIDER_VALUE (set by status:error), fallback to STACK_ERROR
-				// Provide both i64 and String versions
-				g.writeln(fmt.Sprintf("let %s_str = if !_consider_value.is_empty() { _consider_value.clone() } else { STACK_ERROR.peek().unwrap_or_default() };", bindName))
-				g.writeln(fmt.Sprintf("let %s: i64 = %s_str.parse().unwrap_or(0);", bindName, bindName))
-			} else {
-				// For other cases, provide i64 binding (parsed) and String version
-				g.writeln(fmt.Sprintf("let %s: i64 = _consider_value.parse().unwrap_or(0);", bindName))
-				g.writeln(fmt.Sprintf("let %s_str = _consider_value.clone();", bindName))
-			}
-			g.vars[bindName] = true
-			// Track this as a consider binding so print() uses _str version
-			g.considerBindings[bindName] = true
-		}
-		
-		// Generate handler statements
-		for _, stmt := range cas.Handler {
-			g.generateStmt(stmt)
-		}
-		
-		g.indent--
+		g.writeln(fmt.Sprintf("\"%s\" => {", cas.Label))
+		g.generateConsiderCase(cas)
 		g.writeln("}")
 	}
-	
-	// Add default case if not present (to satisfy Rust's exhaustiveness)
-	if !hasDefault {
+	if c.Default != nil {
+		g.writeln("_ => {")
+		g.generateConsiderCase(*c.Default)
+		g.writeln("}")
+	} else {
+		// Rust's match must be exhaustive
 		g.writeln("_ => {}")
 	}
-	
 	g.indent--
 	g.writeln("}")
 	
@@ -2011,6 +3630,67 @@ func (g *RustCodeGen) generateConsiderStmt(c *ast.ConsiderStmt) {
 	g.writeln("}")
 }
 
+// generateConsiderCase binds the status value, if the case asks for it, as
+// an i64 and as a String, and generates the case's handler
+func (g *RustCodeGen) generateConsiderCase(cas ir.ConsiderCase) {
+	g.indent++
+	if cas.Bind != "" {
+		g.writeln(fmt.Sprintf("let %s: i64 = _consider_value.parse().unwrap_or(0);", cas.Bind))
+		g.writeln(fmt.Sprintf("let %s_str = _consider_value.clone();", cas.Bind))
+		g.vars[cas.Bind] = true
+		// Track this as a consider binding so print() uses _str version
+		g.considerBindings[cas.Bind] = true
+	}
+	for _, stmt := range cas.Body {
+		g.generateStmt(stmt)
+	}
+	g.indent--
+}
+
+// generateAssert generates assert(cond) and assert(cond, msg), which panic
+// with the call's position when cond is false
+func (g *RustCodeGen) generateAssert(f *ast.FuncCall) {
+	pos := g.stmtPos
+	if pos.File == "" {
+		pos.File = g.srcFile
+	}
+	where := fmt.Sprintf("%s:%d", pos.File, pos.Line)
+	g.writeln(fmt.Sprintf("if !(%s) {", g.generateCondition(f.Args[0])))
+	g.indent++
+	if len(f.Args) > 1 {
+		g.writeln(fmt.Sprintf("panic!(\"{}: assertion failed: {}\", %q, %s);", where, g.generateExpr(f.Args[1])))
+	} else {
+		g.writeln(fmt.Sprintf("panic!(\"{}: assertion failed\", %q);", where))
+	}
+	g.indent--
+	g.writeln("}")
+}
+
+// generateBenchBlock times a bench block of the file under ual bench with
+// rual::run_bench, each iteration from empty default stacks. Variables
+// declared in the body are local to its closure.
+func (g *RustCodeGen) generateBenchBlock(s *ast.BenchBlock) {
+	pos, ok := g.pos[s]
+	if ok && pos.File != "" {
+		return // a library's
+	}
+	g.writeln(fmt.Sprintf("rual::run_bench(%q, %q, %d, &mut || {", s.Name, g.srcFile, pos.Line))
+	g.indent++
+	g.writeln("DSTACK.clear();")
+	g.writeln("RSTACK.clear();")
+	g.writeln("STACK_ERROR.clear();")
+	saved := make(map[string]bool, len(g.vars))
+	for name, v := range g.vars {
+		saved[name] = v
+	}
+	for _, stmt := range s.Body {
+		g.generateStmt(stmt)
+	}
+	g.vars = saved
+	g.indent--
+	g.writeln("});")
+}
+
 // generatePanicStmt generates a panic statement
 func (g *RustCodeGen) generatePanicStmt(p *ast.PanicStmt) {
 	if p.Value == nil {
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestErrorPosition(t *testing.T) {
+	src := "x = 1\nfunc f() {\n  if (x > 0) {\n    import \"a.ual\"\n  }\n}\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `prog.ual:4:5: import "a.ual" must be at the top level`
+
+	g := NewCodeGen()
+	g.srcFile = "prog.ual"
+	g.Generate(prog)
+	if errs := g.getErrors(); len(errs) != 1 || errs[0] != want {
+		t.Errorf("Go errors = %q, want %q", errs, want)
+	}
+
+	r := NewRustCodeGen()
+	r.srcFile = "prog.ual"
+	r.Generate(prog)
+	if errs := r.getErrors(); len(errs) != 1 || errs[0] != want {
+		t.Errorf("Rust errors = %q, want %q", errs, want)
+	}
+}
+
+// squash returns code with each run of spaces and newlines made one space,
+// as gofmt's alignment and line breaks do not matter to the tests
+func squash(code string) string {
+	return strings.Join(strings.Fields(code), " ")
+}
+
+func TestFunctionFrames(t *testing.T) {
+	src := "func f(n i64, s string) i64 {\n  var m i64 = n - 1\n  return m\n}\n" +
+		"func g(n i64, s string) {\n  var m i64 = n\n  @spawn < {\n    println(n)\n  }\n  println(s, m)\n}\n" +
+		"var x i64 = 1\nprintln(f(x, \"s\"))\ng(x, \"s\")\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := NewCodeGen().Generate(prog)
+
+	// Locals that do not escape are native variables, needing no frame
+	f := out[strings.Index(out, "func f("):strings.Index(out, "func g(")]
+	for _, want := range []string{"var_n := int64(n)", "var_s := string(s)", "var_m := int64((var_n - 1))", "return var_m"} {
+		if !strings.Contains(squash(f), want) {
+			t.Errorf("f lacks %q:\n%s", want, f)
+		}
+	}
+	if strings.Contains(f, "frame_") {
+		t.Errorf("f has a frame:\n%s", f)
+	}
+
+	// n, used by a spawn block, stays on the frame of each call
+	g := out[strings.Index(out, "func g("):strings.Index(out, "func main()")]
+	for _, want := range []string{
+		"frame_i64 := ual.NewStack(ual.Hash, ual.TypeInt64) // frame",
+		"frame_i64.PushAt(0, intToBytes(int64(n))) // param n",
+		"var_s := string(s)",
+		"var_m := int64(func() int64 { v, _ := frame_i64.PeekAt(0); return bytesToInt(v) }())",
+	} {
+		if !strings.Contains(squash(g), want) {
+			t.Errorf("g lacks %q:\n%s", want, g)
+		}
+	}
+	if strings.Contains(g, "frame_string") {
+		t.Errorf("g has a frame for s:\n%s", g)
+	}
+	// Top-level variables number their slots separately
+	if !strings.Contains(squash(out), "stack_i64.PushAt(0, intToBytes(int64(1))) // var x") {
+		t.Errorf("x not in slot 0:\n%s", out[strings.Index(out, "func main()"):])
+	}
+}
+
+func TestFunctionGlobals(t *testing.T) {
+	src := "var total i64 = 0\nvar name string = \"g\"\nfunc bump(n i64) {\n  push:(total + n) let:total\n  total = total * 2\n  var name string = \"l\"\n}\nbump(2)\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := NewCodeGen().Generate(prog)
+	fn := out[strings.Index(out, "func bump("):strings.Index(out, "func main()")]
+	for _, want := range []string{
+		"v, _ := stack_i64.PeekAt(0)",                          // total, a global
+		"stack_i64.PushAt(0, v) } // total = ...",              // updated in place
+		"stack_i64.PushAt(0, intToBytes(int64((func() int64 {", // assigned in place
+		"var_name := string(\"l\")",                            // a local
+	} {
+		if !strings.Contains(squash(fn), want) {
+			t.Errorf("function lacks %q:\n%s", want, fn)
+		}
+	}
+	if !strings.Contains(squash(out), "stack_i64.PushAt(0, intToBytes(int64(0))) // var total") {
+		t.Errorf("total not in slot 0:\n%s", out[strings.Index(out, "func main()"):])
+	}
+
+	// With -O, globals that functions use are package-level variables
+	prog, _ = parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	out = NewCodeGenOptimized(false, true).Generate(prog)
+	for _, want := range []string{"var var_total int64\n", "\tvar_total = int64(0)", "\tvar_total = int64((var_total * 2))", "\tvar_name := string(\"g\")"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("-O output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestPrintfErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`printf("%d %d\n", 1)`, "printf(): format has 2 verbs but 1 arguments"},
+		{`var f = "%d"` + "\n" + `printf(f, 1)`, "printf(): the format must be a string literal"},
+		{`var s = format("%q", 1)`, `format(): unknown verb %q in format`},
+	}
+	for _, tt := range tests {
+		prog, err := parser.NewParser(lexer.NewLexer(tt.src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := NewCodeGen()
+		g.Generate(prog)
+		if errs := g.getErrors(); len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: Go errors = %q, want %q", tt.src, errs, tt.want)
+		}
+		r := NewRustCodeGen()
+		r.Generate(prog)
+		if errs := r.getErrors(); len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: Rust errors = %q, want %q", tt.src, errs, tt.want)
+		}
+	}
+}
+
+// A Hash stack's get and has take one key; another count is reported,
+// not generated
+func TestRustHashKeyCount(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"var v i64 = @h: get()", "@h: get() takes one key"},
+		{`var v i64 = @h: get("a", "b")`, "@h: get() takes one key"},
+		{`var ok bool = @h: has("a", "b")`, "@h: has() takes one key"},
+	}
+	for _, tt := range tests {
+		src := "@h = stack.new(i64, Hash)\n" + tt.src
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewRustCodeGen()
+		out := r.Generate(prog)
+		if errs := r.getErrors(); len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: Rust errors = %q, want %q", tt.src, errs, tt.want)
+		}
+		if strings.Contains(out, "TODO") || !strings.Contains(out, "Default::default()") {
+			t.Errorf("%q: generated:\n%s", tt.src, out)
+		}
+	}
+}
+
+// Float stacks do arithmetic on the floats their bytes encode, rounding
+// to f32 on an f32 stack, rather than on the bytes read as ints
+func TestFloatStackOps(t *testing.T) {
+	src := "@f = stack.new(f64)\n@g = stack.new(f32)\n@f push:1.5 push:2.25 add\n@g push:0.5 inc\n@f push:1.0 push:2.0 lt\nprintln(@g: pop())\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := NewCodeGen().Generate(prog)
+	for _, want := range []string{
+		"x, y := bytesToFloat(a), bytesToFloat(b) stack_f.Push(floatToBytes(x + y))",
+		"x := float64(bytesToFloat32(v)) stack_g.Push(floatToBytes(float64(float32(x + 1))))",
+		"x, y := bytesToFloat(a), bytesToFloat(b) stack_bool.Push(boolToBytes(x < y))",
+		"fmt.Println(func() float32 { v, _ := stack_g.Pop(); return bytesToFloat32(v) }())",
+	} {
+		if !strings.Contains(squash(out), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "bytesToInt(a) + bytesToInt(b)") {
+		t.Errorf("float add done on ints:\n%s", out)
+	}
+}
+
+func TestCheckedPops(t *testing.T) {
+	src := "@s = stack.new(i64)\n@s push:1\n@s pop\n@s { dup add }\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewCodeGen()
+	g.srcFile = "/src/prog.ual"
+	g.checked = true
+	out := g.Generate(prog)
+	for _, want := range []string{
+		"//line /src/prog.ual:3\n",
+		"{ v, _ := ual.Checked(stack_s.Pop()); stack_dstack.Push(v) }",
+		"{ v, _ := ual.Checked(stack_s.Peek()); stack_s.Push(v) }",
+		"{ b, _ := ual.Checked(stack_s.Pop()); a, _ := ual.Checked(stack_s.Pop()); ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("checked output lacks %q:\n%s", want, out)
+		}
+	}
+
+	prog, _ = parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if out := NewCodeGen().Generate(prog); strings.Contains(out, "ual.Checked") || strings.Contains(out, "//line") {
+		t.Errorf("unchecked output checks pops:\n%s", out)
+	}
+}
+
+func TestTestBlocks(t *testing.T) {
+	src := "var n i64 = 1\nassert(n > 0)\ntest \"one\" {\n  push:1\n  assert(n == 1, \"n\")\n}\n"
+	generate := func(tests bool) string {
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := NewCodeGen()
+		g.srcFile = "a_test.ual"
+		g.tests = tests
+		return g.Generate(prog)
+	}
+
+	out := generate(true)
+	for _, want := range []string{
+		`ual.Assert(`,
+		`, "a_test.ual", 2, "")`,
+		`ual.RunTest("one", "a_test.ual", 3, func() {`,
+		"stack_dstack.Clear()",
+		`, "a_test.ual", 5, "n")`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("test output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "// Results") {
+		t.Errorf("test output prints the variables:\n%s", out)
+	}
+
+	// Other builds leave test blocks out
+	if out := generate(false); strings.Contains(out, "ual.RunTest") || !strings.Contains(out, `, "a_test.ual", 2, "")`) {
+		t.Errorf("a build outside ual test runs test blocks or drops assert:\n%s", out)
+	}
+}
+
+func TestBenchBlocks(t *testing.T) {
+	src := "bench \"push-heavy\" {\n  push:1 push:2\n  add pop\n}\n"
+	parse := func() *ast.Program {
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prog
+	}
+
+	g := NewCodeGen()
+	g.srcFile = "a_test.ual"
+	g.bench = true
+	out := g.Generate(parse())
+	for _, want := range []string{
+		`ualbench "github.com/ha1tch/ual/pkg/runtime/bench"`,
+		`ualbench.Run("push-heavy", "a_test.ual", 1, func() {`,
+		"stack_dstack.Clear()",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("bench output lacks %q:\n%s", want, out)
+		}
+	}
+
+	r := NewRustCodeGen()
+	r.srcFile = "a_test.ual"
+	r.bench = true
+	if out := r.Generate(parse()); !strings.Contains(out, `rual::run_bench("push-heavy", "a_test.ual", 1, &mut || {`) {
+		t.Errorf("Rust bench output lacks rual::run_bench:\n%s", out)
+	}
+
+	// Other builds leave bench blocks out
+	if out := NewCodeGen().Generate(parse()); strings.Contains(out, "ualbench") {
+		t.Errorf("a build outside ual bench runs bench blocks:\n%s", out)
+	}
+	if out := NewRustCodeGen().Generate(parse()); strings.Contains(out, "run_bench") {
+		t.Errorf("a Rust build outside ual bench runs bench blocks:\n%s", out)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dead code elimination.
+//
+// The Go generator writes every helper, every default stack, the spawn
+// pool and every ual function, whether the program uses them or not, and
+// keeps the compiler quiet about the rest with lines such as
+//
+//	var _ = math.Pi // suppress unused import
+//	_ = stack_i64
+//
+// pruneGo then removes what the program cannot reach. Starting from main
+// and init (and, for a library, its exported names) it follows the
+// package-level names each declaration refers to, as the parser resolved
+// them, and drops the functions, variables and types it never got to,
+// the suppressing lines, and then the imports nothing uses any more. A
+// global the program does not use is never created, so it neither costs
+// memory nor pulls its runtime code into the binary.
+//
+// Methods are always kept, since they are reached through values rather
+// than names. Only assignments to _ of a package-level name or of a name
+// from an import are taken for suppressions: "_ = x" of a local variable
+// is what keeps the Go compiler from rejecting it, and stays.
+
+// pruneGo returns code, with markers, without what it does not reach
+// from main, or from init and the exported names if library. Code that
+// does not parse is returned as it is.
+func pruneGo(code string, library bool) string {
+	src, _ := markersToComments(code)
+	pruned, ok := pruneDecls(src, library)
+	if !ok {
+		return code
+	}
+	pruned, ok = pruneImports(pruned)
+	if !ok {
+		return code
+	}
+	out, _, ok := commentsToMarkers(pruned)
+	if !ok {
+		return code
+	}
+	return out
+}
+
+// pruneDecls removes the package-level declarations of src that main,
+// init and, for a library, the exported names do not reach, and the
+// suppressions of unused names
+func pruneDecls(src string, library bool) (string, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return src, false
+	}
+
+	// The package-level declarations, by the node the parser resolves
+	// their names to
+	top := map[interface{}]bool{}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			top[d] = true
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				if d.Tok != token.IMPORT {
+					top[spec] = true
+				}
+			}
+		}
+	}
+	var roots []ast.Node
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil || d.Name.Name == "main" || d.Name.Name == "init" || library && d.Name.IsExported() {
+				roots = append(roots, d)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if library && s.Name.IsExported() {
+						roots = append(roots, s)
+					}
+				case *ast.ValueSpec:
+					if blankSpec(s) && !suppresses(s.Values, top) || library && exportsName(s) {
+						roots = append(roots, s)
+					}
+				}
+			}
+		}
+	}
+
+	// Suppressions inside functions, which refer to nothing
+	var cuts []cut
+	suppression := map[ast.Node]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch s := n.(type) {
+		case *ast.AssignStmt:
+			if len(s.Lhs) == 1 && isBlank(s.Lhs[0]) && s.Tok == token.ASSIGN && suppresses(s.Rhs, top) {
+				suppression[s] = true
+			}
+		case *ast.DeclStmt:
+			if g, ok := s.Decl.(*ast.GenDecl); ok && g.Tok == token.VAR && len(g.Specs) == 1 {
+				if v := g.Specs[0].(*ast.ValueSpec); blankSpec(v) && suppresses(v.Values, top) {
+					suppression[s] = true
+				}
+			}
+		}
+		return true
+	})
+	for s := range suppression {
+		cuts = append(cuts, cut{s.Pos(), s.End()})
+	}
+
+	// Everything the roots refer to, and what that refers to
+	reached := map[interface{}]bool{}
+	for len(roots) > 0 {
+		node := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if reached[node] {
+			continue
+		}
+		reached[node] = true
+		ast.Inspect(node, func(n ast.Node) bool {
+			if suppression[n] {
+				return false
+			}
+			if id, ok := n.(*ast.Ident); ok && id.Obj != nil && top[id.Obj.Decl] && !reached[id.Obj.Decl] {
+				roots = append(roots, id.Obj.Decl.(ast.Node))
+			}
+			return true
+		})
+	}
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !reached[d] {
+				cuts = append(cuts, cut{declStart(d.Doc, d), d.End()})
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			var dead []ast.Spec
+			for _, spec := range d.Specs {
+				if !reached[spec] {
+					dead = append(dead, spec)
+				}
+			}
+			if len(dead) == len(d.Specs) {
+				cuts = append(cuts, cut{declStart(d.Doc, d), d.End()})
+				continue
+			}
+			for _, spec := range dead {
+				cuts = append(cuts, specCut(spec))
+			}
+		}
+	}
+	return applyCuts(fset, src, cuts), true
+}
+
+// pruneImports removes the imports src no longer uses
+func pruneImports(src string) (string, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return src, false
+	}
+	used := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	var cuts []cut
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		var dead []ast.Spec
+		for _, spec := range d.Specs {
+			if name := importName(spec.(*ast.ImportSpec)); name != "" && !used[name] {
+				dead = append(dead, spec)
+			}
+		}
+		if len(dead) == len(d.Specs) {
+			cuts = append(cuts, cut{declStart(d.Doc, d), d.End()})
+			continue
+		}
+		for _, spec := range dead {
+			cuts = append(cuts, cut{spec.Pos(), spec.End()})
+		}
+	}
+	return applyCuts(fset, src, cuts), true
+}
+
+// importName returns the name an import is used by, or "" for a blank
+// or dot import, which is never removed
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		if spec.Name.Name == "_" || spec.Name.Name == "." {
+			return ""
+		}
+		return spec.Name.Name
+	}
+	p, _ := strconv.Unquote(spec.Path.Value)
+	return path.Base(p)
+}
+
+// suppresses reports whether exprs only name things, as "_ = x" does to
+// keep x from being reported unused: package-level names, names from
+// imports, and nil converted to an imported type
+func suppresses(exprs []ast.Expr, top map[interface{}]bool) bool {
+	if len(exprs) == 0 {
+		return false
+	}
+	for _, e := range exprs {
+		if call, ok := e.(*ast.CallExpr); ok && len(call.Args) == 1 {
+			if nilArg, ok := call.Args[0].(*ast.Ident); ok && nilArg.Name == "nil" && nilArg.Obj == nil {
+				e = call.Fun // unsafe.Pointer(nil)
+			}
+		}
+		switch x := e.(type) {
+		case *ast.Ident:
+			if x.Obj == nil || !top[x.Obj.Decl] {
+				return false
+			}
+		case *ast.SelectorExpr:
+			if pkg, ok := x.X.(*ast.Ident); !ok || pkg.Obj != nil {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// blankSpec reports whether every name s declares is _
+func blankSpec(s *ast.ValueSpec) bool {
+	for _, name := range s.Names {
+		if name.Name != "_" {
+			return false
+		}
+	}
+	return true
+}
+
+// exportsName reports whether s declares an exported name
+func exportsName(s *ast.ValueSpec) bool {
+	for _, name := range s.Names {
+		if name.IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlank(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == "_"
+}
+
+// declStart is where a declaration begins, with its doc comment
+func declStart(doc *ast.CommentGroup, n ast.Node) token.Pos {
+	if doc != nil {
+		return doc.Pos()
+	}
+	return n.Pos()
+}
+
+// specCut is the text of one spec of a declaration, with its comments
+func specCut(spec ast.Spec) cut {
+	switch s := spec.(type) {
+	case *ast.ValueSpec:
+		return cut{declStart(s.Doc, s), s.End()}
+	case *ast.TypeSpec:
+		return cut{declStart(s.Doc, s), s.End()}
+	}
+	return cut{spec.Pos(), spec.End()}
+}
+
+// cut is text to remove from the source
+type cut struct {
+	from, to token.Pos
+}
+
+// applyCuts returns src without the text of cuts. A cut that is all of
+// the lines it is on takes the whole lines, with a comment after it, and
+// a blank line it leaves at the end of a block; a cut within another is
+// skipped.
+func applyCuts(fset *token.FileSet, src string, cuts []cut) string {
+	sort.Slice(cuts, func(a, b int) bool { return cuts[a].from < cuts[b].from })
+	var out []byte
+	done := 0
+	for _, c := range cuts {
+		from, to := fset.Position(c.from).Offset, fset.Position(c.to).Offset
+		if from < done {
+			continue
+		}
+		lineStart := strings.LastIndex(src[:from], "\n") + 1
+		lineEnd := len(src)
+		if n := strings.Index(src[to:], "\n"); n >= 0 {
+			lineEnd = to + n + 1
+		}
+		rest := strings.TrimSpace(src[to:lineEnd])
+		whole := strings.TrimSpace(src[lineStart:from]) == "" && (rest == "" || strings.HasPrefix(rest, "//"))
+		if whole {
+			from, to = lineStart, lineEnd
+		}
+		out = append(out, src[done:from]...)
+		done = to
+		if whole && strings.HasPrefix(strings.TrimLeft(src[to:], " \t"), "}") {
+			last := bytes.LastIndexByte(out[:max(len(out)-1, 0)], '\n') + 1
+			if len(bytes.TrimSpace(out[last:])) == 0 {
+				out = out[:last]
+			}
+		}
+	}
+	out = append(out, src[done:]...)
+	return string(out)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPruneGo(t *testing.T) {
+	marker := sourceMarker + "prog.ual\x003\x001\n"
+	code := `package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	_ "embed"
+)
+
+var _ = math.Pi // suppress unused import
+
+// unused is never called
+func unused() {
+	os.Exit(1)
+}
+
+var (
+	used = 1
+	idle = 2
+)
+
+type spare struct{}
+
+func (s spare) String() string { return "spare" }
+
+func helper() int {
+	return used
+}
+
+func main() {
+` + marker + `	x := helper()
+	_ = x
+	_ = unused
+	fmt.Println(x)
+}
+`
+	out := pruneGo(code, false)
+	want := `package main
+
+import (
+	"fmt"
+	_ "embed"
+)
+
+var (
+	used = 1
+)
+
+type spare struct{}
+
+func (s spare) String() string { return "spare" }
+
+func helper() int {
+	return used
+}
+
+func main() {
+` + marker + `	x := helper()
+	_ = x
+	fmt.Println(x)
+}
+`
+	if out != want {
+		t.Errorf("pruned:\n%s\nwant:\n%s", out, want)
+	}
+
+	// A library keeps its exported names and what they use
+	lib := "package lib\n\nfunc square(n int) int { return n * n }\n\nfunc cube(n int) int { return n * n * n }\n\nfunc Square(n int) int { return square(n) }\n"
+	out = pruneGo(lib, true)
+	if !strings.Contains(out, "func square(") || strings.Contains(out, "func cube(") {
+		t.Errorf("pruned library:\n%s", out)
+	}
+
+	// Code that does not parse is left alone
+	if bad := "package main\nfunc {"; pruneGo(bad, false) != bad {
+		t.Error("code that does not parse was changed")
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Differential fuzzing (ual dev difffuzz)
+//
+// Generates random well-typed programs from a small grammar, runs each one
+// under iual and the compiled backends, and reports every program whose
+// output or exit status differs between them. Program n of a run is fully
+// determined by the seed and n, so a divergence can be reproduced with
+// --show and the same seed.
+//
+// The generator tracks stack depths and keeps values small, so the
+// programs it writes never pop an empty stack, divide by zero or overflow:
+// a difference in output is a difference between the backends.
+// ============================================================================
+
+// devCommand runs a `ual dev` subcommand
+func devCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ual dev difffuzz [options]")
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "difffuzz":
+		os.Exit(diffFuzz(args[1:]))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dev command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// diffFuzzOptions are the flags of ual dev difffuzz
+type diffFuzzOptions struct {
+	seed     uint64
+	count    int
+	backends []string
+	iual     string
+	keep     string
+	show     int // program to print instead of running, or -1
+}
+
+func parseDiffFuzzFlags(args []string) (diffFuzzOptions, error) {
+	opts := diffFuzzOptions{seed: 1, count: 100, keep: ".", show: -1}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if i+1 >= len(args) {
+			return opts, fmt.Errorf("%s requires an argument", arg)
+		}
+		i++
+		val := args[i]
+		var err error
+		switch arg {
+		case "--seed":
+			opts.seed, err = strconv.ParseUint(val, 10, 64)
+		case "--count", "-n":
+			opts.count, err = strconv.Atoi(val)
+		case "--show":
+			opts.show, err = strconv.Atoi(val)
+		case "--backends":
+			opts.backends = strings.Split(val, ",")
+		case "--iual":
+			opts.iual = val
+		case "--keep":
+			opts.keep = val
+		default:
+			return opts, fmt.Errorf("unknown difffuzz option: %s", arg)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("%s: invalid value %q", arg, val)
+		}
+	}
+	return opts, nil
+}
+
+// diffFuzz runs the harness and returns the process exit status: 0 if all
+// backends agreed on every program, 1 otherwise
+func diffFuzz(args []string) int {
+	opts, err := parseDiffFuzzFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if opts.show >= 0 {
+		fmt.Print(fuzzProgram(opts.seed, opts.show))
+		return 0
+	}
+
+	backends, err := fuzzBackends(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if len(backends) < 2 {
+		fmt.Fprintln(os.Stderr, "error: difffuzz needs at least two working backends")
+		return 1
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ual-difffuzz")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating temp dir: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if verbosity >= verbNormal {
+		fmt.Fprintf(os.Stderr, "difffuzz: seed %d, %d programs, backends %s\n",
+			opts.seed, opts.count, strings.Join(backendNames(backends), ", "))
+	}
+	divergent := 0
+	for n := 0; n < opts.count; n++ {
+		src := fuzzProgram(opts.seed, n)
+		path := filepath.Join(tmpDir, fmt.Sprintf("p%d.ual", n))
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing program: %v\n", err)
+			return 1
+		}
+
+		results := make([]fuzzResult, len(backends))
+		for i, b := range backends {
+			results[i] = b.run(path)
+		}
+		if report := compareResults(backends, results); report != "" {
+			divergent++
+			kept := filepath.Join(opts.keep, fmt.Sprintf("difffuzz-%d-%d.ual", opts.seed, n))
+			if err := os.WriteFile(kept, []byte(src), 0644); err != nil {
+				kept = fmt.Sprintf("not kept: %v", err)
+			}
+			fmt.Printf("program %d (seed %d, %s): backends disagree\n%s", n, opts.seed, kept, report)
+		} else if verbosity >= verbVerbose {
+			fmt.Fprintf(os.Stderr, "program %d: ok\n", n)
+		}
+	}
+
+	fmt.Printf("difffuzz: %d programs, %d divergent\n", opts.count, divergent)
+	if divergent > 0 {
+		return 1
+	}
+	return 0
+}
+
+// ----------------------------------------------------------------------------
+// Backends
+// ----------------------------------------------------------------------------
+
+// fuzzBackend runs a program file under one implementation
+type fuzzBackend struct {
+	name string
+	cmd  func(path string) []string
+}
+
+// fuzzResult is what a backend did with a program
+type fuzzResult struct {
+	stdout string
+	stderr string
+	status int // exit status, -1 if the run failed to start or timed out
+}
+
+// fuzzTimeout bounds one run, including compilation
+const fuzzTimeout = 2 * time.Minute
+
+func (b fuzzBackend) run(path string) fuzzResult {
+	argv := b.cmd(path)
+	ctx, cancel := context.WithTimeout(context.Background(), fuzzTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	r := fuzzResult{stdout: stdout.String(), stderr: stderr.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+		r.status = exitErr.ExitCode()
+	} else if err != nil {
+		r.status = -1
+		r.stderr += err.Error()
+	}
+	return r
+}
+
+// fuzzBackends returns the backends to compare: those named by
+// --backends, or iual, Go and (if its toolchain is installed) Rust. Each
+// is first tried on a trivial program, and one that cannot run it is
+// left out with a warning unless it was asked for by name.
+func fuzzBackends(opts diffFuzzOptions) ([]fuzzBackend, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	iual := opts.iual
+	if iual == "" {
+		iual = filepath.Join(filepath.Dir(self), "iual")
+		if _, err := os.Stat(iual); err != nil {
+			iual, _ = exec.LookPath("iual")
+		}
+	}
+	all := map[string]fuzzBackend{
+		"iual": {"iual", func(path string) []string { return []string{iual, path} }},
+		"go":   {"go", func(path string) []string { return []string{self, "-q", "--target", "go", "run", path} }},
+		"rust": {"rust", func(path string) []string { return []string{self, "-q", "--target", "rust", "run", path} }},
+	}
+
+	names := opts.backends
+	explicit := names != nil
+	if !explicit {
+		names = []string{"iual", "go"}
+		if checkRustVersion() && findRualRuntime() != "" {
+			names = append(names, "rust")
+		}
+	}
+
+	probe, err := os.CreateTemp("", "ual-difffuzz-*.ual")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(probe.Name())
+	probe.WriteString("push:1 dot\n")
+	probe.Close()
+
+	var backends []fuzzBackend
+	for _, name := range names {
+		b, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown backend %q (want iual, go or rust)", name)
+		}
+		if name == "iual" && iual == "" {
+			if explicit {
+				return nil, fmt.Errorf("cannot find iual; build it or pass --iual")
+			}
+			fmt.Fprintln(os.Stderr, "warning: iual not found, leaving it out")
+			continue
+		}
+		if r := b.run(probe.Name()); r.status != 0 || r.stdout != "1\n" {
+			if explicit {
+				return nil, fmt.Errorf("backend %s cannot run programs:\n%s", name, r.stderr)
+			}
+			fmt.Fprintf(os.Stderr, "warning: backend %s cannot run programs, leaving it out\n", name)
+			continue
+		}
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
+func backendNames(backends []fuzzBackend) []string {
+	names := make([]string, len(backends))
+	for i, b := range backends {
+		names[i] = b.name
+	}
+	return names
+}
+
+// compareResults checks every backend against the first and describes
+// the differences, or returns "" if they all agree
+func compareResults(backends []fuzzBackend, results []fuzzResult) string {
+	var b strings.Builder
+	ref := results[0]
+	for i := 1; i < len(results); i++ {
+		r := results[i]
+		if r.status != ref.status {
+			fmt.Fprintf(&b, "  exit status: %s %d, %s %d\n", backends[0].name, ref.status, backends[i].name, r.status)
+		}
+		if r.stdout != ref.stdout {
+			want := strings.SplitAfter(ref.stdout, "\n")
+			got := strings.SplitAfter(r.stdout, "\n")
+			line := 0
+			for line < len(want) && line < len(got) && want[line] == got[line] {
+				line++
+			}
+			fmt.Fprintf(&b, "  output line %d: %s %s, %s %s\n", line+1,
+				backends[0].name, quotedAt(want, line), backends[i].name, quotedAt(got, line))
+		}
+		if b.Len() > 0 && r.stderr != "" && r.status != 0 {
+			fmt.Fprintf(&b, "  %s stderr: %s\n", backends[i].name, firstLine(r.stderr))
+		}
+	}
+	if b.Len() > 0 && ref.stderr != "" && ref.status != 0 {
+		fmt.Fprintf(&b, "  %s stderr: %s\n", backends[0].name, firstLine(ref.stderr))
+	}
+	return b.String()
+}
+
+func quotedAt(lines []string, i int) string {
+	if i < len(lines) && lines[i] != "" {
+		return strconv.Quote(lines[i])
+	}
+	return "end of output"
+}
+
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	return s
+}
+
+// ----------------------------------------------------------------------------
+// Program generator
+// ----------------------------------------------------------------------------
+
+// fuzzStack is a stack of the generated program, with the number of
+// elements it holds at the point being generated
+type fuzzStack struct {
+	name  string
+	elem  string // "i64" or "f64"
+	fifo  bool
+	depth int
+}
+
+// fuzzGen writes one random program
+type fuzzGen struct {
+	rng     *rand.Rand
+	decls   strings.Builder // variable declarations, hoisted to the top
+	body    strings.Builder
+	indent  int
+	ints    []string // i64 variables
+	stacks  []*fuzzStack
+	counter int // loop counters declared
+	nested  int // depth of if/while/for bodies
+}
+
+// fuzzProgram returns program n of the run with the given seed
+func fuzzProgram(seed uint64, n int) string {
+	g := &fuzzGen{rng: rand.New(rand.NewPCG(seed, uint64(n)))}
+	fmt.Fprintf(&g.decls, "-- difffuzz seed %d program %d\n\n", seed, n)
+
+	for i := 0; i < 2+g.rng.IntN(3); i++ {
+		name := fmt.Sprintf("v%d", i)
+		fmt.Fprintf(&g.decls, "var %s i64 = %d\n", name, g.rng.IntN(100))
+		g.ints = append(g.ints, name)
+	}
+	for i := 0; i < 1+g.rng.IntN(3); i++ {
+		s := &fuzzStack{name: fmt.Sprintf("s%d", i), elem: "i64", fifo: g.rng.IntN(2) == 0}
+		if g.rng.IntN(4) == 0 {
+			s.elem = "f64"
+		}
+		persp := ""
+		if s.fifo {
+			persp = ", FIFO"
+		}
+		fmt.Fprintf(&g.decls, "@%s = stack.new(%s%s)\n", s.name, s.elem, persp)
+		g.stacks = append(g.stacks, s)
+	}
+
+	for i := 0; i < 10+g.rng.IntN(20); i++ {
+		g.stmt()
+	}
+	// Print the final state so every variable's value is compared
+	for _, v := range g.ints {
+		g.line("push:%s dot", v)
+	}
+	for _, s := range g.stacks {
+		for ; s.depth > 0; s.depth-- {
+			g.line("@%s dot", s.name)
+		}
+	}
+	return g.decls.String() + "\n" + g.body.String()
+}
+
+func (g *fuzzGen) line(format string, args ...any) {
+	g.body.WriteString(strings.Repeat("    ", g.indent))
+	fmt.Fprintf(&g.body, format, args...)
+	g.body.WriteString("\n")
+}
+
+// floatLit returns a float that prints exactly, like 2.5
+func (g *fuzzGen) floatLit() string {
+	return fmt.Sprintf("%d.%d", g.rng.IntN(20), []int{0, 25, 5, 75}[g.rng.IntN(4)])
+}
+
+func (g *fuzzGen) pick(names []string) string {
+	return names[g.rng.IntN(len(names))]
+}
+
+// intOperand is an i64 variable or a small literal
+func (g *fuzzGen) intOperand() string {
+	if g.rng.IntN(3) == 0 {
+		return strconv.Itoa(1 + g.rng.IntN(20))
+	}
+	return g.pick(g.ints)
+}
+
+// stmt writes one statement. Statements that change a stack's depth are
+// only written at the top level, where the depth is known.
+func (g *fuzzGen) stmt() {
+	top := g.nested == 0
+	switch g.rng.IntN(12) {
+	case 0, 1, 2, 3:
+		g.intAssign()
+	case 4:
+		g.line("push:%s dot", g.intOperand())
+	case 5:
+		if g.nested < 2 {
+			g.ifStmt()
+		} else {
+			g.intAssign()
+		}
+	case 6:
+		if g.nested < 2 {
+			g.whileStmt()
+		} else {
+			g.intAssign()
+		}
+	case 7, 8:
+		if top {
+			g.push()
+		} else {
+			g.intAssign()
+		}
+	case 9:
+		if s := g.stackWithDepth(1); top && s != nil {
+			g.line("@%s dot", s.name)
+			s.depth--
+		} else {
+			g.line("push:%s dot", g.intOperand())
+		}
+	case 10:
+		if s := g.stackWithDepth(1); top && s != nil && s.elem == "i64" {
+			g.forStmt(s)
+		} else {
+			g.intAssign()
+		}
+	case 11:
+		if s := g.stackWithDepth(2); top && s != nil {
+			g.compute(s)
+		} else {
+			g.intAssign()
+		}
+	}
+}
+
+// stackWithDepth returns a random stack holding at least n elements
+func (g *fuzzGen) stackWithDepth(n int) *fuzzStack {
+	var ok []*fuzzStack
+	for _, s := range g.stacks {
+		if s.depth >= n {
+			ok = append(ok, s)
+		}
+	}
+	if len(ok) == 0 {
+		return nil
+	}
+	return ok[g.rng.IntN(len(ok))]
+}
+
+// intAssign writes v = a op b in Forth style. Results are kept below
+// 100003 in magnitude so that no backend overflows.
+func (g *fuzzGen) intAssign() {
+	v := g.pick(g.ints)
+	a, b := g.intOperand(), g.intOperand()
+	switch op := []string{"add", "sub", "mul", "min", "max", "band", "bor", "bxor", "div", "mod"}[g.rng.IntN(10)]; op {
+	case "div", "mod":
+		g.line("push:%s push:%d %s let:%s", a, 1+g.rng.IntN(9), op, v)
+	default:
+		g.line("push:%s push:%s %s push:100003 mod let:%s", a, b, op, v)
+	}
+}
+
+func (g *fuzzGen) block(n int) {
+	g.indent++
+	g.nested++
+	for i := 0; i < n; i++ {
+		g.stmt()
+	}
+	g.nested--
+	g.indent--
+}
+
+func (g *fuzzGen) ifStmt() {
+	cmp := []string{"<", ">", "==", "!=", "<=", ">="}[g.rng.IntN(6)]
+	g.line("if (%s %s %d) {", g.pick(g.ints), cmp, g.rng.IntN(100))
+	g.block(1 + g.rng.IntN(3))
+	if g.rng.IntN(2) == 0 {
+		g.line("} else {")
+		g.block(1 + g.rng.IntN(3))
+	}
+	g.line("}")
+}
+
+// whileStmt writes a counted loop of at most 5 iterations
+func (g *fuzzGen) whileStmt() {
+	i := fmt.Sprintf("i%d", g.counter)
+	g.counter++
+	fmt.Fprintf(&g.decls, "var %s i64 = 0\n", i)
+	g.line("push:0 let:%s", i)
+	g.line("while (%s < %d) {", i, 1+g.rng.IntN(5))
+	g.block(1 + g.rng.IntN(3))
+	g.indent++
+	g.line("push:%s inc let:%s", i, i)
+	g.indent--
+	g.line("}")
+}
+
+// forStmt sums an i64 stack into a variable
+func (g *fuzzGen) forStmt(s *fuzzStack) {
+	v := g.pick(g.ints)
+	g.line("push:0 let:%s", v)
+	g.line("@%s for{|x|", s.name)
+	g.indent++
+	g.line("push:%s push:x add push:100003 mod let:%s", v, v)
+	g.indent--
+	g.line("}")
+}
+
+func (g *fuzzGen) push() {
+	s := g.stacks[g.rng.IntN(len(g.stacks))]
+	if s.elem == "f64" {
+		g.line("@%s push(%s)", s.name, g.floatLit())
+	} else {
+		g.line("@%s push:%s", s.name, g.intOperand())
+	}
+	s.depth++
+}
+
+// compute pops two elements of s and pushes one computed from them
+func (g *fuzzGen) compute(s *fuzzStack) {
+	var expr string
+	if s.elem == "f64" {
+		expr = []string{"a + b", "a - b", "a * 0.5 + b"}[g.rng.IntN(3)]
+	} else {
+		expr = []string{"a + b", "a - b", "a * 3 + b", "(a + b) / 2", "a % 7 + b"}[g.rng.IntN(5)]
+	}
+	g.line("@%s {}.compute(", s.name)
+	g.indent++
+	g.line("{|a, b|")
+	g.indent++
+	g.line("var r = %s", expr)
+	g.line("return r")
+	g.indent--
+	g.line("}")
+	g.indent--
+	g.line(")")
+	s.depth--
+}
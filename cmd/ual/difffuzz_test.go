@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestFuzzProgramDeterministic(t *testing.T) {
+	if fuzzProgram(7, 3) != fuzzProgram(7, 3) {
+		t.Error("same seed and index gave different programs")
+	}
+	if fuzzProgram(7, 3) == fuzzProgram(7, 4) {
+		t.Error("different indexes gave the same program")
+	}
+}
+
+// Every generated program must compile; a program the backends reject
+// would be reported as a divergence that is not one
+func TestFuzzProgramsCompile(t *testing.T) {
+	for n := 0; n < 200; n++ {
+		src := fuzzProgram(1, n)
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatalf("program %d: %v\n%s", n, err, src)
+		}
+		g := NewCodeGen()
+		g.Generate(prog)
+		if g.hasErrors() {
+			t.Fatalf("program %d: %s\n%s", n, g.getErrors()[0], src)
+		}
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	backends := []fuzzBackend{{name: "iual"}, {name: "go"}}
+	same := []fuzzResult{{stdout: "1\n2\n"}, {stdout: "1\n2\n"}}
+	if r := compareResults(backends, same); r != "" {
+		t.Errorf("equal results reported: %s", r)
+	}
+	diff := []fuzzResult{{stdout: "1\n2\n"}, {stdout: "1\n3\n", status: 1, stderr: "boom\n"}}
+	want := "  exit status: iual 0, go 1\n" +
+		"  output line 2: iual \"2\\n\", go \"3\\n\"\n" +
+		"  go stderr: boom\n"
+	if r := compareResults(backends, diff); r != want {
+		t.Errorf("report:\n%s\nwant:\n%s", r, want)
+	}
+}
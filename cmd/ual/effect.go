@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Stack effect checking.
+//
+// checkStackEffects follows the depth of each stack through the program
+// as far as it is known when compiling, the way a Forth programmer follows
+// stack-effect comments, and reports operations that need more elements
+// than the stack holds at that point: add on an empty @dstack, swap on a
+// stack of one element.
+//
+// A depth is known from the start of the program for @dstack, @rstack and
+// @bool, and from its top-level declaration for a LIFO, FIFO or Indexed
+// stack declared once. It is followed through straight-line code and into
+// the branches of an if and the first pass of a loop. Anything the check
+// cannot follow exactly forgets every depth: calls, codeblocks, views,
+// expressions that touch a stack, stack operations it does not model, and
+// loops that break or continue. Once a task has been played, depths are
+// no longer followed at all, since the task runs alongside. Function
+// bodies are not checked, as they start from their caller's stacks.
+//
+// The check never reports an operation that could run with enough
+// elements; it only catches underflows that happen whenever their code
+// runs.
+
+// checkStackEffects returns the stack underflows found in prog, read from
+// path
+func checkStackEffects(prog *ast.Program, path string) error {
+	c := &effectCheck{
+		pos:      prog.Pos,
+		path:     path,
+		declared: map[string]int{},
+		depth:    map[string]int{"dstack": 0, "rstack": 0, "bool": 0},
+	}
+	walkNodes(reflect.ValueOf(prog.Stmts), func(node interface{}) {
+		if d, ok := node.(*ast.StackDecl); ok {
+			c.declared[d.Name]++
+		}
+	})
+	for _, stmt := range prog.Stmts {
+		if d, ok := stmt.(*ast.StackDecl); ok {
+			c.at = prog.Pos[stmt]
+			c.declare(d)
+			continue
+		}
+		if c.stmt(stmt) {
+			break
+		}
+	}
+	if len(c.errors) > 0 {
+		return c.errors
+	}
+	return nil
+}
+
+type effectCheck struct {
+	pos        map[ast.Stmt]ast.Pos
+	path       string
+	declared   map[string]int // declarations of each stack
+	depth      map[string]int // stacks whose depth is known, and the depth
+	concurrent bool           // a task may be running
+	at         ast.Pos        // position of the statement being checked
+	errors     diagnostics
+}
+
+// declare starts following a stack declared at the top level
+func (c *effectCheck) declare(d *ast.StackDecl) {
+	if c.concurrent || c.declared[d.Name] > 1 || d.Perspective == "Hash" || d.Perspective == "Broadcast" {
+		delete(c.depth, d.Name)
+		return
+	}
+	c.depth[d.Name] = 0
+}
+
+// forget makes every depth unknown
+func (c *effectCheck) forget() {
+	c.depth = map[string]int{}
+}
+
+// block checks stmts in order and reports whether they end in a return,
+// break, continue or panic
+func (c *effectCheck) block(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if c.stmt(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// stmt checks one statement and reports whether control leaves the
+// enclosing block after it
+func (c *effectCheck) stmt(stmt ast.Stmt) bool {
+	if pos, ok := c.pos[stmt]; ok {
+		c.at = pos
+	}
+	switch s := stmt.(type) {
+	case *ast.StackOp:
+		c.op(s)
+	case *ast.StackBlock:
+		return c.block(s.Ops)
+	case *ast.Block:
+		return c.block(s.Stmts)
+	case *ast.LetAssign:
+		if c.need(s.Stack, "let", 1) {
+			c.depth[s.Stack]--
+		}
+	case *ast.VarDecl:
+		c.pure(s.Values...)
+	case *ast.Assignment:
+		c.pure(s.Expr)
+	case *ast.ExprStmt:
+		c.pure(s.Expr)
+	case *ast.FuncCall:
+		// Printing builtins and assert leave the stacks alone
+		if s.Name != "print" && s.Name != "println" && s.Name != "printf" && s.Name != "assert" {
+			c.forget()
+		}
+		c.pure(s.Args...)
+	case *ast.IfStmt:
+		c.ifStmt(s)
+	case *ast.WhileStmt:
+		c.pure(s.Condition)
+		c.loop(s.Body)
+	case *ast.RangeStmt:
+		c.pure(s.Start, s.End)
+		c.loop(s.Body)
+	case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt, *ast.PanicStmt:
+		return true
+	case *ast.StackDecl:
+		// Made again on each pass, or for each call
+		delete(c.depth, s.Name)
+	case *ast.FuncDecl, *ast.DeferStmt, *ast.AtExitStmt, *ast.SpawnPush, *ast.StatusStmt, *ast.ErrorPush,
+		*ast.ViewDecl, *ast.ArgsDecl, *ast.ImportStmt:
+		// Nothing runs now
+	case *ast.TestBlock:
+		c.harnessBlock(s.Body)
+	case *ast.BenchBlock:
+		c.harnessBlock(s.Body)
+	case *ast.SpawnOp:
+		if s.Play {
+			c.concurrent = true
+		}
+		c.forget()
+	default:
+		c.forget()
+	}
+	return false
+}
+
+// harnessBlock checks the body of a test or bench block. It runs under
+// ual test or ual bench only, from empty default stacks; what it leaves
+// in the others is not followed.
+func (c *effectCheck) harnessBlock(body []ast.Stmt) {
+	if !c.concurrent {
+		c.depth = map[string]int{"dstack": 0, "rstack": 0, "bool": 0}
+		c.block(body)
+	}
+	c.forget()
+}
+
+// ifStmt checks each branch from the depths before the if. A depth stays
+// known after it if every branch that carries on leaves it the same.
+func (c *effectCheck) ifStmt(s *ast.IfStmt) {
+	if !c.pure(s.Condition) {
+		return
+	}
+	entry := c.depth
+	var outs []map[string]int
+	branch := func(body []ast.Stmt) {
+		c.depth = copyDepths(entry)
+		if !c.block(body) {
+			outs = append(outs, c.depth)
+		}
+	}
+	branch(s.Body)
+	for _, e := range s.ElseIfs {
+		if !c.pure(e.Condition) {
+			c.forget()
+			return
+		}
+		branch(e.Body)
+	}
+	branch(s.Else)
+	c.depth = mergeDepths(outs)
+}
+
+// loop checks the first pass of a loop body from the depths before it.
+// A depth stays known after the loop if the body leaves it unchanged.
+func (c *effectCheck) loop(body []ast.Stmt) {
+	entry := c.depth
+	c.depth = copyDepths(entry)
+	if c.block(body) || leaves(body) {
+		c.forget()
+		return
+	}
+	c.depth = mergeDepths([]map[string]int{entry, c.depth})
+}
+
+// op applies one stack operation
+func (c *effectCheck) op(s *ast.StackOp) {
+	if !c.pure(s.Args...) {
+		return
+	}
+	name, d := s.Stack, 0
+	switch s.Op {
+	case "push":
+		d = len(s.Args)
+	case "pop":
+		moves := s.Target == "" && name != "dstack"
+		if c.need(name, s.Op, 1) {
+			d = -1
+			if moves {
+				c.add("dstack", 1)
+			}
+		} else if moves {
+			delete(c.depth, "dstack")
+		}
+	case "peek":
+		c.need(name, s.Op, 1)
+	case "dup":
+		if c.need(name, s.Op, 1) {
+			d = 1
+		}
+	case "drop", "let", "dot":
+		if c.need(name, s.Op, 1) {
+			d = -1
+		}
+	case "print", "println", "emit":
+		if len(s.Args) == 0 && c.need(name, s.Op, 1) {
+			d = -1
+		}
+	case "swap":
+		c.need(name, s.Op, 2)
+	case "over":
+		if c.need(name, s.Op, 2) {
+			d = 1
+		}
+	case "rot":
+		c.need(name, s.Op, 3)
+	case "neg", "abs", "inc", "dec", "bnot":
+		c.need(name, s.Op, 1)
+	case "add", "sub", "mul", "div", "mod", "min", "max", "band", "bor", "bxor", "shl", "shr":
+		if c.need(name, s.Op, 2) {
+			d = -1
+		}
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		if c.need(name, s.Op, 2) {
+			d = -2
+			c.add("bool", 1)
+		} else {
+			delete(c.depth, "bool")
+		}
+	case "tor":
+		if c.need(name, s.Op, 1) {
+			d = -1
+			c.add("rstack", 1)
+		} else {
+			delete(c.depth, "rstack")
+		}
+	case "fromr":
+		if c.need("rstack", s.Op, 1) {
+			c.add("rstack", -1)
+			d = 1
+		} else {
+			delete(c.depth, name)
+		}
+	case "clear":
+		if _, known := c.depth[name]; known {
+			c.depth[name] = 0
+		}
+	default:
+		c.forget()
+	}
+	c.add(name, d)
+}
+
+// need reports whether the stack is known to hold at least n elements,
+// reporting an underflow if it is known to hold fewer. Its depth is unknown after
+// an underflow, so one mistake is reported once.
+func (c *effectCheck) need(name, op string, n int) bool {
+	depth, known := c.depth[name]
+	if !known {
+		return false
+	}
+	if depth >= n {
+		return true
+	}
+	if c.at.File == "" {
+		pos := c.at
+		pos.File = c.path
+		elements := "elements"
+		if depth == 1 {
+			elements = "element"
+		}
+		c.errors = append(c.errors, fmt.Sprintf("%s: stack underflow: %s needs %d on @%s, which has %d %s here",
+			pos, op, n, name, depth, elements))
+	}
+	delete(c.depth, name)
+	return false
+}
+
+// add changes the depth of a stack, if known, by d
+func (c *effectCheck) add(name string, d int) {
+	if _, known := c.depth[name]; known {
+		c.depth[name] += d
+	}
+}
+
+// pure reports whether exprs leave every stack alone, forgetting every
+// depth if not
+func (c *effectCheck) pure(exprs ...ast.Expr) bool {
+	if !pureExprs(exprs...) {
+		c.forget()
+		return false
+	}
+	return true
+}
+
+// pureExprs reports whether exprs certainly leave every stack alone: they
+// call nothing and name no stack or view
+func pureExprs(exprs ...ast.Expr) bool {
+	for _, e := range exprs {
+		impure := false
+		walkNodes(reflect.ValueOf(e), func(node interface{}) {
+			switch node.(type) {
+			case *ast.FuncCall, *ast.CallExpr, *ast.StackExpr, *ast.StackRef, *ast.ViewExpr, *ast.FnLit:
+				impure = true
+			}
+		})
+		if impure {
+			return false
+		}
+	}
+	return true
+}
+
+// leaves reports whether stmts break or continue a loop
+func leaves(stmts []ast.Stmt) bool {
+	found := false
+	walkNodes(reflect.ValueOf(stmts), func(node interface{}) {
+		switch node.(type) {
+		case *ast.BreakStmt, *ast.ContinueStmt:
+			found = true
+		}
+	})
+	return found
+}
+
+func copyDepths(m map[string]int) map[string]int {
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// mergeDepths returns the depths that are the same in every one of outs
+func mergeDepths(outs []map[string]int) map[string]int {
+	merged := map[string]int{}
+	if len(outs) == 0 {
+		return merged
+	}
+	for name, d := range outs[0] {
+		same := true
+		for _, out := range outs[1:] {
+			if od, ok := out[name]; !ok || od != d {
+				same = false
+				break
+			}
+		}
+		if same {
+			merged[name] = d
+		}
+	}
+	return merged
+}
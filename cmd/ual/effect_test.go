@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestCheckStackEffects(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"push:1\nadd\n", "test.ual:2:1: stack underflow: add needs 2 on @dstack, which has 1 element here"},
+		{"@s = stack.new(i64)\n@s push:1\n@s swap\n", "test.ual:3:1: stack underflow: swap needs 2 on @s, which has 1 element here"},
+		{"@s = stack.new(i64)\n@s { push:1 pop pop }\n", "test.ual:2:1: stack underflow: pop needs 1 on @s, which has 0 elements here"},
+		{"push:1 push:2\ndrop drop\ndot\n", "test.ual:3:1: stack underflow: dot needs 1 on @dstack, which has 0 elements here"},
+		{"push:1\ntor\nfromr fromr\n", "test.ual:3:1: stack underflow: fromr needs 1 on @rstack, which has 0 elements here"},
+		{"var x i64 = 1\nif (x > 0) {\n  push:1\n} else {\n  push:2\n}\nadd\n", "test.ual:7:1: stack underflow: add needs 2 on @dstack, which has 1 element here"},
+		{"for i in 0..3 {\n  push:i\n  mul\n}\n", "test.ual:3:3: stack underflow: mul needs 2 on @dstack, which has 1 element here"},
+		{"push:1 push:2\ntest \"t\" {\n  assert(true)\n  add\n}\n", "test.ual:4:3: stack underflow: add needs 2 on @dstack, which has 0 elements here"},
+		{"push:1\nbench \"b\" {\n  drop\n}\n", "test.ual:3:3: stack underflow: drop needs 1 on @dstack, which has 0 elements here"},
+
+		// Depths the check cannot know
+		{"func two() {\n  push:1 push:2\n}\ntwo()\nadd\n", ""},
+		{"var x i64 = 1\nif (x > 0) {\n  push:1\n}\ndot\n", ""},
+		{"for i in 0..3 {\n  push:i\n}\nadd\n", ""},
+		{"@s = stack.new(i64)\n@s = stack.new(i64)\n@s pop\n", ""},
+		{"@h = stack.new(i64, Hash)\n@h pop\n", ""},
+		{"@s = stack.new(i64)\nfunc fill() {\n  @s push:1\n}\nfill()\n@s pop\n", ""},
+		{"func f() {\n  add\n}\n", ""},
+		{"@s = stack.new(i64)\ntest \"t\" {\n  @s push:1\n}\n@s pop\n", ""},
+	}
+	for _, tt := range tests {
+		prog, err := parser.NewParser(lexer.NewLexer(tt.src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if err := checkStackEffects(prog, "test.ual"); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Escape analysis for user stacks.
+//
+// A stack that only the main goroutine touches can be a ual.UnsafeStack,
+// which skips the mutex. singleThreadedStacks finds them by walking the
+// whole program. A stack is left locked if it is
+//
+//   - used in a spawn block, select, codeblock ({|x| ...}), compute block
+//     or @atexit block, or in a function called from one of these
+//   - named as a value (@s), since the callee may want a *ual.Stack: bring,
+//     walk, views, mock, expect_stack, select cases and the rest
+//   - given an operation outside unsafeStackOps, such as take
+//   - spawn-local, a struct stack or a Broadcast stack
+
+// unsafeStackOps are the operations whose generated code only calls
+// methods that ual.UnsafeStack has
+var unsafeStackOps = map[string]bool{
+	"push": true, "pop": true, "peek": true, "set": true, "get": true, "let": true,
+	"dup": true, "drop": true, "swap": true, "over": true, "rot": true,
+	"add": true, "sub": true, "mul": true, "div": true, "mod": true,
+	"neg": true, "abs": true, "inc": true, "dec": true, "min": true, "max": true,
+	"band": true, "bor": true, "bxor": true, "bnot": true, "shl": true, "shr": true,
+	"eq": true, "ne": true, "lt": true, "gt": true, "le": true, "ge": true,
+	"print": true, "println": true, "dot": true, "emit": true,
+	"tor": true, "fromr": true, "has": true, "del": true, "len": true, "clear": true,
+}
+
+// unsafeStackExprs is unsafeStackOps for stack expressions (@s: pop())
+var unsafeStackExprs = map[string]bool{"pop": true, "peek": true, "len": true, "has": true}
+
+// singleThreadedStacks returns the user stacks of prog that can be
+// generated as ual.UnsafeStack
+func singleThreadedStacks(prog *ast.Program) map[string]bool {
+	a := &escapeAnalysis{
+		funcs:           map[string]*ast.FuncDecl{},
+		stacks:          map[string]bool{},
+		shared:          map[string]bool{},
+		concurrentFuncs: map[string]bool{},
+	}
+	for _, s := range prog.Stmts {
+		if f, ok := s.(*ast.FuncDecl); ok {
+			a.funcs[f.Name] = f
+		}
+	}
+	a.walk(reflect.ValueOf(prog.Stmts), false)
+
+	single := map[string]bool{}
+	if a.allShared {
+		return single
+	}
+	for name := range a.stacks {
+		if !a.shared[name] {
+			single[name] = true
+		}
+	}
+	return single
+}
+
+type escapeAnalysis struct {
+	funcs           map[string]*ast.FuncDecl
+	stacks          map[string]bool // declared user stacks
+	shared          map[string]bool // stacks that must stay locked
+	allShared       bool            // runtime_stats() reads every stack
+	concurrentFuncs map[string]bool // functions called from concurrent code
+}
+
+// call walks the function name again as concurrent code the first time
+// concurrent code calls it
+func (a *escapeAnalysis) call(name string, concurrent bool) {
+	f := a.funcs[name]
+	if !concurrent || f == nil || a.concurrentFuncs[name] {
+		return
+	}
+	a.concurrentFuncs[name] = true
+	a.walk(reflect.ValueOf(f.Body), true)
+}
+
+// walk visits every node under v. concurrent is true inside code that may
+// run on another goroutine.
+func (a *escapeAnalysis) walk(v reflect.Value, concurrent bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			a.walk(v.Elem(), concurrent)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		a.visit(v.Interface(), &concurrent)
+		a.walk(v.Elem(), concurrent)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			a.walk(v.Field(i), concurrent)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			a.walk(v.Index(i), concurrent)
+		}
+	}
+}
+
+// visit records what node says about the stacks it uses, and sets
+// *concurrent for the nodes under it
+func (a *escapeAnalysis) visit(node interface{}, concurrent *bool) {
+	use := func(stack string, ok bool) {
+		if *concurrent || !ok {
+			a.shared[stack] = true
+		}
+	}
+	switch n := node.(type) {
+	case *ast.StackDecl:
+		a.stacks[n.Name] = true
+		use(n.Name, !n.Local && n.ElementType != "struct" && n.Perspective != "Broadcast" && n.Embed == nil)
+	case *ast.StackOp:
+		use(n.Stack, unsafeStackOps[n.Op])
+	case *ast.StackBlock:
+		use(n.Stack, true)
+	case *ast.StackExpr:
+		use(n.Stack, unsafeStackExprs[n.Op])
+	case *ast.ForStmt:
+		use(n.Stack, true)
+	case *ast.StackRef:
+		use(n.Name, false)
+	case *ast.ComputeStmt:
+		use(n.StackName, false)
+		*concurrent = true
+	case *ast.SelectStmt:
+		use(n.DefaultStack, false)
+		for _, c := range n.Cases {
+			use(c.Stack, false)
+		}
+		*concurrent = true
+	case *ast.SpawnPush:
+		use(n.Into, false)
+		*concurrent = true
+	case *ast.FnLit, *ast.AtExitStmt:
+		*concurrent = true
+	case *ast.FuncCall:
+		if n.Name == "runtime_stats" {
+			a.allShared = true
+		}
+		a.call(n.Name, *concurrent)
+	case *ast.CallExpr:
+		a.call(n.Fn, *concurrent)
+	}
+}
+
+// Escape analysis for the variables of functions and codeblocks.
+//
+// Their variables are native Go variables, which each call has of its own,
+// unless concurrent code may use them: a spawn block or select statement
+// in the body runs as a closure that can outlive the statement or run
+// beside it, so the variables it names stay on the frame's type stacks,
+// whose mutex guards them.
+
+// frameEscapes returns the names that the spawn blocks and select
+// statements in body use. It errs on the side of escaping, taking any
+// name they use, a variable of theirs or not.
+func frameEscapes(body []ast.Stmt) map[string]bool {
+	escapes := map[string]bool{}
+	var walk func(v reflect.Value, concurrent bool)
+	walk = func(v reflect.Value, concurrent bool) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem(), concurrent)
+			}
+		case reflect.Ptr:
+			if v.IsNil() {
+				return
+			}
+			var name string
+			switch n := v.Interface().(type) {
+			case *ast.SpawnPush, *ast.SelectStmt:
+				concurrent = true
+			case *ast.Ident:
+				name = n.Name
+			case *ast.StackOp:
+				name = n.Target
+			case *ast.LetAssign:
+				name = n.Name
+			case *ast.Assignment:
+				name = n.Name
+			case *ast.AssignStmt:
+				name = n.Name
+			}
+			if concurrent && name != "" {
+				escapes[name] = true
+			}
+			walk(v.Elem(), concurrent)
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i), concurrent)
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i), concurrent)
+			}
+		}
+	}
+	walk(reflect.ValueOf(body), false)
+	return escapes
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestSingleThreadedStacks(t *testing.T) {
+	src := `
+@seq = stack.new(i64)
+@spawned = stack.new(i64)
+@viaFunc = stack.new(i64)
+@passed = stack.new(i64)
+@taken = stack.new(i64)
+@other = stack.new(i64)
+@local = stack.new(i64)
+
+func touch() {
+	@viaFunc push:1
+}
+
+func quiet() {
+	@local push:1
+}
+
+@seq push:1 push:2 add dot
+@spawned push:1
+@spawn < {
+	@spawned push:2
+	touch()
+}
+@other bring(@passed)
+@taken take:x
+quiet()
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for name := range singleThreadedStacks(prog) {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if want := "local seq"; strings.Join(got, " ") != want {
+		t.Errorf("single-threaded stacks = %v, want %s", got, want)
+	}
+}
+
+func TestSingleThreadedStacksRuntimeStats(t *testing.T) {
+	src := "@a = stack.new(i64)\n@stats = stack.new(i64, Hash)\nruntime_stats(@stats)\n@a push:1\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single := singleThreadedStacks(prog); len(single) != 0 {
+		t.Errorf("runtime_stats() left %v unlocked", single)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"go/format"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatting generated code.
+//
+// Both backends build code line by line, with spacing that depends on
+// the generator, and tidy it before it is written: Go code with go/format,
+// as gofmt would, and Rust code with rustfmt where it is installed. Code
+// that does not format, because the generator made a mistake the compiler
+// will report, is left as it is.
+//
+// The source markers (see sourcemap.go) are not code, so while the code is
+// formatted they are comments, which the formatters keep on lines of their
+// own where they were; afterwards they are markers again, and
+// extractSourceMap sees the formatted lines.
+
+// sourceComment starts a marker while it is a comment: the marker after
+// sourceMarker follows, quoted. The space keeps gofmt from taking it for a
+// directive.
+const sourceComment = "// ual:src "
+
+// rustfmtTimeout bounds how long rustfmt may take
+const rustfmtTimeout = 30 * time.Second
+
+// formatGo returns code, with markers, formatted by go/format
+func formatGo(code string) string {
+	formatted, ok := formatMarked(code, func(src []byte) ([]byte, error) {
+		return format.Source(src)
+	})
+	if !ok {
+		return code
+	}
+	// Without the markers, lines that a comment kept apart may align as
+	// gofmt aligns them. Formatting once more must not add or remove
+	// lines, or the markers would point at the wrong ones.
+	plain, _ := extractSourceMap(formatted)
+	again, err := format.Source([]byte(plain))
+	if err != nil || bytes.Count(again, []byte("\n")) != strings.Count(plain, "\n") {
+		return formatted
+	}
+	return reinsertMarkers(formatted, string(again))
+}
+
+// formatRust returns code, with markers, formatted by rustfmt, or code as
+// it is if rustfmt is not installed
+func formatRust(code string) string {
+	path, err := exec.LookPath("rustfmt")
+	if err != nil {
+		return code
+	}
+	formatted, _ := formatMarked(code, func(src []byte) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), rustfmtTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, path, "--edition", "2021", "--emit", "stdout", "--quiet")
+		cmd.Stdin = bytes.NewReader(src)
+		return cmd.Output()
+	})
+	return formatted
+}
+
+// formatMarked formats code with fn, keeping its markers in place. It
+// returns code and false if fn fails or loses a marker.
+func formatMarked(code string, fn func([]byte) ([]byte, error)) (string, bool) {
+	src, markers := markersToComments(code)
+	formatted, err := fn([]byte(src))
+	if err != nil || len(formatted) == 0 {
+		return code, false
+	}
+	out, kept, ok := commentsToMarkers(string(formatted))
+	if !ok || kept != markers {
+		return code, false
+	}
+	return out, true
+}
+
+// markersToComments returns code with its markers as comments, and how
+// many there were
+func markersToComments(code string) (string, int) {
+	var src strings.Builder
+	markers := 0
+	for _, line := range strings.SplitAfter(code, "\n") {
+		if rest, ok := strings.CutPrefix(line, sourceMarker); ok {
+			src.WriteString(sourceComment + strconv.Quote(strings.TrimSuffix(rest, "\n")) + "\n")
+			markers++
+			continue
+		}
+		src.WriteString(line)
+	}
+	return src.String(), markers
+}
+
+// commentsToMarkers undoes markersToComments on src, returning how many
+// markers it found, and false if one of them is not as it was written.
+// Runs of blank lines are collapsed to one.
+func commentsToMarkers(src string) (string, int, bool) {
+	var out strings.Builder
+	markers := 0
+	blank := false // the last line of code was blank
+	for _, line := range strings.SplitAfter(src, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimLeft(line, " \t"), sourceComment); ok {
+			marker, err := strconv.Unquote(strings.TrimSpace(rest))
+			if err != nil {
+				return src, 0, false
+			}
+			out.WriteString(sourceMarker + marker + "\n")
+			markers++
+			continue
+		}
+		if line == "\n" && blank {
+			continue // a marker kept two blank lines apart
+		}
+		blank = line == "\n"
+		out.WriteString(line)
+	}
+	return out.String(), markers, true
+}
+
+// reinsertMarkers returns plain, which is marked without its markers
+// after changes within its lines, with the markers of marked
+func reinsertMarkers(marked, plain string) string {
+	lines := strings.SplitAfter(plain, "\n")
+	var out strings.Builder
+	n := 0
+	for _, line := range strings.SplitAfter(marked, "\n") {
+		if strings.HasPrefix(line, sourceMarker) {
+			out.WriteString(line)
+			continue
+		}
+		if n < len(lines) {
+			out.WriteString(lines[n])
+			n++
+		}
+	}
+	return out.String()
+}
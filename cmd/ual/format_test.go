@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"go/format"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestGeneratedGoFormatted(t *testing.T) {
+	src := `@s = stack.new(i64)
+var sq = {|x| x * x}
+@s push:2
+@s { dup mul }.consider(
+    ok: println("ok")
+    error |e|: println(e)
+)
+var n i64 = sq(3)
+`
+	generate := func() string {
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := NewCodeGen()
+		g.srcFile = "prog.ual"
+		return g.Generate(prog)
+	}
+	out := generate()
+	formatted, err := format.Source([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != out {
+		t.Errorf("generated code is not gofmt-clean:\n%s", out)
+	}
+	if again := generate(); again != out {
+		t.Error("generating the program twice gives different code")
+	}
+
+	// Made-up names are numbered by kind
+	for _, want := range []string{"_fn1", "_saved_status_1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("code lacks %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatMarked(t *testing.T) {
+	marker := sourceMarker + "prog.ual\x002\x001\n"
+	code := "package main\n\nvar a   = 1\n\n" + marker + "\nvar b = 2\n"
+	out, ok := formatMarked(code, func(src []byte) ([]byte, error) {
+		return format.Source(src)
+	})
+	if want := "package main\n\nvar a = 1\n\n" + marker + "var b = 2\n"; !ok || out != want {
+		t.Errorf("formatted %q, %v, want %q", out, ok, want)
+	}
+
+	// Code that does not format is left as it is
+	out, ok = formatMarked(code, func([]byte) ([]byte, error) { return nil, errors.New("syntax error") })
+	if ok || out != code {
+		t.Errorf("formatted %q, %v", out, ok)
+	}
+	if got := formatGo("package main\nfunc {\n"); got != "package main\nfunc {\n" {
+		t.Errorf("formatGo changed code that does not parse: %q", got)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Stack parameters and generic functions.
+//
+// A stack parameter, as in func drain(@src stack(i64)), passes the
+// caller's stack by reference; its argument must be a stack of that
+// element type. A function whose stack parameter names a type parameter
+// instead of a type, as in func sum(@s stack(T)) T, is generic over the
+// numeric element types. instantiateGenerics replaces it by one copy for
+// each element type it is called with, T replaced by that type
+// throughout, and points each call at its copy: sum(@ints) and
+// sum(@floats) call sum__i64 and sum__f64.
+// The backends then only see ordinary functions.
+
+// builtinStackTypes are the element types of the stacks every program has
+var builtinStackTypes = map[string]string{"dstack": "i64", "rstack": "i64", "bool": "bool", "error": "string"}
+
+// instantiateGenerics checks the stack arguments of calls in prog, read
+// from path, and monomorphizes its generic functions. It reports stack
+// arguments of the wrong type and calls whose type parameters cannot be
+// bound.
+func instantiateGenerics(prog *ast.Program, path string) error {
+	gi := &genericInstances{
+		prog:      prog,
+		path:      path,
+		funcs:     map[string]*ast.FuncDecl{},
+		generics:  map[string]*ast.FuncDecl{},
+		stacks:    map[string]string{},
+		instances: map[string]*ast.FuncDecl{},
+		byGeneric: map[string][]*ast.FuncDecl{},
+	}
+	for _, stmt := range prog.Stmts {
+		f, ok := stmt.(*ast.FuncDecl)
+		if !ok || len(stackParams(f)) == 0 {
+			continue
+		}
+		gi.funcs[f.Name] = f
+		if len(f.TypeParams) > 0 {
+			gi.generics[f.Name] = f
+		}
+	}
+	if len(gi.funcs) == 0 {
+		return nil
+	}
+	for name, typ := range builtinStackTypes {
+		gi.stacks[name] = typ
+	}
+	gi.collectStacks(reflect.ValueOf(prog.Stmts))
+
+	// Calls from ordinary code, then from each instance as it is made
+	for _, stmt := range prog.Stmts {
+		f, ok := stmt.(*ast.FuncDecl)
+		switch {
+		case !ok:
+			gi.scan(stmt, nil)
+		case gi.generics[f.Name] == nil:
+			gi.scan(f, stackParams(f))
+		}
+	}
+	for len(gi.pending) > 0 {
+		f := gi.pending[0]
+		gi.pending = gi.pending[1:]
+		gi.scan(f, stackParams(f))
+	}
+	if len(gi.errors) > 0 {
+		return gi.errors
+	}
+
+	// Each generic function is replaced by its instances, in its place
+	var stmts []ast.Stmt
+	for _, stmt := range prog.Stmts {
+		if f, ok := stmt.(*ast.FuncDecl); ok && gi.generics[f.Name] != nil {
+			for _, inst := range gi.byGeneric[f.Name] {
+				stmts = append(stmts, inst)
+			}
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	prog.Stmts = stmts
+	return nil
+}
+
+type genericInstances struct {
+	prog      *ast.Program
+	path      string
+	funcs     map[string]*ast.FuncDecl   // functions with stack parameters, by name
+	generics  map[string]*ast.FuncDecl   // generic functions, by name
+	stacks    map[string]string          // element type of each declared stack
+	instances map[string]*ast.FuncDecl   // instances, by instance name
+	byGeneric map[string][]*ast.FuncDecl // instances of each generic function, in order made
+	pending   []*ast.FuncDecl            // instances whose calls are not yet scanned
+	pos       ast.Pos                    // position of the statement being scanned
+	errors    diagnostics
+}
+
+// stackParams returns the element types of f's stack parameters
+func stackParams(f *ast.FuncDecl) map[string]string {
+	params := map[string]string{}
+	for _, p := range f.Params {
+		if p.Stack {
+			params[p.Name] = valueType(p.Type)
+		}
+	}
+	return params
+}
+
+// collectStacks records the element type of every stack declared under v
+func (gi *genericInstances) collectStacks(v reflect.Value) {
+	walkNodes(v, func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.StackDecl:
+			typ := valueType(n.ElementType)
+			if typ == "" {
+				typ = "i64"
+			}
+			gi.stacks[n.Name] = typ
+		case *ast.ArgsDecl:
+			gi.stacks["args"] = "string"
+		}
+	})
+}
+
+// scan checks the stack arguments of the calls under node and points the
+// calls to generic functions at their instances. params are the stack
+// parameters in scope.
+func (gi *genericInstances) scan(node interface{}, params map[string]string) {
+	walkNodes(reflect.ValueOf(node), func(n interface{}) {
+		if stmt, ok := n.(ast.Stmt); ok {
+			if pos, ok := gi.prog.Pos[stmt]; ok {
+				gi.pos = pos
+			}
+		}
+		switch c := n.(type) {
+		case *ast.FuncCall:
+			if name, ok := gi.instantiate(c.Name, c.Args, params); ok {
+				c.Name = name
+			}
+		case *ast.CallExpr:
+			if name, ok := gi.instantiate(c.Fn, c.Args, params); ok {
+				c.Fn = name
+			}
+		}
+	})
+}
+
+// instantiate checks the stack arguments of a call to name. If name is
+// generic, it binds its type parameters and returns the name of its
+// instance, making it if needed.
+func (gi *genericInstances) instantiate(name string, args []ast.Expr, params map[string]string) (string, bool) {
+	f := gi.funcs[name]
+	if f == nil {
+		return "", false
+	}
+	bound := map[string]string{}
+	for i, p := range f.Params {
+		if !p.Stack || i >= len(args) {
+			continue
+		}
+		ref, ok := args[i].(*ast.StackRef)
+		if !ok {
+			gi.errorf("%s: argument %d must be a stack, as in %s(@name)", name, i+1, name)
+			return "", false
+		}
+		elem, ok := params[ref.Name]
+		if !ok {
+			elem, ok = gi.stacks[ref.Name]
+		}
+		if !ok {
+			gi.errorf("%s: undefined stack @%s", name, ref.Name)
+			return "", false
+		}
+		if !isTypeParam(f, p.Type) {
+			if want := valueType(p.Type); elem != want {
+				gi.errorf("%s: argument %d must be a stack(%s), but @%s is a stack(%s)", name, i+1, want, ref.Name, elem)
+				return "", false
+			}
+			continue
+		}
+		if prev, ok := bound[p.Type]; ok && prev != elem {
+			gi.errorf("%s: %s is both %s and %s", name, p.Type, prev, elem)
+			return "", false
+		}
+		if !isNumericType(elem) {
+			gi.errorf("%s: %s must be a numeric type, but @%s is a stack(%s)", name, p.Type, ref.Name, elem)
+			return "", false
+		}
+		bound[p.Type] = elem
+	}
+	if gi.generics[name] == nil {
+		return "", false
+	}
+
+	var types []string
+	for _, tp := range f.TypeParams {
+		if bound[tp] == "" {
+			gi.errorf("%s: cannot tell the type of %s from the arguments", name, tp)
+			return "", false
+		}
+		types = append(types, bound[tp])
+	}
+	instName := name + "__" + strings.Join(types, "_")
+	if gi.instances[instName] == nil {
+		inst := gi.copyFunc(f, bound)
+		inst.Name = instName
+		gi.instances[instName] = inst
+		gi.byGeneric[name] = append(gi.byGeneric[name], inst)
+		gi.pending = append(gi.pending, inst)
+	}
+	return instName, true
+}
+
+func (gi *genericInstances) errorf(format string, args ...interface{}) {
+	pos := gi.pos
+	if pos.File == "" {
+		pos.File = gi.path
+	}
+	gi.errors = append(gi.errors, pos.String()+": "+fmt.Sprintf(format, args...))
+}
+
+// copyFunc returns a deep copy of f with its type parameters replaced by
+// the types bound to them. Copied statements keep their positions.
+func (gi *genericInstances) copyFunc(f *ast.FuncDecl, bound map[string]string) *ast.FuncDecl {
+	subst := func(t string) string {
+		if b, ok := bound[t]; ok {
+			return b
+		}
+		return t
+	}
+	var cp func(v reflect.Value) reflect.Value
+	cp = func(v reflect.Value) reflect.Value {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if v.IsNil() {
+				return v
+			}
+			n := reflect.New(v.Elem().Type())
+			n.Elem().Set(cp(v.Elem()))
+			if stmt, ok := v.Interface().(ast.Stmt); ok && gi.prog.Pos != nil {
+				if pos, ok := gi.prog.Pos[stmt]; ok {
+					gi.prog.Pos[n.Interface().(ast.Stmt)] = pos
+				}
+			}
+			return n
+		case reflect.Interface:
+			if v.IsNil() {
+				return v
+			}
+			n := reflect.New(v.Type()).Elem()
+			n.Set(cp(v.Elem()))
+			return n
+		case reflect.Struct:
+			n := reflect.New(v.Type()).Elem()
+			for i := 0; i < v.NumField(); i++ {
+				if field := v.Type().Field(i); field.Type.Kind() == reflect.String && typeFields[field.Name] {
+					n.Field(i).SetString(subst(v.Field(i).String()))
+				} else {
+					n.Field(i).Set(cp(v.Field(i)))
+				}
+			}
+			return n
+		case reflect.Slice:
+			if v.IsNil() {
+				return v
+			}
+			n := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			for i := 0; i < v.Len(); i++ {
+				n.Index(i).Set(cp(v.Index(i)))
+			}
+			return n
+		}
+		return v
+	}
+	inst := cp(reflect.ValueOf(f)).Interface().(*ast.FuncDecl)
+	inst.TypeParams = nil
+	return inst
+}
+
+// isTypeParam reports whether t is a type parameter of f
+func isTypeParam(f *ast.FuncDecl, t string) bool {
+	for _, tp := range f.TypeParams {
+		if tp == t {
+			return true
+		}
+	}
+	return false
+}
+
+// typeFields are the AST fields that hold a type name
+var typeFields = map[string]bool{"Type": true, "ReturnType": true, "ElementType": true}
+
+// walkNodes calls visit for every pointer node under v, parents first
+func walkNodes(v reflect.Value, visit func(node interface{})) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkNodes(v.Elem(), visit)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		visit(v.Interface())
+		walkNodes(v.Elem(), visit)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			walkNodes(v.Field(i), visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkNodes(v.Index(i), visit)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestInstantiateGenerics(t *testing.T) {
+	src := `func first(@s stack(T)) T {
+    var x T = @s: peek()
+    return x
+}
+func twice(@s stack(T)) T {
+    return first(@s) + first(@s)
+}
+@a = stack.new(i64)
+@b = stack.new(f64)
+x = twice(@a)
+y = first(@b)
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := instantiateGenerics(prog, "test.ual"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, stmt := range prog.Stmts {
+		if f, ok := stmt.(*ast.FuncDecl); ok {
+			names = append(names, f.Name+":"+f.ReturnType)
+			if decl, ok := f.Body[0].(*ast.VarDecl); ok && decl.Type != f.ReturnType {
+				t.Errorf("%s: var type %s, want %s", f.Name, decl.Type, f.ReturnType)
+			}
+		}
+	}
+	want := "first__f64:f64 first__i64:i64 twice__i64:i64"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("instances %q, want %q", got, want)
+	}
+}
+
+func TestInstantiateGenericsErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"func f(@s stack(T)) {\n}\nf(1)\n", "test.ual:3:1: f: argument 1 must be a stack, as in f(@name)"},
+		{"func f(@s stack(T)) {\n}\nf(@nope)\n", "test.ual:3:1: f: undefined stack @nope"},
+		{"func f(@s stack(i64)) {\n}\n@a = stack.new(string)\nf(@a)\n", "test.ual:4:1: f: argument 1 must be a stack(i64), but @a is a stack(string)"},
+		{"func f(@s stack(T), @t stack(T)) {\n}\n@a = stack.new(i64)\n@b = stack.new(f64)\nf(@a, @b)\n", "test.ual:5:1: f: T is both i64 and f64"},
+		{"func f(@s stack(T)) {\n}\n@a = stack.new(string)\nf(@a)\n", "test.ual:4:1: f: T must be a numeric type, but @a is a stack(string)"},
+	}
+	for _, tt := range tests {
+		prog, err := parser.NewParser(lexer.NewLexer(tt.src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = instantiateGenerics(prog, "test.ual")
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got error %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+// Each numeric width gets an instance whose result converts to T unless T
+// is i64 or f64, which expressions compute in, so the generated Go
+// compiles whatever T is
+func TestGenericWidths(t *testing.T) {
+	widths := map[string]string{
+		"i8": "int8", "i16": "int16", "i32": "int32", "i64": "int64",
+		"u8": "uint8", "u16": "uint16", "u32": "uint32", "u64": "uint64",
+		"f32": "float32", "f64": "float64",
+	}
+	for w, goType := range widths {
+		src := `func sum(@s stack(T)) T {
+    var total T = 0
+    @s for {|v|
+        total = total + v
+    }
+    return total
+}
+@s = stack.new(` + w + `)
+@s push:1 push:2
+println(sum(@s))
+`
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := instantiateGenerics(prog, "test.ual"); err != nil {
+			t.Fatalf("%s: %v", w, err)
+		}
+		g := NewCodeGen()
+		code := g.Generate(prog)
+		if g.hasErrors() {
+			t.Fatalf("%s: %s", w, g.getErrors()[0])
+		}
+		if _, err := format.Source([]byte(code)); err != nil {
+			t.Fatalf("%s: generated invalid Go: %v", w, err)
+		}
+		sig := "func sum__" + w + "(stack_s *ual.Stack) " + goType + " {"
+		at := strings.Index(code, sig)
+		if at < 0 {
+			t.Fatalf("%s: no %q in\n%s", w, sig, code)
+		}
+		body := code[at:]
+		body = body[:strings.Index(body, "\n}\n")]
+		if w != "i64" && w != "f64" && !strings.Contains(body, "return "+goType+"(") {
+			t.Errorf("%s: result not converted to %s:\n%s", w, goType, body)
+		}
+
+		rg := NewRustCodeGen()
+		rg.Generate(prog)
+		if rg.hasErrors() {
+			t.Errorf("%s: rust: %s", w, rg.getErrors()[0])
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+)
+
+// ual highlight colours a source file with the lexer's own tokens, so
+// docs and terminals need no separate grammar. Every byte of the source
+// is written back unchanged, wrapped in ANSI escapes or HTML spans.
+
+// Highlight classes, also the HTML class names without their ual- prefix
+const (
+	hlPlain   = ""
+	hlKeyword = "keyword"
+	hlOp      = "op"
+	hlStack   = "stack"
+	hlType    = "type"
+	hlString  = "string"
+	hlNumber  = "number"
+	hlComment = "comment"
+)
+
+// ansiColors are the SGR parameters of each class
+var ansiColors = map[string]string{
+	hlKeyword: "1;35",
+	hlOp:      "36",
+	hlStack:   "33",
+	hlType:    "34",
+	hlString:  "32",
+	hlNumber:  "35",
+	hlComment: "90",
+}
+
+// hlSpan is a piece of source and its class
+type hlSpan struct {
+	text  string
+	class string
+}
+
+func highlightCommand(args []string) {
+	format := "ansi"
+	var path string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--format":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "error: --format requires an argument (ansi or html)")
+				os.Exit(1)
+			}
+			i++
+			format = args[i]
+		default:
+			path = arg
+		}
+	}
+	if format != "ansi" && format != "html" {
+		fmt.Fprintf(os.Stderr, "error: --format must be 'ansi' or 'html', got '%s'\n", format)
+		os.Exit(1)
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "usage: ual highlight [--format ansi|html] <file.ual>")
+		os.Exit(1)
+	}
+	source, err := readFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	spans := highlightSpans(source)
+	if format == "html" {
+		writeHTML(os.Stdout, spans)
+	} else {
+		writeANSI(os.Stdout, spans)
+	}
+}
+
+// highlightSpans splits source into classed spans. Joined, their text is
+// source.
+func highlightSpans(source string) []hlSpan {
+	lineStarts := []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	var spans []hlSpan
+	add := func(text, class string) {
+		if text == "" {
+			return
+		}
+		if n := len(spans); n > 0 && spans[n-1].class == class {
+			spans[n-1].text += text
+			return
+		}
+		spans = append(spans, hlSpan{text, class})
+	}
+
+	lex := lexer.NewLexer(source)
+	end := 0 // of the last token
+	for {
+		tok := lex.NextToken()
+		if tok.Type == lexer.TokError {
+			break // the rest is left plain
+		}
+		start := len(source)
+		if tok.Type != lexer.TokEOF {
+			start = lineStarts[tok.Line-1] + tok.Column - 1
+		}
+		// Between tokens there is only blank space and comments
+		for _, s := range commentSpans(source[end:start]) {
+			add(s.text, s.class)
+		}
+		if tok.Type == lexer.TokEOF {
+			return spans
+		}
+		end = lex.Offset()
+		add(source[start:end], tokenClass(tok))
+	}
+	add(source[end:], hlPlain)
+	return spans
+}
+
+// commentSpans splits the text between two tokens into blank space and
+// comments
+func commentSpans(gap string) []hlSpan {
+	var spans []hlSpan
+	plain := 0 // start of the blank space not yet added
+	for i := 0; i < len(gap); {
+		if !isCommentStart(gap[i:]) {
+			i++
+			continue
+		}
+		n := len(gap) - i // a line comment runs to the end of the gap
+		if strings.HasPrefix(gap[i:], "/*") {
+			if k := strings.Index(gap[i+2:], "*/"); k >= 0 {
+				n = k + 4
+			}
+		}
+		if i > plain {
+			spans = append(spans, hlSpan{gap[plain:i], hlPlain})
+		}
+		spans = append(spans, hlSpan{gap[i : i+n], hlComment})
+		i += n
+		plain = i
+	}
+	if plain < len(gap) {
+		spans = append(spans, hlSpan{gap[plain:], hlPlain})
+	}
+	return spans
+}
+
+func isCommentStart(s string) bool {
+	return strings.HasPrefix(s, "--") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/*")
+}
+
+// tokenClass returns the class of tok
+func tokenClass(tok lexer.Token) string {
+	t := tok.Type
+	switch {
+	case t == lexer.TokStackRef:
+		return hlStack
+	case t == lexer.TokInt || t == lexer.TokFloat || t == lexer.TokTrue || t == lexer.TokFalse:
+		return hlNumber
+	case t >= lexer.TokString && t <= lexer.TokStringTail:
+		return hlString
+	case t >= lexer.TokPush && t <= lexer.TokGet,
+		t >= lexer.TokAdd && t <= lexer.TokGe,
+		t >= lexer.TokDup && t <= lexer.TokFromR:
+		return hlOp
+	case t >= lexer.TokLIFO && t <= lexer.TokBytes:
+		return hlType
+	case t >= lexer.TokStack && t <= lexer.TokSelf:
+		return hlKeyword
+	}
+	return hlPlain
+}
+
+// writeANSI writes spans coloured with ANSI escapes. Each line is reset
+// and coloured again, so pagers that cut lines keep the colours right.
+func writeANSI(w io.Writer, spans []hlSpan) {
+	var b strings.Builder
+	for _, s := range spans {
+		color := ansiColors[s.class]
+		if color == "" {
+			b.WriteString(s.text)
+			continue
+		}
+		lines := strings.SplitAfter(s.text, "\n")
+		for _, line := range lines {
+			text := strings.TrimSuffix(line, "\n")
+			if text != "" {
+				fmt.Fprintf(&b, "\x1b[%sm%s\x1b[0m", color, text)
+			}
+			if len(text) < len(line) {
+				b.WriteByte('\n')
+			}
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+// writeHTML writes spans as a <pre> block of <span class="ual-...">
+// elements, for a stylesheet to colour
+func writeHTML(w io.Writer, spans []hlSpan) {
+	var b strings.Builder
+	b.WriteString(`<pre class="ual"><code>`)
+	for _, s := range spans {
+		if s.class == hlPlain {
+			b.WriteString(html.EscapeString(s.text))
+			continue
+		}
+		fmt.Fprintf(&b, `<span class="ual-%s">%s</span>`, s.class, html.EscapeString(s.text))
+	}
+	b.WriteString("</code></pre>\n")
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHighlightSpans(t *testing.T) {
+	src := "-- top\n@q = stack.new(i64, FIFO) /* note */\nvar s = \"a ${x} b\"\nif (x < 3) { @q push:1.5 dup } // end"
+	var joined strings.Builder
+	classes := map[string]string{}
+	for _, s := range highlightSpans(src) {
+		joined.WriteString(s.text)
+		classes[strings.TrimSpace(s.text)] = s.class
+	}
+	if joined.String() != src {
+		t.Fatalf("spans do not join to the source:\n%q", joined.String())
+	}
+	for text, want := range map[string]string{
+		"-- top":     hlComment,
+		"/* note */": hlComment,
+		"// end":     hlComment,
+		"@q":         hlStack,
+		"FIFO":       hlType,
+		"\"a ${":     hlString,
+		"} b\"":      hlString,
+		"push":       hlOp,
+		"dup":        hlOp,
+		"1.5":        hlNumber,
+		"if":         hlKeyword,
+		"stack":      hlKeyword,
+	} {
+		if got, ok := classes[text]; !ok || got != want {
+			t.Errorf("%q: class %q, want %q", text, got, want)
+		}
+	}
+
+	// Every example comes back byte for byte
+	files, _ := filepath.Glob("../../examples/*.ual")
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		for _, s := range highlightSpans(string(data)) {
+			b.WriteString(s.text)
+		}
+		if b.String() != string(data) {
+			t.Errorf("%s: highlighted text differs from the source", f)
+		}
+	}
+}
+
+func TestHighlightOutput(t *testing.T) {
+	spans := highlightSpans("@q push:\"<b>\" -- a\n-- b\n")
+	var h bytes.Buffer
+	writeHTML(&h, spans)
+	want := `<pre class="ual"><code><span class="ual-stack">@q</span> <span class="ual-op">push</span>:<span class="ual-string">&#34;&lt;b&gt;&#34;</span> <span class="ual-comment">-- a</span>` + "\n" +
+		`<span class="ual-comment">-- b</span>` + "\n</code></pre>\n"
+	if h.String() != want {
+		t.Errorf("HTML:\n%s\nwant:\n%s", h.String(), want)
+	}
+
+	var a bytes.Buffer
+	writeANSI(&a, []hlSpan{{"/* a\nb */", hlComment}, {"\n", hlPlain}})
+	if want := "\x1b[90m/* a\x1b[0m\n\x1b[90mb */\x1b[0m\n"; a.String() != want {
+		t.Errorf("ANSI: got %q, want %q", a.String(), want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteProject(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		existing string // a file already in the directory
+		err      string
+	}{
+		{name: "myproj"},
+		{name: "tool-2", existing: "notes.txt"},
+		{name: "taken", existing: "ual.toml", err: "ual.toml already exists"},
+		{name: "started", existing: "main.ual", err: "main.ual already exists"},
+	} {
+		dir := filepath.Join(t.TempDir(), tc.name)
+		if tc.existing != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, tc.existing), []byte("mine\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		name, err := writeProject(dir)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, tc.existing)); string(data) != "mine\n" {
+				t.Errorf("%s: %s was overwritten", tc.name, tc.existing)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".gitignore")); err == nil {
+				t.Errorf("%s: wrote files after refusing", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if name != tc.name {
+			t.Errorf("named %q, want %q", name, tc.name)
+		}
+		for file, want := range map[string]string{
+			"ual.toml":                         `name = "` + tc.name + `"`,
+			"main.ual":                         `import "std/`,
+			".gitignore":                       "/" + tc.name + "\n",
+			filepath.Join("tests", ".gitkeep"): "",
+		} {
+			data, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			} else if !strings.Contains(string(data), want) {
+				t.Errorf("%s: %s lacks %q:\n%s", tc.name, file, want, data)
+			}
+		}
+		m, err := loadManifest(dir)
+		if err != nil || m.Name != tc.name || m.Main != "main.ual" {
+			t.Errorf("%s: manifest %+v, %v", tc.name, m, err)
+		}
+
+		// The entry point compiles, its std import included
+		prog, err := loadProgram(filepath.Join(dir, "main.ual"))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if _, _, err := generateGoProgram(prog, filepath.Join(dir, "main.ual")); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/check"
+	"github.com/ha1tch/ual/pkg/eval"
+)
+
+// Inlining.
+//
+// A call to a ual function costs more than most of their bodies: the
+// generated function makes a frame stack, pushes each parameter onto it
+// and reads them back. inlineCalls replaces calls to small leaf functions
+// with what they compute, so
+//
+//	func square(n i64) i64 { return n * n }
+//	total = total + square(i)
+//
+// compiles as total = total + i * i. A function is inlined when it takes
+// and returns i64, its body is local variables and a return, it uses
+// nothing but its parameters, literals, arithmetic and pure builtins, and
+// what it returns, its locals replaced by their values, is at most
+// --inline nodes of syntax. A call is only inlined where that cannot
+// change what the program does: an argument the body uses more than once
+// must be a variable or a literal, one it uses once must have no effects,
+// and one it does not use must be a literal.
+//
+// Calls inside compute blocks are left alone, since their names are the
+// block's, and so is the function itself, which stays for the calls that
+// were not inlined.
+
+// defaultInlineLimit is the size of the largest function inlined when
+// neither --inline nor ual.toml says otherwise
+const defaultInlineLimit = 12
+
+// inlineFunc is a function calls to which can be inlined
+type inlineFunc struct {
+	params []string
+	result ast.Expr       // in terms of the parameters alone
+	uses   map[string]int // how often result uses each parameter
+}
+
+type inliner struct {
+	funcs    map[string]*inlineFunc
+	declared map[string]int // the program's functions
+}
+
+// exprType is the type of the AST fields a call can be replaced in
+var exprType = reflect.TypeOf((*ast.Expr)(nil)).Elem()
+
+// inlineCalls inlines the calls in prog to functions of up to limit nodes
+// and returns how many it inlined
+func inlineCalls(prog *ast.Program, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	declared := map[string]int{}
+	var decls []*ast.FuncDecl
+	walkNodes(reflect.ValueOf(prog.Stmts), func(node interface{}) {
+		if f, ok := node.(*ast.FuncDecl); ok {
+			declared[f.Name]++
+			decls = append(decls, f)
+		}
+	})
+	in := &inliner{funcs: map[string]*inlineFunc{}, declared: declared}
+	for _, f := range decls {
+		if declared[f.Name] != 1 || check.BuiltinFuncs[f.Name] {
+			continue
+		}
+		if fn := leafFunc(f, declared); fn != nil && size(fn.result) <= limit {
+			in.funcs[f.Name] = fn
+		}
+	}
+	if len(in.funcs) == 0 {
+		return 0
+	}
+	n := 0
+	in.rewrite(reflect.ValueOf(prog.Stmts), &n)
+	return n
+}
+
+// leafFunc returns f as an inlineFunc, or nil if it cannot be inlined.
+// funcs are the program's functions, which a leaf does not call.
+func leafFunc(f *ast.FuncDecl, funcs map[string]int) *inlineFunc {
+	if f.ReturnType != "i64" || f.CanFail || len(f.TypeParams) > 0 || len(f.Body) == 0 {
+		return nil
+	}
+	fn := &inlineFunc{uses: map[string]int{}}
+	values := map[string]ast.Expr{} // parameters and locals
+	for _, p := range f.Params {
+		if p.Stack || p.Type != "i64" || values[p.Name] != nil {
+			return nil
+		}
+		fn.params = append(fn.params, p.Name)
+		values[p.Name] = &ast.Ident{Name: p.Name}
+	}
+
+	// var x i64 = ...; return ...
+	for _, s := range f.Body[:len(f.Body)-1] {
+		d, ok := s.(*ast.VarDecl)
+		if !ok || len(d.Names) != 1 || len(d.Values) != 1 || d.Type != "" && d.Type != "i64" {
+			return nil
+		}
+		v, ok := leafExpr(d.Values[0], values, funcs)
+		if !ok {
+			return nil
+		}
+		values[d.Names[0]] = v
+	}
+	ret, ok := f.Body[len(f.Body)-1].(*ast.ReturnStmt)
+	if !ok || ret.Value == nil || len(ret.Values) > 0 {
+		return nil
+	}
+	if fn.result, ok = leafExpr(ret.Value, values, funcs); !ok {
+		return nil
+	}
+	walkNodes(reflect.ValueOf(fn.result), func(node interface{}) {
+		if id, ok := node.(*ast.Ident); ok {
+			fn.uses[id.Name]++
+		}
+	})
+	return fn
+}
+
+// leafExpr returns e with the names in values replaced by their values,
+// and false if it is anything but arithmetic on them, literals and pure
+// builtins
+func leafExpr(e ast.Expr, values map[string]ast.Expr, funcs map[string]int) (ast.Expr, bool) {
+	switch e := e.(type) {
+	case *ast.IntLit:
+		return &ast.IntLit{Value: e.Value}, true
+	case *ast.Ident:
+		v, ok := values[e.Name]
+		if !ok {
+			return nil, false
+		}
+		return copyExpr(v), true
+	case *ast.UnaryExpr:
+		if e.Op != "-" {
+			return nil, false
+		}
+		operand, ok := leafExpr(e.Operand, values, funcs)
+		return &ast.UnaryExpr{Op: e.Op, Operand: operand}, ok
+	case *ast.BinaryOp:
+		switch e.Op {
+		case "+", "-", "*", "/", "%":
+		default:
+			return nil, false
+		}
+		left, ok := leafExpr(e.Left, values, funcs)
+		if !ok {
+			return nil, false
+		}
+		right, ok := leafExpr(e.Right, values, funcs)
+		return &ast.BinaryOp{Left: left, Op: e.Op, Right: right}, ok
+	case *ast.FuncCall:
+		if !eval.Pure(e.Name) || funcs[e.Name] > 0 {
+			return nil, false
+		}
+		call := &ast.FuncCall{Name: e.Name}
+		for _, arg := range e.Args {
+			a, ok := leafExpr(arg, values, funcs)
+			if !ok {
+				return nil, false
+			}
+			call.Args = append(call.Args, a)
+		}
+		return call, true
+	}
+	return nil, false
+}
+
+// copyExpr returns a copy of e, an expression leafExpr accepts
+func copyExpr(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case *ast.IntLit:
+		return &ast.IntLit{Value: e.Value}
+	case *ast.Ident:
+		return &ast.Ident{Name: e.Name}
+	case *ast.UnaryExpr:
+		return &ast.UnaryExpr{Op: e.Op, Operand: copyExpr(e.Operand)}
+	case *ast.BinaryOp:
+		return &ast.BinaryOp{Left: copyExpr(e.Left), Op: e.Op, Right: copyExpr(e.Right)}
+	case *ast.FuncCall:
+		call := &ast.FuncCall{Name: e.Name}
+		for _, arg := range e.Args {
+			call.Args = append(call.Args, copyExpr(arg))
+		}
+		return call
+	}
+	return e
+}
+
+// size is the number of nodes in e
+func size(e ast.Expr) int {
+	n := 0
+	walkNodes(reflect.ValueOf(e), func(interface{}) { n++ })
+	return n
+}
+
+// rewrite inlines the calls under v, innermost first, counting them in n
+func (in *inliner) rewrite(v reflect.Value, n *int) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		in.rewrite(v.Elem(), n)
+		if call, ok := v.Interface().(*ast.FuncCall); ok && v.Type() == exprType && v.CanSet() {
+			if e := in.inline(call); e != nil {
+				v.Set(reflect.ValueOf(e))
+				*n++
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		switch node := v.Interface().(type) {
+		case *ast.ComputeStmt:
+			return
+		case *ast.ExprStmt:
+			// A codeblock's value: its arguments, not the call itself
+			if node.Expr != nil {
+				in.rewrite(reflect.ValueOf(node.Expr), n)
+			}
+			return
+		}
+		in.rewrite(v.Elem(), n)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			in.rewrite(v.Field(i), n)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			in.rewrite(v.Index(i), n)
+		}
+	}
+}
+
+// inline returns what call computes, or nil if it is not to be inlined
+func (in *inliner) inline(call *ast.FuncCall) ast.Expr {
+	fn, ok := in.funcs[call.Name]
+	if !ok || len(call.Args) != len(fn.params) {
+		return nil
+	}
+	args := map[string]ast.Expr{}
+	for i, p := range fn.params {
+		arg := call.Args[i]
+		switch uses := fn.uses[p]; {
+		case uses == 0 && !isLiteral(arg):
+			return nil
+		case uses == 1 && !in.pureArg(arg):
+			return nil
+		case uses > 1 && !isLiteral(arg):
+			if _, ok := arg.(*ast.Ident); !ok {
+				return nil
+			}
+		}
+		args[p] = arg
+	}
+	// The first use of an argument takes it, the others copies
+	used := map[string]bool{}
+	var result func(e ast.Expr) ast.Expr
+	result = func(e ast.Expr) ast.Expr {
+		switch e := e.(type) {
+		case *ast.Ident:
+			if used[e.Name] {
+				return copyExpr(args[e.Name])
+			}
+			used[e.Name] = true
+			return args[e.Name]
+		case *ast.UnaryExpr:
+			return &ast.UnaryExpr{Op: e.Op, Operand: result(e.Operand)}
+		case *ast.BinaryOp:
+			return &ast.BinaryOp{Left: result(e.Left), Op: e.Op, Right: result(e.Right)}
+		case *ast.FuncCall:
+			c := &ast.FuncCall{Name: e.Name}
+			for _, a := range e.Args {
+				c.Args = append(c.Args, result(a))
+			}
+			return c
+		}
+		return copyExpr(e)
+	}
+	return result(fn.result)
+}
+
+// isLiteral reports whether e is an integer literal, negated or not
+func isLiteral(e ast.Expr) bool {
+	if u, ok := e.(*ast.UnaryExpr); ok && u.Op == "-" {
+		e = u.Operand
+	}
+	_, ok := e.(*ast.IntLit)
+	return ok
+}
+
+// pureArg reports whether evaluating e has no effects: it is variables,
+// literals and arithmetic, comparison and pure builtins on them
+func (in *inliner) pureArg(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.IntLit, *ast.FloatLit, *ast.StringLit, *ast.BoolLit, *ast.Ident:
+		return true
+	case *ast.UnaryExpr:
+		return in.pureArg(e.Operand)
+	case *ast.BinaryOp:
+		return in.pureArg(e.Left) && in.pureArg(e.Right)
+	case *ast.BinaryExpr:
+		return in.pureArg(e.Left) && in.pureArg(e.Right)
+	case *ast.FuncCall:
+		if !eval.Pure(e.Name) || in.declared[e.Name] > 0 {
+			return false
+		}
+		for _, arg := range e.Args {
+			if !in.pureArg(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+func TestInlineCalls(t *testing.T) {
+	funcs := `func square(n i64) i64 {
+    return n * n
+}
+func add3(a i64, b i64, c i64) i64 {
+    var t i64 = a + b
+    return t + c
+}
+func half(x f64) f64 {
+    return x / 2.0
+}
+func outer(n i64) i64 {
+    return square(n) + 1
+}
+func first(a i64, b i64) i64 {
+    return a
+}
+@s = stack.new(i64)
+var i i64 = 3
+`
+	for _, tc := range []struct {
+		call   string
+		limit  int
+		inline bool
+	}{
+		{"square(i)", 12, true},
+		{"square(4)", 12, true},
+		{"add3(i, 1, i + 1)", 12, true},
+		{"add3(i, 1, 2)", 4, false},
+		{"square(i + 1)", 12, false},         // used twice, not a variable
+		{"square(@s: pop())", 12, false},     // an effect
+		{"add3(@s: pop(), 1, 2)", 12, false}, // used once, but an effect
+		{"first(i, 2)", 12, true},
+		{"first(i, i)", 12, false}, // b unused, not a literal
+		{"outer(i)", 12, false},    // not a leaf
+		{"square(i)", 0, false},
+	} {
+		prog := parseLibrary(t, funcs+"println("+tc.call+")\n")
+		n := inlineCalls(prog, tc.limit)
+		name := tc.call[:strings.Index(tc.call, "(")]
+		calls := 0
+		walkNodes(reflect.ValueOf(prog.Stmts[len(prog.Stmts)-1]), func(node interface{}) {
+			if c, ok := node.(*ast.FuncCall); ok && c.Name == name {
+				calls++
+			}
+		})
+		if inlined := n > 0 && calls == 0; inlined != tc.inline {
+			t.Errorf("%s, limit %d: inlined %v, want %v", tc.call, tc.limit, inlined, tc.inline)
+		}
+	}
+
+	// half is f64, so stays a call; outer's call to square is inlined
+	prog := parseLibrary(t, funcs+"var h f64 = half(1.0)\n")
+	if n := inlineCalls(prog, 12); n != 1 {
+		t.Errorf("inlined %d calls, want 1", n)
+	}
+
+	// The arguments land where the parameters were
+	prog = parseLibrary(t, funcs+"println(add3(i, 1, 2))\n")
+	inlineCalls(prog, 12)
+	g := NewCodeGen()
+	out := g.Generate(prog)
+	if errs := g.getErrors(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if !strings.Contains(out, "+ 1) + 2))") || strings.Contains(out, "add3(") {
+		t.Errorf("add3 not inlined:\n%s", out)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/version"
+)
+
+const (
+	ualModule    = "github.com/ha1tch/ual"
+	sqliteModule = ualModule + "/pkg/sqlite"
+)
+
+var keepSourceDir string // --keep-source: write the Go project here and build it there
+
+// writeGoProject writes goCode to dir as a Go module that builds on its own
+// with go build: main.go, a go.mod requiring the ual runtime, and the
+// runtime's packages under vendor/, so that no download or local copy of
+// ual is needed. Files already in dir that ual does not write are left.
+func writeGoProject(dir, name, goCode string) error {
+	src, err := ualSource(findUalRuntime())
+	if err != nil {
+		return err
+	}
+	imports := goImports(goCode)
+	for _, imp := range imports {
+		if imp == sqliteModule {
+			// Its driver is not part of ual, and would have to be downloaded
+			return fmt.Errorf("--keep-source does not support programs that use query or exec")
+		}
+	}
+	pkgs, err := ualPackages(src, imports)
+	if err != nil {
+		return err
+	}
+
+	vendor := filepath.Join(dir, "vendor")
+	if err := os.RemoveAll(vendor); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		rel := strings.TrimPrefix(pkg, ualModule)
+		if err := copyGoFiles(filepath.Join(src, rel), filepath.Join(vendor, ualModule, rel)); err != nil {
+			return err
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(src, "LICENSE")); err == nil {
+		if err := os.WriteFile(filepath.Join(vendor, ualModule, "LICENSE"), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	// modules.txt records what go mod vendor would have, so that go build
+	// finds the vendored runtime consistent with go.mod
+	modules := fmt.Sprintf("# %s v%s\n## explicit; go %s\n%s\n",
+		ualModule, version.Version, moduleGoVersion(src), strings.Join(pkgs, "\n"))
+	goMod := fmt.Sprintf(`module %s
+
+go 1.22
+
+require %s v%s
+`, name, ualModule, version.Version)
+
+	files := []struct{ path, data string }{
+		{filepath.Join(vendor, "modules.txt"), modules},
+		{filepath.Join(dir, "go.mod"), goMod},
+		{filepath.Join(dir, "main.go"), goCode},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, []byte(f.data), 0644); err != nil {
+			return err
+		}
+	}
+	os.Remove(filepath.Join(dir, "go.sum")) // nothing is downloaded to check
+	return nil
+}
+
+// ualSource returns the directory holding the source of the ual module:
+// the local copy at ualDir, or the released version from the module cache
+func ualSource(ualDir string) (string, error) {
+	if ualDir != "" {
+		return ualDir, nil
+	}
+	out, err := exec.Command("go", "mod", "download", "-json", ualModule+"@v"+version.Version).Output()
+	if err != nil {
+		return "", fmt.Errorf("downloading %s v%s: %v", ualModule, version.Version, err)
+	}
+	var mod struct{ Dir, Error string }
+	if err := json.Unmarshal(out, &mod); err != nil || mod.Dir == "" {
+		return "", fmt.Errorf("downloading %s v%s: %s", ualModule, version.Version, mod.Error)
+	}
+	return mod.Dir, nil
+}
+
+// ualPackages returns the ual packages among imports and those they
+// import in turn, sorted, reading their source under src
+func ualPackages(src string, imports []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var visit func(pkg string) error
+	visit = func(pkg string) error {
+		if seen[pkg] || !strings.HasPrefix(pkg, ualModule+"/") {
+			return nil
+		}
+		seen[pkg] = true
+		files, err := goFiles(filepath.Join(src, strings.TrimPrefix(pkg, ualModule)))
+		if err != nil {
+			return err
+		}
+		for _, path := range files {
+			f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+			if err != nil {
+				return err
+			}
+			for _, imp := range f.Imports {
+				dep, _ := strconv.Unquote(imp.Path.Value)
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, imp := range imports {
+		if err := visit(imp); err != nil {
+			return nil, err
+		}
+	}
+	pkgs := make([]string, 0, len(seen))
+	for pkg := range seen {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
+
+// goFiles returns the Go files of the package in dir, without its tests
+func goFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files, nil
+}
+
+// copyGoFiles copies the package in src to dst, as go mod vendor would
+func copyGoFiles(src, dst string) error {
+	files, err := goFiles(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, path := range files {
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		out, err := os.Create(filepath.Join(dst, filepath.Base(path)))
+		if err == nil {
+			_, err = io.Copy(out, in)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moduleGoVersion returns the go version the go.mod in dir declares
+func moduleGoVersion(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), "go "); ok {
+				return strings.TrimSpace(v)
+			}
+		}
+	}
+	return "1.22"
+}
+
+// goModuleName makes a Go module path of a program's file name
+func goModuleName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), ".ual")
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	name = strings.TrimLeft(name, ".-")
+	if name == "" {
+		return "ual_program"
+	}
+	return name
+}
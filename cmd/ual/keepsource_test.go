@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUalPackages(t *testing.T) {
+	got, err := ualPackages("../..", []string{"fmt", "github.com/ha1tch/ual/pkg/runtime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"github.com/ha1tch/ual/pkg/runtime", "github.com/ha1tch/ual/pkg/runtime/internal/deque"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packages %v, want %v", got, want)
+	}
+}
+
+func TestGoModuleName(t *testing.T) {
+	for path, want := range map[string]string{
+		"dir/hello.ual": "hello",
+		"my prog.ual":   "my_prog",
+		".hidden.ual":   "hidden",
+		"/tmp/.ual":     "ual_program",
+		"server-v2.ual": "server-v2",
+	} {
+		if got := goModuleName(path); got != want {
+			t.Errorf("goModuleName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Libraries.
+//
+// `ual compile --lib` generates a package for other code to use, not a
+// program. In Go it is a package named after the .ual file, with each
+// function and each stack the program declares exported under its name
+// capitalised:
+//
+//	func square(n i64) i64 { return n * n }     Square(n int64) int64
+//	@jobs = stack.new(i64, FIFO)                 StackJobs *ual.Stack
+//
+// The statements outside functions run in the package's init function.
+// In Rust the functions and stacks become pub, and the statements outside
+// functions go in a pub fn init(), which the crate's users call first.
+
+// libraryName returns the Go package name for the library generated from
+// the program at path: its file name, lower case, without what Go names
+// cannot hold
+func libraryName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var b strings.Builder
+	for _, r := range strings.ToLower(base) {
+		if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) || token.IsKeyword(name) || name == "main" {
+		name = "ual" + name
+	}
+	return name
+}
+
+// exportName returns name capitalised, and false if Go cannot export it
+func exportName(name string) (string, bool) {
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		return "", false
+	}
+	return strings.ToUpper(name[:1]) + name[1:], true
+}
+
+// generateExports exports the functions and global stacks of a library
+// under names Go packages can use
+func (g *CodeGen) generateExports(funcs []*ast.FuncDecl, stacks []*ast.StackDecl) {
+	exported := map[string]string{} // Go name -> what it exports
+	export := func(name, what string) bool {
+		if prev, ok := exported[name]; ok {
+			g.addError(fmt.Sprintf("%s and %s are both exported as %s", prev, what, name))
+			return false
+		}
+		exported[name] = what
+		return true
+	}
+
+	// Functions named with a capital are exported as they are
+	for _, f := range funcs {
+		if name, ok := exportName(f.Name); ok && name == f.Name {
+			export(name, "function "+f.Name)
+		}
+	}
+	for _, f := range funcs {
+		if f.Name == "init" {
+			g.addError("a library cannot declare a function named init: its init runs the statements outside functions")
+			continue
+		}
+		name, ok := exportName(f.Name)
+		if !ok || name == f.Name || !export(name, "function "+f.Name) {
+			continue
+		}
+		params, returnSig := g.funcSignature(f)
+		var args []string
+		for n, p := range f.Params {
+			args = append(args, p.Name)
+			if p.Stack && !hasParam(f, p.Name, false) {
+				params[n] = strings.TrimPrefix(params[n], "stack_") // src, not stack_src
+			} else if p.Stack {
+				args[n] = "stack_" + p.Name
+			}
+		}
+		call := fmt.Sprintf("%s(%s)", f.Name, strings.Join(args, ", "))
+		if returnSig != "" {
+			call = "return " + call
+		}
+		g.writeln(fmt.Sprintf("// %s is the ual function %s.", name, f.Name))
+		if returnSig != "" {
+			returnSig += " "
+		}
+		g.writeln(fmt.Sprintf("func %s(%s) %s{", name, strings.Join(params, ", "), returnSig))
+		g.indent++
+		g.writeln(call)
+		g.indent--
+		g.writeln("}")
+		g.writeln("")
+	}
+
+	for _, s := range stacks {
+		name, ok := exportName(s.Name)
+		if !ok || !export("Stack"+name, "stack @"+s.Name) {
+			continue
+		}
+		g.writeln(fmt.Sprintf("// Stack%s is the stack @%s.", name, s.Name))
+		g.writeln(fmt.Sprintf("var Stack%s = %s", name, g.stackVarName(s.Name)))
+		g.writeln("")
+	}
+}
+
+// hasParam reports whether f has a parameter named name that is a stack,
+// or is not
+func hasParam(f *ast.FuncDecl, name string, stack bool) bool {
+	for _, p := range f.Params {
+		if p.Name == name && p.Stack == stack {
+			return true
+		}
+	}
+	return false
+}
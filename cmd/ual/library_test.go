@@ -0,0 +1,105 @@
+package main
+
+import (
+	goparser "go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func parseLibrary(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestLibrary(t *testing.T) {
+	src := `@results = stack.new(i64, FIFO)
+func square(n i64) i64 {
+    return n * n
+}
+func Cube(n i64) i64 {
+    return n * n * n
+}
+func drain(@src stack(i64)) i64 {
+    var v i64 = 0
+    @src pop:v
+    return v
+}
+@results push(square(2))
+`
+	g := NewCodeGen()
+	g.library = "mathx"
+	out := g.Generate(parseLibrary(t, src))
+	if errs := g.getErrors(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := goparser.ParseFile(token.NewFileSet(), "mathx.go", out, 0); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	for _, want := range []string{
+		"package mathx\n",
+		"func init() {",
+		"func Square(n int64) int64 {\n\treturn square(n)\n}",
+		"func Drain(src *ual.Stack) int64 {\n\treturn drain(src)\n}",
+		"var StackResults = stack_results\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("library lacks %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"func main()", "func Cube(n int64) int64 {\n\treturn", "NewUnsafeStack", "// Results"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("library has %q:\n%s", unwanted, out)
+		}
+	}
+
+	r := NewRustCodeGen()
+	r.library = true
+	out = r.Generate(parseLibrary(t, src))
+	for _, want := range []string{"pub static ref STACK_RESULTS", "pub fn square(n: i64) -> i64", "pub fn init() {"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Rust library lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "fn main()") {
+		t.Errorf("Rust library has a main:\n%s", out)
+	}
+}
+
+func TestLibraryErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"func size() i64 {\n    return 1\n}\nfunc Size() i64 {\n    return 2\n}\n": "function Size and function size are both exported as Size",
+		"func init() {\n    println(1)\n}\n":                                       "cannot declare a function named init",
+		"args {\n    --n i64 = 1\n}\n":                                             "args blocks are for programs",
+	} {
+		g := NewCodeGen()
+		g.library = "lib"
+		g.Generate(parseLibrary(t, src))
+		if errs := g.getErrors(); len(errs) != 1 || !strings.Contains(errs[0], want) {
+			t.Errorf("%q: errors %q, want %q", src, errs, want)
+		}
+	}
+}
+
+func TestLibraryName(t *testing.T) {
+	for path, want := range map[string]string{
+		"lib/mathx.ual":  "mathx",
+		"My-Utils.ual":   "myutils",
+		"2d.ual":         "ual2d",
+		"func.ual":       "ualfunc",
+		"main.ual":       "ualmain",
+		"string_ops.ual": "string_ops",
+	} {
+		if got := libraryName(path); got != want {
+			t.Errorf("libraryName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
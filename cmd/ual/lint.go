@@ -0,0 +1,717 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/check"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/module"
+	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/version"
+)
+
+// ============================================================================
+// Linting (ual lint)
+//
+// ual lint looks for code that compiles and runs but is probably not what
+// was meant, which the compiler lets through:
+//
+//	stack-balance        if/else branches that leave a stack at different depths
+//	frozen-push          a push to a stack frozen earlier in the same code
+//	consider-default     a consider with no _ case
+//	select-never-pushed  a select case on a stack nothing pushes to
+//	shadowed-builtin     a name that hides a builtin function
+//
+// Each rule only reports what it can see for certain, so a clean lint is
+// no proof; where a rule cannot follow the code (a call, a codeblock, an
+// operation it does not model) it says nothing. Rules are turned off with
+// --disable, or disable = "rule, rule" in the [lint] section of ual.toml,
+// and --enable runs only the rules it names. The findings are printed as
+// file:line:col: message (rule), or with --format sarif as a SARIF 2.1.0
+// log for code scanning tools. Library code is not linted.
+// ============================================================================
+
+// lintRule is one check of ual lint
+type lintRule struct {
+	name string
+	doc  string
+	run  func(l *linter)
+}
+
+var lintRules = []lintRule{
+	{"stack-balance", "if/else branches leave a stack at different depths", lintStackBalance},
+	{"frozen-push", "a push to a stack that is already frozen", lintFrozenPush},
+	{"consider-default", "a consider block has no default (_) case", lintConsiderDefault},
+	{"select-never-pushed", "a select case waits on a stack nothing pushes to", lintSelectNeverPushed},
+	{"shadowed-builtin", "a function, variable or parameter hides a builtin function", lintShadowedBuiltin},
+}
+
+// lintFinding is one problem found by a rule
+type lintFinding struct {
+	rule string
+	pos  ast.Pos
+	msg  string
+}
+
+func (f lintFinding) String() string {
+	return fmt.Sprintf("%s: %s (%s)", f.pos, f.msg, f.rule)
+}
+
+// linter runs the rules over one program
+type linter struct {
+	prog     *ast.Program
+	path     string
+	rule     string // the rule running
+	findings []lintFinding
+}
+
+// lintProgram returns what the rules find in prog, read from path, in
+// source order
+func lintProgram(prog *ast.Program, path string, rules []lintRule) []lintFinding {
+	l := &linter{prog: prog, path: path}
+	for _, r := range rules {
+		l.rule = r.name
+		r.run(l)
+	}
+	sort.SliceStable(l.findings, func(a, b int) bool {
+		pa, pb := l.findings[a].pos, l.findings[b].pos
+		if pa.Line != pb.Line {
+			return pa.Line < pb.Line
+		}
+		return pa.Col < pb.Col
+	})
+	return l.findings
+}
+
+// report records a finding at stmt, unless stmt came from a library
+func (l *linter) report(stmt ast.Stmt, format string, args ...interface{}) {
+	pos, ok := l.prog.Pos[stmt]
+	if !ok || pos.File != "" {
+		return
+	}
+	pos.File = l.path
+	l.findings = append(l.findings, lintFinding{l.rule, pos, fmt.Sprintf(format, args...)})
+}
+
+// inspect calls fn for every node of the program with the innermost
+// statement holding it that has a position, for reporting
+func (l *linter) inspect(fn func(node interface{}, at ast.Stmt)) {
+	var enclosing []ast.Stmt
+	ast.Inspect(l.prog.Stmts, func(node any) bool {
+		if s, ok := node.(ast.Stmt); ok {
+			if _, ok := l.prog.Pos[s]; ok {
+				enclosing = append(enclosing, s)
+			}
+		}
+		if len(enclosing) > 0 {
+			fn(node, enclosing[len(enclosing)-1])
+		}
+		return true
+	}, func(node any) {
+		if s, ok := node.(ast.Stmt); ok && len(enclosing) > 0 && enclosing[len(enclosing)-1] == s {
+			enclosing = enclosing[:len(enclosing)-1]
+		}
+	})
+}
+
+// ----------------------------------------------------------------------------
+// stack-balance
+
+// lintStackBalance reports an if with an else whose branches, each of
+// which it can follow, change some stack by different amounts: the code
+// after it cannot know what the stack holds
+func lintStackBalance(l *linter) {
+	l.inspect(func(node interface{}, at ast.Stmt) {
+		s, ok := node.(*ast.IfStmt)
+		if !ok || len(s.Else) == 0 {
+			return
+		}
+		branches := [][]ast.Stmt{s.Body}
+		labels := []string{"if"}
+		for _, e := range s.ElseIfs {
+			branches = append(branches, e.Body)
+			labels = append(labels, "elseif")
+		}
+		branches = append(branches, s.Else)
+		labels = append(labels, "else")
+
+		effects := make([]map[string]int, len(branches))
+		changed := map[string]bool{}
+		for n, b := range branches {
+			effect, ok := netEffect(b)
+			if !ok {
+				return
+			}
+			effects[n] = effect
+			for name := range effect {
+				changed[name] = true
+			}
+		}
+		names := make([]string, 0, len(changed))
+		for name := range changed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var parts []string
+			balanced := true
+			for n, effect := range effects {
+				balanced = balanced && effect[name] == effects[0][name]
+				parts = append(parts, fmt.Sprintf("%s %+d", labels[n], effect[name]))
+			}
+			if !balanced {
+				l.report(at, "branches change @%s by different amounts (%s)", name, strings.Join(parts, ", "))
+			}
+		}
+	})
+}
+
+// netEffect returns how many elements stmts leave on each stack they
+// change, and false if it cannot tell
+func netEffect(stmts []ast.Stmt) (map[string]int, bool) {
+	effect := map[string]int{}
+	add := func(name string, d int) {
+		if effect[name] += d; effect[name] == 0 {
+			delete(effect, name)
+		}
+	}
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.StackOp:
+			if !pureExprs(s.Args...) || !opEffect(s, add) {
+				return nil, false
+			}
+		case *ast.StackBlock:
+			inner, ok := netEffect(s.Ops)
+			if !ok {
+				return nil, false
+			}
+			for name, d := range inner {
+				add(name, d)
+			}
+		case *ast.LetAssign:
+			add(s.Stack, -1)
+		case *ast.VarDecl:
+			if !pureExprs(s.Values...) {
+				return nil, false
+			}
+		case *ast.Assignment:
+			if !pureExprs(s.Expr) {
+				return nil, false
+			}
+		case *ast.AssignStmt:
+			if !pureExprs(s.Value) {
+				return nil, false
+			}
+		case *ast.FuncCall:
+			if s.Name != "print" && s.Name != "println" && s.Name != "printf" || !pureExprs(s.Args...) {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return effect, true
+}
+
+// opEffect passes the change a stack operation makes to each stack to
+// add, returning false for an operation it does not model
+func opEffect(s *ast.StackOp, add func(name string, d int)) bool {
+	name := s.Stack
+	switch s.Op {
+	case "push":
+		add(name, len(s.Args))
+	case "pop":
+		add(name, -1)
+		if s.Target == "" && name != "dstack" {
+			add("dstack", 1)
+		}
+	case "peek", "swap", "rot", "neg", "abs", "inc", "dec", "bnot", "freeze":
+	case "dup", "over":
+		add(name, 1)
+	case "drop", "let", "dot":
+		add(name, -1)
+	case "print", "println", "emit":
+		if len(s.Args) == 0 {
+			add(name, -1)
+		}
+	case "add", "sub", "mul", "div", "mod", "min", "max", "band", "bor", "bxor", "shl", "shr":
+		add(name, -1)
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		add(name, -2)
+		add("bool", 1)
+	case "tor":
+		add(name, -1)
+		add("rstack", 1)
+	case "fromr":
+		add("rstack", -1)
+		add(name, 1)
+	default:
+		return false
+	}
+	return true
+}
+
+// ----------------------------------------------------------------------------
+// frozen-push
+
+// lintFrozenPush follows each block in order and reports operations that
+// add to a stack after the block, or a block around it, froze the stack
+// in a mode that rejects new elements. An embedded stack is frozen
+// everywhere. Function bodies only know about embedded stacks, as they
+// may be called before the freeze.
+func lintFrozenPush(l *linter) {
+	embedded := map[string]string{}
+	for _, stmt := range l.prog.Stmts {
+		if d, ok := stmt.(*ast.StackDecl); ok && d.Embed != nil {
+			embedded[d.Name] = "is embedded, and so frozen"
+		}
+	}
+	l.frozenBlock(l.prog.Stmts, copyFrozen(embedded), embedded)
+}
+
+// frozenBlock checks stmts with frozen, the stacks frozen on entry and
+// why
+func (l *linter) frozenBlock(stmts []ast.Stmt, frozen, embedded map[string]string) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.StackDecl:
+			if s.Embed == nil {
+				delete(frozen, s.Name)
+			}
+		case *ast.StackOp:
+			l.frozenOp(s, s, frozen)
+			continue
+		case *ast.StackBlock:
+			for _, op := range s.Ops {
+				if o, ok := op.(*ast.StackOp); ok {
+					at := ast.Stmt(o)
+					if _, ok := l.prog.Pos[o]; !ok {
+						at = s
+					}
+					l.frozenOp(o, at, frozen)
+				}
+			}
+			continue
+		case *ast.FuncDecl:
+			l.frozenBlock(s.Body, copyFrozen(embedded), embedded)
+			continue
+		}
+		nestedBlocks(reflect.ValueOf(stmt), func(body []ast.Stmt) {
+			l.frozenBlock(body, copyFrozen(frozen), embedded)
+		})
+	}
+}
+
+// frozenOp checks one stack operation, reported at at
+func (l *linter) frozenOp(s *ast.StackOp, at ast.Stmt, frozen map[string]string) {
+	switch s.Op {
+	case "freeze":
+		mode := "all"
+		if len(s.Args) > 0 {
+			lit, ok := s.Args[0].(*ast.StringLit)
+			if !ok {
+				return
+			}
+			mode = lit.Value
+		}
+		if _, already := frozen[s.Stack]; !already && (mode == "all" || mode == "structure") {
+			frozen[s.Stack] = fmt.Sprintf("was frozen at line %d", l.prog.Pos[at].Line)
+		}
+	case "push", "dup", "over", "fromr", "bring":
+		if why, ok := frozen[s.Stack]; ok {
+			l.report(at, "%s to @%s, which %s", s.Op, s.Stack, why)
+		}
+	}
+}
+
+func copyFrozen(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// nestedBlocks calls fn with each list of statements directly below v:
+// the bodies of an if, a loop, a handler or a codeblock
+func nestedBlocks(v reflect.Value, fn func([]ast.Stmt)) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			nestedBlocks(v.Elem(), fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				nestedBlocks(v.Field(i), fn)
+			}
+		}
+	case reflect.Slice:
+		if body, ok := v.Interface().([]ast.Stmt); ok {
+			fn(body)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			nestedBlocks(v.Index(i), fn)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// consider-default
+
+// lintConsiderDefault reports a consider with no _ case, which does
+// nothing for a status none of its cases name
+func lintConsiderDefault(l *linter) {
+	l.inspect(func(node interface{}, at ast.Stmt) {
+		s, ok := node.(*ast.ConsiderStmt)
+		if !ok {
+			return
+		}
+		var labels []string
+		for _, c := range s.Cases {
+			if c.Label == "_" {
+				return
+			}
+			labels = append(labels, c.Label)
+		}
+		l.report(at, "consider has no default case: a status other than %s runs nothing", strings.Join(labels, ", "))
+	})
+}
+
+// ----------------------------------------------------------------------------
+// select-never-pushed
+
+// takeOps are the stack operations that take from a stack or read it,
+// and never add to it
+var takeOps = map[string]bool{
+	"pop": true, "let": true, "peek": true, "take": true, "drop": true, "clear": true, "len": true,
+	"get": true, "del": true, "has": true, "print": true, "println": true, "emit": true, "dot": true,
+	"tor": true, "freeze": true,
+}
+
+// lintSelectNeverPushed reports a select case on a stack the program
+// declares and never adds to: an operation other than those of takeOps,
+// a reference passing it elsewhere, or a spawn pushing into it all count
+// as adding to it, so the case waits on a stack nothing can fill
+func lintSelectNeverPushed(l *linter) {
+	declared := map[string]bool{}
+	pushed := map[string]bool{}
+	ast.Inspect(l.prog.Stmts, func(node any) bool {
+		switch n := node.(type) {
+		case *ast.StackDecl:
+			declared[n.Name] = true
+			if n.Embed != nil {
+				pushed[n.Name] = true
+			}
+		case *ast.StackOp:
+			pushed[n.Stack] = pushed[n.Stack] || !takeOps[n.Op]
+		case *ast.StackExpr:
+			pushed[n.Stack] = pushed[n.Stack] || !takeOps[n.Op]
+		case *ast.StackRef:
+			pushed[n.Name] = true
+		case *ast.SpawnPush:
+			pushed[n.Into] = true
+		}
+		return true
+	}, nil)
+
+	l.inspect(func(node interface{}, at ast.Stmt) {
+		s, ok := node.(*ast.SelectStmt)
+		if !ok {
+			return
+		}
+		for _, c := range s.Cases {
+			name := c.Stack
+			if name == "" {
+				name = s.DefaultStack
+			}
+			if c.Kind == ast.SelectStack && declared[name] && !pushed[name] {
+				l.report(at, "select waits on @%s, which nothing pushes to", name)
+			}
+		}
+	})
+}
+
+// ----------------------------------------------------------------------------
+// shadowed-builtin
+
+// lintShadowedBuiltin reports functions, variables and parameters named
+// after builtin functions, which calls to the builtin then miss or which
+// read as a call to it
+func lintShadowedBuiltin(l *linter) {
+	l.inspect(func(node interface{}, at ast.Stmt) {
+		names := func(kind string, names ...string) {
+			for _, name := range names {
+				if check.BuiltinFuncs[name] {
+					l.report(at, "%s %s shadows the builtin function %s()", kind, name, name)
+				}
+			}
+		}
+		switch n := node.(type) {
+		case *ast.FuncDecl:
+			names("function", n.Name)
+			for _, p := range n.Params {
+				if !p.Stack {
+					names("parameter", p.Name)
+				}
+			}
+		case *ast.VarDecl:
+			names("variable", n.Names...)
+		case *ast.Assignment:
+			names("variable", n.Name)
+		case *ast.LetAssign:
+			names("variable", n.Name)
+		case *ast.RangeStmt:
+			names("variable", n.Var)
+		case *ast.ForStmt:
+			names("variable", n.Params...)
+		case *ast.FnLit:
+			names("parameter", n.Params...)
+		case *ast.SpawnPush:
+			names("parameter", n.Params...)
+		case *ast.ComputeStmt:
+			names("parameter", n.Params...)
+		case *ast.TryStmt:
+			names("variable", n.ErrName)
+		case *ast.ConsiderStmt:
+			for _, c := range n.Cases {
+				names("binding", c.Bindings...)
+			}
+		case *ast.SelectStmt:
+			for _, c := range n.Cases {
+				names("binding", c.Bindings...)
+			}
+		}
+	})
+}
+
+// ----------------------------------------------------------------------------
+// The command
+
+// lintCommand runs ual lint and exits with its status
+func lintCommand(args []string) {
+	os.Exit(lint(args))
+}
+
+// lint returns 0 if no rule found anything, 1 otherwise
+func lint(args []string) int {
+	format := "text"
+	var enable, disable []string
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--format", "--enable", "--disable":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: %s requires an argument\n", arg)
+				return 1
+			}
+			i++
+			switch arg {
+			case "--format":
+				format = args[i]
+			case "--enable":
+				enable = append(enable, splitList(args[i])...)
+			default:
+				disable = append(disable, splitList(args[i])...)
+			}
+		case "--rules":
+			for _, r := range lintRules {
+				fmt.Printf("%-20s %s\n", r.name, r.doc)
+			}
+			return 0
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "error: unknown lint option: %s\n", arg)
+				return 1
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if format != "text" && format != "sarif" {
+		fmt.Fprintf(os.Stderr, "error: --format must be text or sarif, got '%s'\n", format)
+		return 1
+	}
+	m, err := module.ReadManifest(".")
+	if err == nil {
+		disable = append(disable, m.LintDisable...)
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	rules, err := selectRules(enable, disable)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findPrograms(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no .ual programs found")
+		return 1
+	}
+
+	status := 0
+	var findings []lintFinding
+	for _, path := range files {
+		prog, err := parseForLint(path)
+		if err != nil {
+			var diags diagnostics
+			if !errors.As(err, &diags) {
+				diags = diagnostics{err.Error()}
+			}
+			for _, d := range diags {
+				fmt.Fprintf(os.Stderr, "error: %s\n", d)
+			}
+			status = 1
+			continue
+		}
+		findings = append(findings, lintProgram(prog, path, rules)...)
+	}
+	if len(findings) > 0 {
+		status = 1
+	}
+
+	if format == "sarif" {
+		out, _ := json.MarshalIndent(sarifLog(rules, findings), "", "  ")
+		fmt.Println(string(out))
+		return status
+	}
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if verbosity >= verbVerbose {
+		fmt.Fprintf(os.Stderr, "lint: %d programs, %d findings\n", len(files), len(findings))
+	}
+	return status
+}
+
+// selectRules returns the rules enable names, or every rule if it names
+// none, less those disable names
+func selectRules(enable, disable []string) ([]lintRule, error) {
+	known := map[string]bool{}
+	for _, r := range lintRules {
+		known[r.name] = true
+	}
+	for _, name := range append(append([]string{}, enable...), disable...) {
+		if !known[name] {
+			return nil, fmt.Errorf("no lint rule %q (ual lint --rules lists them)", name)
+		}
+	}
+	var rules []lintRule
+	for _, r := range lintRules {
+		if (len(enable) == 0 || contains(enable, r.name)) && !contains(disable, r.name) {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// splitList splits a comma-separated list of names
+func splitList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForLint lexes, parses and resolves the imports of the program at
+// path, as loadProgram does, without the checks and rewrites of a compile
+func parseForLint(path string) (*ast.Program, error) {
+	source, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var diags diagnostics
+	tokens := lexer.NewLexer(source).Tokenize()
+	for _, tok := range tokens {
+		if tok.Type == lexer.TokError {
+			diags = append(diags, fmt.Sprintf("%s:%d:%d: lexer error: %s", path, tok.Line, tok.Column, tok.Value))
+		}
+	}
+	if len(diags) > 0 {
+		return nil, diags
+	}
+	prog, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		return nil, diagnostics(parser.Diagnostics(path, err))
+	}
+	if err := module.Load(prog, path); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// sarifLog returns findings as a SARIF 2.1.0 log
+func sarifLog(rules []lintRule, findings []lintFinding) map[string]any {
+	ruleList := []map[string]any{}
+	for _, r := range rules {
+		ruleList = append(ruleList, map[string]any{
+			"id":               r.name,
+			"shortDescription": map[string]any{"text": r.doc},
+		})
+	}
+	results := []map[string]any{}
+	for _, f := range findings {
+		results = append(results, map[string]any{
+			"ruleId":  f.rule,
+			"level":   "warning",
+			"message": map[string]any{"text": f.msg},
+			"locations": []map[string]any{{
+				"physicalLocation": map[string]any{
+					"artifactLocation": map[string]any{"uri": filepathToURI(f.pos.File)},
+					"region":           map[string]any{"startLine": f.pos.Line, "startColumn": f.pos.Col},
+				},
+			}},
+		})
+	}
+	return map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]any{{
+			"tool": map[string]any{
+				"driver": map[string]any{"name": "ual lint", "version": version.Version, "rules": ruleList},
+			},
+			"results": results,
+		}},
+	}
+}
+
+// filepathToURI returns path as the URI of a SARIF location: relative
+// paths stay relative, to the directory lint ran in
+func filepathToURI(path string) string {
+	u := url.URL{Path: filepath.ToSlash(filepath.Clean(path))}
+	if filepath.IsAbs(path) {
+		u.Scheme = "file"
+	}
+	return u.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestLint(t *testing.T) {
+	src := `@s = stack.new(i64)
+@inbox = stack.new(i64)
+@fed = stack.new(i64)
+@log = stack.new(i64)
+if (1 == 1) {
+    @s push:1
+} else {
+    @s push:2
+}
+if (1 == 2) {
+    @s push:1 push:2
+} else {
+    @s pop
+}
+@log freeze("append-only")
+@log push:1
+@s freeze
+@s push:3
+@s {}.consider(
+    ok: println("ok")
+)
+@s {}.consider(
+    ok: println("ok")
+    _: println("other")
+)
+@fed push:1
+@s {}.select(
+    @inbox {|msg| println(msg) }
+    @fed {|len| println(len) }
+)
+var sqrt i64 = 2
+func f(n i64) {
+    @s push:n
+}
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"prog.ual:10:1: branches change @dstack by different amounts (if +0, else +1) (stack-balance)",
+		"prog.ual:10:1: branches change @s by different amounts (if +2, else -1) (stack-balance)",
+		"prog.ual:18:1: push to @s, which was frozen at line 17 (frozen-push)",
+		"prog.ual:19:1: consider has no default case: a status other than ok runs nothing (consider-default)",
+		"prog.ual:27:1: select waits on @inbox, which nothing pushes to (select-never-pushed)",
+		"prog.ual:27:1: binding len shadows the builtin function len() (shadowed-builtin)",
+		"prog.ual:31:1: variable sqrt shadows the builtin function sqrt() (shadowed-builtin)",
+	}
+	var got []string
+	for _, f := range lintProgram(prog, "prog.ual", lintRules) {
+		got = append(got, f.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	rules, err := selectRules(nil, []string{"stack-balance", "frozen-push", "consider-default", "select-never-pushed"})
+	if err != nil || len(rules) != 1 || rules[0].name != "shadowed-builtin" {
+		t.Errorf("selectRules: %v, %v", rules, err)
+	}
+	if _, err := selectRules([]string{"no-such-rule"}, nil); err == nil {
+		t.Error("selectRules took an unknown rule")
+	}
+}
+
+func TestLintSarif(t *testing.T) {
+	log := sarifLog(lintRules[:1], []lintFinding{{"stack-balance", ast.Pos{File: "dir/prog.ual", Line: 3, Col: 5}, "unbalanced"}})
+	run := log["runs"].([]map[string]any)[0]
+	result := run["results"].([]map[string]any)[0]
+	loc := result["locations"].([]map[string]any)[0]["physicalLocation"].(map[string]any)
+	if log["version"] != "2.1.0" || result["ruleId"] != "stack-balance" ||
+		loc["artifactLocation"].(map[string]any)["uri"] != "dir/prog.ual" ||
+		loc["region"].(map[string]any)["startLine"] != 3 {
+		t.Errorf("SARIF log: %v", log)
+	}
+	if uri := filepathToURI("/tmp/my prog.ual"); uri != "file:///tmp/my%20prog.ual" {
+		t.Errorf("absolute path URI: %s", uri)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/backend"
 	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/module"
+	"github.com/ha1tch/ual/pkg/optimizer"
 	"github.com/ha1tch/ual/pkg/parser"
 	"github.com/ha1tch/ual/pkg/version"
 )
@@ -23,14 +28,26 @@ const (
 
 var noForth bool
 var optimize bool
+var spawnWorkers int // 0: ual.DefaultSpawnWorkers
+var inlineLimit = -1 // --inline: largest function inlined, 0 for none, -1 for the default (see inline.go)
+var crashDumpDir string // --crash-dump: "" for no crash reports
+var checked bool // --checked: panic on stack underflow
+var library bool // --lib: ual compile generates a package, not a program (see library.go)
+var maxErrors = 10 // --max-errors: diagnostics printed per compile, 0 for all
+var warningsAsErrors bool // --warnings-as-errors: fail the compile on warnings
+var testMode bool // ual test: compile test blocks and the library beside _test.ual files
+var benchMode bool // ual bench: the same for bench blocks
+var profileMode bool // ual profile: main profiles the run (see profile.go)
 var outputPath string
-var targetLang = "go"  // "go" or "rust"
+var targetLang = "go"  // the name of a registered backend
 var targetExplicit = false // true if --target was specified
 var verbosity = verbNormal
 
 // Build profile flags
 var buildProfile = "release" // "debug", "release", "small"
+var profileExplicit = false  // true if a profile flag was specified
 var stripBinary = false
+var staticBinary = false // --static: no dynamic dependencies (see static.go)
 
 // checkGoVersion returns true if Go >= 1.22 is available
 func checkGoVersion() bool {
@@ -88,53 +105,43 @@ func checkRustVersion() bool {
 	return major > 1 || (major == 1 && minor >= 75)
 }
 
-// resolveTarget determines which backend to use based on availability
-// Returns the resolved target ("go" or "rust") or exits with error
+// resolveTarget determines which backend to use based on availability.
+// Returns the resolved target or exits with error.
 func resolveTarget() string {
-	goAvailable := checkGoVersion()
-	rustAvailable := checkRustVersion()
-	
-	if verbosity >= verbDebug {
-		fmt.Fprintf(os.Stderr, "Go >= 1.22 available: %v\n", goAvailable)
-		fmt.Fprintf(os.Stderr, "Rust >= 1.75 available: %v\n", rustAvailable)
-	}
-	
 	if targetExplicit {
 		// User specified a target explicitly
-		switch targetLang {
-		case "go":
-			if !goAvailable {
-				fmt.Fprintln(os.Stderr, "error: --target go specified but Go >= 1.22 is not available")
-				fmt.Fprintln(os.Stderr, "hint: install Go from https://go.dev/dl/")
-				os.Exit(1)
-			}
-			return "go"
-		case "rust":
-			if !rustAvailable {
-				fmt.Fprintln(os.Stderr, "error: --target rust specified but Rust >= 1.75 is not available")
-				fmt.Fprintln(os.Stderr, "hint: install Rust from https://rustup.rs/")
-				os.Exit(1)
-			}
-			return "rust"
+		b := targetBackend()
+		if err := b.Available(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --target %s specified but %v\n", targetLang, err)
+			os.Exit(1)
 		}
+		return targetLang
 	}
 	
 	// No explicit target - auto-select
-	if goAvailable {
-		if verbosity >= verbVerbose {
-			fmt.Fprintln(os.Stderr, "using Go backend (auto-selected)")
+	for n, name := range autoTargets {
+		b, ok := backend.Lookup(name)
+		if !ok {
+			continue
+		}
+		err := b.Available()
+		if verbosity >= verbDebug {
+			fmt.Fprintf(os.Stderr, "%s backend available: %v\n", name, err == nil)
+		}
+		if err != nil {
+			continue
 		}
-		return "go"
-	}
-	
-	if rustAvailable {
 		if verbosity >= verbVerbose {
-			fmt.Fprintln(os.Stderr, "using Rust backend (auto-selected, Go not available)")
+			if n == 0 {
+				fmt.Fprintf(os.Stderr, "using %s backend (auto-selected)\n", name)
+			} else {
+				fmt.Fprintf(os.Stderr, "using %s backend (auto-selected, %s not available)\n", name, strings.Join(autoTargets[:n], ", "))
+			}
 		}
-		return "rust"
+		return name
 	}
 	
-	// Neither available
+	// None available
 	fmt.Fprintln(os.Stderr, "error: no suitable backend available")
 	fmt.Fprintln(os.Stderr, "requires one of:")
 	fmt.Fprintln(os.Stderr, "  - Go >= 1.22   (https://go.dev/dl/)")
@@ -150,7 +157,7 @@ func main() {
 	if verbosity >= verbNormal && len(args) >= 1 {
 		cmd := args[0]
 		// Don't show header for version, help, or run commands
-		if cmd != "version" && cmd != "v" && cmd != "help" && cmd != "h" && cmd != "run" && cmd != "r" {
+		if cmd != "version" && cmd != "v" && cmd != "help" && cmd != "h" && cmd != "run" && cmd != "r" && cmd != "highlight" {
 			fmt.Fprintln(os.Stderr, "ual", version.Version)
 		}
 	}
@@ -161,28 +168,70 @@ func main() {
 	}
 	
 	cmd := args[0]
+	if library && cmd != "compile" && cmd != "c" && !strings.HasSuffix(cmd, ".ual") {
+		fmt.Fprintln(os.Stderr, "error: --lib is for ual compile: a library is not a program to build or run")
+		os.Exit(1)
+	}
 	
 	switch cmd {
 	case "compile", "c":
-		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "error: no input file specified")
+		path, ok := resolveInput(args)
+		if !ok {
 			os.Exit(1)
 		}
-		compile(args[1])
+		compile(path)
 		
 	case "build", "b":
-		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "error: no input file specified")
+		path, ok := resolveInput(args)
+		if !ok {
 			os.Exit(1)
 		}
-		build(args[1])
+		build(path)
 		
 	case "run", "r":
-		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "error: no input file specified")
+		path, ok := resolveInput(args)
+		if !ok {
 			os.Exit(1)
 		}
-		run(args[1], args[2:])
+		var progArgs []string
+		if len(args) > 2 {
+			progArgs = args[2:]
+		}
+		run(path, progArgs)
+		
+	case "init":
+		dir := "."
+		if len(args) >= 2 {
+			dir = args[1]
+		}
+		initProject(dir)
+		
+	case "get":
+		getModules(args[1:])
+		
+	case "test":
+		testCommand(args[1:])
+		
+	case "bench":
+		benchCommand(args[1:])
+		
+	case "verify":
+		verifyCommand(args[1:])
+		
+	case "lint":
+		lintCommand(args[1:])
+		
+	case "profile":
+		profileCommand(args[1:])
+		
+	case "cache":
+		cacheCommand(args[1:])
+		
+	case "watch", "w":
+		watchCommand(args[1:])
+		
+	case "dev":
+		devCommand(args[1:])
 		
 	case "tokens", "t":
 		if len(args) < 2 {
@@ -191,6 +240,9 @@ func main() {
 		}
 		showTokens(args[1])
 		
+	case "highlight":
+		highlightCommand(args[1:])
+		
 	case "ast", "a":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "error: no input file specified")
@@ -229,6 +281,67 @@ func parseFlags(args []string) []string {
 			noForth = true
 		case "--optimize", "-O":
 			optimize = true
+		case "--workers":
+			if i+1 < len(args) {
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 1 {
+					fmt.Fprintf(os.Stderr, "error: --workers must be a positive number, got '%s'\n", args[i])
+					os.Exit(1)
+				}
+				spawnWorkers = n
+			} else {
+				fmt.Fprintln(os.Stderr, "error: --workers requires an argument")
+				os.Exit(1)
+			}
+		case "--inline":
+			if i+1 < len(args) {
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 0 {
+					fmt.Fprintf(os.Stderr, "error: --inline must be a number, got '%s'\n", args[i])
+					os.Exit(1)
+				}
+				inlineLimit = n
+			} else {
+				fmt.Fprintln(os.Stderr, "error: --inline requires an argument")
+				os.Exit(1)
+			}
+		case "--warnings-as-errors":
+			warningsAsErrors = true
+		case "--max-errors":
+			if i+1 < len(args) {
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 0 {
+					fmt.Fprintf(os.Stderr, "error: --max-errors must be a number, got '%s'\n", args[i])
+					os.Exit(1)
+				}
+				maxErrors = n
+			} else {
+				fmt.Fprintln(os.Stderr, "error: --max-errors requires an argument")
+				os.Exit(1)
+			}
+		case "--crash-dump":
+			if i+1 < len(args) {
+				i++
+				crashDumpDir = args[i]
+			} else {
+				fmt.Fprintln(os.Stderr, "error: --crash-dump requires a directory")
+				os.Exit(1)
+			}
+		case "--keep-source":
+			if i+1 < len(args) {
+				i++
+				keepSourceDir = args[i]
+			} else {
+				fmt.Fprintln(os.Stderr, "error: --keep-source requires a directory")
+				os.Exit(1)
+			}
+		case "--checked":
+			checked = true
+		case "--lib":
+			library = true
 		case "--quiet", "-q":
 			verbosity = verbQuiet
 		case "--verbose", "-v":
@@ -248,8 +361,8 @@ func parseFlags(args []string) []string {
 				i++
 				targetLang = args[i]
 				targetExplicit = true
-				if targetLang != "go" && targetLang != "rust" {
-					fmt.Fprintf(os.Stderr, "error: --target must be 'go' or 'rust', got '%s'\n", targetLang)
+				if _, ok := backend.Lookup(targetLang); !ok {
+					fmt.Fprintf(os.Stderr, "error: --target must be one of %s, got '%s'\n", strings.Join(backend.Names(), ", "), targetLang)
 					os.Exit(1)
 				}
 			} else {
@@ -258,14 +371,23 @@ func parseFlags(args []string) []string {
 			}
 		case "--release":
 			buildProfile = "release"
+			profileExplicit = true
 		case "--small":
 			buildProfile = "small"
+			profileExplicit = true
 		case "--build-debug":
 			buildProfile = "debug"
+			profileExplicit = true
 		case "--strip":
 			stripBinary = true
+		case "--static":
+			staticBinary = true
 		default:
 			result = append(result, arg)
+			// Everything after `ual run file.ual` belongs to the program
+			if len(result) == 2 && (result[0] == "run" || result[0] == "r" || result[0] == "watch" || result[0] == "w") {
+				return append(result, args[i+1:]...)
+			}
 		}
 		i++
 	}
@@ -278,9 +400,21 @@ func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  ual compile <file.ual>    Compile to Go or Rust source")
 	fmt.Println("  ual build <file.ual>      Compile to executable binary")
-	fmt.Println("  ual run <file.ual>        Compile and run immediately")
+	fmt.Println("  ual run <file.ual> [args] Compile and run immediately")
+	fmt.Println("  ual init [dir]            Create a new project")
+	fmt.Println("  ual build|run [dir]       Build or run the project in dir (ual.toml)")
+	fmt.Println("  ual watch <file.ual> [args] Run, and run again whenever the source changes")
+	fmt.Println("  ual get [path@version]    Add a library to ual.lock, or fetch all locked ones")
+	fmt.Println("  ual test [path...]        Run the tests in _test.ual files (--format text|tap|json)")
+	fmt.Println("  ual bench [path...]       Run the bench blocks in _test.ual files (--benchtime 1s|100x)")
 	fmt.Println("  ual tokens <file.ual>     Show lexer tokens")
 	fmt.Println("  ual ast <file.ual>        Show parse tree")
+	fmt.Println("  ual highlight <file.ual>  Print highlighted source (--format ansi|html)")
+	fmt.Println("  ual verify [path...]      Run programs under iual and the compiled backends, report differences")
+	fmt.Println("  ual lint [path...]        Report likely mistakes the compiler allows (--format text|sarif)")
+	fmt.Println("  ual profile <file.ual> [args] Run with profiling, report the hottest lines (--top 10, --format text|json)")
+	fmt.Println("  ual dev difffuzz          Compare backends on random programs")
+	fmt.Println("  ual cache dir|clean       Show or empty the build cache")
 	fmt.Println("  ual version               Show version")
 	fmt.Println("  ual help                  Show this help")
 	fmt.Println()
@@ -291,6 +425,13 @@ func printUsage() {
 	fmt.Println("  -v, --verbose             Show detailed compilation info")
 	fmt.Println("  -vv, --debug              Show extra debugging info")
 	fmt.Println("  -O, --optimize            Use native int64 dstack")
+	fmt.Println("  --workers <n>             Max concurrent @spawn tasks (Go target, default 64)")
+	fmt.Println("  --inline <n>              Inline functions of up to n nodes (default 12, 0 for none)")
+	fmt.Println("  --crash-dump <dir>        Write a crash report to dir on panic (Go target)")
+	fmt.Println("  --checked                 Panic on stack underflow at its .ual line (Go target)")
+	fmt.Println("  --lib                     Compile to a Go package or Rust library, not a program")
+	fmt.Println("  --max-errors <n>          Report at most n errors, 0 for all (default 10)")
+	fmt.Println("  --warnings-as-errors      Fail on unused and shadowed variable warnings")
 	fmt.Println("  --version                 Show version and exit")
 	fmt.Println("  --no-forth                Disable default stacks")
 	fmt.Println()
@@ -299,19 +440,25 @@ func printUsage() {
 	fmt.Println("  --small                   Size-optimised (smallest binary)")
 	fmt.Println("  --build-debug             Debug build with symbols")
 	fmt.Println("  --strip                   Strip symbols from binary")
+	fmt.Println("  --static                  Static binary with no dynamic dependencies (Linux)")
+	fmt.Println("  --keep-source <dir>       Write a Go project with the runtime vendored to dir, build it there")
 	fmt.Println()
-	fmt.Println("Short forms: c, b, r, t, a")
+	fmt.Println("Short forms: c, b, r, w, t, a")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  ual compile program.ual              # Creates program.go")
 	fmt.Println("  ual compile --target rust program.ual # Creates program.rs")
+	fmt.Println("  ual compile --lib mathx.ual          # Creates mathx.go, package mathx")
 	fmt.Println("  ual build program.ual                # Creates program binary")
 	fmt.Println("  ual build -o myapp program.ual       # Creates myapp binary")
 	fmt.Println("  ual build --small program.ual        # Smallest binary")
 	fmt.Println("  ual build --strip program.ual        # Stripped binary")
+	fmt.Println("  ual build --static program.ual       # Static binary, for scratch containers")
 	fmt.Println("  ual build --small --target rust prog.ual  # Small Rust binary")
 	fmt.Println("  ual run program.ual                  # Compiles and runs")
 	fmt.Println("  ual -q run program.ual               # Run quietly")
+	fmt.Println("  ual init myproj                      # Creates myproj/ with ual.toml")
+	fmt.Println("  ual get github.com/user/lib@v1.2.0   # Fetches lib, records it in ual.lock")
 }
 
 func readFile(path string) (string, error) {
@@ -322,10 +469,37 @@ func readFile(path string) (string, error) {
 	return string(data), nil
 }
 
-func generateGo(path string) (string, error) {
+// diagnostics is every error found compiling a program, each formatted
+// as "file:line:col: message"
+type diagnostics []string
+
+func (d diagnostics) Error() string {
+	return strings.Join(d, "\n")
+}
+
+// fail prints err and exits. The diagnostics of a compile are printed one
+// per line, at most maxErrors of them.
+func fail(err error) {
+	var diags diagnostics
+	if !errors.As(err, &diags) {
+		diags = diagnostics{err.Error()}
+	}
+	for i, d := range diags {
+		if maxErrors > 0 && i == maxErrors {
+			fmt.Fprintf(os.Stderr, "error: too many errors (%d more)\n", len(diags)-i)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "error: %s\n", d)
+	}
+	os.Exit(1)
+}
+
+// loadProgram lexes, parses and resolves the imports of the program at
+// path and runs the AST optimizer over it
+func loadProgram(path string) (*ast.Program, error) {
 	source, err := readFile(path)
 	if err != nil {
-		return "", fmt.Errorf("reading file: %v", err)
+		return nil, fmt.Errorf("reading file: %v", err)
 	}
 	
 	// Lex
@@ -333,65 +507,135 @@ func generateGo(path string) (string, error) {
 	tokens := lex.Tokenize()
 	
 	// Check for lex errors
+	var diags diagnostics
 	for _, tok := range tokens {
 		if tok.Type == lexer.TokError {
-			return "", fmt.Errorf("lexer error at line %d: %s", tok.Line, tok.Value)
+			diags = append(diags, fmt.Sprintf("%s:%d:%d: lexer error: %s", path, tok.Line, tok.Column, tok.Value))
 		}
 	}
+	if len(diags) > 0 {
+		return nil, diags
+	}
 	
 	// Parse
 	prs := parser.NewParser(tokens)
 	prog, err := prs.Parse()
 	if err != nil {
-		return "", fmt.Errorf("parse error: %v", err)
+		return nil, diagnostics(parser.Diagnostics(path, err))
 	}
-	
+	load := module.Load
+	if (testMode || benchMode) && strings.HasSuffix(path, "_test.ual") {
+		load = module.LoadTest
+	}
+	if err := load(prog, path); err != nil {
+		return nil, err
+	}
+	if err := instantiateGenerics(prog, path); err != nil {
+		return nil, err
+	}
+	if err := checkStackEffects(prog, path); err != nil {
+		return nil, err
+	}
+	warnings := append(unusedWarnings(prog, path), scopeWarnings(prog, path)...)
+	if len(warnings) > 0 {
+		if warningsAsErrors {
+			return nil, diagnostics(warnings)
+		}
+		if verbosity > verbQuiet {
+			for _, w := range warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+			}
+		}
+	}
+	optimizer.Optimize(prog)
+	limit := inlineLimit
+	if limit < 0 {
+		limit = defaultInlineLimit
+	}
+	inlineCalls(prog, limit)
+	return prog, nil
+}
+
+// generateGoProgram returns the Go code for prog, loaded from path, and
+// the map of its lines back to the source
+func generateGoProgram(prog *ast.Program, path string) (string, *sourceMap, error) {
 	// Generate
 	codegen := NewCodeGenOptimized(noForth, optimize)
+	codegen.workers = spawnWorkers
+	codegen.srcFile = path
+	codegen.tests = testMode
+	codegen.bench = benchMode
+	codegen.profile = profileMode
+	if library {
+		if crashDumpDir != "" {
+			return "", nil, fmt.Errorf("--crash-dump is for programs, not libraries")
+		}
+		codegen.library = libraryName(path)
+	}
+	if crashDumpDir != "" || checked {
+		codegen.crashDump = crashDumpDir
+		codegen.checked = checked
+		codegen.srcFile, _ = filepath.Abs(path)
+	}
 	goCode := codegen.Generate(prog)
 	
 	// Check for type errors
 	if codegen.hasErrors() {
-		return "", fmt.Errorf("%s", codegen.getErrors()[0])
+		return "", nil, diagnostics(codegen.getErrors())
 	}
 	
-	return goCode, nil
+	return goCode, codegen.sourceMap, nil
 }
 
-func generateRust(path string) (string, error) {
-	source, err := readFile(path)
-	if err != nil {
-		return "", fmt.Errorf("reading file: %v", err)
+// generateRustProgram is generateGoProgram for Rust
+func generateRustProgram(prog *ast.Program, path string) (string, *sourceMap, error) {
+	if crashDumpDir != "" {
+		return "", nil, fmt.Errorf("--crash-dump is not supported by the Rust backend yet")
 	}
-	
-	// Lex
-	lex := lexer.NewLexer(source)
-	tokens := lex.Tokenize()
-	
-	// Check for lex errors
-	for _, tok := range tokens {
-		if tok.Type == lexer.TokError {
-			return "", fmt.Errorf("lexer error at line %d: %s", tok.Line, tok.Value)
-		}
+	if checked {
+		return "", nil, fmt.Errorf("--checked is not supported by the Rust backend yet")
 	}
-	
-	// Parse
-	prs := parser.NewParser(tokens)
-	prog, err := prs.Parse()
-	if err != nil {
-		return "", fmt.Errorf("parse error: %v", err)
+	if testMode {
+		return "", nil, fmt.Errorf("ual test is not supported by the Rust backend yet")
 	}
 	
 	// Generate Rust
 	codegen := NewRustCodeGen()
+	codegen.srcFile = path
+	codegen.bench = benchMode
+	codegen.library = library
 	rustCode := codegen.Generate(prog)
 	
 	// Check for errors
 	if codegen.hasErrors() {
-		return "", fmt.Errorf("%s", codegen.getErrors()[0])
+		return "", nil, diagnostics(codegen.getErrors())
 	}
 	
-	return rustCode, nil
+	return rustCode, codegen.sourceMap, nil
+}
+
+// targetBackend returns the backend targetLang names
+func targetBackend() backend.Backend {
+	b, ok := backend.Lookup(targetLang)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown target language: %s\n", targetLang)
+		os.Exit(1)
+	}
+	return b
+}
+
+// generate loads the program at path and generates it with b
+func generate(b backend.Backend, path string) (*backend.Output, *backend.Options) {
+	prog, err := loadProgram(path)
+	if err != nil {
+		fail(err)
+	}
+	opts := backendOptions(path)
+	out, err := b.Generate(prog, opts)
+	if err != nil {
+		fail(err)
+	}
+	return out, opts
 }
 
 func compile(path string) {
@@ -399,36 +643,30 @@ func compile(path string) {
 		fmt.Fprintf(os.Stderr, "compiling %s to %s...\n", path, targetLang)
 	}
 	
-	var code string
-	var err error
-	var ext string
-	
-	switch targetLang {
-	case "go":
-		code, err = generateGo(path)
-		ext = ".go"
-	case "rust":
-		code, err = generateRust(path)
-		ext = ".rs"
-	}
-	
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	b := targetBackend()
+	out, _ := generate(b, path)
 	
 	// Determine output path
 	outPath := outputPath
 	if outPath == "" {
-		outPath = strings.TrimSuffix(path, ".ual") + ext
+		outPath = strings.TrimSuffix(path, ".ual") + b.Ext()
 	}
 	
-	err = os.WriteFile(outPath, []byte(code), 0644)
+	err := os.WriteFile(outPath, []byte(out.Code), 0644)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error writing output: %v\n", err)
 		os.Exit(1)
 	}
 	
+	// Source map alongside, for tools that report positions in the code
+	if out.Map != nil {
+		err = os.WriteFile(outPath+".map", out.Map.JSON(filepath.Base(outPath)), 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing source map: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	
 	if verbosity >= verbNormal {
 		fmt.Fprintf(os.Stderr, "compiled %s -> %s\n", path, outPath)
 	}
@@ -442,74 +680,8 @@ func build(path string) {
 		fmt.Fprintf(os.Stderr, "compiling %s to %s (%s)...\n", path, targetLang, buildProfile)
 	}
 	
-	switch targetLang {
-	case "go":
-		buildGo(path)
-	case "rust":
-		buildRust(path)
-	default:
-		fmt.Fprintf(os.Stderr, "error: unknown target language: %s\n", targetLang)
-		os.Exit(1)
-	}
-}
-
-func buildGo(path string) {
-	goCode, err := generateGo(path)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Find the ual runtime directory
-	ualDir := findUalRuntime()
-	
-	// Create temp directory for Go source
-	tmpDir, err := os.MkdirTemp("", "ual-build")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating temp dir: %v\n", err)
-		os.Exit(1)
-	}
-	defer os.RemoveAll(tmpDir)
-	
-	if verbosity >= verbDebug {
-		fmt.Fprintf(os.Stderr, "temp dir: %s\n", tmpDir)
-	}
-	
-	// Write Go source
-	goFile := filepath.Join(tmpDir, "main.go")
-	err = os.WriteFile(goFile, []byte(goCode), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing temp file: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Create go.mod
-	var goMod string
-	if ualDir != "" {
-		if verbosity >= verbDebug {
-			fmt.Fprintf(os.Stderr, "using local runtime: %s\n", ualDir)
-		}
-		goMod = fmt.Sprintf(`module ual_program
-
-go 1.22
-
-require github.com/ha1tch/ual v%s
-
-replace github.com/ha1tch/ual => %s
-`, version.Version, ualDir)
-	} else {
-		goMod = fmt.Sprintf(`module ual_program
-
-go 1.22
-
-require github.com/ha1tch/ual v%s
-`, version.Version)
-	}
-	err = os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte(goMod), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing go.mod: %v\n", err)
-		os.Exit(1)
-	}
+	b := targetBackend()
+	out, opts := generate(b, path)
 	
 	// Determine output binary name
 	binaryPath := outputPath
@@ -523,215 +695,26 @@ require github.com/ha1tch/ual v%s
 		binaryPath = filepath.Join(cwd, binaryPath)
 	}
 	
-	// Run go mod tidy to resolve dependencies
-	tidyCmd := exec.Command("go", "mod", "tidy")
-	tidyCmd.Dir = tmpDir
-	if verbosity >= verbDebug {
-		tidyCmd.Stdout = os.Stdout
-		tidyCmd.Stderr = os.Stderr
-	}
-	tidyCmd.Run() // ignore errors, build will catch them
-	
-	// Build ldflags based on profile
-	var ldflags string
-	switch buildProfile {
-	case "small":
-		ldflags = "-s -w"
-	case "release":
-		if stripBinary {
-			ldflags = "-s -w"
-		}
-	case "debug":
-		// No ldflags for debug
-	}
-	
-	// Run go build
 	if verbosity >= verbVerbose {
 		fmt.Fprintf(os.Stderr, "building %s...\n", binaryPath)
 	}
-	
-	var cmd *exec.Cmd
-	if ldflags != "" {
-		cmd = exec.Command("go", "build", "-ldflags", ldflags, "-o", binaryPath, ".")
-	} else {
-		cmd = exec.Command("go", "build", "-o", binaryPath, ".")
-	}
-	cmd.Dir = tmpDir
-	if verbosity >= verbDebug {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	}
-	
-	err = cmd.Run()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: go build failed: %v\n", err)
-		os.Exit(1)
-	}
-	
-	if verbosity >= verbNormal {
-		fmt.Fprintf(os.Stderr, "built %s -> %s\n", path, binaryPath)
-	}
-}
-
-func buildRust(path string) {
-	rustCode, err := generateRust(path)
-	if err != nil {
+	// An output in ual.toml may name a directory not made yet
+	if err := os.MkdirAll(filepath.Dir(binaryPath), 0755); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	
-	// Find the rual runtime directory
-	rualDir := findRualRuntime()
-	if rualDir == "" {
-		fmt.Fprintf(os.Stderr, "error: cannot find rual runtime library\n")
-		fmt.Fprintf(os.Stderr, "hint: make sure the 'rual' directory exists alongside the ual compiler\n")
-		os.Exit(1)
-	}
-	
-	// Create temp directory for Rust project
-	tmpDir, err := os.MkdirTemp("", "ual-build-rust")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating temp dir: %v\n", err)
-		os.Exit(1)
-	}
-	defer os.RemoveAll(tmpDir)
-	
-	if verbosity >= verbDebug {
-		fmt.Fprintf(os.Stderr, "temp dir: %s\n", tmpDir)
-		fmt.Fprintf(os.Stderr, "rual dir: %s\n", rualDir)
-	}
-	
-	// Create src directory
-	srcDir := filepath.Join(tmpDir, "src")
-	err = os.MkdirAll(srcDir, 0755)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating src dir: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Write Rust source
-	rsFile := filepath.Join(srcDir, "main.rs")
-	err = os.WriteFile(rsFile, []byte(rustCode), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing temp file: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Generate Cargo.toml with appropriate profile
-	cargoToml := generateCargoToml(rualDir)
-	err = os.WriteFile(filepath.Join(tmpDir, "Cargo.toml"), []byte(cargoToml), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing Cargo.toml: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Determine output binary name
-	binaryPath := outputPath
-	if binaryPath == "" {
-		binaryPath = strings.TrimSuffix(filepath.Base(path), ".ual")
-	}
-	
-	// Make absolute
-	if !filepath.IsAbs(binaryPath) {
-		cwd, _ := os.Getwd()
-		binaryPath = filepath.Join(cwd, binaryPath)
-	}
-	
-	// Run cargo build
-	if verbosity >= verbVerbose {
-		fmt.Fprintf(os.Stderr, "building %s...\n", binaryPath)
-	}
-	
-	var cmd *exec.Cmd
-	if buildProfile == "debug" {
-		cmd = exec.Command("cargo", "build")
-	} else {
-		cmd = exec.Command("cargo", "build", "--release")
-	}
-	cmd.Dir = tmpDir
-	if verbosity >= verbDebug {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		// Capture stderr to suppress cargo output unless error
-		cmd.Stderr = nil
-	}
-	
-	err = cmd.Run()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: cargo build failed: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Copy binary to output path
-	var builtBinary string
-	if buildProfile == "debug" {
-		builtBinary = filepath.Join(tmpDir, "target", "debug", "ual_program")
-	} else {
-		builtBinary = filepath.Join(tmpDir, "target", "release", "ual_program")
-	}
-	
-	// Read and write binary (to handle cross-filesystem copy)
-	binaryData, err := os.ReadFile(builtBinary)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading built binary: %v\n", err)
-		os.Exit(1)
-	}
-	
-	err = os.WriteFile(binaryPath, binaryData, 0755)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing output binary: %v\n", err)
+	if err := b.Build(out, opts, binaryPath); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 	
-	// Strip if requested (and not already done by Cargo profile)
-	if stripBinary && buildProfile != "small" {
-		stripCmd := exec.Command("strip", binaryPath)
-		stripCmd.Run() // ignore errors, strip might not be available
-	}
-	
 	if verbosity >= verbNormal {
-		fmt.Fprintf(os.Stderr, "built %s -> %s\n", path, binaryPath)
-	}
-}
-
-func generateCargoToml(rualDir string) string {
-	var profile string
-	
-	switch buildProfile {
-	case "debug":
-		profile = `[profile.dev]
-opt-level = 0
-debug = true`
-	case "release":
-		if stripBinary {
-			profile = `[profile.release]
-opt-level = 3
-strip = true`
+		if keepSourceDir != "" {
+			fmt.Fprintf(os.Stderr, "built %s -> %s (source in %s)\n", path, binaryPath, keepSourceDir)
 		} else {
-			profile = `[profile.release]
-opt-level = 3`
+			fmt.Fprintf(os.Stderr, "built %s -> %s\n", path, binaryPath)
 		}
-	case "small":
-		profile = `[profile.release]
-opt-level = "z"
-lto = true
-codegen-units = 1
-panic = "abort"
-strip = true`
 	}
-	
-	return fmt.Sprintf(`[package]
-name = "ual_program"
-version = "0.1.0"
-edition = "2021"
-
-[dependencies]
-rual = { path = "%s" }
-lazy_static = "1.4"
-
-%s
-`, rualDir, profile)
 }
 
 // findRualRuntime locates the rual Rust runtime library directory
@@ -778,148 +761,36 @@ func run(path string, args []string) {
 		fmt.Fprintf(os.Stderr, "compiling %s to %s...\n", path, targetLang)
 	}
 	
-	switch targetLang {
-	case "go":
-		runGo(path, args)
-	case "rust":
-		runRust(path, args)
-	default:
-		fmt.Fprintf(os.Stderr, "error: unknown target language: %s\n", targetLang)
-		os.Exit(1)
-	}
-}
-
-func runGo(path string, args []string) {
-	goCode, err := generateGo(path)
+	b := targetBackend()
+	out, opts := generate(b, path)
+	status, err := b.Run(out, opts, args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	
-	// Find the ual runtime directory
-	ualDir := findUalRuntime()
-	
-	// Create temp directory
-	tmpDir, err := os.MkdirTemp("", "ual-run")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating temp dir: %v\n", err)
-		os.Exit(1)
-	}
-	defer os.RemoveAll(tmpDir)
-	
-	if verbosity >= verbDebug {
-		fmt.Fprintf(os.Stderr, "temp dir: %s\n", tmpDir)
-	}
-	
-	// Write Go source
-	goFile := filepath.Join(tmpDir, "main.go")
-	err = os.WriteFile(goFile, []byte(goCode), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing temp file: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Create go.mod with replace directive for local development
-	var goMod string
-	if ualDir != "" {
-		if verbosity >= verbDebug {
-			fmt.Fprintf(os.Stderr, "using local runtime: %s\n", ualDir)
-		}
-		goMod = fmt.Sprintf(`module ual_program
-
-go 1.22
-
-require github.com/ha1tch/ual v%s
-
-replace github.com/ha1tch/ual => %s
-`, version.Version, ualDir)
-	} else {
-		goMod = fmt.Sprintf(`module ual_program
-
-go 1.22
-
-require github.com/ha1tch/ual v%s
-`, version.Version)
-	}
-	err = os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte(goMod), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing go.mod: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Run go mod tidy to resolve dependencies
-	tidyCmd := exec.Command("go", "mod", "tidy")
-	tidyCmd.Dir = tmpDir
-	if verbosity >= verbDebug {
-		tidyCmd.Stdout = os.Stdout
-		tidyCmd.Stderr = os.Stderr
-	}
-	tidyCmd.Run() // ignore errors, run will catch them
-	
-	// Run go run
-	if verbosity >= verbVerbose {
-		fmt.Fprintf(os.Stderr, "running %s...\n", path)
-	}
-	
-	cmdArgs := append([]string{"run", "."}, args...)
-	cmd := exec.Command("go", cmdArgs...)
-	cmd.Dir = tmpDir
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	err = cmd.Run()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
-		}
-		fmt.Fprintf(os.Stderr, "error: go run failed: %v\n", err)
-		os.Exit(1)
+	if status != 0 {
+		os.Exit(status)
 	}
 }
 
-func runRust(path string, args []string) {
-	rustCode, err := generateRust(path)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Find the rual runtime directory
-	rualDir := findRualRuntime()
-	if rualDir == "" {
-		fmt.Fprintf(os.Stderr, "error: cannot find rual runtime library\n")
-		fmt.Fprintf(os.Stderr, "hint: make sure the 'rual' directory exists alongside the ual compiler\n")
-		os.Exit(1)
-	}
-	
-	// Create temp directory for Rust project
-	tmpDir, err := os.MkdirTemp("", "ual-run-rust")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating temp dir: %v\n", err)
-		os.Exit(1)
-	}
-	defer os.RemoveAll(tmpDir)
-	
-	if verbosity >= verbDebug {
-		fmt.Fprintf(os.Stderr, "temp dir: %s\n", tmpDir)
-		fmt.Fprintf(os.Stderr, "rual dir: %s\n", rualDir)
-	}
-	
+// writeRustProject writes rustCode and its Cargo.toml to tmpDir, with the
+// rual runtime at rualDir built with features
+func writeRustProject(tmpDir, rustCode, rualDir string, features ...string) error {
 	// Create src directory
 	srcDir := filepath.Join(tmpDir, "src")
-	err = os.MkdirAll(srcDir, 0755)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating src dir: %v\n", err)
-		os.Exit(1)
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		return fmt.Errorf("creating src dir: %v", err)
 	}
 	
 	// Write Rust source
 	rsFile := filepath.Join(srcDir, "main.rs")
-	err = os.WriteFile(rsFile, []byte(rustCode), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing temp file: %v\n", err)
-		os.Exit(1)
+	if err := os.WriteFile(rsFile, []byte(rustCode), 0644); err != nil {
+		return fmt.Errorf("writing temp file: %v", err)
+	}
+	
+	rual := fmt.Sprintf("{ path = %q }", rualDir)
+	if len(features) > 0 {
+		rual = fmt.Sprintf("{ path = %q, features = [\"%s\"] }", rualDir, strings.Join(features, `", "`))
 	}
 	
 	// Generate Cargo.toml (release profile for faster execution)
@@ -929,38 +800,16 @@ version = "0.1.0"
 edition = "2021"
 
 [dependencies]
-rual = { path = "%s" }
+rual = %s
 lazy_static = "1.4"
 
 [profile.release]
 opt-level = 2
-`, rualDir)
-	err = os.WriteFile(filepath.Join(tmpDir, "Cargo.toml"), []byte(cargoToml), 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing Cargo.toml: %v\n", err)
-		os.Exit(1)
-	}
-	
-	// Run cargo run
-	if verbosity >= verbVerbose {
-		fmt.Fprintf(os.Stderr, "running %s...\n", path)
-	}
-	
-	cmdArgs := append([]string{"run", "--release", "-q", "--"}, args...)
-	cmd := exec.Command("cargo", cmdArgs...)
-	cmd.Dir = tmpDir
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	err = cmd.Run()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
-		}
-		fmt.Fprintf(os.Stderr, "error: cargo run failed: %v\n", err)
-		os.Exit(1)
+`, rual)
+	if err := os.WriteFile(filepath.Join(tmpDir, "Cargo.toml"), []byte(cargoToml), 0644); err != nil {
+		return fmt.Errorf("writing Cargo.toml: %v", err)
 	}
+	return nil
 }
 
 // findUalRuntime locates the ual runtime library directory
@@ -1011,6 +860,124 @@ func findUalRuntime() string {
 	return ""
 }
 
+// initProject creates a project skeleton in dir: a ual.toml manifest, a
+// main.ual entry point and a tests directory. Existing files are never
+// overwritten.
+func initProject(dir string) {
+	name, err := writeProject(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if verbosity >= verbNormal {
+		fmt.Fprintf(os.Stderr, "created project %s in %s\n", name, dir)
+		fmt.Fprintf(os.Stderr, "  ual run %s\n", filepath.Join(dir, "main.ual"))
+	}
+}
+
+// writeProject writes a new project to dir, named after it, and returns
+// the name. It writes nothing if any of the files is already there.
+func writeProject(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(abs)
+	
+	files := []struct {
+		path    string
+		content string
+	}{
+		{"ual.toml", fmt.Sprintf(projectManifest, name, targetLang, buildProfile)},
+		{"main.ual", fmt.Sprintf(projectMain, name)},
+		{".gitignore", fmt.Sprintf("/%s\n", name)},
+		{filepath.Join("tests", ".gitkeep"), ""},
+	}
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(dir, f.path)); err == nil {
+			return "", fmt.Errorf("%s already exists", filepath.Join(dir, f.path))
+		}
+	}
+	
+	if err := os.MkdirAll(filepath.Join(dir, "tests"), 0755); err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.path), []byte(f.content), 0644); err != nil {
+			return "", fmt.Errorf("writing %s: %v", f.path, err)
+		}
+	}
+	return name, nil
+}
+
+// getModules implements ual get. With module specs it fetches each one
+// into the cache and records it in the project's ual.lock; with none it
+// fetches and verifies everything ual.lock already lists.
+func getModules(specs []string) {
+	root, ok := module.FindRoot(".")
+	if !ok {
+		root = "."
+	}
+	
+	if len(specs) == 0 {
+		// The modules ual.toml asks for that ual.lock does not have yet
+		m, err := module.ReadManifest(root)
+		if err == nil {
+			var changed []module.Module
+			changed, err = module.GetManifest(m)
+			if verbosity >= verbNormal {
+				for _, m := range changed {
+					fmt.Fprintf(os.Stderr, "added %s %s\n", m.Path, m.Version)
+				}
+			}
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err == nil {
+			err = module.Download(root)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	for _, spec := range specs {
+		changed, err := module.Get(root, spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if verbosity >= verbNormal {
+			for _, m := range changed {
+				fmt.Fprintf(os.Stderr, "added %s %s\n", m.Path, m.Version)
+			}
+		}
+	}
+}
+
+// projectManifest is the ual.toml written by ual init (name, target, profile)
+const projectManifest = `# ual project manifest
+[project]
+name = "%s"
+version = "0.1.0"
+main = "main.ual"
+
+[build]
+# go or rust
+target = "%s"
+# release, small or debug
+profile = "%s"
+`
+
+// projectMain is the main.ual written by ual init (name)
+const projectMain = `-- %s: entry point
+
+import "std/strings"
+
+println(to_upper("hello, world"))
+`
+
 func showTokens(path string) {
 	source, err := readFile(path)
 	if err != nil {
@@ -1039,97 +1006,9 @@ func showAST(path string) {
 	prs := parser.NewParser(tokens)
 	prog, err := prs.Parse()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
-		os.Exit(1)
+		fail(diagnostics(parser.Diagnostics(path, err)))
 	}
 	
-	printAST(prog, 0)
+	ast.Fprint(os.Stdout, prog)
 }
 
-func printAST(node interface{}, indent int) {
-	prefix := strings.Repeat("  ", indent)
-	
-	switch n := node.(type) {
-	case *ast.Program:
-		fmt.Printf("%sProgram\n", prefix)
-		for _, stmt := range n.Stmts {
-			printAST(stmt, indent+1)
-		}
-		
-	case *ast.StackDecl:
-		fmt.Printf("%sStackDecl: @%s : %s (%s, cap=%d)\n", 
-			prefix, n.Name, n.ElementType, n.Perspective, n.Capacity)
-		
-	case *ast.ViewDecl:
-		fmt.Printf("%sViewDecl: %s : %s\n", prefix, n.Name, n.Perspective)
-		
-	case *ast.Assignment:
-		fmt.Printf("%sAssignment: %s =\n", prefix, n.Name)
-		printAST(n.Expr, indent+1)
-		
-	case *ast.StackOp:
-		fmt.Printf("%sStackOp: @%s.%s\n", prefix, n.Stack, n.Op)
-		for _, arg := range n.Args {
-			printAST(arg, indent+1)
-		}
-		
-	case *ast.StackBlock:
-		fmt.Printf("%sStackBlock: @%s\n", prefix, n.Stack)
-		for _, op := range n.Ops {
-			printAST(op, indent+1)
-		}
-		
-	case *ast.ViewOp:
-		fmt.Printf("%sViewOp: %s.%s\n", prefix, n.View, n.Op)
-		for _, arg := range n.Args {
-			printAST(arg, indent+1)
-		}
-		
-	case *ast.IntLit:
-		fmt.Printf("%sIntLit: %d\n", prefix, n.Value)
-		
-	case *ast.FloatLit:
-		fmt.Printf("%sFloatLit: %f\n", prefix, n.Value)
-		
-	case *ast.StringLit:
-		fmt.Printf("%sStringLit: %q\n", prefix, n.Value)
-		
-	case *ast.StackRef:
-		fmt.Printf("%sStackRef: @%s\n", prefix, n.Name)
-		
-	case *ast.Ident:
-		fmt.Printf("%sIdent: %s\n", prefix, n.Name)
-		
-	case *ast.PerspectiveLit:
-		fmt.Printf("%sPerspective: %s\n", prefix, n.Value)
-		
-	case *ast.TypeLit:
-		fmt.Printf("%sType: %s\n", prefix, n.Value)
-		
-	case *ast.BinaryOp:
-		fmt.Printf("%sBinaryOp: %s\n", prefix, n.Op)
-		printAST(n.Left, indent+1)
-		printAST(n.Right, indent+1)
-		
-	case *ast.StackExpr:
-		fmt.Printf("%sStackExpr: @%s.%s\n", prefix, n.Stack, n.Op)
-		for _, arg := range n.Args {
-			printAST(arg, indent+1)
-		}
-		
-	case *ast.ViewExpr:
-		fmt.Printf("%sViewExpr: %s.%s\n", prefix, n.View, n.Op)
-		for _, arg := range n.Args {
-			printAST(arg, indent+1)
-		}
-		
-	case *ast.FnLit:
-		fmt.Printf("%sFnLit: (%s)\n", prefix, strings.Join(n.Params, ", "))
-		for _, stmt := range n.Body {
-			printAST(stmt, indent+1)
-		}
-		
-	default:
-		fmt.Printf("%s<%T>\n", prefix, node)
-	}
-}
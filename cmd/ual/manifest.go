@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/backend"
+	"github.com/ha1tch/ual/pkg/module"
+)
+
+// manifestName is the project manifest written by ual init
+const manifestName = module.ManifestName
+
+// loadManifest reads the ual.toml in dir. Command-line flags override its
+// [build] settings; see module.Manifest for the format.
+func loadManifest(dir string) (*module.Manifest, error) {
+	m, err := module.ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Backends register with this binary, so only it can check the target
+	if _, ok := backend.Lookup(m.Target); m.Target != "" && !ok {
+		return nil, fmt.Errorf("%s: target must be one of %s, got %q", filepath.Join(dir, manifestName), strings.Join(backend.Names(), ", "), m.Target)
+	}
+	return m, nil
+}
+
+// applyManifest makes m's settings, with those of the profile in use over
+// them, the defaults for this invocation and returns the path of the entry
+// point. Flags given on the command line win.
+// Only builds take the output path, since compile writes source instead.
+func applyManifest(m *module.Manifest, building bool) string {
+	if m.Target != "" && !targetExplicit {
+		targetLang = m.Target
+		targetExplicit = true
+	}
+	if m.Profile != "" && !profileExplicit {
+		buildProfile = m.Profile
+	}
+	m = m.WithProfile(buildProfile)
+	if building && outputPath == "" {
+		if m.Output != "" {
+			outputPath = filepath.Join(m.Dir, m.Output)
+		} else {
+			outputPath = filepath.Join(m.Dir, m.Name)
+		}
+	}
+	if spawnWorkers == 0 {
+		spawnWorkers = m.Workers
+	}
+	if inlineLimit < 0 {
+		inlineLimit = m.Inline
+	}
+	optimize = optimize || m.Optimize
+	stripBinary = stripBinary || m.Strip
+	staticBinary = staticBinary || m.Static
+	noForth = noForth || m.NoForth
+	return filepath.Join(m.Dir, m.Main)
+}
+
+// resolveInput returns the .ual file the command in args[0] works on. A
+// directory, or no argument at all, names a project: its ual.toml supplies
+// the entry point and build defaults.
+func resolveInput(args []string) (string, bool) {
+	dir := "."
+	if len(args) >= 2 {
+		info, err := os.Stat(args[1])
+		if err != nil || !info.IsDir() {
+			return args[1], true
+		}
+		dir = args[1]
+	}
+
+	m, err := loadManifest(dir)
+	if os.IsNotExist(err) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "error: no input file specified (and no ual.toml in the current directory)")
+		} else {
+			fmt.Fprintf(os.Stderr, "error: no %s in %s\n", manifestName, dir)
+		}
+		return "", false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return "", false
+	}
+	if verbosity >= verbVerbose {
+		fmt.Fprintf(os.Stderr, "using %s\n", filepath.Join(dir, manifestName))
+	}
+	building := args[0] == "build" || args[0] == "b"
+	return applyManifest(m, building), true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(src string) {
+		if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[build]\ntarget = \"rust\"\n")
+	m, err := loadManifest(dir)
+	if err != nil || m.Target != "rust" || m.Dir != dir || m.Name != filepath.Base(dir) {
+		t.Errorf("got %+v, %v", m, err)
+	}
+
+	write("[build]\ntarget = \"java\"\n")
+	if _, err := loadManifest(dir); err == nil || !strings.Contains(err.Error(), "target must be") {
+		t.Errorf("expected an unknown target to be an error, got %v", err)
+	}
+}
+
+func TestApplyManifestProfile(t *testing.T) {
+	defer func(p string, o string, s bool) { buildProfile, outputPath, stripBinary = p, o, s }(buildProfile, outputPath, stripBinary)
+
+	dir := t.TempDir()
+	src := "[build]\nprofile = \"debug\"\noutput = \"bin/app\"\n\n[profile.debug]\noutput = \"bin/app-debug\"\nstrip = true\n"
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildProfile, outputPath, stripBinary = "release", "", false
+	if main := applyManifest(m, true); main != filepath.Join(dir, "main.ual") {
+		t.Errorf("entry point %s", main)
+	}
+	if buildProfile != "debug" || outputPath != filepath.Join(dir, "bin/app-debug") || !stripBinary {
+		t.Errorf("profile %s, output %s, strip %v", buildProfile, outputPath, stripBinary)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Statements a backend's generateStmt need not handle, and why. A kind
+// listed here that the backend does handle is reported, so the list
+// cannot outlive the gap it records.
+var stmtExempt = map[string]map[string]string{
+	"CodeGen": {
+		"ArrayDecl":         "compute kernels only",
+		"AssignStmt":        "compute kernels only",
+		"IndexedAssignStmt": "compute kernels only",
+	},
+	"RustCodeGen": {
+		"ArrayDecl":         "compute kernels only",
+		"IndexedAssignStmt": "compute kernels only",
+		"FuncDecl":          "hoisted to the top level by Generate",
+	},
+}
+
+// computeStmts are the statements the parser builds in compute kernels
+var computeStmts = []string{
+	"ArrayDecl", "AssignStmt", "BreakStmt", "ContinueStmt", "ExprStmt", "IfStmt",
+	"IndexedAssignStmt", "RangeStmt", "ReturnStmt", "VarDecl", "WhileStmt",
+}
+
+// TestBackendParity checks that both backends generate every kind of
+// statement, so that one cannot silently drop what the other runs.
+func TestBackendParity(t *testing.T) {
+	stmts := astStmts(t)
+	if len(stmts) == 0 {
+		t.Fatal("no statement types found in pkg/ast")
+	}
+	backends := []struct{ recv, file, stmt, compute string }{
+		{"CodeGen", "codegen_go.go", "generateStmt", "generateComputeBodyStmtWithPerspective"},
+		{"RustCodeGen", "codegen_rust.go", "generateStmt", "generateComputeBodyStmt"},
+	}
+	for _, b := range backends {
+		handled := switchCases(t, b.file, b.recv, b.stmt)
+		for _, name := range stmts {
+			why, exempt := stmtExempt[b.recv][name]
+			switch {
+			case !handled[name] && !exempt:
+				t.Errorf("%s.%s does not handle ast.%s", b.recv, b.stmt, name)
+			case handled[name] && exempt:
+				t.Errorf("%s.%s handles ast.%s, exempt as %q", b.recv, b.stmt, name, why)
+			}
+		}
+
+		kernel := switchCases(t, b.file, b.recv, b.compute)
+		for _, name := range computeStmts {
+			// The Go kernel hands what it does not know to generateStmt
+			if !kernel[name] && !(b.recv == "CodeGen" && handled[name]) {
+				t.Errorf("%s.%s does not handle ast.%s", b.recv, b.compute, name)
+			}
+		}
+	}
+}
+
+// astStmts returns the names of the statement types of pkg/ast
+func astStmts(t *testing.T) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	files, err := filepath.Glob("../../pkg/ast/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range files {
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Name.Name != "stmt" || fn.Recv == nil {
+				continue
+			}
+			if star, ok := fn.Recv.List[0].Type.(*ast.StarExpr); ok {
+				names = append(names, star.X.(*ast.Ident).Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// switchCases returns the ast types named by the cases of the type
+// switches in the method recv.fn of file
+func switchCases(t *testing.T, file, recv, fn string) map[string]bool {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := make(map[string]bool)
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Name.Name != fn || fd.Recv == nil {
+			continue
+		}
+		if star, ok := fd.Recv.List[0].Type.(*ast.StarExpr); !ok || star.X.(*ast.Ident).Name != recv {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSwitchStmt)
+			if !ok {
+				return true
+			}
+			for _, stmt := range ts.Body.List {
+				for _, e := range stmt.(*ast.CaseClause).List {
+					if star, ok := e.(*ast.StarExpr); ok {
+						if sel, ok := star.X.(*ast.SelectorExpr); ok {
+							cases[sel.Sel.Name] = true
+						}
+					}
+				}
+			}
+			return true
+		})
+		return cases
+	}
+	t.Fatalf("%s: no method %s.%s", file, recv, fn)
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Top-of-stack caching for the native dstack (-O).
+//
+// A push to @dstack is not emitted straight away. Its value is kept on a
+// virtual stack, g.tos, as a Go expression: an integer literal or a
+// register, a local assigned once with ":=". Stack operations that only
+// move or combine cached values become register assignments, so
+//
+//	push:x push:10 sub pop:y
+//
+// compiles to
+//
+//	_r1 := var_x
+//	_r2 := _r1 - 10
+//	var_y = _r2
+//
+// instead of two _push and two _pop calls. Anything else written through
+// writeln, including the closing brace of a block, first pushes the cached
+// values in order, so the real stack is exact wherever other code can see
+// it.
+
+// tosBinary maps the binary stack ops that combine two cached values to
+// the Go expression computing them from a and b
+var tosBinary = map[string]string{
+	"add": "%s + %s", "sub": "%s - %s", "mul": "%s * %s",
+	"div": "%s / %s", "mod": "%s %% %s",
+	"band": "%s & %s", "bor": "%s | %s", "bxor": "%s ^ %s",
+	"shl": "%s << uint(%s)", "shr": "%s >> uint(%s)",
+	"min": "minInt(%s, %s)", "max": "maxInt(%s, %s)",
+}
+
+// tosUnary is tosBinary for ops on one cached value
+var tosUnary = map[string]string{
+	"neg": "-%s", "abs": "absInt(%s)", "inc": "%s + 1", "dec": "%s - 1", "bnot": "^%s",
+}
+
+// generateTOSOp generates s, an op on the native dstack, against the
+// cached values. It returns false if s needs the real stack; s is then
+// generated as usual and the cache is flushed by its first writeln.
+func (g *CodeGen) generateTOSOp(s *ast.StackOp) bool {
+	n := len(g.tos)
+	switch op := s.Op; {
+	case op == "push":
+		if len(s.Args) != 1 {
+			return false
+		}
+		switch arg := s.Args[0].(type) {
+		case *ast.IntLit:
+			g.tos = append(g.tos, strconv.FormatInt(arg.Value, 10))
+			return true
+		case *ast.Ident:
+			// Copied, as the variable may change before the value is used
+			sym := g.symbols.Lookup(arg.Name)
+			if sym == nil || !sym.Native || !isIntType(sym.Type) {
+				return false
+			}
+			if sym.Type == "i64" {
+				g.tos = append(g.tos, g.register("var_"+arg.Name))
+			} else {
+				g.tos = append(g.tos, g.register("int64(var_"+arg.Name+")"))
+			}
+			return true
+		}
+		return false
+	case n == 0:
+		return false
+	case op == "pop" && s.Target != "":
+		sym := g.symbols.Lookup(s.Target)
+		if sym == nil || !sym.Native || sym.Type != "i64" {
+			return false
+		}
+		g.emit(fmt.Sprintf("var_%s = %s", s.Target, g.tos[n-1]))
+	case op == "pop" || op == "drop":
+		if isRegister(g.tos[n-1]) {
+			g.emit("_ = " + g.tos[n-1])
+		}
+	case op == "dup":
+		g.tos = append(g.tos, g.tos[n-1])
+		return true
+	case op == "dot" || op == "println":
+		if len(s.Args) > 0 {
+			return false
+		}
+		g.emit(fmt.Sprintf("fmt.Println(%s)", g.tos[n-1]))
+	case op == "print" && len(s.Args) == 0:
+		g.emit(fmt.Sprintf("fmt.Print(%s)", g.tos[n-1]))
+	case tosUnary[op] != "":
+		v := g.tos[n-1]
+		if !isRegister(v) {
+			v = g.register(v)
+		}
+		g.tos[n-1] = g.register(fmt.Sprintf(tosUnary[op], v))
+		return true
+	case n < 2:
+		return false
+	case op == "swap":
+		g.tos[n-2], g.tos[n-1] = g.tos[n-1], g.tos[n-2]
+		return true
+	case op == "over":
+		g.tos = append(g.tos, g.tos[n-2])
+		return true
+	case tosBinary[op] != "":
+		a, b := g.tos[n-2], g.tos[n-1]
+		// Literal operands would make a constant expression, which Go
+		// rejects on overflow, division by zero or a negative shift
+		if !isRegister(a) {
+			a = g.register(a)
+		}
+		if !isRegister(b) && op != "add" && op != "sub" && op != "mul" {
+			b = g.register(b)
+		}
+		g.tos = append(g.tos[:n-2], g.register(fmt.Sprintf(tosBinary[op], a, b)))
+		return true
+	case op == "rot" && n >= 3:
+		g.tos[n-3], g.tos[n-2], g.tos[n-1] = g.tos[n-2], g.tos[n-1], g.tos[n-3]
+		return true
+	default:
+		return false
+	}
+	g.tos = g.tos[:n-1]
+	return true
+}
+
+// register assigns expr to a new register and returns its name
+func (g *CodeGen) register(expr string) string {
+	g.registers++
+	name := fmt.Sprintf("_r%d", g.registers)
+	if _, err := strconv.ParseInt(expr, 10, 64); err == nil {
+		expr = "int64(" + expr + ")"
+	}
+	g.emit(fmt.Sprintf("%s := %s", name, expr))
+	return name
+}
+
+func isRegister(v string) bool {
+	return len(v) > 2 && v[:2] == "_r"
+}
+
+// flushTOS pushes the cached values to the native dstack
+func (g *CodeGen) flushTOS() {
+	vals := g.tos
+	g.tos = nil
+	for _, v := range vals {
+		g.emit(fmt.Sprintf("_push(%s)", v))
+	}
+}
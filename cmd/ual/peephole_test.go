@@ -0,0 +1,58 @@
+package main
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+// generateOptimized compiles src with -O and returns the body of main
+func generateOptimized(t *testing.T, src string) string {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	g := NewCodeGenOptimized(false, true)
+	code := g.Generate(prog)
+	if g.hasErrors() {
+		t.Fatal(g.getErrors()[0])
+	}
+	if _, err := format.Source([]byte(code)); err != nil {
+		t.Fatalf("generated invalid Go: %v\n%s", err, code)
+	}
+	return code[strings.Index(code, "func main()"):]
+}
+
+func TestTOSCollapsesPushPop(t *testing.T) {
+	main := generateOptimized(t, "var x i64 = 7\nvar y i64 = 0\npush:x\npush:10\nsub\npop:y\npush:x\ndup\ndrop\ndot\n")
+	for _, want := range []string{"_r1 := var_x", "_r2 := _r1 - 10", "var_y = _r2", "fmt.Println(_r3)"} {
+		if !strings.Contains(main, want) {
+			t.Errorf("missing %q in\n%s", want, main)
+		}
+	}
+	if strings.Contains(main, "_push(") || strings.Contains(main, "_pop()") {
+		t.Errorf("push/pop pairs left in\n%s", main)
+	}
+}
+
+func TestTOSFlushesBeforeOtherCode(t *testing.T) {
+	main := generateOptimized(t, "var x i64 = 1\npush:2\npush:x\nif (x > 0) {\n  add\n}\ndot\n")
+	first, second := strings.Index(main, "_push(2)"), strings.Index(main, "_push(_r1)")
+	branch := strings.Index(main, "if ")
+	if first < 0 || first > second || second > branch {
+		t.Errorf("cached values not pushed in order before the if:\n%s", main)
+	}
+}
+
+func TestTOSConvertsTypedVars(t *testing.T) {
+	main := generateOptimized(t, "var x u8 = 200\nvar y i32 = 7\npush:x\npush:y\nadd\ndot\n")
+	for _, want := range []string{"_r1 := int64(var_x)", "_r2 := int64(var_y)"} {
+		if !strings.Contains(main, want) {
+			t.Errorf("missing %q in\n%s", want, main)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reading pprof profiles.
+//
+// runtime/pprof writes profiles as gzipped protocol buffers
+// (github.com/google/pprof/proto/profile.proto). ual profile only needs
+// the samples and the functions and lines of their stacks, so it decodes
+// those fields itself rather than depending on the pprof module.
+
+// pprofProfile is a decoded profile
+type pprofProfile struct {
+	types   []string // sample types, such as "cpu" and "alloc_space"
+	samples []pprofSample
+}
+
+// pprofSample is one sample: its stack, innermost call first, and a value
+// per sample type
+type pprofSample struct {
+	stack  []pprofFrame
+	values []int64
+}
+
+// pprofFrame is a function and the line in it a sample was at
+type pprofFrame struct {
+	function string
+	file     string
+	line     int
+}
+
+// valueIndex returns the index of the sample type named typ, or -1
+func (p *pprofProfile) valueIndex(typ string) int {
+	for i, t := range p.types {
+		if t == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+// Field numbers of profile.proto
+const (
+	pbProfileSampleType = 1
+	pbProfileSample     = 2
+	pbProfileLocation   = 4
+	pbProfileFunction   = 5
+	pbProfileStrings    = 6
+
+	pbValueTypeType = 1
+
+	pbSampleLocation = 1
+	pbSampleValue    = 2
+
+	pbLocationID   = 1
+	pbLocationLine = 4
+
+	pbLineFunction = 1
+	pbLineLine     = 2
+
+	pbFunctionID   = 1
+	pbFunctionName = 2
+	pbFunctionFile = 4
+)
+
+type pprofFunction struct{ name, file int64 }
+type pprofLine struct{ function, line int64 }
+type pprofRawSample struct {
+	locations []uint64
+	values    []int64
+}
+
+// parsePprof decodes a profile runtime/pprof wrote
+func parsePprof(data []byte) (*pprofProfile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	var typeNames []int64
+	var raw []pprofRawSample
+	locations := map[uint64][]pprofLine{}
+	functions := map[uint64]pprofFunction{}
+	var strs []string
+	err := pbFields(data, func(field int, v uint64, b []byte) error {
+		switch field {
+		case pbProfileSampleType:
+			return pbFields(b, func(field int, v uint64, _ []byte) error {
+				if field == pbValueTypeType {
+					typeNames = append(typeNames, int64(v))
+				}
+				return nil
+			})
+		case pbProfileSample:
+			var s pprofRawSample
+			err := pbFields(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case pbSampleLocation:
+					return pbRepeated(v, b, func(v uint64) { s.locations = append(s.locations, v) })
+				case pbSampleValue:
+					return pbRepeated(v, b, func(v uint64) { s.values = append(s.values, int64(v)) })
+				}
+				return nil
+			})
+			raw = append(raw, s)
+			return err
+		case pbProfileLocation:
+			var id uint64
+			var lines []pprofLine
+			err := pbFields(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case pbLocationID:
+					id = v
+				case pbLocationLine:
+					var l pprofLine
+					lines = append(lines, l)
+					return pbFields(b, func(field int, v uint64, _ []byte) error {
+						switch field {
+						case pbLineFunction:
+							lines[len(lines)-1].function = int64(v)
+						case pbLineLine:
+							lines[len(lines)-1].line = int64(v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			locations[id] = lines
+			return err
+		case pbProfileFunction:
+			var id uint64
+			var f pprofFunction
+			err := pbFields(b, func(field int, v uint64, _ []byte) error {
+				switch field {
+				case pbFunctionID:
+					id = v
+				case pbFunctionName:
+					f.name = int64(v)
+				case pbFunctionFile:
+					f.file = int64(v)
+				}
+				return nil
+			})
+			functions[id] = f
+			return err
+		case pbProfileStrings:
+			strs = append(strs, string(b))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(strs)) {
+			return ""
+		}
+		return strs[i]
+	}
+	p := &pprofProfile{}
+	for _, t := range typeNames {
+		p.types = append(p.types, str(t))
+	}
+	for _, r := range raw {
+		s := pprofSample{values: r.values}
+		for _, id := range r.locations {
+			// A location's lines are its inlined calls, innermost first
+			for _, l := range locations[id] {
+				f := functions[uint64(l.function)]
+				s.stack = append(s.stack, pprofFrame{function: str(f.name), file: str(f.file), line: int(l.line)})
+			}
+		}
+		p.samples = append(p.samples, s)
+	}
+	return p, nil
+}
+
+var errPprofTruncated = errors.New("pprof: truncated profile")
+
+// pbFields calls fn with the number of each field in the message data and
+// its value: v for varints and fixed-size numbers, b for length-delimited
+// fields
+func pbFields(data []byte, fn func(field int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := pbVarint(data)
+		if n == 0 {
+			return errPprofTruncated
+		}
+		data = data[n:]
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = pbVarint(data); n == 0 {
+				return errPprofTruncated
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errPprofTruncated
+			}
+			for i := 7; i >= 0; i-- {
+				v = v<<8 | uint64(data[i])
+			}
+			data = data[8:]
+		case 2:
+			l, n := pbVarint(data)
+			if n == 0 || uint64(len(data)-n) < l {
+				return errPprofTruncated
+			}
+			b = data[n : n+int(l)]
+			data = data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return errPprofTruncated
+			}
+			for i := 3; i >= 0; i-- {
+				v = v<<8 | uint64(data[i])
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("pprof: unsupported wire type %d", key&7)
+		}
+		if err := fn(int(key>>3), v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pbRepeated calls fn with each value of a repeated integer field, which
+// is one varint v, or a packed run of them in b
+func pbRepeated(v uint64, b []byte, fn func(uint64)) error {
+	if b == nil {
+		fn(v)
+		return nil
+	}
+	for len(b) > 0 {
+		v, n := pbVarint(b)
+		if n == 0 {
+			return errPprofTruncated
+		}
+		fn(v)
+		b = b[n:]
+	}
+	return nil
+}
+
+// pbVarint decodes the varint data starts with, returning its length, 0
+// if it is not complete
+func pbVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/runtime/profile"
+)
+
+// ual profile builds a program for the Go target with main profiling the
+// run (see pkg/runtime/profile), runs it, and reads back the CPU and
+// allocation profiles it wrote. Each sample's stack is mapped through the
+// source map to the .ual lines it passes through, so the report names
+// lines of the program rather than of the generated main.go:
+//
+//   - a line's flat cost is spent on the line itself, including the
+//     stack operations and other runtime calls it makes; its cumulative
+//     cost adds the ual functions it calls
+//   - the runtime functions the generated code calls, stack operations
+//     such as Stack.Push first among them, are totalled on their own
+//
+// The program's output goes to stderr, so that stdout is the report.
+
+// profileRuntime is the prefix of the functions of the ual runtime
+const profileRuntime = "github.com/ha1tch/ual/pkg/runtime."
+
+// profileLine is the cost of one line of the program
+type profileLine struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Source    string `json:"source"`
+	CPUFlat   int64  `json:"cpu_flat_ns"`
+	CPUCum    int64  `json:"cpu_cum_ns"`
+	AllocFlat int64  `json:"alloc_flat_bytes"`
+	AllocCum  int64  `json:"alloc_cum_bytes"`
+	Allocs    int64  `json:"allocs"` // objects the line allocated itself
+}
+
+// profileOp is the cost of a runtime function called by generated code
+type profileOp struct {
+	Name       string `json:"name"`
+	CPU        int64  `json:"cpu_ns"`
+	AllocBytes int64  `json:"alloc_bytes"`
+	Allocs     int64  `json:"allocs"`
+}
+
+// profileReport is the document ual profile --format json writes
+type profileReport struct {
+	File       string         `json:"file"`
+	CPU        int64          `json:"cpu_ns"`
+	AllocBytes int64          `json:"alloc_bytes"`
+	Allocs     int64          `json:"allocs"`
+	Lines      []*profileLine `json:"lines"`
+	Operations []*profileOp   `json:"operations"`
+}
+
+func profileCommand(args []string) {
+	format := "text"
+	top := 10
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "--"); i++ {
+		switch arg := args[i]; arg {
+		case "--format", "--top":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: %s requires an argument\n", arg)
+				os.Exit(1)
+			}
+			i++
+			if arg == "--format" {
+				format = args[i]
+				continue
+			}
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "error: --top must be a number of lines, got '%s'\n", args[i])
+				os.Exit(1)
+			}
+			top = n
+		default:
+			fmt.Fprintf(os.Stderr, "error: unknown profile option: %s\n", arg)
+			os.Exit(1)
+		}
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "error: --format must be 'text' or 'json', got '%s'\n", format)
+		os.Exit(1)
+	}
+	path, ok := resolveInput(append([]string{"run"}, args[i:]...))
+	if !ok {
+		os.Exit(1)
+	}
+	var progArgs []string
+	if len(args) > i+1 {
+		progArgs = args[i+1:]
+	}
+
+	targetLang = resolveTarget()
+	if targetLang != "go" {
+		fmt.Fprintf(os.Stderr, "error: ual profile is not supported by the %s backend yet\n", targetLang)
+		os.Exit(1)
+	}
+	r, status, err := profileProgram(path, progArgs, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if format == "json" {
+		data, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Fprintf(os.Stdout, "%s\n", data)
+	} else {
+		writeProfileText(os.Stdout, r, top)
+	}
+	if status != 0 {
+		fmt.Fprintf(os.Stderr, "program exited with status %d\n", status)
+		os.Exit(status)
+	}
+}
+
+// profileProgram builds and runs the program at path with args, its
+// output going to out, and returns the report of its profiles and its
+// exit status
+func profileProgram(path string, args []string, out io.Writer) (*profileReport, int, error) {
+	prog, err := loadProgram(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	profileMode = true
+	goCode, srcMap, err := generateGoProgram(prog, path)
+	profileMode = false
+	if err != nil {
+		return nil, 0, err
+	}
+	var build bytes.Buffer
+	b, err := buildGoCached(goCode, "", false, &build)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v\n%s", err, strings.TrimSpace(build.String()))
+	}
+	defer b.Close()
+
+	dir, err := os.MkdirTemp("", "ual-profile")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	// Panic traces name main.go lines; point them at the .ual source
+	trace := newTraceWriter(out, b.goFile, srcMap)
+	cmd := exec.Command(b.binary, args...)
+	cmd.Env = append(os.Environ(), profile.Env+"="+dir)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = trace
+	cmd.Stderr = trace
+	err = cmd.Run()
+	trace.Flush()
+	status := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	r := newProfileTally(path)
+	for _, name := range []string{profile.CPUFile, profile.AllocsFile} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, status, fmt.Errorf("the program wrote no profile: %v", err)
+		}
+		p, err := parsePprof(data)
+		if err != nil {
+			return nil, status, fmt.Errorf("%s: %v", name, err)
+		}
+		r.add(p, b.goFile, srcMap)
+	}
+	r.finish()
+	return r.profileReport, status, nil
+}
+
+// profileTally is a report while it is being added up
+type profileTally struct {
+	*profileReport
+	lines map[string]*profileLine // by file:line
+	ops   map[string]*profileOp
+}
+
+func newProfileTally(path string) *profileTally {
+	return &profileTally{
+		profileReport: &profileReport{File: path, Lines: []*profileLine{}, Operations: []*profileOp{}},
+		lines:         map[string]*profileLine{},
+		ops:           map[string]*profileOp{},
+	}
+}
+
+// add adds up the samples of p, whose stacks run through generated, the
+// Go file m maps to the program's source
+func (r *profileTally) add(p *pprofProfile, generated string, m *sourceMap) {
+	cpu := p.valueIndex("cpu")
+	space, objects := p.valueIndex("alloc_space"), p.valueIndex("alloc_objects")
+	for _, s := range p.samples {
+		var v [3]int64 // CPU, bytes and objects
+		for i, idx := range []int{cpu, space, objects} {
+			if idx >= 0 && idx < len(s.values) {
+				v[i] = s.values[idx]
+			}
+		}
+		if v == [3]int64{} {
+			continue
+		}
+		r.CPU += v[0]
+		r.AllocBytes += v[1]
+		r.Allocs += v[2]
+
+		seen := map[*profileLine]bool{}
+		called, flat := true, true
+		for i, f := range s.stack {
+			if f.file != generated {
+				continue
+			}
+			// The runtime function the generated code called
+			if called && i > 0 && strings.HasPrefix(s.stack[i-1].function, profileRuntime) {
+				r.op(s.stack[i-1].function).addCost(v)
+			}
+			called = false
+			file, line, ok := m.Lookup(f.line)
+			if !ok {
+				// A helper: its cost is the line that called it
+				continue
+			}
+			l := r.line(file, line)
+			if flat {
+				l.CPUFlat += v[0]
+				l.AllocFlat += v[1]
+				l.Allocs += v[2]
+				flat = false
+			}
+			// Recursion passes a line more than once
+			if !seen[l] {
+				seen[l] = true
+				l.CPUCum += v[0]
+				l.AllocCum += v[1]
+			}
+		}
+	}
+}
+
+func (op *profileOp) addCost(v [3]int64) {
+	op.CPU += v[0]
+	op.AllocBytes += v[1]
+	op.Allocs += v[2]
+}
+
+func (r *profileTally) line(file string, line int) *profileLine {
+	key := fmt.Sprintf("%s:%d", file, line)
+	l, ok := r.lines[key]
+	if !ok {
+		l = &profileLine{File: file, Line: line}
+		r.lines[key] = l
+		r.Lines = append(r.Lines, l)
+	}
+	return l
+}
+
+// op returns the tally of the runtime function named fn, as the generated
+// code calls it: github.com/ha1tch/ual/pkg/runtime.(*Stack).Push is
+// Stack.Push
+func (r *profileTally) op(fn string) *profileOp {
+	name := strings.TrimPrefix(fn, profileRuntime)
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	op, ok := r.ops[name]
+	if !ok {
+		op = &profileOp{Name: name}
+		r.ops[name] = op
+		r.Operations = append(r.Operations, op)
+	}
+	return op
+}
+
+// finish fills in the source of each line and puts the lines and
+// operations in order of CPU, then allocation
+func (r *profileTally) finish() {
+	sources := map[string][]string{}
+	for _, l := range r.Lines {
+		text, ok := sources[l.File]
+		if !ok {
+			data, _ := os.ReadFile(l.File)
+			text = strings.Split(string(data), "\n")
+			sources[l.File] = text
+		}
+		if l.Line <= len(text) {
+			l.Source = strings.TrimSpace(text[l.Line-1])
+		}
+	}
+	sort.SliceStable(r.Lines, func(i, j int) bool {
+		a, b := r.Lines[i], r.Lines[j]
+		if a.CPUFlat != b.CPUFlat {
+			return a.CPUFlat > b.CPUFlat
+		}
+		if a.AllocFlat != b.AllocFlat {
+			return a.AllocFlat > b.AllocFlat
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	sort.SliceStable(r.Operations, func(i, j int) bool {
+		a, b := r.Operations[i], r.Operations[j]
+		if a.CPU != b.CPU {
+			return a.CPU > b.CPU
+		}
+		if a.AllocBytes != b.AllocBytes {
+			return a.AllocBytes > b.AllocBytes
+		}
+		return a.Name < b.Name
+	})
+}
+
+// writeProfileText writes the top lines by CPU and by allocation, and the
+// top runtime operations, in the columns of go tool pprof -top
+func writeProfileText(w io.Writer, r *profileReport, top int) {
+	fmt.Fprintf(w, "%s: %s CPU, %s in %d allocations\n", r.File, formatNanos(r.CPU), formatBytes(r.AllocBytes), r.Allocs)
+
+	fmt.Fprintf(w, "\nCPU by line\n%10s %6s %10s %6s  %s\n", "flat", "flat%", "cum", "cum%", "line")
+	byCPU := topLines(r.Lines, top, func(l *profileLine) int64 { return l.CPUFlat + l.CPUCum })
+	for _, l := range byCPU {
+		fmt.Fprintf(w, "%10s %6s %10s %6s  %s:%d  %s\n", formatNanos(l.CPUFlat), percent(l.CPUFlat, r.CPU),
+			formatNanos(l.CPUCum), percent(l.CPUCum, r.CPU), l.File, l.Line, l.Source)
+	}
+
+	fmt.Fprintf(w, "\nAllocations by line\n%10s %6s %10s %6s  %s\n", "flat", "flat%", "cum", "cum%", "line")
+	byAlloc := append([]*profileLine(nil), r.Lines...)
+	sort.SliceStable(byAlloc, func(i, j int) bool { return byAlloc[i].AllocFlat > byAlloc[j].AllocFlat })
+	byAlloc = topLines(byAlloc, top, func(l *profileLine) int64 { return l.AllocFlat + l.AllocCum })
+	for _, l := range byAlloc {
+		fmt.Fprintf(w, "%10s %6s %10s %6s  %s:%d  %s\n", formatBytes(l.AllocFlat), percent(l.AllocFlat, r.AllocBytes),
+			formatBytes(l.AllocCum), percent(l.AllocCum, r.AllocBytes), l.File, l.Line, l.Source)
+	}
+
+	fmt.Fprintf(w, "\nStack and runtime operations\n%10s %6s %10s %6s  %s\n", "cpu", "cpu%", "alloc", "alloc%", "operation")
+	for i, op := range r.Operations {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(w, "%10s %6s %10s %6s  %s\n", formatNanos(op.CPU), percent(op.CPU, r.CPU),
+			formatBytes(op.AllocBytes), percent(op.AllocBytes, r.AllocBytes), op.Name)
+	}
+}
+
+// topLines returns the first top of lines for which cost is not zero
+func topLines(lines []*profileLine, top int, cost func(*profileLine) int64) []*profileLine {
+	var out []*profileLine
+	for _, l := range lines {
+		if len(out) == top {
+			break
+		}
+		if cost(l) != 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func percent(n, total int64) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+func formatNanos(ns int64) string {
+	if ns == 0 {
+		return "0"
+	}
+	return time.Duration(ns).Round(time.Microsecond).String()
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fkB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
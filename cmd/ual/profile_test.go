@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func TestParsePprof(t *testing.T) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	p, err := parsePprof(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.valueIndex("alloc_space") < 0 || p.valueIndex("alloc_objects") < 0 {
+		t.Fatalf("sample types %v", p.types)
+	}
+	found := false
+	for _, s := range p.samples {
+		if len(s.values) != len(p.types) {
+			t.Fatalf("sample has %d values for %d types", len(s.values), len(p.types))
+		}
+		for _, f := range s.stack {
+			if f.function == "" || f.line <= 0 {
+				t.Fatalf("incomplete frame %+v", f)
+			}
+			if strings.HasPrefix(f.function, "testing.") && strings.HasSuffix(f.file, ".go") {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("no frame of the testing package")
+	}
+
+	if _, err := parsePprof([]byte{0x0a, 0x05, 0x08}); err == nil {
+		t.Error("truncated profile parsed")
+	}
+}
+
+func TestProfileTally(t *testing.T) {
+	// main.go lines 10-12 are prog.ual:3, 20-21 prog.ual:7; 5 is a helper
+	m := &sourceMap{Sources: []string{"prog.ual"}, Ranges: []sourceRange{
+		{Start: 10, End: 12, Line: 3},
+		{Start: 20, End: 21, Line: 7},
+	}}
+	at := func(function, file string, line int) pprofFrame {
+		return pprofFrame{function: function, file: file, line: line}
+	}
+	push := at(profileRuntime+"(*Stack).Push", "stack.go", 99)
+	p := &pprofProfile{types: []string{"samples", "cpu"}, samples: []pprofSample{
+		// prog.ual:3 pushes, called from prog.ual:7
+		{values: []int64{1, 30e6}, stack: []pprofFrame{push, at("main.f", "main.go", 11), at("main.main", "main.go", 20)}},
+		// prog.ual:3 through a helper, recursing through itself
+		{values: []int64{1, 10e6}, stack: []pprofFrame{at("main.bytesToInt", "main.go", 5), at("main.f", "main.go", 10), at("main.f", "main.go", 12), at("main.main", "main.go", 21)}},
+		// the garbage collector
+		{values: []int64{1, 60e6}, stack: []pprofFrame{at("runtime.gcBgMarkWorker", "mgc.go", 1)}},
+	}}
+	r := newProfileTally("prog.ual")
+	r.add(p, "main.go", m)
+	r.finish()
+
+	if r.CPU != 100e6 {
+		t.Errorf("CPU %d, want 100e6", r.CPU)
+	}
+	var got []string
+	for _, l := range r.Lines {
+		got = append(got, fmt.Sprintf("%s %d %d %d", l.File, l.Line, l.CPUFlat, l.CPUCum))
+	}
+	if want := []string{"prog.ual 3 40000000 40000000", "prog.ual 7 0 40000000"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+	if len(r.Operations) != 1 || r.Operations[0].Name != "Stack.Push" || r.Operations[0].CPU != 30e6 {
+		t.Errorf("operations %+v", r.Operations)
+	}
+
+	var out bytes.Buffer
+	writeProfileText(&out, r.profileReport, 1)
+	for _, want := range []string{"prog.ual: 100ms CPU, 0B in 0 allocations", "40ms  40.0%       40ms  40.0%  prog.ual:3", "30ms  30.0%         0B   0.0%  Stack.Push"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "prog.ual:7") {
+		t.Errorf("report has more than --top lines:\n%s", out.String())
+	}
+}
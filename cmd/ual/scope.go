@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Variable scopes and shadowing warnings.
+//
+// A variable declared with var at the top level of a program is global:
+// the top-level code after its declaration and every function can read
+// and update it. The parameters and variables of a function belong to one
+// call of it, and a variable declared in a block (the body of an if, a
+// loop, a codeblock, a handler) belongs to that block. A name refers to
+// the innermost declaration enclosing it.
+//
+// Declaring a variable that hides one of an enclosing scope is allowed but
+// is usually a slip, so scopeWarnings reports it. Library statements are
+// not checked.
+
+// scopeWarnings returns the shadowing warnings for prog, read from path,
+// in source order
+func scopeWarnings(prog *ast.Program, path string) []string {
+	a := analyzeScopes(prog)
+	for i := range a.warnings {
+		a.warnings[i].pos.File = path
+		a.warnings[i].msg = a.warnings[i].pos.String() + ": " + a.warnings[i].msg
+	}
+	return sortWarnings(a.warnings)
+}
+
+// globalUses returns the global variables each function of prog uses, by
+// function name, in the order first used
+func globalUses(prog *ast.Program) map[string][]string {
+	return analyzeScopes(prog).uses
+}
+
+type scopeAnalysis struct {
+	pos      map[ast.Stmt]ast.Pos
+	globals  map[string]ast.Pos   // top-level var declarations, for functions
+	scopes   []map[string]ast.Pos // scopes enclosing the walk, innermost last
+	fn       string               // function being walked, "" at the top level
+	uses     map[string][]string  // globals used by each function
+	at       ast.Pos              // position of the statement being walked
+	warnings []warning
+}
+
+func analyzeScopes(prog *ast.Program) *scopeAnalysis {
+	a := &scopeAnalysis{
+		pos:     prog.Pos,
+		globals: map[string]ast.Pos{},
+		uses:    map[string][]string{},
+	}
+	for _, stmt := range prog.Stmts {
+		if v, ok := stmt.(*ast.VarDecl); ok {
+			for _, name := range v.Names {
+				if _, ok := a.globals[name]; !ok {
+					a.globals[name] = prog.Pos[stmt]
+				}
+			}
+		}
+	}
+
+	// Top-level code sees each global from its declaration on
+	a.scopes = []map[string]ast.Pos{{}}
+	for _, stmt := range prog.Stmts {
+		a.walk(reflect.ValueOf(stmt))
+	}
+	return a
+}
+
+// walk visits every node under v, declaring and resolving variables
+func (a *scopeAnalysis) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			a.walk(v.Elem())
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if stmt, ok := v.Interface().(ast.Stmt); ok {
+			if pos, ok := a.pos[stmt]; ok {
+				a.at = pos
+			}
+		}
+		if !a.visit(v.Interface()) {
+			a.walk(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			a.walk(v.Field(i))
+		}
+	case reflect.Slice:
+		if stmts, ok := v.Interface().([]ast.Stmt); ok {
+			a.block(stmts, nil)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			a.walk(v.Index(i))
+		}
+	}
+}
+
+// visit handles the nodes that declare or use variables, and reports
+// whether it walked the children of node itself
+func (a *scopeAnalysis) visit(node interface{}) bool {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		savedScopes, savedFn := a.scopes, a.fn
+		a.scopes, a.fn = []map[string]ast.Pos{a.globals, {}}, n.Name
+		var params []string
+		for _, p := range n.Params {
+			if !p.Stack {
+				params = append(params, p.Name)
+			}
+		}
+		a.declareIn(n.Body, params, "parameter")
+		a.scopes, a.fn = savedScopes, savedFn
+	case *ast.ForStmt:
+		a.block(n.Body, n.Params)
+	case *ast.RangeStmt:
+		a.walk(reflect.ValueOf(n.Start))
+		a.walk(reflect.ValueOf(n.End))
+		a.block(n.Body, []string{n.Var})
+	case *ast.TryStmt:
+		a.block(n.Body, nil)
+		var bound []string
+		if n.ErrName != "" {
+			bound = []string{n.ErrName}
+		}
+		a.block(n.Catch, bound)
+		a.block(n.Finally, nil)
+	case *ast.ConsiderStmt:
+		a.walk(reflect.ValueOf(n.Block))
+		for _, c := range n.Cases {
+			a.block(c.Handler, c.Bindings)
+		}
+	case *ast.SelectStmt:
+		a.walk(reflect.ValueOf(n.Block))
+		for _, c := range n.Cases {
+			a.walk(reflect.ValueOf(c.Every))
+			a.walk(reflect.ValueOf(c.TimeoutMs))
+			a.walk(reflect.ValueOf(c.TimeoutFn))
+			a.block(c.Handler, c.Bindings)
+		}
+	case *ast.ComputeStmt:
+		a.walk(reflect.ValueOf(n.Setup))
+		a.block(n.Body, n.Params)
+	case *ast.SpawnPush:
+		a.block(n.Body, n.Params)
+	case *ast.FnLit:
+		a.block(n.Body, n.Params)
+	case *ast.VarDecl:
+		for _, val := range n.Values {
+			a.walk(reflect.ValueOf(val))
+		}
+		for _, name := range n.Names {
+			a.declare(name, "variable")
+		}
+	case *ast.LetAssign:
+		// let:name declares name when nothing in scope has it
+		if !a.use(n.Name) {
+			a.scopes[len(a.scopes)-1][n.Name] = a.at
+		}
+	case *ast.StackOp:
+		if n.Target != "" {
+			a.use(n.Target)
+		}
+		return false
+	case *ast.Ident:
+		a.use(n.Name)
+	default:
+		return false
+	}
+	return true
+}
+
+// block walks stmts in a new scope holding the variables bound
+func (a *scopeAnalysis) block(stmts []ast.Stmt, bound []string) {
+	a.scopes = append(a.scopes, map[string]ast.Pos{})
+	a.declareIn(stmts, bound, "variable")
+	a.scopes = a.scopes[:len(a.scopes)-1]
+}
+
+// declareIn declares bound, as kind, in the innermost scope and walks stmts
+// there
+func (a *scopeAnalysis) declareIn(stmts []ast.Stmt, bound []string, kind string) {
+	for _, name := range bound {
+		a.declare(name, kind)
+	}
+	for _, stmt := range stmts {
+		a.walk(reflect.ValueOf(stmt))
+	}
+}
+
+// declare adds name to the innermost scope, warning if it hides a
+// variable of an enclosing one
+func (a *scopeAnalysis) declare(name, kind string) {
+	inner := a.scopes[len(a.scopes)-1]
+	if _, ok := inner[name]; ok {
+		return // a redeclaration, not a new scope's variable
+	}
+	for i := len(a.scopes) - 2; i >= 0; i-- {
+		outer, ok := a.scopes[i][name]
+		if !ok {
+			continue
+		}
+		if a.at.File == "" && a.at.Line > 0 {
+			what := "variable"
+			if _, global := a.globals[name]; global && i == 0 {
+				what = "global variable"
+			}
+			a.warnings = append(a.warnings, warning{a.at,
+				fmt.Sprintf("%s %s shadows the %s declared at line %d", kind, name, what, outer.Line)})
+		}
+		break
+	}
+	inner[name] = a.at
+}
+
+// use resolves a variable used by name, recording the globals functions
+// use, and reports whether any scope has it
+func (a *scopeAnalysis) use(name string) bool {
+	for i := len(a.scopes) - 1; i >= 0; i-- {
+		if _, ok := a.scopes[i][name]; !ok {
+			continue
+		}
+		if i == 0 && a.fn != "" {
+			a.useGlobal(name)
+		}
+		return true
+	}
+	return false
+}
+
+func (a *scopeAnalysis) useGlobal(name string) {
+	for _, used := range a.uses[a.fn] {
+		if used == name {
+			return
+		}
+	}
+	a.uses[a.fn] = append(a.uses[a.fn], name)
+}
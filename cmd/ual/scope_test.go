@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestScopeWarnings(t *testing.T) {
+	src := `var total i64 = 0
+
+func add_to(total i64) {
+	var n i64 = total
+	if (n > 0) {
+		var n i64 = 1
+		push:n dot
+	}
+}
+
+func report() {
+	push:total dot
+	push:later dot
+}
+
+if (total == 0) {
+	var total i64 = 2
+	push:total dot
+}
+@s = stack.new(i64)
+@s for {|v| push:v dot}
+@s for {|v| push:v dot}
+var later i64 = 1
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"prog.ual:3:1: parameter total shadows the global variable declared at line 1",
+		"prog.ual:6:3: variable n shadows the variable declared at line 4",
+		"prog.ual:17:2: variable total shadows the global variable declared at line 1",
+	}
+	if got := scopeWarnings(prog, "prog.ual"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// add_to's total is its parameter; report uses two globals
+	uses := globalUses(prog)
+	if want := map[string][]string{"report": {"total", "later"}}; !reflect.DeepEqual(uses, want) {
+		t.Errorf("globalUses = %v, want %v", uses, want)
+	}
+}
+
+// The Rust backend keeps the globals functions use in statics
+func TestRustGlobals(t *testing.T) {
+	src := `var count i64 = 0
+var label string = "global"
+
+func tally(n i64) {
+	push:(count + n) let:count
+}
+
+tally(5)
+if (count > 1) {
+	var count i64 = 1
+	println(count)
+}
+println(count)
+println(label)
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRustCodeGen()
+	out := r.Generate(prog)
+	if r.hasErrors() {
+		t.Fatalf("errors: %v", r.getErrors())
+	}
+	for _, want := range []string{
+		"static ref GLOBAL_COUNT: rual::Global<i64> = rual::Global::new(0);",
+		"DSTACK.push((GLOBAL_COUNT.get() + n)).ok();",
+		"GLOBAL_COUNT.set(DSTACK.pop().unwrap_or_default());",
+		"GLOBAL_COUNT.set(0);",
+		"let mut count: i64 = 1;\n        println!(\"{}\", count);",
+		"}\n    println!(\"{}\", GLOBAL_COUNT.get());",
+		"let mut label: String",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("generated code lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "GLOBAL_LABEL") {
+		t.Errorf("label, used by no function, is a static:\n%s", out)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/backend"
+)
+
+// Source maps.
+//
+// Both backends note the .ual position of the statement they are
+// generating at the start of each line where it changes, as a marker line
+// no generated code can contain. Generate strips the markers and keeps the
+// positions as a sourceMap, which `ual compile` writes next to the code as
+// JSON and `ual run` uses to rewrite the generated file's positions in
+// panic traces:
+//
+//	main.main()
+//		/tmp/ual-run123/main.go:412 +0x1d
+//
+// becomes
+//
+//	main.main()
+//		prog.ual:17 +0x1d
+//
+// Lines written before any statement, such as the helpers and the setup
+// at the start of main, map to nothing.
+
+// sourceMarker starts a marker line: file, line and column follow,
+// separated by NULs, which generated code quotes
+const sourceMarker = "\x00ual\x00"
+
+// sourceMap maps lines of generated code back to .ual source
+type sourceMap struct {
+	Version int           `json:"version"`
+	File    string        `json:"file"` // the generated file
+	Sources []string      `json:"sources"`
+	Ranges  []sourceRange `json:"ranges"`
+}
+
+// sourceRange maps the generated lines Start to End, counted from 1, to
+// the statement at Line and Column of Sources[Source]
+type sourceRange struct {
+	Start  int `json:"start"`
+	End    int `json:"end"`
+	Source int `json:"source"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// markSource writes a marker for pos to out if it differs from marked, the
+// position last written, and returns the position now in effect. Markers
+// only go at the start of a line.
+func markSource(out *strings.Builder, marked, pos ast.Pos) ast.Pos {
+	if pos == marked {
+		return marked
+	}
+	if s := out.String(); s != "" && s[len(s)-1] != '\n' {
+		return marked
+	}
+	fmt.Fprintf(out, "%s%s\x00%d\x00%d\n", sourceMarker, pos.File, pos.Line, pos.Col)
+	return pos
+}
+
+// extractSourceMap returns code without its marker lines, and the map of
+// its lines to the positions the markers gave
+func extractSourceMap(code string) (string, *sourceMap) {
+	m := &sourceMap{Version: 1, Sources: []string{}, Ranges: []sourceRange{}}
+	sources := map[string]int{}
+	var out strings.Builder
+	out.Grow(len(code))
+	var cur *sourceRange // position of the lines being read, nil if none
+	line := 0
+	for _, text := range strings.SplitAfter(code, "\n") {
+		if strings.HasPrefix(text, sourceMarker) {
+			f := strings.Split(strings.TrimSuffix(text[len(sourceMarker):], "\n"), "\x00")
+			cur = nil
+			if n, _ := strconv.Atoi(f[1]); n > 0 {
+				idx, ok := sources[f[0]]
+				if !ok {
+					idx = len(m.Sources)
+					sources[f[0]] = idx
+					m.Sources = append(m.Sources, f[0])
+				}
+				col, _ := strconv.Atoi(f[2])
+				cur = &sourceRange{Source: idx, Line: n, Column: col}
+			}
+			continue
+		}
+		out.WriteString(text)
+		if text == "" {
+			continue
+		}
+		line++
+		if cur == nil {
+			continue
+		}
+		if last := len(m.Ranges) - 1; last >= 0 && m.Ranges[last].End == line-1 &&
+			m.Ranges[last].Source == cur.Source && m.Ranges[last].Line == cur.Line && m.Ranges[last].Column == cur.Column {
+			m.Ranges[last].End = line
+			continue
+		}
+		r := *cur
+		r.Start, r.End = line, line
+		m.Ranges = append(m.Ranges, r)
+	}
+	return out.String(), m
+}
+
+// Lookup returns the .ual file and line that generated line n came from
+func (m *sourceMap) Lookup(n int) (string, int, bool) {
+	// Ranges are in order and do not overlap
+	lo, hi := 0, len(m.Ranges)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch r := m.Ranges[mid]; {
+		case n < r.Start:
+			hi = mid
+		case n > r.End:
+			lo = mid + 1
+		default:
+			return m.Sources[r.Source], r.Line, true
+		}
+	}
+	return "", 0, false
+}
+
+// JSON returns the map as indented JSON, naming file as the generated file
+func (m *sourceMap) JSON(file string) []byte {
+	named := *m
+	named.File = file
+	data, _ := json.MarshalIndent(&named, "", "  ")
+	return append(data, '\n')
+}
+
+// traceWriter copies a program's stderr to w, rewriting positions in the
+// generated file to the .ual positions they map to. It works a line at a
+// time; Flush writes any last line without a newline.
+type traceWriter struct {
+	w    io.Writer
+	m    backend.SourceMap
+	pos  *regexp.Regexp // file:line, with an optional :col
+	line []byte         // incomplete line
+}
+
+func newTraceWriter(w io.Writer, generated string, m backend.SourceMap) *traceWriter {
+	return &traceWriter{w: w, m: m, pos: regexp.MustCompile(regexp.QuoteMeta(generated) + `:(\d+)(:\d+)?`)}
+}
+
+func (t *traceWriter) Write(p []byte) (int, error) {
+	t.line = append(t.line, p...)
+	for {
+		i := bytes.IndexByte(t.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if _, err := t.w.Write(t.rewrite(t.line[:i+1])); err != nil {
+			return len(p), err
+		}
+		t.line = t.line[i+1:]
+	}
+}
+
+// Flush writes the incomplete last line, if any
+func (t *traceWriter) Flush() {
+	if len(t.line) > 0 {
+		t.w.Write(t.rewrite(t.line))
+		t.line = nil
+	}
+}
+
+func (t *traceWriter) rewrite(line []byte) []byte {
+	if t.m == nil {
+		return line
+	}
+	return t.pos.ReplaceAllFunc(line, func(match []byte) []byte {
+		sub := t.pos.FindSubmatch(match)
+		n, _ := strconv.Atoi(string(sub[1]))
+		if file, ualLine, ok := t.m.Lookup(n); ok {
+			return []byte(fmt.Sprintf("%s:%d", file, ualLine))
+		}
+		return match
+	})
+}
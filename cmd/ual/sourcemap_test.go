@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestSourceMap(t *testing.T) {
+	src := "func f() {\n  push:1\n}\n\npush:2 dot\nf()\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewCodeGen()
+	g.srcFile = "prog.ual"
+	out := g.Generate(prog)
+	if strings.Contains(out, sourceMarker) {
+		t.Fatal("generated code keeps source markers")
+	}
+
+	// Each ual line maps back from the generated line holding its code
+	lines := strings.Split(out, "\n")
+	for _, tt := range []struct {
+		code string
+		line int
+	}{
+		{"func f() {", 1},
+		{"stack_dstack.Push(intToBytes(1))", 2},
+		{"stack_dstack.Push(intToBytes(2))", 5},
+		{"f()", 6},
+	} {
+		n := 0
+		for i, l := range lines {
+			if strings.TrimSpace(l) == tt.code {
+				n = i + 1
+				break
+			}
+		}
+		if n == 0 {
+			t.Fatalf("no line %q in:\n%s", tt.code, out)
+		}
+		file, line, ok := g.sourceMap.Lookup(n)
+		if !ok || file != "prog.ual" || line != tt.line {
+			t.Errorf("line %d (%s) maps to %s:%d %v, want prog.ual:%d", n, tt.code, file, line, ok, tt.line)
+		}
+	}
+	if _, _, ok := g.sourceMap.Lookup(1); ok {
+		t.Error("package clause is mapped")
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	m := &sourceMap{Sources: []string{"prog.ual"}, Ranges: []sourceRange{{Start: 10, End: 12, Line: 4, Column: 1}}}
+	var b strings.Builder
+	w := newTraceWriter(&b, "/tmp/x/main.go", m)
+	// Written in pieces, as a pipe may deliver it
+	w.Write([]byte("main.main()\n\t/tmp/x/main.go:1"))
+	w.Write([]byte("1 +0x1d\n\t/tmp/x/main.go:40 +0x2\npanicked at /tmp/x/main.go:12:5"))
+	w.Flush()
+	want := "main.main()\n\tprog.ual:4 +0x1d\n\t/tmp/x/main.go:40 +0x2\npanicked at prog.ual:4"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
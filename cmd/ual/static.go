@@ -0,0 +1,66 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Static binaries.
+//
+// `ual build --static` builds a binary that needs nothing from the system
+// it runs on, for a scratch container or a machine without the C library
+// it was built against. The Go backend builds with CGO_ENABLED=0, so that
+// the net and os/user packages use their Go code rather than libc's; the
+// Rust backend builds for the musl target of the machine, which links the
+// C library in. Either way the binary is then checked: a static Linux
+// binary has no program interpreter (ld.so) and names no shared library,
+// and a build that produced anything else fails rather than ship it.
+
+// muslTargets are the Rust targets --static builds for, by GOARCH
+var muslTargets = map[string]string{
+	"amd64": "x86_64-unknown-linux-musl",
+	"arm64": "aarch64-unknown-linux-musl",
+	"386":   "i686-unknown-linux-musl",
+}
+
+// muslTarget returns the Rust musl target of this machine
+func muslTarget() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("--static builds Linux binaries, and this is %s", runtime.GOOS)
+	}
+	target, ok := muslTargets[runtime.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("--static: no musl target for %s", runtime.GOARCH)
+	}
+	return target, nil
+}
+
+// checkStatic returns an error if the binary at path needs a dynamic
+// linker or shared libraries to run
+func checkStatic(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return fmt.Errorf("--static: %s is not a Linux (ELF) binary", path)
+	}
+	defer f.Close()
+
+	var needs []string
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			interp := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(interp, 0); err == nil {
+				needs = append(needs, strings.TrimRight(string(interp), "\x00"))
+			} else {
+				needs = append(needs, "a dynamic linker")
+			}
+		}
+	}
+	libs, _ := f.ImportedLibraries() // no dynamic section is no libraries
+	needs = append(needs, libs...)
+	if len(needs) > 0 {
+		return fmt.Errorf("--static: %s is not statically linked: it needs %s", path, strings.Join(needs, ", "))
+	}
+	return nil
+}
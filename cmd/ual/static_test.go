@@ -0,0 +1,38 @@
+package main
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckStatic(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "prog")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkStatic(script); err == nil || !strings.Contains(err.Error(), "not a Linux (ELF) binary") {
+		t.Errorf("a script: %v", err)
+	}
+
+	// The shell links against libc nearly everywhere
+	sh, err := filepath.EvalSymlinks("/bin/sh")
+	if err != nil {
+		t.Skip("no /bin/sh")
+	}
+	f, err := elf.Open(sh)
+	if err != nil {
+		t.Skip("/bin/sh is not an ELF binary")
+	}
+	libs, _ := f.ImportedLibraries()
+	f.Close()
+	if len(libs) == 0 {
+		t.Skip("/bin/sh is statically linked")
+	}
+	err = checkStatic(sh)
+	if err == nil || !strings.Contains(err.Error(), "is not statically linked: it needs ") || !strings.Contains(err.Error(), libs[0]) {
+		t.Errorf("%s: %v, want it to need %s", sh, err, libs[0])
+	}
+}
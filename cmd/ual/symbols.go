@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 // Symbol represents a declared variable
 type Symbol struct {
@@ -9,15 +12,34 @@ type Symbol struct {
 	Index  int    // slot index on type stack (legacy) or unique ID
 	Scope  int    // scope depth
 	Native bool   // true = native Go variable, false = stack-based
+	Frame  bool   // declared in a function or codeblock frame
+	Global bool   // declared by var at the top level
+
+	predeclared bool // global known to functions, not yet declared in main
+}
+
+// Slot returns the Go variable holding the type stack of a stack-based
+// variable: the frame's own stack for locals of a function or codeblock,
+// the global type stack otherwise
+func (s *Symbol) Slot() string {
+	if s.Frame {
+		return "frame_" + TypeStack(s.Type)
+	}
+	return "stack_" + TypeStack(s.Type)
 }
 
 // SymbolTable tracks variables across scopes
 type SymbolTable struct {
 	symbols map[string]*Symbol // current scope lookup
 	scopes  []map[string]*Symbol // scope stack
-	indices map[string]int // next index per type stack
+	indices map[string]int // next index per type stack, by TypeStack
+	frames  []map[string]int // indices of the frames enclosing the current one
+	bases   []int            // scope depths the enclosing frames start at
+	assigned []map[string]bool // names plain assignments made Go variables, by scope
 	depth   int
 	varID   int // unique ID for native variables
+
+	predeclaring bool // globals are being predeclared, and visible
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -31,6 +53,7 @@ func NewSymbolTable() *SymbolTable {
 	// Push global scope
 	st.scopes = append(st.scopes, make(map[string]*Symbol))
 	st.symbols = st.scopes[0]
+	st.assigned = append(st.assigned, make(map[string]bool))
 	return st
 }
 
@@ -39,6 +62,7 @@ func (st *SymbolTable) Enter() {
 	st.depth++
 	newScope := make(map[string]*Symbol)
 	st.scopes = append(st.scopes, newScope)
+	st.assigned = append(st.assigned, make(map[string]bool))
 	// Merge parent symbols for lookup
 	st.symbols = make(map[string]*Symbol)
 	for _, scope := range st.scopes {
@@ -52,6 +76,7 @@ func (st *SymbolTable) Enter() {
 func (st *SymbolTable) Exit() {
 	if st.depth > 0 {
 		st.scopes = st.scopes[:len(st.scopes)-1]
+		st.assigned = st.assigned[:len(st.assigned)-1]
 		st.depth--
 		// Rebuild lookup
 		st.symbols = make(map[string]*Symbol)
@@ -63,8 +88,89 @@ func (st *SymbolTable) Exit() {
 	}
 }
 
+// EnterFrame pushes a new scope for the body of a function or codeblock.
+// Its variables live on type stacks made for each call, so their indices
+// start again from 0.
+func (st *SymbolTable) EnterFrame() {
+	st.frames = append(st.frames, st.indices)
+	st.indices = make(map[string]int)
+	st.Enter()
+	st.bases = append(st.bases, st.depth)
+}
+
+// ExitFrame pops the scope pushed by EnterFrame and returns the type
+// stacks its variables use, sorted
+func (st *SymbolTable) ExitFrame() []string {
+	used := map[string]bool{}
+	for typ, n := range st.indices {
+		if n > 0 {
+			used[TypeStack(typ)] = true
+		}
+	}
+	st.Exit()
+	st.indices = st.frames[len(st.frames)-1]
+	st.frames = st.frames[:len(st.frames)-1]
+	st.bases = st.bases[:len(st.bases)-1]
+	
+	var stacks []string
+	for ts := range used {
+		stacks = append(stacks, ts)
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+// Slot returns the Go variable holding the type stack that a variable of
+// typ declared in the current scope lives on
+func (st *SymbolTable) Slot(typ string) string {
+	return (&Symbol{Type: typ, Frame: len(st.frames) > 0}).Slot()
+}
+
+// DeclareGlobal records a global variable before the statement that
+// declares it is generated, so that functions generated first can use it.
+// Declare or DeclareNative at the top level then takes it over.
+func (st *SymbolTable) DeclareGlobal(name, typ string, native bool) {
+	if _, exists := st.scopes[0][name]; exists {
+		return // a redeclaration, reported when it is generated
+	}
+	sym := &Symbol{Name: name, Type: typ, Native: native, Global: true, predeclared: true}
+	if native {
+		sym.Index = st.varID
+		st.varID++
+	} else {
+		sym.Index = st.indices[TypeStack(typ)]
+		st.indices[TypeStack(typ)]++
+	}
+	st.scopes[0][name] = sym
+	if st.depth == 0 {
+		st.symbols[name] = sym
+	}
+}
+
+// takeGlobal returns the predeclared global name of typ, if the current
+// scope is the top level and it has one, marking it declared
+func (st *SymbolTable) takeGlobal(name, typ string, native bool) *Symbol {
+	sym := st.scopes[len(st.scopes)-1][name]
+	if sym == nil || !sym.predeclared || st.depth > 0 || sym.Native != native {
+		return nil
+	}
+	sym.predeclared = false
+	if sym.Type != typ {
+		// The type inferred where it is declared wins
+		sym.Type = typ
+		if !native {
+			sym.Index = st.indices[TypeStack(typ)]
+			st.indices[TypeStack(typ)]++
+		}
+	}
+	return sym
+}
+
 // Declare adds a variable to current scope, returns index
 func (st *SymbolTable) Declare(name, typ string) (int, error) {
+	if sym := st.takeGlobal(name, typ, false); sym != nil {
+		return sym.Index, nil
+	}
 	// Check for redeclaration in current scope
 	currentScope := st.scopes[len(st.scopes)-1]
 	if _, exists := currentScope[name]; exists {
@@ -72,8 +178,8 @@ func (st *SymbolTable) Declare(name, typ string) (int, error) {
 	}
 	
 	// Get next index for this type
-	idx := st.indices[typ]
-	st.indices[typ]++
+	idx := st.indices[TypeStack(typ)]
+	st.indices[TypeStack(typ)]++
 	
 	sym := &Symbol{
 		Name:   name,
@@ -81,6 +187,7 @@ func (st *SymbolTable) Declare(name, typ string) (int, error) {
 		Index:  idx,
 		Scope:  st.depth,
 		Native: false,
+		Frame:  len(st.frames) > 0,
 	}
 	
 	currentScope[name] = sym
@@ -91,6 +198,9 @@ func (st *SymbolTable) Declare(name, typ string) (int, error) {
 
 // DeclareNative adds a native Go variable to current scope
 func (st *SymbolTable) DeclareNative(name, typ string) (int, error) {
+	if sym := st.takeGlobal(name, typ, true); sym != nil {
+		return sym.Index, nil
+	}
 	// Check for redeclaration in current scope
 	currentScope := st.scopes[len(st.scopes)-1]
 	if _, exists := currentScope[name]; exists {
@@ -115,9 +225,32 @@ func (st *SymbolTable) DeclareNative(name, typ string) (int, error) {
 	return id, nil
 }
 
-// Lookup finds a symbol by name
+// Lookup finds a symbol by name. Outside functions, a global is only
+// found once the statement declaring it has been generated.
 func (st *SymbolTable) Lookup(name string) *Symbol {
-	return st.symbols[name]
+	sym := st.symbols[name]
+	if sym != nil && sym.predeclared && len(st.frames) == 0 && !st.predeclaring {
+		return nil
+	}
+	return sym
+}
+
+// Assign records a plain assignment to name, which is not a declared
+// variable, and reports whether an earlier one made it a Go variable
+// visible here. The body of a function or codeblock does not see those of
+// the code around it.
+func (st *SymbolTable) Assign(name string) bool {
+	base := 0
+	if len(st.bases) > 0 {
+		base = st.bases[len(st.bases)-1]
+	}
+	for d := len(st.assigned) - 1; d >= base; d-- {
+		if st.assigned[d][name] {
+			return true
+		}
+	}
+	st.assigned[len(st.assigned)-1][name] = true
+	return false
 }
 
 // CurrentScopeNatives returns names of native variables declared in current scope
@@ -134,6 +267,15 @@ func (st *SymbolTable) CurrentScopeNatives() []string {
 	return names
 }
 
+// valueType returns the type a value of typ is stored as. A fn value is
+// the handle of a codeblock registered with ual.NewFn, an i64.
+func valueType(typ string) string {
+	if typ == "fn" {
+		return "i64"
+	}
+	return typ
+}
+
 // TypeStack returns the stack name for a type
 func TypeStack(typ string) string {
 	switch typ {
@@ -153,3 +295,18 @@ func TypeStack(typ string) string {
 		return "bytes"
 	}
 }
+
+// nameCounter numbers the names a backend makes up, such as _fn1 for the
+// first codeblock value, counting each kind on its own. A program that
+// gains a select block then renumbers only its select blocks, so the
+// generated code of two versions of it differs where they differ.
+type nameCounter map[string]int
+
+// next returns the next number for a name of kind, from 1
+func (c *nameCounter) next(kind string) int {
+	if *c == nil {
+		*c = nameCounter{}
+	}
+	(*c)[kind]++
+	return (*c)[kind]
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Tail calls.
+//
+// A function that calls itself as the last thing it does, as in
+//
+//	func sum_to(n i64, acc i64) i64 {
+//	    if (n == 0) { return acc }
+//	    return sum_to(n - 1, acc + n)
+//	}
+//
+// has nothing left to do in the caller, so both backends generate its
+// body as a loop: the call assigns the arguments to the parameters, all
+// of them evaluated first, and starts the body again, so a tree walk or a
+// parser written in ual can recurse as deep as its input goes. Rust's
+// stack, unlike Go's, does not grow.
+//
+// A call is in tail position when it is returned, or in a function with no
+// result is the last statement or is followed by a bare return, in the
+// body or in the branches of its if statements. Its stack arguments must
+// be the function's own stack parameters, passed in the same place. A
+// function that defers, makes codeblocks or spawns keeps its calls, since
+// those would outlive the call they belong to, and so does one that can
+// fail or whose result is not returned on every path.
+
+// tailLabel is the loop a tail call continues
+const tailLabel = "tailcall"
+
+// tailCalls returns the statements of f that call f in tail position, or
+// nil if it has none or cannot be a loop
+func tailCalls(f *ast.FuncDecl) map[ast.Stmt]bool {
+	if f.CanFail || f.ReturnType != "" && !terminates(f.Body, nil) {
+		return nil
+	}
+	closes := false
+	walkNodes(reflect.ValueOf(f.Body), func(node interface{}) {
+		switch node.(type) {
+		case *ast.DeferStmt, *ast.FnLit, *ast.SpawnPush:
+			closes = true
+		}
+	})
+	if closes {
+		return nil
+	}
+	calls := map[ast.Stmt]bool{}
+	findTailCalls(f, f.Body, true, calls)
+	if len(calls) == 0 {
+		return nil
+	}
+	return calls
+}
+
+// findTailCalls adds the tail calls in list to calls. last is whether the
+// function ends when list does.
+func findTailCalls(f *ast.FuncDecl, list []ast.Stmt, last bool, calls map[ast.Stmt]bool) {
+	for i, s := range list {
+		end := last && i == len(list)-1
+		switch s := s.(type) {
+		case *ast.ReturnStmt:
+			if call, ok := s.Value.(*ast.FuncCall); ok && f.ReturnType != "" && selfCall(f, call) {
+				calls[s] = true
+			}
+		case *ast.FuncCall:
+			if f.ReturnType == "" && selfCall(f, s) && (end || i+1 < len(list) && bareReturn(list[i+1])) {
+				calls[s] = true
+			}
+		case *ast.IfStmt:
+			findTailCalls(f, s.Body, end, calls)
+			for _, e := range s.ElseIfs {
+				findTailCalls(f, e.Body, end, calls)
+			}
+			findTailCalls(f, s.Else, end, calls)
+		}
+	}
+}
+
+// selfCall reports whether call calls f, with each of f's stack
+// parameters passed as itself
+func selfCall(f *ast.FuncDecl, call *ast.FuncCall) bool {
+	if call.Name != f.Name || len(call.Args) != len(f.Params) {
+		return false
+	}
+	for i, p := range f.Params {
+		ref, ok := call.Args[i].(*ast.StackRef)
+		if p.Stack != ok || ok && ref.Name != p.Name {
+			return false
+		}
+	}
+	return true
+}
+
+func bareReturn(s ast.Stmt) bool {
+	r, ok := s.(*ast.ReturnStmt)
+	return ok && r.Value == nil && len(r.Values) == 0
+}
+
+// terminates reports whether list always ends in a return or in one of
+// calls
+func terminates(list []ast.Stmt, calls map[ast.Stmt]bool) bool {
+	if len(list) == 0 {
+		return false
+	}
+	switch s := list[len(list)-1].(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.IfStmt:
+		if !terminates(s.Body, calls) || !terminates(s.Else, calls) {
+			return false
+		}
+		for _, e := range s.ElseIfs {
+			if !terminates(e.Body, calls) {
+				return false
+			}
+		}
+		return true
+	}
+	return calls[list[len(list)-1]]
+}
+
+// tailArgs returns the value parameters of f and the arguments call
+// passes them; stack arguments are the parameters themselves
+func tailArgs(f *ast.FuncDecl, call *ast.FuncCall) ([]string, []ast.Expr) {
+	var names []string
+	var args []ast.Expr
+	for i, p := range f.Params {
+		if !p.Stack {
+			names = append(names, p.Name)
+			args = append(args, call.Args[i])
+		}
+	}
+	return names, args
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+func TestTailCalls(t *testing.T) {
+	for src, want := range map[string]int{
+		// Returned, and last or followed by a bare return
+		"func f(n i64) i64 {\n  if (n == 0) {\n    return 0\n  }\n  return f(n - 1)\n}\n":                    1,
+		"func f(n i64) {\n  if (n == 0) {\n    return\n  }\n  f(n - 1)\n}\n":                                 1,
+		"func f(n i64) {\n  if (n > 0) {\n    f(n - 1)\n    return\n  }\n  println(n)\n}\n":                  1,
+		"func f(n i64) {\n  if (n > 0) {\n    f(n - 1)\n  } else {\n    f(n + 1)\n  }\n}\n":                  2,
+		"func f(@s stack(i64), n i64) i64 {\n  if (n == 0) {\n    return 0\n  }\n  return f(@s, n - 1)\n}\n": 1,
+
+		// The call is not the last thing done
+		"func f(n i64) i64 {\n  if (n == 0) {\n    return 0\n  }\n  return f(n - 1) + 1\n}\n": 0,
+		"func f(n i64) {\n  if (n > 0) {\n    f(n - 1)\n  }\n  println(n)\n}\n":               0,
+		"func f(n i64) i64 {\n  f(n - 1)\n  return 0\n}\n":                                    0,
+
+		// Functions that stay recursive
+		"func f(@s stack(i64), @t stack(i64)) i64 {\n  return f(@t, @s)\n}\n":                               0,
+		"func f(n i64) i64 {\n  @defer < { println(n) }\n  return f(n - 1)\n}\n":                            0,
+		"func f(n i64) i64 {\n  if (n > 0) {\n    return f(n - 1)\n  }\n}\n":                                0,
+		"@error < func f(n i64) i64 {\n  if (n == 0) {\n    @error < \"zero\"\n  }\n  return f(n - 1)\n}\n": 0,
+		"func f(n i64) {\n  @spawn < {\n    println(n)\n  }\n  f(n - 1)\n}\n":                               0,
+	} {
+		prog := parseLibrary(t, src)
+		if got := len(tailCalls(prog.Stmts[0].(*ast.FuncDecl))); got != want {
+			t.Errorf("%s: %d tail calls, want %d", src, got, want)
+		}
+	}
+
+	src := "func sum_to(n i64, acc i64) i64 {\n  if (n == 0) {\n    return acc\n  }\n  return sum_to(n - 1, acc + n)\n}\nprintln(sum_to(10, 0))\n"
+	g := NewCodeGen()
+	out := g.Generate(parseLibrary(t, src))
+	if errs := g.getErrors(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	fn := out[strings.Index(out, "func sum_to("):strings.Index(out, "func main()")]
+	for _, want := range []string{"tailcall:\n\tfor {\n\t\tvar_n := ", "\t\tn, acc = ", "continue tailcall\n"} {
+		if !strings.Contains(fn, want) {
+			t.Errorf("Go lacks %q:\n%s", want, fn)
+		}
+	}
+	if strings.Count(fn, "sum_to(") != 1 {
+		t.Errorf("Go still recurses:\n%s", fn)
+	}
+
+	r := NewRustCodeGen()
+	out = r.Generate(parseLibrary(t, src))
+	for _, want := range []string{"let mut n = n;", "'tailcall: loop {", "(n, acc) = ((n - 1), (acc + n));", "continue 'tailcall;"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Rust lacks %q:\n%s", want, out)
+		}
+	}
+}
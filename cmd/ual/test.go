@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// ual test builds each _test.ual file under its paths as a program with
+// its test blocks in, after the library files beside it, and runs it.
+// Every test block reports through ual.RunTest to the file the runner
+// names in $UAL_TEST_REPORT, so a program that dies part way still
+// leaves the results of the tests before.
+
+// testFile is the outcome of one _test.ual file
+type testFile struct {
+	path   string
+	tests  []runtime.TestResult // in source order
+	output string               // what the program printed
+	err    error                // it did not compile, build or exit cleanly
+}
+
+func (f *testFile) failed() int {
+	n := 0
+	for _, t := range f.tests {
+		if !t.Passed {
+			n++
+		}
+	}
+	return n
+}
+
+func testCommand(args []string) {
+	format := "text"
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--format":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "error: --format requires an argument (text, tap or json)")
+				os.Exit(1)
+			}
+			i++
+			format = args[i]
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if format != "text" && format != "tap" && format != "json" {
+		fmt.Fprintf(os.Stderr, "error: --format must be 'text', 'tap' or 'json', got '%s'\n", format)
+		os.Exit(1)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "error: no _test.ual files in %s\n", strings.Join(paths, " "))
+		os.Exit(1)
+	}
+
+	targetLang = resolveTarget()
+	testMode = true
+	var results []testFile
+	for _, path := range files {
+		results = append(results, runTestFile(path))
+	}
+	switch format {
+	case "tap":
+		writeTAP(os.Stdout, results)
+	case "json":
+		writeTestJSON(os.Stdout, results)
+	default:
+		writeTestText(os.Stdout, results, verbosity >= verbVerbose)
+	}
+	for _, f := range results {
+		if f.err != nil || f.failed() > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// findTestFiles returns the _test.ual files named by paths, walking
+// directories and skipping those whose names start with a dot
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != p && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, "_test.ual") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// runTestFile compiles, builds and runs the tests of path
+func runTestFile(path string) testFile {
+	f := testFile{path: path}
+	prog, err := loadProgram(path)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	var tests []runtime.TestResult
+	for _, s := range prog.Stmts {
+		if t, ok := s.(*ast.TestBlock); ok && prog.Pos[s].File == "" {
+			tests = append(tests, runtime.TestResult{Name: t.Name, File: path, Line: prog.Pos[s].Line})
+		}
+	}
+	run, err := runHarness(prog, path, runtime.TestReportEnv)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	f.output = run.output
+	ran, err := readTestReport(run.report)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	exited := "program exited"
+	if run.status != 0 {
+		exited = fmt.Sprintf("program exited with status %d", run.status)
+	}
+	f.tests = tests
+	missing := false
+	for i, t := range f.tests {
+		if r, ok := ran[t.Line]; ok {
+			r.File = path
+			f.tests[i] = r
+			continue
+		}
+		f.tests[i].Message = exited + " before the test ended"
+		missing = true
+	}
+	if run.status != 0 && !missing {
+		f.err = errors.New(exited)
+	}
+	return f
+}
+
+// harnessRun is how a program built by ual test or ual bench ran
+type harnessRun struct {
+	report []byte // what it wrote to the report file
+	output string // and to stdout and stderr
+	status int    // the status it exited with
+}
+
+// runHarness generates prog, loaded from path, with the target's backend
+// and has the backend run it, naming a report file in reportEnv and
+// passing env
+func runHarness(prog *ast.Program, path, reportEnv string, env ...string) (harnessRun, error) {
+	var run harnessRun
+	tmpDir, err := os.MkdirTemp("", "ual-harness")
+	if err != nil {
+		return run, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	b := targetBackend()
+	opts := backendOptions(path)
+	report := filepath.Join(tmpDir, "report.jsonl")
+	opts.Env = append([]string{reportEnv + "=" + report}, env...)
+	var out bytes.Buffer
+	opts.Output = &out
+	code, err := b.Generate(prog, opts)
+	if err != nil {
+		return run, err
+	}
+	run.status, err = b.Run(code, opts, nil)
+	if err != nil {
+		if out.Len() > 0 {
+			err = fmt.Errorf("%v\n%s", err, strings.TrimSpace(out.String()))
+		}
+		return run, err
+	}
+	run.output = out.String()
+
+	run.report, err = os.ReadFile(report)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return run, err // with no file, nothing ran
+	}
+	return run, nil
+}
+
+// readTestReport reads the results a test program wrote, by line
+func readTestReport(data []byte) (map[int]runtime.TestResult, error) {
+	ran := make(map[int]runtime.TestResult)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var r runtime.TestResult
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("test report: %v", err)
+		}
+		ran[r.Line] = r
+	}
+	return ran, sc.Err()
+}
+
+// writeTestText writes the results for a terminal: a line per test, the
+// reason of each failure, and the program's output when a file failed or
+// verbose is set
+func writeTestText(w io.Writer, files []testFile, verbose bool) {
+	total, failed := 0, 0
+	for _, f := range files {
+		for _, t := range f.tests {
+			status := "ok  "
+			if !t.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "%s %s:%d %s (%.3fs)\n", status, f.path, t.Line, t.Name, t.Seconds)
+			if !t.Passed {
+				fmt.Fprintf(w, "     %s\n", t.Message)
+			}
+		}
+		if f.err != nil {
+			fmt.Fprintf(w, "FAIL %s\n", f.path)
+			for _, line := range strings.Split(f.err.Error(), "\n") {
+				fmt.Fprintf(w, "     %s\n", line)
+			}
+		}
+		total += len(f.tests)
+		failed += f.failed()
+		if f.output != "" && (verbose || f.err != nil || f.failed() > 0) {
+			fmt.Fprintf(w, "--- output of %s\n%s", f.path, f.output)
+			if !strings.HasSuffix(f.output, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+	}
+	errs := 0
+	for _, f := range files {
+		if f.err != nil {
+			errs++
+		}
+	}
+	switch {
+	case errs > 0:
+		fmt.Fprintf(w, "FAIL: %d of %d tests failed, %d of %d files did not run cleanly\n", failed, total, errs, len(files))
+	case failed > 0:
+		fmt.Fprintf(w, "FAIL: %d of %d tests failed\n", failed, total)
+	default:
+		fmt.Fprintf(w, "PASS: %d tests\n", total)
+	}
+}
+
+// writeTAP writes the results as TAP version 13. A file that did not run
+// cleanly is a failing test point of its own; program output is written
+// as comments.
+func writeTAP(w io.Writer, files []testFile) {
+	fmt.Fprintln(w, "TAP version 13")
+	n := 0
+	for _, f := range files {
+		for _, t := range f.tests {
+			n++
+			if t.Passed {
+				fmt.Fprintf(w, "ok %d - %s\n", n, t.Name)
+				continue
+			}
+			fmt.Fprintf(w, "not ok %d - %s\n", n, t.Name)
+			writeTAPDiagnostic(w, t.Message, fmt.Sprintf("%s:%d", f.path, t.Line))
+		}
+		if f.err != nil {
+			n++
+			fmt.Fprintf(w, "not ok %d - %s\n", n, f.path)
+			writeTAPDiagnostic(w, f.err.Error(), f.path)
+		}
+		if f.output != "" {
+			for _, line := range strings.Split(strings.TrimSuffix(f.output, "\n"), "\n") {
+				fmt.Fprintf(w, "# %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(w, "1..%d\n", n)
+}
+
+// writeTAPDiagnostic writes the YAML block of a failing test point
+func writeTAPDiagnostic(w io.Writer, message, at string) {
+	fmt.Fprintln(w, "  ---")
+	if strings.Contains(message, "\n") {
+		fmt.Fprintln(w, "  message: |")
+		for _, line := range strings.Split(message, "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	} else {
+		fmt.Fprintf(w, "  message: %q\n", message)
+	}
+	fmt.Fprintf(w, "  at: %q\n", at)
+	fmt.Fprintln(w, "  ...")
+}
+
+// testReport is the document ual test --format json writes
+type testReport struct {
+	Passed bool                 `json:"passed"`
+	Total  int                  `json:"total"`
+	Failed int                  `json:"failed"`
+	Tests  []runtime.TestResult `json:"tests"`
+	Errors []testFileError      `json:"errors,omitempty"`
+}
+
+// testFileError is a file that did not compile, build or exit cleanly
+type testFileError struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+	Output  string `json:"output,omitempty"`
+}
+
+func writeTestJSON(w io.Writer, files []testFile) {
+	r := testReport{Tests: []runtime.TestResult{}}
+	for _, f := range files {
+		r.Tests = append(r.Tests, f.tests...)
+		r.Total += len(f.tests)
+		r.Failed += f.failed()
+		if f.err != nil {
+			r.Errors = append(r.Errors, testFileError{File: f.path, Message: f.err.Error(), Output: f.output})
+		}
+	}
+	r.Passed = r.Failed == 0 && len(r.Errors) == 0
+	data, _ := json.MarshalIndent(r, "", "  ")
+	fmt.Fprintf(w, "%s\n", data)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/backend"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+func TestFindTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a_test.ual", "a.ual", "sub/b_test.ual", ".git/c_test.ual", "sub/main.ual"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := findTestFiles([]string{dir, filepath.Join(dir, "a.ual")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a_test.ual"), filepath.Join(dir, "sub/b_test.ual"), filepath.Join(dir, "a.ual")}
+	if strings.Join(files, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	if _, err := findTestFiles([]string{filepath.Join(dir, "nope")}); err == nil {
+		t.Error("a missing path should be an error")
+	}
+}
+
+// testResults are a passing test, a failing one and a file that did not
+// build
+var testResults = []testFile{
+	{
+		path: "a_test.ual",
+		tests: []runtime.TestResult{
+			{Name: "adds", File: "a_test.ual", Line: 1, Passed: true},
+			{Name: "subtracts", File: "a_test.ual", Line: 5, Message: "a_test.ual:6: assertion failed: two"},
+		},
+		output: "hello\n",
+	},
+	{path: "b_test.ual", err: errors.New("go build failed\nmain.go:1: oops")},
+}
+
+func TestTestText(t *testing.T) {
+	var b bytes.Buffer
+	writeTestText(&b, testResults, false)
+	want := `ok   a_test.ual:1 adds (0.000s)
+FAIL a_test.ual:5 subtracts (0.000s)
+     a_test.ual:6: assertion failed: two
+--- output of a_test.ual
+hello
+FAIL b_test.ual
+     go build failed
+     main.go:1: oops
+FAIL: 1 of 2 tests failed, 1 of 2 files did not run cleanly
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	// Output of a passing file shows only with -v
+	pass := []testFile{{path: "c_test.ual", tests: testResults[0].tests[:1], output: "quiet\n"}}
+	b.Reset()
+	writeTestText(&b, pass, false)
+	if want := "ok   c_test.ual:1 adds (0.000s)\nPASS: 1 tests\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	b.Reset()
+	writeTestText(&b, pass, true)
+	if !strings.Contains(b.String(), "--- output of c_test.ual\nquiet\n") {
+		t.Errorf("verbose output lacks the program's output:\n%s", b.String())
+	}
+}
+
+func TestTestTAP(t *testing.T) {
+	var b bytes.Buffer
+	writeTAP(&b, testResults)
+	want := `TAP version 13
+ok 1 - adds
+not ok 2 - subtracts
+  ---
+  message: "a_test.ual:6: assertion failed: two"
+  at: "a_test.ual:5"
+  ...
+# hello
+not ok 3 - b_test.ual
+  ---
+  message: |
+    go build failed
+    main.go:1: oops
+  at: "b_test.ual"
+  ...
+1..3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestTestJSON(t *testing.T) {
+	var b bytes.Buffer
+	writeTestJSON(&b, testResults)
+	var r testReport
+	if err := json.Unmarshal(b.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Passed || r.Total != 2 || r.Failed != 1 || len(r.Tests) != 2 || len(r.Errors) != 1 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if r.Errors[0].File != "b_test.ual" || !strings.HasPrefix(r.Errors[0].Message, "go build failed") {
+		t.Errorf("unexpected file error %+v", r.Errors[0])
+	}
+}
+
+// harnessBackend runs programs by writing a report for the test at line 3
+// and what it was run with, the way a generated program would
+type harnessBackend struct{}
+
+func (harnessBackend) Name() string     { return "harness-test" }
+func (harnessBackend) Ext() string      { return ".txt" }
+func (harnessBackend) Available() error { return nil }
+
+func (harnessBackend) Generate(prog *ast.Program, opts *backend.Options) (*backend.Output, error) {
+	return &backend.Output{Code: opts.Path}, nil
+}
+
+func (harnessBackend) Build(out *backend.Output, opts *backend.Options, binary string) error {
+	return errors.New("not built")
+}
+
+func (harnessBackend) Run(out *backend.Output, opts *backend.Options, args []string) (int, error) {
+	for _, kv := range opts.Env {
+		if report, ok := strings.CutPrefix(kv, runtime.TestReportEnv+"="); ok {
+			line := `{"name":"runs","line":3,"passed":true}` + "\n"
+			if err := os.WriteFile(report, []byte(line), 0644); err != nil {
+				return 0, err
+			}
+		}
+	}
+	fmt.Fprintf(opts.Output, "ran %s with %s\n", out.Code, strings.Join(opts.Env[1:], " "))
+	return 3, nil
+}
+
+func TestHarnessBackend(t *testing.T) {
+	backend.Register(harnessBackend{})
+	saved := targetLang
+	defer func() { targetLang = saved }()
+	targetLang = "harness-test"
+
+	run, err := runHarness(&ast.Program{}, "h_test.ual", runtime.TestReportEnv, "EXTRA=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.output != "ran h_test.ual with EXTRA=1\n" || run.status != 3 {
+		t.Errorf("output %q, status %d", run.output, run.status)
+	}
+	ran, err := readTestReport(run.report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := ran[3]; !ok || r.Name != "runs" || !r.Passed {
+		t.Errorf("report = %+v", ran)
+	}
+}
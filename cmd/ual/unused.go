@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Unused stack and variable warnings.
+//
+// unusedWarnings reports the stacks the program declares and never uses,
+// and the variables it declares with var and never reads. Names are
+// matched across the whole program rather than by scope, so a name read
+// anywhere counts as used: the warnings can miss dead code but never flag
+// live code. Variables set with "x = expr" are printed at the end of the
+// program and so are always read. Library statements are not checked.
+
+// unusedWarnings returns the warnings for prog, read from path, in source
+// order
+func unusedWarnings(prog *ast.Program, path string) []string {
+	u := &unusedAnalysis{
+		stacks: map[string]ast.Stmt{},
+		vars:   map[string]ast.Stmt{},
+		used:   map[string]bool{},
+		read:   map[string]bool{},
+	}
+	u.walk(reflect.ValueOf(prog.Stmts))
+	if u.allUsed {
+		u.stacks = nil
+	}
+
+	var warnings []warning
+	add := func(names map[string]ast.Stmt, seen map[string]bool, format string) {
+		for name, stmt := range names {
+			pos, ok := prog.Pos[stmt]
+			if seen[name] || !ok || pos.File != "" {
+				continue
+			}
+			pos.File = path
+			warnings = append(warnings, warning{pos, pos.String() + ": " + fmt.Sprintf(format, name)})
+		}
+	}
+	add(u.stacks, u.used, "stack @%s is declared but never used")
+	add(u.vars, u.read, "variable %s is assigned but never read")
+	return sortWarnings(warnings)
+}
+
+// warning is a diagnostic that does not stop the compile
+type warning struct {
+	pos ast.Pos
+	msg string
+}
+
+// sortWarnings returns the messages of warnings in source order
+func sortWarnings(warnings []warning) []string {
+	sort.Slice(warnings, func(a, b int) bool {
+		if warnings[a].pos.Line != warnings[b].pos.Line {
+			return warnings[a].pos.Line < warnings[b].pos.Line
+		}
+		return warnings[a].pos.Col < warnings[b].pos.Col
+	})
+
+	msgs := make([]string, len(warnings))
+	for i, w := range warnings {
+		msgs[i] = w.msg
+	}
+	return msgs
+}
+
+type unusedAnalysis struct {
+	stacks  map[string]ast.Stmt // user stacks, by first declaration
+	vars    map[string]ast.Stmt // var-declared variables, by first declaration
+	used    map[string]bool     // stacks named outside their declaration
+	read    map[string]bool     // identifiers read
+	allUsed bool                // runtime_stats() reads every stack
+}
+
+// walk visits every node under v
+func (u *unusedAnalysis) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			u.walk(v.Elem())
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		u.visit(v.Interface())
+		u.walk(v.Elem())
+	case reflect.Struct:
+		if c, ok := v.Interface().(ast.SelectCase); ok {
+			u.used[c.Stack] = true
+		}
+		for i := 0; i < v.NumField(); i++ {
+			u.walk(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			u.walk(v.Index(i))
+		}
+	}
+}
+
+// visit records the stacks and variables node declares or uses
+func (u *unusedAnalysis) visit(node interface{}) {
+	switch n := node.(type) {
+	case *ast.StackDecl:
+		if _, ok := u.stacks[n.Name]; !ok {
+			u.stacks[n.Name] = n
+		}
+	case *ast.VarDecl:
+		for _, name := range n.Names {
+			if _, ok := u.vars[name]; !ok {
+				u.vars[name] = n
+			}
+		}
+	case *ast.StackOp:
+		u.used[n.Stack] = true
+	case *ast.StackBlock:
+		u.used[n.Stack] = true
+	case *ast.StackExpr:
+		u.used[n.Stack] = true
+	case *ast.StackRef:
+		u.used[n.Name] = true
+	case *ast.ForStmt:
+		u.used[n.Stack] = true
+	case *ast.LetAssign:
+		u.used[n.Stack] = true
+	case *ast.ComputeStmt:
+		u.used[n.StackName] = true
+	case *ast.SelectStmt:
+		u.used[n.DefaultStack] = true
+	case *ast.SpawnPush:
+		u.used[n.Into] = true
+	case *ast.Ident:
+		u.read[n.Name] = true
+	case *ast.IndexExpr:
+		u.read[n.Target] = true
+	case *ast.FuncCall:
+		if n.Name == "runtime_stats" {
+			u.allUsed = true
+		}
+	}
+}
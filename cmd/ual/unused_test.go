@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestUnusedWarnings(t *testing.T) {
+	src := `@used = stack.new(i64)
+@dead = stack.new(i64)
+@ref = stack.new(i64)
+var a i64 = 1
+var b i64 = 2
+c = 3
+
+func f() {
+	var d i64 = 0
+	@used pop:d
+	push:a
+}
+
+@used push:1
+@used bring(@ref)
+f()
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"prog.ual:2:1: stack @dead is declared but never used",
+		"prog.ual:5:1: variable b is assigned but never read",
+		"prog.ual:9:2: variable d is assigned but never read",
+	}
+	if got := unusedWarnings(prog, "prog.ual"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestUnusedWarningsRuntimeStats(t *testing.T) {
+	src := "@a = stack.new(i64)\n@stats = stack.new(i64, Hash)\nruntime_stats(@stats)\n"
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unusedWarnings(prog, "prog.ual"); len(got) != 0 {
+		t.Errorf("runtime_stats() program warned: %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================================
+// Conformance (ual verify)
+//
+// Runs every program under the paths given with iual and the compiled
+// backends, as ual dev difffuzz runs its generated ones, and reports each
+// program whose output or exit status differs between them. Programs are
+// run with no input and no arguments; what they print to stderr is not
+// compared, as the backends word their errors differently.
+// ============================================================================
+
+// verifyCommand runs ual verify and exits with its status
+func verifyCommand(args []string) {
+	os.Exit(verify(args))
+}
+
+// verify returns 0 if the backends agreed on every program, 1 otherwise
+func verify(args []string) int {
+	var opts diffFuzzOptions
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--backends", "--iual":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: %s requires an argument\n", arg)
+				return 1
+			}
+			i++
+			if arg == "--iual" {
+				opts.iual = args[i]
+			} else {
+				opts.backends = strings.Split(args[i], ",")
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "error: unknown verify option: %s\n", arg)
+				return 1
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findPrograms(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no .ual programs found")
+		return 1
+	}
+	backends, err := fuzzBackends(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if len(backends) < 2 {
+		fmt.Fprintln(os.Stderr, "error: verify needs at least two working backends")
+		return 1
+	}
+
+	if verbosity >= verbNormal {
+		fmt.Fprintf(os.Stderr, "verify: %d programs, backends %s\n",
+			len(files), strings.Join(backendNames(backends), ", "))
+	}
+	divergent := 0
+	for _, path := range files {
+		results := make([]fuzzResult, len(backends))
+		for i, b := range backends {
+			results[i] = b.run(path)
+		}
+		if report := compareResults(backends, results); report != "" {
+			divergent++
+			fmt.Printf("%s: backends disagree\n%s", path, report)
+		} else if verbosity >= verbVerbose {
+			fmt.Fprintf(os.Stderr, "%s: ok\n", path)
+		}
+	}
+
+	fmt.Printf("verify: %d programs, %d divergent\n", len(files), divergent)
+	if divergent > 0 {
+		return 1
+	}
+	return 0
+}
+
+// findPrograms returns the .ual programs named by paths, walking
+// directories as findTestFiles does. Test files are left out, as they
+// hold tests rather than a program.
+func findPrograms(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != p && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ".ual") && !strings.HasSuffix(path, "_test.ual") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
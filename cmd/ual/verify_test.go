@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindPrograms(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.ual", "b_test.ual", "notes.txt", "sub/c.ual", ".git/d.ual"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("push:1 dot\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := findPrograms([]string{dir, filepath.Join(dir, "b_test.ual")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "a.ual"),
+		filepath.Join(dir, "sub/c.ual"),
+		filepath.Join(dir, "b_test.ual"), // named, so run
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v, want %v", got, want)
+	}
+	if _, err := findPrograms([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("a missing path should be an error")
+	}
+}
+
+func TestVerifyUsage(t *testing.T) {
+	for _, args := range [][]string{{"--bogus"}, {"--backends"}, {t.TempDir()}} {
+		if status := verify(args); status != 1 {
+			t.Errorf("verify %q: status %d, want 1", args, status)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/backend"
+	"github.com/ha1tch/ual/pkg/module"
+)
+
+// ual watch builds and runs a program, then builds and runs it again each
+// time its source changes: the program's file, the library files it
+// imports, and its project's ual.toml and ual.lock. Files are polled, so
+// that no platform's notification API is needed. A change starts a new
+// build once the files have been still for watchSettle, so an editor
+// writing a file in several steps builds once; a program still running
+// is stopped first.
+//
+// The program is built in-process, with the build cache, and run
+// directly, so stopping it stops nothing else. When a build fails its
+// errors are printed against those of the build before: an error that is
+// new is marked + and one that has gone is marked -.
+
+const (
+	watchPoll   = 200 * time.Millisecond
+	watchSettle = 100 * time.Millisecond
+)
+
+// watchCommand runs ual watch: args are the program, or its project
+// directory, and the program's arguments
+func watchCommand(args []string) {
+	path, ok := resolveInput(append([]string{"run"}, args...))
+	if !ok {
+		os.Exit(1)
+	}
+	var progArgs []string
+	if len(args) > 1 {
+		progArgs = args[1:]
+	}
+	targetLang = resolveTarget()
+	b := targetBackend()
+
+	tmpDir, err := os.MkdirTemp("", "ual-watch")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	binary := filepath.Join(tmpDir, "ual_program")
+
+	// ual watch ends on an interrupt, which the program gets as well
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		os.RemoveAll(tmpDir)
+		os.Exit(130)
+	}()
+
+	w := &watcher{path: path, color: colorTerminal(os.Stderr)}
+	for {
+		files, err := w.build(b, binary)
+		stamp := fileStamp(files)
+
+		var proc *exec.Cmd
+		done := make(chan error, 1)
+		if err != nil {
+			w.report(err)
+		} else {
+			w.report(nil)
+			proc = exec.Command(binary, progArgs...)
+			proc.Stdin = os.Stdin
+			proc.Stdout = os.Stdout
+			proc.Stderr = os.Stderr
+			if err := proc.Start(); err != nil {
+				w.status("running %s failed: %v", path, err)
+				proc = nil
+			} else {
+				go func() { done <- proc.Wait() }()
+			}
+		}
+		if proc == nil {
+			w.status("waiting for changes")
+		}
+
+		// Wait for a change, noting when the program ends
+		for {
+			select {
+			case err := <-done:
+				proc = nil
+				status, _ := exitStatus(err)
+				w.status("exited with status %d; waiting for changes", status)
+				continue
+			case <-time.After(watchPoll):
+			}
+			if fileStamp(files) == stamp {
+				continue
+			}
+			// Let the files settle before building
+			for now := fileStamp(files); ; {
+				time.Sleep(watchSettle)
+				next := fileStamp(files)
+				if next == now {
+					break
+				}
+				now = next
+			}
+			break
+		}
+		if proc != nil {
+			proc.Process.Kill()
+			<-done
+			w.status("stopped %s", path)
+		}
+	}
+}
+
+// watcher reports the builds of ual watch
+type watcher struct {
+	path   string
+	color  bool
+	files  []string // the files the program last loaded from
+	errors []string // the errors of the last build, nil if it succeeded
+}
+
+// build loads the program and builds it with b at binary. It returns the
+// files to watch: if the program did not load, those it last loaded from.
+func (w *watcher) build(b backend.Backend, binary string) ([]string, error) {
+	prog, err := loadProgram(w.path)
+	if err != nil {
+		if w.files == nil {
+			return []string{w.path}, err
+		}
+		return w.files, err
+	}
+	files := watchedFiles(prog, w.path)
+	w.files = files
+	opts := backendOptions(w.path)
+	out, err := b.Generate(prog, opts)
+	if err != nil {
+		return files, err
+	}
+	w.status("building %s", w.path)
+	return files, b.Build(out, opts, binary)
+}
+
+// status prints a line about what ual watch is doing
+func (w *watcher) status(format string, args ...any) {
+	if verbosity < verbNormal {
+		return
+	}
+	msg := "[watch] " + fmt.Sprintf(format, args...)
+	if w.color {
+		msg = "\x1b[2m" + msg + "\x1b[0m"
+	}
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// report prints the errors of a build, nil if it succeeded, against those
+// of the build before
+func (w *watcher) report(err error) {
+	if err == nil {
+		if w.errors != nil {
+			w.status("errors fixed")
+		}
+		w.errors = nil
+		return
+	}
+	var diags diagnostics
+	if !errors.As(err, &diags) {
+		diags = diagnostics{err.Error()}
+	}
+	writeErrorDiff(os.Stderr, w.errors, diags, w.color)
+	w.errors = diags
+	if w.errors == nil {
+		w.errors = []string{}
+	}
+}
+
+// writeErrorDiff writes the errors cur to out, at most maxErrors of them.
+// With the errors of the build before, prev, each error is marked + if
+// it is new, and those of prev that have gone follow, marked -.
+func writeErrorDiff(out io.Writer, prev, cur []string, color bool) {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return "\x1b[" + code + "m" + s + "\x1b[0m"
+	}
+	was := make(map[string]bool, len(prev))
+	for _, e := range prev {
+		was[e] = true
+	}
+	for i, e := range cur {
+		if maxErrors > 0 && i == maxErrors {
+			fmt.Fprintf(out, "error: too many errors (%d more)\n", len(cur)-i)
+			break
+		}
+		switch {
+		case prev == nil:
+			fmt.Fprintf(out, "error: %s\n", e)
+		case was[e]:
+			fmt.Fprintf(out, "  error: %s\n", e)
+		default:
+			fmt.Fprintln(out, paint("31", "+ error: "+e))
+		}
+	}
+	is := make(map[string]bool, len(cur))
+	for _, e := range cur {
+		is[e] = true
+	}
+	for _, e := range prev {
+		if !is[e] {
+			fmt.Fprintln(out, paint("32", "- error: "+e))
+		}
+	}
+}
+
+// watchedFiles returns the files a change to which changes prog, loaded
+// from path: path, the library files its statements came from, the files
+// they embed, and the manifest and lock of its project
+func watchedFiles(prog *ast.Program, path string) []string {
+	seen := map[string]bool{path: true}
+	for _, pos := range prog.Pos {
+		if pos.File != "" && !module.IsStd(pos.File) {
+			seen[pos.File] = true
+		}
+	}
+	for _, s := range prog.Stmts {
+		if d, ok := s.(*ast.StackDecl); ok && d.Embed != nil {
+			file := prog.Pos[s].File
+			if file == "" {
+				file = path
+			}
+			seen[filepath.Join(filepath.Dir(file), filepath.FromSlash(d.Embed.Path))] = true
+		}
+	}
+	if root, ok := module.FindRoot(filepath.Dir(path)); ok {
+		seen[filepath.Join(root, manifestName)] = true
+		seen[filepath.Join(root, module.LockName)] = true
+	}
+	files := make([]string, 0, len(seen))
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// fileStamp describes the state of files by their sizes and modification
+// times; a file that does not exist has a state too
+func fileStamp(files []string) string {
+	var b strings.Builder
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", f, info.Size(), info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s -\n", f)
+		}
+	}
+	return b.String()
+}
+
+// colorTerminal reports whether f is a terminal that takes colour
+func colorTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteErrorDiff(t *testing.T) {
+	var b strings.Builder
+	writeErrorDiff(&b, nil, []string{"a.ual:1:1: x"}, false)
+	if got, want := b.String(), "error: a.ual:1:1: x\n"; got != want {
+		t.Errorf("first build:\n%s\nwant:\n%s", got, want)
+	}
+
+	b.Reset()
+	writeErrorDiff(&b, []string{"a.ual:1:1: x", "a.ual:2:1: y"}, []string{"a.ual:1:1: x", "a.ual:3:1: z"}, false)
+	want := `  error: a.ual:1:1: x
++ error: a.ual:3:1: z
+- error: a.ual:2:1: y
+`
+	if got := b.String(); got != want {
+		t.Errorf("diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFileStamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.ual")
+	files := []string{path}
+	missing := fileStamp(files)
+	if err := os.WriteFile(path, []byte("push:1 dot\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written := fileStamp(files)
+	if written == missing {
+		t.Error("creating a file did not change its stamp")
+	}
+	if err := os.WriteFile(path, []byte("push:12 dot\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fileStamp(files) == written {
+		t.Error("rewriting a file did not change its stamp")
+	}
+}
+
+func TestWatchedFilesEmbed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "p.ual")
+	os.WriteFile(filepath.Join(dir, "t.txt"), []byte("hi"), 0644)
+	if err := os.WriteFile(path, []byte("embed \"t.txt\" as @t string\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := loadProgram(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := watchedFiles(prog, path)
+	if len(files) != 2 || files[0] != path || files[1] != filepath.Join(dir, "t.txt") {
+		t.Errorf("watched %v, want the program and t.txt", files)
+	}
+}
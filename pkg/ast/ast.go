@@ -1,6 +1,11 @@
 // Package ast defines the Abstract Syntax Tree types for ual.
 package ast
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Node is the base interface for all AST nodes.
 type Node interface {
 	node()
@@ -23,18 +28,77 @@ type Expr interface {
 // Program represents a complete ual program.
 type Program struct {
 	Stmts []Stmt
+	
+	// Pos records where each statement, nested ones included, starts in
+	// the source. Code generators use it for source maps.
+	Pos map[Stmt]Pos
 }
 
 func (p *Program) node() {}
 
+// Pos is a source position. File is empty for the program's own file and
+// names the library file for statements brought in by an import. Line and
+// Col count from 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+// String formats p as file:line:col for diagnostics, leaving out the parts
+// that are not known
+func (p Pos) String() string {
+	s := strconv.Itoa(p.Line)
+	if p.Col > 0 {
+		s += ":" + strconv.Itoa(p.Col)
+	}
+	if p.File != "" {
+		s = p.File + ":" + s
+	}
+	return s
+}
+
 // StackDecl: @name = stack.new(type, cap: n)
 // or: local @name = stack.new(type) inside spawn blocks
+// or: embed "file.txt" as @name at the top level
 type StackDecl struct {
 	Name        string
 	ElementType string
-	Perspective string // optional, defaults to LIFO
-	Capacity    int    // 0 = unlimited
-	Local       bool   // true for spawn-local stacks
+	Perspective string        // optional, defaults to LIFO
+	Capacity    int           // 0 = unlimited
+	Local       bool          // true for spawn-local stacks
+	Fields      []StructField // for ElementType "struct": stack.new({x: f64, y: f64})
+	Embed       *Embed        // for an embed declaration: the stack is filled from a file, then frozen
+}
+
+// StructField: one field of a struct element type
+type StructField struct {
+	Name string
+	Type string // i64, u64, f64 or bool
+}
+
+// Embed is the file an embed declaration fills its stack with:
+// embed "table.txt" as @table, optionally followed by string or lines
+type Embed struct {
+	Path  string // slash-separated, relative to the file declaring it
+	Lines bool   // one element per line, rather than the whole file
+	Data  string // the file's contents, read by module.Load
+}
+
+// Elements returns what the embedded file puts on the stack, in push
+// order: the whole file, or each line without its line ending.
+func (e *Embed) Elements() []string {
+	if !e.Lines {
+		return []string{e.Data}
+	}
+	lines := strings.SplitAfter(e.Data, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	}
+	return lines
 }
 
 func (s *StackDecl) node() {}
@@ -90,6 +154,38 @@ type VarDecl struct {
 func (v *VarDecl) node() {}
 func (v *VarDecl) stmt() {}
 
+// ImportStmt: import "github.com/user/lib"
+// Makes a library fetched with `ual get` available. Imports are resolved
+// before code generation, which replaces them with the library's statements.
+type ImportStmt struct {
+	Path string
+	Line int
+}
+
+func (i *ImportStmt) node() {}
+func (i *ImportStmt) stmt() {}
+
+// ArgsDecl: args "prog" { flag "verbose" v bool; opt "output" o string = "out.txt"; pos "input" string }
+// Declares command-line arguments; each one becomes a variable of the same
+// name (with '-' replaced by '_') and an entry in the @args Hash stack.
+type ArgsDecl struct {
+	Program string     // program name shown in usage, "" for argv[0]
+	Specs   []*ArgSpec
+}
+
+func (a *ArgsDecl) node() {}
+func (a *ArgsDecl) stmt() {}
+
+// ArgSpec is one entry of an args block.
+type ArgSpec struct {
+	Kind    string // "flag", "opt" or "pos"
+	Name    string // long name: --name for flags/opts, usage label for pos
+	Short   string // single-letter alias (-n), "" if none
+	Type    string // "bool", "string", "i64" or "f64"
+	Default Expr   // nil if none; a pos without default is required
+	Help    string // description shown in usage
+}
+
 // ArrayDecl: var buf[1024] (local fixed-size array in compute blocks)
 type ArrayDecl struct {
 	Name string
@@ -186,20 +282,35 @@ type ForStmt struct {
 func (f *ForStmt) node() {}
 func (f *ForStmt) stmt() {}
 
+// RangeStmt: for i in start..end { body }
+// Var counts from Start up to, but not including, End.
+type RangeStmt struct {
+	Var   string
+	Start Expr
+	End   Expr
+	Body  []Stmt
+}
+
+func (r *RangeStmt) node() {}
+func (r *RangeStmt) stmt() {}
+
 // FuncDecl: func name(params) returnType { body }
 // or: @error < func name(params) returnType { body }  -- can fail
 type FuncDecl struct {
 	Name       string
 	Params     []FuncParam
-	ReturnType string // "" for void
-	CanFail    bool   // true if @error < prefix
+	ReturnType string   // "" for void
+	CanFail    bool     // true if @error < prefix
+	TypeParams []string // element types named by stack parameters, as T in stack(T)
 	Body       []Stmt
 }
 
-// FuncParam represents a function parameter.
+// FuncParam represents a function parameter: name type, or @name stack(type)
+// for a stack passed by reference, where Type is its element type.
 type FuncParam struct {
-	Name string
-	Type string
+	Name  string
+	Type  string
+	Stack bool
 }
 
 func (f *FuncDecl) node() {}
@@ -232,6 +343,35 @@ type DeferStmt struct {
 func (d *DeferStmt) node() {}
 func (d *DeferStmt) stmt() {}
 
+// AtExitStmt: @atexit < { body }
+type AtExitStmt struct {
+	Body []Stmt // exit hook (code block pushed to the atexit stack)
+}
+
+func (a *AtExitStmt) node() {}
+func (a *AtExitStmt) stmt() {}
+
+// TestBlock: test "name" { body }
+// Runs only under `ual test`, which reports each block as a test; a failed
+// assert or expectation in the body fails it.
+type TestBlock struct {
+	Name string
+	Body []Stmt
+}
+
+func (t *TestBlock) node() {}
+func (t *TestBlock) stmt() {}
+
+// BenchBlock: bench "name" { body }
+// Runs only under `ual bench`, which times the body over many iterations.
+type BenchBlock struct {
+	Name string
+	Body []Stmt
+}
+
+func (b *BenchBlock) node() {}
+func (b *BenchBlock) stmt() {}
+
 // PanicStmt: panic or panic:msg or panic:expr
 type PanicStmt struct {
 	Value Expr // nil for bare panic (re-panic in recover)
@@ -278,22 +418,37 @@ type StatusStmt struct {
 func (s *StatusStmt) node() {}
 func (s *StatusStmt) stmt() {}
 
+// SelectKind is what a select case waits on
+type SelectKind int
+
+const (
+	SelectStack  SelectKind = iota // @stack {...}: an element on a stack
+	SelectTimer                    // every(ms) {...}: a periodic timer tick
+	SelectSignal                   // @signal SIGINT {...}: an OS signal
+)
+
 // SelectCase: one case in a select block
 // e.g. @inbox {|msg| handle(msg)} or @inbox {|msg| handle(msg) timeout(100, {|| retry()})}
+// or every(500) {|t| tick(t)} or @signal SIGINT {|sig| shutdown()}
 type SelectCase struct {
+	Kind      SelectKind
 	Stack     string   // stack to wait on ("" uses default from parent, "_" for default case)
+	Every     Expr     // timer interval in milliseconds (SelectTimer)
+	Signal    string   // signal name, e.g. "SIGINT" (SelectSignal)
 	Bindings  []string // variable names for received value: |msg| or |k,v|
 	Handler   []Stmt   // handler statements
 	TimeoutMs Expr     // optional timeout in milliseconds (nil = no timeout)
 	TimeoutFn *FnLit   // optional timeout handler closure
 }
 
-// SelectStmt: block.select( case, case, ... )
-// Waits on multiple stacks, first to yield data wins
+// SelectStmt: block.select( case, case, ... ) or block.select(fair: false, case, ...)
+// Waits on multiple stacks, first to yield data wins. When several are
+// ready one is picked at random, unless fair: false gives earlier cases priority.
 type SelectStmt struct {
 	Block        *StackBlock  // setup block (also provides default stack)
 	DefaultStack string       // stack name from setup block (for implicit cases)
 	Cases        []SelectCase // cases to match
+	Ordered      bool         // fair: false - try ready cases in declaration order
 }
 
 func (s *SelectStmt) node() {}
@@ -323,6 +478,7 @@ func (m *MemberExpr) expr() {}
 type IndexExpr struct {
 	Target string // variable name ("buf") or "self"
 	Index  Expr   // index expression
+	Field  string // self[i].x on a struct stack, else ""
 }
 
 func (i *IndexExpr) node() {}
@@ -348,9 +504,11 @@ func (e *ErrorPush) node() {}
 func (e *ErrorPush) stmt() {}
 
 // SpawnPush: @spawn < { block } — push codeblock to spawn queue
+// With "into @results", the value the block returns is pushed to @results
 type SpawnPush struct {
 	Params []string // parameter names for codeblock
 	Body   []Stmt   // codeblock body
+	Into   string   // result stack name, "" if none
 }
 
 func (s *SpawnPush) node() {}
@@ -358,9 +516,9 @@ func (s *SpawnPush) stmt() {}
 
 // SpawnOp: @spawn peek play, @spawn pop play, etc.
 type SpawnOp struct {
-	Op   string // "peek", "pop", "len", "clear"
+	Op   string // "peek", "pop", "len", "clear", "wait"
 	Play bool   // if true, execute the codeblock
-	Args []Expr // arguments for play()
+	Args []Expr // arguments for play(), or wait's timeout
 }
 
 func (s *SpawnOp) node() {}
@@ -420,6 +578,16 @@ type StringLit struct {
 func (s *StringLit) node() {}
 func (s *StringLit) expr() {}
 
+// InterpString: "value is ${x}"
+// Parts alternate between *StringLit text, which may be empty, and the
+// interpolated expressions, starting and ending with text.
+type InterpString struct {
+	Parts []Expr
+}
+
+func (s *InterpString) node() {}
+func (s *InterpString) expr() {}
+
 // StackRef: @name
 type StackRef struct {
 	Name string
@@ -508,6 +676,23 @@ type ViewExpr struct {
 func (v *ViewExpr) node() {}
 func (v *ViewExpr) expr() {}
 
+// RecordLit: {x: 1.0, y: 2.0} (a struct element)
+type RecordLit struct {
+	Names  []string
+	Values []Expr
+}
+
+func (r *RecordLit) node() {}
+func (r *RecordLit) expr() {}
+
+// ListLit: [a, b, c], the expected contents in expect_stack(@s, [...])
+type ListLit struct {
+	Elems []Expr
+}
+
+func (l *ListLit) node() {}
+func (l *ListLit) expr() {}
+
 // FnLit: anonymous function (codeblock)
 // Syntax: { body } or {|params| body }
 type FnLit struct {
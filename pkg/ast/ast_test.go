@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -13,6 +14,7 @@ func TestStmtInterface(t *testing.T) {
 		&StackOp{},
 		&StackBlock{},
 		&VarDecl{},
+		&ArgsDecl{},
 		&ArrayDecl{},
 		&IndexedAssignStmt{},
 		&LetAssign{},
@@ -504,3 +506,77 @@ func TestNestedStructures(t *testing.T) {
 		t.Errorf("expected 1 if-body statement, got %d", len(ifStmt.Body))
 	}
 }
+
+func TestPosString(t *testing.T) {
+	tests := []struct {
+		pos  Pos
+		want string
+	}{
+		{Pos{File: "lib.ual", Line: 3, Col: 5}, "lib.ual:3:5"},
+		{Pos{Line: 3, Col: 5}, "3:5"},
+		{Pos{File: "lib.ual", Line: 3}, "lib.ual:3"},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	prog := &Program{Stmts: []Stmt{
+		&FuncDecl{Name: "f", Body: []Stmt{&StackOp{Stack: "dstack", Op: "push", Args: []Expr{&IntLit{Value: 1}}}}},
+		&IfStmt{Condition: &Ident{Name: "x"}, Body: []Stmt{&BreakStmt{}}},
+	}}
+	var order []string
+	depth, maxDepth := 0, 0
+	Inspect(prog, func(n any) bool {
+		switch n := n.(type) {
+		case *FuncDecl:
+			order = append(order, "func "+n.Name)
+		case *StackOp:
+			order = append(order, n.Op)
+		case *IntLit:
+			order = append(order, "1")
+		case *IfStmt:
+			order = append(order, "if")
+			return false // skip the condition and body
+		case *BreakStmt:
+			order = append(order, "break")
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		return true
+	}, func(n any) {
+		if _, ok := n.(*IfStmt); !ok {
+			depth--
+		}
+	})
+	if got := strings.Join(order, ","); got != "func f,push,1,if" {
+		t.Errorf("visited %s", got)
+	}
+	if depth != 0 || maxDepth != 4 { // program, func, op, literal
+		t.Errorf("depth %d after the walk, max %d", depth, maxDepth)
+	}
+}
+
+func TestFprint(t *testing.T) {
+	prog := &Program{Stmts: []Stmt{
+		&StackOp{Stack: "dstack", Op: "push", Args: []Expr{&BinaryOp{Op: "+", Left: &IntLit{Value: 1}, Right: &Ident{Name: "x"}}}},
+		&BreakStmt{},
+	}}
+	var b strings.Builder
+	Fprint(&b, prog)
+	want := `Program
+  StackOp: @dstack.push
+    BinaryOp: +
+      IntLit: 1
+      Ident: x
+  <*ast.BreakStmt>
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
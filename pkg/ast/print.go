@@ -0,0 +1,111 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint writes an indented outline of the tree below node to w, a line
+// per node, as ual ast and the iual REPL's :ast show it. Node types it
+// does not know are written as their Go type.
+func Fprint(w io.Writer, node any) {
+	fprint(w, node, 0)
+}
+
+func fprint(w io.Writer, node any, indent int) {
+	prefix := strings.Repeat("  ", indent)
+
+	switch n := node.(type) {
+	case *Program:
+		fmt.Fprintf(w, "%sProgram\n", prefix)
+		for _, stmt := range n.Stmts {
+			fprint(w, stmt, indent+1)
+		}
+
+	case *StackDecl:
+		fmt.Fprintf(w, "%sStackDecl: @%s : %s (%s, cap=%d)\n",
+			prefix, n.Name, n.ElementType, n.Perspective, n.Capacity)
+		if n.Embed != nil {
+			fmt.Fprintf(w, "%s  embed %q (%d bytes)\n", prefix, n.Embed.Path, len(n.Embed.Data))
+		}
+
+	case *ViewDecl:
+		fmt.Fprintf(w, "%sViewDecl: %s : %s\n", prefix, n.Name, n.Perspective)
+
+	case *Assignment:
+		fmt.Fprintf(w, "%sAssignment: %s =\n", prefix, n.Name)
+		fprint(w, n.Expr, indent+1)
+
+	case *StackOp:
+		fmt.Fprintf(w, "%sStackOp: @%s.%s\n", prefix, n.Stack, n.Op)
+		for _, arg := range n.Args {
+			fprint(w, arg, indent+1)
+		}
+
+	case *StackBlock:
+		fmt.Fprintf(w, "%sStackBlock: @%s\n", prefix, n.Stack)
+		for _, op := range n.Ops {
+			fprint(w, op, indent+1)
+		}
+
+	case *ViewOp:
+		fmt.Fprintf(w, "%sViewOp: %s.%s\n", prefix, n.View, n.Op)
+		for _, arg := range n.Args {
+			fprint(w, arg, indent+1)
+		}
+
+	case *IntLit:
+		fmt.Fprintf(w, "%sIntLit: %d\n", prefix, n.Value)
+
+	case *FloatLit:
+		fmt.Fprintf(w, "%sFloatLit: %f\n", prefix, n.Value)
+
+	case *StringLit:
+		fmt.Fprintf(w, "%sStringLit: %q\n", prefix, n.Value)
+
+	case *InterpString:
+		fmt.Fprintf(w, "%sInterpString:\n", prefix)
+		for _, part := range n.Parts {
+			fprint(w, part, indent+1)
+		}
+
+	case *StackRef:
+		fmt.Fprintf(w, "%sStackRef: @%s\n", prefix, n.Name)
+
+	case *Ident:
+		fmt.Fprintf(w, "%sIdent: %s\n", prefix, n.Name)
+
+	case *PerspectiveLit:
+		fmt.Fprintf(w, "%sPerspective: %s\n", prefix, n.Value)
+
+	case *TypeLit:
+		fmt.Fprintf(w, "%sType: %s\n", prefix, n.Value)
+
+	case *BinaryOp:
+		fmt.Fprintf(w, "%sBinaryOp: %s\n", prefix, n.Op)
+		fprint(w, n.Left, indent+1)
+		fprint(w, n.Right, indent+1)
+
+	case *StackExpr:
+		fmt.Fprintf(w, "%sStackExpr: @%s.%s\n", prefix, n.Stack, n.Op)
+		for _, arg := range n.Args {
+			fprint(w, arg, indent+1)
+		}
+
+	case *ViewExpr:
+		fmt.Fprintf(w, "%sViewExpr: %s.%s\n", prefix, n.View, n.Op)
+		for _, arg := range n.Args {
+			fprint(w, arg, indent+1)
+		}
+
+	case *FnLit:
+		fmt.Fprintf(w, "%sFnLit: (%s)\n", prefix, strings.Join(n.Params, ", "))
+		for _, stmt := range n.Body {
+			fprint(w, stmt, indent+1)
+		}
+
+	default:
+		fmt.Fprintf(w, "%s<%T>\n", prefix, node)
+	}
+}
@@ -0,0 +1,51 @@
+package ast
+
+import "reflect"
+
+// Inspect walks the AST below n by reflection, so new node types are
+// covered without changes here. pre is called on entering each node, and
+// its children are skipped if it returns false; post, if given, is called
+// on leaving it.
+func Inspect(n any, pre func(any) bool, post func(any)) {
+	walkValue(reflect.ValueOf(n), pre, post)
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+func walkValue(v reflect.Value, pre func(any) bool, post func(any)) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkValue(v.Elem(), pre, post)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Type().Implements(nodeType) {
+			n := v.Interface()
+			if !pre(n) {
+				if post != nil {
+					post(n)
+				}
+				return
+			}
+			walkValue(v.Elem(), pre, post)
+			if post != nil {
+				post(n)
+			}
+			return
+		}
+		walkValue(v.Elem(), pre, post)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				walkValue(v.Field(i), pre, post)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkValue(v.Index(i), pre, post)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package backend is the interface between the ual compiler and the
+// targets it generates code for.
+//
+// ual compile, build and run look the target named by --target up among
+// the registered backends, and leave generating, building and running the
+// program to it. The Go and Rust backends register themselves from
+// cmd/ual. Another backend registers from the init function of its own
+// package:
+//
+//	func init() {
+//		backend.Register(acmeBackend{})
+//	}
+//
+// and is linked in by a blank import in a file added to cmd/ual:
+//
+//	import _ "example.com/acme/ualtarget"
+//
+// after which --target acme, and target = "acme" in ual.toml, select it.
+package backend
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Backend generates, builds and runs programs for one target.
+type Backend interface {
+	// Name is the name --target selects the backend by.
+	Name() string
+
+	// Ext is the extension, with its dot, of the file ual compile writes.
+	Ext() string
+
+	// Available returns why the target's toolchain cannot be used, or nil
+	// if it can.
+	Available() error
+
+	// Generate generates code for prog, loaded and checked from opts.Path.
+	Generate(prog *ast.Program, opts *Options) (*Output, error)
+
+	// Build builds out as an executable at binary, an absolute path.
+	Build(out *Output, opts *Options, binary string) error
+
+	// Run builds and runs out with args, connected to the standard
+	// streams or to opts.Output, and returns the program's exit status.
+	// The error is for a program that could not be built or started. ual
+	// test and ual bench run their programs with it too.
+	Run(out *Output, opts *Options, args []string) (int, error)
+}
+
+// Options are the compiler flags a backend generates and builds with.
+type Options struct {
+	Path       string // the program's .ual file
+	NoForth    bool   // no default stacks
+	Optimize   bool   // native int64 dstack
+	Workers    int    // the most @spawn tasks run at once, 0 for the default
+	CrashDump  string // the directory crash reports go to, "" for none
+	Checked    bool   // panic on stack underflow
+	Profile    string // "release", "small" or "debug"
+	Strip      bool   // strip symbols from the binary
+	Static     bool   // link nothing dynamically, and check that the binary does not
+	KeepSource string // the directory to build the generated project in, "" for a temporary one
+	Library    bool   // ual compile --lib: generate a library for other code to use, not a program
+	Verbosity  int    // 0 quiet, 1 normal, 2 verbose, 3 debug
+	Bench      bool   // ual bench: build the runtime to count allocations in bench blocks
+
+	// For ual test and ual bench, which read what the program reports
+	Env    []string  // added to the environment Run runs the program in
+	Output io.Writer // where Run sends the build's and the program's output, with no stdin; nil for the standard streams
+}
+
+// Output is a generated program.
+type Output struct {
+	Code string
+	Map  SourceMap // nil if the backend keeps none
+}
+
+// SourceMap maps lines of generated code back to the .ual source.
+type SourceMap interface {
+	// Lookup returns the .ual file and line generated line n came from.
+	Lookup(n int) (file string, line int, ok bool)
+
+	// JSON returns the map as JSON, naming file as the generated file.
+	JSON(file string) []byte
+}
+
+var (
+	mu       sync.RWMutex
+	backends = make(map[string]Backend)
+)
+
+// Register makes b available by its name. It panics if a backend of that
+// name is already registered.
+func Register(b Backend) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := b.Name()
+	if name == "" {
+		panic("backend: Register of a backend with no name")
+	}
+	if _, dup := backends[name]; dup {
+		panic(fmt.Sprintf("backend: Register called twice for %s", name))
+	}
+	backends[name] = b
+}
+
+// Lookup returns the backend registered as name.
+func Lookup(name string) (Backend, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	b, ok := backends[name]
+	return b, ok
+}
+
+// Names returns the names of the registered backends, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
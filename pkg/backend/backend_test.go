@@ -0,0 +1,38 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+type fake string
+
+func (f fake) Name() string                                   { return string(f) }
+func (fake) Ext() string                                      { return ".txt" }
+func (fake) Available() error                                 { return nil }
+func (fake) Generate(*ast.Program, *Options) (*Output, error) { return &Output{}, nil }
+func (fake) Build(*Output, *Options, string) error            { return nil }
+func (fake) Run(*Output, *Options, []string) (int, error)     { return 0, nil }
+
+func TestRegister(t *testing.T) {
+	Register(fake("zz-test"))
+	Register(fake("aa-test"))
+	if b, ok := Lookup("zz-test"); !ok || b.Name() != "zz-test" {
+		t.Errorf("Lookup(zz-test) = %v, %v", b, ok)
+	}
+	if _, ok := Lookup("missing"); ok {
+		t.Error("found an unregistered backend")
+	}
+	if got, want := Names(), []string{"aa-test", "zz-test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	Register(fake("aa-test"))
+}
@@ -0,0 +1,199 @@
+// Package check finds mistakes in a ual program without running it.
+//
+// A check lexes, parses and resolves imports, then looks for stacks and
+// functions that are used but never declared and for calls with the wrong
+// number of arguments: mistakes iual would otherwise only report when it
+// got there. iual --check and the language server (cmd/ual-lsp) are built
+// on it.
+package check
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/module"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+// Diagnostic is one problem found by a check
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	case d.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.File, d.Message)
+}
+
+// BuiltinFuncs are the functions iual provides, callable without a
+// declaration
+var BuiltinFuncs = map[string]bool{
+	"abs": true, "advance_time": true, "appendfile": true, "apply": true, "args": true, "assert": true, "atoi": true,
+	"bool": true, "call": true, "clear_line": true, "color": true, "confirm": true, "cos": true,
+	"env": true, "exec": true, "exists": true, "exit": true, "expect_output": true, "expect_stack": true, "float": true,
+	"format": true, "format_float": true, "format_int": true, "freeze_time": true, "int": true,
+	"is_tty": true, "itoa": true, "len": true, "listen": true, "max": true, "min": true,
+	"mock": true, "password": true, "pow": true, "print": true,
+	"printf": true, "progress": true, "prompt": true, "query": true, "rand": true, "rand_int": true, "read_csv": true, "readfile": true, "readline": true, "render": true,
+	"runtime_stats": true, "seed": true, "seq": true, "setenv": true, "sin": true, "sprintf": true,
+	"sqrt": true, "string": true, "ulid": true, "uuid4": true,
+	"wait_timers": true, "write_csv": true, "writefile": true,
+}
+
+// BuiltinStacks exist in every program
+var BuiltinStacks = []string{"dstack", "rstack", "error", "bool", "spawn", "defer"}
+
+// Source checks the program in source, read from path, without running
+// it and returns every problem found
+func Source(path, source string) []Diagnostic {
+	var diags []Diagnostic
+	tokens := lexer.NewLexer(source).Tokenize()
+	for _, tok := range tokens {
+		if tok.Type == lexer.TokError {
+			diags = append(diags, Diagnostic{path, tok.Line, tok.Column, "lexer error: " + tok.Value})
+		}
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+
+	prog, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		var list parser.ErrorList
+		if !errors.As(err, &list) {
+			return []Diagnostic{{File: path, Message: "parse error: " + err.Error()}}
+		}
+		for _, e := range list {
+			diags = append(diags, Diagnostic{path, e.Pos.Line, e.Pos.Col, "parse error: " + e.Msg})
+		}
+		return diags
+	}
+	if err := module.Load(prog, path); err != nil {
+		return []Diagnostic{{File: path, Message: err.Error()}}
+	}
+	return Program(path, prog)
+}
+
+// Program reports undeclared stacks and functions, and calls to
+// declared functions with the wrong number of arguments
+func Program(path string, prog *ast.Program) []Diagnostic {
+	stacks := make(map[string]bool)
+	for _, name := range BuiltinStacks {
+		stacks[name] = true
+	}
+	funcs := make(map[string]*ast.FuncDecl)
+	ast.Inspect(prog, func(n any) bool {
+		switch n := n.(type) {
+		case *ast.StackDecl:
+			stacks[n.Name] = true
+		case *ast.ArgsDecl:
+			stacks["args"] = true
+		case *ast.FuncDecl:
+			funcs[n.Name] = n
+			for _, p := range n.Params {
+				if p.Stack {
+					stacks[p.Name] = true
+				}
+			}
+		}
+		return true
+	}, nil)
+
+	c := &checker{path: path, pos: prog.Pos, stacks: stacks, funcs: funcs}
+	for _, s := range prog.Stmts {
+		c.check(s)
+	}
+	sort.SliceStable(c.diags, func(a, b int) bool {
+		if c.diags[a].File != c.diags[b].File {
+			return c.diags[a].File < c.diags[b].File
+		}
+		return c.diags[a].Line < c.diags[b].Line
+	})
+	return c.diags
+}
+
+type checker struct {
+	path   string
+	pos    map[ast.Stmt]ast.Pos
+	stacks map[string]bool
+	funcs  map[string]*ast.FuncDecl
+	diags  []Diagnostic
+}
+
+// check reports the problems in one top-level statement, positioned at the
+// innermost statement that contains them
+func (c *checker) check(stmt ast.Stmt) {
+	var at []ast.Pos // enclosing statement positions
+	ast.Inspect(stmt, func(n any) bool {
+		if s, ok := n.(ast.Stmt); ok {
+			if p, ok := c.pos[s]; ok {
+				at = append(at, p)
+			}
+		}
+		if _, ok := n.(*ast.ComputeStmt); ok {
+			return false // compute bodies have their own math builtins
+		}
+		here := ast.Pos{}
+		if len(at) > 0 {
+			here = at[len(at)-1]
+		}
+		switch n := n.(type) {
+		case *ast.StackOp:
+			c.stack(here, n.Stack)
+		case *ast.StackRef:
+			c.stack(here, n.Name)
+		case *ast.StackExpr:
+			c.stack(here, n.Stack)
+		case *ast.LetAssign:
+			c.stack(here, n.Stack)
+		case *ast.ForStmt:
+			c.stack(here, n.Stack)
+		case *ast.FuncCall:
+			c.call(here, n.Name, len(n.Args))
+		case *ast.CallExpr:
+			c.call(here, n.Fn, len(n.Args))
+		}
+		return true
+	}, func(n any) {
+		if s, ok := n.(ast.Stmt); ok {
+			if _, ok := c.pos[s]; ok {
+				at = at[:len(at)-1]
+			}
+		}
+	})
+}
+
+func (c *checker) stack(at ast.Pos, name string) {
+	if name != "" && !c.stacks[name] {
+		c.report(at, fmt.Sprintf("undefined stack: @%s", name))
+	}
+}
+
+func (c *checker) call(at ast.Pos, name string, nargs int) {
+	fn, ok := c.funcs[name]
+	switch {
+	case ok && len(fn.Params) != nargs:
+		c.report(at, fmt.Sprintf("%s takes %d arguments, called with %d", name, len(fn.Params), nargs))
+	case !ok && !BuiltinFuncs[name]:
+		c.report(at, fmt.Sprintf("undefined function: %s", name))
+	}
+}
+
+func (c *checker) report(at ast.Pos, msg string) {
+	file := at.File
+	if file == "" {
+		file = c.path
+	}
+	c.diags = append(c.diags, Diagnostic{File: file, Line: at.Line, Column: at.Col, Message: msg})
+}
@@ -0,0 +1,53 @@
+package check
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob("../../examples/*.ual")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	for _, f := range files {
+		source, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range Source(f, string(source)) {
+			t.Errorf("unexpected diagnostic: %s", d)
+		}
+	}
+}
+
+func TestProblems(t *testing.T) {
+	source := `@s = stack.new(i64)
+func sum2(a i64, b i64) i64 { return a + b }
+@s push:1
+@t push:2
+var x i64 = sum2(1)
+nosuch(3)
+`
+	want := []string{
+		"prog.ual:4:1: undefined stack: @t",
+		"prog.ual:5:1: sum2 takes 2 arguments, called with 1",
+		"prog.ual:6:1: undefined function: nosuch",
+	}
+	diags := Source("prog.ual", source)
+	if len(diags) != len(want) {
+		t.Fatalf("got %v, want %v", diags, want)
+	}
+	for i, d := range diags {
+		if d.String() != want[i] {
+			t.Errorf("diagnostic %d = %q, want %q", i, d, want[i])
+		}
+	}
+
+	diags = Source("prog.ual", "@s = stack.new(i64)\nwhile {\n}\n")
+	if len(diags) != 1 || diags[0].Line != 2 || diags[0].Column != 7 || !strings.HasPrefix(diags[0].Message, "parse error") {
+		t.Errorf("parse error: got %v", diags)
+	}
+}
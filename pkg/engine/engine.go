@@ -0,0 +1,169 @@
+// Package engine runs ual programs inside Go applications, for ual as a
+// scripting language:
+//
+//	prog, err := engine.Compile(`
+//	    var n i64 = 0
+//	    @jobs take:n
+//	    @results push(n * n)
+//	`)
+//	if err != nil {
+//	    return err
+//	}
+//	env := engine.NewEnv()
+//	jobs := env.Stack("jobs", "i64", runtime.FIFO)
+//	results := env.Stack("results", "i64", runtime.FIFO)
+//	jobs.Push(runtime.NewInt(7))
+//	err = prog.Run(ctx, engine.Stdio{Stdout: &out}, env)
+//	v, _ := results.Pop() // 49
+//
+// A Program is compiled once and may be run any number of times, each run
+// with stacks, variables and functions of its own. It runs on the
+// interpreter iual uses (see pkg/interp), in the application's process:
+// the stacks the application adds are shared with it as they are, so the
+// application can push and pop them while it runs.
+//
+// What the runtime holds for the whole process is shared by every run:
+// the exit hooks, which the first run to end runs, the random sequence,
+// the clock freeze_time stops and the bridges opened.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/interp"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/module"
+	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// scriptName names the program in errors, as "<repl>" does in iual's REPL
+const scriptName = "<script>"
+
+// ExitError is what Run returns when the program calls exit with a
+// status other than 0.
+type ExitError = interp.ExitError
+
+// Program is a compiled ual program.
+type Program struct {
+	prog *ast.Program
+}
+
+// Compile parses source, and loads the libraries it imports, relative to
+// the working directory. The error lists every problem found.
+func Compile(source string) (*Program, error) {
+	tokens := lexer.NewLexer(source).Tokenize()
+	var problems []string
+	for _, tok := range tokens {
+		if tok.Type == lexer.TokError {
+			problems = append(problems, fmt.Sprintf("%s:%d:%d: lexer error: %s", scriptName, tok.Line, tok.Column, tok.Value))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	prog, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		return nil, errors.New(strings.Join(parser.Diagnostics(scriptName, err), "\n"))
+	}
+	if err := module.Load(prog, scriptName); err != nil {
+		return nil, fmt.Errorf("%s: %v", scriptName, err)
+	}
+	return &Program{prog: prog}, nil
+}
+
+// Stdio is where a running program reads and writes: readline and prompt
+// read Stdin, print writes Stdout, and the errors of spawned tasks go to
+// Stderr. A nil field leaves the process's own; output sent elsewhere is
+// not a terminal, so color, clear_line and progress write no control
+// sequences to it.
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Env is what a program runs with besides its source.
+type Env struct {
+	// Args are the arguments args blocks and args() see.
+	Args []string
+	// Vars are the variables env() sees, and setenv() sets, in place of
+	// the process environment, which they see if Vars is nil.
+	Vars map[string]string
+
+	stacks []hostStack
+}
+
+// hostStack is a stack the application adds to a program
+type hostStack struct {
+	name, elemType string
+	stack          *runtime.ValueStack
+}
+
+// NewEnv returns an Env with no arguments and the process environment.
+func NewEnv() *Env {
+	return &Env{}
+}
+
+// Stack makes a stack of the perspective p, holding elemType elements
+// ("i64", "f64", "string" and so on, as stack.new takes), that programs run
+// with env see as @name, and returns it. A program that declares @name
+// replaces it with its own.
+func (env *Env) Stack(name, elemType string, p runtime.Perspective) *runtime.ValueStack {
+	s := runtime.NewValueStack(p)
+	env.AddStack(name, elemType, s)
+	return s
+}
+
+// AddStack is Stack for a stack the application already has.
+func (env *Env) AddStack(name, elemType string, s *runtime.ValueStack) {
+	env.stacks = append(env.stacks, hostStack{name, elemType, s})
+}
+
+// Run runs the program until it ends, calls exit, fails or ctx is done,
+// with the streams in stdio and what env holds, which may be nil. A
+// program stopped by ctx stops at the statement it is running, or at the
+// next iteration of its loop; one waiting in take or sleep stops when it
+// wakes. Run returns nil if the program ends or calls exit(0), an
+// *ExitError for any other exit status, ctx's error if it was stopped,
+// and the program's error otherwise.
+func (p *Program) Run(ctx context.Context, stdio Stdio, env *Env) error {
+	i := interp.NewInterpreter()
+	i.SetFilename(scriptName)
+	i.SetExitReturns(true)
+	if stdio.Stdin != nil {
+		i.SetInput(stdio.Stdin)
+	}
+	i.SetOutput(stdio.Stdout, stdio.Stderr)
+	if env != nil {
+		i.SetArgs(env.Args)
+		if env.Vars != nil {
+			i.SetEnv(env.Vars)
+		}
+		for _, s := range env.stacks {
+			i.SetStack(s.name, s.elemType, s.stack)
+		}
+	}
+	for _, h := range registered() {
+		i.SetFunc(h.name, func(args []runtime.Value) (runtime.Value, error) {
+			return h.call(ctx, args)
+		})
+	}
+
+	stop := context.AfterFunc(ctx, func() { i.Interrupt(ctx.Err()) })
+	defer stop()
+	err := i.Run(p.prog)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var exit *ExitError
+	if errors.As(err, &exit) && exit.Code == 0 {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+func TestRun(t *testing.T) {
+	prog, err := Compile(`
+var n i64 = 0
+@jobs take:n
+@results push(n * n)
+println("squared", n, "for", env("USER"), readline())
+setenv("DONE", "yes")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.Vars = map[string]string{"USER": "ann"}
+	jobs := env.Stack("jobs", "i64", runtime.FIFO)
+	results := env.Stack("results", "i64", runtime.FIFO)
+	jobs.Push(runtime.NewInt(7))
+
+	var out bytes.Buffer
+	if err := prog.Run(context.Background(), Stdio{Stdin: strings.NewReader("hi\n"), Stdout: &out}, env); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := results.Pop(); err != nil || v.AsInt() != 49 {
+		t.Errorf("results: %v, %v", v, err)
+	}
+	if out.String() != "squared 7 for ann hi\n" {
+		t.Errorf("output %q", out.String())
+	}
+	if env.Vars["DONE"] != "yes" {
+		t.Errorf("setenv left %v", env.Vars)
+	}
+
+	// Each run has variables of its own
+	jobs.Push(runtime.NewInt(3))
+	out.Reset()
+	if err := prog.Run(context.Background(), Stdio{Stdin: strings.NewReader(""), Stdout: &out}, env); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := results.Pop(); v.AsInt() != 9 {
+		t.Errorf("second run pushed %v", v)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := Compile("var x i64 = \n@s push(")
+	if err == nil || !strings.HasPrefix(err.Error(), "<script>:") {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestExit(t *testing.T) {
+	prog, err := Compile(`
+func stop() i64 {
+    exit(3)
+    return 0
+}
+@dstack {
+    var x i64 = stop()
+}.consider(
+    ok: println("ok")
+    error |e|: println("caught", e)
+)
+println("after")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = prog.Run(context.Background(), Stdio{Stdout: &out}, nil)
+	var exit *ExitError
+	if !errors.As(err, &exit) || exit.Code != 3 || out.Len() != 0 {
+		t.Errorf("error %v, output %q", err, out.String())
+	}
+
+	prog, _ = Compile("println(\"bye\")\nexit(0)\nprintln(\"not reached\")")
+	out.Reset()
+	if err := prog.Run(context.Background(), Stdio{Stdout: &out}, nil); err != nil || out.String() != "bye\n" {
+		t.Errorf("error %v, output %q", err, out.String())
+	}
+}
+
+func TestCancel(t *testing.T) {
+	for _, src := range []string{
+		"while (true) { }",
+		"func spin() {\n    while (true) { }\n}\nspin()",
+	} {
+		prog, err := Compile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err = prog.Run(ctx, Stdio{}, nil)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%q: %v", src, err)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	prices := map[string]float64{"tea": 2.5}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(RegisterFunc("price", func(ctx context.Context, item string) (float64, error) {
+		if ctx == nil {
+			return 0, errors.New("no context")
+		}
+		p, ok := prices[item]
+		if !ok {
+			return 0, errors.New("no price for " + item)
+		}
+		return p, nil
+	}))
+	must(RegisterFunc("sum", func(base int32, more ...uint8) int {
+		for _, n := range more {
+			base += int32(n)
+		}
+		return int(base)
+	}))
+	var logged []string
+	must(RegisterFunc("log", func(msg []byte) { logged = append(logged, string(msg)) }))
+
+	prog, err := Compile(`
+var total f64 = price("tea") * 2.0
+println(total, sum(1, 2, 3), sum(4))
+log("done")
+@dstack {
+    var p f64 = price("cake")
+}.consider(
+    ok: println("ok")
+    error |e|: println("caught", e)
+)
+`)
+	must(err)
+	var out bytes.Buffer
+	must(prog.Run(context.Background(), Stdio{Stdout: &out}, nil))
+	if out.String() != "5 6 4\ncaught no price for cake\n" || len(logged) != 1 || logged[0] != "done" {
+		t.Errorf("output %q, logged %q", out.String(), logged)
+	}
+
+	for src, want := range map[string]string{
+		`sum("x")`:    `sum(): argument 1 is string, want int32`,
+		`sum(1, 300)`: `sum(): argument 2 is 300, which uint8 cannot hold`,
+		`price()`:     `price() expects 1 arguments, got 0`,
+	} {
+		prog, err := Compile(src)
+		must(err)
+		if err := prog.Run(context.Background(), Stdio{}, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, want %q", src, err, want)
+		}
+	}
+
+	for name, fn := range map[string]any{
+		"print": func() {},
+		"nope":  42,
+		"pair":  func() (int, int) { return 0, 0 },
+		"ptr":   func(*int) {},
+	} {
+		if err := RegisterFunc(name, fn); err == nil {
+			t.Errorf("registered %s", name)
+		}
+	}
+}
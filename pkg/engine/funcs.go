@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/ha1tch/ual/pkg/check"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+var (
+	funcsMu sync.Mutex
+	funcs   = map[string]*hostFunc{}
+)
+
+// hostFunc is a Go function registered with RegisterFunc
+type hostFunc struct {
+	name    string
+	fn      reflect.Value
+	ctx     bool // takes the Run context first
+	params  []reflect.Type
+	results []reflect.Type // without the error
+	errs    bool           // returns an error last
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	valueType   = reflect.TypeOf(runtime.Value{})
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// RegisterFunc makes the Go function fn callable as name(...) from the
+// programs run after it, as if they had declared it:
+//
+//	engine.RegisterFunc("lookup", func(ctx context.Context, key string) (int64, error) {
+//	    return store.Get(ctx, key)
+//	})
+//
+// fn takes values of the types the program passes: any integer type for
+// i64, float64 or float32 for f64 (an i64 is converted), string or []byte
+// for string, bool, or a runtime.Value for any of them. A context.Context
+// taken first is the one Run was given. fn may be variadic, and returns
+// nothing, a value of one of those types, an error, or a value and an
+// error; an error it returns is the program's error, which consider
+// catches. An argument of another type, or an integer that fn's type
+// cannot hold, is an error of the call.
+//
+// A program that declares a function named name calls its own. Registering
+// name again replaces fn; the names of builtins cannot be registered.
+func RegisterFunc(name string, fn any) error {
+	if name == "" || check.BuiltinFuncs[name] {
+		return fmt.Errorf("RegisterFunc: %q is not a name a function can have", name)
+	}
+	h := &hostFunc{name: name, fn: reflect.ValueOf(fn)}
+	if h.fn.Kind() != reflect.Func || h.fn.IsNil() {
+		return fmt.Errorf("RegisterFunc: %s: %T is not a function", name, fn)
+	}
+	t := h.fn.Type()
+	for n := 0; n < t.NumIn(); n++ {
+		p := t.In(n)
+		if n == 0 && p == contextType {
+			h.ctx = true
+			continue
+		}
+		if t.IsVariadic() && n == t.NumIn()-1 {
+			p = p.Elem()
+		}
+		if !marshalled(p) {
+			return fmt.Errorf("RegisterFunc: %s: cannot pass %s to Go", name, p)
+		}
+		h.params = append(h.params, p)
+	}
+	for n := 0; n < t.NumOut(); n++ {
+		r := t.Out(n)
+		if n == t.NumOut()-1 && r == errorType {
+			h.errs = true
+			continue
+		}
+		if !marshalled(r) {
+			return fmt.Errorf("RegisterFunc: %s: cannot return %s to ual", name, r)
+		}
+		h.results = append(h.results, r)
+	}
+	if len(h.results) > 1 {
+		return fmt.Errorf("RegisterFunc: %s: returns more than one value", name)
+	}
+
+	funcsMu.Lock()
+	funcs[name] = h
+	funcsMu.Unlock()
+	return nil
+}
+
+// registered returns the functions registered so far
+func registered() []*hostFunc {
+	funcsMu.Lock()
+	defer funcsMu.Unlock()
+	all := make([]*hostFunc, 0, len(funcs))
+	for _, h := range funcs {
+		all = append(all, h)
+	}
+	return all
+}
+
+// marshalled reports whether values of t go between ual and Go
+func marshalled(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+		return true
+	}
+	return t == valueType || t == bytesType
+}
+
+// call calls the function with the values a program passed it
+func (h *hostFunc) call(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+	t := h.fn.Type()
+	want := len(h.params)
+	if t.IsVariadic() {
+		if len(args) < want-1 {
+			return runtime.NilValue, fmt.Errorf("%s() expects at least %d arguments, got %d", h.name, want-1, len(args))
+		}
+	} else if len(args) != want {
+		return runtime.NilValue, fmt.Errorf("%s() expects %d arguments, got %d", h.name, want, len(args))
+	}
+
+	in := make([]reflect.Value, 0, len(args)+1)
+	if h.ctx {
+		in = append(in, reflect.ValueOf(&ctx).Elem())
+	}
+	for n, arg := range args {
+		p := h.params[min(n, want-1)]
+		v, err := toGo(arg, p)
+		if err != nil {
+			return runtime.NilValue, fmt.Errorf("%s(): argument %d %v", h.name, n+1, err)
+		}
+		in = append(in, v)
+	}
+
+	out := h.fn.Call(in)
+	if h.errs {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return runtime.NilValue, err
+		}
+	}
+	if len(h.results) == 0 {
+		return runtime.NilValue, nil
+	}
+	v, err := toUal(out[0])
+	if err != nil {
+		return runtime.NilValue, fmt.Errorf("%s(): %v", h.name, err)
+	}
+	return v, nil
+}
+
+// toGo converts a ual value to a Go value of type t
+func toGo(v runtime.Value, t reflect.Type) (reflect.Value, error) {
+	if t == valueType {
+		return reflect.ValueOf(v), nil
+	}
+	r := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type == runtime.VTInt && !r.OverflowInt(v.AsInt()) {
+			r.SetInt(v.AsInt())
+			return r, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Type == runtime.VTInt && v.AsInt() >= 0 && !r.OverflowUint(uint64(v.AsInt())) {
+			r.SetUint(uint64(v.AsInt()))
+			return r, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.Type == runtime.VTInt || v.Type == runtime.VTFloat {
+			r.SetFloat(v.AsFloat())
+			return r, nil
+		}
+	case reflect.String:
+		if v.Type == runtime.VTString {
+			r.SetString(v.AsString())
+			return r, nil
+		}
+	case reflect.Slice: // []byte
+		if v.Type == runtime.VTString {
+			r.SetBytes([]byte(v.AsString()))
+			return r, nil
+		}
+	case reflect.Bool:
+		if v.Type == runtime.VTBool {
+			r.SetBool(v.AsBool())
+			return r, nil
+		}
+	}
+	if v.Type == runtime.VTInt && (r.CanInt() || r.CanUint()) {
+		return r, fmt.Errorf("is %d, which %s cannot hold", v.AsInt(), t)
+	}
+	return r, fmt.Errorf("is %s, want %s", typeName(v), t)
+}
+
+// toUal converts a Go value a function returned to a ual value
+func toUal(r reflect.Value) (runtime.Value, error) {
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return runtime.NewInt(r.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if r.Uint() > math.MaxInt64 {
+			return runtime.NilValue, fmt.Errorf("returned %d, which i64 cannot hold", r.Uint())
+		}
+		return runtime.NewInt(int64(r.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return runtime.NewFloat(r.Float()), nil
+	case reflect.String:
+		return runtime.NewString(r.String()), nil
+	case reflect.Slice:
+		return runtime.NewString(string(r.Bytes())), nil
+	case reflect.Bool:
+		return runtime.NewBool(r.Bool()), nil
+	}
+	if v, ok := r.Interface().(runtime.Value); ok {
+		return v, nil
+	}
+	return runtime.NilValue, errors.New("returned a value ual cannot hold")
+}
+
+// typeName is the ual type of v, for errors
+func typeName(v runtime.Value) string {
+	switch v.Type {
+	case runtime.VTInt:
+		return "i64"
+	case runtime.VTFloat:
+		return "f64"
+	case runtime.VTString:
+		return "string"
+	case runtime.VTBool:
+		return "bool"
+	case runtime.VTArray:
+		return "an array"
+	case runtime.VTError:
+		return "an error"
+	case runtime.VTCodeblock:
+		return "a codeblock"
+	}
+	return "nil"
+}
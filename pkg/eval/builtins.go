@@ -0,0 +1,98 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// builtins are the builtin functions whose result depends on their
+// arguments alone, by the number of arguments they take
+var builtins = map[string]int{
+	"len": 1, "int": 1, "float": 1, "string": 1, "bool": 1,
+	"abs": 1, "sqrt": 1, "sin": 1, "cos": 1, "pow": 2,
+	"min": 2, "max": 2, "atoi": 1, "itoa": 1,
+}
+
+// Pure reports whether name is a builtin function with no effects, whose
+// result depends on its arguments alone, and so may be called by Call.
+func Pure(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// arity checks that the builtin name is pure and takes n arguments
+func arity(name string, n int) error {
+	want, ok := builtins[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("%s is not a pure builtin", name)
+	case n == want:
+		return nil
+	case want == 1:
+		return fmt.Errorf("%s() takes 1 argument", name)
+	}
+	return fmt.Errorf("%s() takes %d arguments", name, want)
+}
+
+// Call calls the pure builtin function name with args.
+func Call(name string, args []runtime.Value) (runtime.Value, error) {
+	if err := arity(name, len(args)); err != nil {
+		return runtime.NilValue, err
+	}
+	a := args[0]
+	switch name {
+	case "len":
+		switch a.Type {
+		case runtime.VTString:
+			return runtime.NewInt(int64(len(a.AsString()))), nil
+		case runtime.VTArray:
+			return runtime.NewInt(int64(len(a.AsArray()))), nil
+		}
+		return runtime.NewInt(0), nil
+	case "int":
+		return runtime.NewInt(a.AsInt()), nil
+	case "float":
+		return runtime.NewFloat(a.AsFloat()), nil
+	case "string":
+		return runtime.NewString(a.AsString()), nil
+	case "bool":
+		return runtime.NewBool(a.AsBool()), nil
+	case "abs":
+		if a.Type == runtime.VTFloat {
+			return runtime.NewFloat(math.Abs(a.AsFloat())), nil
+		}
+		v := a.AsInt()
+		if v < 0 {
+			v = -v
+		}
+		return runtime.NewInt(v), nil
+	case "sqrt":
+		return runtime.NewFloat(math.Sqrt(a.AsFloat())), nil
+	case "sin":
+		return runtime.NewFloat(math.Sin(a.AsFloat())), nil
+	case "cos":
+		return runtime.NewFloat(math.Cos(a.AsFloat())), nil
+	case "pow":
+		return runtime.NewFloat(math.Pow(a.AsFloat(), args[1].AsFloat())), nil
+	case "min":
+		if a.Compare(args[1]) <= 0 {
+			return a, nil
+		}
+		return args[1], nil
+	case "max":
+		if a.Compare(args[1]) >= 0 {
+			return a, nil
+		}
+		return args[1], nil
+	case "atoi":
+		n, err := strconv.ParseInt(a.AsString(), 10, 64)
+		if err != nil {
+			return runtime.NewInt(0), nil
+		}
+		return runtime.NewInt(n), nil
+	}
+	return runtime.NewString(strconv.FormatInt(a.AsInt(), 10)), nil // itoa
+}
@@ -0,0 +1,16 @@
+// Package eval evaluates ual expressions: the operators, literals and
+// pure builtins that iual and the compiler must agree on.
+//
+// iual evaluates every expression through Expr, handing it an Env that
+// supplies the program's variables and evaluates the expressions that
+// need a running program: stack operations, calls of its functions and
+// the like. The compiler folds constant expressions with Const, which has
+// no variables at all, so a constant such as
+//
+//	const ROOT2 = sqrt(2.0) / 2
+//	var area f64 = pow(2.5, 2) * 3.14159
+//
+// gets the value iual would compute, in the same type, rather than one
+// reimplemented for code generation. The bytecode machine of pkg/vm
+// applies its operators with Apply and Apply1.
+package eval
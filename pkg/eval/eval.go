@@ -0,0 +1,165 @@
+package eval
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// Env is what an expression is evaluated in: the variables, functions and
+// stacks of a running program, or none at all for a constant.
+type Env interface {
+	// Var returns the value of the variable name.
+	Var(name string) (runtime.Value, error)
+	// Func reports whether name is a function of the program. A call
+	// statement such as sqrt(x) calls the program's function sqrt, if
+	// there is one, rather than the builtin.
+	Func(name string) bool
+	// Other evaluates an expression Expr does not: a stack operation, a
+	// member or index, a call of anything but a pure builtin, and so on.
+	Other(e ast.Expr) (runtime.Value, error)
+}
+
+// ErrNotConstant is the error Const returns for an expression that
+// depends on more than literals, operators and pure builtins.
+var ErrNotConstant = errors.New("value must be a constant expression")
+
+// Expr evaluates e in env. Literals, operators and calls of pure builtins
+// are evaluated here, with their operands, and everything else by env.
+func Expr(e ast.Expr, env Env) (runtime.Value, error) {
+	switch e := e.(type) {
+	case *ast.IntLit:
+		return runtime.NewInt(e.Value), nil
+	case *ast.FloatLit:
+		return runtime.NewFloat(e.Value), nil
+	case *ast.StringLit:
+		return runtime.NewString(e.Value), nil
+	case *ast.BoolLit:
+		return runtime.NewBool(e.Value), nil
+	case *ast.InterpString:
+		var sb strings.Builder
+		for _, part := range e.Parts {
+			v, err := Expr(part, env)
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			sb.WriteString(v.AsString())
+		}
+		return runtime.NewString(sb.String()), nil
+	case *ast.Ident:
+		switch e.Name {
+		case "true":
+			return runtime.NewBool(true), nil
+		case "false":
+			return runtime.NewBool(false), nil
+		case "nil":
+			return runtime.NilValue, nil
+		}
+		return env.Var(e.Name)
+	case *ast.BinaryExpr:
+		left, err := Expr(e.Left, env)
+		if err != nil {
+			return runtime.NilValue, err
+		}
+		// && and || skip their right side once the left decides
+		if (e.Op == "&&" && !left.AsBool()) || (e.Op == "||" && left.AsBool()) {
+			return runtime.NewBool(e.Op == "||"), nil
+		}
+		right, err := Expr(e.Right, env)
+		if err != nil {
+			return runtime.NilValue, err
+		}
+		if left.Type == runtime.VTInt && right.Type == runtime.VTInt {
+			if v, ok, err := intOp(e.Op, left.AsInt(), right.AsInt()); ok {
+				return v, err
+			}
+		}
+		return Binary(e.Op, left, right)
+	case *ast.BinaryOp:
+		left, err := Expr(e.Left, env)
+		if err != nil {
+			return runtime.NilValue, err
+		}
+		right, err := Expr(e.Right, env)
+		if err != nil {
+			return runtime.NilValue, err
+		}
+		if left.Type == runtime.VTInt && right.Type == runtime.VTInt {
+			if v, ok, err := intOp(e.Op, left.AsInt(), right.AsInt()); ok {
+				return v, err
+			}
+		}
+		return Arith(e.Op, left, right)
+	case *ast.UnaryExpr:
+		operand, err := Expr(e.Operand, env)
+		if err != nil {
+			return runtime.NilValue, err
+		}
+		return Unary(e.Op, operand)
+	case *ast.CallExpr:
+		if Pure(e.Fn) {
+			return call(e.Fn, e.Args, env)
+		}
+	case *ast.FuncCall:
+		if Pure(e.Name) && !env.Func(e.Name) {
+			return call(e.Name, e.Args, env)
+		}
+	}
+	return env.Other(e)
+}
+
+// call calls the pure builtin name with the values of args
+func call(name string, args []ast.Expr, env Env) (runtime.Value, error) {
+	if err := arity(name, len(args)); err != nil {
+		return runtime.NilValue, err
+	}
+	vals := make([]runtime.Value, len(args))
+	for idx, arg := range args {
+		v, err := Expr(arg, env)
+		if err != nil {
+			return runtime.NilValue, err
+		}
+		vals[idx] = v
+	}
+	return Call(name, vals)
+}
+
+// Const evaluates e as a constant expression, or returns ErrNotConstant.
+// isFunc, which may be nil, reports the functions of the program, whose
+// calls are not constant.
+func Const(e ast.Expr, isFunc func(name string) bool) (runtime.Value, error) {
+	return Expr(e, constEnv(isFunc))
+}
+
+// constEnv is the Env of Const, with no variables or stacks
+type constEnv func(name string) bool
+
+func (constEnv) Var(name string) (runtime.Value, error) {
+	return runtime.NilValue, ErrNotConstant
+}
+
+func (f constEnv) Func(name string) bool {
+	return f != nil && f(name)
+}
+
+func (constEnv) Other(e ast.Expr) (runtime.Value, error) {
+	return runtime.NilValue, ErrNotConstant
+}
+
+// Literal returns the literal expression of v, if it has one: v is an
+// int, float, string or bool.
+func Literal(v runtime.Value) (ast.Expr, bool) {
+	switch v.Type {
+	case runtime.VTInt:
+		return &ast.IntLit{Value: v.AsInt()}, true
+	case runtime.VTFloat:
+		return &ast.FloatLit{Value: v.AsFloat()}, true
+	case runtime.VTString:
+		return &ast.StringLit{Value: v.AsString()}, true
+	case runtime.VTBool:
+		return &ast.BoolLit{Value: v.AsBool()}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,133 @@
+package eval_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// value parses "var v = src" and returns the expression of its value
+func value(t *testing.T, src string) ast.Expr {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer("var v = " + src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	return prog.Stmts[0].(*ast.VarDecl).Values[0]
+}
+
+func TestConst(t *testing.T) {
+	for src, want := range map[string]string{
+		"7 / 2":                    "int 3",
+		"7 / 2.0":                  "float 3.5",
+		"sqrt(16.0) * 2":           "float 8",
+		"pow(2, 10) + len(\"ab\")": "float 1026",
+		"\"n=${3 * 4}\"":           "string n=12",
+		"int(2.9) % 2":             "int 0",
+		"\"a\" + 1":                "string a1",
+	} {
+		v, err := eval.Const(value(t, src), nil)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := fmt.Sprintf("%s %s", typeName(v), v.AsString()); got != want {
+			t.Errorf("%s = %s, want %s", src, got, want)
+		}
+	}
+
+	for src, want := range map[string]string{
+		"x + 1":          eval.ErrNotConstant.Error(),
+		"sqrt(2.0, 3.0)": "sqrt() takes 1 argument",
+		"1 % 0":          "modulo by zero",
+		"@dstack":        eval.ErrNotConstant.Error(),
+	} {
+		if _, err := eval.Const(value(t, src), nil); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", src, err, want)
+		}
+	}
+
+	// A function of the program hides the builtin of its name
+	isFunc := func(name string) bool { return name == "sqrt" }
+	if _, err := eval.Const(value(t, "sqrt(4.0)"), isFunc); !errors.Is(err, eval.ErrNotConstant) {
+		t.Errorf("sqrt(4.0) with a sqrt function: %v, want ErrNotConstant", err)
+	}
+}
+
+func typeName(v runtime.Value) string {
+	switch v.Type {
+	case runtime.VTInt:
+		return "int"
+	case runtime.VTFloat:
+		return "float"
+	case runtime.VTString:
+		return "string"
+	case runtime.VTBool:
+		return "bool"
+	}
+	return "other"
+}
+
+// env is an Env with the variables of vars, in which everything else
+// evaluates to -1
+type env map[string]runtime.Value
+
+func (e env) Var(name string) (runtime.Value, error) {
+	if v, ok := e[name]; ok {
+		return v, nil
+	}
+	return runtime.NilValue, fmt.Errorf("undefined variable: %s", name)
+}
+
+func (env) Func(name string) bool { return false }
+
+func (env) Other(ast.Expr) (runtime.Value, error) { return runtime.NewInt(-1), nil }
+
+func TestExpr(t *testing.T) {
+	vars := env{"x": runtime.NewInt(5), "f": runtime.NewFloat(0.5)}
+	for src, want := range map[string]string{
+		"x * 2 + f":   "10.5",
+		"x + @dstack": "4",
+		"-x":          "-5",
+	} {
+		v, err := eval.Expr(value(t, src), vars)
+		if err != nil || v.AsString() != want {
+			t.Errorf("%s = %s, %v; want %s", src, v.AsString(), err, want)
+		}
+	}
+	if _, err := eval.Expr(value(t, "y + 1"), vars); err == nil || err.Error() != "undefined variable: y" {
+		t.Errorf("y + 1: %v, want undefined variable: y", err)
+	}
+}
+
+func TestOps(t *testing.T) {
+	for _, tc := range []struct {
+		op   string
+		a, b runtime.Value
+		want string
+	}{
+		{"+", runtime.NewInt(2), runtime.NewInt(3), "5"},
+		{"+", runtime.NewInt(2), runtime.NewFloat(0.5), "2.5"},
+		{"+", runtime.NewString("a"), runtime.NewInt(1), "a1"},
+		{"%", runtime.NewInt(7), runtime.NewInt(4), "3"},
+		{"<", runtime.NewFloat(1.5), runtime.NewInt(2), "true"},
+		{"<<", runtime.NewInt(1), runtime.NewInt(4), "16"},
+	} {
+		v, err := eval.Arith(tc.op, tc.a, tc.b)
+		if err != nil || v.AsString() != tc.want {
+			t.Errorf("%s %s %s = %v, %v; want %s", tc.a.AsString(), tc.op, tc.b.AsString(), v.AsString(), err, tc.want)
+		}
+	}
+	if v, _ := eval.Binary("==", runtime.NewInt(1), runtime.NewFloat(1)); !v.AsBool() {
+		t.Errorf("1 == 1.0 is false")
+	}
+	if v, _ := eval.Unary("-", runtime.NewFloat(2)); v.AsFloat() != -2 {
+		t.Errorf("-2.0 = %v", v.AsString())
+	}
+}
@@ -0,0 +1,334 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// Op is an operator. pkg/vm numbers its operator instructions in the same
+// order, from its OpAdd.
+type Op uint8
+
+// Operators. Arithmetic and the numeric comparisons come before the
+// bitwise operators, which take ints even from floats.
+const (
+	Add Op = iota
+	Sub
+	Mul
+	Div
+	Mod
+	NumEq
+	NumNe
+	NumLt
+	NumGt
+	NumLe
+	NumGe
+	BitAnd
+	BitOr
+	BitXor
+	Shl
+	Shr
+	Eq
+	Ne
+	Lt
+	Gt
+	Le
+	Ge
+	And
+	Or
+
+	// Unary operators
+	Neg
+	Not
+	BitNot
+)
+
+var opNames = [...]string{
+	"+", "-", "*", "/", "%",
+	"==", "!=", "<", ">", "<=", ">=",
+	"&", "|", "^", "<<", ">>",
+	"==", "!=", "<", ">", "<=", ">=", "&&", "||",
+	"-", "!", "~",
+}
+
+func (o Op) String() string {
+	if int(o) < len(opNames) {
+		return opNames[o]
+	}
+	return fmt.Sprintf("op(%d)", o)
+}
+
+var (
+	errDivision = errors.New("division by zero")
+	errModulo   = errors.New("modulo by zero")
+)
+
+// BinaryOp returns the operator op of a comparison expression
+// (ast.BinaryExpr), which compares any two values.
+func BinaryOp(op string) (Op, bool) {
+	switch op {
+	case "==":
+		return Eq, true
+	case "!=":
+		return Ne, true
+	case "<":
+		return Lt, true
+	case ">":
+		return Gt, true
+	case "<=":
+		return Le, true
+	case ">=":
+		return Ge, true
+	case "&&":
+		return And, true
+	case "||":
+		return Or, true
+	case "+", "-", "*", "/", "%":
+		return ArithOp(op)
+	}
+	return 0, false
+}
+
+// ArithOp returns the operator op of an arithmetic expression
+// (ast.BinaryOp), which compares numbers only.
+func ArithOp(op string) (Op, bool) {
+	switch op {
+	case "+":
+		return Add, true
+	case "-":
+		return Sub, true
+	case "*":
+		return Mul, true
+	case "/":
+		return Div, true
+	case "%":
+		return Mod, true
+	case "==":
+		return NumEq, true
+	case "!=":
+		return NumNe, true
+	case "<":
+		return NumLt, true
+	case ">":
+		return NumGt, true
+	case "<=":
+		return NumLe, true
+	case ">=":
+		return NumGe, true
+	case "&":
+		return BitAnd, true
+	case "|":
+		return BitOr, true
+	case "^":
+		return BitXor, true
+	case "<<":
+		return Shl, true
+	case ">>":
+		return Shr, true
+	}
+	return 0, false
+}
+
+// UnaryOp returns the unary operator op.
+func UnaryOp(op string) (Op, bool) {
+	switch op {
+	case "-":
+		return Neg, true
+	case "!":
+		return Not, true
+	case "~":
+		return BitNot, true
+	}
+	return 0, false
+}
+
+// Binary applies op, an operator of a comparison expression, to a and b.
+// == and != compare numbers by value and other values by type and value,
+// the orderings compare numbers and strings, and + - * / % are
+// arithmetic as in Arith. && and || take both sides as booleans; the
+// caller skips b when a decides.
+func Binary(op string, a, b runtime.Value) (runtime.Value, error) {
+	o, ok := BinaryOp(op)
+	if !ok {
+		return runtime.NilValue, fmt.Errorf("unknown binary operator: %s", op)
+	}
+	return Apply(o, a, b)
+}
+
+// Arith applies op, an operator of an arithmetic expression, to a and b.
+// + joins strings if either side is one; otherwise arithmetic and
+// comparisons are in floats if either side is a float, and in ints if
+// not. The bitwise operators take ints.
+func Arith(op string, a, b runtime.Value) (runtime.Value, error) {
+	o, ok := ArithOp(op)
+	if !ok {
+		return runtime.NilValue, fmt.Errorf("unknown binary operator: %s", op)
+	}
+	return Apply(o, a, b)
+}
+
+// Unary applies op, - ! or ~, to a.
+func Unary(op string, a runtime.Value) (runtime.Value, error) {
+	o, ok := UnaryOp(op)
+	if !ok {
+		return runtime.NilValue, fmt.Errorf("unknown unary operator: %s", op)
+	}
+	return Apply1(o, a), nil
+}
+
+// Apply applies the binary operator o to a and b.
+func Apply(o Op, a, b runtime.Value) (runtime.Value, error) {
+	switch o {
+	case Eq:
+		return runtime.NewBool(a.Equals(b)), nil
+	case Ne:
+		return runtime.NewBool(!a.Equals(b)), nil
+	case Lt:
+		return runtime.NewBool(a.Compare(b) < 0), nil
+	case Gt:
+		return runtime.NewBool(a.Compare(b) > 0), nil
+	case Le:
+		return runtime.NewBool(a.Compare(b) <= 0), nil
+	case Ge:
+		return runtime.NewBool(a.Compare(b) >= 0), nil
+	case And:
+		return runtime.NewBool(a.AsBool() && b.AsBool()), nil
+	case Or:
+		return runtime.NewBool(a.AsBool() || b.AsBool()), nil
+	}
+
+	if o == Add && (a.Type == runtime.VTString || b.Type == runtime.VTString) {
+		return runtime.NewString(a.AsString() + b.AsString()), nil
+	}
+	if (a.Type == runtime.VTFloat || b.Type == runtime.VTFloat) && o < BitAnd {
+		x, y := a.AsFloat(), b.AsFloat()
+		switch o {
+		case Add:
+			return runtime.NewFloat(x + y), nil
+		case Sub:
+			return runtime.NewFloat(x - y), nil
+		case Mul:
+			return runtime.NewFloat(x * y), nil
+		case Div:
+			if y == 0 {
+				return runtime.NilValue, errDivision
+			}
+			return runtime.NewFloat(x / y), nil
+		case Mod:
+			return runtime.NewFloat(math.Mod(x, y)), nil
+		case NumEq:
+			return runtime.NewBool(x == y), nil
+		case NumNe:
+			return runtime.NewBool(x != y), nil
+		case NumLt:
+			return runtime.NewBool(x < y), nil
+		case NumGt:
+			return runtime.NewBool(x > y), nil
+		case NumLe:
+			return runtime.NewBool(x <= y), nil
+		case NumGe:
+			return runtime.NewBool(x >= y), nil
+		}
+	}
+	return applyInt(o, a.AsInt(), b.AsInt())
+}
+
+// applyInt applies the arithmetic, numeric comparison or bitwise
+// operator o to x and y
+func applyInt(o Op, x, y int64) (runtime.Value, error) {
+	switch o {
+	case Add:
+		return runtime.NewInt(x + y), nil
+	case Sub:
+		return runtime.NewInt(x - y), nil
+	case Mul:
+		return runtime.NewInt(x * y), nil
+	case Div:
+		if y == 0 {
+			return runtime.NilValue, errDivision
+		}
+		return runtime.NewInt(x / y), nil
+	case Mod:
+		if y == 0 {
+			return runtime.NilValue, errModulo
+		}
+		return runtime.NewInt(x % y), nil
+	case NumEq:
+		return runtime.NewBool(x == y), nil
+	case NumNe:
+		return runtime.NewBool(x != y), nil
+	case NumLt:
+		return runtime.NewBool(x < y), nil
+	case NumGt:
+		return runtime.NewBool(x > y), nil
+	case NumLe:
+		return runtime.NewBool(x <= y), nil
+	case NumGe:
+		return runtime.NewBool(x >= y), nil
+	case BitAnd:
+		return runtime.NewInt(x & y), nil
+	case BitOr:
+		return runtime.NewInt(x | y), nil
+	case BitXor:
+		return runtime.NewInt(x ^ y), nil
+	case Shl:
+		return runtime.NewInt(x << uint(y)), nil
+	case Shr:
+		return runtime.NewInt(x >> uint(y)), nil
+	}
+	return runtime.NilValue, fmt.Errorf("unknown binary operator: %s", o)
+}
+
+// intOp applies op to the ints x and y, as Binary and Arith do, in one
+// switch: the walker's common case, which decoding op first slows. ok is
+// false for the operators it leaves to them.
+func intOp(op string, x, y int64) (v runtime.Value, ok bool, err error) {
+	switch op {
+	case "+":
+		return runtime.NewInt(x + y), true, nil
+	case "-":
+		return runtime.NewInt(x - y), true, nil
+	case "*":
+		return runtime.NewInt(x * y), true, nil
+	case "/":
+		if y == 0 {
+			return runtime.NilValue, true, errDivision
+		}
+		return runtime.NewInt(x / y), true, nil
+	case "%":
+		if y == 0 {
+			return runtime.NilValue, true, errModulo
+		}
+		return runtime.NewInt(x % y), true, nil
+	case "==":
+		return runtime.NewBool(x == y), true, nil
+	case "!=":
+		return runtime.NewBool(x != y), true, nil
+	case "<":
+		return runtime.NewBool(x < y), true, nil
+	case ">":
+		return runtime.NewBool(x > y), true, nil
+	case "<=":
+		return runtime.NewBool(x <= y), true, nil
+	case ">=":
+		return runtime.NewBool(x >= y), true, nil
+	}
+	return runtime.NilValue, false, nil
+}
+
+// Apply1 applies the unary operator o to a.
+func Apply1(o Op, a runtime.Value) runtime.Value {
+	switch o {
+	case Neg:
+		if a.Type == runtime.VTFloat {
+			return runtime.NewFloat(-a.AsFloat())
+		}
+		return runtime.NewInt(-a.AsInt())
+	case Not:
+		return runtime.NewBool(!a.AsBool())
+	}
+	return runtime.NewInt(^a.AsInt())
+}
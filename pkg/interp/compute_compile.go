@@ -8,8 +8,13 @@
 //   - Type checking per operation
 //
 // The result is a tight loop of function calls with direct slot access.
+//
+// Container views (self.prop[i]) are read once per run into native
+// []float64 or []int64 slices, by the stack's element type, so kernels
+// index them without converting each element from bytes. Views the block
+// assigns to are written back to the stack when it ends.
 
-package main
+package interp
 
 import (
 	"fmt"
@@ -26,11 +31,24 @@ type ComputeEnv struct {
 	bools    []bool    // bool variable slots
 	arrays   [][]float64 // local arrays
 	
+	floatViews [][]float64 // self.prop views on f64 and f32 stacks
+	intViews   [][]int64   // self.prop views on integer stacks
+	arrayViews []bool      // whether each view was read from an array value
+	
 	returnFloat float64
 	returnInt   int64
 	returnType  string // "float", "int", or ""
 	doReturn    bool
 	doBreak     bool
+	err         error // set by fail, which also ends the block
+}
+
+// fail ends the block with err, as a return does
+func (env *ComputeEnv) fail(err error) {
+	if env.err == nil {
+		env.err = err
+	}
+	env.doReturn = true
 }
 
 // CompiledCompute represents a pre-compiled compute block.
@@ -46,6 +64,10 @@ type CompiledCompute struct {
 	arrayMap     map[string]int // array name -> array slot index
 	arraySizes   map[string]int // array name -> size
 	
+	views        []viewInfo     // self.prop views, by slot
+	floatViews   bool           // views hold float64 rather than int64
+	stackType    string         // element type views are written back as
+	
 	params       []paramInfo    // parameter bindings
 	ops          []func(*ComputeEnv) // compiled operations
 }
@@ -56,6 +78,12 @@ type paramInfo struct {
 	slot    int
 }
 
+// viewInfo describes the view of a container property, self.name
+type viewInfo struct {
+	name    string
+	written bool // the block assigns to self.name[i]
+}
+
 // ComputeCompiler compiles AST to threaded operations.
 type ComputeCompiler struct {
 	floatMap   map[string]int
@@ -70,6 +98,17 @@ type ComputeCompiler struct {
 	nextArray  int
 	
 	params     []paramInfo
+	
+	viewMap    map[string]int // property name -> view slot
+	views      []viewInfo
+	
+	// floatReturn is set for blocks on f64 stacks, whose return values
+	// are computed in float rather than truncated to int. Their views
+	// hold float64 too.
+	floatReturn bool
+	
+	// stackType is the element type of the stack the block runs on
+	stackType string
 }
 
 // NewComputeCompiler creates a new compiler instance.
@@ -80,6 +119,7 @@ func NewComputeCompiler() *ComputeCompiler {
 		boolMap:    make(map[string]int),
 		arrayMap:   make(map[string]int),
 		arraySizes: make(map[string]int),
+		viewMap:    make(map[string]int),
 	}
 }
 
@@ -118,6 +158,9 @@ func (c *ComputeCompiler) Compile(params []string, body []ast.Stmt) (*CompiledCo
 		boolMap:    c.boolMap,
 		arrayMap:   c.arrayMap,
 		arraySizes: c.arraySizes,
+		views:      c.views,
+		floatViews: c.floatReturn,
+		stackType:  c.stackType,
 		params:     c.params,
 		ops:        ops,
 	}, nil
@@ -171,6 +214,18 @@ func (c *ComputeCompiler) scanStmt(stmt ast.Stmt) error {
 		}
 	case *ast.WhileStmt:
 		return c.scanDeclarations(s.Body)
+	case *ast.RangeStmt:
+		// The loop variable has the stack's element type, as in the compilers
+		if c.floatReturn {
+			if _, ok := c.floatMap[s.Var]; !ok {
+				c.floatMap[s.Var] = c.nextFloat
+				c.nextFloat++
+			}
+		} else if _, ok := c.intMap[s.Var]; !ok {
+			c.intMap[s.Var] = c.nextInt
+			c.nextInt++
+		}
+		return c.scanDeclarations(s.Body)
 	case *ast.IfStmt:
 		if err := c.scanDeclarations(s.Body); err != nil {
 			return err
@@ -228,6 +283,12 @@ func (c *ComputeCompiler) inferTypeFromExpr(expr ast.Expr) string {
 	case *ast.CallExpr:
 		// Math functions return float
 		return "f64"
+	case *ast.MemberExpr, *ast.MemberIndexExpr:
+		// Container properties have the stack's element type
+		if c.floatReturn {
+			return "f64"
+		}
+		return "i64"
 	default:
 		return ""
 	}
@@ -261,6 +322,8 @@ func (c *ComputeCompiler) compileStmt(stmt ast.Stmt) (func(*ComputeEnv), error)
 		return c.compileIndexedAssign(s)
 	case *ast.WhileStmt:
 		return c.compileWhile(s)
+	case *ast.RangeStmt:
+		return c.compileRange(s)
 	case *ast.IfStmt:
 		return c.compileIf(s)
 	case *ast.ReturnStmt:
@@ -407,6 +470,9 @@ func (c *ComputeCompiler) compileAssign(s *ast.AssignStmt) (func(*ComputeEnv), e
 }
 
 func (c *ComputeCompiler) compileIndexedAssign(s *ast.IndexedAssignStmt) (func(*ComputeEnv), error) {
+	if s.Target == "self" && s.Member != "" {
+		return c.compileViewAssign(s)
+	}
 	slot, ok := c.arrayMap[s.Target]
 	if !ok {
 		return nil, fmt.Errorf("unknown array: %s", s.Target)
@@ -426,6 +492,51 @@ func (c *ComputeCompiler) compileIndexedAssign(s *ast.IndexedAssignStmt) (func(*
 	}, nil
 }
 
+// compileViewAssign compiles self.prop[i] = expr, a write to the view
+func (c *ComputeCompiler) compileViewAssign(s *ast.IndexedAssignStmt) (func(*ComputeEnv), error) {
+	name := s.Member
+	slot := c.viewSlot(name)
+	c.views[slot].written = true
+	
+	idxFn, err := c.compileIntExpr(s.Index)
+	if err != nil {
+		return nil, err
+	}
+	
+	if c.floatReturn {
+		valFn, err := c.compileFloatExpr(s.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(env *ComputeEnv) {
+			view, k := env.floatViews[slot], idxFn(env)
+			if uint64(k) >= uint64(len(view)) {
+				env.fail(viewBoundsError(name, k, len(view)))
+				return
+			}
+			view[k] = valFn(env)
+		}, nil
+	}
+	
+	valFn, err := c.compileIntExpr(s.Value)
+	if err != nil {
+		// Fractional values are truncated, as the element type is int
+		floatFn, ferr := c.compileFloatExpr(s.Value)
+		if ferr != nil {
+			return nil, err
+		}
+		valFn = func(env *ComputeEnv) int64 { return int64(floatFn(env)) }
+	}
+	return func(env *ComputeEnv) {
+		view, k := env.intViews[slot], idxFn(env)
+		if uint64(k) >= uint64(len(view)) {
+			env.fail(viewBoundsError(name, k, len(view)))
+			return
+		}
+		view[k] = valFn(env)
+	}, nil
+}
+
 func (c *ComputeCompiler) compileWhile(s *ast.WhileStmt) (func(*ComputeEnv), error) {
 	condFn, err := c.compileBoolExpr(s.Condition)
 	if err != nil {
@@ -456,6 +567,51 @@ func (c *ComputeCompiler) compileWhile(s *ast.WhileStmt) (func(*ComputeEnv), err
 	}, nil
 }
 
+func (c *ComputeCompiler) compileRange(s *ast.RangeStmt) (func(*ComputeEnv), error) {
+	startFn, err := c.compileIntExpr(s.Start)
+	if err != nil {
+		return nil, err
+	}
+	endFn, err := c.compileIntExpr(s.End)
+	if err != nil {
+		return nil, err
+	}
+	
+	bodyOps, err := c.compileStmts(s.Body)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Bind the count to the loop variable's slot
+	var bind func(env *ComputeEnv, n int64)
+	if slot, ok := c.floatMap[s.Var]; ok {
+		bind = func(env *ComputeEnv, n int64) { env.floats[slot] = float64(n) }
+	} else {
+		slot := c.intMap[s.Var]
+		bind = func(env *ComputeEnv, n int64) { env.ints[slot] = n }
+	}
+	
+	return func(env *ComputeEnv) {
+		start, end := startFn(env), endFn(env)
+		for n := start; n < end; n++ {
+			bind(env, n)
+			for _, op := range bodyOps {
+				op(env)
+				if env.doBreak || env.doReturn {
+					break
+				}
+			}
+			if env.doReturn {
+				return
+			}
+			if env.doBreak {
+				env.doBreak = false // break only exits innermost loop
+				return
+			}
+		}
+	}, nil
+}
+
 func (c *ComputeCompiler) compileIf(s *ast.IfStmt) (func(*ComputeEnv), error) {
 	condFn, err := c.compileBoolExpr(s.Condition)
 	if err != nil {
@@ -511,9 +667,9 @@ func (c *ComputeCompiler) compileReturn(s *ast.ReturnStmt) (func(*ComputeEnv), e
 	// Check for multiple values first
 	if len(s.Values) > 0 {
 		// For now, just handle the first value
-		// Try int first (preserve integer semantics)
+		// Try int first (preserve integer semantics) unless the stack is f64
 		intFn, err := c.compileIntExpr(s.Values[0])
-		if err == nil {
+		if err == nil && !c.floatReturn {
 			return func(env *ComputeEnv) {
 				env.returnInt = intFn(env)
 				env.returnType = "int"
@@ -539,7 +695,7 @@ func (c *ComputeCompiler) compileReturn(s *ast.ReturnStmt) (func(*ComputeEnv), e
 	
 	// Try int first (preserve integer semantics when possible)
 	intFn, err := c.compileIntExpr(s.Value)
-	if err == nil {
+	if err == nil && !c.floatReturn {
 		return func(env *ComputeEnv) {
 			env.returnInt = intFn(env)
 			env.returnType = "int"
@@ -602,6 +758,15 @@ func (c *ComputeCompiler) compileFloatExpr(expr ast.Expr) (func(*ComputeEnv) flo
 	case *ast.IndexExpr:
 		return c.compileArrayIndex(e)
 	
+	case *ast.MemberExpr:
+		if e.Target != "self" {
+			return nil, fmt.Errorf("member access not supported for: %s", e.Target)
+		}
+		return c.compileFloatView(e.Member, nil)
+	
+	case *ast.MemberIndexExpr:
+		return c.compileFloatView(e.Member, e.Index)
+	
 	default:
 		return nil, fmt.Errorf("unsupported float expression: %T", expr)
 	}
@@ -766,6 +931,87 @@ func (c *ComputeCompiler) compileArrayIndex(e *ast.IndexExpr) (func(*ComputeEnv)
 	}, nil
 }
 
+// viewSlot returns the view slot of self.name, adding one the first time
+// the block uses it.
+func (c *ComputeCompiler) viewSlot(name string) int {
+	if slot, ok := c.viewMap[name]; ok {
+		return slot
+	}
+	c.viewMap[name] = len(c.views)
+	c.views = append(c.views, viewInfo{name: name})
+	return len(c.views) - 1
+}
+
+// viewIndex compiles the index of a view read; self.prop, with no index,
+// reads self.prop[0].
+func (c *ComputeCompiler) viewIndex(index ast.Expr) (func(*ComputeEnv) int64, error) {
+	if index == nil {
+		return func(env *ComputeEnv) int64 { return 0 }, nil
+	}
+	return c.compileIntExpr(index)
+}
+
+// compileFloatView compiles a read of self.name[index] as float64.
+func (c *ComputeCompiler) compileFloatView(name string, index ast.Expr) (func(*ComputeEnv) float64, error) {
+	slot := c.viewSlot(name)
+	idxFn, err := c.viewIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	
+	if c.floatReturn {
+		return func(env *ComputeEnv) float64 {
+			view, k := env.floatViews[slot], idxFn(env)
+			if uint64(k) >= uint64(len(view)) {
+				env.fail(viewBoundsError(name, k, len(view)))
+				return 0
+			}
+			return view[k]
+		}, nil
+	}
+	return func(env *ComputeEnv) float64 {
+		view, k := env.intViews[slot], idxFn(env)
+		if uint64(k) >= uint64(len(view)) {
+			env.fail(viewBoundsError(name, k, len(view)))
+			return 0
+		}
+		return float64(view[k])
+	}, nil
+}
+
+// compileIntView compiles a read of self.name[index] as int64.
+func (c *ComputeCompiler) compileIntView(name string, index ast.Expr) (func(*ComputeEnv) int64, error) {
+	slot := c.viewSlot(name)
+	idxFn, err := c.viewIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	
+	if c.floatReturn {
+		return func(env *ComputeEnv) int64 {
+			view, k := env.floatViews[slot], idxFn(env)
+			if uint64(k) >= uint64(len(view)) {
+				env.fail(viewBoundsError(name, k, len(view)))
+				return 0
+			}
+			return int64(view[k])
+		}, nil
+	}
+	return func(env *ComputeEnv) int64 {
+		view, k := env.intViews[slot], idxFn(env)
+		if uint64(k) >= uint64(len(view)) {
+			env.fail(viewBoundsError(name, k, len(view)))
+			return 0
+		}
+		return view[k]
+	}, nil
+}
+
+// viewBoundsError reports an index k outside a view of n elements
+func viewBoundsError(name string, k int64, n int) error {
+	return fmt.Errorf("self.%s index out of bounds: %d (len %d)", name, k, n)
+}
+
 // compileIntExpr compiles an expression that produces an int64.
 func (c *ComputeCompiler) compileIntExpr(expr ast.Expr) (func(*ComputeEnv) int64, error) {
 	switch e := expr.(type) {
@@ -807,6 +1053,15 @@ func (c *ComputeCompiler) compileIntExpr(expr ast.Expr) (func(*ComputeEnv) int64
 		}
 		return nil, fmt.Errorf("unsupported unary op: %s", e.Op)
 	
+	case *ast.MemberExpr:
+		if e.Target != "self" {
+			return nil, fmt.Errorf("member access not supported for: %s", e.Target)
+		}
+		return c.compileIntView(e.Member, nil)
+	
+	case *ast.MemberIndexExpr:
+		return c.compileIntView(e.Member, e.Index)
+	
 	default:
 		return nil, fmt.Errorf("unsupported int expression: %T", expr)
 	}
@@ -1000,7 +1255,9 @@ func (c *ComputeCompiler) compileBoolBinaryOp(e *ast.BinaryOp) (func(*ComputeEnv
 }
 
 // Execute runs a compiled compute block with the given parameter values.
-func (cc *CompiledCompute) Execute(params []float64) (Value, error) {
+// self is the stack the block runs on, which holds the properties its
+// views read.
+func (cc *CompiledCompute) Execute(params []float64, self *ValueStack) (Value, error) {
 	env := &ComputeEnv{
 		floats: make([]float64, cc.floatSlots),
 		ints:   make([]int64, cc.intSlots),
@@ -1019,6 +1276,10 @@ func (cc *CompiledCompute) Execute(params []float64) (Value, error) {
 		}
 	}
 	
+	if err := cc.loadViews(env, self); err != nil {
+		return NilValue, err
+	}
+	
 	// Execute compiled operations
 	for _, op := range cc.ops {
 		op(env)
@@ -1027,6 +1288,13 @@ func (cc *CompiledCompute) Execute(params []float64) (Value, error) {
 		}
 	}
 	
+	if err := cc.storeViews(env, self); err != nil {
+		return NilValue, err
+	}
+	if env.err != nil {
+		return NilValue, env.err
+	}
+	
 	// Return result
 	switch env.returnType {
 	case "float":
@@ -1036,4 +1304,77 @@ func (cc *CompiledCompute) Execute(params []float64) (Value, error) {
 	default:
 		return NilValue, nil
 	}
-}
\ No newline at end of file
+}
+
+// loadViews reads each property the block views from self into a native
+// slice: the elements of an array value, or a scalar as a slice of one.
+func (cc *CompiledCompute) loadViews(env *ComputeEnv, self *ValueStack) error {
+	if len(cc.views) == 0 {
+		return nil
+	}
+	if cc.floatViews {
+		env.floatViews = make([][]float64, len(cc.views))
+	} else {
+		env.intViews = make([][]int64, len(cc.views))
+	}
+	env.arrayViews = make([]bool, len(cc.views))
+	
+	for slot, v := range cc.views {
+		val, ok := NilValue, false
+		if self != nil {
+			val, ok = self.Get(v.name)
+		}
+		if !ok {
+			return fmt.Errorf("undefined self member: %s", v.name)
+		}
+		elems := []Value{val}
+		if val.IsArray() {
+			elems = val.AsArray()
+			env.arrayViews[slot] = true
+		}
+		if cc.floatViews {
+			view := make([]float64, len(elems))
+			for k, e := range elems {
+				view[k] = e.AsFloat()
+			}
+			env.floatViews[slot] = view
+		} else {
+			view := make([]int64, len(elems))
+			for k, e := range elems {
+				view[k] = e.AsInt()
+			}
+			env.intViews[slot] = view
+		}
+	}
+	return nil
+}
+
+// storeViews writes the views the block assigned to back to self, as
+// values of the stack's element type.
+func (cc *CompiledCompute) storeViews(env *ComputeEnv, self *ValueStack) error {
+	for slot, v := range cc.views {
+		if !v.written {
+			continue
+		}
+		var elems []Value
+		if cc.floatViews {
+			elems = make([]Value, len(env.floatViews[slot]))
+			for k, f := range env.floatViews[slot] {
+				elems[k] = convertValueToType(NewFloat(f), cc.stackType)
+			}
+		} else {
+			elems = make([]Value, len(env.intViews[slot]))
+			for k, n := range env.intViews[slot] {
+				elems[k] = convertValueToType(NewInt(n), cc.stackType)
+			}
+		}
+		val := NewArray(elems)
+		if !env.arrayViews[slot] {
+			val = elems[0]
+		}
+		if err := self.Set(v.name, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,11 +1,18 @@
 // compute_compile_test.go - Unit tests for threaded code compiler
 
-package main
+package interp
 
 import (
+	"encoding/binary"
+	"math"
+	"strings"
 	"testing"
+	"unsafe"
 
 	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/runtime"
 )
 
 // getReturnValue returns the return value as float64, regardless of whether
@@ -743,4 +750,176 @@ func TestNestedExpressions(t *testing.T) {
 	if getReturnValue(env) != 30.0 {
 		t.Errorf("Expected 30.0, got %f", getReturnValue(env))
 	}
-}
\ No newline at end of file
+}
+// viewKernel scales the elements of self.data in place and returns their
+// sum
+const viewKernel = `@buf {
+}.compute(
+    {||
+        var sum = 0.0
+        var i = 0
+        while i < self.n {
+            self.data[i] = self.data[i] * self.scale
+            sum = sum + self.data[i]
+            i = i + 1
+        }
+        return sum
+    }
+)
+`
+
+// newViewKernel returns an interpreter with an f64 Hash stack @buf whose
+// data property holds data, and viewKernel's compute block
+func newViewKernel(tb testing.TB, data []float64) (*Interpreter, *ast.ComputeStmt) {
+	tb.Helper()
+	interp := NewInterpreter()
+	prog, err := parser.NewParser(lexer.NewLexer("@buf = stack.new(f64, Hash)\n" + viewKernel).Tokenize()).Parse()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := interp.Eval(&ast.Program{Stmts: prog.Stmts[:1]}); err != nil {
+		tb.Fatal(err)
+	}
+	elems := make([]Value, len(data))
+	for k, f := range data {
+		elems[k] = NewFloat(f)
+	}
+	buf := interp.stacks["buf"]
+	buf.Set("data", NewArray(elems))
+	buf.Set("n", NewFloat(float64(len(data))))
+	buf.Set("scale", NewFloat(2))
+	return interp, prog.Stmts[1].(*ast.ComputeStmt)
+}
+
+// TestComputeViews verifies self.prop[i] reads and writes, compiled and
+// tree-walked
+func TestComputeViews(t *testing.T) {
+	for _, compiled := range []bool{true, false} {
+		interp, kernel := newViewKernel(t, []float64{1, 2, 3, 4})
+		buf := interp.stacks["buf"]
+		var err error
+		if compiled {
+			err = interp.execComputeStmt(kernel)
+			if interp.compiledCompute[kernel] == nil {
+				t.Error("the kernel did not compile")
+			}
+		} else {
+			err = interp.execComputeStmtSlow(kernel, buf)
+		}
+		if err != nil {
+			t.Fatalf("compiled %v: %v", compiled, err)
+		}
+		if sum, _ := buf.Get("__result_0__"); sum.AsFloat() != 20 {
+			t.Errorf("compiled %v: sum = %v, want 20", compiled, sum.AsString())
+		}
+		data, _ := buf.Get("data")
+		var got []string
+		for _, v := range data.AsArray() {
+			got = append(got, v.AsString())
+		}
+		if strings.Join(got, " ") != "2 4 6 8" {
+			t.Errorf("compiled %v: data = %v, want [2 4 6 8]", compiled, got)
+		}
+	}
+}
+
+// TestComputeScalarView verifies that a scalar property is a view of one
+// element, and that views check their bounds
+func TestComputeScalarView(t *testing.T) {
+	source := `@acc = stack.new(i64, Hash)
+@acc set("sum", 5)
+@acc {
+}.compute(
+    {||
+        var i = 0
+        while i < 10 {
+            self.sum[0] = self.sum[0] + i
+            i = i + 1
+        }
+        return self.sum * 2
+    }
+)
+@acc {
+}.compute(
+    {||
+        self.sum[1] = 1
+    }
+)
+`
+	prog, err := parser.NewParser(lexer.NewLexer(source).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	interp := NewInterpreter()
+	err = interp.Run(prog)
+	if err == nil || !strings.Contains(err.Error(), "self.sum index out of bounds: 1 (len 1)") {
+		t.Errorf("err = %v, want self.sum out of bounds", err)
+	}
+	acc := interp.stacks["acc"]
+	if v, _ := acc.Get("sum"); v.Type != runtime.VTInt || v.AsInt() != 50 {
+		t.Errorf("sum = %v, want 50", v.AsString())
+	}
+	if v, _ := acc.Get("__result_0__"); v.AsInt() != 100 {
+		t.Errorf("result = %v, want 100", v.AsString())
+	}
+}
+
+const viewElems = 1024
+
+// BenchmarkComputeView_Compiled runs viewKernel as threaded code over
+// native views
+func BenchmarkComputeView_Compiled(b *testing.B) {
+	interp, kernel := newViewKernel(b, make([]float64, viewElems))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := interp.execComputeStmt(kernel); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkComputeView_TreeWalk runs viewKernel by walking the tree,
+// converting the property on each element access
+func BenchmarkComputeView_TreeWalk(b *testing.B) {
+	interp, kernel := newViewKernel(b, make([]float64, viewElems))
+	buf := interp.stacks["buf"]
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := interp.execComputeStmtSlow(kernel, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkComputeView_Go runs the code the Go backend generates for
+// viewKernel: an unsafe.Slice view of the property's bytes, with self.n
+// and self.scale decoded on each access
+func BenchmarkComputeView_Go(b *testing.B) {
+	bytesToFloat := func(b []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(b)) }
+	floatToBytes := func(f float64) []byte { return binary.BigEndian.AppendUint64(nil, math.Float64bits(f)) }
+	buf := runtime.NewStack(runtime.Hash, runtime.TypeFloat64)
+	buf.SetRaw("data", make([]byte, 8*viewElems))
+	buf.SetRaw("n", floatToBytes(viewElems))
+	buf.SetRaw("scale", floatToBytes(2))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		func() {
+			buf.Lock()
+			defer buf.Unlock()
+			_raw_data, _ok_data := buf.GetRaw("data")
+			if !_ok_data {
+				panic("compute: property 'data' missing")
+			}
+			_ptr_data := (*float64)(unsafe.Pointer(&_raw_data[0]))
+			_view_data := unsafe.Slice(_ptr_data, len(_raw_data)/8)
+			var sum float64 = 0.0
+			var i float64 = 0
+			for i < func() float64 { _b, _ := buf.GetRaw("n"); return bytesToFloat(_b) }() {
+				_view_data[int(i)] = _view_data[int(i)] * func() float64 { _b, _ := buf.GetRaw("scale"); return bytesToFloat(_b) }()
+				sum = sum + _view_data[int(i)]
+				i = i + 1
+			}
+			buf.SetRaw("__result_0__", floatToBytes(sum))
+		}()
+	}
+}
@@ -0,0 +1,458 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// ============================================================================
+// Debugger (iual debug)
+//
+//   iual debug file.ual [args]
+//
+// The program pauses before its first statement, and then wherever a
+// breakpoint, a step or a watch on a stack's depth says to. While it is
+// paused, commands (see debugHelp) show stacks and variables, set and
+// delete breakpoints and watches, and resume it. Steps go a source line
+// at a time. Compute blocks run as a whole, and tasks started by @spawn
+// run without pausing.
+// ============================================================================
+
+const debugHelp = `break [file:]line     pause before the line, this one if none is given (b)
+watch @name [op n]    pause when the depth of @name changes, or when
+                      depth op n comes true (op is < <= == != >= >) (w)
+delete [n]            delete breakpoint or watch n, or all of them (d)
+info                  list the breakpoints and watches (i)
+step                  run to the next line, into calls (s)
+next                  run to the next line of this function or its callers (n)
+finish                run until this function returns (f)
+continue              run to the next breakpoint or watch (c)
+print @name|var ...   show stacks and variables (p)
+stacks                show every stack
+vars                  show the variables in scope
+where                 show the function calls being run (bt)
+list                  show the source around the pause (l)
+quit                  stop the program (q)
+An empty line repeats the last step, next, finish or continue.
+`
+
+// ErrInterrupt is what a debugger's read function returns when Ctrl-C
+// abandons the line being read
+var ErrInterrupt = errors.New("interrupt")
+
+type stepMode int
+
+const (
+	runFree  stepMode = iota // to a breakpoint or watch
+	stepInto                 // to the next line
+	stepOver                 // to the next line at depth or above
+	stepOut                  // to the next line above depth
+)
+
+type breakpoint struct {
+	id   int
+	file string
+	line int
+}
+
+// depthWatch pauses when the depth of a stack changes, or when a
+// comparison of it comes true
+type depthWatch struct {
+	id    int
+	stack string
+	op    string // "" to pause on every change
+	n     int
+	last  int  // the depth when last checked
+	held  bool // the comparison held when last checked
+}
+
+// frame is a function being called, and the statement calling it
+type frame struct {
+	name string
+	call ast.Pos
+}
+
+// Debugger pauses an Interpreter before statements and reads commands
+// while it is paused.
+type Debugger struct {
+	file string // main program, for statements without a file
+	read func(prompt string) (string, error)
+	out  io.Writer
+
+	breaks  []breakpoint
+	watches []*depthWatch
+	nextID  int
+
+	mode   stepMode
+	depth  int     // call depth a next or finish started at
+	frames []frame // functions being called, outermost first
+	prev   ast.Pos // the last statement reached
+	at     ast.Pos // where the program is paused
+	last   string  // the last command that resumed, for an empty line
+
+	sources  map[string][]string
+	detached bool // the commands ended, so the program runs to its end
+}
+
+// NewDebugger returns a debugger for the program in file, which reads its
+// commands with read and writes to out. The program pauses before its
+// first statement.
+func NewDebugger(file string, read func(prompt string) (string, error), out io.Writer) *Debugger {
+	return &Debugger{
+		file:    file,
+		read:    read,
+		out:     out,
+		mode:    stepInto,
+		sources: make(map[string][]string),
+	}
+}
+
+// SetDebugger pauses the program where d says to
+func (i *Interpreter) SetDebugger(d *Debugger) {
+	i.dbg = d
+}
+
+// enter and leave track the function calls, for next, finish and where
+func (d *Debugger) enter(name string) {
+	d.frames = append(d.frames, frame{name, d.prev})
+}
+
+func (d *Debugger) leave() {
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+// before is called before each statement, and pauses if a watch fires, a
+// step ends on its line or a breakpoint is on it
+func (d *Debugger) before(i *Interpreter, stmt ast.Stmt) {
+	if d.detached {
+		return
+	}
+	pos, ok := i.pos[stmt]
+	if !ok {
+		return
+	}
+	if pos.File == "" {
+		pos.File = d.file
+	}
+	newLine := pos.File != d.prev.File || pos.Line != d.prev.Line
+	d.prev = pos
+
+	var reasons []string
+	for _, w := range d.watches {
+		if r := w.check(i); r != "" {
+			reasons = append(reasons, r)
+		}
+	}
+	if newLine {
+		for _, b := range d.breaks {
+			if b.line == pos.Line && sameFile(b.file, pos.File) {
+				reasons = append(reasons, fmt.Sprintf("breakpoint %d", b.id))
+			}
+		}
+	}
+	pause := len(reasons) > 0
+	if newLine {
+		switch d.mode {
+		case stepInto:
+			pause = true
+		case stepOver:
+			pause = pause || len(d.frames) <= d.depth
+		case stepOut:
+			pause = pause || len(d.frames) < d.depth
+		}
+	}
+	if pause {
+		d.pause(i, pos, reasons)
+	}
+}
+
+// sameFile reports whether a breakpoint's file names path; a bare file
+// name matches that name in any directory
+func sameFile(file, path string) bool {
+	if filepath.Clean(file) == filepath.Clean(path) {
+		return true
+	}
+	return !strings.ContainsRune(file, filepath.Separator) && filepath.Base(path) == file
+}
+
+// check reports why w fires, or "" if it does not
+func (w *depthWatch) check(i *Interpreter) string {
+	depth := 0
+	if s, ok := i.stacks[w.stack]; ok {
+		depth = s.Len()
+	}
+	last := w.last
+	w.last = depth
+	if w.op == "" {
+		if depth == last {
+			return ""
+		}
+		return fmt.Sprintf("watch %d: @%s depth %d -> %d", w.id, w.stack, last, depth)
+	}
+	held := compareDepth(depth, w.op, w.n)
+	fire := held && !w.held
+	w.held = held
+	if !fire {
+		return ""
+	}
+	return fmt.Sprintf("watch %d: @%s depth %d %s %d", w.id, w.stack, depth, w.op, w.n)
+}
+
+func compareDepth(depth int, op string, n int) bool {
+	switch op {
+	case "<":
+		return depth < n
+	case "<=":
+		return depth <= n
+	case "==":
+		return depth == n
+	case "!=":
+		return depth != n
+	case ">=":
+		return depth >= n
+	default:
+		return depth > n
+	}
+}
+
+// pause shows where the program stopped and why, then runs commands until
+// one resumes it
+func (d *Debugger) pause(i *Interpreter, pos ast.Pos, reasons []string) {
+	d.at = pos
+	d.mode = runFree
+	fmt.Fprintf(d.out, "%s:%d", pos.File, pos.Line)
+	if len(reasons) > 0 {
+		fmt.Fprintf(d.out, " (%s)", strings.Join(reasons, "; "))
+	}
+	fmt.Fprintf(d.out, "\n%5d  %s\n", pos.Line, d.source(pos.File, pos.Line))
+	for {
+		line, err := d.read("(iual) ")
+		if err == ErrInterrupt {
+			continue
+		}
+		if err != nil {
+			d.detached = true
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if line = d.last; line == "" {
+				continue
+			}
+		}
+		if d.command(i, line) {
+			return
+		}
+	}
+}
+
+// command runs a debugger command, returning true if it resumes the
+// program
+func (d *Debugger) command(i *Interpreter, line string) bool {
+	args := strings.Fields(line)
+	switch args[0] {
+	case "step", "s":
+		d.mode = stepInto
+	case "next", "n":
+		d.mode, d.depth = stepOver, len(d.frames)
+	case "finish", "f":
+		if len(d.frames) == 0 {
+			fmt.Fprintln(d.out, "not in a function")
+			return false
+		}
+		d.mode, d.depth = stepOut, len(d.frames)
+	case "continue", "c":
+		d.mode = runFree
+	case "break", "b":
+		d.addBreak(args[1:])
+		return false
+	case "watch", "w":
+		d.addWatch(i, args[1:])
+		return false
+	case "delete", "d":
+		d.delete(args[1:])
+		return false
+	case "info", "i":
+		d.info()
+		return false
+	case "print", "p":
+		for _, name := range args[1:] {
+			if strings.HasPrefix(name, "@") {
+				i.WriteStacks(d.out, name)
+			} else if v, ok := i.vars.Get(name); ok {
+				fmt.Fprintf(d.out, "%s = %s\n", name, v.AsString())
+			} else {
+				fmt.Fprintf(d.out, "no variable %s\n", name)
+			}
+		}
+		return false
+	case "stacks":
+		i.WriteStacks(d.out, "")
+		return false
+	case "vars":
+		for _, name := range i.vars.Names() {
+			v, _ := i.vars.Get(name)
+			fmt.Fprintf(d.out, "%s = %s\n", name, v.AsString())
+		}
+		return false
+	case "where", "bt":
+		d.where()
+		return false
+	case "list", "l":
+		d.list()
+		return false
+	case "help", "h":
+		fmt.Fprint(d.out, debugHelp)
+		return false
+	case "quit", "q":
+		os.Exit(0)
+	default:
+		fmt.Fprintf(d.out, "unknown command %s (help lists them)\n", args[0])
+		return false
+	}
+	d.last = line
+	return true
+}
+
+// addBreak sets a breakpoint at [file:]line, or at the pause
+func (d *Debugger) addBreak(args []string) {
+	b := breakpoint{file: d.at.File, line: d.at.Line}
+	if len(args) > 0 {
+		spec := args[0]
+		if k := strings.LastIndexByte(spec, ':'); k >= 0 {
+			b.file, spec = spec[:k], spec[k+1:]
+		} else {
+			b.file = d.file
+		}
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 1 {
+			fmt.Fprintf(d.out, "bad line %q: want break [file:]line\n", args[0])
+			return
+		}
+		b.line = n
+	}
+	d.nextID++
+	b.id = d.nextID
+	d.breaks = append(d.breaks, b)
+	fmt.Fprintf(d.out, "breakpoint %d at %s:%d\n", b.id, b.file, b.line)
+}
+
+// addWatch watches the depth of @name, or a comparison of it
+func (d *Debugger) addWatch(i *Interpreter, args []string) {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "@") || (len(args) != 1 && len(args) != 3) {
+		fmt.Fprintln(d.out, "want watch @name, or watch @name op n")
+		return
+	}
+	w := &depthWatch{stack: args[0][1:]}
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		switch args[1] {
+		case "<", "<=", "==", "!=", ">=", ">":
+		default:
+			err = fmt.Errorf("bad op")
+		}
+		if err != nil {
+			fmt.Fprintf(d.out, "bad watch %q: op is one of < <= == != >= > and n a number\n", strings.Join(args[1:], " "))
+			return
+		}
+		w.op, w.n = args[1], n
+	}
+	w.check(i) // from the depth now
+	d.nextID++
+	w.id = d.nextID
+	d.watches = append(d.watches, w)
+	fmt.Fprintf(d.out, "watch %d on %s\n", w.id, w.describe())
+}
+
+func (w *depthWatch) describe() string {
+	if w.op == "" {
+		return "@" + w.stack + " depth"
+	}
+	return fmt.Sprintf("@%s depth %s %d", w.stack, w.op, w.n)
+}
+
+// delete deletes breakpoint or watch n, or all of them
+func (d *Debugger) delete(args []string) {
+	if len(args) == 0 {
+		d.breaks, d.watches = nil, nil
+		return
+	}
+	n, _ := strconv.Atoi(args[0])
+	for k, b := range d.breaks {
+		if b.id == n {
+			d.breaks = append(d.breaks[:k], d.breaks[k+1:]...)
+			return
+		}
+	}
+	for k, w := range d.watches {
+		if w.id == n {
+			d.watches = append(d.watches[:k], d.watches[k+1:]...)
+			return
+		}
+	}
+	fmt.Fprintf(d.out, "no breakpoint or watch %s\n", args[0])
+}
+
+func (d *Debugger) info() {
+	if len(d.breaks) == 0 && len(d.watches) == 0 {
+		fmt.Fprintln(d.out, "no breakpoints or watches")
+	}
+	for _, b := range d.breaks {
+		fmt.Fprintf(d.out, "%d  breakpoint at %s:%d\n", b.id, b.file, b.line)
+	}
+	for _, w := range d.watches {
+		fmt.Fprintf(d.out, "%d  watch on %s\n", w.id, w.describe())
+	}
+}
+
+// where writes the calls being run, innermost first
+func (d *Debugger) where() {
+	at := d.at
+	for k := len(d.frames) - 1; k >= 0; k-- {
+		fmt.Fprintf(d.out, "#%d  %s  %s:%d\n", len(d.frames)-1-k, d.frames[k].name, at.File, at.Line)
+		at = d.frames[k].call
+	}
+	fmt.Fprintf(d.out, "#%d  main  %s:%d\n", len(d.frames), at.File, at.Line)
+}
+
+// list writes the lines around the pause, marking its own
+func (d *Debugger) list() {
+	lines := d.sourceLines(d.at.File)
+	for n := d.at.Line - 5; n <= d.at.Line+5; n++ {
+		if n < 1 || n > len(lines) {
+			continue
+		}
+		mark := "  "
+		if n == d.at.Line {
+			mark = "=>"
+		}
+		fmt.Fprintf(d.out, "%s%4d  %s\n", mark, n, strings.TrimRight(lines[n-1], " \t\r"))
+	}
+}
+
+// source returns line n of file, without its indentation
+func (d *Debugger) source(file string, n int) string {
+	lines := d.sourceLines(file)
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n-1])
+}
+
+func (d *Debugger) sourceLines(file string) []string {
+	lines, ok := d.sources[file]
+	if !ok {
+		if data, err := os.ReadFile(file); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		d.sources[file] = lines
+	}
+	return lines
+}
@@ -0,0 +1,93 @@
+package interp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestDebugger(t *testing.T) {
+	source := `func sq(n i64) i64 {
+    var r i64 = n * n
+    return r
+}
+
+var k i64 = 1
+while (k <= 3) {
+    @dstack push(sq(k))
+    k = k + 1
+}
+`
+	path := filepath.Join(t.TempDir(), "sq.ual")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.NewParser(lexer.NewLexer(source).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := []string{
+		"next", // line 7
+		"break 9",
+		"watch @dstack >= 2",
+		"continue", // line 9, k = 1
+		"print k @dstack",
+		"",       // continue again: the watch and line 9, k = 2
+		"delete", // both
+		"break sq.ual:3",
+		"continue", // in sq, k = 3
+		"where",
+		"vars",
+		"finish", // back on line 9
+		"watch @dstack",
+		"continue", // the loop ends without another push
+	}
+	read := func(string) (string, error) {
+		if len(commands) == 0 {
+			return "", io.EOF
+		}
+		c := commands[0]
+		commands = commands[1:]
+		return c, nil
+	}
+	var out strings.Builder
+	interp := NewInterpreter()
+	interp.SetDebugger(NewDebugger(path, read, &out))
+	if err := interp.Run(prog); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 0 {
+		t.Errorf("commands left over: %q", commands)
+	}
+
+	for _, want := range []string{
+		path + ":6\n    6  var k i64 = 1\n", // paused before the first statement
+		"breakpoint 1 at " + path + ":9\n",
+		"watch 2 on @dstack depth >= 2\n",
+		path + ":9 (breakpoint 1)\n    9  k = k + 1\n",
+		"k = 1\n@dstack (LIFO i64): [1]\n",
+		path + ":9 (watch 2: @dstack depth 2 >= 2; breakpoint 1)\n",
+		path + ":3 (breakpoint 3)\n    3  return r\n",
+		"#0  sq  " + path + ":3\n#1  main  " + path + ":8\n",
+		"k = 3\nn = 3\nr = 9\n",
+		path + ":9\n",
+		path + ":7\n    7  while (k <= 3) {\n",
+		"watch 4 on @dstack depth\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "watch 4:") {
+		t.Errorf("watch 4 fired with no push after it:\n%s", out.String())
+	}
+	if len(interp.dbg.frames) != 0 {
+		t.Errorf("frames left: %v", interp.dbg.frames)
+	}
+}
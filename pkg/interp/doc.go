@@ -0,0 +1,8 @@
+// Package interp is the ual interpreter: it runs a program's syntax tree,
+// handing function bodies and top-level loops to the bytecode machine of
+// pkg/vm, and is the reference the compiled backends are checked against.
+//
+// iual drives it from the command line, with its REPL, debugger and
+// profiler. Applications that embed ual should use pkg/engine, which runs
+// programs on it with the application's streams and stacks.
+package interp
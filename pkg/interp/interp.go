@@ -0,0 +1,2432 @@
+package interp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/runtime"
+	"github.com/ha1tch/ual/pkg/vm"
+)
+
+// Type aliases for cleaner migration
+type Value = runtime.Value
+type ValueStack = runtime.ValueStack
+type ScopeStack = runtime.ScopeStack
+
+// Re-export constructors
+var (
+	NewInt       = runtime.NewInt
+	NewUint      = runtime.NewUint
+	NewFloat     = runtime.NewFloat
+	NewFloat32   = runtime.NewFloat32
+	NewString    = runtime.NewString
+	NewBool      = runtime.NewBool
+	NewError     = runtime.NewError
+	NewCodeblock = runtime.NewCodeblock
+	NewArray     = runtime.NewArray
+	NilValue     = runtime.NilValue
+)
+
+// Sentinel errors for control flow
+var (
+	errBreak    = errors.New("break")
+	errContinue = errors.New("continue")
+	errReturn   = errors.New("return")
+)
+
+// Interpreter executes a ual AST.
+type Interpreter struct {
+	funcs      map[string]*ast.FuncDecl // user-defined functions
+	stacks     map[string]*ValueStack   // named stacks
+	stackTypes map[string]string        // element types for each stack
+	structs    map[string]*runtime.StructType // layouts of struct stacks
+	views      map[string]*View         // named views
+	vars       *ScopeStack              // variable scopes
+	returnVal  Value                    // return value from last return statement
+	returnVals []Value                  // multiple return values
+	trace      bool                     // trace execution
+	filename   string                   // source filename for errors
+	pos        map[ast.Stmt]ast.Pos     // statement positions, from the parser
+	args       []string                 // program arguments for args blocks
+	
+	// For spawn/defer
+	spawnTasks []func()
+	spawnMu    sync.Mutex         // protects spawnTasks and spawnSched
+	spawnSched *runtime.Scheduler // runs played tasks, started on first play
+	spawnGroup runtime.SpawnGroup // played tasks, for @spawn wait
+	workers    int                // pool size, 0 for runtime.DefaultSpawnWorkers
+	deferStack []func()
+	
+	// For consider blocks
+	status      string
+	statusValue Value
+	
+	// For compute blocks (self reference)
+	computeStack  *ValueStack
+	computeStruct *runtime.StructType // layout of computeStack's elements, if structs
+	computeType   string              // element type of computeStack
+	
+	// Fast-path local variables for compute blocks (avoids scope walking)
+	localVars    map[string]Value
+	inComputeBlock bool
+	
+	// Compiled compute block cache (threaded code)
+	compiledCompute map[*ast.ComputeStmt]*CompiledCompute
+	
+	// For auto-print of top-level assigned variables
+	topLevelVars []string
+	inFunction   bool
+	
+	// Element types bound to the type parameters of the generic function
+	// being called
+	typeArgs map[string]string
+	
+	// Codeblock values: handle h is codeblocks[h-1] (see evalFnLit)
+	codeblocks  []*codeblock
+	codeblockMu sync.Mutex
+	
+	// For --profile: the shared profile, and the time taken by statements
+	// nested in each statement being timed
+	prof       *Profile
+	profNested []time.Duration
+	
+	// For iual debug: pauses before statements (not set in spawned tasks)
+	dbg *Debugger
+	
+	// For bytecode (see vm.go): the machine running it, nil to walk the
+	// tree, and the code of each function and top-level loop run so far,
+	// nil for those that cannot be compiled
+	machine *vm.Machine
+	protos  map[ast.Node]*vm.Proto
+	globals *ScopeStack // the global scope alone, for pure functions
+	
+	// For programs embedded in a Go application (see pkg/engine): where
+	// input comes from and output goes, nil for stdin, os.Stdout and
+	// os.Stderr, with no terminal to control; the variables env()
+	// sees, nil for the process environment; whether exit(code) returns an
+	// *ExitError instead of ending the process; and the error Interrupt
+	// stopped the program with; and the functions the application
+	// provides. Spawned tasks share them.
+	in           *lineInput
+	out, errOut  io.Writer
+	env          *envVars
+	exitReturns  bool
+	halt         *atomic.Pointer[error]
+	hostFuncs    map[string]HostFunc
+}
+
+// HostFunc is a function the application running the program provides,
+// called with the values of its arguments
+type HostFunc func(args []Value) (Value, error)
+
+// lineInput is where readline and prompt read lines from, in place of
+// stdin
+type lineInput struct {
+	mu sync.Mutex
+	r  *bufio.Reader
+}
+
+// envVars are the variables env() and setenv() use in place of the
+// process environment
+type envVars struct {
+	mu   sync.Mutex
+	vars map[string]string
+}
+
+// ExitError is what Run returns when the program calls exit(code), or
+// its args block is given bad arguments, if SetExitReturns is on.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// View represents a perspective on a stack.
+type View struct {
+	Name        string
+	Perspective string
+	Stack       *ValueStack
+	
+	// Broadcast views read through a runtime view, which holds the
+	// subscriber's cursor in the stack
+	sub *runtime.View
+}
+
+// attach binds the view to a stack, subscribing it if it is a Broadcast view.
+func (v *View) attach(stack *ValueStack) error {
+	if v.sub != nil {
+		if err := v.sub.Attach(stack.Stack()); err != nil {
+			return fmt.Errorf("view %s: %v", v.Name, err)
+		}
+	} else if stack.Perspective() == runtime.Broadcast {
+		return fmt.Errorf("view %s: only Broadcast views can attach to a Broadcast stack", v.Name)
+	}
+	v.Stack = stack
+	return nil
+}
+
+// perspectiveFromString converts a perspective string to runtime.Perspective.
+func perspectiveFromString(s string) runtime.Perspective {
+	switch s {
+	case "FIFO":
+		return runtime.FIFO
+	case "Indexed":
+		return runtime.Indexed
+	case "Hash":
+		return runtime.Hash
+	case "Broadcast":
+		return runtime.Broadcast
+	default:
+		return runtime.LIFO
+	}
+}
+
+// NewInterpreter creates a new interpreter.
+func NewInterpreter() *Interpreter {
+	interp := &Interpreter{
+		funcs:           make(map[string]*ast.FuncDecl),
+		stacks:          make(map[string]*ValueStack),
+		stackTypes:      make(map[string]string),
+		structs:         make(map[string]*runtime.StructType),
+		views:           make(map[string]*View),
+		vars:            runtime.NewScopeStack(),
+		compiledCompute: make(map[*ast.ComputeStmt]*CompiledCompute),
+		protos:          make(map[ast.Node]*vm.Proto),
+		halt:            new(atomic.Pointer[error]),
+		hostFuncs:       make(map[string]HostFunc),
+	}
+	interp.globals = interp.vars.FunctionScope()
+	interp.SetBytecode(true)
+	
+	// Create default stacks
+	interp.stacks["dstack"] = runtime.NewValueStack(runtime.LIFO)
+	interp.stackTypes["dstack"] = "i64"
+	interp.stacks["error"] = runtime.NewValueStack(runtime.LIFO)
+	interp.stackTypes["error"] = "string"
+	interp.stacks["rstack"] = runtime.NewValueStack(runtime.LIFO)
+	interp.stackTypes["rstack"] = "i64"
+	interp.stacks["spawn"] = runtime.NewValueStack(runtime.FIFO)
+	interp.stacks["defer"] = runtime.NewValueStack(runtime.LIFO)
+	interp.stacks["bool"] = runtime.NewValueStack(runtime.LIFO)
+	interp.stackTypes["bool"] = "bool"
+	
+	return interp
+}
+
+// SetTrace enables or disables tracing.
+func (i *Interpreter) SetTrace(trace bool) {
+	i.trace = trace
+}
+
+// SetFilename sets the source filename for error messages.
+func (i *Interpreter) SetFilename(filename string) {
+	i.filename = filename
+}
+
+// SetArgs sets the program arguments parsed by an args block.
+func (i *Interpreter) SetArgs(args []string) {
+	i.args = args
+}
+
+// SetWorkers bounds how many spawned tasks run at once.
+func (i *Interpreter) SetWorkers(n int) {
+	i.workers = n
+}
+
+// SetInput makes readline, prompt, confirm and password read from r in
+// place of stdin.
+func (i *Interpreter) SetInput(r io.Reader) {
+	i.in = &lineInput{r: bufio.NewReader(r)}
+}
+
+// SetOutput sends what the program prints to stdout, and the errors of
+// its spawned tasks to stderr. nil leaves os.Stdout or os.Stderr. Output
+// sent elsewhere is not a terminal: is_tty is false, color leaves text as
+// it is, and clear_line and progress do nothing.
+func (i *Interpreter) SetOutput(stdout, stderr io.Writer) {
+	i.out, i.errOut = stdout, stderr
+}
+
+// SetEnv makes env() and setenv() use vars in place of the process
+// environment. setenv() changes vars.
+func (i *Interpreter) SetEnv(vars map[string]string) {
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	i.env = &envVars{vars: vars}
+}
+
+// SetExitReturns makes exit(code) end Run with an *ExitError, after the
+// exit hooks but not the defers, instead of ending the process.
+func (i *Interpreter) SetExitReturns(on bool) {
+	i.exitReturns = on
+}
+
+// SetStack adds the stack s to the program as @name, of elements of type
+// elemType ("i64", "string" and so on), before it runs. The program can
+// use it as if it had declared it.
+func (i *Interpreter) SetStack(name, elemType string, s *ValueStack) {
+	i.stacks[name] = s
+	i.stackTypes[name] = elemType
+}
+
+// SetFunc makes fn callable from the program as name(...), before it runs.
+// A function the program declares with the same name replaces it.
+func (i *Interpreter) SetFunc(name string, fn HostFunc) {
+	i.hostFuncs[name] = fn
+}
+
+// Interrupt stops the program running with err, at the statement it is
+// running or the next iteration of its loop, and its spawned tasks
+// likewise. A program waiting in take or sleep stops when it wakes. Safe
+// to call from any goroutine.
+func (i *Interpreter) Interrupt(err error) {
+	i.halt.CompareAndSwap(nil, &err)
+	if m := i.machine; m != nil {
+		m.Interrupt(err)
+	}
+}
+
+// interrupted returns the error Interrupt gave, if it was called
+func (i *Interpreter) interrupted() error {
+	if err := i.halt.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// stops reports whether err ends the program however it happens, so that
+// consider does not take it for an error of the block it runs
+func (i *Interpreter) stops(err error) bool {
+	var exit *ExitError
+	return errors.As(err, &exit) || (i.interrupted() != nil && errors.Is(err, i.interrupted()))
+}
+
+// stdout is where the program prints
+func (i *Interpreter) stdout() io.Writer {
+	if i.out != nil {
+		return i.out
+	}
+	return os.Stdout
+}
+
+// stderr is where the errors of spawned tasks are reported
+func (i *Interpreter) stderr() io.Writer {
+	if i.errOut != nil {
+		return i.errOut
+	}
+	return os.Stderr
+}
+
+// readLine is readline(): the next line of input, from SetInput's reader
+// if it was called
+func (i *Interpreter) readLine() (string, error) {
+	if i.in == nil {
+		return runtime.ReadLine()
+	}
+	i.in.mu.Lock()
+	defer i.in.mu.Unlock()
+	return runtime.ReadLineFrom(i.in.r)
+}
+
+// prompt is prompt(msg), confirm(msg) or password(msg) reading from
+// SetInput's reader, which has no echo to turn off
+func (i *Interpreter) prompt(name, msg string) Value {
+	if name == "confirm" {
+		msg += " [y/N] "
+	}
+	fmt.Fprint(i.stdout(), msg)
+	line, _ := i.readLine()
+	if name == "confirm" {
+		answer := strings.ToLower(strings.TrimSpace(line))
+		return NewBool(answer == "y" || answer == "yes")
+	}
+	return NewString(line)
+}
+
+// getenv is env(): the variable name, from SetEnv's if it was called
+func (i *Interpreter) getenv(name string) (string, bool) {
+	if i.env == nil {
+		return runtime.Env(name)
+	}
+	i.env.mu.Lock()
+	defer i.env.mu.Unlock()
+	v, ok := i.env.vars[name]
+	return v, ok
+}
+
+// setenv is setenv(): it sets the variable name, in SetEnv's if it was
+// called, refusing the names the process environment refuses
+func (i *Interpreter) setenv(name, value string) error {
+	if i.env == nil {
+		return runtime.SetEnv(name, value)
+	}
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return errors.New("setenv: invalid argument") // as os.Setenv says
+	}
+	i.env.mu.Lock()
+	defer i.env.mu.Unlock()
+	i.env.vars[name] = value
+	return nil
+}
+
+// scheduler returns the pool that runs played spawn tasks, starting it.
+func (i *Interpreter) scheduler() *runtime.Scheduler {
+	i.spawnMu.Lock()
+	defer i.spawnMu.Unlock()
+	if i.spawnSched == nil {
+		workers := i.workers
+		if workers <= 0 {
+			workers = runtime.DefaultSpawnWorkers
+		}
+		i.spawnSched = runtime.NewScheduler(workers)
+	}
+	return i.spawnSched
+}
+
+// waitSpawned waits for every played task and stops the pool.
+func (i *Interpreter) waitSpawned() {
+	i.spawnMu.Lock()
+	sched := i.spawnSched
+	i.spawnSched = nil
+	i.spawnMu.Unlock()
+	if sched != nil {
+		sched.Drain()
+		sched.Shutdown(context.Background())
+	}
+}
+
+// Run executes a program.
+func (i *Interpreter) Run(prog *ast.Program) error {
+	// Exit hooks run last, after the defers and the results below
+	defer runtime.RunAtExit()
+	i.pos = prog.Pos
+	
+	// First pass: collect function declarations
+	for _, stmt := range prog.Stmts {
+		if fn, ok := stmt.(*ast.FuncDecl); ok {
+			i.funcs[fn.Name] = fn
+		}
+	}
+	if i.machine != nil {
+		i.machine.Invalidate()
+	}
+	
+	// Second pass: execute top-level statements
+	for _, stmt := range prog.Stmts {
+		if _, ok := stmt.(*ast.FuncDecl); ok {
+			continue // skip function declarations
+		}
+		if err := i.execTop(stmt); err != nil {
+			if errors.Is(err, errReturn) {
+				continue // top-level return is ok
+			}
+			// Run defers before returning error; exit skips them
+			var exit *ExitError
+			if !errors.As(err, &exit) {
+				i.runDefers()
+			}
+			return err
+		}
+	}
+	
+	// Wait for all spawned tasks to complete
+	i.waitSpawned()
+	
+	// Run defers in LIFO order
+	i.runDefers()
+	
+	// Auto-print top-level assigned variables (like compiler does)
+	i.PrintVars(i.topLevelVars)
+	
+	return nil
+}
+
+// Eval executes the statements of prog after those already run, as the
+// REPL does with each input: functions and stacks declared before stay,
+// and spawned tasks are waited for. Defers and exit hooks are left for
+// Close.
+func (i *Interpreter) Eval(prog *ast.Program) error {
+	if i.pos == nil {
+		i.pos = make(map[ast.Stmt]ast.Pos)
+	}
+	for s, p := range prog.Pos {
+		i.pos[s] = p
+	}
+	for _, stmt := range prog.Stmts {
+		if fn, ok := stmt.(*ast.FuncDecl); ok {
+			i.funcs[fn.Name] = fn
+		}
+	}
+	if i.machine != nil {
+		i.machine.Invalidate()
+	}
+	defer i.waitSpawned()
+	for _, stmt := range prog.Stmts {
+		if _, ok := stmt.(*ast.FuncDecl); ok {
+			continue
+		}
+		if err := i.execTop(stmt); err != nil && !errors.Is(err, errReturn) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close ends a session of Eval calls, running the defers and exit hooks.
+func (i *Interpreter) Close() {
+	i.waitSpawned()
+	i.runDefers()
+	runtime.RunAtExit()
+}
+
+// WriteStacks writes the stack named by arg, bottom to top, or every
+// stack if arg is empty
+func (i *Interpreter) WriteStacks(w io.Writer, arg string) {
+	names := []string{strings.TrimPrefix(arg, "@")}
+	if arg == "" {
+		names = names[:0]
+		for name := range i.stacks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		stack, ok := i.stacks[name]
+		if !ok {
+			fmt.Fprintf(w, "no stack @%s\n", name)
+			continue
+		}
+		fmt.Fprintf(w, "@%s (%s", name, perspectiveName(stack.Perspective()))
+		if t := i.stackTypes[name]; t != "" {
+			fmt.Fprintf(w, " %s", t)
+		}
+		fmt.Fprint(w, "): ")
+		var elems []string
+		if stack.IsHash() {
+			for _, key := range stack.Stack().Keys() {
+				v, _ := stack.Get(key)
+				elems = append(elems, key+": "+i.formatElement(name, v))
+			}
+			fmt.Fprintf(w, "{%s}\n", strings.Join(elems, ", "))
+			continue
+		}
+		for _, v := range stack.All() {
+			elems = append(elems, i.formatElement(name, v))
+		}
+		fmt.Fprintf(w, "[%s]\n", strings.Join(elems, " "))
+	}
+}
+
+// perspectiveName is the name a declaration gives p
+func perspectiveName(p runtime.Perspective) string {
+	switch p {
+	case runtime.FIFO:
+		return "FIFO"
+	case runtime.Indexed:
+		return "Indexed"
+	case runtime.Hash:
+		return "Hash"
+	case runtime.Broadcast:
+		return "Broadcast"
+	default:
+		return "LIFO"
+	}
+}
+
+// PrintVars prints the variables names, as a program does at its end.
+func (i *Interpreter) PrintVars(names []string) {
+	for _, name := range names {
+		if val, ok := i.vars.Get(name); ok {
+			switch val.Type {
+			case runtime.VTInt:
+				fmt.Fprintf(i.stdout(), "%s = %s\n", name, val.AsString()) // unsigned as unsigned
+			case runtime.VTFloat:
+				if val.IsFloat32() {
+					fmt.Fprintf(i.stdout(), "%s = %v\n", name, float32(val.AsFloat()))
+				} else {
+					fmt.Fprintf(i.stdout(), "%s = %v\n", name, val.AsFloat())
+				}
+			case runtime.VTString:
+				fmt.Fprintf(i.stdout(), "%s = %s\n", name, val.AsString())
+			case runtime.VTBool:
+				fmt.Fprintf(i.stdout(), "%s = %v\n", name, val.AsBool())
+			default:
+				fmt.Fprintf(i.stdout(), "%s = %v\n", name, val.AsString())
+			}
+		}
+	}
+}
+
+// runDefers executes all deferred functions in LIFO order.
+func (i *Interpreter) runDefers() {
+	for idx := len(i.deferStack) - 1; idx >= 0; idx-- {
+		i.deferStack[idx]()
+	}
+	i.deferStack = nil
+}
+
+// execStmt executes a statement.
+func (i *Interpreter) execStmt(stmt ast.Stmt) error {
+	if err := i.interrupted(); err != nil {
+		return err
+	}
+	if i.trace {
+		fmt.Fprintf(i.stdout(), "[TRACE] execStmt: %T\n", stmt)
+	}
+	if i.prof != nil {
+		if pos, ok := i.prof.pos[stmt]; ok {
+			defer i.profileStmt(pos)()
+		}
+	}
+	if i.dbg != nil {
+		i.dbg.before(i, stmt)
+	}
+	
+	switch s := stmt.(type) {
+	case *ast.StackDecl:
+		return i.execStackDecl(s)
+	case *ast.ViewDecl:
+		return i.execViewDecl(s)
+	case *ast.VarDecl:
+		return i.execVarDecl(s)
+	case *ast.ArgsDecl:
+		return i.execArgsDecl(s)
+	case *ast.TestBlock, *ast.BenchBlock:
+		// Test and bench blocks only run under ual test and ual bench
+		return nil
+	case *ast.ImportStmt:
+		// Top-level imports are resolved before the program runs
+		return fmt.Errorf("line %d: import %q must be at the top level", s.Line, s.Path)
+	case *ast.Assignment:
+		return i.execAssignment(s)
+	case *ast.ArrayDecl:
+		return i.execArrayDecl(s)
+	case *ast.AssignStmt:
+		return i.execAssignStmt(s)
+	case *ast.IndexedAssignStmt:
+		return i.execIndexedAssignStmt(s)
+	case *ast.LetAssign:
+		return i.execLetAssign(s)
+	case *ast.StackOp:
+		return i.execStackOp(s)
+	case *ast.StackBlock:
+		return i.execStackBlock(s)
+	case *ast.IfStmt:
+		return i.execIfStmt(s)
+	case *ast.WhileStmt:
+		return i.execWhileStmt(s)
+	case *ast.ForStmt:
+		return i.execForStmt(s)
+	case *ast.RangeStmt:
+		return i.execRangeStmt(s)
+	case *ast.BreakStmt:
+		return errBreak
+	case *ast.ContinueStmt:
+		return errContinue
+	case *ast.FuncDecl:
+		// Already collected in first pass
+		return nil
+	case *ast.FuncCall:
+		_, err := i.execFuncCall(s)
+		return err
+	case *ast.ReturnStmt:
+		return i.execReturnStmt(s)
+	case *ast.DeferStmt:
+		return i.execDeferStmt(s)
+	case *ast.AtExitStmt:
+		return i.execAtExitStmt(s)
+	case *ast.PanicStmt:
+		return i.execPanicStmt(s)
+	case *ast.TryStmt:
+		return i.execTryStmt(s)
+	case *ast.ConsiderStmt:
+		return i.execConsiderStmt(s)
+	case *ast.StatusStmt:
+		return i.execStatusStmt(s)
+	case *ast.SelectStmt:
+		return i.execSelectStmt(s)
+	case *ast.ComputeStmt:
+		return i.execComputeStmt(s)
+	case *ast.ErrorPush:
+		return i.execErrorPush(s)
+	case *ast.SpawnPush:
+		return i.execSpawnPush(s)
+	case *ast.SpawnOp:
+		return i.execSpawnOp(s)
+	case *ast.ViewOp:
+		return i.execViewOp(s)
+	case *ast.ExprStmt:
+		_, err := i.evalExpr(s.Expr)
+		return err
+	case *ast.Block:
+		return i.execBlock(s.Stmts)
+	default:
+		return fmt.Errorf("unknown statement type: %T", stmt)
+	}
+}
+
+// execBlock executes a block of statements.
+func (i *Interpreter) execBlock(stmts []ast.Stmt) error {
+	for _, stmt := range stmts {
+		if err := i.execStmt(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execScope executes the body of an if or while. Variables it declares
+// belong to it, so it runs in a scope of its own when it declares any.
+func (i *Interpreter) execScope(stmts []ast.Stmt) error {
+	if i.inComputeBlock && i.localVars != nil || !declaresVars(stmts) {
+		return i.execBlock(stmts)
+	}
+	i.vars.PushScope()
+	defer i.vars.PopScope()
+	return i.execBlock(stmts)
+}
+
+// declaresVars reports whether stmts include a var declaration
+func declaresVars(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.VarDecl); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// execStackDecl creates a new stack.
+func (i *Interpreter) execStackDecl(s *ast.StackDecl) error {
+	// For local stacks, always create (allows shadowing global stacks in spawn)
+	// For global stacks, skip if already exists (matches compiler behavior)
+	// Stacks typed by a type parameter are made for each call, as the
+	// type may differ between calls
+	if _, generic := i.typeArgs[s.ElementType]; !s.Local && !generic {
+		if _, exists := i.stacks[s.Name]; exists {
+			return nil
+		}
+	}
+	
+	persp := s.Perspective
+	if persp == "" {
+		persp = "LIFO"
+	}
+	
+	if s.Capacity > 0 {
+		i.stacks[s.Name] = runtime.NewCappedValueStack(perspectiveFromString(persp), s.Capacity)
+	} else {
+		i.stacks[s.Name] = runtime.NewValueStack(perspectiveFromString(persp))
+	}
+	
+	// Track element type; fn values are codeblock handles
+	elemType := s.ElementType
+	if t, ok := i.typeArgs[elemType]; ok {
+		elemType = t
+	}
+	if elemType == "" || elemType == "fn" {
+		elemType = "i64"
+	}
+	i.stackTypes[s.Name] = elemType
+	if elemType == "struct" {
+		fields := make([]runtime.StructField, len(s.Fields))
+		for idx, f := range s.Fields {
+			fields[idx] = runtime.StructField{Name: f.Name, Type: structFieldType(f.Type)}
+		}
+		i.structs[s.Name] = runtime.NewStructType(fields...)
+	}
+	
+	// An embed declaration's file was read by module.Load; bytes
+	// elements are held as strings, as for any bytes stack
+	if s.Embed != nil {
+		stack := i.stacks[s.Name]
+		for _, e := range s.Embed.Elements() {
+			if err := stack.Push(NewString(e)); err != nil {
+				return err
+			}
+		}
+		stack.Freeze()
+	}
+	
+	return nil
+}
+
+func structFieldType(t string) runtime.ElementType {
+	switch t {
+	case "f64":
+		return runtime.TypeFloat64
+	case "u64":
+		return runtime.TypeUint64
+	case "bool":
+		return runtime.TypeBool
+	}
+	return runtime.TypeInt64
+}
+
+// elementType returns the runtime element type of a stack of type t
+func elementType(t string) runtime.ElementType {
+	switch t {
+	case "i32":
+		return runtime.TypeInt32
+	case "u32":
+		return runtime.TypeUint32
+	case "f32":
+		return runtime.TypeFloat32
+	case "string":
+		return runtime.TypeString
+	case "bytes":
+		return runtime.TypeBytes
+	}
+	if runtime.UintBits(t) > 0 {
+		return runtime.TypeUint64
+	}
+	return structFieldType(t)
+}
+
+// execViewDecl creates a new view.
+func (i *Interpreter) execViewDecl(s *ast.ViewDecl) error {
+	// Create view with the specified perspective
+	view := &View{
+		Name:        s.Name,
+		Perspective: s.Perspective,
+		Stack:       nil, // Will be set by attach
+	}
+	if s.Perspective == "Broadcast" {
+		view.sub = runtime.NewView(runtime.Broadcast)
+	}
+	i.views[s.Name] = view
+	return nil
+}
+
+// execVarDecl declares variables.
+func (i *Interpreter) execVarDecl(s *ast.VarDecl) error {
+	typ := s.Type
+	if t, ok := i.typeArgs[typ]; ok {
+		typ = t
+	}
+	for idx, name := range s.Names {
+		var val Value
+		hasValue := idx < len(s.Values) && s.Values[idx] != nil
+		
+		if hasValue {
+			v, err := i.evalExpr(s.Values[idx])
+			if err != nil {
+				return err
+			}
+			val = v
+			// Integer literals initialise float variables, as in the compilers
+			if (typ == "f64" || typ == "f32") && val.Type == runtime.VTInt {
+				val = NewFloat(val.AsFloat())
+			}
+		} else {
+			// Zero value based on type
+			switch typ {
+			case "i64", "i32", "i16", "i8", "u64", "u32", "u16", "u8", "fn":
+				val = NewInt(0)
+			case "f64", "f32":
+				val = NewFloat(0)
+			case "string":
+				val = NewString("")
+			case "bool":
+				val = NewBool(false)
+			default:
+				val = NilValue
+			}
+		}
+		
+		// u8..u64 variables hold unsigned values, cut to their width, and
+		// f32 ones floats rounded to an f32
+		if bits := runtime.UintBits(typ); bits > 0 && val.IsNumeric() {
+			val = NewUint(uint64(val.AsInt()), bits)
+		} else if typ == "f32" && val.IsNumeric() {
+			val = NewFloat32(val.AsFloat())
+		}
+		
+		// Fast path: use local vars cache in compute blocks
+		if i.inComputeBlock && i.localVars != nil {
+			i.localVars[name] = val
+		} else {
+			// Declared in the innermost scope, hiding any outer variable
+			i.vars.Set(name, val)
+		}
+	}
+	return nil
+}
+
+// execArgsDecl parses the program arguments against an args block, exiting
+// with usage on -h/--help or a parse error, then declares one variable per
+// entry and stores every value in the @args Hash stack.
+func (i *Interpreter) execArgsDecl(s *ast.ArgsDecl) error {
+	specs := make([]runtime.ArgSpec, len(s.Specs))
+	for idx, sp := range s.Specs {
+		specs[idx] = runtime.ArgSpec{Kind: sp.Kind, Name: sp.Name, Short: sp.Short, Type: sp.Type, Help: sp.Help}
+		if sp.Default != nil {
+			def, err := i.evalExpr(sp.Default)
+			if err != nil {
+				return err
+			}
+			specs[idx].Default, specs[idx].HasDefault = def.AsString(), true
+		}
+	}
+	
+	prog := s.Program
+	if prog == "" {
+		prog = strings.TrimSuffix(filepath.Base(i.filename), ".ual")
+	}
+	var a *runtime.Args
+	if i.exitReturns {
+		// As ParseArgsOrExit, without ending the process
+		var err error
+		a, err = runtime.ParseArgs(specs, i.args)
+		if err == runtime.ErrHelp {
+			fmt.Fprint(i.stdout(), runtime.ArgsUsage(prog, specs))
+			return &ExitError{Code: 0}
+		}
+		if err != nil {
+			fmt.Fprintf(i.stderr(), "%s: %v\n%s", prog, err, runtime.ArgsUsage(prog, specs))
+			return &ExitError{Code: 2}
+		}
+	} else {
+		a = runtime.ParseArgsOrExit(prog, specs, i.args)
+	}
+	
+	argsStack, exists := i.stacks["args"]
+	if !exists {
+		argsStack = runtime.NewValueStack(runtime.Hash)
+		i.stacks["args"] = argsStack
+		i.stackTypes["args"] = "string"
+	}
+	for _, sp := range s.Specs {
+		var val Value
+		switch sp.Type {
+		case "bool":
+			val = NewBool(a.Bool(sp.Name))
+		case "i64":
+			val = NewInt(a.Int(sp.Name))
+		case "f64":
+			val = NewFloat(a.Float(sp.Name))
+		default:
+			val = NewString(a.String(sp.Name))
+		}
+		i.vars.SetOrUpdate(strings.ReplaceAll(sp.Name, "-", "_"), val)
+		if err := argsStack.Set(sp.Name, NewString(a.String(sp.Name))); err != nil {
+			return fmt.Errorf("@args: %v", err)
+		}
+	}
+	return nil
+}
+
+// execArrayDecl declares a local array (for compute blocks).
+func (i *Interpreter) execArrayDecl(s *ast.ArrayDecl) error {
+	// Create an array as a slice of Values
+	arr := make([]Value, s.Size)
+	for idx := range arr {
+		arr[idx] = NewInt(0)
+	}
+	i.vars.Set(s.Name, NewArray(arr))
+	return nil
+}
+
+// execAssignStmt assigns a value to a variable.
+func (i *Interpreter) execAssignStmt(s *ast.AssignStmt) error {
+	val, err := i.evalExpr(s.Value)
+	if err != nil {
+		return err
+	}
+	
+	// Fast path: use local vars cache in compute blocks
+	if i.inComputeBlock && i.localVars != nil {
+		if _, exists := i.localVars[s.Name]; exists {
+			i.localVars[s.Name] = val
+			return nil
+		}
+	}
+	
+	// Try to update existing variable first
+	if !i.updateVar(s.Name, val) {
+		// Otherwise create new
+		i.vars.Set(s.Name, val)
+	}
+	return nil
+}
+
+// execIndexedAssignStmt assigns to an array index.
+func (i *Interpreter) execIndexedAssignStmt(s *ast.IndexedAssignStmt) error {
+	idx, err := i.evalExpr(s.Index)
+	if err != nil {
+		return err
+	}
+	val, err := i.evalExpr(s.Value)
+	if err != nil {
+		return err
+	}
+	
+	if s.Target == "self" {
+		if s.Member == "" || i.computeStack == nil {
+			return fmt.Errorf("self indexing not supported outside compute blocks")
+		}
+		return i.assignView(s.Member, int(idx.AsInt()), val)
+	}
+	
+	// Regular array assignment
+	arrVal, ok := i.vars.Get(s.Target)
+	if !ok {
+		return fmt.Errorf("undefined array: %s", s.Target)
+	}
+	
+	if !arrVal.IsArray() {
+		return fmt.Errorf("%s is not an array", s.Target)
+	}
+	
+	arr := arrVal.AsArray()
+	index := int(idx.AsInt())
+	if index < 0 || index >= len(arr) {
+		return fmt.Errorf("array index out of bounds: %d (len %d)", index, len(arr))
+	}
+	
+	arr[index] = val
+	return nil
+}
+
+// assignView sets self.name[index] to val in a compute block. The property
+// is an array, or a scalar read as an array of one; val is stored as the
+// stack's element type.
+func (i *Interpreter) assignView(name string, index int, val Value) error {
+	cur, ok := i.computeStack.Get(name)
+	if !ok {
+		return fmt.Errorf("undefined self member: %s", name)
+	}
+	arr := []Value{cur}
+	if cur.IsArray() {
+		arr = append([]Value(nil), cur.AsArray()...)
+	}
+	if index < 0 || index >= len(arr) {
+		return fmt.Errorf("self.%s index out of bounds: %d (len %d)", name, index, len(arr))
+	}
+	arr[index] = convertValueToType(val, i.computeType)
+	if cur.IsArray() {
+		return i.computeStack.Set(name, NewArray(arr))
+	}
+	return i.computeStack.Set(name, arr[0])
+}
+
+// execLetAssign pops from stack and assigns to variable.
+func (i *Interpreter) execLetAssign(s *ast.LetAssign) error {
+	stackName := s.Stack
+	if stackName == "" {
+		stackName = "dstack"
+	}
+	
+	stack, ok := i.stacks[stackName]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", stackName)
+	}
+	
+	val, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	
+	// An existing variable is updated where it lives, as by assignment
+	if !i.updateVar(s.Name, val) {
+		i.vars.Set(s.Name, val)
+	}
+	return nil
+}
+
+// popTo pops from stack, the stack of s, the value s, a pop:var, stores in
+// its variable, which holds cur. The types must match exactly, and an
+// empty stack gives 0, as in the compiled code.
+func (i *Interpreter) popTo(s *ast.StackOp, stack *ValueStack, cur Value) (Value, error) {
+	stackElemType := i.stackTypes[s.Stack]
+	if stackElemType == "" {
+		stackElemType = "i64" // dstack default
+	}
+	varType := varTypeOf(cur)
+	if !isStrictTypeMatch(heldType(stackElemType), varType) {
+		return NilValue, fmt.Errorf("cannot pop from @%s (%s) to variable '%s' (%s); types must match exactly (use bring() for conversion)",
+			s.Stack, stackElemType, s.Target, varType)
+	}
+	
+	val, err := stack.Pop()
+	if err != nil {
+		// Empty stack - use zero value like compiled version
+		val = NewInt(0)
+	}
+	if bits := cur.UintBits(); bits > 0 && val.IsNumeric() {
+		val = NewUint(uint64(val.AsInt()), bits)
+	} else if cur.IsFloat32() && val.IsNumeric() {
+		val = NewFloat32(val.AsFloat())
+	}
+	return val, nil
+}
+
+// valueTypeToString converts a ValueType to its string representation
+func valueTypeToString(vt runtime.ValueType) string {
+	switch vt {
+	case runtime.VTInt:
+		return "i64"
+	case runtime.VTFloat:
+		return "f64"
+	case runtime.VTString:
+		return "string"
+	case runtime.VTBool:
+		return "bool"
+	default:
+		return "unknown"
+	}
+}
+
+// varTypeOf returns the type of the variable holding v, for the checks of
+// pop and let: u8..u64 variables hold unsigned ints
+func varTypeOf(v Value) string {
+	if bits := v.UintBits(); bits > 0 {
+		return fmt.Sprintf("u%d", bits)
+	}
+	return valueTypeToString(v.Type)
+}
+
+// updateVar sets the existing variable name to val, reporting whether
+// there was one. An unsigned variable stays unsigned, cut to its width,
+// and an f32 one an f32.
+func (i *Interpreter) updateVar(name string, val Value) bool {
+	if old, ok := i.vars.Get(name); ok {
+		if bits := old.UintBits(); bits > 0 && val.IsNumeric() {
+			val = NewUint(uint64(val.AsInt()), bits)
+		} else if old.IsFloat32() && val.IsNumeric() {
+			val = NewFloat32(val.AsFloat())
+		}
+	}
+	return i.vars.Update(name, val)
+}
+
+// isTypeCompatibleIual checks if srcType can be stored in a stack of dstType
+// This is used for push operations where numeric literals adapt to target type
+func isTypeCompatibleIual(srcType, dstType string) bool {
+	if srcType == dstType {
+		return true
+	}
+	// i64 → f64: allowed (promotion for push)
+	if srcType == "i64" && dstType == "f64" {
+		return true
+	}
+	// bool → i64: allowed
+	if srcType == "bool" && dstType == "i64" {
+		return true
+	}
+	// i64, bool → u8..u64: allowed, keeping the low bits
+	if runtime.UintBits(dstType) > 0 && (srcType == "i64" || srcType == "bool") {
+		return true
+	}
+	// i64, bool → i32 keeps the low bits; i8 and i16 stacks hold i64s as
+	// in the compilers; i64, f64 → f32 rounds
+	if ((dstType == "i32" || dstType == "i16" || dstType == "i8") && (srcType == "i64" || srcType == "bool")) ||
+		(dstType == "f32" && (srcType == "i64" || srcType == "f64")) {
+		return true
+	}
+	// Everything else: not compatible
+	return false
+}
+
+// isStrictTypeMatch checks if types match exactly (for let/pop operations)
+// Only bring() can convert between types
+func isStrictTypeMatch(srcType, dstType string) bool {
+	return srcType == dstType
+}
+
+// heldType returns the type of the values a stack of elemType holds:
+// narrower signed integers are i64 and f32 is f64, as variables hold them
+func heldType(elemType string) string {
+	switch elemType {
+	case "i8", "i16", "i32":
+		return "i64"
+	case "f32":
+		return "f64"
+	}
+	return elemType
+}
+
+// isNumericElem reports whether a stack of elemType holds numbers
+func isNumericElem(elemType string) bool {
+	switch elemType {
+	case "i8", "i16", "i32", "i64", "u8", "u16", "u32", "u64", "f32", "f64":
+		return true
+	}
+	return false
+}
+
+// convertValueForStack converts a value to match the target stack type
+func convertValueForStack(val Value, dstType string) Value {
+	srcType := valueTypeToString(val.Type)
+	if srcType == dstType {
+		return val
+	}
+	// i64 → f64
+	if srcType == "i64" && dstType == "f64" {
+		return NewFloat(float64(val.AsInt()))
+	}
+	// bool → i64
+	if srcType == "bool" && dstType == "i64" {
+		if val.AsBool() {
+			return NewInt(1)
+		}
+		return NewInt(0)
+	}
+	// i64, bool → u8..u64, i32, f32
+	if runtime.UintBits(dstType) > 0 || dstType == "i32" || dstType == "f32" {
+		return convertValueToType(val, dstType)
+	}
+	return val
+}
+
+// canConvertTypes checks if srcType can be converted to dstType via bring()
+func canConvertTypes(srcType, dstType string) bool {
+	if srcType == dstType {
+		return true
+	}
+	// Numeric conversions are allowed
+	numericTypes := map[string]bool{"i64": true, "i32": true, "f64": true, "f32": true, "bool": true}
+	isNumeric := func(t string) bool { return numericTypes[t] || runtime.UintBits(t) > 0 }
+	if isNumeric(srcType) && isNumeric(dstType) {
+		return true
+	}
+	// String conversions are not allowed (too risky)
+	return false
+}
+
+// convertValueToType converts a value to the target type (for bring)
+func convertValueToType(val Value, dstType string) Value {
+	srcType := valueTypeToString(val.Type)
+	if srcType == dstType {
+		return val
+	}
+	switch dstType {
+	case "i64":
+		// f64 → i64: truncate
+		// bool → i64: 0/1
+		return NewInt(val.AsInt())
+	case "f64":
+		// i64 → f64: promote
+		// bool → f64: 0.0/1.0
+		return NewFloat(val.AsFloat())
+	case "i32":
+		return NewInt(int64(int32(val.AsInt())))
+	case "i16":
+		return NewInt(int64(int16(val.AsInt())))
+	case "i8":
+		return NewInt(int64(int8(val.AsInt())))
+	case "f32":
+		return NewFloat32(val.AsFloat())
+	case "bool":
+		// numeric → bool: non-zero = true
+		return NewBool(val.AsBool())
+	}
+	if bits := runtime.UintBits(dstType); bits > 0 {
+		// numeric → u8..u64: keep the low bits
+		return NewInt(int64(runtime.WrapUint(uint64(val.AsInt()), bits)))
+	}
+	return val
+}
+
+// execStackOp executes a stack operation.
+func (i *Interpreter) execStackOp(s *ast.StackOp) error {
+	stack, ok := i.stacks[s.Stack]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", s.Stack)
+	}
+	
+	// Unsigned stacks wrap arithmetic to their width
+	if bits := runtime.UintBits(i.stackTypes[s.Stack]); bits > 0 && unsignedOps[s.Op] {
+		return i.execUnsignedOp(stack, s.Op, bits)
+	}
+	// i32 and f32 stacks keep results at their width
+	if t := i.stackTypes[s.Stack]; (t == "i32" || t == "f32") && unsignedOps[s.Op] {
+		return i.execNarrowOp(stack, s.Op, t)
+	}
+	
+	switch s.Op {
+	case "push":
+		// Get stack's declared element type
+		elemType := i.stackTypes[s.Stack]
+		if elemType == "struct" {
+			for _, arg := range s.Args {
+				val, err := i.evalStruct(s.Stack, arg)
+				if err != nil {
+					return err
+				}
+				if err := stack.Push(val); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		
+		for _, arg := range s.Args {
+			val, err := i.evalExpr(arg)
+			if err != nil {
+				return err
+			}
+			if err := i.pushValue(stack, s.Stack, val); err != nil {
+				return err
+			}
+		}
+	case "pop":
+		if s.Target != "" {
+			// pop:var — direct assignment to variable
+			// Variable must be explicitly declared
+			if !i.vars.Has(s.Target) {
+				return fmt.Errorf("cannot pop to undeclared variable '%s'; use 'var %s type = value' first", s.Target, s.Target)
+			}
+			
+			existingVal, _ := i.vars.Get(s.Target)
+			val, err := i.popTo(s, stack, existingVal)
+			if err != nil {
+				return err
+			}
+			i.updateVar(s.Target, val)
+		} else if s.Stack != "dstack" {
+			// Forth model: pop from named stack pushes to dstack
+			val, err := stack.Pop()
+			if err != nil {
+				val = NewInt(0)
+			}
+			// Check type compatibility - only i64 can be pushed to dstack
+			elemType := i.stackTypes[s.Stack]
+			if elemType != "" && elemType != "i64" {
+				return fmt.Errorf("cannot pop from @%s (%s) to @dstack without target variable; use '@%s pop:varname' or '@%s dot'",
+					s.Stack, elemType, s.Stack, s.Stack)
+			}
+			i.stacks["dstack"].Push(val)
+		} else {
+			// Pop from dstack and discard
+			_, _ = stack.Pop()
+		}
+	case "let":
+		// let:name - pop from stack and assign to variable
+		// Variable name comes from Args[0] as an Ident
+		var varName string
+		if len(s.Args) > 0 {
+			if ident, ok := s.Args[0].(*ast.Ident); ok {
+				varName = ident.Name
+			}
+		} else if s.Target != "" {
+			varName = s.Target
+		}
+		if varName == "" {
+			return fmt.Errorf("let requires a variable name")
+		}
+		
+		// Variable must be explicitly declared
+		if !i.vars.Has(varName) {
+			return fmt.Errorf("cannot let to undeclared variable '%s'; use 'var %s type = value' first", varName, varName)
+		}
+		
+		val, err := stack.Pop()
+		if err != nil {
+			// Empty stack - use zero value
+			val = NewInt(0)
+		}
+		
+		// Check type compatibility - must be exact match (use bring() for conversion)
+		stackElemType := i.stackTypes[s.Stack]
+		if stackElemType == "" {
+			stackElemType = "i64" // dstack default
+		}
+		existingVal, _ := i.vars.Get(varName)
+		varType := varTypeOf(existingVal)
+		if !isStrictTypeMatch(heldType(stackElemType), varType) {
+			return fmt.Errorf("cannot let from @%s (%s) to variable '%s' (%s); types must match exactly (use bring() for conversion)",
+				s.Stack, stackElemType, varName, varType)
+		}
+		
+		// Update existing variable
+		i.updateVar(varName, val)
+	case "peek":
+		val, err := stack.Peek()
+		if err != nil {
+			return err
+		}
+		if s.Target != "" {
+			i.vars.Set(s.Target, val)
+		}
+	case "take":
+		// take - blocking pop (matches compiler behavior)
+		// Uses runtime's Take() which blocks until data is available,
+		// or until the optional timeout passes on the program clock
+		var timeout []int64
+		if len(s.Args) >= 1 {
+			ms, err := i.evalExpr(s.Args[0])
+			if err != nil {
+				return err
+			}
+			timeout = append(timeout, ms.AsInt())
+		}
+		val, err := stack.Take(timeout...)
+		if err != nil {
+			// Stack closed or error - use zero value
+			val = NewInt(0)
+		}
+		if s.Target != "" {
+			// Use Update to modify existing variable, fall back to Set for new
+			if !i.updateVar(s.Target, val) {
+				i.vars.Set(s.Target, val)
+			}
+		} else {
+			// Push to dstack like pop does
+			i.stacks["dstack"].Push(val)
+		}
+	case "dup":
+		return stack.Dup()
+	case "drop":
+		return stack.Drop()
+	case "swap":
+		return stack.Swap()
+	case "over":
+		return stack.Over()
+	case "rot":
+		return stack.Rot()
+	case "clear":
+		stack.Clear()
+	case "len":
+		// Push length to dstack
+		i.stacks["dstack"].Push(NewInt(int64(stack.Len())))
+	case "set":
+		if len(s.Args) < 2 {
+			return fmt.Errorf("set requires key and value")
+		}
+		key, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return err
+		}
+		var val Value
+		if i.stackTypes[s.Stack] == "struct" {
+			val, err = i.evalStruct(s.Stack, s.Args[1])
+		} else {
+			val, err = i.evalExpr(s.Args[1])
+		}
+		if err != nil {
+			return err
+		}
+		return stack.Set(key.AsString(), val)
+	case "get":
+		if len(s.Args) < 1 {
+			return fmt.Errorf("get requires key")
+		}
+		key, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return err
+		}
+		val, ok := stack.Get(key.AsString())
+		if !ok {
+			return fmt.Errorf("key not found: %s", key.AsString())
+		}
+		// Push result to dstack or set target
+		if s.Target != "" {
+			i.vars.Set(s.Target, val)
+		} else {
+			i.stacks["dstack"].Push(val)
+		}
+	case "del":
+		// del("key") - remove a key from a Hash stack; failures go to @error
+		if len(s.Args) != 1 {
+			return fmt.Errorf("del requires a key")
+		}
+		key, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return err
+		}
+		if _, err := stack.Delete(key.AsString()); err != nil {
+			i.stacks["error"].Push(NewString(err.Error()))
+		}
+	case "print":
+		// print: always no newline (all forms)
+		if len(s.Args) > 0 {
+			// print:X or print(args) - no newline
+			for idx, arg := range s.Args {
+				val, err := i.evalExpr(arg)
+				if err != nil {
+					return err
+				}
+				if idx > 0 {
+					fmt.Fprint(i.stdout(), " ")
+				}
+				fmt.Fprint(i.stdout(), val.AsString())
+			}
+		} else {
+			// print - Forth-style: pop and print without newline
+			val, err := stack.Pop()
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(i.stdout(), i.formatElement(s.Stack, val))
+		}
+	case "println":
+		if len(s.Args) > 0 {
+			// println(args) or println:X - print with newline
+			for idx, arg := range s.Args {
+				val, err := i.evalExpr(arg)
+				if err != nil {
+					return err
+				}
+				if idx > 0 {
+					fmt.Fprint(i.stdout(), " ")
+				}
+				fmt.Fprint(i.stdout(), val.AsString())
+			}
+			fmt.Fprintln(i.stdout())
+		} else {
+			// println - Forth-style: pop and print with newline
+			val, err := stack.Pop()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(i.stdout(), i.formatElement(s.Stack, val))
+		}
+	case "emit":
+		if len(s.Args) > 0 {
+			// emit:X - print char from value without newline
+			val, err := i.evalExpr(s.Args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(i.stdout(), string(rune(val.AsInt())))
+		} else {
+			// emit - Forth-style: pop and print as char without newline
+			val, err := stack.Pop()
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(i.stdout(), string(rune(val.AsInt())))
+		}
+	case "dot":
+		// Forth-style: pop and print with newline
+		val, err := stack.Pop()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(i.stdout(), i.formatElement(s.Stack, val))
+	// Arithmetic operations
+	case "add", "sub", "mul", "div", "mod":
+		return i.execStackArith(stack, s.Op)
+	case "neg", "abs", "inc", "dec":
+		return i.execStackUnary(stack, s.Op)
+	case "min", "max":
+		return i.execStackMinMax(stack, s.Op)
+	// Comparison operations
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		return i.execStackCompare(stack, s.Op)
+	// Bitwise operations
+	case "band", "bor", "bxor", "shl", "shr":
+		return i.execStackBitwise(stack, s.Op)
+	case "bnot":
+		return i.execStackUnaryBitwise(stack)
+	case "tor":
+		// Move top of current stack to return stack
+		val, err := stack.Pop()
+		if err != nil {
+			val = NewInt(0)
+		}
+		return i.stacks["rstack"].Push(val)
+	case "fromr":
+		// Move top of return stack to current stack
+		val, err := i.stacks["rstack"].Pop()
+		if err != nil {
+			val = NewInt(0)
+		}
+		return stack.Push(val)
+	case "has":
+		// has("key") - pushes true to @bool if a Hash stack holds the key
+		if len(s.Args) == 1 {
+			key, err := i.evalExpr(s.Args[0])
+			if err != nil {
+				return err
+			}
+			return i.stacks["bool"].Push(NewBool(stack.Has(key.AsString())))
+		}
+		// @error has - pushes true to @bool if stack has elements
+		if s.Stack == "error" {
+			hasErrors := i.stacks["error"].Len() > 0
+			return i.stacks["bool"].Push(NewBool(hasErrors))
+		}
+		// General: push bool indicating if stack has elements
+		hasElements := stack.Len() > 0
+		return i.stacks["bool"].Push(NewBool(hasElements))
+	case "bring":
+		// bring(@source) - transfer top element from source to dest with type conversion
+		if len(s.Args) < 1 {
+			return fmt.Errorf("bring requires source stack argument")
+		}
+		if len(s.Args) >= 2 {
+			if _, ok := s.Args[1].(*ast.FnLit); ok {
+				return i.execBringWith(s, stack)
+			}
+		}
+		// Get source stack name from StackRef
+		if ref, ok := s.Args[0].(*ast.StackRef); ok {
+			srcStack, ok := i.stacks[ref.Name]
+			if !ok {
+				return fmt.Errorf("undefined stack: @%s", ref.Name)
+			}
+			
+			// Get type information
+			srcType := i.stackTypes[ref.Name]
+			dstType := i.stackTypes[s.Stack]
+			
+			// Pop from source
+			val, err := srcStack.Pop()
+			if err != nil {
+				val = NewInt(0)
+			}
+			
+			// Convert value to destination type
+			if srcType != "" && dstType != "" && srcType != dstType {
+				// Check if conversion is possible
+				if !canConvertTypes(srcType, dstType) {
+					// Push back to source (atomic - restore on failure)
+					srcStack.Push(val)
+					return fmt.Errorf("cannot bring %s value from @%s to @%s (%s stack)",
+						srcType, ref.Name, s.Stack, dstType)
+				}
+				val = convertValueToType(val, dstType)
+			}
+			
+			return stack.Push(val)
+		}
+	case "walk", "filter", "map":
+		// @dst walk(@src, {|x| ...}) - see execWalkOp
+		return i.execWalkOp(s, stack)
+	case "pmap":
+		// @s pmap({|x| ...}) - see execPmapOp
+		return i.execPmapOp(s, stack)
+	case "b64encode", "b64decode", "hexencode", "hexdecode":
+		// @text b64encode(@blob) - see execCodecOp
+		return i.execCodecOp(s, stack)
+	case "from_json":
+		// @cfg from_json(text) - see runtime.Stack.FromJSON
+		if len(s.Args) != 1 {
+			return fmt.Errorf("@%s from_json requires a text argument", s.Stack)
+		}
+		text, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return err
+		}
+		if err := stack.FromJSON(text.AsString(), elementType(i.stackTypes[s.Stack])); err != nil {
+			// As in compiled code, the error goes to @error and a
+			// consider around it sees the error status
+			i.stacks["error"].Push(NewString(err.Error()))
+			i.status = "error"
+			i.statusValue = NewString(err.Error())
+		}
+	case "pack", "unpack":
+		// @blob pack(@s), @s unpack(@blob) - see execPackOp
+		return i.execPackOp(s, stack)
+	case "bridge":
+		// @telemetry bridge("mqtt://broker:1883/topic") - see runtime.Stack.Bridge
+		if len(s.Args) != 1 {
+			return fmt.Errorf("@%s bridge requires a URL argument", s.Stack)
+		}
+		url, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return err
+		}
+		if err := stack.Bridge(url.AsString(), elementType(i.stackTypes[s.Stack])); err != nil {
+			i.stacks["error"].Push(NewString(err.Error()))
+			i.status = "error"
+			i.statusValue = NewString(err.Error())
+		}
+	case "concat", "split", "strlen", "substr", "contains", "upper", "lower":
+		// @s concat, split(","), ... - see execStringOp
+		return i.execStringOp(s, stack)
+	case "freeze":
+		// freeze - make stack immutable; freeze("append-only") etc. - see runtime.FreezeMode
+		if len(s.Args) == 0 {
+			stack.Freeze()
+			break
+		}
+		name, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return err
+		}
+		mode, ok := runtime.LookupFreezeMode(name.AsString())
+		if !ok {
+			return fmt.Errorf("@%s freeze: mode must be \"all\", \"structure\" or \"append-only\"", s.Stack)
+		}
+		stack.FreezeWith(mode)
+	case "perspective":
+		// perspective(LIFO|FIFO|Indexed|Hash) - change stack's access perspective
+		if len(s.Args) >= 1 {
+			perspVal, err := i.evalExpr(s.Args[0])
+			if err != nil {
+				return err
+			}
+			stack.SetPerspective(perspectiveFromString(perspVal.AsString()))
+		}
+	default:
+		return fmt.Errorf("unknown stack operation: %s", s.Op)
+	}
+	
+	return nil
+}
+
+// pushValue pushes val to stack, the stack name, converting it to the
+// element type.
+func (i *Interpreter) pushValue(stack *ValueStack, name string, val Value) error {
+	if elemType := i.stackTypes[name]; elemType != "" {
+		valType := valueTypeToString(val.Type)
+		if !isTypeCompatibleIual(valType, elemType) {
+			return fmt.Errorf("cannot push %s value to @%s (%s stack)", valType, name, elemType)
+		}
+		// Convert if needed (e.g., int → float)
+		val = convertValueForStack(val, elemType)
+	}
+	return stack.Push(val)
+}
+
+// execCodecOp runs @dst b64encode/b64decode/hexencode/hexdecode(@src),
+// appending each converted element of @src to @dst in walk order.
+// Elements that fail to decode are skipped and reported on @error.
+func (i *Interpreter) execCodecOp(s *ast.StackOp, dst *ValueStack) error {
+	if len(s.Args) != 1 {
+		return fmt.Errorf("%s requires a source stack argument", s.Op)
+	}
+	ref, ok := s.Args[0].(*ast.StackRef)
+	if !ok {
+		return fmt.Errorf("%s: argument must be a stack", s.Op)
+	}
+	src, ok := i.stacks[ref.Name]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", ref.Name)
+	}
+	for _, name := range []string{ref.Name, s.Stack} {
+		if t := i.stackTypes[name]; t != "string" && t != "bytes" {
+			return fmt.Errorf("%s requires string or bytes stacks, @%s is %s", s.Op, name, t)
+		}
+	}
+	
+	codec := runtime.Base64
+	if strings.HasPrefix(s.Op, "hex") {
+		codec = runtime.Hex
+	}
+	convert := codec.Encode
+	if strings.HasSuffix(s.Op, "decode") {
+		convert = codec.Decode
+	}
+	
+	errs := runtime.NewStack(runtime.FIFO, runtime.TypeBytes)
+	dst.Stack().Walk(src.Stack(), func(b []byte) ([]byte, error) {
+		out, err := convert([]byte(runtime.ValueFromBytes(b).AsString()))
+		if err != nil {
+			return nil, err
+		}
+		return NewString(string(out)).ToBytes(), nil
+	}, errs)
+	
+	for {
+		msg, err := errs.Pop()
+		if err != nil {
+			return nil
+		}
+		i.stacks["error"].Push(NewString(string(msg)))
+	}
+}
+
+// execPackOp runs @blob pack(@s) and @s unpack(@blob) with the runtime's
+// ualpack encoder, so blobs are the ones compiled programs read and
+// write. As in compiled code, failures go to @error.
+func (i *Interpreter) execPackOp(s *ast.StackOp, stack *ValueStack) error {
+	var ref *ast.StackRef
+	if len(s.Args) == 1 {
+		ref, _ = s.Args[0].(*ast.StackRef)
+	}
+	if ref == nil {
+		return fmt.Errorf("@%s %s requires a stack argument", s.Stack, s.Op)
+	}
+	other, ok := i.stacks[ref.Name]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", ref.Name)
+	}
+	blob := s.Stack
+	if s.Op == "unpack" {
+		blob = ref.Name
+	}
+	if t := i.stackTypes[blob]; t != "bytes" {
+		return fmt.Errorf("%s: @%s must be a bytes stack, not %s", s.Op, blob, t)
+	}
+	
+	var err error
+	if s.Op == "pack" {
+		var data []byte
+		if data, err = other.Pack(elementType(i.stackTypes[ref.Name])); err == nil {
+			err = stack.Push(NewString(string(data)))
+		}
+	} else {
+		var v Value
+		if v, err = other.Pop(); err != nil {
+			err = fmt.Errorf("unpack: %v", err)
+		} else {
+			err = stack.Unpack([]byte(v.AsString()), elementType(i.stackTypes[s.Stack]))
+		}
+	}
+	if err != nil {
+		i.stacks["error"].Push(NewString(err.Error()))
+		i.status = "error"
+		i.statusValue = NewString(err.Error())
+	}
+	return nil
+}
+
+// execStringOp runs the text operations on a string stack with the
+// runtime's Str helpers, so results match the compiled backends. strlen
+// pushes to @dstack and contains to @bool.
+func (i *Interpreter) execStringOp(s *ast.StackOp, stack *ValueStack) error {
+	if t := i.stackTypes[s.Stack]; t != "string" {
+		return fmt.Errorf("%s requires a string stack, @%s is %s", s.Op, s.Stack, t)
+	}
+	want := map[string]int{"split": 1, "substr": 2, "contains": 1}[s.Op]
+	if len(s.Args) != want {
+		return fmt.Errorf("%s takes %d argument(s), got %d", s.Op, want, len(s.Args))
+	}
+	args := make([]Value, len(s.Args))
+	for n, arg := range s.Args {
+		v, err := i.evalExpr(arg)
+		if err != nil {
+			return err
+		}
+		args[n] = v
+	}
+	
+	popStr := func() []byte {
+		v, err := stack.Pop()
+		if err != nil {
+			return nil // empty pops as "", like the compiled backends
+		}
+		return []byte(v.AsString())
+	}
+	switch s.Op {
+	case "concat":
+		b := popStr()
+		a := popStr()
+		return stack.Push(NewString(string(runtime.StrConcat(a, b))))
+	case "split":
+		for _, p := range runtime.StrSplit(popStr(), args[0].AsString()) {
+			if err := stack.Push(NewString(string(p))); err != nil {
+				return err
+			}
+		}
+	case "strlen":
+		return i.stacks["dstack"].Push(NewInt(runtime.StrLen(popStr())))
+	case "substr":
+		return stack.Push(NewString(string(runtime.Substr(popStr(), args[0].AsInt(), args[1].AsInt()))))
+	case "contains":
+		return i.stacks["bool"].Push(NewBool(runtime.StrContains(popStr(), args[0].AsString())))
+	case "upper":
+		return stack.Push(NewString(string(runtime.StrUpper(popStr()))))
+	case "lower":
+		return stack.Push(NewString(string(runtime.StrLower(popStr()))))
+	}
+	return nil
+}
+
+// execWalkOp runs @dst walk/filter/map(@src, {|x| ...}) through the runtime
+// Walk/Filter so the result order matches the compiled backends. map clears
+// @dst first. Frozen or full destinations push to @error.
+func (i *Interpreter) execWalkOp(s *ast.StackOp, dst *ValueStack) error {
+	if len(s.Args) != 2 {
+		return fmt.Errorf("%s requires (@source, {|x| ...}) arguments", s.Op)
+	}
+	ref, ok := s.Args[0].(*ast.StackRef)
+	if !ok {
+		return fmt.Errorf("%s: first argument must be a stack", s.Op)
+	}
+	src, ok := i.stacks[ref.Name]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", ref.Name)
+	}
+	fn, ok := s.Args[1].(*ast.FnLit)
+	if !ok || len(fn.Params) != 1 {
+		return fmt.Errorf("%s: second argument must be a codeblock {|x| ...}", s.Op)
+	}
+	
+	dstType := i.stackTypes[s.Stack]
+	var fnErr error
+	errs := runtime.NewStack(runtime.LIFO, runtime.TypeBytes)
+	
+	if s.Op == "filter" {
+		dst.Stack().Filter(src.Stack(), func(b []byte) bool {
+			keep, err := i.applyCodeblock(fn, runtime.ValueFromBytes(b))
+			if err != nil && fnErr == nil {
+				fnErr = err
+			}
+			return keep.AsBool()
+		}, errs)
+	} else {
+		if s.Op == "map" {
+			dst.Clear()
+		}
+		// Numeric conversions compute in the destination type, as compiled code does
+		srcType := i.stackTypes[ref.Name]
+		convertArg := srcType != dstType && canConvertTypes(srcType, dstType) && dstType != "bool"
+		dst.Stack().Walk(src.Stack(), func(b []byte) ([]byte, error) {
+			arg := runtime.ValueFromBytes(b)
+			if convertArg {
+				arg = convertValueToType(arg, dstType)
+			}
+			result, err := i.applyCodeblock(fn, arg)
+			if err != nil {
+				if fnErr == nil {
+					fnErr = err
+				}
+				return nil, err
+			}
+			if dstType != "" {
+				result = convertValueToType(result, dstType)
+			}
+			return result.ToBytes(), nil
+		}, errs)
+	}
+	
+	if fnErr != nil {
+		return fnErr
+	}
+	if msg, err := errs.Pop(); err == nil {
+		i.stacks["error"].Push(NewString(string(msg)))
+	}
+	return nil
+}
+
+// execBringWith runs @dst bring(@src, {|x| ...}, {|x| pred}) through the
+// runtime BringWith. The transform computes in the destination type, as in
+// walk, and an element the predicate rejects is dropped.
+func (i *Interpreter) execBringWith(s *ast.StackOp, dst *ValueStack) error {
+	ref, ok := s.Args[0].(*ast.StackRef)
+	if !ok || len(s.Args) > 3 {
+		return fmt.Errorf("bring requires (@source, {|x| ...}) or (@source, {|x| ...}, {|x| pred}) arguments")
+	}
+	src, ok := i.stacks[ref.Name]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", ref.Name)
+	}
+	fn := s.Args[1].(*ast.FnLit)
+	var pred *ast.FnLit
+	if len(s.Args) == 3 {
+		pred, _ = s.Args[2].(*ast.FnLit)
+		if pred == nil || len(pred.Params) != 1 {
+			return fmt.Errorf("bring: predicate must be a codeblock {|x| ...}")
+		}
+	}
+	if len(fn.Params) != 1 {
+		return fmt.Errorf("bring: transform must be a codeblock {|x| ...}")
+	}
+	
+	srcType := i.stackTypes[ref.Name]
+	dstType := i.stackTypes[s.Stack]
+	convertArg := srcType != dstType && canConvertTypes(srcType, dstType) && dstType != "bool"
+	var fnErr error
+	transform := func(b []byte) ([]byte, error) {
+		arg := runtime.ValueFromBytes(b)
+		if convertArg {
+			arg = convertValueToType(arg, dstType)
+		}
+		result, err := i.applyCodeblock(fn, arg)
+		if err != nil {
+			fnErr = err
+			return nil, err
+		}
+		if dstType != "" {
+			result = convertValueToType(result, dstType)
+		}
+		return result.ToBytes(), nil
+	}
+	var keep func([]byte) bool
+	if pred != nil {
+		keep = func(b []byte) bool {
+			ok, err := i.applyCodeblock(pred, runtime.ValueFromBytes(b))
+			if err != nil && fnErr == nil {
+				fnErr = err
+			}
+			return err == nil && ok.AsBool()
+		}
+	}
+	
+	err := dst.Stack().BringWith(src.Stack(), transform, keep)
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		i.stacks["error"].Push(NewString(err.Error()))
+	}
+	return nil
+}
+
+// execPmapOp runs @s pmap({|x| ...}), replacing every element in place.
+// Codeblocks share the interpreter's scopes, so unlike compiled code the
+// elements are mapped one at a time (runtime.MapInPlace).
+func (i *Interpreter) execPmapOp(s *ast.StackOp, stack *ValueStack) error {
+	var fn *ast.FnLit
+	if len(s.Args) == 1 {
+		fn, _ = s.Args[0].(*ast.FnLit)
+	}
+	if fn == nil || len(fn.Params) != 1 {
+		return fmt.Errorf("pmap requires a codeblock argument {|x| ...}")
+	}
+	
+	elemType := i.stackTypes[s.Stack]
+	var fnErr error
+	err := runtime.MapInPlace(stack.Stack(), func(b []byte) ([]byte, error) {
+		result, err := i.applyCodeblock(fn, runtime.ValueFromBytes(b))
+		if err != nil {
+			if fnErr == nil {
+				fnErr = err
+			}
+			return nil, err
+		}
+		if elemType != "" {
+			result = convertValueToType(result, elemType)
+		}
+		return result.ToBytes(), nil
+	}, nil)
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		i.stacks["error"].Push(NewString(err.Error()))
+	}
+	return nil
+}
+
+// applyCodeblock calls a single-parameter codeblock with arg, returning
+// either its explicit return value or the value of its only expression.
+func (i *Interpreter) applyCodeblock(fn *ast.FnLit, arg Value) (Value, error) {
+	i.vars.PushScope()
+	defer i.vars.PopScope()
+	i.vars.Set(fn.Params[0], arg)
+	
+	if len(fn.Body) == 1 {
+		if exprStmt, ok := fn.Body[0].(*ast.ExprStmt); ok {
+			return i.evalExpr(exprStmt.Expr)
+		}
+	}
+	for _, stmt := range fn.Body {
+		if err := i.execStmt(stmt); err != nil {
+			if errors.Is(err, errReturn) {
+				return i.returnVal, nil
+			}
+			return NilValue, err
+		}
+	}
+	return NilValue, nil
+}
+
+// unsignedOps are the stack ops whose result depends on the stack holding
+// unsigned values
+var unsignedOps = map[string]bool{
+	"add": true, "sub": true, "mul": true, "div": true, "mod": true,
+	"neg": true, "abs": true, "inc": true, "dec": true, "min": true, "max": true,
+	"eq": true, "ne": true, "lt": true, "gt": true, "le": true, "ge": true,
+	"band": true, "bor": true, "bxor": true, "shl": true, "shr": true, "bnot": true,
+}
+
+// execUnsignedOp executes an op of unsignedOps on a stack of unsigned
+// values of the given width. Arithmetic wraps modulo 2^bits; division,
+// shifts and comparisons are unsigned.
+func (i *Interpreter) execUnsignedOp(stack *ValueStack, op string, bits uint) error {
+	pop := func() (uint64, error) {
+		v, err := stack.Pop()
+		if err != nil {
+			return 0, err
+		}
+		return runtime.WrapUint(uint64(v.AsInt()), bits), nil
+	}
+	push := func(u uint64) error {
+		return stack.Push(NewInt(int64(runtime.WrapUint(u, bits))))
+	}
+	
+	switch op {
+	case "neg", "abs", "inc", "dec", "bnot":
+		x, err := pop()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "neg":
+			x = -x
+		case "inc":
+			x++
+		case "dec":
+			x--
+		case "bnot":
+			x = ^x
+		}
+		return push(x)
+	}
+	
+	y, err := pop()
+	if err != nil {
+		return err
+	}
+	x, err := pop()
+	if err != nil {
+		return err
+	}
+	switch op {
+	case "add":
+		return push(x + y)
+	case "sub":
+		return push(x - y)
+	case "mul":
+		return push(x * y)
+	case "div":
+		if y == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		return push(x / y)
+	case "mod":
+		if y == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		return push(x % y)
+	case "min":
+		return push(min(x, y))
+	case "max":
+		return push(max(x, y))
+	case "band":
+		return push(x & y)
+	case "bor":
+		return push(x | y)
+	case "bxor":
+		return push(x ^ y)
+	case "shl":
+		return push(x << y)
+	case "shr":
+		return push(x >> y)
+	}
+	
+	var result bool
+	switch op {
+	case "eq":
+		result = x == y
+	case "ne":
+		result = x != y
+	case "lt":
+		result = x < y
+	case "gt":
+		result = x > y
+	case "le":
+		result = x <= y
+	case "ge":
+		result = x >= y
+	}
+	return i.stacks["bool"].Push(NewBool(result))
+}
+
+// narrowResult cuts a compute block result to the width of an i32 or f32
+// stack name, and returns other results unchanged
+func (i *Interpreter) narrowResult(name string, v Value) Value {
+	if t := i.stackTypes[name]; t == "i32" || t == "f32" {
+		return convertValueToType(v, t)
+	}
+	return v
+}
+
+// execNarrowOp executes an op of unsignedOps on an i32 or f32 stack. The
+// op runs on the operands as usual and its result is cut to the stack's
+// width, as the compiled backends store it in 4 bytes.
+func (i *Interpreter) execNarrowOp(stack *ValueStack, op, typ string) error {
+	n := 2
+	switch op {
+	case "neg", "abs", "inc", "dec", "bnot":
+		n = 1
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		return i.execStackCompare(stack, op)
+	}
+	args := make([]Value, n)
+	for k := n - 1; k >= 0; k-- {
+		v, err := stack.Pop()
+		if err != nil {
+			return err
+		}
+		args[k] = v
+	}
+	tmp := runtime.NewValueStack(runtime.LIFO)
+	for _, v := range args {
+		tmp.Push(v)
+	}
+	var err error
+	switch op {
+	case "add", "sub", "mul", "div", "mod":
+		err = i.execStackArith(tmp, op)
+	case "neg", "abs", "inc", "dec":
+		err = i.execStackUnary(tmp, op)
+	case "min", "max":
+		err = i.execStackMinMax(tmp, op)
+	case "bnot":
+		err = i.execStackUnaryBitwise(tmp)
+	default:
+		err = i.execStackBitwise(tmp, op)
+	}
+	if err != nil {
+		return err
+	}
+	v, err := tmp.Pop()
+	if err != nil {
+		return err
+	}
+	return stack.Push(convertValueToType(v, typ))
+}
+
+// execStackArith executes arithmetic on top two stack elements.
+func (i *Interpreter) execStackArith(stack *ValueStack, op string) error {
+	b, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	a, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	
+	var result Value
+	
+	// Use float if either operand is float
+	if a.Type == runtime.VTFloat || b.Type == runtime.VTFloat {
+		af, bf := a.AsFloat(), b.AsFloat()
+		switch op {
+		case "add":
+			result = NewFloat(af + bf)
+		case "sub":
+			result = NewFloat(af - bf)
+		case "mul":
+			result = NewFloat(af * bf)
+		case "div":
+			if bf == 0 {
+				return fmt.Errorf("division by zero")
+			}
+			result = NewFloat(af / bf)
+		case "mod":
+			result = NewFloat(math.Mod(af, bf))
+		}
+	} else {
+		ai, bi := a.AsInt(), b.AsInt()
+		switch op {
+		case "add":
+			result = NewInt(ai + bi)
+		case "sub":
+			result = NewInt(ai - bi)
+		case "mul":
+			result = NewInt(ai * bi)
+		case "div":
+			if bi == 0 {
+				return fmt.Errorf("division by zero")
+			}
+			result = NewInt(ai / bi)
+		case "mod":
+			if bi == 0 {
+				return fmt.Errorf("modulo by zero")
+			}
+			result = NewInt(ai % bi)
+		}
+	}
+	
+	return stack.Push(result)
+}
+
+// execStackUnary executes unary arithmetic on top stack element.
+func (i *Interpreter) execStackUnary(stack *ValueStack, op string) error {
+	a, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	
+	var result Value
+	
+	if a.Type == runtime.VTFloat {
+		af := a.AsFloat()
+		switch op {
+		case "neg":
+			result = NewFloat(-af)
+		case "abs":
+			result = NewFloat(math.Abs(af))
+		case "inc":
+			result = NewFloat(af + 1)
+		case "dec":
+			result = NewFloat(af - 1)
+		}
+	} else {
+		ai := a.AsInt()
+		switch op {
+		case "neg":
+			result = NewInt(-ai)
+		case "abs":
+			if ai < 0 {
+				result = NewInt(-ai)
+			} else {
+				result = NewInt(ai)
+			}
+		case "inc":
+			result = NewInt(ai + 1)
+		case "dec":
+			result = NewInt(ai - 1)
+		}
+	}
+	
+	return stack.Push(result)
+}
+
+// execStackMinMax finds min or max of top two elements.
+func (i *Interpreter) execStackMinMax(stack *ValueStack, op string) error {
+	b, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	a, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	
+	var result Value
+	cmp := a.Compare(b)
+	
+	if op == "min" {
+		if cmp <= 0 {
+			result = a
+		} else {
+			result = b
+		}
+	} else { // max
+		if cmp >= 0 {
+			result = a
+		} else {
+			result = b
+		}
+	}
+	
+	return stack.Push(result)
+}
+
+// execStackCompare compares top two elements.
+func (i *Interpreter) execStackCompare(stack *ValueStack, op string) error {
+	b, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	a, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	
+	cmp := a.Compare(b)
+	var result bool
+	
+	switch op {
+	case "eq":
+		result = a.Equals(b)
+	case "ne":
+		result = !a.Equals(b)
+	case "lt":
+		result = cmp < 0
+	case "gt":
+		result = cmp > 0
+	case "le":
+		result = cmp <= 0
+	case "ge":
+		result = cmp >= 0
+	}
+	
+	return stack.Push(NewBool(result))
+}
+
+// execStackBitwise executes bitwise operations.
+func (i *Interpreter) execStackBitwise(stack *ValueStack, op string) error {
+	b, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	a, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	
+	ai, bi := a.AsInt(), b.AsInt()
+	var result int64
+	
+	switch op {
+	case "band":
+		result = ai & bi
+	case "bor":
+		result = ai | bi
+	case "bxor":
+		result = ai ^ bi
+	case "shl":
+		result = ai << uint(bi)
+	case "shr":
+		result = ai >> uint(bi)
+	}
+	
+	return stack.Push(NewInt(result))
+}
+
+// execStackUnaryBitwise executes unary bitwise not.
+func (i *Interpreter) execStackUnaryBitwise(stack *ValueStack) error {
+	a, err := stack.Pop()
+	if err != nil {
+		return err
+	}
+	return stack.Push(NewInt(^a.AsInt()))
+}
+
+// execStackBlock executes operations within a stack context.
+func (i *Interpreter) execStackBlock(s *ast.StackBlock) error {
+	// Set default stack for implicit operations
+	for _, op := range s.Ops {
+		if err := i.execStmt(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execAssignment handles name = expr assignments.
+func (i *Interpreter) execAssignment(s *ast.Assignment) error {
+	val, err := i.evalExpr(s.Expr)
+	if err != nil {
+		return err
+	}
+	
+	// Track top-level assignments for auto-print
+	if !i.inFunction {
+		i.trackTopLevel(s.Name)
+	}
+	
+	// Try to update existing variable first
+	if !i.updateVar(s.Name, val) {
+		// Otherwise create new
+		i.vars.Set(s.Name, val)
+	}
+	return nil
+}
+
+// trackTopLevel adds name to the variables printed at the end of the
+// program, if it is not there already.
+func (i *Interpreter) trackTopLevel(name string) {
+	for _, n := range i.topLevelVars {
+		if n == name {
+			return
+		}
+	}
+	i.topLevelVars = append(i.topLevelVars, name)
+}
@@ -1,12 +1,15 @@
-package main
+package interp
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
+	"math/rand/v2"
+	"sync"
 	"time"
 
 	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/ir"
 	"github.com/ha1tch/ual/pkg/runtime"
 )
 
@@ -18,7 +21,7 @@ func (i *Interpreter) execIfStmt(s *ast.IfStmt) error {
 	}
 	
 	if cond.AsBool() {
-		return i.execBlock(s.Body)
+		return i.execScope(s.Body)
 	}
 	
 	// Check elseif branches
@@ -28,13 +31,13 @@ func (i *Interpreter) execIfStmt(s *ast.IfStmt) error {
 			return err
 		}
 		if cond.AsBool() {
-			return i.execBlock(elseif.Body)
+			return i.execScope(elseif.Body)
 		}
 	}
 	
 	// Execute else branch
 	if len(s.Else) > 0 {
-		return i.execBlock(s.Else)
+		return i.execScope(s.Else)
 	}
 	
 	return nil
@@ -43,6 +46,9 @@ func (i *Interpreter) execIfStmt(s *ast.IfStmt) error {
 // execWhileStmt executes a while loop.
 func (i *Interpreter) execWhileStmt(s *ast.WhileStmt) error {
 	for {
+		if err := i.interrupted(); err != nil {
+			return err // the body may have no statements to stop at
+		}
 		cond, err := i.evalExpr(s.Condition)
 		if err != nil {
 			return err
@@ -52,9 +58,8 @@ func (i *Interpreter) execWhileStmt(s *ast.WhileStmt) error {
 			break
 		}
 		
-		// Execute body without pushing scope each iteration
-		// Variables are already scoped at the function/compute block level
-		err = i.execBlock(s.Body)
+		// Only bodies that declare variables get a scope of their own
+		err = i.execScope(s.Body)
 		
 		if err != nil {
 			if errors.Is(err, errBreak) {
@@ -69,6 +74,48 @@ func (i *Interpreter) execWhileStmt(s *ast.WhileStmt) error {
 	return nil
 }
 
+// execRangeStmt executes a for loop over start..end. Both bounds are
+// evaluated once, and assigning to the loop variable does not change the
+// iterations.
+func (i *Interpreter) execRangeStmt(s *ast.RangeStmt) error {
+	start, err := i.evalExpr(s.Start)
+	if err != nil {
+		return err
+	}
+	end, err := i.evalExpr(s.End)
+	if err != nil {
+		return err
+	}
+	
+	// The loop variable has a scope of its own for the whole loop; the
+	// body's variables, if it declares any, get one each time round
+	compute := i.inComputeBlock && i.localVars != nil
+	if !compute {
+		i.vars.PushScope()
+		defer i.vars.PopScope()
+	}
+	for n := start.AsInt(); n < end.AsInt(); n++ {
+		var err error
+		if compute {
+			i.localVars[s.Var] = NewInt(n)
+			err = i.execBlock(s.Body)
+		} else {
+			i.vars.Set(s.Var, NewInt(n))
+			err = i.execScope(s.Body)
+		}
+		if err != nil {
+			if errors.Is(err, errBreak) {
+				break
+			}
+			if errors.Is(err, errContinue) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // execForStmt executes a for loop over a stack.
 func (i *Interpreter) execForStmt(s *ast.ForStmt) error {
 	stack, ok := i.stacks[s.Stack]
@@ -76,6 +123,10 @@ func (i *Interpreter) execForStmt(s *ast.ForStmt) error {
 		return fmt.Errorf("undefined stack: @%s", s.Stack)
 	}
 	
+	if s.Perspective == "hash" || (s.Perspective == "" && stack.IsHash()) {
+		return i.execHashFor(s, stack)
+	}
+	
 	elements := stack.All()
 	
 	// Determine iteration order based on perspective
@@ -125,6 +176,39 @@ func (i *Interpreter) execForStmt(s *ast.ForStmt) error {
 	return nil
 }
 
+// execHashFor iterates a Hash stack in key insertion order, binding |k,v|
+// to the key and value. Keys the body pops before their turn are skipped.
+func (i *Interpreter) execHashFor(s *ast.ForStmt, stack *ValueStack) error {
+	for _, key := range stack.Stack().Keys() {
+		elem, ok := stack.Get(key)
+		if !ok {
+			continue
+		}
+		i.vars.PushScope()
+		switch len(s.Params) {
+		case 0:
+			i.stacks["dstack"].Push(elem)
+		case 1:
+			i.vars.Set(s.Params[0], elem)
+		case 2:
+			i.vars.Set(s.Params[0], NewString(key))
+			i.vars.Set(s.Params[1], elem)
+		}
+		err := i.execBlock(s.Body)
+		i.vars.PopScope()
+		if err != nil {
+			if errors.Is(err, errBreak) {
+				break
+			}
+			if errors.Is(err, errContinue) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // execForIteration executes one iteration of a for loop.
 func (i *Interpreter) execForIteration(s *ast.ForStmt, idx int, elem Value) error {
 	i.vars.PushScope()
@@ -190,6 +274,21 @@ func (i *Interpreter) execDeferStmt(s *ast.DeferStmt) error {
 	return nil
 }
 
+// execAtExitStmt pushes an exit hook, capturing variables like a defer.
+func (i *Interpreter) execAtExitStmt(s *ast.AtExitStmt) error {
+	vars := i.vars.Clone()
+	body := s.Body
+	
+	runtime.AtExit(func() {
+		oldVars := i.vars
+		i.vars = vars
+		i.execBlock(body)
+		i.vars = oldVars
+	})
+	
+	return nil
+}
+
 // execPanicStmt executes a panic.
 func (i *Interpreter) execPanicStmt(s *ast.PanicStmt) error {
 	var msg string
@@ -247,6 +346,10 @@ func (i *Interpreter) execConsiderStmt(s *ast.ConsiderStmt) error {
 	// Execute the block
 	if s.Block != nil {
 		if err := i.execStackBlock(s.Block); err != nil {
+			if i.stops(err) {
+				i.status, i.statusValue = savedStatus, savedStatusValue
+				return err
+			}
 			if !errors.Is(err, errReturn) && !errors.Is(err, errBreak) && !errors.Is(err, errContinue) {
 				i.status = "error"
 				i.statusValue = NewString(err.Error())
@@ -323,7 +426,6 @@ func (i *Interpreter) execSelectStmt(s *ast.SelectStmt) error {
 	hasDefault := false
 	var timeoutMs int64 = 0
 	var timeoutCase ast.SelectCase
-	hasTimeout := false
 	for _, c := range s.Cases {
 		if c.Stack == "_" {
 			hasDefault = true
@@ -335,20 +437,51 @@ func (i *Interpreter) execSelectStmt(s *ast.SelectStmt) error {
 				timeoutMs = val.AsInt()
 			}
 			timeoutCase = c
-			hasTimeout = true
 		}
 	}
 	
-	// Calculate deadline if we have a timeout
-	var deadline time.Time
+	// Start the timeout on the program clock, which tests may freeze
+	var expired <-chan struct{}
+	stop := func() {}
 	if timeoutMs > 0 {
-		deadline = time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+		expired, stop = runtime.After(timeoutMs)
 	}
+	defer func() { stop() }()
 	
 	// Blocking loop - keep trying until a case matches
 	for {
-		// Try each case
-		for _, c := range s.Cases {
+		// Try each case, starting from a random one unless fair: false
+		start := 0
+		if !s.Ordered && len(s.Cases) > 1 {
+			start = rand.IntN(len(s.Cases))
+		}
+		for k := range s.Cases {
+			c := &s.Cases[(start+k)%len(s.Cases)]
+			if c.Kind != ast.SelectStack {
+				src, err := i.selectSource(c)
+				if err != nil {
+					return err
+				}
+				if src.Len() == 0 {
+					continue
+				}
+				data, err := src.Pop()
+				if err != nil {
+					continue
+				}
+				val := NewString(string(data))
+				if c.Kind == ast.SelectTimer {
+					val = NewInt(int64(binary.BigEndian.Uint64(data)))
+				}
+				i.vars.PushScope()
+				if len(c.Bindings) > 0 {
+					i.vars.Set(c.Bindings[0], val)
+				}
+				err = i.execBlock(c.Handler)
+				i.vars.PopScope()
+				return err
+			}
+			
 			stackName := c.Stack
 			if stackName == "" {
 				stackName = s.DefaultStack
@@ -381,13 +514,18 @@ func (i *Interpreter) execSelectStmt(s *ast.SelectStmt) error {
 		}
 		
 		// Check timeout
-		if timeoutMs > 0 && time.Now().After(deadline) {
-			// Execute timeout handler if present
-			if hasTimeout && timeoutCase.TimeoutFn != nil {
-				_, err := i.evalFnLit(timeoutCase.TimeoutFn)
+		if timeoutMs > 0 && isClosed(expired) {
+			// Run the timeout handler, which ends the select unless it retries
+			t := ir.LowerTimeout(timeoutCase)
+			if err := i.execBlock(t.Body); err != nil {
 				return err
 			}
-			return nil
+			if !t.Retry {
+				return nil
+			}
+			stop()
+			expired, stop = runtime.After(timeoutMs)
+			continue
 		}
 		
 		// If we have a default case, execute it now (no data on any stack)
@@ -419,6 +557,49 @@ func (i *Interpreter) execSelectStmt(s *ast.SelectStmt) error {
 	}
 }
 
+// isClosed reports whether ch has been closed, without blocking
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// selectSources holds the timer and signal stacks of select cases. Each is
+// created the first time its case is reached and then kept for the rest of
+// the program, like the package-level sources of compiled code.
+var (
+	selectSourcesMu sync.Mutex
+	selectSources   = make(map[*ast.SelectCase]*runtime.Stack)
+)
+
+// selectSource returns the stack a timer or signal select case waits on.
+func (i *Interpreter) selectSource(c *ast.SelectCase) (*runtime.Stack, error) {
+	selectSourcesMu.Lock()
+	defer selectSourcesMu.Unlock()
+	if src, ok := selectSources[c]; ok {
+		return src, nil
+	}
+	
+	var src *runtime.Stack
+	if c.Kind == ast.SelectTimer {
+		ms, err := i.evalExpr(c.Every)
+		if err != nil {
+			return nil, err
+		}
+		src = runtime.Every(ms.AsInt())
+	} else {
+		var err error
+		if src, err = runtime.Signals(c.Signal); err != nil {
+			return nil, err
+		}
+	}
+	selectSources[c] = src
+	return src, nil
+}
+
 // execComputeStmt executes a compute block (infix math).
 func (i *Interpreter) execComputeStmt(s *ast.ComputeStmt) error {
 	// Execute setup block first
@@ -434,11 +615,21 @@ func (i *Interpreter) execComputeStmt(s *ast.ComputeStmt) error {
 		stack = i.stacks["dstack"]
 	}
 	
+	// Struct fields and records are only handled by the tree walker
+	if t := i.structs[s.StackName]; t != nil {
+		if len(s.Params) > 0 {
+			return fmt.Errorf("compute on struct stack @%s cannot use bindings; read fields with self[i].x", s.StackName)
+		}
+		return i.execComputeStmtSlow(s, stack)
+	}
+	
 	// Try compiled fast path
 	compiled, found := i.compiledCompute[s]
 	if !found {
 		// Try to compile
 		compiler := NewComputeCompiler()
+		compiler.floatReturn = i.stackTypes[s.StackName] == "f64" || i.stackTypes[s.StackName] == "f32"
+		compiler.stackType = i.stackTypes[s.StackName]
 		var err error
 		compiled, err = compiler.Compile(s.Params, s.Body)
 		if err != nil {
@@ -459,7 +650,7 @@ func (i *Interpreter) execComputeStmt(s *ast.ComputeStmt) error {
 			params[idx] = val.AsFloat()
 		}
 		
-		result, err := compiled.Execute(params)
+		result, err := compiled.Execute(params, stack)
 		if err != nil {
 			return err
 		}
@@ -468,7 +659,7 @@ func (i *Interpreter) execComputeStmt(s *ast.ComputeStmt) error {
 			if stack.IsHash() {
 				stack.Set("__result_0__", result)
 			} else {
-				stack.Push(result)
+				stack.Push(i.narrowResult(s.StackName, result))
 			}
 		}
 		return nil
@@ -481,9 +672,11 @@ func (i *Interpreter) execComputeStmt(s *ast.ComputeStmt) error {
 // execComputeStmtSlow is the fallback tree-walking execution for compute blocks.
 func (i *Interpreter) execComputeStmtSlow(s *ast.ComputeStmt, stack *ValueStack) error {
 	// Set computeStack for self reference
-	oldComputeStack := i.computeStack
-	i.computeStack = stack
-	defer func() { i.computeStack = oldComputeStack }()
+	oldComputeStack, oldComputeStruct, oldComputeType := i.computeStack, i.computeStruct, i.computeType
+	i.computeStack, i.computeStruct, i.computeType = stack, i.structs[s.StackName], i.stackTypes[s.StackName]
+	defer func() {
+		i.computeStack, i.computeStruct, i.computeType = oldComputeStack, oldComputeStruct, oldComputeType
+	}()
 	
 	// Set up fast local variables cache
 	oldLocalVars := i.localVars
@@ -526,10 +719,10 @@ func (i *Interpreter) execComputeStmtSlow(s *ast.ComputeStmt, stack *ValueStack)
 					// For regular stacks, push values
 					if len(i.returnVals) > 0 {
 						for _, v := range i.returnVals {
-							stack.Push(v)
+							stack.Push(i.narrowResult(s.StackName, v))
 						}
 					} else {
-						stack.Push(i.returnVal)
+						stack.Push(i.narrowResult(s.StackName, i.returnVal))
 					}
 				}
 				return nil
@@ -587,20 +780,37 @@ func (i *Interpreter) execSpawnPush(s *ast.SpawnPush) error {
 		for k, v := range i.stackTypes {
 			childStackTypes[k] = v
 		}
+		childStructs := make(map[string]*runtime.StructType, len(i.structs))
+		for k, v := range i.structs {
+			childStructs[k] = v
+		}
 		
 		child := &Interpreter{
 			funcs:           i.funcs,          // Share function definitions
 			stacks:          childStacks,      // Mixed: own operational stacks, shared user stacks
 			stackTypes:      childStackTypes,  // Own copy for local stack declarations
+			structs:         childStructs,
 			views:           i.views,          // Share views
 			vars:            vars,
 			compiledCompute: make(map[*ast.ComputeStmt]*CompiledCompute),
+			pos:             i.pos,
+			prof:            i.prof,           // Share the profile, not its timers
+			in:              i.in,
+			out:             i.out,
+			errOut:          i.errOut,
+			env:             i.env,
+			exitReturns:     i.exitReturns,
+			halt:            i.halt,
+			hostFuncs:       i.hostFuncs,
 		}
 		child.vars.PushScope()
-		if err := child.execBlock(body); err != nil {
-			fmt.Fprintf(os.Stderr, "[spawn error] %v\n", err)
-		}
+		err := child.execBlock(body)
 		child.vars.PopScope()
+		if s.Into != "" {
+			i.pushSpawnResult(s.Into, child.returnVal, err)
+		} else if err != nil {
+			fmt.Fprintf(i.stderr(), "[spawn error] %v\n", err)
+		}
 	}
 	
 	// Add to spawn tasks with mutex protection
@@ -611,6 +821,28 @@ func (i *Interpreter) execSpawnPush(s *ast.SpawnPush) error {
 	return nil
 }
 
+// pushSpawnResult finishes a task declared with "into @results": the
+// returned value goes to @results, and a task that failed or ended without
+// returning pushes the reason to @error instead, as compiled code does.
+func (i *Interpreter) pushSpawnResult(into string, val Value, err error) {
+	if errors.Is(err, errReturn) && !val.IsNil() {
+		results, ok := i.stacks[into]
+		if !ok {
+			err = fmt.Errorf("undefined stack: @%s", into)
+		} else {
+			if t := i.stackTypes[into]; t != "" {
+				val = convertValueToType(val, t)
+			}
+			err = results.Push(val)
+		}
+	} else if err == nil || errors.Is(err, errReturn) {
+		err = runtime.ErrNoResult
+	}
+	if err != nil {
+		i.stacks["error"].Push(NewString("spawn: " + err.Error()))
+	}
+}
+
 // execSpawnOp executes a spawn operation.
 func (i *Interpreter) execSpawnOp(s *ast.SpawnOp) error {
 	switch s.Op {
@@ -631,16 +863,31 @@ func (i *Interpreter) execSpawnOp(s *ast.SpawnOp) error {
 			i.spawnTasks = i.spawnTasks[1:]
 			i.spawnMu.Unlock()
 			if s.Play {
-				// Launch as goroutine (matches compiler behavior)
-				i.spawnWg.Add(1)
-				go func() {
-					defer i.spawnWg.Done()
+				// Run on the worker pool (matches compiler behavior)
+				i.spawnGroup.Add(1)
+				i.scheduler().Submit(func() {
+					defer i.spawnGroup.Done()
 					task()
-				}()
+				})
 			}
 		} else {
 			i.spawnMu.Unlock()
 		}
+	case "wait":
+		// Block until played tasks finish; wait(ms) gives up after a
+		// timeout with the "timeout" status and the number left
+		var timeout int64
+		if len(s.Args) > 0 {
+			v, err := i.evalExpr(s.Args[0])
+			if err != nil {
+				return err
+			}
+			timeout = v.AsInt()
+		}
+		if err := i.spawnGroup.Wait(timeout); err != nil {
+			i.status = "timeout"
+			i.statusValue = NewInt(int64(i.spawnGroup.Pending()))
+		}
 	case "peek":
 		i.spawnMu.Lock()
 		if len(i.spawnTasks) == 0 {
@@ -650,12 +897,12 @@ func (i *Interpreter) execSpawnOp(s *ast.SpawnOp) error {
 		task := i.spawnTasks[0]
 		i.spawnMu.Unlock()
 		if s.Play {
-			// Launch as goroutine (matches compiler behavior)
-			i.spawnWg.Add(1)
-			go func() {
-				defer i.spawnWg.Done()
+			// Run on the worker pool (matches compiler behavior)
+			i.spawnGroup.Add(1)
+			i.scheduler().Submit(func() {
+				defer i.spawnGroup.Done()
 				task()
-			}()
+			})
 		}
 	}
 	return nil
@@ -679,8 +926,7 @@ func (i *Interpreter) execViewOp(s *ast.ViewOp) error {
 			if !ok {
 				return fmt.Errorf("undefined stack: @%s", ref.Name)
 			}
-			view.Stack = stack
-			return nil
+			return view.attach(stack)
 		}
 		return fmt.Errorf("attach requires stack reference")
 		
@@ -690,7 +936,7 @@ func (i *Interpreter) execViewOp(s *ast.ViewOp) error {
 			if err != nil {
 				return err
 			}
-			fmt.Println(val.AsString())
+			fmt.Fprintln(i.stdout(), val.AsString())
 		}
 		return nil
 		
@@ -0,0 +1,1475 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// evalExpr evaluates an expression and returns its value. Literals,
+// operators and pure builtins are evaluated by pkg/eval, which the
+// compiler folds constants with, and the rest by evalOther.
+func (i *Interpreter) evalExpr(expr ast.Expr) (Value, error) {
+	if i.trace {
+		fmt.Fprintf(i.stdout(), "[TRACE] evalExpr: %T\n", expr)
+	}
+	return eval.Expr(expr, evalEnv{i})
+}
+
+// evalEnv is the Interpreter as the eval.Env of its expressions
+type evalEnv struct {
+	i *Interpreter
+}
+
+func (env evalEnv) Var(name string) (Value, error) {
+	i := env.i
+	// Fast path: check local vars cache first (for compute blocks)
+	if i.inComputeBlock && i.localVars != nil {
+		if val, ok := i.localVars[name]; ok {
+			return val, nil
+		}
+	}
+	
+	// Look up variable in scope stack
+	if val, ok := i.vars.Get(name); ok {
+		return val, nil
+	}
+	
+	return NilValue, fmt.Errorf("undefined variable: %s", name)
+}
+
+func (env evalEnv) Func(name string) bool {
+	_, ok := env.i.funcs[name]
+	return ok
+}
+
+func (env evalEnv) Other(expr ast.Expr) (Value, error) {
+	return env.i.evalOther(expr)
+}
+
+// evalOther evaluates an expression that needs the running program.
+func (i *Interpreter) evalOther(expr ast.Expr) (Value, error) {
+	switch e := expr.(type) {
+	case *ast.StackRef:
+		return i.evalStackRef(e)
+	case *ast.CallExpr:
+		return i.evalCallExpr(e)
+	case *ast.FuncCall:
+		return i.execFuncCall(e)
+	case *ast.StackExpr:
+		return i.evalStackExpr(e)
+	case *ast.MemberExpr:
+		return i.evalMemberExpr(e)
+	case *ast.IndexExpr:
+		return i.evalIndexExpr(e)
+	case *ast.RecordLit:
+		if i.computeStruct == nil {
+			return NilValue, fmt.Errorf("a record can only be pushed to or returned on a struct stack")
+		}
+		return i.evalRecord(i.computeStruct, e)
+	case *ast.MemberIndexExpr:
+		return i.evalMemberIndexExpr(e)
+	case *ast.FnLit:
+		return i.evalFnLit(e)
+	case *ast.PerspectiveLit:
+		return NewString(e.Value), nil
+	case *ast.TypeLit:
+		return NewString(e.Value), nil
+	case *ast.ViewExpr:
+		return i.evalViewExpr(e)
+	default:
+		return NilValue, fmt.Errorf("unknown expression type: %T", expr)
+	}
+}
+
+// evalStackRef evaluates a stack reference (@name).
+func (i *Interpreter) evalStackRef(e *ast.StackRef) (Value, error) {
+	stack, ok := i.stacks[e.Name]
+	if !ok {
+		return NilValue, fmt.Errorf("undefined stack: @%s", e.Name)
+	}
+	// Return stack length as value
+	return NewInt(int64(stack.Len())), nil
+}
+
+// evalCallExpr evaluates a function call expression.
+func (i *Interpreter) evalCallExpr(e *ast.CallExpr) (Value, error) {
+	// Built-in functions
+	switch e.Fn {
+	case "print":
+		for idx, arg := range e.Args {
+			val, err := i.evalExpr(arg)
+			if err != nil {
+				return NilValue, err
+			}
+			if idx > 0 {
+				fmt.Fprint(i.stdout(), " ")
+			}
+			fmt.Fprint(i.stdout(), val.AsString())
+		}
+		fmt.Fprintln(i.stdout())
+		return NilValue, nil
+	case "printf", "sprintf":
+		out, err := i.sprintf(e.Fn, e.Args)
+		if err != nil {
+			return NilValue, err
+		}
+		if e.Fn == "printf" {
+			fmt.Fprint(i.stdout(), out)
+			return NilValue, nil
+		}
+		return NewString(out), nil
+	case "call", "apply":
+		return i.evalCall(e.Fn, e.Args)
+	}
+	
+	// User-defined function
+	fn, ok := i.funcs[e.Fn]
+	if !ok {
+		if host, ok := i.hostFuncs[e.Fn]; ok {
+			return i.callHost(host, e.Args)
+		}
+		return NilValue, fmt.Errorf("undefined function: %s", e.Fn)
+	}
+	
+	return i.callFunc(fn, e.Args)
+}
+
+// execFuncCall executes a function call statement and returns result.
+// sprintf formats the arguments of a printf-style builtin by the format
+// string they start with
+func (i *Interpreter) sprintf(name string, argExprs []ast.Expr) (string, error) {
+	if len(argExprs) < 1 {
+		return "", fmt.Errorf("%s() requires a format string", name)
+	}
+	format, err := i.evalExpr(argExprs[0])
+	if err != nil {
+		return "", err
+	}
+	args := make([]Value, len(argExprs)-1)
+	for idx, arg := range argExprs[1:] {
+		if args[idx], err = i.evalExpr(arg); err != nil {
+			return "", err
+		}
+	}
+	out, err := runtime.Sprintf(format.AsString(), args...)
+	if err != nil {
+		return "", fmt.Errorf("%s(): %v", name, err)
+	}
+	return out, nil
+}
+
+func (i *Interpreter) execFuncCall(s *ast.FuncCall) (Value, error) {
+	// Check built-ins first
+	switch s.Name {
+	case "call", "apply":
+		return i.evalCall(s.Name, s.Args)
+	case "print":
+		for idx, arg := range s.Args {
+			val, err := i.evalExpr(arg)
+			if err != nil {
+				return NilValue, err
+			}
+			if idx > 0 {
+				fmt.Fprint(i.stdout(), " ")
+			}
+			fmt.Fprint(i.stdout(), val.AsString())
+		}
+		fmt.Fprintln(i.stdout())
+		return NilValue, nil
+	case "printf", "format":
+		// printf(fmt, args...) prints, format(fmt, args...) returns the string
+		out, err := i.sprintf(s.Name, s.Args)
+		if err != nil {
+			return NilValue, err
+		}
+		if s.Name == "printf" {
+			fmt.Fprint(i.stdout(), out)
+			return NilValue, nil
+		}
+		return NewString(out), nil
+	case "render":
+		// render(template, @vars)
+		if len(s.Args) != 2 {
+			return NilValue, fmt.Errorf("render() requires (template, @stack) arguments")
+		}
+		ref, ok := s.Args[1].(*ast.StackRef)
+		if !ok {
+			return NilValue, fmt.Errorf("render() second argument must be a stack reference")
+		}
+		vars, ok := i.stacks[ref.Name]
+		if !ok {
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		tmpl, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		out, err := runtime.RenderValues(tmpl.AsString(), vars)
+		if err != nil {
+			return NilValue, err
+		}
+		return NewString(out), nil
+	case "is_tty":
+		return NewBool(i.out == nil && runtime.IsTTY()), nil
+	case "color":
+		// color(name, s)
+		if len(s.Args) != 2 {
+			return NilValue, fmt.Errorf("color() requires (name, string) arguments")
+		}
+		name, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		text, err := i.evalExpr(s.Args[1])
+		if err != nil {
+			return NilValue, err
+		}
+		if i.out != nil {
+			return text, nil // not written to a terminal
+		}
+		return NewString(runtime.Color(name.AsString(), text.AsString())), nil
+	case "prompt", "confirm", "password":
+		// prompt(msg) / password(msg) read a line, confirm(msg) asks y/N
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("%s() requires a message argument", s.Name)
+		}
+		msg, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		if i.in != nil {
+			return i.prompt(s.Name, msg.AsString()), nil
+		}
+		switch s.Name {
+		case "confirm":
+			return NewBool(runtime.Confirm(msg.AsString())), nil
+		case "password":
+			return NewString(runtime.Password(msg.AsString())), nil
+		}
+		return NewString(runtime.Prompt(msg.AsString())), nil
+	case "format_int", "format_float":
+		// format_int(n, width, pad) / format_float(x, prec, width)
+		if len(s.Args) != 3 {
+			if s.Name == "format_int" {
+				return NilValue, fmt.Errorf("format_int() requires (n, width, pad) arguments")
+			}
+			return NilValue, fmt.Errorf("format_float() requires (x, prec, width) arguments")
+		}
+		args := make([]Value, 3)
+		for idx, arg := range s.Args {
+			v, err := i.evalExpr(arg)
+			if err != nil {
+				return NilValue, err
+			}
+			args[idx] = v
+		}
+		if s.Name == "format_int" {
+			return NewString(runtime.FormatInt(args[0].AsInt(), args[1].AsInt(), args[2].AsString())), nil
+		}
+		return NewString(runtime.FormatFloat(args[0].AsFloat(), args[1].AsInt(), args[2].AsInt())), nil
+	case "uuid4":
+		return NewString(runtime.UUID4()), nil
+	case "ulid":
+		return NewString(runtime.ULID()), nil
+	case "seq":
+		// seq(name) - next value of a per-program counter, from 1
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("seq() requires a counter name argument")
+		}
+		name, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		return NewInt(runtime.Seq(name.AsString())), nil
+	case "readfile", "exists":
+		// readfile(path) - the file's contents; exists(path) - whether it is there
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("%s() requires a path argument", s.Name)
+		}
+		path, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		if s.Name == "exists" {
+			return NewBool(runtime.FileExists(path.AsString())), nil
+		}
+		text, err := runtime.ReadFile(path.AsString())
+		i.fileStatus(err)
+		return NewString(text), nil
+	case "rand":
+		// rand() - a float from 0 up to but not including 1
+		if len(s.Args) != 0 {
+			return NilValue, fmt.Errorf("rand() takes no arguments")
+		}
+		return NewFloat(runtime.Rand()), nil
+	case "rand_int", "seed":
+		// rand_int(n) - an integer from 0 up to n; seed(x) restarts the sequence
+		if len(s.Args) != 1 {
+			if s.Name == "seed" {
+				return NilValue, fmt.Errorf("seed() requires a seed argument")
+			}
+			return NilValue, fmt.Errorf("rand_int() requires a bound argument")
+		}
+		v, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		if s.Name == "seed" {
+			runtime.Seed(v.AsInt())
+			return NilValue, nil
+		}
+		return NewInt(runtime.RandInt(v.AsInt())), nil
+	case "env":
+		// env(name) - the variable's value, "" with the not_found status if unset
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("env() requires a variable name argument")
+		}
+		name, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		v, ok := i.getenv(name.AsString())
+		if !ok {
+			i.status = "not_found"
+			i.statusValue = NewString(name.AsString())
+		}
+		return NewString(v), nil
+	case "setenv":
+		// setenv(name, value) - true if set
+		if len(s.Args) != 2 {
+			return NilValue, fmt.Errorf("setenv() requires (name, value) arguments")
+		}
+		name, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		value, err := i.evalExpr(s.Args[1])
+		if err != nil {
+			return NilValue, err
+		}
+		err = i.setenv(name.AsString(), value.AsString())
+		i.fileStatus(err)
+		return NewBool(err == nil), nil
+	case "readline":
+		// readline() - the next line of stdin, "" with the eof status at its end
+		if len(s.Args) != 0 {
+			return NilValue, fmt.Errorf("readline() takes no arguments")
+		}
+		line, err := i.readLine()
+		i.fileStatus(err)
+		return NewString(line), nil
+	case "args":
+		// args(@s) - pushes the program's arguments, returns how many
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("args() requires a @stack argument")
+		}
+		ref, ok := s.Args[0].(*ast.StackRef)
+		if !ok {
+			return NilValue, fmt.Errorf("args() argument must be a stack reference")
+		}
+		st, ok := i.stacks[ref.Name]
+		if !ok {
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		if t := i.stackTypes[ref.Name]; t != "string" {
+			return NilValue, fmt.Errorf("args() requires a string stack, @%s is %s", ref.Name, t)
+		}
+		if st.IsHash() {
+			return NilValue, fmt.Errorf("args() cannot push to the Hash stack @%s", ref.Name)
+		}
+		for _, arg := range i.args {
+			if err := st.Push(NewString(arg)); err != nil {
+				return NilValue, err
+			}
+		}
+		return NewInt(int64(len(i.args))), nil
+	case "writefile", "appendfile":
+		// writefile(path, @s) / appendfile(path, @s) - true if written
+		if len(s.Args) != 2 {
+			return NilValue, fmt.Errorf("%s() requires (path, @stack) arguments", s.Name)
+		}
+		ref, ok := s.Args[1].(*ast.StackRef)
+		if !ok {
+			return NilValue, fmt.Errorf("%s() second argument must be a stack reference", s.Name)
+		}
+		st, ok := i.stacks[ref.Name]
+		if !ok {
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		if st.IsHash() {
+			return NilValue, fmt.Errorf("%s() cannot write the Hash stack @%s", s.Name, ref.Name)
+		}
+		path, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		// In push order: bytes as they are, anything else a line each
+		var data []byte
+		for _, v := range st.All() {
+			data = append(data, i.formatElement(ref.Name, v)...)
+			if i.stackTypes[ref.Name] != "bytes" {
+				data = append(data, '\n')
+			}
+		}
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if s.Name == "appendfile" {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(path.AsString(), flag, 0644)
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		i.fileStatus(err)
+		return NewBool(err == nil), nil
+	case "read_csv", "write_csv":
+		// read_csv(path, @a, @b, ...) - rows read; write_csv(...) - true if written
+		if len(s.Args) < 2 {
+			return NilValue, fmt.Errorf("%s() requires (path, @stack, ...) arguments", s.Name)
+		}
+		var header []string
+		var cols []*ValueStack
+		var types []runtime.ElementType
+		for _, arg := range s.Args[1:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				return NilValue, fmt.Errorf("%s() columns must be stack references", s.Name)
+			}
+			st, ok := i.stacks[ref.Name]
+			if !ok {
+				return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+			}
+			if st.IsHash() {
+				return NilValue, fmt.Errorf("%s() cannot take the Hash stack @%s as a column", s.Name, ref.Name)
+			}
+			header = append(header, ref.Name)
+			cols = append(cols, st)
+			types = append(types, elementType(i.stackTypes[ref.Name]))
+		}
+		path, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		if s.Name == "read_csv" {
+			n, err := runtime.ReadCSVValues(path.AsString(), cols, types)
+			i.fileStatus(err)
+			return NewInt(n), nil
+		}
+		err = runtime.WriteCSVValues(path.AsString(), header, cols, types)
+		i.fileStatus(err)
+		return NewBool(err == nil), nil
+	case "listen":
+		// listen(addr, @reqs, @resps) - serves HTTP in the background, true if listening
+		if len(s.Args) != 3 {
+			return NilValue, fmt.Errorf("listen() requires (addr, @requests, @responses) arguments")
+		}
+		var stacks []*ValueStack
+		for _, arg := range s.Args[1:] {
+			ref, ok := arg.(*ast.StackRef)
+			if !ok {
+				return NilValue, fmt.Errorf("listen() requests and responses must be stack references")
+			}
+			st, ok := i.stacks[ref.Name]
+			if !ok {
+				return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+			}
+			if i.stackTypes[ref.Name] != "string" || st.IsHash() {
+				return NilValue, fmt.Errorf("listen() requires string stacks that are not Hash stacks, @%s is not one", ref.Name)
+			}
+			stacks = append(stacks, st)
+		}
+		addr, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		_, err = runtime.ServeHTTPValues(addr.AsString(), stacks[0], stacks[1])
+		i.fileStatus(err)
+		return NewBool(err == nil), nil
+	case "exit":
+		// exit / exit(code) - runs exit hooks, skips pending defers
+		if len(s.Args) > 1 {
+			return NilValue, fmt.Errorf("exit() takes at most one argument")
+		}
+		code := int64(0)
+		if len(s.Args) == 1 {
+			v, err := i.evalExpr(s.Args[0])
+			if err != nil {
+				return NilValue, err
+			}
+			code = v.AsInt()
+		}
+		if i.exitReturns {
+			return NilValue, &ExitError{Code: int(code)} // Run runs the exit hooks
+		}
+		runtime.Exit(int(code))
+		return NilValue, nil
+	case "clear_line":
+		if i.out == nil {
+			runtime.ClearLine()
+		}
+		return NilValue, nil
+	case "assert":
+		// assert(cond) or assert(cond, msg) - fails the program when false
+		if len(s.Args) < 1 || len(s.Args) > 2 {
+			return NilValue, fmt.Errorf("assert() requires a condition and an optional message")
+		}
+		cond, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		if cond.AsBool() {
+			return NilValue, nil
+		}
+		msg := "assertion failed"
+		if len(s.Args) == 2 {
+			v, err := i.evalExpr(s.Args[1])
+			if err != nil {
+				return NilValue, err
+			}
+			msg += ": " + v.AsString()
+		}
+		return NilValue, fmt.Errorf("line %d: %s", i.pos[s].Line, msg)
+	case "expect_stack":
+		// expect_stack(@s, [...]) - reports a mismatch and carries on
+		var ref *ast.StackRef
+		var list *ast.ListLit
+		if len(s.Args) == 2 {
+			ref, _ = s.Args[0].(*ast.StackRef)
+			list, _ = s.Args[1].(*ast.ListLit)
+		}
+		if ref == nil || list == nil {
+			return NilValue, fmt.Errorf("expect_stack() requires a stack and a list, e.g. expect_stack(@s, [1, 2])")
+		}
+		stack, ok := i.stacks[ref.Name]
+		if !ok {
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		elemType := i.stackTypes[ref.Name]
+		want := make([]Value, len(list.Elems))
+		for idx, e := range list.Elems {
+			v, err := i.evalExpr(e)
+			if err != nil {
+				return NilValue, err
+			}
+			want[idx] = convertValueForStack(v, elemType)
+		}
+		return NewBool(runtime.ExpectValueStack(i.pos[s].Line, ref.Name, stack, want)), nil
+	case "expect_output":
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("expect_output() requires one string argument")
+		}
+		want, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		return NewBool(runtime.ExpectOutput(i.pos[s].Line, want.AsString())), nil
+	case "freeze_time":
+		runtime.FreezeTime()
+		return NilValue, nil
+	case "advance_time", "wait_timers":
+		if len(s.Args) != 1 {
+			return NilValue, fmt.Errorf("%s() requires one argument", s.Name)
+		}
+		n, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		if s.Name == "advance_time" {
+			runtime.AdvanceTime(n.AsInt())
+		} else {
+			runtime.WaitTimers(int(n.AsInt()))
+		}
+		return NilValue, nil
+	case "mock":
+		// mock(@s, [...]) or mock(@s, [...], @sent) - scripted reads,
+		// pushes recorded on @sent
+		var ref, sentRef *ast.StackRef
+		var list *ast.ListLit
+		if len(s.Args) == 2 || len(s.Args) == 3 {
+			ref, _ = s.Args[0].(*ast.StackRef)
+			list, _ = s.Args[1].(*ast.ListLit)
+		}
+		if len(s.Args) == 3 {
+			if sentRef, _ = s.Args[2].(*ast.StackRef); sentRef == nil {
+				ref = nil
+			}
+		}
+		if ref == nil || list == nil {
+			return NilValue, fmt.Errorf("mock() requires a stack and a list, e.g. mock(@net, [\"ok\"], @sent)")
+		}
+		stack, ok := i.stacks[ref.Name]
+		if !ok {
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		var sent *ValueStack
+		if sentRef != nil {
+			if sent, ok = i.stacks[sentRef.Name]; !ok {
+				return NilValue, fmt.Errorf("undefined stack: @%s", sentRef.Name)
+			}
+			if i.stackTypes[sentRef.Name] != i.stackTypes[ref.Name] {
+				return NilValue, fmt.Errorf("mock() records pushes on another stack of the same type as @%s", ref.Name)
+			}
+		}
+		elemType := i.stackTypes[ref.Name]
+		script := make([]Value, len(list.Elems))
+		for idx, e := range list.Elems {
+			v, err := i.evalExpr(e)
+			if err != nil {
+				return NilValue, err
+			}
+			script[idx] = convertValueForStack(v, elemType)
+		}
+		if err := stack.Mock(sent, script); err != nil {
+			return NilValue, fmt.Errorf("mock(@%s): %v", ref.Name, err)
+		}
+		return NilValue, nil
+	case "query", "exec":
+		// The driver is a module of its own, which iual does not link
+		return NilValue, fmt.Errorf("%s() needs SQLite, which iual does not have: use ual run", s.Name)
+	case "runtime_stats":
+		// runtime_stats(@health) - process figures into a Hash i64 stack
+		var ref *ast.StackRef
+		if len(s.Args) == 1 {
+			ref, _ = s.Args[0].(*ast.StackRef)
+		}
+		if ref == nil {
+			return NilValue, fmt.Errorf("runtime_stats() requires a stack argument")
+		}
+		dst, ok := i.stacks[ref.Name]
+		if !ok || !dst.IsHash() || i.stackTypes[ref.Name] != "i64" {
+			return NilValue, fmt.Errorf("runtime_stats() requires a Hash stack of i64, @%s is not one", ref.Name)
+		}
+		stacks := make(map[string]*runtime.Stack, len(i.stacks))
+		for name, vs := range i.stacks {
+			stacks[name] = vs.Stack()
+		}
+		stats := runtime.RuntimeStats(stacks)
+		dst.Clear()
+		for _, st := range stats {
+			dst.Set(st.Name, NewInt(st.Value))
+		}
+		return NilValue, nil
+	case "progress":
+		// progress(n, total)
+		if len(s.Args) != 2 {
+			return NilValue, fmt.Errorf("progress() requires (n, total) arguments")
+		}
+		n, err := i.evalExpr(s.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		total, err := i.evalExpr(s.Args[1])
+		if err != nil {
+			return NilValue, err
+		}
+		if i.out == nil {
+			runtime.Progress(n.AsInt(), total.AsInt())
+		}
+		return NilValue, nil
+	}
+	
+	// User-defined function
+	fn, ok := i.funcs[s.Name]
+	if !ok {
+		if host, ok := i.hostFuncs[s.Name]; ok {
+			return i.callHost(host, s.Args)
+		}
+		return NilValue, fmt.Errorf("undefined function: %s", s.Name)
+	}
+	
+	return i.callFunc(fn, s.Args)
+}
+
+// callHost calls a function the application provides.
+func (i *Interpreter) callHost(fn HostFunc, argExprs []ast.Expr) (Value, error) {
+	args := make([]Value, len(argExprs))
+	for idx, argExpr := range argExprs {
+		v, err := i.evalExpr(argExpr)
+		if err != nil {
+			return NilValue, err
+		}
+		args[idx] = v
+	}
+	return fn(args)
+}
+
+// callFunc calls a user-defined function.
+func (i *Interpreter) callFunc(fn *ast.FuncDecl, argExprs []ast.Expr) (Value, error) {
+	// Evaluate arguments; stack parameters take the stack itself
+	args := make([]Value, len(argExprs))
+	for idx, argExpr := range argExprs {
+		if idx < len(fn.Params) && fn.Params[idx].Stack {
+			continue
+		}
+		val, err := i.evalExpr(argExpr)
+		if err != nil {
+			return NilValue, err
+		}
+		args[idx] = val
+	}
+	
+	// Check arity
+	if len(args) != len(fn.Params) {
+		return NilValue, fmt.Errorf("function %s expects %d arguments, got %d", fn.Name, len(fn.Params), len(args))
+	}
+	
+	// Bind stack parameters to the argument stacks, and type parameters to
+	// their element types, restoring whatever they shadow on return
+	type shadowed struct {
+		stack *ValueStack
+		typ   string
+		ok    bool
+	}
+	var stackParams map[string]shadowed
+	savedTypeArgs := i.typeArgs
+	if len(fn.TypeParams) > 0 {
+		i.typeArgs = map[string]string{}
+	}
+	for idx, param := range fn.Params {
+		if !param.Stack {
+			continue
+		}
+		ref, ok := argExprs[idx].(*ast.StackRef)
+		if !ok {
+			i.typeArgs = savedTypeArgs
+			return NilValue, fmt.Errorf("%s: argument %d must be a stack, as in %s(@name)", fn.Name, idx+1, fn.Name)
+		}
+		stack, ok := i.stacks[ref.Name]
+		if !ok {
+			i.typeArgs = savedTypeArgs
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		elemType := i.stackTypes[ref.Name]
+		if isTypeParam(fn, param.Type) {
+			if prev, ok := i.typeArgs[param.Type]; ok && prev != elemType {
+				i.typeArgs = savedTypeArgs
+				return NilValue, fmt.Errorf("%s: %s is both %s and %s", fn.Name, param.Type, prev, elemType)
+			}
+			if !isNumericElem(elemType) {
+				i.typeArgs = savedTypeArgs
+				return NilValue, fmt.Errorf("%s: %s must be a numeric type, but @%s is a stack(%s)", fn.Name, param.Type, ref.Name, elemType)
+			}
+			i.typeArgs[param.Type] = elemType
+		} else if want := param.Type; elemType != want && !(want == "fn" && elemType == "i64") {
+			i.typeArgs = savedTypeArgs
+			return NilValue, fmt.Errorf("%s: argument %d must be a stack(%s), but @%s is a stack(%s)", fn.Name, idx+1, want, ref.Name, elemType)
+		}
+		if stackParams == nil {
+			stackParams = map[string]shadowed{}
+		}
+		if _, seen := stackParams[param.Name]; !seen {
+			old, had := i.stacks[param.Name]
+			stackParams[param.Name] = shadowed{old, i.stackTypes[param.Name], had}
+		}
+		i.stacks[param.Name] = stack
+		i.stackTypes[param.Name] = elemType
+	}
+	defer func() {
+		for name, s := range stackParams {
+			if s.ok {
+				i.stacks[name], i.stackTypes[name] = s.stack, s.typ
+			} else {
+				delete(i.stacks, name)
+				delete(i.stackTypes, name)
+			}
+		}
+		i.typeArgs = savedTypeArgs
+	}()
+	
+	return i.invoke(fn, args)
+}
+
+// invoke runs the body of fn with args bound to its parameters, which
+// take values; stack parameters are bound already.
+func (i *Interpreter) invoke(fn *ast.FuncDecl, args []Value) (Value, error) {
+	args = i.typedArgs(fn, args)
+	p := i.bytecode(fn)
+	if p != nil && p.Pure {
+		// Pure code needs none of the scope and defer set up below
+		val, err := i.machine.Run(p, args...)
+		if err = i.fromVM(val, err); err != nil {
+			return val, err
+		}
+		return i.typedResult(fn, val), nil
+	}
+	
+	if i.dbg != nil {
+		i.dbg.enter(fn.Name)
+		defer i.dbg.leave()
+	}
+	
+	// Save and clear defer stack for this function scope
+	savedDefers := i.deferStack
+	i.deferStack = nil
+	
+	// Mark that we're in a function (disables auto-print tracking)
+	savedInFunction := i.inFunction
+	i.inFunction = true
+	
+	// Run in a new scope below the globals, not the caller's locals
+	savedVars := i.vars
+	i.vars = i.vars.FunctionScope()
+	
+	// Execute body, as bytecode if it compiled
+	var returnVal Value = NilValue
+	var execErr error
+	if p != nil {
+		returnVal, execErr = i.machine.Run(p, args...)
+		execErr = i.fromVM(returnVal, execErr)
+	} else {
+		// Bind parameters
+		for idx, param := range fn.Params {
+			if param.Stack {
+				continue
+			}
+			i.vars.Set(param.Name, args[idx])
+		}
+		for _, stmt := range fn.Body {
+			err := i.execStmt(stmt)
+			if err != nil {
+				if err == errReturn {
+					returnVal = i.returnVal
+					break
+				}
+				execErr = err
+				break
+			}
+		}
+	}
+	
+	// Run function-scoped defers in LIFO order
+	for idx := len(i.deferStack) - 1; idx >= 0; idx-- {
+		i.deferStack[idx]()
+	}
+	
+	// Restore the caller's scopes, defer stack and inFunction flag
+	i.vars = savedVars
+	i.deferStack = savedDefers
+	i.inFunction = savedInFunction
+	
+	if execErr != nil {
+		return NilValue, execErr
+	}
+	return i.typedResult(fn, returnVal), nil
+}
+
+// typedArgs returns args converted to the numeric types of fn's
+// parameters, as the compilers pass them: 3 passed as an f32 is 3.0, and
+// 300 passed as a u8 is 44. args is copied only if one needs converting.
+func (i *Interpreter) typedArgs(fn *ast.FuncDecl, args []Value) []Value {
+	copied := false
+	for idx, param := range fn.Params {
+		t := param.Type
+		if param.Stack || t == "i64" || idx >= len(args) || !args[idx].IsNumeric() {
+			continue
+		}
+		if arg, ok := i.typeArgs[t]; ok {
+			t = arg
+		}
+		if !isNumericElem(t) || t == "i64" {
+			continue
+		}
+		v := convertValueToType(args[idx], t)
+		if bits := runtime.UintBits(t); bits > 0 {
+			v = NewUint(uint64(args[idx].AsInt()), bits)
+		}
+		if !copied {
+			args = append([]Value(nil), args...)
+			copied = true
+		}
+		args[idx] = v
+	}
+	return args
+}
+
+// typedResult converts v, returned by fn, to fn's numeric return type, as
+// T in a generic function: integers keep the low bits of their width and
+// f32 rounds, as in the compilers.
+func (i *Interpreter) typedResult(fn *ast.FuncDecl, v Value) Value {
+	t := fn.ReturnType
+	if t == "" || t == "i64" || t == "f64" || !v.IsNumeric() {
+		return v
+	}
+	if arg, ok := i.typeArgs[t]; ok {
+		t = arg
+	}
+	if !isNumericElem(t) {
+		return v
+	}
+	if bits := runtime.UintBits(t); bits > 0 {
+		return NewUint(uint64(v.AsInt()), bits)
+	}
+	return convertValueToType(v, t)
+}
+
+// isTypeParam reports whether t is a type parameter of fn.
+func isTypeParam(fn *ast.FuncDecl, t string) bool {
+	for _, tp := range fn.TypeParams {
+		if tp == t {
+			return true
+		}
+	}
+	return false
+}
+
+// evalStackExpr evaluates a stack expression (@stack: op()).
+func (i *Interpreter) evalStackExpr(e *ast.StackExpr) (Value, error) {
+	stack, ok := i.stacks[e.Stack]
+	if !ok {
+		return NilValue, fmt.Errorf("undefined stack: @%s", e.Stack)
+	}
+	
+	switch e.Op {
+	case "pop", "peek":
+		pop := stack.Pop
+		if e.Op == "peek" {
+			pop = stack.Peek
+		}
+		v, err := pop()
+		// An unsigned stack's elements print as unsigned
+		if bits := runtime.UintBits(i.stackTypes[e.Stack]); bits > 0 && err == nil && v.Type == runtime.VTInt {
+			v = NewUint(uint64(v.AsInt()), bits)
+		}
+		return v, err
+	case "len":
+		return NewInt(int64(stack.Len())), nil
+	case "get":
+		if len(e.Args) < 1 {
+			return NilValue, fmt.Errorf("get() requires key argument")
+		}
+		key, err := i.evalExpr(e.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		val, ok := stack.Get(key.AsString())
+		if !ok {
+			return NilValue, fmt.Errorf("key not found: %s", key.AsString())
+		}
+		return val, nil
+	case "has":
+		if len(e.Args) != 1 {
+			return NilValue, fmt.Errorf("has() requires key argument")
+		}
+		key, err := i.evalExpr(e.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		return NewBool(stack.Has(key.AsString())), nil
+	case "to_json":
+		return NewString(stack.ToJSON(elementType(i.stackTypes[e.Stack]))), nil
+	case "reduce", "preduce":
+		// reduce(initial, {|acc, elem| expr}); preduce folds in parallel in
+		// compiled code, which gives the same result for associative fns
+		if len(e.Args) < 2 {
+			return NilValue, fmt.Errorf("reduce() requires initial value and function")
+		}
+		initial, err := i.evalExpr(e.Args[0])
+		if err != nil {
+			return NilValue, err
+		}
+		fn, ok := e.Args[1].(*ast.FnLit)
+		if !ok {
+			return NilValue, fmt.Errorf("reduce() requires a function literal")
+		}
+		if len(fn.Params) != 2 {
+			return NilValue, fmt.Errorf("reduce function must have 2 parameters")
+		}
+		
+		// Iterate over stack elements
+		acc := initial
+		elements := stack.All()
+		for _, elem := range elements {
+			// Create scope for function call
+			i.vars.PushScope()
+			i.vars.Set(fn.Params[0], acc)
+			i.vars.Set(fn.Params[1], elem)
+			
+			// Execute function body
+			var result Value
+			for _, stmt := range fn.Body {
+				err := i.execStmt(stmt)
+				if err != nil {
+					if errors.Is(err, errReturn) {
+						result = i.returnVal
+						break
+					}
+					i.vars.PopScope()
+					return NilValue, err
+				}
+			}
+			
+			// If no explicit return, try to evaluate last statement as expression
+			if result.IsNil() && len(fn.Body) == 1 {
+				if exprStmt, ok := fn.Body[0].(*ast.ExprStmt); ok {
+					result, _ = i.evalExpr(exprStmt.Expr)
+				}
+			}
+			
+			i.vars.PopScope()
+			acc = result
+		}
+		return acc, nil
+	case "map":
+		// map({|elem| expr})
+		if len(e.Args) < 1 {
+			return NilValue, fmt.Errorf("map() requires a function")
+		}
+		fn, ok := e.Args[0].(*ast.FnLit)
+		if !ok {
+			return NilValue, fmt.Errorf("map() requires a function literal")
+		}
+		
+		elements := stack.All()
+		results := make([]Value, 0, len(elements))
+		for _, elem := range elements {
+			i.vars.PushScope()
+			if len(fn.Params) > 0 {
+				i.vars.Set(fn.Params[0], elem)
+			}
+			
+			var result Value
+			for _, stmt := range fn.Body {
+				err := i.execStmt(stmt)
+				if err != nil {
+					if errors.Is(err, errReturn) {
+						result = i.returnVal
+						break
+					}
+					i.vars.PopScope()
+					return NilValue, err
+				}
+			}
+			if result.IsNil() && len(fn.Body) == 1 {
+				if exprStmt, ok := fn.Body[0].(*ast.ExprStmt); ok {
+					result, _ = i.evalExpr(exprStmt.Expr)
+				}
+			}
+			i.vars.PopScope()
+			results = append(results, result)
+		}
+		// Return as array value
+		return NewArray(results), nil
+	case "filter":
+		// filter({|elem| condition})
+		if len(e.Args) < 1 {
+			return NilValue, fmt.Errorf("filter() requires a function")
+		}
+		fn, ok := e.Args[0].(*ast.FnLit)
+		if !ok {
+			return NilValue, fmt.Errorf("filter() requires a function literal")
+		}
+		
+		elements := stack.All()
+		results := make([]Value, 0)
+		for _, elem := range elements {
+			i.vars.PushScope()
+			if len(fn.Params) > 0 {
+				i.vars.Set(fn.Params[0], elem)
+			}
+			
+			var result Value
+			for _, stmt := range fn.Body {
+				err := i.execStmt(stmt)
+				if err != nil {
+					if errors.Is(err, errReturn) {
+						result = i.returnVal
+						break
+					}
+					i.vars.PopScope()
+					return NilValue, err
+				}
+			}
+			if result.IsNil() && len(fn.Body) == 1 {
+				if exprStmt, ok := fn.Body[0].(*ast.ExprStmt); ok {
+					result, _ = i.evalExpr(exprStmt.Expr)
+				}
+			}
+			i.vars.PopScope()
+			if result.AsBool() {
+				results = append(results, elem)
+			}
+		}
+		return NewArray(results), nil
+	default:
+		return NilValue, fmt.Errorf("unknown stack expression operation: %s", e.Op)
+	}
+}
+
+// evalMemberExpr evaluates a member expression (e.g., self.prop).
+func (i *Interpreter) evalMemberExpr(e *ast.MemberExpr) (Value, error) {
+	if e.Target == "self" {
+		// First check if we're in a compute block with a hash stack
+		if i.computeStack != nil {
+			val, ok := i.computeStack.Get(e.Member)
+			if ok {
+				return val, nil
+			}
+		}
+		// Fall back to looking up self.member in vars
+		val, ok := i.vars.Get("self." + e.Member)
+		if ok {
+			return val, nil
+		}
+		return NilValue, fmt.Errorf("undefined self member: %s", e.Member)
+	}
+	return NilValue, fmt.Errorf("member access not supported for: %s", e.Target)
+}
+
+// evalIndexExpr evaluates an index expression (e.g., arr[i]).
+func (i *Interpreter) evalIndexExpr(e *ast.IndexExpr) (Value, error) {
+	idx, err := i.evalExpr(e.Index)
+	if err != nil {
+		return NilValue, err
+	}
+	
+	if e.Target == "self" {
+		// First check if we're in a compute block with an Indexed stack
+		if i.computeStack != nil {
+			index := int(idx.AsInt())
+			elements := i.computeStack.All()
+			if index < 0 || index >= len(elements) {
+				return NilValue, fmt.Errorf("self index out of bounds: %d (len %d)", index, len(elements))
+			}
+			if e.Field == "" && i.computeStruct == nil {
+				return elements[index], nil
+			}
+			return i.structField(elements[index], e.Field)
+		}
+		// Fall back to looking up self array in vars
+		arrVal, ok := i.vars.Get("self")
+		if !ok {
+			return NilValue, fmt.Errorf("self not defined")
+		}
+		if !arrVal.IsArray() {
+			return NilValue, fmt.Errorf("self is not indexable")
+		}
+		arr := arrVal.AsArray()
+		index := int(idx.AsInt())
+		if index < 0 || index >= len(arr) {
+			return NilValue, fmt.Errorf("self index out of bounds: %d", index)
+		}
+		return arr[index], nil
+	}
+	
+	// Regular array
+	arrVal, ok := i.vars.Get(e.Target)
+	if !ok {
+		return NilValue, fmt.Errorf("undefined variable: %s", e.Target)
+	}
+	
+	if !arrVal.IsArray() {
+		return NilValue, fmt.Errorf("%s is not an array", e.Target)
+	}
+	
+	arr := arrVal.AsArray()
+	index := int(idx.AsInt())
+	if index < 0 || index >= len(arr) {
+		return NilValue, fmt.Errorf("array index out of bounds: %d (len %d)", index, len(arr))
+	}
+	return arr[index], nil
+}
+
+// structField returns the named field of a struct element in a compute
+// block
+func (i *Interpreter) structField(elem Value, name string) (Value, error) {
+	t := i.computeStruct
+	if t == nil {
+		return NilValue, fmt.Errorf("self[i].%s: the stack does not hold structs", name)
+	}
+	if name == "" {
+		return NilValue, fmt.Errorf("the stack holds structs; read a field, as in self[i].%s", t.Fields[0].Name)
+	}
+	idx := t.Field(name)
+	if idx < 0 {
+		return NilValue, fmt.Errorf("struct has no field %s", name)
+	}
+	v := elem.AsArray()[idx]
+	if v.Type == runtime.VTBool {
+		// Compute blocks are numeric: a bool field reads as 1 or 0
+		return NewInt(v.AsInt()), nil
+	}
+	return v, nil
+}
+
+// evalStruct evaluates a record pushed to the struct stack name
+func (i *Interpreter) evalStruct(name string, e ast.Expr) (Value, error) {
+	rec, ok := e.(*ast.RecordLit)
+	if !ok {
+		return NilValue, fmt.Errorf("@%s holds structs; push a record such as {x: 1.0, y: 2.0}", name)
+	}
+	return i.evalRecord(i.structs[name], rec)
+}
+
+// evalRecord builds a struct element of type t from a record literal. It
+// is an array of field Values, converted to the field types; fields left
+// out are zero.
+func (i *Interpreter) evalRecord(t *runtime.StructType, rec *ast.RecordLit) (Value, error) {
+	vals := make([]Value, len(t.Fields))
+	for idx, name := range rec.Names {
+		f := t.Field(name)
+		if f < 0 {
+			return NilValue, fmt.Errorf("struct has no field %s", name)
+		}
+		v, err := i.evalExpr(rec.Values[idx])
+		if err != nil {
+			return NilValue, err
+		}
+		vals[f] = v
+	}
+	return NewArray(t.Values(t.PackValues(vals))), nil
+}
+
+// fileStatus sets the consider status of a file builtin that failed with
+// err, if it did
+func (i *Interpreter) fileStatus(err error) {
+	if err != nil {
+		i.status = runtime.FileStatus(err)
+		i.statusValue = NewString(err.Error())
+	}
+}
+
+// formatElement renders an element popped from the stack name for
+// printing
+func (i *Interpreter) formatElement(name string, v Value) string {
+	if t := i.structs[name]; t != nil && v.IsArray() {
+		return t.Format(t.PackValues(v.AsArray()))
+	}
+	if bits := runtime.UintBits(i.stackTypes[name]); bits > 0 && v.Type == runtime.VTInt {
+		return strconv.FormatUint(runtime.WrapUint(uint64(v.AsInt()), bits), 10)
+	}
+	if i.stackTypes[name] == "f32" && v.Type == runtime.VTFloat {
+		return strconv.FormatFloat(v.AsFloat(), 'g', -1, 32)
+	}
+	return v.AsString()
+}
+
+// evalMemberIndexExpr evaluates self.prop[i].
+func (i *Interpreter) evalMemberIndexExpr(e *ast.MemberIndexExpr) (Value, error) {
+	idx, err := i.evalExpr(e.Index)
+	if err != nil {
+		return NilValue, err
+	}
+	
+	// In a compute block, the property is read from the stack
+	var arrVal Value
+	ok := false
+	if i.computeStack != nil {
+		arrVal, ok = i.computeStack.Get(e.Member)
+	}
+	if !ok {
+		// Look up self.member as array
+		arrVal, ok = i.vars.Get("self." + e.Member)
+	}
+	if !ok {
+		return NilValue, fmt.Errorf("undefined self member: %s", e.Member)
+	}
+	
+	arr := []Value{arrVal}
+	if arrVal.IsArray() {
+		arr = arrVal.AsArray()
+	} else if i.computeStack == nil {
+		return NilValue, fmt.Errorf("self.%s is not indexable", e.Member)
+	}
+	
+	index := int(idx.AsInt())
+	if index < 0 || index >= len(arr) {
+		return NilValue, fmt.Errorf("self.%s index out of bounds: %d (len %d)", e.Member, index, len(arr))
+	}
+	return arr[index], nil
+}
+
+// codeblock is a codeblock value: its code and a copy of the variables
+// in scope when it was created, so it captures them by value as the
+// compiled backends do
+type codeblock struct {
+	fn   *ast.FnLit
+	vars *ScopeStack
+}
+
+// evalFnLit evaluates a function literal (codeblock) used as a value. The
+// value is a handle, an int, as ual.NewFn returns in compiled programs.
+func (i *Interpreter) evalFnLit(e *ast.FnLit) (Value, error) {
+	i.codeblockMu.Lock()
+	defer i.codeblockMu.Unlock()
+	i.codeblocks = append(i.codeblocks, &codeblock{fn: e, vars: i.vars.Clone()})
+	return NewInt(int64(len(i.codeblocks))), nil
+}
+
+// callCodeblock calls the codeblock value h with args. Each call starts
+// from the captured variables, with the parameters bound in a scope of
+// their own; the result is the codeblock's only expression, or what it
+// returns, as an int.
+func (i *Interpreter) callCodeblock(h Value, args []Value) (Value, error) {
+	i.codeblockMu.Lock()
+	var c *codeblock
+	if n := h.AsInt(); n >= 1 && n <= int64(len(i.codeblocks)) {
+		c = i.codeblocks[n-1]
+	}
+	i.codeblockMu.Unlock()
+	if c == nil {
+		return NilValue, fmt.Errorf("%s is not a codeblock", h.AsString())
+	}
+	if len(args) != len(c.fn.Params) {
+		return NilValue, fmt.Errorf("codeblock takes %d arguments, got %d", len(c.fn.Params), len(args))
+	}
+	
+	if i.dbg != nil {
+		i.dbg.enter("codeblock")
+		defer i.dbg.leave()
+	}
+	savedVars, savedDefers, savedInFunction := i.vars, i.deferStack, i.inFunction
+	i.vars = c.vars.Clone()
+	i.vars.PushScope()
+	i.deferStack = nil
+	i.inFunction = true
+	defer func() {
+		for idx := len(i.deferStack) - 1; idx >= 0; idx-- {
+			i.deferStack[idx]()
+		}
+		i.vars, i.deferStack, i.inFunction = savedVars, savedDefers, savedInFunction
+	}()
+	for idx, param := range c.fn.Params {
+		i.vars.Set(param, NewInt(args[idx].AsInt()))
+	}
+	
+	if len(c.fn.Body) == 1 {
+		if exprStmt, ok := c.fn.Body[0].(*ast.ExprStmt); ok {
+			val, err := i.evalExpr(exprStmt.Expr)
+			return NewInt(val.AsInt()), err
+		}
+	}
+	for _, stmt := range c.fn.Body {
+		if err := i.execStmt(stmt); err != nil {
+			if errors.Is(err, errReturn) {
+				return NewInt(i.returnVal.AsInt()), nil
+			}
+			return NilValue, err
+		}
+	}
+	return NewInt(0), nil
+}
+
+// evalCall evaluates call(f, args...) and apply(f, @s), which calls f with
+// its arguments popped from @s, the last argument first
+func (i *Interpreter) evalCall(name string, argExprs []ast.Expr) (Value, error) {
+	if len(argExprs) == 0 {
+		return NilValue, fmt.Errorf("%s() requires a codeblock argument", name)
+	}
+	h, err := i.evalExpr(argExprs[0])
+	if err != nil {
+		return NilValue, err
+	}
+	var args []Value
+	if name == "call" {
+		for _, argExpr := range argExprs[1:] {
+			val, err := i.evalExpr(argExpr)
+			if err != nil {
+				return NilValue, err
+			}
+			args = append(args, val)
+		}
+	} else {
+		ref, ok := (*ast.StackRef)(nil), len(argExprs) == 2
+		if ok {
+			ref, ok = argExprs[1].(*ast.StackRef)
+		}
+		if !ok {
+			return NilValue, fmt.Errorf("apply() requires (codeblock, @stack) arguments")
+		}
+		stack, ok := i.stacks[ref.Name]
+		if !ok {
+			return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+		}
+		i.codeblockMu.Lock()
+		var params int
+		if n := h.AsInt(); n >= 1 && n <= int64(len(i.codeblocks)) {
+			params = len(i.codeblocks[n-1].fn.Params)
+		}
+		i.codeblockMu.Unlock()
+		args = make([]Value, params)
+		for idx := params - 1; idx >= 0; idx-- {
+			val, err := stack.Pop()
+			if err != nil {
+				return NilValue, fmt.Errorf("apply: codeblock takes %d arguments: %v", params, err)
+			}
+			args[idx] = val
+		}
+	}
+	val, err := i.callCodeblock(h, args)
+	if err != nil {
+		return NilValue, fmt.Errorf("%s: %v", name, err)
+	}
+	return val, nil
+}
+
+// evalViewExpr evaluates a view expression (view: op()).
+func (i *Interpreter) evalViewExpr(e *ast.ViewExpr) (Value, error) {
+	view, ok := i.views[e.View]
+	if !ok {
+		return NilValue, fmt.Errorf("undefined view: %s", e.View)
+	}
+	
+	switch e.Op {
+	case "attach":
+		// attach(@stack) - bind view to a stack
+		if len(e.Args) < 1 {
+			return NilValue, fmt.Errorf("attach requires stack argument")
+		}
+		if ref, ok := e.Args[0].(*ast.StackRef); ok {
+			stack, ok := i.stacks[ref.Name]
+			if !ok {
+				return NilValue, fmt.Errorf("undefined stack: @%s", ref.Name)
+			}
+			return NilValue, view.attach(stack)
+		}
+		return NilValue, fmt.Errorf("attach requires stack reference")
+		
+	case "pop":
+		// pop through view with its perspective
+		if view.Stack == nil {
+			return NilValue, fmt.Errorf("view %s not attached to stack", e.View)
+		}
+		// Pop based on view's perspective
+		if view.sub != nil {
+			b, err := view.sub.Pop()
+			if err != nil {
+				return NilValue, err
+			}
+			return runtime.ValueFromBytes(b), nil
+		}
+		if view.Perspective == "FIFO" {
+			// Pop from bottom (FIFO order)
+			return view.Stack.PopBottom()
+		}
+		// Default LIFO
+		return view.Stack.Pop()
+		
+	case "peek":
+		// peek through view with its perspective
+		if view.Stack == nil {
+			return NilValue, fmt.Errorf("view %s not attached to stack", e.View)
+		}
+		if view.sub != nil {
+			b, err := view.sub.Peek()
+			if err != nil {
+				return NilValue, err
+			}
+			return runtime.ValueFromBytes(b), nil
+		}
+		if view.Perspective == "FIFO" {
+			return view.Stack.PeekBottom()
+		}
+		return view.Stack.Peek()
+		
+	default:
+		return NilValue, fmt.Errorf("unknown view operation: %s", e.Op)
+	}
+}
@@ -0,0 +1,154 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// ============================================================================
+// Statement profile (iual --profile)
+//
+// Each statement's wall time is charged to its source line. A line's self
+// time leaves out the statements nested inside it, so a loop header or a
+// call only shows the time spent in the line itself and the hotspots are
+// the lines that do the work. Compute blocks run as compiled threaded code
+// and are charged to the line that starts them.
+// ============================================================================
+
+// profileTop is how many lines the report lists
+const profileTop = 20
+
+// Profile accumulates time per source line. One Profile is shared by the
+// interpreter and the children it starts for spawned tasks.
+type Profile struct {
+	pos   map[ast.Stmt]ast.Pos // statement positions, from the parser
+	file  string               // main program, for statements without a file
+	start time.Time
+
+	mu    sync.Mutex
+	lines map[ast.Pos]*lineProfile
+}
+
+type lineProfile struct {
+	count int64
+	self  time.Duration
+}
+
+// NewProfile starts a profile of the program in file
+func NewProfile(prog *ast.Program, file string) *Profile {
+	return &Profile{
+		pos:   prog.Pos,
+		file:  file,
+		start: time.Now(),
+		lines: make(map[ast.Pos]*lineProfile),
+	}
+}
+
+// SetProfile charges the time of each statement run to prof
+func (i *Interpreter) SetProfile(prof *Profile) {
+	i.prof = prof
+}
+
+// profileStmt starts timing a statement at pos and returns the function
+// that stops it. The time of the statements nested inside is subtracted
+// from its own.
+func (i *Interpreter) profileStmt(pos ast.Pos) func() {
+	i.profNested = append(i.profNested, 0)
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		depth := len(i.profNested) - 1
+		nested := i.profNested[depth]
+		i.profNested = i.profNested[:depth]
+		if depth > 0 {
+			i.profNested[depth-1] += elapsed
+		}
+		i.prof.add(pos, elapsed-nested)
+	}
+}
+
+func (p *Profile) add(pos ast.Pos, self time.Duration) {
+	if pos.File == "" {
+		pos.File = p.file
+	}
+	pos.Col = 0 // profiles are per line
+	p.mu.Lock()
+	lp := p.lines[pos]
+	if lp == nil {
+		lp = &lineProfile{}
+		p.lines[pos] = lp
+	}
+	lp.count++
+	lp.self += self
+	p.mu.Unlock()
+}
+
+// Report writes the lines with the most self time to w, busiest first
+func (p *Profile) Report(w io.Writer) {
+	total := time.Since(p.start)
+
+	p.mu.Lock()
+	type row struct {
+		pos ast.Pos
+		lineProfile
+	}
+	rows := make([]row, 0, len(p.lines))
+	for pos, lp := range p.lines {
+		rows = append(rows, row{pos, *lp})
+	}
+	p.mu.Unlock()
+
+	sort.Slice(rows, func(a, b int) bool {
+		if rows[a].self != rows[b].self {
+			return rows[a].self > rows[b].self
+		}
+		if rows[a].pos.File != rows[b].pos.File {
+			return rows[a].pos.File < rows[b].pos.File
+		}
+		return rows[a].pos.Line < rows[b].pos.Line
+	})
+
+	fmt.Fprintf(w, "\nprofile: %v wall time, %d lines run\n", total.Round(time.Microsecond), len(rows))
+	fmt.Fprintf(w, "%12s %7s %10s  %s\n", "self", "%", "count", "line")
+	sources := make(map[string][]string)
+	for n, r := range rows {
+		if n == profileTop {
+			fmt.Fprintf(w, "%12s %7s %10s  (%d more)\n", "", "", "", len(rows)-n)
+			break
+		}
+		pct := 0.0
+		if total > 0 {
+			pct = 100 * float64(r.self) / float64(total)
+		}
+		fmt.Fprintf(w, "%12v %6.1f%% %10d  %s:%d  %s\n",
+			r.self.Round(time.Microsecond), pct, r.count, r.pos.File, r.pos.Line,
+			sourceLine(sources, r.pos))
+	}
+}
+
+// sourceLine returns the trimmed text of the line at pos, reading each
+// file once
+func sourceLine(cache map[string][]string, pos ast.Pos) string {
+	lines, ok := cache[pos.File]
+	if !ok {
+		if data, err := os.ReadFile(pos.File); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		cache[pos.File] = lines
+	}
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	text := strings.TrimSpace(lines[pos.Line-1])
+	if len(text) > 60 {
+		text = text[:57] + "..."
+	}
+	return text
+}
@@ -0,0 +1,51 @@
+package interp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func TestProfile(t *testing.T) {
+	source := `var x i64 = 0
+while (x < 5) {
+    push:x inc let:x
+}
+`
+	path := filepath.Join(t.TempDir(), "loop.ual")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.NewParser(lexer.NewLexer(source).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prof := NewProfile(prog, path)
+	interp := NewInterpreter()
+	interp.SetProfile(prof)
+	if err := interp.Run(prog); err != nil {
+		t.Fatal(err)
+	}
+
+	for line, want := range map[int]int64{1: 1, 2: 1, 3: 5} {
+		lp := prof.lines[ast.Pos{File: path, Line: line}]
+		if lp == nil || lp.count != want {
+			t.Errorf("line %d: got %+v, want count %d", line, lp, want)
+		}
+	}
+	if len(interp.profNested) != 0 {
+		t.Errorf("timers left running: %v", interp.profNested)
+	}
+
+	var out strings.Builder
+	prof.Report(&out)
+	if !strings.Contains(out.String(), "loop.ual:3  push:x inc let:x") {
+		t.Errorf("report does not show line 3:\n%s", out.String())
+	}
+}
@@ -0,0 +1,216 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/check"
+	"github.com/ha1tch/ual/pkg/vm"
+)
+
+// ============================================================================
+// Bytecode
+//
+// Function bodies, and the while and range loops at the top level of a
+// program, run as bytecode on a vm.Machine (see pkg/vm), compiled the
+// first time they run. The interpreter is the machine's host: it holds the
+// globals, functions and stacks, and runs the statements the compiler
+// leaves in the tree. A function taking stacks runs with them bound to
+// its parameters' names, as when walked. Generic functions, code run under
+// --walk, --trace, --profile or the debugger, and spawned tasks walk the
+// tree.
+// ============================================================================
+
+// SetBytecode enables or disables running compiled code; without it,
+// every statement is walked.
+func (i *Interpreter) SetBytecode(on bool) {
+	i.machine = nil
+	if on {
+		i.machine = vm.New(vmHost{i})
+	}
+}
+
+// bytecode returns the compiled code of node, a function or a top-level
+// statement, compiling it the first time, or nil if it is to be walked
+func (i *Interpreter) bytecode(node ast.Node) *vm.Proto {
+	if i.machine == nil || i.dbg != nil || i.prof != nil || i.trace || i.inComputeBlock {
+		return nil
+	}
+	p, ok := i.protos[node]
+	if !ok {
+		var err error
+		if fn, isFunc := node.(*ast.FuncDecl); isFunc {
+			p, err = vm.Compile(fn, i.callable)
+		} else {
+			p, err = vm.CompileStmt(node.(ast.Stmt), i.callable)
+		}
+		if err != nil {
+			p = nil
+		}
+		i.protos[node] = p
+	}
+	return p
+}
+
+// callable reports whether a call of name with argc values can be made
+// from bytecode, as a call of a function taking no stacks
+func (i *Interpreter) callable(name string, argc int) bool {
+	fn, ok := i.funcs[name]
+	if !ok || check.BuiltinFuncs[name] || len(fn.Params) != argc {
+		return false
+	}
+	for _, param := range fn.Params {
+		if param.Stack {
+			return false
+		}
+	}
+	return true
+}
+
+// execTop executes a statement at the top level of the program, as
+// bytecode if it is a loop
+func (i *Interpreter) execTop(stmt ast.Stmt) error {
+	switch stmt.(type) {
+	case *ast.WhileStmt, *ast.RangeStmt:
+		if p := i.bytecode(stmt); p != nil {
+			return i.fromVM(i.machine.Run(p))
+		}
+	}
+	return i.execStmt(stmt)
+}
+
+// fromVM turns the errors a Machine returns for a break, continue or
+// return leaving its code into the walker's
+func (i *Interpreter) fromVM(v Value, err error) error {
+	switch err {
+	case vm.ErrBreak:
+		return errBreak
+	case vm.ErrContinue:
+		return errContinue
+	case vm.ErrReturn:
+		i.returnVal = v
+		return errReturn
+	}
+	return err
+}
+
+// vmHost is the Interpreter as the host of its Machine
+type vmHost struct {
+	i *Interpreter
+}
+
+func (h vmHost) Global(name string, globalOnly bool) (Value, error) {
+	vars := h.i.vars
+	if globalOnly {
+		vars = h.i.globals
+	}
+	if val, ok := vars.Get(name); ok {
+		return val, nil
+	}
+	return NilValue, fmt.Errorf("undefined variable: %s", name)
+}
+
+func (h vmHost) SetGlobal(name string, v Value, report bool) {
+	if report && !h.i.inFunction {
+		h.i.trackTopLevel(name)
+	}
+	if !h.i.updateVar(name, v) {
+		h.i.vars.Set(name, v)
+	}
+}
+
+func (h vmHost) Func(name string, argc int) *vm.Proto {
+	if !h.i.callable(name, argc) {
+		return nil
+	}
+	if p := h.i.bytecode(h.i.funcs[name]); p != nil && p.Pure {
+		return p
+	}
+	return nil
+}
+
+func (h vmHost) Call(name string, args []Value) (Value, error) {
+	fn, ok := h.i.funcs[name]
+	if !ok {
+		return NilValue, fmt.Errorf("undefined function: %s", name)
+	}
+	if len(args) != len(fn.Params) {
+		return NilValue, fmt.Errorf("function %s expects %d arguments, got %d", fn.Name, len(fn.Params), len(args))
+	}
+	for idx, param := range fn.Params {
+		if param.Stack {
+			return NilValue, fmt.Errorf("%s: argument %d must be a stack, as in %s(@name)", fn.Name, idx+1, fn.Name)
+		}
+	}
+	return h.i.invoke(fn, args)
+}
+
+func (h vmHost) Push(name string, v Value) error {
+	stack, ok := h.i.stacks[name]
+	if !ok {
+		return fmt.Errorf("undefined stack: @%s", name)
+	}
+	if h.i.stackTypes[name] == "struct" {
+		return fmt.Errorf("@%s holds structs; push a record such as {x: 1.0, y: 2.0}", name)
+	}
+	return h.i.pushValue(stack, name, v)
+}
+
+func (h vmHost) Pop(name string) (Value, error) {
+	stack, ok := h.i.stacks[name]
+	if !ok {
+		return NilValue, fmt.Errorf("undefined stack: @%s", name)
+	}
+	return stack.Pop()
+}
+
+func (h vmHost) PopTo(f *vm.Fallback, cur Value) (Value, error) {
+	s := f.Stmt.(*ast.StackOp)
+	stack, ok := h.i.stacks[s.Stack]
+	if !ok {
+		return NilValue, fmt.Errorf("undefined stack: @%s", s.Stack)
+	}
+	return h.i.popTo(s, stack, cur)
+}
+
+func (h vmHost) Exec(f *vm.Fallback, locals []Value) (vm.Flow, Value, error) {
+	i := h.i
+	if len(f.Locals) > 0 {
+		i.vars.PushScope()
+		for _, l := range f.Locals {
+			i.vars.Set(l.Name, locals[l.Slot])
+		}
+	}
+	err := i.execStmt(f.Stmt)
+	if len(f.Locals) > 0 {
+		for _, l := range f.Locals {
+			locals[l.Slot], _ = i.vars.Get(l.Name)
+		}
+		i.vars.PopScope()
+	}
+	switch {
+	case err == nil:
+		return vm.FlowNext, NilValue, nil
+	case errors.Is(err, errBreak):
+		return vm.FlowBreak, NilValue, nil
+	case errors.Is(err, errContinue):
+		return vm.FlowContinue, NilValue, nil
+	case errors.Is(err, errReturn):
+		return vm.FlowReturn, i.returnVal, nil
+	}
+	return vm.FlowNext, NilValue, err
+}
+
+func (h vmHost) Eval(f *vm.Fallback, locals []Value) (Value, error) {
+	i := h.i
+	if len(f.Locals) == 0 {
+		return i.evalExpr(f.Expr)
+	}
+	i.vars.PushScope()
+	defer i.vars.PopScope()
+	for _, l := range f.Locals {
+		i.vars.Set(l.Name, locals[l.Slot])
+	}
+	return i.evalExpr(f.Expr)
+}
@@ -0,0 +1,239 @@
+package interp
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+const vmSource = `
+@out = stack.new(i64)
+@words = stack.new(string)
+var scale i64 = 3
+
+func fib(n i64) i64 {
+    if (n < 2) {
+        return n
+    }
+    return fib(n - 1) + fib(n - 2)
+}
+
+func scaled(n i64) i64 {
+    return n * scale
+}
+
+func collatz(n i64) i64 {
+    var steps i64 = 0
+    while (n != 1) {
+        if (n % 2 == 0) {
+            n = n / 2
+        } else {
+            n = 3 * n + 1
+        }
+        steps = steps + 1
+    }
+    return steps
+}
+
+func label(n i64) string {
+    @words push("n${n}")
+    var s string = "x" + n
+    return s
+}
+
+func firstOver(limit i64) i64 {
+    for k in 0..100 {
+        if (k * k > limit) {
+            return k
+        }
+    }
+    return -1
+}
+
+var total i64 = 0
+var i i64 = 0
+while (i < 20) {
+    i = i + 1
+    if (i % 3 == 0) {
+        continue
+    }
+    if (i > 15) {
+        break
+    }
+    total = total + scaled(i)
+}
+@out push(total)
+for k in 1..6 {
+    @out push(fib(k + 10))
+    @out push(collatz(k * 7))
+}
+@out push(firstOver(50))
+@words push(label(4))
+var f f64 = 1
+while (f < 1000.0) {
+    f = f * 1.5
+}
+if (f > 1000.0) {
+    @out push(1)
+}
+var last i64 = 0
+for k in 0..5 {
+    push:(k * 3)
+    let:last
+}
+@out push(last)
+`
+
+func runVMSource(tb testing.TB, prog *ast.Program, bytecode bool) string {
+	tb.Helper()
+	interp := NewInterpreter()
+	interp.SetBytecode(bytecode)
+	if err := interp.Run(prog); err != nil {
+		tb.Fatalf("bytecode %v: %v", bytecode, err)
+	}
+	var out strings.Builder
+	interp.WriteStacks(&out, "out")
+	interp.WriteStacks(&out, "words")
+	return out.String()
+}
+
+func TestBytecodeMatchesWalker(t *testing.T) {
+	prog, err := parser.NewParser(lexer.NewLexer(vmSource).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	walked := runVMSource(t, prog, false)
+	compiled := runVMSource(t, prog, true)
+	if compiled != walked {
+		t.Errorf("bytecode:\n%s\nwalker:\n%s", compiled, walked)
+	}
+	if !strings.Contains(walked, "@out (LIFO i64): [225 89 16 ") || !strings.Contains(walked, " 1 12]") {
+		t.Errorf("unexpected results:\n%s", walked)
+	}
+}
+
+// A function taking stacks runs as bytecode, with the caller's stacks
+// bound to its parameters
+func TestBytecodeStackParams(t *testing.T) {
+	src := `@nums = stack.new(i64)
+@out = stack.new(i64)
+@nums push:1 push:2 push:3
+
+func drain(@src stack(i64), scale i64) i64 {
+    var total i64 = 0
+    var x i64 = 0
+    while (@src: len() > 0) {
+        @src pop:x
+        total = total + x * scale
+    }
+    return total
+}
+
+func copy_into(@from stack(i64), @to stack(i64)) {
+    @from for {|v|
+        @to push(v + 1)
+    }
+}
+
+copy_into(@nums, @out)
+@out push(drain(@nums, 3))
+@out push(drain(@out, 1))
+`
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bytecode := range []bool{false, true} {
+		interp := NewInterpreter()
+		interp.SetBytecode(bytecode)
+		if err := interp.Run(prog); err != nil {
+			t.Fatalf("bytecode %v: %v", bytecode, err)
+		}
+		var out strings.Builder
+		interp.WriteStacks(&out, "out")
+		if want := "@out (LIFO i64): [27]"; !strings.Contains(out.String(), want) {
+			t.Errorf("bytecode %v: got %q, want %q", bytecode, out.String(), want)
+		}
+		if bytecode && interp.protos[interp.funcs["drain"]] == nil {
+			t.Error("drain was not compiled")
+		}
+	}
+}
+
+func TestBytecodeErrors(t *testing.T) {
+	for _, src := range []string{
+		"func f(n i64) i64 {\n    return n / 0\n}\nf(1)",
+		"var k i64 = 0\nwhile (k < 3) {\n    k = k + missing\n}",
+		"func g(n i64) i64 {\n    return n\n}\nvar k i64 = 0\nwhile (k < 1) {\n    k = g(1, 2)\n}",
+		"@t = stack.new(string)\nfunc f(n i64) i64 {\n    var x i64 = 0\n    @t pop:x\n    return x\n}\nf(1)",
+	} {
+		prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msgs [2]string
+		for idx, bytecode := range []bool{false, true} {
+			interp := NewInterpreter()
+			interp.SetBytecode(bytecode)
+			if err := interp.Run(prog); err != nil {
+				msgs[idx] = err.Error()
+			}
+		}
+		if msgs[0] == "" || msgs[0] != msgs[1] {
+			t.Errorf("%q: walker error %q, bytecode error %q", src, msgs[0], msgs[1])
+		}
+	}
+}
+
+func benchmarkFib(b *testing.B, bytecode bool) {
+	prog, err := parser.NewParser(lexer.NewLexer(
+		"func fib(n i64) i64 {\n    if (n < 2) {\n        return n\n    }\n    return fib(n - 1) + fib(n - 2)\n}\n@dstack push(fib(20))",
+	).Tokenize()).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for n := 0; n < b.N; n++ {
+		interp := NewInterpreter()
+		interp.SetBytecode(bytecode)
+		if err := interp.Run(prog); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFib_Bytecode(b *testing.B) { benchmarkFib(b, true) }
+func BenchmarkFib_TreeWalk(b *testing.B) { benchmarkFib(b, false) }
+
+// loopSources are the loops BenchmarkLoop_* run: a while loop at the top
+// level, the same loop in a function, and a range loop pushing to a stack
+var loopSources = map[string]string{
+	"top":   "var i i64 = 0\nvar sum i64 = 0\nwhile (i < 10000) {\n    sum = sum + i\n    i = i + 1\n}",
+	"func":  "func count(n i64) i64 {\n    var i i64 = 0\n    var sum i64 = 0\n    while (i < n) {\n        sum = sum + i\n        i = i + 1\n    }\n    return sum\n}\n@dstack push(count(10000))",
+	"range": "@s = stack.new(i64)\nvar last i64 = 0\nfor k in 0..10000 {\n    @s push(k)\n    push:k\n    let:last\n}",
+}
+
+func benchmarkLoop(b *testing.B, loop string, bytecode bool) {
+	prog, err := parser.NewParser(lexer.NewLexer(loopSources[loop]).Tokenize()).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for n := 0; n < b.N; n++ {
+		interp := NewInterpreter()
+		interp.SetBytecode(bytecode)
+		interp.SetOutput(io.Discard, nil)
+		if err := interp.Run(prog); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoop_Top_Bytecode(b *testing.B)   { benchmarkLoop(b, "top", true) }
+func BenchmarkLoop_Top_TreeWalk(b *testing.B)   { benchmarkLoop(b, "top", false) }
+func BenchmarkLoop_Func_Bytecode(b *testing.B)  { benchmarkLoop(b, "func", true) }
+func BenchmarkLoop_Func_TreeWalk(b *testing.B)  { benchmarkLoop(b, "func", false) }
+func BenchmarkLoop_Range_Bytecode(b *testing.B) { benchmarkLoop(b, "range", true) }
+func BenchmarkLoop_Range_TreeWalk(b *testing.B) { benchmarkLoop(b, "range", false) }
@@ -0,0 +1,15 @@
+// Package ir lowers the constructs of a ual program whose meaning is more
+// than their syntax into the forms the Go and Rust backends emit.
+//
+// A consider, select or compute block decides a good deal before any code
+// is written: which case a status selects and what it binds, which stack a
+// case waits on and how a timeout handler ends, what a compute kernel pops
+// before it runs. Each backend used to decide these for itself, and the
+// two drifted apart. Lowering decides them once, here, so that a backend is
+// left with a small emitter per form and a new construct needs one
+// lowering and two emitters.
+//
+// The forms keep the statements of their bodies as ast.Stmt: those are
+// emitted by the backends' statement generators as before. iual, which
+// runs the AST, is the reference the forms are documented against.
+package ir
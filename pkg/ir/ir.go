@@ -0,0 +1,160 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+// Consider is a consider block. Its status starts as "ok" and Body runs;
+// a status still "ok" with @error not empty becomes "error", with the top
+// of @error as its value. The first case with the status as its label
+// runs, or Default if none has, or nothing if there is no default. The
+// status the block found on entry is restored when it ends.
+type Consider struct {
+	Body    *ast.StackBlock // nil for a bare consider
+	Cases   []ConsiderCase  // the labelled cases, one per label
+	Default *ConsiderCase   // the _ case, or nil
+}
+
+// ConsiderCase is a case of a consider block.
+type ConsiderCase struct {
+	Label string
+	Bind  string // the name the status value is bound to, or ""
+	Body  []ast.Stmt
+}
+
+// LowerConsider lowers a consider block. A later case with the label of
+// an earlier one could never run and is dropped.
+func LowerConsider(c *ast.ConsiderStmt) (*Consider, error) {
+	lc := &Consider{Body: c.Block}
+	seen := make(map[string]bool)
+	for _, cas := range c.Cases {
+		if len(cas.Bindings) > 1 {
+			return nil, fmt.Errorf("consider case %s: a status has one value, so a case binds one name", cas.Label)
+		}
+		if seen[cas.Label] {
+			continue
+		}
+		seen[cas.Label] = true
+		lcas := ConsiderCase{Label: cas.Label, Body: cas.Handler}
+		if len(cas.Bindings) == 1 {
+			lcas.Bind = cas.Bindings[0]
+		}
+		if cas.Label == "_" {
+			lc.Default = &lcas
+		} else {
+			lc.Cases = append(lc.Cases, lcas)
+		}
+	}
+	return lc, nil
+}
+
+// Select is a select block. Setup runs first. A select with a default
+// takes from a ready case's source if there is one and runs the default
+// if not; one without waits for a source to be ready. When several are,
+// one is picked at random, or the first if Fair is false.
+type Select struct {
+	Setup   *ast.StackBlock // nil if the select has none
+	Cases   []SelectCase    // every case but the default, numbered from 0
+	Default []ast.Stmt      // the default case's handler
+	Waits   bool            // there is no default case
+	Fair    bool
+}
+
+// SelectCase is a case of a select block, with the source it takes from.
+type SelectCase struct {
+	Kind    ast.SelectKind
+	Stack   string   // the stack of a stack case, the select's own if it names none
+	Every   ast.Expr // the interval in ms of a timer case
+	Signal  string   // the signal of a signal case
+	Bind    string   // the name the value taken is bound to, or ""
+	Body    []ast.Stmt
+	Timeout *Timeout // nil if the case has no timeout
+}
+
+// Timeout is the timeout of a select case. If the case's source has given
+// nothing Ms milliseconds after the select started waiting, Body runs and
+// the select ends, or, if Retry, starts waiting again.
+type Timeout struct {
+	Ms    ast.Expr
+	Body  []ast.Stmt // the handler up to any retry() or restart()
+	Retry bool       // the handler ends with retry() or restart()
+}
+
+// LowerSelect lowers a select block.
+func LowerSelect(s *ast.SelectStmt) (*Select, error) {
+	ls := &Select{Setup: s.Block, Waits: true, Fair: !s.Ordered}
+	for _, cas := range s.Cases {
+		if cas.Stack == "_" {
+			if !ls.Waits {
+				return nil, fmt.Errorf("select has more than one default case")
+			}
+			ls.Default, ls.Waits = cas.Handler, false
+			continue
+		}
+		if len(cas.Bindings) > 1 {
+			return nil, fmt.Errorf("select case %s: a case takes one value, so binds one name", caseName(cas))
+		}
+		lcas := SelectCase{Kind: cas.Kind, Stack: cas.Stack, Every: cas.Every, Signal: cas.Signal, Body: cas.Handler}
+		if cas.Kind == ast.SelectStack && lcas.Stack == "" {
+			lcas.Stack = s.DefaultStack
+		}
+		if len(cas.Bindings) == 1 {
+			lcas.Bind = cas.Bindings[0]
+		}
+		lcas.Timeout = LowerTimeout(cas)
+		ls.Cases = append(ls.Cases, lcas)
+	}
+	return ls, nil
+}
+
+// LowerTimeout lowers the timeout of a select case, or returns nil if it
+// has none.
+func LowerTimeout(cas ast.SelectCase) *Timeout {
+	if cas.TimeoutMs == nil {
+		return nil
+	}
+	t := &Timeout{Ms: cas.TimeoutMs}
+	if cas.TimeoutFn != nil {
+		t.Body, t.Retry = untilRetry(cas.TimeoutFn.Body)
+	}
+	return t
+}
+
+// untilRetry returns stmts up to a retry() or restart() call, and whether
+// there was one. Nothing after the call could run.
+func untilRetry(stmts []ast.Stmt) ([]ast.Stmt, bool) {
+	for i, stmt := range stmts {
+		if fc, ok := stmt.(*ast.FuncCall); ok && (fc.Name == "retry" || fc.Name == "restart") {
+			return stmts[:i], true
+		}
+	}
+	return stmts, false
+}
+
+// caseName describes a select case in errors
+func caseName(cas ast.SelectCase) string {
+	switch cas.Kind {
+	case ast.SelectTimer:
+		return "every"
+	case ast.SelectSignal:
+		return "@signal " + cas.Signal
+	}
+	return "@" + cas.Stack
+}
+
+// Compute is a compute block. Setup runs first, outside the kernel. The
+// kernel then holds the stack's lock while it pops Pops, the first from
+// the top, and runs Body, whose return pushes its value.
+type Compute struct {
+	Stack string
+	Setup *ast.StackBlock // nil if the block has none
+	Pops  []string
+	Body  []ast.Stmt
+}
+
+// LowerCompute lowers a compute block.
+func LowerCompute(c *ast.ComputeStmt) *Compute {
+	return &Compute{Stack: c.StackName, Setup: c.Setup, Pops: c.Params, Body: c.Body}
+}
@@ -0,0 +1,107 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func parse(t *testing.T, src string) ast.Stmt {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog.Stmts[len(prog.Stmts)-1]
+}
+
+func TestLowerConsider(t *testing.T) {
+	c, err := LowerConsider(parse(t, `@s = stack.new(i64)
+@s {
+    push:1
+}.consider(
+    ok: {
+        push:2
+    }
+    error |e|: {
+        push:3
+    }
+    ok: {
+        push:4
+    }
+    _: {
+        push:5
+    }
+)`).(*ast.ConsiderStmt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Body == nil || len(c.Body.Ops) != 1 {
+		t.Errorf("body = %v", c.Body)
+	}
+	if len(c.Cases) != 2 || c.Cases[0].Label != "ok" || c.Cases[1].Label != "error" {
+		t.Fatalf("cases = %+v", c.Cases)
+	}
+	if c.Cases[1].Bind != "e" || c.Cases[0].Bind != "" {
+		t.Errorf("bindings %q, %q", c.Cases[0].Bind, c.Cases[1].Bind)
+	}
+	if c.Default == nil || len(c.Default.Body) != 1 {
+		t.Errorf("default = %+v", c.Default)
+	}
+
+	_, err = LowerConsider(&ast.ConsiderStmt{Cases: []ast.ConsiderCase{{Label: "ok", Bindings: []string{"a", "b"}}}})
+	if err == nil {
+		t.Error("two bindings lowered")
+	}
+}
+
+func TestLowerSelect(t *testing.T) {
+	s, err := LowerSelect(parse(t, `@inbox = stack.new(i64)
+@other = stack.new(i64)
+@inbox {
+}.select(
+    @other {|v|
+        push:v
+        timeout(20, {||
+            push:7
+            retry()
+            push:8
+        })
+    }
+    _: {
+        push:0
+    }
+)`).(*ast.SelectStmt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Waits || len(s.Default) != 1 || !s.Fair {
+		t.Errorf("waits %v, default %v, fair %v", s.Waits, s.Default, s.Fair)
+	}
+	if len(s.Cases) != 1 {
+		t.Fatalf("cases = %+v", s.Cases)
+	}
+	cas := s.Cases[0]
+	if cas.Stack != "other" || cas.Bind != "v" || cas.Timeout == nil {
+		t.Fatalf("case = %+v", cas)
+	}
+	if !cas.Timeout.Retry || len(cas.Timeout.Body) != 1 {
+		t.Errorf("timeout = %+v", cas.Timeout)
+	}
+
+	// A case naming no stack waits on the select's own
+	s, err = LowerSelect(&ast.SelectStmt{DefaultStack: "inbox", Cases: []ast.SelectCase{{}}, Ordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Waits || s.Fair || s.Cases[0].Stack != "inbox" || s.Cases[0].Timeout != nil {
+		t.Errorf("select = %+v", s)
+	}
+
+	_, err = LowerSelect(&ast.SelectStmt{Cases: []ast.SelectCase{{Stack: "_"}, {Stack: "_"}}})
+	if err == nil {
+		t.Error("two defaults lowered")
+	}
+}
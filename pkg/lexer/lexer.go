@@ -17,6 +17,9 @@ const (
 	TokInt
 	TokFloat
 	TokString
+	TokStringHead // "text ${  - an interpolated string up to its first ${
+	TokStringMid  // } text ${ - text between two interpolations
+	TokStringTail // } text"  - text after the last interpolation
 	
 	// Keywords
 	TokStack
@@ -135,6 +138,7 @@ const (
 	TokFIFO
 	TokIndexed
 	TokHash
+	TokBroadcast
 	
 	// Types
 	TokI8
@@ -161,6 +165,7 @@ const (
 	TokColon
 	TokComma
 	TokDot
+	TokDotDot
 	TokEquals
 	TokPlus
 	TokMinus
@@ -168,6 +173,7 @@ const (
 	TokSlash
 	TokPercent
 	TokPipe
+	TokSemicolon
 	
 	// Special
 	TokNewline
@@ -182,6 +188,9 @@ var TokenNames = map[TokenType]string{
 	TokInt:         "INT",
 	TokFloat:       "FLOAT",
 	TokString:      "STRING",
+	TokStringHead:  "STRING_HEAD",
+	TokStringMid:   "STRING_MID",
+	TokStringTail:  "STRING_TAIL",
 	TokStack:       "stack",
 	TokView:        "view",
 	TokNew:         "new",
@@ -207,6 +216,7 @@ var TokenNames = map[TokenType]string{
 	TokFIFO:        "FIFO",
 	TokIndexed:     "Indexed",
 	TokHash:        "Hash",
+	TokBroadcast:   "Broadcast",
 	TokI64:         "i64",
 	TokF64:         "f64",
 	TokBool:        "bool",
@@ -221,6 +231,7 @@ var TokenNames = map[TokenType]string{
 	TokColon:       ":",
 	TokComma:       ",",
 	TokDot:         ".",
+	TokDotDot:      "..",
 	TokEquals:      "=",
 	TokPlus:        "+",
 	TokMinus:       "-",
@@ -228,6 +239,7 @@ var TokenNames = map[TokenType]string{
 	TokSlash:       "/",
 	TokPercent:     "%",
 	TokPipe:        "|",
+	TokSemicolon:   ";",
 	TokSelect:      "select",
 	TokTimeout:     "timeout",
 	TokRetry:       "retry",
@@ -349,6 +361,7 @@ var Keywords = map[string]TokenType{
 	"FIFO":        TokFIFO,
 	"Indexed":     TokIndexed,
 	"Hash":        TokHash,
+	"Broadcast":   TokBroadcast,
 	// Types
 	"i8":          TokI8,
 	"i16":         TokI16,
@@ -390,6 +403,9 @@ type Lexer struct {
 	pos    int
 	line   int
 	column int
+	
+	// Brace depth inside each open ${ ... } of an interpolated string
+	interp []int
 }
 
 // NewLexer creates a new Lexer for the given input.
@@ -471,7 +487,14 @@ func (l *Lexer) readString() Token {
 	startLine := l.line
 	startCol := l.column
 	l.advance() // consume opening quote
-	
+	return l.readStringText(true, startLine, startCol)
+}
+
+// readStringText reads string text up to the closing quote or the next
+// ${. An interpolated string "a ${x} b" is lexed as TokStringHead "a ",
+// the tokens of x, and TokStringTail " b"; NextToken resumes the text
+// at the } that closes each ${. first is set at the opening quote.
+func (l *Lexer) readStringText(first bool, startLine, startCol int) Token {
 	var sb strings.Builder
 	for {
 		ch := l.peek()
@@ -482,6 +505,15 @@ func (l *Lexer) readString() Token {
 			l.advance()
 			break
 		}
+		if ch == '$' && l.peekAhead(1) == '{' {
+			l.advance() // consume $
+			l.advance() // consume {
+			l.interp = append(l.interp, 0)
+			if first {
+				return Token{TokStringHead, sb.String(), startLine, startCol}
+			}
+			return Token{TokStringMid, sb.String(), startLine, startCol}
+		}
 		if ch == '\\' {
 			l.advance()
 			escaped := l.advance()
@@ -523,7 +555,10 @@ func (l *Lexer) readString() Token {
 			sb.WriteByte(l.advance())
 		}
 	}
-	return Token{TokString, sb.String(), startLine, startCol}
+	if first {
+		return Token{TokString, sb.String(), startLine, startCol}
+	}
+	return Token{TokStringTail, sb.String(), startLine, startCol}
 }
 
 func (l *Lexer) readNumber() Token {
@@ -536,7 +571,7 @@ func (l *Lexer) readNumber() Token {
 		ch := l.peek()
 		if unicode.IsDigit(rune(ch)) {
 			sb.WriteByte(l.advance())
-		} else if ch == '.' && !isFloat {
+		} else if ch == '.' && !isFloat && l.peekAhead(1) != '.' {
 			isFloat = true
 			sb.WriteByte(l.advance())
 		} else {
@@ -612,6 +647,20 @@ func (l *Lexer) NextToken() Token {
 		return l.readString()
 	}
 	
+	// Inside ${ ... }, the } matching the ${ resumes the string
+	if n := len(l.interp); n > 0 {
+		switch {
+		case ch == '{':
+			l.interp[n-1]++
+		case ch == '}' && l.interp[n-1] > 0:
+			l.interp[n-1]--
+		case ch == '}':
+			l.advance()
+			l.interp = l.interp[:n-1]
+			return l.readStringText(false, startLine, startCol)
+		}
+	}
+	
 	// Number
 	if unicode.IsDigit(rune(ch)) {
 		return l.readNumber()
@@ -647,6 +696,12 @@ func (l *Lexer) NextToken() Token {
 	case ',':
 		return Token{TokComma, ",", startLine, startCol}
 	case '.':
+		// Check for ..
+		if l.pos < len(l.input) && l.input[l.pos] == '.' {
+			l.pos++
+			l.column++
+			return Token{TokDotDot, "..", startLine, startCol}
+		}
 		return Token{TokDot, ".", startLine, startCol}
 	case '=':
 		// Check for ==
@@ -691,6 +746,8 @@ func (l *Lexer) NextToken() Token {
 		return Token{TokSlash, "/", startLine, startCol}
 	case '%':
 		return Token{TokPercent, "%", startLine, startCol}
+	case ';':
+		return Token{TokSemicolon, ";", startLine, startCol}
 	case '|':
 		// Check for ||
 		if l.pos < len(l.input) && l.input[l.pos] == '|' {
@@ -712,6 +769,12 @@ func (l *Lexer) NextToken() Token {
 	return Token{TokError, string(ch), startLine, startCol}
 }
 
+// Offset returns the byte offset in the input of the next character to
+// be read: after NextToken, the end of the token it returned.
+func (l *Lexer) Offset() int {
+	return l.pos
+}
+
 // Tokenize returns all tokens from the input.
 func (l *Lexer) Tokenize() []Token {
 	var tokens []Token
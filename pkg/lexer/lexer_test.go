@@ -136,6 +136,23 @@ func TestTokenizeNegativeFloat(t *testing.T) {
 	}
 }
 
+func TestTokenizeRange(t *testing.T) {
+	// The .. of a range ends an integer rather than starting a fraction
+	tokens := NewLexer("0..10").Tokenize()
+	want := []TokenType{TokInt, TokDotDot, TokInt}
+	if len(tokens) < len(want) {
+		t.Fatalf("expected at least %d tokens, got %d", len(want), len(tokens))
+	}
+	for i, typ := range want {
+		if tokens[i].Type != typ {
+			t.Errorf("token %d: expected %v, got %v", i, typ, tokens[i].Type)
+		}
+	}
+	if tokens[0].Value != "0" || tokens[2].Value != "10" {
+		t.Errorf("expected 0 and 10, got %q and %q", tokens[0].Value, tokens[2].Value)
+	}
+}
+
 func TestTokenizeString(t *testing.T) {
 	tests := []struct {
 		input string
@@ -162,6 +179,47 @@ func TestTokenizeString(t *testing.T) {
 	}
 }
 
+func TestTokenizeInterpString(t *testing.T) {
+	// Braces inside ${} nest; \$ is a literal dollar
+	tokens := NewLexer(`"a ${m[{k}]} b ${x} \${y}"`).Tokenize()
+	want := []struct {
+		typ   TokenType
+		value string
+	}{
+		{TokStringHead, "a "},
+		{TokIdent, "m"},
+		{TokLBracket, "["},
+		{TokLBrace, "{"},
+		{TokIdent, "k"},
+		{TokRBrace, "}"},
+		{TokRBracket, "]"},
+		{TokStringMid, " b "},
+		{TokIdent, "x"},
+		{TokStringTail, " ${y}"},
+	}
+	if len(tokens) < len(want) {
+		t.Fatalf("expected at least %d tokens, got %d", len(want), len(tokens))
+	}
+	for i, w := range want {
+		if tokens[i].Type != w.typ || tokens[i].Value != w.value {
+			t.Errorf("token %d: expected %v %q, got %v %q", i, w.typ, w.value, tokens[i].Type, tokens[i].Value)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	// Offset after each token is where its source text ends
+	src := `@q push:"a\"b" -- note` + "\n"
+	lex := NewLexer(src)
+	for _, want := range []string{"@q", " push", ":", `"a\"b"`, " -- note\n", ""} {
+		start := lex.Offset()
+		lex.NextToken()
+		if got := src[start:lex.Offset()]; got != want {
+			t.Errorf("expected token text %q, got %q", want, got)
+		}
+	}
+}
+
 func TestTokenizeStackRef(t *testing.T) {
 	l := NewLexer("@mystack")
 	tokens := l.Tokenize()
@@ -233,8 +291,10 @@ func TestTokenizeOperators(t *testing.T) {
 		{"]", TokRBracket},
 		{",", TokComma},
 		{".", TokDot},
+		{"..", TokDotDot},
 		{":", TokColon},
 		{"|", TokPipe},
+		{";", TokSemicolon},
 	}
 
 	for _, tc := range tests {
@@ -349,6 +409,7 @@ func TestTokenizePerspectives(t *testing.T) {
 		{"FIFO", TokFIFO},
 		{"Indexed", TokIndexed},
 		{"Hash", TokHash},
+		{"Broadcast", TokBroadcast},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,394 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RepoURL returns the git repository a module path is cloned from.
+// Tests and mirrors may replace it.
+var RepoURL = func(path string) string {
+	return "https://" + path + ".git"
+}
+
+// Get fetches path@query into the cache and records it in root's ual.lock,
+// along with the modules it needs. The query is a tag (v1.2.0), a version
+// prefix (v1, v1.2) meaning the highest matching release, empty or
+// "latest" for the highest release, or a branch or commit hash, which is
+// pinned to the commit. If root has a ual.toml, the query is recorded in
+// its [dependencies] too, or the version chosen if there was no query. It
+// returns the modules that were added or changed.
+func Get(root, spec string) ([]Module, error) {
+	path, query, _ := strings.Cut(spec, "@")
+	if err := checkPath(path); err != nil {
+		return nil, err
+	}
+	lock, err := ReadLock(root)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &getter{lock: lock, seen: make(map[string]bool)}
+	if err := g.get(path, query, "", true); err != nil {
+		return nil, err
+	}
+	if err := lock.Write(root); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(root, ManifestName)); err == nil {
+		if query == "" || query == "latest" {
+			query = lock.Find(path).Version
+		}
+		if err := WriteDependency(root, path, query); err != nil {
+			return nil, err
+		}
+	}
+	return g.changed, nil
+}
+
+// Download makes sure every module in root's ual.lock is in the cache and
+// matches its checksum, fetching the ones that are missing.
+func Download(root string) error {
+	lock, err := ReadLock(root)
+	if err != nil {
+		return err
+	}
+	for _, m := range lock.Modules {
+		sum, err := download(m.Path, m.Version)
+		if err != nil {
+			return err
+		}
+		if sum != m.Sum {
+			return fmt.Errorf("%s@%s: checksum mismatch\n\tual.lock:   %s\n\tdownloaded: %s", m.Path, m.Version, m.Sum, sum)
+		}
+	}
+	return nil
+}
+
+// GetManifest fetches the git modules of m's [dependencies] into the
+// cache and records them in the ual.lock beside it, as Get does. A module
+// already locked at a version its query allows is left as it is. It
+// returns the modules that were added or changed.
+func GetManifest(m *Manifest) ([]Module, error) {
+	lock, err := ReadLock(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var changed []Module
+	for _, dep := range m.Dependencies {
+		if dep.Dir != "" {
+			continue
+		}
+		if locked := lock.Find(dep.Path); locked != nil && versionMatches(locked.Version, dep.Version) {
+			continue
+		}
+		mods, err := Get(m.Dir, dep.Path+"@"+dep.Version)
+		if err != nil {
+			return nil, err
+		}
+		changed = append(changed, mods...)
+	}
+	return changed, nil
+}
+
+// versionMatches reports whether the tag version is one query, as Get
+// takes it, could have chosen. A branch is pinned by the lock, so any
+// version matches one until Get is asked for it again.
+func versionMatches(version, query string) bool {
+	if query == "" || query == "latest" || !strings.HasPrefix(query, "v") {
+		return true
+	}
+	return version == query || strings.HasPrefix(query, "v") && strings.HasPrefix(version, query+".")
+}
+
+// getter walks a module and its dependencies, updating the lock
+type getter struct {
+	lock    *Lock
+	seen    map[string]bool // path@version already handled
+	changed []Module
+}
+
+// get resolves and fetches one module. An explicit request (from the
+// command line) always wins; a dependency only replaces a lower locked
+// version. wantSum, if set, is the checksum the requiring library locked.
+func (g *getter) get(path, query, wantSum string, explicit bool) error {
+	version, err := resolveVersion(path, query)
+	if err != nil {
+		return err
+	}
+	key := path + "@" + version
+	if g.seen[key] {
+		return nil
+	}
+	g.seen[key] = true
+
+	old := g.lock.Find(path)
+	if old != nil && !explicit && compareVersions(old.Version, version) >= 0 {
+		return nil
+	}
+
+	sum, err := download(path, version)
+	if err != nil {
+		return err
+	}
+	if old != nil && old.Version == version && old.Sum != sum {
+		return fmt.Errorf("%s@%s: checksum mismatch\n\tual.lock:   %s\n\tdownloaded: %s", path, version, old.Sum, sum)
+	}
+	if wantSum != "" && wantSum != sum {
+		return fmt.Errorf("%s@%s: checksum mismatch\n\trequired:   %s\n\tdownloaded: %s", path, version, wantSum, sum)
+	}
+
+	m := Module{Path: path, Version: version, Sum: sum}
+	if old == nil || *old != m {
+		g.lock.Set(m)
+		g.changed = append(g.changed, m)
+	}
+
+	// The library's own lockfile names what it needs
+	dir, err := m.Dir()
+	if err != nil {
+		return err
+	}
+	deps, err := ReadLock(dir)
+	if err != nil {
+		return fmt.Errorf("%s@%s: %v", path, version, err)
+	}
+	for _, dep := range deps.Modules {
+		if err := g.get(dep.Path, dep.Version, dep.Sum, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPath rejects module paths that are not host/owner/repo style
+func checkPath(path string) error {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || !strings.Contains(parts[0], ".") {
+		return fmt.Errorf("module path %q must start with a host name, e.g. github.com/user/lib", path)
+	}
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, "@\\ ") {
+			return fmt.Errorf("invalid module path %q", path)
+		}
+	}
+	return nil
+}
+
+// resolveVersion turns a query into a tag, or a commit hash. A full
+// version is used as is; anything else is matched against the
+// repository's release tags, or its other refs.
+func resolveVersion(path, query string) (string, error) {
+	if query == "latest" {
+		query = ""
+	}
+	if v, ok := parseSemver(query); ok && v.parts == 3 {
+		return query, nil
+	}
+	if query != "" && !strings.HasPrefix(query, "v") {
+		return resolveRef(path, query)
+	}
+
+	out, err := git("", "ls-remote", "--tags", "--refs", RepoURL(path))
+	if err != nil {
+		return "", fmt.Errorf("%s: listing versions: %v", path, err)
+	}
+	best := ""
+	for _, line := range strings.Split(out, "\n") {
+		_, ref, ok := strings.Cut(line, "refs/tags/")
+		if !ok {
+			continue
+		}
+		v, ok := parseSemver(ref)
+		if !ok || v.parts != 3 || v.pre != "" {
+			continue
+		}
+		if query != "" && ref != query && !strings.HasPrefix(ref, query+".") {
+			continue
+		}
+		if best == "" || compareVersions(ref, best) > 0 {
+			best = ref
+		}
+	}
+	if best == "" {
+		if query == "" {
+			return "", fmt.Errorf("%s: no release tags (vX.Y.Z) found", path)
+		}
+		return "", fmt.Errorf("%s: no release matches %s", path, query)
+	}
+	return best, nil
+}
+
+// resolveRef turns a query that is not a version into what to lock: a tag
+// as it is, a branch as the commit it is at, or a full commit hash.
+func resolveRef(path, ref string) (string, error) {
+	if isCommit(ref) {
+		return ref, nil
+	}
+	out, err := git("", "ls-remote", "--refs", RepoURL(path))
+	if err != nil {
+		return "", fmt.Errorf("%s: listing refs: %v", path, err)
+	}
+	commit := ""
+	for _, line := range strings.Split(out, "\n") {
+		hash, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "refs/tags/" + ref:
+			return ref, nil
+		case "refs/heads/" + ref:
+			commit = hash
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("%s: no tag, branch or commit %s (give a commit as its full hash)", path, ref)
+	}
+	return commit, nil
+}
+
+// isCommit reports whether a version is a full commit hash
+func isCommit(version string) bool {
+	if len(version) != 40 {
+		return false
+	}
+	for _, c := range version {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// download fetches path@version into the cache, unless it is already
+// there, and returns the checksum of the cached files.
+func download(path, version string) (string, error) {
+	dir, err := Module{Path: path, Version: version}.Dir()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return HashDir(dir)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".get-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	if isCommit(version) {
+		err = fetchCommit(src, RepoURL(path), version)
+	} else {
+		_, err = git("", "clone", "--quiet", "--depth", "1", "--branch", version, RepoURL(path), src)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s@%s: %v", path, version, err)
+	}
+	if err := os.RemoveAll(filepath.Join(src, ".git")); err != nil {
+		return "", err
+	}
+	// Rename last, so an interrupted fetch never leaves a partial module
+	if err := os.Rename(src, dir); err != nil {
+		return "", err
+	}
+	return HashDir(dir)
+}
+
+// fetchCommit checks out one commit of the repository at url into dir;
+// git clone can only check out a branch or tag
+func fetchCommit(dir, url, commit string) error {
+	if _, err := git("", "init", "--quiet", dir); err != nil {
+		return err
+	}
+	if _, err := git(dir, "fetch", "--quiet", "--depth", "1", url, commit); err != nil {
+		return err
+	}
+	_, err := git(dir, "checkout", "--quiet", "FETCH_HEAD")
+	return err
+}
+
+// git runs a git command and returns its output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+// semver is a parsed vMAJOR[.MINOR[.PATCH]][-pre] version
+type semver struct {
+	nums  [3]int
+	parts int
+	pre   string
+}
+
+func parseSemver(s string) (semver, bool) {
+	var v semver
+	if !strings.HasPrefix(s, "v") {
+		return v, false
+	}
+	s, v.pre, _ = strings.Cut(s[1:], "-")
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || v.parts == 3 {
+			return v, false
+		}
+		v.nums[v.parts] = n
+		v.parts++
+	}
+	return v, true
+}
+
+// compareVersions orders release tags numerically. A pre-release sorts
+// before its release, and tags that are not versions sort before all
+// versions, by name.
+func compareVersions(a, b string) int {
+	va, okA := parseSemver(a)
+	vb, okB := parseSemver(b)
+	switch {
+	case !okA && !okB:
+		return strings.Compare(a, b)
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := range va.nums {
+		if va.nums[i] != vb.nums[i] {
+			if va.nums[i] < vb.nums[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return 0
+	case va.pre == "":
+		return 1
+	case vb.pre == "":
+		return -1
+	}
+	return strings.Compare(va.pre, vb.pre)
+}
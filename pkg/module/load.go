@@ -0,0 +1,315 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+// Load resolves the top-level imports of prog, the program in file. Each
+// import is replaced by the statements of the library's .ual files, which
+// therefore run before the program's own. The versions come from the
+// ual.lock of file's project, and each library is checked against its
+// checksum before use; the standard library ("std/...") is built in and
+// needs neither, nor does a module the project's ual.toml maps to a local
+// directory. A library imported twice is loaded once. Load also reads the
+// files that embed declarations, in the program and its libraries, name.
+func Load(prog *ast.Program, file string) error {
+	if !needsLoading(prog.Stmts) {
+		return nil
+	}
+	if prog.Pos == nil {
+		prog.Pos = make(map[ast.Stmt]ast.Pos)
+	}
+	l := &loader{file: file, pos: prog.Pos, loaded: make(map[string]bool), verified: make(map[string]bool)}
+	var err error
+	prog.Stmts, err = l.resolve(prog.Stmts)
+	return err
+}
+
+// LoadTest is Load for prog, the _test.ual file file: the library files
+// beside it, those an import of its directory would load, come first, so
+// its tests see the library's functions without importing it.
+func LoadTest(prog *ast.Program, file string) error {
+	if prog.Pos == nil {
+		prog.Pos = make(map[ast.Stmt]ast.Pos)
+	}
+	l := &loader{file: file, pos: prog.Pos, loaded: make(map[string]bool), verified: make(map[string]bool)}
+	files, err := libraryFiles(filepath.Dir(file))
+	if err != nil && !errors.Is(err, errNoFiles) {
+		return err
+	}
+	var stmts []ast.Stmt
+	for _, f := range files {
+		source, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		lib, err := l.parse(f, string(source))
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, lib.Stmts...)
+	}
+	prog.Stmts, err = l.resolve(append(stmts, prog.Stmts...))
+	return err
+}
+
+type loader struct {
+	lock     *Lock                // read on the first import outside std
+	manifest *Manifest            // the same, empty if there is no ual.toml
+	file     string               // the program
+	pos      map[ast.Stmt]ast.Pos // the program's, extended with library files
+	loaded   map[string]bool      // import paths already included
+	verified map[string]bool      // module paths whose checksum matched
+}
+
+// readManifest reads the ual.toml of the program's project, if it has one
+func (l *loader) readManifest() error {
+	if l.manifest != nil {
+		return nil
+	}
+	l.manifest = &Manifest{}
+	root, ok := FindRoot(filepath.Dir(l.file))
+	if !ok {
+		return nil
+	}
+	m, err := ReadManifest(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.manifest = m
+	return nil
+}
+
+// readLock reads the ual.lock of the program's project
+func (l *loader) readLock() error {
+	if l.lock != nil {
+		return nil
+	}
+	root, ok := FindRoot(filepath.Dir(l.file))
+	if !ok {
+		return fmt.Errorf("%s imports libraries but has no %s; run 'ual get'", l.file, LockName)
+	}
+	lock, err := ReadLock(root)
+	if err != nil {
+		return err
+	}
+	l.lock = lock
+	return nil
+}
+
+// resolve returns stmts with each import replaced by its library
+func (l *loader) resolve(stmts []ast.Stmt) ([]ast.Stmt, error) {
+	var libs, rest []ast.Stmt
+	for _, s := range stmts {
+		imp, ok := s.(*ast.ImportStmt)
+		if !ok {
+			rest = append(rest, s)
+			continue
+		}
+		lib, err := l.load(imp)
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, lib...)
+	}
+	if err := l.embed(rest); err != nil {
+		return nil, err
+	}
+	return append(libs, rest...), nil
+}
+
+// embed reads the files of the embed declarations among stmts. As in Go,
+// a path is relative to the file declaring it and may not leave its
+// directory, so a library cannot embed what is outside it.
+func (l *loader) embed(stmts []ast.Stmt) error {
+	for _, s := range stmts {
+		d, ok := s.(*ast.StackDecl)
+		if !ok || d.Embed == nil {
+			continue
+		}
+		pos := l.at(s)
+		if !fs.ValidPath(d.Embed.Path) || d.Embed.Path == "." {
+			return fmt.Errorf("%s: embed %q: the path must be relative, below the directory of %s", pos, d.Embed.Path, filepath.Base(pos.File))
+		}
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(pos.File), filepath.FromSlash(d.Embed.Path)))
+		if err != nil {
+			return fmt.Errorf("%s: embed: %v", pos, err)
+		}
+		if d.ElementType == "string" && !utf8.Valid(data) {
+			return fmt.Errorf("%s: embed %q: the file is not UTF-8 text; embed it as bytes", pos, d.Embed.Path)
+		}
+		d.Embed.Data = string(data)
+	}
+	return nil
+}
+
+// load parses the library an import names, with its own imports resolved
+func (l *loader) load(imp *ast.ImportStmt) ([]ast.Stmt, error) {
+	if l.loaded[imp.Path] {
+		return nil, nil
+	}
+	l.loaded[imp.Path] = true
+	if IsStd(imp.Path) {
+		return l.loadStd(imp)
+	}
+
+	if err := l.readManifest(); err != nil {
+		return nil, err
+	}
+	if _, dir, ok := l.manifest.Local(imp.Path); ok {
+		return l.loadDir(imp, dir)
+	}
+
+	if err := l.readLock(); err != nil {
+		return nil, err
+	}
+	m := l.lock.Owner(imp.Path)
+	if m == nil {
+		return nil, fmt.Errorf("%s: import %q is not in %s; run 'ual get %s'", l.at(imp), imp.Path, LockName, imp.Path)
+	}
+	if !l.verified[m.Path] {
+		if err := m.Verify(); err != nil {
+			return nil, err
+		}
+		l.verified[m.Path] = true
+	}
+
+	dir, err := m.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return l.loadDir(imp, filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(imp.Path, m.Path))))
+}
+
+// loadDir parses the library in dir, which imp names
+func (l *loader) loadDir(imp *ast.ImportStmt, dir string) ([]ast.Stmt, error) {
+	files, err := libraryFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("import %q: %v", imp.Path, err)
+	}
+
+	var stmts []ast.Stmt
+	for _, f := range files {
+		source, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		prog, err := l.parse(f, string(source))
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, prog.Stmts...)
+	}
+	return l.resolve(stmts)
+}
+
+// loadStd parses a standard library package from the copy built into the
+// binary
+func (l *loader) loadStd(imp *ast.ImportStmt) ([]ast.Stmt, error) {
+	files := stdFiles(imp.Path)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: import %q: no such standard library package", l.at(imp), imp.Path)
+	}
+	var stmts []ast.Stmt
+	for _, f := range files {
+		source, err := stdFS.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		prog, err := l.parse(f, string(source))
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, prog.Stmts...)
+	}
+	return l.resolve(stmts)
+}
+
+// at returns the position of s, in the program or a library file
+func (l *loader) at(s ast.Stmt) ast.Pos {
+	pos := l.pos[s]
+	if pos.File == "" {
+		pos.File = l.file
+	}
+	return pos
+}
+
+// parse parses source, the library file path, and records the positions
+// of its statements under path
+func (l *loader) parse(path, source string) (*ast.Program, error) {
+	prog, err := parseSource(path, source)
+	if err != nil {
+		return nil, err
+	}
+	for s, p := range prog.Pos {
+		l.pos[s] = ast.Pos{File: path, Line: p.Line, Col: p.Col}
+	}
+	return prog, nil
+}
+
+var errNoFiles = errors.New("no .ual files")
+
+// libraryFiles lists the .ual files that make up a library, leaving out
+// main.ual and _test.ual files
+func libraryFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".ual") || name == "main.ual" || strings.HasSuffix(name, "_test.ual") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w in %s", errNoFiles, dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func parseSource(path, source string) (*ast.Program, error) {
+	tokens := lexer.NewLexer(source).Tokenize()
+	for _, tok := range tokens {
+		if tok.Type == lexer.TokError {
+			return nil, fmt.Errorf("%s:%d:%d: lexer error: %s", path, tok.Line, tok.Column, tok.Value)
+		}
+	}
+	prog, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		return nil, errors.New(parser.Diagnostic(path, err))
+	}
+	return prog, nil
+}
+
+// needsLoading reports whether stmts import a library or embed a file
+func needsLoading(stmts []ast.Stmt) bool {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *ast.ImportStmt:
+			return true
+		case *ast.StackDecl:
+			if s.Embed != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
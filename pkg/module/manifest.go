@@ -0,0 +1,434 @@
+package module
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestName is a project's manifest, written by ual init
+const ManifestName = "ual.toml"
+
+// Manifest is a project's ual.toml:
+//
+//	[project]
+//	name = "myproj"
+//	version = "0.1.0"
+//	main = "main.ual"
+//
+//	[build]
+//	target = "go"        # go or rust
+//	profile = "release"  # release, small or debug
+//	output = "bin/myproj"
+//	workers = 16
+//	inline = 12          # largest function inlined, 0 for none
+//	optimize = false
+//	strip = false
+//	static = false       # no dynamic dependencies (Linux)
+//	no-forth = false
+//
+//	[profile.debug]      # over [build] when building with this profile
+//	output = "bin/myproj-debug"
+//
+//	[lint]
+//	disable = "consider-default, shadowed-builtin"  # rules ual lint skips
+//
+//	[dependencies]
+//	"github.com/user/lib" = "v1.2"            # fetched by ual get
+//	"example.com/me/util" = { path = "../util" } # a local directory
+type Manifest struct {
+	Dir     string // directory holding ual.toml
+	Name    string
+	Version string
+	Main    string // entry point, relative to Dir
+
+	Target   string // "" lets the compiler pick the backend
+	Profile  string
+	Output   string // relative to Dir
+	Workers  int
+	Inline   int // the largest function inlined, 0 for none; -1 if not set
+	Optimize bool
+	Strip    bool
+	Static   bool
+	NoForth  bool
+
+	LintDisable []string // the rules ual lint skips
+
+	Dependencies []Dependency
+
+	profiles map[string][]setting // [profile.<name>] lines, applied over [build]
+}
+
+// Dependency is a module the [dependencies] section names: a git module
+// with the version ual get fetches, or a local directory.
+type Dependency struct {
+	Path    string // the module path imports name
+	Version string // a version query as ual get takes it, for a git module
+	Dir     string // the directory of a local module, relative to the manifest
+}
+
+// setting is a key = value line of a section
+type setting struct {
+	key, raw string
+}
+
+// Profiles are the build profiles a [profile.<name>] section can name
+var Profiles = []string{"release", "small", "debug"}
+
+// ReadManifest reads the ual.toml in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, ManifestName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseManifest(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	m.Dir = dir
+	if m.Name == "" {
+		abs, _ := filepath.Abs(dir)
+		m.Name = filepath.Base(abs)
+	}
+	return m, nil
+}
+
+// ParseManifest parses the subset of TOML that ual.toml uses: [sections]
+// and key = value lines, where a value is a quoted string, an integer, a
+// boolean, or in [dependencies] an inline table of strings. Unknown
+// sections and keys are errors, so a typo does not silently change a
+// build.
+func ParseManifest(src string) (*Manifest, error) {
+	m := &Manifest{Main: "main.ual", Inline: -1, profiles: make(map[string][]setting)}
+	section := ""
+	seen := make(map[string]bool)
+	for n, line := range strings.Split(src, "\n") {
+		lineNo := n + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: expected ']'", lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if !knownSection(section) {
+				return nil, fmt.Errorf("line %d: unknown section [%s]", lineNo, section)
+			}
+			continue
+		}
+
+		key, raw, ok := cutKey(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		if seen[section+"."+key] {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNo, key)
+		}
+		seen[section+"."+key] = true
+
+		var err error
+		switch {
+		case section == "dependencies":
+			err = m.addDependency(key, raw)
+		case strings.HasPrefix(section, "profile."):
+			if key == "target" || key == "profile" {
+				err = fmt.Errorf("%s cannot be set per profile", key)
+			} else {
+				// Checked against a scratch copy, applied by WithProfile
+				err = (&Manifest{}).set("build", key, raw)
+			}
+			name := strings.TrimPrefix(section, "profile.")
+			m.profiles[name] = append(m.profiles[name], setting{key, raw})
+		default:
+			err = m.set(section, key, raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+
+	switch m.Profile {
+	case "", "release", "small", "debug":
+	default:
+		return nil, fmt.Errorf("profile must be \"release\", \"small\" or \"debug\", got %q", m.Profile)
+	}
+	return m, nil
+}
+
+// knownSection reports whether ual.toml may have the section
+func knownSection(section string) bool {
+	switch section {
+	case "project", "build", "lint", "dependencies":
+		return true
+	}
+	name, ok := strings.CutPrefix(section, "profile.")
+	for _, p := range Profiles {
+		if ok && name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// cutKey splits a key = value line. A key may be quoted, as module paths
+// with dots must be.
+func cutKey(line string) (key, raw string, ok bool) {
+	if strings.HasPrefix(line, `"`) {
+		end := strings.Index(line[1:], `"`)
+		if end < 0 {
+			return "", "", false
+		}
+		key = line[1 : end+1]
+		rest := strings.TrimSpace(line[end+2:])
+		if !strings.HasPrefix(rest, "=") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), key != ""
+	}
+	key, raw, ok = strings.Cut(line, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(raw), ok
+}
+
+// set assigns one key = value line of the given section.
+func (m *Manifest) set(section, key, raw string) error {
+	strs := map[string]*string{
+		"project.name":    &m.Name,
+		"project.version": &m.Version,
+		"project.main":    &m.Main,
+		"build.target":    &m.Target,
+		"build.profile":   &m.Profile,
+		"build.output":    &m.Output,
+	}
+	bools := map[string]*bool{
+		"build.optimize": &m.Optimize,
+		"build.strip":    &m.Strip,
+		"build.static":   &m.Static,
+		"build.no-forth": &m.NoForth,
+	}
+
+	name := section + "." + key
+	if p, ok := strs[name]; ok {
+		s, err := unquote(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be a quoted string", key)
+		}
+		*p = s
+		return nil
+	}
+	if p, ok := bools[name]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil || (raw != "true" && raw != "false") {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		*p = b
+		return nil
+	}
+	if name == "lint.disable" {
+		s, err := unquote(raw)
+		if err != nil {
+			return fmt.Errorf("disable must be a quoted list of rules, as \"rule, rule\"")
+		}
+		m.LintDisable = nil
+		for _, rule := range strings.Split(s, ",") {
+			if rule = strings.TrimSpace(rule); rule != "" {
+				m.LintDisable = append(m.LintDisable, rule)
+			}
+		}
+		return nil
+	}
+	if name == "build.workers" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("workers must be a number")
+		}
+		if n < 0 {
+			return fmt.Errorf("workers must be a positive number, got %d", n)
+		}
+		m.Workers = n
+		return nil
+	}
+	if name == "build.inline" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return fmt.Errorf("inline must be a number, 0 for no inlining")
+		}
+		m.Inline = n
+		return nil
+	}
+	if section == "" {
+		return fmt.Errorf("%s is outside any section", key)
+	}
+	return fmt.Errorf("unknown key %q in [%s]", key, section)
+}
+
+// addDependency adds one line of [dependencies]: path = "version", or
+// path = { path = "dir" } for a local module
+func (m *Manifest) addDependency(path, raw string) error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	dep := Dependency{Path: path}
+	if !strings.HasPrefix(raw, "{") {
+		v, err := unquote(raw)
+		if err != nil {
+			return fmt.Errorf("%s: expected a quoted version or { path = \"dir\" }", path)
+		}
+		dep.Version = v
+		m.Dependencies = append(m.Dependencies, dep)
+		return nil
+	}
+
+	if !strings.HasSuffix(raw, "}") {
+		return fmt.Errorf("%s: expected '}'", path)
+	}
+	body := strings.TrimSpace(raw[1 : len(raw)-1])
+	for _, field := range strings.Split(body, ",") {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("%s: expected key = value in { }", path)
+		}
+		key = strings.TrimSpace(key)
+		s, err := unquote(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("%s: %s must be a quoted string", path, key)
+		}
+		switch key {
+		case "path":
+			dep.Dir = s
+		case "version":
+			dep.Version = s
+		default:
+			return fmt.Errorf("%s: unknown key %q", path, key)
+		}
+	}
+	if (dep.Dir == "") == (dep.Version == "") {
+		return fmt.Errorf("%s: give either a path or a version", path)
+	}
+	m.Dependencies = append(m.Dependencies, dep)
+	return nil
+}
+
+// WithProfile returns m with the settings of its [profile.<name>] section,
+// if it has one, over those of [build].
+func (m *Manifest) WithProfile(name string) *Manifest {
+	p := *m
+	for _, s := range m.profiles[name] {
+		p.set("build", s.key, s.raw) // checked by ParseManifest
+	}
+	return &p
+}
+
+// Local returns the local module an import path belongs to, and the
+// directory holding the package it names; ok is false if no local module
+// in the manifest holds it.
+func (m *Manifest) Local(importPath string) (dep Dependency, dir string, ok bool) {
+	for _, d := range m.Dependencies {
+		if d.Dir == "" || importPath != d.Path && !strings.HasPrefix(importPath, d.Path+"/") {
+			continue
+		}
+		if !ok || len(d.Path) > len(dep.Path) {
+			dep, ok = d, true
+		}
+	}
+	if !ok {
+		return dep, "", false
+	}
+	dir = dep.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(m.Dir, dir)
+	}
+	return dep, filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(importPath, dep.Path))), true
+}
+
+// WriteDependency sets path to version in the [dependencies] of the
+// ual.toml in dir, adding the line, or the section, if it is not there.
+// The rest of the file, comments included, is kept as it is.
+func WriteDependency(dir, path, version string) error {
+	file := filepath.Join(dir, ManifestName)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	src, err := setDependency(string(data), path, version)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	if src == string(data) {
+		return nil
+	}
+	return os.WriteFile(file, []byte(src), 0644)
+}
+
+// setDependency returns src with path = "version" in its [dependencies]
+func setDependency(src, path, version string) (string, error) {
+	entry := strconv.Quote(path) + " = " + strconv.Quote(version)
+	lines := strings.Split(src, "\n")
+	section, last := "", -1 // last is the last line of [dependencies]
+	for i, line := range lines {
+		text := strings.TrimSpace(stripComment(line))
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if section == "dependencies" {
+				last = i
+			}
+			continue
+		}
+		if section != "dependencies" || text == "" {
+			continue
+		}
+		last = i
+		key, raw, ok := cutKey(text)
+		if !ok || key != path {
+			continue
+		}
+		if strings.HasPrefix(raw, "{") {
+			return "", fmt.Errorf("%s is a local dependency", path)
+		}
+		// Keep the line's comment, if it has one
+		lines[i] = entry + line[len(strings.TrimRight(stripComment(line), " \t")):]
+		return strings.Join(lines, "\n"), nil
+	}
+
+	if last < 0 {
+		src = strings.TrimRight(src, "\n")
+		if src != "" {
+			src += "\n\n"
+		}
+		return src + "[dependencies]\n" + entry + "\n", nil
+	}
+	lines = append(lines[:last+1], append([]string{entry}, lines[last+1:]...)...)
+	return strings.Join(lines, "\n"), nil
+}
+
+// unquote returns the string a quoted value holds
+func unquote(raw string) (string, error) {
+	if !strings.HasPrefix(raw, `"`) {
+		return "", strconv.ErrSyntax
+	}
+	return strconv.Unquote(raw)
+}
+
+// stripComment removes a # comment that is not inside a quoted string.
+func stripComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case '#':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
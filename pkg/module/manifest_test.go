@@ -0,0 +1,186 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+)
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest(`# ual project manifest
+[project]
+name = "demo"   # the binary name
+version = "0.2.0"
+main = "src/app.ual"
+
+[build]
+target = "rust"
+profile = "small"
+output = "bin/#demo"
+workers = 8
+inline = 20
+optimize = true
+static = true
+
+[profile.debug]
+output = "bin/demo-debug"
+optimize = false
+inline = 0
+
+[lint]
+disable = "consider-default, shadowed-builtin"
+
+[dependencies]
+"github.com/user/lib" = "v1.2"
+"example.com/me/util" = { path = "../util" }
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Name != "demo" || m.Version != "0.2.0" || m.Main != "src/app.ual" {
+		t.Errorf("project: got %+v", m)
+	}
+	if m.Target != "rust" || m.Profile != "small" || m.Output != "bin/#demo" || m.Workers != 8 || m.Inline != 20 || !m.Optimize || m.Strip || !m.Static {
+		t.Errorf("build: got %+v", m)
+	}
+	if strings.Join(m.LintDisable, " ") != "consider-default shadowed-builtin" {
+		t.Errorf("lint: got %q", m.LintDisable)
+	}
+	want := []Dependency{{Path: "github.com/user/lib", Version: "v1.2"}, {Path: "example.com/me/util", Dir: "../util"}}
+	if len(m.Dependencies) != 2 || m.Dependencies[0] != want[0] || m.Dependencies[1] != want[1] {
+		t.Errorf("dependencies: got %+v", m.Dependencies)
+	}
+
+	d := m.WithProfile("debug")
+	if d.Output != "bin/demo-debug" || d.Optimize || d.Workers != 8 || d.Inline != 0 {
+		t.Errorf("debug profile: got %+v", d)
+	}
+	if m.Output != "bin/#demo" || !m.Optimize {
+		t.Errorf("WithProfile changed the manifest: %+v", m)
+	}
+	if s := m.WithProfile("small"); s.Output != m.Output {
+		t.Errorf("a profile with no section changed output to %q", s.Output)
+	}
+
+	m, err = ParseManifest("[project]\nname = \"x\"\n")
+	if err != nil || m.Main != "main.ual" {
+		t.Errorf("expected main.ual by default, got %+v, %v", m, err)
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	for _, tc := range []struct{ src, want string }{
+		{"[deps]\n", "unknown section"},
+		{"[profile.fast]\n", "unknown section"},
+		{"[build]\ntargte = \"go\"\n", "unknown key"},
+		{"name = \"x\"\n", "outside any section"},
+		{"[build]\ntarget = go\n", "quoted string"},
+		{"[build]\nprofile = \"fast\"\n", "profile must be"},
+		{"[build]\nstrip = yes\n", "true or false"},
+		{"[lint]\ndisable = consider-default\n", "quoted list of rules"},
+		{"[build]\nworkers = many\n", "must be a number"},
+		{"[build]\nworkers = -1\n", "positive"},
+		{"[build]\ninline = -1\n", "0 for no inlining"},
+		{"[build]\nstrip = true\nstrip = false\n", "set twice"},
+		{"[build\n", "expected ']'"},
+		{"[profile.debug]\ntarget = \"go\"\n", "cannot be set per profile"},
+		{"[profile.debug]\nstrip = 1\n", "true or false"},
+		{"[dependencies]\n\"lib\" = \"v1\"\n", "host name"},
+		{"[dependencies]\n\"example.com/u/lib\" = v1\n", "quoted version"},
+		{"[dependencies]\n\"example.com/u/lib\" = { dir = \"x\" }\n", "unknown key"},
+		{"[dependencies]\n\"example.com/u/lib\" = { }\n", "key = value"},
+		{"[dependencies]\n\"example.com/u/lib\" = { path = \"x\", version = \"v1\" }\n", "either"},
+	} {
+		_, err := ParseManifest(tc.src)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.src, tc.want, err)
+		}
+	}
+}
+
+func TestSetDependency(t *testing.T) {
+	for _, tc := range []struct{ src, want string }{
+		{"", "[dependencies]\n\"example.com/u/lib\" = \"v1\"\n"},
+		{"[project]\nname = \"p\"\n", "[project]\nname = \"p\"\n\n[dependencies]\n\"example.com/u/lib\" = \"v1\"\n"},
+		{
+			"[dependencies]\n\"example.com/u/a\" = \"v2\"\n\n[build]\n",
+			"[dependencies]\n\"example.com/u/a\" = \"v2\"\n\"example.com/u/lib\" = \"v1\"\n\n[build]\n",
+		},
+		{
+			"[dependencies]\n\"example.com/u/lib\" = \"v0.9\"   # pinned\n",
+			"[dependencies]\n\"example.com/u/lib\" = \"v1\"   # pinned\n",
+		},
+	} {
+		got, err := setDependency(tc.src, "example.com/u/lib", "v1")
+		if err != nil || got != tc.want {
+			t.Errorf("setDependency(%q) = %q, %v; want %q", tc.src, got, err, tc.want)
+		}
+	}
+
+	local := "[dependencies]\n\"example.com/u/lib\" = { path = \"../lib\" }\n"
+	if _, err := setDependency(local, "example.com/u/lib", "v1"); err == nil {
+		t.Error("replaced a local dependency")
+	}
+}
+
+func TestLoadLocal(t *testing.T) {
+	root := t.TempDir()
+	util := filepath.Join(t.TempDir(), "util")
+	for path, src := range map[string]string{
+		filepath.Join(root, ManifestName):       "[dependencies]\n\"example.com/me/util\" = { path = \"" + filepath.ToSlash(util) + "\" }\n",
+		filepath.Join(util, "double.ual"):       "func double(n i64) i64 { return n * 2 }\n",
+		filepath.Join(util, "more", "quad.ual"): "import \"example.com/me/util\"\nfunc quad(n i64) i64 { return double(double(n)) }\n",
+	} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No ual.lock is needed, and nothing is checked against one
+	prog := parse(t, "import \"example.com/me/util/more\"\npush:1\n")
+	if err := Load(prog, filepath.Join(root, "main.ual")); err != nil {
+		t.Fatal(err)
+	}
+	var funcs []string
+	for _, s := range prog.Stmts {
+		if fn, ok := s.(*ast.FuncDecl); ok {
+			funcs = append(funcs, fn.Name)
+		}
+	}
+	if strings.Join(funcs, ",") != "double,quad" {
+		t.Errorf("functions = %v, want [double quad]", funcs)
+	}
+}
+
+func TestGetManifest(t *testing.T) {
+	remotes := fakeRemote(t)
+	publish(t, remotes, "example.com/u/lib", "v1.0.0", map[string]string{"lib.ual": "-- 1.0\n"})
+	publish(t, remotes, "example.com/u/lib", "v1.1.0", map[string]string{"lib.ual": "-- 1.1\n"})
+	root := t.TempDir()
+	m := &Manifest{Dir: root, Dependencies: []Dependency{
+		{Path: "example.com/u/lib", Version: "v1"},
+		{Path: "example.com/me/util", Dir: "../util"},
+	}}
+
+	changed, err := GetManifest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].Version != "v1.1.0" {
+		t.Errorf("changed = %+v, want lib v1.1.0", changed)
+	}
+
+	// Locked at a version the query allows, the module is kept
+	m.Dependencies[0].Version = "v1.1"
+	if changed, err := GetManifest(m); err != nil || len(changed) != 0 {
+		t.Errorf("second get changed %+v, %v", changed, err)
+	}
+	m.Dependencies[0].Version = "v1.0.0"
+	if changed, err := GetManifest(m); err != nil || len(changed) != 1 || changed[0].Version != "v1.0.0" {
+		t.Errorf("pinning changed %+v, %v", changed, err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package module fetches ual libraries and resolves the imports that use them.
+//
+// `ual get github.com/user/lib@v1.2.0` clones the library's git tag into a
+// shared cache and records it in the project's ual.lock:
+//
+//	github.com/user/lib v1.2.0 h1:3q2+7w...=
+//
+// Each line holds a module path, the version chosen and a checksum of the
+// module's files. A program then writes
+//
+//	import "github.com/user/lib"
+//
+// and the compiler and interpreter load the library from the cache, after
+// checking it still matches the checksum in ual.lock. A library's own
+// ual.lock lists the versions it needs; ual get adds those too, keeping the
+// higher version when two libraries need the same module.
+//
+// A project's ual.toml can list its modules under [dependencies], for ual
+// get with no arguments to fetch, and map a module path to a local
+// directory, which imports then read as it is, without a lock or checksum.
+package module
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LockName is the lockfile ual get maintains next to ual.toml
+const LockName = "ual.lock"
+
+// Module is one locked dependency
+type Module struct {
+	Path    string // e.g. github.com/user/lib
+	Version string // a git tag, e.g. v1.2.0
+	Sum     string // HashDir of the module's files
+}
+
+// Lock is the parsed content of a ual.lock
+type Lock struct {
+	Modules []Module
+}
+
+// ReadLock reads the ual.lock in dir. A missing lockfile is an empty lock.
+func ReadLock(dir string) (*Lock, error) {
+	f, err := os.Open(filepath.Join(dir, LockName))
+	if os.IsNotExist(err) {
+		return &Lock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &Lock{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"path version sum\"", LockName, n)
+		}
+		l.Modules = append(l.Modules, Module{Path: fields[0], Version: fields[1], Sum: fields[2]})
+	}
+	return l, sc.Err()
+}
+
+// Write saves the lock to dir/ual.lock, sorted by path
+func (l *Lock) Write(dir string) error {
+	sort.Slice(l.Modules, func(i, j int) bool { return l.Modules[i].Path < l.Modules[j].Path })
+	var b strings.Builder
+	for _, m := range l.Modules {
+		fmt.Fprintf(&b, "%s %s %s\n", m.Path, m.Version, m.Sum)
+	}
+	return os.WriteFile(filepath.Join(dir, LockName), []byte(b.String()), 0644)
+}
+
+// Find returns the locked module with the given path, or nil
+func (l *Lock) Find(path string) *Module {
+	for i := range l.Modules {
+		if l.Modules[i].Path == path {
+			return &l.Modules[i]
+		}
+	}
+	return nil
+}
+
+// Set adds m, replacing any entry with the same path
+func (l *Lock) Set(m Module) {
+	if old := l.Find(m.Path); old != nil {
+		*old = m
+		return
+	}
+	l.Modules = append(l.Modules, m)
+}
+
+// Owner returns the locked module an import path belongs to: the one
+// whose path is the longest prefix of importPath, so
+// github.com/user/lib/strings is found in github.com/user/lib.
+func (l *Lock) Owner(importPath string) *Module {
+	var best *Module
+	for i := range l.Modules {
+		m := &l.Modules[i]
+		if importPath != m.Path && !strings.HasPrefix(importPath, m.Path+"/") {
+			continue
+		}
+		if best == nil || len(m.Path) > len(best.Path) {
+			best = m
+		}
+	}
+	return best
+}
+
+// CacheDir is where fetched modules live: $UAL_CACHE if set, otherwise
+// ual/mod under the user's cache directory.
+func CacheDir() (string, error) {
+	if dir := os.Getenv("UAL_CACHE"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ual", "mod"), nil
+}
+
+// Dir returns the cache directory holding m's files
+func (m Module) Dir() (string, error) {
+	cache, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, filepath.FromSlash(m.Path)+"@"+m.Version), nil
+}
+
+// Verify checks that m's cached files still match its checksum
+func (m Module) Verify() error {
+	dir, err := m.Dir()
+	if err != nil {
+		return err
+	}
+	sum, err := HashDir(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s@%s is not downloaded; run 'ual get'", m.Path, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	if sum != m.Sum {
+		return fmt.Errorf("%s@%s: checksum mismatch\n\tual.lock:   %s\n\tdownloaded: %s", m.Path, m.Version, m.Sum, sum)
+	}
+	return nil
+}
+
+// HashDir returns a checksum of every file under dir, ignoring .git. Each
+// file contributes its SHA-256 and slash-separated relative path, so the
+// sum changes if any file is added, removed, renamed or edited.
+func HashDir(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+	var lines []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		lines = append(lines, fmt.Sprintf("%x  %s\n", sha256.Sum256(data), filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// FindRoot returns the project directory for a source file: the nearest
+// directory at or above dir holding a ual.lock or ual.toml.
+func FindRoot(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		for _, name := range []string{LockName, "ual.toml"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
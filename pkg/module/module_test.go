@@ -0,0 +1,476 @@
+package module
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+// fakeRemote points RepoURL at local git repositories under a temp dir
+// and gives the test its own module cache.
+func fakeRemote(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	remotes := t.TempDir()
+	t.Setenv("UAL_CACHE", t.TempDir())
+	old := RepoURL
+	RepoURL = func(path string) string { return filepath.Join(remotes, path) }
+	t.Cleanup(func() { RepoURL = old })
+	return remotes
+}
+
+// publish commits files to the fake repository for path and tags it
+func publish(t *testing.T, remotes, path, tag string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(remotes, path)
+	if _, err := os.Stat(dir); err != nil {
+		os.MkdirAll(dir, 0755)
+		run(t, dir, "init", "--quiet")
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, dir, "add", "-A")
+	run(t, dir, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "--quiet", "-m", tag)
+	run(t, dir, "tag", tag)
+}
+
+func run(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	if _, err := git(dir, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	l := &Lock{}
+	l.Set(Module{"github.com/b/lib", "v1.0.0", "h1:b"})
+	l.Set(Module{"github.com/a/lib", "v0.2.0", "h1:a"})
+	l.Set(Module{"github.com/b/lib", "v1.1.0", "h1:b2"})
+	if err := l.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, LockName))
+	want := "github.com/a/lib v0.2.0 h1:a\ngithub.com/b/lib v1.1.0 h1:b2\n"
+	if string(data) != want {
+		t.Errorf("lockfile:\n%s\nwant:\n%s", data, want)
+	}
+
+	got, err := ReadLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Modules) != 2 || got.Find("github.com/b/lib").Version != "v1.1.0" {
+		t.Errorf("read back %+v", got.Modules)
+	}
+	if m := got.Owner("github.com/a/lib/strings"); m == nil || m.Path != "github.com/a/lib" {
+		t.Errorf("Owner of a subdirectory: %+v", m)
+	}
+	if m := got.Owner("github.com/a/library"); m != nil {
+		t.Errorf("Owner matched a different module: %+v", m)
+	}
+}
+
+func TestHashDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.ual"), []byte("push:1"), 0644)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("x"), 0644)
+
+	sum1, err := HashDir(dir)
+	if err != nil || !strings.HasPrefix(sum1, "h1:") {
+		t.Fatalf("HashDir = %q, %v", sum1, err)
+	}
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("y"), 0644)
+	if sum, _ := HashDir(dir); sum != sum1 {
+		t.Error(".git should not affect the sum")
+	}
+	os.WriteFile(filepath.Join(dir, "a.ual"), []byte("push:2"), 0644)
+	if sum, _ := HashDir(dir); sum == sum1 {
+		t.Error("editing a file should change the sum")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.0", "v1.10.0", -1},
+		{"v2.0.0", "v1.9.9", 1},
+		{"v1.0.0", "v1.0.0", 0},
+		{"v1.0.0-rc1", "v1.0.0", -1},
+		{"main", "v0.0.1", -1},
+	}
+	for _, tc := range tests {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	for _, ok := range []string{"github.com/user/lib", "example.org/x"} {
+		if err := checkPath(ok); err != nil {
+			t.Errorf("%s: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"lib", "user/lib", "github.com/../x", "github.com/u/"} {
+		if err := checkPath(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestGetResolvesVersions(t *testing.T) {
+	remotes := fakeRemote(t)
+	for _, tag := range []string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0-beta"} {
+		publish(t, remotes, "example.com/u/lib", tag, map[string]string{"lib.ual": "-- " + tag + "\n"})
+	}
+	root := t.TempDir()
+
+	tests := []struct{ spec, want string }{
+		{"example.com/u/lib", "v1.10.0"},
+		{"example.com/u/lib@v1.2", "v1.2.0"},
+		{"example.com/u/lib@v1.0.0", "v1.0.0"},
+		{"example.com/u/lib@v2.0.0-beta", "v2.0.0-beta"},
+	}
+	for _, tc := range tests {
+		if _, err := Get(root, tc.spec); err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		lock, _ := ReadLock(root)
+		m := lock.Find("example.com/u/lib")
+		if m == nil || m.Version != tc.want {
+			t.Errorf("%s: locked %+v, want %s", tc.spec, m, tc.want)
+			continue
+		}
+		if err := m.Verify(); err != nil {
+			t.Errorf("%s: %v", tc.spec, err)
+		}
+	}
+
+	if _, err := Get(root, "example.com/u/lib@v3"); err == nil {
+		t.Error("expected an error for a version with no release")
+	}
+}
+
+func TestGetPinsCommits(t *testing.T) {
+	remotes := fakeRemote(t)
+	publish(t, remotes, "example.com/u/lib", "v1.0.0", map[string]string{"lib.ual": "-- 1.0\n"})
+	first, _ := git(filepath.Join(remotes, "example.com/u/lib"), "rev-parse", "HEAD")
+	first = strings.TrimSpace(first)
+	publish(t, remotes, "example.com/u/lib", "v1.1.0", map[string]string{"lib.ual": "-- 1.1\n"})
+	branch, _ := git(filepath.Join(remotes, "example.com/u/lib"), "branch", "--show-current")
+	root := t.TempDir()
+
+	// A branch is locked as the commit it is at
+	if _, err := Get(root, "example.com/u/lib@"+strings.TrimSpace(branch)); err != nil {
+		t.Fatal(err)
+	}
+	lock, _ := ReadLock(root)
+	m := lock.Find("example.com/u/lib")
+	if m == nil || !isCommit(m.Version) || m.Version == first {
+		t.Fatalf("locked %+v, want the branch's commit", m)
+	}
+
+	if _, err := Get(root, "example.com/u/lib@"+first); err != nil {
+		t.Fatal(err)
+	}
+	lock, _ = ReadLock(root)
+	m = lock.Find("example.com/u/lib")
+	if m == nil || m.Version != first {
+		t.Fatalf("locked %+v, want %s", m, first)
+	}
+	dir, _ := m.Dir()
+	if data, err := os.ReadFile(filepath.Join(dir, "lib.ual")); err != nil || string(data) != "-- 1.0\n" {
+		t.Errorf("lib.ual at %s = %q, %v", first, data, err)
+	}
+
+	if _, err := Get(root, "example.com/u/lib@nosuch"); err == nil || !strings.Contains(err.Error(), "no tag, branch or commit") {
+		t.Errorf("Get of a missing ref = %v", err)
+	}
+}
+
+func TestGetRecordsManifest(t *testing.T) {
+	remotes := fakeRemote(t)
+	publish(t, remotes, "example.com/u/lib", "v1.2.0", map[string]string{"lib.ual": "-- 1.2\n"})
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, ManifestName), []byte("[project]\nname = \"p\"\n"), 0644)
+
+	if _, err := Get(root, "example.com/u/lib"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadManifest(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Dependency{{Path: "example.com/u/lib", Version: "v1.2.0"}}
+	if !reflect.DeepEqual(m.Dependencies, want) {
+		t.Errorf("dependencies = %+v, want %+v", m.Dependencies, want)
+	}
+
+	// A query is recorded as it was given
+	if _, err := Get(root, "example.com/u/lib@v1"); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = ReadManifest(root)
+	if len(m.Dependencies) != 1 || m.Dependencies[0].Version != "v1" {
+		t.Errorf("dependencies = %+v, want lib at v1", m.Dependencies)
+	}
+}
+
+func TestGetDependencies(t *testing.T) {
+	remotes := fakeRemote(t)
+	publish(t, remotes, "example.com/u/base", "v1.0.0", map[string]string{"base.ual": "-- 1.0\n"})
+	publish(t, remotes, "example.com/u/base", "v1.1.0", map[string]string{"base.ual": "-- 1.1\n"})
+
+	// app needs base v1.0.0; get it first to learn its sum
+	scratch := t.TempDir()
+	if _, err := Get(scratch, "example.com/u/base@v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	lockData, _ := os.ReadFile(filepath.Join(scratch, LockName))
+	publish(t, remotes, "example.com/u/app", "v0.1.0", map[string]string{
+		"app.ual": "-- app\n",
+		LockName:  string(lockData),
+	})
+
+	root := t.TempDir()
+	if _, err := Get(root, "example.com/u/base@v1.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := Get(root, "example.com/u/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].Path != "example.com/u/app" {
+		t.Errorf("changed = %+v, want just app", changed)
+	}
+	lock, _ := ReadLock(root)
+	if m := lock.Find("example.com/u/base"); m == nil || m.Version != "v1.1.0" {
+		t.Errorf("base = %+v, want the higher v1.1.0 kept", m)
+	}
+
+	// A fresh project picks up the dependency at app's version
+	fresh := t.TempDir()
+	if _, err := Get(fresh, "example.com/u/app"); err != nil {
+		t.Fatal(err)
+	}
+	lock, _ = ReadLock(fresh)
+	if m := lock.Find("example.com/u/base"); m == nil || m.Version != "v1.0.0" {
+		t.Errorf("base = %+v, want v1.0.0 from app's lock", m)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	remotes := fakeRemote(t)
+	publish(t, remotes, "example.com/u/lib", "v1.0.0", map[string]string{"lib.ual": "push:1\n"})
+	root := t.TempDir()
+	if _, err := Get(root, "example.com/u/lib@v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tamper with the cached copy
+	lock, _ := ReadLock(root)
+	dir, _ := lock.Find("example.com/u/lib").Dir()
+	os.WriteFile(filepath.Join(dir, "lib.ual"), []byte("push:2\n"), 0644)
+
+	if err := lock.Find("example.com/u/lib").Verify(); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Verify = %v, want checksum mismatch", err)
+	}
+	if err := Download(root); err == nil {
+		t.Error("Download should refuse a tampered module")
+	}
+	if _, err := Get(root, "example.com/u/lib@v1.0.0"); err == nil {
+		t.Error("Get should refuse a tampered module")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	remotes := fakeRemote(t)
+	publish(t, remotes, "example.com/u/math", "v1.0.0", map[string]string{
+		"square.ual":        "func square(n i64) i64 { return n * n }\n",
+		"main.ual":          "push:99\n",
+		"ext/cube.ual":      "import \"example.com/u/math\"\nfunc cube(n i64) i64 { return n * square(n) }\n",
+		"ext/cube_test.ual": "push:98\n",
+	})
+	root := t.TempDir()
+	if _, err := Get(root, "example.com/u/math"); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(root, "main.ual")
+	prog := parse(t, "import \"example.com/u/math/ext\"\nimport \"example.com/u/math\"\npush:1\n")
+	if err := Load(prog, file); err != nil {
+		t.Fatal(err)
+	}
+
+	// ext pulls in math first; the second import of math is a no-op
+	var funcs []string
+	for _, s := range prog.Stmts {
+		if fn, ok := s.(*ast.FuncDecl); ok {
+			funcs = append(funcs, fn.Name)
+		}
+	}
+	if strings.Join(funcs, ",") != "square,cube" {
+		t.Errorf("functions = %v, want [square cube]", funcs)
+	}
+	if len(prog.Stmts) != 3 {
+		t.Errorf("expected 2 functions and the program's push, got %d statements", len(prog.Stmts))
+	}
+
+	prog = parse(t, "import \"example.com/u/other\"\n")
+	if err := Load(prog, file); err == nil || !strings.Contains(err.Error(), "ual get") {
+		t.Errorf("Load of an unlocked import = %v", err)
+	}
+}
+
+func TestLoadTest(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"square.ual":      "func square(n i64) i64 { return n * n }\n",
+		"main.ual":        "push:99\n",
+		"other_test.ual":  "push:98\n",
+		"square_test.ual": "import \"std/strings\"\npush:1\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "square_test.ual")
+	prog := parse(t, "import \"std/strings\"\npush:1\n")
+	if err := LoadTest(prog, file); err != nil {
+		t.Fatal(err)
+	}
+
+	// std/strings, then the library, then the test file itself
+	var square *ast.FuncDecl
+	for _, s := range prog.Stmts {
+		if fn, ok := s.(*ast.FuncDecl); ok && fn.Name == "square" {
+			square = fn
+		}
+	}
+	if square == nil {
+		t.Fatal("the library beside the test was not loaded")
+	}
+	if pos := prog.Pos[square]; pos.File != filepath.Join(dir, "square.ual") || pos.Line != 1 {
+		t.Errorf("square at %v", pos)
+	}
+	if _, ok := prog.Stmts[len(prog.Stmts)-1].(*ast.StackOp); !ok {
+		t.Errorf("the test file's statements should come last, got %T", prog.Stmts[len(prog.Stmts)-1])
+	}
+
+	// A test file on its own is fine
+	alone := filepath.Join(t.TempDir(), "alone_test.ual")
+	if err := LoadTest(parse(t, "push:1\n"), alone); err != nil {
+		t.Errorf("LoadTest without a library = %v", err)
+	}
+}
+
+func TestLoadEmbed(t *testing.T) {
+	dir := t.TempDir()
+	util := filepath.Join(dir, "util")
+	for name, content := range map[string]string{
+		filepath.Join(dir, "assets", "words.txt"): "one\r\ntwo\n",
+		filepath.Join(dir, "bad.bin"):             "\xff\xfe",
+		filepath.Join(util, "table.txt"):          "util table",
+		filepath.Join(util, "util.ual"):           "embed \"table.txt\" as @table string\n",
+		filepath.Join(dir, ManifestName):          "[dependencies]\n\"example.com/me/util\" = { path = \"util\" }\n",
+	} {
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "main.ual")
+
+	prog := parse(t, "import \"example.com/me/util\"\nembed \"assets/words.txt\" as @words lines\nembed \"bad.bin\" as @bad\n")
+	if err := Load(prog, file); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range prog.Stmts {
+		if d, ok := s.(*ast.StackDecl); ok {
+			got = append(got, strings.Join(d.Embed.Elements(), "|"))
+		}
+	}
+	if want := []string{"util table", "one|two", "\xff\xfe"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("embedded %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct{ src, want string }{
+		{"embed \"missing.txt\" as @m\n", "no such file"},
+		{"embed \"../x.txt\" as @m\n", "must be relative"},
+		{"embed \"/etc/hostname\" as @m\n", "must be relative"},
+		{"embed \"bad.bin\" as @m string\n", "not UTF-8"},
+	} {
+		err := Load(parse(t, tc.src), file)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.src, tc.want, err)
+		}
+	}
+}
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestLoadStd(t *testing.T) {
+	// no ual.lock: the standard library is built in
+	file := filepath.Join(t.TempDir(), "main.ual")
+	prog := parse(t, "import \"std/json\"\nimport \"std/strings\"\npush:1\n")
+	if err := Load(prog, file); err != nil {
+		t.Fatal(err)
+	}
+	var upper *ast.FuncDecl
+	for _, s := range prog.Stmts {
+		if fn, ok := s.(*ast.FuncDecl); ok && fn.Name == "to_upper" {
+			upper = fn
+		}
+	}
+	if upper == nil {
+		t.Fatal("std/strings was not loaded")
+	}
+	if pos := prog.Pos[upper]; pos.File != "std/strings/strings.ual" || pos.Line == 0 {
+		t.Errorf("to_upper at %v", pos)
+	}
+
+	prog = parse(t, "\nimport \"std/nope\"\n")
+	err := Load(prog, file)
+	if err == nil || !strings.Contains(err.Error(), "main.ual:2:1: import \"std/nope\": no such standard library package") {
+		t.Errorf("Load of an unknown std package = %v", err)
+	}
+}
+
+func TestStdPackagesParse(t *testing.T) {
+	pkgs := StdPackages()
+	if len(pkgs) == 0 {
+		t.Fatal("no standard library packages")
+	}
+	for _, p := range pkgs {
+		prog := parse(t, "import \""+p+"\"\n")
+		if err := Load(prog, "main.ual"); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package module
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The standard library is a set of ual libraries built into the compiler
+// and interpreter. Programs import them by a path starting with "std/",
+// such as "std/strings"; they need no ual get and no ual.lock.
+//
+//go:embed std
+var stdFS embed.FS
+
+// StdPrefix starts the import paths of the standard library
+const StdPrefix = "std/"
+
+// IsStd reports whether an import path names a standard library package
+func IsStd(importPath string) bool {
+	return strings.HasPrefix(importPath, StdPrefix)
+}
+
+// StdPackages lists the standard library packages, e.g. "std/strings"
+func StdPackages() []string {
+	entries, _ := fs.ReadDir(stdFS, "std")
+	var pkgs []string
+	for _, e := range entries {
+		if e.IsDir() {
+			pkgs = append(pkgs, StdPrefix+e.Name())
+		}
+	}
+	return pkgs
+}
+
+// stdFiles returns the paths of the .ual files in the standard library
+// package importPath, in name order, for reading from stdFS; none if
+// there is no such package
+func stdFiles(importPath string) []string {
+	entries, _ := fs.ReadDir(stdFS, importPath)
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".ual") && !strings.HasSuffix(e.Name(), "_test.ual") {
+			files = append(files, path.Join(importPath, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
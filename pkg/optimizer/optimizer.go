@@ -0,0 +1,422 @@
+// Package optimizer rewrites a parsed ual program into an equivalent one
+// with fewer stack operations, before code generation.
+//
+// It folds constant arithmetic and drops operations that cancel out:
+//
+//	push(2) push(3) add    ->  push(5)
+//	push:4 dup mul         ->  push(16)
+//	dup drop               ->  (nothing)
+//	push:7 drop            ->  (nothing)
+//
+// The initial values of variables are folded too, where they are constant
+// expressions: literals, operators and pure builtins such as sqrt. They
+// are evaluated by pkg/eval, as iual evaluates them, so
+//
+//	var r f64 = sqrt(2.0) / 2  ->  var r f64 = 0.7071067811865476
+//
+// Only consecutive operations on one stack are rewritten, and only on
+// stacks whose behaviour is fully known at compile time: @dstack and
+// uncapped LIFO stacks of integers that the program never freezes or
+// mocks. Integer arithmetic is folded in 64 bits, which gives the same
+// result as the stack would for every integer width, since add, sub, mul
+// and the bitwise ops commute with wrapping. div and mod are folded on i64
+// stacks only. A fold that would overflow or divide by zero is left for
+// the program to do at run time, so it fails or wraps as it always has.
+//
+// Basic usage:
+//
+//	prog, err := prs.Parse()
+//	...
+//	optimizer.Optimize(prog)
+package optimizer
+
+import (
+	"math"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// Optimize rewrites prog in place and returns the number of stack
+// operations it removed
+func Optimize(prog *ast.Program) int {
+	o := &optimizer{stacks: map[string]stackKind{"dstack": wideInt}, funcs: map[string]bool{}, pos: prog.Pos}
+	o.scan(prog.Stmts)
+	walk(prog.Stmts, o.foldValues)
+	prog.Stmts = o.stmts(prog.Stmts)
+	return o.removed
+}
+
+// stackKind is what the optimizer knows about a stack
+type stackKind int
+
+const (
+	unknown   stackKind = iota // not folded
+	narrowInt                  // integers other than i64: no div or mod
+	wideInt                    // i64
+)
+
+type optimizer struct {
+	stacks  map[string]stackKind
+	funcs   map[string]bool      // the program's functions
+	pos     map[ast.Stmt]ast.Pos // the program's, for statements added
+	removed int
+}
+
+// scan records the kind of every declared stack. A stack declared twice
+// differently, frozen, mocked or given a perspective anywhere in the
+// program is not folded: a perspective other than LIFO changes the order
+// its operations pop in.
+func (o *optimizer) scan(list []ast.Stmt) {
+	declared := map[string]bool{}
+	walk(list, func(s ast.Stmt) {
+		if fn, ok := s.(*ast.FuncDecl); ok {
+			o.funcs[fn.Name] = true
+		}
+		if d, ok := s.(*ast.StackDecl); ok {
+			kind := declKind(d)
+			if declared[d.Name] && o.stacks[d.Name] != kind {
+				kind = unknown
+			}
+			declared[d.Name] = true
+			o.stacks[d.Name] = kind
+		}
+	})
+	walk(list, func(s ast.Stmt) {
+		switch s := s.(type) {
+		case *ast.StackOp:
+			if s.Op == "freeze" || s.Op == "perspective" {
+				o.stacks[s.Stack] = unknown
+			}
+		case *ast.FuncCall:
+			if s.Name == "mock" && len(s.Args) > 0 {
+				if ref, ok := s.Args[0].(*ast.StackRef); ok {
+					o.stacks[ref.Name] = unknown
+				}
+			}
+		}
+	})
+}
+
+// foldValues replaces the initial values of a variable declaration that
+// are constant expressions with their literals. A value whose literal
+// would not have the variable's type, or that fails, as by dividing by
+// zero, is left for run time.
+func (o *optimizer) foldValues(s ast.Stmt) {
+	d, ok := s.(*ast.VarDecl)
+	if !ok {
+		return
+	}
+	for idx, e := range d.Values {
+		switch e.(type) {
+		case *ast.IntLit, *ast.FloatLit, *ast.StringLit, *ast.BoolLit:
+			continue
+		}
+		v, err := eval.Const(e, func(name string) bool { return o.funcs[name] })
+		if err != nil || !fits(d.Type, v) {
+			continue
+		}
+		if lit, ok := eval.Literal(v); ok {
+			d.Values[idx] = lit
+		}
+	}
+}
+
+// fits reports whether v, as a literal, can initialise a variable of type
+// t, "" if the variable takes the type of its value
+func fits(t string, v runtime.Value) bool {
+	if v.Type == runtime.VTFloat && (math.IsNaN(v.AsFloat()) || math.IsInf(v.AsFloat(), 0)) {
+		return false
+	}
+	switch t {
+	case "":
+		return true
+	case "i64":
+		return v.Type == runtime.VTInt
+	case "f64", "f32":
+		return v.Type == runtime.VTInt || v.Type == runtime.VTFloat
+	case "string":
+		return v.Type == runtime.VTString
+	case "bool":
+		return v.Type == runtime.VTBool
+	}
+	return false
+}
+
+// walk calls fn for every statement in list and the lists nested in it
+func walk(list []ast.Stmt, fn func(ast.Stmt)) {
+	for _, s := range list {
+		fn(s)
+		for _, body := range bodies(s) {
+			walk(body, fn)
+		}
+	}
+}
+
+func declKind(d *ast.StackDecl) stackKind {
+	if d.Capacity > 0 || (d.Perspective != "" && d.Perspective != "LIFO") {
+		return unknown
+	}
+	switch d.ElementType {
+	case "i64":
+		return wideInt
+	case "i8", "i16", "i32", "u8", "u16", "u32", "u64":
+		return narrowInt
+	}
+	return unknown
+}
+
+// bodies returns the statement lists nested in s. Compute blocks are left
+// out: their bodies are infix code, not stack operations.
+func bodies(s ast.Stmt) [][]ast.Stmt {
+	switch s := s.(type) {
+	case *ast.StackBlock:
+		return [][]ast.Stmt{s.Ops}
+	case *ast.Block:
+		return [][]ast.Stmt{s.Stmts}
+	case *ast.IfStmt:
+		out := [][]ast.Stmt{s.Body, s.Else}
+		for _, e := range s.ElseIfs {
+			out = append(out, e.Body)
+		}
+		return out
+	case *ast.WhileStmt:
+		return [][]ast.Stmt{s.Body}
+	case *ast.ForStmt:
+		return [][]ast.Stmt{s.Body}
+	case *ast.RangeStmt:
+		return [][]ast.Stmt{s.Body}
+	case *ast.FuncDecl:
+		return [][]ast.Stmt{s.Body}
+	case *ast.DeferStmt:
+		return [][]ast.Stmt{s.Body}
+	case *ast.AtExitStmt:
+		return [][]ast.Stmt{s.Body}
+	case *ast.TryStmt:
+		return [][]ast.Stmt{s.Body, s.Catch, s.Finally}
+	case *ast.SpawnPush:
+		return [][]ast.Stmt{s.Body}
+	case *ast.ConsiderStmt:
+		var out [][]ast.Stmt
+		if s.Block != nil {
+			out = append(out, s.Block.Ops)
+		}
+		for _, c := range s.Cases {
+			out = append(out, c.Handler)
+		}
+		return out
+	case *ast.SelectStmt:
+		var out [][]ast.Stmt
+		if s.Block != nil {
+			out = append(out, s.Block.Ops)
+		}
+		for _, c := range s.Cases {
+			out = append(out, c.Handler)
+		}
+		return out
+	}
+	return nil
+}
+
+// stmts optimizes the statements nested in list, then list itself
+func (o *optimizer) stmts(list []ast.Stmt) []ast.Stmt {
+	for _, s := range list {
+		switch s := s.(type) {
+		case *ast.StackBlock:
+			s.Ops = o.stmts(s.Ops)
+		case *ast.Block:
+			s.Stmts = o.stmts(s.Stmts)
+		case *ast.IfStmt:
+			s.Body = o.stmts(s.Body)
+			s.Else = o.stmts(s.Else)
+			for i := range s.ElseIfs {
+				s.ElseIfs[i].Body = o.stmts(s.ElseIfs[i].Body)
+			}
+		case *ast.WhileStmt:
+			s.Body = o.stmts(s.Body)
+		case *ast.ForStmt:
+			s.Body = o.stmts(s.Body)
+		case *ast.RangeStmt:
+			s.Body = o.stmts(s.Body)
+		case *ast.FuncDecl:
+			s.Body = o.stmts(s.Body)
+		case *ast.DeferStmt:
+			s.Body = o.stmts(s.Body)
+		case *ast.AtExitStmt:
+			s.Body = o.stmts(s.Body)
+		case *ast.TryStmt:
+			s.Body = o.stmts(s.Body)
+			s.Catch = o.stmts(s.Catch)
+			s.Finally = o.stmts(s.Finally)
+		case *ast.SpawnPush:
+			s.Body = o.stmts(s.Body)
+		case *ast.ConsiderStmt:
+			if s.Block != nil {
+				s.Block.Ops = o.stmts(s.Block.Ops)
+			}
+			for i := range s.Cases {
+				s.Cases[i].Handler = o.stmts(s.Cases[i].Handler)
+			}
+		case *ast.SelectStmt:
+			if s.Block != nil {
+				s.Block.Ops = o.stmts(s.Block.Ops)
+			}
+			for i := range s.Cases {
+				s.Cases[i].Handler = o.stmts(s.Cases[i].Handler)
+			}
+		}
+	}
+	return o.peephole(list)
+}
+
+// peephole rewrites runs of stack operations in list. Each operation is
+// appended to out and then combined with the constant pushes before it,
+// so folds cascade: push:1 push:2 add push:3 mul -> push(9).
+func (o *optimizer) peephole(list []ast.Stmt) []ast.Stmt {
+	out := make([]ast.Stmt, 0, len(list))
+	for _, s := range list {
+		op, ok := s.(*ast.StackOp)
+		if !ok || op.Target != "" || o.stacks[op.Stack] == unknown {
+			out = append(out, s)
+			continue
+		}
+		kind := o.stacks[op.Stack]
+		a, b := constPush(out, 2, op.Stack), constPush(out, 1, op.Stack)
+
+		switch op.Op {
+		case "add", "sub", "mul", "div", "mod", "band", "bor", "bxor":
+			if a == nil || b == nil {
+				break
+			}
+			if v, ok := fold(op.Op, literal(a), literal(b), kind); ok {
+				setLiteral(a, v)
+				out = out[:len(out)-1]
+				o.removed += 2
+				continue
+			}
+		case "neg", "inc", "dec":
+			if b == nil {
+				break
+			}
+			if v, ok := foldUnary(op.Op, literal(b)); ok {
+				setLiteral(b, v)
+				o.removed++
+				continue
+			}
+		case "dup":
+			if b != nil {
+				// push:c dup -> push:c push:c, which later ops may fold
+				push := &ast.StackOp{Stack: b.Stack, Op: "push", Args: []ast.Expr{&ast.IntLit{Value: literal(b)}}, ColonForm: b.ColonForm}
+				if pos, ok := o.pos[op]; ok {
+					o.pos[push] = pos
+				}
+				out = append(out, push)
+				continue
+			}
+		case "drop":
+			if b != nil {
+				out = out[:len(out)-1]
+				o.removed += 2
+				continue
+			}
+			if prev, ok := last(out).(*ast.StackOp); ok && prev.Stack == op.Stack && prev.Op == "dup" {
+				out = out[:len(out)-1]
+				o.removed += 2
+				continue
+			}
+		case "swap":
+			if a != nil && b != nil {
+				va, vb := literal(a), literal(b)
+				setLiteral(a, vb)
+				setLiteral(b, va)
+				o.removed++
+				continue
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// constPush returns the n-th statement from the end of out if it and every
+// statement after it are pushes of an integer literal to stack
+func constPush(out []ast.Stmt, n int, stack string) *ast.StackOp {
+	if len(out) < n {
+		return nil
+	}
+	for _, s := range out[len(out)-n:] {
+		op, ok := s.(*ast.StackOp)
+		if !ok || op.Stack != stack || op.Op != "push" || op.Target != "" || len(op.Args) != 1 {
+			return nil
+		}
+		if _, ok := op.Args[0].(*ast.IntLit); !ok {
+			return nil
+		}
+	}
+	return out[len(out)-n].(*ast.StackOp)
+}
+
+func last(out []ast.Stmt) ast.Stmt {
+	if len(out) == 0 {
+		return nil
+	}
+	return out[len(out)-1]
+}
+
+func literal(push *ast.StackOp) int64 {
+	return push.Args[0].(*ast.IntLit).Value
+}
+
+// setLiteral replaces the pushed value with a new literal, so a literal
+// shared with other nodes is never changed
+func setLiteral(push *ast.StackOp, v int64) {
+	push.Args = []ast.Expr{&ast.IntLit{Value: v}}
+}
+
+// fold computes a op b, reporting false if the result should be left to
+// run time
+func fold(op string, a, b int64, kind stackKind) (int64, bool) {
+	switch op {
+	case "add":
+		r := a + b
+		return r, (r > a) == (b > 0) || b == 0
+	case "sub":
+		r := a - b
+		return r, (r < a) == (b > 0) || b == 0
+	case "mul":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		r := a * b
+		return r, r/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	case "div", "mod":
+		if kind != wideInt || b == 0 || b == -1 {
+			return 0, false
+		}
+		if op == "div" {
+			return a / b, true
+		}
+		return a % b, true
+	case "band":
+		return a & b, true
+	case "bor":
+		return a | b, true
+	case "bxor":
+		return a ^ b, true
+	}
+	return 0, false
+}
+
+// foldUnary computes op a, reporting false on overflow
+func foldUnary(op string, a int64) (int64, bool) {
+	switch op {
+	case "neg":
+		return -a, a != math.MinInt64
+	case "inc":
+		return a + 1, a != math.MaxInt64
+	case "dec":
+		return a - 1, a != math.MinInt64
+	}
+	return 0, false
+}
@@ -0,0 +1,142 @@
+package optimizer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+)
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	return prog
+}
+
+// ops lists the stack operations in list, nested ones included, as
+// "@stack op(args)"
+func ops(list []ast.Stmt) string {
+	var out []string
+	walk(list, func(s ast.Stmt) {
+		op, ok := s.(*ast.StackOp)
+		if !ok {
+			return
+		}
+		var args []string
+		for _, a := range op.Args {
+			if lit, ok := a.(*ast.IntLit); ok {
+				args = append(args, fmt.Sprint(lit.Value))
+			} else {
+				args = append(args, "?")
+			}
+		}
+		out = append(out, fmt.Sprintf("@%s %s(%s)", op.Stack, op.Op, strings.Join(args, ",")))
+	})
+	return strings.Join(out, " ")
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    string
+		removed int
+	}{
+		{"push:2 push:3 add", "@dstack push(5)", 2},
+		{"push(2) push(3) add push(4) mul", "@dstack push(20)", 4},
+		{"push:4 dup mul", "@dstack push(16)", 2},
+		{"push:10 push:3 sub neg", "@dstack push(-7)", 3},
+		{"push:1 push:2 swap", "@dstack push(2) @dstack push(1)", 1},
+		{"push:7 drop", "", 2},
+		{"dup drop", "", 2},
+		{"push:6 push:4 div", "@dstack push(1)", 2},
+		{"@a = stack.new(i64)\n@a push:9 push:2 mod", "@a push(1)", 2},
+		{"@a = stack.new(u8)\n@a push:250 push:10 add", "@a push(260)", 2},
+		{"if (x > 0) {\n push:1 push:1 add\n}", "@dstack push(2)", 2},
+		{"func f() {\n dup drop dot\n}", "@dstack dot()", 2},
+	}
+	for _, tt := range tests {
+		prog := parse(t, tt.src)
+		removed := Optimize(prog)
+		if got := ops(prog.Stmts); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+		if removed != tt.removed {
+			t.Errorf("%q: removed %d ops, want %d", tt.src, removed, tt.removed)
+		}
+	}
+}
+
+func TestOptimizeLeavesAlone(t *testing.T) {
+	for _, src := range []string{
+		"push:1 add",
+		"push:1 pop:x push:2 add",
+		"push:6 push:0 div",
+		"push:6 push:-1 div",
+		"push:9223372036854775807 push:1 add",
+		"push:-9223372036854775808 neg",
+		"@a = stack.new(i8)\n@a push:6 push:4 div",
+		"@a = stack.new(f64)\n@a push:2 push:3 add",
+		"@a = stack.new(i64, FIFO)\n@a push:2 push:3 sub",
+		"@a = stack.new(i64, cap: 1)\n@a push:7 drop",
+		"@a = stack.new(i64)\n@a push:2 push:3 add\n@a freeze",
+		"@a = stack.new(i64)\nmock(@a, [1])\n@a push:2 push:3 add",
+		"@a = stack.new(i64)\n@a perspective(FIFO)\n@a push:10 push:3 sub",
+		"@a = stack.new(i64)\n@a push:10 push:3 sub\n@a perspective(FIFO)",
+		"@a = stack.new(i64)\n@a {\n perspective(FIFO)\n push:10 push:3 sub\n}",
+		"perspective(FIFO)\npush:2 push:3 sub",
+	} {
+		prog := parse(t, src)
+		before := ops(prog.Stmts)
+		if removed := Optimize(prog); removed != 0 {
+			t.Errorf("%q: removed %d ops", src, removed)
+		}
+		if after := ops(prog.Stmts); after != before {
+			t.Errorf("%q: rewrote %q to %q", src, before, after)
+		}
+	}
+}
+
+func TestFoldValues(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // of the first value, %#v of its literal
+	}{
+		{"var r f64 = sqrt(16.0) / 2", "&ast.FloatLit{Value:2}"},
+		{"var n = 7 / 2 + len(\"abc\")", "&ast.IntLit{Value:6}"},
+		{"var n i64 = 1 + 2", "&ast.IntLit{Value:3}"},
+		{"var s = \"v${2 * 21}\"", "&ast.StringLit{Value:\"v42\"}"},
+		{"var f f64 = 10 / 4", "&ast.IntLit{Value:2}"},
+		{"func f() {\n var x = pow(2, 3)\n}", "&ast.FloatLit{Value:8}"},
+		// Left alone: not constant, failing, or not of the variable's type
+		{"var y = 1\nvar n = y + 1", "*ast.BinaryOp"},
+		{"var n = 1 / 0", "*ast.BinaryOp"},
+		{"var n i64 = sqrt(4.0)", "*ast.FuncCall"},
+		{"var n i32 = 1 + 2", "*ast.BinaryOp"},
+		{"var r = sqrt(0.0 - 1.0)", "*ast.FuncCall"},
+		{"func sqrt(x f64) f64 {\n return x\n}\nvar r = sqrt(4.0)", "*ast.FuncCall"},
+	}
+	for _, tt := range tests {
+		prog := parse(t, tt.src)
+		Optimize(prog)
+		var last *ast.VarDecl
+		walk(prog.Stmts, func(s ast.Stmt) {
+			if d, ok := s.(*ast.VarDecl); ok {
+				last = d
+			}
+		})
+		got := fmt.Sprintf("%T", last.Values[0])
+		switch v := last.Values[0].(type) {
+		case *ast.IntLit, *ast.FloatLit, *ast.StringLit:
+			got = fmt.Sprintf("%#v", v)
+		}
+		if got != tt.want {
+			t.Errorf("%q: value %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
@@ -2,18 +2,97 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
 	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/runtime"
 )
 
 // Parser
 
 type Parser struct {
-	tokens []lexer.Token
-	pos    int
+	tokens  []lexer.Token
+	pos     int
+	stmtPos map[ast.Stmt]ast.Pos // start of each parsed statement
+	errors  ErrorList            // syntax errors recovered from so far
+	words   map[string][]*ast.StackOp // def'd words, by name
+	enums   map[string]map[string]int64 // enum members' values, by enum and member name
+	consts  map[string]ast.Expr // const values, folded to literals, by name
+	topLevel bool // the statement being parsed is not nested in another
+	typeParams []string // type parameters of the function being parsed
+}
+
+// Error is a syntax error. Its message starts "line N:", as parse errors
+// always have; Pos gives the line and column for file:line:col diagnostics.
+type Error struct {
+	Pos ast.Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Pos.Line, e.Msg)
+}
+
+// ErrorList is every syntax error Parse found, in source order. After an
+// error the parser skips to the end of the statement and carries on, so
+// one run reports the errors of all statements.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Unwrap gives errors.As the individual errors
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
+
+// Diagnostics formats err, returned by Parse for the file path, as one
+// Diagnostic per syntax error
+func Diagnostics(path string, err error) []string {
+	var list ErrorList
+	if !errors.As(err, &list) {
+		return []string{Diagnostic(path, err)}
+	}
+	diags := make([]string, len(list))
+	for i, e := range list {
+		diags[i] = Diagnostic(path, e)
+	}
+	return diags
+}
+
+// Diagnostic formats err, returned by Parse for the file path, as
+// "path:line:col: parse error: ..."; for an ErrorList, the first error
+func Diagnostic(path string, err error) string {
+	var perr *Error
+	if errors.As(err, &perr) {
+		pos := perr.Pos
+		pos.File = path
+		return fmt.Sprintf("%s: parse error: %s", pos, perr.Msg)
+	}
+	return fmt.Sprintf("%s: parse error: %v", path, err)
+}
+
+// errorAt returns an Error at tok
+func errorAt(tok lexer.Token, format string, args ...interface{}) error {
+	return &Error{Pos: ast.Pos{Line: tok.Line, Col: tok.Column}, Msg: fmt.Sprintf(format, args...)}
 }
 
 func NewParser(tokens []lexer.Token) *Parser {
@@ -22,14 +101,14 @@ func NewParser(tokens []lexer.Token) *Parser {
 
 func (p *Parser) peek() lexer.Token {
 	if p.pos >= len(p.tokens) {
-		return lexer.Token{lexer.TokEOF, "", 0, 0}
+		return lexer.Token{Type: lexer.TokEOF}
 	}
 	return p.tokens[p.pos]
 }
 
 func (p *Parser) peekAhead(n int) lexer.Token {
 	if p.pos+n >= len(p.tokens) {
-		return lexer.Token{lexer.TokEOF, "", 0, 0}
+		return lexer.Token{Type: lexer.TokEOF}
 	}
 	return p.tokens[p.pos+n]
 }
@@ -43,7 +122,7 @@ func (p *Parser) advance() lexer.Token {
 func (p *Parser) expect(t lexer.TokenType) (lexer.Token, error) {
 	tok := p.peek()
 	if tok.Type != t {
-		return tok, fmt.Errorf("line %d: expected %v, got %v", tok.Line, lexer.TokenNames[t], tok)
+		return tok, errorAt(tok, "expected %v, got %v", lexer.TokenNames[t], tok)
 	}
 	return p.advance(), nil
 }
@@ -56,30 +135,129 @@ func (p *Parser) skipNewlines() {
 
 func (p *Parser) Parse() (*ast.Program, error) {
 	prog := &ast.Program{}
+	p.stmtPos = make(map[ast.Stmt]ast.Pos)
+	prog.Pos = p.stmtPos
 	
 	p.skipNewlines()
 	
 	for p.peek().Type != lexer.TokEOF {
+		start := p.pos
+		p.topLevel = true
 		stmt, err := p.parseStmt()
 		if err != nil {
-			return nil, err
-		}
-		if stmt != nil {
+			if !p.recover(start, err) {
+				return nil, err
+			}
+		} else if stmt != nil {
 			prog.Stmts = append(prog.Stmts, stmt)
 		}
 		p.skipNewlines()
 	}
 	
+	if len(p.errors) > 0 {
+		return nil, p.errors
+	}
 	return prog, nil
 }
 
+// recover records err, from the statement that starts at token start, and
+// skips to the end of that statement: the next newline outside braces, or
+// the '}' closing the enclosing block. It returns false for an error that
+// is not a syntax error, which cannot be recovered from.
+func (p *Parser) recover(start int, err error) bool {
+	var perr *Error
+	if !errors.As(err, &perr) {
+		return false
+	}
+	if list, ok := err.(ErrorList); ok {
+		p.errors = append(p.errors, list...)
+	} else {
+		p.errors = append(p.errors, perr)
+	}
+	p.pos = start
+	depth := 0
+	for first := true; ; first = false {
+		switch p.peek().Type {
+		case lexer.TokEOF:
+			return true
+		case lexer.TokNewline:
+			if depth == 0 && !first {
+				return true
+			}
+		case lexer.TokLBrace:
+			depth++
+		case lexer.TokRBrace:
+			if depth == 0 && !first {
+				return true
+			}
+			if depth > 0 {
+				depth--
+			}
+		}
+		p.advance()
+	}
+}
+
+// parseStmt parses one statement and records where it starts
 func (p *Parser) parseStmt() (ast.Stmt, error) {
+	start := p.peek()
+	stmt, err := p.parseStmtAt()
+	if stmt != nil && p.stmtPos != nil {
+		p.stmtPos[stmt] = ast.Pos{Line: start.Line, Col: start.Column}
+	}
+	return stmt, err
+}
+
+func (p *Parser) parseStmtAt() (ast.Stmt, error) {
 	tok := p.peek()
+	top := p.topLevel
+	p.topLevel = false
 	
 	switch tok.Type {
 	case lexer.TokStackRef:
 		return p.parseStackStmt()
 	case lexer.TokIdent:
+		if tok.Value == "const" && p.peekAhead(1).Type == lexer.TokIdent && p.peekAhead(2).Type == lexer.TokEquals {
+			if !top {
+				return nil, errorAt(tok, "const must be at the top level")
+			}
+			return p.parseConstDecl()
+		}
+		if p.consts[tok.Value] != nil && p.peekAhead(1).Type == lexer.TokEquals {
+			return nil, errorAt(tok, "cannot assign to const %s", tok.Value)
+		}
+		if tok.Value == "args" && p.isArgsDecl() {
+			return p.parseArgsDecl()
+		}
+		if tok.Value == "def" && p.peekAhead(2).Type == lexer.TokLBrace {
+			return p.parseWordDef()
+		}
+		if tok.Value == "enum" && p.peekAhead(1).Type == lexer.TokIdent && p.peekAhead(2).Type == lexer.TokLBrace {
+			return p.parseEnumDecl()
+		}
+		if (tok.Value == "test" || tok.Value == "bench") && p.peekAhead(1).Type == lexer.TokString && p.peekAhead(2).Type == lexer.TokLBrace {
+			if !top {
+				return nil, errorAt(tok, tok.Value+" must be at the top level")
+			}
+			return p.parseTestBlock()
+		}
+		if tok.Value == "assert" && p.peekAhead(1).Type == lexer.TokLParen {
+			return p.parseAssert()
+		}
+		if p.words[tok.Value] != nil {
+			return p.parseImplicitStackOps()
+		}
+		if tok.Value == "embed" && p.peekAhead(1).Type == lexer.TokString {
+			if !top {
+				return nil, errorAt(tok, "embed must be at the top level")
+			}
+			return p.parseEmbedDecl()
+		}
+		if tok.Value == "import" && p.peekAhead(1).Type == lexer.TokString {
+			p.advance() // consume 'import'
+			path := p.advance()
+			return &ast.ImportStmt{Path: path.Value, Line: tok.Line}, nil
+		}
 		return p.parseIdentStmt()
 	case lexer.TokVar:
 		return p.parseVarDecl()
@@ -91,6 +269,8 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 		return p.parseIfStmt()
 	case lexer.TokWhile:
 		return p.parseWhileStmt()
+	case lexer.TokFor:
+		return p.parseRangeStmt()
 	case lexer.TokBreak:
 		p.advance()
 		return &ast.BreakStmt{}, nil
@@ -113,7 +293,7 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 		if p.peek().Type == lexer.TokLParen {
 			p.advance() // consume (
 			if p.peek().Type != lexer.TokRParen {
-				return nil, fmt.Errorf("line %d: retry() takes no arguments", tok.Line)
+				return nil, errorAt(tok, "retry() takes no arguments")
 			}
 			p.advance() // consume )
 		}
@@ -124,7 +304,7 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 		if p.peek().Type == lexer.TokLParen {
 			p.advance() // consume (
 			if p.peek().Type != lexer.TokRParen {
-				return nil, fmt.Errorf("line %d: restart() takes no arguments", tok.Line)
+				return nil, errorAt(tok, "restart() takes no arguments")
 			}
 			p.advance() // consume )
 		}
@@ -137,7 +317,7 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 		if isOperationToken(tok.Type) {
 			return p.parseImplicitStackOps()
 		}
-		return nil, fmt.Errorf("line %d: unexpected token %v", tok.Line, tok)
+		return nil, errorAt(tok, "unexpected token %v", tok)
 	}
 }
 
@@ -151,7 +331,7 @@ func (p *Parser) parseImplicitStackOps() (ast.Stmt, error) {
 			return nil, err
 		}
 		if op != nil {
-			ops = append(ops, op)
+			ops = p.appendOp(ops, op)
 		}
 		
 		next := p.peek()
@@ -201,13 +381,14 @@ func (p *Parser) parseStackStmt() (ast.Stmt, error) {
 		return &ast.ErrorPush{Message: expr}, nil
 	}
 	
-	// Check for @defer < { block } — push code block to defer stack
-	if name == "defer" && next.Type == lexer.TokSymLt {
+	// Check for @defer < { block } / @atexit < { block } — push code block
+	// to the defer or atexit stack
+	if (name == "defer" || name == "atexit") && next.Type == lexer.TokSymLt {
 		p.advance() // consume <
 		
 		// Expect { block }
 		if p.peek().Type != lexer.TokLBrace {
-			return nil, fmt.Errorf("line %d: expected '{' after '@defer <'", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '{' after '@%s <'", name)
 		}
 		p.advance() // consume '{'
 		p.skipNewlines()
@@ -225,9 +406,12 @@ func (p *Parser) parseStackStmt() (ast.Stmt, error) {
 		}
 		
 		if _, err := p.expect(lexer.TokRBrace); err != nil {
-			return nil, fmt.Errorf("line %d: expected '}' to close defer block", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '}' to close %s block", name)
 		}
 		
+		if name == "atexit" {
+			return &ast.AtExitStmt{Body: body}, nil
+		}
 		return &ast.DeferStmt{Body: body}, nil
 	}
 	
@@ -237,7 +421,7 @@ func (p *Parser) parseStackStmt() (ast.Stmt, error) {
 		
 		// Expect { block } or {|params| block }
 		if p.peek().Type != lexer.TokLBrace {
-			return nil, fmt.Errorf("line %d: expected '{' after '@spawn <'", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '{' after '@spawn <'")
 		}
 		p.advance() // consume '{'
 		
@@ -258,7 +442,7 @@ func (p *Parser) parseStackStmt() (ast.Stmt, error) {
 				}
 			}
 			if _, err := p.expect(lexer.TokPipe); err != nil {
-				return nil, fmt.Errorf("line %d: expected '|' to close parameter list", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected '|' to close parameter list")
 			}
 		}
 		
@@ -277,13 +461,24 @@ func (p *Parser) parseStackStmt() (ast.Stmt, error) {
 		}
 		
 		if _, err := p.expect(lexer.TokRBrace); err != nil {
-			return nil, fmt.Errorf("line %d: expected '}' to close spawn block", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '}' to close spawn block")
 		}
 		
-		return &ast.SpawnPush{Params: params, Body: body}, nil
+		// Optional: into @results - push the block's return value there
+		var into string
+		if p.peek().Type == lexer.TokIdent && p.peek().Value == "into" {
+			p.advance() // consume 'into'
+			ref, err := p.expect(lexer.TokStackRef)
+			if err != nil {
+				return nil, errorAt(p.peek(), "expected @stack after 'into'")
+			}
+			into = ref.Value
+		}
+		
+		return &ast.SpawnPush{Params: params, Body: body, Into: into}, nil
 	}
 	
-	// Check for @spawn operations: peek, pop, len, clear (with optional play)
+	// Check for @spawn operations: peek, pop, len, clear, wait (with optional play)
 	if name == "spawn" {
 		return p.parseSpawnOp()
 	}
@@ -293,7 +488,7 @@ func (p *Parser) parseStackStmt() (ast.Stmt, error) {
 		p.advance() // consume .
 		perspTok, err := p.expect(lexer.TokIdent)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: expected perspective name after '.'", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected perspective name after '.'")
 		}
 		perspective = perspTok.Value
 		next = p.peek()
@@ -397,10 +592,10 @@ func (p *Parser) parseStackBlock(name string) (ast.Stmt, error) {
 				return nil, err
 			}
 			if op != nil {
-				ops = append(ops, op)
+				ops = p.appendOp(ops, op)
 			} else if tok.Type != lexer.TokNewline {
 				// Not an operation and not a newline - unexpected token
-				return nil, fmt.Errorf("line %d: unexpected token in block: %v", tok.Line, tok)
+				return nil, errorAt(tok, "unexpected token in block: %v", tok)
 			}
 		}
 		
@@ -430,7 +625,7 @@ func (p *Parser) parseStackBlock(name string) (ast.Stmt, error) {
 			return p.parseCompute(block)
 		}
 		// Not consider, select, or compute, put the dot back conceptually by returning error
-		return nil, fmt.Errorf("line %d: expected 'consider', 'select', or 'compute' after '.'", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected 'consider', 'select', or 'compute' after '.'")
 	}
 	
 	return block, nil
@@ -441,12 +636,16 @@ func (p *Parser) parseStackOps(name string) (ast.Stmt, error) {
 	var ops []ast.Stmt
 	
 	for {
+		tok := p.peek()
+		start := p.pos
 		op, err := p.parseOperation(name, false)
 		if err != nil {
 			return nil, err
 		}
 		if op != nil {
-			ops = append(ops, op)
+			ops = p.appendOp(ops, op)
+		} else if p.pos == start {
+			return nil, errorAt(tok, "unexpected token in operations on @%s: %v", name, tok)
 		}
 		
 		// Check for end of operations
@@ -463,6 +662,309 @@ func (p *Parser) parseStackOps(name string) (ast.Stmt, error) {
 	return &ast.StackBlock{Stack: name, Ops: ops}, nil
 }
 
+// def name { op op ... }: a word, a named sequence of stack operations.
+// A word used as an operation, on any stack, is replaced by its
+// operations applied to that stack, so it costs nothing at run time and
+// works in every backend. Words are defined before use, in the same file.
+func (p *Parser) parseWordDef() (ast.Stmt, error) {
+	defTok := p.advance() // consume 'def'
+	nameTok := p.advance()
+	if nameTok.Type != lexer.TokIdent {
+		if isOperationToken(nameTok.Type) {
+			return nil, errorAt(nameTok, "cannot redefine built-in operation %s", nameTok.Value)
+		}
+		return nil, errorAt(nameTok, "expected word name after 'def'")
+	}
+	if p.words[nameTok.Value] != nil {
+		return nil, errorAt(nameTok, "word %s already defined", nameTok.Value)
+	}
+	p.advance() // consume '{'
+	p.skipNewlines()
+
+	var ops []ast.Stmt
+	for p.peek().Type != lexer.TokRBrace && p.peek().Type != lexer.TokEOF {
+		tok := p.peek()
+		op, err := p.parseOperation("", true)
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			return nil, errorAt(tok, "unexpected token in word %s: %v", nameTok.Value, tok)
+		}
+		// A word's operations are inlined, so it cannot use itself, and
+		// a name that is neither an operation nor an earlier word would
+		// only fail where the word is used
+		if op.Op == nameTok.Value {
+			return nil, errorAt(defTok, "word %s cannot use itself", nameTok.Value)
+		}
+		if tok.Type == lexer.TokIdent && !identOps[op.Op] && p.words[op.Op] == nil {
+			return nil, errorAt(defTok, "undefined word %s in word %s", op.Op, nameTok.Value)
+		}
+		ops = p.appendOp(ops, op)
+		p.skipNewlines()
+	}
+	if _, err := p.expect(lexer.TokRBrace); err != nil {
+		return nil, errorAt(p.peek(), "expected '}' to close word %s", nameTok.Value)
+	}
+	if len(ops) == 0 {
+		return nil, errorAt(nameTok, "word %s has no operations", nameTok.Value)
+	}
+
+	if p.words == nil {
+		p.words = make(map[string][]*ast.StackOp)
+	}
+	for _, op := range ops {
+		p.words[nameTok.Value] = append(p.words[nameTok.Value], op.(*ast.StackOp))
+	}
+	return nil, nil
+}
+
+// enum Color { Red, Green, Blue }: named i64 constants, numbered from 0
+// unless a member gives its value (Red = 1), each following member one
+// more than the last. Color.Green is replaced by its value wherever it is
+// used, like a word; enums are declared before use, in the same file.
+func (p *Parser) parseEnumDecl() (ast.Stmt, error) {
+	p.advance() // consume 'enum'
+	nameTok := p.advance()
+	if p.enums[nameTok.Value] != nil {
+		return nil, errorAt(nameTok, "enum %s already defined", nameTok.Value)
+	}
+	p.advance() // consume '{'
+
+	members := map[string]int64{}
+	var next int64
+	for p.skipNewlines(); p.peek().Type != lexer.TokRBrace; p.skipNewlines() {
+		memberTok, err := p.expect(lexer.TokIdent)
+		if err != nil {
+			return nil, errorAt(memberTok, "expected member name in enum %s", nameTok.Value)
+		}
+		if _, ok := members[memberTok.Value]; ok {
+			return nil, errorAt(memberTok, "enum %s has two members named %s", nameTok.Value, memberTok.Value)
+		}
+		if p.peek().Type == lexer.TokEquals {
+			p.advance() // consume '='
+			neg := p.peek().Type == lexer.TokMinus
+			if neg {
+				p.advance()
+			}
+			valTok, err := p.expect(lexer.TokInt)
+			if err != nil {
+				return nil, errorAt(valTok, "expected integer value for %s.%s", nameTok.Value, memberTok.Value)
+			}
+			next, err = strconv.ParseInt(valTok.Value, 10, 64)
+			if err != nil {
+				return nil, errorAt(valTok, "value %s of %s.%s is out of range for i64", valTok.Value, nameTok.Value, memberTok.Value)
+			}
+			if neg {
+				next = -next
+			}
+		}
+		members[memberTok.Value] = next
+		next++
+		p.skipNewlines()
+		if p.peek().Type != lexer.TokComma {
+			break
+		}
+		p.advance()
+	}
+	if _, err := p.expect(lexer.TokRBrace); err != nil {
+		return nil, errorAt(p.peek(), "expected '}' to close enum %s", nameTok.Value)
+	}
+	if len(members) == 0 {
+		return nil, errorAt(nameTok, "enum %s has no members", nameTok.Value)
+	}
+
+	if p.enums == nil {
+		p.enums = make(map[string]map[string]int64)
+	}
+	p.enums[nameTok.Value] = members
+	return nil, nil
+}
+
+// enumMember parses Enum.Member, after the enum's name, as its value
+func (p *Parser) enumMember(name lexer.Token) (ast.Expr, error) {
+	p.advance() // consume '.'
+	memberTok, err := p.expect(lexer.TokIdent)
+	if err != nil {
+		return nil, errorAt(memberTok, "expected member name after %s.", name.Value)
+	}
+	val, ok := p.enums[name.Value][memberTok.Value]
+	if !ok {
+		return nil, errorAt(memberTok, "enum %s has no member %s", name.Value, memberTok.Value)
+	}
+	return &ast.IntLit{Value: val}, nil
+}
+
+// const MAX = 1024: a named constant. Its value is an expression of
+// literals and earlier constants, folded when it is declared, and each use
+// of MAX is replaced by the result, so a const costs nothing at run time.
+// Integer constants also serve as stack capacities and compute array
+// sizes. Constants are declared at the top level, before use.
+func (p *Parser) parseConstDecl() (ast.Stmt, error) {
+	p.advance() // consume 'const'
+	nameTok := p.advance()
+	p.advance() // consume '='
+	if p.consts[nameTok.Value] != nil {
+		return nil, errorAt(nameTok, "const %s already defined", nameTok.Value)
+	}
+	valTok := p.peek()
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	val, err := foldConst(expr)
+	if err != nil {
+		return nil, errorAt(valTok, "const %s: %v", nameTok.Value, err)
+	}
+	if p.consts == nil {
+		p.consts = make(map[string]ast.Expr)
+	}
+	p.consts[nameTok.Value] = val
+	return nil, nil
+}
+
+// constValue returns a fresh copy of the literal value of const name
+func (p *Parser) constValue(name string) (ast.Expr, bool) {
+	switch v := p.consts[name].(type) {
+	case *ast.IntLit:
+		return &ast.IntLit{Value: v.Value}, true
+	case *ast.FloatLit:
+		return &ast.FloatLit{Value: v.Value}, true
+	case *ast.StringLit:
+		return &ast.StringLit{Value: v.Value}, true
+	case *ast.BoolLit:
+		return &ast.BoolLit{Value: v.Value}, true
+	}
+	return nil, false
+}
+
+// intLit converts an integer literal token. Literals above the i64 range
+// are kept as their u64 bit pattern, so var x u64 = 18446744073709551615
+// holds the full value; literals above the u64 range are an error
+func intLit(tok lexer.Token) (ast.Expr, error) {
+	val, err := strconv.ParseUint(tok.Value, 10, 64)
+	if err != nil {
+		return nil, errorAt(tok, "integer literal %s is out of range for u64", tok.Value)
+	}
+	return &ast.IntLit{Value: int64(val)}, nil
+}
+
+// intConst parses an integer literal or integer const, as where a size is
+// expected
+func (p *Parser) intConst(what string) (int64, error) {
+	tok := p.advance()
+	switch tok.Type {
+	case lexer.TokInt:
+		n, err := strconv.ParseInt(tok.Value, 10, 64)
+		if err != nil {
+			return 0, errorAt(tok, "%s %s is out of range", what, tok.Value)
+		}
+		return n, nil
+	case lexer.TokIdent:
+		if lit, ok := p.consts[tok.Value].(*ast.IntLit); ok {
+			return lit.Value, nil
+		}
+	}
+	return 0, errorAt(tok, "%s must be an integer literal or const", what)
+}
+
+// foldConst evaluates a constant expression to a literal. The operators
+// and builtins are applied by pkg/eval, as iual applies them, once the
+// operands are known to be of types they take.
+func foldConst(e ast.Expr) (ast.Expr, error) {
+	switch v := e.(type) {
+	case *ast.IntLit, *ast.FloatLit, *ast.StringLit, *ast.BoolLit:
+		return v, nil
+	case *ast.UnaryExpr:
+		x, err := foldConst(v.Operand)
+		if err != nil {
+			return nil, err
+		}
+		if !isNumber(x) || v.Op != "-" {
+			return nil, fmt.Errorf("cannot apply %s to a constant of that type", v.Op)
+		}
+		return constEval(&ast.UnaryExpr{Op: v.Op, Operand: x})
+	case *ast.BinaryOp:
+		l, err := foldConst(v.Left)
+		if err != nil {
+			return nil, err
+		}
+		r, err := foldConst(v.Right)
+		if err != nil {
+			return nil, err
+		}
+		_, lStr := l.(*ast.StringLit)
+		_, rStr := r.(*ast.StringLit)
+		arith := strings.Contains("+-*/%", v.Op) && isNumber(l) && isNumber(r)
+		if !arith && !(v.Op == "+" && lStr && rStr) {
+			return nil, fmt.Errorf("cannot apply %s to constants of those types", v.Op)
+		}
+		return constEval(&ast.BinaryOp{Left: l, Op: v.Op, Right: r})
+	case *ast.FuncCall:
+		if eval.Pure(v.Name) {
+			args := make([]ast.Expr, len(v.Args))
+			for idx, arg := range v.Args {
+				lit, err := foldConst(arg)
+				if err != nil {
+					return nil, err
+				}
+				args[idx] = lit
+			}
+			return constEval(&ast.FuncCall{Name: v.Name, Args: args})
+		}
+	}
+	return nil, eval.ErrNotConstant
+}
+
+// constEval evaluates e, whose operands are literals, to a literal
+func constEval(e ast.Expr) (ast.Expr, error) {
+	val, err := eval.Const(e, nil)
+	if err != nil {
+		return nil, err
+	}
+	if val.Type == runtime.VTFloat && (math.IsNaN(val.AsFloat()) || math.IsInf(val.AsFloat(), 0)) {
+		return nil, fmt.Errorf("%s is not a finite number", val.AsString())
+	}
+	lit, ok := eval.Literal(val)
+	if !ok {
+		return nil, eval.ErrNotConstant
+	}
+	return lit, nil
+}
+
+// isNumber reports whether e is a numeric literal
+func isNumber(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.IntLit, *ast.FloatLit:
+		return true
+	}
+	return false
+}
+
+// identOps are the stack operations whose names are not keywords
+var identOps = map[string]bool{
+	"clear": true, "len": true, "has": true, "del": true, "msg": true,
+	"pmap": true, "bridge": true, "pack": true, "unpack": true, "from_json": true,
+	"b64encode": true, "b64decode": true, "hexencode": true, "hexdecode": true,
+	"concat": true, "split": true, "strlen": true, "substr": true,
+	"contains": true, "upper": true, "lower": true,
+}
+
+// appendOp appends op to ops, or, if op uses a word, the word's operations
+// on op's stack
+func (p *Parser) appendOp(ops []ast.Stmt, op *ast.StackOp) []ast.Stmt {
+	word := p.words[op.Op]
+	if word == nil || len(op.Args) > 0 || op.Target != "" {
+		return append(ops, op)
+	}
+	for _, w := range word {
+		inlined := *w
+		inlined.Stack = op.Stack
+		ops = append(ops, &inlined)
+	}
+	return ops
+}
+
 // Parse a single operation: op(args) or op:arg or op
 func (p *Parser) parseOperation(stackName string, inBlock bool) (*ast.StackOp, error) {
 	tok := p.peek()
@@ -521,7 +1023,7 @@ func (p *Parser) parseOperation(stackName string, inBlock bool) (*ast.StackOp, e
 			p.advance() // consume :
 			varTok, err := p.expect(lexer.TokIdent)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: expected variable name after %s():", p.peek().Line, op)
+				return nil, errorAt(p.peek(), "expected variable name after %s():", op)
 			}
 			target = varTok.Value
 		}
@@ -534,7 +1036,7 @@ func (p *Parser) parseOperation(stackName string, inBlock bool) (*ast.StackOp, e
 		if op == "pop" || op == "take" {
 			varTok, err := p.expect(lexer.TokIdent)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: expected variable name after %s:", p.peek().Line, op)
+				return nil, errorAt(p.peek(), "expected variable name after %s:", op)
 			}
 			target = varTok.Value
 		} else {
@@ -588,14 +1090,14 @@ func (p *Parser) parseLocalStackDecl() (ast.Stmt, error) {
 	// Expect @name
 	stackTok, err := p.expect(lexer.TokStackRef)
 	if err != nil {
-		return nil, fmt.Errorf("line %d: expected @stackname after 'local'", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected @stackname after 'local'")
 	}
 	name := stackTok.Value
 	
 	// Expect =
 	_, err = p.expect(lexer.TokEquals)
 	if err != nil {
-		return nil, fmt.Errorf("line %d: expected '=' after local @%s", p.peek().Line, name)
+		return nil, errorAt(p.peek(), "expected '=' after local @%s", name)
 	}
 	
 	// Parse stack.new(...)
@@ -610,7 +1112,38 @@ func (p *Parser) parseLocalStackDecl() (ast.Stmt, error) {
 		return sd, nil
 	}
 	
-	return nil, fmt.Errorf("line %d: local declaration must be a stack.new()", p.peek().Line)
+	return nil, errorAt(p.peek(), "local declaration must be a stack.new()")
+}
+
+// parseEmbedDecl parses embed "file" as @name, declaring a stack that
+// holds the file: as one bytes element, one string with string after the
+// name, or a string per line, FIFO, with lines.
+func (p *Parser) parseEmbedDecl() (ast.Stmt, error) {
+	p.advance() // consume 'embed'
+	path := p.advance()
+	if as := p.peek(); as.Type != lexer.TokIdent || as.Value != "as" {
+		return nil, errorAt(as, "expected 'as @name' after embed %q", path.Value)
+	}
+	p.advance()
+	stackTok, err := p.expect(lexer.TokStackRef)
+	if err != nil {
+		return nil, errorAt(p.peek(), "expected @stackname after 'as'")
+	}
+	
+	decl := &ast.StackDecl{Name: stackTok.Value, ElementType: "bytes", Embed: &ast.Embed{Path: path.Value}}
+	switch tok := p.peek(); {
+	case tok.Type == lexer.TokBytes:
+		p.advance()
+	case tok.Type == lexer.TokStringType:
+		p.advance()
+		decl.ElementType = "string"
+	case tok.Type == lexer.TokIdent && tok.Value == "lines":
+		p.advance()
+		decl.ElementType = "string"
+		decl.Perspective = "FIFO"
+		decl.Embed.Lines = true
+	}
+	return decl, nil
 }
 
 func (p *Parser) parseStackDecl(name string) (ast.Stmt, error) {
@@ -635,16 +1168,23 @@ func (p *Parser) parseStackDecl(name string) (ast.Stmt, error) {
 		return nil, err
 	}
 	
-	// Type
-	typeTok := p.advance()
-	elemType := typeTok.Value
-	
 	decl := &ast.StackDecl{
 		Name:        name,
-		ElementType: elemType,
 		Perspective: "LIFO",
 	}
 	
+	// Type, or {field: type, ...} for a struct element
+	if p.peek().Type == lexer.TokLBrace {
+		fields, err := p.parseStructFields()
+		if err != nil {
+			return nil, err
+		}
+		decl.ElementType = "struct"
+		decl.Fields = fields
+	} else {
+		decl.ElementType = p.advance().Value
+	}
+	
 	// Optional: cap, perspective
 	for p.peek().Type == lexer.TokComma {
 		p.advance() // consume ,
@@ -656,13 +1196,14 @@ func (p *Parser) parseStackDecl(name string) (ast.Stmt, error) {
 			if err != nil {
 				return nil, err
 			}
-			capTok, err := p.expect(lexer.TokInt)
+			capacity, err := p.intConst("capacity")
 			if err != nil {
 				return nil, err
 			}
-			fmt.Sscanf(capTok.Value, "%d", &decl.Capacity)
+			decl.Capacity = int(capacity)
 		} else if optTok.Type == lexer.TokLIFO || optTok.Type == lexer.TokFIFO || 
-		          optTok.Type == lexer.TokIndexed || optTok.Type == lexer.TokHash {
+		          optTok.Type == lexer.TokIndexed || optTok.Type == lexer.TokHash ||
+		          optTok.Type == lexer.TokBroadcast {
 			p.advance()
 			decl.Perspective = optTok.Value
 		}
@@ -676,6 +1217,86 @@ func (p *Parser) parseStackDecl(name string) (ast.Stmt, error) {
 	return decl, nil
 }
 
+// parseStructFields: {x: f64, y: f64, alive: bool}
+func (p *Parser) parseStructFields() ([]ast.StructField, error) {
+	open := p.advance() // consume {
+	var fields []ast.StructField
+	seen := make(map[string]bool)
+	for p.skipNewlines(); p.peek().Type != lexer.TokRBrace; p.skipNewlines() {
+		nameTok, err := p.expect(lexer.TokIdent)
+		if err != nil {
+			return nil, err
+		}
+		if seen[nameTok.Value] {
+			return nil, errorAt(nameTok, "duplicate struct field %s", nameTok.Value)
+		}
+		seen[nameTok.Value] = true
+		if _, err := p.expect(lexer.TokColon); err != nil {
+			return nil, err
+		}
+		typeTok := p.advance()
+		switch typeTok.Type {
+		case lexer.TokI64, lexer.TokU64, lexer.TokF64, lexer.TokBool:
+		default:
+			return nil, errorAt(typeTok, "struct field %s must be i64, u64, f64 or bool, not %s",
+				nameTok.Value, typeTok.Value)
+		}
+		fields = append(fields, ast.StructField{Name: nameTok.Value, Type: typeTok.Value})
+		p.skipNewlines()
+		if p.peek().Type != lexer.TokComma {
+			break
+		}
+		p.advance()
+	}
+	if _, err := p.expect(lexer.TokRBrace); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errorAt(open, "a struct needs at least one field")
+	}
+	return fields, nil
+}
+
+// isRecordLit reports whether a { starts a record literal, {name: value},
+// rather than a codeblock
+func (p *Parser) isRecordLit() bool {
+	n := 1
+	for p.peekAhead(n).Type == lexer.TokNewline {
+		n++
+	}
+	return p.peekAhead(n).Type == lexer.TokIdent && p.peekAhead(n+1).Type == lexer.TokColon
+}
+
+// parseRecordLit: {x: 1.0, y: 2.0}, with values parsed by value
+func (p *Parser) parseRecordLit(value func() (ast.Expr, error)) (ast.Expr, error) {
+	p.advance() // consume {
+	rec := &ast.RecordLit{}
+	for p.skipNewlines(); p.peek().Type != lexer.TokRBrace; p.skipNewlines() {
+		nameTok, err := p.expect(lexer.TokIdent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(lexer.TokColon); err != nil {
+			return nil, err
+		}
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		rec.Names = append(rec.Names, nameTok.Value)
+		rec.Values = append(rec.Values, v)
+		p.skipNewlines()
+		if p.peek().Type != lexer.TokComma {
+			break
+		}
+		p.advance()
+	}
+	if _, err := p.expect(lexer.TokRBrace); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
 // parseVarDecl: var name type = value
 // or: var name, name2 type = value, value2
 // or: var name, name2 type (zero init)
@@ -688,7 +1309,7 @@ func (p *Parser) parseVarDecl() (ast.Stmt, error) {
 	for {
 		nameTok, err := p.expect(lexer.TokIdent)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: expected variable name", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected variable name")
 		}
 		names = append(names, nameTok.Value)
 		
@@ -705,7 +1326,7 @@ func (p *Parser) parseVarDecl() (ast.Stmt, error) {
 	// Check for type or equals
 	next := p.peek()
 	
-	if isTypeToken(next.Type) {
+	if isTypeToken(next.Type) || next.Type == lexer.TokIdent && containsName(p.typeParams, next.Value) {
 		// Explicit type
 		typeName = next.Value
 		p.advance()
@@ -724,7 +1345,7 @@ func (p *Parser) parseVarDecl() (ast.Stmt, error) {
 					if p.peek().Type == lexer.TokComma {
 						p.advance()
 					} else {
-						return nil, fmt.Errorf("line %d: expected %d values for %d variables", p.peek().Line, len(names), len(names))
+						return nil, errorAt(p.peek(), "expected %d values for %d variables", len(names), len(names))
 					}
 				}
 			}
@@ -743,12 +1364,12 @@ func (p *Parser) parseVarDecl() (ast.Stmt, error) {
 				if p.peek().Type == lexer.TokComma {
 					p.advance()
 				} else {
-					return nil, fmt.Errorf("line %d: expected %d values for %d variables", p.peek().Line, len(names), len(names))
+					return nil, errorAt(p.peek(), "expected %d values for %d variables", len(names), len(names))
 				}
 			}
 		}
 	} else {
-		return nil, fmt.Errorf("line %d: expected type or = in var declaration", next.Line)
+		return nil, errorAt(next, "expected type or = in var declaration")
 	}
 	
 	return &ast.VarDecl{Names: names, Type: typeName, Values: values}, nil
@@ -760,14 +1381,14 @@ func (p *Parser) parseLetAssign(stack string) (ast.Stmt, error) {
 	
 	// Expect colon
 	if p.peek().Type != lexer.TokColon {
-		return nil, fmt.Errorf("line %d: expected ':' after let", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected ':' after let")
 	}
 	p.advance() // consume ':'
 	
 	// Expect name
 	nameTok, err := p.expect(lexer.TokIdent)
 	if err != nil {
-		return nil, fmt.Errorf("line %d: expected variable name after let:", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected variable name after let:")
 	}
 	
 	return &ast.LetAssign{Name: nameTok.Value, Stack: stack}, nil
@@ -854,13 +1475,56 @@ func (p *Parser) parseWhileStmt() (ast.Stmt, error) {
 	}, nil
 }
 
+// parseRangeStmt: for i in start..end { body }
+func (p *Parser) parseRangeStmt() (ast.Stmt, error) {
+	r, err := p.parseRangeHeader(p.parseExpr)
+	if err != nil {
+		return nil, err
+	}
+	r.Body, err = p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseRangeHeader parses for i in start..end up to the body, reading the
+// bounds with parseBound
+func (p *Parser) parseRangeHeader(parseBound func() (ast.Expr, error)) (*ast.RangeStmt, error) {
+	p.advance() // consume 'for'
+	
+	name, err := p.expect(lexer.TokIdent)
+	if err != nil {
+		return nil, errorAt(p.peek(), "expected loop variable after for")
+	}
+	if in := p.peek(); in.Type != lexer.TokIdent || in.Value != "in" {
+		return nil, errorAt(in, "expected 'in' after for %s", name.Value)
+	}
+	p.advance() // consume 'in'
+	
+	start, err := parseBound()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().Type != lexer.TokDotDot {
+		return nil, errorAt(p.peek(), "expected '..' in range")
+	}
+	p.advance() // consume '..'
+	end, err := parseBound()
+	if err != nil {
+		return nil, err
+	}
+	
+	return &ast.RangeStmt{Var: name.Value, Start: start, End: end}, nil
+}
+
 // parseForStmt: @stack for{ body } or @stack for{|v| body } or @stack.fifo for{|i,v| body }
 func (p *Parser) parseForStmt(stack, perspective string) (ast.Stmt, error) {
 	p.advance() // consume 'for'
 	
 	// Expect {
 	if p.peek().Type != lexer.TokLBrace {
-		return nil, fmt.Errorf("line %d: expected '{' after for", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' after for")
 	}
 	p.advance() // consume '{'
 	
@@ -881,7 +1545,7 @@ func (p *Parser) parseForStmt(stack, perspective string) (ast.Stmt, error) {
 		}
 		
 		if p.peek().Type != lexer.TokPipe {
-			return nil, fmt.Errorf("line %d: expected '|' to close params", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '|' to close params")
 		}
 		p.advance() // consume closing |
 	}
@@ -902,7 +1566,7 @@ func (p *Parser) parseForStmt(stack, perspective string) (ast.Stmt, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRBrace {
-		return nil, fmt.Errorf("line %d: expected '}' to close for block", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '}' to close for block")
 	}
 	p.advance() // consume '}'
 	
@@ -921,27 +1585,45 @@ func (p *Parser) parseFuncDecl(canFail bool) (ast.Stmt, error) {
 	// Function name
 	nameTok, err := p.expect(lexer.TokIdent)
 	if err != nil {
-		return nil, fmt.Errorf("line %d: expected function name", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected function name")
 	}
 	
 	// Parameters
 	if p.peek().Type != lexer.TokLParen {
-		return nil, fmt.Errorf("line %d: expected '(' after function name", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '(' after function name")
 	}
 	p.advance() // consume '('
 	
 	var params []ast.FuncParam
+	var typeParams []string
 	for p.peek().Type != lexer.TokRParen && p.peek().Type != lexer.TokEOF {
+		// @name stack(type): a stack parameter. An element type that is not
+		// a type names a type parameter, bound by each call.
+		if p.peek().Type == lexer.TokStackRef {
+			param, generic, err := p.parseStackParam()
+			if err != nil {
+				return nil, err
+			}
+			if generic && !containsName(typeParams, param.Type) {
+				typeParams = append(typeParams, param.Type)
+			}
+			params = append(params, param)
+			if p.peek().Type == lexer.TokComma {
+				p.advance()
+			}
+			continue
+		}
+		
 		// param name
 		paramName, err := p.expect(lexer.TokIdent)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: expected parameter name", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected parameter name")
 		}
 		
 		// param type
 		paramType := p.advance()
 		if !isTypeToken(paramType.Type) && paramType.Type != lexer.TokIdent {
-			return nil, fmt.Errorf("line %d: expected parameter type", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected parameter type")
 		}
 		
 		params = append(params, ast.FuncParam{Name: paramName.Value, Type: paramType.Value})
@@ -952,7 +1634,7 @@ func (p *Parser) parseFuncDecl(canFail bool) (ast.Stmt, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRParen {
-		return nil, fmt.Errorf("line %d: expected ')' after parameters", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected ')' after parameters")
 	}
 	p.advance() // consume ')'
 	
@@ -963,8 +1645,11 @@ func (p *Parser) parseFuncDecl(canFail bool) (ast.Stmt, error) {
 		returnType = retTok.Value
 	}
 	
-	// Body
+	// Body, where the type parameters can be used as types
+	saved := p.typeParams
+	p.typeParams = typeParams
 	body, err := p.parseBlock()
+	p.typeParams = saved
 	if err != nil {
 		return nil, err
 	}
@@ -974,10 +1659,41 @@ func (p *Parser) parseFuncDecl(canFail bool) (ast.Stmt, error) {
 		Params:     params,
 		ReturnType: returnType,
 		CanFail:    canFail,
+		TypeParams: typeParams,
 		Body:       body,
 	}, nil
 }
 
+// parseStackParam parses a stack parameter, @name stack(type). generic
+// reports whether the element type is a type parameter.
+func (p *Parser) parseStackParam() (param ast.FuncParam, generic bool, err error) {
+	name := p.advance().Value
+	if p.peek().Type != lexer.TokStack {
+		return param, false, errorAt(p.peek(), "expected stack(type) after parameter @%s", name)
+	}
+	p.advance()
+	if _, err := p.expect(lexer.TokLParen); err != nil {
+		return param, false, err
+	}
+	typ := p.advance()
+	if !isTypeToken(typ.Type) && typ.Type != lexer.TokIdent {
+		return param, false, errorAt(typ, "expected element type of parameter @%s", name)
+	}
+	if _, err := p.expect(lexer.TokRParen); err != nil {
+		return param, false, err
+	}
+	return ast.FuncParam{Name: name, Type: typ.Value, Stack: true}, typ.Type == lexer.TokIdent, nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 // parseReturnStmt: return or return expr
 func (p *Parser) parseReturnStmt() (ast.Stmt, error) {
 	p.advance() // consume 'return'
@@ -1046,14 +1762,14 @@ func (p *Parser) parseStatusStmt() (ast.Stmt, error) {
 	
 	// Expect colon
 	if p.peek().Type != lexer.TokColon {
-		return nil, fmt.Errorf("line %d: expected ':' after status", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected ':' after status")
 	}
 	p.advance() // consume ':'
 	
 	// Parse label (identifier)
 	labelTok := p.peek()
 	if labelTok.Type != lexer.TokIdent {
-		return nil, fmt.Errorf("line %d: expected status label", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected status label")
 	}
 	label := p.advance().Value
 	
@@ -1067,7 +1783,7 @@ func (p *Parser) parseStatusStmt() (ast.Stmt, error) {
 			return nil, err
 		}
 		if p.peek().Type != lexer.TokRParen {
-			return nil, fmt.Errorf("line %d: expected ')' after status value", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected ')' after status value")
 		}
 		p.advance() // consume ')'
 	}
@@ -1083,7 +1799,7 @@ func (p *Parser) parseTryStmt() (ast.Stmt, error) {
 	
 	// Parse try body
 	if _, err := p.expect(lexer.TokLBrace); err != nil {
-		return nil, fmt.Errorf("line %d: expected '{' after try", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' after try")
 	}
 	p.skipNewlines()
 	
@@ -1100,7 +1816,7 @@ func (p *Parser) parseTryStmt() (ast.Stmt, error) {
 	}
 	
 	if _, err := p.expect(lexer.TokRBrace); err != nil {
-		return nil, fmt.Errorf("line %d: expected '}' to close try block", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '}' to close try block")
 	}
 	p.skipNewlines()
 	
@@ -1118,18 +1834,18 @@ func (p *Parser) parseTryStmt() (ast.Stmt, error) {
 			p.advance() // consume '|'
 			nameTok, err := p.expect(lexer.TokIdent)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: expected identifier in catch binding", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected identifier in catch binding")
 			}
 			errName = nameTok.Value
 			if _, err := p.expect(lexer.TokPipe); err != nil {
-				return nil, fmt.Errorf("line %d: expected '|' to close catch binding", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected '|' to close catch binding")
 			}
 			p.skipNewlines()
 		}
 		
 		// Parse catch body
 		if _, err := p.expect(lexer.TokLBrace); err != nil {
-			return nil, fmt.Errorf("line %d: expected '{' after catch", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '{' after catch")
 		}
 		p.skipNewlines()
 		
@@ -1145,7 +1861,7 @@ func (p *Parser) parseTryStmt() (ast.Stmt, error) {
 		}
 		
 		if _, err := p.expect(lexer.TokRBrace); err != nil {
-			return nil, fmt.Errorf("line %d: expected '}' to close catch block", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '}' to close catch block")
 		}
 		p.skipNewlines()
 	}
@@ -1157,7 +1873,7 @@ func (p *Parser) parseTryStmt() (ast.Stmt, error) {
 		
 		// Parse finally body
 		if _, err := p.expect(lexer.TokLBrace); err != nil {
-			return nil, fmt.Errorf("line %d: expected '{' after finally", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '{' after finally")
 		}
 		p.skipNewlines()
 		
@@ -1173,13 +1889,13 @@ func (p *Parser) parseTryStmt() (ast.Stmt, error) {
 		}
 		
 		if _, err := p.expect(lexer.TokRBrace); err != nil {
-			return nil, fmt.Errorf("line %d: expected '}' to close finally block", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '}' to close finally block")
 		}
 	}
 	
 	// Must have catch or finally (or both)
 	if len(catchBody) == 0 && len(finallyBody) == 0 {
-		return nil, fmt.Errorf("line %d: try must have catch or finally block", p.peek().Line)
+		return nil, errorAt(p.peek(), "try must have catch or finally block")
 	}
 	
 	return &ast.TryStmt{
@@ -1215,7 +1931,7 @@ func (p *Parser) parseSpawnOp() (ast.Stmt, error) {
 			op = p.advance().Value // "len", "clear", etc.
 		default:
 			if len(ops) == 0 {
-				return nil, fmt.Errorf("line %d: expected operation after @spawn (got %v)", tok.Line, tok.Type)
+				return nil, errorAt(tok, "expected operation after @spawn (got %v)", tok.Type)
 			}
 			break // Not a spawn op, end parsing
 		}
@@ -1258,44 +1974,138 @@ func (p *Parser) parseSpawnOp() (ast.Stmt, error) {
 				}
 			}
 		}
-		
-		ops = append(ops, &ast.SpawnOp{Op: op, Play: play, Args: args})
+		
+		// @spawn wait(ms) - optional timeout
+		if op == "wait" && p.peek().Type == lexer.TokLParen {
+			p.advance() // consume '('
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, err := p.expect(lexer.TokRParen); err != nil {
+				return nil, err
+			}
+		}
+		
+		ops = append(ops, &ast.SpawnOp{Op: op, Play: play, Args: args})
+	}
+	
+	if len(ops) == 0 {
+		return nil, errorAt(p.peek(), "expected operation after @spawn")
+	}
+	
+	if len(ops) == 1 {
+		return ops[0], nil
+	}
+	
+	// Multiple ops - wrap in a block
+	stmts := make([]ast.Stmt, len(ops))
+	for i, op := range ops {
+		stmts[i] = op
+	}
+	return &ast.Block{Stmts: stmts}, nil
+}
+
+// parseCondition: (cond), where cond is expr op expr, expr alone, or
+// conditions combined with &&, || and !
+func (p *Parser) parseCondition() (ast.Expr, error) {
+	// Expect opening paren
+	if p.peek().Type != lexer.TokLParen {
+		return nil, errorAt(p.peek(), "expected '(' for condition")
+	}
+	p.advance() // consume '('
+	
+	cond, err := p.parseOrCond()
+	if err != nil {
+		return nil, err
+	}
+	
+	// Expect closing paren
+	if p.peek().Type != lexer.TokRParen {
+		if _, ok := cond.(*ast.BinaryExpr); !ok {
+			return nil, errorAt(p.peek(), "expected ')' or comparison operator")
+		}
+		return nil, errorAt(p.peek(), "expected ')' after condition")
+	}
+	p.advance() // consume ')'
+	
+	return cond, nil
+}
+
+// parseOrCond: cond || cond ...
+// Conditions bind tightest at comparisons, then !, then &&, then ||.
+func (p *Parser) parseOrCond() (ast.Expr, error) {
+	left, err := p.parseAndCond()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().Type == lexer.TokBarBar {
+		p.advance() // consume ||
+		right, err := p.parseAndCond()
+		if err != nil {
+			return nil, err
+		}
+		left = &ast.BinaryExpr{Left: left, Op: "||", Right: right}
 	}
-	
-	if len(ops) == 0 {
-		return nil, fmt.Errorf("line %d: expected operation after @spawn", p.peek().Line)
+	return left, nil
+}
+
+// parseAndCond: cond && cond ...
+func (p *Parser) parseAndCond() (ast.Expr, error) {
+	left, err := p.parseNotCond()
+	if err != nil {
+		return nil, err
 	}
-	
-	if len(ops) == 1 {
-		return ops[0], nil
+	for p.peek().Type == lexer.TokAmpAmp {
+		p.advance() // consume &&
+		right, err := p.parseNotCond()
+		if err != nil {
+			return nil, err
+		}
+		left = &ast.BinaryExpr{Left: left, Op: "&&", Right: right}
 	}
-	
-	// Multiple ops - wrap in a block
-	stmts := make([]ast.Stmt, len(ops))
-	for i, op := range ops {
-		stmts[i] = op
+	return left, nil
+}
+
+// parseNotCond: !cond or a comparison
+func (p *Parser) parseNotCond() (ast.Expr, error) {
+	if p.peek().Type == lexer.TokBang {
+		p.advance() // consume !
+		operand, err := p.parseNotCond()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.UnaryExpr{Op: "!", Operand: operand}, nil
 	}
-	return &ast.Block{Stmts: stmts}, nil
+	return p.parseComparison()
 }
 
-// parseCondition: (expr op expr) or (expr)
-func (p *Parser) parseCondition() (ast.Expr, error) {
-	// Expect opening paren
-	if p.peek().Type != lexer.TokLParen {
-		return nil, fmt.Errorf("line %d: expected '(' for condition", p.peek().Line)
+// parseComparison: expr op expr, expr alone (a truthy check), or a
+// parenthesised condition. A parenthesis followed by anything but the end
+// of the condition, as in (a + b) > c, opens an expression instead.
+func (p *Parser) parseComparison() (ast.Expr, error) {
+	if p.peek().Type == lexer.TokLParen {
+		save := p.pos
+		p.advance() // consume '('
+		cond, err := p.parseOrCond()
+		if err == nil && p.peek().Type == lexer.TokRParen {
+			p.advance() // consume ')'
+			switch p.peek().Type {
+			case lexer.TokRParen, lexer.TokAmpAmp, lexer.TokBarBar:
+				return cond, nil
+			}
+		}
+		p.pos = save
 	}
-	p.advance() // consume '('
 	
-	// Parse left operand
 	left, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 	
-	// Check for comparison operator
-	tok := p.peek()
 	var op string
-	switch tok.Type {
+	switch p.peek().Type {
 	case lexer.TokSymGt:
 		op = ">"
 	case lexer.TokSymLt:
@@ -1310,27 +2120,14 @@ func (p *Parser) parseCondition() (ast.Expr, error) {
 		op = "!="
 	default:
 		// Just a single expression (truthy check)
-		if p.peek().Type != lexer.TokRParen {
-			return nil, fmt.Errorf("line %d: expected ')' or comparison operator", p.peek().Line)
-		}
-		p.advance() // consume ')'
 		return left, nil
 	}
-	
 	p.advance() // consume operator
 	
-	// Parse right operand
 	right, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
-	
-	// Expect closing paren
-	if p.peek().Type != lexer.TokRParen {
-		return nil, fmt.Errorf("line %d: expected ')' after condition", p.peek().Line)
-	}
-	p.advance() // consume ')'
-	
 	return &ast.BinaryExpr{Left: left, Op: op, Right: right}, nil
 }
 
@@ -1339,7 +2136,7 @@ func (p *Parser) parseBlock() ([]ast.Stmt, error) {
 	p.skipNewlines()
 	
 	if p.peek().Type != lexer.TokLBrace {
-		return nil, fmt.Errorf("line %d: expected '{' for block", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' for block")
 	}
 	p.advance() // consume '{'
 	
@@ -1354,14 +2151,16 @@ func (p *Parser) parseBlock() ([]ast.Stmt, error) {
 		}
 		
 		if p.peek().Type == lexer.TokEOF {
-			return nil, fmt.Errorf("unexpected end of file, expected '}'")
+			return nil, errorAt(p.peek(), "unexpected end of file, expected '}'")
 		}
 		
+		start := p.pos
 		stmt, err := p.parseStmt()
 		if err != nil {
-			return nil, err
-		}
-		if stmt != nil {
+			if !p.recover(start, err) {
+				return nil, err
+			}
+		} else if stmt != nil {
 			stmts = append(stmts, stmt)
 		}
 	}
@@ -1375,7 +2174,7 @@ func (p *Parser) parseConsider(block *ast.StackBlock) (*ast.ConsiderStmt, error)
 	p.advance() // consume 'consider'
 	
 	if p.peek().Type != lexer.TokLParen {
-		return nil, fmt.Errorf("line %d: expected '(' after 'consider'", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '(' after 'consider'")
 	}
 	p.advance() // consume '('
 	
@@ -1406,7 +2205,7 @@ func (p *Parser) parseConsider(block *ast.StackBlock) (*ast.ConsiderStmt, error)
 	
 	// Must have at least one case
 	if len(cases) == 0 {
-		return nil, fmt.Errorf("line %d: consider block requires at least one case", p.peek().Line)
+		return nil, errorAt(p.peek(), "consider block requires at least one case")
 	}
 	
 	return &ast.ConsiderStmt{Block: block, Cases: cases}, nil
@@ -1423,8 +2222,11 @@ func (p *Parser) parseConsiderCase() (*ast.ConsiderCase, error) {
 		label = p.advance().Value
 	case lexer.TokInt:
 		label = p.advance().Value
+	case lexer.TokTimeout:
+		// status set by @spawn wait(ms)
+		label = p.advance().Value
 	default:
-		return nil, fmt.Errorf("line %d: expected case label (identifier or integer)", tok.Line)
+		return nil, errorAt(tok, "expected case label (identifier or integer)")
 	}
 	
 	// Check for _ (default case)
@@ -1441,7 +2243,7 @@ func (p *Parser) parseConsiderCase() (*ast.ConsiderCase, error) {
 		// Parse binding names
 		for p.peek().Type != lexer.TokPipe && p.peek().Type != lexer.TokEOF {
 			if p.peek().Type != lexer.TokIdent {
-				return nil, fmt.Errorf("line %d: expected binding name", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected binding name")
 			}
 			bindings = append(bindings, p.advance().Value)
 			
@@ -1451,14 +2253,14 @@ func (p *Parser) parseConsiderCase() (*ast.ConsiderCase, error) {
 		}
 		
 		if p.peek().Type != lexer.TokPipe {
-			return nil, fmt.Errorf("line %d: expected '|' to close bindings", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '|' to close bindings")
 		}
 		p.advance() // consume closing |
 	}
 	
 	// Expect colon
 	if p.peek().Type != lexer.TokColon {
-		return nil, fmt.Errorf("line %d: expected ':' after case label", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected ':' after case label")
 	}
 	p.advance() // consume :
 	
@@ -1492,13 +2294,13 @@ func (p *Parser) parseConsiderCase() (*ast.ConsiderCase, error) {
 	}, nil
 }
 
-// parseSelect: .select( case, case, ... )
+// parseSelect: .select( case, case, ... ) or .select(fair: false, case, ...)
 // Parses the select block after a stack block
 func (p *Parser) parseSelect(block *ast.StackBlock) (*ast.SelectStmt, error) {
 	p.advance() // consume 'select'
 	
 	if p.peek().Type != lexer.TokLParen {
-		return nil, fmt.Errorf("line %d: expected '(' after 'select'", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '(' after 'select'")
 	}
 	p.advance() // consume '('
 	
@@ -1510,6 +2312,26 @@ func (p *Parser) parseSelect(block *ast.StackBlock) (*ast.SelectStmt, error) {
 		defaultStack = block.Stack
 	}
 	
+	// Optional fair: true|false before the cases
+	ordered := false
+	if p.peek().Type == lexer.TokIdent && p.peek().Value == "fair" && p.peekAhead(1).Type == lexer.TokColon {
+		p.advance() // consume fair
+		p.advance() // consume :
+		switch p.peek().Type {
+		case lexer.TokTrue:
+		case lexer.TokFalse:
+			ordered = true
+		default:
+			return nil, errorAt(p.peek(), "expected true or false after 'fair:'")
+		}
+		p.advance()
+		p.skipNewlines()
+		if p.peek().Type == lexer.TokComma {
+			p.advance()
+			p.skipNewlines()
+		}
+	}
+	
 	var cases []ast.SelectCase
 	
 	for p.peek().Type != lexer.TokRParen && p.peek().Type != lexer.TokEOF {
@@ -1535,13 +2357,14 @@ func (p *Parser) parseSelect(block *ast.StackBlock) (*ast.SelectStmt, error) {
 	
 	// Must have at least one case
 	if len(cases) == 0 {
-		return nil, fmt.Errorf("line %d: select block requires at least one case", p.peek().Line)
+		return nil, errorAt(p.peek(), "select block requires at least one case")
 	}
 	
-	return &ast.SelectStmt{Block: block, DefaultStack: defaultStack, Cases: cases}, nil
+	return &ast.SelectStmt{Block: block, DefaultStack: defaultStack, Cases: cases, Ordered: ordered}, nil
 }
 
 // parseSelectCase: @stack {|var| handler timeout(...)} or {|var| handler} or _: { default }
+// or every(ms) {|t| handler} or @signal SIGINT {|sig| handler}
 func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 	var stackName string
 	
@@ -1583,22 +2406,50 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 		}, nil
 	}
 	
-	// Check for @stack reference or use default
-	if tok.Type == lexer.TokStackRef {
+	kind := ast.SelectStack
+	var every ast.Expr
+	var signal string
+	
+	// Check for @signal NAME, every(ms), @stack reference or use default
+	if tok.Type == lexer.TokStackRef && tok.Value == "signal" && p.peekAhead(1).Type == lexer.TokIdent {
+		p.advance() // consume @signal
+		nameTok := p.advance()
+		name := strings.ToUpper(nameTok.Value)
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		if !selectSignals[name] {
+			return nil, errorAt(nameTok, "unknown signal '%s' in select case", nameTok.Value)
+		}
+		kind = ast.SelectSignal
+		signal = name
+	} else if tok.Type == lexer.TokIdent && tok.Value == "every" && p.peekAhead(1).Type == lexer.TokLParen {
+		p.advance() // consume every
+		p.advance() // consume (
+		msExpr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(lexer.TokRParen); err != nil {
+			return nil, err
+		}
+		kind = ast.SelectTimer
+		every = msExpr
+	} else if tok.Type == lexer.TokStackRef {
 		stackName = p.advance().Value
 	} else if tok.Type == lexer.TokLBrace {
 		// No stack specified, use default
 		stackName = defaultStack
 		if stackName == "" {
-			return nil, fmt.Errorf("line %d: no default stack for select case, must specify @stack", tok.Line)
+			return nil, errorAt(tok, "no default stack for select case, must specify @stack")
 		}
 	} else {
-		return nil, fmt.Errorf("line %d: expected @stack or '{' in select case", tok.Line)
+		return nil, errorAt(tok, "expected @stack, every(ms), @signal or '{' in select case")
 	}
 	
 	// Expect opening brace
 	if p.peek().Type != lexer.TokLBrace {
-		return nil, fmt.Errorf("line %d: expected '{' after stack reference in select case", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' after %s in select case", selectSourceName(kind))
 	}
 	p.advance() // consume {
 	
@@ -1613,7 +2464,7 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 		// Parse binding names
 		for p.peek().Type != lexer.TokPipe && p.peek().Type != lexer.TokEOF {
 			if p.peek().Type != lexer.TokIdent {
-				return nil, fmt.Errorf("line %d: expected binding name", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected binding name")
 			}
 			bindings = append(bindings, p.advance().Value)
 			
@@ -1623,7 +2474,7 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 		}
 		
 		if p.peek().Type != lexer.TokPipe {
-			return nil, fmt.Errorf("line %d: expected '|' to close bindings", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '|' to close bindings")
 		}
 		p.advance() // consume closing |
 	}
@@ -1641,7 +2492,7 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 			p.advance() // consume timeout
 			
 			if p.peek().Type != lexer.TokLParen {
-				return nil, fmt.Errorf("line %d: expected '(' after timeout", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected '(' after timeout")
 			}
 			p.advance() // consume (
 			
@@ -1659,7 +2510,7 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 				
 				// Parse the timeout handler closure: {|| ... }
 				if p.peek().Type != lexer.TokLBrace {
-					return nil, fmt.Errorf("line %d: expected '{' for timeout handler", p.peek().Line)
+					return nil, errorAt(p.peek(), "expected '{' for timeout handler")
 				}
 				
 				fnExpr, err := p.parseCodeblock()
@@ -1669,12 +2520,12 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 				if fn, ok := fnExpr.(*ast.FnLit); ok {
 					timeoutFn = fn
 				} else {
-					return nil, fmt.Errorf("line %d: timeout handler must be a closure", p.peek().Line)
+					return nil, errorAt(p.peek(), "timeout handler must be a closure")
 				}
 			}
 			
 			if p.peek().Type != lexer.TokRParen {
-				return nil, fmt.Errorf("line %d: expected ')' after timeout", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected ')' after timeout")
 			}
 			p.advance() // consume )
 			
@@ -1694,12 +2545,15 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRBrace {
-		return nil, fmt.Errorf("line %d: expected '}' to close select case", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '}' to close select case")
 	}
 	p.advance() // consume }
 	
 	return &ast.SelectCase{
+		Kind:      kind,
 		Stack:     stackName,
+		Every:     every,
+		Signal:    signal,
 		Bindings:  bindings,
 		Handler:   handler,
 		TimeoutMs: timeoutMs,
@@ -1707,19 +2561,37 @@ func (p *Parser) parseSelectCase(defaultStack string) (*ast.SelectCase, error) {
 	}, nil
 }
 
+// selectSignals are the signals a select case can wait on with @signal.
+// Names are normalised to their SIG-prefixed form by parseSelectCase.
+var selectSignals = map[string]bool{
+	"SIGHUP": true, "SIGINT": true, "SIGQUIT": true, "SIGTERM": true,
+	"SIGUSR1": true, "SIGUSR2": true, "SIGWINCH": true,
+}
+
+// selectSourceName describes a select case's source for error messages
+func selectSourceName(kind ast.SelectKind) string {
+	switch kind {
+	case ast.SelectTimer:
+		return "every(ms)"
+	case ast.SelectSignal:
+		return "@signal"
+	}
+	return "stack reference"
+}
+
 // parseCompute: .compute({|a, b| ... return x})
 func (p *Parser) parseCompute(block *ast.StackBlock) (*ast.ComputeStmt, error) {
 	p.advance() // consume 'compute'
 	
 	if p.peek().Type != lexer.TokLParen {
-		return nil, fmt.Errorf("line %d: expected '(' after compute", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '(' after compute")
 	}
 	p.advance() // consume (
 	
 	p.skipNewlines()
 	
 	if p.peek().Type != lexer.TokLBrace {
-		return nil, fmt.Errorf("line %d: expected '{' to start compute kernel", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' to start compute kernel")
 	}
 	p.advance() // consume {
 	
@@ -1737,7 +2609,7 @@ func (p *Parser) parseCompute(block *ast.StackBlock) (*ast.ComputeStmt, error) {
 		if p.peek().Type != lexer.TokPipe {
 			for p.peek().Type != lexer.TokPipe && p.peek().Type != lexer.TokEOF {
 				if p.peek().Type != lexer.TokIdent {
-					return nil, fmt.Errorf("line %d: expected binding name", p.peek().Line)
+					return nil, errorAt(p.peek(), "expected binding name")
 				}
 				params = append(params, p.advance().Value)
 				
@@ -1748,7 +2620,7 @@ func (p *Parser) parseCompute(block *ast.StackBlock) (*ast.ComputeStmt, error) {
 		}
 		
 		if p.peek().Type != lexer.TokPipe {
-			return nil, fmt.Errorf("line %d: expected '|' to close bindings", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '|' to close bindings")
 		}
 		p.advance() // consume closing |
 	}
@@ -1769,14 +2641,14 @@ func (p *Parser) parseCompute(block *ast.StackBlock) (*ast.ComputeStmt, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRBrace {
-		return nil, fmt.Errorf("line %d: expected '}' to close compute kernel", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '}' to close compute kernel")
 	}
 	p.advance() // consume }
 	
 	p.skipNewlines()
 	
 	if p.peek().Type != lexer.TokRParen {
-		return nil, fmt.Errorf("line %d: expected ')' to close compute", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected ')' to close compute")
 	}
 	p.advance() // consume )
 	
@@ -1818,6 +2690,11 @@ func (p *Parser) parseComputeStmt() (ast.Stmt, error) {
 		return p.parseComputeWhile()
 	}
 	
+	// for i in start..end { ... }
+	if tok.Type == lexer.TokFor {
+		return p.parseComputeRange()
+	}
+	
 	// break
 	if tok.Type == lexer.TokBreak {
 		p.advance()
@@ -1841,17 +2718,17 @@ func (p *Parser) parseComputeStmt() (ast.Stmt, error) {
 		
 		// Must be self.prop[i] = expr
 		if p.peek().Type != lexer.TokDot {
-			return nil, fmt.Errorf("line %d: expected '.' after self for assignment", tok.Line)
+			return nil, errorAt(tok, "expected '.' after self for assignment")
 		}
 		p.advance() // consume .
 		
 		if p.peek().Type != lexer.TokIdent {
-			return nil, fmt.Errorf("line %d: expected property name after self.", tok.Line)
+			return nil, errorAt(tok, "expected property name after self.")
 		}
 		member := p.advance().Value
 		
 		if p.peek().Type != lexer.TokLBracket {
-			return nil, fmt.Errorf("line %d: self.%s is read-only; use self.%s[i] for array write", tok.Line, member, member)
+			return nil, errorAt(tok, "self.%s is read-only; use self.%s[i] for array write", member, member)
 		}
 		p.advance() // consume [
 		
@@ -1861,12 +2738,12 @@ func (p *Parser) parseComputeStmt() (ast.Stmt, error) {
 		}
 		
 		if p.peek().Type != lexer.TokRBracket {
-			return nil, fmt.Errorf("line %d: expected ']' after index", tok.Line)
+			return nil, errorAt(tok, "expected ']' after index")
 		}
 		p.advance() // consume ]
 		
 		if p.peek().Type != lexer.TokEquals {
-			return nil, fmt.Errorf("line %d: expected '=' for assignment", tok.Line)
+			return nil, errorAt(tok, "expected '=' for assignment")
 		}
 		p.advance() // consume =
 		
@@ -1883,7 +2760,7 @@ func (p *Parser) parseComputeStmt() (ast.Stmt, error) {
 		}, nil
 	}
 	
-	return nil, fmt.Errorf("line %d: unexpected token '%s' in compute block", tok.Line, tok.Value)
+	return nil, errorAt(tok, "unexpected token '%s' in compute block", tok.Value)
 }
 
 // parseComputeVarDecl: var x = expr OR var buf[1024]
@@ -1891,7 +2768,7 @@ func (p *Parser) parseComputeVarDecl() (ast.Stmt, error) {
 	p.advance() // consume var
 	
 	if p.peek().Type != lexer.TokIdent {
-		return nil, fmt.Errorf("line %d: expected variable name after var", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected variable name after var")
 	}
 	name := p.advance().Value
 	
@@ -1899,14 +2776,13 @@ func (p *Parser) parseComputeVarDecl() (ast.Stmt, error) {
 	if p.peek().Type == lexer.TokLBracket {
 		p.advance() // consume [
 		
-		if p.peek().Type != lexer.TokInt {
-			return nil, fmt.Errorf("line %d: array size must be an integer literal", p.peek().Line)
+		size, err := p.intConst("array size")
+		if err != nil {
+			return nil, err
 		}
-		sizeStr := p.advance().Value
-		size, _ := strconv.ParseInt(sizeStr, 10, 64)
 		
 		if p.peek().Type != lexer.TokRBracket {
-			return nil, fmt.Errorf("line %d: expected ']' after array size", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected ']' after array size")
 		}
 		p.advance() // consume ]
 		
@@ -1918,7 +2794,7 @@ func (p *Parser) parseComputeVarDecl() (ast.Stmt, error) {
 	
 	// Regular variable: var x = expr
 	if p.peek().Type != lexer.TokEquals {
-		return nil, fmt.Errorf("line %d: expected '=' after variable name", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '=' after variable name")
 	}
 	p.advance() // consume =
 	
@@ -1971,7 +2847,7 @@ func (p *Parser) parseComputeIf() (ast.Stmt, error) {
 	p.skipNewlines()
 	
 	if p.peek().Type != lexer.TokLBrace {
-		return nil, fmt.Errorf("line %d: expected '{' after if condition", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' after if condition")
 	}
 	p.advance() // consume {
 	p.skipNewlines()
@@ -1989,7 +2865,7 @@ func (p *Parser) parseComputeIf() (ast.Stmt, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRBrace {
-		return nil, fmt.Errorf("line %d: expected '}' to close if block", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '}' to close if block")
 	}
 	p.advance() // consume }
 	
@@ -2001,7 +2877,7 @@ func (p *Parser) parseComputeIf() (ast.Stmt, error) {
 		p.skipNewlines()
 		
 		if p.peek().Type != lexer.TokLBrace {
-			return nil, fmt.Errorf("line %d: expected '{' after else", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '{' after else")
 		}
 		p.advance() // consume {
 		p.skipNewlines()
@@ -2018,7 +2894,7 @@ func (p *Parser) parseComputeIf() (ast.Stmt, error) {
 		}
 		
 		if p.peek().Type != lexer.TokRBrace {
-			return nil, fmt.Errorf("line %d: expected '}' to close else block", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '}' to close else block")
 		}
 		p.advance() // consume }
 	}
@@ -2042,7 +2918,7 @@ func (p *Parser) parseComputeWhile() (ast.Stmt, error) {
 	p.skipNewlines()
 	
 	if p.peek().Type != lexer.TokLBrace {
-		return nil, fmt.Errorf("line %d: expected '{' after while condition", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '{' after while condition")
 	}
 	p.advance() // consume {
 	p.skipNewlines()
@@ -2060,7 +2936,7 @@ func (p *Parser) parseComputeWhile() (ast.Stmt, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRBrace {
-		return nil, fmt.Errorf("line %d: expected '}' to close while block", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected '}' to close while block")
 	}
 	p.advance() // consume }
 	
@@ -2070,6 +2946,40 @@ func (p *Parser) parseComputeWhile() (ast.Stmt, error) {
 	}, nil
 }
 
+// parseComputeRange: for i in start..end { ... }
+func (p *Parser) parseComputeRange() (ast.Stmt, error) {
+	r, err := p.parseRangeHeader(p.parseInfixExpr)
+	if err != nil {
+		return nil, err
+	}
+	
+	p.skipNewlines()
+	
+	if p.peek().Type != lexer.TokLBrace {
+		return nil, errorAt(p.peek(), "expected '{' after range")
+	}
+	p.advance() // consume {
+	p.skipNewlines()
+	
+	for p.peek().Type != lexer.TokRBrace && p.peek().Type != lexer.TokEOF {
+		stmt, err := p.parseComputeStmt()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			r.Body = append(r.Body, stmt)
+		}
+		p.skipNewlines()
+	}
+	
+	if p.peek().Type != lexer.TokRBrace {
+		return nil, errorAt(p.peek(), "expected '}' to close for block")
+	}
+	p.advance() // consume }
+	
+	return r, nil
+}
+
 // parseComputeAssignOrExpr: x = expr, buf[i] = expr, or just expr
 func (p *Parser) parseComputeAssignOrExpr() (ast.Stmt, error) {
 	name := p.advance().Value
@@ -2082,12 +2992,12 @@ func (p *Parser) parseComputeAssignOrExpr() (ast.Stmt, error) {
 			return nil, err
 		}
 		if p.peek().Type != lexer.TokRBracket {
-			return nil, fmt.Errorf("line %d: expected ']' after index", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected ']' after index")
 		}
 		p.advance() // consume ]
 		
 		if p.peek().Type != lexer.TokEquals {
-			return nil, fmt.Errorf("line %d: expected '=' after indexed target", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '=' after indexed target")
 		}
 		p.advance() // consume =
 		
@@ -2144,7 +3054,7 @@ func (p *Parser) parseInfixOr() (ast.Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		left = &ast.BinaryExpr{Op: "or", Left: left, Right: right}
+		left = &ast.BinaryExpr{Op: "||", Left: left, Right: right}
 	}
 	return left, nil
 }
@@ -2161,7 +3071,7 @@ func (p *Parser) parseInfixAnd() (ast.Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		left = &ast.BinaryExpr{Op: "and", Left: left, Right: right}
+		left = &ast.BinaryExpr{Op: "&&", Left: left, Right: right}
 	}
 	return left, nil
 }
@@ -2276,10 +3186,16 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 	tok := p.peek()
 	
 	switch tok.Type {
+	case lexer.TokLBrace:
+		// return {x: sx, y: sy} on a struct stack
+		if p.isRecordLit() {
+			return p.parseRecordLit(p.parseInfixExpr)
+		}
+		return nil, errorAt(tok, "unexpected '{' in expression")
+		
 	case lexer.TokInt:
 		p.advance()
-		val, _ := strconv.ParseInt(tok.Value, 10, 64)
-		return &ast.IntLit{Value: val}, nil
+		return intLit(tok)
 		
 	case lexer.TokFloat:
 		p.advance()
@@ -2312,6 +3228,12 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 	case lexer.TokIdent:
 		p.advance()
 		name := tok.Value
+		if p.enums[name] != nil && p.peek().Type == lexer.TokDot {
+			return p.enumMember(tok)
+		}
+		if val, ok := p.constValue(name); ok && p.peek().Type != lexer.TokLParen {
+			return val, nil
+		}
 		// Check for function call: ident(args)
 		if p.peek().Type == lexer.TokLParen {
 			return p.parseInfixCall(name)
@@ -2321,10 +3243,10 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 			p.advance() // consume [
 			index, err := p.parseInfixExpr()
 			if err != nil {
-				return nil, fmt.Errorf("line %d: error parsing index: %v", tok.Line, err)
+				return nil, errorAt(tok, "error parsing index: %v", err)
 			}
 			if p.peek().Type != lexer.TokRBracket {
-				return nil, fmt.Errorf("line %d: expected ']' after index", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected ']' after index")
 			}
 			p.advance() // consume ]
 			return &ast.IndexExpr{Target: name, Index: index}, nil
@@ -2337,7 +3259,7 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 		if p.peek().Type == lexer.TokDot {
 			p.advance() // consume .
 			if p.peek().Type != lexer.TokIdent {
-				return nil, fmt.Errorf("line %d: expected member name after self.", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected member name after self.")
 			}
 			member := p.advance().Value
 			
@@ -2346,10 +3268,10 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 				p.advance() // consume [
 				index, err := p.parseInfixExpr()
 				if err != nil {
-					return nil, fmt.Errorf("line %d: error parsing index: %v", tok.Line, err)
+					return nil, errorAt(tok, "error parsing index: %v", err)
 				}
 				if p.peek().Type != lexer.TokRBracket {
-					return nil, fmt.Errorf("line %d: expected ']' after index", p.peek().Line)
+					return nil, errorAt(p.peek(), "expected ']' after index")
 				}
 				p.advance() // consume ]
 				return &ast.MemberIndexExpr{Target: "self", Member: member, Index: index}, nil
@@ -2360,15 +3282,21 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 			p.advance() // consume [
 			index, err := p.parseInfixExpr()
 			if err != nil {
-				return nil, fmt.Errorf("line %d: error parsing index: %v", tok.Line, err)
+				return nil, errorAt(tok, "error parsing index: %v", err)
 			}
 			if p.peek().Type != lexer.TokRBracket {
-				return nil, fmt.Errorf("line %d: expected ']' after index", p.peek().Line)
+				return nil, errorAt(p.peek(), "expected ']' after index")
 			}
 			p.advance() // consume ]
-			return &ast.IndexExpr{Target: "self", Index: index}, nil
+			// self[i].x - a field of a struct element
+			field := ""
+			if p.peek().Type == lexer.TokDot && p.peekAhead(1).Type == lexer.TokIdent {
+				p.advance() // consume .
+				field = p.advance().Value
+			}
+			return &ast.IndexExpr{Target: "self", Index: index, Field: field}, nil
 		} else {
-			return nil, fmt.Errorf("line %d: expected '.' or '[' after self", tok.Line)
+			return nil, errorAt(tok, "expected '.' or '[' after self")
 		}
 		
 	case lexer.TokLParen:
@@ -2378,13 +3306,13 @@ func (p *Parser) parseInfixPrimary() (ast.Expr, error) {
 			return nil, err
 		}
 		if p.peek().Type != lexer.TokRParen {
-			return nil, fmt.Errorf("line %d: expected ')' after expression", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected ')' after expression")
 		}
 		p.advance() // consume )
 		return expr, nil
 		
 	default:
-		return nil, fmt.Errorf("line %d: unexpected token '%s' in expression", tok.Line, tok.Value)
+		return nil, errorAt(tok, "unexpected token '%s' in expression", tok.Value)
 	}
 }
 
@@ -2405,19 +3333,123 @@ func (p *Parser) parseInfixCall(name string) (ast.Expr, error) {
 	}
 	
 	if p.peek().Type != lexer.TokRParen {
-		return nil, fmt.Errorf("line %d: expected ')' after function arguments", p.peek().Line)
+		return nil, errorAt(p.peek(), "expected ')' after function arguments")
 	}
 	p.advance() // consume )
 	
 	return &ast.CallExpr{Fn: name, Args: args}, nil
 }
 
+// isArgsDecl reports whether the "args" identifier at the current position
+// starts an args block: args { ... } or args "prog" { ... }
+func (p *Parser) isArgsDecl() bool {
+	next := p.peekAhead(1)
+	if next.Type == lexer.TokString {
+		next = p.peekAhead(2)
+	}
+	return next.Type == lexer.TokLBrace
+}
+
+// parseArgsDecl parses a command-line argument block:
+//
+//	args "prog" {
+//	    flag "verbose" v bool "print more"
+//	    opt "output" o string = "out.txt"
+//	    pos "input" string
+//	}
+//
+// Each entry is kind, name, optional short letter, type, optional
+// "= default" and optional help string, separated by newlines or ';'.
+func (p *Parser) parseArgsDecl() (ast.Stmt, error) {
+	p.advance() // consume 'args'
+	decl := &ast.ArgsDecl{}
+	if p.peek().Type == lexer.TokString {
+		decl.Program = p.advance().Value
+	}
+	if _, err := p.expect(lexer.TokLBrace); err != nil {
+		return nil, err
+	}
+	
+	for {
+		for p.peek().Type == lexer.TokNewline || p.peek().Type == lexer.TokSemicolon {
+			p.advance()
+		}
+		if p.peek().Type == lexer.TokRBrace {
+			p.advance()
+			break
+		}
+		spec, err := p.parseArgSpec()
+		if err != nil {
+			return nil, err
+		}
+		decl.Specs = append(decl.Specs, spec)
+		
+		switch p.peek().Type {
+		case lexer.TokNewline, lexer.TokSemicolon, lexer.TokRBrace:
+		default:
+			tok := p.peek()
+			return nil, errorAt(tok, "expected newline or ';' after %s %q, got %v", spec.Kind, spec.Name, tok)
+		}
+	}
+	return decl, nil
+}
+
+// parseArgSpec parses one entry of an args block
+func (p *Parser) parseArgSpec() (*ast.ArgSpec, error) {
+	kindTok := p.advance()
+	switch kindTok.Value {
+	case "flag", "opt", "pos":
+	default:
+		return nil, errorAt(kindTok, "expected flag, opt or pos in args block, got %v", kindTok)
+	}
+	nameTok, err := p.expect(lexer.TokString)
+	if err != nil {
+		return nil, err
+	}
+	spec := &ast.ArgSpec{Kind: kindTok.Value, Name: nameTok.Value}
+	
+	// Optional short alias: a single letter before the type
+	if tok := p.peek(); len(tok.Value) == 1 && tok.Type != lexer.TokString && unicode.IsLetter(rune(tok.Value[0])) {
+		if spec.Kind == "pos" {
+			return nil, errorAt(tok, "positional argument %q cannot have a short alias", spec.Name)
+		}
+		spec.Short = p.advance().Value
+	}
+	
+	typeTok := p.advance()
+	switch typeTok.Type {
+	case lexer.TokBool, lexer.TokStringType, lexer.TokI64, lexer.TokF64:
+		spec.Type = typeTok.Value
+	default:
+		return nil, errorAt(typeTok, "expected bool, string, i64 or f64 for %q, got %v", spec.Name, typeTok)
+	}
+	if spec.Kind == "flag" && spec.Type != "bool" {
+		return nil, errorAt(typeTok, "flag %q must be bool (use opt for values)", spec.Name)
+	}
+	
+	if p.peek().Type == lexer.TokEquals {
+		p.advance() // consume =
+		switch p.peek().Type {
+		case lexer.TokTrue, lexer.TokFalse:
+			spec.Default = &ast.BoolLit{Value: p.advance().Type == lexer.TokTrue}
+		default:
+			if spec.Default, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.peek().Type == lexer.TokString {
+		spec.Help = p.advance().Value
+	}
+	return spec, nil
+}
+
 // isTypeToken checks if token is a type name
 func isTypeToken(t lexer.TokenType) bool {
 	switch t {
 	case lexer.TokI8, lexer.TokI16, lexer.TokI32, lexer.TokI64,
 	     lexer.TokU8, lexer.TokU16, lexer.TokU32, lexer.TokU64,
-	     lexer.TokF32, lexer.TokF64, lexer.TokString, lexer.TokBool, lexer.TokBytes:
+	     lexer.TokF32, lexer.TokF64, lexer.TokString, lexer.TokStringType, lexer.TokBool, lexer.TokBytes, lexer.TokFn:
 		return true
 	}
 	return false
@@ -2493,14 +3525,53 @@ func (p *Parser) parseIdentStmt() (ast.Stmt, error) {
 		}
 		
 		if p.peek().Type != lexer.TokRParen {
-			return nil, fmt.Errorf("line %d: expected ')' after function arguments", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected ')' after function arguments")
 		}
 		p.advance() // consume ')'
 		
 		return &ast.FuncCall{Name: name, Args: args}, nil
 	}
 	
-	return nil, fmt.Errorf("line %d: expected = or : or ( after identifier", next.Line)
+	return nil, errorAt(next, "expected = or : or ( after identifier")
+}
+
+// parseTestBlock: test "name" { body } or bench "name" { body }
+func (p *Parser) parseTestBlock() (ast.Stmt, error) {
+	kind := p.advance().Value // consume 'test' or 'bench'
+	name := p.advance().Value
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if kind == "bench" {
+		return &ast.BenchBlock{Name: name, Body: body}, nil
+	}
+	return &ast.TestBlock{Name: name, Body: body}, nil
+}
+
+// parseAssert: assert(condition) or assert(condition, message). The
+// condition is written as in an if, so it is not an ordinary argument.
+func (p *Parser) parseAssert() (ast.Stmt, error) {
+	p.advance() // consume 'assert'
+	p.advance() // consume '('
+	cond, err := p.parseOrCond()
+	if err != nil {
+		return nil, err
+	}
+	args := []ast.Expr{cond}
+	if p.peek().Type == lexer.TokComma {
+		p.advance() // consume ,
+		msg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, msg)
+	}
+	if p.peek().Type != lexer.TokRParen {
+		return nil, errorAt(p.peek(), "expected ')' after assert condition")
+	}
+	p.advance() // consume ')'
+	return &ast.FuncCall{Name: "assert", Args: args}, nil
 }
 
 func (p *Parser) parseViewDecl(name string) (ast.Stmt, error) {
@@ -2609,6 +3680,37 @@ func (p *Parser) parseMultiplicative() (ast.Expr, error) {
 	return left, nil
 }
 
+// parseInterpString: "text ${expr} text ..." as lexed into a
+// TokStringHead, then each expression followed by a TokStringMid or, for
+// the last, a TokStringTail
+func (p *Parser) parseInterpString() (ast.Expr, error) {
+	head := p.advance()
+	s := &ast.InterpString{Parts: []ast.Expr{&ast.StringLit{Value: head.Value}}}
+	for {
+		if p.peek().Type == lexer.TokStringMid || p.peek().Type == lexer.TokStringTail {
+			return nil, errorAt(p.peek(), "expected expression in ${}")
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		s.Parts = append(s.Parts, expr)
+		
+		tok := p.peek()
+		switch tok.Type {
+		case lexer.TokStringMid:
+			p.advance()
+			s.Parts = append(s.Parts, &ast.StringLit{Value: tok.Value})
+		case lexer.TokStringTail:
+			p.advance()
+			s.Parts = append(s.Parts, &ast.StringLit{Value: tok.Value})
+			return s, nil
+		default:
+			return nil, errorAt(tok, "expected '}' to close ${ in string")
+		}
+	}
+}
+
 func (p *Parser) parsePrimary() (ast.Expr, error) {
 	tok := p.peek()
 	
@@ -2622,11 +3724,13 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		}
 		return &ast.UnaryExpr{Op: "-", Operand: operand}, nil
 		
+	case lexer.TokTrue, lexer.TokFalse:
+		p.advance()
+		return &ast.BoolLit{Value: tok.Type == lexer.TokTrue}, nil
+		
 	case lexer.TokInt:
 		p.advance()
-		var val int64
-		fmt.Sscanf(tok.Value, "%d", &val)
-		return &ast.IntLit{Value: val}, nil
+		return intLit(tok)
 		
 	case lexer.TokFloat:
 		p.advance()
@@ -2638,6 +3742,30 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		p.advance()
 		return &ast.StringLit{Value: tok.Value}, nil
 		
+	case lexer.TokStringHead:
+		return p.parseInterpString()
+		
+	case lexer.TokLBracket:
+		// [a, b, c] - list literal
+		p.advance()
+		list := &ast.ListLit{}
+		for p.skipNewlines(); p.peek().Type != lexer.TokRBracket; p.skipNewlines() {
+			elem, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			list.Elems = append(list.Elems, elem)
+			p.skipNewlines()
+			if p.peek().Type != lexer.TokComma {
+				break
+			}
+			p.advance()
+		}
+		if _, err := p.expect(lexer.TokRBracket); err != nil {
+			return nil, err
+		}
+		return list, nil
+		
 	case lexer.TokStackRef:
 		p.advance()
 		name := tok.Value
@@ -2685,6 +3813,13 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		p.advance()
 		name := tok.Value
 		
+		if p.enums[name] != nil && p.peek().Type == lexer.TokDot {
+			return p.enumMember(tok)
+		}
+		if val, ok := p.constValue(name); ok && p.peek().Type != lexer.TokLParen && p.peek().Type != lexer.TokColon {
+			return val, nil
+		}
+		
 		if p.peek().Type == lexer.TokColon {
 			// Could be view: op(...) or func:arg (shorthand)
 			// Look ahead to determine which
@@ -2769,7 +3904,7 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		
 		return &ast.Ident{Name: name}, nil
 		
-	case lexer.TokLIFO, lexer.TokFIFO, lexer.TokIndexed, lexer.TokHash:
+	case lexer.TokLIFO, lexer.TokFIFO, lexer.TokIndexed, lexer.TokHash, lexer.TokBroadcast:
 		p.advance()
 		return &ast.PerspectiveLit{Value: tok.Value}, nil
 		
@@ -2779,6 +3914,9 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		return &ast.TypeLit{Value: tok.Value}, nil
 		
 	case lexer.TokLBrace:
+		if p.isRecordLit() {
+			return p.parseRecordLit(p.parseExpr)
+		}
 		// Codeblock (anonymous func): { body } or {|params| body }
 		return p.parseCodeblock()
 		
@@ -2795,8 +3933,42 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		return expr, nil
 		
 	default:
-		return nil, fmt.Errorf("line %d: unexpected token in expression: %v", tok.Line, tok)
+		return nil, errorAt(tok, "unexpected token in expression: %v", tok)
+	}
+}
+
+// parseCodeblockExpr parses an expression codeblock body, allowing a single
+// trailing comparison so predicates like {|x| x % 2 == 0} work for filter
+func (p *Parser) parseCodeblockExpr() (ast.Expr, error) {
+	left, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	
+	var op string
+	switch p.peek().Type {
+	case lexer.TokSymEq:
+		op = "=="
+	case lexer.TokSymNe:
+		op = "!="
+	case lexer.TokSymLt:
+		op = "<"
+	case lexer.TokSymGt:
+		op = ">"
+	case lexer.TokSymLe:
+		op = "<="
+	case lexer.TokSymGe:
+		op = ">="
+	default:
+		return left, nil
+	}
+	p.advance()
+	
+	right, err := p.parseExpr()
+	if err != nil {
+		return nil, err
 	}
+	return &ast.BinaryOp{Left: left, Op: op, Right: right}, nil
 }
 
 // parseCodeblock: { body } or {|params| body }
@@ -2832,7 +4004,7 @@ func (p *Parser) parseCodeblock() (ast.Expr, error) {
 		
 		_, err = p.expect(lexer.TokPipe)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: expected '|' to close parameter list", p.peek().Line)
+			return nil, errorAt(p.peek(), "expected '|' to close parameter list")
 		}
 	}
 	
@@ -2841,8 +4013,9 @@ func (p *Parser) parseCodeblock() (ast.Expr, error) {
 	// Try to parse as expression first (for simple codeblocks like {|a,b| a + b})
 	// Save position for backtracking
 	startPos := p.pos
+	startErrs := len(p.errors)
 	
-	expr, exprErr := p.parseExpr()
+	expr, exprErr := p.parseCodeblockExpr()
 	
 	p.skipNewlines()
 	
@@ -2854,6 +4027,7 @@ func (p *Parser) parseCodeblock() (ast.Expr, error) {
 	
 	// Backtrack and parse as statements
 	p.pos = startPos
+	p.errors = p.errors[:startErrs]
 	p.skipNewlines()
 	
 	var body []ast.Stmt
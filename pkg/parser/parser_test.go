@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -93,6 +94,80 @@ func TestParseStackDeclWithPerspective(t *testing.T) {
 	}
 }
 
+func TestParseBroadcastPerspective(t *testing.T) {
+	input := "@events = stack.new(i64, Broadcast)\nv = view.new(Broadcast)"
+	tokens := tokenize(input)
+	p := NewParser(tokens)
+	prog, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(prog.Stmts))
+	}
+
+	decl, ok := prog.Stmts[0].(*ast.StackDecl)
+	if !ok || decl.Perspective != "Broadcast" {
+		t.Errorf("expected Broadcast StackDecl, got %#v", prog.Stmts[0])
+	}
+	view, ok := prog.Stmts[1].(*ast.ViewDecl)
+	if !ok || view.Perspective != "Broadcast" {
+		t.Errorf("expected Broadcast ViewDecl, got %#v", prog.Stmts[1])
+	}
+}
+
+func TestParseStatementPositions(t *testing.T) {
+	input := "x = 1\n\nfunc f() {\n  y = 2\n}\n"
+	p := NewParser(tokenize(input))
+	prog, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(prog.Stmts))
+	}
+
+	if pos := prog.Pos[prog.Stmts[0]]; pos.Line != 1 {
+		t.Errorf("x = 1: expected line 1, got %d", pos.Line)
+	}
+	fn := prog.Stmts[1].(*ast.FuncDecl)
+	if pos := prog.Pos[fn]; pos.Line != 3 {
+		t.Errorf("func: expected line 3, got %d", pos.Line)
+	}
+	if pos := prog.Pos[fn.Body[0]]; pos.Line != 4 || pos.Col != 3 {
+		t.Errorf("nested y = 2: expected 4:3, got %s", pos)
+	}
+}
+
+func TestParseErrorRecovery(t *testing.T) {
+	input := "x = 1\nwhile {\n}\nfunc f() {\n  y = (1 +\n  if x > 0 {\n  }\n}\nz = )\n"
+	_, err := NewParser(tokenize(input)).Parse()
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList, got %T: %v", err, err)
+	}
+	var lines []int
+	for _, e := range list {
+		lines = append(lines, e.Pos.Line)
+	}
+	if fmt.Sprint(lines) != "[2 5 6 9]" {
+		t.Errorf("error lines = %v, want [2 5 6 9]", lines)
+	}
+	if diags := Diagnostics("prog.ual", err); len(diags) != 4 || !strings.HasPrefix(diags[3], "prog.ual:9:5: ") {
+		t.Errorf("Diagnostics = %q", diags)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := NewParser(tokenize("x = 1\nwhile {\n}\n")).Parse()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got := Diagnostic("prog.ual", err); !strings.HasPrefix(got, "prog.ual:2:7: parse error: ") {
+		t.Errorf("Diagnostic = %q, want prog.ual:2:7: parse error: ...", got)
+	}
+}
+
 func TestParseStackPush(t *testing.T) {
 	input := "@numbers push(42)"
 	tokens := tokenize(input)
@@ -117,6 +192,39 @@ func TestParseStackPush(t *testing.T) {
 	}
 }
 
+func TestParseHashDelHas(t *testing.T) {
+	input := "@ages del(\"bob\")\n@ages has(\"bob\")\nx = @ages: has(\"bob\")"
+	p := NewParser(tokenize(input))
+	prog, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(prog.Stmts))
+	}
+
+	for i, want := range []string{"del", "has"} {
+		op, ok := prog.Stmts[i].(*ast.StackOp)
+		if !ok {
+			t.Fatalf("statement %d: expected StackOp, got %T", i, prog.Stmts[i])
+		}
+		if op.Op != want || len(op.Args) != 1 {
+			t.Errorf("statement %d: expected %s with 1 arg, got %s with %d", i, want, op.Op, len(op.Args))
+		}
+		if key, ok := op.Args[0].(*ast.StringLit); !ok || key.Value != "bob" {
+			t.Errorf("statement %d: expected key \"bob\", got %#v", i, op.Args[0])
+		}
+	}
+
+	assign, ok := prog.Stmts[2].(*ast.Assignment)
+	if !ok {
+		t.Fatalf("expected Assignment, got %T", prog.Stmts[2])
+	}
+	if e, ok := assign.Expr.(*ast.StackExpr); !ok || e.Op != "has" || len(e.Args) != 1 {
+		t.Errorf("expected StackExpr has with 1 arg, got %#v", assign.Expr)
+	}
+}
+
 func TestParseVarDecl(t *testing.T) {
 	input := "var x i64 = 10"
 	tokens := tokenize(input)
@@ -185,6 +293,33 @@ func TestParseFuncDecl(t *testing.T) {
 	}
 }
 
+func TestParseGenericFuncDecl(t *testing.T) {
+	input := `func fill(@s stack(T), v T, @n stack(i64)) {
+		var x T = v
+		@s push:x
+	}`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fn := prog.Stmts[0].(*ast.FuncDecl)
+	if len(fn.TypeParams) != 1 || fn.TypeParams[0] != "T" {
+		t.Errorf("expected type params [T], got %v", fn.TypeParams)
+	}
+	want := []ast.FuncParam{{Name: "s", Type: "T", Stack: true}, {Name: "v", Type: "T"}, {Name: "n", Type: "i64", Stack: true}}
+	if fmt.Sprint(fn.Params) != fmt.Sprint(want) {
+		t.Errorf("expected params %v, got %v", want, fn.Params)
+	}
+	if decl := fn.Body[0].(*ast.VarDecl); decl.Type != "T" {
+		t.Errorf("expected var type T, got %q", decl.Type)
+	}
+
+	// A type parameter is only a type inside its function
+	if _, err := NewParser(tokenize("var x T = 1")).Parse(); err == nil {
+		t.Error("expected error for unknown type T")
+	}
+}
+
 func TestParseIfStmt(t *testing.T) {
 	input := `if (x > 0) {
 		@stack push(1)
@@ -259,6 +394,38 @@ func TestParseWhileStmt(t *testing.T) {
 	}
 }
 
+func TestParseRangeStmt(t *testing.T) {
+	input := `for i in 0..n + 1 {
+		println(i)
+	}`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, ok := prog.Stmts[0].(*ast.RangeStmt)
+	if !ok {
+		t.Fatalf("expected RangeStmt, got %T", prog.Stmts[0])
+	}
+	if r.Var != "i" {
+		t.Errorf("expected var 'i', got %q", r.Var)
+	}
+	if lit, ok := r.Start.(*ast.IntLit); !ok || lit.Value != 0 {
+		t.Errorf("expected start 0, got %#v", r.Start)
+	}
+	if _, ok := r.End.(*ast.BinaryOp); !ok {
+		t.Errorf("expected end n + 1, got %T", r.End)
+	}
+	if len(r.Body) != 1 {
+		t.Errorf("expected 1 body statement, got %d", len(r.Body))
+	}
+
+	for _, bad := range []string{"for i 0..3 {\n}", "for i in 0, 3 {\n}", "for in 0..3 {\n}"} {
+		if _, err := NewParser(tokenize(bad)).Parse(); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
 func TestParseMainFunc(t *testing.T) {
 	// ual doesn't have a main block - programs are top-level statements
 	// But we can test that func main() works like any other function
@@ -329,6 +496,133 @@ func TestParseDeferStmt(t *testing.T) {
 	}
 }
 
+func TestParseAtExitStmt(t *testing.T) {
+	input := `@atexit < {
+		push:1
+		dot
+	}`
+	tokens := tokenize(input)
+	p := NewParser(tokens)
+	prog, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(prog.Stmts))
+	}
+
+	a, ok := prog.Stmts[0].(*ast.AtExitStmt)
+	if !ok {
+		t.Fatalf("expected AtExitStmt, got %T", prog.Stmts[0])
+	}
+	if len(a.Body) != 2 {
+		t.Errorf("expected 2 body statements, got %d", len(a.Body))
+	}
+}
+
+func TestParseSpawnWait(t *testing.T) {
+	tests := []struct {
+		input   string
+		timeout bool
+	}{
+		{"@spawn wait", false},
+		{"@spawn wait(500)", true},
+	}
+	for _, tc := range tests {
+		prog, err := NewParser(tokenize(tc.input)).Parse()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.input, err)
+		}
+		op, ok := prog.Stmts[0].(*ast.SpawnOp)
+		if !ok {
+			t.Fatalf("%s: expected SpawnOp, got %T", tc.input, prog.Stmts[0])
+		}
+		if op.Op != "wait" || (len(op.Args) == 1) != tc.timeout {
+			t.Errorf("%s: got op %q with %d args", tc.input, op.Op, len(op.Args))
+		}
+	}
+}
+
+func TestParseSpawnInto(t *testing.T) {
+	input := `@spawn < { return 6 * 7 } into @results`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp, ok := prog.Stmts[0].(*ast.SpawnPush)
+	if !ok {
+		t.Fatalf("expected SpawnPush, got %T", prog.Stmts[0])
+	}
+	if sp.Into != "results" {
+		t.Errorf("expected into @results, got %q", sp.Into)
+	}
+	if _, ok := sp.Body[0].(*ast.ReturnStmt); !ok || len(sp.Body) != 1 {
+		t.Errorf("expected a single return statement, got %#v", sp.Body)
+	}
+
+	if _, err := NewParser(tokenize(`@spawn < { return 1 } into results`)).Parse(); err == nil {
+		t.Error("expected error for 'into' without a stack")
+	}
+}
+
+func TestParseSelectSources(t *testing.T) {
+	input := `@inbox { }.select(
+    @inbox {|msg| push:msg }
+    every(250) {|t| push:t }
+    @signal int {|sig| push:1 }
+)`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sel, ok := prog.Stmts[0].(*ast.SelectStmt)
+	if !ok {
+		t.Fatalf("expected SelectStmt, got %T", prog.Stmts[0])
+	}
+	if len(sel.Cases) != 3 {
+		t.Fatalf("expected 3 cases, got %d", len(sel.Cases))
+	}
+	if c := sel.Cases[0]; c.Kind != ast.SelectStack || c.Stack != "inbox" {
+		t.Errorf("case 0: expected @inbox, got %#v", c)
+	}
+	if c := sel.Cases[1]; c.Kind != ast.SelectTimer || c.Every == nil || c.Bindings[0] != "t" {
+		t.Errorf("case 1: expected every(250) binding t, got %#v", c)
+	}
+	if c := sel.Cases[2]; c.Kind != ast.SelectSignal || c.Signal != "SIGINT" {
+		t.Errorf("case 2: expected @signal SIGINT, got %#v", c)
+	}
+
+	if _, err := NewParser(tokenize(`@inbox { }.select( @signal SIGNOPE { } )`)).Parse(); err == nil {
+		t.Error("expected error for unknown signal")
+	}
+}
+
+func TestParseSelectFair(t *testing.T) {
+	for _, tc := range []struct {
+		input   string
+		ordered bool
+	}{
+		{`@a { }.select( @a {|x| push:x } )`, false},
+		{`@a { }.select(fair: true, @a {|x| push:x } )`, false},
+		{`@a { }.select(fair: false
+    @a {|x| push:x }
+)`, true},
+	} {
+		prog, err := NewParser(tokenize(tc.input)).Parse()
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.input, err)
+		}
+		sel := prog.Stmts[0].(*ast.SelectStmt)
+		if sel.Ordered != tc.ordered || len(sel.Cases) != 1 {
+			t.Errorf("%q: got Ordered=%v with %d cases", tc.input, sel.Ordered, len(sel.Cases))
+		}
+	}
+
+	if _, err := NewParser(tokenize(`@a { }.select(fair: 1, @a { } )`)).Parse(); err == nil {
+		t.Error("expected error for non-boolean fair:")
+	}
+}
+
 func TestParseReturnStmt(t *testing.T) {
 	input := "return 42"
 	tokens := tokenize(input)
@@ -432,20 +726,88 @@ func TestParseComparison(t *testing.T) {
 	}
 }
 
-func TestParseLogicalOps(t *testing.T) {
-	// Logical ops work in conditions 
-	input := `if (x > 0 && y > 0) {
-		@data push(1)
-	}`
+func TestParseWalkWithPredicate(t *testing.T) {
+	input := `@evens filter(@numbers, {|x| x % 2 == 0})`
 	tokens := tokenize(input)
 	p := NewParser(tokens)
-	_, err := p.Parse()
+	prog, err := p.Parse()
 	if err != nil {
-		// This may or may not be supported - just don't fail hard
-		t.Logf("logical ops parsing: %v", err)
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(prog.Stmts))
+	}
+
+	op, ok := prog.Stmts[0].(*ast.StackOp)
+	if !ok {
+		t.Fatalf("expected StackOp, got %T", prog.Stmts[0])
+	}
+	if op.Stack != "evens" || op.Op != "filter" || len(op.Args) != 2 {
+		t.Fatalf("unexpected op: @%s %s with %d args", op.Stack, op.Op, len(op.Args))
+	}
+	fn, ok := op.Args[1].(*ast.FnLit)
+	if !ok {
+		t.Fatalf("expected FnLit, got %T", op.Args[1])
+	}
+	body, ok := fn.Body[0].(*ast.ExprStmt)
+	if !ok {
+		t.Fatalf("expected expression body, got %T", fn.Body[0])
+	}
+	cmp, ok := body.Expr.(*ast.BinaryOp)
+	if !ok || cmp.Op != "==" {
+		t.Errorf("expected == comparison, got %#v", body.Expr)
+	}
+}
+
+func TestParseLogicalOps(t *testing.T) {
+	tests := []struct {
+		cond, want string
+	}{
+		{"x > 0 && y > 0", "((x > 0) && (y > 0))"},
+		{"a == 1 || b == 2 && c == 3", "((a == 1) || ((b == 2) && (c == 3)))"},
+		{"(a == 1 || b == 2) && c == 3", "(((a == 1) || (b == 2)) && (c == 3))"},
+		{"!(x > 0) || done", "(!(x > 0) || done)"},
+		{"!!ok", "!!ok"},
+		{"(a + b) * 2 > c", "(((a + b) * 2) > c)"},
+		{"((x))", "x"},
+	}
+	for _, tt := range tests {
+		input := "if (" + tt.cond + ") {\n}"
+		prog, err := NewParser(tokenize(input)).Parse()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.cond, err)
+			continue
+		}
+		got := condString(prog.Stmts[0].(*ast.IfStmt).Condition)
+		if got != tt.want {
+			t.Errorf("%s: parsed as %s, want %s", tt.cond, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"if (x > 0 &&) {\n}", "if (x > 0 y) {\n}", "if ((x > 0) {\n}"} {
+		if _, err := NewParser(tokenize(bad)).Parse(); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
 	}
 }
 
+// condString renders a condition with every operation parenthesised
+func condString(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.BinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", condString(e.Left), e.Op, condString(e.Right))
+	case *ast.BinaryOp:
+		return fmt.Sprintf("(%s %s %s)", condString(e.Left), e.Op, condString(e.Right))
+	case *ast.UnaryExpr:
+		return e.Op + condString(e.Operand)
+	case *ast.Ident:
+		return e.Name
+	case *ast.IntLit:
+		return fmt.Sprint(e.Value)
+	}
+	return fmt.Sprintf("%T", e)
+}
+
 // Error cases
 
 func TestParseErrorUnclosedBrace(t *testing.T) {
@@ -562,6 +924,61 @@ func TestParseFloatLiteral(t *testing.T) {
 	}
 }
 
+func TestParseArgsDecl(t *testing.T) {
+	input := `args "tool" {
+    flag "verbose" v bool "print more"; opt "output" o string = "out.txt"
+    pos "input" string
+}
+var args_seen = 1`
+	tokens := tokenize(input)
+	p := NewParser(tokens)
+	prog, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decl, ok := prog.Stmts[0].(*ast.ArgsDecl)
+	if !ok {
+		t.Fatalf("expected ArgsDecl, got %T", prog.Stmts[0])
+	}
+	if decl.Program != "tool" || len(decl.Specs) != 3 {
+		t.Fatalf("unexpected decl: %q with %d specs", decl.Program, len(decl.Specs))
+	}
+	want := []ast.ArgSpec{
+		{Kind: "flag", Name: "verbose", Short: "v", Type: "bool", Help: "print more"},
+		{Kind: "opt", Name: "output", Short: "o", Type: "string"},
+		{Kind: "pos", Name: "input", Type: "string"},
+	}
+	for i, w := range want {
+		got := decl.Specs[i]
+		if got.Kind != w.Kind || got.Name != w.Name || got.Short != w.Short || got.Type != w.Type || got.Help != w.Help {
+			t.Errorf("spec %d: got %+v, want %+v", i, *got, w)
+		}
+	}
+	if lit, ok := decl.Specs[1].Default.(*ast.StringLit); !ok || lit.Value != "out.txt" {
+		t.Errorf("expected default \"out.txt\", got %#v", decl.Specs[1].Default)
+	}
+}
+
+func TestParseArgsDeclErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		errContains string
+	}{
+		{`args { flag "n" i64 }`, "must be bool"},
+		{`args { pos "file" f string }`, "short alias"},
+		{`args { option "x" string }`, "expected flag, opt or pos"},
+		{`args { opt "x" string "a" "b" }`, "expected newline"},
+	}
+
+	for _, tc := range tests {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("input %q: error %v should contain %q", tc.input, err, tc.errContains)
+		}
+	}
+}
+
 func TestParseNegativeLiteral(t *testing.T) {
 	input := "@data push(-42)"
 	tokens := tokenize(input)
@@ -585,6 +1002,120 @@ func TestParseNegativeLiteral(t *testing.T) {
 	}
 }
 
+func TestParseIntLiteralRange(t *testing.T) {
+	prog, err := NewParser(tokenize("var y u64 = 18446744073709551615")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decl := prog.Stmts[0].(*ast.VarDecl)
+	if lit, ok := decl.Values[0].(*ast.IntLit); !ok || uint64(lit.Value) != 18446744073709551615 {
+		t.Errorf("value = %#v, want the u64 maximum", decl.Values[0])
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"var y u64 = 18446744073709551616", "prog.ual:1:13: parse error: integer literal 18446744073709551616 is out of range for u64"},
+		{"@s push:99999999999999999999", "prog.ual:1:9: parse error: integer literal 99999999999999999999 is out of range for u64"},
+		{"enum E {\n  A = 9223372036854775808\n}", "prog.ual:2:7: parse error: value 9223372036854775808 of E.A is out of range for i64"},
+		{"@s = stack.new(i64, cap: 18446744073709551615)", "prog.ual:1:26: parse error: capacity 18446744073709551615 is out of range"},
+	}
+	for _, tc := range tests {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if got := Diagnostic("prog.ual", err); got != tc.want {
+			t.Errorf("%q: Diagnostic = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseListLit(t *testing.T) {
+	input := "expect_stack(@s, [1, \"two\",\n  3.5])"
+	tokens := tokenize(input)
+	p := NewParser(tokens)
+	prog, err := p.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	call, ok := prog.Stmts[0].(*ast.FuncCall)
+	if !ok {
+		t.Fatalf("expected FuncCall, got %T", prog.Stmts[0])
+	}
+	if len(call.Args) != 2 {
+		t.Fatalf("expected 2 args, got %d", len(call.Args))
+	}
+	list, ok := call.Args[1].(*ast.ListLit)
+	if !ok {
+		t.Fatalf("expected ListLit, got %T", call.Args[1])
+	}
+	if len(list.Elems) != 3 {
+		t.Fatalf("expected 3 elements, got %d", len(list.Elems))
+	}
+	if _, ok := list.Elems[1].(*ast.StringLit); !ok {
+		t.Errorf("expected StringLit, got %T", list.Elems[1])
+	}
+
+	prog, err = NewParser(tokenize("expect_stack(@s, [])")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list := prog.Stmts[0].(*ast.FuncCall).Args[1].(*ast.ListLit); len(list.Elems) != 0 {
+		t.Errorf("expected empty list, got %d elements", len(list.Elems))
+	}
+}
+
+func TestParseStructStack(t *testing.T) {
+	input := `@points = stack.new({x: f64, y: f64, hit: bool}, Indexed)
+@points push({x: 1.0, y: 2.0})
+@points {
+}.compute({||
+    return {x: self[0].x * 2, y: self[1].y}
+})`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decl, ok := prog.Stmts[0].(*ast.StackDecl)
+	if !ok {
+		t.Fatalf("expected StackDecl, got %T", prog.Stmts[0])
+	}
+	if decl.ElementType != "struct" || decl.Perspective != "Indexed" || len(decl.Fields) != 3 {
+		t.Fatalf("decl = %+v", decl)
+	}
+	if decl.Fields[2] != (ast.StructField{Name: "hit", Type: "bool"}) {
+		t.Errorf("field 2 = %+v", decl.Fields[2])
+	}
+
+	op := prog.Stmts[1].(*ast.StackOp)
+	rec, ok := op.Args[0].(*ast.RecordLit)
+	if !ok || len(rec.Names) != 2 || rec.Names[1] != "y" {
+		t.Fatalf("push arg = %#v", op.Args[0])
+	}
+
+	compute := prog.Stmts[2].(*ast.ComputeStmt)
+	ret := compute.Body[0].(*ast.ReturnStmt)
+	rec, ok = ret.Values[0].(*ast.RecordLit)
+	if !ok {
+		t.Fatalf("return value = %T", ret.Values[0])
+	}
+	field := rec.Values[0].(*ast.BinaryExpr).Left.(*ast.IndexExpr)
+	if field.Target != "self" || field.Field != "x" {
+		t.Errorf("self[0].x parsed as %+v", field)
+	}
+
+	for _, bad := range []string{
+		"@s = stack.new({x: string})",
+		"@s = stack.new({x: f64, x: i64})",
+		"@s = stack.new({})",
+	} {
+		if _, err := NewParser(tokenize(bad)).Parse(); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
 func TestParseComputeBlock(t *testing.T) {
 	input := `@data {
 }.compute({|a, b|
@@ -700,6 +1231,7 @@ func TestParseErrorMessages(t *testing.T) {
 		{`func test() {`, "expected"},
 		{`@stack push(`, "expected"},
 		{`while { }`, "expected"},
+		{`@stack x = 1`, "unexpected token in operations on @stack"},
 	}
 
 	for _, tc := range tests {
@@ -715,3 +1247,291 @@ func TestParseErrorMessages(t *testing.T) {
 		}
 	}
 }
+
+func TestParseWordDef(t *testing.T) {
+	input := `def square { dup mul }
+def cube {
+    dup square mul
+}
+@nums push:3 cube
+square`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(prog.Stmts))
+	}
+
+	want := map[string]string{"nums": "push dup dup mul mul", "dstack": "dup mul"}
+	for _, stmt := range prog.Stmts {
+		block, ok := stmt.(*ast.StackBlock)
+		if !ok {
+			t.Fatalf("expected StackBlock, got %T", stmt)
+		}
+		var ops []string
+		for _, s := range block.Ops {
+			op := s.(*ast.StackOp)
+			if op.Stack != block.Stack {
+				t.Errorf("%s inlined on @%s, want @%s", op.Op, op.Stack, block.Stack)
+			}
+			ops = append(ops, op.Op)
+		}
+		if got := strings.Join(ops, " "); got != want[block.Stack] {
+			t.Errorf("@%s ops = %q, want %q", block.Stack, got, want[block.Stack])
+		}
+	}
+}
+
+func TestParseWordDefErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		errContains string
+	}{
+		{"def dup { dup }", "cannot redefine built-in operation dup"},
+		{"def w { dup }\ndef w { drop }", "word w already defined"},
+		{"def w { }", "word w has no operations"},
+		{"def w { if }", "unexpected token in word w"},
+		{"def loop1 { dup loop1 }", "line 1: word loop1 cannot use itself"},
+		{"push:1\n\ndef w {\n  dup nosuch\n}", "line 3: undefined word nosuch in word w"},
+		{"def w { dup v }\ndef v { drop }", "undefined word v in word w"},
+	}
+
+	for _, tc := range tests {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("input %q: error %v should contain %q", tc.input, err, tc.errContains)
+		}
+	}
+}
+
+func TestParseInterpString(t *testing.T) {
+	prog, err := NewParser(tokenize(`println("n is ${n + 1}!")`)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	op, ok := prog.Stmts[0].(*ast.StackOp)
+	if !ok || len(op.Args) != 1 {
+		t.Fatalf("expected println op, got %#v", prog.Stmts[0])
+	}
+	s, ok := op.Args[0].(*ast.InterpString)
+	if !ok {
+		t.Fatalf("expected InterpString, got %T", op.Args[0])
+	}
+	if len(s.Parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d", len(s.Parts))
+	}
+	if lit, ok := s.Parts[0].(*ast.StringLit); !ok || lit.Value != "n is " {
+		t.Errorf("expected text 'n is ', got %#v", s.Parts[0])
+	}
+	if _, ok := s.Parts[1].(*ast.BinaryOp); !ok {
+		t.Errorf("expected n + 1, got %T", s.Parts[1])
+	}
+	if lit, ok := s.Parts[2].(*ast.StringLit); !ok || lit.Value != "!" {
+		t.Errorf("expected text '!', got %#v", s.Parts[2])
+	}
+
+	for _, bad := range []string{`println("${}")`, `println("${x y}")`} {
+		if _, err := NewParser(tokenize(bad)).Parse(); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestParseEnumDecl(t *testing.T) {
+	input := `enum Color { Red, Green, Blue }
+enum Level {
+    Low = 10,
+    Mid,
+    High = -1,
+}
+@s push:Color.Blue
+var x i64 = Level.Mid`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(prog.Stmts))
+	}
+	op, ok := prog.Stmts[0].(*ast.StackOp)
+	if !ok || len(op.Args) != 1 {
+		t.Fatalf("expected push, got %#v", prog.Stmts[0])
+	}
+	if lit, ok := op.Args[0].(*ast.IntLit); !ok || lit.Value != 2 {
+		t.Errorf("Color.Blue = %#v, want 2", op.Args[0])
+	}
+	decl, ok := prog.Stmts[1].(*ast.VarDecl)
+	if !ok || len(decl.Values) != 1 {
+		t.Fatalf("expected var, got %#v", prog.Stmts[1])
+	}
+	if lit, ok := decl.Values[0].(*ast.IntLit); !ok || lit.Value != 11 {
+		t.Errorf("Level.Mid = %#v, want 11", decl.Values[0])
+	}
+}
+
+func TestParseEnumDeclErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		errContains string
+	}{
+		{"enum E { A }\nenum E { B }", "enum E already defined"},
+		{"enum E { A, A }", "enum E has two members named A"},
+		{"enum E { }", "enum E has no members"},
+		{"enum E { A = x }", "expected integer value for E.A"},
+		{"enum E { A }\npush:E.B", "enum E has no member B"},
+	}
+
+	for _, tc := range tests {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("input %q: error %v should contain %q", tc.input, err, tc.errContains)
+		}
+	}
+}
+
+func TestParseTestBlock(t *testing.T) {
+	input := `var test = 1
+test "adds" {
+    push:2 push:3 add let:x
+    assert(x == 5 && test == 1, "2 + 3")
+    assert(x)
+}`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(prog.Stmts))
+	}
+	block, ok := prog.Stmts[1].(*ast.TestBlock)
+	if !ok || block.Name != "adds" || len(block.Body) != 3 {
+		t.Fatalf("expected test block, got %#v", prog.Stmts[1])
+	}
+	call, ok := block.Body[1].(*ast.FuncCall)
+	if !ok || call.Name != "assert" || len(call.Args) != 2 {
+		t.Fatalf("expected assert with a message, got %#v", block.Body[1])
+	}
+	if cond, ok := call.Args[0].(*ast.BinaryExpr); !ok || cond.Op != "&&" {
+		t.Errorf("expected && condition, got %#v", call.Args[0])
+	}
+	if call, ok := block.Body[2].(*ast.FuncCall); !ok || len(call.Args) != 1 {
+		t.Errorf("expected assert without a message, got %#v", block.Body[2])
+	}
+
+	prog, err = NewParser(tokenize("bench \"pushes\" {\n    push:1 drop\n}")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, ok := prog.Stmts[0].(*ast.BenchBlock); !ok || b.Name != "pushes" || len(b.Body) != 1 {
+		t.Errorf("expected bench block, got %#v", prog.Stmts[0])
+	}
+
+	for _, tc := range []struct{ input, errContains string }{
+		{"func f() {\n    test \"t\" { }\n}", "test must be at the top level"},
+		{"if (true) {\n    bench \"b\" { }\n}", "bench must be at the top level"},
+		{"assert(x == 1 \"msg\")", "expected ')' after assert condition"},
+	} {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("input %q: error %v should contain %q", tc.input, err, tc.errContains)
+		}
+	}
+}
+
+func TestParseConstDecl(t *testing.T) {
+	input := `const N = 4
+const SIZE = N * 256 + 1
+const RATIO = SIZE / 2.0
+const NAME = "a" + "b"
+const ROOT = sqrt(float(N)) * 3
+@s = stack.new(i64, cap: N)
+push:SIZE
+var x = RATIO
+var s = NAME
+var r = ROOT`
+	prog, err := NewParser(tokenize(input)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prog.Stmts) != 5 {
+		t.Fatalf("expected 5 statements, got %d", len(prog.Stmts))
+	}
+	if decl, ok := prog.Stmts[0].(*ast.StackDecl); !ok || decl.Capacity != 4 {
+		t.Errorf("expected capacity 4, got %#v", prog.Stmts[0])
+	}
+	if op, ok := prog.Stmts[1].(*ast.StackOp); !ok || len(op.Args) != 1 {
+		t.Errorf("expected push, got %#v", prog.Stmts[1])
+	} else if lit, ok := op.Args[0].(*ast.IntLit); !ok || lit.Value != 1025 {
+		t.Errorf("SIZE = %#v, want 1025", op.Args[0])
+	}
+	ratio := prog.Stmts[2].(*ast.VarDecl).Values[0]
+	if lit, ok := ratio.(*ast.FloatLit); !ok || lit.Value != 512.5 {
+		t.Errorf("RATIO = %#v, want 512.5", ratio)
+	}
+	name := prog.Stmts[3].(*ast.VarDecl).Values[0]
+	if lit, ok := name.(*ast.StringLit); !ok || lit.Value != "ab" {
+		t.Errorf("NAME = %#v, want \"ab\"", name)
+	}
+	root := prog.Stmts[4].(*ast.VarDecl).Values[0]
+	if lit, ok := root.(*ast.FloatLit); !ok || lit.Value != 6 {
+		t.Errorf("ROOT = %#v, want 6.0", root)
+	}
+}
+
+func TestParseConstDeclErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		errContains string
+	}{
+		{"const N = 1\nconst N = 2", "const N already defined"},
+		{"var x = 1\nconst N = x + 1", "const N: value must be a constant expression"},
+		{"const N = 1 / 0", "const N: division by zero"},
+		{"const N = \"a\" * 2", "const N: cannot apply *"},
+		{"const N = sqrt(0.0 - 1.0)", "const N: NaN is not a finite number"},
+		{"func f() i64 {\n  return 1\n}\nconst N = f()", "const N: value must be a constant expression"},
+		{"const N = 1\nN = 2", "cannot assign to const N"},
+		{"func f() {\n  const N = 1\n}", "const must be at the top level"},
+		{"@s = stack.new(i64, cap: n)", "capacity must be an integer literal or const"},
+	}
+
+	for _, tc := range tests {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("input %q: error %v should contain %q", tc.input, err, tc.errContains)
+		}
+	}
+}
+
+func TestParseEmbedDecl(t *testing.T) {
+	prog, err := NewParser(tokenize("embed \"a.txt\" as @a\nembed \"b.txt\" as @b string\nembed \"c.txt\" as @c lines\n")).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ast.StackDecl{
+		{Name: "a", ElementType: "bytes", Embed: &ast.Embed{Path: "a.txt"}},
+		{Name: "b", ElementType: "string", Embed: &ast.Embed{Path: "b.txt"}},
+		{Name: "c", ElementType: "string", Perspective: "FIFO", Embed: &ast.Embed{Path: "c.txt", Lines: true}},
+	}
+	if len(prog.Stmts) != len(want) {
+		t.Fatalf("expected %d statements, got %d", len(want), len(prog.Stmts))
+	}
+	for n, w := range want {
+		d, ok := prog.Stmts[n].(*ast.StackDecl)
+		if !ok || d.Embed == nil || d.Name != w.Name || d.ElementType != w.ElementType ||
+			d.Perspective != w.Perspective || *d.Embed != *w.Embed {
+			t.Errorf("statement %d = %#v, want %#v", n, prog.Stmts[n], w)
+		}
+	}
+
+	for _, tc := range []struct{ input, errContains string }{
+		{"func f() {\n    embed \"a.txt\" as @a\n}", "embed must be at the top level"},
+		{"embed \"a.txt\" @a", "expected 'as @name'"},
+		{"embed \"a.txt\" as a", "expected @stackname"},
+	} {
+		_, err := NewParser(tokenize(tc.input)).Parse()
+		if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("input %q: error %v should contain %q", tc.input, err, tc.errContains)
+		}
+	}
+}
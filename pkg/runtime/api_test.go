@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The exported API of this package is recorded in testdata/api.txt. Code
+// generated by older compilers must keep building against newer runtimes,
+// so TestAPI fails if anything in that file is removed or changed. New API
+// is added to the file with
+//
+//	go test ./pkg/runtime -run TestAPI -update-api
+//
+// and reviewed like any other change.
+var updateAPI = flag.Bool("update-api", false, "rewrite testdata/api.txt from the current API")
+
+// experimental names are exported for benchmarks and are not covered by
+// the compatibility guarantee
+var experimental = map[string]bool{
+	"Int64Stack": true, "NewInt64Stack": true, "NewCappedInt64Stack": true,
+	"Int64View": true, "NewInt64View": true,
+	"WSStack": true, "NewWSStack": true, "NewWSStackCapped": true,
+	"NewWSStackCappedWithBufSize": true, "Task": true,
+}
+
+const apiFile = "testdata/api.txt"
+
+func TestAPI(t *testing.T) {
+	got, err := exportedAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateAPI {
+		out := "# Exported API of github.com/ha1tch/ual/pkg/runtime. See api_test.go.\n" +
+			strings.Join(got, "\n") + "\n"
+		if err := os.WriteFile(apiFile, []byte(out), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := readAPI(apiFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := make(map[string]bool, len(got))
+	for _, line := range got {
+		have[line] = true
+	}
+	recorded := make(map[string]bool, len(want))
+	for _, line := range want {
+		recorded[line] = true
+		if !have[line] {
+			t.Errorf("removed or changed: %s", line)
+		}
+	}
+	for _, line := range got {
+		if !recorded[line] {
+			t.Errorf("not in %s (run with -update-api to add it): %s", apiFile, line)
+		}
+	}
+}
+
+// exportedAPI lists the package's exported API, one declaration, field or
+// method per line, sorted
+func exportedAPI() ([]string, error) {
+	fset := token.NewFileSet()
+	pkg, err := importer.ForCompiler(fset, "source", nil).Import("github.com/ha1tch/ual/pkg/runtime")
+	if err != nil {
+		return nil, err
+	}
+	qual := types.RelativeTo(pkg)
+
+	var lines []string
+	for _, name := range pkg.Scope().Names() {
+		obj := pkg.Scope().Lookup(name)
+		if !obj.Exported() || experimental[name] {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Const:
+			lines = append(lines, fmt.Sprintf("%s = %s", types.ObjectString(obj, qual), obj.Val()))
+		case *types.TypeName:
+			lines = append(lines, typeAPI(obj, qual)...)
+		default:
+			lines = append(lines, types.ObjectString(obj, qual))
+		}
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
+
+// typeAPI describes a named type: its kind, then its exported fields and
+// the exported methods of the type and its pointer
+func typeAPI(obj *types.TypeName, qual types.Qualifier) []string {
+	name := obj.Name()
+	if obj.IsAlias() {
+		return []string{fmt.Sprintf("type %s = %s", name, types.TypeString(obj.Type(), qual))}
+	}
+
+	var lines []string
+	switch u := obj.Type().Underlying().(type) {
+	case *types.Struct:
+		lines = append(lines, fmt.Sprintf("type %s struct", name))
+		for i := 0; i < u.NumFields(); i++ {
+			if f := u.Field(i); f.Exported() {
+				lines = append(lines, fmt.Sprintf("type %s struct, %s %s", name, f.Name(), types.TypeString(f.Type(), qual)))
+			}
+		}
+	case *types.Interface:
+		lines = append(lines, fmt.Sprintf("type %s interface", name))
+		for i := 0; i < u.NumMethods(); i++ {
+			m := u.Method(i)
+			lines = append(lines, fmt.Sprintf("type %s interface, %s%s", name, m.Name(),
+				strings.TrimPrefix(types.TypeString(m.Type(), qual), "func")))
+		}
+		return lines
+	default:
+		lines = append(lines, fmt.Sprintf("type %s %s", name, types.TypeString(u, qual)))
+	}
+
+	mset := types.NewMethodSet(types.NewPointer(obj.Type()))
+	for i := 0; i < mset.Len(); i++ {
+		if m := mset.At(i).Obj(); m.Exported() {
+			lines = append(lines, types.ObjectString(m, qual))
+		}
+	}
+	return lines
+}
+
+// readAPI reads an API file, skipping blank lines and # comments
+func readAPI(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
@@ -0,0 +1,277 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Command-line arguments (args blocks)
+//
+//   args "tool" {
+//       flag "verbose" v bool "print more"
+//       opt "output" o string = "out.txt"
+//       pos "input" string
+//   }
+//
+// Flags are --name or -n and take no value (--name=false is accepted).
+// Options take a value as --name v, --name=v, -n v or -nv. Positionals are
+// filled in order; one without a default is required. "--" ends option
+// parsing. -h/--help prints usage unless an entry claims that name.
+// ============================================================================
+
+// ErrHelp is returned by ParseArgs when -h or --help is given.
+var ErrHelp = errors.New("help requested")
+
+// ArgSpec describes one entry of an args block.
+type ArgSpec struct {
+	Kind       string // "flag", "opt" or "pos"
+	Name       string
+	Short      string // single letter, "" if none
+	Type       string // "bool", "string", "i64" or "f64"
+	Default    string // string form of the default
+	HasDefault bool
+	Help       string
+}
+
+// Args holds parsed argument values in string form, keyed by name.
+type Args struct {
+	specs  []ArgSpec
+	values map[string]string
+}
+
+// ParseArgs parses argv (without the program name) against specs.
+func ParseArgs(specs []ArgSpec, argv []string) (*Args, error) {
+	a := &Args{specs: specs, values: make(map[string]string, len(specs))}
+	var positionals []*ArgSpec
+	for i := range specs {
+		sp := &specs[i]
+		switch {
+		case sp.HasDefault:
+			a.values[sp.Name] = sp.Default
+		case sp.Type == "bool":
+			a.values[sp.Name] = "false"
+		case sp.Type == "i64" || sp.Type == "f64":
+			a.values[sp.Name] = "0"
+		default:
+			a.values[sp.Name] = ""
+		}
+		if sp.Kind == "pos" {
+			positionals = append(positionals, sp)
+		}
+	}
+
+	npos := 0
+	optionsDone := false
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+
+		if optionsDone || arg == "-" || !strings.HasPrefix(arg, "-") || isNumeric(arg) {
+			if npos >= len(positionals) {
+				return nil, fmt.Errorf("unexpected argument %q", arg)
+			}
+			if err := a.set(positionals[npos], positionals[npos].Name, arg); err != nil {
+				return nil, err
+			}
+			npos++
+			continue
+		}
+		if arg == "--" {
+			optionsDone = true
+			continue
+		}
+
+		// --name[=value] or -n[value]
+		var sp *ArgSpec
+		var label, value string
+		var hasValue bool
+		if strings.HasPrefix(arg, "--") {
+			name := arg[2:]
+			name, value, hasValue = strings.Cut(name, "=")
+			label = "--" + name
+			sp = a.lookup(func(s *ArgSpec) bool { return s.Name == name })
+		} else {
+			short := arg[1:2]
+			value = strings.TrimPrefix(arg[2:], "=")
+			hasValue = len(arg) > 2
+			label = "-" + short
+			sp = a.lookup(func(s *ArgSpec) bool { return s.Short == short })
+		}
+		if sp == nil {
+			if label == "--help" || label == "-h" {
+				return nil, ErrHelp
+			}
+			return nil, fmt.Errorf("unknown option %s", label)
+		}
+
+		if sp.Kind == "flag" {
+			if !hasValue {
+				value = "true"
+			}
+		} else if !hasValue {
+			if i+1 >= len(argv) {
+				return nil, fmt.Errorf("%s requires a value", label)
+			}
+			i++
+			value = argv[i]
+		}
+		if err := a.set(sp, label, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sp := range positionals[npos:] {
+		if !sp.HasDefault {
+			return nil, fmt.Errorf("missing argument <%s>", sp.Name)
+		}
+	}
+	return a, nil
+}
+
+// ParseArgsOrExit parses os-style argv, printing usage and exiting on
+// -h/--help (status 0) or on a parse error (status 2). An empty prog uses
+// the executable's name.
+func ParseArgsOrExit(prog string, specs []ArgSpec, argv []string) *Args {
+	if prog == "" {
+		prog = filepath.Base(os.Args[0])
+	}
+	a, err := ParseArgs(specs, argv)
+	if err == ErrHelp {
+		fmt.Fprint(os.Stdout, ArgsUsage(prog, specs))
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n%s", prog, err, ArgsUsage(prog, specs))
+		os.Exit(2)
+	}
+	return a
+}
+
+// PushArgs pushes the program's arguments, without its name, onto the
+// string stack s in the order they were given, and returns how many there
+// were. It is args(@s), for programs that read their arguments without an
+// args block.
+func PushArgs(s *Stack) int64 {
+	for _, arg := range os.Args[1:] {
+		s.Push([]byte(arg))
+	}
+	return int64(len(os.Args) - 1)
+}
+
+func (a *Args) lookup(match func(*ArgSpec) bool) *ArgSpec {
+	for i := range a.specs {
+		if a.specs[i].Kind != "pos" && match(&a.specs[i]) {
+			return &a.specs[i]
+		}
+	}
+	return nil
+}
+
+// set validates value against the spec's type and stores it
+func (a *Args) set(sp *ArgSpec, label, value string) error {
+	var err error
+	switch sp.Type {
+	case "bool":
+		var b bool
+		b, err = strconv.ParseBool(value)
+		value = strconv.FormatBool(b)
+	case "i64":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "f64":
+		_, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: expected %s", value, label, sp.Type)
+	}
+	a.values[sp.Name] = value
+	return nil
+}
+
+// String returns the value of name as given on the command line.
+func (a *Args) String(name string) string {
+	return a.values[name]
+}
+
+// Int returns the value of an i64 argument.
+func (a *Args) Int(name string) int64 {
+	n, _ := strconv.ParseInt(a.values[name], 10, 64)
+	return n
+}
+
+// Float returns the value of an f64 argument.
+func (a *Args) Float(name string) float64 {
+	f, _ := strconv.ParseFloat(a.values[name], 64)
+	return f
+}
+
+// Bool returns the value of a bool argument.
+func (a *Args) Bool(name string) bool {
+	return a.values[name] == "true"
+}
+
+// Fill stores every value in a Hash stack of strings, keyed by name.
+func (a *Args) Fill(s *Stack) error {
+	for _, sp := range a.specs {
+		if err := s.Push([]byte(a.values[sp.Name]), []byte(sp.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArgsUsage formats a usage message for specs.
+func ArgsUsage(prog string, specs []ArgSpec) string {
+	var b strings.Builder
+	b.WriteString("usage: " + prog + " [options]")
+
+	type row struct{ left, help string }
+	var rows []row
+	hasHelp := false
+	for _, sp := range specs {
+		if sp.Kind == "pos" {
+			if sp.HasDefault {
+				fmt.Fprintf(&b, " [%s]", sp.Name)
+			} else {
+				fmt.Fprintf(&b, " <%s>", sp.Name)
+			}
+			continue
+		}
+		left := "    --" + sp.Name
+		if sp.Short != "" {
+			left = "-" + sp.Short + ", --" + sp.Name
+		}
+		if sp.Kind == "opt" {
+			left += " " + sp.Type
+		}
+		help := sp.Help
+		if sp.HasDefault && sp.Kind == "opt" {
+			help = strings.TrimSpace(help + fmt.Sprintf(" (default %q)", sp.Default))
+		}
+		rows = append(rows, row{left, help})
+		hasHelp = hasHelp || sp.Name == "help" || sp.Short == "h"
+	}
+	if !hasHelp {
+		rows = append(rows, row{"-h, --help", "show this help"})
+	}
+	b.WriteString("\n\noptions:\n")
+
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r.left))
+	}
+	for _, r := range rows {
+		line := fmt.Sprintf("  %-*s  %s", width, r.left, r.help)
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return b.String()
+}
+
+// isNumeric reports whether s parses as a number, so "-5" is positional
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,127 @@
+package runtime
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func testArgSpecs() []ArgSpec {
+	return []ArgSpec{
+		{Kind: "flag", Name: "verbose", Short: "v", Type: "bool", Help: "print more"},
+		{Kind: "opt", Name: "output", Short: "o", Type: "string", Default: "out.txt", HasDefault: true},
+		{Kind: "opt", Name: "count", Short: "n", Type: "i64"},
+		{Kind: "pos", Name: "input", Type: "string"},
+		{Kind: "pos", Name: "scale", Type: "f64", Default: "1.5", HasDefault: true},
+	}
+}
+
+func TestPushArgs(t *testing.T) {
+	saved := os.Args
+	os.Args = []string{"prog", "a b", "-v", ""}
+	defer func() { os.Args = saved }()
+
+	s := NewStack(FIFO, TypeString)
+	if n := PushArgs(s); n != 3 {
+		t.Errorf("PushArgs = %d, want 3", n)
+	}
+	for _, want := range []string{"a b", "-v", ""} {
+		if got, _ := s.Pop(); string(got) != want {
+			t.Errorf("popped %q, want %q", got, want)
+		}
+	}
+}
+
+func TestParseArgsDefaults(t *testing.T) {
+	a, err := ParseArgs(testArgSpecs(), []string{"in.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Bool("verbose") || a.String("output") != "out.txt" || a.Int("count") != 0 {
+		t.Errorf("defaults: verbose=%v output=%q count=%d", a.Bool("verbose"), a.String("output"), a.Int("count"))
+	}
+	if a.String("input") != "in.txt" || a.Float("scale") != 1.5 {
+		t.Errorf("positionals: input=%q scale=%v", a.String("input"), a.Float("scale"))
+	}
+}
+
+func TestParseArgsForms(t *testing.T) {
+	tests := []struct {
+		argv    []string
+		verbose bool
+		output  string
+		count   int64
+		input   string
+	}{
+		{[]string{"-v", "-o", "a", "-n", "3", "x"}, true, "a", 3, "x"},
+		{[]string{"--verbose", "--output=b", "--count", "-4", "x"}, true, "b", -4, "x"},
+		{[]string{"-oc", "-n=5", "--verbose=false", "x"}, false, "c", 5, "x"},
+		{[]string{"x", "--", "-2"}, false, "out.txt", 0, "x"},
+		{[]string{"--", "-v"}, false, "out.txt", 0, "-v"},
+	}
+	for _, tc := range tests {
+		a, err := ParseArgs(testArgSpecs(), tc.argv)
+		if err != nil {
+			t.Errorf("%v: %v", tc.argv, err)
+			continue
+		}
+		if a.Bool("verbose") != tc.verbose || a.String("output") != tc.output ||
+			a.Int("count") != tc.count || a.String("input") != tc.input {
+			t.Errorf("%v: got verbose=%v output=%q count=%d input=%q", tc.argv,
+				a.Bool("verbose"), a.String("output"), a.Int("count"), a.String("input"))
+		}
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		argv []string
+		want string
+	}{
+		{nil, "missing argument <input>"},
+		{[]string{"--nope", "x"}, "unknown option --nope"},
+		{[]string{"x", "-o"}, "-o requires a value"},
+		{[]string{"-n", "many", "x"}, `invalid value "many" for -n: expected i64`},
+		{[]string{"x", "2", "3"}, `unexpected argument "3"`},
+	}
+	for _, tc := range tests {
+		_, err := ParseArgs(testArgSpecs(), tc.argv)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%v: got %v, want %q", tc.argv, err, tc.want)
+		}
+	}
+
+	if _, err := ParseArgs(testArgSpecs(), []string{"--help"}); err != ErrHelp {
+		t.Errorf("--help: got %v", err)
+	}
+}
+
+func TestArgsFillAndUsage(t *testing.T) {
+	a, err := ParseArgs(testArgSpecs(), []string{"-v", "in.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStack(Hash, TypeString)
+	if err := a.Fill(s); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Peek([]byte("verbose")); string(v) != "true" {
+		t.Errorf("@args verbose: got %q", v)
+	}
+	if v, _ := s.Peek([]byte("output")); string(v) != "out.txt" {
+		t.Errorf("@args output: got %q", v)
+	}
+
+	usage := ArgsUsage("tool", testArgSpecs())
+	for _, want := range []string{
+		"usage: tool [options] <input> [scale]\n",
+		"  -v, --verbose        print more\n",
+		"  -o, --output string  (default \"out.txt\")\n",
+		"  -n, --count i64\n",
+		"  -h, --help           show this help\n",
+	} {
+		if !strings.Contains(usage, want) {
+			t.Errorf("usage missing %q:\n%s", want, usage)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ============================================================================
+// Exit hooks (@atexit)
+//
+//   @atexit < { close(fd) }
+//
+// Hooks run LIFO, each at most once, when main returns (after its @defer
+// blocks), on exit(code), and on SIGINT and SIGTERM. exit(code) does not
+// unwind, so pending @defer blocks are skipped; anything that must be
+// released on every path belongs in @atexit. Signal handling is installed
+// by the first AtExit call (see HandleInterrupts) and is best-effort: a
+// hook still running when a second signal arrives is not waited for.
+// ============================================================================
+
+var atExit struct {
+	mu      sync.Mutex
+	hooks   []func()
+	running bool // RunAtExit is running hooks
+}
+
+// exitProcess is os.Exit, replaceable in tests
+var exitProcess = os.Exit
+
+// AtExit pushes fn onto the exit hook stack.
+func AtExit(fn func()) {
+	HandleInterrupts()
+	atExit.mu.Lock()
+	atExit.hooks = append(atExit.hooks, fn)
+	atExit.mu.Unlock()
+}
+
+// RunAtExit pops and runs the exit hooks in LIFO order until none are left,
+// including hooks registered by other hooks. A hook that panics is reported
+// on stderr and the rest still run. Safe to call more than once.
+func RunAtExit() {
+	for {
+		atExit.mu.Lock()
+		n := len(atExit.hooks)
+		atExit.running = n > 0
+		if n == 0 {
+			atExit.mu.Unlock()
+			return
+		}
+		fn := atExit.hooks[n-1]
+		atExit.hooks = atExit.hooks[:n-1]
+		atExit.mu.Unlock()
+
+		runExitHook(fn)
+	}
+}
+
+func runExitHook(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "atexit: %v\n", r)
+		}
+	}()
+	fn()
+}
+
+// Exit runs the exit hooks and ends the program with status code.
+func Exit(code int) {
+	RunAtExit()
+	exitProcess(code)
+}
@@ -0,0 +1,42 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunAtExitLIFO(t *testing.T) {
+	var order []int
+	AtExit(func() { order = append(order, 1) })
+	AtExit(func() {
+		order = append(order, 2)
+		AtExit(func() { order = append(order, 4) }) // registered while exiting
+	})
+	AtExit(func() { panic("boom") })
+	AtExit(func() { order = append(order, 3) })
+
+	RunAtExit()
+	if want := []int{3, 2, 4, 1}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got %v, want %v", order, want)
+	}
+
+	RunAtExit() // hooks run once
+	if len(order) != 4 {
+		t.Errorf("hooks ran again: %v", order)
+	}
+}
+
+func TestExitRunsHooks(t *testing.T) {
+	saved := exitProcess
+	defer func() { exitProcess = saved }()
+
+	code := -1
+	exitProcess = func(c int) { code = c }
+	ran := false
+	AtExit(func() { ran = true })
+
+	Exit(3)
+	if !ran || code != 3 {
+		t.Errorf("Exit(3): hook ran=%v, code=%d", ran, code)
+	}
+}
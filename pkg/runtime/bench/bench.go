@@ -0,0 +1,107 @@
+// Package bench runs the bench blocks of ual programs built by ual bench.
+//
+//	bench "name" { ... }
+//
+// Each bench block runs through Run, which times the body with
+// testing.Benchmark: the body runs b.N times, N growing until the run
+// takes $UAL_BENCHTIME (1s unless set; "100x" runs exactly 100
+// iterations). Results go, a line of JSON each, to the file named by
+// $UAL_BENCH_REPORT, or to stderr without it. It is a package of its own
+// so that other programs do not link the testing package.
+package bench
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// ReportEnv is the environment variable naming the bench report file
+const ReportEnv = "UAL_BENCH_REPORT"
+
+// TimeEnv is the environment variable holding the time, or the
+// iteration count with an x suffix, each benchmark runs for
+const TimeEnv = "UAL_BENCHTIME"
+
+// Result is the outcome of one bench block
+type Result struct {
+	Name        string  `json:"name"`
+	File        string  `json:"file"`
+	Line        int     `json:"line"`
+	N           int     `json:"n"` // iterations timed
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	Message     string  `json:"message,omitempty"` // why it failed
+}
+
+var timeOnce sync.Once
+
+// setTime passes $UAL_BENCHTIME to testing.Benchmark, which reads
+// the -test.benchtime flag
+func setTime() {
+	timeOnce.Do(func() {
+		v := os.Getenv(TimeEnv)
+		if v == "" {
+			return
+		}
+		testing.Init()
+		if err := flag.Set("test.benchtime", v); err != nil {
+			fmt.Fprintf(os.Stderr, "ual: %s: %v\n", TimeEnv, err)
+		}
+	})
+}
+
+// Run times body as the bench block name, declared at file and line,
+// and reports the result. A panic in the body ends the benchmark and is
+// its message.
+func Run(name, file string, line int, body func()) Result {
+	setTime()
+	r := Result{Name: name, File: file, Line: line}
+	res := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		defer func() {
+			if v := recover(); v != nil {
+				r.Message = fmt.Sprint(v)
+				b.FailNow()
+			}
+		}()
+		for i := 0; i < b.N; i++ {
+			body()
+		}
+	})
+	if r.Message == "" && res.N == 0 {
+		r.Message = "benchmark failed"
+	}
+	if res.N > 0 {
+		r.N = res.N
+		r.NsPerOp = float64(res.T.Nanoseconds()) / float64(res.N)
+		r.BytesPerOp = res.AllocedBytesPerOp()
+		r.AllocsPerOp = res.AllocsPerOp()
+	}
+	report(r)
+	return r
+}
+
+func report(r Result) {
+	path := os.Getenv(ReportEnv)
+	if path == "" {
+		if r.Message != "" {
+			fmt.Fprintf(os.Stderr, "FAIL %s\n  %s\n", r.Name, r.Message)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s\t%d\t%.2f ns/op\t%d B/op\t%d allocs/op\n", r.Name, r.N, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ual: bench report: %v\n", err)
+		return
+	}
+	defer f.Close()
+	data, _ := json.Marshal(r)
+	f.Write(append(data, '\n'))
+}
@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+func TestRun(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "report.jsonl")
+	t.Setenv(ReportEnv, report)
+
+	s := runtime.NewStack(runtime.LIFO, runtime.TypeInt64)
+	one := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	calls := 0
+	r := Run("push", "a_test.ual", 3, func() {
+		calls++
+		s.Push(one)
+		s.Pop()
+	})
+	if r.Message != "" || r.N == 0 || r.NsPerOp <= 0 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if calls < r.N {
+		t.Errorf("body ran %d times for N = %d", calls, r.N)
+	}
+
+	crash := Run("crash", "a_test.ual", 9, func() { panic("boom") })
+	if crash.Message != "boom" || crash.N != 0 {
+		t.Errorf("unexpected result %+v", crash)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 results in the report, got %d:\n%s", len(lines), data)
+	}
+	var got Result
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "push" || got.Line != 3 || got.N != r.N {
+		t.Errorf("unexpected report %+v", got)
+	}
+}
@@ -36,6 +36,26 @@ func BenchmarkPushHash(b *testing.B) {
 	}
 }
 
+func BenchmarkPushPopUnsafe(b *testing.B) {
+	s := NewUnsafeStack(LIFO, TypeInt64)
+	data := intToBytes(42)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Push(data)
+		s.Pop()
+	}
+}
+
+func BenchmarkPushPopLocked(b *testing.B) {
+	s := NewStack(LIFO, TypeInt64)
+	data := intToBytes(42)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Push(data)
+		s.Pop()
+	}
+}
+
 // Pop benchmarks
 
 func BenchmarkPopLIFO(b *testing.B) {
@@ -0,0 +1,171 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// Bridges
+//
+//   @telemetry bridge("mqtt://broker:1883/sensors/temp")
+//
+// A bridged stack keeps working as before, and also sends the text of each
+// value pushed to it somewhere else, and pushes what arrives from there. A
+// bridge is opened by the function registered for its URL scheme; the
+// runtime registers "mqtt" and "mqtts" (mqtt.go), and a Go program that
+// embeds the runtime can add its own with RegisterBridge.
+//
+// Values travel as text, the form write_csv writes and read_csv reads:
+// "42", "2.5", "true", and strings and bytes as they are. A message whose
+// text is not a value of the stack's element type is dropped. Values that
+// arrive through the bridge are not sent back through it.
+// ============================================================================
+
+// Bridge carries a stack's pushes to somewhere else
+type Bridge interface {
+	// Send is called with the text of each value pushed to the stack,
+	// with the stack locked: it must not block.
+	Send(payload []byte)
+	// Close sends what Send was given and not yet sent, as far as it
+	// can, and stops the bridge.
+	Close() error
+}
+
+// BridgeFunc opens a bridge to u. It passes each message that arrives to
+// deliver, which pushes it to the stack.
+type BridgeFunc func(u *url.URL, deliver func(payload []byte)) (Bridge, error)
+
+var bridges = struct {
+	mu    sync.RWMutex
+	funcs map[string]BridgeFunc
+}{funcs: map[string]BridgeFunc{}}
+
+// RegisterBridge makes open the function that opens bridges with URLs of
+// the given scheme, replacing any registered before.
+func RegisterBridge(scheme string, open BridgeFunc) {
+	bridges.mu.Lock()
+	defer bridges.mu.Unlock()
+	bridges.funcs[strings.ToLower(scheme)] = open
+}
+
+// stackBridge is a stack's open bridge and how it converts between
+// elements and text
+type stackBridge struct {
+	b    Bridge
+	text func(data []byte) []byte // element to message
+}
+
+// Bridge opens the bridge to rawURL and attaches it to s. Only LIFO, FIFO
+// and Indexed stacks can be bridged, and only once. The bridge is closed
+// when the program ends.
+func (s *Stack) Bridge(rawURL string) error {
+	t := s.elementType
+	text := func(data []byte) []byte { return []byte(formatElement(data, t)) }
+	return s.openBridge(rawURL, text, func(payload []byte) ([]byte, error) {
+		return csvElement(string(payload), t)
+	})
+}
+
+// BridgeTo is Bridge with the error pushed to errStack, if it is not nil,
+// instead of returned.
+func (s *Stack) BridgeTo(rawURL string, errStack *Stack) {
+	if err := s.Bridge(rawURL); err != nil && errStack != nil {
+		errStack.Push([]byte(err.Error()))
+	}
+}
+
+// Bridge is Stack.Bridge for the interpreter: t is the element type of the
+// stack in the program.
+func (vs *ValueStack) Bridge(rawURL string, t ElementType) error {
+	text := func(data []byte) []byte {
+		return []byte(formatElement(valueElement(ValueFromBytes(data), t), t))
+	}
+	return vs.stack.openBridge(rawURL, text, func(payload []byte) ([]byte, error) {
+		data, err := csvElement(string(payload), t)
+		if err != nil {
+			return nil, err
+		}
+		return elementValue(data, t).ToBytes(), nil
+	})
+}
+
+func (s *Stack) openBridge(rawURL string, text func([]byte) []byte, element func([]byte) ([]byte, error)) error {
+	if s.perspective != LIFO && s.perspective != FIFO && s.perspective != Indexed {
+		return errors.New("bridge: requires a LIFO, FIFO or Indexed stack")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("bridge: %v", err)
+	}
+	bridges.mu.RLock()
+	open, ok := bridges.funcs[strings.ToLower(u.Scheme)]
+	bridges.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("bridge: no bridge for scheme %q (have %s)", u.Scheme, bridgeSchemes())
+	}
+
+	s.mu.RLock()
+	bridged := s.bridge != nil
+	s.mu.RUnlock()
+	if bridged {
+		return errors.New("bridge: the stack is already bridged")
+	}
+	b, err := open(u, func(payload []byte) {
+		if data, err := element(payload); err == nil {
+			s.inject(data)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("bridge: %v", err)
+	}
+
+	s.mu.Lock()
+	if s.bridge != nil {
+		s.mu.Unlock()
+		b.Close()
+		return errors.New("bridge: the stack is already bridged")
+	}
+	s.bridge = &stackBridge{b: b, text: text}
+	s.mu.Unlock()
+	AtExit(func() { b.Close() })
+	return nil
+}
+
+// inject pushes a value that arrived through the bridge without sending
+// it back. It is dropped if s cannot take it: frozen, full or mocked.
+func (s *Stack) inject(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.allows(freezeAdd) || s.mocked {
+		return
+	}
+	if s.capacity > 0 && len(s.elements)-s.head >= s.capacity {
+		return
+	}
+	s.elements = append(s.elements, Element{data: s.pack(data)})
+	s.keys = append(s.keys, nil)
+	s.version++
+	s.cond.Broadcast()
+}
+
+// send passes a pushed value to the bridge. Caller holds s.mu.
+func (sb *stackBridge) send(data []byte) {
+	sb.b.Send(sb.text(data))
+}
+
+// bridgeSchemes lists the registered schemes for error messages
+func bridgeSchemes() string {
+	bridges.mu.RLock()
+	defer bridges.mu.RUnlock()
+	schemes := make([]string, 0, len(bridges.funcs))
+	for s := range bridges.funcs {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return strings.Join(schemes, ", ")
+}
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// loopBridge records what it is sent and lets the test deliver messages
+type loopBridge struct {
+	mu      sync.Mutex
+	sent    []string
+	deliver func([]byte)
+	closed  bool
+}
+
+func (b *loopBridge) Send(payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, string(payload))
+}
+
+func (b *loopBridge) Close() error {
+	b.closed = true
+	return nil
+}
+
+func openLoop() *loopBridge {
+	b := &loopBridge{}
+	RegisterBridge("loop", func(u *url.URL, deliver func([]byte)) (Bridge, error) {
+		if u.Host != "here" {
+			return nil, errors.New("nobody there")
+		}
+		b.deliver = deliver
+		return b, nil
+	})
+	return b
+}
+
+func TestBridge(t *testing.T) {
+	b := openLoop()
+	s := NewStack(FIFO, TypeInt32)
+	if err := s.Bridge("loop://here/x"); err != nil {
+		t.Fatal(err)
+	}
+	s.Push(intToBytes(-4))
+	b.deliver([]byte("17"))
+	b.deliver([]byte("not a number")) // dropped
+	s.Push(intToBytes(5))
+
+	if strings.Join(b.sent, " ") != "-4 5" {
+		t.Errorf("sent %q", b.sent)
+	}
+	if s.ToJSON() != "[-4,17,5]" {
+		t.Errorf("stack holds %s", s.ToJSON())
+	}
+	if err := s.Bridge("loop://here/x"); err == nil || !strings.Contains(err.Error(), "already bridged") {
+		t.Errorf("bridging twice: %v", err)
+	}
+
+	s.FreezeWith(FreezeAll)
+	b.deliver([]byte("9"))
+	if s.Len() != 3 {
+		t.Errorf("delivered to a frozen stack: %s", s.ToJSON())
+	}
+}
+
+func TestBridgeErrors(t *testing.T) {
+	openLoop()
+	errs := NewStack(LIFO, TypeString)
+	cases := []struct {
+		s    *Stack
+		url  string
+		want string
+	}{
+		{NewStack(LIFO, TypeString), "carrier-pigeon://coop/x", `no bridge for scheme "carrier-pigeon"`},
+		{NewStack(LIFO, TypeString), "loop://there/x", "nobody there"},
+		{NewStack(Hash, TypeString), "loop://here/x", "LIFO, FIFO or Indexed"},
+		{NewStack(LIFO, TypeString), "mqtt:///x", "no broker"},
+		{NewStack(LIFO, TypeString), "mqtt://broker", "no topic"},
+		{NewStack(LIFO, TypeString), "mqtt://broker/a/+/c", "not a topic to publish to"},
+	}
+	for _, c := range cases {
+		c.s.BridgeTo(c.url, errs)
+		msg, _ := errs.Pop()
+		if !strings.HasPrefix(string(msg), "bridge: ") || !strings.Contains(string(msg), c.want) {
+			t.Errorf("bridge(%q): %q, want %q", c.url, msg, c.want)
+		}
+	}
+}
+
+func TestValueStackBridge(t *testing.T) {
+	b := openLoop()
+	vs := NewValueStack(LIFO)
+	if err := vs.Bridge("loop://here/x", TypeFloat64); err != nil {
+		t.Fatal(err)
+	}
+	vs.Push(NewFloat(2.5))
+	b.deliver([]byte("0.25"))
+	if len(b.sent) != 1 || b.sent[0] != "2.5" {
+		t.Errorf("sent %q", b.sent)
+	}
+	if v, _ := vs.Pop(); v.AsFloat() != 0.25 {
+		t.Errorf("delivered %v", v)
+	}
+}
@@ -23,12 +23,28 @@ func (e *BringError) Error() string {
 // Optional params: for hash destination, first param is key.
 // For type conversions, additional params may specify conversion mode (e.g., base for string->int).
 func (dest *Stack) Bring(source *Stack, params ...[]byte) error {
+	return dest.BringWith(source, nil, nil, params...)
+}
+
+// BringWith is Bring with an optional transform and predicate. pred sees
+// the source element; if it returns false the element is taken from source
+// and dropped, and dest is unchanged. Otherwise fn, if given, produces the
+// element to add to dest in dest's type, replacing the type conversion. If
+// fn fails, neither stack changes. Both stacks are locked while fn and pred
+// run, so they must not use them.
+func (dest *Stack) BringWith(source *Stack, fn WalkFunc, pred func([]byte) bool, params ...[]byte) error {
 	// Lock both stacks (consistent order: source first)
 	source.mu.Lock()
 	defer source.mu.Unlock()
 	dest.mu.Lock()
 	defer dest.mu.Unlock()
 	
+	if source.perspective == Broadcast {
+		return &BringError{source, dest, nil, errBroadcastRead.Error()}
+	}
+	if !source.allows(freezeRemove) {
+		return &BringError{source, dest, nil, ErrFrozen.Error()}
+	}
 	srcSize := len(source.elements) - source.head
 	if srcSize == 0 {
 		return &BringError{source, dest, nil, "source stack empty"}
@@ -47,13 +63,24 @@ func (dest *Stack) Bring(source *Stack, params ...[]byte) error {
 	}
 	
 	srcElem := source.elements[srcIdx]
-	srcData := srcElem.data
+	srcData := source.unpack(srcElem.data)
+	
+	if pred != nil && !pred(srcData) {
+		source.bringRemove(srcIdx)
+		source.version++
+		return nil
+	}
 	
 	// Type conversion if needed
 	var destData []byte
 	var err error
 	
-	if source.elementType == dest.elementType {
+	if fn != nil {
+		destData, err = fn(srcData)
+		if err != nil {
+			return &BringError{source, dest, srcData, err.Error()}
+		}
+	} else if source.elementType == dest.elementType {
 		// Same type, no conversion
 		destData = srcData
 	} else {
@@ -75,43 +102,60 @@ func (dest *Stack) Bring(source *Stack, params ...[]byte) error {
 		}
 	}
 	
+	if !dest.allowsSet(destKey) {
+		return &BringError{source, dest, srcData, ErrFrozen.Error()}
+	}
+	
 	// Now we commit: remove from source, add to dest
 	// This is the atomic part - we've validated everything
 	
-	// Remove from source (O(1) for LIFO and FIFO)
-	switch source.perspective {
-	case LIFO:
-		source.elements = source.elements[:srcIdx]
-		source.keys = source.keys[:srcIdx]
-	case FIFO:
-		source.head++
-	case Indexed:
-		source.elements = append(source.elements[:srcIdx], source.elements[srcIdx+1:]...)
-		source.keys = append(source.keys[:srcIdx], source.keys[srcIdx+1:]...)
-	case Hash:
-		if source.keys[srcIdx] != nil {
-			delete(source.hashIdx, string(source.keys[srcIdx]))
-		}
-		source.elements[srcIdx] = Element{}
-		source.keys[srcIdx] = nil
-	}
+	source.bringRemove(srcIdx)
 	
 	// Add to dest
-	newElem := Element{data: destData}
+	newElem := Element{data: dest.pack(destData)}
 	dest.elements = append(dest.elements, newElem)
 	dest.keys = append(dest.keys, destKey)
 	if dest.perspective == Hash && destKey != nil {
 		dest.hashIdx[string(destKey)] = len(dest.elements) - 1
 	}
+	if dest.bridge != nil {
+		dest.bridge.send(destData)
+	}
+	source.version++
+	dest.version++
 	
 	return nil
 }
 
+// bringRemove removes the element at idx (O(1) for LIFO and FIFO).
+// Caller holds s.mu.
+func (s *Stack) bringRemove(idx int) {
+	switch s.perspective {
+	case LIFO:
+		s.elements = s.elements[:idx]
+		s.keys = s.keys[:idx]
+	case FIFO:
+		s.head++
+	case Indexed:
+		s.elements = append(s.elements[:idx], s.elements[idx+1:]...)
+		s.keys = append(s.keys[:idx], s.keys[idx+1:]...)
+	case Hash:
+		if s.keys[idx] != nil {
+			delete(s.hashIdx, string(s.keys[idx]))
+		}
+		s.elements[idx] = Element{}
+		s.keys[idx] = nil
+	}
+}
+
 // convert transforms data from one type to another
 func convert(data []byte, from, to ElementType, params [][]byte) ([]byte, error) {
+	from, to = wideType(from), wideType(to) // compact stacks convert in their API encoding
 	switch from {
 	case TypeInt64:
 		return convertFromInt64(data, to, params)
+	case TypeUint64:
+		return convertFromUint64(data, to, params)
 	case TypeFloat64:
 		return convertFromFloat64(data, to, params)
 	case TypeString:
@@ -128,7 +172,7 @@ func convertFromInt64(data []byte, to ElementType, params [][]byte) ([]byte, err
 	val := bytesToInt(data)
 	
 	switch to {
-	case TypeInt64:
+	case TypeInt64, TypeUint64:
 		return data, nil
 	case TypeFloat64:
 		f := float64(val)
@@ -151,6 +195,31 @@ func convertFromInt64(data []byte, to ElementType, params [][]byte) ([]byte, err
 	return nil, errors.New("unknown target type")
 }
 
+func convertFromUint64(data []byte, to ElementType, params [][]byte) ([]byte, error) {
+	val := uint64(bytesToInt(data))
+	
+	switch to {
+	case TypeUint64, TypeInt64:
+		return data, nil
+	case TypeFloat64:
+		return float64ToBytes(float64(val)), nil
+	case TypeString:
+		base := 10
+		if len(params) > 0 {
+			base = int(bytesToInt(params[0]))
+		}
+		return []byte(strconv.FormatUint(val, base)), nil
+	case TypeBytes:
+		return data, nil
+	case TypeBool:
+		if val == 0 {
+			return []byte{0}, nil
+		}
+		return []byte{1}, nil
+	}
+	return nil, errors.New("unknown target type")
+}
+
 func convertFromFloat64(data []byte, to ElementType, params [][]byte) ([]byte, error) {
 	val := bytesToFloat64(data)
 	
@@ -160,6 +229,11 @@ func convertFromFloat64(data []byte, to ElementType, params [][]byte) ([]byte, e
 	case TypeInt64:
 		// Default: truncate. Could use params for floor/ceil/round
 		return intToBytes(int64(val)), nil
+	case TypeUint64:
+		if val < 0 {
+			return nil, errors.New("cannot convert negative float to unsigned")
+		}
+		return intToBytes(int64(uint64(val))), nil
 	case TypeString:
 		s := strconv.FormatFloat(val, 'f', -1, 64)
 		return []byte(s), nil
@@ -190,6 +264,16 @@ func convertFromString(data []byte, to ElementType, params [][]byte) ([]byte, er
 			return nil, errors.New("cannot parse string as integer: " + err.Error())
 		}
 		return intToBytes(val), nil
+	case TypeUint64:
+		base := 10
+		if len(params) > 0 {
+			base = int(bytesToInt(params[0]))
+		}
+		val, err := strconv.ParseUint(s, base, 64)
+		if err != nil {
+			return nil, errors.New("cannot parse string as unsigned integer: " + err.Error())
+		}
+		return intToBytes(int64(val)), nil
 	case TypeFloat64:
 		val, err := strconv.ParseFloat(s, 64)
 		if err != nil {
@@ -214,7 +298,7 @@ func convertFromBytes(data []byte, to ElementType, params [][]byte) ([]byte, err
 	switch to {
 	case TypeBytes:
 		return data, nil
-	case TypeInt64:
+	case TypeInt64, TypeUint64:
 		return data, nil // interpret bytes as int
 	case TypeFloat64:
 		return data, nil // interpret bytes as float
@@ -235,7 +319,7 @@ func convertFromBool(data []byte, to ElementType, params [][]byte) ([]byte, erro
 	switch to {
 	case TypeBool:
 		return data, nil
-	case TypeInt64:
+	case TypeInt64, TypeUint64:
 		if val {
 			return intToBytes(1), nil
 		}
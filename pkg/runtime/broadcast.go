@@ -0,0 +1,91 @@
+package runtime
+
+import "errors"
+
+// Broadcast stacks fan out: every element pushed is delivered to each view
+// attached with the Broadcast perspective, and each view reads the elements
+// in push order at its own pace. The stack keeps one shared log plus a
+// cursor per subscribed view, so nothing is copied per consumer. An element
+// is dropped once every subscriber has read it, and elements pushed while
+// nobody is subscribed are discarded.
+//
+//	events := NewStack(Broadcast, TypeInt64)
+//	a, b := NewView(Broadcast), NewView(Broadcast)
+//	a.Attach(events)
+//	b.Attach(events)
+//	events.Push(intToBytes(1))
+//	a.Pop() // 1
+//	b.Pop() // 1
+//
+// A subscriber that stops reading holds the log open; Detach it when done.
+// On a capped Broadcast stack the slowest subscriber applies backpressure:
+// pushes fail with "stack is full" until it catches up.
+
+// errBroadcastRead is returned when a Broadcast stack is read directly
+var errBroadcastRead = errors.New("broadcast stack is read through a Broadcast view")
+
+// errBroadcastAttach is returned when a view and stack disagree about Broadcast
+var errBroadcastAttach = errors.New("Broadcast views attach only to Broadcast stacks, and only Broadcast views attach to them")
+
+// broadcastEnd returns the sequence number the next push will get.
+// Must be called with s.mu held
+func (s *Stack) broadcastEnd() uint64 {
+	return s.seq + uint64(len(s.elements)-s.head)
+}
+
+// subscribe registers v to receive elements pushed from now on.
+// Must be called with s.mu held for writing
+func (s *Stack) subscribe(v *View) {
+	if s.subs == nil {
+		s.subs = make(map[*View]uint64)
+	}
+	s.subs[v] = s.broadcastEnd()
+}
+
+// unsubscribe removes v and drops anything only it was still waiting for.
+// Must be called with s.mu held for writing
+func (s *Stack) unsubscribe(v *View) {
+	delete(s.subs, v)
+	s.trimBroadcast()
+}
+
+// trimBroadcast drops the elements every subscriber has already read.
+// Must be called with s.mu held for writing
+func (s *Stack) trimBroadcast() {
+	oldest := s.broadcastEnd()
+	for _, cur := range s.subs {
+		oldest = min(oldest, max(cur, s.seq))
+	}
+	n := int(oldest - s.seq)
+	if n == 0 {
+		return
+	}
+	clear(s.elements[s.head : s.head+n])
+	s.head += n
+	s.seq = oldest
+	if s.head == len(s.elements) || (s.head > len(s.elements)/2 && s.head > 100) {
+		s.compact()
+	}
+}
+
+// broadcastIndex returns the slice index of the next element for v, or
+// false if v has read everything pushed so far.
+// Must be called with s.mu held
+func (s *Stack) broadcastIndex(v *View) (int, bool) {
+	cur, ok := s.subs[v]
+	if !ok {
+		return 0, false
+	}
+	idx := s.head + int(max(cur, s.seq)-s.seq)
+	return idx, idx < len(s.elements)
+}
+
+// broadcastPending returns how many elements v has not read yet.
+// Must be called with s.mu held
+func (s *Stack) broadcastPending(v *View) int {
+	idx, ok := s.broadcastIndex(v)
+	if !ok {
+		return 0
+	}
+	return len(s.elements) - idx
+}
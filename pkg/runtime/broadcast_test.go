@@ -0,0 +1,166 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestBroadcastFanOut(t *testing.T) {
+	s := NewStack(Broadcast, TypeInt64)
+	s.Push(intToBytes(1)) // nobody subscribed yet: dropped
+
+	a, b := NewView(Broadcast), NewView(Broadcast)
+	if err := a.Attach(s); err != nil {
+		t.Fatal(err)
+	}
+	b.Attach(s)
+
+	for i := int64(10); i <= 30; i += 10 {
+		s.Push(intToBytes(i))
+	}
+
+	for _, want := range []int64{10, 20, 30} {
+		val, err := a.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytesToInt(val) != want {
+			t.Errorf("a: expected %d, got %d", want, bytesToInt(val))
+		}
+	}
+	if _, err := a.Pop(); err == nil {
+		t.Error("a: expected empty after reading everything")
+	}
+
+	// b reads independently and still sees every element
+	if b.Remaining() != 3 {
+		t.Errorf("b: expected 3 remaining, got %d", b.Remaining())
+	}
+	val, _ := b.Peek()
+	if bytesToInt(val) != 10 {
+		t.Errorf("b: expected peek 10, got %d", bytesToInt(val))
+	}
+	for _, want := range []int64{10, 20, 30} {
+		val, _ := b.Pop()
+		if bytesToInt(val) != want {
+			t.Errorf("b: expected %d, got %d", want, bytesToInt(val))
+		}
+	}
+}
+
+func TestBroadcastTrim(t *testing.T) {
+	s := NewStack(Broadcast, TypeInt64)
+	a, b := NewView(Broadcast), NewView(Broadcast)
+	a.Attach(s)
+	b.Attach(s)
+
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	a.Pop()
+	if s.Len() != 2 {
+		t.Errorf("expected 2 held for b, got %d", s.Len())
+	}
+	b.Pop()
+	if s.Len() != 1 {
+		t.Errorf("expected 1 held after both read, got %d", s.Len())
+	}
+
+	// Detaching the slow reader releases what only it was waiting for
+	a.Pop()
+	b.Detach()
+	if s.Len() != 0 {
+		t.Errorf("expected empty after detach, got %d", s.Len())
+	}
+
+	// A late subscriber only sees pushes after it attached
+	s.Push(intToBytes(3))
+	c := NewView(Broadcast)
+	c.Attach(s)
+	s.Push(intToBytes(4))
+	val, _ := c.Pop()
+	if bytesToInt(val) != 4 {
+		t.Errorf("c: expected 4, got %d", bytesToInt(val))
+	}
+	val, _ = a.Pop()
+	if bytesToInt(val) != 3 {
+		t.Errorf("a: expected 3, got %d", bytesToInt(val))
+	}
+}
+
+func TestBroadcastClear(t *testing.T) {
+	s := NewStack(Broadcast, TypeInt64)
+	v := NewView(Broadcast)
+	v.Attach(s)
+	s.Push(intToBytes(1))
+	s.Clear()
+	s.Push(intToBytes(2))
+
+	val, err := v.Pop()
+	if err != nil || bytesToInt(val) != 2 {
+		t.Errorf("expected 2 after clear, got %d (%v)", bytesToInt(val), err)
+	}
+}
+
+func TestBroadcastCapacity(t *testing.T) {
+	s := NewCappedStack(Broadcast, TypeInt64, 2)
+	fast, slow := NewView(Broadcast), NewView(Broadcast)
+	fast.Attach(s)
+	slow.Attach(s)
+
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	fast.Pop()
+	fast.Pop()
+	if err := s.Push(intToBytes(3)); err == nil {
+		t.Error("expected full: slow reader has not caught up")
+	}
+	slow.Pop()
+	if err := s.Push(intToBytes(3)); err != nil {
+		t.Errorf("expected room after slow read: %v", err)
+	}
+}
+
+func TestBroadcastRejectsDirectReads(t *testing.T) {
+	s := NewStack(Broadcast, TypeInt64)
+	v := NewView(Broadcast)
+	v.Attach(s)
+	s.Push(intToBytes(1))
+
+	if _, err := s.Pop(); err == nil {
+		t.Error("expected Pop on a Broadcast stack to fail")
+	}
+	if _, err := s.Peek(); err == nil {
+		t.Error("expected Peek on a Broadcast stack to fail")
+	}
+	if err := NewView(FIFO).Attach(s); err == nil {
+		t.Error("expected a FIFO view to be refused")
+	}
+	if err := NewView(Broadcast).Attach(NewStack(FIFO, TypeInt64)); err == nil {
+		t.Error("expected a Broadcast view on a FIFO stack to be refused")
+	}
+	if err := v.Slice(0, 1); err == nil {
+		t.Error("expected a Broadcast view to refuse slicing")
+	}
+}
+
+func TestBroadcastWalk(t *testing.T) {
+	s := NewStack(Broadcast, TypeInt64)
+	v := NewView(Broadcast)
+	v.Attach(s)
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	v.Pop()
+	s.Push(intToBytes(3))
+
+	sum, err := Reduce(v, intToBytes(0), func(acc, x []byte) []byte {
+		return intToBytes(bytesToInt(acc) + bytesToInt(x))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytesToInt(sum) != 5 {
+		t.Errorf("expected 2+3 = 5, got %d", bytesToInt(sum))
+	}
+	if v.Remaining() != 2 {
+		t.Errorf("walking should not consume, %d remaining", v.Remaining())
+	}
+}
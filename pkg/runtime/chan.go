@@ -0,0 +1,76 @@
+package runtime
+
+import "context"
+
+// ============================================================================
+// Channel bridges
+//
+// Connect stacks to channel-based Go code when the runtime is embedded in a
+// larger program:
+//
+//   for msg := range ual.StackToChan(inbox, ctx) { ... }   // stack -> chan
+//   go ual.ChanToStack(requests, inbox)                    // chan -> stack
+//
+// Elements are handed over as-is, in the order Take would return them.
+// ============================================================================
+
+// StackToChan returns a channel that receives the elements taken from s,
+// one at a time, as Take would return them. The channel is closed once s is
+// closed and empty, or when ctx is done. An element taken just as ctx is
+// cancelled is put back on s rather than lost.
+func StackToChan(s *Stack, ctx context.Context) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for {
+			data, err := s.take(ctx, 0, afterFunc)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- data:
+			case <-ctx.Done():
+				s.untake(data)
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// ChanToStack pushes every value received from ch onto s until ch is
+// closed, then closes s so that readers blocked in Take see the end of the
+// stream. It blocks, so run it in its own goroutine. If a push fails (s is
+// frozen or full) it stops and returns the error, leaving s open.
+func ChanToStack(ch <-chan []byte, s *Stack) error {
+	for data := range ch {
+		if err := s.Push(data); err != nil {
+			return err
+		}
+	}
+	s.Close()
+	return nil
+}
+
+// untake returns data to the position popElement took it from, so the
+// next Take returns it again.
+func (s *Stack) untake(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem := Element{data: s.pack(data)}
+	if s.perspective == FIFO {
+		if s.head > 0 {
+			s.head--
+			s.elements[s.head] = elem
+			s.keys[s.head] = nil
+		} else {
+			s.elements = append([]Element{elem}, s.elements...)
+			s.keys = append([][]byte{nil}, s.keys...)
+		}
+	} else {
+		s.elements = append(s.elements, elem)
+		s.keys = append(s.keys, nil)
+	}
+	s.version++
+	s.cond.Broadcast()
+}
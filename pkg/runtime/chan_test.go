@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStackToChan(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	for i := int64(1); i <= 3; i++ {
+		s.Push(intToBytes(i))
+	}
+	ch := StackToChan(s, context.Background())
+
+	for want := int64(1); want <= 3; want++ {
+		if got := bytesToInt(<-ch); got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+
+	// Elements pushed later are delivered too, and Close ends the stream
+	go func() {
+		s.Push(intToBytes(4))
+		s.Close()
+	}()
+	if got := bytesToInt(<-ch); got != 4 {
+		t.Errorf("got %d, want 4", got)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("channel should close after the stack is closed and empty")
+	}
+}
+
+func TestStackToChanCancel(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := StackToChan(s, ctx)
+
+	// Nobody receives, so the first element is taken but never delivered
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			// The element raced the cancellation; it was delivered, not lost
+			if _, ok := <-ch; ok {
+				t.Fatal("channel should close after cancel")
+			}
+			return
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+
+	if s.Len() != 2 {
+		t.Fatalf("expected both elements back on the stack, got %d", s.Len())
+	}
+	if v, _ := s.Take(); bytesToInt(v) != 1 {
+		t.Errorf("first element should be put back in front, got %d", bytesToInt(v))
+	}
+}
+
+func TestChanToStack(t *testing.T) {
+	s := NewStack(FIFO, TypeBytes)
+	ch := make(chan []byte)
+	done := make(chan error, 1)
+	go func() { done <- ChanToStack(ch, s) }()
+
+	ch <- []byte("a")
+	ch <- []byte("b")
+	close(ch)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsClosed() {
+		t.Error("stack should be closed once the channel is")
+	}
+	for _, want := range []string{"a", "b"} {
+		if got, err := s.Take(); err != nil || string(got) != want {
+			t.Errorf("got %q, %v; want %q", got, err, want)
+		}
+	}
+
+	// A full stack stops the bridge with an error and stays open
+	capped := NewCappedStack(LIFO, TypeBytes, 1)
+	ch = make(chan []byte, 2)
+	ch <- []byte("x")
+	ch <- []byte("y")
+	close(ch)
+	if err := ChanToStack(ch, capped); err == nil {
+		t.Error("expected an error pushing to a full stack")
+	}
+	if capped.IsClosed() {
+		t.Error("stack should stay open after a failed push")
+	}
+}
@@ -0,0 +1,61 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ============================================================================
+// Checked builds
+//
+// A program built with `ual build --checked` panics on a pop or peek of an
+// empty stack instead of carrying on with a zero. The compiler emits
+// //line directives, so the caller of Checked or Underflow is at a .ual
+// file and line, and the panic names it.
+// ============================================================================
+
+// UnderflowError is the panic value of a stack underflow in a checked build
+type UnderflowError struct {
+	File string
+	Line int
+}
+
+func (e *UnderflowError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, ErrUnderflow)
+}
+
+func (e *UnderflowError) Unwrap() error { return ErrUnderflow }
+
+// Checked passes on the results of a Pop or Peek, panicking with an
+// UnderflowError at its caller's position if the stack was empty
+func Checked(v []byte, err error) ([]byte, error) {
+	if errors.Is(err, ErrEmpty) {
+		Underflow(1)
+	}
+	return v, err
+}
+
+// Underflow panics with an UnderflowError at the position of the function
+// skip frames above its caller: 0 names the caller itself
+func Underflow(skip int) {
+	_, file, line, _ := runtime.Caller(skip + 1)
+	panic(&UnderflowError{File: relPath(file), Line: line})
+}
+
+// relPath returns file relative to the working directory if it is below
+// it, so positions read as the compiler was given them
+func relPath(file string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return file
+	}
+	rel, err := filepath.Rel(wd, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+	return rel
+}
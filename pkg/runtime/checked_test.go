@@ -0,0 +1,41 @@
+package runtime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckedPassesResults(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	s.Push(intToBytes(7))
+	v, err := Checked(s.Pop())
+	if err != nil || bytesToInt(v) != 7 {
+		t.Errorf("expected 7, got %v, %v", v, err)
+	}
+
+	// Errors other than an empty stack are left to the caller
+	s.Push(intToBytes(1))
+	s.Freeze()
+	if _, err := Checked(s.Pop()); !errors.Is(err, ErrFrozen) {
+		t.Errorf("expected ErrFrozen, got %v", err)
+	}
+}
+
+func TestCheckedPanicsOnEmpty(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	defer func() {
+		r := recover()
+		err, ok := r.(*UnderflowError)
+		if !ok {
+			t.Fatalf("expected *UnderflowError, got %v", r)
+		}
+		if !errors.Is(err, ErrUnderflow) {
+			t.Error("UnderflowError does not wrap ErrUnderflow")
+		}
+		if !strings.HasSuffix(err.File, "checked_test.go") || err.Line == 0 {
+			t.Errorf("expected the caller's position, got %s", err)
+		}
+	}()
+	Checked(s.Pop())
+}
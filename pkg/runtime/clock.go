@@ -0,0 +1,184 @@
+package runtime
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Program clock
+//
+//   freeze_time()       time stands still for timeouts and tickers
+//   advance_time(500)   move it on 500ms, firing whatever comes due
+//   wait_timers(1)      wait until a task is blocked on a timer
+//
+// Take timeouts, spawn wait timeouts, select timeouts and every() ticks
+// read the program clock. It is the wall clock until FreezeTime; from then
+// on it only moves when AdvanceTime moves it, so timeout logic can be
+// tested without sleeping and without races against the scheduler. Timers
+// started before the freeze keep to real time.
+// ============================================================================
+
+var clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when a timer is added
+	frozen bool
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func init() {
+	clock.cond = sync.NewCond(&clock.mu)
+}
+
+// fakeTimer is a timer on the frozen clock
+type fakeTimer struct {
+	when   time.Time
+	period time.Duration // tickers only
+	fire   func(now time.Time)
+}
+
+// FreezeTime stops the program clock at the current time. Calling it again
+// has no effect.
+func FreezeTime() {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if !clock.frozen {
+		clock.frozen = true
+		clock.now = time.Now()
+	}
+}
+
+// TimeFrozen reports whether FreezeTime has been called
+func TimeFrozen() bool {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.frozen
+}
+
+// Now returns the time on the program clock
+func Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if clock.frozen {
+		return clock.now
+	}
+	return time.Now()
+}
+
+// AdvanceTime moves the frozen clock on by ms milliseconds, firing the
+// timers that come due in order of their deadlines. A ticker fires once
+// for each period that passes. Timers fire before AdvanceTime returns, so
+// a take that times out has done so by then. It freezes the clock first
+// if need be.
+func AdvanceTime(ms int64) {
+	FreezeTime()
+	clock.mu.Lock()
+	target := clock.now.Add(time.Duration(ms) * time.Millisecond)
+	for {
+		sort.SliceStable(clock.timers, func(a, b int) bool {
+			return clock.timers[a].when.Before(clock.timers[b].when)
+		})
+		if len(clock.timers) == 0 || clock.timers[0].when.After(target) {
+			break
+		}
+		t := clock.timers[0]
+		clock.timers = clock.timers[1:]
+		clock.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			clock.timers = append(clock.timers, t)
+		}
+		now := clock.now
+		clock.mu.Unlock()
+		t.fire(now)
+		clock.mu.Lock()
+	}
+	clock.now = target
+	clock.mu.Unlock()
+}
+
+// PendingTimers returns the number of timers waiting on the frozen clock
+func PendingTimers() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return len(clock.timers)
+}
+
+// WaitTimers blocks until at least n timers are waiting on the frozen
+// clock, so that a test can be sure a task has reached its take or select
+// before it advances time past the timeout
+func WaitTimers(n int) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	for len(clock.timers) < n {
+		clock.cond.Wait()
+	}
+}
+
+// After returns a channel that is closed after ms milliseconds on the
+// program clock, and a function that stops the timer early
+func After(ms int64) (expired <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+	return ch, afterFunc(time.Duration(ms)*time.Millisecond, func() { close(ch) })
+}
+
+// afterFunc calls f after d on the program clock. The returned function
+// cancels the call if it has not happened yet. On the frozen clock f runs
+// in the goroutine that advances time.
+func afterFunc(d time.Duration, f func()) (stop func()) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if !clock.frozen {
+		t := time.AfterFunc(d, f)
+		return func() { t.Stop() }
+	}
+	t := &fakeTimer{when: clock.now.Add(d), fire: func(time.Time) { f() }}
+	addTimer(t)
+	return func() { removeTimer(t) }
+}
+
+// tickFunc calls f with the time on the program clock every d for as
+// long as f returns true
+func tickFunc(d time.Duration, f func(now time.Time) bool) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if !clock.frozen {
+		go func() {
+			t := time.NewTicker(d)
+			defer t.Stop()
+			for now := range t.C {
+				if !f(now) {
+					return
+				}
+			}
+		}()
+		return
+	}
+	t := &fakeTimer{when: clock.now.Add(d), period: d}
+	t.fire = func(now time.Time) {
+		if !f(now) {
+			removeTimer(t)
+		}
+	}
+	addTimer(t)
+}
+
+// addTimer adds t to the frozen clock. Caller holds clock.mu.
+func addTimer(t *fakeTimer) {
+	clock.timers = append(clock.timers, t)
+	clock.cond.Broadcast()
+}
+
+// removeTimer stops t if it has not fired
+func removeTimer(t *fakeTimer) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	for i, other := range clock.timers {
+		if other == t {
+			clock.timers = append(clock.timers[:i], clock.timers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+// thawClock puts the program clock back on real time
+func thawClock() {
+	clock.mu.Lock()
+	clock.frozen = false
+	clock.timers = nil
+	clock.mu.Unlock()
+}
+
+func TestFrozenTakeTimeout(t *testing.T) {
+	FreezeTime()
+	defer thawClock()
+
+	s := NewStack(FIFO, TypeInt64)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s.Take(500)
+		errc <- err
+	}()
+
+	WaitTimers(1)
+	AdvanceTime(499)
+	select {
+	case err := <-errc:
+		t.Fatalf("take returned before its timeout: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	AdvanceTime(1)
+	if err := <-errc; err == nil || err.Error() != "take timeout" {
+		t.Fatalf("err = %v, want take timeout", err)
+	}
+	if n := PendingTimers(); n != 0 {
+		t.Errorf("%d timers left after timeout", n)
+	}
+}
+
+func TestFrozenTakeStopsTimer(t *testing.T) {
+	FreezeTime()
+	defer thawClock()
+
+	s := NewStack(FIFO, TypeInt64)
+	done := make(chan []byte, 1)
+	go func() {
+		b, _ := s.Take(500)
+		done <- b
+	}()
+	WaitTimers(1)
+	s.Push(intToBytes(7))
+	if got := bytesToInt(<-done); got != 7 {
+		t.Fatalf("took %d, want 7", got)
+	}
+	if n := PendingTimers(); n != 0 {
+		t.Errorf("%d timers left after take", n)
+	}
+}
+
+func TestFrozenEvery(t *testing.T) {
+	FreezeTime()
+	defer thawClock()
+
+	start := Now()
+	ticks := Every(100)
+	AdvanceTime(250)
+	if ticks.Len() != 1 {
+		t.Fatalf("%d ticks pending, want 1 (the second dropped)", ticks.Len())
+	}
+	b, _ := ticks.Pop()
+	if got := bytesToInt(b); got != start.Add(100*time.Millisecond).UnixMilli() {
+		t.Errorf("tick at %d, want %d", got, start.Add(100*time.Millisecond).UnixMilli())
+	}
+	AdvanceTime(50)
+	if ticks.Len() != 1 {
+		t.Errorf("no tick at 300ms")
+	}
+
+	ticks.Close()
+	AdvanceTime(100)
+	if n := PendingTimers(); n != 0 {
+		t.Errorf("ticker still running after close")
+	}
+	if got := Now().Sub(start); got != 400*time.Millisecond {
+		t.Errorf("clock moved %v, want 400ms", got)
+	}
+}
+
+func TestFrozenSpawnGroupWait(t *testing.T) {
+	FreezeTime()
+	defer thawClock()
+
+	var g SpawnGroup
+	g.Add(1)
+	errc := make(chan error, 1)
+	go func() { errc <- g.Wait(1000) }()
+	WaitTimers(1)
+	AdvanceTime(1000)
+	if err := <-errc; err != ErrWaitTimeout {
+		t.Fatalf("err = %v, want ErrWaitTimeout", err)
+	}
+	g.Done()
+}
@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+)
+
+// ============================================================================
+// Binary-to-text codecs
+//
+//   @text b64encode(@blob)   @blob b64decode(@text)
+//   @text hexencode(@blob)   @blob hexdecode(@text)
+//
+// Encode and Decode are WalkFuncs, so a whole stack is converted with
+// dest.Walk(src, Base64.Encode, errStack): elements that fail to decode are
+// skipped and reported on errStack. Payloads too large to hold as a single
+// element can be streamed with NewEncoder/NewDecoder or EncodeStream/
+// DecodeStream instead.
+// ============================================================================
+
+// Codec is a binary-to-text encoding.
+type Codec struct {
+	name       string
+	encodedLen func(n int) int
+	encode     func(dst, src []byte)
+	decodedLen func(n int) int
+	decode     func(dst, src []byte) (int, error)
+	newEncoder func(w io.Writer) io.WriteCloser
+	newDecoder func(r io.Reader) io.Reader
+}
+
+// Base64 is standard padded base64 (RFC 4648).
+var Base64 = &Codec{
+	name:       "base64",
+	encodedLen: base64.StdEncoding.EncodedLen,
+	encode:     base64.StdEncoding.Encode,
+	decodedLen: base64.StdEncoding.DecodedLen,
+	decode:     base64.StdEncoding.Decode,
+	newEncoder: func(w io.Writer) io.WriteCloser { return base64.NewEncoder(base64.StdEncoding, w) },
+	newDecoder: func(r io.Reader) io.Reader { return base64.NewDecoder(base64.StdEncoding, r) },
+}
+
+// Hex is lowercase hexadecimal. Decoding accepts either case.
+var Hex = &Codec{
+	name:       "hex",
+	encodedLen: hex.EncodedLen,
+	encode:     func(dst, src []byte) { hex.Encode(dst, src) },
+	decodedLen: func(n int) int { return n / 2 },
+	decode:     hex.Decode,
+	newEncoder: func(w io.Writer) io.WriteCloser { return nopWriteCloser{hex.NewEncoder(w)} },
+	newDecoder: hex.NewDecoder,
+}
+
+// Name returns the codec's name, "base64" or "hex".
+func (c *Codec) Name() string { return c.name }
+
+// Encode returns the text encoding of data.
+func (c *Codec) Encode(data []byte) ([]byte, error) {
+	out := make([]byte, c.encodedLen(len(data)))
+	c.encode(out, data)
+	return out, nil
+}
+
+// Decode returns the bytes encoded by text. Leading and trailing
+// whitespace is ignored, so lines copied from logs decode as-is.
+func (c *Codec) Decode(text []byte) ([]byte, error) {
+	text = bytes.TrimSpace(text)
+	out := make([]byte, c.decodedLen(len(text)))
+	n, err := c.decode(out, text)
+	if err != nil {
+		return nil, &CodecError{Codec: c.name, Err: err}
+	}
+	return out[:n], nil
+}
+
+// NewEncoder returns a writer that encodes everything written to it onto
+// w. Close flushes any partial block and must be called when done.
+func (c *Codec) NewEncoder(w io.Writer) io.WriteCloser {
+	return c.newEncoder(w)
+}
+
+// NewDecoder returns a reader that decodes the text read from r.
+func (c *Codec) NewDecoder(r io.Reader) io.Reader {
+	return c.newDecoder(r)
+}
+
+// EncodeStream encodes all of r onto w and returns the number of input
+// bytes consumed.
+func (c *Codec) EncodeStream(w io.Writer, r io.Reader) (int64, error) {
+	enc := c.NewEncoder(w)
+	n, err := io.Copy(enc, r)
+	if cerr := enc.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// DecodeStream decodes all of the text in r onto w and returns the number
+// of decoded bytes written.
+func (c *Codec) DecodeStream(w io.Writer, r io.Reader) (int64, error) {
+	return io.Copy(w, c.NewDecoder(r))
+}
+
+// CodecError reports input that is not valid for a codec.
+type CodecError struct {
+	Codec string
+	Err   error
+}
+
+func (e *CodecError) Error() string { return e.Codec + " decode: " + e.Err.Error() }
+func (e *CodecError) Unwrap() error { return e.Err }
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,96 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		codec *Codec
+		in    string
+		want  string
+	}{
+		{Base64, "", ""},
+		{Base64, "hello", "aGVsbG8="},
+		{Base64, "\x00\xff\x10", "AP8Q"},
+		{Hex, "", ""},
+		{Hex, "hello", "68656c6c6f"},
+		{Hex, "\x00\xff\x10", "00ff10"},
+	}
+	for _, tt := range tests {
+		enc, err := tt.codec.Encode([]byte(tt.in))
+		if err != nil || string(enc) != tt.want {
+			t.Errorf("%s encode %q: got %q, %v; want %q", tt.codec.Name(), tt.in, enc, err, tt.want)
+			continue
+		}
+		dec, err := tt.codec.Decode(enc)
+		if err != nil || string(dec) != tt.in {
+			t.Errorf("%s decode %q: got %q, %v; want %q", tt.codec.Name(), enc, dec, err, tt.in)
+		}
+	}
+}
+
+func TestCodecDecode(t *testing.T) {
+	if got, err := Hex.Decode([]byte("00FF10\n")); err != nil || string(got) != "\x00\xff\x10" {
+		t.Errorf("hex: uppercase with newline: got %q, %v", got, err)
+	}
+	if got, err := Base64.Decode([]byte("  aGVs\nbG8=  ")); err != nil || string(got) != "hello" {
+		t.Errorf("base64: wrapped with spaces: got %q, %v", got, err)
+	}
+
+	for _, c := range []*Codec{Base64, Hex} {
+		_, err := c.Decode([]byte("not valid!"))
+		var cerr *CodecError
+		if !errors.As(err, &cerr) || cerr.Codec != c.Name() {
+			t.Errorf("%s: expected CodecError, got %v", c.Name(), err)
+		}
+	}
+}
+
+func TestCodecWalk(t *testing.T) {
+	blobs := NewStack(FIFO, TypeBytes)
+	blobs.Push([]byte("ab"))
+	blobs.Push([]byte{0, 1, 2})
+
+	text := NewStack(FIFO, TypeString)
+	text.Walk(blobs, Base64.Encode, nil)
+	text.Push([]byte("%%%"))
+
+	errs := NewStack(LIFO, TypeBytes)
+	back := NewStack(FIFO, TypeBytes)
+	back.Walk(text, Base64.Decode, errs)
+
+	if back.Len() != 2 || errs.Len() != 1 {
+		t.Fatalf("expected 2 decoded and 1 error, got %d and %d", back.Len(), errs.Len())
+	}
+	for _, want := range []string{"ab", "\x00\x01\x02"} {
+		got, _ := back.Pop()
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestCodecStream(t *testing.T) {
+	payload := strings.Repeat("0123456789", 10000)
+	for _, c := range []*Codec{Base64, Hex} {
+		var text bytes.Buffer
+		n, err := c.EncodeStream(&text, strings.NewReader(payload))
+		if err != nil || n != int64(len(payload)) {
+			t.Fatalf("%s: EncodeStream: %d, %v", c.Name(), n, err)
+		}
+		whole, _ := c.Encode([]byte(payload))
+		if !bytes.Equal(text.Bytes(), whole) {
+			t.Errorf("%s: streamed encoding differs from Encode", c.Name())
+		}
+
+		var out bytes.Buffer
+		n, err = c.DecodeStream(&out, &text)
+		if err != nil || n != int64(len(payload)) || out.String() != payload {
+			t.Errorf("%s: DecodeStream: %d, %v", c.Name(), n, err)
+		}
+	}
+}
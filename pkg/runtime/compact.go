@@ -0,0 +1,63 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// ============================================================================
+// Compact stacks
+//
+//   @samples = stack.new(f32)     also i32 and u32
+//
+// Stacks of TypeInt32, TypeUint32 and TypeFloat32 keep 4 bytes per element
+// instead of 8. Push, Pop and the rest of the Stack API still take and
+// return the 8-byte encoding of TypeInt64, TypeUint64 and TypeFloat64,
+// narrowing values on the way in and widening them on the way out, so code
+// written for 64-bit stacks works unchanged. The Raw methods used by
+// compute blocks see the 4-byte layout.
+// ============================================================================
+
+// wideType returns the 64-bit type whose encoding the API uses for t
+func wideType(t ElementType) ElementType {
+	switch t {
+	case TypeInt32:
+		return TypeInt64
+	case TypeUint32:
+		return TypeUint64
+	case TypeFloat32:
+		return TypeFloat64
+	}
+	return t
+}
+
+// pack returns data as a stack of s's type stores it
+func (s *Stack) pack(data []byte) []byte {
+	if wideType(s.elementType) == s.elementType || len(data) != 8 {
+		return data
+	}
+	b := make([]byte, 4)
+	switch s.elementType {
+	case TypeInt32, TypeUint32:
+		binary.BigEndian.PutUint32(b, uint32(bytesToInt(data)))
+	case TypeFloat32:
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(bytesToFloat64(data))))
+	}
+	return b
+}
+
+// unpack returns data, as stored by s, in the encoding of its wide type
+func (s *Stack) unpack(data []byte) []byte {
+	if wideType(s.elementType) == s.elementType || len(data) != 4 {
+		return data
+	}
+	u := binary.BigEndian.Uint32(data)
+	switch s.elementType {
+	case TypeInt32:
+		return intToBytes(int64(int32(u)))
+	case TypeUint32:
+		return intToBytes(int64(u))
+	default:
+		return float64ToBytes(float64(math.Float32frombits(u)))
+	}
+}
@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	ints := []struct {
+		typ      ElementType
+		in, want int64
+	}{
+		{TypeInt32, -1, -1},
+		{TypeInt32, math.MaxInt32, math.MaxInt32},
+		{TypeInt32, math.MaxInt32 + 1, math.MinInt32}, // wraps like int32
+		{TypeUint32, -1, math.MaxUint32},
+		{TypeUint32, 1 << 32, 0},
+	}
+	for _, tt := range ints {
+		s := NewStack(LIFO, tt.typ)
+		s.Push(intToBytes(tt.in))
+		if n := len(s.elements[0].data); n != 4 {
+			t.Errorf("type %d: stored %d bytes, want 4", tt.typ, n)
+		}
+		if v, _ := s.Pop(); bytesToInt(v) != tt.want {
+			t.Errorf("type %d: push %d, pop %d, want %d", tt.typ, tt.in, bytesToInt(v), tt.want)
+		}
+	}
+
+	s := NewStack(FIFO, TypeFloat32)
+	s.Push(float64ToBytes(0.1))
+	s.Push(float64ToBytes(-2.5))
+	if v, _ := s.Pop(); bytesToFloat64(v) != float64(float32(0.1)) {
+		t.Errorf("f32: got %v, want %v", bytesToFloat64(v), float32(0.1))
+	}
+	if v, _ := s.Peek(); bytesToFloat64(v) != -2.5 {
+		t.Errorf("f32 peek: got %v, want -2.5", bytesToFloat64(v))
+	}
+}
+
+func TestCompactRaw(t *testing.T) {
+	s := NewStack(LIFO, TypeFloat32)
+	s.Push(float64ToBytes(1.5))
+	s.Lock()
+	raw, _ := s.PopRaw()
+	s.Unlock()
+	if len(raw) != 4 || math.Float32frombits(uint32(bytesToInt(raw))) != 1.5 {
+		t.Errorf("PopRaw: got % x, want the 4-byte float32 1.5", raw)
+	}
+}
+
+func TestCompactBring(t *testing.T) {
+	i32 := NewStack(LIFO, TypeInt32)
+	i64 := NewStack(LIFO, TypeInt64)
+	i32.Push(intToBytes(-7))
+	if err := i64.Bring(i32); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := i64.Pop(); bytesToInt(v) != -7 {
+		t.Errorf("i32 to i64: got %d", bytesToInt(v))
+	}
+
+	f32 := NewStack(LIFO, TypeFloat32)
+	str := NewStack(LIFO, TypeString)
+	str.Push([]byte("0.25"))
+	if err := f32.Bring(str); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatElement(mustPeek(t, f32), f32.elementType); got != "0.25" {
+		t.Errorf("string to f32: got %s", got)
+	}
+	if err := str.Bring(f32); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := str.Pop(); string(v) != "0.25" {
+		t.Errorf("f32 to string: got %q", v)
+	}
+}
+
+func mustPeek(t *testing.T, s *Stack) []byte {
+	t.Helper()
+	v, err := s.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// Crash reports
+//
+// A program built with `ual build --crash-dump dir` writes a report to dir
+// when it panics: the ual-level backtrace, the depth of every global stack
+// and the last operations it ran. Nothing is sent anywhere.
+//
+// The compiler emits //line directives, so Go reports .ual file and line
+// positions for the generated code, and a CrashTrace call before each
+// statement. Generated code starts with EnableCrashDump and defers
+// CrashGuard in main and in every spawned task.
+// ============================================================================
+
+// crashTraceLen is how many of the most recent operations a report shows
+const crashTraceLen = 64
+
+var crash struct {
+	dir    string
+	ops    []string // description of each traced operation, by id
+	stacks sync.Map // name -> *Stack
+
+	ring [crashTraceLen]atomic.Int32 // op id + 1; 0 = unused
+	next atomic.Uint64
+
+	once sync.Once
+}
+
+// EnableCrashDump turns crash reports on. They are written to dir, and
+// ops[id] describes the operation CrashTrace(id) records.
+func EnableCrashDump(dir string, ops []string) {
+	crash.dir = dir
+	crash.ops = ops
+}
+
+// WatchStack includes s, by name, in the stack depths of a crash report
+func WatchStack(name string, s *Stack) {
+	crash.stacks.Store(name, s)
+}
+
+// CrashTrace records that the operation with the given id is about to run
+func CrashTrace(id int) {
+	n := crash.next.Add(1) - 1
+	crash.ring[n%crashTraceLen].Store(int32(id) + 1)
+}
+
+// CrashGuard writes a crash report if the goroutine is panicking and then
+// lets the panic continue. It must be deferred directly:
+//
+//	defer ual.CrashGuard()
+//
+// Only the first panic in the process is reported.
+func CrashGuard() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if crash.dir != "" {
+		crash.once.Do(func() {
+			path, err := writeCrashReport(r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "crash report failed: %v\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "crash report written to %s\n", path)
+			}
+		})
+	}
+	panic(r)
+}
+
+// writeCrashReport writes the report for panic value r and returns its path
+func writeCrashReport(r any) (string, error) {
+	if err := os.MkdirAll(crash.dir, 0755); err != nil {
+		return "", err
+	}
+	prog := filepath.Base(os.Args[0])
+	now := time.Now()
+	path := filepath.Join(crash.dir, fmt.Sprintf("crash-%s-%s-%d.txt", prog, now.Format("20060102-150405"), os.Getpid()))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ual crash report\n")
+	fmt.Fprintf(&b, "program: %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(&b, "time:    %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "panic:   %v\n", r)
+
+	b.WriteString("\nbacktrace (innermost first):\n")
+	for _, f := range ualFrames(3) {
+		fmt.Fprintf(&b, "  %-20s %s:%d\n", f.name, f.file, f.line)
+	}
+
+	b.WriteString("\nstacks:\n")
+	var names []string
+	depths := make(map[string]int)
+	crash.stacks.Range(func(k, v any) bool {
+		name := k.(string)
+		names = append(names, name)
+		depths[name] = v.(*Stack).Len()
+		return true
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  @%-19s %d\n", name, depths[name])
+	}
+
+	b.WriteString("\nlast operations (oldest first):\n")
+	for _, op := range recentOps() {
+		fmt.Fprintf(&b, "  %s\n", op)
+	}
+
+	b.WriteString("\ngo stack:\n")
+	b.Write(debug.Stack())
+
+	return path, os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+type ualFrame struct {
+	name string
+	file string
+	line int
+}
+
+// ualFrames returns the frames of the panicking goroutine that map to .ual
+// source, skipping the first skip callers
+func ualFrames(skip int) []ualFrame {
+	pcs := make([]uintptr, 128)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var out []ualFrame
+	for {
+		f, more := frames.Next()
+		if strings.HasSuffix(f.File, ".ual") {
+			name := strings.TrimPrefix(f.Function, "main.")
+			out = append(out, ualFrame{name: name, file: f.File, line: f.Line})
+		}
+		if !more {
+			return out
+		}
+	}
+}
+
+// recentOps returns the descriptions of the last traced operations
+func recentOps() []string {
+	end := crash.next.Load()
+	start := uint64(0)
+	if end > crashTraceLen {
+		start = end - crashTraceLen
+	}
+	var ops []string
+	for i := start; i < end; i++ {
+		id := int(crash.ring[i%crashTraceLen].Load()) - 1
+		if id >= 0 && id < len(crash.ops) {
+			ops = append(ops, crash.ops[id])
+		}
+	}
+	return ops
+}
@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func resetCrash(t *testing.T) {
+	t.Helper()
+	for i := range crash.ring {
+		crash.ring[i].Store(0)
+	}
+	crash.next.Store(0)
+	crash.stacks.Range(func(k, _ any) bool {
+		crash.stacks.Delete(k)
+		return true
+	})
+	t.Cleanup(func() { EnableCrashDump("", nil) })
+}
+
+func TestCrashTraceKeepsLatest(t *testing.T) {
+	resetCrash(t)
+	ops := make([]string, 100)
+	for i := range ops {
+		ops[i] = fmt.Sprintf("op%d", i)
+	}
+	EnableCrashDump(t.TempDir(), ops)
+
+	CrashTrace(0)
+	CrashTrace(1)
+	if got := recentOps(); strings.Join(got, ",") != "op0,op1" {
+		t.Errorf("expected [op0 op1], got %v", got)
+	}
+
+	for i := range ops {
+		CrashTrace(i)
+	}
+	got := recentOps()
+	if len(got) != crashTraceLen {
+		t.Fatalf("expected %d ops, got %d", crashTraceLen, len(got))
+	}
+	if got[0] != "op36" || got[len(got)-1] != "op99" {
+		t.Errorf("expected op36..op99, got %s..%s", got[0], got[len(got)-1])
+	}
+}
+
+func TestCrashReport(t *testing.T) {
+	resetCrash(t)
+	dir := filepath.Join(t.TempDir(), "dumps")
+	EnableCrashDump(dir, []string{"main.ual:1  @jobs push:1", "main.ual:2  x = 1 / 0"})
+	jobs := NewStack(FIFO, TypeInt64)
+	jobs.Push(intToBytes(1))
+	WatchStack("jobs", jobs)
+	CrashTrace(0)
+	CrashTrace(1)
+
+	path, err := writeCrashReport(errors.New("boom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	for _, want := range []string{
+		"panic:   boom",
+		"@jobs                1",
+		"main.ual:1  @jobs push:1\n  main.ual:2  x = 1 / 0\n",
+		"go stack:",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestCrashGuardRepanics(t *testing.T) {
+	resetCrash(t)
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("expected the panic to continue, got %v", r)
+		}
+	}()
+	defer CrashGuard()
+	panic("boom")
+}
@@ -0,0 +1,259 @@
+package runtime
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// CSV
+//
+//   read_csv(path, @a, @b, ...)    pushes field n of each row to the n-th
+//                                  stack, returning the rows read
+//   write_csv(path, @a, @b, ...)   writes element k of each stack as row k
+//
+// A file starts with a header row, which write_csv fills with the names of
+// the stacks and read_csv skips. Fields are converted to the element type
+// of their stack as text is by a parse: numbers and booleans may have
+// space around them, strings are taken as they are. A row is pushed only
+// once all its fields have converted, so the stacks stay the same length.
+// Rows are read one at a time, so a file need not fit in memory beside
+// the stacks. Failures set the consider status as for readfile.
+// ============================================================================
+
+// ReadCSV pushes the fields of each row of the CSV file at path after its
+// header to cols, in order, and returns the rows read. On an error the
+// rows before it stay pushed.
+func ReadCSV(path string, cols ...*Stack) (int64, error) {
+	types := make([]ElementType, len(cols))
+	for n, c := range cols {
+		types[n] = c.elementType
+	}
+	return readCSV(path, types, func(row [][]byte) error {
+		for n, c := range cols {
+			if err := c.Push(row[n]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteCSV replaces the file at path with a CSV file of the header row
+// and then, for each k, a row of the k-th elements of cols in push order.
+// The stacks must be the same length.
+func WriteCSV(path string, header []string, cols ...*Stack) error {
+	fields := make([][]string, len(cols))
+	for n, c := range cols {
+		c.mu.RLock()
+		if c.perspective == Hash {
+			c.mu.RUnlock()
+			return errors.New("write_csv: a Hash stack has no order to write in")
+		}
+		for _, e := range c.elements[c.head:] {
+			fields[n] = append(fields[n], formatElement(c.unpack(e.data), c.elementType))
+		}
+		c.mu.RUnlock()
+	}
+	return writeCSV(path, header, fields)
+}
+
+// ReadCSVValues is ReadCSV for the interpreter: types are the element
+// types of the stacks in the program.
+func ReadCSVValues(path string, cols []*ValueStack, types []ElementType) (int64, error) {
+	return readCSV(path, types, func(row [][]byte) error {
+		for n, c := range cols {
+			if err := c.Push(elementValue(row[n], types[n])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteCSVValues is WriteCSV for the interpreter: types are the element
+// types of the stacks in the program.
+func WriteCSVValues(path string, header []string, cols []*ValueStack, types []ElementType) error {
+	fields := make([][]string, len(cols))
+	for n, c := range cols {
+		if c.IsHash() {
+			return errors.New("write_csv: a Hash stack has no order to write in")
+		}
+		for _, v := range c.All() {
+			fields[n] = append(fields[n], formatElement(valueElement(v, types[n]), types[n]))
+		}
+	}
+	return writeCSV(path, header, fields)
+}
+
+// PushRow pushes row[k] to cols[k] as read_csv pushes a row, for row
+// sources outside this package such as database queries. A field may be
+// text, a string or []byte converted as read_csv converts a field; an
+// int64, float64, bool or time.Time, converted from its text; or nil,
+// the zero value of the element type. Nothing is pushed unless every
+// field converts.
+func PushRow(cols []*Stack, row []any) error {
+	if len(row) != len(cols) {
+		return fmt.Errorf("%d fields, %d stacks", len(row), len(cols))
+	}
+	data := make([][]byte, len(row))
+	for n, field := range row {
+		t := cols[n].elementType
+		var text string
+		switch f := field.(type) {
+		case nil:
+			data[n] = zeroElement(t)
+			continue
+		case string:
+			text = f
+		case []byte:
+			text = string(f)
+		case int64:
+			text = strconv.FormatInt(f, 10)
+		case float64:
+			text = strconv.FormatFloat(f, 'f', -1, 64)
+		case bool:
+			text = strconv.FormatBool(f)
+		case time.Time:
+			text = f.Format(time.RFC3339Nano)
+		default:
+			text = fmt.Sprint(f)
+		}
+		var err error
+		if data[n], err = csvElement(text, t); err != nil {
+			return fmt.Errorf("field %d: %v", n+1, err)
+		}
+	}
+	for n, c := range cols {
+		if err := c.Push(data[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zeroElement is the zero value of type t
+func zeroElement(t ElementType) []byte {
+	switch wideType(t) {
+	case TypeInt64, TypeUint64:
+		return intToBytes(0)
+	case TypeFloat64:
+		return float64ToBytes(0)
+	case TypeBool:
+		return []byte{0}
+	}
+	return []byte{}
+}
+
+// readCSV reads the rows after the header of the CSV file at path,
+// converts their fields to types and passes them to push
+func readCSV(path string, types []ElementType, push func(row [][]byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	var rows int64
+	for header := true; ; header = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, fmt.Errorf("read_csv: %v", err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) != len(types) {
+			return rows, fmt.Errorf("read_csv: line %d: %d fields, want %d", line, len(record), len(types))
+		}
+		if header {
+			continue
+		}
+		row := make([][]byte, len(types))
+		for n, field := range record {
+			if row[n], err = csvElement(field, types[n]); err != nil {
+				return rows, fmt.Errorf("read_csv: line %d, field %d: %v", line, n+1, err)
+			}
+		}
+		if err := push(row); err != nil {
+			return rows, fmt.Errorf("read_csv: %v", err)
+		}
+		rows++
+	}
+}
+
+// csvElement converts a field to an element of type t
+func csvElement(field string, t ElementType) ([]byte, error) {
+	switch wideType(t) {
+	case TypeInt64:
+		i, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", field)
+		}
+		return intToBytes(i), nil
+	case TypeUint64:
+		u, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an unsigned integer", field)
+		}
+		return intToBytes(int64(u)), nil
+	case TypeFloat64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", field)
+		}
+		return float64ToBytes(f), nil
+	case TypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", field)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	}
+	return []byte(field), nil
+}
+
+// writeCSV writes the file of write_csv, fields holding the text of each
+// column
+func writeCSV(path string, header []string, fields [][]string) error {
+	if len(fields) == 0 {
+		return errors.New("write_csv: no stacks to write")
+	}
+	for _, col := range fields[1:] {
+		if len(col) != len(fields[0]) {
+			return errors.New("write_csv: the stacks are not the same length")
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(header)
+	row := make([]string, len(fields))
+	for k := range fields[0] {
+		for n := range fields {
+			row[n] = fields[n][k]
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,132 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.csv")
+	data := "name,qty,price,ok\n" +
+		"apple, 3 ,0.5,true\n" +
+		"\"pear, green\",10,1.25,false\n" +
+		"\"say \"\"hi\"\"\",-2,2,1\n"
+	os.WriteFile(path, []byte(data), 0644)
+
+	names := NewStack(Indexed, TypeString)
+	qty := NewStack(Indexed, TypeInt32)
+	price := NewStack(Indexed, TypeFloat64)
+	ok := NewStack(Indexed, TypeBool)
+	n, err := ReadCSV(path, names, qty, price, ok)
+	if err != nil || n != 3 {
+		t.Fatalf("ReadCSV = %d, %v; want 3 rows", n, err)
+	}
+	for _, c := range []struct {
+		s    *Stack
+		want string
+	}{
+		{names, `["apple","pear, green","say \"hi\""]`},
+		{qty, `[3,10,-2]`},
+		{price, `[0.5,1.25,2]`},
+		{ok, `[true,false,true]`},
+	} {
+		if got := c.s.ToJSON(); got != c.want {
+			t.Errorf("read %s, want %s", got, c.want)
+		}
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct{ data, want string }{
+		{"a,b\n1,2\n3,x\n", `line 3, field 2: "x" is not an integer`},
+		{"a,b\n1,2\n3\n", "line 3: 1 fields, want 2"},
+		{"a,b\n1,2\n3,\"4\n", "read_csv:"},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, "bad.csv")
+		os.WriteFile(path, []byte(c.data), 0644)
+		a := NewStack(Indexed, TypeInt64)
+		b := NewStack(Indexed, TypeInt64)
+		n, err := ReadCSV(path, a, b)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("reading %q: error %v, want one with %q", c.data, err, c.want)
+		}
+		// The rows before the error stay, and the stacks stay the same length
+		if n != 1 || a.Len() != 1 || b.Len() != 1 {
+			t.Errorf("reading %q: %d rows, stacks of %d and %d; want 1 row", c.data, n, a.Len(), b.Len())
+		}
+	}
+
+	_, err := ReadCSV(filepath.Join(dir, "missing.csv"), NewStack(Indexed, TypeInt64))
+	if got := FileStatus(err); got != "not_found" {
+		t.Errorf("reading a missing file: status %q, want not_found", got)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	names := NewStack(FIFO, TypeString)
+	qty := NewStack(LIFO, TypeInt64)
+	for _, s := range []string{"apple", "pear, green", " pad"} {
+		names.Push([]byte(s))
+	}
+	for _, q := range []int64{3, 10, -2} {
+		qty.Push(intToBytes(q))
+	}
+	if err := WriteCSV(path, []string{"names", "qty"}, names, qty); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ReadFile(path)
+	if want := "names,qty\napple,3\n\"pear, green\",10\n\" pad\",-2\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+
+	// What write_csv writes read_csv reads back
+	names2 := NewStack(Indexed, TypeString)
+	qty2 := NewStack(Indexed, TypeInt64)
+	if n, err := ReadCSV(path, names2, qty2); err != nil || n != 3 {
+		t.Fatalf("ReadCSV = %d, %v; want 3 rows", n, err)
+	}
+	if names2.ToJSON() != names.ToJSON() || qty2.ToJSON() != qty.ToJSON() {
+		t.Errorf("read back %s %s", names2.ToJSON(), qty2.ToJSON())
+	}
+
+	qty.Pop()
+	if err := WriteCSV(path, []string{"names", "qty"}, names, qty); err == nil {
+		t.Error("wrote stacks of different lengths")
+	}
+}
+
+func TestPushRow(t *testing.T) {
+	name := NewStack(FIFO, TypeString)
+	qty := NewStack(FIFO, TypeInt64)
+	price := NewStack(FIFO, TypeFloat64)
+	ok := NewStack(FIFO, TypeBool)
+	cols := []*Stack{name, qty, price, ok}
+	for _, row := range [][]any{
+		{"apple", int64(3), 0.5, int64(1)},
+		{[]byte("pear"), nil, int64(2), true},
+		{nil, float64(4), nil, nil},
+	} {
+		if err := PushRow(cols, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := name.ToJSON() + qty.ToJSON() + price.ToJSON() + ok.ToJSON()
+	if want := `["apple","pear",""][3,0,4][0.5,2,0][true,true,false]`; got != want {
+		t.Errorf("pushed %s, want %s", got, want)
+	}
+
+	if err := PushRow(cols, []any{"fig", 2.5, 1.0, true}); err == nil || !strings.Contains(err.Error(), "field 2") {
+		t.Errorf("pushing 2.5 to an i64 stack: %v", err)
+	}
+	if err := PushRow(cols, []any{"fig"}); err == nil {
+		t.Error("pushed a row of one field to four stacks")
+	}
+	if name.Len() != 3 || qty.Len() != 3 {
+		t.Errorf("a failed row was pushed: %s %s", name.ToJSON(), qty.ToJSON())
+	}
+}
@@ -1,11 +1,27 @@
 // Package runtime provides the stack-based runtime library for compiled ual programs.
 //
 // This package implements:
-//   - Stack: thread-safe stack with multiple perspectives (LIFO, FIFO, Indexed, Hash)
+//   - Stack: thread-safe stack with multiple perspectives (LIFO, FIFO, Indexed, Hash, Broadcast)
 //   - View: decoupled perspective on a stack
 //   - Walk: iteration operations (Filter, Reduce, Map)
 //   - Bring: element transfer between stacks
 //   - WorkSteal: work-stealing scheduler
+//   - StackToChan, ChanToStack: bridges between stacks and Go channels
+//   - Every, Signals: timers and OS signals as stacks, for select cases
+//   - Serve, Dial: stacks shared between processes over TCP or Unix sockets
+//   - CrashGuard: local crash reports for programs built with --crash-dump
+//   - Checked, Underflow: stack underflow panics at .ual positions for programs built with --checked
+//   - RuntimeStats: goroutine, heap, GC and stack depth figures
+//   - ExpectStack, ExpectOutput: expect_stack and expect_output checks
+//   - RunTest, Assert: test blocks and assert, reported to ual test
+//   - StructType: packed layout of struct elements
+//   - FreezeTime, AdvanceTime, Stack.Mock: frozen clock and stack doubles for tests
+//   - StrSplit, Substr, StrUpper, ...: text operations on string stack elements
+//   - UintBits, WrapUint: widths and wrapping of unsigned stacks
+//   - Context, Shutdown, HandleInterrupts: cancelling blocked takes on Ctrl-C and SIGTERM
+//   - TypeInt32, TypeUint32, TypeFloat32: stacks that store elements in 4 bytes
+//   - FreezeWith, FreezeMode: read-only, structure-frozen and append-only stacks
+//   - UnsafeStack: a Stack without locking, for stacks one goroutine uses
 //
 // Compiled ual programs import this package as:
 //
@@ -17,9 +33,30 @@
 //	stack.Push(intToBytes(42))
 //	val, _ := stack.Pop()
 //
-// Stacks support four perspectives:
+// Stacks support five perspectives:
 //   - LIFO: Last-In-First-Out (traditional stack)
 //   - FIFO: First-In-First-Out (queue)
 //   - Indexed: Random access by index
 //   - Hash: Key-value access
+//   - Broadcast: Every attached Broadcast view reads every element
+//
+// # Compatibility
+//
+// Programs compiled by one version of ual must keep building against the
+// runtime of any later version with the same major number, so the exported
+// API only grows. Exported names are not removed, and their signatures,
+// the values of exported constants and the element encodings do not
+// change. This covers everything recorded in testdata/api.txt, which
+// TestAPI checks the package against; Int64Stack, Int64View, WSStack and
+// Task are exported for benchmarks and are not covered.
+//
+// Errors from Stack, View and ValueStack are, or wrap, the sentinel values
+// ErrEmpty, ErrFull, ErrFrozen, ErrClosed, ErrTimeout, ErrCancelled,
+// ErrUnderflow, ErrKeyRequired, ErrKeyNotFound, ErrOutOfBounds,
+// ErrNotAttached and ErrStale, so test for them with errors.Is. Their
+// messages are what ual programs see on @error and do not change either.
+// A RemoteStack returns the same sentinels as a local one.
+//
+// Implementation details live in internal packages, which only this
+// package can import and which may change in any release.
 package runtime
@@ -0,0 +1,24 @@
+package runtime
+
+import "os"
+
+// ============================================================================
+// Environment variables
+//
+//   env(name)             the variable's value; "" with the not_found
+//                         status if it is not set
+//   setenv(name, value)   sets the variable for the program and the
+//                         processes it starts
+// ============================================================================
+
+// Env returns the value of the environment variable name and whether it
+// is set. A variable set to "" is set.
+func Env(name string) (string, bool) {
+	return os.LookupEnv(name)
+}
+
+// SetEnv sets the environment variable name to value. A name that is
+// empty or holds '=' is an error.
+func SetEnv(name, value string) error {
+	return os.Setenv(name, value)
+}
@@ -0,0 +1,22 @@
+package runtime
+
+import "testing"
+
+func TestEnv(t *testing.T) {
+	t.Setenv("UAL_TEST_ENV", "")
+	if v, ok := Env("UAL_TEST_ENV"); v != "" || !ok {
+		t.Errorf("a variable set to \"\": got %q, %v", v, ok)
+	}
+	if err := SetEnv("UAL_TEST_ENV", "a=b"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := Env("UAL_TEST_ENV"); v != "a=b" || !ok {
+		t.Errorf("got %q, %v; want \"a=b\", true", v, ok)
+	}
+	if _, ok := Env("UAL_TEST_ENV_UNSET"); ok {
+		t.Error("an unset variable is set")
+	}
+	if err := SetEnv("", "x"); err == nil {
+		t.Error("set a variable with no name")
+	}
+}
@@ -0,0 +1,71 @@
+package runtime
+
+import "errors"
+
+// ============================================================================
+// Stack errors
+//
+// Stack, View and ValueStack operations fail with these sentinel values, or
+// with errors that wrap them, so callers can test for a condition with
+// errors.Is instead of matching messages. The messages are what ual
+// programs see on @error and are kept stable as well.
+// ============================================================================
+
+var (
+	// ErrEmpty is returned by pop, peek and friends on an empty stack
+	ErrEmpty = errors.New("stack empty")
+
+	// ErrFull is returned by a push to a capped stack at capacity
+	ErrFull = errors.New("stack is full")
+
+	// ErrFrozen is returned by any change to a frozen stack
+	ErrFrozen = errors.New("stack is frozen")
+
+	// ErrClosed is returned by Take on a closed stack once it is empty
+	ErrClosed = errors.New("stack closed")
+
+	// ErrTimeout is returned by Take when its timeout runs out
+	ErrTimeout = errors.New("take timeout")
+
+	// ErrCancelled is returned by TakeWithContext when its context is done
+	ErrCancelled = errors.New("cancelled")
+
+	// ErrUnderflow is wrapped by the errors of operations that need more
+	// elements than the stack holds, such as swap on a one-element stack
+	ErrUnderflow = errors.New("stack underflow")
+
+	// ErrKeyRequired is returned by positional access to a Hash stack
+	ErrKeyRequired = errors.New("hash perspective requires key")
+
+	// ErrKeyNotFound is returned by a keyed read of a missing key
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrOutOfBounds is returned by an index past either end of the stack
+	ErrOutOfBounds = errors.New("index out of bounds")
+
+	// ErrNotAttached is returned by a view that is not attached to a stack
+	ErrNotAttached = errors.New("view not attached")
+)
+
+// sentinels are the stack errors by message, so that errors returned by a
+// remote stack can be matched with errors.Is like local ones
+var sentinels = map[string]error{}
+
+func init() {
+	for _, err := range []error{
+		ErrEmpty, ErrFull, ErrFrozen, ErrClosed, ErrTimeout, ErrCancelled,
+		ErrKeyRequired, ErrKeyNotFound, ErrOutOfBounds, ErrNotAttached,
+		ErrStale,
+	} {
+		sentinels[err.Error()] = err
+	}
+}
+
+// errorFromMessage returns the sentinel error with message msg, or a new
+// error if there is none
+func errorFromMessage(msg string) error {
+	if err, ok := sentinels[msg]; ok {
+		return err
+	}
+	return errors.New(msg)
+}
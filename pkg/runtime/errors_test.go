@@ -0,0 +1,74 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	s := NewCappedStack(LIFO, TypeInt64, 1)
+	if _, err := s.Pop(); !errors.Is(err, ErrEmpty) {
+		t.Errorf("pop on empty: got %v, want ErrEmpty", err)
+	}
+	s.Push(intToBytes(1))
+	if err := s.Push(intToBytes(2)); !errors.Is(err, ErrFull) {
+		t.Errorf("push when full: got %v, want ErrFull", err)
+	}
+	if _, err := s.PeekAt(5); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("peek past the end: got %v, want ErrOutOfBounds", err)
+	}
+	s.Freeze()
+	if _, err := s.Pop(); !errors.Is(err, ErrFrozen) {
+		t.Errorf("pop when frozen: got %v, want ErrFrozen", err)
+	}
+
+	h := NewStack(Hash, TypeInt64)
+	h.Push(intToBytes(1), []byte("k"))
+	if _, err := h.Peek(); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("peek without a key: got %v, want ErrKeyRequired", err)
+	}
+	if _, err := h.Peek([]byte("nope")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("peek a missing key: got %v, want ErrKeyNotFound", err)
+	}
+
+	vs := NewValueStack(LIFO)
+	vs.Push(NewInt(1))
+	if err := vs.Swap(); !errors.Is(err, ErrUnderflow) || err.Error() != "stack underflow: swap requires 2 elements" {
+		t.Errorf("swap with one element: got %v, want ErrUnderflow", err)
+	}
+
+	if _, err := NewView(LIFO).Pop(); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("pop on a detached view: got %v, want ErrNotAttached", err)
+	}
+}
+
+func TestTakeErrors(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	if _, err := s.Take(10); err != ErrTimeout {
+		t.Errorf("take: got %v, want ErrTimeout", err)
+	}
+
+	// Generated select code matches this timeout by its message
+	_, err := s.TakeWithContext(context.Background(), 10)
+	if !errors.Is(err, ErrTimeout) || err.Error() != "timeout" {
+		t.Errorf("take with context: got %v, want \"timeout\" matching ErrTimeout", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.TakeWithContext(ctx, 0); err != ErrCancelled {
+		t.Errorf("take with cancelled context: got %v, want ErrCancelled", err)
+	}
+
+	s.Close()
+	if _, err := s.Take(); err != ErrClosed {
+		t.Errorf("take on closed stack: got %v, want ErrClosed", err)
+	}
+}
+
+func TestRemoteSentinelErrors(t *testing.T) {
+	rs := dialTest(t, serveTest(t, NewStack(LIFO, TypeInt64)))
+	if _, err := rs.Pop(); err != ErrEmpty {
+		t.Errorf("remote pop on empty: got %v, want ErrEmpty", err)
+	}
+}
@@ -0,0 +1,245 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// Expectations
+//
+//   expect_stack(@s, [1, 2, 3])    @s holds 1, 2, 3, bottom to top
+//   expect_output("a\nb\n")        stdout since the last expect_output
+//
+// A failed expectation prints what was wanted, what was found and where
+// they first differ to stderr, and the program carries on so every failure
+// is seen. It then exits with status 1. Programs that use expectations
+// call EnableExpect first; with output capture on, stdout is still written
+// through as usual.
+// ============================================================================
+
+var expect struct {
+	mu       sync.Mutex
+	failures int
+
+	// Output capture: os.Stdout is a pipe copied to the real stdout and to
+	// buf, which holds the output since the last ExpectOutput
+	stdout *os.File
+	w      *os.File
+	done   chan struct{}
+	buf    bytes.Buffer
+}
+
+// EnableExpect prepares the program for expect_stack and expect_output,
+// capturing stdout if captureOutput is set. It registers an exit hook that
+// reports the number of failed expectations and exits with status 1 if
+// there were any; call it before any other AtExit so that hook runs last.
+func EnableExpect(captureOutput bool) {
+	if captureOutput {
+		startCapture()
+	}
+	AtExit(finishExpect)
+}
+
+// ExpectFailures returns how many expectations have failed so far
+func ExpectFailures() int {
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	return expect.failures
+}
+
+func finishExpect() {
+	if expect.w != nil {
+		expect.mu.Lock()
+		stopCapture()
+		expect.mu.Unlock()
+	}
+	if n := ExpectFailures(); n > 0 {
+		fmt.Fprintf(os.Stderr, "%d expectation(s) failed\n", n)
+		exitProcess(1)
+	}
+}
+
+// ExpectStack checks that s holds want, bottom to top (for a Hash stack,
+// its values in key order). want is encoded in the stack's element type.
+// line is the source line of the check, or 0 if unknown.
+func ExpectStack(line int, name string, s *Stack, want [][]byte) bool {
+	s.mu.RLock()
+	var got []string
+	for i := s.head; i < len(s.elements); i++ {
+		if s.perspective == Hash && s.keys[i] == nil {
+			continue // popped key
+		}
+		got = append(got, expectElement(s.elementType, s.unpack(s.elements[i].data)))
+	}
+	t := s.elementType
+	s.mu.RUnlock()
+
+	wantStr := make([]string, len(want))
+	for i, w := range want {
+		wantStr[i] = expectElement(t, w)
+	}
+	return ExpectValues(line, fmt.Sprintf("expect_stack(@%s)", name), got, wantStr)
+}
+
+// ExpectValueStack is ExpectStack for the interpreter's stacks of Values
+func ExpectValueStack(line int, name string, vs *ValueStack, want []Value) bool {
+	s := vs.stack
+	s.mu.RLock()
+	var got []string
+	for i := s.head; i < len(s.elements); i++ {
+		if s.perspective == Hash && s.keys[i] == nil {
+			continue
+		}
+		got = append(got, expectValue(ValueFromBytes(s.elements[i].data)))
+	}
+	s.mu.RUnlock()
+
+	wantStr := make([]string, len(want))
+	for i, w := range want {
+		wantStr[i] = expectValue(w)
+	}
+	return ExpectValues(line, fmt.Sprintf("expect_stack(@%s)", name), got, wantStr)
+}
+
+// ExpectValues checks a sequence of formatted values against want and
+// reports the first difference as the failure of what
+func ExpectValues(line int, what string, got, want []string) bool {
+	i := 0
+	for i < len(got) && i < len(want) && got[i] == want[i] {
+		i++
+	}
+	if i == len(got) && i == len(want) {
+		return true
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "want: [%s]\n", strings.Join(want, ", "))
+	fmt.Fprintf(&b, "got:  [%s]\n", strings.Join(got, ", "))
+	fmt.Fprintf(&b, "first difference at position %d: want %s, got %s",
+		i, valueAt(want, i), valueAt(got, i))
+	expectFailed(line, what, b.String())
+	return false
+}
+
+// ExpectOutput checks that the program has printed want since the last
+// ExpectOutput (or since it started). Output capture must be enabled.
+func ExpectOutput(line int, want string) bool {
+	expect.mu.Lock()
+	if expect.w == nil {
+		expect.mu.Unlock()
+		expectFailed(line, "expect_output", "output is not being captured")
+		return false
+	}
+	got := stopCapture()
+	startCapture()
+	expect.mu.Unlock()
+
+	if got == want {
+		return true
+	}
+	gotLines := strings.SplitAfter(got, "\n")
+	wantLines := strings.SplitAfter(want, "\n")
+	i := 0
+	for i < len(gotLines) && i < len(wantLines) && gotLines[i] == wantLines[i] {
+		i++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "want: %s\n", strconv.Quote(want))
+	fmt.Fprintf(&b, "got:  %s\n", strconv.Quote(got))
+	fmt.Fprintf(&b, "first difference at line %d: want %s, got %s",
+		i+1, quotedAt(wantLines, i), quotedAt(gotLines, i))
+	expectFailed(line, "expect_output", b.String())
+	return false
+}
+
+func expectFailed(line int, what, detail string) {
+	expect.mu.Lock()
+	expect.failures++
+	expect.mu.Unlock()
+
+	where := ""
+	if line > 0 {
+		where = fmt.Sprintf("line %d: ", line)
+	}
+	detail = strings.ReplaceAll(detail, "\n", "\n  ")
+	fmt.Fprintf(os.Stderr, "%s%s failed\n  %s\n", where, what, detail)
+}
+
+func valueAt(vals []string, i int) string {
+	if i < len(vals) {
+		return vals[i]
+	}
+	return "nothing"
+}
+
+func quotedAt(lines []string, i int) string {
+	if i < len(lines) && lines[i] != "" {
+		return strconv.Quote(lines[i])
+	}
+	return "end of output"
+}
+
+// expectElement renders an element as it is written in ual source, with
+// strings quoted
+func expectElement(t ElementType, b []byte) string {
+	if t == TypeString || t == TypeBytes {
+		return strconv.Quote(string(b))
+	}
+	return formatElement(b, t)
+}
+
+// expectValue renders a Value the way expectElement renders its element
+func expectValue(v Value) string {
+	switch v.Type {
+	case VTInt:
+		return strconv.FormatInt(v.iVal, 10)
+	case VTFloat:
+		return strconv.FormatFloat(v.fVal, 'f', -1, 64)
+	case VTBool:
+		return strconv.FormatBool(v.iVal != 0)
+	case VTString:
+		return strconv.Quote(v.AsString())
+	default:
+		return v.AsString()
+	}
+}
+
+// startCapture points os.Stdout at a pipe whose contents are copied to
+// the real stdout and kept for ExpectOutput. Caller holds expect.mu, or
+// is EnableExpect.
+func startCapture() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	if expect.stdout == nil {
+		expect.stdout = os.Stdout
+	}
+	expect.w = w
+	expect.done = make(chan struct{})
+	os.Stdout = w
+	go func(done chan struct{}) {
+		io.Copy(io.MultiWriter(expect.stdout, &expect.buf), r)
+		r.Close()
+		close(done)
+	}(expect.done)
+}
+
+// stopCapture restores the real stdout and returns what was printed since
+// capture started. Caller holds expect.mu.
+func stopCapture() string {
+	os.Stdout = expect.stdout
+	expect.w.Close()
+	<-expect.done
+	expect.w = nil
+	out := expect.buf.String()
+	expect.buf.Reset()
+	return out
+}
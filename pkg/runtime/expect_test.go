@@ -0,0 +1,78 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExpectStack(t *testing.T) {
+	before := ExpectFailures()
+
+	s := NewStack(LIFO, TypeInt64)
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	s.Push(intToBytes(3))
+	if !ExpectStack(1, "s", s, [][]byte{intToBytes(1), intToBytes(2), intToBytes(3)}) {
+		t.Error("matching stack reported as failed")
+	}
+	if ExpectStack(2, "s", s, [][]byte{intToBytes(1), intToBytes(2)}) {
+		t.Error("extra element not reported")
+	}
+
+	h := NewStack(Hash, TypeString)
+	h.Push([]byte("a"), []byte("k1"))
+	h.Push([]byte("b"), []byte("k2"))
+	h.Pop([]byte("k1"))
+	if !ExpectStack(3, "h", h, [][]byte{[]byte("b")}) {
+		t.Error("Hash stack should skip popped keys")
+	}
+
+	if got := ExpectFailures() - before; got != 1 {
+		t.Errorf("failures = %d, want 1", got)
+	}
+}
+
+func TestExpectValuesFirstDifference(t *testing.T) {
+	if !ExpectValues(0, "x", []string{"1", "2"}, []string{"1", "2"}) {
+		t.Error("equal values reported as different")
+	}
+	if ExpectValues(0, "x", []string{"1", "4"}, []string{"1", "2", "3"}) {
+		t.Error("different values reported as equal")
+	}
+	if got := expectElement(TypeFloat64, float64ToBytes(2.5)); got != "2.5" {
+		t.Errorf("float formats as %s", got)
+	}
+	if got := expectElement(TypeString, []byte("hi")); got != `"hi"` {
+		t.Errorf("string formats as %s", got)
+	}
+}
+
+func TestExpectOutput(t *testing.T) {
+	saved := exitProcess
+	defer func() { exitProcess = saved }()
+	code := -1
+	exitProcess = func(c int) { code = c }
+
+	before := ExpectFailures()
+	EnableExpect(true)
+	fmt.Println("hello")
+	fmt.Print("world")
+	if !ExpectOutput(1, "hello\nworld") {
+		t.Error("captured output did not match")
+	}
+	fmt.Println("again")
+	if ExpectOutput(2, "once\n") {
+		t.Error("mismatch not reported")
+	}
+	if ExpectFailures()-before != 1 {
+		t.Errorf("failures = %d, want 1", ExpectFailures()-before)
+	}
+
+	RunAtExit()
+	if expect.w != nil {
+		t.Error("capture still running after exit")
+	}
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+}
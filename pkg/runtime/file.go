@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ============================================================================
+// Files
+//
+//   readfile(path)           the file's contents as a string
+//   writefile(path, @s)      replaces the file with the elements of @s
+//   appendfile(path, @s)     adds the elements of @s to the end of the file
+//   exists(path)             whether the file exists
+//
+// The elements of a stack are written in the order they were pushed: a
+// string or number element as a line of its own, a bytes element as it is.
+// When a call fails the consider status says why, see FileStatus.
+// ============================================================================
+
+// ReadFile returns the contents of the file at path.
+func ReadFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// WriteFile replaces the file at path, creating it if need be, with the
+// elements of s.
+func WriteFile(path string, s *Stack) error {
+	data, err := fileData(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// AppendFile adds the elements of s to the end of the file at path,
+// creating it if need be.
+func AppendFile(path string, s *Stack) error {
+	data, err := fileData(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// FileExists reports whether there is a file, or a directory, at path.
+func FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// FileStatus returns the consider status a failed file builtin, or
+// readline, sets: "not_found" if there is no such file, "denied" if it may
+// not be read or written, "eof" at the end of input, and "error"
+// otherwise.
+func FileStatus(err error) string {
+	switch {
+	case err == io.EOF:
+		return "eof"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, fs.ErrPermission):
+		return "denied"
+	}
+	return "error"
+}
+
+// fileData returns the elements of s, in push order, as the file
+// writefile writes
+func fileData(s *Stack) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.perspective == Hash {
+		return nil, errors.New("writefile: a Hash stack has no order to write in")
+	}
+	var b strings.Builder
+	for _, e := range s.elements[s.head:] {
+		data := s.unpack(e.data)
+		if s.elementType == TypeBytes {
+			b.Write(data)
+			continue
+		}
+		b.WriteString(formatElement(data, s.elementType))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,77 @@
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+
+	lines := NewStack(FIFO, TypeString)
+	lines.Push([]byte("alpha"))
+	lines.Push([]byte("beta"))
+	if err := WriteFile(path, lines); err != nil {
+		t.Fatal(err)
+	}
+	nums := NewStack(LIFO, TypeInt64)
+	nums.Push(intToBytes(1))
+	nums.Push(intToBytes(-2))
+	if err := AppendFile(path, nums); err != nil {
+		t.Fatal(err)
+	}
+	raw := NewStack(LIFO, TypeBytes)
+	raw.Push([]byte("x"))
+	raw.Push([]byte("y"))
+	if err := AppendFile(path, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "alpha\nbeta\n1\n-2\nxy"; got != want {
+		t.Errorf("file holds %q, want %q", got, want)
+	}
+	if !FileExists(path) {
+		t.Errorf("FileExists(%s) = false", path)
+	}
+
+	// Popped elements of a FIFO are not written
+	lines.Pop()
+	if err := WriteFile(path, lines); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ReadFile(path); got != "beta\n" {
+		t.Errorf("after a pop the file holds %q, want %q", got, "beta\n")
+	}
+
+	if err := WriteFile(path, NewStack(Hash, TypeString)); err == nil {
+		t.Error("wrote a Hash stack")
+	}
+}
+
+func TestFileStatus(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.txt")
+	if FileExists(missing) {
+		t.Errorf("FileExists(%s) = true", missing)
+	}
+	_, err := ReadFile(missing)
+	if got := FileStatus(err); got != "not_found" {
+		t.Errorf("reading a missing file: status %q, want not_found", got)
+	}
+	err = WriteFile(filepath.Join(missing, "x"), NewStack(LIFO, TypeString))
+	if got := FileStatus(err); got != "not_found" {
+		t.Errorf("writing below a missing directory: status %q, want not_found", got)
+	}
+	if got := FileStatus(os.ErrPermission); got != "denied" {
+		t.Errorf("permission error: status %q, want denied", got)
+	}
+	_, err = ReadFile(dir)
+	if got := FileStatus(err); got != "error" {
+		t.Errorf("reading a directory: status %q, want error", got)
+	}
+}
@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ============================================================================
+// Codeblock values
+//
+//   var f fn = {|x| x * 2}     a codeblock stored in a variable
+//   @fns push:f                ... or on a stack of type fn
+//   call(f, 21)                calls it with arguments
+//   apply(f, @args)            calls it with arguments popped from @args
+//
+// A codeblock used as a value is registered as a Fn, and the value itself
+// is the Fn's handle. Handles are int64s, so they live on i64 stacks and
+// in i64 variables and pass between goroutines like any other number.
+// Registered codeblocks stay alive until the program ends.
+// ============================================================================
+
+// Fn is a registered codeblock: the number of arguments it takes and its
+// body, which receives exactly that many
+type Fn struct {
+	Params int
+	Body   func(args []int64) int64
+}
+
+var fnTable struct {
+	mu  sync.RWMutex
+	fns []*Fn // handle h is fns[h-1]
+}
+
+// NewFn registers a codeblock taking params arguments and returns its
+// handle. Handles count up from 1, so 0 is never a codeblock.
+func NewFn(params int, body func(args []int64) int64) int64 {
+	fnTable.mu.Lock()
+	defer fnTable.mu.Unlock()
+	fnTable.fns = append(fnTable.fns, &Fn{Params: params, Body: body})
+	return int64(len(fnTable.fns))
+}
+
+// LookupFn returns the codeblock with handle h
+func LookupFn(h int64) (*Fn, error) {
+	fnTable.mu.RLock()
+	defer fnTable.mu.RUnlock()
+	if h < 1 || h > int64(len(fnTable.fns)) {
+		return nil, fmt.Errorf("%d is not a codeblock", h)
+	}
+	return fnTable.fns[h-1], nil
+}
+
+// CallFn calls the codeblock with handle h. It panics if h is not a
+// codeblock or args does not match its parameters.
+func CallFn(h int64, args ...int64) int64 {
+	fn, err := LookupFn(h)
+	if err != nil {
+		panic("call: " + err.Error())
+	}
+	if len(args) != fn.Params {
+		panic(fmt.Sprintf("call: codeblock takes %d arguments, got %d", fn.Params, len(args)))
+	}
+	return fn.Body(args)
+}
+
+// ApplyFn calls the codeblock with handle h, taking one argument per
+// parameter from pop, the Pop method of a Stack or UnsafeStack. The last
+// argument is popped first, so arguments pushed in order are passed in
+// order. It panics if h is not a codeblock or pop fails.
+func ApplyFn(h int64, pop func(...[]byte) ([]byte, error)) int64 {
+	fn, err := LookupFn(h)
+	if err != nil {
+		panic("apply: " + err.Error())
+	}
+	args := make([]int64, fn.Params)
+	for i := len(args) - 1; i >= 0; i-- {
+		b, err := pop()
+		if err != nil {
+			panic(fmt.Sprintf("apply: codeblock takes %d arguments: %v", fn.Params, err))
+		}
+		args[i] = bytesToInt(b)
+	}
+	return fn.Body(args)
+}
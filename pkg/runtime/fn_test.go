@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallFn(t *testing.T) {
+	base := int64(10)
+	add := NewFn(2, func(args []int64) int64 { return args[0] + args[1] + base })
+	if add < 1 {
+		t.Fatalf("handle %d", add)
+	}
+	if got := CallFn(add, 1, 2); got != 13 {
+		t.Errorf("CallFn = %d, want 13", got)
+	}
+	base = 20 // closures see later changes to what they capture
+	if got := CallFn(add, 1, 2); got != 23 {
+		t.Errorf("CallFn after change = %d, want 23", got)
+	}
+
+	if _, err := LookupFn(0); err == nil {
+		t.Error("LookupFn(0) succeeded")
+	}
+	expectPanic(t, "is not a codeblock", func() { CallFn(add + 1000) })
+	expectPanic(t, "takes 2 arguments, got 1", func() { CallFn(add, 1) })
+}
+
+func TestApplyFn(t *testing.T) {
+	sub := NewFn(2, func(args []int64) int64 { return args[0] - args[1] })
+	s := NewStack(LIFO, TypeInt64)
+	s.Push(intToBytes(10))
+	s.Push(intToBytes(3))
+	if got := ApplyFn(sub, s.Pop); got != 7 {
+		t.Errorf("ApplyFn = %d, want 7 (10 - 3)", got)
+	}
+	if s.Len() != 0 {
+		t.Errorf("%d elements left", s.Len())
+	}
+	expectPanic(t, "takes 2 arguments", func() { ApplyFn(sub, s.Pop) })
+}
+
+func expectPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), want) {
+			t.Errorf("panic %v, want one containing %q", r, want)
+		}
+	}()
+	f()
+}
@@ -0,0 +1,229 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// Number formatting
+//
+//   format_int(n, width, pad)     FormatInt(-42, 6, "0")  -> "-00042"
+//   format_float(x, prec, width)  FormatFloat(3.14159, 2, 8) -> "    3.14"
+//
+// Output never depends on the locale: '.' is the decimal point, there is no
+// digit grouping, and the backends produce identical strings.
+// ============================================================================
+
+// FormatInt formats n in decimal, padded on the left with pad to at least
+// width characters. A pad of "0" goes after the sign, as printf's %05d
+// does. Only the first character of pad is used; "" means a space. A
+// negative width pads on the right instead.
+func FormatInt(n int64, width int64, pad string) string {
+	s := strconv.FormatInt(n, 10)
+	r, _ := utf8.DecodeRuneInString(pad)
+	if pad == "" {
+		r = ' '
+	}
+	if r == '0' && n < 0 && width > 0 {
+		return "-" + padLeft(s[1:], width-1, '0')
+	}
+	return padString(s, width, r)
+}
+
+// FormatFloat formats x with prec digits after the decimal point, or as
+// few as needed to represent it exactly if prec is negative, right-aligned
+// in width characters (left-aligned if width is negative). NaN and
+// infinities format as "NaN", "Inf" and "-Inf".
+func FormatFloat(x float64, prec int64, width int64) string {
+	var s string
+	switch {
+	case math.IsNaN(x):
+		s = "NaN"
+	case math.IsInf(x, 1):
+		s = "Inf"
+	case math.IsInf(x, -1):
+		s = "-Inf"
+	default:
+		if prec < 0 {
+			prec = -1
+		}
+		s = strconv.FormatFloat(x, 'f', int(prec), 64)
+	}
+	return padString(s, width, ' ')
+}
+
+// padString pads s with r to |width| characters, on the left for a
+// positive width and on the right for a negative one.
+func padString(s string, width int64, r rune) string {
+	if width < 0 {
+		n := -width - int64(utf8.RuneCountInString(s))
+		if n <= 0 {
+			return s
+		}
+		return s + strings.Repeat(string(r), int(n))
+	}
+	return padLeft(s, width, r)
+}
+
+func padLeft(s string, width int64, r rune) string {
+	n := width - int64(utf8.RuneCountInString(s))
+	if n <= 0 {
+		return s
+	}
+	return strings.Repeat(string(r), int(n)) + s
+}
+
+// ============================================================================
+// printf formats
+//
+//   printf("%-8s %5d %.2f\n", name, n, x)   format(...) returns the string
+//
+// A verb is %[flags][width][.prec]verb. The verbs are d (decimal), x and X
+// (hex), o (octal), b (binary), f (fixed point, 6 digits unless prec is
+// given) and s or v (any value, as print shows it). The flags are - (pad
+// on the right), + (always show the sign, d and f only) and 0 (pad with
+// zeros, numbers only). x, X, o and b show negative numbers in 64-bit
+// two's complement. %% is a literal percent sign.
+// ============================================================================
+
+// FormatVerb is one verb of a printf format, such as %-8.2f
+type FormatVerb struct {
+	Flags string // any of "-", "+" and "0", in that order
+	Width int    // -1 if not given
+	Prec  int    // -1 if not given
+	Verb  byte   // one of d x X o b f s v
+}
+
+// IsInt reports whether v formats an integer
+func (v FormatVerb) IsInt() bool {
+	return strings.IndexByte("dxXob", v.Verb) >= 0
+}
+
+// IsFloat reports whether v formats a float
+func (v FormatVerb) IsFloat() bool {
+	return v.Verb == 'f'
+}
+
+// Unsigned reports whether v shows its integer in two's complement
+func (v FormatVerb) Unsigned() bool {
+	return strings.IndexByte("xXob", v.Verb) >= 0
+}
+
+// HasFlag reports whether v has the flag c
+func (v FormatVerb) HasFlag(c byte) bool {
+	return strings.IndexByte(v.Flags, c) >= 0
+}
+
+// String returns v as a Go fmt verb, with s as v so that any value prints
+// as print shows it
+func (v FormatVerb) String() string {
+	s := "%" + v.Flags
+	if v.Width >= 0 {
+		s += strconv.Itoa(v.Width)
+	}
+	if v.Prec >= 0 {
+		s += "." + strconv.Itoa(v.Prec)
+	}
+	if v.Verb == 's' {
+		return s + "v"
+	}
+	return s + string(v.Verb)
+}
+
+// ParseFormat splits a printf format into its text and its verbs: text[i]
+// comes before verbs[i], and the last text after the last verb, so text
+// has one more element than verbs. %% in the text is unescaped.
+func ParseFormat(format string) (text []string, verbs []FormatVerb, err error) {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+		v := FormatVerb{Width: -1, Prec: -1}
+		flags := map[byte]bool{}
+		for ; i < len(format) && strings.IndexByte("-+0 #", format[i]) >= 0; i++ {
+			if format[i] == ' ' || format[i] == '#' {
+				return nil, nil, fmt.Errorf("unsupported flag %q in format", format[i])
+			}
+			flags[format[i]] = true
+		}
+		if flags['-'] {
+			delete(flags, '0') // as in C, - wins
+		}
+		for _, c := range []byte("-+0") {
+			if flags[c] {
+				v.Flags += string(c)
+			}
+		}
+		start := i
+		for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+		}
+		if i > start {
+			v.Width, _ = strconv.Atoi(format[start:i])
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			start = i
+			for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+			}
+			v.Prec, _ = strconv.Atoi(format[start:i])
+		}
+		if i >= len(format) {
+			return nil, nil, fmt.Errorf("incomplete verb at end of format")
+		}
+		v.Verb = format[i]
+		switch {
+		case strings.IndexByte("dxXobfsv", v.Verb) < 0:
+			return nil, nil, fmt.Errorf("unknown verb %%%c in format", v.Verb)
+		case v.Prec >= 0 && !v.IsFloat():
+			return nil, nil, fmt.Errorf("%%%c does not take a precision", v.Verb)
+		case v.HasFlag('+') && v.Verb != 'd' && v.Verb != 'f':
+			return nil, nil, fmt.Errorf("%%%c does not take the + flag", v.Verb)
+		case v.HasFlag('0') && !v.IsInt() && !v.IsFloat():
+			return nil, nil, fmt.Errorf("%%%c does not take the 0 flag", v.Verb)
+		}
+		text = append(text, sb.String())
+		sb.Reset()
+		verbs = append(verbs, v)
+	}
+	return append(text, sb.String()), verbs, nil
+}
+
+// Sprintf formats args by format, as the printf and format builtins do.
+// It fails if the format is malformed or does not have a verb for each
+// argument.
+func Sprintf(format string, args ...Value) (string, error) {
+	text, verbs, err := ParseFormat(format)
+	if err != nil {
+		return "", err
+	}
+	if len(verbs) != len(args) {
+		return "", fmt.Errorf("format has %d verbs but %d arguments", len(verbs), len(args))
+	}
+	var sb strings.Builder
+	for i, v := range verbs {
+		sb.WriteString(text[i])
+		switch {
+		case v.Unsigned():
+			fmt.Fprintf(&sb, v.String(), uint64(args[i].AsInt()))
+		case v.IsInt():
+			fmt.Fprintf(&sb, v.String(), args[i].AsInt())
+		case v.IsFloat():
+			fmt.Fprintf(&sb, v.String(), args[i].AsFloat())
+		default:
+			fmt.Fprintf(&sb, v.String(), args[i].AsString())
+		}
+	}
+	sb.WriteString(text[len(verbs)])
+	return sb.String(), nil
+}
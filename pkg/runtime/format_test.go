@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatInt(t *testing.T) {
+	tests := []struct {
+		n     int64
+		width int64
+		pad   string
+		want  string
+	}{
+		{42, 6, "0", "000042"},
+		{-42, 6, "0", "-00042"},
+		{42, 6, " ", "    42"},
+		{42, 6, "", "    42"},
+		{42, 6, "*-", "****42"},
+		{42, -6, ".", "42...."},
+		{-42, -6, "0", "-42000"},
+		{123456, 3, "0", "123456"},
+		{7, 3, "·", "··7"},
+		{math.MinInt64, 22, "0", "-009223372036854775808"},
+	}
+	for _, tt := range tests {
+		if got := FormatInt(tt.n, tt.width, tt.pad); got != tt.want {
+			t.Errorf("FormatInt(%d, %d, %q) = %q, want %q", tt.n, tt.width, tt.pad, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		x     float64
+		prec  int64
+		width int64
+		want  string
+	}{
+		{3.14159, 2, 8, "    3.14"},
+		{3.14159, 2, -8, "3.14    "},
+		{2.5, 0, 0, "2"},
+		{0.125, 2, 0, "0.12"},
+		{-1.5, 3, 0, "-1.500"},
+		{0.1, -1, 0, "0.1"},
+		{1e21, 0, 0, "1000000000000000000000"},
+		{math.NaN(), 2, 5, "  NaN"},
+		{math.Inf(1), 2, 0, "Inf"},
+		{math.Inf(-1), 2, 0, "-Inf"},
+	}
+	for _, tt := range tests {
+		if got := FormatFloat(tt.x, tt.prec, tt.width); got != tt.want {
+			t.Errorf("FormatFloat(%v, %d, %d) = %q, want %q", tt.x, tt.prec, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []Value
+		want   string
+	}{
+		{"%d items", []Value{NewInt(3)}, "3 items"},
+		{"[%5d|%-5d|%05d]", []Value{NewInt(42), NewInt(42), NewInt(-42)}, "[   42|42   |-0042]"},
+		{"%+d %x %X %o %b", []Value{NewInt(7), NewInt(255), NewInt(255), NewInt(8), NewInt(5)}, "+7 ff FF 10 101"},
+		{"%x", []Value{NewInt(-1)}, "ffffffffffffffff"},
+		{"%f %.2f %8.3f", []Value{NewFloat(1.5), NewFloat(3.14159), NewFloat(-2.5)}, "1.500000 3.14   -2.500"},
+		{"%.1f", []Value{NewInt(2)}, "2.0"},
+		{"%s=%v", []Value{NewString("n"), NewFloat(0.25)}, "n=0.25"},
+		{"[%-4s|%4s]", []Value{NewString("ab"), NewBool(true)}, "[ab  |true]"},
+		{"100%%", nil, "100%"},
+	}
+	for _, tt := range tests {
+		got, err := Sprintf(tt.format, tt.args...)
+		if err != nil {
+			t.Errorf("Sprintf(%q): %v", tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestParseFormatErrors(t *testing.T) {
+	for _, format := range []string{"%", "%5", "%q", "% d", "%#x", "%.2d", "%+s", "%05s"} {
+		if _, _, err := ParseFormat(format); err == nil {
+			t.Errorf("ParseFormat(%q): expected error", format)
+		}
+	}
+	if _, err := Sprintf("%d %d", NewInt(1)); err == nil {
+		t.Error("Sprintf with too few arguments: expected error")
+	}
+}
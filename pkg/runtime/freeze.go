@@ -0,0 +1,84 @@
+package runtime
+
+// ============================================================================
+// Freeze modes
+//
+//   @s freeze                  FreezeAll: read-only
+//   @s freeze("structure")     FreezeStructure: values change in place only
+//   @s freeze("append-only")   AppendOnly: pushes only, as for an audit log
+//
+// A mode is the set of changes the stack rejects with ErrFrozen. Freezing
+// again adds to the set, so a stack never thaws: an append-only stack
+// frozen with FreezeStructure becomes read-only.
+// ============================================================================
+
+// FreezeMode selects the changes a frozen stack rejects
+type FreezeMode uint8
+
+// the changes a FreezeMode can reject
+const (
+	freezeAdd FreezeMode = 1 << iota
+	freezeRemove
+	freezeUpdate
+)
+
+const (
+	// FreezeAll rejects every change
+	FreezeAll = freezeAdd | freezeRemove | freezeUpdate
+
+	// FreezeStructure rejects adding and removing elements. A Hash key can
+	// still be set again and an Indexed slot rewritten.
+	FreezeStructure = freezeAdd | freezeRemove
+
+	// AppendOnly accepts new elements and rejects pops, takes, deletes and
+	// updates of existing ones
+	AppendOnly = freezeRemove | freezeUpdate
+)
+
+var freezeModes = map[string]FreezeMode{
+	"all":         FreezeAll,
+	"structure":   FreezeStructure,
+	"append-only": AppendOnly,
+}
+
+// LookupFreezeMode returns the mode named by freeze("...") in ual source:
+// "all", "structure" or "append-only"
+func LookupFreezeMode(name string) (FreezeMode, bool) {
+	mode, ok := freezeModes[name]
+	return mode, ok
+}
+
+// FreezeWith freezes the stack in mode, on top of any earlier freeze
+func (s *Stack) FreezeWith(mode FreezeMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compact()
+	s.frozen |= mode
+}
+
+// Frozen returns the changes the stack rejects, 0 if it is not frozen
+func (s *Stack) Frozen() FreezeMode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.frozen
+}
+
+// allows reports whether the freeze mode permits change. Caller holds s.mu.
+func (s *Stack) allows(change FreezeMode) bool {
+	return s.frozen&change == 0
+}
+
+// allowsSet reports whether the freeze mode permits storing a value under
+// key: an update if the Hash stack has the key, otherwise an addition.
+// Caller holds s.mu.
+func (s *Stack) allowsSet(key []byte) bool {
+	if s.frozen == 0 {
+		return true
+	}
+	if s.perspective == Hash {
+		if _, exists := s.hashIdx[string(key)]; exists {
+			return s.allows(freezeUpdate)
+		}
+	}
+	return s.allows(freezeAdd)
+}
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFreezeAppendOnly(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	s.Push(intToBytes(1))
+	s.FreezeWith(AppendOnly)
+
+	if err := s.Push(intToBytes(2)); err != nil {
+		t.Fatalf("push to an append-only stack: %v", err)
+	}
+	if _, err := s.Pop(); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Pop = %v, want ErrFrozen", err)
+	}
+	if _, err := s.Take(); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Take = %v, want ErrFrozen", err)
+	}
+	s.Clear()
+	if s.Len() != 2 {
+		t.Errorf("Len = %d after Clear, want 2", s.Len())
+	}
+	if err := s.PushAt(0, intToBytes(9)); !errors.Is(err, ErrFrozen) {
+		t.Errorf("PushAt over an element = %v, want ErrFrozen", err)
+	}
+
+	dst := NewStack(LIFO, TypeInt64)
+	if err := dst.Bring(s); err == nil {
+		t.Error("expected bring from an append-only stack to fail")
+	}
+	src := NewStack(LIFO, TypeInt64)
+	src.Push(intToBytes(3))
+	if err := s.Bring(src); err != nil {
+		t.Errorf("bring to an append-only stack: %v", err)
+	}
+	if s.Len() != 3 {
+		t.Errorf("Len = %d, want 3", s.Len())
+	}
+}
+
+func TestFreezeAppendOnlyHash(t *testing.T) {
+	h := NewStack(Hash, TypeInt64)
+	h.Push(intToBytes(1), []byte("a"))
+	h.FreezeWith(AppendOnly)
+
+	if err := h.Push(intToBytes(2), []byte("b")); err != nil {
+		t.Errorf("push of a new key: %v", err)
+	}
+	if err := h.Push(intToBytes(3), []byte("a")); !errors.Is(err, ErrFrozen) {
+		t.Errorf("update of a key = %v, want ErrFrozen", err)
+	}
+	if _, err := h.Delete("a"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Delete = %v, want ErrFrozen", err)
+	}
+}
+
+func TestFreezeStructure(t *testing.T) {
+	h := NewStack(Hash, TypeInt64)
+	h.Push(intToBytes(1), []byte("a"))
+	h.FreezeWith(FreezeStructure)
+
+	if err := h.Push(intToBytes(2), []byte("a")); err != nil {
+		t.Errorf("update of a key: %v", err)
+	}
+	if err := h.Push(intToBytes(3), []byte("b")); !errors.Is(err, ErrFrozen) {
+		t.Errorf("push of a new key = %v, want ErrFrozen", err)
+	}
+	if v, _ := h.Peek([]byte("a")); bytesToInt(v) != 2 {
+		t.Errorf("a = %d, want 2", bytesToInt(v))
+	}
+
+	s := NewStack(Indexed, TypeInt64)
+	s.Push(intToBytes(1))
+	s.FreezeWith(FreezeStructure)
+	if err := ParallelMap(s, func(b []byte) ([]byte, error) { return intToBytes(bytesToInt(b) * 10), nil }, nil); err != nil {
+		t.Errorf("ParallelMap: %v", err)
+	}
+	if err := s.Push(intToBytes(2)); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Push = %v, want ErrFrozen", err)
+	}
+}
+
+func TestFreezeModesCombine(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	if s.IsFrozen() {
+		t.Fatal("new stack is frozen")
+	}
+	s.FreezeWith(AppendOnly)
+	s.FreezeWith(FreezeStructure)
+	if s.Frozen() != FreezeAll {
+		t.Errorf("Frozen = %d, want FreezeAll", s.Frozen())
+	}
+	if err := s.Push(intToBytes(1)); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Push = %v, want ErrFrozen", err)
+	}
+
+	for name, want := range map[string]FreezeMode{"all": FreezeAll, "structure": FreezeStructure, "append-only": AppendOnly} {
+		if got, ok := LookupFreezeMode(name); !ok || got != want {
+			t.Errorf("LookupFreezeMode(%q) = %d, %v", name, got, ok)
+		}
+	}
+	if _, ok := LookupFreezeMode("read-only"); ok {
+		t.Error("LookupFreezeMode accepted an unknown mode")
+	}
+}
@@ -0,0 +1,211 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// HTTP
+//
+//   listen(":8080", @reqs, @resps)
+//
+// ServeHTTP serves HTTP in the background. Each request is pushed to the
+// requests stack as a JSON object, the text from_json reads into a Hash
+// stack:
+//
+//   {"id":"1","method":"GET","host":"localhost:8080","path":"/hi",
+//    "query":"a=1","remote":"...","body":"","Accept":"*/*",...}
+//
+// its headers other than Host following the fixed members by their
+// canonical names, with repeated headers joined by ", ". A handler answers by pushing a JSON
+// object to the responses stack, normally a Hash stack's to_json: "id" as
+// in the request, "status" (200 if left out), "body", and any other member
+// as a response header. Responses are matched to requests by id, so they
+// may come in any order and from any task. A request not answered within
+// httpTimeout gets 504 Gateway Timeout.
+// ============================================================================
+
+// httpTimeout is how long a request waits for its response
+var httpTimeout = 30 * time.Second
+
+// maxHTTPBody bounds the request body pushed to the stack
+const maxHTTPBody = 8 << 20
+
+// HTTPServer is a server started by ServeHTTP
+type HTTPServer struct {
+	srv       *http.Server
+	ln        net.Listener
+	requests  *Stack
+	responses *Stack
+	wrap      func(string) []byte // text to element
+	unwrap    func([]byte) string // element to text
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	next   atomic.Uint64
+
+	mu      sync.Mutex
+	waiting map[string]chan *Stack // request id -> its response, a Hash stack
+}
+
+// ServeHTTP listens on addr, host:port with either part optional, and
+// serves HTTP through the string stacks requests and responses until the
+// program ends or the server is closed.
+func ServeHTTP(addr string, requests, responses *Stack) (*HTTPServer, error) {
+	text := func(s string) []byte { return []byte(s) }
+	return serveHTTP(addr, requests, responses, text, func(b []byte) string { return string(b) })
+}
+
+// ServeHTTPValues is ServeHTTP for the interpreter.
+func ServeHTTPValues(addr string, requests, responses *ValueStack) (*HTTPServer, error) {
+	text := func(s string) []byte { return NewString(s).ToBytes() }
+	return serveHTTP(addr, requests.stack, responses.stack, text, func(b []byte) string { return ValueFromBytes(b).AsString() })
+}
+
+func serveHTTP(addr string, requests, responses *Stack, wrap func(string) []byte, unwrap func([]byte) string) (*HTTPServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	h := &HTTPServer{
+		ln:        ln,
+		requests:  requests,
+		responses: responses,
+		wrap:      wrap,
+		unwrap:    unwrap,
+		waiting:   make(map[string]chan *Stack),
+	}
+	h.ctx, h.cancel = context.WithCancel(Context())
+	h.srv = &http.Server{Handler: http.HandlerFunc(h.handle)}
+	go h.srv.Serve(ln)
+	go h.dispatch()
+	return h, nil
+}
+
+// Addr returns the address the server listens on, with the port chosen
+// if addr left it out or gave 0.
+func (h *HTTPServer) Addr() string {
+	return h.ln.Addr().String()
+}
+
+// Close stops the server. Requests waiting for a response get 503.
+func (h *HTTPServer) Close() error {
+	h.cancel()
+	return h.srv.Close()
+}
+
+// handle pushes a request and writes the response it is given
+func (h *HTTPServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPBody+1))
+	if err != nil {
+		http.Error(w, "cannot read the request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxHTTPBody {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id := strconv.FormatUint(h.next.Add(1), 10)
+	reply := make(chan *Stack, 1)
+	h.mu.Lock()
+	h.waiting[id] = reply
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.waiting, id)
+		h.mu.Unlock()
+	}()
+
+	req := NewStack(Hash, TypeString)
+	for _, f := range [][2]string{
+		{"id", id}, {"method", r.Method}, {"host", r.Host}, {"path", r.URL.Path}, {"query", r.URL.RawQuery},
+		{"remote", r.RemoteAddr}, {"body", string(body)},
+	} {
+		req.Push([]byte(f[1]), []byte(f[0]))
+	}
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		req.Push([]byte(strings.Join(r.Header[name], ", ")), []byte(name))
+	}
+	if err := h.requests.Push(h.wrap(req.ToJSON())); err != nil {
+		http.Error(w, "cannot queue the request: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	timer := time.NewTimer(httpTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-reply:
+		writeHTTPResponse(w, resp)
+	case <-timer.C:
+		http.Error(w, "no response", http.StatusGatewayTimeout)
+	case <-h.ctx.Done():
+		http.Error(w, "server stopped", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+// dispatch takes responses and hands each to the request it answers.
+// Responses that are not JSON objects, or answer no waiting request, are
+// dropped.
+func (h *HTTPServer) dispatch() {
+	for {
+		data, err := h.responses.take(h.ctx, 0, afterFunc)
+		if err != nil {
+			return // closed, frozen or shut down
+		}
+		resp := NewStack(Hash, TypeString)
+		if resp.fromJSON(h.unwrap(data)) != nil {
+			continue
+		}
+		id, _ := resp.Peek([]byte("id"))
+		h.mu.Lock()
+		reply, ok := h.waiting[string(id)]
+		delete(h.waiting, string(id))
+		h.mu.Unlock()
+		if ok {
+			reply <- resp
+		}
+	}
+}
+
+// writeHTTPResponse writes a response record
+func writeHTTPResponse(w http.ResponseWriter, resp *Stack) {
+	status := http.StatusOK
+	if v, err := resp.Peek([]byte("status")); err == nil {
+		n, err := strconv.Atoi(strings.TrimSpace(string(v)))
+		if err != nil || n < 100 || n > 999 {
+			http.Error(w, fmt.Sprintf("bad status %q", v), http.StatusInternalServerError)
+			return
+		}
+		status = n
+	}
+	var body []byte
+	for _, key := range resp.Keys() {
+		v, _ := resp.Peek([]byte(key))
+		switch key {
+		case "id", "status":
+		case "body":
+			body = v
+		default:
+			w.Header().Set(key, string(v))
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// answer takes one request from reqs and pushes the response resp gives
+// for it, a Hash string stack of the request's members
+func answer(t *testing.T, reqs, resps *Stack, resp func(req *Stack) map[string]string) {
+	data, err := reqs.Take(2000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	req := NewStack(Hash, TypeString)
+	if err := req.fromJSON(string(data)); err != nil {
+		t.Error(err)
+		return
+	}
+	out := NewStack(Hash, TypeString)
+	id, _ := req.GetRaw("id")
+	out.Push(id, []byte("id"))
+	for k, v := range resp(req) {
+		out.Push([]byte(v), []byte(k))
+	}
+	resps.Push([]byte(out.ToJSON()))
+}
+
+func TestServeHTTP(t *testing.T) {
+	reqs := NewStack(FIFO, TypeString)
+	resps := NewStack(FIFO, TypeString)
+	srv, err := ServeHTTP("127.0.0.1:0", reqs, resps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	go answer(t, reqs, resps, func(req *Stack) map[string]string {
+		get := func(k string) string { v, _ := req.GetRaw(k); return string(v) }
+		return map[string]string{
+			"status":       "201",
+			"body":         get("method") + " " + get("path") + "?" + get("query") + " " + get("X-Name") + " " + get("body"),
+			"Content-Type": "text/plain",
+		}
+	})
+	r, err := http.Post("http://"+srv.Addr()+"/hello?a=1", "text/plain", strings.NewReader("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(r.Body)
+	r.Body.Close()
+	if r.StatusCode != 201 || r.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("got status %d, Content-Type %q", r.StatusCode, r.Header.Get("Content-Type"))
+	}
+	if want := "POST /hello?a=1  hi"; string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+
+	// A status that is not a number is the handler's fault
+	go answer(t, reqs, resps, func(*Stack) map[string]string { return map[string]string{"status": "ok"} })
+	r, err = http.Get("http://" + srv.Addr() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 500 {
+		t.Errorf("bad status: got %d, want 500", r.StatusCode)
+	}
+}
+
+func TestServeHTTPTimeout(t *testing.T) {
+	saved := httpTimeout
+	httpTimeout = 50 * time.Millisecond
+	defer func() { httpTimeout = saved }()
+
+	reqs := NewValueStack(FIFO)
+	resps := NewValueStack(FIFO)
+	srv, err := ServeHTTPValues("127.0.0.1:0", reqs, resps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	r, err := http.Get("http://" + srv.Addr() + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 504 {
+		t.Errorf("got %d, want 504", r.StatusCode)
+	}
+	if v, _ := reqs.Pop(); !strings.Contains(v.AsString(), `"path":"/slow"`) {
+		t.Errorf("pushed %q", v.AsString())
+	}
+
+	if _, err := ServeHTTP(srv.Addr(), NewStack(FIFO, TypeString), NewStack(FIFO, TypeString)); err == nil {
+		t.Error("listened twice on one address")
+	}
+}
@@ -0,0 +1,118 @@
+package runtime
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Unique IDs
+//
+//   uuid4()     random RFC 4122 version 4 UUID, "xxxxxxxx-xxxx-4xxx-yxxx-..."
+//   ulid()      26-character ULID; sorts by creation time, and IDs made in
+//               the same millisecond by one program still sort in order
+//   seq(name)   1, 2, 3, ... per name for the life of the program
+// ============================================================================
+
+// UUID4 returns a random version 4 UUID in canonical form.
+func UUID4() string {
+	var u [16]byte
+	randomBytes(u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// crockford is the ULID base32 alphabet (no I, L, O or U)
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulidState struct {
+	mu sync.Mutex
+	ms uint64
+	hi uint16 // top 16 of the 80 random bits
+	lo uint64 // bottom 64
+}
+
+// ULID returns a new ULID: a 48-bit millisecond timestamp followed by 80
+// random bits. Within one millisecond the random part is incremented
+// instead of redrawn, so successive ULIDs from a program always increase.
+func ULID() string {
+	return ulidAt(uint64(time.Now().UnixMilli()))
+}
+
+func ulidAt(ms uint64) string {
+	s := &ulidState
+	s.mu.Lock()
+	if ms <= s.ms {
+		// Same (or an earlier, if the clock stepped back) millisecond
+		ms = s.ms
+		s.lo++
+		if s.lo == 0 {
+			s.hi++
+			if s.hi == 0 {
+				ms++ // random part exhausted: borrow the next millisecond
+			}
+		}
+	} else {
+		var r [10]byte
+		randomBytes(r[:])
+		s.hi = binary.BigEndian.Uint16(r[0:2])
+		s.lo = binary.BigEndian.Uint64(r[2:])
+	}
+	s.ms = ms
+	hi, lo := s.hi, s.lo
+	s.mu.Unlock()
+	return encodeULID(ms, hi, lo)
+}
+
+func encodeULID(ms uint64, hi uint16, lo uint64) string {
+	// 128 bits as two halves: ms(48) hi(16) | lo(64), written 5 bits at a
+	// time from the least significant end into 26 characters
+	top := ms<<16 | uint64(hi)
+	var b [26]byte
+	for i := 25; i >= 0; i-- {
+		b[i] = crockford[lo&0x1f]
+		lo = lo>>5 | top<<59
+		top >>= 5
+	}
+	return string(b[:])
+}
+
+var seqState struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// Seq returns the next value of the named counter, starting at 1.
+// Counters live for the life of the program and are safe to share
+// between spawned tasks.
+func Seq(name string) int64 {
+	s := &seqState
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = make(map[string]int64)
+	}
+	s.counters[name]++
+	return s.counters[name]
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("runtime: no source of randomness: " + err.Error())
+	}
+}
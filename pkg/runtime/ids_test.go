@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestUUID4(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		u := UUID4()
+		if !re.MatchString(u) {
+			t.Fatalf("bad UUID %q", u)
+		}
+		if seen[u] {
+			t.Fatalf("duplicate UUID %q", u)
+		}
+		seen[u] = true
+	}
+}
+
+func TestULID(t *testing.T) {
+	re := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	prev := ""
+	for i := 0; i < 1000; i++ {
+		u := ULID()
+		if !re.MatchString(u) {
+			t.Fatalf("bad ULID %q", u)
+		}
+		if u <= prev {
+			t.Fatalf("ULIDs not increasing: %q then %q", prev, u)
+		}
+		prev = u
+	}
+
+	// The timestamp is the first 10 characters
+	if got := encodeULID(1469918176385, 0, 0)[:10]; got != "01ARYZ6S41" {
+		t.Errorf("timestamp 1469918176385: got %q, want 01ARYZ6S41", got)
+	}
+}
+
+func TestSeq(t *testing.T) {
+	if Seq("test.a") != 1 || Seq("test.a") != 2 || Seq("test.b") != 1 {
+		t.Fatal("counters should start at 1 and be independent")
+	}
+
+	var wg sync.WaitGroup
+	seen := make([]bool, 1001)
+	var mu sync.Mutex
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				n := Seq("test.shared")
+				mu.Lock()
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for n := 1; n <= 1000; n++ {
+		if !seen[n] {
+			t.Fatalf("value %d missing from concurrent sequence", n)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Package deque holds the work-stealing deques behind the runtime's
+// parallel operations. It is internal: the types here may change in any
+// release, and programs reach them only through pkg/runtime.
+package deque
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ============================================================================
+// Traditional Work-Stealing (Chase-Lev style deque)
+// As taught in computer science - lock-free owner, locked steal
+// ============================================================================
+
+// Task represents a unit of work
+type Task struct {
+	ID   int64
+	Data []byte
+}
+
+// Deque is a work-stealing deque (traditional implementation)
+// Owner pushes/pops from bottom (LIFO), thieves steal from top (FIFO)
+type Deque struct {
+	tasks  []Task
+	bottom int64      // atomic - owner's end
+	top    int64      // atomic - thief's end
+	mu     sync.Mutex // for steal synchronization
+}
+
+// New creates a Deque holding up to capacity tasks
+func New(capacity int) *Deque {
+	return &Deque{
+		tasks:  make([]Task, capacity),
+		bottom: 0,
+		top:    0,
+	}
+}
+
+// Push adds a task (owner only, LIFO end)
+func (d *Deque) Push(t Task) bool {
+	b := atomic.LoadInt64(&d.bottom)
+	top := atomic.LoadInt64(&d.top)
+
+	if b-top >= int64(len(d.tasks)) {
+		return false // full
+	}
+
+	d.tasks[b%int64(len(d.tasks))] = t
+	atomic.StoreInt64(&d.bottom, b+1)
+	return true
+}
+
+// Pop removes a task (owner only, LIFO end)
+func (d *Deque) Pop() (Task, bool) {
+	b := atomic.LoadInt64(&d.bottom) - 1
+	atomic.StoreInt64(&d.bottom, b)
+
+	top := atomic.LoadInt64(&d.top)
+
+	if top <= b {
+		// Non-empty
+		t := d.tasks[b%int64(len(d.tasks))]
+		if top == b {
+			// Last element - race with steal
+			if !atomic.CompareAndSwapInt64(&d.top, top, top+1) {
+				// Lost race
+				atomic.StoreInt64(&d.bottom, b+1)
+				return Task{}, false
+			}
+			atomic.StoreInt64(&d.bottom, b+1)
+		}
+		return t, true
+	}
+
+	// Empty
+	atomic.StoreInt64(&d.bottom, top)
+	return Task{}, false
+}
+
+// Steal takes a task (thief, FIFO end)
+func (d *Deque) Steal() (Task, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	top := atomic.LoadInt64(&d.top)
+	b := atomic.LoadInt64(&d.bottom)
+
+	if top >= b {
+		return Task{}, false // empty
+	}
+
+	t := d.tasks[top%int64(len(d.tasks))]
+	if !atomic.CompareAndSwapInt64(&d.top, top, top+1) {
+		return Task{}, false // lost race
+	}
+
+	return t, true
+}
+
+// Len returns approximate size
+func (d *Deque) Len() int {
+	b := atomic.LoadInt64(&d.bottom)
+	t := atomic.LoadInt64(&d.top)
+	size := b - t
+	if size < 0 {
+		return 0
+	}
+	return int(size)
+}
@@ -0,0 +1,43 @@
+package deque
+
+import "testing"
+
+func TestDeque(t *testing.T) {
+	d := New(100)
+
+	// Owner pushes
+	d.Push(Task{ID: 1})
+	d.Push(Task{ID: 2})
+	d.Push(Task{ID: 3})
+	d.Push(Task{ID: 4})
+	d.Push(Task{ID: 5})
+
+	// Owner pops (LIFO - gets 5)
+	task, ok := d.Pop()
+	if !ok || task.ID != 5 {
+		t.Errorf("owner expected 5, got %d", task.ID)
+	}
+
+	// Thief steals (FIFO - gets 1)
+	task, ok = d.Steal()
+	if !ok || task.ID != 1 {
+		t.Errorf("thief expected 1, got %d", task.ID)
+	}
+
+	// Owner pops (gets 4)
+	task, ok = d.Pop()
+	if !ok || task.ID != 4 {
+		t.Errorf("owner expected 4, got %d", task.ID)
+	}
+
+	// Thief steals (gets 2)
+	task, ok = d.Steal()
+	if !ok || task.ID != 2 {
+		t.Errorf("thief expected 2, got %d", task.ID)
+	}
+
+	// Only 3 remains
+	if d.Len() != 1 {
+		t.Errorf("expected 1 remaining, got %d", d.Len())
+	}
+}
@@ -1,4 +1,4 @@
-package runtime
+package deque
 
 import (
 	"sync/atomic"
@@ -6,7 +6,7 @@ import (
 
 // FastInt64Stack is a lock-free stack optimised for single-producer patterns.
 // Uses atomic operations for the hot path, falling back to CAS for contention.
-// LIFO only - use Int64Stack for FIFO.
+// LIFO only - use runtime.Int64Stack for FIFO.
 type FastInt64Stack struct {
 	elements []int64
 	top      int64 // atomic: index of next push slot
@@ -76,18 +76,18 @@ func (s *FastInt64Stack) Steal() (int64, bool) {
 	return s.Pop()
 }
 
-// WorkStealingDeque is a proper Chase-Lev work-stealing deque.
+// Int64Deque is a proper Chase-Lev work-stealing deque.
 // Owner pushes/pops from top (LIFO), thieves steal from bottom (FIFO).
-type WorkStealingDeque struct {
+type Int64Deque struct {
 	elements []int64
 	top      int64 // atomic: owner's end (LIFO)
 	bottom   int64 // atomic: thieves' end (FIFO)
 	capacity int64
 }
 
-// NewWorkStealingDeque creates a fixed-capacity work-stealing deque.
-func NewWorkStealingDeque(capacity int) *WorkStealingDeque {
-	return &WorkStealingDeque{
+// NewInt64Deque creates a fixed-capacity Int64Deque.
+func NewInt64Deque(capacity int) *Int64Deque {
+	return &Int64Deque{
 		elements: make([]int64, capacity),
 		top:      0,
 		bottom:   0,
@@ -96,32 +96,32 @@ func NewWorkStealingDeque(capacity int) *WorkStealingDeque {
 }
 
 // Push adds a value at the top (owner only). O(1).
-func (d *WorkStealingDeque) Push(value int64) bool {
+func (d *Int64Deque) Push(value int64) bool {
 	top := atomic.LoadInt64(&d.top)
 	bottom := atomic.LoadInt64(&d.bottom)
-	
+
 	if top-bottom >= d.capacity {
 		return false // full
 	}
-	
+
 	d.elements[top%d.capacity] = value
 	atomic.AddInt64(&d.top, 1)
 	return true
 }
 
 // Pop removes from top (owner only). O(1).
-func (d *WorkStealingDeque) Pop() (int64, bool) {
+func (d *Int64Deque) Pop() (int64, bool) {
 	top := atomic.AddInt64(&d.top, -1)
 	bottom := atomic.LoadInt64(&d.bottom)
-	
+
 	if top < bottom {
 		// Empty, restore top
 		atomic.StoreInt64(&d.top, bottom)
 		return 0, false
 	}
-	
+
 	value := d.elements[top%d.capacity]
-	
+
 	if top == bottom {
 		// Last element - race with thieves
 		if !atomic.CompareAndSwapInt64(&d.bottom, bottom, bottom+1) {
@@ -131,31 +131,31 @@ func (d *WorkStealingDeque) Pop() (int64, bool) {
 		}
 		atomic.StoreInt64(&d.top, bottom+1)
 	}
-	
+
 	return value, true
 }
 
 // Steal removes from bottom (thieves). O(1).
-func (d *WorkStealingDeque) Steal() (int64, bool) {
+func (d *Int64Deque) Steal() (int64, bool) {
 	bottom := atomic.LoadInt64(&d.bottom)
 	top := atomic.LoadInt64(&d.top)
-	
+
 	if bottom >= top {
 		return 0, false // empty
 	}
-	
+
 	value := d.elements[bottom%d.capacity]
-	
+
 	if !atomic.CompareAndSwapInt64(&d.bottom, bottom, bottom+1) {
 		// Another thief got it
 		return 0, false
 	}
-	
+
 	return value, true
 }
 
 // Len returns approximate size.
-func (d *WorkStealingDeque) Len() int {
+func (d *Int64Deque) Len() int {
 	top := atomic.LoadInt64(&d.top)
 	bottom := atomic.LoadInt64(&d.bottom)
 	size := top - bottom
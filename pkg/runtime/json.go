@@ -0,0 +1,267 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// JSON
+//
+//   @cfg from_json(text)   a JSON object into a Hash stack, key by key, or
+//                          a JSON array into any other stack, in order
+//   @cfg: to_json()        the stack as JSON text: a Hash stack as an
+//                          object, any other as an array in push order
+//
+// Values are converted to the stack's element type. A string stack takes
+// anything: strings as they are, numbers and booleans as written, and
+// nested objects and arrays as their JSON text. A number stack takes
+// numbers (an integer stack only whole ones) and a bool stack booleans.
+// null values are skipped. rual writes JSON the same way, byte for byte.
+// ============================================================================
+
+// FromJSON parses text and stores it in s: the members of an object, in
+// order, if s is a Hash stack, or the elements of an array otherwise. If
+// the text is not JSON of that shape, or a value does not fit the stack's
+// type, nothing is stored and the error is pushed to errStack if it is not
+// nil.
+func (s *Stack) FromJSON(text string, errStack *Stack) {
+	if err := s.fromJSON(text); err != nil {
+		if errStack != nil {
+			errStack.Push([]byte(err.Error()))
+		}
+	}
+}
+
+func (s *Stack) fromJSON(text string) error {
+	s.mu.RLock()
+	hash, t := s.perspective == Hash, s.elementType
+	s.mu.RUnlock()
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	open, end, shape := json.Delim('['), json.Delim(']'), "an array"
+	if hash {
+		open, end, shape = '{', '}', "an object"
+	}
+	if tok, err := dec.Token(); err != nil || tok != open {
+		return fmt.Errorf("from_json: expected %s", shape)
+	}
+
+	type member struct{ key, value []byte }
+	var members []member
+	for n := 0; dec.More(); n++ {
+		var key []byte
+		if hash {
+			tok, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("from_json: %v", err)
+			}
+			key = []byte(tok.(string))
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("from_json: %v", err)
+		}
+		value, ok, err := jsonElement(raw, t)
+		if err != nil {
+			if hash {
+				return fmt.Errorf("from_json: %q: %v", key, err)
+			}
+			return fmt.Errorf("from_json: element %d: %v", n, err)
+		}
+		if ok {
+			members = append(members, member{key, value})
+		}
+	}
+	if tok, err := dec.Token(); err != nil || tok != end {
+		return fmt.Errorf("from_json: expected %s", shape)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("from_json: unexpected text after the JSON value")
+	}
+
+	for _, m := range members {
+		var err error
+		if hash {
+			err = s.Push(m.value, m.key)
+		} else {
+			err = s.Push(m.value)
+		}
+		if err != nil {
+			return fmt.Errorf("from_json: %v", err)
+		}
+	}
+	return nil
+}
+
+// jsonElement converts a JSON value to an element of type t. ok is false
+// for null, which is skipped.
+func jsonElement(raw json.RawMessage, t ElementType) (data []byte, ok bool, err error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		return nil, false, nil
+	}
+
+	switch wideType(t) {
+	case TypeString, TypeBytes:
+		switch v := v.(type) {
+		case string:
+			return []byte(v), true, nil
+		case map[string]any, []any:
+			var b bytes.Buffer
+			json.Compact(&b, raw)
+			return b.Bytes(), true, nil
+		}
+		return bytes.TrimSpace(raw), true, nil
+	case TypeInt64, TypeUint64:
+		if n, isNum := v.(json.Number); isNum {
+			if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+				return intToBytes(i), true, nil
+			}
+			if u, err := strconv.ParseUint(string(n), 10, 64); err == nil && wideType(t) == TypeUint64 {
+				return intToBytes(int64(u)), true, nil
+			}
+			return nil, false, fmt.Errorf("%s is not an integer", n)
+		}
+		return nil, false, fmt.Errorf("expected a number, got %s", jsonKind(v))
+	case TypeFloat64:
+		if n, isNum := v.(json.Number); isNum {
+			f, err := strconv.ParseFloat(string(n), 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s is out of range", n)
+			}
+			return float64ToBytes(f), true, nil
+		}
+		return nil, false, fmt.Errorf("expected a number, got %s", jsonKind(v))
+	case TypeBool:
+		if b, isBool := v.(bool); isBool {
+			if b {
+				return []byte{1}, true, nil
+			}
+			return []byte{0}, true, nil
+		}
+		return nil, false, fmt.Errorf("expected a boolean, got %s", jsonKind(v))
+	}
+	return nil, false, errors.New("unsupported element type")
+}
+
+// jsonKind names the kind of a decoded JSON value, for errors
+func jsonKind(v any) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "an array"
+	}
+	return "an object"
+}
+
+// ToJSON returns s as compact JSON text: an object of its keys, in the
+// order they were first set, for a Hash stack, or an array of its
+// elements in push order otherwise. Strings and bytes are written as JSON
+// strings, with bytes that are not UTF-8 replaced, and floats that are not
+// finite as null.
+func (s *Stack) ToJSON() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash := s.perspective == Hash
+	b := []byte{'['}
+	if hash {
+		b[0] = '{'
+	}
+	first := true
+	for i := s.head; i < len(s.elements); i++ {
+		if hash && s.keys[i] == nil {
+			continue // deleted
+		}
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		if hash {
+			b = appendJSONString(b, s.keys[i])
+			b = append(b, ':')
+		}
+		b = appendJSONElement(b, s.unpack(s.elements[i].data), s.elementType)
+	}
+	if hash {
+		return string(append(b, '}'))
+	}
+	return string(append(b, ']'))
+}
+
+// appendJSONElement appends an element of type t as a JSON value
+func appendJSONElement(b, data []byte, t ElementType) []byte {
+	switch wideType(t) {
+	case TypeString, TypeBytes:
+		return appendJSONString(b, data)
+	case TypeFloat64:
+		if f := bytesToFloat64(data); math.IsNaN(f) || math.IsInf(f, 0) {
+			return append(b, "null"...)
+		}
+	}
+	return append(b, formatElement(data, t)...)
+}
+
+// appendJSONString appends s as a JSON string. Only '"', '\' and control
+// characters are escaped, so rual can write the same bytes.
+func appendJSONString(b, s []byte) []byte {
+	b = append(b, '"')
+	for len(s) > 0 {
+		r, size := utf8.DecodeRune(s)
+		switch {
+		case r == '"' || r == '\\':
+			b = append(b, '\\', byte(r))
+		case r == '\n':
+			b = append(b, `\n`...)
+		case r == '\r':
+			b = append(b, `\r`...)
+		case r == '\t':
+			b = append(b, `\t`...)
+		case r < 0x20:
+			b = fmt.Appendf(b, `\u%04x`, r)
+		default:
+			// RuneError for a bad byte writes U+FFFD
+			b = utf8.AppendRune(b, r)
+		}
+		s = s[size:]
+	}
+	return append(b, '"')
+}
+
+// FromJSON is Stack.FromJSON for the interpreter: the values are converted
+// to type t, the element type of the stack in the program, and the error
+// is returned.
+func (vs *ValueStack) FromJSON(text string, t ElementType) error {
+	typed := NewStack(vs.Perspective(), t)
+	if err := typed.fromJSON(text); err != nil {
+		return err
+	}
+	if err := vs.PushTyped(typed); err != nil {
+		return fmt.Errorf("from_json: %v", err)
+	}
+	return nil
+}
+
+// ToJSON is Stack.ToJSON for the interpreter, writing the values as
+// elements of type t.
+func (vs *ValueStack) ToJSON(t ElementType) string {
+	return vs.Typed(t).ToJSON()
+}
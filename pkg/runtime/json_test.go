@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFromJSONHash(t *testing.T) {
+	cfg := NewStack(Hash, TypeString)
+	errs := NewStack(LIFO, TypeString)
+	cfg.FromJSON(`{"name": "ual", "port": 8080, "debug": true, "tags": [1, 2], "none": null}`, errs)
+	if errs.Len() != 0 {
+		e, _ := errs.Pop()
+		t.Fatalf("from_json failed: %s", e)
+	}
+	if got, want := cfg.ToJSON(), `{"name":"ual","port":"8080","debug":"true","tags":"[1,2]"}`; got != want {
+		t.Errorf("to_json = %s, want %s", got, want)
+	}
+
+	// A key set again keeps its place
+	cfg.FromJSON(`{"debug": "no", "extra": "x\ty"}`, errs)
+	if got, want := cfg.ToJSON(), `{"name":"ual","port":"8080","debug":"no","tags":"[1,2]","extra":"x\ty"}`; got != want {
+		t.Errorf("after a second from_json to_json = %s, want %s", got, want)
+	}
+	cfg.Pop([]byte("port"))
+	if got, want := cfg.ToJSON(), `{"name":"ual","debug":"no","tags":"[1,2]","extra":"x\ty"}`; got != want {
+		t.Errorf("after a pop to_json = %s, want %s", got, want)
+	}
+}
+
+func TestFromJSONArray(t *testing.T) {
+	errs := NewStack(LIFO, TypeString)
+
+	nums := NewStack(Indexed, TypeInt64)
+	nums.FromJSON(`[3, -1, 40]`, errs)
+	if got, want := nums.ToJSON(), `[3,-1,40]`; got != want {
+		t.Errorf("int to_json = %s, want %s", got, want)
+	}
+
+	floats := NewStack(FIFO, TypeFloat32)
+	floats.FromJSON(`[1.5, 2, -0.25]`, errs)
+	if got, want := floats.ToJSON(), `[1.5,2,-0.25]`; got != want {
+		t.Errorf("f32 to_json = %s, want %s", got, want)
+	}
+
+	flags := NewStack(LIFO, TypeBool)
+	flags.FromJSON(`[true, false]`, errs)
+	if got, want := flags.ToJSON(), `[true,false]`; got != want {
+		t.Errorf("bool to_json = %s, want %s", got, want)
+	}
+
+	big := NewStack(LIFO, TypeUint64)
+	big.FromJSON(`[18446744073709551615]`, errs)
+	if got, want := big.ToJSON(), `[18446744073709551615]`; got != want {
+		t.Errorf("u64 to_json = %s, want %s", got, want)
+	}
+	if errs.Len() != 0 {
+		e, _ := errs.Pop()
+		t.Fatalf("from_json failed: %s", e)
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	cases := []struct {
+		perspective Perspective
+		t           ElementType
+		text        string
+		want        string
+	}{
+		{Hash, TypeString, `[1]`, "expected an object"},
+		{LIFO, TypeInt64, `{"a": 1}`, "expected an array"},
+		{LIFO, TypeInt64, `[1, 2.5]`, "element 1: 2.5 is not an integer"},
+		{LIFO, TypeInt64, `[18446744073709551615]`, "is not an integer"},
+		{Hash, TypeFloat64, `{"x": "1"}`, `"x": expected a number, got a string`},
+		{LIFO, TypeBool, `[1]`, "expected a boolean, got a number"},
+		{LIFO, TypeString, `["a"] x`, "unexpected text"},
+		{LIFO, TypeString, `["a"`, "from_json"},
+	}
+	for _, c := range cases {
+		s := NewStack(c.perspective, c.t)
+		errs := NewStack(LIFO, TypeString)
+		s.FromJSON(c.text, errs)
+		e, err := errs.Pop()
+		if err != nil {
+			t.Errorf("from_json(%s): no error", c.text)
+			continue
+		}
+		if !strings.Contains(string(e), c.want) {
+			t.Errorf("from_json(%s): error %q, want one with %q", c.text, e, c.want)
+		}
+		if s.Len() != 0 {
+			t.Errorf("from_json(%s): stored %d elements after an error", c.text, s.Len())
+		}
+	}
+}
+
+func TestToJSONStrings(t *testing.T) {
+	s := NewStack(LIFO, TypeBytes)
+	s.Push([]byte("a\"b\\c\n\x01é"))
+	s.Push([]byte{'x', 0xff})
+	if got, want := s.ToJSON(), `["a\"b\\c\n\u0001é","x`+"�"+`"]`; got != want {
+		t.Errorf("to_json = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package runtime
+
+import "errors"
+
+// ============================================================================
+// Stack doubles
+//
+//   mock(@net, ["200 OK", "404 Not Found"], @sent)
+//
+// A mocked stack stands in for an endpoint the program talks to: what the
+// program reads from it comes from a script, and what it pushes to it is
+// recorded on another stack for the test to check, or dropped.
+// ============================================================================
+
+// Mock turns s into a test double. Its contents are replaced by script,
+// which pop, peek and take return in order whatever s's perspective, and
+// from then on values pushed to s go to sent instead, or are dropped if
+// sent is nil. Hash and Broadcast stacks cannot be mocked, and sent must
+// be a positional stack of the same element type that is not itself
+// mocked. Mocking s again replaces the script.
+func (s *Stack) Mock(sent *Stack, script ...[]byte) error {
+	if s.perspective == Hash || s.perspective == Broadcast {
+		return errors.New("mock requires a LIFO, FIFO or Indexed stack")
+	}
+	if sent != nil {
+		if sent == s {
+			return errors.New("mock cannot record pushes on the mocked stack")
+		}
+		if sent.perspective == Hash || sent.perspective == Broadcast {
+			return errors.New("mock records pushes on a LIFO, FIFO or Indexed stack")
+		}
+		if sent.elementType != s.elementType {
+			return errors.New("mock records pushes on a stack of the same element type")
+		}
+		sent.mu.RLock()
+		mocked := sent.mocked
+		sent.mu.RUnlock()
+		if mocked {
+			return errors.New("mock cannot record pushes on a mocked stack")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frozen != 0 {
+		return ErrFrozen
+	}
+	s.elements = make([]Element, 0, len(script))
+	s.keys = make([][]byte, 0, len(script))
+	s.head = 0
+	for i := range script {
+		// LIFO and Indexed stacks read from the top: load the script
+		// reversed so it still comes out in order
+		b := script[i]
+		if s.perspective != FIFO {
+			b = script[len(script)-1-i]
+		}
+		s.elements = append(s.elements, Element{data: s.pack(b)})
+		s.keys = append(s.keys, nil)
+	}
+	s.version++
+	s.mocked = true
+	s.sent = sent
+	s.cond.Broadcast()
+	return nil
+}
+
+// IsMocked reports whether s is a test double
+func (s *Stack) IsMocked() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mocked
+}
+
+// pushSent records a push to a mocked stack. Caller holds s.mu.
+func (s *Stack) pushSent(value []byte) error {
+	if s.sent == nil {
+		return nil
+	}
+	return s.sent.Push(value)
+}
+
+// Mock is Stack.Mock for the interpreter's stacks of Values
+func (vs *ValueStack) Mock(sent *ValueStack, script []Value) error {
+	data := make([][]byte, len(script))
+	for i, v := range script {
+		data[i] = v.ToBytes()
+	}
+	if sent == nil {
+		return vs.stack.Mock(nil, data...)
+	}
+	return vs.stack.Mock(sent.stack, data...)
+}
@@ -0,0 +1,54 @@
+package runtime
+
+import "testing"
+
+func TestMockScript(t *testing.T) {
+	for _, p := range []Perspective{LIFO, FIFO, Indexed} {
+		s := NewStack(p, TypeString)
+		s.Push([]byte("stale"))
+		sent := NewStack(FIFO, TypeString)
+		if err := s.Mock(sent, []byte("a"), []byte("b")); err != nil {
+			t.Fatal(err)
+		}
+
+		s.Push([]byte("req1"))
+		if b, _ := s.Pop(); string(b) != "a" {
+			t.Errorf("perspective %v: first read %q, want a", p, b)
+		}
+		s.Push([]byte("req2"))
+		if b, _ := s.Take(); string(b) != "b" {
+			t.Errorf("perspective %v: second read %q, want b", p, b)
+		}
+		if s.Len() != 0 {
+			t.Errorf("perspective %v: %d elements left", p, s.Len())
+		}
+		if !ExpectStack(0, "sent", sent, [][]byte{[]byte("req1"), []byte("req2")}) {
+			t.Errorf("perspective %v: pushes not recorded", p)
+		}
+	}
+}
+
+func TestMockDropsPushes(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	if err := s.Mock(nil); err != nil {
+		t.Fatal(err)
+	}
+	s.Push(intToBytes(1))
+	if s.Len() != 0 || !s.IsMocked() {
+		t.Errorf("push to a mock without a sent stack was kept")
+	}
+}
+
+func TestMockErrors(t *testing.T) {
+	h := NewStack(Hash, TypeInt64)
+	if err := h.Mock(nil); err == nil {
+		t.Error("mocked a Hash stack")
+	}
+	s := NewStack(LIFO, TypeInt64)
+	if err := s.Mock(s); err == nil {
+		t.Error("recorded pushes on the mocked stack")
+	}
+	if err := s.Mock(NewStack(LIFO, TypeString)); err == nil {
+		t.Error("recorded pushes on a stack of another type")
+	}
+}
@@ -0,0 +1,149 @@
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts one MQTT 5 client, acknowledges its CONNECT and
+// SUBSCRIBE, and passes the packets that follow to the test
+type fakeBroker struct {
+	ln      net.Listener
+	conn    net.Conn
+	packets chan []byte // first byte, then body
+	connect []byte
+	refuse  byte // CONNACK reason code
+}
+
+func newFakeBroker(t *testing.T, refuse byte) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb := &fakeBroker{ln: ln, packets: make(chan []byte, 16), refuse: refuse}
+	ready := make(chan struct{})
+	go func() {
+		defer close(fb.packets)
+		conn, err := ln.Accept()
+		if err != nil {
+			close(ready)
+			return
+		}
+		fb.conn = conn
+		r := bufio.NewReader(conn)
+		_, fb.connect, _ = readMQTTPacket(r)
+		conn.Write(mqttPacket(mqttConnAck, []byte{0, fb.refuse, 0}))
+		if fb.refuse == 0 {
+			typ, sub, _ := readMQTTPacket(r)
+			fb.packets <- append([]byte{typ}, sub...)
+			conn.Write(mqttPacket(mqttSubAck, []byte{sub[0], sub[1], 0, 0}))
+		}
+		close(ready)
+		for {
+			typ, body, err := readMQTTPacket(r)
+			if err != nil {
+				return
+			}
+			fb.packets <- append([]byte{typ}, body...)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		<-ready
+		if fb.conn != nil {
+			fb.conn.Close()
+		}
+	})
+	return fb
+}
+
+func (fb *fakeBroker) next(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case p := <-fb.packets:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet from the client")
+		return nil
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	fb := newFakeBroker(t, 0)
+	s := NewStack(FIFO, TypeInt64)
+	if err := s.Bridge("mqtt://ann:secret@" + fb.ln.Addr().String() + "/plant/temp?client=pump-7"); err != nil {
+		t.Fatal(err)
+	}
+
+	// CONNECT: MQTT 5, clean start, user name and password
+	c := fb.connect
+	if !bytes.HasPrefix(c, []byte("\x00\x04MQTT\x05\xC2")) || !bytes.Contains(c, []byte("\x00\x06pump-7\x00\x03ann\x00\x06secret")) {
+		t.Errorf("CONNECT %q", c)
+	}
+	// SUBSCRIBE to the topic with No Local
+	if sub := fb.next(t); sub[0] != mqttSubscribe || !bytes.HasSuffix(sub, []byte("\x00\x0Aplant/temp\x04")) {
+		t.Errorf("SUBSCRIBE %q", sub)
+	}
+
+	s.Push(intToBytes(21))
+	if pub := fb.next(t); pub[0] != mqttPublish || string(pub[1:]) != "\x00\x0Aplant/temp\x0021" {
+		t.Errorf("PUBLISH %q", pub)
+	}
+
+	// A message from the broker, with a property, arrives on the stack
+	msg := append(appendMQTTString(nil, "plant/temp"), 2, 0x01, 0x01)
+	fb.conn.Write(mqttPacket(mqttPublish, append(msg, "19"...)))
+	got, err := s.Take(5000)
+	if err != nil || bytesToInt(got) != 21 {
+		t.Fatalf("took %v, %v", got, err)
+	}
+	if got, err = s.Take(5000); err != nil || bytesToInt(got) != 19 {
+		t.Fatalf("took %v, %v", got, err)
+	}
+
+	// Closing sends what is queued, then DISCONNECT
+	s.mu.Lock()
+	b := s.bridge.b
+	s.mu.Unlock()
+	s.Push(intToBytes(7))
+	b.Close()
+	if pub := fb.next(t); !strings.HasSuffix(string(pub), "\x007") {
+		t.Errorf("PUBLISH %q", pub)
+	}
+	if p := fb.next(t); p[0] != mqttDisconnect {
+		t.Errorf("got %q, want DISCONNECT", p)
+	}
+}
+
+func TestMQTTRefused(t *testing.T) {
+	fb := newFakeBroker(t, 0x86)
+	err := NewStack(LIFO, TypeString).Bridge("mqtt://" + fb.ln.Addr().String() + "/t")
+	if err == nil || err.Error() != "bridge: mqtt: connection refused: bad user name or password" {
+		t.Errorf("error %v", err)
+	}
+}
+
+func TestMQTTPacket(t *testing.T) {
+	body := bytes.Repeat([]byte{'x'}, 321)
+	p := mqttPacket(mqttPublish, body)
+	if !bytes.Equal(p[:3], []byte{0x30, 0xC1, 0x02}) {
+		t.Errorf("header % x", p[:3])
+	}
+	typ, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil || typ != mqttPublish || !bytes.Equal(got, body) {
+		t.Errorf("read %x, %d bytes, %v", typ, len(got), err)
+	}
+
+	// QoS 1: a packet id follows the topic
+	pub := append(appendMQTTString(nil, "t"), 0, 9, 0)
+	if payload, ok := mqttPayload(mqttPublish|0x02, append(pub, "hi"...)); !ok || string(payload) != "hi" {
+		t.Errorf("payload %q, %v", payload, ok)
+	}
+	if _, ok := mqttPayload(mqttPublish, []byte{0, 9, 't'}); ok {
+		t.Error("read a truncated PUBLISH")
+	}
+}
@@ -0,0 +1,293 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ============================================================================
+// ualpack
+//
+//   @blob pack(@s)     pushes the encoding of @s to the bytes stack @blob
+//   @s unpack(@blob)   pops an encoding from @blob and pushes its elements
+//
+// A stack's element type, perspective and elements, in push order, as
+// bytes that a Go or Rust program can read back. The format is specified
+// in docs/UALPACK_SPEC.md; rual implements the same one.
+// ============================================================================
+
+// PackMagic starts every ualpack encoding
+const PackMagic = "UALP"
+
+// PackVersion is the version of the format EncodeStack writes
+const PackVersion = 1
+
+// EncodeStack returns the ualpack encoding of s. A Broadcast stack holds
+// no elements to encode and is an error.
+func EncodeStack(s *Stack) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.perspective == Broadcast {
+		return nil, errors.New("pack: a Broadcast stack holds no elements")
+	}
+	hash := s.perspective == Hash
+	count := 0
+	for i := s.head; i < len(s.elements); i++ {
+		if !hash || s.keys[i] != nil {
+			count++
+		}
+	}
+
+	b := append([]byte(PackMagic), PackVersion, byte(s.elementType), byte(s.perspective), 0)
+	b = binary.AppendUvarint(b, uint64(count))
+	for i := s.head; i < len(s.elements); i++ {
+		if hash {
+			if s.keys[i] == nil {
+				continue // deleted
+			}
+			b = binary.AppendUvarint(b, uint64(len(s.keys[i])))
+			b = append(b, s.keys[i]...)
+		}
+		b = appendPackElement(b, s.unpack(s.elements[i].data), s.elementType)
+	}
+	return b, nil
+}
+
+// appendPackElement appends an element of type t, given in the encoding of
+// its wide type
+func appendPackElement(b, data []byte, t ElementType) []byte {
+	switch t {
+	case TypeInt64, TypeUint64, TypeFloat64:
+		return append(b, data...)
+	case TypeInt32, TypeUint32:
+		return binary.BigEndian.AppendUint32(b, uint32(bytesToInt(data)))
+	case TypeFloat32:
+		return binary.BigEndian.AppendUint32(b, math.Float32bits(float32(bytesToFloat64(data))))
+	case TypeBool:
+		if len(data) > 0 && data[0] != 0 {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	}
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// DecodeStack returns a new stack holding what data, a ualpack encoding,
+// describes.
+func DecodeStack(data []byte) (*Stack, error) {
+	t, p, elems, err := decodePack(data)
+	if err != nil {
+		return nil, err
+	}
+	s := NewStack(p, t)
+	for _, e := range elems {
+		if err := s.Push(e.value, e.key...); err != nil {
+			return nil, fmt.Errorf("unpack: %v", err)
+		}
+	}
+	return s, nil
+}
+
+type packElement struct {
+	key   [][]byte // one key on a Hash stack, none otherwise
+	value []byte   // in the encoding of the wide type
+}
+
+// decodePack checks and splits a ualpack encoding
+func decodePack(data []byte) (ElementType, Perspective, []packElement, error) {
+	fail := func(format string, args ...any) (ElementType, Perspective, []packElement, error) {
+		return 0, 0, nil, fmt.Errorf("unpack: "+format, args...)
+	}
+	if len(data) < 8 || !bytes.Equal(data[:4], []byte(PackMagic)) {
+		return fail("not a ualpack encoding")
+	}
+	if data[4] != PackVersion {
+		return fail("version %d is not supported", data[4])
+	}
+	t, p := ElementType(data[5]), Perspective(data[6])
+	if t > TypeFloat32 {
+		return fail("unknown element type %d", data[5])
+	}
+	if p > Hash {
+		return fail("unknown perspective %d", data[6])
+	}
+	if data[7] != 0 {
+		return fail("unknown flags %#x", data[7])
+	}
+	r := packReader{data: data[8:]}
+	count, ok := r.uvarint()
+	if !ok {
+		return fail("truncated")
+	}
+
+	var elems []packElement
+	keys := map[string]bool{}
+	for n := uint64(0); n < count; n++ {
+		var e packElement
+		if p == Hash {
+			key, ok := r.lenBytes()
+			if !ok {
+				return fail("truncated")
+			}
+			if keys[string(key)] {
+				return fail("key %q twice", key)
+			}
+			keys[string(key)] = true
+			e.key = [][]byte{key}
+		}
+		if e.value, ok = r.element(t); !ok {
+			return fail("truncated")
+		}
+		elems = append(elems, e)
+	}
+	if len(r.data) != 0 {
+		return fail("%d bytes after the last element", len(r.data))
+	}
+	return t, p, elems, nil
+}
+
+// packReader reads the parts of a ualpack encoding
+type packReader struct {
+	data []byte
+}
+
+func (r *packReader) next(n uint64) ([]byte, bool) {
+	if uint64(len(r.data)) < n {
+		return nil, false
+	}
+	b := bytes.Clone(r.data[:n]) // the stack keeps it
+	r.data = r.data[n:]
+	return b, true
+}
+
+func (r *packReader) uvarint() (uint64, bool) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, false
+	}
+	r.data = r.data[n:]
+	return v, true
+}
+
+func (r *packReader) lenBytes() ([]byte, bool) {
+	n, ok := r.uvarint()
+	if !ok {
+		return nil, false
+	}
+	return r.next(n)
+}
+
+// element reads an element of type t, returning it in the encoding of the
+// wide type
+func (r *packReader) element(t ElementType) ([]byte, bool) {
+	switch t {
+	case TypeInt64, TypeUint64, TypeFloat64:
+		return r.next(8)
+	case TypeInt32, TypeUint32, TypeFloat32:
+		b, ok := r.next(4)
+		if !ok {
+			return nil, false
+		}
+		u := binary.BigEndian.Uint32(b)
+		switch t {
+		case TypeInt32:
+			return intToBytes(int64(int32(u))), true
+		case TypeUint32:
+			return intToBytes(int64(u)), true
+		}
+		return float64ToBytes(float64(math.Float32frombits(u))), true
+	case TypeBool:
+		b, ok := r.next(1)
+		if !ok || b[0] > 1 {
+			return nil, false
+		}
+		return b, true
+	}
+	return r.lenBytes()
+}
+
+// PackFrom pushes the ualpack encoding of src to s, a bytes stack. The
+// error, if any, is pushed to errStack if it is not nil.
+func (s *Stack) PackFrom(src *Stack, errStack *Stack) {
+	data, err := EncodeStack(src)
+	if err == nil {
+		err = s.Push(data)
+	}
+	if err != nil && errStack != nil {
+		errStack.Push([]byte(err.Error()))
+	}
+}
+
+// UnpackFrom pops a ualpack encoding from src and pushes its elements to
+// s, which must have the same element type and be a Hash stack if and only
+// if the encoded one was. Keys already set on s are set again. If the
+// encoding does not fit, nothing is pushed and the error is pushed to
+// errStack if it is not nil.
+func (s *Stack) UnpackFrom(src *Stack, errStack *Stack) {
+	if err := s.unpackFrom(src); err != nil && errStack != nil {
+		errStack.Push([]byte(err.Error()))
+	}
+}
+
+func (s *Stack) unpackFrom(src *Stack) error {
+	data, err := src.Pop()
+	if err != nil {
+		return fmt.Errorf("unpack: %v", err)
+	}
+	return s.unpackData(data)
+}
+
+// unpackData pushes the elements of the ualpack encoding data to s
+func (s *Stack) unpackData(data []byte) error {
+	t, p, elems, err := decodePack(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t != s.elementType {
+		return fmt.Errorf("unpack: %s elements, the stack holds %s", packTypeName(t), packTypeName(s.elementType))
+	}
+	if (p == Hash) != (s.perspective == Hash) {
+		return errors.New("unpack: only a Hash stack takes the elements of a Hash stack")
+	}
+	for _, e := range elems {
+		if err := s.push(e.value, e.key...); err != nil {
+			return fmt.Errorf("unpack: %v", err)
+		}
+	}
+	return nil
+}
+
+// Pack is EncodeStack for the interpreter, encoding the values as
+// elements of type t.
+func (vs *ValueStack) Pack(t ElementType) ([]byte, error) {
+	return EncodeStack(vs.Typed(t))
+}
+
+// Unpack is the second half of Stack.UnpackFrom for the interpreter: the
+// elements of data must be of type t, the element type of vs in the
+// program.
+func (vs *ValueStack) Unpack(data []byte, t ElementType) error {
+	typed := NewStack(vs.Perspective(), t)
+	if err := typed.unpackData(data); err != nil {
+		return err
+	}
+	if err := vs.PushTyped(typed); err != nil {
+		return fmt.Errorf("unpack: %v", err)
+	}
+	return nil
+}
+
+// packTypeName is the ual name of an element type, for errors
+func packTypeName(t ElementType) string {
+	names := []string{"i64", "u64", "f64", "string", "bytes", "bool", "i32", "u32", "f32"}
+	if int(t) < len(names) {
+		return names[t]
+	}
+	return "unknown"
+}
@@ -0,0 +1,161 @@
+package runtime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPackRoundTrip(t *testing.T) {
+	cases := []struct {
+		t     ElementType
+		elems []string
+	}{
+		{TypeInt64, []string{"3", "-7", "9223372036854775807"}},
+		{TypeUint64, []string{"0", "18446744073709551615"}},
+		{TypeFloat64, []string{"0.5", "-2.25"}},
+		{TypeInt32, []string{"-2147483648", "42"}},
+		{TypeUint32, []string{"4294967295"}},
+		{TypeFloat32, []string{"1.5", "-0.25"}},
+		{TypeBool, []string{"true", "false"}},
+		{TypeString, []string{"", "héllo", "a\"b"}},
+		{TypeBytes, []string{"\x00\xff"}},
+	}
+	for _, c := range cases {
+		for _, p := range []Perspective{LIFO, FIFO, Indexed} {
+			s := NewStack(p, c.t)
+			for _, e := range c.elems {
+				data, err := csvElement(e, c.t)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.Push(data)
+			}
+			data, err := EncodeStack(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeStack(data)
+			if err != nil {
+				t.Fatalf("%s: %v", packTypeName(c.t), err)
+			}
+			if got.Perspective() != p || got.elementType != c.t || got.ToJSON() != s.ToJSON() {
+				t.Errorf("%s: decoded %s, want %s", packTypeName(c.t), got.ToJSON(), s.ToJSON())
+			}
+		}
+	}
+}
+
+func TestPackHash(t *testing.T) {
+	s := NewStack(Hash, TypeInt64)
+	s.Push(intToBytes(1), []byte("a"))
+	s.Push(intToBytes(2), []byte("b"))
+	s.Push(intToBytes(3), []byte("c"))
+	s.Delete("b")
+	data, err := EncodeStack(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeStack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ToJSON() != `{"a":1,"c":3}` {
+		t.Errorf("decoded %s", got.ToJSON())
+	}
+}
+
+// rual's encode_stack writes the same bytes
+func TestPackBytes(t *testing.T) {
+	s := NewStack(Hash, TypeString)
+	s.Push([]byte("x"), []byte("a"))
+	data, _ := EncodeStack(s)
+	if want := "UALP\x01\x03\x03\x00\x01\x01a\x01x"; string(data) != want {
+		t.Errorf("encoded %q, want %q", data, want)
+	}
+}
+
+func TestPackFromUnpackFrom(t *testing.T) {
+	src := NewStack(FIFO, TypeString)
+	src.Push([]byte("x"))
+	src.Push([]byte("y"))
+	blob := NewStack(LIFO, TypeBytes)
+	errs := NewStack(LIFO, TypeString)
+	blob.PackFrom(src, errs)
+
+	dst := NewStack(LIFO, TypeString)
+	dst.Push([]byte("w"))
+	dst.UnpackFrom(blob, errs)
+	if errs.Len() != 0 || dst.ToJSON() != `["w","x","y"]` || blob.Len() != 0 {
+		t.Errorf("unpacked %s with %d errors", dst.ToJSON(), errs.Len())
+	}
+
+	blob.PackFrom(src, errs)
+	ints := NewStack(LIFO, TypeInt64)
+	ints.UnpackFrom(blob, errs)
+	msg, _ := errs.Pop()
+	if ints.Len() != 0 || !strings.Contains(string(msg), "string elements, the stack holds i64") {
+		t.Errorf("unpacking strings to an i64 stack: %q", msg)
+	}
+
+	hash := NewStack(Hash, TypeString)
+	blob.PackFrom(src, errs)
+	hash.UnpackFrom(blob, errs)
+	if msg, _ := errs.Pop(); !strings.Contains(string(msg), "Hash stack") {
+		t.Errorf("unpacking a FIFO stack to a Hash stack: %q", msg)
+	}
+}
+
+func TestDecodeStackErrors(t *testing.T) {
+	s := NewStack(Hash, TypeInt32)
+	s.Push(intToBytes(5), []byte("k"))
+	good, _ := EncodeStack(s)
+
+	dup := append(bytes.Clone(good[:8]), 2)
+	dup = append(dup, good[9:]...)
+	dup = append(dup, good[9:]...)
+
+	cases := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte("nope"), "not a ualpack encoding"},
+		{append([]byte("UALP"), 9, 0, 0, 0, 0), "version 9"},
+		{append([]byte("UALP"), 1, 42, 0, 0, 0), "element type 42"},
+		{append([]byte("UALP"), 1, 0, 9, 0, 0), "perspective 9"},
+		{append([]byte("UALP"), 1, 0, 0, 1, 0), "flags"},
+		{good[:len(good)-1], "truncated"},
+		{append(bytes.Clone(good), 0), "1 bytes after"},
+		{dup, `key "k" twice`},
+		{append([]byte("UALP"), 1, byte(TypeBool), 0, 0, 1, 2), "truncated"},
+	}
+	for _, c := range cases {
+		if _, err := DecodeStack(c.data); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("decoding %x: error %v, want one with %q", c.data, err, c.want)
+		}
+	}
+
+	if _, err := EncodeStack(NewStack(Broadcast, TypeInt64)); err == nil {
+		t.Error("encoded a Broadcast stack")
+	}
+}
+
+func TestValueStackPack(t *testing.T) {
+	vs := NewValueStack(Hash)
+	vs.Set("a", NewInt(7))
+	vs.Set("b", NewInt(-1))
+	data, err := vs.Pack(TypeInt32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := NewValueStack(Hash)
+	if err := got.Unpack(data, TypeInt32); err != nil {
+		t.Fatal(err)
+	}
+	if got.ToJSON(TypeInt32) != `{"a":7,"b":-1}` {
+		t.Errorf("unpacked %s", got.ToJSON(TypeInt32))
+	}
+	if err := got.Unpack(data, TypeInt64); err == nil {
+		t.Error("unpacked i32 elements as i64")
+	}
+}
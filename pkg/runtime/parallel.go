@@ -0,0 +1,202 @@
+package runtime
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ha1tch/ual/pkg/runtime/internal/deque"
+)
+
+// ============================================================================
+// Parallel map / reduce
+//
+// The elements are split into chunks which are dealt round-robin onto one
+// work-stealing deque per worker. Each worker drains its own deque (LIFO)
+// and then steals from the others (FIFO), so uneven chunks still spread
+// across all cores.
+// Stacks smaller than parallelMinChunk elements are processed sequentially.
+// ============================================================================
+
+// parallelMinChunk is the smallest chunk handed to a worker. It is a
+// variable so tests can exercise the parallel path on small stacks.
+var parallelMinChunk = 1024
+
+// parallelChunksPerWorker controls how finely the work is split: more
+// chunks than workers leaves something to steal when chunks run unevenly.
+const parallelChunksPerWorker = 4
+
+// ErrStackChanged is returned by ParallelMap when the stack was restructured
+// (pushed, popped, cleared) while the work was running.
+var ErrStackChanged = errors.New("stack changed during parallel map")
+
+// parallelFor calls work(lo, hi) for consecutive ranges covering [0, n),
+// on up to GOMAXPROCS goroutines, and returns when all ranges are done.
+func parallelFor(n int, work func(lo, hi int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if limit := (n + parallelMinChunk - 1) / parallelMinChunk; workers > limit {
+		workers = limit
+	}
+	if workers <= 1 {
+		if n > 0 {
+			work(0, n)
+		}
+		return
+	}
+
+	chunks := workers * parallelChunksPerWorker
+	size := (n + chunks - 1) / chunks
+	if size < parallelMinChunk {
+		size = parallelMinChunk
+	}
+	chunks = (n + size - 1) / size
+
+	deques := make([]*deque.Deque, workers)
+	for w := range deques {
+		deques[w] = deque.New(chunks/workers + 1)
+	}
+	for c := 0; c < chunks; c++ {
+		deques[c%workers].Push(Task{ID: int64(c)})
+	}
+
+	run := func(t Task) {
+		lo := int(t.ID) * size
+		hi := lo + size
+		if hi > n {
+			hi = n
+		}
+		work(lo, hi)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for {
+				if t, ok := deques[w].Pop(); ok {
+					run(t)
+					continue
+				}
+				// Own deque is empty: help the others. No tasks are added
+				// once workers start, so a fruitless pass means we're done.
+				stole := false
+				for i := 1; i < workers; i++ {
+					if t, ok := deques[(w+i)%workers].Steal(); ok {
+						run(t)
+						stole = true
+						break
+					}
+				}
+				if !stole {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// ParallelMap replaces every element of s with fn(element), running fn on
+// several goroutines at once. fn must be safe for concurrent use. Order and
+// keys are unchanged; elements for which fn fails keep their old value and
+// the error is pushed to errStack if provided. A frozen s is an error, and
+// ErrStackChanged (leaving s unchanged) means s was restructured while fn
+// was running.
+func ParallelMap(s *Stack, fn WalkFunc, errStack *Stack) error {
+	return mapInPlace(s, fn, errStack, parallelFor)
+}
+
+// MapInPlace is ParallelMap on the calling goroutine only, for an fn that
+// is not safe for concurrent use (such as an interpreted codeblock).
+func MapInPlace(s *Stack, fn WalkFunc, errStack *Stack) error {
+	return mapInPlace(s, fn, errStack, func(n int, work func(lo, hi int)) {
+		if n > 0 {
+			work(0, n)
+		}
+	})
+}
+
+func mapInPlace(s *Stack, fn WalkFunc, errStack *Stack, each func(n int, work func(lo, hi int))) error {
+	s.mu.RLock()
+	if !s.allows(freezeUpdate) {
+		s.mu.RUnlock()
+		return ErrFrozen
+	}
+	indices := walkOrder(s)
+	data := make([][]byte, len(indices))
+	for i, idx := range indices {
+		data[i] = s.unpack(s.elements[idx].data)
+	}
+	version := s.version
+	s.mu.RUnlock()
+
+	results := make([][]byte, len(data))
+	errs := make([]error, len(data))
+	each(len(data), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			results[i], errs[i] = fn(data[i])
+		}
+	})
+
+	s.mu.Lock()
+	if s.version != version {
+		s.mu.Unlock()
+		return ErrStackChanged
+	}
+	for i, idx := range indices {
+		if errs[i] == nil {
+			s.elements[idx] = Element{data: s.pack(results[i])}
+		}
+	}
+	s.mu.Unlock()
+
+	// Pushed after s.mu is released, errStack may be s
+	if errStack != nil {
+		for _, err := range errs {
+			if err != nil {
+				errStack.Push([]byte(err.Error()))
+			}
+		}
+	}
+	return nil
+}
+
+// ParallelReduce folds source with fn like Reduce, but reduces chunks on
+// several goroutines and then folds the partial results in order. fn must
+// be associative and safe for concurrent use, and initial should be its
+// identity (0 for +, 1 for *), since every chunk starts from it. Sources
+// too small to split give exactly Reduce's result.
+func ParallelReduce(source Walkable, initial []byte, fn func(acc, elem []byte) []byte) ([]byte, error) {
+	data, _, err := source.walkSnapshot()
+	if err != nil {
+		return initial, err
+	}
+
+	type partial struct {
+		lo  int
+		acc []byte
+	}
+	var mu sync.Mutex
+	var partials []partial
+	parallelFor(len(data), func(lo, hi int) {
+		acc := initial
+		for _, elem := range data[lo:hi] {
+			acc = fn(acc, elem)
+		}
+		mu.Lock()
+		partials = append(partials, partial{lo, acc})
+		mu.Unlock()
+	})
+
+	if len(partials) == 0 {
+		return initial, nil
+	}
+	sort.Slice(partials, func(i, j int) bool { return partials[i].lo < partials[j].lo })
+	acc := partials[0].acc
+	for _, p := range partials[1:] {
+		acc = fn(acc, p.acc)
+	}
+	return acc, nil
+}
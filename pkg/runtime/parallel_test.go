@@ -0,0 +1,122 @@
+package runtime
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// withSmallChunks makes the parallel path kick in for small stacks.
+func withSmallChunks(t *testing.T, n int) {
+	t.Helper()
+	saved := parallelMinChunk
+	parallelMinChunk = n
+	t.Cleanup(func() { parallelMinChunk = saved })
+}
+
+func TestParallelForCoversRange(t *testing.T) {
+	withSmallChunks(t, 3)
+
+	const n = 1000
+	var hits [n]int32
+	parallelFor(n, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			atomic.AddInt32(&hits[i], 1)
+		}
+	})
+	for i, h := range hits {
+		if h != 1 {
+			t.Fatalf("index %d visited %d times", i, h)
+		}
+	}
+}
+
+func TestParallelMap(t *testing.T) {
+	withSmallChunks(t, 4)
+
+	s := NewStack(Indexed, TypeInt64)
+	for i := int64(0); i < 100; i++ {
+		s.Push(intToBytes(i))
+	}
+	errStack := NewStack(LIFO, TypeBytes)
+	err := ParallelMap(s, func(b []byte) ([]byte, error) {
+		v := bytesToInt(b)
+		if v == 7 {
+			return nil, errors.New("seven")
+		}
+		return intToBytes(v * v), nil
+	}, errStack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		b, _ := s.GetAtRaw(i)
+		want := int64(i * i)
+		if i == 7 {
+			want = 7 // failed elements keep their value
+		}
+		if got := bytesToInt(b); got != want {
+			t.Errorf("element %d: got %d, want %d", i, got, want)
+		}
+	}
+	if errStack.Len() != 1 {
+		t.Errorf("expected 1 error, got %d", errStack.Len())
+	}
+}
+
+func TestParallelMapLIFO(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	ParallelMap(s, func(b []byte) ([]byte, error) {
+		return intToBytes(bytesToInt(b) * 10), nil
+	}, nil)
+
+	top, _ := s.Pop()
+	if bytesToInt(top) != 20 {
+		t.Errorf("top: got %d, want 20", bytesToInt(top))
+	}
+
+	s.Freeze()
+	if err := ParallelMap(s, func(b []byte) ([]byte, error) { return b, nil }, nil); err == nil {
+		t.Error("expected error mapping a frozen stack")
+	}
+}
+
+func TestParallelReduce(t *testing.T) {
+	withSmallChunks(t, 5)
+
+	s := NewStack(Indexed, TypeInt64)
+	for i := int64(1); i <= 1000; i++ {
+		s.Push(intToBytes(i))
+	}
+	sum := func(acc, elem []byte) []byte {
+		return intToBytes(bytesToInt(acc) + bytesToInt(elem))
+	}
+	got, err := ParallelReduce(s, intToBytes(0), sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytesToInt(got) != 500500 {
+		t.Errorf("sum: got %d, want 500500", bytesToInt(got))
+	}
+
+	// Order is kept even for non-commutative folds
+	str := NewStack(FIFO, TypeString)
+	for _, w := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		str.Push([]byte(w))
+	}
+	withSmallChunks(t, 2)
+	cat, _ := ParallelReduce(str, nil, func(acc, elem []byte) []byte {
+		return append(append([]byte{}, acc...), elem...)
+	})
+	if string(cat) != "abcdefghijkl" {
+		t.Errorf("concat: got %q", cat)
+	}
+
+	empty := NewStack(Indexed, TypeInt64)
+	if got, _ := ParallelReduce(empty, intToBytes(9), sum); bytesToInt(got) != 9 {
+		t.Errorf("empty: got %d, want 9", bytesToInt(got))
+	}
+}
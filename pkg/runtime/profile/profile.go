@@ -0,0 +1,70 @@
+// Package profile profiles ual programs built by ual profile.
+//
+// Start, called first thing in main, writes a CPU profile of the run to
+// cpu.pprof in the directory named by $UAL_PROFILE, and the allocations
+// made to allocs.pprof when the program exits, by returning from main or
+// through exit(code). Without the variable it does nothing. It is a
+// package of its own so that other programs do not link runtime/pprof.
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+
+	ual "github.com/ha1tch/ual/pkg/runtime"
+)
+
+// Env is the environment variable naming the directory profiles go to
+const Env = "UAL_PROFILE"
+
+// CPUFile and AllocsFile are the names of the profiles in that directory
+const (
+	CPUFile    = "cpu.pprof"
+	AllocsFile = "allocs.pprof"
+)
+
+// memProfileRate samples an allocation every this many bytes, finer than
+// the runtime's default so that short runs still show where they allocate
+const memProfileRate = 4096
+
+// Start starts profiling if $UAL_PROFILE is set, and registers the exit
+// hook that writes the profiles
+func Start() {
+	dir := os.Getenv(Env)
+	if dir == "" {
+		return
+	}
+	runtime.MemProfileRate = memProfileRate
+	cpu, err := os.Create(filepath.Join(dir, CPUFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ual: profile: %v\n", err)
+		return
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		fmt.Fprintf(os.Stderr, "ual: profile: %v\n", err)
+		cpu.Close()
+		return
+	}
+	ual.AtExit(func() {
+		pprof.StopCPUProfile()
+		cpu.Close()
+		writeAllocs(filepath.Join(dir, AllocsFile))
+	})
+}
+
+func writeAllocs(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ual: profile: %v\n", err)
+		return
+	}
+	defer f.Close()
+	// The allocation counts are as of the last collection
+	runtime.GC()
+	if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+		fmt.Fprintf(os.Stderr, "ual: profile: %v\n", err)
+	}
+}
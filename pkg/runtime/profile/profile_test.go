@@ -0,0 +1,39 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+func TestStart(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(Env, dir)
+	Start()
+	var keep [][]byte
+	for i := 0; i < 1000; i++ {
+		keep = append(keep, make([]byte, 1024))
+	}
+	runtime.RunAtExit()
+	for _, name := range []string{CPUFile, AllocsFile} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s is empty", name)
+		}
+	}
+	_ = keep
+
+	// Without the variable nothing is written
+	off := t.TempDir()
+	t.Setenv(Env, "")
+	Start()
+	runtime.RunAtExit()
+	if entries, _ := os.ReadDir(off); len(entries) > 0 {
+		t.Errorf("wrote %d files without %s", len(entries), Env)
+	}
+}
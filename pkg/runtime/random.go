@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync"
+)
+
+// ============================================================================
+// Random numbers
+//
+//   rand()        a float from 0 up to but not including 1
+//   rand_int(n)   an integer from 0 up to but not including n
+//   seed(x)       restarts the sequence from x
+//
+// The generator is xoshiro256** (Blackman and Vigna), its state filled
+// from the seed by splitmix64. rual implements the same steps, so a seeded
+// program draws the same numbers from either backend and from iual. Until
+// seed is called the state comes from the operating system, and each run
+// differs. Neither is suitable for keys or tokens.
+// ============================================================================
+
+var rng struct {
+	sync.Mutex
+	s      [4]uint64
+	seeded bool
+}
+
+// Seed restarts the random sequence from x.
+func Seed(x int64) {
+	rng.Lock()
+	defer rng.Unlock()
+	seedLocked(uint64(x))
+}
+
+// seedLocked fills the state from x with splitmix64. Caller holds rng.
+func seedLocked(x uint64) {
+	for i := range rng.s {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		rng.s[i] = z ^ z>>31
+	}
+	rng.seeded = true
+}
+
+// next returns the next 64 bits of the sequence. Caller holds rng.
+func next() uint64 {
+	if !rng.seeded {
+		var b [8]byte
+		randomBytes(b[:])
+		seedLocked(binary.LittleEndian.Uint64(b[:]))
+	}
+	s := &rng.s
+	result := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return result
+}
+
+// Rand returns a float from 0 up to but not including 1, from the top 53
+// bits of the next value.
+func Rand() float64 {
+	rng.Lock()
+	defer rng.Unlock()
+	return float64(next()>>11) * 0x1p-53
+}
+
+// RandInt returns an integer from 0 up to but not including n, or 0 if n
+// is not positive. Values that would favour the low numbers are drawn
+// again, so every result is as likely.
+func RandInt(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	rng.Lock()
+	defer rng.Unlock()
+	bound := uint64(n)
+	threshold := -bound % bound // 2^64 mod n
+	for {
+		if r := next(); r >= threshold {
+			return int64(r % bound)
+		}
+	}
+}
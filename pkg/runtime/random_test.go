@@ -0,0 +1,43 @@
+package runtime
+
+import "testing"
+
+// The sequences rual must reproduce, see rual/src/random.rs
+func TestSeedSequence(t *testing.T) {
+	Seed(0)
+	rng.Lock()
+	first := next()
+	rng.Unlock()
+	if first != 0x99ec5f36cb75f2b4 {
+		t.Errorf("first value after seed(0): %#x, want 0x99ec5f36cb75f2b4", first)
+	}
+
+	Seed(42)
+	for i, want := range []int64{742, 102, 9, 193} {
+		if got := RandInt(1000); got != want {
+			t.Errorf("rand_int(1000) #%d after seed(42): got %d, want %d", i, got, want)
+		}
+	}
+	Seed(42)
+	if got := Rand(); got != 0.08386297105988216 {
+		t.Errorf("rand() after seed(42): got %v", got)
+	}
+}
+
+func TestRandRange(t *testing.T) {
+	Seed(7)
+	for i := 0; i < 1000; i++ {
+		if f := Rand(); f < 0 || f >= 1 {
+			t.Fatalf("rand() = %v", f)
+		}
+		if n := RandInt(3); n < 0 || n >= 3 {
+			t.Fatalf("rand_int(3) = %d", n)
+		}
+	}
+	if n := RandInt(0); n != 0 {
+		t.Errorf("rand_int(0) = %d", n)
+	}
+	if n := RandInt(-5); n != 0 {
+		t.Errorf("rand_int(-5) = %d", n)
+	}
+}
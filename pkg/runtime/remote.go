@@ -0,0 +1,376 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Remote stacks
+//
+// Serve exports a stack on a TCP or Unix socket and Dial attaches to it from
+// another process, so a stack can act as a queue shared between machines
+// without a broker:
+//
+//   srv, _ := ual.Serve(jobs, "tcp://:9000")        // producer side
+//   jobs, _ := ual.Dial("tcp://host:9000")          // each worker
+//   for { job, err := jobs.Take(); ... }
+//
+// Every call is one request and one response on the connection. Both are
+// frames: a 4-byte big-endian length followed by that many bytes. A request
+// frame holds an op byte and its arguments, each a 4-byte length and the
+// bytes; a response frame holds a status byte and the value or the error
+// message. Elements are sent as raw bytes, exactly as the stack stores them.
+// ============================================================================
+
+// Request ops
+const (
+	remotePush byte = iota + 1
+	remotePop
+	remotePeek
+	remoteTake // timeout in ms (8 bytes, 0 = wait forever)
+	remoteLen
+	remoteClose
+)
+
+// Response status
+const (
+	remoteOK byte = iota
+	remoteErr
+)
+
+// maxRemoteFrame bounds a frame so a bad peer cannot make us allocate
+// without limit
+const maxRemoteFrame = 64 << 20
+
+// Server serves one stack to remote clients
+type Server struct {
+	stack *Stack
+	ln    net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Serve exports s at addr, "tcp://host:port" or "unix:///path/to.sock",
+// and handles clients in the background until Close. Use port 0 to let
+// the system pick one; Addr reports it.
+func Serve(s *Stack, addr string) (*Server, error) {
+	network, address, err := parseRemoteAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		os.Remove(address) // a stale socket from an earlier run
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{stack: s, ln: ln, conns: make(map[net.Conn]struct{})}
+	srv.wg.Add(1)
+	go srv.accept()
+	return srv, nil
+}
+
+// Addr returns the address the server listens on
+func (srv *Server) Addr() net.Addr {
+	return srv.ln.Addr()
+}
+
+// Close stops accepting clients and disconnects the current ones. The
+// stack itself is left open.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	for c := range srv.conns {
+		c.Close()
+	}
+	srv.mu.Unlock()
+	err := srv.ln.Close()
+	srv.wg.Wait()
+	return err
+}
+
+func (srv *Server) accept() {
+	defer srv.wg.Done()
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			conn.Close()
+			return
+		}
+		srv.conns[conn] = struct{}{}
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+		go srv.serve(conn)
+	}
+}
+
+// serve answers one client's requests in order until it disconnects
+func (srv *Server) serve(conn net.Conn) {
+	defer srv.wg.Done()
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, conn)
+		srv.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		req, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if len(req) == 0 {
+			return
+		}
+		args, err := splitArgs(req[1:])
+		if err != nil {
+			return
+		}
+
+		var data []byte
+		switch req[0] {
+		case remotePush:
+			if len(args) == 0 {
+				err = errors.New("push requires a value")
+			} else {
+				err = srv.stack.Push(args[0], args[1:]...)
+			}
+		case remotePop:
+			data, err = srv.stack.Pop(args...)
+		case remotePeek:
+			data, err = srv.stack.Peek(args...)
+		case remoteTake:
+			data, err = srv.take(conn, r, args)
+		case remoteLen:
+			data = intToBytes(int64(srv.stack.Len()))
+		case remoteClose:
+			srv.stack.Close()
+		default:
+			err = fmt.Errorf("unknown remote op %d", req[0])
+		}
+
+		if err := writeResponse(conn, data, err); err != nil {
+			if req[0] == remoteTake && data != nil {
+				srv.stack.untake(data) // the client never got it
+			}
+			return
+		}
+	}
+}
+
+// take blocks like Stack.Take, but gives up if the client hangs up while
+// waiting, so an element is never taken for a client that is gone.
+func (srv *Server) take(conn net.Conn, r *bufio.Reader, args [][]byte) ([]byte, error) {
+	var timeout int64
+	if len(args) > 0 {
+		timeout = bytesToInt(args[0])
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A client waiting for a reply sends nothing, so a read only returns
+	// when the connection fails
+	hangup := make(chan struct{})
+	go func() {
+		defer close(hangup)
+		if _, err := r.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel()
+		}
+	}()
+	defer func() {
+		conn.SetReadDeadline(time.Unix(1, 0)) // wake the watcher
+		<-hangup
+		conn.SetReadDeadline(time.Time{})
+	}()
+
+	return srv.stack.take(ctx, timeout, afterFunc)
+}
+
+// RemoteStack is a stack served by another process. Its methods mirror
+// Stack's and may also fail with network errors. It is safe for
+// concurrent use; calls are sent one at a time.
+type RemoteStack struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to a stack exported by Serve at addr
+func Dial(addr string) (*RemoteStack, error) {
+	network, address, err := parseRemoteAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteStack{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Push adds an element to the remote stack (a key is needed for Hash)
+func (rs *RemoteStack) Push(value []byte, key ...[]byte) error {
+	_, err := rs.call(remotePush, append([][]byte{value}, key...)...)
+	return err
+}
+
+// Pop removes and returns an element, as Stack.Pop
+func (rs *RemoteStack) Pop(param ...[]byte) ([]byte, error) {
+	return rs.call(remotePop, param...)
+}
+
+// Peek returns an element without removing it, as Stack.Peek
+func (rs *RemoteStack) Peek(param ...[]byte) ([]byte, error) {
+	return rs.call(remotePeek, param...)
+}
+
+// Take blocks until an element is available, the remote stack is closed,
+// or the optional timeout in milliseconds passes, as Stack.Take
+func (rs *RemoteStack) Take(timeoutMs ...int64) ([]byte, error) {
+	var timeout int64
+	if len(timeoutMs) > 0 {
+		timeout = timeoutMs[0]
+	}
+	return rs.call(remoteTake, intToBytes(timeout))
+}
+
+// TakeWithContext is Take that also gives up when ctx is done, returning
+// ErrCancelled. The server only abandons a take when its client goes, so
+// cancelling closes the connection and later calls fail.
+func (rs *RemoteStack) TakeWithContext(ctx context.Context, timeoutMs int64) ([]byte, error) {
+	stop := context.AfterFunc(ctx, func() { rs.conn.Close() })
+	defer stop()
+	data, err := rs.call(remoteTake, intToBytes(timeoutMs))
+	if err != nil && ctx.Err() != nil {
+		return nil, ErrCancelled
+	}
+	return data, err
+}
+
+// Len returns the number of elements on the remote stack
+func (rs *RemoteStack) Len() (int, error) {
+	data, err := rs.call(remoteLen)
+	if err != nil {
+		return 0, err
+	}
+	return int(bytesToInt(data)), nil
+}
+
+// CloseStack closes the remote stack, so Take returns "stack closed" once
+// it is empty, for every client
+func (rs *RemoteStack) CloseStack() error {
+	_, err := rs.call(remoteClose)
+	return err
+}
+
+// Close disconnects from the server. The remote stack is unaffected.
+func (rs *RemoteStack) Close() error {
+	return rs.conn.Close()
+}
+
+// call sends one request and waits for its response
+func (rs *RemoteStack) call(op byte, args ...[]byte) ([]byte, error) {
+	req := []byte{op}
+	for _, a := range args {
+		req = binary.BigEndian.AppendUint32(req, uint32(len(a)))
+		req = append(req, a...)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err := writeFrame(rs.conn, req); err != nil {
+		return nil, err
+	}
+	resp, err := readFrame(rs.r)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 {
+		return nil, errors.New("remote: empty response")
+	}
+	if resp[0] == remoteErr {
+		return nil, errorFromMessage(string(resp[1:]))
+	}
+	return resp[1:], nil
+}
+
+// parseRemoteAddr splits "tcp://host:port" or "unix:///path" into the
+// network and address net.Listen and net.Dial take
+func parseRemoteAddr(addr string) (string, string, error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("remote address %q must look like tcp://host:port or unix:///path", addr)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("remote address %q: unsupported network %q", addr, network)
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxRemoteFrame {
+		return nil, fmt.Errorf("remote: frame of %d bytes is too large", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// splitArgs decodes the length-prefixed arguments of a request
+func splitArgs(b []byte) ([][]byte, error) {
+	var args [][]byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("remote: truncated argument")
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint32(len(b)) < n {
+			return nil, errors.New("remote: truncated argument")
+		}
+		args = append(args, b[:n:n])
+		b = b[n:]
+	}
+	return args, nil
+}
+
+func writeResponse(w io.Writer, data []byte, err error) error {
+	if err != nil {
+		return writeFrame(w, append([]byte{remoteErr}, err.Error()...))
+	}
+	return writeFrame(w, append([]byte{remoteOK}, data...))
+}
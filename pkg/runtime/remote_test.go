@@ -0,0 +1,148 @@
+package runtime
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func serveTest(t *testing.T, s *Stack) *Server {
+	t.Helper()
+	srv, err := Serve(s, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func dialTest(t *testing.T, srv *Server) *RemoteStack {
+	t.Helper()
+	rs, err := Dial("tcp://" + srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rs.Close() })
+	return rs
+}
+
+func TestRemotePushPop(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	rs := dialTest(t, serveTest(t, s))
+
+	for i := int64(1); i <= 3; i++ {
+		if err := rs.Push(intToBytes(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := rs.Len(); n != 3 || s.Len() != 3 {
+		t.Errorf("expected 3 on both sides, remote %d local %d", n, s.Len())
+	}
+	if v, _ := rs.Peek(); bytesToInt(v) != 1 {
+		t.Errorf("peek: expected 1, got %d", bytesToInt(v))
+	}
+	for i := int64(1); i <= 3; i++ {
+		v, err := rs.Pop()
+		if err != nil || bytesToInt(v) != i {
+			t.Errorf("pop: expected %d, got %d (%v)", i, bytesToInt(v), err)
+		}
+	}
+	if _, err := rs.Pop(); err == nil || err.Error() != "stack empty" {
+		t.Errorf("pop on empty: expected \"stack empty\", got %v", err)
+	}
+}
+
+func TestRemoteHash(t *testing.T) {
+	s := NewStack(Hash, TypeBytes)
+	rs := dialTest(t, serveTest(t, s))
+
+	if err := rs.Push([]byte("blue"), []byte("color")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := rs.Peek([]byte("color"))
+	if err != nil || string(v) != "blue" {
+		t.Errorf("expected blue, got %q (%v)", v, err)
+	}
+	if err := rs.Push([]byte("x")); err == nil {
+		t.Error("expected a keyless push to a Hash stack to fail")
+	}
+}
+
+func TestRemoteTake(t *testing.T) {
+	s := NewStack(FIFO, TypeBytes)
+	srv := serveTest(t, s)
+	worker := dialTest(t, srv)
+	producer := dialTest(t, srv)
+
+	if _, err := worker.Take(20); err == nil || err.Error() != "take timeout" {
+		t.Errorf("expected take timeout, got %v", err)
+	}
+
+	got := make(chan []byte)
+	go func() {
+		v, _ := worker.Take()
+		got <- v
+	}()
+	time.Sleep(20 * time.Millisecond)
+	producer.Push([]byte("job"))
+	select {
+	case v := <-got:
+		if string(v) != "job" {
+			t.Errorf("expected job, got %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Take did not return")
+	}
+
+	producer.CloseStack()
+	if _, err := worker.Take(); err == nil || err.Error() != "stack closed" {
+		t.Errorf("expected stack closed, got %v", err)
+	}
+}
+
+func TestRemoteTakeHangup(t *testing.T) {
+	s := NewStack(FIFO, TypeBytes)
+	srv := serveTest(t, s)
+	rs := dialTest(t, srv)
+
+	go rs.Take()
+	time.Sleep(20 * time.Millisecond)
+	rs.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	// The abandoned Take must not swallow the next element
+	s.Push([]byte("kept"))
+	time.Sleep(20 * time.Millisecond)
+	if v, err := s.Pop(); err != nil || !bytes.Equal(v, []byte("kept")) {
+		t.Errorf("expected the element to stay on the stack, got %q (%v)", v, err)
+	}
+}
+
+func TestRemoteUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "stack.sock")
+	s := NewStack(LIFO, TypeInt64)
+	srv, err := Serve(s, "unix://"+sock)
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	defer srv.Close()
+
+	rs, err := Dial("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	rs.Push(intToBytes(7))
+	if v, _ := s.Pop(); bytesToInt(v) != 7 {
+		t.Errorf("expected 7, got %d", bytesToInt(v))
+	}
+}
+
+func TestRemoteBadAddr(t *testing.T) {
+	for _, addr := range []string{"localhost:9000", "udp://:9000", "tcp://"} {
+		if _, err := Serve(NewStack(LIFO, TypeInt64), addr); err == nil {
+			t.Errorf("%s: expected error", addr)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ============================================================================
+// Work-stealing scheduler
+//
+// A fixed pool of workers, each with its own task queue. Submit deals tasks
+// round-robin onto the queues; a worker runs its own newest task first
+// (LIFO) and, when its queue is empty, steals the oldest task (FIFO) from
+// the others. The pool size bounds how many tasks run at once, so tasks
+// that block waiting for each other need enough workers to make progress.
+// ============================================================================
+
+// DefaultSpawnWorkers is the pool size used for @spawn tasks unless the
+// compiler is given --workers. It is deliberately larger than the core
+// count because spawned tasks often block on take.
+const DefaultSpawnWorkers = 64
+
+// ErrSchedulerClosed is returned by Submit after Shutdown.
+var ErrSchedulerClosed = errors.New("scheduler is shut down")
+
+// WorkerStats describes one worker's queue.
+type WorkerStats struct {
+	Queued   int   // tasks waiting in this worker's queue
+	Executed int64 // tasks this worker has run
+	Stolen   int64 // of those, tasks taken from another worker's queue
+}
+
+// Scheduler runs submitted tasks on a fixed pool of work-stealing workers.
+type Scheduler struct {
+	queues []*schedQueue
+	next   atomic.Uint64 // round-robin submit position
+
+	mu      sync.Mutex
+	work    *sync.Cond // signalled when a task is queued or on shutdown
+	idle    *sync.Cond // broadcast when pending drops to zero
+	queued  atomic.Int64
+	pending atomic.Int64 // submitted and not yet finished
+	closed  bool
+	done    chan struct{} // closed when every worker has exited
+}
+
+type schedQueue struct {
+	mu       sync.Mutex
+	tasks    []func()
+	executed atomic.Int64
+	stolen   atomic.Int64
+}
+
+// NewScheduler starts a scheduler with the given number of workers.
+// workers <= 0 uses GOMAXPROCS.
+func NewScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	s := &Scheduler{
+		queues: make([]*schedQueue, workers),
+		done:   make(chan struct{}),
+	}
+	s.work = sync.NewCond(&s.mu)
+	s.idle = sync.NewCond(&s.mu)
+	for w := range s.queues {
+		s.queues[w] = &schedQueue{}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			s.worker(w)
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(s.done)
+	}()
+	return s
+}
+
+// Workers returns the pool size.
+func (s *Scheduler) Workers() int {
+	return len(s.queues)
+}
+
+// Submit queues fn to run on a worker.
+func (s *Scheduler) Submit(fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSchedulerClosed
+	}
+	q := s.queues[(s.next.Add(1)-1)%uint64(len(s.queues))]
+	q.mu.Lock()
+	q.tasks = append(q.tasks, fn)
+	q.mu.Unlock()
+	s.pending.Add(1)
+	s.queued.Add(1)
+	s.work.Signal()
+	return nil
+}
+
+// Drain blocks until every task submitted so far, and any they submit in
+// turn, has finished.
+func (s *Scheduler) Drain() {
+	s.mu.Lock()
+	for s.pending.Load() > 0 {
+		s.idle.Wait()
+	}
+	s.mu.Unlock()
+}
+
+// Shutdown stops accepting tasks and waits for the queued and running ones
+// to finish, or for ctx to be done, in which case it returns ctx.Err() and
+// the workers carry on in the background until the queues are empty.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.work.Broadcast()
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of every worker's queue, indexed by worker.
+func (s *Scheduler) Stats() []WorkerStats {
+	stats := make([]WorkerStats, len(s.queues))
+	for w, q := range s.queues {
+		q.mu.Lock()
+		stats[w].Queued = len(q.tasks)
+		q.mu.Unlock()
+		stats[w].Executed = q.executed.Load()
+		stats[w].Stolen = q.stolen.Load()
+	}
+	return stats
+}
+
+func (s *Scheduler) worker(w int) {
+	own := s.queues[w]
+	for {
+		fn, stolen := s.take(w)
+		if fn == nil {
+			// Nothing queued anywhere: sleep until Submit or Shutdown.
+			// queued is re-checked under mu, which Submit holds while
+			// signalling, so a wakeup cannot be missed.
+			s.mu.Lock()
+			for s.queued.Load() == 0 && !s.closed {
+				s.work.Wait()
+			}
+			exit := s.queued.Load() == 0 && s.closed
+			s.mu.Unlock()
+			if exit {
+				return
+			}
+			continue
+		}
+
+		own.executed.Add(1)
+		if stolen {
+			own.stolen.Add(1)
+		}
+		s.run(fn)
+	}
+}
+
+// take pops worker w's newest task, or steals another worker's oldest
+func (s *Scheduler) take(w int) (fn func(), stolen bool) {
+	if fn := s.queues[w].pop(); fn != nil {
+		s.queued.Add(-1)
+		return fn, false
+	}
+	for i := 1; i < len(s.queues); i++ {
+		if fn := s.queues[(w+i)%len(s.queues)].steal(); fn != nil {
+			s.queued.Add(-1)
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Scheduler) run(fn func()) {
+	defer func() {
+		if s.pending.Add(-1) == 0 {
+			s.mu.Lock()
+			s.idle.Broadcast()
+			s.mu.Unlock()
+		}
+	}()
+	fn()
+}
+
+func (q *schedQueue) pop() func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.tasks)
+	if n == 0 {
+		return nil
+	}
+	fn := q.tasks[n-1]
+	q.tasks[n-1] = nil
+	q.tasks = q.tasks[:n-1]
+	return fn
+}
+
+func (q *schedQueue) steal() func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	fn := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	return fn
+}
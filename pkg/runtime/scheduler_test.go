@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunsAll(t *testing.T) {
+	s := NewScheduler(4)
+	var n atomic.Int64
+	for i := 0; i < 1000; i++ {
+		if err := s.Submit(func() {
+			n.Add(1)
+			if n.Load()%100 == 0 {
+				s.Submit(func() { n.Add(1) }) // tasks may submit tasks
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Drain()
+	if got := n.Load(); got < 1000 {
+		t.Errorf("ran %d tasks, want at least 1000", got)
+	}
+
+	var executed int64
+	for _, st := range s.Stats() {
+		executed += st.Executed
+		if st.Queued != 0 {
+			t.Errorf("queue not empty after Drain: %+v", st)
+		}
+	}
+	if executed != n.Load() {
+		t.Errorf("stats count %d executed, ran %d", executed, n.Load())
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(func() {}); err != ErrSchedulerClosed {
+		t.Errorf("Submit after Shutdown: got %v", err)
+	}
+}
+
+func TestSchedulerBoundsConcurrency(t *testing.T) {
+	const workers = 3
+	s := NewScheduler(workers)
+	var running, peak atomic.Int64
+	for i := 0; i < 30; i++ {
+		s.Submit(func() {
+			r := running.Add(1)
+			for {
+				p := peak.Load()
+				if r <= p || peak.CompareAndSwap(p, r) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	s.Drain()
+	if p := peak.Load(); p > workers {
+		t.Errorf("%d tasks ran at once with %d workers", p, workers)
+	}
+	s.Shutdown(context.Background())
+}
+
+func TestSchedulerSteals(t *testing.T) {
+	s := NewScheduler(2)
+	defer s.Shutdown(context.Background())
+
+	// Queues are filled round-robin, so while one worker is blocked the
+	// tasks dealt to it can only finish by being stolen.
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Submit(func() { close(started); <-release })
+	<-started
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		s.Submit(func() { wg.Done() })
+	}
+	wg.Wait()
+	close(release)
+	s.Drain()
+
+	var stolen int64
+	for _, st := range s.Stats() {
+		stolen += st.Stolen
+	}
+	if stolen == 0 {
+		t.Error("expected some tasks to be stolen")
+	}
+}
+
+func TestSchedulerShutdownTimeout(t *testing.T) {
+	s := NewScheduler(1)
+	release := make(chan struct{})
+	s.Submit(func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown with a blocked task: got %v", err)
+	}
+	close(release)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 // Package runtime provides ScopeStack for managing variable scopes.
 package runtime
 
+import "sort"
+
 // ScopeStack manages nested variable scopes.
 // Note: This implementation is NOT thread-safe. The interpreter is single-threaded.
 type ScopeStack struct {
@@ -13,6 +15,14 @@ func (ss *ScopeStack) PushScope() { ss.scopes = append(ss.scopes, make(map[strin
 func (ss *ScopeStack) PopScope()  { if len(ss.scopes) > 1 { ss.scopes = ss.scopes[:len(ss.scopes)-1] } }
 func (ss *ScopeStack) Depth() int { return len(ss.scopes) }
 
+// FunctionScope returns the scopes a function body runs in: the global
+// scope of ss, shared, and a new scope for the function's own variables.
+// The caller's local scopes are left out, so a function cannot see or
+// change them.
+func (ss *ScopeStack) FunctionScope() *ScopeStack {
+	return &ScopeStack{scopes: []map[string]Value{ss.scopes[0], make(map[string]Value)}}
+}
+
 func (ss *ScopeStack) Get(name string) (Value, bool) {
 	for i := len(ss.scopes) - 1; i >= 0; i-- { if v, ok := ss.scopes[i][name]; ok { return v, true } }
 	return NilValue, false
@@ -37,6 +47,16 @@ func (ss *ScopeStack) Has(name string) bool {
 	return false
 }
 
+// Names returns the names of the variables Get can see, sorted.
+func (ss *ScopeStack) Names() []string {
+	seen := make(map[string]bool)
+	for _, scope := range ss.scopes { for name := range scope { seen[name] = true } }
+	names := make([]string, 0, len(seen))
+	for name := range seen { names = append(names, name) }
+	sort.Strings(names)
+	return names
+}
+
 func (ss *ScopeStack) Clone() *ScopeStack {
 	newScopes := make([]map[string]Value, len(ss.scopes))
 	for i, scope := range ss.scopes { newScope := make(map[string]Value, len(scope)); for k, v := range scope { newScope[k] = v }; newScopes[i] = newScope }
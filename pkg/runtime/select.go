@@ -0,0 +1,24 @@
+package runtime
+
+import "math/rand/v2"
+
+// SelectPop pops from the first of stacks that has an element and returns
+// its index and the element, or -1 and nil if every stack is empty. It is
+// the non-blocking step of a select block.
+//
+// When fair is true the stacks are tried starting from a random one, so a
+// busy stack cannot starve the ones after it. When fair is false they are
+// tried in order, giving earlier stacks priority.
+func SelectPop(fair bool, stacks ...*Stack) (int, []byte) {
+	start := 0
+	if fair && len(stacks) > 1 {
+		start = rand.IntN(len(stacks))
+	}
+	for k := range stacks {
+		i := (start + k) % len(stacks)
+		if v, err := stacks[i].Pop(); err == nil {
+			return i, v
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,40 @@
+package runtime
+
+import "testing"
+
+func TestSelectPop(t *testing.T) {
+	a := NewStack(FIFO, TypeInt64)
+	b := NewStack(FIFO, TypeInt64)
+
+	if i, v := SelectPop(true, a, b); i != -1 || v != nil {
+		t.Errorf("empty stacks: got %d, %v", i, v)
+	}
+
+	// Ordered: the first stack always wins while it has elements
+	for n := 0; n < 10; n++ {
+		a.Push(intToBytes(1))
+		b.Push(intToBytes(2))
+	}
+	for n := 0; n < 10; n++ {
+		if i, _ := SelectPop(false, a, b); i != 0 {
+			t.Fatalf("ordered pop %d: got stack %d", n, i)
+		}
+	}
+	if i, v := SelectPop(false, a, b); i != 1 || bytesToInt(v) != 2 {
+		t.Errorf("ordered pop after a drained: got %d, %v", i, v)
+	}
+
+	// Fair: both stacks are served while both have elements
+	for n := 0; n < 200; n++ {
+		a.Push(intToBytes(1))
+		b.Push(intToBytes(2))
+	}
+	counts := [2]int{}
+	for n := 0; n < 200; n++ {
+		i, _ := SelectPop(true, a, b)
+		counts[i]++
+	}
+	if counts[0] < 50 || counts[1] < 50 {
+		t.Errorf("fair pops are skewed: %v", counts)
+	}
+}
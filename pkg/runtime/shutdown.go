@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ============================================================================
+// Program context
+//
+//   ual.HandleInterrupts()                        // start of a compiled main
+//   v, err := s.TakeWithContext(ual.Context(), 0)
+//   ual.StopOnShutdown(err)
+//
+// Context is cancelled when the program shuts down, so every blocking call
+// made with it returns ErrCancelled instead of waiting for a push that will
+// never come. Compiled programs make all their takes and selects with it,
+// and HandleInterrupts shuts them down on Ctrl-C (SIGINT) and SIGTERM.
+// ============================================================================
+
+var program struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	own       map[os.Signal]bool // signals the program receives on a Signals stack
+	interrupt sync.Once
+}
+
+func init() {
+	program.ctx, program.cancel = context.WithCancel(context.Background())
+	program.own = make(map[os.Signal]bool)
+}
+
+// Context returns the program context. It is done once Shutdown is called.
+func Context() context.Context {
+	return program.ctx
+}
+
+// Shutdown cancels the program context, then runs the exit hooks and ends
+// the program with status code, as Exit does. Hooks run after the
+// cancellation, so a take in a hook cannot wait behind a blocked task.
+func Shutdown(code int) {
+	program.cancel()
+	Exit(code)
+}
+
+// StopOnShutdown blocks for good if err is the ErrCancelled of a call cut
+// short by Shutdown, so that the task goes no further while the program
+// exits. It returns for any other err, and in exit hooks.
+func StopOnShutdown(err error) {
+	if !errors.Is(err, ErrCancelled) || program.ctx.Err() == nil {
+		return
+	}
+	atExit.mu.Lock()
+	running := atExit.running
+	atExit.mu.Unlock()
+	if !running {
+		select {}
+	}
+}
+
+// HandleInterrupts makes SIGINT and SIGTERM shut the program down with the
+// conventional 128+signal status. A signal the program receives on a
+// Signals stack is left to it. A second signal while the exit hooks run
+// kills the process outright. Calling it again has no effect.
+func HandleInterrupts() {
+	program.interrupt.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			for sig := range ch {
+				if ownSignal(sig) {
+					continue
+				}
+				signal.Stop(ch)
+				code := 1
+				if n, ok := sig.(syscall.Signal); ok {
+					code = 128 + int(n)
+				}
+				Shutdown(code)
+			}
+		}()
+	})
+}
+
+// ownSignals records that the program receives sigs itself
+func ownSignals(sigs []os.Signal) {
+	program.mu.Lock()
+	defer program.mu.Unlock()
+	for _, sig := range sigs {
+		program.own[sig] = true
+	}
+}
+
+func ownSignal(sig os.Signal) bool {
+	program.mu.Lock()
+	defer program.mu.Unlock()
+	return program.own[sig]
+}
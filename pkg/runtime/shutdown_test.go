@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTakeWithContextLeavesStack(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.TakeWithContext(ctx, 0)
+		done <- err
+	}()
+	cancel()
+	if err := <-done; err != ErrCancelled {
+		t.Fatalf("expected ErrCancelled, got %v", err)
+	}
+
+	// A cancelled take must not remove an element pushed afterwards, as
+	// the old polling take could for the cases that lost a select
+	s.Push(intToBytes(7))
+	time.Sleep(20 * time.Millisecond)
+	if s.Len() != 1 {
+		t.Errorf("expected the element to stay, stack has %d", s.Len())
+	}
+}
+
+func TestRemoteTakeWithContext(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	rs := dialTest(t, serveTest(t, s))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := rs.TakeWithContext(ctx, 0); err != ErrCancelled {
+		t.Fatalf("expected ErrCancelled, got %v", err)
+	}
+	// The server gives up the take once it sees the client go
+	time.Sleep(20 * time.Millisecond)
+	s.Push(intToBytes(1))
+	time.Sleep(50 * time.Millisecond)
+	if s.Len() != 1 {
+		t.Errorf("expected the element to stay, stack has %d", s.Len())
+	}
+}
+
+// TestShutdown cancels the program context, so it must stay the only test
+// that calls Shutdown
+func TestShutdown(t *testing.T) {
+	saved := exitProcess
+	defer func() { exitProcess = saved }()
+	code := -1
+	exitProcess = func(c int) { code = c }
+
+	s := NewStack(LIFO, TypeInt64)
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.TakeWithContext(Context(), 0)
+		done <- err
+	}()
+
+	var hookErr error
+	AtExit(func() { _, hookErr = s.TakeWithContext(Context(), 0) })
+	Shutdown(130)
+
+	if code != 130 {
+		t.Errorf("expected exit status 130, got %d", code)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("blocked take: expected ErrCancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked take did not return on shutdown")
+	}
+	if !errors.Is(hookErr, ErrCancelled) {
+		t.Errorf("take in exit hook: expected ErrCancelled, got %v", hookErr)
+	}
+	StopOnShutdown(errors.New("other")) // returns
+}
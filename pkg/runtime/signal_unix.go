@@ -0,0 +1,11 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package runtime
+
+import "syscall"
+
+func init() {
+	signalsByName["SIGUSR1"] = syscall.SIGUSR1
+	signalsByName["SIGUSR2"] = syscall.SIGUSR2
+	signalsByName["SIGWINCH"] = syscall.SIGWINCH
+}
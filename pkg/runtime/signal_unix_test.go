@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package runtime
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestSignalsDelivery(t *testing.T) {
+	sigs, err := Signals("USR1")
+	if err != nil {
+		t.Fatalf("Signals: %v", err)
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	v, err := sigs.Take(1000)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if string(v) != "SIGUSR1" {
+		t.Errorf("expected SIGUSR1, got %q", v)
+	}
+}
@@ -0,0 +1,95 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ============================================================================
+// Select sources
+//
+// Timers and OS signals surfaced as stacks, so that a select block waits on
+// them the same way it waits on any other stack:
+//
+//   ticks := ual.Every(500)              // Unix time in ms, every 500ms
+//   sigs, err := ual.Signals("SIGINT")   // "SIGINT" on each delivery
+// ============================================================================
+
+// Every returns a FIFO int64 stack that receives the current Unix time in
+// milliseconds every ms milliseconds. Like time.Ticker it holds at most one
+// pending tick; ticks that arrive while one is still waiting are dropped.
+// Closing the stack stops the timer. It ticks on the program clock, so a
+// frozen clock only ticks as AdvanceTime moves it.
+func Every(ms int64) *Stack {
+	if ms < 1 {
+		ms = 1
+	}
+	s := NewCappedStack(FIFO, TypeInt64, 1)
+	tickFunc(time.Duration(ms)*time.Millisecond, func(t time.Time) bool {
+		if s.IsClosed() {
+			return false
+		}
+		s.Push(intToBytes(t.UnixMilli())) // full: drop the tick
+		return true
+	})
+	return s
+}
+
+// Signals returns a FIFO string stack that receives the name of each of the
+// given signals ("SIGINT", "SIGTERM", ...) as it is delivered to the process.
+// Names may omit the SIG prefix. The signals no longer trigger their default
+// action or HandleInterrupts; they are delivered to the stack for the life
+// of the program.
+func Signals(names ...string) (*Stack, error) {
+	sigs := make([]os.Signal, 0, len(names))
+	for _, name := range names {
+		sig, ok := LookupSignal(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q", name)
+		}
+		sigs = append(sigs, sig)
+	}
+
+	s := NewStack(FIFO, TypeString)
+	ownSignals(sigs)
+	ch := make(chan os.Signal, 4)
+	signal.Notify(ch, sigs...)
+	go func() {
+		for sig := range ch {
+			s.Push([]byte(signalName(sig)))
+		}
+	}()
+	return s, nil
+}
+
+// LookupSignal returns the signal called name, with or without its SIG
+// prefix. Only signals available on the current platform are found.
+func LookupSignal(name string) (os.Signal, bool) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signalsByName[name]
+	return sig, ok
+}
+
+var signalsByName = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  os.Interrupt,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// signalName is the SIG-prefixed name LookupSignal accepts for sig
+func signalName(sig os.Signal) string {
+	for name, s := range signalsByName {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
@@ -0,0 +1,36 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvery(t *testing.T) {
+	ticks := Every(10)
+	defer ticks.Close()
+
+	start := time.Now().UnixMilli()
+	v, err := ticks.Take(1000)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if ms := bytesToInt(v); ms < start {
+		t.Errorf("tick %d is before start %d", ms, start)
+	}
+
+	// An untaken tick is held, later ones are dropped
+	time.Sleep(50 * time.Millisecond)
+	if n := ticks.Len(); n != 1 {
+		t.Errorf("expected 1 pending tick, got %d", n)
+	}
+}
+
+func TestSignals(t *testing.T) {
+	if _, err := Signals("SIGNOPE"); err == nil {
+		t.Error("expected error for unknown signal")
+	}
+	if _, ok := LookupSignal("term"); !ok {
+		t.Error("expected LookupSignal to accept a name without SIG")
+	}
+
+}
@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ============================================================================
+// Spawn groups (@spawn wait)
+//
+// A SpawnGroup counts running tasks like sync.WaitGroup, but Wait can give
+// up after a timeout and Pending reports how many tasks are left. The zero
+// value is an empty group ready to use.
+// ============================================================================
+
+// ErrWaitTimeout is returned by SpawnGroup.Wait when the timeout expires
+// before every task has finished.
+var ErrWaitTimeout = errors.New("spawn wait timeout")
+
+// SpawnGroup waits for a collection of tasks to finish.
+type SpawnGroup struct {
+	mu   sync.Mutex
+	n    int
+	zero chan struct{} // closed when n returns to 0; nil while n == 0
+}
+
+// Add adds delta, which may be negative, to the number of running tasks.
+// A negative total panics, as with sync.WaitGroup.
+func (g *SpawnGroup) Add(delta int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.n == 0 && delta > 0 {
+		g.zero = make(chan struct{})
+	}
+	g.n += delta
+	if g.n < 0 {
+		panic("runtime: negative SpawnGroup counter")
+	}
+	if g.n == 0 && g.zero != nil {
+		close(g.zero)
+		g.zero = nil
+	}
+}
+
+// Done marks one task as finished.
+func (g *SpawnGroup) Done() {
+	g.Add(-1)
+}
+
+// Pending returns the number of tasks still running.
+func (g *SpawnGroup) Pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.n
+}
+
+// Wait blocks until every task has finished. Optional timeout in
+// milliseconds (0 = wait forever); ErrWaitTimeout if it expires first.
+func (g *SpawnGroup) Wait(timeoutMs ...int64) error {
+	g.mu.Lock()
+	zero := g.zero
+	g.mu.Unlock()
+	if zero == nil {
+		return nil
+	}
+
+	timeout := int64(0)
+	if len(timeoutMs) > 0 {
+		timeout = timeoutMs[0]
+	}
+	if timeout <= 0 {
+		<-zero
+		return nil
+	}
+
+	expired, stop := After(timeout)
+	defer stop()
+	select {
+	case <-zero:
+		return nil
+	case <-expired:
+		return ErrWaitTimeout
+	}
+}
+
+// ============================================================================
+// Spawn results (@spawn < { ... return x } into @results)
+// ============================================================================
+
+// ErrNoResult is reported when a task with a result stack finishes without
+// returning a value.
+var ErrNoResult = errors.New("spawn task ended without a result")
+
+// RunForResult runs the body of a task declared with "into @results". The
+// value fn returns is pushed to results. If fn panics, ends without a
+// result (ok is false), or results refuses the push, the error is pushed
+// to errStack instead, when it is not nil.
+func RunForResult(fn func() (value []byte, ok bool), results, errStack *Stack) {
+	err := runForResult(fn, results)
+	if err != nil && errStack != nil {
+		errStack.Push([]byte("spawn: " + err.Error()))
+	}
+}
+
+func runForResult(fn func() ([]byte, bool), results *Stack) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	value, ok := fn()
+	if !ok {
+		return ErrNoResult
+	}
+	return results.Push(value)
+}
@@ -0,0 +1,91 @@
+package runtime
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpawnGroupWait(t *testing.T) {
+	var g SpawnGroup
+	if err := g.Wait(); err != nil {
+		t.Fatalf("empty group: %v", err)
+	}
+
+	var n atomic.Int64
+	for i := 0; i < 10; i++ {
+		g.Add(1)
+		go func() {
+			defer g.Done()
+			time.Sleep(time.Millisecond)
+			n.Add(1)
+		}()
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if n.Load() != 10 || g.Pending() != 0 {
+		t.Errorf("after Wait: ran %d, pending %d", n.Load(), g.Pending())
+	}
+
+	// The group can be reused once empty
+	g.Add(1)
+	go g.Done()
+	if err := g.Wait(1000); err != nil {
+		t.Errorf("reused group: %v", err)
+	}
+}
+
+func TestSpawnGroupTimeout(t *testing.T) {
+	var g SpawnGroup
+	release := make(chan struct{})
+	g.Add(2)
+	go func() { <-release; g.Done() }()
+	go func() { <-release; g.Done() }()
+
+	if err := g.Wait(10); err != ErrWaitTimeout {
+		t.Errorf("blocked tasks: got %v, want ErrWaitTimeout", err)
+	}
+	if p := g.Pending(); p != 2 {
+		t.Errorf("pending: got %d, want 2", p)
+	}
+	close(release)
+	if err := g.Wait(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSpawnGroupNegative(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on negative counter")
+		}
+	}()
+	var g SpawnGroup
+	g.Done()
+}
+
+func TestRunForResult(t *testing.T) {
+	results := NewStack(FIFO, TypeInt64)
+	errs := NewStack(FIFO, TypeBytes)
+
+	RunForResult(func() ([]byte, bool) { return intToBytes(42), true }, results, errs)
+	RunForResult(func() ([]byte, bool) { return nil, false }, results, errs)
+	RunForResult(func() ([]byte, bool) { panic("boom") }, results, errs)
+
+	if v, err := results.Pop(); err != nil || bytesToInt(v) != 42 {
+		t.Errorf("result: got %v, %v; want 42", v, err)
+	}
+	if results.Len() != 0 {
+		t.Errorf("failed tasks pushed %d results", results.Len())
+	}
+	for _, want := range []string{"spawn: " + ErrNoResult.Error(), "spawn: boom"} {
+		msg, err := errs.Pop()
+		if err != nil || string(msg) != want {
+			t.Errorf("error: got %q, %v; want %q", msg, err, want)
+		}
+	}
+
+	// A nil error stack drops failures
+	RunForResult(func() ([]byte, bool) { return nil, false }, results, nil)
+}
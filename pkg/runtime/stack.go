@@ -17,6 +17,7 @@ const (
 	FIFO
 	Indexed
 	Hash
+	Broadcast // fan-out: each Broadcast view reads every push (see broadcast.go)
 )
 
 // ElementType represents the type property of a container
@@ -29,6 +30,9 @@ const (
 	TypeString
 	TypeBytes
 	TypeBool
+	TypeInt32   // stored in 4 bytes, see compact.go
+	TypeUint32  // stored in 4 bytes
+	TypeFloat32 // stored in 4 bytes
 )
 
 // Element wraps raw bytes with type awareness
@@ -42,7 +46,7 @@ type Stack struct {
 	cond        *sync.Cond   // for blocking take
 	perspective Perspective
 	elementType ElementType
-	frozen      bool
+	frozen      FreezeMode // changes rejected, see freeze.go
 	capacity    int // 0 = unlimited
 	closed      bool // when true, take returns immediately
 	
@@ -55,6 +59,22 @@ type Stack struct {
 	
 	// Position tracking for FIFO (head points to first valid element)
 	head int
+	
+	// Structural version: bumped whenever elements are added, removed or
+	// moved. Views compare it against the version they last synced to.
+	version uint64
+	
+	// Broadcast perspective only: the sequence number of elements[head],
+	// and the next sequence number each subscribed view will read
+	seq  uint64
+	subs map[*View]uint64
+	
+	// Test doubles (Mock): pushes go to sent, or nowhere if it is nil
+	mocked bool
+	sent   *Stack
+	
+	// Bridge (bridge.go): pushes are also sent through it
+	bridge *stackBridge
 }
 
 // NewStack creates a stack with given perspective and element type
@@ -107,25 +127,42 @@ func (s *Stack) IsFull() bool {
 func (s *Stack) Push(value []byte, key ...[]byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.push(value, key...)
+}
+
+// push is Push without locking. Caller holds s.mu.
+func (s *Stack) push(value []byte, key ...[]byte) error {
+	var k []byte
+	if len(key) > 0 {
+		k = key[0]
+	}
+	if !s.allowsSet(k) {
+		return ErrFrozen
+	}
+	
+	if s.mocked {
+		return s.pushSent(value)
+	}
 	
-	if s.frozen {
-		return errors.New("stack is frozen")
+	if s.perspective == Broadcast && len(s.subs) == 0 {
+		return nil // nobody listening
 	}
 	
 	if s.capacity > 0 && len(s.elements)-s.head >= s.capacity {
-		return errors.New("stack is full")
+		return ErrFull
 	}
 	
-	elem := Element{data: value}
+	elem := Element{data: s.pack(value)}
 	
 	switch s.perspective {
-	case LIFO, FIFO, Indexed:
+	case LIFO, FIFO, Indexed, Broadcast:
 		s.elements = append(s.elements, elem)
 		s.keys = append(s.keys, nil) // no key for positional
+		s.version++
 		
 	case Hash:
 		if len(key) == 0 {
-			return errors.New("hash perspective requires key")
+			return ErrKeyRequired
 		}
 		k := key[0]
 		keyStr := string(k)
@@ -142,8 +179,12 @@ func (s *Stack) Push(value []byte, key ...[]byte) error {
 		s.elements = append(s.elements, elem)
 		s.keys = append(s.keys, k)
 		s.hashIdx[keyStr] = idx
+		s.version++
 	}
 	
+	if s.bridge != nil {
+		s.bridge.send(value)
+	}
 	s.cond.Broadcast() // wake all waiters
 	return nil
 }
@@ -152,14 +193,21 @@ func (s *Stack) Push(value []byte, key ...[]byte) error {
 func (s *Stack) Pop(param ...[]byte) ([]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
-	if s.frozen {
-		return nil, errors.New("stack is frozen")
+	return s.pop(param...)
+}
+
+// pop is Pop without locking. Caller holds s.mu.
+func (s *Stack) pop(param ...[]byte) ([]byte, error) {
+	if !s.allows(freezeRemove) {
+		return nil, ErrFrozen
+	}
+	if s.perspective == Broadcast {
+		return nil, errBroadcastRead
 	}
 	
 	size := len(s.elements) - s.head
 	if size == 0 {
-		return nil, errors.New("stack empty")
+		return nil, ErrEmpty
 	}
 	
 	var elem Element
@@ -172,7 +220,7 @@ func (s *Stack) Pop(param ...[]byte) ([]byte, error) {
 			offset := bytesToInt(param[0])
 			idx = len(s.elements) - 1 - int(offset)
 			if idx < s.head || idx >= len(s.elements) {
-				return nil, errors.New("index out of bounds")
+				return nil, ErrOutOfBounds
 			}
 			// Non-default pop requires shift
 			elem = s.elements[idx]
@@ -191,7 +239,7 @@ func (s *Stack) Pop(param ...[]byte) ([]byte, error) {
 			offset := bytesToInt(param[0])
 			idx = s.head + int(offset)
 			if idx < s.head || idx >= len(s.elements) {
-				return nil, errors.New("index out of bounds")
+				return nil, ErrOutOfBounds
 			}
 			// Non-default pop requires shift
 			elem = s.elements[idx]
@@ -216,7 +264,7 @@ func (s *Stack) Pop(param ...[]byte) ([]byte, error) {
 			idx = s.head + int(bytesToInt(param[0]))
 		}
 		if idx < s.head || idx >= len(s.elements) {
-			return nil, errors.New("index out of bounds")
+			return nil, ErrOutOfBounds
 		}
 		elem = s.elements[idx]
 		s.elements = append(s.elements[:idx], s.elements[idx+1:]...)
@@ -225,12 +273,12 @@ func (s *Stack) Pop(param ...[]byte) ([]byte, error) {
 	case Hash:
 		// No default, key required
 		if len(param) == 0 {
-			return nil, errors.New("hash perspective requires key")
+			return nil, ErrKeyRequired
 		}
 		keyStr := string(param[0])
 		idx, exists := s.hashIdx[keyStr]
 		if !exists {
-			return nil, errors.New("key not found")
+			return nil, ErrKeyNotFound
 		}
 		elem = s.elements[idx]
 		// Remove from hash index
@@ -241,17 +289,26 @@ func (s *Stack) Pop(param ...[]byte) ([]byte, error) {
 		// Could compact periodically, but for now just leave tombstones
 	}
 	
-	return elem.data, nil
+	s.version++
+	return s.unpack(elem.data), nil
 }
 
 // Peek returns element without removing it
 func (s *Stack) Peek(param ...[]byte) ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.peek(param...)
+}
+
+// peek is Peek without locking. Caller holds s.mu.
+func (s *Stack) peek(param ...[]byte) ([]byte, error) {
+	if s.perspective == Broadcast {
+		return nil, errBroadcastRead
+	}
 	
 	size := len(s.elements) - s.head
 	if size == 0 {
-		return nil, errors.New("stack empty")
+		return nil, ErrEmpty
 	}
 	
 	var idx int
@@ -279,21 +336,21 @@ func (s *Stack) Peek(param ...[]byte) ([]byte, error) {
 		
 	case Hash:
 		if len(param) == 0 {
-			return nil, errors.New("hash perspective requires key")
+			return nil, ErrKeyRequired
 		}
 		keyStr := string(param[0])
 		var exists bool
 		idx, exists = s.hashIdx[keyStr]
 		if !exists {
-			return nil, errors.New("key not found")
+			return nil, ErrKeyNotFound
 		}
 	}
 	
 	if idx < s.head || idx >= len(s.elements) {
-		return nil, errors.New("index out of bounds")
+		return nil, ErrOutOfBounds
 	}
 	
-	return s.elements[idx].data, nil
+	return s.unpack(s.elements[idx].data), nil
 }
 
 // =============================================================================
@@ -320,6 +377,12 @@ func (s *Stack) Unlock() {
 // UNSAFE: Caller must hold s.mu.Lock() before calling.
 // Used by generated compute block code.
 func (s *Stack) PopRaw() ([]byte, error) {
+	if s.perspective == Broadcast {
+		return nil, errBroadcastRead
+	}
+	if !s.allows(freezeRemove) {
+		return nil, ErrFrozen
+	}
 	size := len(s.elements) - s.head
 	if size == 0 {
 		return nil, errors.New("stack underflow in compute")
@@ -347,6 +410,7 @@ func (s *Stack) PopRaw() ([]byte, error) {
 	} else {
 		s.elements = s.elements[:idx]
 	}
+	s.version++
 
 	return elem.data, nil
 }
@@ -355,11 +419,21 @@ func (s *Stack) PopRaw() ([]byte, error) {
 // UNSAFE: Caller must hold s.mu.Lock() before calling.
 // Used by generated compute block code.
 func (s *Stack) PushRaw(value []byte) error {
+	if !s.allows(freezeAdd) {
+		return ErrFrozen
+	}
+	if s.mocked {
+		return s.pushSent(value)
+	}
 	if s.capacity > 0 && len(s.elements)-s.head >= s.capacity {
 		return errors.New("stack full in compute")
 	}
 	s.elements = append(s.elements, Element{data: value})
 	s.keys = append(s.keys, nil) // maintain key slice alignment
+	s.version++
+	if s.bridge != nil {
+		s.bridge.send(s.unpack(value))
+	}
 	return nil
 }
 
@@ -371,6 +445,9 @@ func (s *Stack) SetRaw(key string, value []byte) error {
 	if s.perspective != Hash {
 		return errors.New("SetRaw only valid for Hash perspective")
 	}
+	if !s.allowsSet([]byte(key)) {
+		return ErrFrozen
+	}
 	elem := Element{data: value}
 	
 	// Check if key exists - update in place
@@ -384,6 +461,7 @@ func (s *Stack) SetRaw(key string, value []byte) error {
 	s.elements = append(s.elements, elem)
 	s.keys = append(s.keys, []byte(key))
 	s.hashIdx[key] = idx
+	s.version++
 	return nil
 }
 
@@ -420,20 +498,106 @@ func (s *Stack) GetAtRaw(index int) ([]byte, bool) {
 	return s.elements[idx].data, true
 }
 
+// Has reports whether a Hash stack holds key. Other perspectives have no
+// keys and return false.
+func (s *Stack) Has(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.has(key)
+}
+
+// has is Has without locking. Caller holds s.mu.
+func (s *Stack) has(key string) bool {
+	if s.perspective != Hash {
+		return false
+	}
+	_, exists := s.hashIdx[key]
+	return exists
+}
+
+// Delete removes key and its value from a Hash stack and reports whether it
+// was there. Deleting a missing key is not an error. Unlike Pop, Delete
+// leaves no tombstone, so Len drops and the other keys keep their order.
+func (s *Stack) Delete(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteKey(key)
+}
+
+// deleteKey is Delete without locking. Caller holds s.mu.
+func (s *Stack) deleteKey(key string) (bool, error) {
+	if s.perspective != Hash {
+		return false, errors.New("delete requires a Hash stack")
+	}
+	if !s.allows(freezeRemove) {
+		return false, ErrFrozen
+	}
+	idx, exists := s.hashIdx[key]
+	if !exists {
+		return false, nil
+	}
+	s.elements = append(s.elements[:idx], s.elements[idx+1:]...)
+	s.keys = append(s.keys[:idx], s.keys[idx+1:]...)
+	s.reindex()
+	s.version++
+	return true, nil
+}
+
+// Keys returns the keys of a Hash stack in the order they were first set.
+// Updating a key keeps its place; a popped key that is set again goes to
+// the end. Other perspectives have no keys and return nil.
+func (s *Stack) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keyList()
+}
+
+// keyList is Keys without locking. Caller holds s.mu.
+func (s *Stack) keyList() []string {
+	if s.perspective != Hash {
+		return nil
+	}
+	keys := make([]string, 0, len(s.hashIdx))
+	for i := s.head; i < len(s.keys); i++ {
+		if s.keys[i] != nil {
+			keys = append(keys, string(s.keys[i]))
+		}
+	}
+	return keys
+}
+
 // Len returns number of elements
 func (s *Stack) Len() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.size()
+}
+
+// size is Len without locking. Caller holds s.mu.
+func (s *Stack) size() int {
 	return len(s.elements) - s.head
 }
 
-// Clear removes all elements from the stack
+// Clear removes all elements from the stack. It does nothing to a stack
+// frozen against removal.
 func (s *Stack) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.clear()
+}
+
+// clear is Clear without locking. Caller holds s.mu.
+func (s *Stack) clear() {
+	if !s.allows(freezeRemove) {
+		return
+	}
+	if s.perspective == Broadcast {
+		s.seq = s.broadcastEnd() // subscribers skip what was cleared
+	}
 	s.elements = s.elements[:0]
 	s.keys = s.keys[:0]
 	s.head = 0
+	s.version++
 	if s.perspective == Hash {
 		s.hashIdx = make(map[string]int)
 	}
@@ -444,18 +608,29 @@ func (s *Stack) Clear() {
 func (s *Stack) PushAt(index int, value []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
-	if s.frozen {
-		return errors.New("stack is frozen")
+	return s.pushAt(index, value)
+}
+
+// pushAt is PushAt without locking. Caller holds s.mu.
+func (s *Stack) pushAt(index int, value []byte) error {
+	change := freezeUpdate
+	if len(s.elements) <= index {
+		change = freezeAdd
+	}
+	if !s.allows(change) {
+		return ErrFrozen
 	}
 	
 	// Extend if needed
+	if len(s.elements) <= index {
+		s.version++
+	}
 	for len(s.elements) <= index {
 		s.elements = append(s.elements, Element{})
 		s.keys = append(s.keys, nil)
 	}
 	
-	s.elements[index] = Element{data: value}
+	s.elements[index] = Element{data: s.pack(value)}
 	return nil
 }
 
@@ -464,12 +639,25 @@ func (s *Stack) PushAt(index int, value []byte) error {
 func (s *Stack) PeekAt(index int) ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	
-	if index < 0 || index >= len(s.elements) {
-		return nil, errors.New("index out of bounds")
+	return s.peekAt(index)
+}
+
+// peekAt is PeekAt without locking. Caller holds s.mu.
+func (s *Stack) peekAt(index int) ([]byte, error) {
+	// Relative to head, so popped FIFO elements are not seen
+	idx := s.head + index
+	if index < 0 || idx >= len(s.elements) {
+		return nil, ErrOutOfBounds
 	}
 	
-	return s.elements[index].data, nil
+	return s.unpack(s.elements[idx].data), nil
+}
+
+// Perspective returns how the stack is accessed
+func (s *Stack) Perspective() Perspective {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.perspective
 }
 
 // SetPerspective changes how the stack is accessed
@@ -479,6 +667,7 @@ func (s *Stack) SetPerspective(p Perspective) {
 	
 	oldPerspective := s.perspective
 	s.perspective = p
+	s.version++
 	
 	// If switching to hash from non-hash, we need keys
 	// This is a problem - elements don't have keys yet
@@ -513,21 +702,29 @@ func (s *Stack) compact() {
 	s.elements = s.elements[s.head:]
 	s.keys = s.keys[s.head:]
 	s.head = 0
+	s.version++
 }
 
 // Freeze makes the stack immutable. Peek, Walk still work. Push, Pop will error.
+// It is FreezeWith(FreezeAll).
 func (s *Stack) Freeze() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.compact()
-	s.frozen = true
+	s.FreezeWith(FreezeAll)
 }
 
-// IsFrozen returns whether the stack is immutable
+// Version returns the stack's structural version. It changes whenever
+// elements are added, removed or moved, but not when a Hash value is
+// updated in place.
+func (s *Stack) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// IsFrozen returns whether the stack is frozen in any mode
 func (s *Stack) IsFrozen() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.frozen
+	return s.frozen != 0
 }
 
 // Helper: bytes to int64
@@ -563,39 +760,62 @@ func (s *Stack) Take(timeoutMs ...int64) ([]byte, error) {
 	if len(timeoutMs) > 0 {
 		timeout = timeoutMs[0]
 	}
+	return s.take(context.Background(), timeout, afterFunc)
+}
+
+// take is Take that also gives up when ctx is done, with the clock its
+// timeout runs on. It never removes an element it cannot return.
+func (s *Stack) take(ctx context.Context, timeout int64, after func(time.Duration, func()) func()) ([]byte, error) {
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		defer stop()
+	}
 	
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
+	if s.perspective == Broadcast {
+		return nil, errBroadcastRead
+	}
+	if !s.allows(freezeRemove) {
+		return nil, ErrFrozen
+	}
+	
 	// Set up timeout if specified
 	var timedOut bool
-	var timer *time.Timer
 	if timeout > 0 {
-		timer = time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+		stop := after(time.Duration(timeout)*time.Millisecond, func() {
 			s.mu.Lock()
 			timedOut = true
 			s.cond.Broadcast() // wake to check timeout
 			s.mu.Unlock()
 		})
-		defer timer.Stop()
+		defer stop()
 	}
 	
 	// Wait loop - condvar pattern with Broadcast for robustness
-	for len(s.elements)-s.head == 0 && !s.closed && !timedOut {
+	for len(s.elements)-s.head == 0 && !s.closed && !timedOut && ctx.Err() == nil {
 		s.cond.Wait() // atomically: unlock, wait, re-lock
 	}
 	
 	// Check why we woke up
+	if ctx.Err() != nil {
+		return nil, ErrCancelled
+	}
 	if timedOut {
-		return nil, errors.New("take timeout")
+		return nil, ErrTimeout
 	}
 	
 	if s.closed && len(s.elements)-s.head == 0 {
-		return nil, errors.New("stack closed")
+		return nil, ErrClosed
 	}
 	
 	// We have an element - take it
-	return s.popElement().data, nil
+	return s.unpack(s.popElement().data), nil
 }
 
 // popElement removes and returns an element (must hold lock)
@@ -620,62 +840,30 @@ func (s *Stack) popElement() Element {
 		s.elements = s.elements[:idx]
 		s.keys = s.keys[:idx]
 	}
+	s.version++
 	return elem
 }
 
 // TakeWithContext removes and returns an element, blocking until one is available
 // or the context is cancelled. Optional timeout in milliseconds (0 = no timeout, just context).
 // Returns nil, error if context is cancelled, stack is closed, or timeout.
+// A take that is cancelled leaves the stack as it was.
 func (s *Stack) TakeWithContext(ctx context.Context, timeoutMs int64) ([]byte, error) {
-	// Create a channel to receive the result
-	type result struct {
-		data []byte
-		err  error
-	}
-	resultCh := make(chan result, 1)
-	
-	go func() {
-		if timeoutMs > 0 {
-			data, err := s.Take(timeoutMs)
-			resultCh <- result{data, err}
-		} else {
-			// Use a very long timeout to allow context cancellation to work
-			// Check periodically
-			for {
-				// Try take with short timeout
-				data, err := s.Take(100) // 100ms check interval
-				if err == nil {
-					resultCh <- result{data, nil}
-					return
-				}
-				if err.Error() != "take timeout" {
-					// Real error (closed, etc)
-					resultCh <- result{nil, err}
-					return
-				}
-				// It was a timeout, check if context is done
-				select {
-				case <-ctx.Done():
-					resultCh <- result{nil, errors.New("cancelled")}
-					return
-				default:
-					// Continue waiting
-				}
-			}
-		}
-	}()
-	
-	select {
-	case <-ctx.Done():
-		return nil, errors.New("cancelled")
-	case r := <-resultCh:
-		if r.err != nil && r.err.Error() == "take timeout" {
-			return nil, errors.New("timeout")
-		}
-		return r.data, r.err
+	data, err := s.take(ctx, timeoutMs, afterFunc)
+	if err == ErrTimeout {
+		return nil, contextTimeout{}
 	}
+	return data, err
 }
 
+// contextTimeout is ErrTimeout as TakeWithContext reports it. Generated
+// select code compares its message with "timeout", so the message must
+// stay as it is; errors.Is(err, ErrTimeout) also matches it.
+type contextTimeout struct{}
+
+func (contextTimeout) Error() string        { return "timeout" }
+func (contextTimeout) Is(target error) bool { return target == ErrTimeout }
+
 // Close signals that no more data will be pushed.
 // Wakes all waiting Take calls.
 func (s *Stack) Close() {
@@ -40,12 +40,12 @@ func (s *Int64Stack) Push(value int64) error {
 	
 	if s.frozen {
 		s.mu.Unlock()
-		return errors.New("stack is frozen")
+		return ErrFrozen
 	}
 	
 	if s.capacity > 0 && len(s.elements)-s.head >= s.capacity {
 		s.mu.Unlock()
-		return errors.New("stack is full")
+		return ErrFull
 	}
 	
 	s.elements = append(s.elements, value)
@@ -59,13 +59,13 @@ func (s *Int64Stack) Pop() (int64, error) {
 	
 	if s.frozen {
 		s.mu.Unlock()
-		return 0, errors.New("stack is frozen")
+		return 0, ErrFrozen
 	}
 	
 	size := len(s.elements) - s.head
 	if size == 0 {
 		s.mu.Unlock()
-		return 0, errors.New("stack empty")
+		return 0, ErrEmpty
 	}
 	
 	var value int64
@@ -95,7 +95,7 @@ func (s *Int64Stack) Peek() (int64, error) {
 	size := len(s.elements) - s.head
 	if size == 0 {
 		s.mu.RUnlock()
-		return 0, errors.New("stack empty")
+		return 0, ErrEmpty
 	}
 	
 	var value int64
@@ -122,7 +122,7 @@ func (s *Int64Stack) PeekAt(offset int) (int64, error) {
 	
 	if idx < s.head || idx >= len(s.elements) {
 		s.mu.RUnlock()
-		return 0, errors.New("index out of bounds")
+		return 0, ErrOutOfBounds
 	}
 	
 	value := s.elements[idx]
@@ -215,7 +215,7 @@ func (v *Int64View) Peek() (int64, error) {
 	v.mu.RLock()
 	if v.stack == nil {
 		v.mu.RUnlock()
-		return 0, errors.New("view not attached")
+		return 0, ErrNotAttached
 	}
 	
 	v.stack.mu.RLock()
@@ -224,7 +224,7 @@ func (v *Int64View) Peek() (int64, error) {
 	if size == 0 {
 		v.stack.mu.RUnlock()
 		v.mu.RUnlock()
-		return 0, errors.New("stack empty")
+		return 0, ErrEmpty
 	}
 	
 	var idx int
@@ -251,7 +251,7 @@ func (v *Int64View) Pop() (int64, error) {
 	v.mu.Lock()
 	if v.stack == nil {
 		v.mu.Unlock()
-		return 0, errors.New("view not attached")
+		return 0, ErrNotAttached
 	}
 	
 	v.stack.mu.Lock()
@@ -259,14 +259,14 @@ func (v *Int64View) Pop() (int64, error) {
 	if v.stack.frozen {
 		v.stack.mu.Unlock()
 		v.mu.Unlock()
-		return 0, errors.New("stack is frozen")
+		return 0, ErrFrozen
 	}
 	
 	size := len(v.stack.elements) - v.stack.head
 	if size == 0 {
 		v.stack.mu.Unlock()
 		v.mu.Unlock()
-		return 0, errors.New("stack empty")
+		return 0, ErrEmpty
 	}
 	
 	var value int64
@@ -295,7 +295,7 @@ func (v *Int64View) Advance() error {
 	v.mu.Lock()
 	if v.stack == nil {
 		v.mu.Unlock()
-		return errors.New("view not attached")
+		return ErrNotAttached
 	}
 	v.cursor++
 	v.mu.Unlock()
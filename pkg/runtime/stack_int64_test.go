@@ -2,6 +2,8 @@ package runtime
 
 import (
 	"testing"
+
+	"github.com/ha1tch/ual/pkg/runtime/internal/deque"
 )
 
 // ============================================================
@@ -272,7 +274,7 @@ func BenchmarkInt64Stack_Allocs(b *testing.B) {
 // ============================================================
 
 func BenchmarkFastInt64Stack_Push(b *testing.B) {
-	s := NewFastInt64Stack(b.N + 100)
+	s := deque.NewFastInt64Stack(b.N + 100)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		s.Push(int64(i))
@@ -280,7 +282,7 @@ func BenchmarkFastInt64Stack_Push(b *testing.B) {
 }
 
 func BenchmarkFastInt64Stack_Pop(b *testing.B) {
-	s := NewFastInt64Stack(b.N + 100)
+	s := deque.NewFastInt64Stack(b.N + 100)
 	for i := 0; i < b.N; i++ {
 		s.Push(int64(i))
 	}
@@ -291,7 +293,7 @@ func BenchmarkFastInt64Stack_Pop(b *testing.B) {
 }
 
 func BenchmarkFastInt64Stack_PushPop(b *testing.B) {
-	s := NewFastInt64Stack(1000)
+	s := deque.NewFastInt64Stack(1000)
 	for i := 0; i < 500; i++ {
 		s.Push(int64(i))
 	}
@@ -307,7 +309,7 @@ func BenchmarkFastInt64Stack_PushPop(b *testing.B) {
 // ============================================================
 
 func BenchmarkWorkStealingDeque_Push(b *testing.B) {
-	d := NewWorkStealingDeque(b.N + 100)
+	d := deque.NewInt64Deque(b.N + 100)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		d.Push(int64(i))
@@ -315,7 +317,7 @@ func BenchmarkWorkStealingDeque_Push(b *testing.B) {
 }
 
 func BenchmarkWorkStealingDeque_Pop(b *testing.B) {
-	d := NewWorkStealingDeque(b.N + 100)
+	d := deque.NewInt64Deque(b.N + 100)
 	for i := 0; i < b.N; i++ {
 		d.Push(int64(i))
 	}
@@ -326,7 +328,7 @@ func BenchmarkWorkStealingDeque_Pop(b *testing.B) {
 }
 
 func BenchmarkWorkStealingDeque_Steal(b *testing.B) {
-	d := NewWorkStealingDeque(b.N + 100)
+	d := deque.NewInt64Deque(b.N + 100)
 	for i := 0; i < b.N; i++ {
 		d.Push(int64(i))
 	}
@@ -337,7 +339,7 @@ func BenchmarkWorkStealingDeque_Steal(b *testing.B) {
 }
 
 func BenchmarkWorkStealingDeque_OwnerThief(b *testing.B) {
-	d := NewWorkStealingDeque(10000)
+	d := deque.NewInt64Deque(10000)
 	for i := 0; i < 1000; i++ {
 		d.Push(int64(i))
 	}
@@ -351,7 +353,7 @@ func BenchmarkWorkStealingDeque_OwnerThief(b *testing.B) {
 }
 
 func BenchmarkWorkStealingDeque_Parallel(b *testing.B) {
-	d := NewWorkStealingDeque(100000)
+	d := deque.NewInt64Deque(100000)
 	for i := 0; i < 50000; i++ {
 		d.Push(int64(i))
 	}
@@ -393,7 +395,7 @@ func BenchmarkAllImpls_PushPop(b *testing.B) {
 	})
 	
 	b.Run("FastInt64", func(b *testing.B) {
-		s := NewFastInt64Stack(1000)
+		s := deque.NewFastInt64Stack(1000)
 		for i := 0; i < 500; i++ {
 			s.Push(int64(i))
 		}
@@ -405,7 +407,7 @@ func BenchmarkAllImpls_PushPop(b *testing.B) {
 	})
 	
 	b.Run("Deque", func(b *testing.B) {
-		d := NewWorkStealingDeque(1000)
+		d := deque.NewInt64Deque(1000)
 		for i := 0; i < 500; i++ {
 			d.Push(int64(i))
 		}
@@ -455,7 +457,7 @@ func BenchmarkAllImpls_WorkSteal(b *testing.B) {
 	})
 	
 	b.Run("Deque", func(b *testing.B) {
-		d := NewWorkStealingDeque(10000)
+		d := deque.NewInt64Deque(10000)
 		for i := 0; i < 1000; i++ {
 			d.Push(int64(i))
 		}
@@ -471,7 +473,7 @@ func BenchmarkAllImpls_WorkSteal(b *testing.B) {
 
 // Sustained throughput: producer keeps queue filled
 func BenchmarkWorkStealingDeque_SustainedSteal(b *testing.B) {
-	d := NewWorkStealingDeque(10000)
+	d := deque.NewInt64Deque(10000)
 	
 	// Producer goroutine keeps it filled
 	done := make(chan bool)
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"errors"
 	"runtime"
 	"testing"
 	"time"
@@ -61,6 +62,28 @@ func TestFIFOStack(t *testing.T) {
 	if bytesToInt(val) != 20 {
 		t.Errorf("expected 20, got %d", bytesToInt(val))
 	}
+	
+	// Indexes start at the head, past the popped elements
+	val, err = s.PeekAt(0)
+	if err != nil || bytesToInt(val) != 30 {
+		t.Errorf("PeekAt(0) = %d, %v; want 30", bytesToInt(val), err)
+	}
+	if _, err := s.PeekAt(1); err == nil {
+		t.Error("PeekAt(1) should be out of bounds")
+	}
+	
+	vs := NewValueStack(FIFO)
+	vs.Push(NewInt(1))
+	vs.Push(NewInt(2))
+	vs.Push(NewInt(3))
+	vs.Pop()
+	all := vs.All()
+	if len(all) != 2 || all[0].AsInt() != 2 || all[1].AsInt() != 3 {
+		t.Errorf("All() = %v, want [2 3]", all)
+	}
+	if v, _ := vs.Pop(); v.AsInt() != 2 {
+		t.Errorf("All() reordered the stack: popped %d, want 2", v.AsInt())
+	}
 }
 
 func TestIndexedStack(t *testing.T) {
@@ -136,6 +159,65 @@ func TestHashStack(t *testing.T) {
 	}
 }
 
+func TestHashKeys(t *testing.T) {
+	s := NewStack(Hash, TypeInt64)
+	s.Push(intToBytes(1), []byte("zeta"))
+	s.Push(intToBytes(2), []byte("alpha"))
+	s.Push(intToBytes(3), []byte("mid"))
+	s.Push(intToBytes(4), []byte("zeta")) // update keeps its place
+	s.Pop([]byte("alpha"))
+	s.SetRaw("alpha", intToBytes(5)) // set again: goes to the end
+
+	got := s.Keys()
+	want := []string{"zeta", "mid", "alpha"}
+	if len(got) != len(want) {
+		t.Fatalf("Keys() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Keys() = %v, want %v", got, want)
+		}
+	}
+
+	if keys := NewStack(LIFO, TypeInt64).Keys(); keys != nil {
+		t.Errorf("LIFO Keys() = %v, want nil", keys)
+	}
+}
+
+func TestHashDeleteHas(t *testing.T) {
+	s := NewStack(Hash, TypeInt64)
+	s.Push(intToBytes(1), []byte("a"))
+	s.Push(intToBytes(2), []byte("b"))
+	s.Push(intToBytes(3), []byte("c"))
+
+	if !s.Has("b") || s.Has("missing") {
+		t.Fatal("Has before delete")
+	}
+	if ok, err := s.Delete("b"); !ok || err != nil {
+		t.Fatalf("Delete(b) = %v, %v", ok, err)
+	}
+	if ok, err := s.Delete("b"); ok || err != nil {
+		t.Fatalf("second Delete(b) = %v, %v", ok, err)
+	}
+	if s.Has("b") || s.Len() != 2 {
+		t.Errorf("after delete: Has(b) = %v, Len = %d", s.Has("b"), s.Len())
+	}
+	if v, err := s.Peek([]byte("c")); err != nil || bytesToInt(v) != 3 {
+		t.Errorf("Peek(c) = %d, %v", bytesToInt(v), err)
+	}
+	if keys := s.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("Keys() = %v", keys)
+	}
+
+	s.Freeze()
+	if _, err := s.Delete("a"); err == nil {
+		t.Error("expected an error deleting from a frozen stack")
+	}
+	if _, err := NewStack(LIFO, TypeInt64).Delete("a"); err == nil {
+		t.Error("expected an error deleting from a LIFO stack")
+	}
+}
+
 func TestBringSameType(t *testing.T) {
 	src := NewStack(LIFO, TypeInt64)
 	dst := NewStack(LIFO, TypeInt64)
@@ -221,6 +303,50 @@ func TestBringFailsAtomically(t *testing.T) {
 	}
 }
 
+func TestBringWith(t *testing.T) {
+	src := NewStack(FIFO, TypeInt64)
+	dst := NewStack(FIFO, TypeFloat64)
+	src.Push(intToBytes(-1))
+	src.Push(intToBytes(3))
+	
+	double := func(b []byte) ([]byte, error) {
+		return float64ToBytes(float64(bytesToInt(b)) * 2), nil
+	}
+	positive := func(b []byte) bool { return bytesToInt(b) > 0 }
+	
+	// -1 is rejected: taken from the source but not brought
+	if err := dst.BringWith(src, double, positive); err != nil {
+		t.Fatal(err)
+	}
+	if src.Len() != 1 || dst.Len() != 0 {
+		t.Errorf("after rejecting -1: expected 1 and 0 elements, got %d and %d", src.Len(), dst.Len())
+	}
+	
+	if err := dst.BringWith(src, double, positive); err != nil {
+		t.Fatal(err)
+	}
+	val, _ := dst.Pop()
+	if bytesToFloat64(val) != 6 {
+		t.Errorf("expected 6, got %v", bytesToFloat64(val))
+	}
+}
+
+func TestBringWithFailsAtomically(t *testing.T) {
+	src := NewStack(LIFO, TypeInt64)
+	dst := NewStack(LIFO, TypeInt64)
+	src.Push(intToBytes(7))
+	
+	err := dst.BringWith(src, func([]byte) ([]byte, error) {
+		return nil, errors.New("bad element")
+	}, nil)
+	if err == nil || err.Error() != "bring failed: bad element" {
+		t.Errorf("expected the transform's error, got %v", err)
+	}
+	if src.Len() != 1 || dst.Len() != 0 {
+		t.Errorf("a failed transform should change neither stack, got %d and %d", src.Len(), dst.Len())
+	}
+}
+
 func TestPerspectiveSwitch(t *testing.T) {
 	s := NewStack(LIFO, TypeInt64)
 	
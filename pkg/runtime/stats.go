@@ -0,0 +1,74 @@
+package runtime
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+)
+
+// ============================================================================
+// Runtime stats
+//
+// runtime_stats(@health) fills a Hash i64 stack with figures a long-running
+// program can report about itself:
+//
+//   goroutines        goroutines running, including idle pool workers
+//   heap_bytes        bytes of live heap objects
+//   heap_objects      number of live heap objects
+//   gc_count          garbage collections so far
+//   gc_pause_ns       total time the program was paused for them
+//   gc_last_pause_ns  length of the most recent pause
+//   stack.<name>      depth of each global stack
+// ============================================================================
+
+// Stat is one named figure from RuntimeStats
+type Stat struct {
+	Name  string
+	Value int64
+}
+
+// RuntimeStats returns the current figures, in the order listed above, with
+// the stacks in name order. It briefly stops the world to read the heap, so
+// call it every few seconds rather than in a tight loop.
+func RuntimeStats(stacks map[string]*Stack) []Stat {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var lastPause uint64
+	if m.NumGC > 0 {
+		lastPause = m.PauseNs[(m.NumGC+255)%256]
+	}
+
+	stats := []Stat{
+		{"goroutines", int64(runtime.NumGoroutine())},
+		{"heap_bytes", int64(m.HeapAlloc)},
+		{"heap_objects", int64(m.HeapObjects)},
+		{"gc_count", int64(m.NumGC)},
+		{"gc_pause_ns", int64(m.PauseTotalNs)},
+		{"gc_last_pause_ns", int64(lastPause)},
+	}
+	names := make([]string, 0, len(stacks))
+	for name := range stacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats = append(stats, Stat{"stack." + name, int64(stacks[name].Len())})
+	}
+	return stats
+}
+
+// FillRuntimeStats replaces the contents of dst, a Hash stack of i64, with
+// RuntimeStats(stacks)
+func FillRuntimeStats(dst *Stack, stacks map[string]*Stack) error {
+	if dst.Perspective() != Hash {
+		return errors.New("runtime_stats requires a Hash stack")
+	}
+	stats := RuntimeStats(stacks)
+	dst.Clear()
+	for _, st := range stats {
+		if err := dst.SetRaw(st.Name, intToBytes(st.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package runtime
+
+import "testing"
+
+func TestRuntimeStats(t *testing.T) {
+	jobs := NewStack(FIFO, TypeInt64)
+	jobs.Push(intToBytes(1))
+	jobs.Push(intToBytes(2))
+	health := NewStack(Hash, TypeInt64)
+	health.SetRaw("stale", intToBytes(9))
+
+	if err := FillRuntimeStats(health, map[string]*Stack{"jobs": jobs, "health": health}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := health.GetRaw("stale"); ok {
+		t.Error("old keys should be cleared")
+	}
+	for _, key := range []string{"goroutines", "heap_bytes", "heap_objects", "gc_count", "gc_pause_ns", "gc_last_pause_ns"} {
+		if _, ok := health.GetRaw(key); !ok {
+			t.Errorf("missing %s", key)
+		}
+	}
+	if v, _ := health.GetRaw("goroutines"); bytesToInt(v) < 1 {
+		t.Errorf("goroutines = %d", bytesToInt(v))
+	}
+	if v, _ := health.GetRaw("stack.jobs"); bytesToInt(v) != 2 {
+		t.Errorf("stack.jobs = %d, want 2", bytesToInt(v))
+	}
+
+	if err := FillRuntimeStats(NewStack(LIFO, TypeInt64), nil); err == nil {
+		t.Error("expected an error for a non-Hash destination")
+	}
+}
@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// String stack operations
+//
+//   @s concat             "ab" "cd" -> "abcd"
+//   @s split(",")         "a,b" -> "a" "b"
+//   @s strlen             "héllo" -> 5 on @dstack
+//   @s substr(1, 3)       "héllo" -> "éll"
+//   @s contains("ll")     "héllo" -> true on @bool
+//   @s upper  @s lower
+//
+// Each takes the element encoding of a string stack and returns a new
+// slice. Lengths and positions count characters, not bytes, so text that
+// is not ASCII is never cut in the middle of a character.
+// ============================================================================
+
+// StrConcat returns a followed by b
+func StrConcat(a, b []byte) []byte {
+	out := make([]byte, 0, len(a)+len(b))
+	out = append(out, a...)
+	return append(out, b...)
+}
+
+// StrSplit returns the parts of s around each sep, in order. An empty sep
+// splits s into its characters.
+func StrSplit(s []byte, sep string) [][]byte {
+	parts := bytes.Split(s, []byte(sep))
+	for i, p := range parts {
+		parts[i] = bytes.Clone(p)
+	}
+	return parts
+}
+
+// StrLen returns the number of characters in s
+func StrLen(s []byte) int64 {
+	return int64(utf8.RuneCount(s))
+}
+
+// Substr returns n characters of s from character start. A range that
+// runs past either end of s is cut short, so it never fails.
+func Substr(s []byte, start, n int64) []byte {
+	if start < 0 {
+		n += start
+		start = 0
+	}
+	if n <= 0 {
+		return []byte{}
+	}
+	i := 0
+	for ; start > 0 && i < len(s); start-- {
+		_, size := utf8.DecodeRune(s[i:])
+		i += size
+	}
+	j := i
+	for ; n > 0 && j < len(s); n-- {
+		_, size := utf8.DecodeRune(s[j:])
+		j += size
+	}
+	return bytes.Clone(s[i:j])
+}
+
+// StrContains reports whether sub occurs in s
+func StrContains(s []byte, sub string) bool {
+	return bytes.Contains(s, []byte(sub))
+}
+
+// StrUpper returns s with every letter in upper case
+func StrUpper(s []byte) []byte {
+	return bytes.ToUpper(s)
+}
+
+// StrLower returns s with every letter in lower case
+func StrLower(s []byte) []byte {
+	return bytes.ToLower(s)
+}
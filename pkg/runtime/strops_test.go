@@ -0,0 +1,73 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStrSplit(t *testing.T) {
+	tests := []struct {
+		s, sep string
+		want   []string
+	}{
+		{"a,b,,c", ",", []string{"a", "b", "", "c"}},
+		{"abc", ",", []string{"abc"}},
+		{"", ",", []string{""}},
+		{"hé!", "", []string{"h", "é", "!"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range StrSplit([]byte(tt.s), tt.sep) {
+			got = append(got, string(p))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("StrSplit(%q, %q) = %q, want %q", tt.s, tt.sep, got, tt.want)
+		}
+	}
+}
+
+func TestSubstr(t *testing.T) {
+	tests := []struct {
+		s        string
+		start, n int64
+		want     string
+	}{
+		{"héllo", 1, 3, "éll"},
+		{"héllo", 0, 99, "héllo"},
+		{"héllo", 4, 5, "o"},
+		{"héllo", 5, 1, ""},
+		{"héllo", -2, 3, "h"},
+		{"héllo", 2, -1, ""},
+	}
+	for _, tt := range tests {
+		if got := string(Substr([]byte(tt.s), tt.start, tt.n)); got != tt.want {
+			t.Errorf("Substr(%q, %d, %d) = %q, want %q", tt.s, tt.start, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestStrOps(t *testing.T) {
+	if got := string(StrConcat([]byte("ab"), []byte("cd"))); got != "abcd" {
+		t.Errorf("StrConcat = %q", got)
+	}
+	if got := StrLen([]byte("héllo")); got != 5 {
+		t.Errorf("StrLen = %d, want 5", got)
+	}
+	if !StrContains([]byte("héllo"), "ll") || StrContains([]byte("héllo"), "L") {
+		t.Error("StrContains")
+	}
+	if got := string(StrUpper([]byte("héllo"))); got != "HÉLLO" {
+		t.Errorf("StrUpper = %q", got)
+	}
+	if got := string(StrLower([]byte("HÉLLO"))); got != "héllo" {
+		t.Errorf("StrLower = %q", got)
+	}
+
+	// Results never share memory with their input
+	a := []byte("abcd")
+	p := Substr(a, 0, 2)
+	a[0] = 'x'
+	if string(p) != "ab" {
+		t.Errorf("Substr aliases its input: %q", p)
+	}
+}
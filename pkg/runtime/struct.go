@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Struct elements
+//
+//   @points = stack.new({x: f64, y: f64})
+//
+// A struct element is its fields packed in declaration order, each encoded
+// as on a stack of its own type: 8 bytes for i64, u64 and f64 and 1 byte
+// for bool. The stack itself holds TypeBytes elements of StructType.Size.
+// ============================================================================
+
+// StructField is one field of a struct element
+type StructField struct {
+	Name   string
+	Type   ElementType
+	Offset int // set by NewStructType
+}
+
+// StructType is the packed layout of a struct element
+type StructType struct {
+	Fields []StructField
+	Size   int
+}
+
+// NewStructType lays out fields in order. Field types must be TypeInt64,
+// TypeUint64, TypeFloat64 or TypeBool.
+func NewStructType(fields ...StructField) *StructType {
+	t := &StructType{Fields: make([]StructField, len(fields))}
+	for i, f := range fields {
+		f.Offset = t.Size
+		t.Size += fieldWidth(f.Type)
+		t.Fields[i] = f
+	}
+	return t
+}
+
+func fieldWidth(t ElementType) int {
+	switch t {
+	case TypeInt64, TypeUint64, TypeFloat64:
+		return 8
+	case TypeBool:
+		return 1
+	}
+	panic(fmt.Sprintf("struct field of type %d", t))
+}
+
+// Field returns the index of the named field, or -1
+func (t *StructType) Field(name string) int {
+	for i, f := range t.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pack builds an element from one encoded value per field, in order
+func (t *StructType) Pack(fields ...[]byte) []byte {
+	if len(fields) != len(t.Fields) {
+		panic(fmt.Sprintf("struct has %d fields, packed %d", len(t.Fields), len(fields)))
+	}
+	b := make([]byte, t.Size)
+	for i, f := range t.Fields {
+		copy(b[f.Offset:f.Offset+fieldWidth(f.Type)], fields[i])
+	}
+	return b
+}
+
+// Get returns the encoded value of field i of element b
+func (t *StructType) Get(b []byte, i int) []byte {
+	f := t.Fields[i]
+	return b[f.Offset : f.Offset+fieldWidth(f.Type)]
+}
+
+// Format renders element b as {x: 1.5, y: 2}
+func (t *StructType) Format(b []byte) string {
+	if len(b) != t.Size {
+		return fmt.Sprintf("<struct: %d bytes>", len(b))
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, f := range t.Fields {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(f.Name)
+		sb.WriteString(": ")
+		sb.WriteString(formatElement(t.Get(b, i), f.Type))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// PackValues builds an element from one Value per field, converting each
+// to its field's type. The interpreter keeps struct elements as arrays of
+// field Values and uses this to format them.
+func (t *StructType) PackValues(vals []Value) []byte {
+	fields := make([][]byte, len(t.Fields))
+	for i, f := range t.Fields {
+		var v Value
+		if i < len(vals) {
+			v = vals[i]
+		}
+		switch f.Type {
+		case TypeFloat64:
+			fields[i] = float64ToBytes(v.AsFloat())
+		case TypeBool:
+			fields[i] = []byte{0}
+			if v.AsBool() {
+				fields[i][0] = 1
+			}
+		default:
+			fields[i] = intToBytes(v.AsInt())
+		}
+	}
+	return t.Pack(fields...)
+}
+
+// Values decodes element b into one Value per field
+func (t *StructType) Values(b []byte) []Value {
+	vals := make([]Value, len(t.Fields))
+	for i, f := range t.Fields {
+		data := t.Get(b, i)
+		switch f.Type {
+		case TypeFloat64:
+			vals[i] = NewFloat(bytesToFloat64(data))
+		case TypeBool:
+			vals[i] = NewBool(data[0] != 0)
+		default:
+			vals[i] = NewInt(bytesToInt(data))
+		}
+	}
+	return vals
+}
@@ -0,0 +1,52 @@
+package runtime
+
+import "testing"
+
+func TestStructPack(t *testing.T) {
+	st := NewStructType(
+		StructField{Name: "x", Type: TypeFloat64},
+		StructField{Name: "n", Type: TypeInt64},
+		StructField{Name: "ok", Type: TypeBool},
+	)
+	if st.Size != 17 || st.Fields[2].Offset != 16 {
+		t.Fatalf("layout: size %d, ok at %d", st.Size, st.Fields[2].Offset)
+	}
+	if st.Field("n") != 1 || st.Field("z") != -1 {
+		t.Errorf("Field lookup: n=%d z=%d", st.Field("n"), st.Field("z"))
+	}
+
+	b := st.Pack(float64ToBytes(1.5), intToBytes(-3), []byte{1})
+	if got := bytesToInt(st.Get(b, 1)); got != -3 {
+		t.Errorf("n = %d, want -3", got)
+	}
+	if got := st.Format(b); got != "{x: 1.5, n: -3, ok: true}" {
+		t.Errorf("Format = %s", got)
+	}
+
+	s := NewStack(Indexed, TypeBytes)
+	s.Push(b)
+	raw, _ := s.GetAtRaw(0)
+	if got := st.Format(raw); got != "{x: 1.5, n: -3, ok: true}" {
+		t.Errorf("element read back as %s", got)
+	}
+}
+
+func TestStructValues(t *testing.T) {
+	st := NewStructType(
+		StructField{Name: "x", Type: TypeFloat64},
+		StructField{Name: "ok", Type: TypeBool},
+	)
+	// Values are converted to the field types, and missing ones are zero
+	vals := st.Values(st.PackValues([]Value{NewInt(2)}))
+	if vals[0].Type != VTFloat || vals[0].AsFloat() != 2 || vals[1].AsBool() {
+		t.Errorf("values = %v", vals)
+	}
+
+	// Arrays survive a ValueStack, which is how iual keeps struct elements
+	vs := NewValueStack(LIFO)
+	vs.Push(NewArray(vals))
+	v, err := vs.Pop()
+	if err != nil || !v.IsArray() || len(v.AsArray()) != 2 || v.AsArray()[0].AsFloat() != 2 {
+		t.Errorf("popped %v, %v", v, err)
+	}
+}
@@ -0,0 +1,211 @@
+package runtime
+
+import (
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Template rendering (mustache-like)
+//
+// Supported tags:
+//   {{name}}             value of key, HTML-escaped
+//   {{{name}}} {{&name}} value of key, raw
+//   {{#name}}...{{/name}} section, rendered if key is truthy
+//   {{^name}}...{{/name}} inverted section, rendered if key is falsy/missing
+//   {{! comment}}        ignored
+//
+// Missing keys render as the empty string.
+// ============================================================================
+
+// LookupFunc resolves a template key to its string form.
+type LookupFunc func(key string) (string, bool)
+
+// Render expands a template using the keys of a Hash perspective stack.
+// Values are formatted according to the stack's element type.
+func Render(tmpl string, vars *Stack) (string, error) {
+	if vars == nil {
+		return RenderFunc(tmpl, func(string) (string, bool) { return "", false })
+	}
+	if vars.perspective != Hash {
+		return "", errors.New("render requires a Hash perspective stack")
+	}
+	return RenderFunc(tmpl, func(key string) (string, bool) {
+		data, err := vars.Peek([]byte(key))
+		if err != nil {
+			return "", false
+		}
+		return formatElement(data, vars.elementType), true
+	})
+}
+
+// RenderValues expands a template using the keys of a Hash ValueStack.
+func RenderValues(tmpl string, vars *ValueStack) (string, error) {
+	if vars == nil {
+		return RenderFunc(tmpl, func(string) (string, bool) { return "", false })
+	}
+	if !vars.IsHash() {
+		return "", errors.New("render requires a Hash perspective stack")
+	}
+	return RenderFunc(tmpl, func(key string) (string, bool) {
+		v, ok := vars.Get(key)
+		if !ok {
+			return "", false
+		}
+		return v.AsString(), true
+	})
+}
+
+// RenderFunc expands a template, resolving keys through lookup.
+func RenderFunc(tmpl string, lookup LookupFunc) (string, error) {
+	nodes, rest, err := parseTemplate(tmpl, "")
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", errors.New("template: unexpected trailing input")
+	}
+	var sb strings.Builder
+	renderNodes(&sb, nodes, lookup)
+	return sb.String(), nil
+}
+
+type tmplKind int
+
+const (
+	tmplText tmplKind = iota
+	tmplVar
+	tmplRaw
+	tmplSection
+	tmplInverted
+)
+
+type tmplNode struct {
+	kind     tmplKind
+	text     string // literal text or key name
+	children []tmplNode
+}
+
+// parseTemplate parses until the closing tag for section (or EOF when
+// section is empty) and returns the remaining input after that tag.
+func parseTemplate(s string, section string) ([]tmplNode, string, error) {
+	var nodes []tmplNode
+	for {
+		open := strings.Index(s, "{{")
+		if open < 0 {
+			if section != "" {
+				return nil, "", errors.New("template: unclosed section '" + section + "'")
+			}
+			if s != "" {
+				nodes = append(nodes, tmplNode{kind: tmplText, text: s})
+			}
+			return nodes, "", nil
+		}
+		if open > 0 {
+			nodes = append(nodes, tmplNode{kind: tmplText, text: s[:open]})
+		}
+		s = s[open+2:]
+
+		// Triple mustache: {{{name}}}
+		if strings.HasPrefix(s, "{") {
+			end := strings.Index(s, "}}}")
+			if end < 0 {
+				return nil, "", errors.New("template: unclosed '{{{' tag")
+			}
+			nodes = append(nodes, tmplNode{kind: tmplRaw, text: strings.TrimSpace(s[1:end])})
+			s = s[end+3:]
+			continue
+		}
+
+		end := strings.Index(s, "}}")
+		if end < 0 {
+			return nil, "", errors.New("template: unclosed '{{' tag")
+		}
+		tag := strings.TrimSpace(s[:end])
+		s = s[end+2:]
+		if tag == "" {
+			return nil, "", errors.New("template: empty tag")
+		}
+
+		switch tag[0] {
+		case '!':
+			// comment
+		case '&':
+			nodes = append(nodes, tmplNode{kind: tmplRaw, text: strings.TrimSpace(tag[1:])})
+		case '#', '^':
+			name := strings.TrimSpace(tag[1:])
+			children, rest, err := parseTemplate(s, name)
+			if err != nil {
+				return nil, "", err
+			}
+			kind := tmplSection
+			if tag[0] == '^' {
+				kind = tmplInverted
+			}
+			nodes = append(nodes, tmplNode{kind: kind, text: name, children: children})
+			s = rest
+		case '/':
+			name := strings.TrimSpace(tag[1:])
+			if name != section {
+				if section == "" {
+					return nil, "", errors.New("template: unexpected closing tag '" + name + "'")
+				}
+				return nil, "", errors.New("template: expected {{/" + section + "}}, got {{/" + name + "}}")
+			}
+			return nodes, s, nil
+		default:
+			nodes = append(nodes, tmplNode{kind: tmplVar, text: tag})
+		}
+	}
+}
+
+func renderNodes(sb *strings.Builder, nodes []tmplNode, lookup LookupFunc) {
+	for _, n := range nodes {
+		switch n.kind {
+		case tmplText:
+			sb.WriteString(n.text)
+		case tmplVar:
+			if v, ok := lookup(n.text); ok {
+				sb.WriteString(html.EscapeString(v))
+			}
+		case tmplRaw:
+			if v, ok := lookup(n.text); ok {
+				sb.WriteString(v)
+			}
+		case tmplSection:
+			if v, ok := lookup(n.text); ok && truthy(v) {
+				renderNodes(sb, n.children, lookup)
+			}
+		case tmplInverted:
+			if v, ok := lookup(n.text); !ok || !truthy(v) {
+				renderNodes(sb, n.children, lookup)
+			}
+		}
+	}
+}
+
+// truthy treats "", "0" and "false" as false, matching ual's bool conversion.
+func truthy(s string) bool {
+	return s != "" && s != "0" && s != "false"
+}
+
+// formatElement renders raw element bytes as text for the given element type.
+func formatElement(data []byte, t ElementType) string {
+	switch wideType(t) {
+	case TypeInt64:
+		return strconv.FormatInt(bytesToInt(data), 10)
+	case TypeUint64:
+		return strconv.FormatUint(uint64(bytesToInt(data)), 10)
+	case TypeFloat64:
+		return strconv.FormatFloat(bytesToFloat64(data), 'f', -1, 64)
+	case TypeBool:
+		if len(data) > 0 && data[0] != 0 {
+			return "true"
+		}
+		return "false"
+	default:
+		return string(data)
+	}
+}
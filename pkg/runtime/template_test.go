@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"testing"
+)
+
+func TestRenderVariables(t *testing.T) {
+	vars := NewStack(Hash, TypeString)
+	vars.Push([]byte("Ada"), []byte("name"))
+	vars.Push([]byte("<b>ual</b>"), []byte("lang"))
+
+	out, err := Render("Hello {{name}}, welcome to {{lang}} / {{{lang}}} / {{& lang}}{{missing}}!", vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Hello Ada, welcome to &lt;b&gt;ual&lt;/b&gt; / <b>ual</b> / <b>ual</b>!"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestRenderTypedValues(t *testing.T) {
+	ints := NewStack(Hash, TypeInt64)
+	ints.Push(intToBytes(-42), []byte("n"))
+	out, _ := Render("n={{n}}", ints)
+	if out != "n=-42" {
+		t.Errorf("int: got %q", out)
+	}
+
+	floats := NewStack(Hash, TypeFloat64)
+	floats.Push(float64ToBytes(2.5), []byte("x"))
+	out, _ = Render("x={{x}}", floats)
+	if out != "x=2.5" {
+		t.Errorf("float: got %q", out)
+	}
+
+	bools := NewStack(Hash, TypeBool)
+	bools.Push([]byte{1}, []byte("ok"))
+	out, _ = Render("{{ok}}", bools)
+	if out != "true" {
+		t.Errorf("bool: got %q", out)
+	}
+}
+
+func TestRenderSections(t *testing.T) {
+	vars := NewStack(Hash, TypeInt64)
+	vars.Push(intToBytes(3), []byte("count"))
+	vars.Push(intToBytes(0), []byte("errors"))
+
+	tmpl := "{{#count}}items: {{count}}{{/count}}{{^errors}}, no errors{{/errors}}{{#errors}}, failed{{/errors}}{{! note }}"
+	out, err := Render(tmpl, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "items: 3, no errors" {
+		t.Errorf("got %q", out)
+	}
+
+	out, _ = Render("{{^absent}}none{{/absent}}", vars)
+	if out != "none" {
+		t.Errorf("inverted missing: got %q", out)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	vars := NewStack(Hash, TypeString)
+
+	cases := []string{
+		"{{name",
+		"{{#a}}open",
+		"{{#a}}x{{/b}}",
+		"{{/a}}",
+		"{{}}",
+		"{{{raw}}",
+	}
+	for _, tmpl := range cases {
+		if _, err := Render(tmpl, vars); err == nil {
+			t.Errorf("expected error for %q", tmpl)
+		}
+	}
+
+	lifo := NewStack(LIFO, TypeString)
+	if _, err := Render("x", lifo); err == nil {
+		t.Error("expected error for non-hash stack")
+	}
+}
+
+func TestRenderValues(t *testing.T) {
+	vs := NewValueStack(Hash)
+	vs.Set("user", NewString("bob"))
+	vs.Set("score", NewInt(7))
+
+	out, err := RenderValues("{{user}} scored {{score}}", vs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "bob scored 7" {
+		t.Errorf("got %q", out)
+	}
+}
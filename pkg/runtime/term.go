@@ -0,0 +1,186 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ============================================================================
+// Terminal helpers
+//
+// Colour and cursor control are only emitted when stdout is a terminal, so
+// programs can call them unconditionally and still produce clean output when
+// piped or redirected. Setting NO_COLOR disables colour but keeps
+// clear_line/progress working.
+// ============================================================================
+
+// termOut is where terminal control sequences are written.
+var termOut io.Writer = os.Stdout
+
+// termIsTTY reports whether termOut is an interactive terminal.
+var termIsTTY = func() bool {
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+var ansiCodes = map[string]string{
+	"black":     "30",
+	"red":       "31",
+	"green":     "32",
+	"yellow":    "33",
+	"blue":      "34",
+	"magenta":   "35",
+	"cyan":      "36",
+	"white":     "37",
+	"gray":      "90",
+	"grey":      "90",
+	"bold":      "1",
+	"dim":       "2",
+	"underline": "4",
+}
+
+// IsTTY reports whether stdout is a terminal.
+func IsTTY() bool {
+	return termIsTTY()
+}
+
+// Color wraps s in the ANSI sequence for the named colour or style.
+// Returns s unchanged when stdout is not a terminal, NO_COLOR is set,
+// or the name is unknown.
+func Color(name, s string) string {
+	code, ok := ansiCodes[strings.ToLower(name)]
+	if !ok || !termIsTTY() || os.Getenv("NO_COLOR") != "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// ClearLine erases the current terminal line and returns the cursor to
+// column 0. No-op when stdout is not a terminal.
+func ClearLine() {
+	if !termIsTTY() {
+		return
+	}
+	fmt.Fprint(termOut, "\r\x1b[2K")
+}
+
+// progressWidth is the number of cells in the progress bar.
+const progressWidth = 30
+
+// Progress draws a progress bar for n of total on the current line,
+// finishing with a newline once n reaches total. No-op when stdout is
+// not a terminal.
+func Progress(n, total int64) {
+	if !termIsTTY() {
+		return
+	}
+	fmt.Fprint(termOut, "\r\x1b[2K"+progressBar(n, total))
+	if total > 0 && n >= total {
+		fmt.Fprintln(termOut)
+	}
+}
+
+// progressBar formats "[#####     ]  50% (5/10)" with n clamped to [0, total].
+func progressBar(n, total int64) string {
+	if total <= 0 {
+		total = 1
+	}
+	n = max(0, min(n, total))
+	filled := int(n * progressWidth / total)
+	pct := n * 100 / total
+	return fmt.Sprintf("[%s%s] %3d%% (%d/%d)",
+		strings.Repeat("#", filled), strings.Repeat(" ", progressWidth-filled), pct, n, total)
+}
+
+// ============================================================================
+// Interactive input
+//
+// Prompts are written to stdout and answers read a line at a time from
+// stdin, so scripted input (echo y | prog) works as well as a terminal.
+// ============================================================================
+
+// termIn is where prompt answers are read from.
+var termIn io.Reader = os.Stdin
+
+// termInReader buffers termIn; rebuilt whenever termIn is replaced.
+var (
+	termInReader *bufio.Reader
+	termInSource io.Reader
+)
+
+// termInIsTTY reports whether stdin is an interactive terminal.
+var termInIsTTY = func() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readLine reads one line from termIn without its line ending. At EOF it
+// returns whatever was read, possibly "".
+func readLine() string {
+	line, _ := ReadLine()
+	return line
+}
+
+// ReadLine returns the next line of stdin without its line ending. A last
+// line with no newline is still a line; once there is nothing left to
+// read it returns "" and io.EOF.
+func ReadLine() (string, error) {
+	if termInReader == nil || termInSource != termIn {
+		termInReader = bufio.NewReader(termIn)
+		termInSource = termIn
+	}
+	return ReadLineFrom(termInReader)
+}
+
+// ReadLineFrom is ReadLine reading from r in place of stdin.
+func ReadLineFrom(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// Prompt writes msg and returns the line the user types.
+func Prompt(msg string) string {
+	fmt.Fprint(termOut, msg)
+	return readLine()
+}
+
+// Confirm writes msg followed by " [y/N] " and reports whether the answer
+// was y or yes (any case). Anything else, including EOF, is false.
+func Confirm(msg string) bool {
+	fmt.Fprint(termOut, msg+" [y/N] ")
+	switch strings.ToLower(strings.TrimSpace(readLine())) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// Password writes msg and reads a line with terminal echo turned off.
+// When stdin is not a terminal the line is read as-is.
+func Password(msg string) string {
+	fmt.Fprint(termOut, msg)
+	if !termInIsTTY() {
+		return readLine()
+	}
+	restore, err := disableEcho(int(os.Stdin.Fd()))
+	if err != nil {
+		return readLine()
+	}
+	line := readLine()
+	restore()
+	// The user's Enter was not echoed either
+	fmt.Fprintln(termOut)
+	return line
+}
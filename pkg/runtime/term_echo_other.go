@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package runtime
+
+import "errors"
+
+// disableEcho is unsupported here; Password falls back to visible input.
+func disableEcho(fd int) (func(), error) {
+	return nil, errors.New("echo control not supported on this platform")
+}
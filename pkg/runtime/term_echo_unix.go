@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package runtime
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho turns off terminal echo on fd and returns a function that
+// restores the previous settings.
+func disableEcho(fd int) (func(), error) {
+	var old syscall.Termios
+	if err := termios(fd, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+	quiet := old
+	quiet.Lflag &^= syscall.ECHO
+	if err := termios(fd, ioctlSetTermios, &quiet); err != nil {
+		return nil, err
+	}
+	return func() { termios(fd, ioctlSetTermios, &old) }, nil
+}
+
+func termios(fd int, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package runtime
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// withTerm redirects terminal output and forces the TTY check for a test.
+func withTerm(t *testing.T, tty bool) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	savedOut, savedTTY := termOut, termIsTTY
+	termOut = &buf
+	termIsTTY = func() bool { return tty }
+	t.Cleanup(func() { termOut, termIsTTY = savedOut, savedTTY })
+	return &buf
+}
+
+// withInput feeds prompt answers from s for a test.
+func withInput(t *testing.T, s string) {
+	t.Helper()
+	savedIn, savedTTY := termIn, termInIsTTY
+	termIn = strings.NewReader(s)
+	termInIsTTY = func() bool { return false }
+	t.Cleanup(func() { termIn, termInIsTTY = savedIn, savedTTY })
+}
+
+func TestColorTTY(t *testing.T) {
+	withTerm(t, true)
+	t.Setenv("NO_COLOR", "")
+
+	if got := Color("red", "err"); got != "\x1b[31merr\x1b[0m" {
+		t.Errorf("red: got %q", got)
+	}
+	if got := Color("Bold", "x"); got != "\x1b[1mx\x1b[0m" {
+		t.Errorf("bold: got %q", got)
+	}
+	if got := Color("mauve", "x"); got != "x" {
+		t.Errorf("unknown colour should be unchanged, got %q", got)
+	}
+}
+
+func TestTermNonTTY(t *testing.T) {
+	buf := withTerm(t, false)
+
+	if IsTTY() {
+		t.Error("IsTTY should be false")
+	}
+	if got := Color("red", "err"); got != "err" {
+		t.Errorf("non-tty colour: got %q", got)
+	}
+	ClearLine()
+	Progress(3, 10)
+	if buf.Len() != 0 {
+		t.Errorf("expected no output on non-tty, got %q", buf.String())
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	withTerm(t, true)
+	t.Setenv("NO_COLOR", "1")
+
+	if got := Color("green", "ok"); got != "ok" {
+		t.Errorf("NO_COLOR: got %q", got)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	buf := withTerm(t, true)
+
+	Progress(5, 10)
+	want := "\r\x1b[2K[" + "###############" + "               " + "]  50% (5/10)"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	Progress(12, 10)
+	want = "\r\x1b[2K[" + "##############################" + "] 100% (10/10)\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	ClearLine()
+	if buf.String() != "\r\x1b[2K" {
+		t.Errorf("clear_line: got %q", buf.String())
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	buf := withTerm(t, false)
+	withInput(t, "Ada\r\nLovelace")
+
+	if got := Prompt("name? "); got != "Ada" {
+		t.Errorf("first line: got %q", got)
+	}
+	if got := Prompt("surname? "); got != "Lovelace" {
+		t.Errorf("unterminated last line: got %q", got)
+	}
+	if got := Prompt("more? "); got != "" {
+		t.Errorf("EOF: got %q", got)
+	}
+	if buf.String() != "name? surname? more? " {
+		t.Errorf("prompts: got %q", buf.String())
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	withInput(t, "one\n\nthree")
+
+	for _, want := range []string{"one", "", "three"} {
+		if got, err := ReadLine(); got != want || err != nil {
+			t.Errorf("got %q, %v; want %q", got, err, want)
+		}
+	}
+	if got, err := ReadLine(); got != "" || err != io.EOF {
+		t.Errorf("at EOF: got %q, %v", got, err)
+	}
+	if got := FileStatus(io.EOF); got != "eof" {
+		t.Errorf("EOF status %q, want eof", got)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	buf := withTerm(t, false)
+	withInput(t, "y\n YES \nno\n\n")
+
+	want := []bool{true, true, false, false, false}
+	for i, w := range want {
+		if got := Confirm("ok?"); got != w {
+			t.Errorf("answer %d: got %v, want %v", i, got, w)
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "ok? [y/N] ") {
+		t.Errorf("prompt: got %q", buf.String())
+	}
+}
+
+func TestPasswordNonTTY(t *testing.T) {
+	buf := withTerm(t, false)
+	withInput(t, "s3cret\n")
+
+	if got := Password("password: "); got != "s3cret" {
+		t.Errorf("got %q", got)
+	}
+	if buf.String() != "password: " {
+		t.Errorf("prompt: got %q", buf.String())
+	}
+}
@@ -0,0 +1,105 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Tests
+//
+//   test "name" { ... assert(cond, "msg") }
+//
+// `ual test` builds a program whose test blocks each run through RunTest.
+// A test fails if its body panics, as a failed Assert does, or if an
+// expect_stack or expect_output fails while it runs. Each result is
+// appended as a line of JSON to the file named by $UAL_TEST_REPORT as
+// soon as the test ends, so a program that exits part way still reports
+// the tests before. Without the variable, results are printed to stderr.
+// ============================================================================
+
+// TestReportEnv is the environment variable naming the test report file
+const TestReportEnv = "UAL_TEST_REPORT"
+
+// TestResult is the outcome of one test block
+type TestResult struct {
+	Name    string  `json:"name"`
+	File    string  `json:"file"`
+	Line    int     `json:"line"`
+	Passed  bool    `json:"passed"`
+	Message string  `json:"message,omitempty"` // why it failed
+	Seconds float64 `json:"seconds"`
+}
+
+// AssertionError is the panic value of a failed Assert
+type AssertionError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *AssertionError) Error() string {
+	msg := "assertion failed"
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
+}
+
+// Assert panics with an AssertionError at file and line unless ok
+func Assert(ok bool, file string, line int, msg string) {
+	if !ok {
+		panic(&AssertionError{File: file, Line: line, Message: msg})
+	}
+}
+
+var testReport sync.Mutex
+
+// RunTest runs body as the test block name, declared at file and line,
+// and reports its result
+func RunTest(name, file string, line int, body func()) TestResult {
+	r := TestResult{Name: name, File: file, Line: line, Passed: true}
+	start := time.Now()
+	failures := ExpectFailures()
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				r.Passed = false
+				r.Message = fmt.Sprint(v)
+			}
+		}()
+		body()
+	}()
+	if r.Passed && ExpectFailures() > failures {
+		r.Passed = false
+		r.Message = "expectation failed"
+	}
+	r.Seconds = time.Since(start).Seconds()
+	reportTest(r)
+	return r
+}
+
+func reportTest(r TestResult) {
+	testReport.Lock()
+	defer testReport.Unlock()
+	path := os.Getenv(TestReportEnv)
+	if path == "" {
+		if r.Passed {
+			fmt.Fprintf(os.Stderr, "ok   %s\n", r.Name)
+		} else {
+			fmt.Fprintf(os.Stderr, "FAIL %s\n  %s\n", r.Name, r.Message)
+		}
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ual: test report: %v\n", err)
+		return
+	}
+	defer f.Close()
+	data, _ := json.Marshal(r)
+	f.Write(append(data, '\n'))
+}
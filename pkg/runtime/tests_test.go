@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunTest(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "report.jsonl")
+	t.Setenv(TestReportEnv, report)
+
+	pass := RunTest("pass", "a_test.ual", 1, func() { Assert(true, "a_test.ual", 2, "fine") })
+	fail := RunTest("fail", "a_test.ual", 4, func() {
+		Assert(1+1 == 3, "a_test.ual", 5, "sum")
+		t.Error("the body carried on after a failed assert")
+	})
+	crash := RunTest("crash", "a_test.ual", 7, func() { panic("boom") })
+
+	if !pass.Passed || fail.Passed || crash.Passed {
+		t.Fatalf("expected pass, fail, fail: got %v, %v, %v", pass.Passed, fail.Passed, crash.Passed)
+	}
+	if fail.Message != "a_test.ual:5: assertion failed: sum" {
+		t.Errorf("unexpected message %q", fail.Message)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 results in the report, got %d:\n%s", len(lines), data)
+	}
+	var r TestResult
+	if err := json.Unmarshal([]byte(lines[2]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "crash" || r.Line != 7 || r.Passed || r.Message != "boom" {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestRunTestExpectations(t *testing.T) {
+	t.Setenv(TestReportEnv, filepath.Join(t.TempDir(), "report.jsonl"))
+	defer func(n int) { expect.failures = n }(expect.failures)
+
+	s := NewStack(LIFO, TypeInt64)
+	s.Push(intToBytes(1))
+	r := RunTest("expect", "a_test.ual", 1, func() {
+		ExpectStack(2, "s", s, [][]byte{intToBytes(2)})
+	})
+	if r.Passed || r.Message != "expectation failed" {
+		t.Errorf("expected a failure from the expectation, got %+v", r)
+	}
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import "strings"
+
+// ============================================================================
+// Unsigned stacks
+//
+//   @a = stack.new(u8)
+//   @a push:250 push:10 add      -> 4
+//   @a push:0 dec                -> 255
+//
+// Every unsigned type is stored as TypeUint64. The compilers keep the
+// declared width and wrap each result to it, as Go and Rust do for their
+// fixed-size unsigned integers.
+// ============================================================================
+
+// UintBits returns the width of the unsigned type named typ ("u8" gives 8),
+// or 0 if typ is not an unsigned type
+func UintBits(typ string) uint {
+	switch strings.ToLower(typ) {
+	case "u8":
+		return 8
+	case "u16":
+		return 16
+	case "u32":
+		return 32
+	case "u64":
+		return 64
+	}
+	return 0
+}
+
+// WrapUint returns v cut to its low bits bits, the value it has on an
+// unsigned stack of that width
+func WrapUint(v uint64, bits uint) uint64 {
+	if bits >= 64 {
+		return v
+	}
+	return v & (1<<bits - 1)
+}
+
+// uintWidth marks an int Value as unsigned, holding the width of its type
+type uintWidth uint
+
+// NewUint returns v as a value of an unsigned type of width bits, as a u64
+// variable holds it. It is an int whose bits are read as unsigned.
+func NewUint(v uint64, bits uint) Value {
+	return Value{Type: VTInt, iVal: int64(WrapUint(v, bits)), pVal: uintWidth(bits)}
+}
+
+// UintBits returns the width of v's unsigned type, or 0 if v is not
+// unsigned
+func (v Value) UintBits() uint {
+	if w, ok := v.pVal.(uintWidth); ok && v.Type == VTInt {
+		return uint(w)
+	}
+	return 0
+}
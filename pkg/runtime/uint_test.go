@@ -0,0 +1,88 @@
+package runtime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWrapUint(t *testing.T) {
+	minusOne := uint64(math.MaxUint64)
+	tests := []struct {
+		v    uint64
+		bits uint
+		want uint64
+	}{
+		{250 + 10, 8, 4},
+		{255, 8, 255},
+		{minusOne, 8, 255},
+		{minusOne, 16, 65535},
+		{1 << 32, 32, 0},
+		{minusOne, 32, math.MaxUint32},
+		{minusOne, 64, math.MaxUint64},
+		{200 * 2, 8, 144},
+	}
+	for _, tt := range tests {
+		if got := WrapUint(tt.v, tt.bits); got != tt.want {
+			t.Errorf("WrapUint(%d, %d) = %d, want %d", tt.v, tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestUintBits(t *testing.T) {
+	for typ, want := range map[string]uint{"u8": 8, "u16": 16, "u32": 32, "u64": 64, "i64": 0, "string": 0} {
+		if got := UintBits(typ); got != want {
+			t.Errorf("UintBits(%q) = %d, want %d", typ, got, want)
+		}
+	}
+}
+
+func TestBringUint64(t *testing.T) {
+	src := NewStack(LIFO, TypeUint64)
+	src.Push(intToBytes(-1)) // math.MaxUint64
+
+	str := NewStack(LIFO, TypeString)
+	if err := str.Bring(src); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := str.Pop(); string(val) != "18446744073709551615" {
+		t.Errorf("uint64 to string: got %q", val)
+	}
+
+	str.Push([]byte("18446744073709551615"))
+	if err := src.Bring(str); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := src.Pop(); uint64(bytesToInt(val)) != math.MaxUint64 {
+		t.Errorf("string to uint64: got %d", uint64(bytesToInt(val)))
+	}
+
+	str.Push([]byte("-1"))
+	if err := src.Bring(str); err == nil {
+		t.Error("expected an error bringing \"-1\" to an unsigned stack")
+	}
+
+	src.Push(intToBytes(-1))
+	f := NewStack(LIFO, TypeFloat64)
+	if err := f.Bring(src); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := f.Pop(); bytesToFloat64(val) != math.MaxUint64 {
+		t.Errorf("uint64 to float64: got %v", bytesToFloat64(val))
+	}
+}
+
+func TestNewUint(t *testing.T) {
+	max := NewUint(math.MaxUint64, 64)
+	if max.UintBits() != 64 || max.AsString() != "18446744073709551615" {
+		t.Errorf("got %d bits, %s", max.UintBits(), max.AsString())
+	}
+	if b := NewUint(300, 8); b.AsInt() != 44 || b.UintBits() != 8 {
+		t.Errorf("300 as u8: got %d, %d bits", b.AsInt(), b.UintBits())
+	}
+	if NewInt(-1).UintBits() != 0 || NewInt(-1).AsString() != "-1" {
+		t.Error("a signed int reads as unsigned")
+	}
+	if max.Compare(NewUint(1, 64)) != 1 {
+		t.Error("unsigned values compare signed")
+	}
+}
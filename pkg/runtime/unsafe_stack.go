@@ -0,0 +1,85 @@
+package runtime
+
+// UnsafeStack is a Stack without locking, for a stack that only one
+// goroutine ever uses. The compiler generates one for each user stack it
+// can prove never reaches a spawn block, select, codeblock or other
+// stack operation, so sequential programs do not pay for the mutex on
+// every push and pop.
+//
+// It has the single-stack methods of Stack, which behave the same. It
+// cannot be passed where a *Stack is expected, and using it from two
+// goroutines at once is a data race.
+type UnsafeStack struct {
+	s *Stack
+}
+
+// NewUnsafeStack creates an unlocked stack with given perspective and
+// element type
+func NewUnsafeStack(p Perspective, t ElementType) *UnsafeStack {
+	return &UnsafeStack{s: NewStack(p, t)}
+}
+
+// NewCappedUnsafeStack creates an unlocked stack with fixed capacity
+func NewCappedUnsafeStack(p Perspective, t ElementType, capacity int) *UnsafeStack {
+	return &UnsafeStack{s: NewCappedStack(p, t, capacity)}
+}
+
+// Push adds an element. For hash perspective, requires a key.
+func (u *UnsafeStack) Push(value []byte, key ...[]byte) error {
+	return u.s.push(value, key...)
+}
+
+// Pop removes and returns an element, as Stack.Pop
+func (u *UnsafeStack) Pop(param ...[]byte) ([]byte, error) {
+	return u.s.pop(param...)
+}
+
+// Peek returns element without removing it
+func (u *UnsafeStack) Peek(param ...[]byte) ([]byte, error) {
+	return u.s.peek(param...)
+}
+
+// PushAt stores a value at a specific index
+func (u *UnsafeStack) PushAt(index int, value []byte) error {
+	return u.s.pushAt(index, value)
+}
+
+// PeekAt retrieves a value at a specific index without removing it
+func (u *UnsafeStack) PeekAt(index int) ([]byte, error) {
+	return u.s.peekAt(index)
+}
+
+// Has reports whether a Hash stack holds key
+func (u *UnsafeStack) Has(key string) bool {
+	return u.s.has(key)
+}
+
+// Delete removes key and its value from a Hash stack, as Stack.Delete
+func (u *UnsafeStack) Delete(key string) (bool, error) {
+	return u.s.deleteKey(key)
+}
+
+// Keys returns the keys of a Hash stack in the order they were first set
+func (u *UnsafeStack) Keys() []string {
+	return u.s.keyList()
+}
+
+// Len returns number of elements
+func (u *UnsafeStack) Len() int {
+	return u.s.size()
+}
+
+// Clear removes all elements from the stack
+func (u *UnsafeStack) Clear() {
+	u.s.clear()
+}
+
+// Perspective returns how the stack is accessed
+func (u *UnsafeStack) Perspective() Perspective {
+	return u.s.perspective
+}
+
+// Capacity returns the stack's capacity (0 = unlimited)
+func (u *UnsafeStack) Capacity() int {
+	return u.s.capacity
+}
@@ -0,0 +1,43 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnsafeStackMatchesStack(t *testing.T) {
+	u := NewUnsafeStack(FIFO, TypeInt32)
+	for i := int64(1); i <= 3; i++ {
+		u.Push(intToBytes(i))
+	}
+	if v, _ := u.Pop(); bytesToInt(v) != 1 {
+		t.Errorf("Pop = %d, want 1", bytesToInt(v))
+	}
+	if v, _ := u.PeekAt(1); bytesToInt(v) != 3 {
+		t.Errorf("PeekAt(1) = %d, want 3", bytesToInt(v))
+	}
+	if u.Len() != 2 {
+		t.Errorf("Len = %d, want 2", u.Len())
+	}
+	u.Clear()
+	if _, err := u.Pop(); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Pop after Clear = %v, want ErrEmpty", err)
+	}
+
+	h := NewUnsafeStack(Hash, TypeInt64)
+	h.Push(intToBytes(1), []byte("a"))
+	h.Push(intToBytes(2), []byte("b"))
+	if !h.Has("a") || h.Has("c") {
+		t.Error("Has gave the wrong answer")
+	}
+	h.Delete("a")
+	if keys := h.Keys(); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("Keys = %v, want [b]", keys)
+	}
+
+	c := NewCappedUnsafeStack(LIFO, TypeInt64, 1)
+	c.Push(intToBytes(1))
+	if err := c.Push(intToBytes(2)); !errors.Is(err, ErrFull) {
+		t.Errorf("Push over capacity = %v, want ErrFull", err)
+	}
+}
@@ -49,6 +49,21 @@ func NewArray(v []Value) Value   { return Value{Type: VTArray, pVal: v} }
 func NewError(code, msg string) Value { return Value{Type: VTError, pVal: fmt.Sprintf("%s: %s", code, msg)} }
 func NewCodeblock(params []string, body interface{}) Value { return Value{Type: VTCodeblock, pVal: &Codeblock{Params: params, Body: body}} }
 
+// f32Mark marks a float Value as an f32
+type f32Mark struct{}
+
+// NewFloat32 returns v rounded to an f32, as an f32 variable holds it. It
+// prints with the digits an f32 needs, as the compiled backends print it.
+func NewFloat32(v float64) Value {
+	return Value{Type: VTFloat, fVal: float64(float32(v)), pVal: f32Mark{}}
+}
+
+// IsFloat32 reports whether v is an f32
+func (v Value) IsFloat32() bool {
+	_, ok := v.pVal.(f32Mark)
+	return ok && v.Type == VTFloat
+}
+
 func (v Value) AsInt() int64 {
 	switch v.Type {
 	case VTInt: return v.iVal
@@ -62,7 +77,7 @@ func (v Value) AsInt() int64 {
 func (v Value) AsFloat() float64 {
 	switch v.Type {
 	case VTFloat: return v.fVal
-	case VTInt: return float64(v.iVal)
+	case VTInt: if v.UintBits() > 0 { return float64(uint64(v.iVal)) }; return float64(v.iVal)
 	case VTBool: return float64(v.iVal)
 	case VTString: f, _ := strconv.ParseFloat(v.pVal.(string), 64); return f
 	default: return 0
@@ -71,8 +86,8 @@ func (v Value) AsFloat() float64 {
 
 func (v Value) AsString() string {
 	switch v.Type {
-	case VTInt: return strconv.FormatInt(v.iVal, 10)
-	case VTFloat: return strconv.FormatFloat(v.fVal, 'g', -1, 64)
+	case VTInt: if v.UintBits() > 0 { return strconv.FormatUint(uint64(v.iVal), 10) }; return strconv.FormatInt(v.iVal, 10)
+	case VTFloat: if v.IsFloat32() { return strconv.FormatFloat(v.fVal, 'g', -1, 32) }; return strconv.FormatFloat(v.fVal, 'g', -1, 64)
 	case VTString: return v.pVal.(string)
 	case VTBool: if v.iVal != 0 { return "true" }; return "false"
 	case VTNil: return "nil"
@@ -131,6 +146,10 @@ func (v Value) Compare(other Value) int {
 			a, b := v.AsFloat(), other.AsFloat()
 			if a < b { return -1 }; if a > b { return 1 }; return 0
 		}
+		if v.UintBits() > 0 && other.UintBits() > 0 {
+			a, b := uint64(v.iVal), uint64(other.iVal)
+			if a < b { return -1 }; if a > b { return 1 }; return 0
+		}
 		a, b := v.AsInt(), other.AsInt()
 		if a < b { return -1 }; if a > b { return 1 }; return 0
 	}
@@ -155,6 +174,14 @@ func (v Value) ToBytes() []byte {
 		binary.LittleEndian.PutUint32(buf[1:5], uint32(len(s))); copy(buf[5:], s); return buf
 	case VTBool:
 		buf := make([]byte, 2); buf[0] = byte(VTBool); if v.iVal != 0 { buf[1] = 1 }; return buf
+	case VTArray:
+		// count, then each element length-prefixed (struct elements in iual)
+		arr := v.pVal.([]Value); buf := make([]byte, 5); buf[0] = byte(VTArray)
+		binary.LittleEndian.PutUint32(buf[1:5], uint32(len(arr)))
+		for _, e := range arr {
+			eb := e.ToBytes(); buf = binary.LittleEndian.AppendUint32(buf, uint32(len(eb))); buf = append(buf, eb...)
+		}
+		return buf
 	default: return []byte{byte(VTNil)}
 	}
 }
@@ -172,6 +199,14 @@ func ValueFromBytes(b []byte) Value {
 	case VTError:
 		if len(b) < 5 { return NilValue }; slen := binary.LittleEndian.Uint32(b[1:5])
 		if len(b) < 5+int(slen) { return NilValue }; return Value{Type: VTError, pVal: string(b[5:5+slen])}
+	case VTArray:
+		if len(b) < 5 { return NilValue }; n := binary.LittleEndian.Uint32(b[1:5]); rest := b[5:]
+		arr := make([]Value, 0, n)
+		for k := uint32(0); k < n; k++ {
+			if len(rest) < 4 { return NilValue }; elen := binary.LittleEndian.Uint32(rest)
+			if len(rest) < 4+int(elen) { return NilValue }; arr = append(arr, ValueFromBytes(rest[4:4+elen])); rest = rest[4+elen:]
+		}
+		return NewArray(arr)
 	default: return NilValue
 	}
 }
@@ -4,6 +4,7 @@ package runtime
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -29,9 +30,12 @@ func (vs *ValueStack) IsIndexed() bool              { return vs.stack.perspectiv
 func (vs *ValueStack) Capacity() int  { return vs.stack.Capacity() }
 func (vs *ValueStack) IsFull() bool   { return vs.stack.IsFull() }
 func (vs *ValueStack) Freeze()        { vs.stack.Freeze() }
+func (vs *ValueStack) FreezeWith(mode FreezeMode) { vs.stack.FreezeWith(mode) }
 func (vs *ValueStack) IsFrozen() bool { return vs.stack.IsFrozen() }
 func (vs *ValueStack) Set(key string, v Value) error { return vs.stack.SetRaw(key, v.ToBytes()) }
 func (vs *ValueStack) Get(key string) (Value, bool)  { b, ok := vs.stack.GetRaw(key); if !ok { return NilValue, false }; return ValueFromBytes(b), true }
+func (vs *ValueStack) Has(key string) bool           { return vs.stack.Has(key) }
+func (vs *ValueStack) Delete(key string) (bool, error) { return vs.stack.Delete(key) }
 func (vs *ValueStack) GetAt(index int) (Value, bool) { b, ok := vs.stack.GetAtRaw(index); if !ok { return NilValue, false }; return ValueFromBytes(b), true }
 func (vs *ValueStack) PeekAt(offset int) (Value, error) { b, err := vs.stack.PeekAt(offset); if err != nil { return NilValue, err }; return ValueFromBytes(b), nil }
 func (vs *ValueStack) Close()        { vs.stack.Close() }
@@ -49,36 +53,37 @@ func (vs *ValueStack) Dup() error { vs.mu.Lock(); defer vs.mu.Unlock(); v, err :
 func (vs *ValueStack) Drop() error { _, err := vs.Pop(); return err }
 func (vs *ValueStack) Swap() error {
 	vs.mu.Lock(); defer vs.mu.Unlock()
-	if vs.Len() < 2 { return errors.New("stack underflow: swap requires 2 elements") }
+	if vs.Len() < 2 { return fmt.Errorf("%w: swap requires 2 elements", ErrUnderflow) }
 	b, _ := vs.Pop(); a, _ := vs.Pop(); vs.Push(b); vs.Push(a); return nil
 }
 func (vs *ValueStack) Over() error {
 	vs.mu.Lock(); defer vs.mu.Unlock()
-	if vs.Len() < 2 { return errors.New("stack underflow: over requires 2 elements") }
+	if vs.Len() < 2 { return fmt.Errorf("%w: over requires 2 elements", ErrUnderflow) }
 	b, _ := vs.Pop(); a, _ := vs.Peek(); vs.Push(b); vs.Push(a); return nil
 }
 func (vs *ValueStack) Rot() error {
 	vs.mu.Lock(); defer vs.mu.Unlock()
-	if vs.Len() < 3 { return errors.New("stack underflow: rot requires 3 elements") }
+	if vs.Len() < 3 { return fmt.Errorf("%w: rot requires 3 elements", ErrUnderflow) }
 	c, _ := vs.Pop(); b, _ := vs.Pop(); a, _ := vs.Pop(); vs.Push(b); vs.Push(c); vs.Push(a); return nil
 }
 func (vs *ValueStack) Nip() error {
 	vs.mu.Lock(); defer vs.mu.Unlock()
-	if vs.Len() < 2 { return errors.New("stack underflow: nip requires 2 elements") }
+	if vs.Len() < 2 { return fmt.Errorf("%w: nip requires 2 elements", ErrUnderflow) }
 	b, _ := vs.Pop(); vs.Pop(); vs.Push(b); return nil
 }
 func (vs *ValueStack) Tuck() error {
 	vs.mu.Lock(); defer vs.mu.Unlock()
-	if vs.Len() < 2 { return errors.New("stack underflow: tuck requires 2 elements") }
+	if vs.Len() < 2 { return fmt.Errorf("%w: tuck requires 2 elements", ErrUnderflow) }
 	b, _ := vs.Pop(); a, _ := vs.Pop(); vs.Push(b); vs.Push(a); vs.Push(b); return nil
 }
 
+// All returns the elements bottom to top (oldest first on a FIFO stack)
+// without removing them
 func (vs *ValueStack) All() []Value {
-	vs.mu.RLock(); defer vs.mu.RUnlock()
-	n := vs.Len(); result := make([]Value, n)
-	vs.stack.Lock(); defer vs.stack.Unlock()
-	for i := n - 1; i >= 0; i-- { b, err := vs.stack.PopRaw(); if err != nil { break }; result[i] = ValueFromBytes(b) }
-	for _, v := range result { vs.stack.PushRaw(v.ToBytes()) }
+	s := vs.stack
+	s.mu.RLock(); defer s.mu.RUnlock()
+	result := make([]Value, 0, len(s.elements)-s.head)
+	for _, e := range s.elements[s.head:] { result = append(result, ValueFromBytes(e.data)) }
 	return result
 }
 
@@ -95,7 +100,7 @@ func (vs *ValueStack) PushAll(values []Value) error {
 
 func (vs *ValueStack) PopBottom() (Value, error) {
 	vs.mu.Lock(); defer vs.mu.Unlock()
-	if vs.Len() == 0 { return NilValue, errors.New("stack is empty") }
+	if vs.Len() == 0 { return NilValue, ErrEmpty }
 	oldPersp := vs.stack.perspective; vs.stack.SetPerspective(FIFO)
 	b, err := vs.stack.PopRaw(); vs.stack.SetPerspective(oldPersp)
 	if err != nil { return NilValue, err }; return ValueFromBytes(b), nil
@@ -103,7 +108,64 @@ func (vs *ValueStack) PopBottom() (Value, error) {
 
 func (vs *ValueStack) PeekBottom() (Value, error) {
 	vs.mu.RLock(); defer vs.mu.RUnlock()
-	if vs.Len() == 0 { return NilValue, errors.New("stack is empty") }
+	if vs.Len() == 0 { return NilValue, ErrEmpty }
 	b, ok := vs.stack.GetAtRaw(0); if !ok { return NilValue, errors.New("cannot peek bottom") }
 	return ValueFromBytes(b), nil
 }
+
+// Typed returns a copy of vs as a stack of type t, the element type of the
+// stack in the program, for the runtime functions that take one.
+func (vs *ValueStack) Typed(t ElementType) *Stack {
+	s := vs.stack
+	s.mu.RLock(); defer s.mu.RUnlock()
+	typed := NewStack(s.perspective, t)
+	for n := s.head; n < len(s.elements); n++ {
+		if s.perspective == Hash && s.keys[n] == nil { continue }
+		data := valueElement(ValueFromBytes(s.elements[n].data), t)
+		if s.perspective == Hash { typed.push(data, s.keys[n]) } else { typed.push(data) }
+	}
+	return typed
+}
+
+// PushTyped pushes the elements of src, in push order, converted to
+// Values; with their keys if src is a Hash stack.
+func (vs *ValueStack) PushTyped(src *Stack) error {
+	src.mu.RLock(); defer src.mu.RUnlock()
+	for n := src.head; n < len(src.elements); n++ {
+		if src.perspective == Hash && src.keys[n] == nil { continue }
+		v := elementValue(src.unpack(src.elements[n].data), src.elementType).ToBytes()
+		var err error
+		if src.perspective == Hash { err = vs.stack.Push(v, src.keys[n]) } else { err = vs.stack.Push(v) }
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// elementValue converts an element of type t to a Value
+func elementValue(data []byte, t ElementType) Value {
+	switch wideType(t) {
+	case TypeInt64, TypeUint64:
+		return NewInt(bytesToInt(data))
+	case TypeFloat64:
+		return NewFloat(bytesToFloat64(data))
+	case TypeBool:
+		return NewBool(len(data) > 0 && data[0] != 0)
+	}
+	return NewString(string(data))
+}
+
+// valueElement converts a Value to an element of type t
+func valueElement(v Value, t ElementType) []byte {
+	switch wideType(t) {
+	case TypeInt64, TypeUint64:
+		return intToBytes(v.AsInt())
+	case TypeFloat64:
+		return float64ToBytes(v.AsFloat())
+	case TypeBool:
+		if v.AsBool() {
+			return []byte{1}
+		}
+		return []byte{0}
+	}
+	return []byte(v.AsString())
+}
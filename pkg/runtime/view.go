@@ -5,6 +5,12 @@ import (
 	"sync"
 )
 
+// ErrStale is returned by view operations when the attached stack has been
+// structurally changed (elements added, removed or moved) since the view's
+// cursor, window or hash index was established. Call Resync to accept the
+// stack's current layout.
+var ErrStale = errors.New("view is stale: stack changed since last sync")
+
 // View is a decoupled perspective attached to a stack.
 // Multiple views can attach to the same stack with independent cursors.
 type View struct {
@@ -19,27 +25,52 @@ type View struct {
 	// Hash: not used (hash uses key lookup)
 	cursor int
 	
+	// Window - restricts the view to a range of logical positions
+	// (in perspective order) without copying. winLen < 0 = unbounded.
+	winStart int
+	winLen   int
+	
 	// Hash index - built on attach for Hash perspective
 	hashIdx map[string]int
+	
+	// Stack version this view last synced to (see ErrStale)
+	version uint64
 }
 
 // NewView creates a view with the given perspective, not yet attached
 func NewView(p Perspective) *View {
 	return &View{
 		perspective: p,
+		winLen:      -1,
 	}
 }
 
-// Attach connects this view to a stack and initializes cursor state
+// Attach connects this view to a stack and initializes cursor state.
+// A Broadcast view subscribes to a Broadcast stack and receives every
+// element pushed from now on.
 func (v *View) Attach(s *Stack) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 	
+	if (v.perspective == Broadcast) != (s.Perspective() == Broadcast) {
+		return errBroadcastAttach
+	}
+	v.unsubscribe()
+	if v.perspective == Broadcast {
+		s.mu.Lock()
+		s.subscribe(v)
+		s.mu.Unlock()
+	}
+	
 	v.stack = s
 	v.cursor = 0
+	v.winStart = 0
+	v.winLen = -1
 	
 	if v.perspective == Hash {
 		v.rebuildHashIndex()
+	} else {
+		v.version = s.Version()
 	}
 	
 	return nil
@@ -56,6 +87,79 @@ func (v *View) rebuildHashIndex() {
 			v.hashIdx[string(v.stack.keys[i])] = i
 		}
 	}
+	v.version = v.stack.version
+}
+
+// Resync accepts the stack's current layout after a structural change:
+// the hash index is rebuilt and the view stops reporting ErrStale. The
+// cursor and window are kept as they are, now relative to the new layout.
+func (v *View) Resync() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	
+	if v.stack == nil {
+		return
+	}
+	if v.perspective == Hash {
+		v.rebuildHashIndex()
+		return
+	}
+	v.version = v.stack.Version()
+}
+
+// IsStale reports whether an operation on the view would return ErrStale
+func (v *View) IsStale() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	
+	if v.stack == nil {
+		return false
+	}
+	v.stack.mu.RLock()
+	defer v.stack.mu.RUnlock()
+	return v.checkStale() != nil
+}
+
+// checkStale returns ErrStale if the stack changed structurally since the
+// view synced and the view holds state that depends on element positions.
+// A positional view with no cursor offset or window resolves every access
+// afresh and is never stale.
+// Must be called with v.mu and v.stack.mu held
+func (v *View) checkStale() error {
+	if v.stack.version != v.version && v.hasPositionState() {
+		return ErrStale
+	}
+	return nil
+}
+
+// unsubscribe stops a Broadcast view's delivery from its current stack.
+// Must be called with v.mu held
+func (v *View) unsubscribe() {
+	if v.stack == nil || v.perspective != Broadcast {
+		return
+	}
+	v.stack.mu.Lock()
+	v.stack.unsubscribe(v)
+	v.stack.mu.Unlock()
+}
+
+// hasPositionState reports whether the view caches anything tied to
+// element positions. A Broadcast view's cursor lives in the stack, which
+// keeps it valid, so it is never stale. Must be called with v.mu held
+func (v *View) hasPositionState() bool {
+	if v.perspective == Broadcast {
+		return false
+	}
+	return v.perspective == Hash || v.cursor != 0 || v.winStart != 0 || v.winLen >= 0
+}
+
+// syncIfUnpositioned marks the view as in sync before it first takes on a
+// cursor offset or window, so that state is measured against the current
+// layout. Must be called with v.mu held
+func (v *View) syncIfUnpositioned() {
+	if v.stack != nil && !v.hasPositionState() {
+		v.version = v.stack.Version()
+	}
 }
 
 // Detach disconnects this view from its stack
@@ -63,9 +167,12 @@ func (v *View) Detach() {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 	
+	v.unsubscribe()
 	v.stack = nil
 	v.hashIdx = nil
 	v.cursor = 0
+	v.winStart = 0
+	v.winLen = -1
 }
 
 // Stack returns the attached stack (or nil)
@@ -80,18 +187,29 @@ func (v *View) Perspective() Perspective {
 	return v.perspective
 }
 
-// SetPerspective changes the access mode and resets cursor
+// SetPerspective changes the access mode and resets cursor. Switching to
+// or from Broadcast detaches the view, since the stack it was attached to
+// no longer fits.
 func (v *View) SetPerspective(p Perspective) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 	
+	if (p == Broadcast) != (v.perspective == Broadcast) {
+		v.unsubscribe()
+		v.stack = nil
+	}
 	v.perspective = p
 	v.cursor = 0
+	v.winStart = 0
+	v.winLen = -1
 	
 	if p == Hash && v.stack != nil {
 		v.rebuildHashIndex()
 	} else {
 		v.hashIdx = nil
+		if v.stack != nil {
+			v.version = v.stack.Version()
+		}
 	}
 }
 
@@ -101,18 +219,30 @@ func (v *View) Peek(param ...[]byte) ([]byte, error) {
 	defer v.mu.Unlock()
 	
 	if v.stack == nil {
-		return nil, errors.New("view not attached")
+		return nil, ErrNotAttached
 	}
 	
 	v.stack.mu.RLock()
 	defer v.stack.mu.RUnlock()
 	
+	if v.perspective == Broadcast {
+		idx, ok := v.stack.broadcastIndex(v)
+		if !ok {
+			return nil, ErrEmpty
+		}
+		return v.stack.unpack(v.stack.elements[idx].data), nil
+	}
+	
+	if err := v.checkStale(); err != nil {
+		return nil, err
+	}
+	
 	idx, err := v.resolveIndex(param)
 	if err != nil {
 		return nil, err
 	}
 	
-	return v.stack.elements[idx].data, nil
+	return v.stack.unpack(v.stack.elements[idx].data), nil
 }
 
 // Advance moves cursor forward in perspective order
@@ -121,13 +251,17 @@ func (v *View) Advance() error {
 	defer v.mu.Unlock()
 	
 	if v.stack == nil {
-		return errors.New("view not attached")
+		return ErrNotAttached
 	}
 	
 	if v.perspective == Hash {
 		return errors.New("hash perspective has no cursor to advance")
 	}
+	if v.perspective == Broadcast {
+		return errors.New("broadcast perspective advances by pop")
+	}
 	
+	v.syncIfUnpositioned()
 	v.cursor++
 	return nil
 }
@@ -150,6 +284,7 @@ func (v *View) Cursor() int {
 func (v *View) SetCursor(pos int) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
+	v.syncIfUnpositioned()
 	v.cursor = pos
 }
 
@@ -165,71 +300,170 @@ func (v *View) Remaining() int {
 	v.stack.mu.RLock()
 	defer v.stack.mu.RUnlock()
 	
-	size := len(v.stack.elements) - v.stack.head
-	
 	switch v.perspective {
 	case LIFO, FIFO, Indexed:
-		remaining := size - v.cursor
+		remaining := v.windowSize() - v.cursor
 		if remaining < 0 {
 			return 0
 		}
 		return remaining
 	case Hash:
 		return len(v.hashIdx)
+	case Broadcast:
+		return v.stack.broadcastPending(v)
 	}
 	
 	return 0
 }
 
+// =============================================================================
+// Windows (Slice, Skip, TakeN)
+// =============================================================================
+
+// Slice narrows the view to positions [start, end) of its current window.
+// Positions are counted in perspective order (LIFO: 0 = top).
+// No elements are copied; the window tracks the live stack. Resets the cursor.
+func (v *View) Slice(start, end int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	
+	if v.perspective == Hash || v.perspective == Broadcast {
+		return errors.New("hash and broadcast perspectives cannot be sliced")
+	}
+	if start < 0 || end < start {
+		return errors.New("invalid slice range")
+	}
+	
+	v.syncIfUnpositioned()
+	length := end - start
+	if v.winLen >= 0 {
+		length = min(length, max(v.winLen-start, 0))
+	}
+	v.winStart += start
+	v.winLen = length
+	v.cursor = 0
+	return nil
+}
+
+// Skip drops the first n positions from the view's window. Resets the cursor.
+func (v *View) Skip(n int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	
+	if v.perspective == Hash || v.perspective == Broadcast {
+		return errors.New("hash and broadcast perspectives cannot be sliced")
+	}
+	if n < 0 {
+		return errors.New("skip count must be non-negative")
+	}
+	
+	v.syncIfUnpositioned()
+	v.winStart += n
+	if v.winLen >= 0 {
+		v.winLen = max(v.winLen-n, 0)
+	}
+	v.cursor = 0
+	return nil
+}
+
+// TakeN limits the view's window to at most n positions. Resets the cursor.
+func (v *View) TakeN(n int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	
+	if v.perspective == Hash || v.perspective == Broadcast {
+		return errors.New("hash and broadcast perspectives cannot be sliced")
+	}
+	if n < 0 {
+		return errors.New("take count must be non-negative")
+	}
+	
+	v.syncIfUnpositioned()
+	if v.winLen < 0 || n < v.winLen {
+		v.winLen = n
+	}
+	v.cursor = 0
+	return nil
+}
+
+// Unslice removes any window, exposing the whole stack again.
+func (v *View) Unslice() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.winStart = 0
+	v.winLen = -1
+	v.cursor = 0
+}
+
+// Window returns the window start and length (length < 0 = unbounded)
+func (v *View) Window() (start, length int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.winStart, v.winLen
+}
+
+// windowSize returns the number of elements visible through the window.
+// Must be called with v.stack.mu held
+func (v *View) windowSize() int {
+	size := len(v.stack.elements) - v.stack.head - v.winStart
+	if size < 0 {
+		return 0
+	}
+	if v.winLen >= 0 && size > v.winLen {
+		return v.winLen
+	}
+	return size
+}
+
+// windowIndex maps a window position (in perspective order) to a slice index.
+// Must be called with v.stack.mu held
+func (v *View) windowIndex(pos int) (int, error) {
+	if pos < 0 || pos >= v.windowSize() {
+		return 0, ErrOutOfBounds
+	}
+	if v.perspective == LIFO {
+		return len(v.stack.elements) - 1 - v.winStart - pos, nil
+	}
+	return v.stack.head + v.winStart + pos, nil
+}
+
 // resolveIndex converts perspective + cursor + optional param to actual slice index
 // Must be called with v.mu and v.stack.mu held
 func (v *View) resolveIndex(param [][]byte) (int, error) {
 	size := len(v.stack.elements) - v.stack.head
 	if size == 0 {
-		return 0, errors.New("stack empty")
+		return 0, ErrEmpty
 	}
 	
 	var idx int
 	
 	switch v.perspective {
-	case LIFO:
-		// Count from end, cursor is offset from end
-		offset := v.cursor
-		if len(param) > 0 {
-			offset = int(bytesToInt(param[0]))
-		}
-		idx = len(v.stack.elements) - 1 - offset
-		
-	case FIFO:
-		// Count from head, cursor is offset from head
-		offset := v.cursor
-		if len(param) > 0 {
-			offset = int(bytesToInt(param[0]))
-		}
-		idx = v.stack.head + offset
-		
-	case Indexed:
-		// Direct position, cursor or param
+	case LIFO, FIFO, Indexed:
+		// Position within the window: cursor or param
+		// LIFO counts from the end, FIFO/Indexed from the head
 		pos := v.cursor
 		if len(param) > 0 {
 			pos = int(bytesToInt(param[0]))
 		}
-		idx = v.stack.head + pos
+		var err error
+		if idx, err = v.windowIndex(pos); err != nil {
+			return 0, err
+		}
 		
 	case Hash:
 		if len(param) == 0 {
-			return 0, errors.New("hash perspective requires key")
+			return 0, ErrKeyRequired
 		}
 		keyStr := string(param[0])
 		var exists bool
 		idx, exists = v.hashIdx[keyStr]
 		if !exists {
-			return 0, errors.New("key not found")
+			return 0, ErrKeyNotFound
 		}
 	}
 	
 	if idx < v.stack.head || idx >= len(v.stack.elements) {
-		return 0, errors.New("index out of bounds")
+		return 0, ErrOutOfBounds
 	}
 	
 	// Check for tombstone (hash perspective)
@@ -249,19 +483,35 @@ func (v *View) Pop(param ...[]byte) ([]byte, error) {
 	defer v.mu.Unlock()
 	
 	if v.stack == nil {
-		return nil, errors.New("view not attached")
+		return nil, ErrNotAttached
 	}
 	
 	v.stack.mu.Lock()
 	defer v.stack.mu.Unlock()
 	
-	if v.stack.frozen {
-		return nil, errors.New("stack is frozen")
+	if !v.stack.allows(freezeRemove) {
+		return nil, ErrFrozen
+	}
+	if err := v.checkStale(); err != nil {
+		return nil, err
+	}
+	
+	if v.perspective == Broadcast {
+		// Reading only moves this view's cursor; the element stays for
+		// the other subscribers until they have all read it
+		idx, ok := v.stack.broadcastIndex(v)
+		if !ok {
+			return nil, ErrEmpty
+		}
+		data := v.stack.unpack(v.stack.elements[idx].data)
+		v.stack.subs[v] = v.stack.seq + uint64(idx-v.stack.head) + 1
+		v.stack.trimBroadcast()
+		return data, nil
 	}
 	
 	size := len(v.stack.elements) - v.stack.head
 	if size == 0 {
-		return nil, errors.New("stack empty")
+		return nil, ErrEmpty
 	}
 	
 	var elem Element
@@ -274,15 +524,14 @@ func (v *View) Pop(param ...[]byte) ([]byte, error) {
 		if len(param) > 0 {
 			offset = int(bytesToInt(param[0]))
 		}
-		idx := len(v.stack.elements) - 1 - offset
-		
-		if idx < v.stack.head || idx >= len(v.stack.elements) {
-			return nil, errors.New("index out of bounds")
+		idx, err := v.windowIndex(offset)
+		if err != nil {
+			return nil, err
 		}
 		
 		elem = v.stack.elements[idx]
 		
-		if offset == 0 {
+		if idx == len(v.stack.elements)-1 {
 			// Fast path: just shrink slice
 			v.stack.elements = v.stack.elements[:idx]
 			v.stack.keys = v.stack.keys[:idx]
@@ -298,15 +547,14 @@ func (v *View) Pop(param ...[]byte) ([]byte, error) {
 		if len(param) > 0 {
 			offset = int(bytesToInt(param[0]))
 		}
-		idx := v.stack.head + offset
-		
-		if idx < v.stack.head || idx >= len(v.stack.elements) {
-			return nil, errors.New("index out of bounds")
+		idx, err := v.windowIndex(offset)
+		if err != nil {
+			return nil, err
 		}
 		
 		elem = v.stack.elements[idx]
 		
-		if offset == 0 {
+		if idx == v.stack.head {
 			// Fast path: just advance head
 			v.stack.head++
 			if v.stack.head > len(v.stack.elements)/2 && v.stack.head > 100 {
@@ -322,9 +570,9 @@ func (v *View) Pop(param ...[]byte) ([]byte, error) {
 		if len(param) == 0 {
 			return nil, errors.New("indexed perspective requires position")
 		}
-		idx := v.stack.head + int(bytesToInt(param[0]))
-		if idx < v.stack.head || idx >= len(v.stack.elements) {
-			return nil, errors.New("index out of bounds")
+		idx, err := v.windowIndex(int(bytesToInt(param[0])))
+		if err != nil {
+			return nil, err
 		}
 		elem = v.stack.elements[idx]
 		v.stack.elements = append(v.stack.elements[:idx], v.stack.elements[idx+1:]...)
@@ -332,12 +580,12 @@ func (v *View) Pop(param ...[]byte) ([]byte, error) {
 		
 	case Hash:
 		if len(param) == 0 {
-			return nil, errors.New("hash perspective requires key")
+			return nil, ErrKeyRequired
 		}
 		keyStr := string(param[0])
 		idx, exists := v.hashIdx[keyStr]
 		if !exists {
-			return nil, errors.New("key not found")
+			return nil, ErrKeyNotFound
 		}
 		elem = v.stack.elements[idx]
 		
@@ -352,61 +600,20 @@ func (v *View) Pop(param ...[]byte) ([]byte, error) {
 		v.stack.keys[idx] = nil
 	}
 	
-	return elem.data, nil
+	// The view made this change itself, so it stays in sync
+	v.stack.version++
+	v.version = v.stack.version
+	return v.stack.unpack(elem.data), nil
 }
 
-// Walk traverses stack from cursor position in perspective order
+// Walk traverses the view from its cursor to the end of its window, in the
+// view's perspective order, storing results in dest (see Stack.Walk).
+// A nil dest discards the results.
 func (v *View) Walk(fn WalkFunc, dest *Stack, errStack *Stack) {
-	v.mu.Lock()
-	defer v.mu.Unlock()
-	
-	if v.stack == nil {
-		return
-	}
-	
-	v.stack.mu.RLock()
-	indices := v.walkIndices()
-	elements := make([]Element, len(indices))
-	keys := make([][]byte, len(indices))
-	for i, idx := range indices {
-		elements[i] = v.stack.elements[idx]
-		keys[i] = v.stack.keys[idx]
-	}
-	v.stack.mu.RUnlock()
-	
-	// Process outside of stack lock
-	if dest != nil {
-		dest.mu.Lock()
-		defer dest.mu.Unlock()
-	}
-	
-	for i, elem := range elements {
-		result, err := fn(elem.data)
-		if err != nil {
-			if errStack != nil {
-				errStack.Push([]byte(err.Error()))
-			}
-			continue
-		}
-		
-		if dest != nil {
-			if dest.perspective == Hash {
-				key := keys[i]
-				if key == nil {
-					key = intToBytes(int64(i))
-				}
-				dest.elements = append(dest.elements, Element{data: result})
-				dest.keys = append(dest.keys, key)
-				if dest.hashIdx == nil {
-					dest.hashIdx = make(map[string]int)
-				}
-				dest.hashIdx[string(key)] = len(dest.elements) - 1
-			} else {
-				dest.elements = append(dest.elements, Element{data: result})
-				dest.keys = append(dest.keys, nil)
-			}
-		}
+	if dest == nil {
+		dest = NewStack(FIFO, TypeBytes)
 	}
+	dest.Walk(v, fn, errStack)
 }
 
 // walkIndices returns indices to walk based on perspective and cursor
@@ -420,18 +627,11 @@ func (v *View) walkIndices() []int {
 	indices := make([]int, 0, size)
 	
 	switch v.perspective {
-	case LIFO:
-		// From cursor position (offset from end) to head
-		start := len(v.stack.elements) - 1 - v.cursor
-		for i := start; i >= v.stack.head; i-- {
-			indices = append(indices, i)
-		}
-		
-	case FIFO, Indexed:
-		// From cursor position to end
-		start := v.stack.head + v.cursor
-		for i := start; i < len(v.stack.elements); i++ {
-			indices = append(indices, i)
+	case LIFO, FIFO, Indexed:
+		// From cursor position to the end of the window
+		for pos := v.cursor; pos < v.windowSize(); pos++ {
+			idx, _ := v.windowIndex(pos)
+			indices = append(indices, idx)
 		}
 		
 	case Hash:
@@ -441,6 +641,14 @@ func (v *View) walkIndices() []int {
 				indices = append(indices, i)
 			}
 		}
+		
+	case Broadcast:
+		// What this view has not read yet, without consuming it
+		if start, ok := v.stack.broadcastIndex(v); ok {
+			for i := start; i < len(v.stack.elements); i++ {
+				indices = append(indices, i)
+			}
+		}
 	}
 	
 	return indices
@@ -356,3 +356,272 @@ func TestMoveViewBetweenStacks(t *testing.T) {
 		t.Errorf("on s2 expected 100, got %d", bytesToInt(val))
 	}
 }
+
+func TestViewSliceIndexed(t *testing.T) {
+	s := NewStack(Indexed, TypeInt64)
+	for i := int64(0); i < 10; i++ {
+		s.Push(intToBytes(i * 10))
+	}
+	
+	v := NewView(Indexed)
+	v.Attach(s)
+	
+	if err := v.Slice(2, 6); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Position 0 of the window is element 2 of the stack
+	val, err := v.Peek(intToBytes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytesToInt(val) != 20 {
+		t.Errorf("expected 20, got %d", bytesToInt(val))
+	}
+	
+	if v.Remaining() != 4 {
+		t.Errorf("expected 4 remaining, got %d", v.Remaining())
+	}
+	
+	// Outside the window
+	if _, err := v.Peek(intToBytes(4)); err == nil {
+		t.Error("expected out of bounds error past end of window")
+	}
+	
+	// Stack itself is untouched
+	if s.Len() != 10 {
+		t.Errorf("slice should not copy or remove, got len %d", s.Len())
+	}
+}
+
+func TestViewSkipTakeN(t *testing.T) {
+	s := NewStack(Indexed, TypeInt64)
+	for i := int64(1); i <= 8; i++ {
+		s.Push(intToBytes(i))
+	}
+	
+	v := NewView(FIFO)
+	v.Attach(s)
+	v.Skip(3)  // 4..8
+	v.TakeN(3) // 4, 5, 6
+	v.Skip(1)  // 5, 6
+	
+	start, length := v.Window()
+	if start != 4 || length != 2 {
+		t.Errorf("expected window (4, 2), got (%d, %d)", start, length)
+	}
+	
+	var got []int64
+	for v.Remaining() > 0 {
+		val, _ := v.Peek()
+		got = append(got, bytesToInt(val))
+		v.Advance()
+	}
+	if len(got) != 2 || got[0] != 5 || got[1] != 6 {
+		t.Errorf("expected [5 6], got %v", got)
+	}
+	
+	// Slice is relative to the current window and clamped to it
+	v.Slice(1, 10)
+	if v.Remaining() != 1 {
+		t.Errorf("expected 1 remaining after nested slice, got %d", v.Remaining())
+	}
+	
+	v.Unslice()
+	if v.Remaining() != 8 {
+		t.Errorf("expected 8 remaining after unslice, got %d", v.Remaining())
+	}
+}
+
+func TestViewSliceLIFO(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	for i := int64(1); i <= 5; i++ {
+		s.Push(intToBytes(i))
+	}
+	
+	v := NewView(LIFO)
+	v.Attach(s)
+	v.Slice(1, 3) // LIFO order 5 4 3 2 1 -> 4, 3
+	
+	val, _ := v.Pop()
+	if bytesToInt(val) != 4 {
+		t.Errorf("expected 4, got %d", bytesToInt(val))
+	}
+	if s.Len() != 4 {
+		t.Errorf("expected stack len 4 after pop, got %d", s.Len())
+	}
+	
+	// Window tracks the live stack: now 5 3 2 1 -> 3, 2
+	val, _ = v.Peek()
+	if bytesToInt(val) != 3 {
+		t.Errorf("expected 3, got %d", bytesToInt(val))
+	}
+}
+
+func TestViewSliceHashRejected(t *testing.T) {
+	v := NewView(Hash)
+	if err := v.Slice(0, 1); err == nil {
+		t.Error("expected error slicing hash view")
+	}
+	if err := NewView(Indexed).Slice(3, 1); err == nil {
+		t.Error("expected error for end < start")
+	}
+}
+
+func TestWalkFromSlicedView(t *testing.T) {
+	s := NewStack(Indexed, TypeInt64)
+	for i := int64(1); i <= 6; i++ {
+		s.Push(intToBytes(i))
+	}
+	
+	v := NewView(Indexed)
+	v.Attach(s)
+	v.Slice(1, 4) // 2, 3, 4
+	
+	dest := NewStack(FIFO, TypeInt64)
+	dest.Walk(v, func(data []byte) ([]byte, error) {
+		return intToBytes(bytesToInt(data) * 10), nil
+	}, nil)
+	
+	want := []int64{20, 30, 40}
+	if dest.Len() != len(want) {
+		t.Fatalf("expected %d elements, got %d", len(want), dest.Len())
+	}
+	for _, w := range want {
+		val, _ := dest.Pop()
+		if bytesToInt(val) != w {
+			t.Errorf("expected %d, got %d", w, bytesToInt(val))
+		}
+	}
+	
+	sum, err := Reduce(v, intToBytes(0), func(acc, elem []byte) []byte {
+		return intToBytes(bytesToInt(acc) + bytesToInt(elem))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytesToInt(sum) != 9 {
+		t.Errorf("expected reduce over window = 9, got %d", bytesToInt(sum))
+	}
+}
+
+// =============================================================================
+// Staleness
+// =============================================================================
+
+func TestStackVersion(t *testing.T) {
+	s := NewStack(Hash, TypeInt64)
+	v0 := s.Version()
+	
+	s.Push(intToBytes(1), []byte("a"))
+	v1 := s.Version()
+	if v1 == v0 {
+		t.Error("push of a new key should bump version")
+	}
+	
+	s.Push(intToBytes(2), []byte("a"))
+	if s.Version() != v1 {
+		t.Error("in-place hash update should not bump version")
+	}
+	
+	s.Pop([]byte("a"))
+	if s.Version() == v1 {
+		t.Error("pop should bump version")
+	}
+}
+
+func TestViewStaleWindow(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	for i := int64(1); i <= 5; i++ {
+		s.Push(intToBytes(i))
+	}
+	
+	v := NewView(FIFO)
+	v.Attach(s)
+	v.Skip(2)
+	
+	val, err := v.Peek()
+	if err != nil || bytesToInt(val) != 3 {
+		t.Fatalf("expected 3, got %d (%v)", bytesToInt(val), err)
+	}
+	
+	// Another consumer removes the head: window positions now shift
+	s.Pop()
+	if _, err := v.Peek(); err != ErrStale {
+		t.Fatalf("expected ErrStale, got %v", err)
+	}
+	if !v.IsStale() {
+		t.Error("IsStale should report true")
+	}
+	if _, err := v.Pop(); err != ErrStale {
+		t.Errorf("pop: expected ErrStale, got %v", err)
+	}
+	if _, err := Reduce(v, intToBytes(0), func(a, e []byte) []byte { return a }); err != ErrStale {
+		t.Errorf("reduce: expected ErrStale, got %v", err)
+	}
+	
+	v.Resync()
+	val, err = v.Peek()
+	if err != nil || bytesToInt(val) != 4 {
+		t.Errorf("after resync expected 4, got %d (%v)", bytesToInt(val), err)
+	}
+}
+
+func TestViewOwnPopStaysInSync(t *testing.T) {
+	s := NewStack(LIFO, TypeInt64)
+	for i := int64(1); i <= 4; i++ {
+		s.Push(intToBytes(i))
+	}
+	
+	v := NewView(LIFO)
+	v.Attach(s)
+	v.SetCursor(1)
+	
+	if val, err := v.Pop(); err != nil || bytesToInt(val) != 3 {
+		t.Fatalf("expected 3, got %d (%v)", bytesToInt(val), err)
+	}
+	if val, err := v.Peek(); err != nil || bytesToInt(val) != 2 {
+		t.Errorf("expected 2 after own pop, got %d (%v)", bytesToInt(val), err)
+	}
+}
+
+func TestViewUnpositionedNeverStale(t *testing.T) {
+	// Work-stealing style views with no cursor or window always see
+	// the live stack, so concurrent pushes must not make them stale
+	s := NewStack(LIFO, TypeInt64)
+	owner := NewView(LIFO)
+	thief := NewView(FIFO)
+	owner.Attach(s)
+	thief.Attach(s)
+	
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	
+	if val, err := thief.Pop(); err != nil || bytesToInt(val) != 1 {
+		t.Errorf("thief: expected 1, got %d (%v)", bytesToInt(val), err)
+	}
+	if val, err := owner.Pop(); err != nil || bytesToInt(val) != 2 {
+		t.Errorf("owner: expected 2, got %d (%v)", bytesToInt(val), err)
+	}
+}
+
+func TestViewHashStale(t *testing.T) {
+	s := NewStack(Hash, TypeInt64)
+	s.Push(intToBytes(1), []byte("a"))
+	
+	v := NewView(Hash)
+	v.Attach(s)
+	
+	s.Clear()
+	s.Push(intToBytes(9), []byte("b"))
+	s.Push(intToBytes(7), []byte("a"))
+	
+	if _, err := v.Peek([]byte("a")); err != ErrStale {
+		t.Fatalf("expected ErrStale, got %v", err)
+	}
+	v.Resync()
+	val, err := v.Peek([]byte("a"))
+	if err != nil || bytesToInt(val) != 7 {
+		t.Errorf("after resync expected 7, got %d (%v)", bytesToInt(val), err)
+	}
+}
@@ -3,22 +3,144 @@ package runtime
 // WalkFunc is applied to each element during walk
 type WalkFunc func(data []byte) ([]byte, error)
 
-// Walk traverses source in perspective order, applies fn to each element,
-// pushes results to destination. Errors go to errStack if provided.
-// Source is NOT consumed (unlike bring).
-func (dest *Stack) Walk(source *Stack, fn WalkFunc, errStack *Stack) {
-	source.mu.RLock()
-	defer source.mu.RUnlock()
+// Walkable is a source for Walk, Filter, Map and Reduce: a Stack, or a View
+// (which contributes only the elements inside its cursor and window).
+type Walkable interface {
+	// walkSnapshot returns element data and keys in traversal order
+	walkSnapshot() ([][]byte, [][]byte, error)
+}
+
+// walkSnapshot captures the stack's elements in perspective order.
+// Only slice headers are copied, not element data.
+func (s *Stack) walkSnapshot() ([][]byte, [][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	
+	indices := walkOrder(s)
+	data := make([][]byte, len(indices))
+	keys := make([][]byte, len(indices))
+	for i, idx := range indices {
+		data[i] = s.unpack(s.elements[idx].data)
+		keys[i] = s.keys[idx]
+	}
+	return data, keys, nil
+}
+
+// walkSnapshot captures the elements visible through the view, from the
+// cursor to the end of its window, in the view's perspective order.
+// Returns ErrStale if the view is out of sync with its stack.
+func (v *View) walkSnapshot() ([][]byte, [][]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	
+	if v.stack == nil {
+		return nil, nil, nil
+	}
+	
+	v.stack.mu.RLock()
+	defer v.stack.mu.RUnlock()
+	
+	if err := v.checkStale(); err != nil {
+		return nil, nil, err
+	}
+	indices := v.walkIndices()
+	data := make([][]byte, len(indices))
+	keys := make([][]byte, len(indices))
+	for i, idx := range indices {
+		data[i] = v.stack.unpack(v.stack.elements[idx].data)
+		keys[i] = v.stack.keys[idx]
+	}
+	return data, keys, nil
+}
+
+// walkResult is one output of a walk, before it is committed to dest
+type walkResult struct {
+	data []byte
+	key  []byte // source key (Hash sources), nil otherwise
+	pos  int    // position in source traversal order
+}
+
+// commitWalkResults stores results in dest so that reading dest in its own
+// perspective yields them in the order they were produced: LIFO destinations
+// receive them in reverse so the first result ends up on top. Hash
+// destinations reuse the source key, falling back to the position, and
+// overwrite existing keys. Errors (frozen, full) go to errStack.
+func (dest *Stack) commitWalkResults(results []walkResult, errStack *Stack) {
+	if err := dest.storeWalkResults(results); err != nil && errStack != nil {
+		// Pushed after dest.mu is released, errStack may be dest
+		errStack.Push([]byte(err.Error()))
+	}
+}
+
+func (dest *Stack) storeWalkResults(results []walkResult) error {
 	dest.mu.Lock()
 	defer dest.mu.Unlock()
 	
-	// Determine traversal order based on source perspective
-	indices := walkOrder(source)
+	if len(results) == 0 {
+		return nil
+	}
+	for i := range results {
+		r := results[i]
+		if dest.perspective == LIFO {
+			r = results[len(results)-1-i]
+		}
+		if err := dest.appendWalkResult(r.data, r.key, r.pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendWalkResult pushes a walk result to dest. Caller holds dest.mu.
+// Fails if dest is at capacity or its freeze mode rejects the change.
+func (dest *Stack) appendWalkResult(result, key []byte, pos int) error {
+	if dest.perspective == Hash && key == nil {
+		key = intToBytes(int64(pos))
+	}
+	if !dest.allowsSet(key) {
+		return ErrFrozen
+	}
+	if dest.perspective == Hash {
+		if idx, exists := dest.hashIdx[string(key)]; exists {
+			dest.elements[idx] = Element{data: dest.pack(result)}
+			return nil
+		}
+	}
+	if dest.capacity > 0 && len(dest.elements)-dest.head >= dest.capacity {
+		return ErrFull
+	}
+	dest.elements = append(dest.elements, Element{data: dest.pack(result)})
+	if dest.perspective == Hash {
+		dest.keys = append(dest.keys, key)
+		dest.hashIdx[string(key)] = len(dest.elements) - 1
+	} else {
+		dest.keys = append(dest.keys, nil)
+	}
+	dest.version++
+	if dest.bridge != nil {
+		dest.bridge.send(result)
+	}
+	return nil
+}
+
+// Walk traverses source in its perspective order, applies fn to each
+// element and stores the results in dest, so that dest then yields them in
+// that same order. Elements for which fn fails are skipped and the error is
+// pushed to errStack if provided. Source is NOT consumed (unlike bring), and
+// may be dest itself.
+func (dest *Stack) Walk(source Walkable, fn WalkFunc, errStack *Stack) {
+	// Snapshot first so source and dest may be the same stack
+	data, keys, err := source.walkSnapshot()
+	if err != nil {
+		if errStack != nil {
+			errStack.Push([]byte(err.Error()))
+		}
+		return
+	}
 	
-	for _, idx := range indices {
-		elem := source.elements[idx]
-		result, err := fn(elem.data)
-		
+	results := make([]walkResult, 0, len(data))
+	for i, elem := range data {
+		result, err := fn(elem)
 		if err != nil {
 			if errStack != nil {
 				// Push error info to error stack
@@ -27,24 +149,10 @@ func (dest *Stack) Walk(source *Stack, fn WalkFunc, errStack *Stack) {
 			}
 			continue // skip this element, continue with others
 		}
-		
-		// Push result to dest
-		if dest.perspective == Hash {
-			// For hash dest during walk, use source key if available
-			var key []byte
-			if source.keys[idx] != nil {
-				key = source.keys[idx]
-			} else {
-				key = intToBytes(int64(idx))
-			}
-			dest.elements = append(dest.elements, Element{data: result})
-			dest.keys = append(dest.keys, key)
-			dest.hashIdx[string(key)] = len(dest.elements) - 1
-		} else {
-			dest.elements = append(dest.elements, Element{data: result})
-			dest.keys = append(dest.keys, nil)
-		}
+		results = append(results, walkResult{data: result, key: keys[i], pos: i})
 	}
+	
+	dest.commitWalkResults(results, errStack)
 }
 
 // walkOrder returns indices in perspective order
@@ -79,35 +187,25 @@ func walkOrder(s *Stack) []int {
 	return indices
 }
 
-// Filter walks source, keeping only elements where predicate returns true
-func (dest *Stack) Filter(source *Stack, pred func([]byte) bool, errStack *Stack) {
-	// Custom walk that skips elements not matching predicate
-	source.mu.RLock()
-	defer source.mu.RUnlock()
-	dest.mu.Lock()
-	defer dest.mu.Unlock()
-	
-	indices := walkOrder(source)
+// Filter walks source, keeping only elements where pred returns true.
+// Kept elements are stored in dest in source order, as with Walk.
+func (dest *Stack) Filter(source Walkable, pred func([]byte) bool, errStack *Stack) {
+	data, keys, err := source.walkSnapshot()
+	if err != nil {
+		if errStack != nil {
+			errStack.Push([]byte(err.Error()))
+		}
+		return
+	}
 	
-	for _, idx := range indices {
-		elem := source.elements[idx]
-		if pred(elem.data) {
-			if dest.perspective == Hash {
-				var key []byte
-				if source.keys[idx] != nil {
-					key = source.keys[idx]
-				} else {
-					key = intToBytes(int64(idx))
-				}
-				dest.elements = append(dest.elements, Element{data: elem.data})
-				dest.keys = append(dest.keys, key)
-				dest.hashIdx[string(key)] = len(dest.elements) - 1
-			} else {
-				dest.elements = append(dest.elements, Element{data: elem.data})
-				dest.keys = append(dest.keys, nil)
-			}
+	results := make([]walkResult, 0, len(data))
+	for i, elem := range data {
+		if pred(elem) {
+			results = append(results, walkResult{data: elem, key: keys[i], pos: i})
 		}
 	}
+	
+	dest.commitWalkResults(results, errStack)
 }
 
 // Map is a convenience wrapper: walk with transform, results to new stack
@@ -117,17 +215,18 @@ func Map(source *Stack, fn WalkFunc, destType ElementType, errStack *Stack) *Sta
 	return dest
 }
 
-// Reduce walks source and accumulates a result
-func Reduce(source *Stack, initial []byte, fn func(acc, elem []byte) []byte) []byte {
-	source.mu.RLock()
-	defer source.mu.RUnlock()
+// Reduce walks source and accumulates a result.
+// The only error is ErrStale, when source is a view out of sync with its stack.
+func Reduce(source Walkable, initial []byte, fn func(acc, elem []byte) []byte) ([]byte, error) {
+	data, _, err := source.walkSnapshot()
+	if err != nil {
+		return initial, err
+	}
 	
 	acc := initial
-	indices := walkOrder(source)
-	
-	for _, idx := range indices {
-		acc = fn(acc, source.elements[idx].data)
+	for _, elem := range data {
+		acc = fn(acc, elem)
 	}
 	
-	return acc
+	return acc, nil
 }
@@ -32,18 +32,18 @@ func TestWalkLIFO(t *testing.T) {
 	}
 	
 	// LIFO walk order: 3, 2, 1 -> 9, 4, 1
-	// But pushed in that order, so popping LIFO gives: 1, 4, 9
+	// Dest yields results in walk order, so popping LIFO gives: 9, 4, 1
 	val, _ := dst.Pop()
-	if bytesToInt(val) != 1 {
-		t.Errorf("expected 1, got %d", bytesToInt(val))
+	if bytesToInt(val) != 9 {
+		t.Errorf("expected 9, got %d", bytesToInt(val))
 	}
 	val, _ = dst.Pop()
 	if bytesToInt(val) != 4 {
 		t.Errorf("expected 4, got %d", bytesToInt(val))
 	}
 	val, _ = dst.Pop()
-	if bytesToInt(val) != 9 {
-		t.Errorf("expected 9, got %d", bytesToInt(val))
+	if bytesToInt(val) != 1 {
+		t.Errorf("expected 1, got %d", bytesToInt(val))
 	}
 }
 
@@ -156,7 +156,10 @@ func TestReduce(t *testing.T) {
 		return intToBytes(a + e)
 	}
 	
-	result := Reduce(src, intToBytes(0), sum)
+	result, err := Reduce(src, intToBytes(0), sum)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	
 	if bytesToInt(result) != 10 {
 		t.Errorf("expected sum 10, got %d", bytesToInt(result))
@@ -244,3 +247,164 @@ func TestWalkFrozenStack(t *testing.T) {
 		t.Errorf("expected 2, got %d", bytesToInt(val))
 	}
 }
+
+// popAll drains a positional stack in its own perspective order
+func popAll(s *Stack) []int64 {
+	var out []int64
+	for s.Len() > 0 {
+		val, err := s.Pop()
+		if err != nil {
+			break
+		}
+		out = append(out, bytesToInt(val))
+	}
+	return out
+}
+
+func equalInts(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWalkPreservesOrderAcrossPerspectives(t *testing.T) {
+	double := func(data []byte) ([]byte, error) {
+		return intToBytes(bytesToInt(data) * 2), nil
+	}
+	
+	cases := []struct {
+		name     string
+		src, dst Perspective
+		want     []int64 // dst drained in its own perspective
+	}{
+		{"LIFO->LIFO", LIFO, LIFO, []int64{6, 4, 2}},
+		{"LIFO->FIFO", LIFO, FIFO, []int64{6, 4, 2}},
+		{"FIFO->LIFO", FIFO, LIFO, []int64{2, 4, 6}},
+		{"FIFO->FIFO", FIFO, FIFO, []int64{2, 4, 6}},
+		{"Indexed->FIFO", Indexed, FIFO, []int64{2, 4, 6}},
+	}
+	for _, c := range cases {
+		src := NewStack(c.src, TypeInt64)
+		dst := NewStack(c.dst, TypeInt64)
+		for i := int64(1); i <= 3; i++ {
+			src.Push(intToBytes(i))
+		}
+		dst.Walk(src, double, nil)
+		if got := popAll(dst); !equalInts(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWalkIndexedDest(t *testing.T) {
+	src := NewStack(LIFO, TypeInt64)
+	dst := NewStack(Indexed, TypeInt64)
+	for i := int64(1); i <= 3; i++ {
+		src.Push(intToBytes(i))
+	}
+	
+	dst.Walk(src, func(data []byte) ([]byte, error) {
+		return intToBytes(bytesToInt(data) + 10), nil
+	}, nil)
+	
+	// Position 0 holds the first result (source top)
+	for pos, want := range []int64{13, 12, 11} {
+		val, err := dst.Peek(intToBytes(int64(pos)))
+		if err != nil || bytesToInt(val) != want {
+			t.Errorf("index %d: expected %d, got %d (%v)", pos, want, bytesToInt(val), err)
+		}
+	}
+}
+
+func TestWalkPositionalToHash(t *testing.T) {
+	src := NewStack(FIFO, TypeInt64)
+	dst := NewStack(Hash, TypeInt64)
+	src.Push(intToBytes(5))
+	src.Push(intToBytes(7))
+	
+	dst.Walk(src, func(data []byte) ([]byte, error) { return data, nil }, nil)
+	
+	// Positional sources are keyed by traversal position
+	val, err := dst.Peek(intToBytes(1))
+	if err != nil || bytesToInt(val) != 7 {
+		t.Errorf("expected 7 at key 1, got %d (%v)", bytesToInt(val), err)
+	}
+}
+
+func TestFilterPerspectives(t *testing.T) {
+	isOdd := func(data []byte) bool { return bytesToInt(data)%2 != 0 }
+	
+	lifo := NewStack(LIFO, TypeInt64)
+	fifo := NewStack(FIFO, TypeInt64)
+	for i := int64(1); i <= 5; i++ {
+		lifo.Push(intToBytes(i))
+		fifo.Push(intToBytes(i))
+	}
+	
+	dstLIFO := NewStack(LIFO, TypeInt64)
+	dstLIFO.Filter(lifo, isOdd, nil)
+	if got := popAll(dstLIFO); !equalInts(got, []int64{5, 3, 1}) {
+		t.Errorf("LIFO filter: got %v", got)
+	}
+	
+	dstFIFO := NewStack(FIFO, TypeInt64)
+	dstFIFO.Filter(fifo, isOdd, nil)
+	if got := popAll(dstFIFO); !equalInts(got, []int64{1, 3, 5}) {
+		t.Errorf("FIFO filter: got %v", got)
+	}
+	
+	hash := NewStack(Hash, TypeInt64)
+	hash.Push(intToBytes(1), []byte("one"))
+	hash.Push(intToBytes(2), []byte("two"))
+	dstHash := NewStack(Hash, TypeInt64)
+	dstHash.Filter(hash, isOdd, nil)
+	if dstHash.Len() != 1 {
+		t.Fatalf("hash filter: expected 1 element, got %d", dstHash.Len())
+	}
+	if _, err := dstHash.Peek([]byte("one")); err != nil {
+		t.Errorf("hash filter should keep key 'one': %v", err)
+	}
+}
+
+func TestWalkSelf(t *testing.T) {
+	s := NewStack(FIFO, TypeInt64)
+	s.Push(intToBytes(1))
+	s.Push(intToBytes(2))
+	
+	s.Walk(s, func(data []byte) ([]byte, error) {
+		return intToBytes(bytesToInt(data) * 10), nil
+	}, nil)
+	
+	if got := popAll(s); !equalInts(got, []int64{1, 2, 10, 20}) {
+		t.Errorf("self walk: got %v", got)
+	}
+}
+
+func TestWalkIntoFrozenOrFullDest(t *testing.T) {
+	src := NewStack(FIFO, TypeInt64)
+	for i := int64(1); i <= 3; i++ {
+		src.Push(intToBytes(i))
+	}
+	identity := func(data []byte) ([]byte, error) { return data, nil }
+	
+	frozen := NewStack(FIFO, TypeInt64)
+	frozen.Freeze()
+	errStack := NewStack(LIFO, TypeString)
+	frozen.Walk(src, identity, errStack)
+	if frozen.Len() != 0 || errStack.Len() != 1 {
+		t.Errorf("frozen dest: len %d, errors %d", frozen.Len(), errStack.Len())
+	}
+	
+	capped := NewCappedStack(FIFO, TypeInt64, 2)
+	errStack = NewStack(LIFO, TypeString)
+	capped.Walk(src, identity, errStack)
+	if capped.Len() != 2 || errStack.Len() != 1 {
+		t.Errorf("capped dest: len %d, errors %d", capped.Len(), errStack.Len())
+	}
+}
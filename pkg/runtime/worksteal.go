@@ -1,110 +1,9 @@
 package runtime
 
-import (
-	"sync"
-	"sync/atomic"
-)
+import "github.com/ha1tch/ual/pkg/runtime/internal/deque"
 
-// ============================================================================
-// Traditional Work-Stealing (Chase-Lev style deque)
-// As taught in computer science - lock-free owner, locked steal
-// ============================================================================
-
-// Task represents a unit of work
-type Task struct {
-	ID   int64
-	Data []byte
-}
-
-// WSDeque is a work-stealing deque (traditional implementation)
-// Owner pushes/pops from bottom (LIFO), thieves steal from top (FIFO)
-type WSDeque struct {
-	tasks  []Task
-	bottom int64 // atomic - owner's end
-	top    int64 // atomic - thief's end
-	mu     sync.Mutex // for steal synchronization
-}
-
-// NewWSDeque creates a traditional work-stealing deque
-func NewWSDeque(capacity int) *WSDeque {
-	return &WSDeque{
-		tasks:  make([]Task, capacity),
-		bottom: 0,
-		top:    0,
-	}
-}
-
-// Push adds a task (owner only, LIFO end)
-func (d *WSDeque) Push(t Task) bool {
-	b := atomic.LoadInt64(&d.bottom)
-	top := atomic.LoadInt64(&d.top)
-	
-	if b-top >= int64(len(d.tasks)) {
-		return false // full
-	}
-	
-	d.tasks[b%int64(len(d.tasks))] = t
-	atomic.StoreInt64(&d.bottom, b+1)
-	return true
-}
-
-// Pop removes a task (owner only, LIFO end)
-func (d *WSDeque) Pop() (Task, bool) {
-	b := atomic.LoadInt64(&d.bottom) - 1
-	atomic.StoreInt64(&d.bottom, b)
-	
-	top := atomic.LoadInt64(&d.top)
-	
-	if top <= b {
-		// Non-empty
-		t := d.tasks[b%int64(len(d.tasks))]
-		if top == b {
-			// Last element - race with steal
-			if !atomic.CompareAndSwapInt64(&d.top, top, top+1) {
-				// Lost race
-				atomic.StoreInt64(&d.bottom, b+1)
-				return Task{}, false
-			}
-			atomic.StoreInt64(&d.bottom, b+1)
-		}
-		return t, true
-	}
-	
-	// Empty
-	atomic.StoreInt64(&d.bottom, top)
-	return Task{}, false
-}
-
-// Steal takes a task (thief, FIFO end)
-func (d *WSDeque) Steal() (Task, bool) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	
-	top := atomic.LoadInt64(&d.top)
-	b := atomic.LoadInt64(&d.bottom)
-	
-	if top >= b {
-		return Task{}, false // empty
-	}
-	
-	t := d.tasks[top%int64(len(d.tasks))]
-	if !atomic.CompareAndSwapInt64(&d.top, top, top+1) {
-		return Task{}, false // lost race
-	}
-	
-	return t, true
-}
-
-// Len returns approximate size
-func (d *WSDeque) Len() int {
-	b := atomic.LoadInt64(&d.bottom)
-	t := atomic.LoadInt64(&d.top)
-	size := b - t
-	if size < 0 {
-		return 0
-	}
-	return int(size)
-}
+// Task is a unit of work for WSStack
+type Task = deque.Task
 
 // ============================================================================
 // ual Work-Stealing (using decoupled views)
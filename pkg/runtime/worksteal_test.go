@@ -3,52 +3,14 @@ package runtime
 import (
 	"sync"
 	"testing"
+
+	"github.com/ha1tch/ual/pkg/runtime/internal/deque"
 )
 
 // ============================================================================
 // Correctness Tests
 // ============================================================================
 
-func TestTraditionalWorkStealing(t *testing.T) {
-	d := NewWSDeque(100)
-	
-	// Owner pushes
-	d.Push(Task{ID: 1})
-	d.Push(Task{ID: 2})
-	d.Push(Task{ID: 3})
-	d.Push(Task{ID: 4})
-	d.Push(Task{ID: 5})
-	
-	// Owner pops (LIFO - gets 5)
-	task, ok := d.Pop()
-	if !ok || task.ID != 5 {
-		t.Errorf("owner expected 5, got %d", task.ID)
-	}
-	
-	// Thief steals (FIFO - gets 1)
-	task, ok = d.Steal()
-	if !ok || task.ID != 1 {
-		t.Errorf("thief expected 1, got %d", task.ID)
-	}
-	
-	// Owner pops (gets 4)
-	task, ok = d.Pop()
-	if !ok || task.ID != 4 {
-		t.Errorf("owner expected 4, got %d", task.ID)
-	}
-	
-	// Thief steals (gets 2)
-	task, ok = d.Steal()
-	if !ok || task.ID != 2 {
-		t.Errorf("thief expected 2, got %d", task.ID)
-	}
-	
-	// Only 3 remains
-	if d.Len() != 1 {
-		t.Errorf("expected 1 remaining, got %d", d.Len())
-	}
-}
-
 func TestUalWorkStealing(t *testing.T) {
 	ws := NewWSStack()
 	
@@ -157,7 +119,7 @@ func TestCappedStackFull(t *testing.T) {
 // ============================================================================
 
 func BenchmarkTraditional_Push(b *testing.B) {
-	d := NewWSDeque(b.N + 100)
+	d := deque.New(b.N + 100)
 	task := Task{ID: 42, Data: []byte("test")}
 	
 	b.ResetTimer()
@@ -177,7 +139,7 @@ func BenchmarkUal_Push(b *testing.B) {
 }
 
 func BenchmarkTraditional_Pop(b *testing.B) {
-	d := NewWSDeque(b.N + 100)
+	d := deque.New(b.N + 100)
 	task := Task{ID: 42, Data: []byte("test")}
 	for i := 0; i < b.N; i++ {
 		d.Push(task)
@@ -203,7 +165,7 @@ func BenchmarkUal_Pop(b *testing.B) {
 }
 
 func BenchmarkTraditional_Steal(b *testing.B) {
-	d := NewWSDeque(b.N + 100)
+	d := deque.New(b.N + 100)
 	task := Task{ID: 42, Data: []byte("test")}
 	for i := 0; i < b.N; i++ {
 		d.Push(task)
@@ -229,7 +191,7 @@ func BenchmarkUal_Steal(b *testing.B) {
 }
 
 func BenchmarkTraditional_PushPop(b *testing.B) {
-	d := NewWSDeque(1000)
+	d := deque.New(1000)
 	task := Task{ID: 42, Data: []byte("test")}
 	
 	b.ResetTimer()
@@ -255,7 +217,7 @@ func BenchmarkUal_PushPop(b *testing.B) {
 // ============================================================================
 
 func BenchmarkTraditional_Concurrent(b *testing.B) {
-	d := NewWSDeque(10000)
+	d := deque.New(10000)
 	task := Task{ID: 42, Data: []byte("test")}
 	
 	// Pre-fill
@@ -304,7 +266,7 @@ func BenchmarkUal_Concurrent(b *testing.B) {
 
 // Simulates work-stealing scheduler: 1 owner + N thieves
 func BenchmarkTraditional_OneOwnerManyThieves(b *testing.B) {
-	d := NewWSDeque(100000)
+	d := deque.New(100000)
 	task := Task{ID: 42, Data: []byte("test")}
 	
 	// Pre-fill
@@ -412,7 +374,7 @@ func BenchmarkUal_OneOwnerManyThieves(b *testing.B) {
 // ============================================================================
 
 func BenchmarkTraditional_Allocs(b *testing.B) {
-	d := NewWSDeque(10000)
+	d := deque.New(10000)
 	task := Task{ID: 42, Data: []byte("test")}
 	
 	b.ReportAllocs()
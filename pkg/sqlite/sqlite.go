@@ -0,0 +1,116 @@
+// Package sqlite gives ual programs SQLite databases:
+//
+//	var n i64 = query("shop.db", "SELECT name, qty FROM items WHERE qty > ?", 2, @names, @qtys)
+//	var changed i64 = exec("shop.db", "UPDATE items SET qty = qty - 1 WHERE name = ?", "apple")
+//
+// It is a module of its own, so that only programs that use a database
+// depend on the driver, modernc.org/sqlite, which needs no cgo. Generated
+// programs that call query or exec import it beside the runtime.
+//
+// A database is named by its path, or by ":memory:" or a "file:" URI with
+// options, and opened on first use. Each is opened once for the life of
+// the program and used through a single connection, so that a ":memory:"
+// database keeps its tables between calls, and closed when the program
+// ends.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	ual "github.com/ha1tch/ual/pkg/runtime"
+	_ "modernc.org/sqlite"
+)
+
+var open struct {
+	mu  sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+// db returns the database named by path, opening it on first use
+func db(path string) (*sql.DB, error) {
+	open.mu.Lock()
+	defer open.mu.Unlock()
+	if d, ok := open.dbs[path]; ok {
+		return d, nil
+	}
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	d.SetMaxOpenConns(1)
+	if err := d.Ping(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if open.dbs == nil {
+		open.dbs = make(map[string]*sql.DB)
+	}
+	open.dbs[path] = d
+	ual.AtExit(func() { d.Close() })
+	return d, nil
+}
+
+// Query runs query on the database at path, with args for its ? parameters,
+// and pushes column k of each result row to cols[k], converted to the
+// stack's element type as read_csv converts fields; NULL is the zero
+// value. It returns the rows pushed. The result must have as many columns
+// as there are stacks; a row that does not convert stops the query, with
+// the rows before it pushed.
+func Query(path, query string, args []any, cols ...*ual.Stack) (int64, error) {
+	d, err := db(path)
+	if err != nil {
+		return 0, fmt.Errorf("query: %v", err)
+	}
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("query: %v", err)
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("query: %v", err)
+	}
+	if len(names) != len(cols) {
+		return 0, fmt.Errorf("query: %d columns, %d stacks", len(names), len(cols))
+	}
+
+	row := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for n := range row {
+		ptrs[n] = &row[n]
+	}
+	var pushed int64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return pushed, fmt.Errorf("query: %v", err)
+		}
+		if err := ual.PushRow(cols, row); err != nil {
+			return pushed, fmt.Errorf("query: row %d: %v", pushed+1, err)
+		}
+		pushed++
+	}
+	if err := rows.Err(); err != nil {
+		return pushed, fmt.Errorf("query: %v", err)
+	}
+	return pushed, nil
+}
+
+// Exec runs statement on the database at path, with args for its ?
+// parameters, and returns the rows it changed.
+func Exec(path, statement string, args ...any) (int64, error) {
+	d, err := db(path)
+	if err != nil {
+		return 0, fmt.Errorf("exec: %v", err)
+	}
+	res, err := d.Exec(statement, args...)
+	if err != nil {
+		return 0, fmt.Errorf("exec: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("exec: %v", err)
+	}
+	return n, nil
+}
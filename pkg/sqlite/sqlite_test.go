@@ -0,0 +1,79 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ual "github.com/ha1tch/ual/pkg/runtime"
+)
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shop.db")
+	if _, err := Exec(path, "CREATE TABLE items (name TEXT, qty INTEGER, price REAL)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]any{{"apple", 3, 0.5}, {"pear", 1, 0.75}, {"fig", nil, 2.0}} {
+		if _, err := Exec(path, "INSERT INTO items VALUES (?, ?, ?)", row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := Exec(path, "UPDATE items SET qty = qty + 1 WHERE qty IS NOT NULL"); n != 2 || err != nil {
+		t.Errorf("exec: %d, %v", n, err)
+	}
+
+	names := ual.NewStack(ual.FIFO, ual.TypeString)
+	qtys := ual.NewStack(ual.FIFO, ual.TypeInt64)
+	prices := ual.NewStack(ual.FIFO, ual.TypeFloat64)
+	n, err := Query(path, "SELECT name, qty, price FROM items WHERE price < ? ORDER BY name", []any{5}, names, qtys, prices)
+	if n != 3 || err != nil {
+		t.Fatalf("query: %d, %v", n, err)
+	}
+	if names.ToJSON() != `["apple","fig","pear"]` || qtys.ToJSON() != "[4,0,2]" || prices.ToJSON() != "[0.5,2,0.75]" {
+		t.Errorf("got %s %s %s", names.ToJSON(), qtys.ToJSON(), prices.ToJSON())
+	}
+}
+
+func TestMemory(t *testing.T) {
+	// One connection, so the table outlives the statement that made it
+	if _, err := Exec(":memory:", "CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Exec(":memory:", "INSERT INTO t VALUES (7), (8)"); err != nil {
+		t.Fatal(err)
+	}
+	xs := ual.NewStack(ual.LIFO, ual.TypeInt64)
+	if n, err := Query(":memory:", "SELECT x FROM t", nil, xs); n != 2 || err != nil || xs.ToJSON() != "[7,8]" {
+		t.Errorf("query: %d, %v, %s", n, err, xs.ToJSON())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "e.db")
+	Exec(path, "CREATE TABLE t (a TEXT, b TEXT)")
+	Exec(path, "INSERT INTO t VALUES ('1', 'x'), ('2', 'y')")
+	one := ual.NewStack(ual.LIFO, ual.TypeString)
+	ints := ual.NewStack(ual.FIFO, ual.TypeInt64)
+	cases := []struct {
+		run  func() (int64, error)
+		n    int64
+		want string
+	}{
+		{func() (int64, error) { return Exec(path, "DROP TABLE nowhere") }, 0, "exec: "},
+		{func() (int64, error) { return Query(path, "SELEC a FROM t", nil, one) }, 0, "query: "},
+		{func() (int64, error) { return Query(path, "SELECT a, b FROM t", nil, one) }, 0, "query: 2 columns, 1 stacks"},
+		{func() (int64, error) { return Query(path, "SELECT a || b FROM t ORDER BY a DESC", nil, ints) }, 0, "query: row 1: field 1:"},
+		{func() (int64, error) {
+			return Query(path, "SELECT CASE a WHEN '1' THEN 5 ELSE b END FROM t ORDER BY a", nil, ints)
+		}, 1, "query: row 2:"},
+	}
+	for i, c := range cases {
+		n, err := c.run()
+		if n != c.n || err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("case %d: %d, %v, want %q", i, n, err, c.want)
+		}
+	}
+	if ints.ToJSON() != "[5]" {
+		t.Errorf("pushed %s", ints.ToJSON())
+	}
+}
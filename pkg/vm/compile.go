@@ -0,0 +1,642 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// Callable reports whether a call of the function name with argc
+// arguments can be made by OpCall: it is a function of the program, not a
+// builtin, taking that many values and no stacks. Other calls are left to
+// the host.
+type Callable func(name string, argc int) bool
+
+// Compile compiles the body of fn, which has no type parameters. A stack
+// parameter takes a slot holding nothing: the host binds the stack to the
+// parameter's name before the call, and the body reaches it by name.
+func Compile(fn *ast.FuncDecl, callable Callable) (*Proto, error) {
+	if len(fn.TypeParams) > 0 {
+		return nil, fmt.Errorf("%s: type parameters", fn.Name)
+	}
+	c := newCompiler(fn.Name, callable)
+	for _, param := range fn.Params {
+		n := c.slot()
+		if !param.Stack {
+			c.scopes[0][param.Name] = n
+			c.f32s[n] = param.Type == "f32"
+		}
+	}
+	c.p.NumParams = len(fn.Params)
+	if err := c.stmts(fn.Body); err != nil {
+		return nil, err
+	}
+	c.emit(OpReturnNil, 0, 0)
+	return c.finish(), nil
+}
+
+// CompileStmt compiles a statement at the top level of a program. The
+// variables it assigns but does not declare are the host's.
+func CompileStmt(stmt ast.Stmt, callable Callable) (*Proto, error) {
+	c := newCompiler("", callable)
+	if err := c.stmt(stmt); err != nil {
+		return nil, err
+	}
+	return c.finish(), nil
+}
+
+// compiler builds a Proto
+type compiler struct {
+	p        *Proto
+	callable Callable
+	scopes   []map[string]int // the locals visible, by name, innermost last
+	loops    []*loop          // the loops being compiled, innermost last
+	names    map[string]int
+	consts   map[runtime.Value]int
+	uints    map[int]int  // the widths of the unsigned locals, by slot
+	f32s     map[int]bool // the f32 locals, by slot
+	depth    int          // of the operand stack
+	impure   bool
+}
+
+// loop is a loop being compiled, with the jumps and fallbacks that leave
+// it to patch once its ends are known
+type loop struct {
+	breaks, continues []int
+	fallbacks         []*Fallback
+}
+
+func newCompiler(name string, callable Callable) *compiler {
+	return &compiler{
+		p:        &Proto{Name: name},
+		callable: callable,
+		scopes:   []map[string]int{{}},
+		names:    map[string]int{},
+		uints:    map[int]int{},
+		f32s:     map[int]bool{},
+		consts:   map[runtime.Value]int{},
+	}
+}
+
+// finish marks the globals a pure function reads as globals only
+func (c *compiler) finish() *Proto {
+	p := c.p
+	p.Pure = !c.impure
+	if p.Pure && p.Name != "" {
+		for idx := range p.Code {
+			if p.Code[idx].Op == OpGlobal {
+				p.Code[idx].B = 1
+			}
+		}
+	}
+	p.calls = make([]callSite, len(p.Names))
+	return p
+}
+
+// emit appends an instruction and returns its index
+func (c *compiler) emit(op Op, a, b int) int {
+	switch op {
+	case OpConst, OpLoad, OpGlobal, OpEval, OpPopStack:
+		c.depth++
+	case OpStore, OpSetGlobal, OpPop, OpJumpIf, OpJumpNot, OpPush, OpReturn:
+		c.depth--
+	case OpConcat:
+		c.depth -= a - 1
+	case OpCall:
+		c.depth -= b - 1
+	default:
+		if op >= OpAdd && op <= OpOr {
+			c.depth--
+		}
+	}
+	if c.depth > c.p.MaxStack {
+		c.p.MaxStack = c.depth
+	}
+	c.p.Code = append(c.p.Code, Instr{op, int32(a), int32(b)})
+	return len(c.p.Code) - 1
+}
+
+// patch points the jump at pc to the next instruction
+func (c *compiler) patch(pc int) {
+	c.p.Code[pc].A = int32(len(c.p.Code))
+}
+
+func (c *compiler) constant(v runtime.Value) {
+	n, ok := c.consts[v]
+	if !ok {
+		n = len(c.p.Consts)
+		c.p.Consts = append(c.p.Consts, v)
+		c.consts[v] = n
+	}
+	c.emit(OpConst, n, 0)
+}
+
+func (c *compiler) name(s string) int {
+	n, ok := c.names[s]
+	if !ok {
+		n = len(c.p.Names)
+		c.p.Names = append(c.p.Names, s)
+		c.names[s] = n
+	}
+	return n
+}
+
+// slot allocates a local
+func (c *compiler) slot() int {
+	c.p.NumLocals++
+	return c.p.NumLocals - 1
+}
+
+// declare makes name a local of the innermost scope
+func (c *compiler) declare(name string) int {
+	scope := c.scopes[len(c.scopes)-1]
+	n, ok := scope[name]
+	if !ok {
+		n = c.slot()
+		scope[name] = n
+	}
+	return n
+}
+
+// local returns the slot of the local name, if there is one
+func (c *compiler) local(name string) (int, bool) {
+	for idx := len(c.scopes) - 1; idx >= 0; idx-- {
+		if n, ok := c.scopes[idx][name]; ok {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func (c *compiler) pushScope() { c.scopes = append(c.scopes, map[string]int{}) }
+func (c *compiler) popScope()  { c.scopes = c.scopes[:len(c.scopes)-1] }
+
+func (c *compiler) stmts(stmts []ast.Stmt) error {
+	for _, s := range stmts {
+		if err := c.stmt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scoped compiles the body of an if or loop, whose declarations are its own
+func (c *compiler) scoped(stmts []ast.Stmt) error {
+	c.pushScope()
+	defer c.popScope()
+	return c.stmts(stmts)
+}
+
+func (c *compiler) stmt(s ast.Stmt) error {
+	switch s := s.(type) {
+	case *ast.VarDecl:
+		for idx, name := range s.Names {
+			if idx < len(s.Values) && s.Values[idx] != nil {
+				if err := c.expr(s.Values[idx]); err != nil {
+					return err
+				}
+				if s.Type == "f64" {
+					c.emit(OpFloat, 0, 0)
+				}
+			} else {
+				c.constant(zero(s.Type))
+			}
+			n := c.declare(name)
+			if bits := int(runtime.UintBits(s.Type)); bits > 0 {
+				c.uints[n] = bits
+				c.emit(OpUint, bits, 0)
+			}
+			if c.f32s[n] = s.Type == "f32"; c.f32s[n] {
+				c.emit(OpFloat, 32, 0)
+			}
+			c.emit(OpStore, n, 0)
+		}
+		return nil
+	case *ast.AssignStmt:
+		if err := c.expr(s.Value); err != nil {
+			return err
+		}
+		c.store(s.Name, 0)
+		return nil
+	case *ast.Assignment:
+		if err := c.expr(s.Expr); err != nil {
+			return err
+		}
+		c.store(s.Name, 1)
+		return nil
+	case *ast.IfStmt:
+		return c.ifStmt(s)
+	case *ast.WhileStmt:
+		return c.whileStmt(s)
+	case *ast.RangeStmt:
+		return c.rangeStmt(s)
+	case *ast.BreakStmt:
+		if len(c.loops) > 0 {
+			l := c.loops[len(c.loops)-1]
+			l.breaks = append(l.breaks, c.emit(OpJump, 0, 0))
+			return nil
+		}
+	case *ast.ContinueStmt:
+		if len(c.loops) > 0 {
+			l := c.loops[len(c.loops)-1]
+			l.continues = append(l.continues, c.emit(OpJump, 0, 0))
+			return nil
+		}
+	case *ast.ReturnStmt:
+		if c.p.Name != "" && len(s.Values) == 0 {
+			if s.Value == nil {
+				c.emit(OpReturnNil, 0, 0)
+				return nil
+			}
+			if err := c.expr(s.Value); err != nil {
+				return err
+			}
+			c.emit(OpReturn, 0, 0)
+			return nil
+		}
+	case *ast.ExprStmt:
+		if err := c.expr(s.Expr); err != nil {
+			return err
+		}
+		c.emit(OpPop, 0, 0)
+		return nil
+	case *ast.FuncCall:
+		if c.callable(s.Name, len(s.Args)) {
+			if err := c.call(s.Name, s.Args); err != nil {
+				return err
+			}
+			c.emit(OpPop, 0, 0)
+			return nil
+		}
+	case *ast.StackOp:
+		if s.Op == "push" && len(s.Args) > 0 && !hasRecord(s.Args) {
+			for _, arg := range s.Args {
+				if err := c.expr(arg); err != nil {
+					return err
+				}
+				c.emit(OpPush, c.name(s.Stack), 0)
+			}
+			return nil
+		}
+		if n, ok := c.local(s.Target); ok && s.Op == "pop" && len(s.Args) == 0 {
+			// The host checks the types and pops; the value is the local's
+			f := &Fallback{Stmt: s, Locals: []Local{{s.Target, n}}, Break: -1, Continue: -1}
+			c.p.Fallbacks = append(c.p.Fallbacks, f)
+			c.emit(OpPopTo, len(c.p.Fallbacks)-1, 0)
+			return nil
+		}
+	case *ast.LetAssign:
+		stack := s.Stack
+		if stack == "" {
+			stack = "dstack"
+		}
+		c.emit(OpPopStack, c.name(stack), 0)
+		c.store(s.Name, 0)
+		return nil
+	case *ast.Block:
+		return c.stmts(s.Stmts)
+	}
+	return c.fallback(s, nil)
+}
+
+// store pops into the variable name, wrapping it if it is unsigned and
+// rounding it if it is an f32; report is OpSetGlobal's B
+func (c *compiler) store(name string, report int) {
+	if n, ok := c.local(name); ok {
+		if bits := c.uints[n]; bits > 0 {
+			c.emit(OpUint, bits, 0)
+		}
+		if c.f32s[n] {
+			c.emit(OpFloat, 32, 0)
+		}
+		c.emit(OpStore, n, 0)
+		return
+	}
+	c.impure = true
+	c.emit(OpSetGlobal, c.name(name), report)
+}
+
+func (c *compiler) ifStmt(s *ast.IfStmt) error {
+	var ends []int
+	branch := func(cond ast.Expr, body []ast.Stmt) error {
+		if err := c.expr(cond); err != nil {
+			return err
+		}
+		next := c.emit(OpJumpNot, 0, 0)
+		if err := c.scoped(body); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(OpJump, 0, 0))
+		c.patch(next)
+		return nil
+	}
+	if err := branch(s.Condition, s.Body); err != nil {
+		return err
+	}
+	for _, elseif := range s.ElseIfs {
+		if err := branch(elseif.Condition, elseif.Body); err != nil {
+			return err
+		}
+	}
+	if err := c.scoped(s.Else); err != nil {
+		return err
+	}
+	for _, pc := range ends {
+		c.patch(pc)
+	}
+	return nil
+}
+
+func (c *compiler) whileStmt(s *ast.WhileStmt) error {
+	top := len(c.p.Code)
+	if err := c.expr(s.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(OpJumpNot, 0, 0)
+	l := &loop{}
+	c.loops = append(c.loops, l)
+	if err := c.scoped(s.Body); err != nil {
+		return err
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+	c.emit(OpJump, top, 0)
+	c.patch(exit)
+	c.endLoop(l, top)
+	return nil
+}
+
+// rangeStmt compiles for v in start..end. The bounds are evaluated once
+// into two hidden locals, the count and the end, with the loop variable
+// after them; assigning to the variable does not change the count.
+func (c *compiler) rangeStmt(s *ast.RangeStmt) error {
+	if err := c.expr(s.Start); err != nil {
+		return err
+	}
+	if err := c.expr(s.End); err != nil {
+		return err
+	}
+	c.pushScope()
+	defer c.popScope()
+	count := c.slot()
+	c.slot()
+	c.emit(OpStore, count+1, 0)
+	c.emit(OpStore, count, 0)
+	c.scopes[len(c.scopes)-1][s.Var] = c.slot()
+
+	top := c.emit(OpRange, count, 0)
+	l := &loop{}
+	c.loops = append(c.loops, l)
+	if err := c.stmts(s.Body); err != nil {
+		return err
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+	next := c.emit(OpInc, count, 0)
+	c.emit(OpJump, top, 0)
+	c.p.Code[top].B = int32(len(c.p.Code))
+	c.endLoop(l, next)
+	return nil
+}
+
+// endLoop points the breaks of l after the loop and its continues at next
+func (c *compiler) endLoop(l *loop, next int) {
+	for _, pc := range l.breaks {
+		c.patch(pc)
+	}
+	for _, pc := range l.continues {
+		c.p.Code[pc].A = int32(next)
+	}
+	for _, f := range l.fallbacks {
+		f.Break, f.Continue = len(c.p.Code), next
+	}
+}
+
+func (c *compiler) expr(e ast.Expr) error {
+	switch e := e.(type) {
+	case *ast.IntLit:
+		c.constant(runtime.NewInt(e.Value))
+	case *ast.FloatLit:
+		c.constant(runtime.NewFloat(e.Value))
+	case *ast.StringLit:
+		c.constant(runtime.NewString(e.Value))
+	case *ast.BoolLit:
+		c.constant(runtime.NewBool(e.Value))
+	case *ast.InterpString:
+		for _, part := range e.Parts {
+			if err := c.expr(part); err != nil {
+				return err
+			}
+		}
+		c.emit(OpConcat, len(e.Parts), 0)
+	case *ast.Ident:
+		switch e.Name {
+		case "true", "false":
+			c.constant(runtime.NewBool(e.Name == "true"))
+		case "nil":
+			c.constant(runtime.NilValue)
+		default:
+			if n, ok := c.local(e.Name); ok {
+				c.emit(OpLoad, n, 0)
+			} else {
+				c.emit(OpGlobal, c.name(e.Name), 0)
+			}
+		}
+	case *ast.BinaryExpr:
+		if e.Op == "&&" || e.Op == "||" {
+			return c.logical(e)
+		}
+		o, ok := eval.BinaryOp(e.Op)
+		if !ok {
+			return c.fallback(nil, e)
+		}
+		return c.binary(OpAdd+Op(o), e.Left, e.Right)
+	case *ast.BinaryOp:
+		o, ok := eval.ArithOp(e.Op)
+		if !ok {
+			return c.fallback(nil, e)
+		}
+		return c.binary(OpAdd+Op(o), e.Left, e.Right)
+	case *ast.UnaryExpr:
+		o, ok := eval.UnaryOp(e.Op)
+		if !ok {
+			return c.fallback(nil, e)
+		}
+		if err := c.expr(e.Operand); err != nil {
+			return err
+		}
+		c.emit(OpAdd+Op(o), 0, 0)
+	case *ast.CallExpr:
+		if !c.callable(e.Fn, len(e.Args)) {
+			return c.fallback(nil, e)
+		}
+		return c.call(e.Fn, e.Args)
+	case *ast.FuncCall:
+		if !c.callable(e.Name, len(e.Args)) {
+			return c.fallback(nil, e)
+		}
+		return c.call(e.Name, e.Args)
+	default:
+		return c.fallback(nil, e)
+	}
+	return nil
+}
+
+func (c *compiler) binary(op Op, left, right ast.Expr) error {
+	if err := c.expr(left); err != nil {
+		return err
+	}
+	if err := c.expr(right); err != nil {
+		return err
+	}
+	c.emit(op, 0, 0)
+	return nil
+}
+
+// logical compiles && and ||, which skip their right side once the left
+// decides
+func (c *compiler) logical(e *ast.BinaryExpr) error {
+	if err := c.expr(e.Left); err != nil {
+		return err
+	}
+	jump := OpJumpNot
+	if e.Op == "||" {
+		jump = OpJumpIf
+	}
+	decided := c.emit(jump, 0, 0)
+	if err := c.expr(e.Right); err != nil {
+		return err
+	}
+	c.emit(OpBool, 0, 0)
+	end := c.emit(OpJump, 0, 0)
+	c.depth--
+	c.patch(decided)
+	c.constant(runtime.NewBool(e.Op == "||"))
+	c.patch(end)
+	return nil
+}
+
+func (c *compiler) call(name string, args []ast.Expr) error {
+	for _, arg := range args {
+		if err := c.expr(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(OpCall, c.name(name), len(args))
+	return nil
+}
+
+// fallback leaves the statement s, or the expression e, to the host. A
+// statement that assigns to variables that are not local can only run in
+// the host's scopes as they are, with no locals to hand it.
+func (c *compiler) fallback(s ast.Stmt, e ast.Expr) error {
+	f := &Fallback{Stmt: s, Expr: e, Break: -1, Continue: -1}
+	var node ast.Node = e
+	if s != nil {
+		node = s
+	}
+	for _, name := range names(node) {
+		if n, ok := c.local(name); ok {
+			f.Locals = append(f.Locals, Local{name, n})
+		}
+	}
+	if len(f.Locals) > 0 {
+		for _, name := range binds(s) {
+			if _, ok := c.local(name); !ok {
+				return fmt.Errorf("%T assigns to %s, which is not local", s, name)
+			}
+		}
+	}
+	if len(c.loops) > 0 {
+		l := c.loops[len(c.loops)-1]
+		l.fallbacks = append(l.fallbacks, f)
+	}
+	c.impure = true
+	c.p.Fallbacks = append(c.p.Fallbacks, f)
+	if s != nil {
+		c.emit(OpExec, len(c.p.Fallbacks)-1, 0)
+	} else {
+		c.emit(OpEval, len(c.p.Fallbacks)-1, 0)
+	}
+	return nil
+}
+
+// binds returns the variables the statement s assigns, declaring them if
+// they do not exist
+func binds(s ast.Stmt) []string {
+	switch s := s.(type) {
+	case *ast.LetAssign:
+		return []string{s.Name}
+	case *ast.ArrayDecl:
+		return []string{s.Name}
+	case *ast.StackOp:
+		if s.Target != "" {
+			return []string{s.Target}
+		}
+	case *ast.ArgsDecl:
+		var names []string
+		for _, sp := range s.Specs {
+			names = append(names, strings.ReplaceAll(sp.Name, "-", "_"))
+		}
+		return names
+	}
+	return nil
+}
+
+// names returns every string in the tree of node: the names of all the
+// variables it uses, and more
+func names(node ast.Node) []string {
+	seen := map[string]bool{}
+	var list []string
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.String:
+			if s := v.String(); !seen[s] {
+				seen[s] = true
+				list = append(list, s)
+			}
+		case reflect.Pointer, reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Struct:
+			for idx := 0; idx < v.NumField(); idx++ {
+				walk(v.Field(idx))
+			}
+		case reflect.Slice, reflect.Array:
+			for idx := 0; idx < v.Len(); idx++ {
+				walk(v.Index(idx))
+			}
+		}
+	}
+	walk(reflect.ValueOf(node))
+	return list
+}
+
+// hasRecord reports whether any of args is a record literal
+func hasRecord(args []ast.Expr) bool {
+	for _, arg := range args {
+		if _, ok := arg.(*ast.RecordLit); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// zero is the value of a variable of type t declared without one
+func zero(t string) runtime.Value {
+	switch t {
+	case "i64", "i32", "i16", "i8", "u64", "u32", "u16", "u8", "fn":
+		return runtime.NewInt(0)
+	case "f64", "f32":
+		return runtime.NewFloat(0)
+	case "string":
+		return runtime.NewString("")
+	case "bool":
+		return runtime.NewBool(false)
+	}
+	return runtime.NilValue
+}
@@ -0,0 +1,511 @@
+// Package vm compiles ual function bodies and loops to bytecode and runs
+// them on a stack machine.
+//
+// iual walks the syntax tree of a program, but a program spends nearly all
+// its time in a few functions and loops, and those it hands to a Machine.
+// A function body, or a while or range loop at the top level, is compiled
+// once to a Proto: local variables live in numbered slots rather than the
+// maps of a scope, and operators, jumps and calls are instructions. What
+// the compiler does not know (stack operations other than push, let and pop:var, spawns,
+// selects, compute blocks and the builtins) stays in the tree: the Proto
+// keeps each such statement or expression as a Fallback, and the Machine
+// asks its Host, the interpreter, to run it, handing over the local
+// variables it names.
+//
+// Operators are applied by pkg/eval, as in the tree walker, and errors
+// carry the walker's messages.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// Op is an instruction's operation.
+type Op uint8
+
+// Operations. Comments show what each pops and pushes, with A and B the
+// instruction's operands.
+const (
+	OpConst     Op = iota // push Consts[A]
+	OpLoad                // push local A
+	OpStore               // pop into local A
+	OpGlobal              // push the variable Names[A] from the host, only its globals if B is 1
+	OpSetGlobal           // pop into the variable Names[A] of the host, reporting it if B is 1
+	OpPop                 // pop and drop
+
+	// Binary operators: pop b, pop a, push a op b. They are in the order
+	// of eval's operators, so OpAdd+o is the instruction of eval.Op o.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpNumEq
+	OpNumNe
+	OpNumLt
+	OpNumGt
+	OpNumLe
+	OpNumGe
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpEq
+	OpNe
+	OpLt
+	OpGt
+	OpLe
+	OpGe
+	OpAnd
+	OpOr
+
+	// Unary operators: pop a, push op a
+	OpNeg
+	OpNot
+	OpBitNot
+
+	OpBool    // pop a, push a as a bool
+	OpFloat   // pop a, push it as a float if it is an int, as an f32 if A is 32
+	OpUint    // pop a, push it as an unsigned int of A bits if it is a number
+	OpConcat  // pop A values, push them joined as a string
+	OpJump    // go to A
+	OpJumpIf  // pop a, go to A if it is true
+	OpJumpNot // pop a, go to A if it is false
+
+	OpRange // if local A < local A+1, set local A+2 to it, else go to B
+	OpInc   // add 1 to local A
+
+	OpCall      // pop B arguments, call the function Names[A], push its result
+	OpPush      // pop a, push it to the stack Names[A] through the host
+	OpPopStack  // push a value popped from the stack Names[A] through the host
+	OpPopTo     // pop the pop:var of Fallbacks[A] into the local it names through the host
+	OpExec      // run the statement of Fallbacks[A] through the host
+	OpEval      // push the value of the expression of Fallbacks[A], through the host
+	OpReturn    // pop a and return it
+	OpReturnNil // return nil
+)
+
+var opNames = [...]string{
+	"const", "load", "store", "global", "setglobal", "pop",
+	"add", "sub", "mul", "div", "mod",
+	"numeq", "numne", "numlt", "numgt", "numle", "numge",
+	"bitand", "bitor", "bitxor", "shl", "shr",
+	"eq", "ne", "lt", "gt", "le", "ge", "and", "or",
+	"neg", "not", "bitnot",
+	"bool", "float", "uint", "concat", "jump", "jumpif", "jumpnot",
+	"range", "inc",
+	"call", "push", "popstack", "popto", "exec", "eval", "return", "returnnil",
+}
+
+func (o Op) String() string {
+	if int(o) < len(opNames) {
+		return opNames[o]
+	}
+	return fmt.Sprintf("op%d", o)
+}
+
+// Instr is one instruction.
+type Instr struct {
+	Op   Op
+	A, B int32
+}
+
+// Proto is compiled code: a function body, whose parameters are its first
+// locals, or a statement.
+type Proto struct {
+	Name      string // the function's, or "" for a statement
+	Code      []Instr
+	Consts    []runtime.Value
+	Names     []string // of variables, functions and stacks
+	Fallbacks []*Fallback
+	NumParams int
+	NumLocals int
+	MaxStack  int
+
+	// Pure is set when the code neither runs fallbacks nor assigns to
+	// variables other than its locals, so it needs nothing from the
+	// host's scopes but the globals it reads. Calls between pure
+	// functions stay in the Machine.
+	Pure bool
+
+	calls []callSite // by Names index, for OpCall
+}
+
+// callSite caches the function an OpCall calls.
+type callSite struct {
+	proto *Proto
+	gen   int // Machine.gen when looked up; 0 for never
+}
+
+// Fallback is a statement or expression the Machine leaves to its host.
+type Fallback struct {
+	Stmt ast.Stmt // run by OpExec, or a pop:var popped by OpPopTo
+	Expr ast.Expr // evaluated by OpEval
+
+	// Locals are the local variables the node names, which the host
+	// makes visible to it, and reads back after a statement.
+	Locals []Local
+
+	// Where a break or continue out of the statement goes, or -1 outside
+	// a loop
+	Break, Continue int
+}
+
+// Local is a local variable: its name and slot.
+type Local struct {
+	Name string
+	Slot int
+}
+
+// Flow is how a statement run by the host ended.
+type Flow int
+
+const (
+	FlowNext Flow = iota
+	FlowBreak
+	FlowContinue
+	FlowReturn
+)
+
+// Errors Run returns when a break, continue or return leaves the code
+// compiled, for the host to pass on as it would from a statement: a break
+// in a function body outside a loop, say, or a return from a loop at the
+// top level.
+var (
+	ErrBreak    = errors.New("break")
+	ErrContinue = errors.New("continue")
+	ErrReturn   = errors.New("return")
+)
+
+// Host is what a Machine runs code for: the interpreter, which holds the
+// variables, functions and stacks that are not local to compiled code.
+type Host interface {
+	// Global returns the variable name, or an error if there is none. If
+	// globalOnly is set, only the program's globals are looked at.
+	Global(name string, globalOnly bool) (runtime.Value, error)
+	// SetGlobal assigns v to the variable name, declaring it if there is
+	// none. report is set for an assignment statement (ast.Assignment),
+	// whose variable a program prints at its end if assigned outside a
+	// function.
+	SetGlobal(name string, v runtime.Value, report bool)
+	// Func returns the compiled body of the function name, if it is pure
+	// and takes argc values, or nil to have Call called instead.
+	Func(name string, argc int) *Proto
+	// Call calls the function name.
+	Call(name string, args []runtime.Value) (runtime.Value, error)
+	// Push pushes v to the stack name.
+	Push(stack string, v runtime.Value) error
+	// Pop pops a value from the stack name.
+	Pop(stack string) (runtime.Value, error)
+	// PopTo pops the value the statement f.Stmt, a pop:var, stores in its
+	// variable, the one local f names, which holds cur.
+	PopTo(f *Fallback, cur runtime.Value) (runtime.Value, error)
+	// Exec runs the statement f.Stmt, with the locals f names set from
+	// locals and written back to it afterwards, and tells how it ended,
+	// with the value returned for FlowReturn.
+	Exec(f *Fallback, locals []runtime.Value) (Flow, runtime.Value, error)
+	// Eval evaluates the expression f.Expr, with the locals f names set
+	// from locals.
+	Eval(f *Fallback, locals []runtime.Value) (runtime.Value, error)
+}
+
+// Machine runs Protos for a host. Its methods are not safe for
+// concurrent use.
+type Machine struct {
+	host  Host
+	stack []runtime.Value       // locals and operands of the frames running
+	top   int                   // first free element of stack
+	gen   int                   // counts Invalidate calls, for the call caches
+	halt  atomic.Pointer[error] // set by Interrupt
+}
+
+// New returns a Machine running code for host.
+func New(host Host) *Machine {
+	return &Machine{host: host, stack: make([]runtime.Value, 1024), gen: 1}
+}
+
+// Invalidate forgets the functions calls were found to call, for when
+// the host's functions change.
+func (m *Machine) Invalidate() {
+	m.gen++
+}
+
+// Interrupt makes the code running stop with err at its next jump back
+// or call, and any run later stop there too. Unlike the other methods it
+// may be called from any goroutine.
+func (m *Machine) Interrupt(err error) {
+	m.halt.CompareAndSwap(nil, &err)
+}
+
+// Run runs p with args as its parameters and returns the value it
+// returns, or nil if it ends without a return.
+func (m *Machine) Run(p *Proto, args ...runtime.Value) (runtime.Value, error) {
+	return m.run(p, args)
+}
+
+func (m *Machine) run(p *Proto, args []runtime.Value) (runtime.Value, error) {
+	if err := m.halt.Load(); err != nil {
+		return runtime.NilValue, *err
+	}
+	base := m.top
+	need := p.NumLocals + p.MaxStack
+	if base+need > len(m.stack) {
+		// The frames running keep the old array; it is theirs alone
+		m.stack = make([]runtime.Value, 2*(base+need))
+	}
+	frame := m.stack[base : base+need : base+need]
+	m.top = base + need
+	copy(frame, args)
+	clear(frame[len(args):p.NumLocals])
+	v, err := m.exec(p, frame[:p.NumLocals], frame[p.NumLocals:])
+	clear(frame)
+	m.top = base
+	return v, err
+}
+
+// exec runs the code of p on its locals and operand stack
+func (m *Machine) exec(p *Proto, locals, stack []runtime.Value) (runtime.Value, error) {
+	code := p.Code
+	sp := 0
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.Op {
+		case OpConst:
+			stack[sp] = p.Consts[in.A]
+			sp++
+		case OpLoad:
+			stack[sp] = locals[in.A]
+			sp++
+		case OpStore:
+			sp--
+			locals[in.A] = stack[sp]
+		case OpGlobal:
+			v, err := m.host.Global(p.Names[in.A], in.B == 1)
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			stack[sp] = v
+			sp++
+		case OpSetGlobal:
+			sp--
+			m.host.SetGlobal(p.Names[in.A], stack[sp], in.B == 1)
+		case OpPop:
+			sp--
+
+		case OpAdd, OpSub, OpMul, OpLt, OpGt, OpLe, OpGe, OpEq, OpNe,
+			OpNumLt, OpNumGt, OpNumLe, OpNumGe, OpNumEq, OpNumNe:
+			sp--
+			a, b := stack[sp-1], stack[sp]
+			if a.Type == runtime.VTInt && b.Type == runtime.VTInt {
+				stack[sp-1] = intOp(in.Op, a.AsInt(), b.AsInt())
+				continue
+			}
+			v, err := eval.Apply(eval.Op(in.Op-OpAdd), a, b)
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			stack[sp-1] = v
+		case OpDiv, OpMod, OpBitAnd, OpBitOr, OpBitXor, OpShl, OpShr, OpAnd, OpOr:
+			sp--
+			v, err := eval.Apply(eval.Op(in.Op-OpAdd), stack[sp-1], stack[sp])
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			stack[sp-1] = v
+		case OpNeg, OpNot, OpBitNot:
+			stack[sp-1] = eval.Apply1(eval.Op(in.Op-OpAdd), stack[sp-1])
+		case OpBool:
+			stack[sp-1] = runtime.NewBool(stack[sp-1].AsBool())
+		case OpFloat:
+			if in.A == 32 && stack[sp-1].IsNumeric() {
+				stack[sp-1] = runtime.NewFloat32(stack[sp-1].AsFloat())
+			} else if stack[sp-1].Type == runtime.VTInt {
+				stack[sp-1] = runtime.NewFloat(stack[sp-1].AsFloat())
+			}
+		case OpUint:
+			if v := stack[sp-1]; v.IsNumeric() {
+				stack[sp-1] = runtime.NewUint(uint64(v.AsInt()), uint(in.A))
+			}
+		case OpConcat:
+			var sb strings.Builder
+			for _, v := range stack[sp-int(in.A) : sp] {
+				sb.WriteString(v.AsString())
+			}
+			sp -= int(in.A)
+			stack[sp] = runtime.NewString(sb.String())
+			sp++
+
+		case OpJump:
+			if int(in.A) <= pc {
+				if err := m.halt.Load(); err != nil {
+					return runtime.NilValue, *err
+				}
+			}
+			pc = int(in.A) - 1
+		case OpJumpIf:
+			sp--
+			if stack[sp].AsBool() {
+				pc = int(in.A) - 1
+			}
+		case OpJumpNot:
+			sp--
+			if !stack[sp].AsBool() {
+				pc = int(in.A) - 1
+			}
+		case OpRange:
+			if n := locals[in.A].AsInt(); n < locals[in.A+1].AsInt() {
+				locals[in.A+2] = runtime.NewInt(n)
+			} else {
+				pc = int(in.B) - 1
+			}
+		case OpInc:
+			locals[in.A] = runtime.NewInt(locals[in.A].AsInt() + 1)
+
+		case OpCall:
+			argc := int(in.B)
+			args := stack[sp-argc : sp]
+			var v runtime.Value
+			var err error
+			if callee := m.callee(p, in.A, argc); callee != nil {
+				v, err = m.run(callee, args)
+			} else {
+				v, err = m.host.Call(p.Names[in.A], args)
+			}
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			sp -= argc
+			stack[sp] = v
+			sp++
+		case OpPush:
+			sp--
+			if err := m.host.Push(p.Names[in.A], stack[sp]); err != nil {
+				return runtime.NilValue, err
+			}
+		case OpPopStack:
+			v, err := m.host.Pop(p.Names[in.A])
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			stack[sp] = v
+			sp++
+		case OpPopTo:
+			l := p.Fallbacks[in.A].Locals[0]
+			v, err := m.host.PopTo(p.Fallbacks[in.A], locals[l.Slot])
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			locals[l.Slot] = v
+		case OpExec:
+			f := p.Fallbacks[in.A]
+			flow, v, err := m.host.Exec(f, locals)
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			switch flow {
+			case FlowBreak:
+				if f.Break < 0 {
+					return runtime.NilValue, ErrBreak
+				}
+				pc = f.Break - 1
+			case FlowContinue:
+				if f.Continue < 0 {
+					return runtime.NilValue, ErrContinue
+				}
+				pc = f.Continue - 1
+			case FlowReturn:
+				if p.Name == "" {
+					return v, ErrReturn
+				}
+				return v, nil
+			}
+		case OpEval:
+			v, err := m.host.Eval(p.Fallbacks[in.A], locals)
+			if err != nil {
+				return runtime.NilValue, err
+			}
+			stack[sp] = v
+			sp++
+		case OpReturn:
+			return stack[sp-1], nil
+		case OpReturnNil:
+			return runtime.NilValue, nil
+		default:
+			return runtime.NilValue, fmt.Errorf("vm: bad instruction %s at %d", in.Op, pc)
+		}
+	}
+	return runtime.NilValue, nil
+}
+
+// intOp applies the arithmetic or comparison operator o to two ints,
+// neither of them the divisor of a division
+func intOp(o Op, x, y int64) runtime.Value {
+	switch o {
+	case OpAdd:
+		return runtime.NewInt(x + y)
+	case OpSub:
+		return runtime.NewInt(x - y)
+	case OpMul:
+		return runtime.NewInt(x * y)
+	case OpLt, OpNumLt:
+		return runtime.NewBool(x < y)
+	case OpGt, OpNumGt:
+		return runtime.NewBool(x > y)
+	case OpLe, OpNumLe:
+		return runtime.NewBool(x <= y)
+	case OpGe, OpNumGe:
+		return runtime.NewBool(x >= y)
+	case OpEq, OpNumEq:
+		return runtime.NewBool(x == y)
+	}
+	return runtime.NewBool(x != y)
+}
+
+// callee returns the Proto the OpCall of p's name n calls, if it can run
+// in the Machine, or nil
+func (m *Machine) callee(p *Proto, n int32, argc int) *Proto {
+	c := &p.calls[n]
+	if c.gen != m.gen {
+		c.proto, c.gen = m.host.Func(p.Names[n], argc), m.gen
+	}
+	return c.proto
+}
+
+// String disassembles p, one instruction a line.
+func (p *Proto) String() string {
+	var sb strings.Builder
+	for pc, in := range p.Code {
+		fmt.Fprintf(&sb, "%4d  %s", pc, in.Op)
+		switch in.Op {
+		case OpConst:
+			fmt.Fprintf(&sb, " %s", p.Consts[in.A].AsString())
+		case OpLoad, OpStore, OpInc:
+			fmt.Fprintf(&sb, " %d", in.A)
+		case OpGlobal, OpSetGlobal, OpPush:
+			fmt.Fprintf(&sb, " %s", p.Names[in.A])
+		case OpCall:
+			fmt.Fprintf(&sb, " %s %d", p.Names[in.A], in.B)
+		case OpConcat, OpJump, OpJumpIf, OpJumpNot:
+			fmt.Fprintf(&sb, " %d", in.A)
+		case OpRange:
+			fmt.Fprintf(&sb, " %d %d", in.A, in.B)
+		case OpPopTo:
+			f := p.Fallbacks[in.A]
+			fmt.Fprintf(&sb, " %s %d", f.Stmt.(*ast.StackOp).Stack, f.Locals[0].Slot)
+		case OpExec:
+			fmt.Fprintf(&sb, " %T", p.Fallbacks[in.A].Stmt)
+		case OpEval:
+			fmt.Fprintf(&sb, " %T", p.Fallbacks[in.A].Expr)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,317 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ha1tch/ual/pkg/ast"
+	"github.com/ha1tch/ual/pkg/eval"
+	"github.com/ha1tch/ual/pkg/lexer"
+	"github.com/ha1tch/ual/pkg/parser"
+	"github.com/ha1tch/ual/pkg/runtime"
+)
+
+// host is a Host for tests: its functions are compiled and run on m, and
+// the statements left to it are printed to out with the locals they see
+type host struct {
+	m       *Machine
+	globals map[string]runtime.Value
+	funcs   map[string]*Proto
+	pushed  []runtime.Value
+	out     strings.Builder
+}
+
+func newHost(t *testing.T, src string) (*host, []ast.Stmt) {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src).Tokenize()).Parse()
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	h := &host{globals: map[string]runtime.Value{}, funcs: map[string]*Proto{}}
+	h.m = New(h)
+	decls := map[string]*ast.FuncDecl{}
+	var rest []ast.Stmt
+	for _, s := range prog.Stmts {
+		if fn, ok := s.(*ast.FuncDecl); ok {
+			decls[fn.Name] = fn
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	callable := func(name string, argc int) bool {
+		fn, ok := decls[name]
+		return ok && len(fn.Params) == argc
+	}
+	for name, fn := range decls {
+		p, err := Compile(fn, callable)
+		if err != nil {
+			t.Fatalf("compile %s: %v", name, err)
+		}
+		h.funcs[name] = p
+	}
+	return h, rest
+}
+
+// run compiles and runs the statements of a program on h
+func (h *host) run(t *testing.T, stmts []ast.Stmt) {
+	t.Helper()
+	for _, s := range stmts {
+		p, err := CompileStmt(s, nil)
+		if err != nil {
+			t.Fatalf("compile %T: %v", s, err)
+		}
+		if _, err := h.m.Run(p); err != nil {
+			t.Fatalf("run:\n%s\n%v", p, err)
+		}
+	}
+}
+
+func (h *host) Global(name string, globalOnly bool) (runtime.Value, error) {
+	if v, ok := h.globals[name]; ok {
+		return v, nil
+	}
+	return runtime.NilValue, fmt.Errorf("undefined variable: %s", name)
+}
+
+func (h *host) SetGlobal(name string, v runtime.Value, report bool) {
+	h.globals[name] = v
+}
+
+func (h *host) Func(name string, argc int) *Proto {
+	if p := h.funcs[name]; p != nil && p.Pure {
+		return p
+	}
+	return nil
+}
+
+func (h *host) Call(name string, args []runtime.Value) (runtime.Value, error) {
+	p, ok := h.funcs[name]
+	if !ok {
+		return runtime.NilValue, fmt.Errorf("undefined function: %s", name)
+	}
+	return h.m.Run(p, args...)
+}
+
+func (h *host) Push(stack string, v runtime.Value) error {
+	h.pushed = append(h.pushed, v)
+	return nil
+}
+
+func (h *host) Pop(stack string) (runtime.Value, error) {
+	if len(h.pushed) == 0 {
+		return runtime.NilValue, errors.New("stack underflow")
+	}
+	v := h.pushed[len(h.pushed)-1]
+	h.pushed = h.pushed[:len(h.pushed)-1]
+	return v, nil
+}
+
+func (h *host) PopTo(f *Fallback, cur runtime.Value) (runtime.Value, error) {
+	return h.Pop(f.Stmt.(*ast.StackOp).Stack)
+}
+
+func (h *host) Exec(f *Fallback, locals []runtime.Value) (Flow, runtime.Value, error) {
+	fmt.Fprintf(&h.out, "%T", f.Stmt)
+	for _, l := range f.Locals {
+		fmt.Fprintf(&h.out, " %s=%s", l.Name, locals[l.Slot].AsString())
+	}
+	h.out.WriteString("\n")
+	return FlowNext, runtime.NilValue, nil
+}
+
+func (h *host) Eval(f *Fallback, locals []runtime.Value) (runtime.Value, error) {
+	return runtime.NewInt(100), nil
+}
+
+func TestCalls(t *testing.T) {
+	h, _ := newHost(t, `
+func fib(n i64) i64 {
+    if (n < 2) {
+        return n
+    }
+    return fib(n - 1) + fib(n - 2)
+}
+func half(x f64) f64 {
+    var y f64 = x / 2
+    return y
+}
+`)
+	fib := h.funcs["fib"]
+	if !fib.Pure {
+		t.Errorf("fib is not pure:\n%s", fib)
+	}
+	v, err := h.m.Run(fib, runtime.NewInt(20))
+	if err != nil || v.AsInt() != 6765 {
+		t.Errorf("fib(20) = %v, %v; want 6765", v, err)
+	}
+	v, err = h.m.Run(h.funcs["half"], runtime.NewFloat(3))
+	if err != nil || v.AsFloat() != 1.5 {
+		t.Errorf("half(3) = %v, %v; want 1.5", v, err)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	h, stmts := newHost(t, `
+while (i < 10) {
+    i = i + 1
+    if (i == 5) {
+        continue
+    }
+    if (i == 8) {
+        break
+    }
+    total = total + i
+}
+for k in 1..4 {
+    squares = squares + k * k
+    @dstack push(k)
+}
+`)
+	h.globals["total"] = runtime.NewInt(0)
+	h.globals["i"] = runtime.NewInt(0)
+	h.globals["squares"] = runtime.NewInt(0)
+	h.run(t, stmts)
+	if got := h.globals["total"].AsInt(); got != 1+2+3+4+6+7 {
+		t.Errorf("total = %d, want %d", got, 1+2+3+4+6+7)
+	}
+	if got := h.globals["squares"].AsInt(); got != 1+4+9 {
+		t.Errorf("squares = %d, want %d", got, 1+4+9)
+	}
+	if len(h.pushed) != 3 || h.pushed[2].AsInt() != 3 {
+		t.Errorf("pushed %v, want [1 2 3]", h.pushed)
+	}
+}
+
+func TestLet(t *testing.T) {
+	h, _ := newHost(t, `
+func wrap(n i64) i64 {
+    var small u8 = 250
+    for k in 0..n {
+        push:(small + 5)
+        let:small
+    }
+    return small
+}
+func empty() i64 {
+    var x i64 = 0
+    let:x
+    return x
+}
+func popped() i64 {
+    var x i64 = 0
+    @s push(7)
+    @s pop:x
+    return x
+}
+`)
+	p := h.funcs["wrap"]
+	if len(p.Fallbacks) > 0 {
+		t.Errorf("let left to the host:\n%s", p)
+	}
+	v, err := h.m.Run(p, runtime.NewInt(3))
+	if err != nil || v.AsInt() != 9 || v.UintBits() != 8 {
+		t.Errorf("wrap(3) = %v, %v; want u8 9", v, err)
+	}
+	// Each let popped what the push before it pushed
+	if len(h.pushed) > 0 {
+		t.Errorf("left %v on the stack", h.pushed)
+	}
+	if _, err := h.m.Run(p, runtime.NewInt(0)); err != nil {
+		t.Errorf("wrap(0): %v", err)
+	}
+	if _, err := h.m.Run(h.funcs["empty"]); err == nil || err.Error() != "stack underflow" {
+		t.Errorf("empty(): %v, want stack underflow", err)
+	}
+	// pop:x pops through the host, into the local
+	p = h.funcs["popped"]
+	if !p.Pure || !strings.Contains(p.String(), "popto s 0") {
+		t.Errorf("pop:x not compiled:\n%s", p)
+	}
+	if v, err := h.m.Run(p); err != nil || v.AsInt() != 7 {
+		t.Errorf("popped() = %v, %v; want 7", v, err)
+	}
+}
+
+func TestFallbacks(t *testing.T) {
+	h, _ := newHost(t, `
+func show(n i64) i64 {
+    var i i64 = 0
+    while (i < n) {
+        print(i)
+        i = i + 1
+    }
+    return i + sqrt(4)
+}
+`)
+	p := h.funcs["show"]
+	if p.Pure {
+		t.Errorf("show is pure, though it prints:\n%s", p)
+	}
+	v, err := h.m.Run(p, runtime.NewInt(2))
+	if err != nil || v.AsInt() != 102 {
+		t.Errorf("show(2) = %v, %v; want 102", v, err)
+	}
+	// print(i) is a stack operation, on no stack
+	want := "*ast.StackOp i=0\n*ast.StackOp i=1\n"
+	if h.out.String() != want {
+		t.Errorf("host ran:\n%s\nwant:\n%s", h.out.String(), want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	h, stmts := newHost(t, `
+func quot(a i64, b i64) i64 {
+    return a / b
+}
+x = y + 1
+`)
+	if _, err := h.m.Run(h.funcs["quot"], runtime.NewInt(1), runtime.NewInt(0)); err == nil || err.Error() != "division by zero" {
+		t.Errorf("quot(1, 0): %v, want division by zero", err)
+	}
+	p, err := CompileStmt(stmts[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.m.Run(p); err == nil || err.Error() != "undefined variable: y" {
+		t.Errorf("x = y + 1: %v, want undefined variable: y", err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	h, _ := newHost(t, `
+func spin(n i64) i64 {
+    while (n > 0) {
+        n = n + 1
+    }
+    return n
+}
+`)
+	stop := errors.New("stopped")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.m.Interrupt(stop)
+	}()
+	if _, err := h.m.Run(h.funcs["spin"], runtime.NewInt(1)); err != stop {
+		t.Errorf("spin: %v, want stopped", err)
+	}
+	if _, err := h.m.Run(h.funcs["spin"], runtime.NewInt(0)); err != stop {
+		t.Errorf("run after Interrupt: %v, want stopped", err)
+	}
+}
+
+func TestOpOrder(t *testing.T) {
+	for _, tc := range []struct {
+		vm   Op
+		eval eval.Op
+	}{
+		{OpAdd, eval.Add}, {OpNumGe, eval.NumGe}, {OpBitAnd, eval.BitAnd},
+		{OpEq, eval.Eq}, {OpOr, eval.Or}, {OpNeg, eval.Neg}, {OpBitNot, eval.BitNot},
+	} {
+		if tc.vm != OpAdd+Op(tc.eval) {
+			t.Errorf("%s is not OpAdd+%s", tc.vm, tc.eval)
+		}
+	}
+}
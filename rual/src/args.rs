@@ -0,0 +1,325 @@
+//! Command-line arguments for `args` blocks
+//!
+//! Mirrors the Go runtime's ParseArgs: flags are `--name` or `-n` and take no
+//! value (`--name=false` is accepted); options take a value as `--name v`,
+//! `--name=v`, `-n v` or `-nv`; positionals are filled in order and one
+//! without a default is required. `--` ends option parsing and `-h`/`--help`
+//! prints usage unless an entry claims that name.
+
+use std::collections::HashMap;
+
+use crate::{Result, Stack};
+
+/// One entry of an args block
+#[derive(Debug, Clone)]
+pub struct ArgSpec {
+    pub kind: &'static str, // "flag", "opt" or "pos"
+    pub name: &'static str,
+    pub short: &'static str, // single letter, "" if none
+    pub ty: &'static str,    // "bool", "string", "i64" or "f64"
+    pub default: Option<String>,
+    pub help: &'static str,
+}
+
+/// Why argument parsing stopped
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum ArgsError {
+    Help,
+    Invalid(String),
+}
+
+impl std::fmt::Display for ArgsError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            ArgsError::Help => write!(f, "help requested"),
+            ArgsError::Invalid(msg) => write!(f, "{}", msg),
+        }
+    }
+}
+
+impl std::error::Error for ArgsError {}
+
+/// Parsed argument values in string form, keyed by name
+#[derive(Debug, Clone)]
+pub struct Args {
+    specs: Vec<ArgSpec>,
+    values: HashMap<&'static str, String>,
+}
+
+/// Parse `argv` (without the program name) against `specs`
+pub fn parse_args(specs: &[ArgSpec], argv: &[String]) -> std::result::Result<Args, ArgsError> {
+    let invalid = |msg: String| Err(ArgsError::Invalid(msg));
+    let mut a = Args { specs: specs.to_vec(), values: HashMap::new() };
+    for sp in specs {
+        let zero = match (&sp.default, sp.ty) {
+            (Some(d), _) => d.clone(),
+            (None, "bool") => "false".to_string(),
+            (None, "i64") | (None, "f64") => "0".to_string(),
+            (None, _) => String::new(),
+        };
+        a.values.insert(sp.name, zero);
+    }
+    let positionals: Vec<&ArgSpec> = specs.iter().filter(|s| s.kind == "pos").collect();
+
+    let mut npos = 0;
+    let mut options_done = false;
+    let mut i = 0;
+    while i < argv.len() {
+        let arg = argv[i].as_str();
+        i += 1;
+
+        if options_done || arg == "-" || !arg.starts_with('-') || arg.parse::<f64>().is_ok() {
+            match positionals.get(npos) {
+                Some(sp) => set(&mut a.values, sp, sp.name, arg)?,
+                None => return invalid(format!("unexpected argument {:?}", arg)),
+            }
+            npos += 1;
+            continue;
+        }
+        if arg == "--" {
+            options_done = true;
+            continue;
+        }
+
+        // --name[=value] or -n[value]
+        let (label, sp, inline) = if let Some(rest) = arg.strip_prefix("--") {
+            let (name, value) = match rest.split_once('=') {
+                Some((n, v)) => (n, Some(v.to_string())),
+                None => (rest, None),
+            };
+            (format!("--{}", name), specs.iter().find(|s| s.kind != "pos" && s.name == name), value)
+        } else {
+            let end = 1 + arg[1..].chars().next().map_or(0, char::len_utf8);
+            let short = &arg[1..end];
+            let value = (arg.len() > end).then(|| arg[end..].trim_start_matches('=').to_string());
+            (format!("-{}", short), specs.iter().find(|s| s.kind != "pos" && s.short == short), value)
+        };
+        let sp = match sp {
+            Some(sp) => sp,
+            None if label == "--help" || label == "-h" => return Err(ArgsError::Help),
+            None => return invalid(format!("unknown option {}", label)),
+        };
+
+        let value = match inline {
+            Some(v) => v,
+            None if sp.kind == "flag" => "true".to_string(),
+            None => match argv.get(i) {
+                Some(v) => {
+                    i += 1;
+                    v.clone()
+                }
+                None => return invalid(format!("{} requires a value", label)),
+            },
+        };
+        set(&mut a.values, sp, &label, &value)?;
+    }
+
+    if let Some(sp) = positionals[npos..].iter().find(|s| s.default.is_none()) {
+        return invalid(format!("missing argument <{}>", sp.name));
+    }
+    Ok(a)
+}
+
+/// Push the process arguments, without the program name, onto `stack` in
+/// the order they were given and return how many there were: `args(@s)`
+pub fn push_args(stack: &Stack<String>) -> i64 {
+    let mut n = 0;
+    for arg in std::env::args().skip(1) {
+        let _ = stack.push(arg);
+        n += 1;
+    }
+    n
+}
+
+/// Parse the process arguments, printing usage and exiting on -h/--help
+/// (status 0) or on a parse error (status 2). An empty `prog` uses the
+/// executable's name.
+pub fn parse_args_or_exit(prog: &str, specs: &[ArgSpec]) -> Args {
+    let argv: Vec<String> = std::env::args().skip(1).collect();
+    let prog = if prog.is_empty() {
+        std::env::args()
+            .next()
+            .and_then(|p| std::path::Path::new(&p).file_name().map(|f| f.to_string_lossy().into_owned()))
+            .unwrap_or_default()
+    } else {
+        prog.to_string()
+    };
+    match parse_args(specs, &argv) {
+        Ok(a) => a,
+        Err(ArgsError::Help) => {
+            print!("{}", args_usage(&prog, specs));
+            std::process::exit(0);
+        }
+        Err(e) => {
+            eprint!("{}: {}\n{}", prog, e, args_usage(&prog, specs));
+            std::process::exit(2);
+        }
+    }
+}
+
+/// Validate `value` against the spec's type and store it
+fn set(
+    values: &mut HashMap<&'static str, String>,
+    sp: &ArgSpec,
+    label: &str,
+    value: &str,
+) -> std::result::Result<(), ArgsError> {
+    let stored = match sp.ty {
+        "bool" => parse_bool(value).map(|b| b.to_string()),
+        "i64" => value.parse::<i64>().ok().map(|_| value.to_string()),
+        "f64" => value.parse::<f64>().ok().map(|_| value.to_string()),
+        _ => Some(value.to_string()),
+    };
+    match stored {
+        Some(v) => {
+            values.insert(sp.name, v);
+            Ok(())
+        }
+        None => Err(ArgsError::Invalid(format!(
+            "invalid value {:?} for {}: expected {}",
+            value, label, sp.ty
+        ))),
+    }
+}
+
+/// Accepts the same spellings as Go's strconv.ParseBool
+fn parse_bool(s: &str) -> Option<bool> {
+    match s {
+        "1" | "t" | "T" | "true" | "TRUE" | "True" => Some(true),
+        "0" | "f" | "F" | "false" | "FALSE" | "False" => Some(false),
+        _ => None,
+    }
+}
+
+impl Args {
+    /// The value of `name` as given on the command line
+    pub fn get_string(&self, name: &str) -> String {
+        self.values.get(name).cloned().unwrap_or_default()
+    }
+
+    /// The value of an i64 argument
+    pub fn get_i64(&self, name: &str) -> i64 {
+        self.values.get(name).and_then(|v| v.parse().ok()).unwrap_or(0)
+    }
+
+    /// The value of an f64 argument
+    pub fn get_f64(&self, name: &str) -> f64 {
+        self.values.get(name).and_then(|v| v.parse().ok()).unwrap_or(0.0)
+    }
+
+    /// The value of a bool argument
+    pub fn get_bool(&self, name: &str) -> bool {
+        self.values.get(name).map_or(false, |v| v == "true")
+    }
+
+    /// Store every value in a Hash stack of strings, keyed by name
+    pub fn fill(&self, s: &Stack<String>) -> Result<()> {
+        for sp in &self.specs {
+            s.push_keyed(sp.name, self.get_string(sp.name))?;
+        }
+        Ok(())
+    }
+}
+
+/// Format a usage message for `specs`
+pub fn args_usage(prog: &str, specs: &[ArgSpec]) -> String {
+    let mut out = format!("usage: {} [options]", prog);
+    let mut rows: Vec<(String, String)> = Vec::new();
+    let mut has_help = false;
+    for sp in specs {
+        if sp.kind == "pos" {
+            if sp.default.is_some() {
+                out.push_str(&format!(" [{}]", sp.name));
+            } else {
+                out.push_str(&format!(" <{}>", sp.name));
+            }
+            continue;
+        }
+        let mut left = if sp.short.is_empty() {
+            format!("    --{}", sp.name)
+        } else {
+            format!("-{}, --{}", sp.short, sp.name)
+        };
+        if sp.kind == "opt" {
+            left.push_str(&format!(" {}", sp.ty));
+        }
+        let mut help = sp.help.to_string();
+        if let (Some(d), "opt") = (&sp.default, sp.kind) {
+            help = format!("{} (default {:?})", help, d).trim().to_string();
+        }
+        rows.push((left, help));
+        has_help = has_help || sp.name == "help" || sp.short == "h";
+    }
+    if !has_help {
+        rows.push(("-h, --help".to_string(), "show this help".to_string()));
+    }
+    out.push_str("\n\noptions:\n");
+
+    let width = rows.iter().map(|(l, _)| l.len()).max().unwrap_or(0);
+    for (left, help) in rows {
+        let line = format!("  {:<width$}  {}", left, help, width = width);
+        out.push_str(line.trim_end());
+        out.push('\n');
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn specs() -> Vec<ArgSpec> {
+        let spec = |kind, name, short, ty, default: Option<&str>| ArgSpec {
+            kind,
+            name,
+            short,
+            ty,
+            default: default.map(String::from),
+            help: "",
+        };
+        vec![
+            spec("flag", "verbose", "v", "bool", None),
+            spec("opt", "output", "o", "string", Some("out.txt")),
+            spec("opt", "count", "n", "i64", None),
+            spec("pos", "input", "", "string", None),
+        ]
+    }
+
+    fn argv(args: &[&str]) -> Vec<String> {
+        args.iter().map(|s| s.to_string()).collect()
+    }
+
+    #[test]
+    fn test_parse_args_forms() {
+        let a = parse_args(&specs(), &argv(&["-v", "--output=a", "-n", "-4", "x"])).unwrap();
+        assert!(a.get_bool("verbose"));
+        assert_eq!(a.get_string("output"), "a");
+        assert_eq!(a.get_i64("count"), -4);
+        assert_eq!(a.get_string("input"), "x");
+
+        let a = parse_args(&specs(), &argv(&["-ob", "--", "-v"])).unwrap();
+        assert!(!a.get_bool("verbose"));
+        assert_eq!(a.get_string("output"), "b");
+        assert_eq!(a.get_string("input"), "-v");
+    }
+
+    #[test]
+    fn test_parse_args_errors() {
+        let err = |args: &[&str]| parse_args(&specs(), &argv(args)).unwrap_err().to_string();
+        assert_eq!(err(&[]), "missing argument <input>");
+        assert_eq!(err(&["--nope", "x"]), "unknown option --nope");
+        assert_eq!(err(&["x", "-o"]), "-o requires a value");
+        assert_eq!(err(&["-n", "many", "x"]), "invalid value \"many\" for -n: expected i64");
+        assert_eq!(err(&["x", "y"]), "unexpected argument \"y\"");
+        assert_eq!(parse_args(&specs(), &argv(&["-h"])).unwrap_err(), ArgsError::Help);
+    }
+
+    #[test]
+    fn test_args_usage() {
+        let usage = args_usage("tool", &specs());
+        assert!(usage.starts_with("usage: tool [options] <input>\n"));
+        assert!(usage.contains("  -o, --output string  (default \"out.txt\")\n"));
+        assert!(usage.contains("  -v, --verbose\n"));
+        assert!(usage.contains("  -h, --help           show this help\n"));
+    }
+}
@@ -0,0 +1,134 @@
+//! Exit hooks for `@atexit` blocks
+//!
+//! Mirrors the Go runtime: hooks run LIFO, each at most once, when main
+//! returns (after its deferred blocks), on `exit(code)`, and on SIGTERM.
+//! `exit` does not unwind, so pending defers are skipped. Generated `main`
+//! holds an [`AtExitGuard`] so the hooks also run when main panics.
+//!
+//! SIGTERM handling is installed by the first [`at_exit`] call (Unix only).
+//! The signal handler just writes to a pipe; a watcher thread runs the hooks
+//! and exits with status 143. This is best-effort: hooks may run while other
+//! threads are still working.
+
+use std::panic::{catch_unwind, AssertUnwindSafe};
+use std::sync::{Mutex, Once};
+
+type Hook = Box<dyn FnOnce() + Send + 'static>;
+
+static HOOKS: Mutex<Vec<Hook>> = Mutex::new(Vec::new());
+static SIGNALS: Once = Once::new();
+
+/// Push `f` onto the exit hook stack
+pub fn at_exit<F: FnOnce() + Send + 'static>(f: F) {
+    SIGNALS.call_once(signals::install);
+    HOOKS.lock().unwrap_or_else(|e| e.into_inner()).push(Box::new(f));
+}
+
+/// Pop and run the exit hooks in LIFO order until none are left, including
+/// hooks registered by other hooks. A hook that panics is reported on
+/// stderr and the rest still run.
+pub fn run_at_exit() {
+    loop {
+        let hook = HOOKS.lock().unwrap_or_else(|e| e.into_inner()).pop();
+        let Some(hook) = hook else { return };
+        if let Err(e) = catch_unwind(AssertUnwindSafe(hook)) {
+            let msg = e
+                .downcast_ref::<&str>()
+                .map(|s| s.to_string())
+                .or_else(|| e.downcast_ref::<String>().cloned())
+                .unwrap_or_else(|| "panic".to_string());
+            eprintln!("atexit: {}", msg);
+        }
+    }
+}
+
+/// Run the exit hooks and end the program with status `code`
+pub fn exit(code: i32) -> ! {
+    run_at_exit();
+    std::process::exit(code)
+}
+
+/// Runs the exit hooks when dropped, including during a panic
+pub struct AtExitGuard;
+
+impl Drop for AtExitGuard {
+    fn drop(&mut self) {
+        run_at_exit();
+    }
+}
+
+#[cfg(unix)]
+mod signals {
+    use std::os::raw::c_int;
+    use std::sync::atomic::{AtomicI32, Ordering};
+
+    const SIGTERM: c_int = 15;
+
+    extern "C" {
+        fn pipe(fds: *mut c_int) -> c_int;
+        fn read(fd: c_int, buf: *mut u8, n: usize) -> isize;
+        fn write(fd: c_int, buf: *const u8, n: usize) -> isize;
+        fn signal(sig: c_int, handler: usize) -> usize;
+    }
+
+    static WRITE_FD: AtomicI32 = AtomicI32::new(-1);
+
+    // Only async-signal-safe work here: wake the watcher thread
+    extern "C" fn on_signal(_: c_int) {
+        let b = 1u8;
+        unsafe { write(WRITE_FD.load(Ordering::Relaxed), &b, 1) };
+    }
+
+    pub fn install() {
+        let mut fds = [0 as c_int; 2];
+        if unsafe { pipe(fds.as_mut_ptr()) } != 0 {
+            return;
+        }
+        WRITE_FD.store(fds[1], Ordering::Relaxed);
+        let read_fd = fds[0];
+        let spawned = std::thread::Builder::new().name("ual-atexit".into()).spawn(move || {
+            let mut b = 0u8;
+            if unsafe { read(read_fd, &mut b, 1) } == 1 {
+                unsafe { signal(SIGTERM, 0) }; // SIG_DFL: a second SIGTERM kills outright
+                super::exit(128 + SIGTERM);
+            }
+        });
+        if spawned.is_ok() {
+            unsafe { signal(SIGTERM, on_signal as extern "C" fn(c_int) as usize) };
+        }
+    }
+}
+
+#[cfg(not(unix))]
+mod signals {
+    pub fn install() {}
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Arc;
+
+    #[test]
+    fn test_run_at_exit_lifo() {
+        let order = Arc::new(Mutex::new(Vec::new()));
+        let push = |n: i32| {
+            let order = Arc::clone(&order);
+            move || order.lock().unwrap().push(n)
+        };
+        at_exit(push(1));
+        let (o, inner) = (Arc::clone(&order), push(4));
+        at_exit(move || {
+            o.lock().unwrap().push(2);
+            at_exit(inner); // registered while exiting
+        });
+        at_exit(|| panic!("boom"));
+        at_exit(push(3));
+
+        drop(AtExitGuard);
+        assert_eq!(*order.lock().unwrap(), vec![3, 2, 4, 1]);
+
+        run_at_exit(); // hooks run once
+        assert_eq!(order.lock().unwrap().len(), 4);
+    }
+}
@@ -0,0 +1,323 @@
+//! Benchmarks for `bench` blocks under `ual bench`
+//!
+//! Mirrors the Go runtime's bench package: [`run_bench`] runs a body once
+//! to warm up, then over a growing number of iterations until a run takes
+//! `$UAL_BENCHTIME` (1s unless set; `100x` runs exactly 100 iterations),
+//! and appends the result as a line of JSON to the file named by
+//! `$UAL_BENCH_REPORT`, or prints it to stderr without it.
+//!
+//! Allocations are counted when the crate is built with the `bench`
+//! feature, which installs a counting global allocator; without it they
+//! are reported as zero.
+
+use std::io::Write;
+use std::panic::{catch_unwind, AssertUnwindSafe};
+use std::time::{Duration, Instant};
+
+/// The environment variable naming the bench report file
+pub const BENCH_REPORT_ENV: &str = "UAL_BENCH_REPORT";
+
+/// The environment variable holding the time, or the iteration count with
+/// an `x` suffix, each benchmark runs for
+pub const BENCH_TIME_ENV: &str = "UAL_BENCHTIME";
+
+/// The outcome of one bench block
+#[derive(Debug, Clone, PartialEq)]
+pub struct BenchResult {
+    pub name: String,
+    pub file: String,
+    pub line: i64,
+    pub n: u64,
+    pub ns_per_op: f64,
+    pub bytes_per_op: u64,
+    pub allocs_per_op: u64,
+    pub message: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum BenchTime {
+    Time(Duration),
+    Count(u64),
+}
+
+/// Iterations timed in one run, and what they took
+struct Sample {
+    n: u64,
+    elapsed: Duration,
+    bytes: u64,
+    allocs: u64,
+}
+
+/// Time `body` as the bench block `name`, declared at `file` and `line`,
+/// and report the result. A panic in the body ends the benchmark and is
+/// its message.
+pub fn run_bench(name: &str, file: &str, line: i64, body: &mut dyn FnMut()) -> BenchResult {
+    let mut r = BenchResult {
+        name: name.to_string(),
+        file: file.to_string(),
+        line,
+        n: 0,
+        ns_per_op: 0.0,
+        bytes_per_op: 0,
+        allocs_per_op: 0,
+        message: None,
+    };
+    let time = parse_bench_time(&std::env::var(BENCH_TIME_ENV).unwrap_or_default());
+    match catch_unwind(AssertUnwindSafe(|| measure(time, body))) {
+        Ok(s) => {
+            r.n = s.n;
+            r.ns_per_op = s.elapsed.as_nanos() as f64 / s.n as f64;
+            r.bytes_per_op = s.bytes / s.n;
+            r.allocs_per_op = s.allocs / s.n;
+        }
+        Err(e) => {
+            let msg = e
+                .downcast_ref::<&str>()
+                .map(|s| s.to_string())
+                .or_else(|| e.downcast_ref::<String>().cloned())
+                .unwrap_or_else(|| "panic".to_string());
+            r.message = Some(msg);
+        }
+    }
+    report(&r);
+    r
+}
+
+fn measure(time: BenchTime, body: &mut dyn FnMut()) -> Sample {
+    let mut s = run(1, body);
+    match time {
+        BenchTime::Count(n) => {
+            if n > 1 {
+                s = run(n, body);
+            }
+        }
+        BenchTime::Time(goal) => {
+            // Like testing.B: aim 20% past the goal from the last rate,
+            // growing at most 100x a run
+            let goal = goal.as_nanos();
+            while s.elapsed.as_nanos() < goal && s.n < 1_000_000_000 {
+                let per_op = (s.elapsed.as_nanos() / s.n as u128).max(1);
+                let mut n = (goal / per_op) as u64;
+                n += n / 5;
+                n = n.min(s.n * 100).max(s.n + 1).min(1_000_000_000);
+                s = run(n, body);
+            }
+        }
+    }
+    s
+}
+
+fn run(n: u64, body: &mut dyn FnMut()) -> Sample {
+    let (allocs, bytes) = alloc_counts();
+    let start = Instant::now();
+    for _ in 0..n {
+        body();
+    }
+    let elapsed = start.elapsed();
+    let (allocs_after, bytes_after) = alloc_counts();
+    Sample {
+        n,
+        elapsed,
+        bytes: bytes_after - bytes,
+        allocs: allocs_after - allocs,
+    }
+}
+
+/// Parse `$UAL_BENCHTIME`: `100x`, or a duration such as `2s`, `500ms` or
+/// `1.5s`. Anything else is the default, 1s.
+fn parse_bench_time(s: &str) -> BenchTime {
+    let s = s.trim();
+    if let Some(n) = s.strip_suffix('x').and_then(|n| n.parse::<u64>().ok()) {
+        if n > 0 {
+            return BenchTime::Count(n);
+        }
+    }
+    parse_duration(s)
+        .map(BenchTime::Time)
+        .unwrap_or(BenchTime::Time(Duration::from_secs(1)))
+}
+
+fn parse_duration(s: &str) -> Option<Duration> {
+    let units = [
+        ("ns", 1e-9),
+        ("us", 1e-6),
+        ("µs", 1e-6),
+        ("ms", 1e-3),
+        ("s", 1.0),
+        ("m", 60.0),
+        ("h", 3600.0),
+    ];
+    for (suffix, secs) in units {
+        if let Some(v) = s.strip_suffix(suffix).and_then(|v| v.parse::<f64>().ok()) {
+            if v > 0.0 && v.is_finite() {
+                return Some(Duration::from_secs_f64(v * secs));
+            }
+        }
+    }
+    None
+}
+
+fn report(r: &BenchResult) {
+    let path = std::env::var(BENCH_REPORT_ENV).unwrap_or_default();
+    if path.is_empty() {
+        match &r.message {
+            Some(msg) => eprintln!("FAIL {}\n  {}", r.name, msg),
+            None => eprintln!(
+                "{}\t{}\t{:.2} ns/op\t{} B/op\t{} allocs/op",
+                r.name, r.n, r.ns_per_op, r.bytes_per_op, r.allocs_per_op
+            ),
+        }
+        return;
+    }
+    let file = std::fs::OpenOptions::new().create(true).append(true).open(&path);
+    match file {
+        Ok(mut f) => {
+            let _ = writeln!(f, "{}", to_json(r));
+        }
+        Err(e) => eprintln!("ual: bench report: {}", e),
+    }
+}
+
+/// The report line of `r`, in the Go runtime's field names
+fn to_json(r: &BenchResult) -> String {
+    let mut s = format!(
+        "{{\"name\":{},\"file\":{},\"line\":{},\"n\":{},\"ns_per_op\":{},\"bytes_per_op\":{},\"allocs_per_op\":{}",
+        json_string(&r.name),
+        json_string(&r.file),
+        r.line,
+        r.n,
+        r.ns_per_op,
+        r.bytes_per_op,
+        r.allocs_per_op
+    );
+    if let Some(msg) = &r.message {
+        s.push_str(&format!(",\"message\":{}", json_string(msg)));
+    }
+    s.push('}');
+    s
+}
+
+fn json_string(s: &str) -> String {
+    let mut out = String::from("\"");
+    for c in s.chars() {
+        match c {
+            '"' => out.push_str("\\\""),
+            '\\' => out.push_str("\\\\"),
+            '\n' => out.push_str("\\n"),
+            '\r' => out.push_str("\\r"),
+            '\t' => out.push_str("\\t"),
+            c if (c as u32) < 0x20 => out.push_str(&format!("\\u{:04x}", c as u32)),
+            c => out.push(c),
+        }
+    }
+    out.push('"');
+    out
+}
+
+#[cfg(feature = "bench")]
+mod counting {
+    use std::alloc::{GlobalAlloc, Layout, System};
+    use std::sync::atomic::{AtomicU64, Ordering};
+
+    pub static ALLOCS: AtomicU64 = AtomicU64::new(0);
+    pub static BYTES: AtomicU64 = AtomicU64::new(0);
+
+    /// The system allocator, counting allocations and the bytes asked for
+    pub struct Counting;
+
+    fn count(size: usize) {
+        ALLOCS.fetch_add(1, Ordering::Relaxed);
+        BYTES.fetch_add(size as u64, Ordering::Relaxed);
+    }
+
+    unsafe impl GlobalAlloc for Counting {
+        unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+            count(layout.size());
+            System.alloc(layout)
+        }
+
+        unsafe fn alloc_zeroed(&self, layout: Layout) -> *mut u8 {
+            count(layout.size());
+            System.alloc_zeroed(layout)
+        }
+
+        unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+            count(new_size);
+            System.realloc(ptr, layout, new_size)
+        }
+
+        unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+            System.dealloc(ptr, layout)
+        }
+    }
+
+    #[global_allocator]
+    static GLOBAL: Counting = Counting;
+}
+
+/// Allocations and bytes allocated so far
+fn alloc_counts() -> (u64, u64) {
+    #[cfg(feature = "bench")]
+    {
+        use std::sync::atomic::Ordering;
+        (
+            counting::ALLOCS.load(Ordering::Relaxed),
+            counting::BYTES.load(Ordering::Relaxed),
+        )
+    }
+    #[cfg(not(feature = "bench"))]
+    {
+        (0, 0)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_bench_time() {
+        assert_eq!(parse_bench_time("100x"), BenchTime::Count(100));
+        assert_eq!(parse_bench_time("2s"), BenchTime::Time(Duration::from_secs(2)));
+        assert_eq!(parse_bench_time("500ms"), BenchTime::Time(Duration::from_millis(500)));
+        assert_eq!(parse_bench_time("1.5s"), BenchTime::Time(Duration::from_millis(1500)));
+        assert_eq!(parse_bench_time(""), BenchTime::Time(Duration::from_secs(1)));
+        assert_eq!(parse_bench_time("0x"), BenchTime::Time(Duration::from_secs(1)));
+        assert_eq!(parse_bench_time("soon"), BenchTime::Time(Duration::from_secs(1)));
+    }
+
+    #[test]
+    fn test_measure_count() {
+        let mut calls = 0;
+        let s = measure(BenchTime::Count(50), &mut || calls += 1);
+        assert_eq!(s.n, 50);
+        assert_eq!(calls, 51); // one more to warm up
+    }
+
+    #[test]
+    fn test_measure_time() {
+        let s = measure(BenchTime::Time(Duration::from_millis(20)), &mut || {
+            std::hint::black_box(0);
+        });
+        assert!(s.n > 1);
+        assert!(s.elapsed >= Duration::from_millis(20) || s.n == 1_000_000_000);
+    }
+
+    #[test]
+    fn test_to_json() {
+        let r = BenchResult {
+            name: "push \"a\"".to_string(),
+            file: "a_test.ual".to_string(),
+            line: 3,
+            n: 10,
+            ns_per_op: 12.5,
+            bytes_per_op: 0,
+            allocs_per_op: 0,
+            message: Some("boom\n".to_string()),
+        };
+        assert_eq!(
+            to_json(&r),
+            r#"{"name":"push \"a\"","file":"a_test.ual","line":3,"n":10,"ns_per_op":12.5,"bytes_per_op":0,"allocs_per_op":0,"message":"boom\n"}"#
+        );
+    }
+}
@@ -0,0 +1,76 @@
+//! Codeblock values: `call(f, args...)` and `apply(f, @s)`
+//!
+//! Mirrors the Go runtime. A codeblock used as a value is registered here
+//! and the value itself is its handle, an `i64`, so it lives on i64 stacks
+//! and in i64 variables. Handles count up from 1; registered codeblocks
+//! stay alive until the program ends.
+
+use std::sync::{Arc, RwLock};
+
+type Body = Arc<dyn Fn(&[i64]) -> i64 + Send + Sync>;
+
+static FNS: RwLock<Vec<(usize, Body)>> = RwLock::new(Vec::new());
+
+/// Register a codeblock taking `params` arguments and return its handle
+pub fn new_fn(params: usize, body: impl Fn(&[i64]) -> i64 + Send + Sync + 'static) -> i64 {
+    let mut fns = FNS.write().unwrap_or_else(|e| e.into_inner());
+    fns.push((params, Arc::new(body)));
+    fns.len() as i64
+}
+
+fn lookup(h: i64, what: &str) -> (usize, Body) {
+    let fns = FNS.read().unwrap_or_else(|e| e.into_inner());
+    if h < 1 || h as usize > fns.len() {
+        panic!("{}: {} is not a codeblock", what, h);
+    }
+    let (params, body) = &fns[h as usize - 1];
+    (*params, Arc::clone(body))
+}
+
+/// Call the codeblock with handle `h`. Panics if `h` is not a codeblock
+/// or `args` does not match its parameters.
+pub fn call_fn(h: i64, args: &[i64]) -> i64 {
+    let (params, body) = lookup(h, "call");
+    if args.len() != params {
+        panic!("call: codeblock takes {} arguments, got {}", params, args.len());
+    }
+    body(args)
+}
+
+/// Call the codeblock with handle `h`, taking one argument per parameter
+/// from `pop`, the last argument first. Panics if `h` is not a codeblock
+/// or `pop` runs out.
+pub fn apply_fn(h: i64, mut pop: impl FnMut() -> Option<i64>) -> i64 {
+    let (params, body) = lookup(h, "apply");
+    let mut args = vec![0; params];
+    for i in (0..params).rev() {
+        match pop() {
+            Some(v) => args[i] = v,
+            None => panic!("apply: codeblock takes {} arguments", params),
+        }
+    }
+    body(&args)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_call_fn() {
+        let base = 10;
+        let add = new_fn(2, move |a| a[0] + a[1] + base);
+        assert!(add >= 1);
+        assert_eq!(call_fn(add, &[1, 2]), 13);
+        assert!(std::panic::catch_unwind(|| call_fn(add, &[1])).is_err());
+        assert!(std::panic::catch_unwind(|| call_fn(0, &[])).is_err());
+    }
+
+    #[test]
+    fn test_apply_fn() {
+        let sub = new_fn(2, |a| a[0] - a[1]);
+        let mut stack = vec![10, 3];
+        assert_eq!(apply_fn(sub, || stack.pop()), 7);
+        assert!(stack.is_empty());
+    }
+}
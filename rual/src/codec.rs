@@ -0,0 +1,254 @@
+//! Binary-to-text codecs for `b64encode`/`b64decode` and `hexencode`/`hexdecode`
+//!
+//! Mirrors the Go runtime: [`Codec::encode`] and [`Codec::decode`] convert
+//! one element, and generated code applies them to a whole stack with
+//! [`Stack::try_walk`](crate::Stack::try_walk). Payloads too large to hold
+//! as a single element can be streamed with [`Codec::encode_stream`] and
+//! [`Codec::decode_stream`].
+
+use std::io::{self, Read, Write};
+
+const B64: &[u8; 64] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+const HEX: &[u8; 16] = b"0123456789abcdef";
+
+/// Size of the chunks read by the streaming functions (a multiple of 3 and 4)
+const STREAM_CHUNK: usize = 3 * 4 * 1024;
+
+/// A binary-to-text encoding
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Codec {
+    /// Standard padded base64 (RFC 4648)
+    Base64,
+    /// Lowercase hexadecimal; decoding accepts either case
+    Hex,
+}
+
+/// Input that is not valid for a codec
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CodecError {
+    pub codec: &'static str,
+    pub offset: usize,
+}
+
+impl std::fmt::Display for CodecError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{} decode: illegal {} data at input byte {}", self.codec, self.codec, self.offset)
+    }
+}
+
+impl std::error::Error for CodecError {}
+
+impl Codec {
+    /// "base64" or "hex"
+    pub fn name(&self) -> &'static str {
+        match self {
+            Codec::Base64 => "base64",
+            Codec::Hex => "hex",
+        }
+    }
+
+    /// The text encoding of `data`
+    pub fn encode(&self, data: &[u8]) -> String {
+        let mut out = String::with_capacity(data.len() * 2);
+        match self {
+            Codec::Base64 => {
+                for chunk in data.chunks(3) {
+                    let b = [chunk[0], *chunk.get(1).unwrap_or(&0), *chunk.get(2).unwrap_or(&0)];
+                    let n = (b[0] as u32) << 16 | (b[1] as u32) << 8 | b[2] as u32;
+                    for i in 0..4 {
+                        if i <= chunk.len() {
+                            out.push(B64[(n >> (18 - 6 * i) & 0x3f) as usize] as char);
+                        } else {
+                            out.push('=');
+                        }
+                    }
+                }
+            }
+            Codec::Hex => {
+                for &b in data {
+                    out.push(HEX[(b >> 4) as usize] as char);
+                    out.push(HEX[(b & 0x0f) as usize] as char);
+                }
+            }
+        }
+        out
+    }
+
+    /// The bytes encoded by `text`. Leading and trailing whitespace is
+    /// ignored, and base64 may also contain line breaks.
+    pub fn decode(&self, text: &[u8]) -> Result<Vec<u8>, CodecError> {
+        let start = text.iter().position(|b| !b.is_ascii_whitespace()).unwrap_or(text.len());
+        let end = text.iter().rposition(|b| !b.is_ascii_whitespace()).map_or(start, |i| i + 1);
+        let err = |offset: usize| CodecError { codec: self.name(), offset };
+
+        let mut out = Vec::with_capacity(text.len() / 2);
+        match self {
+            Codec::Base64 => {
+                let mut quad = [0u8; 4];
+                let mut n = 0;
+                let mut pad = 0;
+                for (i, &c) in text.iter().enumerate().take(end).skip(start) {
+                    if c == b'\r' || c == b'\n' {
+                        continue;
+                    }
+                    let v = match c {
+                        b'=' if n >= 2 => {
+                            pad += 1;
+                            0
+                        }
+                        _ if pad > 0 => return Err(err(i)),
+                        _ => b64_value(c).ok_or_else(|| err(i))?,
+                    };
+                    quad[n] = v;
+                    n += 1;
+                    if n == 4 {
+                        let v = (quad[0] as u32) << 18 | (quad[1] as u32) << 12 | (quad[2] as u32) << 6 | quad[3] as u32;
+                        out.extend_from_slice(&[(v >> 16) as u8, (v >> 8) as u8, v as u8][..3 - pad]);
+                        n = 0;
+                        if pad > 0 {
+                            pad = 3; // nothing may follow the padding
+                        }
+                    }
+                }
+                if n != 0 {
+                    return Err(err(end));
+                }
+            }
+            Codec::Hex => {
+                let digits = &text[start..end];
+                if digits.len() % 2 != 0 {
+                    return Err(err(end));
+                }
+                for (i, pair) in digits.chunks(2).enumerate() {
+                    let hi = hex_value(pair[0]).ok_or_else(|| err(start + 2 * i))?;
+                    let lo = hex_value(pair[1]).ok_or_else(|| err(start + 2 * i + 1))?;
+                    out.push(hi << 4 | lo);
+                }
+            }
+        }
+        Ok(out)
+    }
+
+    /// Encode all of `r` onto `w`, returning the number of input bytes read
+    pub fn encode_stream<R: Read, W: Write>(&self, w: &mut W, r: &mut R) -> io::Result<u64> {
+        let mut buf = vec![0u8; STREAM_CHUNK];
+        let mut total = 0u64;
+        loop {
+            let n = read_full(r, &mut buf)?;
+            total += n as u64;
+            w.write_all(self.encode(&buf[..n]).as_bytes())?;
+            if n < buf.len() {
+                return Ok(total);
+            }
+        }
+    }
+
+    /// Decode all of the text in `r` onto `w`, returning the number of
+    /// decoded bytes written
+    pub fn decode_stream<R: Read, W: Write>(&self, w: &mut W, r: &mut R) -> io::Result<u64> {
+        let unit = match self {
+            Codec::Base64 => 4,
+            Codec::Hex => 2,
+        };
+        let mut buf = vec![0u8; STREAM_CHUNK];
+        let mut pending: Vec<u8> = Vec::new();
+        let mut total = 0u64;
+        loop {
+            let n = read_full(r, &mut buf)?;
+            pending.extend(buf[..n].iter().filter(|b| !b.is_ascii_whitespace()));
+            let last = n < buf.len();
+            let take = if last { pending.len() } else { pending.len() / unit * unit };
+            let out = self
+                .decode(&pending[..take])
+                .map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))?;
+            w.write_all(&out)?;
+            total += out.len() as u64;
+            pending.drain(..take);
+            if last {
+                return Ok(total);
+            }
+        }
+    }
+}
+
+/// Fill `buf` from `r`, stopping early only at end of input
+fn read_full<R: Read>(r: &mut R, buf: &mut [u8]) -> io::Result<usize> {
+    let mut n = 0;
+    while n < buf.len() {
+        match r.read(&mut buf[n..]) {
+            Ok(0) => break,
+            Ok(k) => n += k,
+            Err(e) if e.kind() == io::ErrorKind::Interrupted => {}
+            Err(e) => return Err(e),
+        }
+    }
+    Ok(n)
+}
+
+fn b64_value(c: u8) -> Option<u8> {
+    match c {
+        b'A'..=b'Z' => Some(c - b'A'),
+        b'a'..=b'z' => Some(c - b'a' + 26),
+        b'0'..=b'9' => Some(c - b'0' + 52),
+        b'+' => Some(62),
+        b'/' => Some(63),
+        _ => None,
+    }
+}
+
+fn hex_value(c: u8) -> Option<u8> {
+    match c {
+        b'0'..=b'9' => Some(c - b'0'),
+        b'a'..=b'f' => Some(c - b'a' + 10),
+        b'A'..=b'F' => Some(c - b'A' + 10),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_round_trip() {
+        let cases: &[(Codec, &[u8], &str)] = &[
+            (Codec::Base64, b"", ""),
+            (Codec::Base64, b"hello", "aGVsbG8="),
+            (Codec::Base64, b"\x00\xff\x10", "AP8Q"),
+            (Codec::Base64, b"ab", "YWI="),
+            (Codec::Hex, b"", ""),
+            (Codec::Hex, b"hello", "68656c6c6f"),
+            (Codec::Hex, b"\x00\xff\x10", "00ff10"),
+        ];
+        for &(codec, raw, text) in cases {
+            assert_eq!(codec.encode(raw), text);
+            assert_eq!(codec.decode(text.as_bytes()).unwrap(), raw);
+        }
+    }
+
+    #[test]
+    fn test_decode_lenient_and_errors() {
+        assert_eq!(Codec::Hex.decode(b"00FF10\n").unwrap(), b"\x00\xff\x10");
+        assert_eq!(Codec::Base64.decode(b"  aGVs\nbG8=  ").unwrap(), b"hello");
+        assert!(Codec::Base64.decode(b"not valid!").is_err());
+        assert!(Codec::Base64.decode(b"YQ==YQ==").is_err());
+        assert!(Codec::Hex.decode(b"abc").is_err());
+        assert!(Codec::Hex.decode(b"zz").is_err());
+    }
+
+    #[test]
+    fn test_stream() {
+        let payload = "0123456789".repeat(10000).into_bytes();
+        for codec in [Codec::Base64, Codec::Hex] {
+            let mut text = Vec::new();
+            let n = codec.encode_stream(&mut text, &mut payload.as_slice()).unwrap();
+            assert_eq!(n, payload.len() as u64);
+            assert_eq!(text, codec.encode(&payload).into_bytes());
+
+            let mut out = Vec::new();
+            let n = codec.decode_stream(&mut out, &mut text.as_slice()).unwrap();
+            assert_eq!(n, payload.len() as u64);
+            assert_eq!(out, payload);
+        }
+    }
+}
@@ -0,0 +1,398 @@
+//! CSV files: `read_csv(path, @a, @b, ...)` and `write_csv(path, @a, @b, ...)`
+//!
+//! Mirrors the Go runtime, which uses `encoding/csv`: a file starts with a
+//! header row, which `write_csv` fills with the names of the stacks and
+//! `read_csv` skips. Fields are converted to the element type of their
+//! column, see [`CsvField`], and a row is pushed only once all its fields
+//! have converted. Rows are read a line at a time. The messages for text
+//! that is not CSV differ from Go's.
+
+use std::fs::File;
+use std::io::{BufRead, BufReader, Write};
+
+use crate::{FileError, Perspective, Stack};
+
+/// An element type a CSV column can hold
+pub trait CsvField: Sized {
+    /// Convert a field, or say why it does not fit
+    fn from_csv(field: &str) -> Result<Self, String>;
+    /// The field the element is written as
+    fn to_csv(&self) -> String;
+}
+
+impl CsvField for String {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        Ok(field.to_string())
+    }
+
+    fn to_csv(&self) -> String {
+        self.clone()
+    }
+}
+
+impl CsvField for Vec<u8> {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        Ok(field.as_bytes().to_vec())
+    }
+
+    fn to_csv(&self) -> String {
+        String::from_utf8_lossy(self).into_owned()
+    }
+}
+
+impl CsvField for i64 {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        field.trim().parse().map_err(|_| format!("{:?} is not an integer", field))
+    }
+
+    fn to_csv(&self) -> String {
+        self.to_string()
+    }
+}
+
+impl CsvField for i32 {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        i64::from_csv(field).map(|i| i as i32)
+    }
+
+    fn to_csv(&self) -> String {
+        self.to_string()
+    }
+}
+
+impl CsvField for u64 {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        field.trim().parse().map_err(|_| format!("{:?} is not an unsigned integer", field))
+    }
+
+    fn to_csv(&self) -> String {
+        self.to_string()
+    }
+}
+
+impl CsvField for u32 {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        u64::from_csv(field).map(|u| u as u32)
+    }
+
+    fn to_csv(&self) -> String {
+        self.to_string()
+    }
+}
+
+impl CsvField for f64 {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        // As Go's ParseFloat, a number too large is an error, "inf" is not
+        match field.trim().parse::<f64>() {
+            Ok(f) if f.is_finite() || field.to_ascii_lowercase().contains("inf") => Ok(f),
+            _ => Err(format!("{:?} is not a number", field)),
+        }
+    }
+
+    fn to_csv(&self) -> String {
+        if self.is_infinite() {
+            return if *self > 0.0 { "+Inf" } else { "-Inf" }.to_string();
+        }
+        self.to_string()
+    }
+}
+
+impl CsvField for f32 {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        f64::from_csv(field).map(|f| f as f32)
+    }
+
+    fn to_csv(&self) -> String {
+        // Written widened, as the Go runtime does
+        (*self as f64).to_csv()
+    }
+}
+
+impl CsvField for bool {
+    fn from_csv(field: &str) -> Result<Self, String> {
+        // The spellings Go's ParseBool takes
+        match field.trim() {
+            "1" | "t" | "T" | "true" | "TRUE" | "True" => Ok(true),
+            "0" | "f" | "F" | "false" | "FALSE" | "False" => Ok(false),
+            _ => Err(format!("{:?} is not a boolean", field)),
+        }
+    }
+
+    fn to_csv(&self) -> String {
+        self.to_string()
+    }
+}
+
+/// A stack read_csv and write_csv take as a column
+pub trait CsvColumn {
+    /// Whether `field` converts to an element
+    fn check(&self, field: &str) -> Result<(), String>;
+    /// Push `field`, which check took
+    fn push_field(&self, field: &str) -> Result<(), String>;
+    /// The fields of the elements in push order
+    fn fields(&self) -> Result<Vec<String>, String>;
+}
+
+impl<T: Clone + CsvField> CsvColumn for Stack<T> {
+    fn check(&self, field: &str) -> Result<(), String> {
+        T::from_csv(field).map(|_| ())
+    }
+
+    fn push_field(&self, field: &str) -> Result<(), String> {
+        let e = T::from_csv(field)?;
+        self.push(e).map_err(|e| e.to_string())
+    }
+
+    fn fields(&self) -> Result<Vec<String>, String> {
+        if self.perspective() == Perspective::Hash {
+            return Err("write_csv: a Hash stack has no order to write in".to_string());
+        }
+        Ok(self.lock().as_slice().iter().map(|e| e.to_csv()).collect())
+    }
+}
+
+/// Push the fields of each row of the CSV file at `path` after its header
+/// to `cols`, in order. Returns the rows read, and the error that stopped
+/// the read if there was one; the rows before it stay pushed.
+pub fn read_csv(path: &str, cols: &[&dyn CsvColumn]) -> (i64, Result<(), FileError>) {
+    let mut rows = 0;
+    let file = match File::open(path) {
+        Ok(f) => f,
+        Err(e) => return (0, Err(e.into())),
+    };
+    let mut r = CsvReader { input: BufReader::new(file), line: 0 };
+    let fail = |msg: String| Err(FileError::new("error", &format!("read_csv: {}", msg)));
+    let mut header = true;
+    loop {
+        let (line, record) = match r.record() {
+            Ok(Some(rec)) => rec,
+            Ok(None) => return (rows, Ok(())),
+            Err(msg) => return (rows, fail(msg)),
+        };
+        if record.len() != cols.len() {
+            return (rows, fail(format!("line {}: {} fields, want {}", line, record.len(), cols.len())));
+        }
+        if header {
+            header = false;
+            continue;
+        }
+        for (n, (col, field)) in cols.iter().zip(&record).enumerate() {
+            if let Err(e) = col.check(field) {
+                return (rows, fail(format!("line {}, field {}: {}", line, n + 1, e)));
+            }
+        }
+        for (col, field) in cols.iter().zip(&record) {
+            if let Err(e) = col.push_field(field) {
+                return (rows, fail(e));
+            }
+        }
+        rows += 1;
+    }
+}
+
+/// Replace the file at `path` with a CSV file of the `header` row and then,
+/// for each k, a row of the k-th elements of `cols` in push order. The
+/// stacks must be the same length.
+pub fn write_csv(path: &str, header: &[&str], cols: &[&dyn CsvColumn]) -> Result<(), FileError> {
+    let mut fields = Vec::with_capacity(cols.len());
+    for col in cols {
+        fields.push(col.fields().map_err(|e| FileError::new("error", &e))?);
+    }
+    if fields.is_empty() {
+        return Err(FileError::new("error", "write_csv: no stacks to write"));
+    }
+    if fields.iter().any(|f| f.len() != fields[0].len()) {
+        return Err(FileError::new("error", "write_csv: the stacks are not the same length"));
+    }
+
+    let mut out = String::new();
+    write_row(&mut out, header.iter().copied());
+    for k in 0..fields[0].len() {
+        write_row(&mut out, fields.iter().map(|f| f[k].as_str()));
+    }
+    File::create(path)?.write_all(out.as_bytes())?;
+    Ok(())
+}
+
+/// Append a row, quoting the fields that need it as Go's csv.Writer does
+fn write_row<'a>(out: &mut String, row: impl Iterator<Item = &'a str>) {
+    for (n, field) in row.enumerate() {
+        if n > 0 {
+            out.push(',');
+        }
+        let quote = field == r"\."
+            || field.contains(|c| matches!(c, ',' | '"' | '\r' | '\n'))
+            || field.chars().next().map_or(false, char::is_whitespace);
+        if quote {
+            out.push('"');
+            out.push_str(&field.replace('"', "\"\""));
+            out.push('"');
+        } else {
+            out.push_str(field);
+        }
+    }
+    out.push('\n');
+}
+
+/// Reads the records of a CSV file as Go's csv.Reader does: "\r\n" ends a
+/// line as "\n" does, empty lines are skipped, and a quoted field may hold
+/// line ends.
+struct CsvReader<R> {
+    input: R,
+    line: usize,
+}
+
+impl<R: BufRead> CsvReader<R> {
+    /// The next line, ending in "\n", or None at the end of the input
+    fn next_line(&mut self) -> Result<Option<String>, String> {
+        let mut buf = Vec::new();
+        match self.input.read_until(b'\n', &mut buf) {
+            Ok(0) => return Ok(None),
+            Ok(_) => {}
+            Err(e) => return Err(e.to_string()),
+        }
+        self.line += 1;
+        let mut line = String::from_utf8_lossy(&buf).into_owned();
+        if line.ends_with("\r\n") {
+            line.truncate(line.len() - 2);
+            line.push('\n');
+        } else if !line.ends_with('\n') {
+            line.push('\n');
+        }
+        Ok(Some(line))
+    }
+
+    /// The next record and the line it starts on, or None at the end
+    fn record(&mut self) -> Result<Option<(usize, Vec<String>)>, String> {
+        let mut line = loop {
+            match self.next_line()? {
+                None => return Ok(None),
+                Some(l) if l == "\n" => continue,
+                Some(l) => break l,
+            }
+        };
+        let start = self.line;
+        let mut fields = Vec::new();
+        let mut pos = 0;
+        loop {
+            let rest = &line[pos..];
+            if !rest.starts_with('"') {
+                // An unquoted field runs to the next comma
+                let end = rest.find(|c| c == ',' || c == '\n').unwrap_or(rest.len());
+                let field = &rest[..end];
+                if field.contains('"') {
+                    return Err(format!("line {}: bare \" in a field that is not quoted", self.line));
+                }
+                fields.push(field.to_string());
+                if rest[end..].starts_with(',') {
+                    pos += end + 1;
+                    continue;
+                }
+                return Ok(Some((start, fields)));
+            }
+
+            // A quoted field, which may run over several lines
+            let mut field = String::new();
+            pos += 1;
+            loop {
+                let rest = &line[pos..];
+                match rest.find('"') {
+                    Some(q) => {
+                        field.push_str(&rest[..q]);
+                        let after = &rest[q + 1..];
+                        if after.starts_with('"') {
+                            field.push('"');
+                            pos += q + 2;
+                        } else if after.starts_with(',') {
+                            pos += q + 2;
+                            fields.push(field);
+                            break;
+                        } else if after.starts_with('\n') {
+                            fields.push(field);
+                            return Ok(Some((start, fields)));
+                        } else {
+                            return Err(format!("line {}: extraneous or missing \" in a quoted field", self.line));
+                        }
+                    }
+                    None => {
+                        field.push_str(rest);
+                        match self.next_line()? {
+                            Some(l) => {
+                                line = l;
+                                pos = 0;
+                            }
+                            None => {
+                                return Err(format!("line {}: extraneous or missing \" in a quoted field", self.line))
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_write_read() {
+        let path = std::env::temp_dir().join(format!("rual-csv-{}.csv", std::process::id()));
+        let path = path.to_str().unwrap();
+
+        let names: Stack<String> = Stack::new(Perspective::FIFO);
+        let qty: Stack<i64> = Stack::new(Perspective::LIFO);
+        for (s, q) in [("apple", 3), ("pear, green", 10), (" pad", -2)] {
+            names.push(s.to_string()).unwrap();
+            qty.push(q).unwrap();
+        }
+        write_csv(path, &["names", "qty"], &[&names, &qty]).unwrap();
+        assert_eq!(read_file(path), "names,qty\napple,3\n\"pear, green\",10\n\" pad\",-2\n");
+
+        let names2: Stack<String> = Stack::new(Perspective::Indexed);
+        let qty2: Stack<i64> = Stack::new(Perspective::Indexed);
+        let (n, r) = read_csv(path, &[&names2, &qty2]);
+        assert!(r.is_ok());
+        assert_eq!(n, 3);
+        assert_eq!(names2.peek_at(1).unwrap(), "pear, green");
+        assert_eq!(qty2.peek_at(2).unwrap(), -2);
+        std::fs::remove_file(path).unwrap();
+    }
+
+    #[test]
+    fn test_read_errors() {
+        let path = std::env::temp_dir().join(format!("rual-csv-bad-{}.csv", std::process::id()));
+        let path = path.to_str().unwrap();
+        for (data, want) in [
+            ("a,b\n1,2\n3,x\n", "line 3, field 2: \"x\" is not an integer"),
+            ("a,b\r\n1,2\r\n3\r\n", "line 3: 1 fields, want 2"),
+            ("a,b\n1,2\n3,\"4\n", "read_csv:"),
+        ] {
+            std::fs::write(path, data).unwrap();
+            let a: Stack<i64> = Stack::new(Perspective::Indexed);
+            let b: Stack<i64> = Stack::new(Perspective::Indexed);
+            let (n, r) = read_csv(path, &[&a, &b]);
+            assert!(r.unwrap_err().to_string().contains(want), "{:?}", data);
+            assert_eq!((n, a.len(), b.len()), (1, 1, 1));
+        }
+        std::fs::remove_file(path).unwrap();
+
+        let a: Stack<i64> = Stack::new(Perspective::Indexed);
+        assert_eq!(read_csv("/nonexistent/rual.csv", &[&a]).1.unwrap_err().status(), "not_found");
+    }
+
+    #[test]
+    fn test_quoted() {
+        let data = "h\n\"a \"\"b\"\"\nc\"\n\nplain\n";
+        let mut r = CsvReader { input: data.as_bytes(), line: 0 };
+        assert_eq!(r.record().unwrap(), Some((1, vec!["h".to_string()])));
+        assert_eq!(r.record().unwrap(), Some((2, vec!["a \"b\"\nc".to_string()])));
+        assert_eq!(r.record().unwrap(), Some((5, vec!["plain".to_string()])));
+        assert_eq!(r.record().unwrap(), None);
+    }
+
+    fn read_file(path: &str) -> String {
+        std::fs::read_to_string(path).unwrap()
+    }
+}
@@ -0,0 +1,36 @@
+//! Environment variables: `env(name)` and `setenv(name, value)`
+//!
+//! Mirrors the Go runtime. A variable set to "" is set. Bytes of a value
+//! that are not UTF-8 are replaced.
+
+use crate::FileError;
+
+/// The value of the environment variable `name`, or None if it is not set
+pub fn env(name: &str) -> Option<String> {
+    std::env::var_os(name).map(|v| v.to_string_lossy().into_owned())
+}
+
+/// Set the environment variable `name` to `value`. A name that is empty
+/// or holds '=', or either holding a NUL, is an error, where
+/// `std::env::set_var` would panic.
+pub fn setenv(name: &str, value: &str) -> Result<(), FileError> {
+    if name.is_empty() || name.contains('=') || name.contains('\0') || value.contains('\0') {
+        return Err(FileError::new("error", &format!("setenv {}: invalid argument", name)));
+    }
+    std::env::set_var(name, value);
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_env() {
+        setenv("RUAL_TEST_ENV", "a=b").unwrap();
+        assert_eq!(env("RUAL_TEST_ENV").as_deref(), Some("a=b"));
+        assert_eq!(env("RUAL_TEST_ENV_UNSET"), None);
+        assert_eq!(setenv("", "x").unwrap_err().status(), "error");
+        assert!(setenv("A=B", "x").is_err());
+    }
+}
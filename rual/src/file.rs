@@ -0,0 +1,140 @@
+//! Files: `readfile`, `writefile`, `appendfile` and `exists`
+//!
+//! Mirrors the Go runtime. The elements of a stack are written in the
+//! order they were pushed: a string or number element as a line of its
+//! own, a bytes element as it is. A failed call's `FileError` names the
+//! consider status it sets.
+
+use std::fmt;
+use std::fs::OpenOptions;
+use std::io::{self, Write};
+
+use crate::{Perspective, Stack};
+
+/// Why a file builtin failed
+#[derive(Debug)]
+pub struct FileError {
+    status: &'static str,
+    message: String,
+}
+
+impl FileError {
+    pub(crate) fn new(status: &'static str, message: &str) -> Self {
+        FileError { status, message: message.to_string() }
+    }
+
+    /// The consider status the failure sets: `not_found`, `denied` or `error`
+    pub fn status(&self) -> &'static str {
+        self.status
+    }
+}
+
+impl fmt::Display for FileError {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for FileError {}
+
+impl From<io::Error> for FileError {
+    fn from(e: io::Error) -> Self {
+        let status = match e.kind() {
+            io::ErrorKind::NotFound => "not_found",
+            io::ErrorKind::PermissionDenied => "denied",
+            _ => "error",
+        };
+        FileError { status, message: e.to_string() }
+    }
+}
+
+/// An element type writefile can write
+pub trait FileElement {
+    /// Append the element as it goes in a file
+    fn write_to(&self, out: &mut Vec<u8>);
+}
+
+impl FileElement for Vec<u8> {
+    fn write_to(&self, out: &mut Vec<u8>) {
+        out.extend_from_slice(self);
+    }
+}
+
+macro_rules! line_element {
+    ($($t:ty),*) => {
+        $(impl FileElement for $t {
+            fn write_to(&self, out: &mut Vec<u8>) {
+                out.extend_from_slice(self.to_string().as_bytes());
+                out.push(b'\n');
+            }
+        })*
+    };
+}
+
+line_element!(String, i64, i32, u64, u32, f64, f32, bool);
+
+/// The contents of the file at `path`, with any bytes that are not UTF-8
+/// replaced
+pub fn read_file(path: &str) -> Result<String, FileError> {
+    let data = std::fs::read(path)?;
+    Ok(String::from_utf8_lossy(&data).into_owned())
+}
+
+/// Replace the file at `path`, or add to its end if `append`, with the
+/// elements of `stack`, creating it if need be
+pub fn write_file<T: Clone + FileElement>(path: &str, stack: &Stack<T>, append: bool) -> Result<(), FileError> {
+    if stack.perspective() == Perspective::Hash {
+        return Err(FileError {
+            status: "error",
+            message: "writefile: a Hash stack has no order to write in".to_string(),
+        });
+    }
+    let mut data = Vec::new();
+    for e in stack.lock().as_slice() {
+        e.write_to(&mut data);
+    }
+    let mut f = OpenOptions::new()
+        .write(true)
+        .create(true)
+        .append(append)
+        .truncate(!append)
+        .open(path)?;
+    f.write_all(&data)?;
+    Ok(())
+}
+
+/// Whether there is a file, or a directory, at `path`
+pub fn file_exists(path: &str) -> bool {
+    std::fs::metadata(path).is_ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_write_read() {
+        let path = std::env::temp_dir().join(format!("rual-file-{}.txt", std::process::id()));
+        let path = path.to_str().unwrap();
+
+        let lines: Stack<String> = Stack::new(Perspective::FIFO);
+        lines.push("alpha".to_string()).unwrap();
+        lines.push("beta".to_string()).unwrap();
+        write_file(path, &lines, false).unwrap();
+        let nums: Stack<i64> = Stack::new(Perspective::LIFO);
+        nums.push(1).unwrap();
+        nums.push(-2).unwrap();
+        write_file(path, &nums, true).unwrap();
+
+        assert_eq!(read_file(path).unwrap(), "alpha\nbeta\n1\n-2\n");
+        assert!(file_exists(path));
+        std::fs::remove_file(path).unwrap();
+    }
+
+    #[test]
+    fn test_status() {
+        let e = read_file("/nonexistent/rual-file.txt").unwrap_err();
+        assert_eq!(e.status(), "not_found");
+        assert!(!file_exists("/nonexistent/rual-file.txt"));
+    }
+}
@@ -0,0 +1,86 @@
+//! Locale-independent number formatting for `format_int` and `format_float`
+//!
+//! Mirrors the Go runtime so both backends print identical strings: '.' is
+//! the decimal point, there is no digit grouping, and NaN and infinities
+//! are written "NaN", "Inf" and "-Inf".
+
+/// Format `n` in decimal, padded on the left with `pad` to at least `width`
+/// characters. A pad of "0" goes after the sign, as printf's `%05d` does.
+/// Only the first character of `pad` is used; "" means a space. A negative
+/// width pads on the right instead.
+pub fn format_int(n: i64, width: i64, pad: &str) -> String {
+    let s = n.to_string();
+    let c = pad.chars().next().unwrap_or(' ');
+    if c == '0' && n < 0 && width > 0 {
+        return format!("-{}", pad_left(&s[1..], width - 1, '0'));
+    }
+    pad_string(s, width, c)
+}
+
+/// Format `x` with `prec` digits after the decimal point, or as few as
+/// needed to represent it exactly if `prec` is negative, right-aligned in
+/// `width` characters (left-aligned if `width` is negative)
+pub fn format_float(x: f64, prec: i64, width: i64) -> String {
+    let s = if x.is_nan() {
+        "NaN".to_string()
+    } else if x.is_infinite() {
+        if x > 0.0 { "Inf".to_string() } else { "-Inf".to_string() }
+    } else if prec < 0 {
+        format!("{}", x)
+    } else {
+        format!("{:.*}", prec as usize, x)
+    };
+    pad_string(s, width, ' ')
+}
+
+fn pad_string(s: String, width: i64, c: char) -> String {
+    if width < 0 {
+        let n = -width - s.chars().count() as i64;
+        if n <= 0 {
+            return s;
+        }
+        let mut out = s;
+        out.extend(std::iter::repeat(c).take(n as usize));
+        return out;
+    }
+    pad_left(&s, width, c)
+}
+
+fn pad_left(s: &str, width: i64, c: char) -> String {
+    let n = width - s.chars().count() as i64;
+    if n <= 0 {
+        return s.to_string();
+    }
+    let mut out: String = std::iter::repeat(c).take(n as usize).collect();
+    out.push_str(s);
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_int() {
+        assert_eq!(format_int(42, 6, "0"), "000042");
+        assert_eq!(format_int(-42, 6, "0"), "-00042");
+        assert_eq!(format_int(42, 6, ""), "    42");
+        assert_eq!(format_int(42, 6, "*-"), "****42");
+        assert_eq!(format_int(42, -6, "."), "42....");
+        assert_eq!(format_int(123456, 3, "0"), "123456");
+        assert_eq!(format_int(7, 3, "·"), "··7");
+        assert_eq!(format_int(i64::MIN, 22, "0"), "-009223372036854775808");
+    }
+
+    #[test]
+    fn test_format_float() {
+        assert_eq!(format_float(3.14159, 2, 8), "    3.14");
+        assert_eq!(format_float(3.14159, 2, -8), "3.14    ");
+        assert_eq!(format_float(2.5, 0, 0), "2");
+        assert_eq!(format_float(0.125, 2, 0), "0.12");
+        assert_eq!(format_float(0.1, -1, 0), "0.1");
+        assert_eq!(format_float(1e21, 0, 0), "1000000000000000000000");
+        assert_eq!(format_float(f64::NAN, 2, 5), "  NaN");
+        assert_eq!(format_float(f64::NEG_INFINITY, 2, 0), "-Inf");
+    }
+}
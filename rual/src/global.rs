@@ -0,0 +1,47 @@
+//! Global variables: the top-level variables of a program that its
+//! functions use
+//!
+//! Mirrors the Go backend, where they are package-level variables. Each is
+//! a static the generated code reads with `get` and updates with `set`, so
+//! functions, the top-level code and spawned tasks share one value. The
+//! lock is held only inside `get` and `set`, so an expression may read a
+//! global more than once, and an update may read the value it replaces.
+
+use std::sync::Mutex;
+
+/// A global variable holding a `T`
+pub struct Global<T>(Mutex<T>);
+
+impl<T: Clone> Global<T> {
+    /// A global holding `value`
+    pub fn new(value: T) -> Self {
+        Global(Mutex::new(value))
+    }
+
+    /// The value held
+    pub fn get(&self) -> T {
+        self.0.lock().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+
+    /// Replace the value held
+    pub fn set(&self, value: T) {
+        *self.0.lock().unwrap_or_else(|e| e.into_inner()) = value;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_global() {
+        let count = Global::new(0i64);
+        count.set(count.get() + 5);
+        count.set(count.get() + count.get());
+        assert_eq!(count.get(), 10);
+
+        let label = Global::new(String::from("global"));
+        label.set(format!("{}!", label.get()));
+        assert_eq!(label.get(), "global!");
+    }
+}
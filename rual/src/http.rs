@@ -0,0 +1,341 @@
+//! HTTP: `listen(":8080", @reqs, @resps)`
+//!
+//! Mirrors the Go runtime's ServeHTTP: each request is pushed to the
+//! requests stack as a JSON object with the members "id", "method",
+//! "host", "path", "query", "remote" and "body", then its other headers by
+//! their canonical names, and a handler answers by pushing a JSON object
+//! with the same "id", a "status" (200 if left out), a "body" and any other
+//! member as a response header. Responses are matched to requests by id. A request not answered
+//! within [`HTTP_TIMEOUT`] gets 504.
+//!
+//! The server is small: one thread per connection, one request per
+//! connection, and request bodies must come with a Content-Length.
+
+use std::collections::HashMap;
+use std::io::{BufRead, BufReader, Write};
+use std::net::{TcpListener, TcpStream};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::mpsc;
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::Duration;
+
+use crate::json::write_string;
+use crate::{from_json, FileError, Perspective, Stack, StackError};
+
+/// How long a request waits for its response
+pub const HTTP_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// The largest request body accepted
+const MAX_HTTP_BODY: usize = 8 << 20;
+
+/// A response: its members other than "id", in the order they were set
+type Reply = Vec<(String, String)>;
+
+struct Server {
+    requests: &'static Stack<String>,
+    next: AtomicU64,
+    waiting: Mutex<HashMap<String, mpsc::Sender<Reply>>>,
+}
+
+/// Listen on `addr`, host:port with the host optional, and serve HTTP
+/// through the string stacks `requests` and `responses` for the life of
+/// the program.
+pub fn listen(
+    addr: &str,
+    requests: &'static Stack<String>,
+    responses: &'static Stack<String>,
+) -> Result<(), FileError> {
+    let addr = if addr.starts_with(':') { format!("0.0.0.0{}", addr) } else { addr.to_string() };
+    let listener = TcpListener::bind(&addr)?;
+    let server = Arc::new(Server { requests, next: AtomicU64::new(0), waiting: Mutex::new(HashMap::new()) });
+
+    let s = server.clone();
+    thread::spawn(move || {
+        for conn in listener.incoming().flatten() {
+            let s = s.clone();
+            thread::spawn(move || s.handle(conn));
+        }
+    });
+    thread::spawn(move || server.dispatch(responses));
+    Ok(())
+}
+
+impl Server {
+    /// Read one request, push it and write the response it is given
+    fn handle(&self, conn: TcpStream) {
+        let remote = conn.peer_addr().map(|a| a.to_string()).unwrap_or_default();
+        let mut out = match conn.try_clone() {
+            Ok(c) => c,
+            Err(_) => return,
+        };
+        let mut r = BufReader::new(conn);
+        let (method, target, mut headers, body) = match read_request(&mut r) {
+            Ok(req) => req,
+            Err((status, msg)) => {
+                write_response(&mut out, status, &[], msg.as_bytes());
+                return;
+            }
+        };
+        let (path, query) = match target.split_once('?') {
+            Some((p, q)) => (percent_decode(p), q.to_string()),
+            None => (percent_decode(&target), String::new()),
+        };
+
+        // As in Go, the Host header is a member of its own
+        let host = match headers.iter().position(|(n, _)| n == "Host") {
+            Some(n) => headers.remove(n).1,
+            None => String::new(),
+        };
+
+        let id = (self.next.fetch_add(1, Ordering::SeqCst) + 1).to_string();
+        let (tx, rx) = mpsc::channel();
+        self.waiting.lock().unwrap().insert(id.clone(), tx);
+
+        let mut json = String::from("{");
+        let fixed = [
+            ("id", id.as_str()),
+            ("method", method.as_str()),
+            ("host", host.as_str()),
+            ("path", path.as_str()),
+            ("query", query.as_str()),
+            ("remote", remote.as_str()),
+        ];
+        for (n, (k, v)) in fixed.iter().enumerate() {
+            if n > 0 {
+                json.push(',');
+            }
+            member(&mut json, k, v.as_bytes());
+        }
+        json.push(',');
+        member(&mut json, "body", &body);
+        for (k, v) in &headers {
+            json.push(',');
+            member(&mut json, k, v.as_bytes());
+        }
+        json.push('}');
+
+        if let Err(e) = self.requests.push(json) {
+            self.waiting.lock().unwrap().remove(&id);
+            let msg = format!("cannot queue the request: {}", e);
+            write_response(&mut out, 503, &[], msg.as_bytes());
+            return;
+        }
+        let reply = rx.recv_timeout(HTTP_TIMEOUT);
+        self.waiting.lock().unwrap().remove(&id);
+        match reply {
+            Ok(members) => respond(&mut out, members),
+            Err(_) => write_response(&mut out, 504, &[], b"no response"),
+        }
+    }
+
+    /// Take responses and hand each to the request it answers. Responses
+    /// that are not JSON objects, or answer no waiting request, are dropped.
+    fn dispatch(&self, responses: &'static Stack<String>) {
+        loop {
+            let text = match responses.take_timeout(1000) {
+                Ok(text) => text,
+                Err(StackError::Timeout) => continue,
+                Err(_) => return, // closed or frozen
+            };
+            let resp: Stack<String> = Stack::new(Perspective::Hash);
+            if from_json(&resp, &text).is_err() {
+                continue;
+            }
+            let keys = resp.keys();
+            let guard = resp.lock();
+            let id = guard.get_raw("id").cloned().unwrap_or_default();
+            let members: Reply = keys
+                .iter()
+                .filter(|k| k.as_str() != "id")
+                .filter_map(|k| guard.get_raw(k).map(|v| (k.clone(), v.clone())))
+                .collect();
+            if let Some(tx) = self.waiting.lock().unwrap().remove(&id) {
+                tx.send(members).ok();
+            }
+        }
+    }
+}
+
+/// Append `"key":"value"` to a JSON object
+fn member(out: &mut String, key: &str, value: &[u8]) {
+    write_string(out, key.as_bytes());
+    out.push(':');
+    write_string(out, value);
+}
+
+type Request = (String, String, Vec<(String, String)>, Vec<u8>);
+
+/// Read the request line, headers and body of a request. The headers are
+/// returned sorted by their canonical names, repeated ones joined by ", ".
+fn read_request(r: &mut impl BufRead) -> Result<Request, (u16, String)> {
+    let bad = |m: &str| (400, m.to_string());
+    let mut line = String::new();
+    r.read_line(&mut line).map_err(|_| bad("cannot read the request"))?;
+    let mut parts = line.split_whitespace();
+    let (method, target) = match (parts.next(), parts.next(), parts.next()) {
+        (Some(m), Some(t), Some(v)) if v.starts_with("HTTP/") => (m.to_string(), t.to_string()),
+        _ => return Err(bad("malformed request line")),
+    };
+
+    let mut headers: Vec<(String, String)> = Vec::new();
+    loop {
+        line.clear();
+        if r.read_line(&mut line).map_err(|_| bad("cannot read the headers"))? == 0 {
+            return Err(bad("unexpected end of headers"));
+        }
+        let l = line.trim_end_matches(['\r', '\n']);
+        if l.is_empty() {
+            break;
+        }
+        let (name, value) = l.split_once(':').ok_or_else(|| bad("malformed header"))?;
+        let name = canonical_header(name.trim());
+        let value = value.trim().to_string();
+        match headers.iter_mut().find(|(n, _)| *n == name) {
+            Some((_, v)) => {
+                v.push_str(", ");
+                v.push_str(&value);
+            }
+            None => headers.push((name, value)),
+        }
+    }
+    headers.sort();
+
+    let header = |name: &str| headers.iter().find(|(n, _)| n == name).map(|(_, v)| v.as_str());
+    if header("Transfer-Encoding").is_some() {
+        return Err((501, "request bodies must have a Content-Length".to_string()));
+    }
+    let len = match header("Content-Length") {
+        Some(v) => v.parse::<usize>().map_err(|_| bad("bad Content-Length"))?,
+        None => 0,
+    };
+    if len > MAX_HTTP_BODY {
+        return Err((413, "request body too large".to_string()));
+    }
+    let mut body = vec![0u8; len];
+    r.read_exact(&mut body).map_err(|_| bad("cannot read the request body"))?;
+    Ok((method, target, headers, body))
+}
+
+/// Write the response a handler gave: a status that is not a number from
+/// 100 to 999 is the handler's fault, a 500
+fn respond(out: &mut TcpStream, members: Reply) {
+    let mut status = 200;
+    if let Some((_, v)) = members.iter().find(|(k, _)| k == "status") {
+        match v.trim().parse::<u16>() {
+            Ok(n) if (100..=999).contains(&n) => status = n,
+            _ => {
+                let msg = format!("bad status {:?}", v);
+                write_response(out, 500, &[], msg.as_bytes());
+                return;
+            }
+        }
+    }
+    let mut body: &[u8] = b"";
+    let mut headers = Vec::new();
+    for (k, v) in &members {
+        match k.as_str() {
+            "status" => {}
+            "body" => body = v.as_bytes(),
+            _ => headers.push((canonical_header(k), v.clone())),
+        }
+    }
+    write_response(out, status, &headers, body);
+}
+
+fn write_response(out: &mut TcpStream, status: u16, headers: &[(String, String)], body: &[u8]) {
+    let mut head = format!("HTTP/1.1 {} {}\r\n", status, reason(status));
+    if !headers.iter().any(|(k, _)| k == "Content-Type") {
+        head.push_str("Content-Type: text/plain; charset=utf-8\r\n");
+    }
+    for (k, v) in headers {
+        if k != "Content-Length" && k != "Connection" {
+            head.push_str(&format!("{}: {}\r\n", k, v));
+        }
+    }
+    head.push_str(&format!("Content-Length: {}\r\nConnection: close\r\n\r\n", body.len()));
+    out.write_all(head.as_bytes()).ok();
+    out.write_all(body).ok();
+    out.flush().ok();
+}
+
+/// A header name as Go's textproto writes it: "content-type" is
+/// "Content-Type"
+fn canonical_header(name: &str) -> String {
+    let mut out = String::with_capacity(name.len());
+    let mut upper = true;
+    for c in name.chars() {
+        out.push(if upper { c.to_ascii_uppercase() } else { c.to_ascii_lowercase() });
+        upper = c == '-';
+    }
+    out
+}
+
+/// Decode %XX escapes in a path, leaving malformed ones as they are
+pub(crate) fn percent_decode(s: &str) -> String {
+    let b = s.as_bytes();
+    let mut out = Vec::with_capacity(b.len());
+    let mut i = 0;
+    while i < b.len() {
+        if b[i] == b'%' && i + 2 < b.len() {
+            let hex = |c: u8| (c as char).to_digit(16);
+            if let (Some(hi), Some(lo)) = (hex(b[i + 1]), hex(b[i + 2])) {
+                out.push((hi * 16 + lo) as u8);
+                i += 3;
+                continue;
+            }
+        }
+        out.push(b[i]);
+        i += 1;
+    }
+    String::from_utf8_lossy(&out).into_owned()
+}
+
+fn reason(status: u16) -> &'static str {
+    match status {
+        200 => "OK",
+        201 => "Created",
+        202 => "Accepted",
+        204 => "No Content",
+        301 => "Moved Permanently",
+        302 => "Found",
+        304 => "Not Modified",
+        400 => "Bad Request",
+        401 => "Unauthorized",
+        403 => "Forbidden",
+        404 => "Not Found",
+        405 => "Method Not Allowed",
+        409 => "Conflict",
+        413 => "Payload Too Large",
+        500 => "Internal Server Error",
+        501 => "Not Implemented",
+        503 => "Service Unavailable",
+        504 => "Gateway Timeout",
+        _ => "",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn parses_requests() {
+        let raw = b"POST /a%20b?x=1 HTTP/1.1\r\nhost: h\r\nX-NAME: n\r\nx-name: m\r\nContent-Length: 2\r\n\r\nhiextra";
+        let (method, target, headers, body) = read_request(&mut &raw[..]).unwrap();
+        assert_eq!((method.as_str(), target.as_str(), &body[..]), ("POST", "/a%20b?x=1", &b"hi"[..]));
+        assert_eq!(
+            headers,
+            vec![
+                ("Content-Length".to_string(), "2".to_string()),
+                ("Host".to_string(), "h".to_string()),
+                ("X-Name".to_string(), "n, m".to_string()),
+            ]
+        );
+        assert_eq!(percent_decode("/a%20b%zz%4"), "/a b%zz%4");
+
+        assert_eq!(read_request(&mut &b"nonsense\r\n\r\n"[..]).unwrap_err().0, 400);
+        let chunked = b"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n";
+        assert_eq!(read_request(&mut &chunked[..]).unwrap_err().0, 501);
+    }
+}
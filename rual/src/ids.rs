@@ -0,0 +1,143 @@
+//! Unique IDs: `uuid4()`, `ulid()` and `seq(name)`
+//!
+//! Mirrors the Go runtime. ULIDs made in the same millisecond increment
+//! the random part rather than redrawing it, so successive ULIDs from one
+//! program always sort in creation order. Randomness comes from
+//! `/dev/urandom` where available, otherwise from the standard library's
+//! per-process random hash keys.
+
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// ULID base32 alphabet (no I, L, O or U)
+const CROCKFORD: &[u8; 32] = b"0123456789ABCDEFGHJKMNPQRSTVWXYZ";
+
+struct UlidState {
+    ms: u64,
+    hi: u16,
+    lo: u64,
+}
+
+static ULID_STATE: Mutex<UlidState> = Mutex::new(UlidState { ms: 0, hi: 0, lo: 0 });
+static SEQ: Mutex<Option<HashMap<String, i64>>> = Mutex::new(None);
+
+/// A random RFC 4122 version 4 UUID in canonical form
+pub fn uuid4() -> String {
+    let mut u = [0u8; 16];
+    random_bytes(&mut u);
+    u[6] = u[6] & 0x0f | 0x40; // version 4
+    u[8] = u[8] & 0x3f | 0x80; // RFC 4122 variant
+
+    let mut s = String::with_capacity(36);
+    for (i, b) in u.iter().enumerate() {
+        if i == 4 || i == 6 || i == 8 || i == 10 {
+            s.push('-');
+        }
+        s.push_str(&format!("{:02x}", b));
+    }
+    s
+}
+
+/// A new 26-character ULID: 48-bit millisecond timestamp, 80 random bits
+pub fn ulid() -> String {
+    let ms = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |d| d.as_millis() as u64);
+    ulid_at(ms)
+}
+
+fn ulid_at(ms: u64) -> String {
+    let (ms, hi, lo) = {
+        let mut s = ULID_STATE.lock().unwrap_or_else(|e| e.into_inner());
+        let mut ms = ms;
+        if ms <= s.ms {
+            // Same (or an earlier, if the clock stepped back) millisecond
+            ms = s.ms;
+            s.lo = s.lo.wrapping_add(1);
+            if s.lo == 0 {
+                s.hi = s.hi.wrapping_add(1);
+                if s.hi == 0 {
+                    ms += 1; // random part exhausted: borrow the next millisecond
+                }
+            }
+        } else {
+            let mut r = [0u8; 10];
+            random_bytes(&mut r);
+            s.hi = u16::from_be_bytes([r[0], r[1]]);
+            s.lo = u64::from_be_bytes(r[2..].try_into().unwrap());
+        }
+        s.ms = ms;
+        (ms, s.hi, s.lo)
+    };
+    encode_ulid(ms, hi, lo)
+}
+
+fn encode_ulid(ms: u64, hi: u16, lo: u64) -> String {
+    let mut v = (ms as u128) << 80 | (hi as u128) << 64 | lo as u128;
+    let mut b = [0u8; 26];
+    for c in b.iter_mut().rev() {
+        *c = CROCKFORD[(v & 0x1f) as usize];
+        v >>= 5;
+    }
+    String::from_utf8(b.to_vec()).unwrap()
+}
+
+/// The next value of the named counter, starting at 1. Counters live for
+/// the life of the program and are shared between threads.
+pub fn seq(name: &str) -> i64 {
+    let mut counters = SEQ.lock().unwrap_or_else(|e| e.into_inner());
+    let n = counters.get_or_insert_with(HashMap::new).entry(name.to_string()).or_insert(0);
+    *n += 1;
+    *n
+}
+
+pub(crate) fn random_bytes(buf: &mut [u8]) {
+    use std::io::Read;
+    if let Ok(mut f) = std::fs::File::open("/dev/urandom") {
+        if f.read_exact(buf).is_ok() {
+            return;
+        }
+    }
+    use std::collections::hash_map::RandomState;
+    use std::hash::{BuildHasher, Hasher};
+    for chunk in buf.chunks_mut(8) {
+        let mut h = RandomState::new().build_hasher();
+        h.write_u128(SystemTime::now().duration_since(UNIX_EPOCH).map_or(0, |d| d.as_nanos()));
+        let r = h.finish().to_le_bytes();
+        chunk.copy_from_slice(&r[..chunk.len()]);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_uuid4() {
+        let u = uuid4();
+        assert_eq!(u.len(), 36);
+        assert_eq!(&u[14..15], "4");
+        assert!(matches!(&u[19..20], "8" | "9" | "a" | "b"));
+        assert_ne!(u, uuid4());
+    }
+
+    #[test]
+    fn test_ulid_increasing() {
+        let mut prev = String::new();
+        for _ in 0..1000 {
+            let u = ulid();
+            assert_eq!(u.len(), 26);
+            assert!(u > prev, "{} then {}", prev, u);
+            prev = u;
+        }
+        assert_eq!(&encode_ulid(1469918176385, 0, 0)[..10], "01ARYZ6S41");
+    }
+
+    #[test]
+    fn test_seq() {
+        assert_eq!(seq("test.a"), 1);
+        assert_eq!(seq("test.a"), 2);
+        assert_eq!(seq("test.b"), 1);
+    }
+}
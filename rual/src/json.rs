@@ -0,0 +1,610 @@
+//! JSON: `@cfg from_json(text)` and `@cfg: to_json()`
+//!
+//! Mirrors the Go runtime: a Hash stack reads and writes a JSON object, in
+//! the order its keys were first set, and any other stack an array, in
+//! push order. Values take the stack's element type, see [`JsonElement`];
+//! null values are skipped. [`to_json`] writes the same bytes as the Go
+//! runtime, and [`from_json`] fails on the same input, though the message
+//! for text that is not JSON differs.
+
+use std::fmt;
+
+use crate::{Perspective, Stack};
+
+/// Why `from_json` stored nothing
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct JsonError {
+    message: String,
+}
+
+impl JsonError {
+    fn new(message: String) -> Self {
+        JsonError { message: format!("from_json: {}", message) }
+    }
+}
+
+impl fmt::Display for JsonError {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for JsonError {}
+
+/// A JSON value other than null, as an element converts from it
+#[derive(Debug, Clone, PartialEq)]
+pub enum JsonValue {
+    Bool(bool),
+    /// A number, as written
+    Number(String),
+    String(String),
+    /// An array, as compact JSON text
+    Array(String),
+    /// An object, as compact JSON text
+    Object(String),
+}
+
+impl JsonValue {
+    fn kind(&self) -> &'static str {
+        match self {
+            JsonValue::Bool(_) => "a boolean",
+            JsonValue::Number(_) => "a number",
+            JsonValue::String(_) => "a string",
+            JsonValue::Array(_) => "an array",
+            JsonValue::Object(_) => "an object",
+        }
+    }
+
+    /// The text a string stack stores for the value
+    fn text(&self) -> String {
+        match self {
+            JsonValue::Bool(b) => b.to_string(),
+            JsonValue::Number(s) | JsonValue::String(s) | JsonValue::Array(s) | JsonValue::Object(s) => s.clone(),
+        }
+    }
+}
+
+/// An element type `from_json` and `to_json` convert
+pub trait JsonElement: Sized {
+    /// Convert a JSON value to an element, or say why it does not fit
+    fn from_json(value: &JsonValue) -> Result<Self, String>;
+    /// Append the element as a JSON value
+    fn write_json(&self, out: &mut String);
+}
+
+impl JsonElement for String {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        Ok(value.text())
+    }
+
+    fn write_json(&self, out: &mut String) {
+        write_string(out, self.as_bytes());
+    }
+}
+
+impl JsonElement for Vec<u8> {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        Ok(value.text().into_bytes())
+    }
+
+    fn write_json(&self, out: &mut String) {
+        write_string(out, self);
+    }
+}
+
+impl JsonElement for bool {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        match value {
+            JsonValue::Bool(b) => Ok(*b),
+            v => Err(format!("expected a boolean, got {}", v.kind())),
+        }
+    }
+
+    fn write_json(&self, out: &mut String) {
+        out.push_str(if *self { "true" } else { "false" });
+    }
+}
+
+/// The number a value holds, or why it is not one
+fn number(value: &JsonValue) -> Result<&str, String> {
+    match value {
+        JsonValue::Number(n) => Ok(n),
+        v => Err(format!("expected a number, got {}", v.kind())),
+    }
+}
+
+impl JsonElement for i64 {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        let n = number(value)?;
+        n.parse().map_err(|_| format!("{} is not an integer", n))
+    }
+
+    fn write_json(&self, out: &mut String) {
+        out.push_str(&self.to_string());
+    }
+}
+
+impl JsonElement for u64 {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        // As in Go, a negative number keeps its bits
+        let n = number(value)?;
+        n.parse::<i64>()
+            .map(|i| i as u64)
+            .or_else(|_| n.parse())
+            .map_err(|_| format!("{} is not an integer", n))
+    }
+
+    fn write_json(&self, out: &mut String) {
+        out.push_str(&self.to_string());
+    }
+}
+
+impl JsonElement for i32 {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        i64::from_json(value).map(|i| i as i32)
+    }
+
+    fn write_json(&self, out: &mut String) {
+        out.push_str(&self.to_string());
+    }
+}
+
+impl JsonElement for u32 {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        u64::from_json(value).map(|u| u as u32)
+    }
+
+    fn write_json(&self, out: &mut String) {
+        out.push_str(&self.to_string());
+    }
+}
+
+impl JsonElement for f64 {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        let n = number(value)?;
+        match n.parse::<f64>() {
+            Ok(f) if f.is_finite() => Ok(f),
+            _ => Err(format!("{} is out of range", n)),
+        }
+    }
+
+    fn write_json(&self, out: &mut String) {
+        if self.is_finite() {
+            out.push_str(&self.to_string());
+        } else {
+            out.push_str("null");
+        }
+    }
+}
+
+impl JsonElement for f32 {
+    fn from_json(value: &JsonValue) -> Result<Self, String> {
+        f64::from_json(value).map(|f| f as f32)
+    }
+
+    fn write_json(&self, out: &mut String) {
+        // Written widened, as the Go runtime does
+        (*self as f64).write_json(out);
+    }
+}
+
+/// Parse `text` into `stack`: the members of an object, in order, if it is
+/// a Hash stack, or the elements of an array otherwise. If the text is not
+/// JSON of that shape, or a value does not fit the element type, nothing is
+/// stored.
+pub fn from_json<T: Clone + JsonElement>(stack: &Stack<T>, text: &str) -> Result<(), JsonError> {
+    let hash = stack.perspective() == Perspective::Hash;
+    let (open, close, shape) = if hash { (b'{', b'}', "an object") } else { (b'[', b']', "an array") };
+    let mut p = Parser { b: text.as_bytes(), pos: 0 };
+
+    p.space();
+    if p.peek() != Some(open) {
+        return Err(JsonError::new(format!("expected {}", shape)));
+    }
+    p.pos += 1;
+    let mut members: Vec<(String, T)> = Vec::new();
+    p.space();
+    if p.peek() == Some(close) {
+        p.pos += 1;
+    } else {
+        for n in 0.. {
+            let mut key = String::new();
+            if hash {
+                p.space();
+                if p.peek() != Some(b'"') {
+                    return Err(JsonError::new(p.error("an object key")));
+                }
+                key = p.string().map_err(JsonError::new)?;
+                p.space();
+                if p.peek() != Some(b':') {
+                    return Err(JsonError::new(p.error("':'")));
+                }
+                p.pos += 1;
+            }
+            if let Some(value) = p.value().map_err(JsonError::new)? {
+                match T::from_json(&value) {
+                    Ok(e) => members.push((key, e)),
+                    Err(e) if hash => return Err(JsonError::new(format!("{:?}: {}", key, e))),
+                    Err(e) => return Err(JsonError::new(format!("element {}: {}", n, e))),
+                }
+            }
+            p.space();
+            match p.peek() {
+                Some(b',') => p.pos += 1,
+                Some(c) if c == close => {
+                    p.pos += 1;
+                    break;
+                }
+                _ => return Err(JsonError::new(p.error("',' or the end"))),
+            }
+        }
+    }
+    p.space();
+    if p.pos < p.b.len() {
+        return Err(JsonError::new("unexpected text after the JSON value".to_string()));
+    }
+
+    for (key, e) in members {
+        let pushed = if hash { stack.push_keyed(&key, e) } else { stack.push(e) };
+        pushed.map_err(|e| JsonError::new(e.to_string()))?;
+    }
+    Ok(())
+}
+
+/// `stack` as compact JSON text: an object of its keys, in the order they
+/// were first set, for a Hash stack, or an array of its elements in push
+/// order otherwise. Bytes that are not UTF-8 are replaced, and floats that
+/// are not finite written as null.
+pub fn to_json<T: Clone + JsonElement>(stack: &Stack<T>) -> String {
+    let mut out = String::new();
+    if stack.perspective() == Perspective::Hash {
+        let keys = stack.keys();
+        let guard = stack.lock();
+        out.push('{');
+        let mut first = true;
+        for key in &keys {
+            if let Some(e) = guard.get_raw(key) {
+                if !first {
+                    out.push(',');
+                }
+                first = false;
+                write_string(&mut out, key.as_bytes());
+                out.push(':');
+                e.write_json(&mut out);
+            }
+        }
+        out.push('}');
+        return out;
+    }
+    out.push('[');
+    for (n, e) in stack.lock().as_slice().iter().enumerate() {
+        if n > 0 {
+            out.push(',');
+        }
+        e.write_json(&mut out);
+    }
+    out.push(']');
+    out
+}
+
+/// Append `s` as a JSON string. Only '"', '\' and control characters are
+/// escaped, and each byte that is not UTF-8 becomes U+FFFD, as in Go.
+pub(crate) fn write_string(out: &mut String, mut s: &[u8]) {
+    out.push('"');
+    loop {
+        let (valid, rest) = match std::str::from_utf8(s) {
+            Ok(v) => (v, None),
+            Err(e) => {
+                let (v, rest) = s.split_at(e.valid_up_to());
+                (std::str::from_utf8(v).unwrap_or_default(), Some(&rest[1..]))
+            }
+        };
+        for c in valid.chars() {
+            match c {
+                '"' => out.push_str("\\\""),
+                '\\' => out.push_str("\\\\"),
+                '\n' => out.push_str("\\n"),
+                '\r' => out.push_str("\\r"),
+                '\t' => out.push_str("\\t"),
+                c if (c as u32) < 0x20 => out.push_str(&format!("\\u{:04x}", c as u32)),
+                c => out.push(c),
+            }
+        }
+        match rest {
+            Some(rest) => {
+                out.push('\u{FFFD}');
+                s = rest;
+            }
+            None => break,
+        }
+    }
+    out.push('"');
+}
+
+/// A JSON reader over the bytes of a str
+struct Parser<'a> {
+    b: &'a [u8],
+    pos: usize,
+}
+
+impl<'a> Parser<'a> {
+    fn peek(&self) -> Option<u8> {
+        self.b.get(self.pos).copied()
+    }
+
+    fn space(&mut self) {
+        while let Some(b' ' | b'\t' | b'\n' | b'\r') = self.peek() {
+            self.pos += 1;
+        }
+    }
+
+    fn error(&self, want: &str) -> String {
+        match self.peek() {
+            Some(_) => format!("expected {} at offset {}", want, self.pos),
+            None => "unexpected end of JSON input".to_string(),
+        }
+    }
+
+    /// The next value, None for null. Arrays and objects are checked and
+    /// kept as compact text.
+    fn value(&mut self) -> Result<Option<JsonValue>, String> {
+        self.space();
+        let start = self.pos;
+        match self.peek() {
+            Some(b'"') => self.string().map(|s| Some(JsonValue::String(s))),
+            Some(b'{' | b'[') => {
+                self.skip()?;
+                Ok(Some(compact(&self.b[start..self.pos], self.b[start] == b'{')))
+            }
+            Some(b't') => self.word("true").map(|_| Some(JsonValue::Bool(true))),
+            Some(b'f') => self.word("false").map(|_| Some(JsonValue::Bool(false))),
+            Some(b'n') => self.word("null").map(|_| None),
+            Some(b'-' | b'0'..=b'9') => {
+                self.number()?;
+                let n = std::str::from_utf8(&self.b[start..self.pos]).unwrap_or_default();
+                Ok(Some(JsonValue::Number(n.to_string())))
+            }
+            _ => Err(self.error("a value")),
+        }
+    }
+
+    /// Check the next value and move past it
+    fn skip(&mut self) -> Result<(), String> {
+        self.space();
+        let close = match self.peek() {
+            Some(b'{') => b'}',
+            Some(b'[') => b']',
+            _ => return self.value().map(|_| ()),
+        };
+        self.pos += 1;
+        self.space();
+        if self.peek() == Some(close) {
+            self.pos += 1;
+            return Ok(());
+        }
+        loop {
+            if close == b'}' {
+                self.space();
+                if self.peek() != Some(b'"') {
+                    return Err(self.error("an object key"));
+                }
+                self.string()?;
+                self.space();
+                if self.peek() != Some(b':') {
+                    return Err(self.error("':'"));
+                }
+                self.pos += 1;
+            }
+            self.skip()?;
+            self.space();
+            match self.peek() {
+                Some(b',') => self.pos += 1,
+                Some(c) if c == close => {
+                    self.pos += 1;
+                    return Ok(());
+                }
+                _ => return Err(self.error("',' or the end")),
+            }
+        }
+    }
+
+    fn word(&mut self, w: &str) -> Result<(), String> {
+        if self.b[self.pos..].starts_with(w.as_bytes()) {
+            self.pos += w.len();
+            Ok(())
+        } else {
+            Err(self.error("a value"))
+        }
+    }
+
+    fn digits(&mut self) -> Result<(), String> {
+        let start = self.pos;
+        while let Some(b'0'..=b'9') = self.peek() {
+            self.pos += 1;
+        }
+        if self.pos == start {
+            return Err(self.error("a digit"));
+        }
+        Ok(())
+    }
+
+    fn number(&mut self) -> Result<(), String> {
+        if self.peek() == Some(b'-') {
+            self.pos += 1;
+        }
+        if self.peek() == Some(b'0') {
+            self.pos += 1;
+        } else {
+            self.digits()?;
+        }
+        if self.peek() == Some(b'.') {
+            self.pos += 1;
+            self.digits()?;
+        }
+        if let Some(b'e' | b'E') = self.peek() {
+            self.pos += 1;
+            if let Some(b'+' | b'-') = self.peek() {
+                self.pos += 1;
+            }
+            self.digits()?;
+        }
+        Ok(())
+    }
+
+    fn hex4(&mut self) -> Result<u32, String> {
+        let h = self.b.get(self.pos..self.pos + 4).and_then(|h| std::str::from_utf8(h).ok());
+        match h.and_then(|h| u32::from_str_radix(h, 16).ok()) {
+            Some(u) => {
+                self.pos += 4;
+                Ok(u)
+            }
+            None => Err(self.error("four hex digits")),
+        }
+    }
+
+    /// The string at pos, unescaped. An escaped surrogate that is not half
+    /// of a pair becomes U+FFFD, as in Go.
+    fn string(&mut self) -> Result<String, String> {
+        self.pos += 1;
+        let mut s = String::new();
+        loop {
+            let start = self.pos;
+            while let Some(c) = self.peek() {
+                if c == b'"' || c == b'\\' || c < 0x20 {
+                    break;
+                }
+                self.pos += 1;
+            }
+            s.push_str(std::str::from_utf8(&self.b[start..self.pos]).unwrap_or_default());
+            match self.peek() {
+                Some(b'"') => {
+                    self.pos += 1;
+                    return Ok(s);
+                }
+                Some(b'\\') => self.pos += 1,
+                _ => return Err(self.error("'\"'")),
+            }
+            let c = self.peek();
+            self.pos += 1;
+            match c {
+                Some(b'"') => s.push('"'),
+                Some(b'\\') => s.push('\\'),
+                Some(b'/') => s.push('/'),
+                Some(b'b') => s.push('\u{8}'),
+                Some(b'f') => s.push('\u{c}'),
+                Some(b'n') => s.push('\n'),
+                Some(b'r') => s.push('\r'),
+                Some(b't') => s.push('\t'),
+                Some(b'u') => {
+                    let mut u = self.hex4()?;
+                    if (0xD800..0xDC00).contains(&u) && self.b[self.pos..].starts_with(b"\\u") {
+                        let save = self.pos;
+                        self.pos += 2;
+                        let lo = self.hex4()?;
+                        if (0xDC00..0xE000).contains(&lo) {
+                            u = 0x10000 + ((u - 0xD800) << 10) + (lo - 0xDC00);
+                        } else {
+                            self.pos = save;
+                        }
+                    }
+                    s.push(char::from_u32(u).unwrap_or('\u{FFFD}'));
+                }
+                _ => {
+                    self.pos -= 1;
+                    return Err(self.error("an escape"));
+                }
+            }
+        }
+    }
+}
+
+/// The checked array or object `raw` with the space between its tokens
+/// removed
+fn compact(raw: &[u8], object: bool) -> JsonValue {
+    let mut out = Vec::with_capacity(raw.len());
+    let (mut quoted, mut escaped) = (false, false);
+    for &c in raw {
+        if quoted {
+            out.push(c);
+            if escaped {
+                escaped = false;
+            } else if c == b'\\' {
+                escaped = true;
+            } else if c == b'"' {
+                quoted = false;
+            }
+            continue;
+        }
+        match c {
+            b' ' | b'\t' | b'\n' | b'\r' => {}
+            b'"' => {
+                quoted = true;
+                out.push(c);
+            }
+            _ => out.push(c),
+        }
+    }
+    let text = String::from_utf8(out).unwrap_or_default();
+    if object {
+        JsonValue::Object(text)
+    } else {
+        JsonValue::Array(text)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_hash() {
+        let cfg: Stack<String> = Stack::new(Perspective::Hash);
+        from_json(&cfg, r#"{"name": "ual", "port": 8080, "debug": true, "tags": [1, 2], "none": null}"#).unwrap();
+        assert_eq!(to_json(&cfg), r#"{"name":"ual","port":"8080","debug":"true","tags":"[1,2]"}"#);
+        from_json(&cfg, r#"{"debug": "no", "extra": "x\ty"}"#).unwrap();
+        assert_eq!(
+            to_json(&cfg),
+            r#"{"name":"ual","port":"8080","debug":"no","tags":"[1,2]","extra":"x\ty"}"#
+        );
+    }
+
+    #[test]
+    fn test_array() {
+        let nums: Stack<i64> = Stack::new(Perspective::Indexed);
+        from_json(&nums, "[3, -1, 40]").unwrap();
+        assert_eq!(to_json(&nums), "[3,-1,40]");
+        let floats: Stack<f32> = Stack::new(Perspective::FIFO);
+        from_json(&floats, "[1.5, 2, -0.25]").unwrap();
+        assert_eq!(to_json(&floats), "[1.5,2,-0.25]");
+        let big: Stack<u64> = Stack::new(Perspective::LIFO);
+        from_json(&big, "[18446744073709551615]").unwrap();
+        assert_eq!(to_json(&big), "[18446744073709551615]");
+    }
+
+    #[test]
+    fn test_errors() {
+        let nums: Stack<i64> = Stack::new(Perspective::LIFO);
+        assert_eq!(
+            from_json(&nums, "[1, 2.5]").unwrap_err().to_string(),
+            "from_json: element 1: 2.5 is not an integer"
+        );
+        assert_eq!(from_json(&nums, r#"{"a": 1}"#).unwrap_err().to_string(), "from_json: expected an array");
+        assert!(from_json(&nums, "[1] x").unwrap_err().to_string().contains("unexpected text"));
+        assert!(from_json(&nums, "[1").is_err());
+        assert_eq!(nums.len(), 0);
+
+        let cfg: Stack<f64> = Stack::new(Perspective::Hash);
+        assert_eq!(
+            from_json(&cfg, r#"{"x": "1"}"#).unwrap_err().to_string(),
+            r#"from_json: "x": expected a number, got a string"#
+        );
+    }
+
+    #[test]
+    fn test_strings() {
+        let s: Stack<Vec<u8>> = Stack::new(Perspective::LIFO);
+        s.push(b"a\"b\\c\n\x01\xc3\xa9".to_vec()).unwrap();
+        s.push(vec![b'x', 0xff]).unwrap();
+        assert_eq!(to_json(&s), "[\"a\\\"b\\\\c\\n\\u0001\u{e9}\",\"x\u{FFFD}\"]");
+    }
+}
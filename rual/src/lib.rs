@@ -8,6 +8,21 @@
 //! - **Views**: Borrowed perspectives on stacks
 //! - **Blocking operations**: Take with timeout
 //! - **Work stealing**: Chase-Lev deques and ual-native work stealing
+//! - **Templates**: mustache-like rendering against Hash stacks
+//! - **Terminal**: colour, line clearing and progress bars (no-op on non-TTY)
+//! - **Args**: command-line parsing for `args` blocks, and `args(@s)`
+//! - **Exit hooks**: the `@atexit` stack, run on exit, `exit(code)` and SIGTERM
+//! - **IDs**: `uuid4()`, time-ordered `ulid()` and named `seq()` counters
+//! - **Random numbers**: `rand()`, `rand_int(n)` and `seed(x)`, the same sequence as Go
+//! - **Codecs**: base64 and hex encoding, per element or streamed
+//! - **Formatting**: locale-independent `format_int` and `format_float`
+//! - **Bridges**: `bridge(url)` mirrors a stack to an MQTT topic, or any registered scheme
+//! - **Files**: `readfile`, `writefile`, `appendfile` and `exists`
+//! - **Environment**: `env(name)` and `setenv(name, value)`
+//! - **Select sources**: `every(ms)` timers and OS signals as stacks
+//! - **Codeblock values**: `call(f, args...)` and `apply(f, @s)` on fn handles
+//! - **Benchmarks**: timing `bench` blocks for `ual bench`
+//! - **Globals**: top-level variables shared with the program's functions
 //!
 //! ## Design Philosophy
 //!
@@ -33,12 +48,52 @@ mod value;
 mod view;
 mod sync;
 mod worksteal;
+mod template;
+mod term;
+mod args;
+mod atexit;
+mod ids;
+mod random;
+mod codec;
+mod format;
+mod file;
+mod env;
+mod json;
+mod csv;
+mod pack;
+mod http;
+mod bridge;
+mod mqtt;
+mod source;
+mod closure;
+mod bench;
+mod global;
 
-pub use stack::{Stack, Perspective, ElementType};
+pub use stack::{Stack, Perspective, ElementType, FreezeMode};
 pub use value::{Value, ValueType, Codeblock};
 pub use view::{View, WorkStealViews};
-pub use sync::BlockingStack;
+pub use sync::{BlockingStack, SpawnGroup, SpawnGuard, run_for_result};
 pub use worksteal::{WSDeque, WSStack, Task};
+pub use template::{render, render_with};
+pub use term::{is_tty, color, clear_line, progress, prompt, confirm, password, readline};
+pub use args::{ArgSpec, Args, ArgsError, parse_args, parse_args_or_exit, args_usage, push_args};
+pub use atexit::{at_exit, run_at_exit, exit, AtExitGuard};
+pub use ids::{uuid4, ulid, seq};
+pub use random::{rand, rand_int, seed};
+pub use codec::{Codec, CodecError};
+pub use format::{format_int, format_float};
+pub use file::{read_file, write_file, file_exists, FileError, FileElement};
+pub use env::{env, setenv};
+pub use json::{from_json, to_json, JsonElement, JsonError, JsonValue};
+pub use csv::{read_csv, write_csv, CsvColumn, CsvField};
+pub use pack::{encode_stack, decode_stack, unpack_into, PackElement, PackError, PACK_MAGIC, PACK_VERSION};
+pub use http::{listen, HTTP_TIMEOUT};
+pub use bridge::{bridge, register_bridge, Bridge, BridgeOpen, BridgeUrl, Deliver};
+pub use mqtt::MQTT_QUEUE;
+pub use source::{every, signals, select_pick};
+pub use closure::{new_fn, call_fn, apply_fn};
+pub use bench::{run_bench, BenchResult, BENCH_REPORT_ENV, BENCH_TIME_ENV};
+pub use global::Global;
 
 /// Error type for stack operations
 #[derive(Debug, Clone, PartialEq, Eq)]
@@ -0,0 +1,359 @@
+//! ualpack: `@blob pack(@s)` and `@s unpack(@blob)`
+//!
+//! A stack's element type, perspective and elements, in push order, as one
+//! bytes element. The format is specified in docs/UALPACK_SPEC.md and is
+//! the one the Go runtime reads and writes, byte for byte, so a blob packed
+//! by either backend unpacks in the other. Decoding fails on the same
+//! input with the same messages.
+
+use std::fmt;
+
+use crate::{Perspective, Stack};
+
+/// The first bytes of every encoding
+pub const PACK_MAGIC: &[u8; 4] = b"UALP";
+
+/// The version of the format [`encode_stack`] writes
+pub const PACK_VERSION: u8 = 1;
+
+/// The ual names of the element type codes, for errors
+const TYPE_NAMES: [&str; 9] = ["i64", "u64", "f64", "string", "bytes", "bool", "i32", "u32", "f32"];
+
+/// Why an encoding was not unpacked
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PackError {
+    message: String,
+}
+
+impl PackError {
+    fn new(message: String) -> Self {
+        PackError { message: format!("unpack: {}", message) }
+    }
+}
+
+impl fmt::Display for PackError {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for PackError {}
+
+/// An element type a stack can be packed as: its code in the header, and
+/// the encoding of one element
+pub trait PackElement: Sized {
+    /// The element type code, as the Go runtime numbers its types
+    const TYPE: u8;
+    /// Append the encoding of the element
+    fn write_pack(&self, out: &mut Vec<u8>);
+    /// The element from its encoding, which has the length the type
+    /// reads: 8, 4 or 1 bytes, or the bytes after the length of a string
+    fn from_pack(raw: &[u8]) -> Self;
+}
+
+macro_rules! pack_fixed {
+    ($t:ty, $code:expr, $n:expr) => {
+        impl PackElement for $t {
+            const TYPE: u8 = $code;
+            fn write_pack(&self, out: &mut Vec<u8>) {
+                out.extend_from_slice(&self.to_be_bytes());
+            }
+            fn from_pack(raw: &[u8]) -> Self {
+                let mut b = [0u8; $n];
+                b.copy_from_slice(raw);
+                <$t>::from_be_bytes(b)
+            }
+        }
+    };
+}
+
+pack_fixed!(i64, 0, 8);
+pack_fixed!(u64, 1, 8);
+pack_fixed!(f64, 2, 8);
+pack_fixed!(i32, 6, 4);
+pack_fixed!(u32, 7, 4);
+pack_fixed!(f32, 8, 4);
+
+impl PackElement for String {
+    const TYPE: u8 = 3;
+    fn write_pack(&self, out: &mut Vec<u8>) {
+        put_bytes(out, self.as_bytes());
+    }
+    fn from_pack(raw: &[u8]) -> Self {
+        String::from_utf8_lossy(raw).into_owned()
+    }
+}
+
+impl PackElement for Vec<u8> {
+    const TYPE: u8 = 4;
+    fn write_pack(&self, out: &mut Vec<u8>) {
+        put_bytes(out, self);
+    }
+    fn from_pack(raw: &[u8]) -> Self {
+        raw.to_vec()
+    }
+}
+
+impl PackElement for bool {
+    const TYPE: u8 = 5;
+    fn write_pack(&self, out: &mut Vec<u8>) {
+        out.push(*self as u8);
+    }
+    fn from_pack(raw: &[u8]) -> Self {
+        raw[0] == 1
+    }
+}
+
+/// The ualpack encoding of `stack`: live keys in the order they were first
+/// set on a Hash stack, elements in push order otherwise.
+pub fn encode_stack<T: Clone + PackElement>(stack: &Stack<T>) -> Vec<u8> {
+    let perspective = stack.perspective();
+    let mut out = PACK_MAGIC.to_vec();
+    out.extend_from_slice(&[PACK_VERSION, T::TYPE, perspective_code(perspective), 0]);
+    if perspective == Perspective::Hash {
+        let keys = stack.keys();
+        let guard = stack.lock();
+        let live: Vec<(&String, &T)> =
+            keys.iter().filter_map(|k| guard.get_raw(k).map(|e| (k, e))).collect();
+        put_uvarint(&mut out, live.len() as u64);
+        for (key, e) in live {
+            put_bytes(&mut out, key.as_bytes());
+            e.write_pack(&mut out);
+        }
+        return out;
+    }
+    let guard = stack.lock();
+    let elems = guard.as_slice();
+    put_uvarint(&mut out, elems.len() as u64);
+    for e in elems {
+        e.write_pack(&mut out);
+    }
+    out
+}
+
+/// A new stack holding what `data` describes. Its elements must be of
+/// type `T`.
+pub fn decode_stack<T: Clone + PackElement>(data: &[u8]) -> Result<Stack<T>, PackError> {
+    let packed = parse(data)?;
+    check_type::<T>(packed.ty)?;
+    let stack = Stack::new(packed.perspective);
+    push_all(&stack, packed)?;
+    Ok(stack)
+}
+
+/// Push the elements of the encoding `data` to `stack`, which must hold
+/// the same element type and be a Hash stack if and only if the encoded
+/// one was. If the encoding does not fit, nothing is pushed.
+pub fn unpack_into<T: Clone + PackElement>(stack: &Stack<T>, data: &[u8]) -> Result<(), PackError> {
+    let packed = parse(data)?;
+    check_type::<T>(packed.ty)?;
+    if (packed.perspective == Perspective::Hash) != (stack.perspective() == Perspective::Hash) {
+        return Err(PackError::new("only a Hash stack takes the elements of a Hash stack".into()));
+    }
+    push_all(stack, packed)
+}
+
+fn check_type<T: PackElement>(ty: u8) -> Result<(), PackError> {
+    if ty == T::TYPE {
+        return Ok(());
+    }
+    Err(PackError::new(format!(
+        "{} elements, the stack holds {}",
+        TYPE_NAMES[ty as usize], TYPE_NAMES[T::TYPE as usize]
+    )))
+}
+
+fn push_all<T: Clone + PackElement>(stack: &Stack<T>, packed: Packed<'_>) -> Result<(), PackError> {
+    for (key, raw) in packed.elems {
+        let e = T::from_pack(raw);
+        let pushed = match key {
+            Some(k) => stack.push_keyed(&k, e),
+            None => stack.push(e),
+        };
+        pushed.map_err(|e| PackError::new(e.to_string()))?;
+    }
+    Ok(())
+}
+
+/// A checked encoding, its elements not yet converted
+struct Packed<'a> {
+    ty: u8,
+    perspective: Perspective,
+    elems: Vec<(Option<String>, &'a [u8])>,
+}
+
+fn parse(data: &[u8]) -> Result<Packed<'_>, PackError> {
+    let fail = |m: String| Err(PackError::new(m));
+    if data.len() < 8 || &data[..4] != PACK_MAGIC {
+        return fail("not a ualpack encoding".into());
+    }
+    if data[4] != PACK_VERSION {
+        return fail(format!("version {} is not supported", data[4]));
+    }
+    let ty = data[5];
+    if ty as usize >= TYPE_NAMES.len() {
+        return fail(format!("unknown element type {}", ty));
+    }
+    let perspective = match perspective_from(data[6]) {
+        Some(p) => p,
+        None => return fail(format!("unknown perspective {}", data[6])),
+    };
+    if data[7] != 0 {
+        return fail(format!("unknown flags {:#x}", data[7]));
+    }
+
+    let mut r = &data[8..];
+    let truncated = || PackError::new("truncated".into());
+    let count = get_uvarint(&mut r).ok_or_else(truncated)?;
+    let mut elems = Vec::new();
+    let mut seen = std::collections::HashSet::new();
+    for _ in 0..count {
+        let mut key = None;
+        if perspective == Perspective::Hash {
+            let k = String::from_utf8_lossy(get_bytes(&mut r).ok_or_else(truncated)?).into_owned();
+            if !seen.insert(k.clone()) {
+                return fail(format!("key {:?} twice", k));
+            }
+            key = Some(k);
+        }
+        let raw = get_element(&mut r, ty).ok_or_else(truncated)?;
+        elems.push((key, raw));
+    }
+    if !r.is_empty() {
+        return fail(format!("{} bytes after the last element", r.len()));
+    }
+    Ok(Packed { ty, perspective, elems })
+}
+
+fn perspective_code(p: Perspective) -> u8 {
+    match p {
+        Perspective::LIFO => 0,
+        Perspective::FIFO => 1,
+        Perspective::Indexed => 2,
+        Perspective::Hash => 3,
+    }
+}
+
+fn perspective_from(code: u8) -> Option<Perspective> {
+    match code {
+        0 => Some(Perspective::LIFO),
+        1 => Some(Perspective::FIFO),
+        2 => Some(Perspective::Indexed),
+        3 => Some(Perspective::Hash),
+        _ => None,
+    }
+}
+
+/// Append `v` as an unsigned LEB128 varint, as Go's binary.AppendUvarint
+fn put_uvarint(out: &mut Vec<u8>, mut v: u64) {
+    while v >= 0x80 {
+        out.push(v as u8 | 0x80);
+        v >>= 7;
+    }
+    out.push(v as u8);
+}
+
+fn put_bytes(out: &mut Vec<u8>, b: &[u8]) {
+    put_uvarint(out, b.len() as u64);
+    out.extend_from_slice(b);
+}
+
+fn get_uvarint(r: &mut &[u8]) -> Option<u64> {
+    let mut v = 0u64;
+    for (n, &b) in r.iter().enumerate().take(10) {
+        if n == 9 && b > 1 {
+            return None; // overflows 64 bits
+        }
+        v |= ((b & 0x7f) as u64) << (7 * n);
+        if b < 0x80 {
+            *r = &r[n + 1..];
+            return Some(v);
+        }
+    }
+    None
+}
+
+fn get_next<'a>(r: &mut &'a [u8], n: u64) -> Option<&'a [u8]> {
+    if (r.len() as u64) < n {
+        return None;
+    }
+    let (b, rest) = r.split_at(n as usize);
+    *r = rest;
+    Some(b)
+}
+
+fn get_bytes<'a>(r: &mut &'a [u8]) -> Option<&'a [u8]> {
+    let n = get_uvarint(r)?;
+    get_next(r, n)
+}
+
+/// Read one element of type code `ty`
+fn get_element<'a>(r: &mut &'a [u8], ty: u8) -> Option<&'a [u8]> {
+    match ty {
+        0 | 1 | 2 => get_next(r, 8),
+        6 | 7 | 8 => get_next(r, 4),
+        5 => get_next(r, 1).filter(|b| b[0] <= 1),
+        _ => get_bytes(r),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn round_trip() {
+        let s: Stack<i64> = Stack::new(Perspective::FIFO);
+        s.push(3).unwrap();
+        s.push(-7).unwrap();
+        let data = encode_stack(&s);
+        assert_eq!(&data[..8], b"UALP\x01\x00\x01\x00");
+        let back: Stack<i64> = decode_stack(&data).unwrap();
+        assert_eq!(back.perspective(), Perspective::FIFO);
+        assert_eq!(back.lock().as_slice(), &[3, -7]);
+
+        let f: Stack<f32> = Stack::new(Perspective::LIFO);
+        f.push(1.5).unwrap();
+        let back: Stack<f32> = decode_stack(&encode_stack(&f)).unwrap();
+        assert_eq!(back.lock().as_slice(), &[1.5]);
+    }
+
+    #[test]
+    fn same_bytes_as_go() {
+        // EncodeStack of a Hash string stack {"a": "x"} in the Go runtime
+        let s: Stack<String> = Stack::new(Perspective::Hash);
+        s.push_keyed("a", "x".to_string()).unwrap();
+        assert_eq!(encode_stack(&s), b"UALP\x01\x03\x03\x00\x01\x01a\x01x".to_vec());
+    }
+
+    #[test]
+    fn errors() {
+        let s: Stack<String> = Stack::new(Perspective::LIFO);
+        s.push("x".to_string()).unwrap();
+        let data = encode_stack(&s);
+
+        let ints: Stack<i64> = Stack::new(Perspective::LIFO);
+        let err = unpack_into(&ints, &data).unwrap_err();
+        assert_eq!(err.to_string(), "unpack: string elements, the stack holds i64");
+        assert_eq!(ints.len(), 0);
+
+        let hash: Stack<String> = Stack::new(Perspective::Hash);
+        assert!(unpack_into(&hash, &data).unwrap_err().to_string().contains("Hash stack"));
+
+        let cases: Vec<(Vec<u8>, &str)> = vec![
+            (b"nope".to_vec(), "not a ualpack encoding"),
+            (b"UALP\x09\x00\x00\x00\x00".to_vec(), "version 9"),
+            (b"UALP\x01\x2a\x00\x00\x00".to_vec(), "element type 42"),
+            (b"UALP\x01\x00\x09\x00\x00".to_vec(), "perspective 9"),
+            (b"UALP\x01\x00\x00\x01\x00".to_vec(), "flags 0x1"),
+            (data[..data.len() - 1].to_vec(), "truncated"),
+            ([&data[..], b"\x00"].concat(), "1 bytes after"),
+            (b"UALP\x01\x03\x03\x00\x02\x01k\x00\x01k\x00".to_vec(), "key \"k\" twice"),
+            (b"UALP\x01\x05\x00\x00\x01\x02".to_vec(), "truncated"),
+        ];
+        for (data, want) in cases {
+            let err = decode_stack::<String>(&data).err().map(|e| e.to_string()).unwrap_or_default();
+            assert!(err.contains(want), "{:?}: {:?}, want {:?}", data, err, want);
+        }
+    }
+}
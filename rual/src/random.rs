@@ -0,0 +1,96 @@
+//! Random numbers: `rand()`, `rand_int(n)` and `seed(x)`
+//!
+//! The same xoshiro256** generator as the Go runtime, its state filled
+//! from the seed by splitmix64, so a seeded program draws the same numbers
+//! from either backend. Until `seed` is called the state comes from the
+//! operating system. Not suitable for keys or tokens.
+
+use std::sync::Mutex;
+
+use crate::ids::random_bytes;
+
+struct Rng {
+    s: [u64; 4],
+    seeded: bool,
+}
+
+static RNG: Mutex<Rng> = Mutex::new(Rng { s: [0; 4], seeded: false });
+
+impl Rng {
+    /// Fill the state from `x` with splitmix64
+    fn seed(&mut self, mut x: u64) {
+        for v in self.s.iter_mut() {
+            x = x.wrapping_add(0x9e3779b97f4a7c15);
+            let mut z = x;
+            z = (z ^ (z >> 30)).wrapping_mul(0xbf58476d1ce4e5b9);
+            z = (z ^ (z >> 27)).wrapping_mul(0x94d049bb133111eb);
+            *v = z ^ (z >> 31);
+        }
+        self.seeded = true;
+    }
+
+    fn next(&mut self) -> u64 {
+        if !self.seeded {
+            let mut b = [0u8; 8];
+            random_bytes(&mut b);
+            self.seed(u64::from_le_bytes(b));
+        }
+        let s = &mut self.s;
+        let result = s[1].wrapping_mul(5).rotate_left(7).wrapping_mul(9);
+        let t = s[1] << 17;
+        s[2] ^= s[0];
+        s[3] ^= s[1];
+        s[1] ^= s[2];
+        s[0] ^= s[3];
+        s[2] ^= t;
+        s[3] = s[3].rotate_left(45);
+        result
+    }
+}
+
+/// Restart the random sequence from `x`
+pub fn seed(x: i64) {
+    RNG.lock().unwrap().seed(x as u64);
+}
+
+/// A float from 0 up to but not including 1, from the top 53 bits of the
+/// next value
+pub fn rand() -> f64 {
+    (RNG.lock().unwrap().next() >> 11) as f64 * (1.0 / (1u64 << 53) as f64)
+}
+
+/// An integer from 0 up to but not including `n`, or 0 if `n` is not
+/// positive. Values that would favour the low numbers are drawn again.
+pub fn rand_int(n: i64) -> i64 {
+    if n <= 0 {
+        return 0;
+    }
+    let bound = n as u64;
+    let threshold = bound.wrapping_neg() % bound; // 2^64 mod n
+    let mut rng = RNG.lock().unwrap();
+    loop {
+        let r = rng.next();
+        if r >= threshold {
+            return (r % bound) as i64;
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    // The sequences of the Go runtime's TestSeedSequence
+    #[test]
+    fn test_seed_sequence() {
+        seed(0);
+        assert_eq!(RNG.lock().unwrap().next(), 0x99ec5f36cb75f2b4);
+        seed(42);
+        let got: Vec<i64> = (0..4).map(|_| rand_int(1000)).collect();
+        assert_eq!(got, vec![742, 102, 9, 193]);
+        seed(42);
+        assert_eq!(rand(), 0.08386297105988216);
+        assert_eq!(rand_int(0), 0);
+        assert_eq!(rand_int(-5), 0);
+    }
+}
@@ -0,0 +1,226 @@
+//! Select support: timer and signal sources, and picking a ready case
+//!
+//! Mirrors the Go runtime. Each source is a stack that a select case waits
+//! on like any other: [`every`] receives the Unix time in milliseconds on
+//! each tick, and [`signals`] receives the name of each signal delivered.
+//! Signal handling is Unix only; the handler writes the signal number to a
+//! pipe and a watcher thread pushes it to the subscribed stacks.
+//! [`select_pick`] chooses which of the ready cases runs.
+
+use crate::{Perspective, Stack};
+use std::cell::Cell;
+use std::sync::Arc;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// The index of the ready case a select runs, or `None` if none is ready.
+///
+/// When `fair` is true the search starts from a random case, so a busy
+/// stack cannot starve the ones after it. When `fair` is false the first
+/// ready case wins, giving earlier cases priority.
+pub fn select_pick(ready: &[bool], fair: bool) -> Option<usize> {
+    let n = ready.len();
+    let start = if fair && n > 1 { random_below(n) } else { 0 };
+    (0..n).map(|k| (start + k) % n).find(|&i| ready[i])
+}
+
+/// A cheap per-thread xorshift generator; select only needs to spread picks
+fn random_below(n: usize) -> usize {
+    thread_local! {
+        static STATE: Cell<u64> = Cell::new({
+            use std::collections::hash_map::RandomState;
+            use std::hash::{BuildHasher, Hasher};
+            RandomState::new().build_hasher().finish() | 1
+        });
+    }
+    STATE.with(|s| {
+        let mut x = s.get();
+        x ^= x << 13;
+        x ^= x >> 7;
+        x ^= x << 17;
+        s.set(x);
+        (x % n as u64) as usize
+    })
+}
+
+/// A FIFO stack that receives the current Unix time in milliseconds every
+/// `ms` milliseconds. At most one tick is held; ticks that arrive while one
+/// is still waiting are dropped. Closing the stack stops the timer.
+pub fn every(ms: i64) -> Arc<Stack<i64>> {
+    let interval = Duration::from_millis(ms.max(1) as u64);
+    let stack = Arc::new(Stack::with_capacity(Perspective::FIFO, 1));
+    let ticks = Arc::clone(&stack);
+    std::thread::spawn(move || loop {
+        std::thread::sleep(interval);
+        if ticks.is_closed() {
+            return;
+        }
+        let now = SystemTime::now().duration_since(UNIX_EPOCH).unwrap_or_default();
+        let _ = ticks.push(now.as_millis() as i64); // full: drop the tick
+    });
+    stack
+}
+
+/// A FIFO stack that receives the name of each of the given signals
+/// ("SIGINT", "SIGTERM", ...) as it is delivered to the process. Names may
+/// omit the SIG prefix. The signals no longer trigger their default action.
+pub fn signals(names: &[&str]) -> Result<Arc<Stack<String>>, String> {
+    let mut sigs = Vec::with_capacity(names.len());
+    for name in names {
+        match signals::lookup(name) {
+            Some(sig) => sigs.push(sig),
+            None => return Err(format!("unknown signal \"{}\"", name)),
+        }
+    }
+    let stack = Arc::new(Stack::new(Perspective::FIFO));
+    signals::subscribe(&sigs, Arc::clone(&stack))?;
+    Ok(stack)
+}
+
+#[cfg(unix)]
+mod signals {
+    use crate::Stack;
+    use std::os::raw::c_int;
+    use std::sync::atomic::{AtomicI32, Ordering};
+    use std::sync::{Arc, Mutex, Once};
+
+    #[cfg(any(target_os = "linux", target_os = "android"))]
+    const NAMES: &[(&str, c_int)] = &[
+        ("SIGHUP", 1), ("SIGINT", 2), ("SIGQUIT", 3), ("SIGTERM", 15),
+        ("SIGUSR1", 10), ("SIGUSR2", 12), ("SIGWINCH", 28),
+    ];
+    #[cfg(not(any(target_os = "linux", target_os = "android")))]
+    const NAMES: &[(&str, c_int)] = &[
+        ("SIGHUP", 1), ("SIGINT", 2), ("SIGQUIT", 3), ("SIGTERM", 15),
+        ("SIGUSR1", 30), ("SIGUSR2", 31), ("SIGWINCH", 28),
+    ];
+
+    extern "C" {
+        fn pipe(fds: *mut c_int) -> c_int;
+        fn read(fd: c_int, buf: *mut u8, n: usize) -> isize;
+        fn write(fd: c_int, buf: *const u8, n: usize) -> isize;
+        fn signal(sig: c_int, handler: usize) -> usize;
+    }
+
+    static WRITE_FD: AtomicI32 = AtomicI32::new(-1);
+    static WATCHER: Once = Once::new();
+    static SUBSCRIBERS: Mutex<Vec<(c_int, Arc<Stack<String>>)>> = Mutex::new(Vec::new());
+
+    // Only async-signal-safe work here: hand the number to the watcher
+    extern "C" fn on_signal(sig: c_int) {
+        let b = sig as u8;
+        unsafe { write(WRITE_FD.load(Ordering::Relaxed), &b, 1) };
+    }
+
+    pub fn lookup(name: &str) -> Option<c_int> {
+        let mut name = name.to_ascii_uppercase();
+        if !name.starts_with("SIG") {
+            name.insert_str(0, "SIG");
+        }
+        NAMES.iter().find(|(n, _)| *n == name).map(|&(_, sig)| sig)
+    }
+
+    fn name_of(sig: c_int) -> &'static str {
+        NAMES.iter().find(|&&(_, s)| s == sig).map_or("SIG?", |&(n, _)| n)
+    }
+
+    fn start_watcher() -> bool {
+        let mut fds = [0 as c_int; 2];
+        if unsafe { pipe(fds.as_mut_ptr()) } != 0 {
+            return false;
+        }
+        WRITE_FD.store(fds[1], Ordering::Relaxed);
+        let read_fd = fds[0];
+        std::thread::Builder::new()
+            .name("ual-signals".into())
+            .spawn(move || {
+                let mut b = 0u8;
+                while unsafe { read(read_fd, &mut b, 1) } == 1 {
+                    let sig = b as c_int;
+                    for (s, stack) in SUBSCRIBERS.lock().unwrap().iter() {
+                        if *s == sig {
+                            let _ = stack.push(name_of(sig).to_string());
+                        }
+                    }
+                }
+            })
+            .is_ok()
+    }
+
+    pub fn subscribe(sigs: &[c_int], stack: Arc<Stack<String>>) -> Result<(), String> {
+        WATCHER.call_once(|| {
+            start_watcher();
+        });
+        if WRITE_FD.load(Ordering::Relaxed) < 0 {
+            return Err("signal watcher could not be started".to_string());
+        }
+        let mut subs = SUBSCRIBERS.lock().unwrap();
+        for &sig in sigs {
+            subs.push((sig, Arc::clone(&stack)));
+            unsafe { signal(sig, on_signal as extern "C" fn(c_int) as usize) };
+        }
+        Ok(())
+    }
+}
+
+#[cfg(not(unix))]
+mod signals {
+    use crate::Stack;
+    use std::sync::Arc;
+
+    pub fn lookup(_name: &str) -> Option<i32> {
+        None
+    }
+
+    pub fn subscribe(_sigs: &[i32], _stack: Arc<Stack<String>>) -> Result<(), String> {
+        Err("signals are not supported on this platform".to_string())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_select_pick() {
+        assert_eq!(select_pick(&[false, false], true), None);
+        assert_eq!(select_pick(&[], true), None);
+        assert_eq!(select_pick(&[true, true], false), Some(0));
+        assert_eq!(select_pick(&[false, true], false), Some(1));
+
+        let mut counts = [0; 2];
+        for _ in 0..200 {
+            counts[select_pick(&[true, true], true).unwrap()] += 1;
+        }
+        assert!(counts[0] > 50 && counts[1] > 50, "skewed: {:?}", counts);
+    }
+
+    #[test]
+    fn test_every() {
+        let ticks = every(10);
+        std::thread::sleep(Duration::from_millis(60));
+        assert_eq!(ticks.len(), 1); // later ticks dropped
+        assert!(ticks.pop().unwrap() > 0);
+        ticks.close();
+    }
+
+    #[test]
+    fn test_signals() {
+        assert!(signals(&["SIGNOPE"]).is_err());
+
+        #[cfg(unix)]
+        {
+            extern "C" {
+                fn raise(sig: std::os::raw::c_int) -> std::os::raw::c_int;
+            }
+            let sigs = signals(&["usr1"]).unwrap();
+            unsafe { raise(signals::lookup("SIGUSR1").unwrap()) };
+            for _ in 0..100 {
+                if !sigs.is_empty() {
+                    break;
+                }
+                std::thread::sleep(Duration::from_millis(10));
+            }
+            assert_eq!(sigs.pop().unwrap(), "SIGUSR1");
+        }
+    }
+}
@@ -31,6 +31,34 @@ pub enum ElementType {
     Bool,
 }
 
+/// The changes a frozen stack rejects. Freezing again adds to them, so an
+/// append-only stack frozen with `STRUCTURE` becomes read-only.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct FreezeMode(u8);
+
+impl FreezeMode {
+    const ADD: u8 = 1;
+    const REMOVE: u8 = 2;
+    const UPDATE: u8 = 4;
+
+    /// Reject every change
+    pub const ALL: FreezeMode = FreezeMode(Self::ADD | Self::REMOVE | Self::UPDATE);
+    /// Reject adding and removing elements; values can change in place
+    pub const STRUCTURE: FreezeMode = FreezeMode(Self::ADD | Self::REMOVE);
+    /// Accept new elements only
+    pub const APPEND_ONLY: FreezeMode = FreezeMode(Self::REMOVE | Self::UPDATE);
+
+    /// The mode named by `freeze("...")`: "all", "structure" or "append-only"
+    pub fn lookup(name: &str) -> Option<FreezeMode> {
+        match name {
+            "all" => Some(Self::ALL),
+            "structure" => Some(Self::STRUCTURE),
+            "append-only" => Some(Self::APPEND_ONLY),
+            _ => None,
+        }
+    }
+}
+
 /// Inner state of the stack (behind the mutex)
 struct StackInner<T> {
     elements: Vec<T>,
@@ -38,11 +66,15 @@ struct StackInner<T> {
     hash_idx: HashMap<String, usize>,
     head: usize,  // For FIFO: index of first valid element
     perspective: Perspective,
-    frozen: bool,
+    frozen: u8,  // FreezeMode bits
     closed: bool,
     capacity: usize,  // 0 = unlimited
+    sink: Option<Sink<T>>,  // a bridge, see bridge.rs: pushes are also passed to it
 }
 
+/// Where a bridged stack's pushes also go
+pub(crate) type Sink<T> = Box<dyn Fn(&T) + Send>;
+
 impl<T> StackInner<T> {
     fn len(&self) -> usize {
         self.elements.len() - self.head
@@ -56,6 +88,21 @@ impl<T> StackInner<T> {
         self.capacity > 0 && self.len() >= self.capacity
     }
 
+    /// Whether the freeze mode permits a change (FreezeMode::ADD, ...)
+    fn allows(&self, change: u8) -> bool {
+        self.frozen & change == 0
+    }
+
+    /// Whether the freeze mode permits storing a value under key: an
+    /// update if the Hash stack has the key, otherwise an addition
+    fn allows_set(&self, key: &str) -> bool {
+        if self.perspective == Perspective::Hash && self.hash_idx.contains_key(key) {
+            self.allows(FreezeMode::UPDATE)
+        } else {
+            self.allows(FreezeMode::ADD)
+        }
+    }
+
     /// Compact FIFO slack when head gets too far ahead
     fn compact(&mut self) {
         if self.head > 0 && self.head > self.elements.len() / 2 && self.head > 100 {
@@ -100,9 +147,10 @@ impl<T: Clone> Stack<T> {
                 },
                 head: 0,
                 perspective,
-                frozen: false,
+                frozen: 0,
                 closed: false,
                 capacity: 0,
+                sink: None,
             }),
         }
     }
@@ -120,9 +168,10 @@ impl<T: Clone> Stack<T> {
                 },
                 head: 0,
                 perspective,
-                frozen: false,
+                frozen: 0,
                 closed: false,
                 capacity,
+                sink: None,
             }),
         }
     }
@@ -131,7 +180,7 @@ impl<T: Clone> Stack<T> {
     pub fn push(&self, value: T) -> Result<()> {
         let mut inner = self.inner.lock();
         
-        if inner.frozen {
+        if !inner.allows(FreezeMode::ADD) {
             return Err(StackError::Frozen);
         }
         if inner.is_full() {
@@ -140,6 +189,9 @@ impl<T: Clone> Stack<T> {
         
         match inner.perspective {
             Perspective::LIFO | Perspective::FIFO | Perspective::Indexed => {
+                if let Some(sink) = &inner.sink {
+                    sink(&value);
+                }
                 inner.elements.push(value);
                 inner.keys.push(None);
                 Ok(())
@@ -154,7 +206,7 @@ impl<T: Clone> Stack<T> {
     pub fn push_keyed(&self, key: &str, value: T) -> Result<()> {
         let mut inner = self.inner.lock();
         
-        if inner.frozen {
+        if !inner.allows_set(key) {
             return Err(StackError::Frozen);
         }
         if inner.is_full() {
@@ -207,6 +259,10 @@ impl<T: Clone> Stack<T> {
     pub fn take_timeout(&self, timeout_ms: u64) -> Result<T> {
         use std::time::{Duration, Instant};
         
+        if !self.inner.lock().allows(FreezeMode::REMOVE) {
+            return Err(StackError::Frozen);
+        }
+        
         // Fast path: try non-blocking first
         if let Ok(value) = self.pop() {
             return Ok(value);
@@ -243,7 +299,7 @@ impl<T: Clone> Stack<T> {
 
     /// Internal pop implementation
     fn pop_inner(&self, inner: &mut MutexGuard<StackInner<T>>, param: Option<PopParam>) -> Result<T> {
-        if inner.frozen {
+        if !inner.allows(FreezeMode::REMOVE) {
             return Err(StackError::Frozen);
         }
         if inner.is_empty() {
@@ -407,9 +463,52 @@ impl<T: Clone> Stack<T> {
         self.inner.lock().is_empty()
     }
 
-    /// Clear all elements
+    /// Whether a Hash stack holds `key`
+    pub fn has(&self, key: &str) -> bool {
+        self.inner.lock().hash_idx.contains_key(key)
+    }
+
+    /// Remove `key` from a Hash stack, reporting whether it was there.
+    /// Unlike `pop_key` no tombstone is left, so `len` drops and the other
+    /// keys keep their order.
+    pub fn delete(&self, key: &str) -> Result<bool> {
+        let mut inner = self.inner.lock();
+        if inner.perspective != Perspective::Hash {
+            return Err(StackError::KeyRequired);
+        }
+        if !inner.allows(FreezeMode::REMOVE) {
+            return Err(StackError::Frozen);
+        }
+        let idx = match inner.hash_idx.remove(key) {
+            Some(idx) => idx,
+            None => return Ok(false),
+        };
+        inner.elements.remove(idx);
+        inner.keys.remove(idx);
+        for i in inner.hash_idx.values_mut() {
+            if *i > idx {
+                *i -= 1;
+            }
+        }
+        Ok(true)
+    }
+
+    /// Keys of a Hash stack in the order they were first set. Updating a
+    /// key keeps its place; other perspectives have no keys.
+    pub fn keys(&self) -> Vec<String> {
+        let inner = self.inner.lock();
+        if inner.perspective != Perspective::Hash {
+            return Vec::new();
+        }
+        inner.keys[inner.head..].iter().flatten().cloned().collect()
+    }
+
+    /// Clear all elements. Does nothing to a stack frozen against removal.
     pub fn clear(&self) {
         let mut inner = self.inner.lock();
+        if !inner.allows(FreezeMode::REMOVE) {
+            return;
+        }
         inner.elements.clear();
         inner.keys.clear();
         inner.hash_idx.clear();
@@ -418,14 +517,19 @@ impl<T: Clone> Stack<T> {
 
     /// Freeze the stack (make immutable)
     pub fn freeze(&self) {
+        self.freeze_with(FreezeMode::ALL)
+    }
+
+    /// Freeze the stack in `mode`, on top of any earlier freeze
+    pub fn freeze_with(&self, mode: FreezeMode) {
         let mut inner = self.inner.lock();
         inner.compact();
-        inner.frozen = true;
+        inner.frozen |= mode.0;
     }
 
-    /// Check if frozen
+    /// Check if frozen in any mode
     pub fn is_frozen(&self) -> bool {
-        self.inner.lock().frozen
+        self.inner.lock().frozen != 0
     }
 
     /// Close the stack (signal no more pushes)
@@ -438,6 +542,27 @@ impl<T: Clone> Stack<T> {
         self.inner.lock().closed
     }
 
+    /// Pass every later push to `sink` as well; false if the stack already
+    /// has a sink
+    pub(crate) fn set_sink(&self, sink: Sink<T>) -> bool {
+        let mut inner = self.inner.lock();
+        if inner.sink.is_some() {
+            return false;
+        }
+        inner.sink = Some(sink);
+        true
+    }
+
+    /// Push a value that arrived through a bridge, without passing it to
+    /// the sink. It is dropped if the stack is frozen or full.
+    pub(crate) fn inject(&self, value: T) {
+        let mut inner = self.inner.lock();
+        if inner.allows(FreezeMode::ADD) && !inner.is_full() && inner.perspective != Perspective::Hash {
+            inner.elements.push(value);
+            inner.keys.push(None);
+        }
+    }
+
     /// Get current perspective
     pub fn perspective(&self) -> Perspective {
         self.inner.lock().perspective
@@ -483,6 +608,141 @@ impl<T: Clone> Stack<T> {
         self.inner.lock().capacity
     }
 
+    // =========================================================================
+    // Walk / filter
+    //
+    // The source is traversed in its own perspective order and the results
+    // are stored so this stack yields them in that same order: a LIFO
+    // destination receives them reversed so the first result ends up on top.
+    // Hash destinations reuse the source key, or the traversal position when
+    // the source element has none.
+    // =========================================================================
+
+    /// Snapshot elements and keys in perspective order
+    fn snapshot(&self) -> Vec<(Option<String>, T)> {
+        let inner = self.inner.lock();
+        let live = (inner.head..inner.elements.len())
+            .map(|i| (inner.keys[i].clone(), inner.elements[i].clone()));
+        match inner.perspective {
+            Perspective::LIFO => live.rev().collect(),
+            Perspective::FIFO | Perspective::Indexed => live.collect(),
+            Perspective::Hash => live.filter(|(k, _)| k.is_some()).collect(),
+        }
+    }
+
+    /// Apply `f` to every element of `source` and append the results
+    pub fn walk<S: Clone, F: FnMut(&S) -> T>(&self, source: &Stack<S>, mut f: F) -> Result<()> {
+        let results = source.snapshot()
+            .into_iter()
+            .map(|(k, v)| (k, f(&v)))
+            .collect();
+        self.store_results(results)
+    }
+
+    /// Like [`walk`](Self::walk) for a fallible `f`: elements for which `f`
+    /// fails are skipped and their errors passed to `on_err`
+    pub fn try_walk<S, E, F, H>(&self, source: &Stack<S>, mut f: F, mut on_err: H) -> Result<()>
+    where
+        S: Clone,
+        F: FnMut(&S) -> std::result::Result<T, E>,
+        H: FnMut(E),
+    {
+        let results = source.snapshot()
+            .into_iter()
+            .filter_map(|(k, v)| match f(&v) {
+                Ok(r) => Some((k, r)),
+                Err(e) => {
+                    on_err(e);
+                    None
+                }
+            })
+            .collect();
+        self.store_results(results)
+    }
+
+    /// Move one element from `source`, taken as `pop` would, converting it
+    /// with `f`. An element `pred` rejects is taken and dropped. Both stacks
+    /// stay locked throughout, so `f` and `pred` must not use them.
+    pub fn bring_with<S, F, P>(&self, source: &Stack<S>, f: F, pred: P) -> Result<()>
+    where
+        S: Clone,
+        F: FnOnce(&S) -> T,
+        P: FnOnce(&S) -> bool,
+    {
+        let mut src = source.inner.lock();
+        let mut inner = self.inner.lock();
+        if !inner.allows(FreezeMode::ADD) {
+            return Err(StackError::Frozen);
+        }
+        if inner.is_full() {
+            return Err(StackError::Full);
+        }
+        if inner.perspective == Perspective::Hash {
+            return Err(StackError::KeyRequired);
+        }
+        let value = source.pop_inner(&mut src, None)?;
+        if pred(&value) {
+            let value = f(&value);
+            if let Some(sink) = &inner.sink {
+                sink(&value);
+            }
+            inner.elements.push(value);
+            inner.keys.push(None);
+        }
+        Ok(())
+    }
+
+    /// Append the elements of `source` for which `pred` returns true
+    pub fn filter<F: FnMut(&T) -> bool>(&self, source: &Stack<T>, mut pred: F) -> Result<()> {
+        let results = source.snapshot()
+            .into_iter()
+            .filter(|(_, v)| pred(v))
+            .collect();
+        self.store_results(results)
+    }
+
+    fn store_results(&self, mut results: Vec<(Option<String>, T)>) -> Result<()> {
+        let mut inner = self.inner.lock();
+        if results.is_empty() {
+            return Ok(());
+        }
+        if inner.perspective == Perspective::LIFO {
+            results.reverse();
+        }
+        for (pos, (key, value)) in results.into_iter().enumerate() {
+            if inner.perspective == Perspective::Hash {
+                let key = key.unwrap_or_else(|| pos.to_string());
+                if !inner.allows_set(&key) {
+                    return Err(StackError::Frozen);
+                }
+                if let Some(&idx) = inner.hash_idx.get(&key) {
+                    inner.elements[idx] = value;
+                    continue;
+                }
+                if inner.is_full() {
+                    return Err(StackError::Full);
+                }
+                let idx = inner.elements.len();
+                inner.elements.push(value);
+                inner.keys.push(Some(key.clone()));
+                inner.hash_idx.insert(key, idx);
+            } else {
+                if !inner.allows(FreezeMode::ADD) {
+                    return Err(StackError::Frozen);
+                }
+                if inner.is_full() {
+                    return Err(StackError::Full);
+                }
+                if let Some(sink) = &inner.sink {
+                    sink(&value);
+                }
+                inner.elements.push(value);
+                inner.keys.push(None);
+            }
+        }
+        Ok(())
+    }
+
     // =========================================================================
     // Raw access for compute blocks (caller must hold lock)
     // =========================================================================
@@ -493,6 +753,87 @@ impl<T: Clone> Stack<T> {
     }
 }
 
+// =========================================================================
+// Parallel map / reduce
+// =========================================================================
+
+/// Smallest chunk handed to a worker thread; smaller stacks run inline
+const PAR_MIN_CHUNK: usize = 1024;
+
+/// Split `items` into chunks and run `work(chunk_index, chunk)` on up to
+/// one thread per core. Idle threads take the next unclaimed chunk, so
+/// uneven chunks still spread across all cores.
+fn par_chunks<U: Send, F: Fn(usize, &mut [U]) + Sync>(items: &mut [U], min_chunk: usize, work: F) {
+    let cores = std::thread::available_parallelism().map_or(1, |n| n.get());
+    let workers = cores.min((items.len() + min_chunk - 1) / min_chunk);
+    if workers <= 1 {
+        if !items.is_empty() {
+            work(0, items);
+        }
+        return;
+    }
+    let size = ((items.len() + workers * 4 - 1) / (workers * 4)).max(min_chunk);
+    let queue = Mutex::new(items.chunks_mut(size).enumerate());
+    std::thread::scope(|scope| {
+        for _ in 0..workers {
+            scope.spawn(|| loop {
+                let next = queue.lock().next();
+                match next {
+                    Some((i, chunk)) => work(i, chunk),
+                    None => break,
+                }
+            });
+        }
+    });
+}
+
+impl<T: Clone + Send + Sync> Stack<T> {
+    /// Replace every element with `f(element)`, splitting large stacks
+    /// across threads. Order and keys are unchanged; `f` must not touch
+    /// this stack (it is locked for the duration).
+    pub fn par_map<F: Fn(&T) -> T + Sync>(&self, f: F) -> Result<()> {
+        self.par_map_chunked(PAR_MIN_CHUNK, f)
+    }
+
+    fn par_map_chunked<F: Fn(&T) -> T + Sync>(&self, min_chunk: usize, f: F) -> Result<()> {
+        let mut inner = self.inner.lock();
+        if !inner.allows(FreezeMode::UPDATE) {
+            return Err(StackError::Frozen);
+        }
+        let head = inner.head;
+        par_chunks(&mut inner.elements[head..], min_chunk, |_, chunk| {
+            for x in chunk.iter_mut() {
+                *x = f(x);
+            }
+        });
+        Ok(())
+    }
+
+    /// Fold the elements in perspective order like reduce, but fold chunks
+    /// on several threads and then combine the partial results in order.
+    /// `f` must be associative and `init` should be its identity (0 for +),
+    /// since every chunk starts from it.
+    pub fn par_reduce<F: Fn(T, T) -> T + Sync>(&self, init: T, f: F) -> T {
+        self.par_reduce_chunked(PAR_MIN_CHUNK, init, f)
+    }
+
+    fn par_reduce_chunked<F: Fn(T, T) -> T + Sync>(&self, min_chunk: usize, init: T, f: F) -> T {
+        let mut items: Vec<T> = self.snapshot().into_iter().map(|(_, v)| v).collect();
+        let partials = Mutex::new(Vec::new());
+        par_chunks(&mut items, min_chunk, |i, chunk| {
+            let acc = chunk.iter().cloned().fold(init.clone(), &f);
+            partials.lock().push((i, acc));
+        });
+        let mut partials = partials.into_inner();
+        partials.sort_by_key(|(i, _)| *i);
+        let mut parts = partials.into_iter().map(|(_, acc)| acc);
+        match parts.next() {
+            Some(first) => parts.fold(first, &f),
+            None => init,
+        }
+    }
+}
+
 /// Parameter for pop/peek operations
 enum PopParam {
     Index(usize),
@@ -526,12 +867,15 @@ impl<'a, T: Clone> StackGuard<'a, T> {
 
     /// Push without locking
     pub fn push_raw(&mut self, value: T) -> Result<()> {
-        if self.inner.frozen {
+        if !self.inner.allows(FreezeMode::ADD) {
             return Err(StackError::Frozen);
         }
         if self.inner.is_full() {
             return Err(StackError::Full);
         }
+        if let Some(sink) = &self.inner.sink {
+            sink(&value);
+        }
         self.inner.elements.push(value);
         self.inner.keys.push(None);
         Ok(())
@@ -662,6 +1006,43 @@ mod tests {
         assert!(stack.peek_key("b").is_err());
     }
 
+    #[test]
+    fn test_keys() {
+        let stack: Stack<i64> = Stack::new(Perspective::Hash);
+        stack.push_keyed("zeta", 1).unwrap();
+        stack.push_keyed("alpha", 2).unwrap();
+        stack.push_keyed("mid", 3).unwrap();
+        stack.push_keyed("zeta", 4).unwrap();
+        stack.pop_key("alpha").unwrap();
+        stack.push_keyed("alpha", 5).unwrap();
+        assert_eq!(stack.keys(), vec!["zeta", "mid", "alpha"]);
+
+        let lifo: Stack<i64> = Stack::new(Perspective::LIFO);
+        lifo.push(1).unwrap();
+        assert!(lifo.keys().is_empty());
+    }
+
+    #[test]
+    fn test_delete_has() {
+        let stack: Stack<i64> = Stack::new(Perspective::Hash);
+        stack.push_keyed("a", 1).unwrap();
+        stack.push_keyed("b", 2).unwrap();
+        stack.push_keyed("c", 3).unwrap();
+
+        assert!(stack.has("b"));
+        assert_eq!(stack.delete("b"), Ok(true));
+        assert_eq!(stack.delete("b"), Ok(false));
+        assert!(!stack.has("b"));
+        assert_eq!(stack.len(), 2);
+        assert_eq!(stack.peek_key("c").unwrap(), 3);
+        assert_eq!(stack.keys(), vec!["a", "c"]);
+
+        stack.freeze();
+        assert_eq!(stack.delete("a"), Err(StackError::Frozen));
+        let lifo: Stack<i64> = Stack::new(Perspective::LIFO);
+        assert!(lifo.delete("a").is_err());
+    }
+
     #[test]
     fn test_freeze() {
         let stack: Stack<i64> = Stack::new(Perspective::LIFO);
@@ -711,4 +1092,135 @@ mod tests {
         let slice = guard.as_slice();
         assert_eq!(slice, &[1, 2, 3]);
     }
+
+    #[test]
+    fn test_walk_preserves_order() {
+        for p in [Perspective::LIFO, Perspective::FIFO, Perspective::Indexed] {
+            let src: Stack<i64> = Stack::new(p);
+            for v in [1, 2, 3] {
+                src.push(v).unwrap();
+            }
+            let dst: Stack<i64> = Stack::new(p);
+            dst.walk(&src, |x| x * 10).unwrap();
+
+            let want = if p == Perspective::LIFO { [30, 20, 10] } else { [10, 20, 30] };
+            let got: Vec<i64> = (0..3).map(|i| dst.peek_at(i).unwrap()).collect();
+            assert_eq!(got, want, "{:?}", p);
+            assert_eq!(src.len(), 3);  // Source not consumed
+        }
+    }
+
+    #[test]
+    fn test_walk_hash() {
+        let src: Stack<i64> = Stack::new(Perspective::Hash);
+        src.push_keyed("a", 1).unwrap();
+        src.push_keyed("b", 2).unwrap();
+        src.pop_key("a").unwrap();
+
+        let dst: Stack<String> = Stack::new(Perspective::Hash);
+        dst.walk(&src, |x| format!("v{}", x)).unwrap();
+        assert_eq!(dst.peek_key("b").unwrap(), "v2");
+        assert!(dst.peek_key("a").is_err());
+
+        // Positional source into Hash falls back to the position as key
+        let list: Stack<i64> = Stack::new(Perspective::FIFO);
+        list.push(7).unwrap();
+        let h: Stack<i64> = Stack::new(Perspective::Hash);
+        h.walk(&list, |x| *x).unwrap();
+        assert_eq!(h.peek_key("0").unwrap(), 7);
+    }
+
+    #[test]
+    fn test_bring_with() {
+        let src: Stack<i64> = Stack::new(Perspective::FIFO);
+        let dst: Stack<f64> = Stack::new(Perspective::FIFO);
+        src.push(-1).unwrap();
+        src.push(3).unwrap();
+        dst.bring_with(&src, |x| *x as f64 * 2.0, |x| *x > 0).unwrap();
+        assert_eq!(src.len(), 1);  // -1 taken and dropped
+        assert!(dst.is_empty());
+        dst.bring_with(&src, |x| *x as f64 * 2.0, |x| *x > 0).unwrap();
+        assert_eq!(dst.pop().unwrap(), 6.0);
+        assert!(dst.bring_with(&src, |x| *x as f64, |_| true).is_err());
+
+        let full: Stack<f64> = Stack::with_capacity(Perspective::FIFO, 1);
+        full.push(0.0).unwrap();
+        src.push(5).unwrap();
+        assert!(full.bring_with(&src, |x| *x as f64, |_| true).is_err());
+        assert_eq!(src.len(), 1);  // left in place
+    }
+
+    #[test]
+    fn test_filter() {
+        let src: Stack<i64> = Stack::new(Perspective::LIFO);
+        for v in 1..=5 {
+            src.push(v).unwrap();
+        }
+        src.filter(&src, |x| x % 2 == 1).unwrap();  // Self-filter appends
+        assert_eq!(src.len(), 8);
+        assert_eq!(src.pop().unwrap(), 5);
+
+        let frozen: Stack<i64> = Stack::new(Perspective::FIFO);
+        frozen.freeze();
+        assert!(frozen.filter(&src, |_| true).is_err());
+
+        let full: Stack<i64> = Stack::with_capacity(Perspective::FIFO, 1);
+        assert!(full.filter(&src, |_| true).is_err());
+    }
+
+    #[test]
+    fn test_par_map() {
+        let s: Stack<i64> = Stack::new(Perspective::Indexed);
+        for v in 0..100 {
+            s.push(v).unwrap();
+        }
+        s.par_map_chunked(3, |x| x * x).unwrap();
+        assert_eq!(s.len(), 100);
+        assert_eq!(s.peek_at(0).unwrap(), 0);
+        assert_eq!(s.peek_at(99).unwrap(), 99 * 99);
+
+        let frozen: Stack<i64> = Stack::new(Perspective::LIFO);
+        frozen.freeze();
+        assert!(frozen.par_map(|x| *x).is_err());
+    }
+
+    #[test]
+    fn test_freeze_modes() {
+        let log: Stack<i64> = Stack::new(Perspective::FIFO);
+        log.push(1).unwrap();
+        log.freeze_with(FreezeMode::APPEND_ONLY);
+        assert!(log.push(2).is_ok());
+        assert!(log.pop().is_err());
+        log.clear();
+        assert_eq!(log.len(), 2);
+
+        let h: Stack<i64> = Stack::new(Perspective::Hash);
+        h.push_keyed("a", 1).unwrap();
+        h.freeze_with(FreezeMode::STRUCTURE);
+        assert!(h.push_keyed("a", 2).is_ok());
+        assert!(h.push_keyed("b", 3).is_err());
+        h.freeze_with(FreezeMode::APPEND_ONLY);
+        assert!(h.push_keyed("a", 4).is_err());
+        assert_eq!(FreezeMode::lookup("append-only"), Some(FreezeMode::APPEND_ONLY));
+        assert_eq!(FreezeMode::lookup("read-only"), None);
+    }
+
+    #[test]
+    fn test_par_reduce() {
+        let s: Stack<i64> = Stack::new(Perspective::Indexed);
+        for v in 1..=1000 {
+            s.push(v).unwrap();
+        }
+        assert_eq!(s.par_reduce_chunked(7, 0, |a, b| a + b), 500500);
+
+        // Chunks are combined in order
+        let words: Stack<String> = Stack::new(Perspective::FIFO);
+        for w in ["a", "b", "c", "d", "e", "f", "g"] {
+            words.push(w.to_string()).unwrap();
+        }
+        assert_eq!(words.par_reduce_chunked(2, String::new(), |a, b| a + &b), "abcdefg");
+
+        let empty: Stack<i64> = Stack::new(Perspective::Indexed);
+        assert_eq!(empty.par_reduce(9, |a, b| a + b), 9);
+    }
 }
@@ -1,11 +1,13 @@
 //! Blocking stack operations with timeout support
 //!
 //! Provides `BlockingStack<T>` which wraps a `Stack<T>` and adds
-//! blocking `take()` operations that wait for data.
+//! blocking `take()` operations that wait for data, `SpawnGroup`,
+//! which waits for spawned tasks (`@spawn wait`), and `run_for_result`
+//! for tasks declared with `into @results`.
 
 use std::time::{Duration, Instant};
 use parking_lot::{Mutex, Condvar};
-use crate::{Stack, Perspective, Result, StackError};
+use crate::{Stack, Perspective, FreezeMode, Result, StackError};
 
 /// A stack with blocking take operations
 pub struct BlockingStack<T> {
@@ -156,12 +158,120 @@ impl<T: Clone> BlockingStack<T> {
         self.stack.freeze()
     }
 
+    /// Freeze in a mode
+    pub fn freeze_with(&self, mode: FreezeMode) {
+        self.stack.freeze_with(mode)
+    }
+
     /// Get underlying stack for raw access
     pub fn inner(&self) -> &Stack<T> {
         &self.stack
     }
 }
 
+/// Counts running spawned tasks so `@spawn wait` can block until they finish
+pub struct SpawnGroup {
+    count: Mutex<usize>,
+    zero: Condvar,
+}
+
+/// Marks a task as finished when dropped, even if the task panics
+pub struct SpawnGuard<'a>(&'a SpawnGroup);
+
+impl SpawnGroup {
+    /// Create an empty group
+    pub fn new() -> Self {
+        SpawnGroup { count: Mutex::new(0), zero: Condvar::new() }
+    }
+
+    /// Add `n` running tasks
+    pub fn add(&self, n: usize) {
+        *self.count.lock() += n;
+    }
+
+    /// Mark one task as finished
+    pub fn done(&self) {
+        let mut count = self.count.lock();
+        *count = count.checked_sub(1).expect("negative SpawnGroup counter");
+        if *count == 0 {
+            self.zero.notify_all();
+        }
+    }
+
+    /// Add one task, returning a guard that marks it finished on drop
+    pub fn start(&self) -> SpawnGuard<'_> {
+        self.add(1);
+        SpawnGuard(self)
+    }
+
+    /// Number of tasks still running
+    pub fn pending(&self) -> usize {
+        *self.count.lock()
+    }
+
+    /// Block until every task has finished
+    ///
+    /// - `timeout_ms = None`: wait forever
+    /// - `timeout_ms = Some(n)`: give up after n milliseconds
+    ///
+    /// Returns false if the timeout expired first.
+    pub fn wait(&self, timeout_ms: Option<u64>) -> bool {
+        let deadline = timeout_ms.map(|ms| Instant::now() + Duration::from_millis(ms));
+        let mut count = self.count.lock();
+        while *count > 0 {
+            match deadline {
+                Some(dl) => {
+                    let now = Instant::now();
+                    if now >= dl {
+                        return false;
+                    }
+                    self.zero.wait_for(&mut count, dl - now);
+                }
+                None => self.zero.wait(&mut count),
+            }
+        }
+        true
+    }
+}
+
+impl Default for SpawnGroup {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Drop for SpawnGuard<'_> {
+    fn drop(&mut self) {
+        self.0.done();
+    }
+}
+
+/// Message reported when a task with a result stack returns nothing
+pub const NO_RESULT: &str = "spawn task ended without a result";
+
+/// Run the body of a task declared with `into @results`: the value `f`
+/// returns is pushed to `results`. If `f` panics, returns `None`, or the
+/// push fails, the reason is pushed to `errors` instead.
+pub fn run_for_result<T, F>(f: F, results: &Stack<T>, errors: &Stack<String>)
+where
+    T: Clone,
+    F: FnOnce() -> Option<T>,
+{
+    let err = match std::panic::catch_unwind(std::panic::AssertUnwindSafe(f)) {
+        Ok(Some(value)) => results.push(value).err().map(|e| e.to_string()),
+        Ok(None) => Some(NO_RESULT.to_string()),
+        Err(e) => Some(
+            e.downcast_ref::<&str>()
+                .map(|s| s.to_string())
+                .or_else(|| e.downcast_ref::<String>().cloned())
+                .unwrap_or_else(|| "panic".to_string()),
+        ),
+    };
+    if let Some(msg) = err {
+        errors.push(format!("spawn: {}", msg)).ok();
+    }
+}
+
 /// Extension trait for creating blocking stacks
 pub trait IntoBlocking<T> {
     fn into_blocking(self) -> BlockingStack<T>;
@@ -245,6 +355,50 @@ mod tests {
         assert!(matches!(result, Err(StackError::Closed)));
     }
 
+    #[test]
+    fn test_run_for_result() {
+        let results: Stack<i64> = Stack::new(Perspective::FIFO);
+        let errors: Stack<String> = Stack::new(Perspective::FIFO);
+        run_for_result(|| Some(42), &results, &errors);
+        run_for_result(|| None, &results, &errors);
+        run_for_result(|| -> Option<i64> { panic!("boom") }, &results, &errors);
+
+        assert_eq!(results.pop().unwrap(), 42);
+        assert!(results.is_empty());
+        assert_eq!(errors.pop().unwrap(), format!("spawn: {}", NO_RESULT));
+        assert_eq!(errors.pop().unwrap(), "spawn: boom");
+    }
+
+    #[test]
+    fn test_spawn_group_wait() {
+        let group = Arc::new(SpawnGroup::new());
+        assert!(group.wait(None));
+
+        let release = Arc::new(BlockingStack::<i64>::new(Perspective::LIFO));
+        let handles: Vec<_> = (0..3)
+            .map(|_| {
+                let (group, release) = (Arc::clone(&group), Arc::clone(&release));
+                group.add(1);
+                thread::spawn(move || {
+                    let _guard = SpawnGuard(&group);
+                    release.take_timeout(Some(5000)).ok();
+                })
+            })
+            .collect();
+
+        assert!(!group.wait(Some(20)));
+        assert_eq!(group.pending(), 3);
+
+        for _ in 0..3 {
+            release.push(1).unwrap();
+        }
+        assert!(group.wait(Some(5000)));
+        assert_eq!(group.pending(), 0);
+        for h in handles {
+            h.join().unwrap();
+        }
+    }
+
     #[test]
     fn test_nonblocking_mode() {
         let stack = BlockingStack::<i64>::new(Perspective::LIFO);
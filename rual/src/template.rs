@@ -0,0 +1,163 @@
+//! Mustache-like template rendering against Hash stacks
+//!
+//! Supported tags: `{{name}}` (HTML-escaped), `{{{name}}}` / `{{&name}}` (raw),
+//! `{{#name}}..{{/name}}` (section), `{{^name}}..{{/name}}` (inverted section)
+//! and `{{! comment}}`. Missing keys render as the empty string.
+
+use std::fmt::Display;
+use crate::Stack;
+
+enum Node {
+    Text(String),
+    Var(String),
+    Raw(String),
+    Section(String, Vec<Node>),
+    Inverted(String, Vec<Node>),
+}
+
+/// Render a template using the keys of a Hash perspective stack
+pub fn render<T: Clone + Display>(tmpl: &str, vars: &Stack<T>) -> std::result::Result<String, String> {
+    render_with(tmpl, |key| vars.peek_key(key).ok().map(|v| v.to_string()))
+}
+
+/// Render a template, resolving keys through `lookup`
+pub fn render_with<F: Fn(&str) -> Option<String>>(tmpl: &str, lookup: F) -> std::result::Result<String, String> {
+    let (nodes, rest) = parse(tmpl, "")?;
+    if !rest.is_empty() {
+        return Err("template: unexpected trailing input".to_string());
+    }
+    let mut out = String::new();
+    render_nodes(&mut out, &nodes, &lookup);
+    Ok(out)
+}
+
+fn parse<'a>(mut s: &'a str, section: &str) -> std::result::Result<(Vec<Node>, &'a str), String> {
+    let mut nodes = Vec::new();
+    loop {
+        let open = match s.find("{{") {
+            Some(i) => i,
+            None => {
+                if !section.is_empty() {
+                    return Err(format!("template: unclosed section '{}'", section));
+                }
+                if !s.is_empty() {
+                    nodes.push(Node::Text(s.to_string()));
+                }
+                return Ok((nodes, ""));
+            }
+        };
+        if open > 0 {
+            nodes.push(Node::Text(s[..open].to_string()));
+        }
+        s = &s[open + 2..];
+
+        if let Some(inner) = s.strip_prefix('{') {
+            let end = inner.find("}}}").ok_or("template: unclosed '{{{' tag")?;
+            nodes.push(Node::Raw(inner[..end].trim().to_string()));
+            s = &inner[end + 3..];
+            continue;
+        }
+
+        let end = s.find("}}").ok_or("template: unclosed '{{' tag")?;
+        let tag = s[..end].trim();
+        s = &s[end + 2..];
+        let mut chars = tag.chars();
+        let first = chars.next().ok_or("template: empty tag")?;
+        let name = chars.as_str().trim();
+
+        match first {
+            '!' => {}
+            '&' => nodes.push(Node::Raw(name.to_string())),
+            '#' | '^' => {
+                let (children, rest) = parse(s, name)?;
+                if first == '#' {
+                    nodes.push(Node::Section(name.to_string(), children));
+                } else {
+                    nodes.push(Node::Inverted(name.to_string(), children));
+                }
+                s = rest;
+            }
+            '/' => {
+                if name != section {
+                    if section.is_empty() {
+                        return Err(format!("template: unexpected closing tag '{}'", name));
+                    }
+                    return Err(format!("template: expected {{{{/{}}}}}, got {{{{/{}}}}}", section, name));
+                }
+                return Ok((nodes, s));
+            }
+            _ => nodes.push(Node::Var(tag.to_string())),
+        }
+    }
+}
+
+fn render_nodes<F: Fn(&str) -> Option<String>>(out: &mut String, nodes: &[Node], lookup: &F) {
+    for node in nodes {
+        match node {
+            Node::Text(t) => out.push_str(t),
+            Node::Var(k) => {
+                if let Some(v) = lookup(k) {
+                    out.push_str(&escape_html(&v));
+                }
+            }
+            Node::Raw(k) => {
+                if let Some(v) = lookup(k) {
+                    out.push_str(&v);
+                }
+            }
+            Node::Section(k, children) => {
+                if lookup(k).map_or(false, |v| truthy(&v)) {
+                    render_nodes(out, children, lookup);
+                }
+            }
+            Node::Inverted(k, children) => {
+                if !lookup(k).map_or(false, |v| truthy(&v)) {
+                    render_nodes(out, children, lookup);
+                }
+            }
+        }
+    }
+}
+
+fn truthy(s: &str) -> bool {
+    !s.is_empty() && s != "0" && s != "false"
+}
+
+fn escape_html(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    for c in s.chars() {
+        match c {
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '&' => out.push_str("&amp;"),
+            '\'' => out.push_str("&#39;"),
+            '"' => out.push_str("&#34;"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::Perspective;
+
+    #[test]
+    fn test_render_vars_and_sections() {
+        let vars: Stack<String> = Stack::new(Perspective::Hash);
+        vars.push_keyed("name", "Ada".to_string()).unwrap();
+        vars.push_keyed("tag", "<b>".to_string()).unwrap();
+
+        let out = render("Hi {{name}} {{tag}} {{{tag}}}{{#name}}!{{/name}}{{^nope}}?{{/nope}}", &vars).unwrap();
+        assert_eq!(out, "Hi Ada &lt;b&gt; <b>!?");
+    }
+
+    #[test]
+    fn test_render_errors() {
+        let vars: Stack<i64> = Stack::new(Perspective::Hash);
+        assert!(render("{{#a}}x", &vars).is_err());
+        assert!(render("{{#a}}x{{/b}}", &vars).is_err());
+        assert!(render("{{a", &vars).is_err());
+    }
+}
@@ -0,0 +1,172 @@
+//! Terminal helpers: colour, line clearing, progress bars and prompts
+//!
+//! Control sequences are only written when stdout is a terminal, so programs
+//! can call these unconditionally and still produce clean piped output.
+//! Setting `NO_COLOR` disables colour. Prompts read a line from stdin, so
+//! scripted input works as well as a terminal.
+
+use std::io::{BufRead, IsTerminal, Write};
+
+use crate::FileError;
+
+const PROGRESS_WIDTH: i64 = 30;
+
+/// Whether stdout is a terminal
+pub fn is_tty() -> bool {
+    std::io::stdout().is_terminal()
+}
+
+/// Wrap `s` in the ANSI sequence for a colour or style name.
+/// Returns `s` unchanged on non-TTY, with `NO_COLOR` set, or for unknown names.
+pub fn color(name: &str, s: &str) -> String {
+    match ansi_code(name) {
+        Some(code) if is_tty() && std::env::var_os("NO_COLOR").map_or(true, |v| v.is_empty()) => {
+            format!("\x1b[{}m{}\x1b[0m", code, s)
+        }
+        _ => s.to_string(),
+    }
+}
+
+/// Erase the current line and return to column 0 (no-op on non-TTY)
+pub fn clear_line() {
+    if is_tty() {
+        print!("\r\x1b[2K");
+        let _ = std::io::stdout().flush();
+    }
+}
+
+/// Draw a progress bar for `n` of `total`, ending with a newline once
+/// `n` reaches `total` (no-op on non-TTY)
+pub fn progress(n: i64, total: i64) {
+    if !is_tty() {
+        return;
+    }
+    print!("\r\x1b[2K{}", progress_bar(n, total));
+    if total > 0 && n >= total {
+        println!();
+    }
+    let _ = std::io::stdout().flush();
+}
+
+/// Write `msg` and return the next line from stdin, without its line ending
+pub fn prompt(msg: &str) -> String {
+    print!("{}", msg);
+    read_line()
+}
+
+/// Write `msg` followed by " [y/N] " and return whether the answer was y/yes
+pub fn confirm(msg: &str) -> bool {
+    print!("{} [y/N] ", msg);
+    is_yes(&read_line())
+}
+
+/// Write `msg` and read a line with terminal echo turned off.
+/// When stdin is not a terminal the line is read as-is.
+pub fn password(msg: &str) -> String {
+    print!("{}", msg);
+    if !std::io::stdin().is_terminal() || !set_echo(false) {
+        return read_line();
+    }
+    let line = read_line();
+    set_echo(true);
+    // The user's Enter was not echoed either
+    println!();
+    line
+}
+
+/// The next line of stdin without its line ending: `readline()`. A last
+/// line with no newline is still a line; once there is nothing left to
+/// read the error has the `eof` status.
+pub fn readline() -> std::result::Result<String, FileError> {
+    let _ = std::io::stdout().flush();
+    let mut line = String::new();
+    match std::io::stdin().lock().read_line(&mut line) {
+        Ok(0) => Err(FileError::new("eof", "end of input")),
+        Ok(_) => Ok(line.trim_end_matches(['\r', '\n']).to_string()),
+        Err(e) => Err(e.into()),
+    }
+}
+
+fn read_line() -> String {
+    let _ = std::io::stdout().flush();
+    let mut line = String::new();
+    let _ = std::io::stdin().lock().read_line(&mut line);
+    line.trim_end_matches(['\r', '\n']).to_string()
+}
+
+fn is_yes(answer: &str) -> bool {
+    matches!(answer.trim().to_ascii_lowercase().as_str(), "y" | "yes")
+}
+
+/// Toggle terminal echo through stty (no libc dependency). Returns false if
+/// echo could not be changed.
+#[cfg(unix)]
+fn set_echo(on: bool) -> bool {
+    std::process::Command::new("stty")
+        .arg(if on { "echo" } else { "-echo" })
+        .stdin(std::process::Stdio::inherit())
+        .status()
+        .map_or(false, |s| s.success())
+}
+
+#[cfg(not(unix))]
+fn set_echo(_on: bool) -> bool {
+    false
+}
+
+fn ansi_code(name: &str) -> Option<&'static str> {
+    Some(match name.to_ascii_lowercase().as_str() {
+        "black" => "30",
+        "red" => "31",
+        "green" => "32",
+        "yellow" => "33",
+        "blue" => "34",
+        "magenta" => "35",
+        "cyan" => "36",
+        "white" => "37",
+        "gray" | "grey" => "90",
+        "bold" => "1",
+        "dim" => "2",
+        "underline" => "4",
+        _ => return None,
+    })
+}
+
+fn progress_bar(n: i64, total: i64) -> String {
+    let total = total.max(1);
+    let n = n.clamp(0, total);
+    let filled = (n * PROGRESS_WIDTH / total) as usize;
+    format!(
+        "[{}{}] {:>3}% ({}/{})",
+        "#".repeat(filled),
+        " ".repeat(PROGRESS_WIDTH as usize - filled),
+        n * 100 / total,
+        n,
+        total
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_progress_bar() {
+        assert_eq!(progress_bar(5, 10), format!("[{}{}]  50% (5/10)", "#".repeat(15), " ".repeat(15)));
+        assert_eq!(progress_bar(12, 10), format!("[{}] 100% (10/10)", "#".repeat(30)));
+    }
+
+    #[test]
+    fn test_is_yes() {
+        assert!(is_yes("y"));
+        assert!(is_yes(" YES "));
+        assert!(!is_yes("no"));
+        assert!(!is_yes(""));
+    }
+
+    #[test]
+    fn test_ansi_code() {
+        assert_eq!(ansi_code("Red"), Some("31"));
+        assert_eq!(ansi_code("mauve"), None);
+    }
+}
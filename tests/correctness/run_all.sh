@@ -3,7 +3,7 @@
 # ual Correctness Test Suite
 # =============================================================================
 #
-# Tests all 92 ual examples across Go, Rust, and iual backends.
+# Tests the ual examples across Go (with and without -O), Rust, and iual backends.
 # Compares output against expected results in expected/ directory.
 #
 # Usage:
@@ -11,6 +11,7 @@
 #
 # Options:
 #   --go              Test Go backend only
+#   --go-opt          Test Go backend with -O only
 #   --rust            Test Rust backend only
 #   --iual            Test interpreter only
 #   --all             Test all backends (default if none specified)
@@ -45,6 +46,7 @@ cd "$PROJECT_DIR"
 # =============================================================================
 
 TEST_GO=false
+TEST_GO_OPT=false
 TEST_RUST=false
 TEST_IUAL=false
 UPDATE_EXPECTED=false
@@ -54,13 +56,26 @@ VERBOSE=false
 SAVE_RESULTS=false
 SINGLE_EXAMPLE=""
 
+# rust_unsupported prints why the Rust backend cannot compile example $1
+# yet, or nothing if it can
+rust_unsupported() {
+    case "$1" in
+        106_broadcast)    echo "Broadcast stacks and views" ;;
+        108_runtime_stats) echo "runtime_stats()" ;;
+        111_expect)       echo "expect_stack() and expect_output()" ;;
+        112_structs)      echo "struct element types" ;;
+        113_test_doubles) echo "freeze_time(), advance_time() and mock stacks" ;;
+    esac
+}
+
 # Parse arguments
 while [[ $# -gt 0 ]]; do
     case $1 in
         --go)       TEST_GO=true; shift ;;
+        --go-opt)   TEST_GO_OPT=true; shift ;;
         --rust)     TEST_RUST=true; shift ;;
         --iual)     TEST_IUAL=true; shift ;;
-        --all)      TEST_GO=true; TEST_RUST=true; TEST_IUAL=true; shift ;;
+        --all)      TEST_GO=true; TEST_GO_OPT=true; TEST_RUST=true; TEST_IUAL=true; shift ;;
         --update)   UPDATE_EXPECTED=true; shift ;;
         --json)     JSON_OUTPUT=true; shift ;;
         --quiet)    QUIET=true; shift ;;
@@ -80,8 +95,9 @@ while [[ $# -gt 0 ]]; do
 done
 
 # Default to all if none specified
-if ! $TEST_GO && ! $TEST_RUST && ! $TEST_IUAL && ! $UPDATE_EXPECTED; then
+if ! $TEST_GO && ! $TEST_GO_OPT && ! $TEST_RUST && ! $TEST_IUAL && ! $UPDATE_EXPECTED; then
     TEST_GO=true
+    TEST_GO_OPT=true
     TEST_RUST=true
     TEST_IUAL=true
 fi
@@ -204,7 +220,7 @@ TEST_OUTPUT=""
 TEST_EXPECTED=""
 
 # Run a single test and return status
-# Args: $1=ual_file, $2=backend (go|rust|iual)
+# Args: $1=ual_file, $2=backend (go|goopt|rust|iual)
 # Returns: pass|fail:reason|skip:reason
 # Sets: TEST_OUTPUT (actual output), TEST_EXPECTED (expected output)
 run_single_test() {
@@ -237,11 +253,27 @@ run_single_test() {
             fi
             ;;
             
+        goopt)
+            if TEST_OUTPUT=$(./ual -q run -O "$ual_file" 2>&1); then
+                if [ "$TEST_OUTPUT" = "$TEST_EXPECTED" ]; then
+                    echo "pass"
+                else
+                    echo "fail:output_mismatch"
+                fi
+            else
+                echo "fail:execution_error"
+            fi
+            ;;
+            
         rust)
             if [ -z "$RUST_PROJECT" ] || ! $RUST_AVAILABLE; then
                 echo "skip:no_rust"
                 return
             fi
+            if [ -n "$(rust_unsupported "$name")" ]; then
+                echo "skip:rust_unsupported"
+                return
+            fi
             
             # Generate Rust code
             if ! ./ual compile --target rust "$ual_file" -o "$RUST_PROJECT/src/main.rs" 2>/dev/null; then
@@ -372,6 +404,7 @@ run_tests() {
     # Results tracking
     local total=0
     local go_pass=0 go_fail=0 go_skip=0
+    local goopt_pass=0 goopt_fail=0 goopt_skip=0
     local rust_pass=0 rust_fail=0 rust_skip=0
     local iual_pass=0 iual_fail=0 iual_skip=0
     local failed_tests=()
@@ -388,11 +421,13 @@ run_tests() {
         echo ""
         printf "%-35s" "Example"
         $TEST_GO && printf "%6s" "Go"
+        $TEST_GO_OPT && printf "%8s" "Go -O"
         $TEST_RUST && printf "%8s" "Rust"
         $TEST_IUAL && printf "%8s" "iual"
         echo ""
         printf "%-35s" "-------"
         $TEST_GO && printf "%6s" "----"
+        $TEST_GO_OPT && printf "%8s" "------"
         $TEST_RUST && printf "%8s" "------"
         $TEST_IUAL && printf "%8s" "------"
         echo ""
@@ -403,8 +438,8 @@ run_tests() {
         local name=$(basename "$ual_file" .ual)
         total=$((total + 1))
         
-        local go_status="" rust_status="" iual_status=""
-        local go_output="" rust_output="" iual_output=""
+        local go_status="" goopt_status="" rust_status="" iual_status=""
+        local go_output="" goopt_output="" rust_output="" iual_output=""
         local expected=""
         
         # Test each backend
@@ -420,6 +455,18 @@ run_tests() {
             esac
         fi
         
+        if $TEST_GO_OPT; then
+            goopt_status=$(run_single_test "$ual_file" "goopt")
+            goopt_output="$TEST_OUTPUT"
+            [ -z "$expected" ] && expected="$TEST_EXPECTED"
+            
+            case "$goopt_status" in
+                pass) goopt_pass=$((goopt_pass + 1)) ;;
+                fail*) goopt_fail=$((goopt_fail + 1)); failed_tests+=("$name:go-opt") ;;
+                skip*) goopt_skip=$((goopt_skip + 1)) ;;
+            esac
+        fi
+        
         if $TEST_RUST; then
             rust_status=$(run_single_test "$ual_file" "rust")
             rust_output="$TEST_OUTPUT"
@@ -450,6 +497,7 @@ run_tests() {
             json_first=false
             json_results+="{\"name\":\"$name\""
             $TEST_GO && json_results+=",\"go\":\"$go_status\""
+            $TEST_GO_OPT && json_results+=",\"go_opt\":\"$goopt_status\""
             $TEST_RUST && json_results+=",\"rust\":\"$rust_status\""
             $TEST_IUAL && json_results+=",\"iual\":\"$iual_status\""
             json_results+="}"
@@ -461,6 +509,7 @@ run_tests() {
             local has_failure=false
             
             [[ "$go_status" == fail* ]] && has_failure=true
+            [[ "$goopt_status" == fail* ]] && has_failure=true
             [[ "$rust_status" == fail* ]] && has_failure=true
             [[ "$iual_status" == fail* ]] && has_failure=true
             
@@ -477,6 +526,14 @@ run_tests() {
                     esac
                 fi
                 
+                if $TEST_GO_OPT; then
+                    case "$goopt_status" in
+                        pass)  printf "      ${GREEN}✓${NC} " ;;
+                        skip*) printf "      ${YELLOW}○${NC} " ;;
+                        fail*) printf "      ${RED}✗${NC} " ;;
+                    esac
+                fi
+                
                 if $TEST_RUST; then
                     case "$rust_status" in
                         pass)  printf "      ${GREEN}✓${NC} " ;;
@@ -501,6 +558,10 @@ run_tests() {
                         echo -e "  ${RED}Go output mismatch:${NC}"
                         show_diff "$name" "$expected" "$go_output" | sed 's/^/    /'
                     fi
+                    if [[ "$goopt_status" == fail:output_mismatch ]]; then
+                        echo -e "  ${RED}Go -O output mismatch:${NC}"
+                        show_diff "$name" "$expected" "$goopt_output" | sed 's/^/    /'
+                    fi
                     if [[ "$rust_status" == fail:output_mismatch ]]; then
                         echo -e "  ${RED}Rust output mismatch:${NC}"
                         show_diff "$name" "$expected" "$rust_output" | sed 's/^/    /'
@@ -525,6 +586,7 @@ run_tests() {
   "backends": {
 EOF
         $TEST_GO && echo "    \"go\": {\"pass\": $go_pass, \"fail\": $go_fail, \"skip\": $go_skip},"
+        $TEST_GO_OPT && echo "    \"go_opt\": {\"pass\": $goopt_pass, \"fail\": $goopt_fail, \"skip\": $goopt_skip},"
         $TEST_RUST && echo "    \"rust\": {\"pass\": $rust_pass, \"fail\": $rust_fail, \"skip\": $rust_skip},"
         $TEST_IUAL && echo "    \"iual\": {\"pass\": $iual_pass, \"fail\": $iual_fail, \"skip\": $iual_skip}"
         cat << EOF
@@ -540,6 +602,10 @@ EOF
         $TEST_GO && [ $go_skip -gt 0 ] && printf " (%d skipped)" $go_skip
         $TEST_GO && echo ""
         
+        $TEST_GO_OPT && printf "Go -O: %d/%d passed" $goopt_pass $((goopt_pass + goopt_fail))
+        $TEST_GO_OPT && [ $goopt_skip -gt 0 ] && printf " (%d skipped)" $goopt_skip
+        $TEST_GO_OPT && echo ""
+        
         $TEST_RUST && printf "Rust: %d/%d passed" $rust_pass $((rust_pass + rust_fail))
         $TEST_RUST && [ $rust_skip -gt 0 ] && printf " (%d skipped)" $rust_skip
         $TEST_RUST && echo ""
@@ -571,6 +637,7 @@ EOF
                 echo "ual Test Results - $timestamp"
                 echo ""
                 $TEST_GO && echo "Go:   $go_pass/$((go_pass + go_fail)) passed ($go_skip skipped)"
+                $TEST_GO_OPT && echo "Go -O: $goopt_pass/$((goopt_pass + goopt_fail)) passed ($goopt_skip skipped)"
                 $TEST_RUST && echo "Rust: $rust_pass/$((rust_pass + rust_fail)) passed ($rust_skip skipped)"
                 $TEST_IUAL && echo "iual: $iual_pass/$((iual_pass + iual_fail)) passed ($iual_skip skipped)"
                 if [ ${#failed_tests[@]} -gt 0 ]; then
@@ -589,6 +656,7 @@ EOF
     # Exit code: fail if any tests failed
     local has_failures=false
     $TEST_GO && [ $go_fail -gt 0 ] && has_failures=true
+    $TEST_GO_OPT && [ $goopt_fail -gt 0 ] && has_failures=true
     $TEST_RUST && [ $rust_fail -gt 0 ] && has_failures=true
     $TEST_IUAL && [ $iual_fail -gt 0 ] && has_failures=true
     